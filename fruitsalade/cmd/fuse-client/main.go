@@ -1,4 +1,4 @@
-// Phase 2 FUSE Client - Production
+// FruitSalade FUSE Client
 //
 // Full-featured client with read + write support:
 // - JWT authentication
@@ -12,9 +12,33 @@
 //
 //	fruitsalade-fuse mount [flags]    Mount filesystem (default)
 //	fruitsalade-fuse pin <file-id>    Pin a cached file
+//	fruitsalade-fuse pin -r <path>    Pin a folder, including files added later
 //	fruitsalade-fuse unpin <file-id>  Unpin a cached file
-//	fruitsalade-fuse pinned           List pinned files
+//	fruitsalade-fuse unpin -r <path>  Remove a folder pin
+//	fruitsalade-fuse pinned           List pinned files and folders
 //	fruitsalade-fuse status           Show cache status
+//	fruitsalade-fuse rebuild-index    Rebuild the cache index from the cache directory
+//	fruitsalade-fuse sync-config      List folders excluded from sync
+//	fruitsalade-fuse sync-config exclude|include <path>
+//	                                  Leave a folder out of the mount, or sync it again
+//	fruitsalade-fuse match-test <pattern>... <path>
+//	                                  Test how patterns match a path
+//	fruitsalade-fuse share <path>     Create a share link and print its URL
+//	fruitsalade-fuse shares           List my share links
+//	fruitsalade-fuse unshare <id>     Revoke a share link
+//	fruitsalade-fuse version          Show build version (also -version)
+//
+// On a mounted filesystem, files can also be pinned by path with
+// `setfattr -n user.fruitsalade.pin -v 1 <file>` (0 unpins), and
+// user.fruitsalade.status reports remote, cached, pinned or dirty. Reading
+// <mount>/.fruitsalade/status returns cache stats and the connection state
+// as JSON.
+//
+// With -metrics-addr, cache and filesystem statistics are served in the
+// Prometheus format at http://<addr>/metrics.
+//
+// With -log-file, the log is also written to a file, which is rotated at
+// -log-max-size bytes keeping -log-max-files old files.
 package main
 
 import (
@@ -23,7 +47,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -32,12 +59,20 @@ import (
 	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/fuse"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/pattern"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/selective"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
 	"golang.org/x/term"
 )
 
 func main() {
+	client.UserAgent = version.UserAgent("fuse")
+
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
+		case "version":
+			fmt.Printf("fruitsalade-fuse %s\n", version.String())
+			return
 		case "login":
 			cmdLogin(os.Args[2:])
 			return
@@ -56,6 +91,24 @@ func main() {
 		case "status":
 			cmdStatus(os.Args[2:])
 			return
+		case "rebuild-index":
+			cmdRebuildIndex(os.Args[2:])
+			return
+		case "sync-config":
+			cmdSyncConfig(os.Args[2:])
+			return
+		case "match-test":
+			cmdMatchTest(os.Args[2:])
+			return
+		case "share":
+			cmdShare(os.Args[2:])
+			return
+		case "shares":
+			cmdShares(os.Args[2:])
+			return
+		case "unshare":
+			cmdUnshare(os.Args[2:])
+			return
 		case "mount":
 			// Strip "mount" from args and fall through to normal parsing
 			os.Args = append(os.Args[:1], os.Args[2:]...)
@@ -70,15 +123,41 @@ func cmdMount() {
 	serverURL := flag.String("server", "http://localhost:8080", "Server URL")
 	cacheDir := flag.String("cache", "/tmp/fruitsalade-cache", "Cache directory")
 	maxCacheSize := flag.Int64("max-cache", 1<<30, "Maximum cache size in bytes (default 1GB)")
+	minFree := flag.Int64("min-free", 0, "Free space in bytes kept on the cache's volume: new content is not cached below it and writes fail with ENOSPC (0 = 1GB or 5% of the volume, whichever is less; -1 = none)")
+	cachePolicy := flag.String("cache-policy", cache.PolicySizeAge, "Which cached file to evict first: size-age (largest size × idle time) or lru")
 	refreshInterval := flag.Duration("refresh", 30*time.Second, "Metadata refresh interval (0 to disable)")
+	readAhead := flag.Int64("read-ahead", 0, "Bytes a sequential read of a large uncached file fetches at once (0 = 1MB, -1 = only what is read)")
+	attrTimeout := flag.Duration("attr-timeout", 0, "How long the kernel caches names and attributes (0 = the refresh interval, or 5s without one; -1s = not at all)")
 	verifyHash := flag.Bool("verify-hash", false, "Verify file hashes after download")
 	watchSSE := flag.Bool("watch", false, "Subscribe to server events for real-time updates")
+	watchTransport := flag.String("watch-transport", client.TransportAuto, "Event transport for -watch: auto (SSE, WebSocket if SSE keeps failing), sse or ws")
 	healthCheck := flag.Duration("health-check", 30*time.Second, "Health check interval for offline recovery")
 	token := flag.String("token", "", "JWT authentication token")
+	apiKey := flag.String("api-key", "", "API key (fsk_...) to use instead of a token")
+	reauthCommand := flag.String("reauth-command", "", "Shell command to run when the server rejects the saved token (e.g. a desktop notification)")
+	dirSizes := flag.Bool("dir-sizes", false, "Report the total size of a directory's contents as its size")
+	readOnly := flag.Bool("read-only", false, "Refuse all writes with EROFS without contacting the server")
+	symlinkAliases := flag.Bool("symlink-aliases", false, "Show aliases as symbolic links to their target instead of a copy of it")
+	maxDownloadRate := flag.String("max-download-rate", "", "Cap background downloads (prefetch, pinning) at this rate, e.g. 2MB (per second); reads by applications are not held back")
+	maxUploadRate := flag.String("max-upload-rate", "", "Cap uploads at this rate, e.g. 512KB (per second)")
+	rateSchedule := flag.String("rate-schedule", "", "Other rates by time of day, e.g. 19:00-07:00=unlimited,12:00-13:00=5MB")
+	var excludes stringList
+	flag.Var(&excludes, "exclude", "Server folder to leave out of the mount (repeatable; see also sync-config)")
+	onConflict := flag.String("on-conflict", fuse.ConflictCopy, "When a file changed on the server since it was opened: conflict-copy or overwrite")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. 127.0.0.1:9101)")
 	verbosity := flag.Int("v", 1, "Verbosity level: 0=quiet, 1=info, 2=debug")
+	logFile := flag.String("log-file", "", "Also write the log to this file")
+	logMaxSize := flag.Int64("log-max-size", logger.DefaultMaxSize, "Rotate the log file when it would grow past this many bytes (0 = never)")
+	logMaxFiles := flag.Int("log-max-files", logger.DefaultMaxFiles, "Rotated log files to keep")
+	showVersion := flag.Bool("version", false, "Print version and exit")
 
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Printf("fruitsalade-fuse %s\n", version.String())
+		return
+	}
+
 	switch *verbosity {
 	case 0:
 		logger.SetLevel(logger.LevelQuiet)
@@ -87,20 +166,49 @@ func cmdMount() {
 	default:
 		logger.SetLevel(logger.LevelDebug)
 	}
+	if *logFile != "" {
+		f, err := logger.OpenRotatingFile(*logFile, *logMaxSize, *logMaxFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		logger.AddSink(f)
+	}
 
 	if *mountPoint == "" {
 		fmt.Fprintf(os.Stderr, "Error: -mount is required\n")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if !fuse.ValidConflictPolicy(*onConflict) {
+		fmt.Fprintf(os.Stderr, "Error: -on-conflict must be %s or %s\n", fuse.ConflictCopy, fuse.ConflictOverwrite)
+		os.Exit(1)
+	}
+	if !cache.ValidPolicy(*cachePolicy) {
+		fmt.Fprintf(os.Stderr, "Error: -cache-policy must be %s or %s\n", cache.PolicySizeAge, cache.PolicyLRU)
+		os.Exit(1)
+	}
+	rates, err := client.ParseRateLimits(*maxDownloadRate, *maxUploadRate, *rateSchedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !client.ValidTransport(*watchTransport) {
+		fmt.Fprintf(os.Stderr, "Error: -watch-transport must be %s, %s or %s\n", client.TransportAuto, client.TransportSSE, client.TransportWS)
+		os.Exit(1)
+	}
 
+	if *apiKey == "" {
+		*apiKey = os.Getenv("FRUITSALADE_API_KEY")
+	}
 	if *token == "" {
 		*token = os.Getenv("FRUITSALADE_TOKEN")
 	}
 
 	// Auto-load from token file if no token provided
 	var tokenFile *client.TokenFile
-	if *token == "" {
+	if *token == "" && *apiKey == "" {
 		tf, err := client.LoadToken()
 		if err == nil {
 			if tf.IsExpired(0) {
@@ -113,12 +221,12 @@ func cmdMount() {
 		}
 	}
 
-	if *token == "" {
-		fmt.Fprintf(os.Stderr, "Error: no token available. Use -token, FRUITSALADE_TOKEN, or run 'fruitsalade-fuse login'\n")
+	if *token == "" && *apiKey == "" {
+		fmt.Fprintf(os.Stderr, "Error: no token available. Use -token, -api-key, FRUITSALADE_TOKEN, or run 'fruitsalade-fuse login'\n")
 		os.Exit(1)
 	}
 
-	logger.Info("FruitSalade Phase 2 FUSE Client (read/write)")
+	logger.Info("FruitSalade FUSE Client (read/write) %s", version.String())
 	logger.Info("  Server:     %s", *serverURL)
 	logger.Info("  Mount:      %s", *mountPoint)
 	logger.Info("  Cache:      %s (max %d MB)", *cacheDir, *maxCacheSize/(1<<20))
@@ -127,10 +235,22 @@ func cmdMount() {
 		ServerURL:         *serverURL,
 		CacheDir:          *cacheDir,
 		MaxCacheSize:      *maxCacheSize,
+		CachePolicy:       *cachePolicy,
+		MinFreeSpace:      *minFree,
 		RefreshInterval:   *refreshInterval,
 		VerifyHash:        *verifyHash,
 		WatchSSE:          *watchSSE,
+		WatchTransport:    *watchTransport,
 		HealthCheckPeriod: *healthCheck,
+		APIKey:            *apiKey,
+		ConflictPolicy:    *onConflict,
+		DirSizes:          *dirSizes,
+		Exclude:           excludes,
+		ReadOnly:          *readOnly,
+		SymlinkAliases:    *symlinkAliases,
+		AttrTimeout:       *attrTimeout,
+		ReadAhead:         *readAhead,
+		RateLimits:        rates,
 	}
 
 	fruitFS, err := fuse.NewFruitFS(cfg)
@@ -138,14 +258,28 @@ func cmdMount() {
 		logger.Error("Failed to create filesystem: %v", err)
 		os.Exit(1)
 	}
+	if ex := fruitFS.Excludes(); len(ex) > 0 {
+		logger.Info("  Excluded:   %s", strings.Join(ex, ", "))
+	}
+	if *readOnly {
+		logger.Info("  Read-only:  yes")
+	}
 
 	fruitFS.SetAuthToken(*token)
 
+	if *metricsAddr != "" {
+		cache.ServeMetrics(*metricsAddr, fruitFS.Collector())
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	logger.Info("Fetching metadata...")
 	if err := fruitFS.FetchMetadata(ctx); err != nil {
+		if _, ok := client.AsUpgradeRequired(err); ok {
+			logger.Error("Cannot mount: %v", err)
+			os.Exit(1)
+		}
 		logger.Error("Failed to fetch metadata: %v", err)
 		os.Exit(1)
 	}
@@ -162,7 +296,17 @@ func cmdMount() {
 
 	// Start token refresh loop if using a saved token file
 	if tokenFile != nil {
-		fruitFS.Client().StartTokenRefreshLoop(ctx, tokenFile)
+		fruitFS.Client().StartTokenRefreshLoop(ctx, tokenFile, client.TokenRefreshHooks{
+			Refreshed: func(tf *client.TokenFile) {
+				fruitFS.SetAuthToken(tf.Token)
+			},
+			Rejected: func(err error) {
+				logger.Error("The server rejected the saved token (revoked or expired). Run 'fruitsalade-fuse login' to sign in again; the mount picks up the new token automatically.")
+				if *reauthCommand != "" {
+					runReauthCommand(*reauthCommand)
+				}
+			},
+		})
 	}
 
 	logger.Info("Filesystem mounted at %s (read/write)", *mountPoint)
@@ -177,9 +321,34 @@ func cmdMount() {
 	fruitFS.StopSSEWatch()
 	fruitFS.StopHealthCheck()
 	server.Unmount()
+	if err := fruitFS.SaveCacheIndex(); err != nil {
+		logger.Error("Failed to save cache index: %v", err)
+	}
 	logger.Info("Done")
 }
 
+// stringList collects the values of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runReauthCommand runs the -reauth-command hook in the background.
+func runReauthCommand(command string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	go func() {
+		if err := cmd.Run(); err != nil {
+			logger.Error("Re-auth command failed: %v", err)
+		}
+	}()
+}
+
 func cmdLogin(args []string) {
 	fs := flag.NewFlagSet("login", flag.ExitOnError)
 	serverURL := fs.String("server", "http://localhost:8080", "Server URL")
@@ -293,13 +462,23 @@ func openCache(args []string) (*cache.Cache, *flag.FlagSet) {
 func cmdPin(args []string) {
 	fs := flag.NewFlagSet("pin", flag.ExitOnError)
 	cacheDir := fs.String("cache", "/tmp/fruitsalade-cache", "Cache directory")
+	recursive := fs.Bool("r", false, "Pin a folder path and everything below it")
+	serverURL := fs.String("server", "", "Server URL for prefetching (default: from saved login)")
+	token := fs.String("token", "", "JWT authentication token (or FRUITSALADE_TOKEN)")
+	apiKey := fs.String("api-key", "", "API key (or FRUITSALADE_API_KEY)")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
 		fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse pin [-cache dir] <file-id>\n")
+		fmt.Fprintf(os.Stderr, "       fruitsalade-fuse pin -r [-cache dir] [-server url] <path>\n")
 		os.Exit(1)
 	}
 
+	if *recursive {
+		pinFolder(*cacheDir, fs.Arg(0), *serverURL, *token, *apiKey)
+		return
+	}
+
 	fileID := fs.Arg(0)
 	c, err := cache.New(*cacheDir, 0)
 	if err != nil {
@@ -320,10 +499,11 @@ func cmdPin(args []string) {
 func cmdUnpin(args []string) {
 	fs := flag.NewFlagSet("unpin", flag.ExitOnError)
 	cacheDir := fs.String("cache", "/tmp/fruitsalade-cache", "Cache directory")
+	recursive := fs.Bool("r", false, "Remove the pin of a folder path")
 	fs.Parse(args)
 
 	if fs.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse unpin [-cache dir] <file-id>\n")
+		fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse unpin [-cache dir] [-r] <file-id|path>\n")
 		os.Exit(1)
 	}
 
@@ -334,6 +514,20 @@ func cmdUnpin(args []string) {
 		os.Exit(1)
 	}
 
+	if *recursive {
+		c.LoadPins()
+		if err := c.UnpinPrefix(fileID); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := c.SavePins(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to persist pins: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Unpinned folder: %s\n", fileID)
+		return
+	}
+
 	if err := c.Unpin(fileID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -344,6 +538,61 @@ func cmdUnpin(args []string) {
 	fmt.Printf("Unpinned: %s\n", fileID)
 }
 
+// pinFolder records a pin rule and downloads the folder's current files.
+// A running mount picks up the rule on its next refresh.
+func pinFolder(cacheDir, path, serverURL, token, apiKey string) {
+	c, err := cache.New(cacheDir, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	c.LoadPins()
+	prefix := c.PinPrefix(path)
+	if err := c.SavePins(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to persist pins: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pinned folder: %s\n", prefix)
+
+	if apiKey == "" {
+		apiKey = os.Getenv("FRUITSALADE_API_KEY")
+	}
+	if token == "" {
+		token = os.Getenv("FRUITSALADE_TOKEN")
+	}
+	if tf, err := client.LoadToken(); err == nil && !tf.IsExpired(0) {
+		if token == "" && apiKey == "" {
+			token = tf.Token
+		}
+		if serverURL == "" {
+			serverURL = tf.Server
+		}
+	}
+	if serverURL == "" || (token == "" && apiKey == "") {
+		fmt.Println("Not logged in; files will be downloaded by the next mount.")
+		return
+	}
+
+	fruitFS, err := fuse.NewFruitFS(fuse.Config{ServerURL: serverURL, CacheDir: cacheDir, APIKey: apiKey})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fruitFS.SetAuthToken(token)
+
+	ctx := context.Background()
+	if err := fruitFS.FetchMetadata(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	n, err := fruitFS.FillPinnedFolders(ctx)
+	fmt.Printf("Downloaded %d files\n", n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func cmdPinned(args []string) {
 	fs := flag.NewFlagSet("pinned", flag.ExitOnError)
 	cacheDir := fs.String("cache", "/tmp/fruitsalade-cache", "Cache directory")
@@ -356,13 +605,17 @@ func cmdPinned(args []string) {
 	}
 	c.LoadPins()
 
+	rules := c.PinRules()
 	pinned := c.Pinned()
-	if len(pinned) == 0 {
+	if len(pinned) == 0 && len(rules) == 0 {
 		fmt.Println("No pinned files.")
 		return
 	}
 
 	fmt.Printf("%-40s  %10s  %s\n", "FILE ID", "SIZE", "PATH")
+	for _, r := range rules {
+		fmt.Printf("%-40s  %10s  %s\n", "(rule)", "-", r)
+	}
 	for _, e := range pinned {
 		fmt.Printf("%-40s  %10d  %s\n", e.FileID, e.Size, e.LocalPath)
 	}
@@ -387,4 +640,126 @@ func cmdStatus(args []string) {
 	fmt.Printf("Cache size:      %d bytes\n", size)
 	fmt.Printf("Max size:        %d bytes\n", maxSize)
 	fmt.Printf("Pinned files:    %d\n", len(pinned))
+	if disk := c.DiskStatus(); disk.Known {
+		fmt.Printf("Disk free:       %d bytes (%d reserved)\n", disk.Free, disk.Reserve)
+	}
+}
+
+// cmdRebuildIndex rescans the cache directory and rewrites the cache
+// index. Run it while no mount is using the cache.
+func cmdRebuildIndex(args []string) {
+	fs := flag.NewFlagSet("rebuild-index", flag.ExitOnError)
+	cacheDir := fs.String("cache", "/tmp/fruitsalade-cache", "Cache directory")
+	fs.Parse(args)
+
+	c, err := cache.New(*cacheDir, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	n, err := c.RebuildIndex()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Indexed %d cached files\n", n)
+}
+
+// cmdSyncConfig lists, adds or removes folders excluded from sync. A
+// running mount applies the change on its next refresh.
+func cmdSyncConfig(args []string) {
+	fs := flag.NewFlagSet("sync-config", flag.ExitOnError)
+	cacheDir := fs.String("cache", "/tmp/fruitsalade-cache", "Cache directory")
+	fs.Parse(args)
+
+	paths, err := selective.Load(*cacheDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch fs.Arg(0) {
+	case "", "list":
+		if len(paths) == 0 {
+			fmt.Println("No excluded folders; everything is synced.")
+			return
+		}
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		return
+	case "exclude", "include":
+		if fs.NArg() != 2 {
+			break
+		}
+		folder := path.Clean("/" + fs.Arg(1))
+		if fs.Arg(0) == "exclude" {
+			paths = append(paths, folder)
+		} else {
+			before := len(paths)
+			paths = slices.DeleteFunc(paths, func(p string) bool { return path.Clean("/"+p) == folder })
+			if len(paths) == before {
+				fmt.Fprintf(os.Stderr, "Error: %s is not excluded\n", folder)
+				os.Exit(1)
+			}
+		}
+		if err := selective.Save(*cacheDir, paths); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if fs.Arg(0) == "exclude" {
+			fmt.Printf("Excluded: %s\n", folder)
+		} else {
+			fmt.Printf("Included: %s\n", folder)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse sync-config [-cache dir] [list]\n")
+	fmt.Fprintf(os.Stderr, "       fruitsalade-fuse sync-config [-cache dir] exclude|include <path>\n")
+	os.Exit(1)
+}
+
+func cmdMatchTest(args []string) {
+	fs := flag.NewFlagSet("match-test", flag.ExitOnError)
+	ignoreCase := fs.Bool("i", false, "Case-insensitive matching")
+	isDir := fs.Bool("dir", false, "Treat the path as a directory")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse match-test [-i] [-dir] <pattern>... <path>\n")
+		os.Exit(2)
+	}
+
+	patterns := fs.Args()[:fs.NArg()-1]
+	path := fs.Arg(fs.NArg() - 1)
+
+	m, err := pattern.NewMatcher(patterns, pattern.Options{CaseInsensitive: *ignoreCase})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	for _, p := range m.Patterns() {
+		result := "-"
+		if p.Match(path, *isDir) {
+			result = "match"
+			if p.Negated() {
+				result = "match (negated)"
+			}
+		}
+		fmt.Printf("  %-30s %s\n", p.String(), result)
+	}
+
+	matched, rule := m.Explain(path, *isDir)
+	if matched {
+		fmt.Printf("%s: MATCHED by %q\n", path, rule.String())
+		return
+	}
+	if rule != nil {
+		fmt.Printf("%s: NOT MATCHED (excluded by %q)\n", path, rule.String())
+	} else {
+		fmt.Printf("%s: NOT MATCHED\n", path)
+	}
+	os.Exit(1)
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// apiFlags are the connection flags of the commands that call the API
+// without a mount.
+type apiFlags struct {
+	serverURL *string
+	token     *string
+	apiKey    *string
+}
+
+func addAPIFlags(fs *flag.FlagSet) apiFlags {
+	return apiFlags{
+		serverURL: fs.String("server", "", "Server URL (default: from saved login)"),
+		token:     fs.String("token", "", "JWT authentication token (or FRUITSALADE_TOKEN)"),
+		apiKey:    fs.String("api-key", "", "API key (or FRUITSALADE_API_KEY)"),
+	}
+}
+
+// client returns an API client authenticated by the flags, the environment
+// or the saved login, in that order, and the server URL. It exits if there
+// are no credentials.
+func (f apiFlags) client() (*client.Client, string) {
+	serverURL, token, apiKey := *f.serverURL, *f.token, *f.apiKey
+	if apiKey == "" {
+		apiKey = os.Getenv("FRUITSALADE_API_KEY")
+	}
+	if token == "" {
+		token = os.Getenv("FRUITSALADE_TOKEN")
+	}
+	if tf, err := client.LoadToken(); err == nil && !tf.IsExpired(0) {
+		if token == "" && apiKey == "" {
+			token = tf.Token
+		}
+		if serverURL == "" {
+			serverURL = tf.Server
+		}
+	}
+	if serverURL == "" || (token == "" && apiKey == "") {
+		fmt.Fprintf(os.Stderr, "Error: not logged in. Run 'fruitsalade-fuse login' or use -server with -token or -api-key\n")
+		os.Exit(1)
+	}
+
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	return client.New(client.Config{
+		BaseURL:   serverURL,
+		Timeout:   30 * time.Second,
+		AuthToken: token,
+		APIKey:    apiKey,
+	}), serverURL
+}
+
+// serverPath turns a command-line path into a server path. A path inside a
+// mounted filesystem, recognized by the .fruitsalade control directory at
+// its root, is made relative to the mount; anything else is taken as a
+// server path.
+func serverPath(arg string) string {
+	abs, err := filepath.Abs(arg)
+	if err == nil {
+		if _, err := os.Lstat(abs); err == nil {
+			for dir := abs; ; dir = filepath.Dir(dir) {
+				if _, err := os.Stat(filepath.Join(dir, ".fruitsalade", "status")); err == nil {
+					rel, _ := filepath.Rel(dir, abs)
+					if rel == "." {
+						return "/"
+					}
+					return "/" + filepath.ToSlash(rel)
+				}
+				if dir == filepath.Dir(dir) {
+					break
+				}
+			}
+		}
+	}
+	return "/" + strings.Trim(arg, "/")
+}
+
+// parseExpiry parses a link lifetime: a Go duration such as 12h or a
+// number of days such as 7d. Empty means no expiry.
+func parseExpiry(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid expiry %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid expiry %q (use e.g. 12h or 7d)", s)
+	}
+	return d, nil
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func cmdShare(args []string) {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	expires := fs.String("expires", "", "Link lifetime, e.g. 12h or 7d (default: never)")
+	password := fs.String("password", "", "Password required to open the link")
+	maxDownloads := fs.Int("max-downloads", 0, "Maximum number of downloads (0 = unlimited)")
+	asJSON := fs.Bool("json", false, "Print the link as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse share [-expires 7d] [-password pw] [-max-downloads n] [-json] <path>\n")
+		os.Exit(1)
+	}
+	ttl, err := parseExpiry(*expires)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	c, _ := api.client()
+	link, err := c.CreateShareLink(context.Background(), serverPath(fs.Arg(0)), &protocol.ShareLinkRequest{
+		Password:     *password,
+		ExpiresInSec: int64(ttl / time.Second),
+		MaxDownloads: *maxDownloads,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		printJSON(link)
+		return
+	}
+	fmt.Println(link.URL)
+}
+
+func cmdShares(args []string) {
+	fs := flag.NewFlagSet("shares", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the links as JSON")
+	fs.Parse(args)
+
+	c, serverURL := api.client()
+	links, err := c.ListShareLinks(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		if links == nil {
+			links = []protocol.ShareLinkEntry{}
+		}
+		printJSON(links)
+		return
+	}
+	if len(links) == 0 {
+		fmt.Println("No share links.")
+		return
+	}
+
+	fmt.Printf("%-24s  %-9s  %-16s  %s\n", "ID", "DOWNLOADS", "EXPIRES", "PATH")
+	for _, l := range links {
+		downloads := strconv.Itoa(l.DownloadCount)
+		if l.MaxDownloads > 0 {
+			downloads += "/" + strconv.Itoa(l.MaxDownloads)
+		}
+		expires := "never"
+		if l.ExpiresAt != nil {
+			expires = l.ExpiresAt.Local().Format("2006-01-02 15:04")
+		}
+		target := l.Path
+		if l.AlbumID != nil {
+			target = "album: " + l.AlbumName
+		}
+		fmt.Printf("%-24s  %-9s  %-16s  %s\n", l.ID, downloads, expires, target)
+	}
+	fmt.Printf("\nLinks open at %s/app/#share/<id>\n", serverURL)
+}
+
+func cmdUnshare(args []string) {
+	fs := flag.NewFlagSet("unshare", flag.ExitOnError)
+	api := addAPIFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: fruitsalade-fuse unshare [-json] <id>\n")
+		os.Exit(1)
+	}
+
+	c, _ := api.client()
+	id := fs.Arg(0)
+	if err := c.RevokeShareLink(context.Background(), id); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		printJSON(map[string]interface{}{"id": id, "revoked": true})
+		return
+	}
+	fmt.Printf("Revoked: %s\n", id)
+}
@@ -5,7 +5,10 @@
 //
 // It walks a local directory (-data flag or /testdata default),
 // uploads each file via the configured backend, and records metadata in PostgreSQL.
-// Designed to run once as an init container.
+// Designed to run once as an init container, but safe to re-run: files whose
+// size and modification time (or hash, with -verify) match the database are
+// skipped, and -prune moves entries whose source files are gone to the trash.
+// A -manifest file assigns owners, groups and visibility by path prefix.
 package main
 
 import (
@@ -16,15 +19,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/config"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
@@ -35,8 +44,20 @@ import (
 func main() {
 	dataDir := flag.String("data", "/testdata", "Directory with seed files")
 	migrationsDir := flag.String("migrations", "/app/migrations", "Migrations directory")
+	prefix := flag.String("prefix", "/", "Virtual directory to seed into")
+	verify := flag.Bool("verify", false, "Compare file hashes instead of size and modification time")
+	prune := flag.Bool("prune", false, "Move entries under -prefix whose source files no longer exist to the trash")
+	pruneStorage := flag.Bool("prune-storage", false, "With -prune, delete the entries and their storage objects instead of trashing them")
+	concurrency := flag.Int("concurrency", 4, "Number of parallel uploads")
+	manifestFile := flag.String("manifest", "", "JSON file mapping path prefixes to owner_username, group_name and visibility")
+	createMissing := flag.Bool("create-missing", false, "Create users and groups named in -manifest that do not exist")
 	flag.Parse()
 
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	seedRoot := path.Clean("/" + *prefix)
+
 	// Initialize logging
 	if err := logging.Init(logging.Config{Level: "info", Format: "console"}); err != nil {
 		panic("logging init: " + err.Error())
@@ -49,6 +70,10 @@ func main() {
 	if err != nil {
 		logging.Fatal("config error", zap.Error(err))
 	}
+	paths.SetLimits(paths.Limits{MaxBytes: cfg.MaxPathLength, MaxDepth: cfg.MaxPathDepth})
+	if err := paths.ValidatePath(seedRoot); err != nil {
+		logging.Fatal("invalid -prefix", zap.Error(err))
+	}
 
 	ctx := context.Background()
 
@@ -80,7 +105,12 @@ func main() {
 	groupStore := sharing.NewGroupStore(db)
 	locationStore := storage.NewLocationStore(db)
 
-	storageRouter, err := storage.NewRouter(ctx, locationStore, groupStore)
+	keys, err := storage.LoadKeyring(cfg.EncryptionKeys, cfg.EncryptionKeysFile)
+	if err != nil {
+		logging.Fatal("encryption keys", zap.Error(err))
+	}
+
+	storageRouter, err := storage.NewRouter(ctx, locationStore, groupStore, keys)
 	if err != nil {
 		logging.Fatal("storage router init failed", zap.Error(err))
 	}
@@ -134,6 +164,39 @@ func main() {
 		logging.Fatal("no default storage backend", zap.Error(err))
 	}
 
+	var owners *manifest
+	if *manifestFile != "" {
+		entries, err := loadManifest(*manifestFile)
+		if err != nil {
+			logging.Fatal("failed to load manifest", zap.Error(err))
+		}
+		permissionStore := sharing.NewPermissionStore(db)
+		permissionStore.SetGroupStore(groupStore)
+		provisioner := sharing.NewProvisioner(groupStore, metaStore, permissionStore)
+		owners, err = resolveManifest(ctx, entries, auth.New(db, cfg.JWTSecret), groupStore, provisioner, *createMissing)
+		if err != nil {
+			logging.Fatal("failed to resolve manifest", zap.Error(err))
+		}
+		logging.Info("loaded manifest", zap.String("file", *manifestFile), zap.Int("entries", len(entries)))
+	}
+
+	existing, err := metaStore.ListSubtree(ctx, seedRoot)
+	if err != nil {
+		logging.Fatal("failed to list existing entries", zap.Error(err))
+	}
+	sd := &seeder{
+		store:       metaStore,
+		backend:     backend,
+		verify:      *verify,
+		owners:      owners,
+		existing:    make(map[string]*postgres.SubtreeRow, len(existing)),
+		visited:     map[string]bool{"/": true},
+		createdDirs: map[string]bool{"/": true},
+	}
+	for i := range existing {
+		sd.existing[existing[i].Path] = &existing[i]
+	}
+
 	// Upsert root directory
 	root := &postgres.FileRow{
 		ID:         fileID("/"),
@@ -147,37 +210,74 @@ func main() {
 		logging.Fatal("failed to upsert root", zap.Error(err))
 	}
 
-	// Track directories we've created
-	createdDirs := map[string]bool{"/": true}
+	// Upload files in parallel; directories are created by the walk itself,
+	// which reaches each directory before anything inside it.
+	jobs := make(chan seedJob)
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := sd.seedFile(ctx, job); err != nil {
+					logging.Error("seed file failed", zap.String("path", job.virtualPath), zap.Error(err))
+					failed.Add(1)
+				}
+			}
+		}()
+	}
 
 	// Walk data directory
-	logging.Info("seeding files...", zap.String("dir", *dataDir))
-	err = filepath.Walk(*dataDir, func(path string, info os.FileInfo, walkErr error) error {
+	logging.Info("seeding files...", zap.String("dir", *dataDir), zap.String("prefix", seedRoot))
+	err = filepath.Walk(*dataDir, func(localPath string, info os.FileInfo, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
 
-		relPath, _ := filepath.Rel(*dataDir, path)
-		if relPath == "." {
+		relPath, _ := filepath.Rel(*dataDir, localPath)
+		virtualPath := path.Join(seedRoot, filepath.ToSlash(relPath))
+		if err := validPath(virtualPath); err != nil {
+			// The server would refuse the same path from a client
+			logging.Warn("skipping invalid path", zap.String("local", localPath), zap.Error(err))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		virtualPath := "/" + filepath.ToSlash(relPath)
 
 		if info.IsDir() {
-			return seedDir(ctx, metaStore, virtualPath, info, createdDirs)
+			sd.visited[virtualPath] = true
+			if err := sd.ensureParentDirs(ctx, virtualPath); err != nil {
+				return err
+			}
+			return sd.seedDir(ctx, virtualPath, info)
 		}
 
 		// Skip non-data files (scripts, SQL seeds)
-		ext := strings.ToLower(filepath.Ext(path))
+		ext := strings.ToLower(filepath.Ext(localPath))
 		if ext == ".sql" || ext == ".py" {
 			return nil
 		}
 
-		return seedFile(ctx, metaStore, backend, path, virtualPath, info, createdDirs)
+		sd.visited[virtualPath] = true
+		jobs <- seedJob{localPath: localPath, virtualPath: virtualPath, info: info}
+		return nil
 	})
+	close(jobs)
+	wg.Wait()
 	if err != nil {
 		logging.Fatal("walk failed", zap.Error(err))
 	}
+	if n := failed.Load(); n > 0 {
+		logging.Fatal("seeding failed", zap.Int64("files", n))
+	}
+
+	if *prune {
+		if err := sd.prune(ctx, storageRouter, *pruneStorage); err != nil {
+			logging.Fatal("prune failed", zap.Error(err))
+		}
+	}
 
 	// Run gallery seed SQL if present (tags, albums, album images)
 	gallerySeed := filepath.Join(*dataDir, "gallery_seed.sql")
@@ -191,7 +291,33 @@ func main() {
 	}
 
 	total, _ := metaStore.FileCount(ctx)
-	logging.Info("seeding complete", zap.Int64("entries", total))
+	logging.Info("seeding complete",
+		zap.Int64("created", sd.created.Load()),
+		zap.Int64("updated", sd.updated.Load()),
+		zap.Int64("skipped", sd.skipped.Load()),
+		zap.Int64("pruned", sd.pruned.Load()),
+		zap.Int64("entries", total))
+}
+
+// seeder holds the state of one seeding run. existing is read-only once
+// the walk starts; visited and createdDirs are only touched by the walk.
+type seeder struct {
+	store   *postgres.Store
+	backend storage.Backend
+	verify  bool
+	owners  *manifest
+
+	existing    map[string]*postgres.SubtreeRow
+	visited     map[string]bool
+	createdDirs map[string]bool
+
+	created, updated, skipped, pruned atomic.Int64
+}
+
+type seedJob struct {
+	localPath   string
+	virtualPath string
+	info        os.FileInfo
 }
 
 func fileID(virtualPath string) string {
@@ -199,30 +325,54 @@ func fileID(virtualPath string) string {
 	return fmt.Sprintf("%x", h[:8])
 }
 
-func ensureParentDirs(ctx context.Context, store *postgres.Store, virtualPath string, createdDirs map[string]bool) error {
-	dir := filepath.Dir(virtualPath)
-	if dir == "." || dir == "/" {
-		return nil
+// record counts an upserted entry and takes a trashed row back out of the
+// trash; UpsertFile leaves deleted_at alone.
+func (sd *seeder) record(ctx context.Context, virtualPath string) error {
+	prev := sd.existing[virtualPath]
+	switch {
+	case prev == nil:
+		sd.created.Add(1)
+	case prev.Deleted:
+		if err := sd.store.RestoreFile(ctx, virtualPath); err != nil {
+			return err
+		}
+		sd.created.Add(1)
+	default:
+		sd.updated.Add(1)
 	}
-	dir = filepath.ToSlash(dir)
-	if createdDirs[dir] {
+	return nil
+}
+
+// live returns the existing, not trashed row at virtualPath.
+func (sd *seeder) live(virtualPath string) *postgres.SubtreeRow {
+	if r := sd.existing[virtualPath]; r != nil && !r.Deleted {
+		return r
+	}
+	return nil
+}
+
+func (sd *seeder) ensureParentDirs(ctx context.Context, virtualPath string) error {
+	dir := path.Dir(virtualPath)
+	if sd.createdDirs[dir] {
 		return nil
 	}
-	if err := ensureParentDirs(ctx, store, dir, createdDirs); err != nil {
+	if err := sd.ensureParentDirs(ctx, dir); err != nil {
 		return err
 	}
-	return seedDir(ctx, store, dir, nil, createdDirs)
+	sd.visited[dir] = true
+	return sd.seedDir(ctx, dir, nil)
 }
 
-func seedDir(ctx context.Context, store *postgres.Store, virtualPath string, info os.FileInfo, createdDirs map[string]bool) error {
-	if createdDirs[virtualPath] {
+func (sd *seeder) seedDir(ctx context.Context, virtualPath string, info os.FileInfo) error {
+	if sd.createdDirs[virtualPath] {
 		return nil
 	}
-	parentPath := filepath.ToSlash(filepath.Dir(virtualPath))
-	if parentPath == "." {
-		parentPath = "/"
+	owner := sd.owners.lookup(virtualPath)
+	if r := sd.live(virtualPath); r != nil && r.IsDir && owner.matches(&r.FileRow) {
+		sd.createdDirs[virtualPath] = true
+		sd.skipped.Add(1)
+		return nil
 	}
-	name := filepath.Base(virtualPath)
 	modTime := time.Now()
 	if info != nil {
 		modTime = info.ModTime()
@@ -230,60 +380,156 @@ func seedDir(ctx context.Context, store *postgres.Store, virtualPath string, inf
 
 	row := &postgres.FileRow{
 		ID:         fileID(virtualPath),
-		Name:       name,
+		Name:       path.Base(virtualPath),
 		Path:       virtualPath,
-		ParentPath: parentPath,
+		ParentPath: path.Dir(virtualPath),
 		IsDir:      true,
 		ModTime:    modTime,
 	}
-	if err := store.UpsertFile(ctx, row); err != nil {
+	owner.apply(row)
+	if err := sd.store.UpsertFile(ctx, row); err != nil {
 		return fmt.Errorf("upsert dir %s: %w", virtualPath, err)
 	}
-	createdDirs[virtualPath] = true
+	if err := sd.record(ctx, virtualPath); err != nil {
+		return fmt.Errorf("restore dir %s: %w", virtualPath, err)
+	}
+	sd.createdDirs[virtualPath] = true
 	logging.Info("  DIR", zap.String("path", virtualPath))
 	return nil
 }
 
-func seedFile(ctx context.Context, store *postgres.Store, backend storage.Backend, localPath, virtualPath string, info os.FileInfo, createdDirs map[string]bool) error {
-	if err := ensureParentDirs(ctx, store, virtualPath, createdDirs); err != nil {
-		return err
+// unchanged reports whether the database already holds this version of
+// the file: same size and modification time, or same hash when verifying,
+// and the ownership the manifest asks for. data is the file content if it
+// had to be read for the hash.
+func (sd *seeder) unchanged(job seedJob) (ok bool, data []byte, err error) {
+	r := sd.live(job.virtualPath)
+	if r == nil || r.IsDir || r.Size != job.info.Size() || !sd.owners.lookup(job.virtualPath).matches(&r.FileRow) {
+		return false, nil, nil
+	}
+	if !sd.verify {
+		// PostgreSQL stores microseconds
+		return r.ModTime.Equal(job.info.ModTime().Truncate(time.Microsecond)), nil, nil
 	}
+	data, err = os.ReadFile(job.localPath)
+	if err != nil {
+		return false, nil, fmt.Errorf("read %s: %w", job.localPath, err)
+	}
+	return r.Hash == fmt.Sprintf("%x", sha256.Sum256(data)), data, nil
+}
 
-	data, err := os.ReadFile(localPath)
+func (sd *seeder) seedFile(ctx context.Context, job seedJob) error {
+	same, data, err := sd.unchanged(job)
 	if err != nil {
-		return fmt.Errorf("read %s: %w", localPath, err)
+		return err
+	}
+	if same {
+		sd.skipped.Add(1)
+		logging.Debug("  SKIP", zap.String("path", job.virtualPath))
+		return nil
+	}
+
+	if data == nil {
+		data, err = os.ReadFile(job.localPath)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", job.localPath, err)
+		}
 	}
 
 	hash := sha256.Sum256(data)
 	hashStr := fmt.Sprintf("%x", hash)
 
-	// Storage key is the virtual path without leading /
-	key := strings.TrimPrefix(virtualPath, "/")
+	key, err := storage.KeyForPath(job.virtualPath)
+	if err != nil {
+		return err
+	}
 
 	// Upload via backend interface
-	if err := backend.PutObject(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+	if err := sd.backend.PutObject(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
 		return fmt.Errorf("upload %s: %w", key, err)
 	}
 
-	parentPath := filepath.ToSlash(filepath.Dir(virtualPath))
-	if parentPath == "." {
-		parentPath = "/"
-	}
 	row := &postgres.FileRow{
-		ID:         fileID(virtualPath),
-		Name:       filepath.Base(virtualPath),
-		Path:       virtualPath,
-		ParentPath: parentPath,
+		ID:         fileID(job.virtualPath),
+		Name:       path.Base(job.virtualPath),
+		Path:       job.virtualPath,
+		ParentPath: path.Dir(job.virtualPath),
 		Size:       int64(len(data)),
-		ModTime:    info.ModTime(),
+		ModTime:    job.info.ModTime(),
 		IsDir:      false,
 		Hash:       hashStr,
 		S3Key:      key,
 	}
-	if err := store.UpsertFile(ctx, row); err != nil {
-		return fmt.Errorf("upsert file %s: %w", virtualPath, err)
+	sd.owners.lookup(job.virtualPath).apply(row)
+	if err := sd.store.UpsertFile(ctx, row); err != nil {
+		return fmt.Errorf("upsert file %s: %w", job.virtualPath, err)
+	}
+	if err := sd.record(ctx, job.virtualPath); err != nil {
+		return fmt.Errorf("restore file %s: %w", job.virtualPath, err)
+	}
+
+	logging.Info("  FILE", zap.String("path", job.virtualPath), zap.Int("bytes", len(data)))
+	return nil
+}
+
+// prune trashes live entries under the seeded prefix that the walk did not
+// visit. With purge, it deletes them and their storage objects instead.
+func (sd *seeder) prune(ctx context.Context, router *storage.Router, purge bool) error {
+	var gone []string
+	for p, r := range sd.existing {
+		if r.Deleted || sd.visited[p] {
+			continue
+		}
+		gone = append(gone, p)
 	}
+	sort.Strings(gone)
+
+	var lastDir string
+	for _, p := range gone {
+		// Deleting a directory takes everything below it along
+		if lastDir != "" && strings.HasPrefix(p, lastDir+"/") {
+			sd.pruned.Add(1)
+			continue
+		}
+		if sd.existing[p].IsDir {
+			lastDir = p
+		}
 
-	logging.Info("  FILE", zap.String("path", virtualPath), zap.Int("bytes", len(data)))
+		if !purge {
+			if err := sd.store.SoftDeleteUnattributed(ctx, p); err != nil {
+				return err
+			}
+		} else {
+			deleted, err := sd.store.DeleteTree(ctx, p)
+			if err != nil {
+				return fmt.Errorf("delete %s: %w", p, err)
+			}
+			for _, d := range deleted {
+				if d.S3Key == "" {
+					continue
+				}
+				backend, _, err := router.ResolveForFile(ctx, d.StorageLocID, d.GroupID)
+				if err != nil {
+					logging.Warn("no backend for pruned content", zap.String("key", d.S3Key), zap.Error(err))
+					continue
+				}
+				if err := sd.store.ReleaseContent(ctx, d.S3Key, d.StorageLocID, backend.DeleteObject); err != nil {
+					logging.Warn("failed to delete pruned content", zap.String("key", d.S3Key), zap.Error(err))
+				}
+			}
+		}
+		sd.pruned.Add(1)
+		logging.Info("  PRUNE", zap.String("path", p))
+	}
 	return nil
 }
+
+// validPath checks a seeded path as the server checks the paths clients
+// create: the path rules and the storage key it maps to.
+func validPath(p string) error {
+	if err := paths.ValidatePath(p); err != nil {
+		return err
+	}
+	_, err := storage.KeyForPath(p)
+	return err
+}
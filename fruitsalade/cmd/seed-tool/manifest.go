@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+)
+
+// manifestEntry assigns ownership to everything under a path prefix. The
+// manifest file is a JSON object keyed by virtual path prefix:
+//
+//	{
+//	  "/engineering": {"owner_username": "alice", "group_name": "engineering", "visibility": "group"},
+//	  "/engineering/private": {"owner_username": "bob", "visibility": "private"}
+//	}
+//
+// The longest matching prefix wins. Empty fields leave the column alone.
+type manifestEntry struct {
+	OwnerUsername string `json:"owner_username"`
+	GroupName     string `json:"group_name"`
+	Visibility    string `json:"visibility"`
+}
+
+// ownership is a manifest entry resolved to user and group IDs.
+type ownership struct {
+	ownerID    *int
+	groupID    *int
+	visibility string
+}
+
+// manifest maps path prefixes to ownership.
+type manifest struct {
+	prefixes []string // longest first
+	entries  map[string]ownership
+}
+
+// loadManifest reads and validates a manifest file.
+func loadManifest(file string) (map[string]manifestEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]manifestEntry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+
+	entries := make(map[string]manifestEntry, len(raw))
+	for prefix, e := range raw {
+		switch e.Visibility {
+		case "", "public", "group", "private":
+		default:
+			return nil, fmt.Errorf("%s: visibility must be public, group or private", prefix)
+		}
+		entries[path.Clean("/"+prefix)] = e
+	}
+	return entries, nil
+}
+
+// resolveManifest looks up the users and groups a manifest names. Unknown
+// names are an error unless createMissing is set, in which case users get a
+// random password and groups are created with their folders.
+func resolveManifest(ctx context.Context, entries map[string]manifestEntry, users *auth.Auth, groups *sharing.GroupStore, provisioner *sharing.Provisioner, createMissing bool) (*manifest, error) {
+	userIDs := map[string]int{}
+	groupIDs := map[string]int{}
+	var unknown []string
+
+	lookupUser := func(name string) (int, error) {
+		if id, ok := userIDs[name]; ok {
+			return id, nil
+		}
+		id, err := users.GetUserIDByUsername(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+		if id == 0 && createMissing {
+			if id, err = users.CreateUserRandomPassword(ctx, name); err != nil {
+				return 0, fmt.Errorf("create user %q: %w", name, err)
+			}
+			logging.Info("created user from manifest", zap.String("username", name))
+		}
+		if id == 0 {
+			unknown = append(unknown, "user "+name)
+		}
+		userIDs[name] = id
+		return id, nil
+	}
+	lookupGroup := func(name string) (int, error) {
+		if id, ok := groupIDs[name]; ok {
+			return id, nil
+		}
+		id, err := groups.GetGroupIDByName(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+		if id == 0 && createMissing {
+			g, err := groups.CreateGroup(ctx, name, "Created by seed-tool", nil, 0)
+			if err != nil {
+				return 0, fmt.Errorf("create group %q: %w", name, err)
+			}
+			if err := provisioner.ProvisionGroupFolders(ctx, g); err != nil {
+				return 0, fmt.Errorf("provision group %q: %w", name, err)
+			}
+			id = g.ID
+			logging.Info("created group from manifest", zap.String("group", name))
+		}
+		if id == 0 {
+			unknown = append(unknown, "group "+name)
+		}
+		groupIDs[name] = id
+		return id, nil
+	}
+
+	m := &manifest{entries: make(map[string]ownership, len(entries))}
+	for prefix, e := range entries {
+		o := ownership{visibility: e.Visibility}
+		if e.OwnerUsername != "" {
+			id, err := lookupUser(e.OwnerUsername)
+			if err != nil {
+				return nil, err
+			}
+			o.ownerID = &id
+		}
+		if e.GroupName != "" {
+			id, err := lookupGroup(e.GroupName)
+			if err != nil {
+				return nil, err
+			}
+			o.groupID = &id
+		}
+		m.entries[prefix] = o
+		m.prefixes = append(m.prefixes, prefix)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown %s (use -create-missing to create them)", strings.Join(unknown, ", "))
+	}
+
+	sort.Slice(m.prefixes, func(i, j int) bool { return len(m.prefixes[i]) > len(m.prefixes[j]) })
+	return m, nil
+}
+
+// lookup returns the ownership for virtualPath, or nil if no entry covers it.
+func (m *manifest) lookup(virtualPath string) *ownership {
+	if m == nil {
+		return nil
+	}
+	for _, p := range m.prefixes {
+		if p == "/" || virtualPath == p || strings.HasPrefix(virtualPath, p+"/") {
+			o := m.entries[p]
+			return &o
+		}
+	}
+	return nil
+}
+
+// apply sets the ownership columns on row.
+func (o *ownership) apply(row *postgres.FileRow) {
+	if o == nil {
+		return
+	}
+	row.OwnerID = o.ownerID
+	row.GroupID = o.groupID
+	row.Visibility = o.visibility
+}
+
+// matches reports whether row already carries this ownership. UpsertFile
+// never replaces an owner, so a row with a different one still matches.
+func (o *ownership) matches(row *postgres.FileRow) bool {
+	if o == nil {
+		return true
+	}
+	if o.ownerID != nil && row.OwnerID == nil {
+		return false
+	}
+	if o.groupID != nil && (row.GroupID == nil || *row.GroupID != *o.groupID) {
+		return false
+	}
+	return o.visibility == "" || o.visibility == row.Visibility
+}
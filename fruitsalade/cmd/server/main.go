@@ -14,6 +14,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,23 +22,38 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/api"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/config"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/importer"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/scrub"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sftpd"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
 	s3storage "github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/s3"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/textindex"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/thumbs"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/webhooks"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
 	"go.uber.org/zap"
 )
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "-version" || os.Args[1] == "--version" || os.Args[1] == "version") {
+		fmt.Printf("fruitsalade-server %s\n", version.String())
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -55,8 +71,16 @@ func main() {
 	defer logging.Sync()
 
 	logging.Info("FruitSalade Server starting...",
+		zap.String("version", version.Version),
+		zap.String("commit", version.Commit),
+		zap.String("build_date", version.BuildDate),
 		zap.String("listen", cfg.ListenAddr),
 		zap.String("metrics", cfg.MetricsAddr))
+	if cfg.MinClientVersion != "" {
+		logging.Info("minimum client version enforced", zap.String("min_client_version", cfg.MinClientVersion))
+	}
+
+	paths.SetLimits(paths.Limits{MaxBytes: cfg.MaxPathLength, MaxDepth: cfg.MaxPathDepth})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -68,6 +92,7 @@ func main() {
 		logging.Fatal("database connection failed", zap.Error(err))
 	}
 	defer metaStore.Close()
+	metaStore.SetDedup(cfg.DedupEnabled)
 
 	// Run migrations
 	migrationsDir := findMigrationsDir()
@@ -81,18 +106,42 @@ func main() {
 	// Initialize auth
 	db := metaStore.DB()
 	authHandler := auth.New(db, cfg.JWTSecret)
+	authHandler.SetPasswordPolicy(auth.PasswordPolicy{
+		MinLength:      cfg.PasswordMinLength,
+		RequireMixed:   cfg.PasswordRequireMixed,
+		RejectUsername: cfg.PasswordRejectUsername,
+	})
+	authHandler.SetLockoutConfig(auth.LockoutConfig{
+		MaxFailures:      cfg.LoginMaxFailures,
+		MaxFailuresPerIP: cfg.LoginMaxFailuresPerIP,
+		BaseLockout:      cfg.LoginLockout,
+		MaxLockout:       cfg.LoginLockoutMax,
+	})
+	trustedProxies, err := auth.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logging.Fatal("invalid TRUSTED_PROXIES", zap.Error(err))
+	}
+	authHandler.SetTrustedProxies(trustedProxies)
 	if err := authHandler.EnsureDefaultAdmin(ctx); err != nil {
 		logging.Error("failed to ensure default admin", zap.Error(err))
 	}
 
+	// Activity log: written in batches off the request path
+	activityRecorder := activity.NewRecorder(metaStore, 1000)
+	activityRecorder.Start()
+	defer activityRecorder.Stop()
+	authHandler.SetActivityRecorder(activityRecorder)
+
 	// Initialize OIDC provider (optional)
+	var oidcProvider *auth.OIDCProvider
 	if cfg.OIDCIssuerURL != "" {
-		oidcProvider, err := auth.NewOIDCProvider(ctx, auth.OIDCConfig{
+		oidcProvider, err = auth.NewOIDCProvider(ctx, auth.OIDCConfig{
 			IssuerURL:    cfg.OIDCIssuerURL,
 			ClientID:     cfg.OIDCClientID,
 			ClientSecret: cfg.OIDCClientSecret,
 			AdminClaim:   cfg.OIDCAdminClaim,
 			AdminValue:   cfg.OIDCAdminValue,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
 		}, authHandler)
 		if err != nil {
 			logging.Fatal("OIDC provider init failed", zap.Error(err))
@@ -115,6 +164,7 @@ func main() {
 
 	// Initialize quota store and rate limiter
 	quotaStore := quota.NewQuotaStore(db)
+	quotaStore.SetCountDerived(cfg.QuotaIncludeDerived)
 	rateLimiter := quota.NewRateLimiter(quotaStore)
 	logging.Info("quota and rate limiter initialized")
 
@@ -122,10 +172,32 @@ func main() {
 	provisioner := sharing.NewProvisioner(groupStore, metaStore, permissionStore)
 	logging.Info("provisioner initialized")
 
+	if cfg.ProvisionRepair {
+		reports, err := provisioner.RepairAll(ctx)
+		if err != nil {
+			logging.Error("provision repair failed", zap.Error(err))
+		}
+		for _, rep := range reports {
+			for _, item := range rep.Created {
+				logging.Info("provision repair: recreated",
+					zap.String("group", rep.GroupName), zap.String("type", item.Type), zap.String("path", item.Path))
+			}
+			for _, e := range rep.Errors {
+				logging.Warn("provision repair: "+e, zap.String("group", rep.GroupName))
+			}
+		}
+		logging.Info("provision repair complete", zap.Int("groups", len(reports)))
+	}
+
 	// Initialize storage location store and router
 	locationStore := storage.NewLocationStore(db)
 
-	storageRouter, err := storage.NewRouter(ctx, locationStore, groupStore)
+	keys, err := storage.LoadKeyring(cfg.EncryptionKeys, cfg.EncryptionKeysFile)
+	if err != nil {
+		logging.Fatal("encryption keys", zap.Error(err))
+	}
+
+	storageRouter, err := storage.NewRouter(ctx, locationStore, groupStore, keys)
 	if err != nil {
 		logging.Fatal("storage router init failed", zap.Error(err))
 	}
@@ -173,10 +245,32 @@ func main() {
 			zap.String("name", locName))
 	}
 
+	// Check every storage location's health and tell admins about changes
+	storageRouter.SetOnHealthChange(func(loc *storage.StorageLocation, h storage.LocationHealth) {
+		e := events.Event{
+			Type:      events.EventStorageHealth,
+			Timestamp: time.Now().Unix(),
+			AdminOnly: true,
+			Storage: &protocol.StorageHealthPayload{
+				LocationID: loc.ID,
+				Name:       loc.Name,
+				Healthy:    h.Healthy,
+				Error:      h.Error,
+			},
+		}
+		if replica := storageRouter.HealthyReplica(loc.ID); replica != nil && !h.Healthy {
+			e.Storage.ReplicaID = replica.ID
+		}
+		broadcaster.Publish(e)
+	})
+	storageRouter.StartHealthChecks(ctx, 30*time.Second)
+
 	// Initialize gallery subsystem
 	galleryStore := gallery.NewGalleryStore(db)
-	pluginCaller := gallery.NewPluginCaller()
-	processor := gallery.NewProcessor(galleryStore, storageRouter, pluginCaller, 2)
+	pluginCaller := gallery.NewPluginCaller(cfg.GalleryPluginDir)
+	processor := gallery.NewProcessor(galleryStore, storageRouter, pluginCaller, cfg.GalleryWorkers)
+	processor.SetMaxAttempts(cfg.GalleryMaxAttempts)
+	processor.SetStackWindow(cfg.GalleryStackWindow)
 	processor.Start(ctx)
 	defer processor.Stop()
 
@@ -195,12 +289,81 @@ func main() {
 		provisioner, locationStore,
 		galleryDeps,
 	)
+
+	// OIDC group membership sync (needs the provisioner and tree refresh)
+	if oidcProvider != nil && cfg.OIDCGroupsClaim != "" {
+		groupSync := sharing.NewClaimGroupSync(groupStore, provisioner, cfg.OIDCGroupRole, cfg.OIDCAutoCreateGroups)
+		groupSync.SetOnChange(func(ctx context.Context) { srv.RefreshTree(ctx) })
+		oidcProvider.SetGroupSyncer(groupSync)
+		logging.Info("OIDC group sync enabled",
+			zap.String("claim", cfg.OIDCGroupsClaim),
+			zap.Bool("autocreate", cfg.OIDCAutoCreateGroups))
+	}
+
+	// Content search: extract and index text from documents
+	textIndexStore := textindex.NewStore(db)
+	textIndexer := textindex.NewProcessor(textIndexStore, storageRouter, 1, cfg.ContentIndexMaxSize)
+	textIndexer.Start(ctx)
+	defer textIndexer.Stop()
+	srv.SetTextIndex(textIndexStore, textIndexer)
+
+	// Thumbnails for documents, videos and images outside the gallery
+	thumbGenerator := thumbs.NewGenerator(galleryStore, storageRouter, 2)
+	thumbGenerator.Start(ctx)
+	defer thumbGenerator.Stop()
+	srv.SetThumbnails(thumbGenerator)
+
+	// Initialize integrity scrubber
+	scrubber := scrub.New(metaStore, storageRouter, cfg.ScrubMaxBytesPerSec, cfg.ScrubAutoRepair)
+	scrubber.Start(ctx, cfg.ScrubInterval)
+	srv.SetScrubber(scrubber)
+	srv.SetImporter(importer.New(ctx, metaStore, storageRouter))
+	srv.SetRekeyer(storage.NewRekeyer(ctx))
+	srv.SetActivityRecorder(activityRecorder)
+
+	// Outbound webhooks for file events
+	webhookStore := webhooks.NewStore(db)
+	webhookDispatcher := webhooks.NewDispatcher(webhookStore, broadcaster, webhooks.Options{
+		Workers:       cfg.WebhookWorkers,
+		QueueSize:     cfg.WebhookQueueSize,
+		MaxAttempts:   cfg.WebhookMaxAttempts,
+		Timeout:       cfg.WebhookTimeout,
+		AllowInternal: cfg.WebhookAllowInternal,
+	})
+	webhookDispatcher.Start(ctx)
+	defer webhookDispatcher.Stop()
+	srv.SetWebhooks(webhookStore, webhookDispatcher)
+
+	// Settings changed by an admin (and saved ones applied by Init) take
+	// effect without a restart
+	settings := srv.Settings()
+	settings.Subscribe(func(st config.Settings) {
+		logging.SetLevel(st.LogLevel)
+	})
+
 	if err := srv.Init(ctx); err != nil {
 		logging.Fatal("server init failed", zap.Error(err))
 	}
 
-	// Backfill gallery: process any existing unprocessed images
+	// SFTP frontend (optional)
+	if cfg.SFTPListenAddr != "" {
+		hostKey, err := sftpd.LoadOrCreateHostKey(cfg.SFTPHostKeyFile)
+		if err != nil {
+			logging.Fatal("failed to load sftp host key", zap.Error(err))
+		}
+		sftpServer := sftpd.NewServer(
+			metaStore, storageRouter, authHandler, permissionStore,
+			quotaStore, srv, srv.Uploads(), hostKey,
+		)
+		if err := sftpServer.Start(cfg.SFTPListenAddr); err != nil {
+			logging.Fatal("failed to start sftp server", zap.Error(err))
+		}
+		defer sftpServer.Stop()
+	}
+
+	// Backfill gallery and content index for existing files
 	go processor.ProcessExisting(ctx)
+	go textIndexer.ProcessExisting(ctx)
 
 	// Start metrics server
 	metricsServer := &http.Server{
@@ -227,14 +390,41 @@ func main() {
 		}
 	}
 
-	// Graceful shutdown
+	// Graceful shutdown: stop SSE and new upload sessions, let in-flight
+	// requests and the gallery queue finish within SHUTDOWN_TIMEOUT, then
+	// abort whatever is left.
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
-		logging.Info("shutting down...")
+		logging.Info("shutting down...", zap.Duration("timeout", cfg.ShutdownTimeout))
+
+		srv.BeginShutdown()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer shutdownCancel()
+
+		pending := srv.InFlight()
+		var aborted int64
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			aborted = srv.InFlight()
+			logging.Warn("drain timeout exceeded, aborting remaining requests", zap.Error(err))
+			httpServer.Close()
+		}
+		drained := pending - aborted
+		if drained < 0 {
+			drained = 0
+		}
+		logging.Info("http server stopped",
+			zap.Int64("drained", drained),
+			zap.Int64("aborted", aborted))
+
+		if processor.Drain(shutdownCtx) {
+			logging.Info("gallery queue drained")
+		}
+
 		cancel()
-		httpServer.Close()
 		metricsServer.Close()
 	}()
 
@@ -252,7 +442,7 @@ func main() {
 		}
 	}()
 
-	// Start periodic cleanup (rate limiter buckets + old bandwidth records)
+	// Start periodic cleanup (rate limiter buckets, old bandwidth records and usage snapshots)
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour)
 		defer ticker.Stop()
@@ -267,37 +457,33 @@ func main() {
 				} else if n > 0 {
 					logging.Info("cleaned old bandwidth records", zap.Int64("count", n))
 				}
+				if n, err := metaStore.PruneUsageSnapshots(ctx, cfg.UsageHistoryDays); err != nil {
+					logging.Error("usage snapshot cleanup failed", zap.Error(err))
+				} else if n > 0 {
+					logging.Info("pruned old usage snapshots", zap.Int64("count", n))
+				}
 			}
 		}
 	}()
 
-	// Start periodic trash auto-purge (30-day retention)
+	// Record daily usage snapshots for the stats history (once at startup,
+	// which just refreshes today's row after a restart)
 	go func() {
-		ticker := time.NewTicker(6 * time.Hour)
+		ticker := time.NewTicker(24 * time.Hour)
 		defer ticker.Stop()
 		for {
+			if snap, err := metaStore.RecordUsageSnapshot(ctx); err != nil {
+				logging.Error("usage snapshot failed", zap.Error(err))
+			} else {
+				logging.Debug("usage snapshot recorded",
+					zap.String("date", snap.Date),
+					zap.Int64("total_bytes", snap.TotalBytes),
+					zap.Int64("files", snap.FileCount))
+			}
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				purged, err := metaStore.PurgeExpiredTrash(ctx, 30*24*time.Hour)
-				if err != nil {
-					logging.Error("trash auto-purge failed", zap.Error(err))
-					continue
-				}
-				if len(purged) > 0 {
-					for _, p := range purged {
-						if p.S3Key == "" {
-							continue
-						}
-						backend, _, err := storageRouter.ResolveForFile(ctx, p.StorageLocID, p.GroupID)
-						if err == nil && backend != nil {
-							backend.DeleteObject(ctx, p.S3Key)
-						}
-					}
-					srv.RefreshTree(ctx)
-					logging.Info("trash auto-purge completed", zap.Int("purged", len(purged)))
-				}
 			}
 		}
 	}()
@@ -315,6 +501,10 @@ func main() {
 			logging.Fatal("server error", zap.Error(err))
 		}
 	}
+
+	// ListenAndServe returns as soon as Shutdown starts; wait for the drain
+	// before the deferred Stop calls run.
+	<-shutdownDone
 }
 
 func findMigrationsDir() string {
@@ -7,6 +7,15 @@
 // Usage:
 //
 //	fruitsalade-winclient -server http://host:48000 -token TOKEN -sync-root /path
+//
+// On Windows a notification area icon shows the sync state and offers to
+// pause sync, open the sync folder or the web app, and show recent
+// activity. Use -tray=false to run without it.
+//
+// With -log-file, the log is also written to a file, which is rotated at
+// -log-max-size bytes keeping -log-max-files old files. Run as a service,
+// the client logs to %ProgramData%\FruitSalade\logs\fruitsalade.log
+// unless -log-file is given.
 package main
 
 import (
@@ -19,19 +28,27 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/winclient"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/cache"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
 	"golang.org/x/term"
 )
 
 func main() {
+	client.UserAgent = version.UserAgent("windows")
+
 	// Handle subcommands before flag.Parse()
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
+		case "version":
+			fmt.Printf("fruitsalade-winclient %s\n", version.String())
+			return
 		case "login":
 			cmdLogin(os.Args[2:])
 			return
@@ -45,22 +62,46 @@ func main() {
 	syncRoot := flag.String("sync-root", defaultSyncRoot(), "Sync root directory")
 	server := flag.String("server", "http://localhost:48000", "Server URL")
 	token := flag.String("token", "", "Auth token (JWT)")
+	apiKey := flag.String("api-key", "", "API key (fsk_...) to use instead of a token")
 	cacheDir := flag.String("cache", defaultCacheDir(), "Cache directory")
 	maxCache := flag.Int64("max-cache", 1<<30, "Max cache size in bytes")
+	minFree := flag.Int64("min-free", 0, "Free bytes kept on the cache's volume (0 = 1GB or 5% of the volume, whichever is less; -1 = none)")
 	refresh := flag.Duration("refresh", 30*time.Second, "Metadata refresh interval (0 to disable)")
 	watchSSE := flag.Bool("watch", true, "Watch for SSE events")
 	healthCheck := flag.Duration("health-check", 15*time.Second, "Health check period (0 to disable)")
 	verifyHash := flag.Bool("verify-hash", false, "Verify file hashes after download")
+	maxDownloadRate := flag.String("max-download-rate", "", "Cap background downloads at this rate, e.g. 2MB (per second); opening files is not held back")
+	maxUploadRate := flag.String("max-upload-rate", "", "Cap uploads at this rate, e.g. 512KB (per second)")
+	rateSchedule := flag.String("rate-schedule", "", "Other rates by time of day, e.g. 19:00-07:00=unlimited,12:00-13:00=5MB")
+	var excludes stringList
+	flag.Var(&excludes, "exclude", "Server folder to leave out of the sync root (repeatable; the service uses only the sync-config file in the cache directory)")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus cache metrics on this address (e.g. 127.0.0.1:9101)")
 	verbose := flag.Bool("v", false, "Verbose (debug) logging")
+	logFile := flag.String("log-file", "", "Also write the log to this file (the service logs to %ProgramData%\\FruitSalade\\logs by default)")
+	logMaxSize := flag.Int64("log-max-size", logger.DefaultMaxSize, "Rotate the log file when it would grow past this many bytes (0 = never)")
+	logMaxFiles := flag.Int("log-max-files", logger.DefaultMaxFiles, "Rotated log files to keep")
+	showTray := flag.Bool("tray", true, "Show the notification area icon (Windows only)")
 	installService := flag.Bool("install-service", false, "Install as Windows service")
 	uninstallService := flag.Bool("uninstall-service", false, "Uninstall Windows service")
+	showVersion := flag.Bool("version", false, "Print version and exit")
 
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Printf("fruitsalade-winclient %s\n", version.String())
+		return
+	}
+
 	if *verbose {
 		logger.SetLevel(logger.LevelDebug)
 	}
 
+	rates, err := client.ParseRateLimits(*maxDownloadRate, *maxUploadRate, *rateSchedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Handle service install/uninstall
 	if *installService {
 		doInstallService()
@@ -71,18 +112,36 @@ func main() {
 		return
 	}
 
+	// A service has no console, so it always logs to a file
+	asService := isWindowsService()
+	if asService && *logFile == "" {
+		*logFile = serviceLogFile()
+	}
+	if *logFile != "" {
+		f, err := logger.OpenRotatingFile(*logFile, *logMaxSize, *logMaxFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		logger.AddSink(f)
+	}
+
 	// Check if running as Windows service
-	if isWindowsService() {
-		runAsService(*mode, *syncRoot, *server, *token, *cacheDir, *maxCache,
-			*refresh, *watchSSE, *healthCheck, *verifyHash)
+	if asService {
+		runAsService(*mode, *syncRoot, *server, *token, *cacheDir, *maxCache, *minFree,
+			*refresh, *watchSSE, *healthCheck, *verifyHash, rates)
 		return
 	}
 
 	// Auto-load token from file if not provided via flag or env
+	if *apiKey == "" {
+		*apiKey = os.Getenv("FRUITSALADE_API_KEY")
+	}
 	if *token == "" {
 		*token = os.Getenv("FRUITSALADE_TOKEN")
 	}
-	if *token == "" {
+	if *token == "" && *apiKey == "" {
 		if tf, err := client.LoadToken(); err == nil {
 			if tf.IsExpired(0) {
 				fmt.Fprintf(os.Stderr, "Error: saved token has expired. Run 'login' to authenticate.\n")
@@ -96,13 +155,17 @@ func main() {
 	cfg := winclient.CoreConfig{
 		ServerURL:         *server,
 		AuthToken:         *token,
+		APIKey:            *apiKey,
 		CacheDir:          *cacheDir,
 		SyncRoot:          *syncRoot,
 		MaxCacheSize:      *maxCache,
+		MinFreeSpace:      *minFree,
 		RefreshInterval:   *refresh,
 		HealthCheckPeriod: *healthCheck,
 		WatchSSE:          *watchSSE,
 		VerifyHash:        *verifyHash,
+		Exclude:           excludes,
+		RateLimits:        rates,
 	}
 
 	core, err := winclient.NewClientCore(cfg)
@@ -111,6 +174,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *metricsAddr != "" {
+		cache.ServeMetrics(*metricsAddr, cache.NewCollector(core.Cache))
+	}
+
 	// Fetch initial metadata
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -130,13 +197,23 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	var stopOnce sync.Once
+	shutdown := func() {
+		stopOnce.Do(func() {
+			logger.Info("Shutting down...")
+			cancel()
+			backend.Stop()
+		})
+	}
 	go func() {
 		<-sigCh
-		logger.Info("Shutting down...")
-		cancel()
-		backend.Stop()
+		shutdown()
 	}()
 
+	if *showTray {
+		startTray(core, *syncRoot, *server, shutdown)
+	}
+
 	// Start backend (blocks)
 	if err := backend.Start(ctx, core); err != nil {
 		if ctx.Err() != nil {
@@ -149,6 +226,16 @@ func main() {
 	}
 }
 
+// stringList collects the values of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func selectBackend(mode, syncRoot string) winclient.Backend {
 	switch mode {
 	case "cfapi":
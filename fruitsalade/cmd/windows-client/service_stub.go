@@ -6,15 +6,22 @@ import (
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 )
 
 func isWindowsService() bool {
 	return false
 }
 
+// serviceLogFile is never used: there is no service outside Windows.
+func serviceLogFile() string {
+	return ""
+}
+
 func runAsService(mode, syncRoot, server, token, cacheDir string,
-	maxCache int64, refresh time.Duration, watchSSE bool,
-	healthCheck time.Duration, verifyHash bool) {
+	maxCache, minFree int64, refresh time.Duration, watchSSE bool,
+	healthCheck time.Duration, verifyHash bool, rates client.RateLimits) {
 	fmt.Fprintln(os.Stderr, "Windows service mode is only available on Windows.")
 	os.Exit(1)
 }
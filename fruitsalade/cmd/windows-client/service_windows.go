@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/winclient"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
 )
 
@@ -27,10 +29,12 @@ type fruitService struct {
 	token      string
 	cacheDir   string
 	maxCache   int64
+	minFree    int64
 	refresh    time.Duration
 	watchSSE   bool
 	healthChk  time.Duration
 	verifyHash bool
+	rates      client.RateLimits
 }
 
 func (s *fruitService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
@@ -42,10 +46,12 @@ func (s *fruitService) Execute(args []string, r <-chan svc.ChangeRequest, change
 		CacheDir:          s.cacheDir,
 		SyncRoot:          s.syncRoot,
 		MaxCacheSize:      s.maxCache,
+		MinFreeSpace:      s.minFree,
 		RefreshInterval:   s.refresh,
 		HealthCheckPeriod: s.healthChk,
 		WatchSSE:          s.watchSSE,
 		VerifyHash:        s.verifyHash,
+		RateLimits:        s.rates,
 	}
 
 	core, err := winclient.NewClientCore(cfg)
@@ -101,9 +107,19 @@ func isWindowsService() bool {
 	return isService
 }
 
+// serviceLogFile returns the default log file of the service, under
+// %ProgramData% since the service has no console to log to.
+func serviceLogFile() string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "FruitSalade", "logs", "fruitsalade.log")
+}
+
 func runAsService(mode, syncRoot, server, token, cacheDir string,
-	maxCache int64, refresh time.Duration, watchSSE bool,
-	healthCheck time.Duration, verifyHash bool) {
+	maxCache, minFree int64, refresh time.Duration, watchSSE bool,
+	healthCheck time.Duration, verifyHash bool, rates client.RateLimits) {
 
 	svcHandler := &fruitService{
 		mode:       mode,
@@ -112,10 +128,12 @@ func runAsService(mode, syncRoot, server, token, cacheDir string,
 		token:      token,
 		cacheDir:   cacheDir,
 		maxCache:   maxCache,
+		minFree:    minFree,
 		refresh:    refresh,
 		watchSSE:   watchSSE,
 		healthChk:  healthCheck,
 		verifyHash: verifyHash,
+		rates:      rates,
 	}
 
 	if err := svc.Run(serviceName, svcHandler); err != nil {
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+import "github.com/fruitsalade/fruitsalade/fruitsalade/internal/winclient"
+
+// startTray does nothing: the tray icon is only available on Windows.
+func startTray(core *winclient.ClientCore, syncRoot, webURL string, quit func()) {}
@@ -0,0 +1,479 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/winclient"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+)
+
+// The tray icon shows the sync state of the client and offers a menu to
+// pause sync, open the sync folder or the web app, and look at recent
+// activity. It talks to Win32 directly: a hidden window owns the
+// notification area icon and receives its clicks, and updates from the
+// client are posted to that window so that all UI work happens on the
+// tray's own locked OS thread. Notifications ("balloons", shown as toasts
+// on Windows 10 and later) announce a rejected login and sync conflicts.
+
+var (
+	user32  = windows.NewLazySystemDLL("user32.dll")
+	shell32 = windows.NewLazySystemDLL("shell32.dll")
+
+	procRegisterClassExW       = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW        = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW         = user32.NewProc("DefWindowProcW")
+	procDestroyWindow          = user32.NewProc("DestroyWindow")
+	procGetMessageW            = user32.NewProc("GetMessageW")
+	procTranslateMessage       = user32.NewProc("TranslateMessage")
+	procDispatchMessageW       = user32.NewProc("DispatchMessageW")
+	procPostMessageW           = user32.NewProc("PostMessageW")
+	procPostQuitMessage        = user32.NewProc("PostQuitMessage")
+	procRegisterWindowMessageW = user32.NewProc("RegisterWindowMessageW")
+	procLoadIconW              = user32.NewProc("LoadIconW")
+	procCreatePopupMenu        = user32.NewProc("CreatePopupMenu")
+	procAppendMenuW            = user32.NewProc("AppendMenuW")
+	procTrackPopupMenu         = user32.NewProc("TrackPopupMenu")
+	procDestroyMenu            = user32.NewProc("DestroyMenu")
+	procGetCursorPos           = user32.NewProc("GetCursorPos")
+	procSetForegroundWindow    = user32.NewProc("SetForegroundWindow")
+	procShellNotifyIconW       = shell32.NewProc("Shell_NotifyIconW")
+)
+
+// Win32 constants used by the tray.
+const (
+	wmDestroy     = 0x0002
+	wmClose       = 0x0010
+	wmContextMenu = 0x007B
+	wmCommand     = 0x0111
+	wmLButtonUp   = 0x0202
+	wmRButtonUp   = 0x0205
+	wmApp         = 0x8000
+
+	wmTrayIcon   = wmApp + 1 // notification icon callback
+	wmTrayUpdate = wmApp + 2 // status changed or a notification is queued
+
+	nimAdd    = 0
+	nimModify = 1
+	nimDelete = 2
+
+	nifMessage = 0x01
+	nifIcon    = 0x02
+	nifTip     = 0x04
+	nifInfo    = 0x10
+
+	niifInfo    = 0x1
+	niifWarning = 0x2
+	niifError   = 0x3
+
+	idiApplication = 32512
+	idiError       = 32513
+	idiWarning     = 32515
+
+	mfString    = 0x000
+	mfGrayed    = 0x001
+	mfPopup     = 0x010
+	mfSeparator = 0x800
+
+	tpmRightButton = 0x002
+	tpmNoNotify    = 0x080
+	tpmReturnCmd   = 0x100
+)
+
+// Tray menu command IDs. Activity entries are informational and have none.
+const (
+	cmdPause = iota + 1
+	cmdOpenFolder
+	cmdOpenWeb
+	cmdQuit
+)
+
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   windows.Handle
+	Icon       windows.Handle
+	Cursor     windows.Handle
+	Background windows.Handle
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     windows.Handle
+}
+
+type point struct {
+	X, Y int32
+}
+
+type msg struct {
+	Hwnd    windows.HWND
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      point
+	Private uint32
+}
+
+type notifyIconData struct {
+	Size            uint32
+	Wnd             windows.HWND
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            windows.Handle
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	TimeoutVersion  uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GUIDItem        windows.GUID
+	BalloonIcon     windows.Handle
+}
+
+// notification is a balloon waiting to be shown by the tray thread.
+type notification struct {
+	title, text string
+	flags       uint32
+}
+
+// tray is the notification area icon. It implements winclient.Observer.
+type tray struct {
+	core     *winclient.ClientCore
+	syncRoot string
+	webURL   string
+	quit     func()
+
+	hwnd           windows.HWND
+	taskbarCreated uint32 // message sent when Explorer restarts
+
+	mu      sync.Mutex
+	status  winclient.SyncStatus
+	pending []notification
+}
+
+// theTray is the tray of this process, for the window procedure.
+var theTray *tray
+
+// startTray shows the tray icon until quit is called or the process exits.
+// quit is called when the user picks Quit from the menu.
+func startTray(core *winclient.ClientCore, syncRoot, webURL string, quit func()) {
+	t := &tray{core: core, syncRoot: syncRoot, webURL: webURL, quit: quit, status: core.Status()}
+	theTray = t
+	ready := make(chan error, 1)
+	go t.run(ready)
+	if err := <-ready; err != nil {
+		logger.Error("Tray icon unavailable: %v", err)
+		return
+	}
+	core.AddObserver(t)
+}
+
+// run creates the window and icon and runs the message loop on a locked
+// OS thread, as Win32 windows belong to the thread that created them.
+func (t *tray) run(ready chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := t.create(); err != nil {
+		ready <- err
+		return
+	}
+	ready <- nil
+
+	var m msg
+	for {
+		r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		if int32(r) <= 0 {
+			return
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+func (t *tray) create() error {
+	var instance windows.Handle
+	if err := windows.GetModuleHandleEx(0, nil, &instance); err != nil {
+		return fmt.Errorf("module handle: %w", err)
+	}
+	className := windows.StringToUTF16Ptr("FruitSaladeTray")
+	wc := wndClassEx{
+		WndProc:   windows.NewCallback(trayWndProc),
+		Instance:  instance,
+		ClassName: className,
+	}
+	wc.Size = uint32(unsafe.Sizeof(wc))
+	if r, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&wc))); r == 0 {
+		return fmt.Errorf("register window class: %w", err)
+	}
+
+	hwnd, _, err := procCreateWindowExW.Call(0,
+		uintptr(unsafe.Pointer(className)),
+		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr("FruitSalade"))),
+		0, 0, 0, 0, 0, 0, 0, uintptr(instance), 0)
+	if hwnd == 0 {
+		return fmt.Errorf("create window: %w", err)
+	}
+	t.hwnd = windows.HWND(hwnd)
+
+	msgID, _, _ := procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(windows.StringToUTF16Ptr("TaskbarCreated"))))
+	t.taskbarCreated = uint32(msgID)
+
+	if !t.notifyIcon(nimAdd, nil) {
+		procDestroyWindow.Call(hwnd)
+		return fmt.Errorf("add notification icon")
+	}
+	return nil
+}
+
+// StatusChanged implements winclient.Observer.
+func (t *tray) StatusChanged(st winclient.SyncStatus) {
+	t.mu.Lock()
+	prev := t.status
+	t.status = st
+	if st.State == winclient.StateAuthFailed && prev.State != winclient.StateAuthFailed {
+		t.pending = append(t.pending, notification{
+			title: "FruitSalade: sign-in required",
+			text:  "The server rejected the login. Run \"fruitsalade-winclient login\" to sign in again.",
+			flags: niifError,
+		})
+	}
+	if st.DiskFull && !prev.DiskFull {
+		t.pending = append(t.pending, notification{
+			title: "FruitSalade: disk almost full",
+			text:  "Files are no longer kept offline and edits cannot be saved until disk space is freed.",
+			flags: niifWarning,
+		})
+	}
+	t.mu.Unlock()
+	t.post()
+}
+
+// ActivityAdded implements winclient.Observer.
+func (t *tray) ActivityAdded(a winclient.Activity) {
+	if a.Kind != winclient.ActivityConflict {
+		return
+	}
+	t.mu.Lock()
+	t.pending = append(t.pending, notification{
+		title: "FruitSalade: sync conflict",
+		text:  a.Path + " changed on the server while it was edited here. Your version was saved as a conflict copy.",
+		flags: niifWarning,
+	})
+	t.mu.Unlock()
+	t.post()
+}
+
+// post wakes the tray thread to apply updates.
+func (t *tray) post() {
+	procPostMessageW.Call(uintptr(t.hwnd), wmTrayUpdate, 0, 0)
+}
+
+func trayWndProc(hwnd, message, wParam, lParam uintptr) uintptr {
+	t := theTray
+	switch uint32(message) {
+	case wmTrayIcon:
+		switch uint32(lParam) & 0xffff {
+		case wmLButtonUp, wmRButtonUp, wmContextMenu:
+			t.showMenu()
+		}
+		return 0
+	case wmTrayUpdate:
+		t.update()
+		return 0
+	case wmClose:
+		procDestroyWindow.Call(hwnd)
+		return 0
+	case wmDestroy:
+		t.notifyIcon(nimDelete, nil)
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	if t != nil && t.taskbarCreated != 0 && uint32(message) == t.taskbarCreated {
+		t.notifyIcon(nimAdd, nil)
+		return 0
+	}
+	r, _, _ := procDefWindowProcW.Call(hwnd, message, wParam, lParam)
+	return r
+}
+
+// update refreshes the icon and shows queued notifications.
+func (t *tray) update() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	t.notifyIcon(nimModify, nil)
+	for i := range pending {
+		t.notifyIcon(nimModify, &pending[i])
+	}
+}
+
+// notifyIcon adds, updates or removes the icon, with n as a balloon.
+func (t *tray) notifyIcon(op uintptr, n *notification) bool {
+	t.mu.Lock()
+	st := t.status
+	t.mu.Unlock()
+
+	nid := notifyIconData{
+		Wnd:             t.hwnd,
+		ID:              1,
+		Flags:           nifMessage | nifIcon | nifTip,
+		CallbackMessage: wmTrayIcon,
+	}
+	nid.Size = uint32(unsafe.Sizeof(nid))
+	icon, _, _ := procLoadIconW.Call(0, uintptr(stateIcon(st.State)))
+	nid.Icon = windows.Handle(icon)
+	copyUTF16(nid.Tip[:], "FruitSalade: "+statusText(st))
+	if n != nil {
+		nid.Flags |= nifInfo
+		copyUTF16(nid.InfoTitle[:], n.title)
+		copyUTF16(nid.Info[:], n.text)
+		nid.InfoFlags = n.flags
+	}
+	r, _, _ := procShellNotifyIconW.Call(op, uintptr(unsafe.Pointer(&nid)))
+	return r != 0
+}
+
+// showMenu shows the context menu at the cursor and runs the chosen
+// command.
+func (t *tray) showMenu() {
+	t.mu.Lock()
+	st := t.status
+	t.mu.Unlock()
+
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+
+	appendMenu(menu, mfString|mfGrayed, 0, statusText(st))
+	if st.LastError != "" {
+		appendMenu(menu, mfString|mfGrayed, 0, "Last error: "+truncate(st.LastError, 80))
+	}
+	if st.DiskFull {
+		appendMenu(menu, mfString|mfGrayed, 0, "Disk almost full: not caching files")
+	}
+	appendMenu(menu, mfSeparator, 0, "")
+	if t.core.Paused() {
+		appendMenu(menu, mfString, cmdPause, "Resume sync")
+	} else {
+		appendMenu(menu, mfString, cmdPause, "Pause sync")
+	}
+	appendMenu(menu, mfString, cmdOpenFolder, "Open sync folder")
+	appendMenu(menu, mfString, cmdOpenWeb, "Open web app")
+
+	activity, _, _ := procCreatePopupMenu.Call()
+	recent := t.core.RecentActivity()
+	if len(recent) == 0 {
+		appendMenu(activity, mfString|mfGrayed, 0, "No recent activity")
+	}
+	for _, a := range recent {
+		appendMenu(activity, mfString|mfGrayed, 0,
+			fmt.Sprintf("%s  %s: %s", a.Time.Format("15:04"), truncate(a.Path, 60), a.Message))
+	}
+	appendMenu(menu, mfPopup, activity, "Recent activity")
+
+	appendMenu(menu, mfSeparator, 0, "")
+	appendMenu(menu, mfString, cmdQuit, "Quit")
+
+	var pt point
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+	// Without this the menu does not close when clicking elsewhere
+	procSetForegroundWindow.Call(uintptr(t.hwnd))
+	cmd, _, _ := procTrackPopupMenu.Call(menu, tpmRightButton|tpmReturnCmd|tpmNoNotify,
+		uintptr(pt.X), uintptr(pt.Y), 0, uintptr(t.hwnd), 0)
+
+	switch cmd {
+	case cmdPause:
+		if t.core.Paused() {
+			t.core.Resume()
+		} else {
+			t.core.Pause()
+		}
+	case cmdOpenFolder:
+		shellOpen(t.syncRoot)
+	case cmdOpenWeb:
+		shellOpen(t.webURL)
+	case cmdQuit:
+		t.notifyIcon(nimDelete, nil)
+		t.quit()
+	}
+}
+
+func appendMenu(menu uintptr, flags uint32, id uintptr, text string) {
+	var label uintptr
+	if text != "" {
+		label = uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(text)))
+	}
+	procAppendMenuW.Call(menu, uintptr(flags), id, label)
+}
+
+// shellOpen opens a folder or URL with its default handler.
+func shellOpen(target string) {
+	err := windows.ShellExecute(0, windows.StringToUTF16Ptr("open"),
+		windows.StringToUTF16Ptr(target), nil, nil, windows.SW_SHOWNORMAL)
+	if err != nil {
+		logger.Error("Failed to open %s: %v", target, err)
+	}
+}
+
+// stateIcon picks a stock icon for a sync state.
+func stateIcon(state string) uintptr {
+	switch state {
+	case winclient.StateAuthFailed:
+		return idiError
+	case winclient.StateOffline, winclient.StatePaused:
+		return idiWarning
+	default:
+		return idiApplication
+	}
+}
+
+// statusText describes the status for the tooltip and menu.
+func statusText(st winclient.SyncStatus) string {
+	switch st.State {
+	case winclient.StateAuthFailed:
+		return "Sign-in required"
+	case winclient.StatePaused:
+		return "Sync paused"
+	case winclient.StateOffline:
+		return "Offline"
+	case winclient.StateSyncing:
+		return fmt.Sprintf("Syncing (%d pending)", st.Pending)
+	default:
+		return "Up to date"
+	}
+}
+
+// copyUTF16 copies s into the fixed-size, NUL-terminated buffer dst,
+// truncating it if needed.
+func copyUTF16(dst []uint16, s string) {
+	u := windows.StringToUTF16(s)
+	if len(u) > len(dst) {
+		u = u[:len(dst)]
+		u[len(u)-1] = 0
+	}
+	copy(dst, u)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
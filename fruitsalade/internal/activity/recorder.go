@@ -0,0 +1,169 @@
+// Package activity records user actions to the activity log.
+package activity
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// Actions recorded besides the file event types (create, modify, delete, ...).
+const (
+	ActionLogin            = "login"
+	ActionLoginFailed      = "login_failed"
+	ActionLoginLockout     = "login_lockout"
+	ActionMove             = "move"
+	ActionCopy             = "copy"
+	ActionShareCreate      = "share_create"
+	ActionShareRevoke      = "share_revoke"
+	ActionShareUpload      = "share_upload"
+	ActionPermissionSet    = "permission_set"
+	ActionPermissionRemove = "permission_remove"
+	ActionPermissionCopy   = "permission_copy"
+	ActionImpersonate      = "impersonate"
+	ActionMaintenance      = "maintenance"
+	ActionConflictResolve  = "conflict_resolve"
+)
+
+const (
+	batchSize     = 100
+	flushInterval = 2 * time.Second
+)
+
+// Entry is a single action to record.
+type Entry struct {
+	UserID    int
+	Username  string
+	Action    string
+	Path      string
+	Details   map[string]interface{}
+	RequestID string // X-Request-ID of the API request, if any
+
+	// The admin who acted as UserID through an impersonation token
+	ImpersonatorID   int
+	ImpersonatorName string
+}
+
+// Store persists batches of activity entries. Implemented by *postgres.Store.
+type Store interface {
+	InsertActivity(ctx context.Context, entries []postgres.ActivityEntry) error
+}
+
+// Recorder writes activity entries asynchronously in batches. Record never
+// blocks: when the buffer is full the entry is dropped and counted.
+// A nil *Recorder discards everything.
+type Recorder struct {
+	store Store
+	queue chan postgres.ActivityEntry
+	stop  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// NewRecorder creates a recorder buffering up to bufferSize entries.
+func NewRecorder(store Store, bufferSize int) *Recorder {
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	return &Recorder{
+		store: store,
+		queue: make(chan postgres.ActivityEntry, bufferSize),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Start launches the writer goroutine.
+func (r *Recorder) Start() {
+	r.wg.Add(1)
+	go r.run()
+	logging.Info("activity recorder started", zap.Int("buffer", cap(r.queue)))
+}
+
+// Stop flushes buffered entries and stops the writer.
+func (r *Recorder) Stop() {
+	r.once.Do(func() { close(r.stop) })
+	r.wg.Wait()
+	logging.Info("activity recorder stopped")
+}
+
+// Record queues an entry for writing.
+func (r *Recorder) Record(e Entry) {
+	if r == nil {
+		return
+	}
+	details := "{}"
+	if len(e.Details) > 0 {
+		if b, err := json.Marshal(e.Details); err == nil {
+			details = string(b)
+		}
+	}
+	entry := postgres.ActivityEntry{
+		UserID:       e.UserID,
+		Username:     e.Username,
+		Action:       e.Action,
+		ResourcePath: e.Path,
+		Details:      details,
+		RequestID:    e.RequestID,
+		CreatedAt:    time.Now(),
+
+		ImpersonatorID: e.ImpersonatorID,
+		Impersonator:   e.ImpersonatorName,
+	}
+	select {
+	case r.queue <- entry:
+	default:
+		metrics.RecordActivityDropped()
+	}
+}
+
+func (r *Recorder) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]postgres.ActivityEntry, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := r.store.InsertActivity(ctx, batch); err != nil {
+			logging.Warn("failed to write activity log", zap.Int("entries", len(batch)), zap.Error(err))
+		}
+		cancel()
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e := <-r.queue:
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-r.stop:
+			// Drain what is already buffered
+			for {
+				select {
+				case e := <-r.queue:
+					batch = append(batch, e)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package activity
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+)
+
+type fakeStore struct {
+	mu      sync.Mutex
+	batches [][]postgres.ActivityEntry
+}
+
+func (f *fakeStore) InsertActivity(ctx context.Context, entries []postgres.ActivityEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, append([]postgres.ActivityEntry(nil), entries...))
+	return nil
+}
+
+func (f *fakeStore) entries() []postgres.ActivityEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var all []postgres.ActivityEntry
+	for _, b := range f.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func TestRecorderFlushesOnStop(t *testing.T) {
+	store := &fakeStore{}
+	r := NewRecorder(store, 10)
+	r.Start()
+
+	r.Record(Entry{UserID: 1, Username: "alice", Action: ActionLogin})
+	r.Record(Entry{UserID: 1, Username: "alice", Action: ActionMove, Path: "/b.txt",
+		Details: map[string]interface{}{"from": "/a.txt"}})
+	r.Stop()
+
+	got := store.entries()
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Action != ActionLogin || got[0].Details != "{}" {
+		t.Errorf("first entry = %+v", got[0])
+	}
+	var details map[string]string
+	if err := json.Unmarshal([]byte(got[1].Details), &details); err != nil || details["from"] != "/a.txt" {
+		t.Errorf("details = %q", got[1].Details)
+	}
+	if got[1].ResourcePath != "/b.txt" || got[1].CreatedAt.IsZero() {
+		t.Errorf("second entry = %+v", got[1])
+	}
+}
+
+func TestRecorderBatches(t *testing.T) {
+	store := &fakeStore{}
+	r := NewRecorder(store, 2*batchSize)
+	for i := 0; i < batchSize+5; i++ {
+		r.Record(Entry{UserID: i, Action: ActionLogin})
+	}
+	r.Start()
+	r.Stop()
+
+	if len(store.batches) != 2 {
+		t.Fatalf("got %d batches, want 2", len(store.batches))
+	}
+	if len(store.batches[0]) != batchSize || len(store.batches[1]) != 5 {
+		t.Errorf("batch sizes = %d, %d", len(store.batches[0]), len(store.batches[1]))
+	}
+}
+
+func TestRecorderDropsWhenFull(t *testing.T) {
+	store := &fakeStore{}
+	r := NewRecorder(store, 2)
+
+	// Not started: nothing drains the buffer
+	for i := 0; i < 5; i++ {
+		r.Record(Entry{UserID: i, Action: ActionLogin})
+	}
+	r.Start()
+	r.Stop()
+
+	if got := len(store.entries()); got != 2 {
+		t.Errorf("got %d entries, want 2 (rest dropped)", got)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Record(Entry{Action: ActionLogin}) // must not panic
+}
+
+func TestRecorderKeepsImpersonator(t *testing.T) {
+	store := &fakeStore{}
+	r := NewRecorder(store, 10)
+	r.Start()
+	r.Record(Entry{UserID: 2, Username: "bob", Action: ActionMove, Path: "/x",
+		ImpersonatorID: 1, ImpersonatorName: "admin"})
+	r.Stop()
+
+	got := store.entries()
+	if len(got) != 1 || got[0].ImpersonatorID != 1 || got[0].Impersonator != "admin" || got[0].UserID != 2 {
+		t.Errorf("entries = %+v", got)
+	}
+}
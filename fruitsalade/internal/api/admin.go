@@ -2,16 +2,24 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/config"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
 )
 
 // requireAdmin checks that the request is from an admin user.
@@ -62,6 +70,10 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.auth.CreateUser(r.Context(), req.Username, req.Password, req.IsAdmin); err != nil {
+		if errors.Is(err, auth.ErrWeakPassword) {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		s.sendError(w, http.StatusInternalServerError, "failed to create user: "+err.Error())
 		return
 	}
@@ -93,16 +105,131 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.auth.DeleteUser(r.Context(), userID); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to delete user: "+err.Error())
+	opts, err := userDeleteOptions(r)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"user_id": userID,
-		"deleted": true,
+	resp, err := s.auth.DeleteUser(r.Context(), userID, auth.UserDeletion{
+		TransferTo:   opts.TransferTo,
+		OrphanAction: opts.OrphanAction,
+		ActorID:      claims.UserID,
 	})
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			s.sendError(w, http.StatusNotFound, "user not found")
+		case errors.Is(err, auth.ErrLastAdmin):
+			s.sendError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, auth.ErrDeletionOption), errors.Is(err, auth.ErrTransferTarget):
+			s.sendError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.sendError(w, http.StatusInternalServerError, "failed to delete user: "+err.Error())
+		}
+		return
+	}
+	// Owners changed, and private files may have gone to the trash
+	s.RefreshTree(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// userDeleteOptions reads the options of DELETE /api/v1/admin/users/{userID}
+// from the query string or, without any there, the JSON body.
+func userDeleteOptions(r *http.Request) (protocol.UserDeleteRequest, error) {
+	var req protocol.UserDeleteRequest
+	q := r.URL.Query()
+	if q.Has("transfer_to") || q.Has("orphan_action") {
+		if v := q.Get("transfer_to"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return req, errors.New("invalid transfer_to")
+			}
+			req.TransferTo = id
+		}
+		req.OrphanAction = q.Get("orphan_action")
+		return req, nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return req, errors.New("invalid request body")
+	}
+	return req, nil
+}
+
+// handleUserFootprint reports what deleting a user affects, so that the
+// admin can choose between transfer_to and an orphan_action.
+func (s *Server) handleUserFootprint(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	fp, err := s.auth.UserFootprint(r.Context(), userID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		s.sendError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get footprint: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fp)
+}
+
+// handleUpdateUser applies a partial update to a user: admin flag,
+// disabled state, email and display name.
+func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	var req auth.UserUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if userID == claims.UserID && req.Disabled != nil && *req.Disabled {
+		s.sendError(w, http.StatusBadRequest, "cannot disable yourself")
+		return
+	}
+
+	user, err := s.auth.UpdateUser(r.Context(), userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			s.sendError(w, http.StatusNotFound, "user not found")
+		case errors.Is(err, auth.ErrInvalidEmail):
+			s.sendError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, auth.ErrLastAdmin):
+			s.sendError(w, http.StatusConflict, err.Error())
+		default:
+			s.sendError(w, http.StatusInternalServerError, "failed to update user: "+err.Error())
+		}
+		return
+	}
+
+	logging.Info("admin updated user",
+		zap.Int("user_id", userID),
+		zap.String("by", claims.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
 }
 
 func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
@@ -129,7 +256,14 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.auth.ChangePassword(r.Context(), userID, req.Password); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to change password: "+err.Error())
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			s.sendError(w, http.StatusNotFound, "user not found")
+		case errors.Is(err, auth.ErrWeakPassword):
+			s.sendError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.sendError(w, http.StatusInternalServerError, "failed to change password: "+err.Error())
+		}
 		return
 	}
 
@@ -140,6 +274,57 @@ func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleForcePasswordChange makes a user change their password before
+// they can do anything else.
+func (s *Server) handleForcePasswordChange(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	if err := s.auth.ForcePasswordChange(r.Context(), userID); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			s.sendError(w, http.StatusNotFound, "user not found or has no local password")
+			return
+		}
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":              userID,
+		"must_change_password": true,
+	})
+}
+
+// handleForcePasswordChangeAll forces a password change on every user with
+// a local password, including the calling admin.
+func (s *Server) handleForcePasswordChangeAll(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+
+	n, err := s.auth.ForcePasswordChangeAll(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logging.Info("admin forced password rotation for all users",
+		zap.String("by", claims.Username), zap.Int64("users", n))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": n,
+	})
+}
+
 // ─── Admin: User Groups ─────────────────────────────────────────────────────
 
 func (s *Server) handleUserGroups(w http.ResponseWriter, r *http.Request) {
@@ -181,11 +366,91 @@ func (s *Server) handleListShareLinks(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusInternalServerError, "failed to list share links: "+err.Error())
 		return
 	}
+	policy := s.shareLinks.LifetimePolicy()
+	for i := range links {
+		links[i].ExceedsPolicy = links[i].IsActive && policy.Exceeds(links[i].CreatedAt, links[i].ExpiresAt)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(links)
 }
 
+// shareLifetimePolicy returns the share link lifetime policy of settings.
+func shareLifetimePolicy(st config.Settings) sharing.LifetimePolicy {
+	return sharing.LifetimePolicy{
+		Max:     time.Duration(st.ShareMaxLifetimeSec) * time.Second,
+		Default: time.Duration(st.ShareDefaultLifetimeSec) * time.Second,
+	}
+}
+
+// handleShareLinkPolicy sets the max share link lifetime (the
+// share_max_lifetime_sec setting) and shortens the existing links that
+// outlive it to expire that long after their creation. With dry_run,
+// nothing changes and the response tells how many links would be
+// shortened. A max of 0 lifts the limit; shortened links stay so.
+func (s *Server) handleShareLinkPolicy(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+
+	var req struct {
+		MaxLifetimeSec *int64 `json:"max_lifetime_sec"`
+		DryRun         bool   `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.MaxLifetimeSec == nil {
+		s.sendError(w, http.StatusBadRequest, "max_lifetime_sec required")
+		return
+	}
+	next := s.settings.Get()
+	next.ShareMaxLifetimeSec = *req.MaxLifetimeSec
+	if err := next.Validate(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !req.DryRun {
+		value, _ := json.Marshal(*req.MaxLifetimeSec)
+		var saveErr error
+		_, err := s.settings.Update(map[string]json.RawMessage{"share_max_lifetime_sec": value}, func(values map[string]json.RawMessage) error {
+			saveErr = s.metadata.SaveServerSettings(r.Context(), values, claims.UserID)
+			return saveErr
+		})
+		if saveErr != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to save settings: "+saveErr.Error())
+			return
+		}
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	count, err := s.shareLinks.ApplyMaxLifetime(r.Context(), time.Duration(*req.MaxLifetimeSec)*time.Second, req.DryRun)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to apply share link policy: "+err.Error())
+		return
+	}
+
+	if !req.DryRun {
+		logging.Info("share link policy changed",
+			zap.Int64("max_lifetime_sec", *req.MaxLifetimeSec),
+			zap.Int64("shortened", count),
+			zap.String("admin", claims.Username))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"max_lifetime_sec": *req.MaxLifetimeSec,
+		"dry_run":          req.DryRun,
+		"shortened":        count,
+	})
+}
+
 // ─── Admin: Dashboard Stats ─────────────────────────────────────────────────
 
 func (s *Server) handleDashboardStats(w http.ResponseWriter, r *http.Request) {
@@ -224,8 +489,185 @@ func (s *Server) handleDashboardStats(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleStatsHistory returns the daily usage snapshots of the last ?days=
+// days (default 90), oldest first.
+func (s *Server) handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	days := 90
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 3650 {
+			s.sendError(w, http.StatusBadRequest, "days must be between 1 and 3650")
+			return
+		}
+		days = n
+	}
+
+	history, err := s.metadata.UsageHistory(r.Context(), days)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to load usage history")
+		return
+	}
+	if history == nil {
+		history = []postgres.UsageSnapshot{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"days":      days,
+		"snapshots": history,
+	})
+}
+
+// ─── Admin: Login Lockouts ──────────────────────────────────────────────────
+
+// handleListLockouts lists the usernames and IPs with failed logins,
+// locked out ones first. ?locked=true lists only those.
+func (s *Server) handleListLockouts(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	lockouts := s.auth.Lockouts().List()
+	if r.URL.Query().Get("locked") == "true" {
+		locked := lockouts[:0]
+		for _, l := range lockouts {
+			if !l.LockedUntil.IsZero() {
+				locked = append(locked, l)
+			}
+		}
+		lockouts = locked
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockouts)
+}
+
+// handleClearLockout clears the failures of a username or IP so it can log
+// in again at once.
+func (s *Server) handleClearLockout(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+
+	kind, key := r.PathValue("kind"), r.PathValue("key")
+	if kind != auth.LockoutUser && kind != auth.LockoutIP {
+		s.sendError(w, http.StatusBadRequest, "kind must be 'user' or 'ip'")
+		return
+	}
+	if !s.auth.Lockouts().Clear(kind, key) {
+		s.sendError(w, http.StatusNotFound, "no failed logins recorded for "+kind+" "+key)
+		return
+	}
+	logging.Info("admin cleared login lockout",
+		zap.String("kind", kind),
+		zap.String("key", key),
+		zap.String("by", claims.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":    kind,
+		"key":     key,
+		"cleared": true,
+	})
+}
+
+// ─── Admin: Rate Limits ─────────────────────────────────────────────────────
+
+// throttledUser is one row of GET /api/v1/admin/ratelimit/top.
+type throttledUser struct {
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username,omitempty"`
+	Throttled int    `json:"throttled"`
+}
+
+// handleRateLimitTop lists the users with the most rate-limited requests
+// in the last hour. ?n= sets how many (default 10, max 100).
+func (s *Server) handleRateLimitTop(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	n := 10
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		v, err := strconv.Atoi(nStr)
+		if err != nil || v <= 0 || v > 100 {
+			s.sendError(w, http.StatusBadRequest, "n must be between 1 and 100")
+			return
+		}
+		n = v
+	}
+
+	top := s.rateLimiter.TopThrottled(n)
+	names := make(map[int]string)
+	if len(top) > 0 {
+		if users, err := s.auth.ListUsers(r.Context()); err == nil {
+			for _, u := range users {
+				names[u.ID] = u.Username
+			}
+		}
+	}
+
+	result := make([]throttledUser, 0, len(top))
+	for _, t := range top {
+		result = append(result, throttledUser{
+			UserID:    t.UserID,
+			Username:  names[t.UserID],
+			Throttled: t.Throttled,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window_seconds": int(quota.ThrottleWindow.Seconds()),
+		"users":          result,
+	})
+}
+
 // ─── Token Management (user-facing, not admin-only) ─────────────────────────
 
+// handleChangeOwnPassword lets a user rotate their password. It is the one
+// endpoint (besides logout) open to users who must change their password.
+func (s *Server) handleChangeOwnPassword(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if claims.APIKeyID != 0 {
+		s.sendError(w, http.StatusForbidden, "API keys cannot change passwords")
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.auth.ChangeOwnPassword(r.Context(), claims.UserID, req.CurrentPassword, req.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrWrongPassword):
+			s.sendError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, auth.ErrWeakPassword):
+			s.sendError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.sendError(w, http.StatusInternalServerError, "failed to change password: "+err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"changed": true})
+}
+
 func (s *Server) handleRevokeCurrentToken(w http.ResponseWriter, r *http.Request) {
 	claims := auth.GetClaims(r.Context())
 	if claims == nil {
@@ -267,6 +709,43 @@ func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(sessions)
 }
 
+// handleListAllSessions lists active sessions of all users with the client
+// version each device last connected with. ?outdated=true keeps only
+// FruitSalade clients older than the configured minimum client version.
+func (s *Server) handleListAllSessions(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	sessions, err := s.auth.ListAllSessions(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list sessions: "+err.Error())
+		return
+	}
+
+	minVersion := s.settings.Get().MinClientVersion
+	if r.URL.Query().Get("outdated") == "true" {
+		filtered := sessions[:0]
+		for _, sess := range sessions {
+			_, v, ok := strings.Cut(sess.ClientVersion, "/")
+			if ok && !version.AtLeast(v, minVersion) {
+				filtered = append(filtered, sess)
+			}
+		}
+		sessions = filtered
+	}
+	if sessions == nil {
+		sessions = []auth.DeviceToken{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions":           sessions,
+		"min_client_version": minVersion,
+		"server_version":     version.Version,
+	})
+}
+
 func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
 	claims := auth.GetClaims(r.Context())
 	if claims == nil {
@@ -284,6 +763,7 @@ func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusInternalServerError, "failed to revoke session: "+err.Error())
 		return
 	}
+	s.endSessionStreams(tokenID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"token_id": tokenID, "revoked": true})
@@ -352,71 +832,142 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 			"enabled":   cfg.TLSCertFile != "" && cfg.TLSKeyFile != "",
 			"cert_file": cfg.TLSCertFile,
 		},
-		"runtime": map[string]interface{}{
-			"log_level":             cfg.LogLevel,
-			"max_upload_size":       cfg.MaxUploadSize,
-			"default_max_storage":   cfg.DefaultMaxStorage,
-			"default_max_bandwidth": cfg.DefaultMaxBandwidth,
-			"default_requests_per_min": cfg.DefaultRequestsPerMin,
-		},
+		"runtime":          s.settings.Get(),
+		"hot_reload":       config.SettingKeys(),
+		"restart_required": config.RestartRequired,
+		"overridden":       s.settings.Overridden(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleUpdateConfig changes runtime settings. The body maps setting keys
+// (see the "runtime" section of GET) to new values; null reverts one to
+// its environment value. Changes are saved and take effect immediately.
 func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
-	if s.requireAdmin(w, r) == nil {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
 		return
 	}
 
-	if s.config == nil {
-		s.sendError(w, http.StatusInternalServerError, "configuration not available")
+	var req map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	var req map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.sendError(w, http.StatusBadRequest, "invalid request body")
+	var saveErr error
+	settings, err := s.settings.Update(req, func(values map[string]json.RawMessage) error {
+		saveErr = s.metadata.SaveServerSettings(r.Context(), values, claims.UserID)
+		return saveErr
+	})
+	if saveErr != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to save settings: "+saveErr.Error())
+		return
+	}
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	cfg := s.config
+	keys := make([]string, 0, len(req))
+	for k := range req {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	logging.Info("settings changed", zap.Strings("keys", keys), zap.String("admin", claims.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated":    true,
+		"runtime":    settings,
+		"overridden": s.settings.Overridden(),
+	})
+}
+
+// ─── Admin: Version Retention ───────────────────────────────────────────────
+
+func (s *Server) handleListRetentionOverrides(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
 
-	if v, ok := req["log_level"].(string); ok {
-		cfg.LogLevel = v
-		logging.SetLevel(v)
-		logging.Info("log level changed", zap.String("level", v))
+	overrides, err := s.metadata.ListRetentionOverrides(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list retention overrides: "+err.Error())
+		return
+	}
+	if overrides == nil {
+		overrides = []postgres.RetentionOverride{}
 	}
 
-	if v, ok := req["max_upload_size"].(float64); ok {
-		cfg.MaxUploadSize = int64(v)
-		s.maxUploadSize = int64(v)
-		logging.Info("max upload size changed", zap.Int64("size", int64(v)))
+	settings := s.settings.Get()
+	defaults := postgres.RetentionPolicy{
+		KeepCount:  settings.VersionKeepCount,
+		MaxAgeDays: settings.VersionMaxAgeDays,
 	}
 
-	if v, ok := req["default_max_storage"].(float64); ok {
-		cfg.DefaultMaxStorage = int64(v)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"defaults":  defaults,
+		"overrides": overrides,
+	})
+}
+
+func (s *Server) handleSetRetentionOverride(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
 	}
 
-	if v, ok := req["default_max_bandwidth"].(float64); ok {
-		cfg.DefaultMaxBandwidth = int64(v)
+	path := "/" + r.PathValue("path")
+
+	var req struct {
+		KeepCount  *int `json:"keep_count"`
+		MaxAgeDays *int `json:"max_age_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if (req.KeepCount != nil && *req.KeepCount < 0) || (req.MaxAgeDays != nil && *req.MaxAgeDays < 0) {
+		s.sendError(w, http.StatusBadRequest, "retention values must be >= 0")
+		return
 	}
 
-	if v, ok := req["default_requests_per_min"].(float64); ok {
-		cfg.DefaultRequestsPerMin = int(v)
+	if err := s.metadata.SetRetentionOverride(r.Context(), path, req.KeepCount, req.MaxAgeDays); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to set retention override: "+err.Error())
+		return
 	}
 
+	logging.Info("version retention override set",
+		zap.String("path", path),
+		zap.String("by", claims.Username))
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"updated": true,
-		"runtime": map[string]interface{}{
-			"log_level":             cfg.LogLevel,
-			"max_upload_size":       cfg.MaxUploadSize,
-			"default_max_storage":   cfg.DefaultMaxStorage,
-			"default_max_bandwidth": cfg.DefaultMaxBandwidth,
-			"default_requests_per_min": cfg.DefaultRequestsPerMin,
-		},
+		"path":         path,
+		"keep_count":   req.KeepCount,
+		"max_age_days": req.MaxAgeDays,
+	})
+}
+
+func (s *Server) handleDeleteRetentionOverride(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	path := "/" + r.PathValue("path")
+	if err := s.metadata.DeleteRetentionOverride(r.Context(), path); err != nil {
+		s.sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":    path,
+		"deleted": true,
 	})
 }
 
@@ -436,6 +987,7 @@ func (s *Server) handleStorageDashboard(w http.ResponseWriter, r *http.Request)
 	byVisibility, _ := s.metadata.StorageByVisibility(ctx)
 	growth, _ := s.metadata.StorageGrowth(ctx, 90)
 	trashSize, trashCount, _ := s.metadata.TrashStats(ctx)
+	versionsSize, versionsCount, _ := s.metadata.VersionStats(ctx)
 
 	// Aggregate type breakdown by category
 	categoryMap := make(map[string][2]int64) // [size, count]
@@ -463,7 +1015,7 @@ func (s *Server) handleStorageDashboard(w http.ResponseWriter, r *http.Request)
 	var totalFiles int
 	if byUser != nil {
 		for _, u := range byUser {
-			totalSize += u.Size
+			totalSize += u.ContentBytes
 			totalFiles += u.Count
 		}
 	}
@@ -482,6 +1034,8 @@ func (s *Server) handleStorageDashboard(w http.ResponseWriter, r *http.Request)
 		"total_files":   totalFiles,
 		"trash_size":    trashSize,
 		"trash_count":   trashCount,
+		"versions_size":  versionsSize,
+		"versions_count": versionsCount,
 		"by_user":       byUser,
 		"by_group":      byGroup,
 		"by_category":   byCategory,
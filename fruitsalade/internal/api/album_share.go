@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Album Share Links ──────────────────────────────────────────────────────
+//
+// An album share link lets anyone holding it view a custom album: its
+// listing, and the thumbnails and content of its members, without logging
+// in. Only members of the album are reachable through the link. Album
+// links are share links with an album ID, so they are listed and revoked
+// through the share link endpoints.
+
+func (s *Server) handleCreateAlbumShare(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid album ID")
+		return
+	}
+
+	album, err := s.galleryStore.GetAlbum(r.Context(), id)
+	if err != nil || album == nil {
+		s.sendError(w, http.StatusNotFound, "album not found")
+		return
+	}
+	if album.UserID != claims.UserID && !claims.IsAdmin {
+		s.sendError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	var req protocol.AlbumShareRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.sendError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	if req.ExpiresInSec < 0 || req.MaxViews < 0 {
+		s.sendError(w, http.StatusBadRequest, "expires_in_sec and max_views must not be negative")
+		return
+	}
+
+	link, err := s.shareLinks.CreateForAlbum(r.Context(), id, claims.UserID, req.Password, req.ExpiresInSec, req.MaxViews)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to create share link: "+err.Error())
+		return
+	}
+
+	// Album links open the same web app landing page as file links
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	shareURL := fmt.Sprintf("%s://%s/app/#share/%s", scheme, r.Host, link.ID)
+	if req.Password != "" {
+		shareURL += "/" + req.Password
+	}
+
+	logging.Info("album share link created",
+		zap.Int("album_id", id),
+		zap.String("link_id", link.ID))
+	s.recordActivity(r.Context(), claims, activity.ActionShareCreate, "", map[string]interface{}{
+		"link_id":   link.ID,
+		"album_id":  id,
+		"album":     album.Name,
+		"password":  req.Password != "",
+		"max_views": req.MaxViews,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(protocol.AlbumShareResponse{
+		ID:        link.ID,
+		AlbumID:   id,
+		URL:       shareURL,
+		ExpiresAt: link.ExpiresAt,
+		MaxViews:  link.MaxDownloads,
+		CreatedAt: link.CreatedAt,
+	})
+}
+
+// handleSharedAlbum returns a shared album and its members. Each call
+// counts as a view of the link.
+func (s *Server) handleSharedAlbum(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	link, err := s.shareLinks.ValidateAlbum(r.Context(), token, r.URL.Query().Get("password"), true)
+	if err != nil {
+		s.sendError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	album, err := s.galleryStore.GetAlbum(r.Context(), *link.AlbumID)
+	if err != nil || album == nil {
+		s.sendError(w, http.StatusNotFound, "shared album not found")
+		return
+	}
+	items, err := s.galleryStore.ListAlbumItems(r.Context(), album.ID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list album: "+err.Error())
+		return
+	}
+
+	resp := protocol.SharedAlbumResponse{
+		Name:        album.Name,
+		Description: album.Description,
+		ExpiresAt:   link.ExpiresAt,
+		Items:       make([]protocol.SharedAlbumItem, 0, len(items)),
+	}
+	if owner, err := s.auth.GetUser(r.Context(), link.CreatedBy); err == nil {
+		resp.Owner = owner.Username
+		if owner.DisplayName != "" {
+			resp.Owner = owner.DisplayName
+		}
+	}
+	for _, it := range items {
+		resp.Items = append(resp.Items, protocol.SharedAlbumItem{
+			Path:         it.FilePath,
+			Name:         it.Name,
+			Size:         it.Size,
+			MediaType:    it.MediaType,
+			Width:        it.Width,
+			Height:       it.Height,
+			DateTaken:    it.DateTaken,
+			HasThumbnail: it.HasThumbnail,
+		})
+	}
+
+	s.shareLinks.IncrementDownloads(r.Context(), token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// sharedAlbumMember validates an album link for loading a member and
+// returns the member's path, or writes an error. Paths that are not
+// members of the album are reported as not found.
+func (s *Server) sharedAlbumMember(w http.ResponseWriter, r *http.Request) (*sharing.ShareLink, string, bool) {
+	link, err := s.shareLinks.ValidateAlbum(r.Context(), r.PathValue("token"), r.URL.Query().Get("password"), false)
+	if err != nil {
+		s.sendError(w, http.StatusForbidden, err.Error())
+		return nil, "", false
+	}
+
+	filePath := "/" + r.PathValue("path")
+	ok, err := s.galleryStore.AlbumContains(r.Context(), *link.AlbumID, filePath)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to check album: "+err.Error())
+		return nil, "", false
+	}
+	if !ok {
+		s.sendError(w, http.StatusNotFound, "not in shared album")
+		return nil, "", false
+	}
+	return link, filePath, true
+}
+
+func (s *Server) handleSharedAlbumThumb(w http.ResponseWriter, r *http.Request) {
+	if _, filePath, ok := s.sharedAlbumMember(w, r); ok {
+		s.serveGalleryThumb(w, r, filePath, galleryThumbCacheControl)
+	}
+}
+
+// handleSharedAlbumContent serves a member of a shared album. Photos and
+// videos are served inline; anything else is sent as a download so it
+// cannot run as a page on the server's origin.
+// Transfers count against the bandwidth of the link's creator.
+func (s *Server) handleSharedAlbumContent(w http.ResponseWriter, r *http.Request) {
+	link, filePath, ok := s.sharedAlbumMember(w, r)
+	if !ok {
+		return
+	}
+
+	fileRow, err := s.metadata.GetFileRow(r.Context(), filePath)
+	if err != nil || fileRow == nil {
+		s.sendError(w, http.StatusNotFound, "shared file not found")
+		return
+	}
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), fileRow.StorageLocID, fileRow.GroupID)
+	if err != nil {
+		s.sendStorageError(w, err)
+		return
+	}
+
+	creator, err := s.auth.GetUser(r.Context(), link.CreatedBy)
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, "shared file not found")
+		return
+	}
+	limit, ok := s.downloadAllowed(w, r, creator.ID, creator.IsAdmin, filePath, "share")
+	if !ok {
+		return
+	}
+
+	reader, size, err := backend.GetObject(r.Context(), fileRow.S3Key, 0, 0)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to retrieve content: "+err.Error())
+		return
+	}
+	defer reader.Close()
+
+	contentType, disposition := sharedAlbumContentType(filePath)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`%s; filename="%s"`, disposition, path.Base(filePath)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+
+	meter := s.quotaStore.NewMeter(r.Context(), w, creator.ID, limit)
+	n, err := io.Copy(meter, reader)
+	meter.Finish()
+	if meter.Exceeded() {
+		metrics.RecordDownloadDenied("share")
+	}
+	if err != nil {
+		logging.Warn("shared album transfer error", zap.String("path", filePath), zap.Error(err))
+	}
+	metrics.RecordContentDownload(n, err == nil)
+}
+
+// sharedAlbumContentType returns the Content-Type and disposition for a
+// shared album member. Albums only take photos and videos, but members
+// added before that was enforced may be anything.
+func sharedAlbumContentType(filePath string) (string, string) {
+	contentType := mime.TypeByExtension(path.Ext(filePath))
+	if gallery.IsMediaFile(filePath) &&
+		(strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/")) {
+		return contentType, "inline"
+	}
+	return "application/octet-stream", "attachment"
+}
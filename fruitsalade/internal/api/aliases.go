@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Aliases ────────────────────────────────────────────────────────────────
+//
+// POST /api/v1/alias gives a file or directory a second path. The tree
+// shows the target's files below the alias too (see postgres.CreateAlias),
+// and reads, uploads and permission checks at an alias path act on the
+// path it stands for. Deleting an alias removes only the alias. Moving,
+// copying or deleting what lies below an alias is refused; that is done
+// at the target. Since alias nodes hold copies of their targets, writes
+// that touch an alias or a target rebuild the whole tree.
+
+// errInsideAlias is returned for a path below an alias where only the
+// alias' target can be changed.
+var errInsideAlias = errors.New("path is inside an alias; change it at the alias target")
+
+func (s *Server) handleCreateAlias(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var req protocol.AliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Target == "" || req.Link == "" {
+		s.sendError(w, http.StatusBadRequest, "target and link required")
+		return
+	}
+	if err := validateNewPath(req.Link); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	link := path.Clean("/" + req.Link)
+	target := path.Clean("/" + req.Target)
+	if link == "/" || target == "/" {
+		s.sendError(w, http.StatusBadRequest, "cannot alias root or onto root")
+		return
+	}
+
+	// An alias of a path below an alias stands for what that path does
+	target, _ = s.resolveAlias(target)
+	if s.insideAlias(link) {
+		s.sendError(w, http.StatusBadRequest, errInsideAlias.Error())
+		return
+	}
+	if pathWithin(link, target) || s.aliasesWithin(target, link) {
+		s.sendError(w, http.StatusBadRequest, "alias would contain itself")
+		return
+	}
+
+	ctx := r.Context()
+	if !s.permissions.CheckAccess(ctx, claims.UserID, target, "read", claims.IsAdmin) ||
+		!s.permissions.CheckAccess(ctx, claims.UserID, link, "write", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "access denied")
+		return
+	}
+	root := s.currentTree()
+	if s.findNode(root, target) == nil {
+		s.sendError(w, http.StatusNotFound, "path not found: "+target)
+		return
+	}
+	if s.findNode(root, link) != nil {
+		s.sendError(w, http.StatusConflict, link+" already exists")
+		return
+	}
+
+	if err := s.ensureParentDirs(ctx, link); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to create parent dirs: "+err.Error())
+		return
+	}
+	created, err := s.metadata.CreateAlias(ctx, link, target, claims.UserID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to create alias: "+err.Error())
+		return
+	}
+	if !created {
+		s.sendError(w, http.StatusConflict, link+" already exists")
+		return
+	}
+	if err := s.RefreshTree(ctx); err != nil {
+		logging.Error("tree rebuild failed", zap.Error(err))
+	}
+
+	logging.Info("alias created", zap.String("link", link), zap.String("target", target))
+	s.publishEvent(ctx, events.EventCreate, link, 0, "", 0, claims.UserID, claims.Username)
+
+	node := s.findNode(s.currentTree(), link)
+	if node == nil {
+		s.sendError(w, http.StatusInternalServerError, "alias not in tree: "+link)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(copyNode(node))
+}
+
+// resolveAlias returns the path p stands for if it is an alias or lies
+// below one, and whether it does. p need not exist: a new file in an
+// alias directory belongs in the target directory.
+func (s *Server) resolveAlias(p string) (string, bool) {
+	p = path.Clean("/" + p)
+	node := s.currentTree()
+	if node == nil {
+		return p, false
+	}
+	rest := strings.Split(strings.Trim(p, "/"), "/")
+	for len(rest) > 0 && rest[0] != "" {
+		var next *models.FileNode
+		for _, child := range node.Children {
+			if child.Name == rest[0] {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+		rest = rest[1:]
+	}
+	if node.AliasTarget == "" {
+		return p, false
+	}
+	return path.Join(append([]string{node.AliasTarget}, rest...)...), true
+}
+
+// insideAlias reports whether p lies below an alias, as opposed to being
+// one or being outside of all.
+func (s *Server) insideAlias(p string) bool {
+	_, ok := s.resolveAlias(path.Dir(path.Clean("/" + p)))
+	return ok
+}
+
+// aliasesWithin reports whether an alias at or below dir has a target
+// that contains p, so that an alias at p of dir would contain itself.
+func (s *Server) aliasesWithin(dir, p string) bool {
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	for alias, target := range s.aliases {
+		if pathWithin(alias, dir) && pathWithin(p, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAliasMove refuses to move from to to when either lies below an
+// alias, or when an alias would end up below its own target.
+func (s *Server) checkAliasMove(from, to string) error {
+	if s.insideAlias(from) || s.insideAlias(to) {
+		return errInsideAlias
+	}
+	moved := func(p string) string {
+		if pathWithin(p, from) {
+			return to + strings.TrimPrefix(p, from)
+		}
+		return p
+	}
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	for alias, target := range s.aliases {
+		if pathWithin(moved(alias), moved(target)) {
+			return fmt.Errorf("moving %s to %s would put alias %s inside its target", from, to, alias)
+		}
+	}
+	return nil
+}
+
+// aliasesAffected reports whether a write to paths changes an alias or
+// the target of one, which a tree patch cannot carry over to the alias.
+func (s *Server) aliasesAffected(paths []string) bool {
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	for alias, target := range s.aliases {
+		for _, p := range paths {
+			p = path.Clean("/" + p)
+			if pathWithin(p, alias) || pathWithin(p, target) || pathWithin(alias, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// treeAliases returns the aliases in the tree at root, mapped to their
+// targets. The copies below aliases are not searched: an alias in a target
+// is found there.
+func treeAliases(root *models.FileNode) map[string]string {
+	aliases := make(map[string]string)
+	var walk func(n *models.FileNode)
+	walk = func(n *models.FileNode) {
+		for _, child := range n.Children {
+			if child.Alias {
+				aliases[child.Path] = child.AliasTarget
+			} else if child.IsDir {
+				walk(child)
+			}
+		}
+	}
+	if root != nil {
+		walk(root)
+	}
+	return aliases
+}
+
+// nodeSource returns the path whose row backs node: its target if it is
+// or lies below an alias.
+func nodeSource(node *models.FileNode) string {
+	if node.AliasTarget != "" {
+		return node.AliasTarget
+	}
+	return node.Path
+}
+
+// pathWithin reports whether p is dir or lies below it.
+func pathWithin(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// refreshForAliases rebuilds the tree if a write to paths affects an
+// alias, and reports whether it did.
+func (s *Server) refreshForAliases(ctx context.Context, paths []string) bool {
+	if !s.aliasesAffected(paths) {
+		return false
+	}
+	if err := s.RefreshTree(ctx); err != nil {
+		logging.Error("tree rebuild failed", zap.Error(err))
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// handleListAPIKeys handles GET /api/v1/auth/apikeys (protected).
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	keys, err := s.auth.ListAPIKeys(r.Context(), claims.UserID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list api keys: "+err.Error())
+		return
+	}
+	if keys == nil {
+		keys = []auth.APIKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handleCreateAPIKey handles POST /api/v1/auth/apikeys (protected).
+// The secret is only part of this response.
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if claims.APIKeyID != 0 {
+		s.sendError(w, http.StatusForbidden, "api keys cannot create api keys")
+		return
+	}
+
+	var req struct {
+		Name       string `json:"name"`
+		Scope      string `json:"scope"`
+		PathPrefix string `json:"path_prefix"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		s.sendError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if _, err := auth.NormalizeAPIKeyScope(req.Scope); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, secret, err := s.auth.CreateAPIKey(r.Context(), claims.UserID, strings.TrimSpace(req.Name), req.Scope, req.PathPrefix)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*auth.APIKey
+		Key string `json:"key"`
+	}{key, secret})
+}
+
+// handleDeleteAPIKey handles DELETE /api/v1/auth/apikeys/{keyID} (protected).
+func (s *Server) handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if claims.APIKeyID != 0 {
+		s.sendError(w, http.StatusForbidden, "api keys cannot revoke api keys")
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.PathValue("keyID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid key ID")
+		return
+	}
+
+	if err := s.auth.DeleteAPIKey(r.Context(), claims.UserID, keyID); err != nil {
+		code := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			code = http.StatusNotFound
+		}
+		s.sendError(w, code, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"key_id": keyID, "revoked": true})
+}
+
+// apiKeyPathlessRoutes are the routes without a file path that a
+// path-restricted API key may use. The tree is pruned to the key's prefix.
+var apiKeyPathlessRoutes = map[string]bool{
+	"GET /api/v1/tree":   true,
+	"GET /api/v1/events": true,
+	"GET /api/v1/usage":  true,
+}
+
+// apiKeyScope enforces the restrictions of API keys on the protected routes:
+// read-only keys may only use safe methods, and keys with a path prefix may
+// only reach files below it. JWT sessions pass through unchanged.
+func (s *Server) apiKeyScope(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := auth.GetClaims(r.Context())
+		if claims == nil || claims.APIKeyID == 0 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		if claims.ReadOnly() && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			s.sendError(w, http.StatusForbidden, "api key is read-only")
+			return
+		}
+
+		if claims.PathPrefix != "" {
+			_, pattern := mux.Handler(r)
+			if !apiKeyAllowsRoute(claims, pattern, r.URL.Path) {
+				s.sendError(w, http.StatusForbidden, "api key is restricted to "+claims.PathPrefix)
+				return
+			}
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyAllowsRoute checks a path-restricted key against the matched route
+// pattern. For "{path...}" routes the file path is the rest of the URL.
+func apiKeyAllowsRoute(claims *auth.Claims, pattern, urlPath string) bool {
+	if apiKeyPathlessRoutes[pattern] {
+		return true
+	}
+	i := strings.Index(pattern, "{path...}")
+	if i < 0 {
+		return false
+	}
+	literal := pattern[strings.Index(pattern, " ")+1 : i]
+	filePath := "/" + strings.TrimPrefix(urlPath, literal)
+	if claims.AllowsPath(filePath) {
+		return true
+	}
+	// Subtree listings of parent directories are pruned like the full tree
+	return strings.HasPrefix(pattern, "GET /api/v1/tree/") && isAncestorPath(filePath, claims.PathPrefix)
+}
+
+// isAncestorPath reports whether dir is a parent directory of p.
+func isAncestorPath(dir, p string) bool {
+	dir = strings.TrimSuffix(dir, "/")
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// pruneToPrefix returns the part of the tree on the way to and below prefix.
+func pruneToPrefix(node *models.FileNode, prefix string) *models.FileNode {
+	if node == nil {
+		return nil
+	}
+	if node.Path == prefix || strings.HasPrefix(node.Path, prefix+"/") {
+		return node
+	}
+	if !node.IsDir || !isAncestorPath(node.Path, prefix) {
+		return nil
+	}
+	pruned := copyNode(node)
+	for _, child := range node.Children {
+		if c := pruneToPrefix(child, prefix); c != nil {
+			pruned.Children = append(pruned.Children, c)
+		}
+	}
+	pruned.SetChildCount(len(pruned.Children))
+	fstree.SumChildren(pruned)
+	return pruned
+}
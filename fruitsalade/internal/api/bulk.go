@@ -0,0 +1,632 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// ─── Bulk Move & Copy ───────────────────────────────────────────────────────
+//
+// POST /api/v1/bulk/move and /api/v1/bulk/copy move or copy paths into one
+// destination directory. Every item is checked before anything changes:
+// access, that it exists, that a directory does not go into itself, and
+// what is in the way at its target, including the targets of the items
+// before it. on_conflict decides about taken names: "skip" leaves the item
+// alone, "rename" picks the first free "name (n).ext", and "overwrite"
+// replaces a file, which is kept as a version, or an empty directory;
+// without a policy the item fails. The items then run one by one, each in
+// its own transaction, and a failed one does not stop the rest. With
+// atomic, one failed check fails the request and the items run in one
+// transaction. The tree is refreshed once at the end.
+
+// bulkItem is one path of a bulk move or copy.
+type bulkItem struct {
+	res      protocol.BulkItemResult
+	node     *models.FileNode  // the source
+	row      *postgres.FileRow // move: the source row
+	replaced *postgres.FileRow // what the item replaces at its target
+	unmoved  bool              // move: already in the destination
+
+	// copy: the rows to create, directories before their contents, and
+	// the content to copy to their keys
+	copies  []postgres.PathCopy
+	objects []bulkObject
+	files   int
+	dirs    int
+	bytes   int64
+}
+
+// bulkObject is the content of a copied file and the key of the copy.
+type bulkObject struct {
+	row *postgres.FileRow
+	key string
+}
+
+func (it *bulkItem) fail(code protocol.ErrorCode, msg string) {
+	it.res.Status = protocol.BulkStatusFailed
+	it.res.To = ""
+	it.res.Code = code
+	it.res.Error = msg
+}
+
+// pending reports whether the item passed its checks and still has to run.
+func (it *bulkItem) pending() bool {
+	return it.res.Status == ""
+}
+
+// bulkPlan is what the checks of one bulk request share.
+type bulkPlan struct {
+	claims  *auth.Claims
+	dest    string
+	policy  string
+	copying bool
+	root    *models.FileNode // the tree, only what the user can see for a copy
+	sources map[string]bool
+	targets map[string]bool // the targets of the items checked so far
+	bytes   int64           // copy: the content of the items checked so far
+}
+
+func (s *Server) handleBulkMove(w http.ResponseWriter, r *http.Request) {
+	s.handleBulkTransfer(w, r, false)
+}
+
+func (s *Server) handleBulkCopy(w http.ResponseWriter, r *http.Request) {
+	s.handleBulkTransfer(w, r, true)
+}
+
+func (s *Server) handleBulkTransfer(w http.ResponseWriter, r *http.Request, copying bool) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	// A BulkCopyRequest has the same fields
+	var req protocol.BulkMoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Paths) == 0 || req.Destination == "" {
+		s.sendError(w, http.StatusBadRequest, "paths and destination required")
+		return
+	}
+	switch req.OnConflict {
+	case "", protocol.OnConflictSkip, protocol.OnConflictRename, protocol.OnConflictOverwrite:
+	default:
+		s.sendError(w, http.StatusBadRequest, "on_conflict must be skip, rename or overwrite")
+		return
+	}
+	if err := validateNewPath(req.Destination); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	ctx := r.Context()
+	dest := path.Clean("/" + req.Destination)
+	if node := s.findNode(s.currentTree(), dest); node != nil && !node.IsDir {
+		s.sendError(w, http.StatusConflict, "destination is a file: "+dest)
+		return
+	}
+
+	plan := &bulkPlan{
+		claims:  claims,
+		dest:    dest,
+		policy:  req.OnConflict,
+		copying: copying,
+		root:    s.currentTree(),
+		sources: make(map[string]bool, len(req.Paths)),
+		targets: make(map[string]bool, len(req.Paths)),
+	}
+	if copying {
+		plan.root = s.filterTree(ctx, plan.root, claims, -1)
+	}
+	for _, p := range req.Paths {
+		plan.sources[path.Clean("/"+p)] = true
+	}
+	items := make([]*bulkItem, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		it := &bulkItem{res: protocol.BulkItemResult{From: p}}
+		s.checkBulkItem(ctx, plan, it)
+		items = append(items, it)
+	}
+
+	var resp protocol.BulkMoveResponse
+	aborted := false
+	for _, it := range items {
+		aborted = aborted || req.Atomic && it.res.Status == protocol.BulkStatusFailed
+	}
+	var run []*bulkItem
+	for _, it := range items {
+		switch {
+		case aborted && it.res.Status != protocol.BulkStatusFailed:
+			it.res = protocol.BulkItemResult{From: it.res.From, Status: protocol.BulkStatusAborted}
+		case it.pending():
+			run = append(run, it)
+		}
+	}
+
+	if len(run) > 0 {
+		if err := s.ensureParentDirs(ctx, dest+"/placeholder"); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to create destination: "+err.Error())
+			return
+		}
+		groups := make([][]*bulkItem, 0, len(run))
+		if req.Atomic {
+			groups = append(groups, run)
+		} else {
+			for _, it := range run {
+				groups = append(groups, []*bulkItem{it})
+			}
+		}
+		for _, group := range groups {
+			var err error
+			if copying {
+				err = s.copyBulk(ctx, claims, group)
+			} else {
+				err = s.moveBulk(ctx, claims, group)
+			}
+			if err != nil && req.Atomic {
+				resp.RolledBack = true
+			}
+		}
+		s.RefreshTree(ctx)
+	}
+
+	resp.Results = make([]protocol.BulkItemResult, 0, len(items))
+	for _, it := range items {
+		switch it.res.Status {
+		case protocol.BulkStatusMoved, protocol.BulkStatusCopied:
+			resp.Succeeded++
+		case protocol.BulkStatusSkipped:
+			resp.Skipped++
+		default:
+			resp.Failed++
+			if it.res.Error != "" {
+				resp.Errors = append(resp.Errors, it.res.From+": "+it.res.Error)
+			}
+		}
+		resp.Results = append(resp.Results, it.res)
+	}
+	logging.Info("bulk transfer",
+		zap.Bool("copy", copying), zap.String("destination", dest), zap.Bool("atomic", req.Atomic),
+		zap.Int("succeeded", resp.Succeeded), zap.Int("skipped", resp.Skipped), zap.Int("failed", resp.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkBulkItem checks one item of plan and settles its target, or fails
+// or skips it.
+func (s *Server) checkBulkItem(ctx context.Context, plan *bulkPlan, it *bulkItem) {
+	from := path.Clean("/" + it.res.From)
+	it.res.From = from
+	verb := "move"
+	if plan.copying {
+		verb = "copy"
+	}
+	if from == "/" {
+		it.fail(protocol.ErrCodeBadRequest, "cannot "+verb+" root")
+		return
+	}
+	if pathWithin(plan.dest, from) {
+		it.fail(protocol.ErrCodeBadRequest, "cannot "+verb+" a directory into itself")
+		return
+	}
+	if !plan.copying {
+		for dir := path.Dir(from); dir != "/"; dir = path.Dir(dir) {
+			if plan.sources[dir] {
+				it.fail(protocol.ErrCodeConflict, "moved with "+dir)
+				return
+			}
+		}
+	}
+
+	claims := plan.claims
+	if plan.copying {
+		if source, _ := s.resolveAlias(from); !s.permissions.CheckAccess(ctx, claims.UserID, source, "read", claims.IsAdmin) {
+			it.fail(protocol.ErrCodeAccessDenied, "read access denied")
+			return
+		}
+	} else if !s.permissions.CheckAccess(ctx, claims.UserID, from, "write", claims.IsAdmin) {
+		it.fail(protocol.ErrCodeAccessDenied, "write access denied")
+		return
+	}
+	it.node = s.findNode(plan.root, from)
+	if it.node == nil {
+		it.fail(protocol.ErrCodeNotFound, "not found")
+		return
+	}
+
+	to := path.Join(plan.dest, path.Base(from))
+	if !plan.copying && to == from {
+		it.unmoved = true
+		it.res.To = to
+		return
+	}
+	if !s.permissions.CheckAccess(ctx, claims.UserID, to, "write", claims.IsAdmin) {
+		it.fail(protocol.ErrCodeAccessDenied, "write access denied: "+to)
+		return
+	}
+
+	taken := plan.targets[to]
+	if !taken {
+		exists, err := s.metadata.PathExists(ctx, to)
+		if err != nil {
+			it.fail(protocol.ErrCodeInternal, err.Error())
+			return
+		}
+		taken = exists
+	}
+	if taken {
+		switch plan.policy {
+		case protocol.OnConflictSkip:
+			it.res.Status = protocol.BulkStatusSkipped
+			it.res.Code = protocol.ErrCodeConflict
+			it.res.Error = to + " already exists"
+			return
+		case protocol.OnConflictRename:
+			free, err := s.freeBulkTarget(ctx, plan, to, it.node.IsDir)
+			if err != nil {
+				it.fail(protocol.ErrCodeConflict, err.Error())
+				return
+			}
+			to = free
+			it.res.Renamed = true
+		case protocol.OnConflictOverwrite:
+			if msg := s.checkBulkReplace(ctx, plan, it, from, to); msg != "" {
+				it.fail(protocol.ErrCodeConflict, msg)
+				return
+			}
+		default:
+			it.fail(protocol.ErrCodeConflict, to+" already exists")
+			return
+		}
+	}
+
+	if plan.copying {
+		s.checkBulkCopy(ctx, plan, it, to)
+	} else {
+		s.checkBulkMove(ctx, it, from, to)
+	}
+	if it.pending() {
+		it.res.To = to
+		it.res.Replaced = it.replaced != nil
+		plan.targets[to] = true
+	}
+}
+
+// checkBulkReplace checks that the item may overwrite what is at to and
+// loads it, or says why not.
+func (s *Server) checkBulkReplace(ctx context.Context, plan *bulkPlan, it *bulkItem, from, to string) string {
+	switch {
+	case to == from:
+		return "cannot overwrite " + from + " with itself"
+	case plan.targets[to]:
+		return to + " is the target of another item"
+	case plan.sources[to]:
+		return to + " is itself moved or copied"
+	}
+	dst := s.findNode(s.currentTree(), to)
+	switch {
+	case dst == nil:
+		return to + " is in the trash"
+	case dst.IsDir != it.node.IsDir:
+		return "cannot replace a file with a directory or a directory with a file"
+	case dst.IsDir && len(dst.Children) > 0:
+		return "directory not empty: " + to
+	}
+	row, err := s.metadata.GetFileRow(ctx, to)
+	if err != nil {
+		return err.Error()
+	}
+	if row == nil {
+		return to + " is in the trash"
+	}
+	it.replaced = row
+	return ""
+}
+
+// checkBulkMove checks moving the item to to, as handleMove does.
+func (s *Server) checkBulkMove(ctx context.Context, it *bulkItem, from, to string) {
+	if err := s.checkMoveTarget(from, to); err != nil {
+		if isPathError(err) {
+			it.fail(protocol.ErrCodeInvalidPath, err.Error())
+		} else {
+			it.fail(protocol.ErrCodeBadRequest, err.Error())
+		}
+		return
+	}
+	row, err := s.metadata.GetFileRow(ctx, from)
+	if err != nil {
+		it.fail(protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	if row == nil {
+		it.fail(protocol.ErrCodeNotFound, "not found")
+		return
+	}
+	if row.StorageLocID != nil && s.storageRouter.IsReadOnly(*row.StorageLocID) {
+		it.fail(protocol.ErrCodeReadOnly, "storage location is read-only")
+		return
+	}
+	if _, _, err := s.storageRouter.ResolveForUpload(ctx, to, nil); err != nil && errors.Is(err, storage.ErrReadOnlyStorage) {
+		it.fail(protocol.ErrCodeReadOnly, "storage location is read-only")
+		return
+	}
+	it.row = row
+}
+
+// checkBulkCopy checks copying the item to to, as copyPath does, and
+// lists the rows and content to copy. The copies of earlier items count
+// towards the quota.
+func (s *Server) checkBulkCopy(ctx context.Context, plan *bulkPlan, it *bulkItem, to string) {
+	if err := validateNewPath(to); err != nil {
+		it.fail(protocol.ErrCodeInvalidPath, err.Error())
+		return
+	}
+	if s.insideAlias(to) {
+		it.fail(protocol.ErrCodeBadRequest, errInsideAlias.Error())
+		return
+	}
+	if err := paths.ValidateSubtree(to, fstree.Height(it.node)); err != nil {
+		it.fail(protocol.ErrCodeInvalidPath, err.Error())
+		return
+	}
+	var items int
+	if err := sizeCopy(it.node, 0, &items, &it.bytes); err != nil {
+		it.fail(protocol.ErrCodeFileTooLarge, err.Error())
+		return
+	}
+	if err := s.uploads.CheckQuota(ctx, plan.claims, plan.bytes+it.bytes); err != nil {
+		if errors.Is(err, upload.ErrQuotaExceeded) {
+			it.fail(protocol.ErrCodeQuotaExceeded, err.Error())
+		} else {
+			it.fail(protocol.ErrCodeInternal, err.Error())
+		}
+		return
+	}
+	if err := s.listBulkCopy(ctx, it, it.node, to); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrReadOnlyStorage):
+			it.fail(protocol.ErrCodeReadOnly, err.Error())
+		case isPathError(err):
+			it.fail(protocol.ErrCodeInvalidPath, err.Error())
+		default:
+			it.fail(protocol.ErrorCodeForStatus(copyStatus(err)), err.Error())
+		}
+		return
+	}
+	if it.replaced != nil {
+		it.copies[0].Replace = true
+	}
+	plan.bytes += it.bytes
+}
+
+// listBulkCopy adds the rows and content to copy node to dst to the item.
+// A file replacing another has its content copied to a staging key, as the
+// replaced file may still use its own; it moves there once the copy is
+// committed.
+func (s *Server) listBulkCopy(ctx context.Context, it *bulkItem, node *models.FileNode, dst string) error {
+	if node.IsDir {
+		it.copies = append(it.copies, postgres.PathCopy{To: dst})
+		it.dirs++
+		for _, child := range node.Children {
+			if err := s.listBulkCopy(ctx, it, child, path.Join(dst, child.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	row, err := s.metadata.GetFileRow(ctx, nodeSource(node))
+	if err != nil {
+		return err
+	}
+	if row == nil || row.IsDir {
+		return fmt.Errorf("%w: %s", errCopyNotFound, node.Path)
+	}
+	if row.StorageLocID != nil && s.storageRouter.IsReadOnly(*row.StorageLocID) {
+		return fmt.Errorf("%w: %s", storage.ErrReadOnlyStorage, node.Path)
+	}
+	key := stagingKey()
+	if it.replaced == nil || it.replaced.IsDir {
+		if key, err = storage.KeyForPath(dst); err != nil {
+			return err
+		}
+	}
+	it.copies = append(it.copies, postgres.PathCopy{From: row.Path, To: dst, S3Key: key})
+	if row.S3Key != "" && !postgres.IsContentKey(row.S3Key) {
+		it.objects = append(it.objects, bulkObject{row: row, key: key})
+	}
+	it.files++
+	return nil
+}
+
+// freeBulkTarget returns the first "stem (n).ext" next to to that neither
+// exists nor is the target of an earlier item. Directory names are not
+// split at a dot.
+func (s *Server) freeBulkTarget(ctx context.Context, plan *bulkPlan, to string, isDir bool) (string, error) {
+	dir, name := path.Split(to)
+	ext := ""
+	if !isDir {
+		ext = path.Ext(name)
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; n < 100; n++ {
+		p := path.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		if plan.targets[p] {
+			continue
+		}
+		exists, err := s.metadata.PathExists(ctx, p)
+		if err != nil {
+			return "", fmt.Errorf("check %s: %w", p, err)
+		}
+		if !exists {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("too many items named %q", name)
+}
+
+// stagingKey returns a new object key for content on its way to a key
+// still in use.
+func stagingKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "_staging/" + hex.EncodeToString(b)
+}
+
+// moveBulk moves the items of one transaction. On error all of them fail.
+func (s *Server) moveBulk(ctx context.Context, claims *auth.Claims, group []*bulkItem) error {
+	moves := make([]postgres.PathMove, 0, len(group))
+	moved := make([]*bulkItem, 0, len(group))
+	for _, it := range group {
+		if it.unmoved {
+			it.res.Status = protocol.BulkStatusMoved
+			continue
+		}
+		if it.replaced != nil && !it.replaced.IsDir {
+			s.backupReplacedContent(ctx, it.replaced)
+		}
+		moves = append(moves, postgres.PathMove{From: it.res.From, To: it.res.To, Replace: it.replaced != nil})
+		moved = append(moved, it)
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+
+	versions, err := s.metadata.MoveFiles(ctx, moves)
+	if err != nil {
+		logging.Warn("bulk move failed", zap.Int("items", len(moves)), zap.Error(err))
+		for _, it := range group {
+			it.fail(protocol.ErrCodeInternal, "failed to move: "+err.Error())
+		}
+		return err
+	}
+
+	for i, it := range moved {
+		from, to := it.res.From, it.res.To
+		s.relocateObject(ctx, to)
+		if it.replaced != nil && !it.replaced.IsDir {
+			if row, _ := s.metadata.GetFileRow(ctx, to); row != nil {
+				s.releaseReplaced(ctx, it.replaced, row.S3Key)
+			}
+		}
+
+		s.publishEvent(ctx, events.EventDelete, from, 0, "", 0, claims.UserID, claims.Username)
+		eventType := events.EventCreate
+		if it.replaced != nil {
+			eventType = events.EventModify
+		}
+		if it.row.IsDir {
+			s.publishEvent(ctx, eventType, to, 0, "", 0, claims.UserID, claims.Username)
+		} else {
+			version := it.row.Version
+			if versions[i] > 0 {
+				version = versions[i]
+			}
+			s.publishEvent(ctx, eventType, to, version, it.row.Hash, it.row.Size, claims.UserID, claims.Username)
+		}
+		s.recordActivity(ctx, claims, activity.ActionMove, to, map[string]interface{}{"from": from, "replaced": it.replaced != nil})
+		it.res.Status = protocol.BulkStatusMoved
+	}
+	return nil
+}
+
+// copyBulk copies the items of one transaction: their content first, then
+// their rows. On error all of them fail and the copied content is deleted
+// again.
+func (s *Server) copyBulk(ctx context.Context, claims *auth.Claims, group []*bulkItem) error {
+	type copiedObject struct {
+		backend storage.Backend
+		key     string
+	}
+	var objects []copiedObject
+	undo := func() {
+		for _, o := range objects {
+			o.backend.DeleteObject(ctx, o.key)
+		}
+	}
+	fail := func(msg string, err error) error {
+		logging.Warn("bulk copy failed", zap.Int("items", len(group)), zap.Error(err))
+		undo()
+		for _, it := range group {
+			it.fail(protocol.ErrCodeInternal, msg+": "+err.Error())
+		}
+		return err
+	}
+
+	var copies []postgres.PathCopy
+	for _, it := range group {
+		for _, obj := range it.objects {
+			backend, _, err := s.storageRouter.ResolveForFile(ctx, obj.row.StorageLocID, obj.row.GroupID)
+			if err == nil {
+				err = backend.CopyObject(ctx, obj.row.S3Key, obj.key)
+			}
+			if err != nil {
+				return fail("copy content of "+obj.row.Path, err)
+			}
+			objects = append(objects, copiedObject{backend, obj.key})
+		}
+		copies = append(copies, it.copies...)
+	}
+	for _, it := range group {
+		if it.replaced != nil && !it.replaced.IsDir {
+			s.backupReplacedContent(ctx, it.replaced)
+		}
+	}
+
+	ownerID := claims.UserID
+	versions, err := s.metadata.CopyFiles(ctx, copies, &ownerID)
+	if err != nil {
+		return fail("failed to copy", err)
+	}
+
+	first := 0
+	for _, it := range group {
+		from, to := it.res.From, it.res.To
+		if it.replaced != nil && !it.replaced.IsDir {
+			s.relocateObject(ctx, to)
+			if row, _ := s.metadata.GetFileRow(ctx, to); row != nil {
+				s.releaseReplaced(ctx, it.replaced, row.S3Key)
+			}
+		}
+
+		eventType := events.EventCreate
+		if it.replaced != nil {
+			eventType = events.EventModify
+		}
+		if it.node.IsDir {
+			s.publishEvent(ctx, eventType, to, 0, "", 0, claims.UserID, claims.Username)
+		} else {
+			s.publishEvent(ctx, eventType, to, versions[first], it.node.Hash, it.node.Size, claims.UserID, claims.Username)
+		}
+		first += len(it.copies)
+		s.recordActivity(ctx, claims, activity.ActionCopy, to, map[string]interface{}{
+			"from": from, "files": it.files, "dirs": it.dirs, "bytes": it.bytes, "replaced": it.replaced != nil,
+		})
+		it.res.Status = protocol.BulkStatusCopied
+	}
+	return nil
+}
@@ -24,6 +24,9 @@ import (
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/textindex"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 	"go.uber.org/zap"
 )
 
@@ -96,6 +99,13 @@ func (m *ChunkedUploadManager) handleInitUpload(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// Sessions started now could not finish before the process exits
+	if m.server.Draining() {
+		w.Header().Set("Retry-After", "5")
+		m.sendError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
 	var req initUploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		m.sendError(w, http.StatusBadRequest, "invalid request body")
@@ -112,6 +122,11 @@ func (m *ChunkedUploadManager) handleInitUpload(w http.ResponseWriter, r *http.R
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := validateNewPath(path); err != nil {
+		m.server.sendPathError(w, err)
+		return
+	}
+	path, _ = m.server.resolveAlias(path)
 
 	// Check write permission
 	if !m.server.permissions.CheckAccess(r.Context(), claims.UserID, path, "write", claims.IsAdmin) {
@@ -122,7 +137,7 @@ func (m *ChunkedUploadManager) handleInitUpload(w http.ResponseWriter, r *http.R
 	// Check if the target storage is read-only before allocating resources
 	_, _, roErr := m.server.storageRouter.ResolveForUpload(r.Context(), path, nil)
 	if roErr != nil && errors.Is(roErr, storage.ErrReadOnlyStorage) {
-		m.sendError(w, http.StatusForbidden, "storage location is read-only")
+		m.server.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
 		return
 	}
 
@@ -133,7 +148,7 @@ func (m *ChunkedUploadManager) handleInitUpload(w http.ResponseWriter, r *http.R
 	ok, err := m.server.quotaStore.CheckStorageQuota(r.Context(), claims.UserID, req.FileSize)
 	if err == nil && !ok {
 		metrics.RecordQuotaExceeded("storage")
-		m.sendError(w, http.StatusRequestEntityTooLarge, "storage quota exceeded")
+		m.server.sendErrorCode(w, http.StatusRequestEntityTooLarge, protocol.ErrCodeQuotaExceeded, "storage quota exceeded", "")
 		return
 	}
 
@@ -291,6 +306,12 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 		return
 	}
 
+	declaredHash, ok := declaredSHA256(r)
+	if !ok {
+		m.sendError(w, http.StatusBadRequest, "invalid "+protocol.HeaderContentSHA256+" header: want a hex SHA-256")
+		return
+	}
+
 	uploadID := r.PathValue("uploadId")
 
 	// Load upload record
@@ -349,6 +370,15 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 	}
 	hashStr := fmt.Sprintf("%x", hasher.Sum(nil))
 
+	// The assembled file is corrupt and the bad chunk is unknown: drop the
+	// upload so the client starts over
+	if declaredHash != "" && declaredHash != hashStr {
+		f.Close()
+		m.discard(r.Context(), uploadID)
+		m.server.sendChecksumMismatch(w, path, &upload.ChecksumError{Expected: declaredHash, Actual: hashStr}, "chunked")
+		return
+	}
+
 	// Seek back to beginning for upload to backend
 	if _, err := f.Seek(0, io.SeekStart); err != nil {
 		f.Close()
@@ -356,8 +386,12 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 		return
 	}
 
-	// S3 key
-	s3Key := strings.TrimPrefix(path, "/")
+	s3Key, err := storage.KeyForPath(path)
+	if err != nil {
+		f.Close()
+		m.server.sendPathError(w, err)
+		return
+	}
 
 	// Check existing file for versioning
 	newVersion := 1
@@ -370,7 +404,7 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 		}
 
 		existBackend, _, _ := m.server.storageRouter.ResolveForFile(r.Context(), existingRow.StorageLocID, existingRow.GroupID)
-		if existBackend != nil {
+		if existBackend != nil && !postgres.IsContentKey(existingRow.S3Key) {
 			versionKey := fmt.Sprintf("_versions/%s/%d", s3Key, existingRow.Version)
 			if err := existBackend.CopyObject(r.Context(), existingRow.S3Key, versionKey); err != nil {
 				logging.Warn("failed to backup version content", zap.String("path", path), zap.Error(err))
@@ -389,15 +423,18 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 	if err != nil {
 		f.Close()
 		if errors.Is(err, storage.ErrReadOnlyStorage) {
-			m.sendError(w, http.StatusForbidden, "storage location is read-only")
+			m.server.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
 			return
 		}
-		m.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
+		m.server.sendStorageError(w, err)
 		return
 	}
 
-	// Stream temp file to backend
-	if err := backend.PutObject(r.Context(), s3Key, f, fileSize); err != nil {
+	// Stream temp file to backend, unless the location already holds it
+	s3Key, _, err = m.server.metadata.PutContent(r.Context(), hashStr, loc.ID, fileSize, s3Key, func(key string) error {
+		return backend.PutObject(r.Context(), key, f, fileSize)
+	})
+	if err != nil {
 		f.Close()
 		m.sendError(w, http.StatusInternalServerError, "failed to upload to storage: "+err.Error())
 		return
@@ -436,12 +473,17 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 	}
 
 	if err := m.server.metadata.UpsertFile(r.Context(), fileRow); err != nil {
+		if postgres.IsContentKey(s3Key) {
+			m.server.metadata.ReleaseContent(r.Context(), s3Key, storageLocID, backend.DeleteObject)
+		}
 		m.sendError(w, http.StatusInternalServerError, "failed to save metadata: "+err.Error())
 		return
 	}
+	if existingRow != nil && !existingRow.IsDir {
+		m.server.releaseReplaced(r.Context(), existingRow, s3Key)
+	}
 
-	// Refresh tree
-	m.server.RefreshTree(r.Context())
+	m.server.updateTree(r.Context(), fileRow.Path)
 
 	// Track bandwidth
 	m.server.quotaStore.TrackBandwidth(r.Context(), claims.UserID, fileSize, 0)
@@ -457,13 +499,18 @@ func (m *ChunkedUploadManager) handleCompleteUpload(w http.ResponseWriter, r *ht
 	if existingRow != nil {
 		eventType = events.EventModify
 	}
-	m.server.publishEvent(eventType, path, newVersion, hashStr, fileSize, claims.UserID, claims.Username)
+	m.server.publishEvent(r.Context(), eventType, path, newVersion, hashStr, fileSize, claims.UserID, claims.Username)
 
 	// Gallery processing
-	if m.server.processor != nil && gallery.IsImageFile(path) {
+	if m.server.processor != nil && gallery.IsMediaFile(path) {
 		m.server.processor.Enqueue(path)
 	}
 
+	// Content search indexing
+	if m.server.textIndexer != nil && textindex.IsIndexable(path) {
+		m.server.textIndexer.Enqueue(path)
+	}
+
 	// Mark upload as completed and clean up
 	m.db.ExecContext(r.Context(),
 		`UPDATE chunked_uploads SET status = 'completed' WHERE id = $1`, uploadID)
@@ -569,10 +616,7 @@ func (m *ChunkedUploadManager) handleAbortUpload(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Clean up
-	m.db.ExecContext(r.Context(), `DELETE FROM upload_chunks WHERE upload_id = $1`, uploadID)
-	m.db.ExecContext(r.Context(), `DELETE FROM chunked_uploads WHERE id = $1`, uploadID)
-	os.Remove(m.tempPath(uploadID))
+	m.discard(r.Context(), uploadID)
 
 	logging.Info("chunked upload aborted", zap.String("upload_id", uploadID))
 
@@ -601,15 +645,20 @@ func (m *ChunkedUploadManager) cleanupExpired(ctx context.Context) {
 	}
 
 	for _, id := range ids {
-		m.db.ExecContext(ctx, `DELETE FROM upload_chunks WHERE upload_id = $1`, id)
-		m.db.ExecContext(ctx, `DELETE FROM chunked_uploads WHERE id = $1`, id)
-		os.Remove(m.tempPath(id))
+		m.discard(ctx, id)
 		logging.Info("cleaned up expired chunked upload", zap.String("upload_id", id))
 	}
 }
 
 // ─── Helpers ────────────────────────────────────────────────────────────────
 
+// discard deletes an upload's records and its assembled temp file.
+func (m *ChunkedUploadManager) discard(ctx context.Context, uploadID string) {
+	m.db.ExecContext(ctx, `DELETE FROM upload_chunks WHERE upload_id = $1`, uploadID)
+	m.db.ExecContext(ctx, `DELETE FROM chunked_uploads WHERE id = $1`, uploadID)
+	os.Remove(m.tempPath(uploadID))
+}
+
 func (m *ChunkedUploadManager) sendError(w http.ResponseWriter, code int, message string) {
 	m.server.sendError(w, code, message)
 }
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── File Comments ──────────────────────────────────────────────────────────
+
+// maxCommentLength bounds a comment body, in bytes.
+const maxCommentLength = 10000
+
+func commentResponse(c *postgres.CommentRow) protocol.CommentResponse {
+	return protocol.CommentResponse{
+		ID:         c.ID,
+		Path:       c.Path,
+		AuthorID:   c.AuthorID,
+		AuthorName: c.AuthorName,
+		Body:       c.Body,
+		ParentID:   c.ParentID,
+		Resolved:   c.Resolved,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+	}
+}
+
+// publishComment tells open viewers of path that its comments changed.
+func (s *Server) publishComment(claims *auth.Claims, c *postgres.CommentRow, action string) {
+	if s.broadcaster == nil {
+		return
+	}
+	s.broadcaster.Publish(events.Event{
+		Type:      events.EventComment,
+		Path:      c.Path,
+		Timestamp: time.Now().Unix(),
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Comment:   &protocol.CommentPayload{ID: c.ID, ParentID: c.ParentID, Action: action},
+	})
+}
+
+// commentPath returns the file path of a comments request after checking
+// that it exists and the user can read it.
+func (s *Server) commentPath(w http.ResponseWriter, r *http.Request, claims *auth.Claims) (string, bool) {
+	path := "/" + r.PathValue("path")
+	if path == "/" {
+		s.sendError(w, http.StatusBadRequest, "path required")
+		return "", false
+	}
+	if !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "read", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "access denied")
+		return "", false
+	}
+	if s.findNode(s.currentTree(), path) == nil {
+		s.sendError(w, http.StatusNotFound, "path not found: "+path)
+		return "", false
+	}
+	return path, true
+}
+
+// commentByID loads the comment named in the request and checks that the
+// user can read its file.
+func (s *Server) commentByID(w http.ResponseWriter, r *http.Request, claims *auth.Claims) *postgres.CommentRow {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid comment ID")
+		return nil
+	}
+	c, err := s.metadata.GetComment(r.Context(), id)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get comment: "+err.Error())
+		return nil
+	}
+	if c == nil || !s.permissions.CheckAccess(r.Context(), claims.UserID, c.Path, "read", claims.IsAdmin) {
+		s.sendError(w, http.StatusNotFound, "comment not found")
+		return nil
+	}
+	return c
+}
+
+func (s *Server) handleListComments(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	path, ok := s.commentPath(w, r, claims)
+	if !ok {
+		return
+	}
+
+	comments, err := s.metadata.ListComments(r.Context(), path)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list comments: "+err.Error())
+		return
+	}
+	resp := make([]protocol.CommentResponse, 0, len(comments))
+	for i := range comments {
+		resp = append(resp, commentResponse(&comments[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleAddComment(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	path, ok := s.commentPath(w, r, claims)
+	if !ok {
+		return
+	}
+
+	var req protocol.CreateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" || len(req.Body) > maxCommentLength {
+		s.sendError(w, http.StatusBadRequest, "comment body must be 1 to 10000 bytes")
+		return
+	}
+	if req.ParentID != 0 {
+		parent, err := s.metadata.GetComment(r.Context(), req.ParentID)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to get comment: "+err.Error())
+			return
+		}
+		if parent == nil || parent.Path != path {
+			s.sendError(w, http.StatusBadRequest, "parent comment not found on this file")
+			return
+		}
+		if parent.ParentID != 0 {
+			s.sendError(w, http.StatusBadRequest, "replies cannot be replied to")
+			return
+		}
+	}
+
+	c, err := s.metadata.AddComment(r.Context(), path, claims.UserID, req.Body, req.ParentID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to add comment: "+err.Error())
+		return
+	}
+	s.publishComment(claims, c, protocol.CommentAdded)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(commentResponse(c))
+}
+
+func (s *Server) handleUpdateComment(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	c := s.commentByID(w, r, claims)
+	if c == nil {
+		return
+	}
+
+	var req protocol.UpdateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Body == nil && req.Resolved == nil {
+		s.sendError(w, http.StatusBadRequest, "body or resolved required")
+		return
+	}
+	if req.Body != nil {
+		if c.AuthorID != claims.UserID {
+			s.sendError(w, http.StatusForbidden, "only the author can edit a comment")
+			return
+		}
+		body := strings.TrimSpace(*req.Body)
+		if body == "" || len(body) > maxCommentLength {
+			s.sendError(w, http.StatusBadRequest, "comment body must be 1 to 10000 bytes")
+			return
+		}
+		req.Body = &body
+	}
+
+	updated, err := s.metadata.UpdateComment(r.Context(), c.ID, req.Body, req.Resolved)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to update comment: "+err.Error())
+		return
+	}
+	if updated == nil {
+		s.sendError(w, http.StatusNotFound, "comment not found")
+		return
+	}
+
+	action := protocol.CommentEdited
+	if req.Resolved != nil && *req.Resolved != c.Resolved {
+		action = protocol.CommentReopened
+		if *req.Resolved {
+			action = protocol.CommentResolved
+		}
+	}
+	s.publishComment(claims, updated, action)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(commentResponse(updated))
+}
+
+func (s *Server) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	c := s.commentByID(w, r, claims)
+	if c == nil {
+		return
+	}
+	if c.AuthorID != claims.UserID && !claims.IsAdmin {
+		s.sendError(w, http.StatusForbidden, "only the author or an admin can delete a comment")
+		return
+	}
+
+	if err := s.metadata.DeleteComment(r.Context(), c.ID); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to delete comment: "+err.Error())
+		return
+	}
+	s.publishComment(claims, c, protocol.CommentDeleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      c.ID,
+		"deleted": true,
+	})
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Content Compression ────────────────────────────────────────────────────
+//
+// Whole-file downloads of compressible types (CONTENT_COMPRESS_TYPES) are
+// compressed for clients that accept it, preferring zstd over gzip. Range
+// requests never are: their offsets refer to the stored bytes. Compressed
+// responses have no Content-Length, and the ETag stays the hash of the
+// uncompressed content, which is what clients verify.
+
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// compressMinSize is the smallest file worth compressing.
+const compressMinSize = 1024
+
+// zstdWindowSize caps the zstd window at the 8 MiB browsers accept for
+// Content-Encoding: zstd.
+const zstdWindowSize = 8 << 20
+
+var zstdPool = sync.Pool{
+	New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize))
+		return enc
+	},
+}
+
+// parseCompressTypes splits a comma-separated list of MIME types.
+func parseCompressTypes(list string) []string {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// compressibleType reports whether contentType is one of types, where
+// "text/*" stands for every text type.
+func compressibleType(contentType string, types []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range types {
+		if family, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, family+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// compressContent reports whether a file of contentType and size may be
+// sent compressed.
+func (s *Server) compressContent(contentType string, size int64) bool {
+	return size >= compressMinSize && compressibleType(contentType, s.compressTypes)
+}
+
+// negotiateEncoding returns the coding to send a response in according to
+// an Accept-Encoding header: the supported one with the highest q-value,
+// zstd on a tie, or "" for none.
+func negotiateEncoding(header string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				q = 0
+			}
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{encodingZstd, encodingGzip} {
+		q, ok := weights[coding]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// contentEncoder compresses a response body on its way to w and records
+// the bytes before and after compression.
+type contentEncoder struct {
+	encoding string
+	zw       io.WriteCloser
+	out      countingWriter
+	in       int64
+}
+
+func newContentEncoder(w io.Writer, encoding string) *contentEncoder {
+	e := &contentEncoder{encoding: encoding, out: countingWriter{w: w}}
+	if encoding == encodingZstd {
+		enc := zstdPool.Get().(*zstd.Encoder)
+		enc.Reset(&e.out)
+		e.zw = enc
+	} else {
+		gw := gzipPool.Get().(*gzip.Writer)
+		gw.Reset(&e.out)
+		e.zw = gw
+	}
+	return e
+}
+
+func (e *contentEncoder) Write(p []byte) (int, error) {
+	n, err := e.zw.Write(p)
+	e.in += int64(n)
+	return n, err
+}
+
+// Close ends the compressed stream and returns the compressor to its
+// pool. The encoder must not be used afterwards.
+func (e *contentEncoder) Close() error {
+	err := e.zw.Close()
+	switch zw := e.zw.(type) {
+	case *zstd.Encoder:
+		zw.Reset(io.Discard)
+		zstdPool.Put(zw)
+	case *gzip.Writer:
+		zw.Reset(io.Discard)
+		gzipPool.Put(zw)
+	}
+	metrics.RecordContentCompression(e.encoding, e.in, e.out.n)
+	return err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,490 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Sync Conflicts ─────────────────────────────────────────────────────────
+//
+// A sync conflict is content that lost against a newer server version: an
+// upload refused with 409, or a conflict a client detected and reported.
+// The losing content is kept under _conflicts/ until its user resolves the
+// conflict by keeping their content, the server's, or both.
+
+// conflictKey returns a new object key for the losing content of a conflict.
+func conflictKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "_conflicts/" + hex.EncodeToString(b)
+}
+
+// recordConflict stores content as the user's losing side of a conflict on
+// path and announces it to the user's sessions. A conflict already open
+// for the same content is returned instead of recording a second one.
+func (s *Server) recordConflict(ctx context.Context, claims *auth.Claims, path, source, device string,
+	serverVersion int, serverHash string, content []byte) (*postgres.ConflictRow, error) {
+	if claims == nil {
+		return nil, nil
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	existing, err := s.metadata.FindOpenConflict(ctx, claims.UserID, path, hash)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	var groupID *int
+	if row, err := s.metadata.GetFileRow(ctx, path); err == nil && row != nil {
+		groupID = row.GroupID
+	}
+	backend, loc, err := s.storageRouter.ResolveForUpload(ctx, path, groupID)
+	if err != nil {
+		return nil, err
+	}
+	key := conflictKey()
+	if err := backend.PutObject(ctx, key, bytes.NewReader(content), int64(len(content))); err != nil {
+		return nil, fmt.Errorf("store conflict content: %w", err)
+	}
+
+	c := &postgres.ConflictRow{
+		UserID:        claims.UserID,
+		Path:          path,
+		Source:        source,
+		Device:        device,
+		ServerVersion: serverVersion,
+		ServerHash:    serverHash,
+		MineKey:       key,
+		MineSize:      int64(len(content)),
+		MineHash:      hash,
+	}
+	if loc != nil {
+		c.StorageLocID = &loc.ID
+	}
+	c, err = s.metadata.AddConflict(ctx, c)
+	if err != nil {
+		backend.DeleteObject(ctx, key)
+		return nil, err
+	}
+
+	logging.Info("sync conflict recorded",
+		zap.Int64("id", c.ID),
+		zap.String("path", path),
+		zap.String("source", source),
+		zap.Int("user_id", claims.UserID))
+	s.publishConflict(claims, c, protocol.ConflictCreated)
+	return c, nil
+}
+
+// publishConflict tells the user's sessions that a conflict of theirs was
+// created or resolved.
+func (s *Server) publishConflict(claims *auth.Claims, c *postgres.ConflictRow, action string) {
+	if s.broadcaster == nil {
+		return
+	}
+	s.broadcaster.Publish(events.Event{
+		Type:      events.EventConflict,
+		Path:      c.Path,
+		Timestamp: time.Now().Unix(),
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		ForUserID: c.UserID,
+		Conflict:  &protocol.ConflictPayload{ID: c.ID, Action: action, Resolution: c.Resolution},
+	})
+}
+
+// conflictResponse describes c with the file now at its path as the
+// server's side.
+func (s *Server) conflictResponse(ctx context.Context, c *postgres.ConflictRow) protocol.SyncConflict {
+	resp := protocol.SyncConflict{
+		ID:            c.ID,
+		Path:          c.Path,
+		Source:        c.Source,
+		Device:        c.Device,
+		ServerVersion: c.ServerVersion,
+		Mine: protocol.ConflictSide{
+			Hash:    c.MineHash,
+			Size:    c.MineSize,
+			ModTime: c.CreatedAt,
+		},
+		CreatedAt:  c.CreatedAt,
+		Resolution: c.Resolution,
+		ResolvedAt: c.ResolvedAt,
+	}
+	if row, err := s.metadata.GetFileRow(ctx, c.Path); err == nil && row != nil && !row.IsDir {
+		resp.Theirs = &protocol.ConflictSide{
+			Version: row.Version,
+			Hash:    row.Hash,
+			Size:    row.Size,
+			ModTime: row.ModTime,
+		}
+	}
+	return resp
+}
+
+// conflictByID loads the conflict named in the request. Conflicts are
+// private to their user.
+func (s *Server) conflictByID(w http.ResponseWriter, r *http.Request, claims *auth.Claims) *postgres.ConflictRow {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid conflict ID")
+		return nil
+	}
+	c, err := s.metadata.GetConflict(r.Context(), id)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get conflict: "+err.Error())
+		return nil
+	}
+	if c == nil || c.UserID != claims.UserID {
+		s.sendError(w, http.StatusNotFound, "conflict not found")
+		return nil
+	}
+	return c
+}
+
+func (s *Server) handleListConflicts(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	conflicts, err := s.metadata.ListOpenConflicts(r.Context(), claims.UserID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list conflicts: "+err.Error())
+		return
+	}
+	resp := make([]protocol.SyncConflict, 0, len(conflicts))
+	for i := range conflicts {
+		resp = append(resp, s.conflictResponse(r.Context(), &conflicts[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleReportConflict records a conflict a client detected itself, e.g.
+// a file changed locally and on the server while it was offline. The
+// request body is the client's content; ?version= is the server version
+// it lost against, the current one if omitted.
+func (s *Server) handleReportConflict(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" || path == "/" {
+		s.sendError(w, http.StatusBadRequest, "path required")
+		return
+	}
+	path = "/" + strings.TrimLeft(path, "/")
+	if err := validateNewPath(path); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	if !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "write", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "write access denied")
+		return
+	}
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.sendError(w, http.StatusBadRequest, "invalid version")
+			return
+		}
+		version = n
+	}
+
+	limit := s.uploadLimit(r.Context(), claims)
+	content, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to read content")
+		return
+	}
+	if int64(len(content)) > limit {
+		s.sendError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("file too large: max %d bytes", limit))
+		return
+	}
+
+	var serverHash string
+	if row, err := s.metadata.GetFileRow(r.Context(), path); err == nil && row != nil {
+		if version == 0 {
+			version = row.Version
+		}
+		if version == row.Version {
+			serverHash = row.Hash
+		}
+	}
+
+	c, err := s.recordConflict(r.Context(), claims, path, protocol.ConflictSourceClient,
+		r.URL.Query().Get("device"), version, serverHash, content)
+	if err != nil {
+		s.sendUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s.conflictResponse(r.Context(), c))
+}
+
+// handleConflictMine streams the user's losing content of a conflict.
+func (s *Server) handleConflictMine(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	c := s.conflictByID(w, r, claims)
+	if c == nil {
+		return
+	}
+	if c.Resolution != "" {
+		s.sendError(w, http.StatusGone, "conflict already resolved")
+		return
+	}
+
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), c.StorageLocID, nil)
+	if err != nil {
+		s.sendStorageError(w, err)
+		return
+	}
+	reader, size, err := backend.GetObject(r.Context(), c.MineKey, 0, 0)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to retrieve conflict content: "+err.Error())
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Content-SHA256", c.MineHash)
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		logging.Warn("conflict content transfer error", zap.Int64("id", c.ID), zap.Error(err))
+	}
+	metrics.RecordContentDownload(n, err == nil)
+}
+
+// handleConflictTheirs streams the server's side of a conflict: the file
+// now at its path.
+func (s *Server) handleConflictTheirs(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	c := s.conflictByID(w, r, claims)
+	if c == nil {
+		return
+	}
+	if !s.permissions.CheckAccess(r.Context(), claims.UserID, c.Path, "read", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "access denied")
+		return
+	}
+	row, err := s.metadata.GetFileRow(r.Context(), c.Path)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get file: "+err.Error())
+		return
+	}
+	if row == nil || row.IsDir {
+		s.sendError(w, http.StatusNotFound, "file no longer exists: "+c.Path)
+		return
+	}
+
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), row.StorageLocID, row.GroupID)
+	if err != nil {
+		s.sendStorageError(w, err)
+		return
+	}
+	reader, size, err := backend.GetObject(r.Context(), row.S3Key, 0, 0)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to retrieve content: "+err.Error())
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Version", strconv.Itoa(row.Version))
+	w.Header().Set("X-Content-SHA256", row.Hash)
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		logging.Warn("conflict content transfer error", zap.Int64("id", c.ID), zap.Error(err))
+	}
+	metrics.RecordContentDownload(n, err == nil)
+}
+
+// conflictCopyPath returns a free sibling path for the losing content of
+// c, named like the conflict copies of the FUSE client:
+// <name>.conflict-<device>-<time>.
+func (s *Server) conflictCopyPath(ctx context.Context, c *postgres.ConflictRow, username string) (string, error) {
+	device := c.Device
+	if device == "" {
+		device = username
+	}
+	base := fmt.Sprintf("%s.conflict-%s-%s", c.Path, device, c.CreatedAt.Format("20060102-150405"))
+	p := base
+	for i := 2; ; i++ {
+		row, err := s.metadata.GetFileRow(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		if row == nil {
+			return p, nil
+		}
+		p = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// readConflictContent returns the losing content of c.
+func (s *Server) readConflictContent(ctx context.Context, c *postgres.ConflictRow) ([]byte, error) {
+	backend, _, err := s.storageRouter.ResolveForRead(ctx, c.StorageLocID, nil)
+	if err != nil {
+		return nil, err
+	}
+	reader, _, err := backend.GetObject(ctx, c.MineKey, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read conflict content: %w", err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (s *Server) handleResolveConflict(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	c := s.conflictByID(w, r, claims)
+	if c == nil {
+		return
+	}
+
+	var req protocol.ResolveConflictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch req.Resolution {
+	case protocol.ResolveKeepMine, protocol.ResolveKeepTheirs, protocol.ResolveKeepBoth:
+	default:
+		s.sendError(w, http.StatusBadRequest, "resolution must be keep-mine, keep-theirs or keep-both")
+		return
+	}
+	if c.Resolution != "" {
+		s.sendError(w, http.StatusConflict, "conflict already resolved: "+c.Resolution)
+		return
+	}
+
+	// keep-mine writes the losing content over the file, keep-both next to it
+	var target string
+	switch req.Resolution {
+	case protocol.ResolveKeepMine:
+		target = c.Path
+	case protocol.ResolveKeepBoth:
+		p, err := s.conflictCopyPath(r.Context(), c, claims.Username)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to name conflict copy: "+err.Error())
+			return
+		}
+		target = p
+	}
+	var copyPath string
+	if target != "" {
+		if err := validateNewPath(target); err != nil {
+			s.sendPathError(w, err)
+			return
+		}
+		if !s.permissions.CheckAccess(r.Context(), claims.UserID, target, "write", claims.IsAdmin) {
+			s.sendError(w, http.StatusForbidden, "write access denied")
+			return
+		}
+		content, err := s.readConflictContent(r.Context(), c)
+		if err != nil {
+			s.sendUploadError(w, err)
+			return
+		}
+		res, err := s.uploads.Store(r.Context(), upload.Request{
+			Path:    target,
+			Content: bytes.NewReader(content),
+			Size:    int64(len(content)),
+			Claims:  claims,
+		})
+		if err != nil {
+			s.sendUploadError(w, err)
+			return
+		}
+		s.afterUpload(r.Context(), claims, res)
+		if req.Resolution == protocol.ResolveKeepBoth {
+			copyPath = target
+		}
+	}
+
+	ok, err := s.metadata.ResolveConflict(r.Context(), c.ID, req.Resolution)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to resolve conflict: "+err.Error())
+		return
+	}
+	if !ok {
+		s.sendError(w, http.StatusConflict, "conflict already resolved")
+		return
+	}
+	s.deleteConflictContent(r.Context(), c)
+
+	resolved, err := s.metadata.GetConflict(r.Context(), c.ID)
+	if err != nil || resolved == nil {
+		resolved = c
+		resolved.Resolution = req.Resolution
+	}
+	s.publishConflict(claims, resolved, protocol.ConflictResolved)
+	details := map[string]interface{}{"id": c.ID, "resolution": req.Resolution}
+	if copyPath != "" {
+		details["copy_path"] = copyPath
+	}
+	s.recordActivity(r.Context(), claims, activity.ActionConflictResolve, c.Path, details)
+
+	resp := s.conflictResponse(r.Context(), resolved)
+	resp.CopyPath = copyPath
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// deleteConflictContent removes the losing content of a resolved conflict.
+func (s *Server) deleteConflictContent(ctx context.Context, c *postgres.ConflictRow) {
+	backend, _, err := s.storageRouter.ResolveForRead(ctx, c.StorageLocID, nil)
+	if err == nil {
+		err = backend.DeleteObject(ctx, c.MineKey)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logging.Warn("failed to delete conflict content",
+			zap.Int64("id", c.ID), zap.String("key", c.MineKey), zap.Error(err))
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// ─── Copy ───────────────────────────────────────────────────────────────────
+//
+// POST /api/v1/copy copies a file, or a directory with everything the user
+// can read below it. Copies are new files: version 1, owned by the copying
+// user, with their own storage object. Deduplicated content is shared with
+// a reference instead. The whole copy is checked against the user's quota
+// and the limits below before anything is written.
+
+// Limits on a single directory copy.
+const (
+	maxCopyDepth = 32
+	maxCopyItems = 10000
+)
+
+var (
+	errCopyInvalid  = errors.New("invalid copy")
+	errCopyDenied   = errors.New("access denied")
+	errCopyNotFound = errors.New("not found")
+	errCopyTooLarge = errors.New("copy too large")
+)
+
+// copyStatus returns the HTTP status for an error of copyPath.
+func copyStatus(err error) int {
+	switch {
+	case errors.Is(err, errCopyInvalid), isPathError(err):
+		return http.StatusBadRequest
+	case errors.Is(err, errCopyDenied):
+		return http.StatusForbidden
+	case errors.Is(err, errCopyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, postgres.ErrPathExists):
+		return http.StatusConflict
+	case errors.Is(err, errCopyTooLarge), errors.Is(err, upload.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var req protocol.CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.From == "" || req.To == "" {
+		s.sendError(w, http.StatusBadRequest, "from and to required")
+		return
+	}
+
+	resp, err := s.copyPath(r.Context(), claims, req.From, req.To, req.Recursive)
+	if isPathError(err) {
+		s.sendPathError(w, err)
+		return
+	}
+	if errors.Is(err, upload.ErrQuotaExceeded) {
+		s.sendErrorCode(w, http.StatusRequestEntityTooLarge, protocol.ErrCodeQuotaExceeded, err.Error(), "")
+		return
+	}
+	if err != nil {
+		s.sendError(w, copyStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// copyPath copies from to to for claims. Errors before the copy starts
+// are path errors (isPathError) or wrap one of the errCopy errors,
+// postgres.ErrPathExists or upload.ErrQuotaExceeded; files that fail
+// during the copy are reported in the response.
+func (s *Server) copyPath(ctx context.Context, claims *auth.Claims, from, to string, recursive bool) (*protocol.CopyResponse, error) {
+	if err := validateNewPath(to); err != nil {
+		return nil, err
+	}
+	from = path.Clean("/" + from)
+	to = path.Clean("/" + to)
+	if from == "/" || to == "/" {
+		return nil, fmt.Errorf("%w: cannot copy root or onto root", errCopyInvalid)
+	}
+	if to == from || strings.HasPrefix(to, from+"/") {
+		return nil, fmt.Errorf("%w: cannot copy a path into itself", errCopyInvalid)
+	}
+	if s.insideAlias(to) {
+		return nil, fmt.Errorf("%w: %v", errCopyInvalid, errInsideAlias)
+	}
+	if source, _ := s.resolveAlias(from); !s.permissions.CheckAccess(ctx, claims.UserID, source, "read", claims.IsAdmin) {
+		return nil, fmt.Errorf("%w: %s", errCopyDenied, from)
+	}
+	if !s.permissions.CheckAccess(ctx, claims.UserID, to, "write", claims.IsAdmin) {
+		return nil, fmt.Errorf("%w: %s", errCopyDenied, to)
+	}
+
+	// Only what the user can see is copied
+	src := s.findNode(s.filterTree(ctx, s.currentTree(), claims, -1), from)
+	if src == nil {
+		return nil, fmt.Errorf("%w: %s", errCopyNotFound, from)
+	}
+	if src.IsDir && !recursive {
+		return nil, fmt.Errorf("%w: recursive required to copy a directory", errCopyInvalid)
+	}
+	if err := paths.ValidateSubtree(to, fstree.Height(src)); err != nil {
+		return nil, err
+	}
+	if exists, err := s.metadata.PathExists(ctx, to); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("%w: %s", postgres.ErrPathExists, to)
+	}
+
+	var items int
+	var bytes int64
+	if err := sizeCopy(src, 0, &items, &bytes); err != nil {
+		return nil, err
+	}
+	if err := s.uploads.CheckQuota(ctx, claims, bytes); err != nil {
+		return nil, err
+	}
+
+	if err := s.ensureParentDirs(ctx, to); err != nil {
+		return nil, fmt.Errorf("create parent dirs: %w", err)
+	}
+	resp := &protocol.CopyResponse{From: from, To: to}
+	s.copyNode(ctx, claims, src, to, resp)
+
+	if src.IsDir {
+		s.RefreshTree(ctx)
+		s.publishEvent(ctx, events.EventCreate, to, 0, "", 0, claims.UserID, claims.Username)
+	} else if resp.Files > 0 {
+		s.updateTree(ctx, to)
+		s.publishEvent(ctx, events.EventCreate, to, 1, src.Hash, src.Size, claims.UserID, claims.Username)
+	}
+	logging.Info("copied",
+		zap.String("from", from), zap.String("to", to),
+		zap.Int("files", resp.Files), zap.Int("dirs", resp.Dirs), zap.Int64("bytes", resp.Bytes))
+	s.recordActivity(ctx, claims, activity.ActionCopy, to, map[string]interface{}{
+		"from": from, "files": resp.Files, "dirs": resp.Dirs, "bytes": resp.Bytes,
+	})
+	return resp, nil
+}
+
+// sizeCopy counts the items and bytes below node and checks them against
+// the copy limits.
+func sizeCopy(node *models.FileNode, depth int, items *int, bytes *int64) error {
+	if depth > maxCopyDepth {
+		return fmt.Errorf("%w: more than %d levels deep", errCopyTooLarge, maxCopyDepth)
+	}
+	if *items++; *items > maxCopyItems {
+		return fmt.Errorf("%w: more than %d items", errCopyTooLarge, maxCopyItems)
+	}
+	if !node.IsDir {
+		*bytes += node.Size
+		return nil
+	}
+	for _, child := range node.Children {
+		if err := sizeCopy(child, depth+1, items, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyNode copies node to dst, directories before their contents.
+func (s *Server) copyNode(ctx context.Context, claims *auth.Claims, node *models.FileNode, dst string, resp *protocol.CopyResponse) {
+	if !node.IsDir {
+		if err := s.copyFile(ctx, claims, nodeSource(node), dst); err != nil {
+			logging.Warn("copy failed", zap.String("from", node.Path), zap.String("to", dst), zap.Error(err))
+			resp.Errors = append(resp.Errors, node.Path+": "+err.Error())
+			return
+		}
+		resp.Files++
+		resp.Bytes += node.Size
+		return
+	}
+
+	ownerID := claims.UserID
+	dir := &postgres.FileRow{
+		ID:         fileID(dst),
+		Name:       path.Base(dst),
+		Path:       dst,
+		ParentPath: path.Dir(dst),
+		IsDir:      true,
+		ModTime:    time.Now(),
+		OwnerID:    &ownerID,
+	}
+	if err := s.metadata.UpsertFile(ctx, dir); err != nil {
+		resp.Errors = append(resp.Errors, node.Path+": "+err.Error())
+		return
+	}
+	resp.Dirs++
+	for _, child := range node.Children {
+		s.copyNode(ctx, claims, child, path.Join(dst, child.Name), resp)
+	}
+}
+
+// copyFile copies one file's row and storage object. The object stays in
+// the source's storage location; a copy that cannot be stored leaves no
+// row behind.
+func (s *Server) copyFile(ctx context.Context, claims *auth.Claims, src, dst string) error {
+	row, err := s.metadata.GetFileRow(ctx, src)
+	if err != nil {
+		return err
+	}
+	if row == nil || row.IsDir {
+		return errors.New("file not found")
+	}
+	if row.StorageLocID != nil && s.storageRouter.IsReadOnly(*row.StorageLocID) {
+		return errors.New("storage location is read-only")
+	}
+
+	ownerID := claims.UserID
+	if err := s.metadata.CopyFileRow(ctx, src, dst, &ownerID); err != nil {
+		return err
+	}
+	if row.S3Key == "" || postgres.IsContentKey(row.S3Key) {
+		return nil
+	}
+	key, err := storage.KeyForPath(dst)
+	if err == nil {
+		var backend storage.Backend
+		if backend, _, err = s.storageRouter.ResolveForFile(ctx, row.StorageLocID, row.GroupID); err == nil {
+			err = backend.CopyObject(ctx, row.S3Key, key)
+		}
+	}
+	if err != nil {
+		s.metadata.DeleteFile(ctx, dst)
+		return fmt.Errorf("copy content: %w", err)
+	}
+	return nil
+}
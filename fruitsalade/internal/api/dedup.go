@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"go.uber.org/zap"
+)
+
+// releaseReplaced drops what an overwritten file row held on its previous
+// content. Failures are only logged: the new content is already saved.
+func (s *Server) releaseReplaced(ctx context.Context, old *postgres.FileRow, newKey string) {
+	backend, _, err := s.storageRouter.ResolveForFile(ctx, old.StorageLocID, old.GroupID)
+	if err != nil || backend == nil {
+		return
+	}
+	if err := s.metadata.ReleaseReplaced(ctx, old.S3Key, newKey, old.StorageLocID, backend.DeleteObject); err != nil {
+		logging.Warn("failed to release replaced content",
+			zap.String("path", old.Path), zap.String("key", old.S3Key), zap.Error(err))
+	}
+}
+
+// handleDedupStats reports how much storage content deduplication saves.
+func (s *Server) handleDedupStats(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	stats, err := s.metadata.GetDedupStats(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/textindex"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// ─── Protocol frontends ─────────────────────────────────────────────────────
+
+// These methods let non-HTTP frontends (SFTP) share the server's tree view,
+// permission rules and change notifications.
+
+// StatVisible returns the tree node at path if the user can see it, applying
+// the same visibility and read rules as the tree endpoint. Returns nil if the
+// path does not exist or is hidden.
+func (s *Server) StatVisible(ctx context.Context, claims *auth.Claims, path string) *models.FileNode {
+	node, _, userGroups, userPerms := s.lookupVisible(ctx, claims, path)
+	if node == nil {
+		return nil
+	}
+	if !node.IsDir && !claims.IsAdmin && !s.checkAccessFast(node, claims, userGroups, userPerms) {
+		return nil
+	}
+	return copyNode(node)
+}
+
+// ListVisible returns the children of dir the user can see. ok is false if
+// dir does not exist, is not a directory or is hidden.
+func (s *Server) ListVisible(ctx context.Context, claims *auth.Claims, dir string) (children []*models.FileNode, ok bool) {
+	node, vis, userGroups, userPerms := s.lookupVisible(ctx, claims, dir)
+	if node == nil || !node.IsDir {
+		return nil, false
+	}
+	for _, child := range node.Children {
+		if !claims.IsAdmin {
+			if !s.permissions.CheckEffectiveVisibility(child, vis.Inherit(child), claims.UserID, false, userGroups) {
+				continue
+			}
+			if !child.IsDir && !s.checkAccessFast(child, claims, userGroups, userPerms) {
+				continue
+			}
+		}
+		children = append(children, copyNode(child))
+	}
+	return children, true
+}
+
+// lookupVisible walks the tree to path, checking visibility of every node
+// on the way. The node's effective visibility and the permission maps are
+// returned for further checks.
+func (s *Server) lookupVisible(ctx context.Context, claims *auth.Claims, path string) (*models.FileNode, sharing.Visibility, map[int]string, map[string]string) {
+	node := s.currentTree()
+	if node == nil || claims == nil {
+		return nil, sharing.Visibility{}, nil, nil
+	}
+	vis := sharing.Visibility{}.Inherit(node)
+
+	var userGroups map[int]string
+	var userPerms map[string]string
+	if !claims.IsAdmin {
+		userGroups, _ = s.groups.GetUserGroupsMap(ctx, claims.UserID)
+		if userGroups == nil {
+			userGroups = make(map[int]string)
+		}
+		userPerms, _ = s.permissions.GetUserPermissionsMap(ctx, claims.UserID)
+		if userPerms == nil {
+			userPerms = make(map[string]string)
+		}
+	}
+
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var next *models.FileNode
+		for _, child := range node.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, sharing.Visibility{}, nil, nil
+		}
+		vis = vis.Inherit(next)
+		if !claims.IsAdmin && !s.permissions.CheckEffectiveVisibility(next, vis, claims.UserID, false, userGroups) {
+			return nil, sharing.Visibility{}, nil, nil
+		}
+		node = next
+	}
+	return node, vis, userGroups, userPerms
+}
+
+// ResolveAlias returns the path p stands for if it is an alias or lies
+// below one, and whether it does. Frontends read, write and check
+// permissions at the resolved path, as the HTTP handlers do.
+func (s *Server) ResolveAlias(p string) (string, bool) {
+	return s.resolveAlias(p)
+}
+
+// Uploads returns the upload service shared with the other frontends.
+func (s *Server) Uploads() *upload.Service {
+	return s.uploads
+}
+
+// EnsureParentDirs creates missing parent directories of path.
+func (s *Server) EnsureParentDirs(ctx context.Context, path string) error {
+	return s.ensureParentDirs(ctx, path)
+}
+
+// NotifyChange updates the tree after a change made outside the HTTP API,
+// publishes the SSE event and queues gallery/content indexing for new or
+// modified files.
+func (s *Server) NotifyChange(ctx context.Context, eventType, path string, version int, hash string, size int64, claims *auth.Claims) {
+	if eventType == events.EventCreate && hash == "" {
+		// A directory, possibly moved here with its contents
+		s.RefreshTree(ctx)
+	} else {
+		s.updateTree(ctx, path)
+	}
+
+	var userID int
+	var username string
+	if claims != nil {
+		userID = claims.UserID
+		username = claims.Username
+	}
+	s.publishEvent(ctx, eventType, path, version, hash, size, userID, username)
+
+	if eventType != events.EventCreate && eventType != events.EventModify || hash == "" {
+		return
+	}
+	if s.processor != nil && gallery.IsMediaFile(path) {
+		s.processor.Enqueue(path)
+	}
+	if s.textIndexer != nil && textindex.IsIndexable(path) {
+		s.textIndexer.Enqueue(path)
+	}
+}
+
+// RecordActivity adds an action made outside the HTTP API to the activity log.
+func (s *Server) RecordActivity(claims *auth.Claims, action, path string, details map[string]interface{}) {
+	s.recordActivity(context.Background(), claims, action, path, details)
+}
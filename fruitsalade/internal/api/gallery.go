@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
@@ -73,10 +75,13 @@ func (s *Server) handleGallerySearch(w http.ResponseWriter, r *http.Request) {
 		CameraModel: q.Get("camera_model"),
 		Country:     q.Get("country"),
 		City:        q.Get("city"),
+		MediaType:   q.Get("media_type"),
 		SortBy:      q.Get("sort_by"),
 		SortOrder:   q.Get("sort_order"),
 		UserID:      claims.UserID,
 		IsAdmin:     claims.IsAdmin,
+
+		CollapseStacks: q.Get("collapse_stacks") == "true",
 	}
 
 	if limit, err := strconv.Atoi(q.Get("limit")); err == nil {
@@ -137,25 +142,7 @@ func (s *Server) handleGallerySearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, r := range results {
-		item := protocol.GalleryItem{
-			FilePath:     r.FilePath,
-			FileName:     r.FileName,
-			Size:         r.Size,
-			ModTime:      r.ModTime,
-			Hash:         r.Hash,
-			Width:        r.Width,
-			Height:       r.Height,
-			CameraMake:   r.CameraMake,
-			CameraModel:  r.CameraModel,
-			DateTaken:    r.DateTaken,
-			Latitude:     r.Latitude,
-			Longitude:    r.Longitude,
-			LocationCity: r.LocationCity,
-			Country:      r.LocationCountry,
-			HasThumbnail: r.HasThumbnail,
-			Tags:         tagMap[r.FilePath],
-		}
-		resp.Items = append(resp.Items, item)
+		resp.Items = append(resp.Items, galleryItem(r, tagMap[r.FilePath]))
 	}
 
 	if resp.Items == nil {
@@ -166,6 +153,36 @@ func (s *Server) handleGallerySearch(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// galleryItem converts a search result to its API form.
+func galleryItem(r gallery.SearchResult, tags []string) protocol.GalleryItem {
+	item := protocol.GalleryItem{
+		FilePath:     r.FilePath,
+		FileName:     r.FileName,
+		Size:         r.Size,
+		ModTime:      r.ModTime,
+		Hash:         r.Hash,
+		Width:        r.Width,
+		Height:       r.Height,
+		CameraMake:   r.CameraMake,
+		CameraModel:  r.CameraModel,
+		DateTaken:    r.DateTaken,
+		Latitude:     r.Latitude,
+		Longitude:    r.Longitude,
+		LocationCity: r.LocationCity,
+		Country:      r.LocationCountry,
+		HasThumbnail: r.HasThumbnail,
+		MediaType:    r.MediaType,
+		Duration:     r.Duration,
+		Tags:         tags,
+		StackID:      r.StackID,
+	}
+	// A stack of which only one photo matches needs no badge
+	if r.StackCount > 1 {
+		item.StackCount = r.StackCount
+	}
+	return item
+}
+
 // ─── Gallery Thumbnail ──────────────────────────────────────────────────────
 
 func (s *Server) handleGalleryThumb(w http.ResponseWriter, r *http.Request) {
@@ -184,6 +201,14 @@ func (s *Server) handleGalleryThumb(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.serveGalleryThumb(w, r, filePath, galleryThumbCacheControl)
+}
+
+// galleryThumbCacheControl lets browsers keep gallery thumbnails a day.
+const galleryThumbCacheControl = "public, max-age=86400"
+
+// serveGalleryThumb writes the gallery thumbnail of filePath.
+func (s *Server) serveGalleryThumb(w http.ResponseWriter, r *http.Request, filePath, cacheControl string) {
 	thumbKey := s.galleryStore.GetThumbKey(r.Context(), filePath)
 	if thumbKey == "" {
 		s.sendError(w, http.StatusNotFound, "no thumbnail")
@@ -205,7 +230,7 @@ func (s *Server) handleGalleryThumb(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "image/jpeg")
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
-	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Cache-Control", cacheControl)
 	io.Copy(w, reader)
 }
 
@@ -241,6 +266,8 @@ func (s *Server) handleGalleryMetadata(w http.ResponseWriter, r *http.Request) {
 	resp := protocol.GalleryMetadataResponse{
 		FilePath:        meta.FilePath,
 		FileName:        strings.TrimPrefix(meta.FilePath, "/"),
+		MediaType:       meta.MediaType,
+		Duration:        meta.Duration,
 		Width:           meta.Width,
 		Height:          meta.Height,
 		CameraMake:      meta.CameraMake,
@@ -264,7 +291,7 @@ func (s *Server) handleGalleryMetadata(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get file size from the tree
-	node := s.findNode(s.tree, filePath)
+	node := s.findNode(s.currentTree(), filePath)
 	if node != nil {
 		resp.Size = node.Size
 		resp.FileName = node.Name
@@ -440,12 +467,13 @@ func (s *Server) handleAddTag(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
-	if req.Tag == "" {
-		s.sendError(w, http.StatusBadRequest, "tag is required")
+	tag, err := gallery.NormalizeTag(req.Tag)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := s.galleryStore.AddTag(r.Context(), filePath, req.Tag, "manual", 1.0); err != nil {
+	if err := s.galleryStore.AddTag(r.Context(), filePath, tag, "manual", 1.0); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to add tag: "+err.Error())
 		return
 	}
@@ -454,7 +482,7 @@ func (s *Server) handleAddTag(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"file_path": filePath,
-		"tag":       req.Tag,
+		"tag":       tag,
 	})
 }
 
@@ -472,11 +500,15 @@ func (s *Server) handleRemoveTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tag := r.URL.Query().Get("tag")
-	if tag == "" {
+	if r.URL.Query().Get("tag") == "" {
 		s.sendError(w, http.StatusBadRequest, "tag query parameter required")
 		return
 	}
+	tag, err := gallery.NormalizeTag(r.URL.Query().Get("tag"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if err := s.galleryStore.RemoveTag(r.Context(), filePath, tag); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to remove tag: "+err.Error())
@@ -536,6 +568,7 @@ func (s *Server) handleGalleryStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(protocol.GalleryStatsResponse{
 		TotalImages: stats.TotalImages,
+		Videos:      stats.Videos,
 		WithGPS:     stats.WithGPS,
 		WithTags:    stats.WithTags,
 		Processed:   stats.Processed,
@@ -611,6 +644,10 @@ func (s *Server) handleCreatePlugin(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusBadRequest, "name and webhook_url are required")
 		return
 	}
+	if _, err := gallery.ParsePluginOptions(req.WebhookURL, req.Config, s.config.GalleryPluginDir); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	plugin := &gallery.Plugin{
 		Name:       req.Name,
@@ -661,6 +698,10 @@ func (s *Server) handleUpdatePlugin(w http.ResponseWriter, r *http.Request) {
 	if req.Config != nil {
 		existing.Config = req.Config
 	}
+	if _, err := gallery.ParsePluginOptions(existing.WebhookURL, existing.Config, s.config.GalleryPluginDir); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if err := s.galleryStore.UpdatePlugin(r.Context(), existing); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to update plugin: "+err.Error())
@@ -715,13 +756,14 @@ func (s *Server) handleTestPlugin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := s.pluginCaller.TestPlugin(r.Context(), *plugin)
+	resp, latency, err := s.pluginCaller.TestPlugin(r.Context(), *plugin)
 	if err != nil {
 		s.galleryStore.UpdatePluginHealth(r.Context(), id, err.Error())
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
+			"success":    false,
+			"error":      err.Error(),
+			"latency_ms": latency.Milliseconds(),
 		})
 		return
 	}
@@ -730,8 +772,9 @@ func (s *Server) handleTestPlugin(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"tags":    resp.Tags,
+		"success":    true,
+		"tags":       resp.Tags,
+		"latency_ms": latency.Milliseconds(),
 	})
 }
 
@@ -743,7 +786,7 @@ func (s *Server) handleReprocessGallery(w http.ResponseWriter, r *http.Request)
 	// Reset all to pending and re-enqueue
 	db := s.auth.DB()
 	_, err := db.ExecContext(r.Context(),
-		`UPDATE image_metadata SET status = 'pending', updated_at = NOW()`)
+		`UPDATE image_metadata SET status = 'pending', attempts = 0, last_error = '', next_retry_at = NULL, updated_at = NOW()`)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to reset: "+err.Error())
 		return
@@ -759,6 +802,328 @@ func (s *Server) handleReprocessGallery(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// handleGalleryFailures lists files the processor gave up on.
+func (s *Server) handleGalleryFailures(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	failures, err := s.galleryStore.ListFailures(r.Context(), 1000)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list failures: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failures)
+}
+
+// handleRetryGalleryFailures requeues the given failed files with a fresh
+// set of attempts.
+func (s *Server) handleRetryGalleryFailures(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	var req protocol.GalleryRetryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Paths) == 0 {
+		s.sendError(w, http.StatusBadRequest, "paths is required")
+		return
+	}
+
+	requeued, err := s.processor.Retry(r.Context(), req.Paths)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to requeue: "+err.Error())
+		return
+	}
+
+	logging.Info("gallery: failed files requeued by admin", zap.Int("count", len(requeued)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.GalleryRetryResponse{Requeued: len(requeued)})
+}
+
+// ─── Duplicates ─────────────────────────────────────────────────────────────
+
+// duplicateDistance returns the Hamming distance to use for duplicate
+// grouping: the request override if valid, else the configured default.
+func (s *Server) duplicateDistance(override *int) int {
+	if override != nil && *override >= 0 && *override <= gallery.MaxDuplicateDistance {
+		return *override
+	}
+	return s.settings.Get().GalleryDuplicateDistance
+}
+
+func (s *Server) handleGalleryDuplicates(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var override *int
+	if v := r.URL.Query().Get("distance"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > gallery.MaxDuplicateDistance {
+			s.sendError(w, http.StatusBadRequest, "distance must be between 0 and "+strconv.Itoa(gallery.MaxDuplicateDistance))
+			return
+		}
+		override = &d
+	}
+	distance := s.duplicateDistance(override)
+
+	pf := s.galleryPermFilter(r.Context(), claims)
+	images, err := s.galleryStore.ListHashedImages(r.Context(), pf)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list images: "+err.Error())
+		return
+	}
+
+	groups := gallery.GroupDuplicates(images, distance)
+	resp := protocol.GalleryDuplicatesResponse{
+		Groups:      make([]protocol.DuplicateGroup, 0, len(groups)),
+		TotalGroups: len(groups),
+		MaxDistance: distance,
+	}
+	for _, g := range groups {
+		keepHash := g.Images[0].Hash
+		group := protocol.DuplicateGroup{
+			Keep:        g.Keep,
+			WastedBytes: g.WastedBytes,
+		}
+		for _, img := range g.Images {
+			group.Items = append(group.Items, protocol.DuplicateImage{
+				FilePath:     img.FilePath,
+				FileName:     path.Base(img.FilePath),
+				Size:         img.Size,
+				Width:        img.Width,
+				Height:       img.Height,
+				DateTaken:    img.DateTaken,
+				HasThumbnail: img.HasThumbnail,
+				Distance:     gallery.HammingDistance(keepHash, img.Hash),
+			})
+		}
+		resp.Groups = append(resp.Groups, group)
+		resp.TotalWastedBytes += g.WastedBytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleResolveDuplicates(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var req protocol.ResolveDuplicatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Trash) == 0 {
+		s.sendError(w, http.StatusBadRequest, "trash list is required")
+		return
+	}
+
+	// Re-group with the caller's view of the library so that only actual
+	// duplicates can be trashed and every group keeps at least one copy.
+	pf := s.galleryPermFilter(r.Context(), claims)
+	images, err := s.galleryStore.ListHashedImages(r.Context(), pf)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list images: "+err.Error())
+		return
+	}
+	groups := gallery.GroupDuplicates(images, s.duplicateDistance(req.MaxDistance))
+
+	groupOf := make(map[string]int)
+	sizeOf := make(map[string]int64)
+	for gi, g := range groups {
+		for _, img := range g.Images {
+			groupOf[img.FilePath] = gi
+			sizeOf[img.FilePath] = img.Size
+		}
+	}
+
+	resp := protocol.ResolveDuplicatesResponse{
+		Trashed: []string{},
+		Failed:  make(map[string]string),
+	}
+	requested := make(map[int]int)
+	var toTrash []string
+	seen := make(map[string]bool)
+	for _, p := range req.Trash {
+		p = "/" + strings.TrimPrefix(p, "/")
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		gi, ok := groupOf[p]
+		if !ok {
+			resp.Failed[p] = "not part of a duplicate group"
+			continue
+		}
+		requested[gi]++
+		toTrash = append(toTrash, p)
+	}
+	for gi, n := range requested {
+		if n >= len(groups[gi].Images) {
+			s.sendError(w, http.StatusBadRequest, "request would trash every copy of a duplicate group (suggested keep: "+groups[gi].Keep+")")
+			return
+		}
+	}
+
+	for _, p := range toTrash {
+		if !claims.IsAdmin {
+			ownerID, hasOwner := s.permissions.GetOwnerID(r.Context(), p)
+			if hasOwner && ownerID != claims.UserID &&
+				!s.permissions.CheckAccess(r.Context(), claims.UserID, p, "owner", false) {
+				resp.Failed[p] = "only the owner or admin can delete"
+				continue
+			}
+		}
+		fileRow, err := s.metadata.GetFileRow(r.Context(), p)
+		if err != nil || fileRow == nil {
+			resp.Failed[p] = "file not found"
+			continue
+		}
+		if fileRow.StorageLocID != nil && s.storageRouter.IsReadOnly(*fileRow.StorageLocID) {
+			resp.Failed[p] = "storage location is read-only"
+			continue
+		}
+		if err := s.metadata.SoftDeleteFile(r.Context(), p, claims.UserID); err != nil {
+			resp.Failed[p] = "failed to delete: " + err.Error()
+			continue
+		}
+		resp.Trashed = append(resp.Trashed, p)
+		resp.FreedBytes += sizeOf[p]
+		s.publishEvent(r.Context(), events.EventDelete, p, 0, "", 0, claims.UserID, claims.Username)
+	}
+
+	if len(resp.Trashed) > 0 {
+		s.updateTree(r.Context(), resp.Trashed...)
+		logging.Info("gallery: duplicates moved to trash",
+			zap.String("user", claims.Username),
+			zap.Int("count", len(resp.Trashed)),
+			zap.Int64("freed_bytes", resp.FreedBytes))
+	}
+	if len(resp.Failed) == 0 {
+		resp.Failed = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ─── Burst Stacks ───────────────────────────────────────────────────────────
+
+// handleGalleryStack lists the photos of a burst stack the caller can see.
+func (s *Server) handleGalleryStack(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	stackID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid stack ID")
+		return
+	}
+
+	members, err := s.galleryStore.ListStack(r.Context(), stackID, s.galleryPermFilterAt(r.Context(), claims, 2))
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list stack: "+err.Error())
+		return
+	}
+	if len(members) == 0 {
+		s.sendError(w, http.StatusNotFound, "stack not found")
+		return
+	}
+
+	var paths []string
+	for _, m := range members {
+		paths = append(paths, m.FilePath)
+	}
+	tagMap, _ := s.galleryStore.GetTagsForFiles(r.Context(), paths)
+
+	resp := protocol.GalleryStackResponse{StackID: stackID}
+	for _, m := range members {
+		resp.Items = append(resp.Items, galleryItem(m, tagMap[m.FilePath]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGalleryUnstack takes a photo out of its burst stack for good.
+func (s *Server) handleGalleryUnstack(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	stackID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid stack ID")
+		return
+	}
+
+	var req protocol.UnstackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		s.sendError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	filePath := "/" + strings.TrimPrefix(req.Path, "/")
+	if !s.permissions.CheckAccess(r.Context(), claims.UserID, filePath, "write", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	ok, err := s.galleryStore.Unstack(r.Context(), stackID, filePath, s.processor.StackWindow())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to unstack: "+err.Error())
+		return
+	}
+	if !ok {
+		s.sendError(w, http.StatusNotFound, filePath+" is not in stack "+strconv.Itoa(stackID))
+		return
+	}
+
+	logging.Info("gallery: photo taken out of its stack",
+		zap.String("path", filePath), zap.Int("stack_id", stackID), zap.String("user", claims.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":      filePath,
+		"unstacked": true,
+	})
+}
+
+// handleRestackGallery recomputes all burst stacks.
+func (s *Server) handleRestackGallery(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	window := s.processor.StackWindow()
+	if window <= 0 {
+		s.sendError(w, http.StatusConflict, "burst stacks are disabled (GALLERY_STACK_WINDOW=0)")
+		return
+	}
+
+	photos, stacks, err := s.galleryStore.RestackAll(r.Context(), window)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to restack: "+err.Error())
+		return
+	}
+
+	logging.Info("gallery: burst stacks rebuilt", zap.Int("photos", photos), zap.Int("stacks", stacks))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.RestackResponse{Photos: photos, Stacks: stacks})
+}
+
 // ─── Custom Albums ──────────────────────────────────────────────────────────
 
 func (s *Server) handleListUserAlbums(w http.ResponseWriter, r *http.Request) {
@@ -985,6 +1350,10 @@ func (s *Server) handleAddImageToAlbum(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.galleryStore.AddImageToAlbum(r.Context(), id, req.FilePath); err != nil {
+		if err == gallery.ErrNotMedia {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		s.sendError(w, http.StatusInternalServerError, "failed to add image: "+err.Error())
 		return
 	}
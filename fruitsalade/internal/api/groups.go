@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
@@ -189,6 +192,46 @@ func (s *Server) handleMoveGroup(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleProvisionGroup(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	groupID, err := strconv.Atoi(r.PathValue("groupID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+	if s.provisioner == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "provisioning is not available")
+		return
+	}
+
+	group, err := s.groups.GetGroup(r.Context(), groupID)
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, "group not found")
+		return
+	}
+
+	report, err := s.provisioner.RepairGroup(r.Context(), group)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to provision group: "+err.Error())
+		return
+	}
+	if len(report.Created) > 0 {
+		s.RefreshTree(r.Context())
+	}
+
+	logging.Info("group provisioning repaired",
+		zap.Int("group_id", groupID),
+		zap.Int("created", len(report.Created)),
+		zap.Int("present", len(report.Present)),
+		zap.Int("errors", len(report.Errors)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
 // ─── Admin: Group Members ───────────────────────────────────────────────────
 
 func (s *Server) handleListGroupMembers(w http.ResponseWriter, r *http.Request) {
@@ -381,7 +424,8 @@ func (s *Server) handleSetGroupPermission(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if s.requireGroupAdmin(w, r, groupID) == nil {
+	claims := s.requireGroupAdmin(w, r, groupID)
+	if claims == nil {
 		return
 	}
 
@@ -397,15 +441,30 @@ func (s *Server) handleSetGroupPermission(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := s.groups.SetPermission(r.Context(), groupID, path, req.Permission); err != nil {
+	if err := s.groups.SetPermission(r.Context(), groupID, path, req.Permission, claims.UserID); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to set permission: "+err.Error())
 		return
 	}
+	if members, err := s.groups.ListMembers(r.Context(), groupID); err == nil {
+		grant := &protocol.GrantPayload{Permission: req.Permission, GroupID: groupID}
+		if g, err := s.groups.GetGroup(r.Context(), groupID); err == nil {
+			grant.GroupName = g.Name
+		}
+		recipients := make([]int, len(members))
+		for i, m := range members {
+			recipients[i] = m.UserID
+		}
+		s.notifyPermissionGranted(claims, path, grant, recipients...)
+	}
 
 	logging.Info("group permission set",
 		zap.Int("group_id", groupID),
 		zap.String("path", path),
 		zap.String("permission", req.Permission))
+	s.recordActivity(r.Context(), claims, activity.ActionPermissionSet, path, map[string]interface{}{
+		"group_id":   groupID,
+		"permission": req.Permission,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -434,6 +493,9 @@ func (s *Server) handleDeleteGroupPermission(w http.ResponseWriter, r *http.Requ
 	}
 
 	logging.Info("group permission removed", zap.Int("group_id", groupID), zap.String("path", path))
+	s.recordActivity(r.Context(), auth.GetClaims(r.Context()), activity.ActionPermissionRemove, path, map[string]interface{}{
+		"group_id": groupID,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -443,6 +505,102 @@ func (s *Server) handleDeleteGroupPermission(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// ─── Admin: Group Share Links ───────────────────────────────────────────────
+//
+// Group admins can see and revoke the share links that expose their group's
+// folder, whoever created them.
+
+// groupFolder returns the folder of a group, answering the request with an
+// error if there is none.
+func (s *Server) groupFolder(w http.ResponseWriter, r *http.Request, groupID int) (string, bool) {
+	group, err := s.groups.GetGroup(r.Context(), groupID)
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, "group not found")
+		return "", false
+	}
+	folder, err := s.groups.GroupPath(r.Context(), group)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to resolve group folder: "+err.Error())
+		return "", false
+	}
+	return folder, true
+}
+
+func (s *Server) handleListGroupShareLinks(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("groupID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+
+	if s.requireGroupAdmin(w, r, groupID) == nil {
+		return
+	}
+	folder, ok := s.groupFolder(w, r, groupID)
+	if !ok {
+		return
+	}
+
+	activeOnly := r.URL.Query().Get("active") == "true"
+
+	links, err := s.shareLinks.ListUnderPath(r.Context(), folder, activeOnly)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list share links: "+err.Error())
+		return
+	}
+	if links == nil {
+		links = []sharing.ShareLinkWithUser{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+func (s *Server) handleRevokeGroupShareLink(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("groupID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+	linkID := r.PathValue("id")
+
+	claims := s.requireGroupAdmin(w, r, groupID)
+	if claims == nil {
+		return
+	}
+	folder, ok := s.groupFolder(w, r, groupID)
+	if !ok {
+		return
+	}
+
+	// Links outside the group's folder are reported as missing
+	link, err := s.shareLinks.GetByID(r.Context(), linkID)
+	if err != nil || (link.Path != folder && !strings.HasPrefix(link.Path, folder+"/")) {
+		s.sendError(w, http.StatusNotFound, "share link not found")
+		return
+	}
+
+	if err := s.shareLinks.Revoke(r.Context(), linkID); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to revoke: "+err.Error())
+		return
+	}
+
+	logging.Info("share link revoked by group admin",
+		zap.String("link_id", linkID),
+		zap.Int("group_id", groupID),
+		zap.String("by", claims.Username))
+	s.recordActivity(r.Context(), claims, activity.ActionShareRevoke, link.Path, map[string]interface{}{
+		"link_id":  linkID,
+		"group_id": groupID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      linkID,
+		"revoked": true,
+	})
+}
+
 // ─── Visibility ─────────────────────────────────────────────────────────────
 
 func (s *Server) handleGetVisibility(w http.ResponseWriter, r *http.Request) {
@@ -469,10 +627,20 @@ func (s *Server) handleGetVisibility(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// An empty visibility is inherited from the nearest ancestor
+	inherited := vis == ""
+	if inherited {
+		vis = s.inheritedVisibility(path).Value
+		if vis == "" {
+			vis = "public"
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"path":       path,
 		"visibility": vis,
+		"inherited":  inherited,
 	})
 }
 
@@ -504,7 +672,14 @@ func (s *Server) handleSetVisibility(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.permissions.SetVisibility(r.Context(), path, req.Visibility); err != nil {
+	var updated int64 = 1
+	var err error
+	if req.Recursive {
+		updated, err = s.permissions.SetVisibilityRecursive(r.Context(), path, req.Visibility)
+	} else {
+		err = s.permissions.SetVisibility(r.Context(), path, req.Visibility)
+	}
+	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to set visibility: "+err.Error())
 		return
 	}
@@ -512,11 +687,17 @@ func (s *Server) handleSetVisibility(w http.ResponseWriter, r *http.Request) {
 	// Refresh tree to pick up visibility change
 	s.RefreshTree(r.Context())
 
-	logging.Info("visibility set", zap.String("path", path), zap.String("visibility", req.Visibility))
+	logging.Info("visibility set",
+		zap.String("path", path),
+		zap.String("visibility", req.Visibility),
+		zap.Bool("recursive", req.Recursive),
+		zap.Int64("updated", updated))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"path":       path,
 		"visibility": req.Visibility,
+		"recursive":  req.Recursive,
+		"updated":    updated,
 	})
 }
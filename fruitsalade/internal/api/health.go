@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// Readiness check timeouts.
+const (
+	dbPingTimeout      = 2 * time.Second
+	storageHeadTimeout = 3 * time.Second
+)
+
+// healthSentinelKey is looked up in the default storage backend to prove it
+// answers. It does not need to exist.
+const healthSentinelKey = ".fruitsalade-health"
+
+// Check states.
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// healthCheck is the result of one readiness check.
+type healthCheck struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+
+	// Check-specific details
+	Location    string  `json:"location,omitempty"`
+	Backend     string  `json:"backend,omitempty"`
+	Subscribers *int    `json:"subscribers,omitempty"`
+	AgeSeconds  float64 `json:"age_seconds,omitempty"`
+	Items       int     `json:"items,omitempty"`
+}
+
+// readinessResponse is returned by GET /health/ready.
+type readinessResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]*healthCheck `json:"checks"`
+
+	// Maintenance is set while the server is read-only. The instance still
+	// serves reads, so it does not fail readiness.
+	Maintenance *protocol.MaintenanceStatus `json:"maintenance,omitempty"`
+}
+
+// handleHealthReady reports whether the instance can serve traffic: the
+// database answers, the default storage backend answers and the metadata
+// tree is loaded. Returns 503 when a critical check fails.
+func (s *Server) handleHealthReady(w http.ResponseWriter, r *http.Request) {
+	resp := readinessResponse{
+		Status: checkOK,
+		Checks: map[string]*healthCheck{
+			"database": s.checkDatabase(r.Context()),
+			"storage":  s.checkStorage(r.Context()),
+			"tree":     s.checkTree(),
+			"events":   s.checkEvents(),
+		},
+	}
+
+	if s.Draining() {
+		resp.Checks["shutdown"] = &healthCheck{Status: checkFail, Critical: true, Error: "server is shutting down"}
+	}
+	if st := s.maintenance.get(); st.ReadOnly {
+		resp.Maintenance = &st
+	}
+
+	code := http.StatusOK
+	for _, c := range resp.Checks {
+		if c.Critical && c.Status != checkOK {
+			resp.Status = checkFail
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) checkDatabase(ctx context.Context) *healthCheck {
+	c := &healthCheck{Status: checkOK, Critical: true}
+	if s.metadata == nil {
+		c.Status, c.Error = checkFail, "not configured"
+		return c
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	start := time.Now()
+	err := s.metadata.DB().PingContext(ctx)
+	c.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		c.Status, c.Error = checkFail, err.Error()
+	}
+	return c
+}
+
+func (s *Server) checkStorage(ctx context.Context) *healthCheck {
+	c := &healthCheck{Status: checkOK, Critical: true}
+	if s.storageRouter == nil {
+		c.Status, c.Error = checkFail, "not configured"
+		return c
+	}
+	backend, loc, err := s.storageRouter.GetDefault()
+	if err != nil {
+		c.Status, c.Error = checkFail, err.Error()
+		return c
+	}
+	c.Location, c.Backend = loc.Name, backend.Type()
+
+	ctx, cancel := context.WithTimeout(ctx, storageHeadTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err = backend.ObjectExists(ctx, healthSentinelKey)
+	c.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		c.Status, c.Error = checkFail, err.Error()
+	}
+	return c
+}
+
+func (s *Server) checkTree() *healthCheck {
+	c := &healthCheck{Status: checkOK, Critical: true}
+	tree := s.currentTree()
+	if tree == nil {
+		c.Status, c.Error = checkFail, "metadata tree not built"
+		return c
+	}
+	c.AgeSeconds = s.treeAge().Seconds()
+	c.Items = fstree.CountNodes(tree)
+	return c
+}
+
+func (s *Server) checkEvents() *healthCheck {
+	c := &healthCheck{Status: checkOK}
+	if s.broadcaster == nil {
+		c.Status, c.Error = checkFail, "not configured"
+		return c
+	}
+	n := s.broadcaster.Count()
+	c.Subscribers = &n
+	return c
+}
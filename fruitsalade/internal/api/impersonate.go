@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// handleImpersonate handles POST /api/v1/admin/impersonate/{userID}: a
+// short-lived token that sees and acts exactly as the user, for support.
+func (s *Server) handleImpersonate(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+	if claims.APIKeyID != 0 {
+		s.sendError(w, http.StatusForbidden, "api keys cannot impersonate")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	var ttl time.Duration
+	if s.config != nil {
+		ttl = s.config.ImpersonationTTL
+	}
+	token, target, err := s.auth.Impersonate(r.Context(), claims, userID, ttl)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			s.sendError(w, http.StatusNotFound, "user not found")
+		case errors.Is(err, auth.ErrCannotImpersonate):
+			s.sendError(w, http.StatusBadRequest, err.Error())
+		default:
+			s.sendError(w, http.StatusInternalServerError, "failed to impersonate: "+err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.ImpersonateResponse{
+		Token:        token,
+		ExpiresAt:    target.ExpiresAt.Time,
+		User:         protocol.Identity{UserID: target.UserID, Username: target.Username},
+		Impersonator: protocol.Identity{UserID: claims.UserID, Username: claims.Username},
+	})
+}
+
+// handleWhoAmI handles GET /api/v1/auth/whoami: the authenticated user
+// and, for impersonation tokens, the admin behind them.
+func (s *Server) handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	resp := protocol.WhoAmIResponse{
+		UserID:   claims.UserID,
+		Username: claims.Username,
+		IsAdmin:  claims.IsAdmin,
+	}
+	if claims.Impersonating() {
+		resp.Impersonator = &protocol.Identity{UserID: claims.ImpersonatorID, Username: claims.ImpersonatorName}
+	}
+	if claims.ExpiresAt != nil {
+		resp.ExpiresAt = &claims.ExpiresAt.Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// impersonationDeniedRoutes are the protected routes an impersonation token
+// may not use: changes to the account's credentials, sessions and quota,
+// user deletion, and starting another impersonation.
+var impersonationDeniedRoutes = map[string]bool{
+	"POST /api/v1/auth/refresh":                      true,
+	"POST /api/v1/auth/password":                     true,
+	"DELETE /api/v1/auth/sessions/{tokenID}":         true,
+	"POST /api/v1/auth/totp/setup":                   true,
+	"POST /api/v1/auth/totp/enable":                  true,
+	"POST /api/v1/auth/totp/disable":                 true,
+	"POST /api/v1/auth/totp/backup":                  true,
+	"POST /api/v1/auth/ssh-keys":                     true,
+	"DELETE /api/v1/auth/ssh-keys/{keyID}":           true,
+	"POST /api/v1/auth/apikeys":                      true,
+	"DELETE /api/v1/auth/apikeys/{keyID}":            true,
+	"PUT /api/v1/admin/quotas/{userID}":              true,
+	"DELETE /api/v1/admin/users/{userID}":            true,
+	"PUT /api/v1/admin/users/{userID}/password":      true,
+	"POST /api/v1/admin/impersonate/{userID}":        true,
+	"POST /api/v1/admin/users/force-password-change": true,
+}
+
+// impersonationScope rejects impersonation tokens on the routes in
+// impersonationDeniedRoutes, whatever the impersonated user may do.
+func (s *Server) impersonationScope(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := auth.GetClaims(r.Context())
+		if claims != nil && claims.Impersonating() {
+			if _, pattern := mux.Handler(r); impersonationDeniedRoutes[pattern] {
+				logging.Warn("impersonated request denied",
+					zap.String("route", pattern),
+					zap.String("user", claims.Username),
+					zap.String("admin", claims.ImpersonatorName))
+				s.sendError(w, http.StatusForbidden, "not allowed while impersonating")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// attributeImpersonator adds the admin behind an impersonation token to e
+// when e is an action of the user the request is authenticated as.
+func attributeImpersonator(ctx context.Context, e *activity.Entry) {
+	claims := auth.GetClaims(ctx)
+	if claims != nil && claims.Impersonating() && claims.UserID == e.UserID {
+		e.ImpersonatorID = claims.ImpersonatorID
+		e.ImpersonatorName = claims.ImpersonatorName
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// Read-only maintenance mode keeps files readable while refusing every
+// change to them, e.g. during a storage migration. Writes get 503 with
+// ErrCodeMaintenance; reads, logins, the event stream and the admin API
+// keep working so that an admin can carry out the migration and turn the
+// mode off again. The mode is saved with the runtime settings and so
+// survives a restart.
+
+// maintenanceSettingKey is the server_settings key the mode is saved under.
+const maintenanceSettingKey = "maintenance"
+
+// defaultMaintenanceMessage is sent with refused writes when the admin gave
+// no message.
+const defaultMaintenanceMessage = "server is in read-only maintenance mode"
+
+// ErrMaintenance is returned by WriteBlocked while the server is in
+// read-only maintenance mode.
+var ErrMaintenance = errors.New(defaultMaintenanceMessage)
+
+// maintenanceMode holds the current maintenance status.
+type maintenanceMode struct {
+	mu     sync.RWMutex
+	status protocol.MaintenanceStatus
+}
+
+func (m *maintenanceMode) get() protocol.MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+func (m *maintenanceMode) set(st protocol.MaintenanceStatus) {
+	m.mu.Lock()
+	m.status = st
+	m.mu.Unlock()
+}
+
+// Maintenance returns the current maintenance status.
+func (s *Server) Maintenance() protocol.MaintenanceStatus {
+	return s.maintenance.get()
+}
+
+// WriteBlocked returns an error wrapping ErrMaintenance with the admin's
+// message while the server is read-only, nil otherwise. For frontends that
+// do not go through the HTTP handler, such as SFTP.
+func (s *Server) WriteBlocked() error {
+	st := s.maintenance.get()
+	if !st.ReadOnly {
+		return nil
+	}
+	if st.Message == "" {
+		return ErrMaintenance
+	}
+	return fmt.Errorf("%w: %s", ErrMaintenance, st.Message)
+}
+
+// loadMaintenance restores the mode saved in server_settings, if any, and
+// removes it from saved so that the runtime settings do not see it.
+func (s *Server) loadMaintenance(saved map[string]json.RawMessage) {
+	raw, ok := saved[maintenanceSettingKey]
+	if !ok {
+		return
+	}
+	delete(saved, maintenanceSettingKey)
+	var st protocol.MaintenanceStatus
+	if err := json.Unmarshal(raw, &st); err != nil {
+		logging.Warn("ignoring saved maintenance mode", zap.Error(err))
+		return
+	}
+	s.maintenance.set(st)
+	if st.ReadOnly {
+		logging.Warn("server is in read-only maintenance mode", zap.String("message", st.Message))
+	}
+}
+
+// maintenanceEvent returns the event announcing st.
+func maintenanceEvent(st protocol.MaintenanceStatus) events.Event {
+	return events.Event{
+		Type:        events.EventMaintenance,
+		Timestamp:   time.Now().Unix(),
+		Maintenance: &st,
+	}
+}
+
+// maintenanceMiddleware refuses writes while the server is read-only.
+func (s *Server) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if st := s.maintenance.get(); st.ReadOnly && isWriteRequest(r) {
+			msg := st.Message
+			if msg == "" {
+				msg = defaultMaintenanceMessage
+			}
+			s.sendErrorCode(w, http.StatusServiceUnavailable, protocol.ErrCodeMaintenance, msg, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWriteRequest reports whether r may change files: a POST, PUT, PATCH or
+// DELETE to the API outside the auth and admin endpoints, or a WebDAV
+// request other than GET, HEAD, OPTIONS and PROPFIND.
+func isWriteRequest(r *http.Request) bool {
+	p := r.URL.Path
+	if p == "/webdav" || strings.HasPrefix(p, "/webdav/") {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			return false
+		}
+		return true
+	}
+	if !strings.HasPrefix(p, "/api/v1/") ||
+		strings.HasPrefix(p, "/api/v1/auth/") || strings.HasPrefix(p, "/api/v1/admin/") {
+		return false
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// ─── Admin: Maintenance ─────────────────────────────────────────────────────
+
+func (s *Server) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.maintenance.get())
+}
+
+func (s *Server) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+
+	var req protocol.MaintenanceStatus
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	st := protocol.MaintenanceStatus{ReadOnly: req.ReadOnly}
+	if req.ReadOnly {
+		st.Message = strings.TrimSpace(req.Message)
+		since := time.Now().UTC()
+		if cur := s.maintenance.get(); cur.ReadOnly && cur.Since != nil {
+			since = *cur.Since
+		}
+		st.Since = &since
+	}
+
+	value, _ := json.Marshal(st)
+	if !st.ReadOnly {
+		value = []byte("null")
+	}
+	if err := s.metadata.SaveServerSettings(r.Context(), map[string]json.RawMessage{maintenanceSettingKey: value}, claims.UserID); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to save maintenance mode: "+err.Error())
+		return
+	}
+	s.maintenance.set(st)
+
+	if s.broadcaster != nil {
+		s.broadcaster.Publish(maintenanceEvent(st))
+	}
+	s.recordActivity(r.Context(), claims, activity.ActionMaintenance, "", map[string]interface{}{
+		"readonly": st.ReadOnly,
+		"message":  st.Message,
+	})
+	logging.Info("maintenance mode changed",
+		zap.Bool("readonly", st.ReadOnly),
+		zap.String("message", st.Message),
+		zap.String("admin", claims.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
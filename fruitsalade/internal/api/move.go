@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// ─── Move ───────────────────────────────────────────────────────────────────
+//
+// POST /api/v1/move renames a file or directory in one database
+// transaction, so clients get an atomic rename instead of copy and delete.
+// The FUSE client maps rename(2) onto it: with Overwrite a file replaces
+// the file at the destination like an upload would, keeping the replaced
+// content as the previous version, and a directory replaces an empty
+// directory.
+
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var req protocol.MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.From == "" || req.To == "" {
+		s.sendError(w, http.StatusBadRequest, "from and to required")
+		return
+	}
+	if err := validateNewPath(req.To); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	from := path.Clean("/" + req.From)
+	to := path.Clean("/" + req.To)
+	if from == "/" || to == "/" {
+		s.sendError(w, http.StatusBadRequest, "cannot move root")
+		return
+	}
+	if strings.HasPrefix(to, from+"/") {
+		s.sendError(w, http.StatusBadRequest, "cannot move a directory into itself")
+		return
+	}
+	if err := s.checkAliasMove(from, to); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	if !s.permissions.CheckAccess(ctx, claims.UserID, from, "write", claims.IsAdmin) ||
+		!s.permissions.CheckAccess(ctx, claims.UserID, to, "write", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "write access denied")
+		return
+	}
+
+	root := s.currentTree()
+	src := s.findNode(root, from)
+	if src == nil {
+		s.sendError(w, http.StatusNotFound, "path not found: "+from)
+		return
+	}
+	if err := paths.ValidateSubtree(to, fstree.Height(src)); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	if from == to {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(protocol.MoveResponse{From: from, To: to, ID: src.ID, IsDir: src.IsDir, Version: src.Version})
+		return
+	}
+
+	row, err := s.metadata.GetFileRow(ctx, from)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if row == nil {
+		s.sendError(w, http.StatusNotFound, "path not found: "+from)
+		return
+	}
+	if row.StorageLocID != nil && s.storageRouter.IsReadOnly(*row.StorageLocID) {
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
+		return
+	}
+	if _, _, err := s.storageRouter.ResolveForUpload(ctx, to, nil); err != nil && errors.Is(err, storage.ErrReadOnlyStorage) {
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
+		return
+	}
+
+	// What is in the way: a live file or directory may be replaced, a
+	// trashed one keeps its path until it is purged or restored
+	var replaced *postgres.FileRow
+	if dst := s.findNode(root, to); dst != nil {
+		if !req.Overwrite {
+			s.sendError(w, http.StatusConflict, to+" already exists")
+			return
+		}
+		switch {
+		case dst.IsDir != row.IsDir:
+			s.sendError(w, http.StatusConflict, "cannot replace a file with a directory or a directory with a file")
+			return
+		case dst.IsDir && len(dst.Children) > 0:
+			s.sendError(w, http.StatusConflict, "directory not empty: "+to)
+			return
+		}
+		if replaced, err = s.metadata.GetFileRow(ctx, to); err != nil {
+			s.sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else if exists, err := s.metadata.PathExists(ctx, to); err != nil {
+		s.sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if exists {
+		s.sendError(w, http.StatusConflict, to+" is in the trash")
+		return
+	}
+
+	if err := s.ensureParentDirs(ctx, to); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to create parent dirs: "+err.Error())
+		return
+	}
+
+	version := row.Version
+	switch {
+	case replaced == nil:
+		err = s.metadata.MoveFile(ctx, from, to)
+	case replaced.IsDir:
+		// An empty directory: nothing to keep
+		if err = s.metadata.DeleteFile(ctx, to); err == nil {
+			err = s.metadata.MoveFile(ctx, from, to)
+		}
+	default:
+		s.saveReplacedVersion(ctx, replaced)
+		version, err = s.metadata.ReplaceFile(ctx, from, to)
+	}
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to move: "+err.Error())
+		return
+	}
+	s.relocateObject(ctx, to)
+	if replaced != nil && !replaced.IsDir {
+		if moved, _ := s.metadata.GetFileRow(ctx, to); moved != nil {
+			s.releaseReplaced(ctx, replaced, moved.S3Key)
+		}
+	}
+
+	if row.IsDir {
+		s.RefreshTree(ctx)
+	} else {
+		s.updateTree(ctx, from, to)
+	}
+
+	logging.Info("file moved",
+		zap.String("from", from), zap.String("to", to), zap.Bool("replaced", replaced != nil))
+	s.publishEvent(ctx, events.EventDelete, from, 0, "", 0, claims.UserID, claims.Username)
+	eventType := events.EventCreate
+	if replaced != nil {
+		eventType = events.EventModify
+	}
+	if row.IsDir {
+		s.publishEvent(ctx, eventType, to, 0, "", 0, claims.UserID, claims.Username)
+	} else {
+		s.publishEvent(ctx, eventType, to, version, row.Hash, row.Size, claims.UserID, claims.Username)
+	}
+	s.recordActivity(ctx, claims, activity.ActionMove, to, map[string]interface{}{"from": from, "replaced": replaced != nil})
+
+	resp := protocol.MoveResponse{From: from, To: to, ID: fileID(to), IsDir: row.IsDir, Replaced: replaced != nil}
+	if !row.IsDir {
+		resp.Version = version
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// saveReplacedVersion keeps the file a move is about to replace as a
+// version of its path, as an overwriting upload does. Deduplicated content
+// needs no copy: the version references the shared object.
+func (s *Server) saveReplacedVersion(ctx context.Context, old *postgres.FileRow) {
+	if old.Size == 0 {
+		return
+	}
+	if err := s.metadata.SaveVersion(ctx, old.Path); err != nil {
+		logging.Warn("failed to save version", zap.String("path", old.Path), zap.Error(err))
+		return
+	}
+	s.backupReplacedContent(ctx, old)
+}
+
+// backupReplacedContent copies the content of a file about to be replaced
+// to the key of its saved version. Deduplicated content needs no copy.
+func (s *Server) backupReplacedContent(ctx context.Context, old *postgres.FileRow) {
+	if old.Size == 0 || postgres.IsContentKey(old.S3Key) {
+		return
+	}
+	backend, _, err := s.storageRouter.ResolveForFile(ctx, old.StorageLocID, old.GroupID)
+	if err != nil || backend == nil {
+		return
+	}
+	versionKey := fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(old.Path, "/"), old.Version)
+	if err := backend.CopyObject(ctx, old.S3Key, versionKey); err != nil {
+		logging.Warn("failed to backup version content", zap.String("path", old.Path), zap.Error(err))
+	}
+}
+
+// checkMoveTarget validates to as the new path of from, including the depth
+// the contents of a directory would end up at and the aliases involved.
+func (s *Server) checkMoveTarget(from, to string) error {
+	if err := validateNewPath(to); err != nil {
+		return err
+	}
+	if err := s.checkAliasMove(from, to); err != nil {
+		return err
+	}
+	if src := s.findNode(s.currentTree(), from); src != nil {
+		return paths.ValidateSubtree(to, fstree.Height(src))
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Auto-Organize ──────────────────────────────────────────────────────────
+//
+// A directory can carry a rule like {"pattern": "{year}/{month}"}. Once the
+// gallery processor has read the date taken of a file uploaded directly into
+// that directory, the file is moved into the subdirectory the pattern names.
+// Dates are taken in UTC; EXIF times carry no zone and are read as UTC, so
+// they keep their wall-clock value.
+
+// organizeReplacer builds the replacer that fills in a pattern for t.
+func organizeReplacer(t time.Time) *strings.Replacer {
+	return strings.NewReplacer(
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+	)
+}
+
+// validateOrganizePattern checks that pattern expands to a path below the
+// rule's directory.
+func validateOrganizePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("pattern required")
+	}
+	expanded := organizeReplacer(time.Now()).Replace(pattern)
+	if strings.ContainsAny(expanded, "{}") {
+		return errors.New("pattern may only use {year}, {month} and {day}")
+	}
+	for _, seg := range strings.Split(expanded, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return errors.New("pattern must be a relative path without empty, . or .. segments")
+		}
+	}
+	return nil
+}
+
+// organizeTarget returns where rule puts the file at p, or false if the
+// file has no date taken and the rule leaves such files in place.
+func organizeTarget(rule *postgres.OrganizeRule, p string, modTime time.Time, taken *time.Time) (string, bool) {
+	if taken == nil {
+		if rule.Fallback != protocol.OrganizeFallbackModTime {
+			return "", false
+		}
+		taken = &modTime
+	}
+	sub := organizeReplacer(taken.UTC()).Replace(rule.Pattern)
+	return path.Join(rule.DirPath, sub, path.Base(p)), true
+}
+
+// autoOrganize is called by the gallery processor after it saved a file's
+// metadata and moves the file if its directory has a rule.
+func (s *Server) autoOrganize(ctx context.Context, meta *gallery.ImageMetadata) {
+	rule, err := s.metadata.GetOrganizeRule(ctx, path.Dir(meta.FilePath))
+	if err != nil {
+		logging.Warn("auto-organize: failed to get rule", zap.String("path", meta.FilePath), zap.Error(err))
+		return
+	}
+	if rule == nil {
+		return
+	}
+	if _, err := s.organizeFile(ctx, nil, rule, meta.FilePath, meta.DateTaken); err != nil {
+		logging.Warn("auto-organize failed", zap.String("path", meta.FilePath), zap.Error(err))
+	}
+}
+
+// organizeFile moves the file at p to where rule puts it and reports
+// whether it moved. claims is nil for moves made by the processor.
+func (s *Server) organizeFile(ctx context.Context, claims *auth.Claims, rule *postgres.OrganizeRule, p string, taken *time.Time) (bool, error) {
+	row, err := s.metadata.GetFileRow(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	if row == nil || row.IsDir {
+		return false, nil
+	}
+	target, ok := organizeTarget(rule, row.Path, row.ModTime, taken)
+	if !ok || target == row.Path {
+		return false, nil
+	}
+	if row.StorageLocID != nil && s.storageRouter.IsReadOnly(*row.StorageLocID) {
+		return false, errors.New("storage location is read-only")
+	}
+	if exists, err := s.metadata.PathExists(ctx, target); err != nil {
+		return false, err
+	} else if exists {
+		return false, fmt.Errorf("%s already exists", target)
+	}
+
+	if err := s.ensureParentDirs(ctx, target); err != nil {
+		return false, fmt.Errorf("create parent dirs: %w", err)
+	}
+	if err := s.metadata.MoveFile(ctx, row.Path, target); err != nil {
+		return false, err
+	}
+	s.relocateObject(ctx, target)
+	s.updateTree(ctx, row.Path, target)
+
+	var userID int
+	var username string
+	if claims != nil {
+		userID = claims.UserID
+		username = claims.Username
+	}
+	s.publishEvent(ctx, events.EventDelete, row.Path, 0, "", 0, userID, username)
+	s.publishEvent(ctx, events.EventCreate, target, row.Version, row.Hash, row.Size, userID, username)
+	s.recordActivity(ctx, claims, activity.ActionMove, target, map[string]interface{}{"from": row.Path, "via": "organize"})
+	return true, nil
+}
+
+// relocateObject moves the storage object of a moved file, or those below
+// a moved directory, so their keys match the new paths again. Shared
+// content is not keyed by path.
+func (s *Server) relocateObject(ctx context.Context, p string) {
+	row, err := s.metadata.GetFileRow(ctx, p)
+	if err != nil || row == nil {
+		return
+	}
+	if row.IsDir {
+		children, _ := s.metadata.ListDir(ctx, p)
+		for _, child := range children {
+			s.relocateObject(ctx, child.Path)
+		}
+		return
+	}
+	newKey, err := storage.KeyForPath(row.Path)
+	if err != nil {
+		logging.Warn("not moving object", zap.String("path", p), zap.Error(err))
+		return
+	}
+	if row.S3Key == "" || row.S3Key == newKey || postgres.IsContentKey(row.S3Key) {
+		return
+	}
+	backend, _, err := s.storageRouter.ResolveForFile(ctx, row.StorageLocID, row.GroupID)
+	if err != nil {
+		return
+	}
+	if err := backend.CopyObject(ctx, row.S3Key, newKey); err != nil {
+		logging.Warn("failed to move object", zap.String("path", p), zap.Error(err))
+		return
+	}
+	oldKey := row.S3Key
+	row.S3Key = newKey
+	if err := s.metadata.UpsertFile(ctx, row); err != nil {
+		logging.Warn("failed to update storage key", zap.String("path", p), zap.Error(err))
+		return
+	}
+	backend.DeleteObject(ctx, oldKey)
+}
+
+// organizeDir returns the directory of an organize request after checking
+// that it exists and the user may manage its rule: admins and the
+// directory's owner can.
+func (s *Server) organizeDir(w http.ResponseWriter, r *http.Request, claims *auth.Claims, dir string) (string, bool) {
+	dir = path.Clean("/" + dir)
+	if dir == "/" {
+		s.sendError(w, http.StatusBadRequest, "path required")
+		return "", false
+	}
+	node := s.findNode(s.currentTree(), dir)
+	if node == nil || !node.IsDir {
+		s.sendError(w, http.StatusNotFound, "directory not found: "+dir)
+		return "", false
+	}
+	if !claims.IsAdmin && node.OwnerID != claims.UserID {
+		s.sendError(w, http.StatusForbidden, "only an admin or the folder owner can organize a folder")
+		return "", false
+	}
+	return dir, true
+}
+
+func organizeRuleResponse(rule *postgres.OrganizeRule) protocol.OrganizeRuleResponse {
+	return protocol.OrganizeRuleResponse{
+		Path:      rule.DirPath,
+		Pattern:   rule.Pattern,
+		Fallback:  rule.Fallback,
+		UpdatedAt: rule.UpdatedAt,
+	}
+}
+
+func (s *Server) handleGetOrganizeRule(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	dir := path.Clean("/" + r.PathValue("path"))
+	if !s.permissions.CheckAccess(r.Context(), claims.UserID, dir, "read", claims.IsAdmin) {
+		s.sendError(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	rule, err := s.metadata.GetOrganizeRule(r.Context(), dir)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get rule: "+err.Error())
+		return
+	}
+	if rule == nil {
+		s.sendError(w, http.StatusNotFound, "no organize rule on "+dir)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(organizeRuleResponse(rule))
+}
+
+func (s *Server) handleSetOrganizeRule(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	dir, ok := s.organizeDir(w, r, claims, r.PathValue("path"))
+	if !ok {
+		return
+	}
+
+	var req protocol.OrganizeRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Pattern = strings.Trim(strings.TrimSpace(req.Pattern), "/")
+	if err := validateOrganizePattern(req.Pattern); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch req.Fallback {
+	case "":
+		req.Fallback = protocol.OrganizeFallbackKeep
+	case protocol.OrganizeFallbackKeep, protocol.OrganizeFallbackModTime:
+	default:
+		s.sendError(w, http.StatusBadRequest, "fallback must be keep or mod_time")
+		return
+	}
+
+	rule, err := s.metadata.SetOrganizeRule(r.Context(), dir, req.Pattern, req.Fallback, claims.UserID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to set rule: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(organizeRuleResponse(rule))
+}
+
+func (s *Server) handleDeleteOrganizeRule(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	dir, ok := s.organizeDir(w, r, claims, r.PathValue("path"))
+	if !ok {
+		return
+	}
+
+	found, err := s.metadata.DeleteOrganizeRule(r.Context(), dir)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to delete rule: "+err.Error())
+		return
+	}
+	if !found {
+		s.sendError(w, http.StatusNotFound, "no organize rule on "+dir)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":    dir,
+		"deleted": true,
+	})
+}
+
+// handleApplyOrganizeRule sorts the media already in a directory. The route
+// is POST /api/v1/organize/{path...}, whose path must end in /apply.
+func (s *Server) handleApplyOrganizeRule(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	raw, isApply := strings.CutSuffix(r.PathValue("path"), "/apply")
+	if !isApply {
+		s.sendError(w, http.StatusNotFound, "use POST /api/v1/organize/{path}/apply")
+		return
+	}
+	dir, ok := s.organizeDir(w, r, claims, raw)
+	if !ok {
+		return
+	}
+
+	rule, err := s.metadata.GetOrganizeRule(r.Context(), dir)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get rule: "+err.Error())
+		return
+	}
+	if rule == nil {
+		s.sendError(w, http.StatusNotFound, "no organize rule on "+dir)
+		return
+	}
+	children, err := s.metadata.ListDir(r.Context(), dir)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list directory: "+err.Error())
+		return
+	}
+
+	resp := protocol.OrganizeApplyResponse{}
+	for _, child := range children {
+		if child.IsDir || !gallery.IsMediaFile(child.Path) {
+			continue
+		}
+		if !claims.IsAdmin && !s.permissions.CheckAccess(r.Context(), claims.UserID, child.Path, "write", false) {
+			resp.Failed++
+			resp.Errors = append(resp.Errors, "access denied: "+child.Path)
+			continue
+		}
+
+		var taken *time.Time
+		if s.galleryStore != nil {
+			if meta, err := s.galleryStore.GetMetadata(r.Context(), child.Path); err == nil && meta != nil {
+				taken = meta.DateTaken
+			}
+		}
+		moved, err := s.organizeFile(r.Context(), claims, rule, child.Path, taken)
+		switch {
+		case err != nil:
+			resp.Failed++
+			resp.Errors = append(resp.Errors, child.Path+": "+err.Error())
+		case moved:
+			resp.Moved++
+		default:
+			resp.Skipped++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
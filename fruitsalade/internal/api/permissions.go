@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Bulk Permissions ───────────────────────────────────────────────────────
+
+// jobKindPermissions is the kind of the job events of explicit recursive
+// grants.
+const jobKindPermissions = "permissions"
+
+// setPermissionTree writes explicit grants for userID on path and all it
+// contains. Large trees take a while, so the progress is published to the
+// granting user as job events with the returned job ID.
+func (s *Server) setPermissionTree(ctx context.Context, claims *auth.Claims, userID int, path, permission string) (int, string, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	jobID := "perm-" + hex.EncodeToString(b)
+
+	publish := func(state string, progress float64, message string) {
+		if s.broadcaster == nil {
+			return
+		}
+		s.broadcaster.Publish(events.Event{
+			Type:      events.EventJob,
+			Path:      path,
+			Timestamp: time.Now().Unix(),
+			UserID:    claims.UserID,
+			Username:  claims.Username,
+			ForUserID: claims.UserID,
+			Job: &protocol.JobPayload{
+				ID:       jobID,
+				Kind:     jobKindPermissions,
+				State:    state,
+				Progress: progress,
+				Message:  message,
+			},
+		})
+	}
+
+	count, err := s.permissions.SetPermissionTree(ctx, userID, path, permission, claims.UserID, func(done, total int) {
+		if done < total {
+			publish("running", float64(done)/float64(total), "")
+		}
+	})
+	if err != nil {
+		publish("failed", 0, err.Error())
+		return 0, jobID, err
+	}
+	publish("done", 1, "")
+	return count, jobID, nil
+}
+
+// canManagePermissions reports whether the user may view and change the
+// permissions of path: admins and the path's owner can.
+func (s *Server) canManagePermissions(ctx context.Context, claims *auth.Claims, path string) bool {
+	if claims.IsAdmin {
+		return true
+	}
+	ownerID, hasOwner := s.permissions.GetOwnerID(ctx, path)
+	return hasOwner && ownerID == claims.UserID
+}
+
+// handleCopyPermissions clones the permissions of one path onto another.
+// Group permissions are copied only by admins, who are the only ones to
+// manage them.
+func (s *Server) handleCopyPermissions(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var req protocol.PermissionCopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	from := "/" + strings.Trim(req.From, "/")
+	to := "/" + strings.Trim(req.To, "/")
+	if req.From == "" || req.To == "" {
+		s.sendError(w, http.StatusBadRequest, "from and to required")
+		return
+	}
+	if from == to {
+		s.sendError(w, http.StatusBadRequest, "from and to are the same path")
+		return
+	}
+	tree := s.currentTree()
+	if s.findNode(tree, to) == nil {
+		s.sendError(w, http.StatusNotFound, "path not found: "+to)
+		return
+	}
+	if !s.canManagePermissions(r.Context(), claims, from) || !s.canManagePermissions(r.Context(), claims, to) {
+		s.sendError(w, http.StatusForbidden, "only the owner or admin can manage permissions")
+		return
+	}
+
+	copied, groupCount, err := s.permissions.CopyPermissions(r.Context(), from, to, claims.UserID, claims.IsAdmin)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to copy permissions: "+err.Error())
+		return
+	}
+
+	resp := protocol.PermissionCopyResponse{
+		From:             from,
+		To:               to,
+		Permissions:      make([]protocol.PermissionResponse, 0, len(copied)),
+		GroupPermissions: groupCount,
+	}
+	for _, p := range copied {
+		resp.Permissions = append(resp.Permissions, protocol.PermissionResponse{
+			UserID:     p.UserID,
+			Path:       p.Path,
+			Permission: p.Permission,
+			Recursive:  p.Recursive,
+		})
+		s.notifyPermissionGranted(claims, to, &protocol.GrantPayload{Permission: p.Permission}, p.UserID)
+	}
+
+	logging.Info("permissions copied",
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.Int("users", len(copied)),
+		zap.Int64("groups", groupCount))
+	s.recordActivity(r.Context(), claims, activity.ActionPermissionCopy, to, map[string]interface{}{
+		"from":              from,
+		"permissions":       len(copied),
+		"group_permissions": groupCount,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
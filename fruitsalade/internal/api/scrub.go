@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/scrub"
+)
+
+// ─── Admin: Integrity Scrubber ──────────────────────────────────────────────
+
+// handleStartScrub starts a scrub of the files at or below "prefix" and/or
+// on storage location "location_id"; an empty body scrubs everything.
+func (s *Server) handleStartScrub(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	if s.scrubber == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "integrity scrubber is not enabled")
+		return
+	}
+
+	var scope scrub.Scope
+	if err := json.NewDecoder(r.Body).Decode(&scope); err != nil && err != io.EOF {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if scope.Prefix != "" {
+		scope.Prefix = "/" + strings.Trim(scope.Prefix, "/")
+	}
+	if scope.LocationID != 0 && s.storageRouter.GetLocation(scope.LocationID) == nil {
+		s.sendError(w, http.StatusBadRequest, "storage location not found")
+		return
+	}
+
+	if err := s.scrubber.Trigger(scope); err != nil {
+		if errors.Is(err, scrub.ErrRunning) {
+			s.sendError(w, http.StatusConflict, "a scrub is already running")
+			return
+		}
+		s.sendError(w, http.StatusInternalServerError, "failed to start scrub: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.scrubber.Status())
+}
+
+func (s *Server) handleScrubStatus(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	if s.scrubber == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "integrity scrubber is not enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.scrubber.Status())
+}
+
+// handleListIntegrityIssues lists unrepaired integrity issues, newest
+// first. ?all=true includes repaired ones; ?limit= caps the list (default
+// 100, max 1000).
+func (s *Server) handleListIntegrityIssues(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 || v > 1000 {
+			s.sendError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = v
+	}
+	all := r.URL.Query().Get("all") == "true"
+
+	issues, err := s.metadata.ListIntegrityIssues(r.Context(), all, limit)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list integrity issues: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(issues)
+}
@@ -2,9 +2,9 @@
 package api
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,24 +19,36 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/config"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/cors"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/importer"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/scrub"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/textindex"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/thumbs"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
 	davpkg "github.com/fruitsalade/fruitsalade/fruitsalade/internal/webdav"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/webhooks"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/webapp"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
 )
 
 // Package-level compiled regex for Range header parsing.
@@ -52,22 +64,30 @@ type Server struct {
 	metadata      *postgres.Store
 	storageRouter *storage.Router
 	auth          *auth.Auth
-	tree          *models.FileNode
-	maxUploadSize int64
+	tree          *models.FileNode // replaced, never modified; see treeupdate.go
+	treeBuilt     time.Time
+	treeGen       uint64
+	aliases       map[string]string // alias path -> target path in tree; see aliases.go
+	treeMu        sync.RWMutex      // guards tree, treeBuilt, treeGen and aliases
+	treeUpdateMu  sync.Mutex        // serializes incremental tree updates
+	uploads       *upload.Service
 	config        *config.Config
+	settings      *config.Live // runtime settings; see admin config
 
 	// SSE
 	broadcaster *events.Broadcaster
 
 	// Sharing
-	permissions  *sharing.PermissionStore
-	shareLinks   *sharing.ShareLinkStore
-	groups       *sharing.GroupStore
-	provisioner  *sharing.Provisioner
+	permissions *sharing.PermissionStore
+	shareLinks  *sharing.ShareLinkStore
+	groups      *sharing.GroupStore
+	provisioner *sharing.Provisioner
 
 	// Quotas
-	quotaStore  *quota.QuotaStore
-	rateLimiter *quota.RateLimiter
+	quotaStore      *quota.QuotaStore
+	rateLimiter     *quota.RateLimiter
+	bandwidthExempt []string // path prefixes exempt from the bandwidth quota
+	compressTypes   []string // MIME types sent compressed; see compress.go
 
 	// Storage admin
 	locationStore *storage.LocationStore
@@ -77,8 +97,38 @@ type Server struct {
 	processor    *gallery.Processor
 	pluginCaller *gallery.PluginCaller
 
+	// Content search
+	textIndex   *textindex.Store
+	textIndexer *textindex.Processor
+
+	// Thumbnails for arbitrary file types
+	thumbnails *thumbs.Generator
+
+	// Integrity scrubber (nil = disabled)
+	scrubber *scrub.Scrubber
+
+	// Import of existing storage objects (nil = disabled)
+	importer *importer.Importer
+
+	// Re-wrapping of encrypted objects under a new key (nil = disabled)
+	rekeyer *storage.Rekeyer
+
+	// Outbound webhooks (nil = disabled)
+	webhookStore      *webhooks.Store
+	webhookDispatcher *webhooks.Dispatcher
+
 	// Chunked uploads
 	chunked *ChunkedUploadManager
+
+	// Activity log writer
+	recorder *activity.Recorder
+
+	// Read-only maintenance mode; see maintenance.go
+	maintenance maintenanceMode
+
+	// Graceful shutdown
+	draining atomic.Bool
+	inFlight atomic.Int64
 }
 
 // GalleryDeps bundles the gallery subsystem dependencies.
@@ -109,7 +159,7 @@ func NewServer(
 		metadata:      metadata,
 		storageRouter: storageRouter,
 		auth:          authHandler,
-		maxUploadSize: maxUploadSize,
+		uploads:       upload.NewService(metadata, storageRouter, permissions, quotaStore, maxUploadSize),
 		broadcaster:   broadcaster,
 		permissions:   permissions,
 		shareLinks:    shareLinks,
@@ -120,10 +170,29 @@ func NewServer(
 		config:        cfg,
 		locationStore: locationStore,
 	}
+	if cfg != nil {
+		s.bandwidthExempt = quota.ParseExemptPaths(cfg.BandwidthExemptPaths)
+		s.compressTypes = parseCompressTypes(cfg.ContentCompressTypes)
+		s.settings = config.NewLive(cfg.Settings())
+	} else {
+		s.settings = config.NewLive(config.Settings{LogLevel: "info", MaxUploadSize: maxUploadSize, GalleryDuplicateDistance: 4})
+	}
+	if s.shareLinks != nil {
+		s.shareLinks.SetLifetimePolicy(shareLifetimePolicy(s.settings.Get()))
+	}
+	s.settings.Subscribe(func(st config.Settings) {
+		s.uploads.SetMaxUploadSize(st.MaxUploadSize)
+		if s.shareLinks != nil {
+			s.shareLinks.SetLifetimePolicy(shareLifetimePolicy(st))
+		}
+	})
 	if galleryDeps != nil {
 		s.galleryStore = galleryDeps.Store
 		s.processor = galleryDeps.Processor
 		s.pluginCaller = galleryDeps.PluginCaller
+		if s.processor != nil {
+			s.processor.SetOnProcessed(s.autoOrganize)
+		}
 	}
 
 	// Initialize chunked upload manager
@@ -136,44 +205,125 @@ func NewServer(
 	return s
 }
 
-// Init initializes the server by building the metadata tree.
+// SetTextIndex enables content search and indexing of uploaded documents.
+func (s *Server) SetTextIndex(store *textindex.Store, processor *textindex.Processor) {
+	s.textIndex = store
+	s.textIndexer = processor
+}
+
+// SetThumbnails enables the generic thumbnail endpoint.
+func (s *Server) SetThumbnails(generator *thumbs.Generator) {
+	s.thumbnails = generator
+}
+
+// SetScrubber enables the integrity scrub admin endpoints.
+func (s *Server) SetScrubber(scrubber *scrub.Scrubber) {
+	s.scrubber = scrubber
+}
+
+// SetImporter enables the storage import admin endpoints. Imports that
+// add files refresh the tree.
+func (s *Server) SetImporter(imp *importer.Importer) {
+	s.importer = imp
+	imp.SetOnChange(func(ctx context.Context, st importer.Status) {
+		if err := s.RefreshTree(ctx); err != nil {
+			logging.Error("failed to refresh tree after import", zap.Error(err))
+			return
+		}
+		s.publishEvent(ctx, events.EventCreate, st.Request.PathPrefix, 0, "", 0, st.Request.UserID, st.Request.Username)
+	})
+}
+
+// SetRekeyer enables rekeying encrypted storage locations.
+func (s *Server) SetRekeyer(rk *storage.Rekeyer) {
+	s.rekeyer = rk
+}
+
+// SetWebhooks enables the webhook admin endpoints.
+func (s *Server) SetWebhooks(store *webhooks.Store, dispatcher *webhooks.Dispatcher) {
+	s.webhookStore = store
+	s.webhookDispatcher = dispatcher
+}
+
+// SetActivityRecorder enables writing the activity log.
+func (s *Server) SetActivityRecorder(recorder *activity.Recorder) {
+	s.recorder = recorder
+}
+
+// Settings returns the runtime settings, which admins can change without
+// a restart.
+func (s *Server) Settings() *config.Live {
+	return s.settings
+}
+
+// Init initializes the server by applying the saved settings and building
+// the metadata tree.
 func (s *Server) Init(ctx context.Context) error {
+	saved, err := s.metadata.ServerSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("load settings: %w", err)
+	}
+	s.loadMaintenance(saved)
+	if err := s.settings.Load(saved); err != nil {
+		logging.Warn("some saved settings were not applied", zap.Error(err))
+	}
+	if keys := s.settings.Overridden(); len(keys) > 0 {
+		logging.Info("applied saved settings", zap.Strings("keys", keys))
+	}
+
 	logging.Info("building metadata tree from database...")
 	tree, err := s.metadata.BuildTree(ctx)
 	if err != nil {
 		return fmt.Errorf("build tree: %w", err)
 	}
+	s.treeMu.Lock()
 	s.tree = tree
-	count := countNodes(tree)
+	s.aliases = treeAliases(tree)
+	s.treeBuilt = time.Now()
+	s.treeGen++
+	s.treeMu.Unlock()
+	count := fstree.CountNodes(tree)
 	metrics.SetMetadataTreeSize(int64(count))
 	logging.Info("metadata tree built", zap.Int("items", count))
 
 	// Start chunked upload cleanup
 	s.chunked.StartCleanup(ctx)
 
+	if s.config != nil {
+		s.startTreeRebuild(ctx, s.config.TreeRebuildInterval)
+	}
+	s.startTrashPurge(ctx, trashPurgeInterval)
+	s.startVersionPrune(ctx, versionPruneInterval)
+
 	return nil
 }
 
-// RefreshTree rebuilds the metadata tree.
+// RefreshTree rebuilds the metadata tree from the database. If an
+// incremental update lands while the rows are read, the rebuild starts over
+// so the update is not lost; after maxTreeRebuildRetries it is swapped in
+// anyway and the next rebuild catches up.
 func (s *Server) RefreshTree(ctx context.Context) error {
-	tree, err := s.metadata.BuildTree(ctx)
-	if err != nil {
-		return err
-	}
-	s.tree = tree
-	metrics.SetMetadataTreeSize(int64(countNodes(tree)))
-	return nil
-}
+	for attempt := 0; ; attempt++ {
+		gen := s.treeGeneration()
+		tree, err := s.metadata.BuildTree(ctx)
+		if err != nil {
+			return err
+		}
 
-func countNodes(node *models.FileNode) int {
-	if node == nil {
-		return 0
-	}
-	count := 1
-	for _, child := range node.Children {
-		count += countNodes(child)
+		s.treeMu.Lock()
+		if s.treeGen != gen && attempt < maxTreeRebuildRetries {
+			s.treeMu.Unlock()
+			continue
+		}
+		s.tree = tree
+		s.aliases = treeAliases(tree)
+		s.treeBuilt = time.Now()
+		s.treeGen++
+		s.treeMu.Unlock()
+
+		metrics.SetMetadataTreeSize(int64(fstree.CountNodes(tree)))
+		return nil
 	}
-	return count
 }
 
 // Handler returns the HTTP handler with auth and metrics middleware.
@@ -182,6 +332,8 @@ func (s *Server) Handler() http.Handler {
 
 	// Public endpoints (no auth required)
 	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /health/live", s.handleHealth)
+	mux.HandleFunc("GET /health/ready", s.handleHealthReady)
 	mux.HandleFunc("POST /api/v1/auth/token", s.auth.HandleLogin)
 	mux.HandleFunc("POST /api/v1/auth/device-code", s.handleDeviceCodeInit)
 	mux.HandleFunc("POST /api/v1/auth/device-token", s.handleDeviceCodePoll)
@@ -190,6 +342,14 @@ func (s *Server) Handler() http.Handler {
 	// Public share link endpoints (no auth)
 	mux.HandleFunc("GET /api/v1/share/{token}/info", s.handleShareInfo)
 	mux.HandleFunc("GET /api/v1/share/{token}", s.handleShareDownload)
+	mux.HandleFunc("GET /api/v1/share/{token}/files", s.handleShareFiles)
+	mux.HandleFunc("GET /api/v1/share/{token}/preview", s.handleSharePreview)
+	mux.HandleFunc("POST /api/v1/share/{token}/upload/{filename}", s.handleShareUpload)
+	if s.galleryStore != nil {
+		mux.HandleFunc("GET /api/v1/gallery/shared-album/{token}", s.handleSharedAlbum)
+		mux.HandleFunc("GET /api/v1/gallery/shared-album/{token}/thumb/{path...}", s.handleSharedAlbumThumb)
+		mux.HandleFunc("GET /api/v1/gallery/shared-album/{token}/content/{path...}", s.handleSharedAlbumContent)
+	}
 
 	// Web app (no auth — the app handles login via API)
 	// WEBAPP_DIR overrides embedded assets for live-reload during development
@@ -220,7 +380,7 @@ func (s *Server) Handler() http.Handler {
 	})
 
 	// WebDAV endpoint (has its own auth middleware)
-	davHandler := davpkg.NewHandler(s.metadata, s.storageRouter, s.auth)
+	davHandler := davpkg.NewHandler(s.metadata, s.storageRouter, s.auth, s.quotaStore, s.permissions, s, s.bandwidthExempt)
 	mux.Handle("/webdav/", davHandler)
 	mux.Handle("/webdav", davHandler)
 
@@ -234,8 +394,12 @@ func (s *Server) Handler() http.Handler {
 
 	// Write endpoints
 	protected.HandleFunc("POST /api/v1/content/{path...}", s.handleUpload)
+	protected.HandleFunc("POST /api/v1/upload-check", s.handleUploadCheck)
 	protected.HandleFunc("PUT /api/v1/tree/{path...}", s.handleCreateOrUpdate)
 	protected.HandleFunc("DELETE /api/v1/tree/{path...}", s.handleDelete)
+	protected.HandleFunc("POST /api/v1/move", s.handleMove)
+	protected.HandleFunc("POST /api/v1/copy", s.handleCopy)
+	protected.HandleFunc("POST /api/v1/alias", s.handleCreateAlias)
 
 	// Chunked upload endpoints
 	protected.HandleFunc("POST /api/v1/uploads/init", s.chunked.handleInitUpload)
@@ -248,14 +412,18 @@ func (s *Server) Handler() http.Handler {
 	protected.HandleFunc("GET /api/v1/versions", s.handleVersionedFiles)
 	protected.HandleFunc("GET /api/v1/versions/{path...}", s.handleVersions)
 	protected.HandleFunc("POST /api/v1/versions/{path...}", s.handleRollback)
+	protected.HandleFunc("DELETE /api/v1/versions/{path...}", s.handleDeleteVersion)
 
-	// SSE endpoint
+	// Event endpoints (SSE and WebSocket)
 	protected.HandleFunc("GET /api/v1/events", s.handleEvents)
+	protected.HandleFunc("GET /api/v1/ws", s.handleWebSocket)
 
 	// Permission endpoints
 	protected.HandleFunc("PUT /api/v1/permissions/{path...}", s.handleSetPermission)
 	protected.HandleFunc("GET /api/v1/permissions/{path...}", s.handleListPermissions)
 	protected.HandleFunc("DELETE /api/v1/permissions/{path...}", s.handleDeletePermission)
+	protected.HandleFunc("POST /api/v1/permissions/copy", s.handleCopyPermissions)
+	protected.HandleFunc("GET /api/v1/shared-with-me", s.handleSharedWithMe)
 
 	// Share link management endpoints
 	protected.HandleFunc("GET /api/v1/shares", s.handleListUserShares)
@@ -265,18 +433,37 @@ func (s *Server) Handler() http.Handler {
 	// Admin quota endpoints
 	protected.HandleFunc("GET /api/v1/admin/quotas/{userID}", s.handleGetQuota)
 	protected.HandleFunc("PUT /api/v1/admin/quotas/{userID}", s.handleSetQuota)
+	protected.HandleFunc("GET /api/v1/admin/ratelimit/top", s.handleRateLimitTop)
+	protected.HandleFunc("POST /api/v1/admin/tree/rebuild", s.handleTreeRebuild)
 
 	// Admin UI endpoints
 	protected.HandleFunc("GET /api/v1/admin/users", s.handleListUsers)
 	protected.HandleFunc("POST /api/v1/admin/users", s.handleCreateUser)
+	protected.HandleFunc("PUT /api/v1/admin/users/{userID}", s.handleUpdateUser)
 	protected.HandleFunc("DELETE /api/v1/admin/users/{userID}", s.handleDeleteUser)
+	protected.HandleFunc("GET /api/v1/admin/users/{userID}/footprint", s.handleUserFootprint)
 	protected.HandleFunc("PUT /api/v1/admin/users/{userID}/password", s.handleChangePassword)
+	protected.HandleFunc("POST /api/v1/admin/users/{userID}/force-password-change", s.handleForcePasswordChange)
+	protected.HandleFunc("POST /api/v1/admin/users/force-password-change", s.handleForcePasswordChangeAll)
+	protected.HandleFunc("POST /api/v1/admin/impersonate/{userID}", s.handleImpersonate)
 	protected.HandleFunc("GET /api/v1/admin/users/{userID}/groups", s.handleUserGroups)
 	protected.HandleFunc("GET /api/v1/admin/sharelinks", s.handleListShareLinks)
+	protected.HandleFunc("PUT /api/v1/admin/sharelinks/policy", s.handleShareLinkPolicy)
 	protected.HandleFunc("GET /api/v1/admin/stats", s.handleDashboardStats)
+	protected.HandleFunc("GET /api/v1/admin/stats/history", s.handleStatsHistory)
+	protected.HandleFunc("GET /api/v1/admin/activity", s.handleAdminActivity)
 	protected.HandleFunc("GET /api/v1/admin/storage-dashboard", s.handleStorageDashboard)
+	protected.HandleFunc("GET /api/v1/admin/dedup", s.handleDedupStats)
+	protected.HandleFunc("GET /api/v1/admin/sessions", s.handleListAllSessions)
+	protected.HandleFunc("GET /api/v1/admin/security/lockouts", s.handleListLockouts)
+	protected.HandleFunc("DELETE /api/v1/admin/security/lockouts/{kind}/{key}", s.handleClearLockout)
 	protected.HandleFunc("GET /api/v1/admin/config", s.handleGetConfig)
 	protected.HandleFunc("PUT /api/v1/admin/config", s.handleUpdateConfig)
+	protected.HandleFunc("GET /api/v1/admin/maintenance", s.handleGetMaintenance)
+	protected.HandleFunc("PUT /api/v1/admin/maintenance", s.handleSetMaintenance)
+	protected.HandleFunc("GET /api/v1/admin/version-retention", s.handleListRetentionOverrides)
+	protected.HandleFunc("PUT /api/v1/admin/version-retention/{path...}", s.handleSetRetentionOverride)
+	protected.HandleFunc("DELETE /api/v1/admin/version-retention/{path...}", s.handleDeleteRetentionOverride)
 
 	// Admin group endpoints
 	protected.HandleFunc("GET /api/v1/admin/groups", s.handleListGroups)
@@ -285,6 +472,7 @@ func (s *Server) Handler() http.Handler {
 	protected.HandleFunc("GET /api/v1/admin/groups/{groupID}", s.handleGetGroup)
 	protected.HandleFunc("DELETE /api/v1/admin/groups/{groupID}", s.handleDeleteGroup)
 	protected.HandleFunc("PUT /api/v1/admin/groups/{groupID}/parent", s.handleMoveGroup)
+	protected.HandleFunc("POST /api/v1/admin/groups/{groupID}/provision", s.handleProvisionGroup)
 	protected.HandleFunc("GET /api/v1/admin/groups/{groupID}/members", s.handleListGroupMembers)
 	protected.HandleFunc("POST /api/v1/admin/groups/{groupID}/members", s.handleAddGroupMember)
 	protected.HandleFunc("PUT /api/v1/admin/groups/{groupID}/members/{userID}/role", s.handleUpdateMemberRole)
@@ -292,6 +480,8 @@ func (s *Server) Handler() http.Handler {
 	protected.HandleFunc("GET /api/v1/admin/groups/{groupID}/permissions", s.handleListGroupPermissions)
 	protected.HandleFunc("PUT /api/v1/admin/groups/{groupID}/permissions/{path...}", s.handleSetGroupPermission)
 	protected.HandleFunc("DELETE /api/v1/admin/groups/{groupID}/permissions/{path...}", s.handleDeleteGroupPermission)
+	protected.HandleFunc("GET /api/v1/admin/groups/{groupID}/sharelinks", s.handleListGroupShareLinks)
+	protected.HandleFunc("DELETE /api/v1/admin/groups/{groupID}/sharelinks/{id}", s.handleRevokeGroupShareLink)
 
 	// Admin storage endpoints
 	protected.HandleFunc("GET /api/v1/admin/storage", s.handleListStorageLocations)
@@ -302,6 +492,29 @@ func (s *Server) Handler() http.Handler {
 	protected.HandleFunc("POST /api/v1/admin/storage/{id}/test", s.handleTestStorageLocation)
 	protected.HandleFunc("POST /api/v1/admin/storage/{id}/default", s.handleSetDefaultStorage)
 	protected.HandleFunc("GET /api/v1/admin/storage/{id}/stats", s.handleStorageStats)
+	protected.HandleFunc("POST /api/v1/admin/storage/{id}/rekey", s.handleRekeyStorageLocation)
+	protected.HandleFunc("GET /api/v1/admin/storage/{id}/rekey", s.handleRekeyStatus)
+	protected.HandleFunc("POST /api/v1/admin/storage/{id}/import", s.handleStartStorageImport)
+	protected.HandleFunc("GET /api/v1/admin/storage/{id}/import", s.handleStorageImportStatus)
+
+	// Admin: integrity scrubber
+	protected.HandleFunc("POST /api/v1/admin/scrub", s.handleStartScrub)
+	protected.HandleFunc("GET /api/v1/admin/scrub/status", s.handleScrubStatus)
+
+	// Admin webhook endpoints
+	protected.HandleFunc("GET /api/v1/admin/webhooks", s.handleListWebhooks)
+	protected.HandleFunc("POST /api/v1/admin/webhooks", s.handleCreateWebhook)
+	protected.HandleFunc("GET /api/v1/admin/webhooks/{id}", s.handleGetWebhook)
+	protected.HandleFunc("PUT /api/v1/admin/webhooks/{id}", s.handleUpdateWebhook)
+	protected.HandleFunc("DELETE /api/v1/admin/webhooks/{id}", s.handleDeleteWebhook)
+	protected.HandleFunc("POST /api/v1/admin/webhooks/{id}/test", s.handleTestWebhook)
+	protected.HandleFunc("GET /api/v1/admin/webhooks/{id}/deliveries", s.handleWebhookDeliveries)
+	protected.HandleFunc("GET /api/v1/admin/integrity-issues", s.handleListIntegrityIssues)
+
+	// Thumbnails
+	if s.thumbnails != nil {
+		protected.HandleFunc("GET /api/v1/thumb/{path...}", s.handleThumb)
+	}
 
 	// Gallery endpoints
 	if s.galleryStore != nil {
@@ -316,6 +529,10 @@ func (s *Server) Handler() http.Handler {
 		protected.HandleFunc("GET /api/v1/gallery/tags", s.handleListTags)
 		protected.HandleFunc("GET /api/v1/gallery/stats", s.handleGalleryStats)
 		protected.HandleFunc("GET /api/v1/gallery/map/points", s.handleGalleryMapPoints)
+		protected.HandleFunc("GET /api/v1/gallery/duplicates", s.handleGalleryDuplicates)
+		protected.HandleFunc("POST /api/v1/gallery/duplicates/resolve", s.handleResolveDuplicates)
+		protected.HandleFunc("GET /api/v1/gallery/stack/{id}", s.handleGalleryStack)
+		protected.HandleFunc("POST /api/v1/gallery/stack/{id}/unstack", s.handleGalleryUnstack)
 
 		// Custom album endpoints
 		protected.HandleFunc("GET /api/v1/gallery/albums", s.handleListUserAlbums)
@@ -326,6 +543,7 @@ func (s *Server) Handler() http.Handler {
 		protected.HandleFunc("POST /api/v1/gallery/albums/{id}/images", s.handleAddImageToAlbum)
 		protected.HandleFunc("DELETE /api/v1/gallery/albums/{id}/images", s.handleRemoveImageFromAlbum)
 		protected.HandleFunc("PUT /api/v1/gallery/albums/{id}/cover", s.handleSetAlbumCover)
+		protected.HandleFunc("POST /api/v1/gallery/albums/{id}/share", s.handleCreateAlbumShare)
 		protected.HandleFunc("GET /api/v1/gallery/image-albums/{path...}", s.handleGetAlbumsForImage)
 
 		// Per-user tag management
@@ -339,6 +557,9 @@ func (s *Server) Handler() http.Handler {
 		protected.HandleFunc("DELETE /api/v1/admin/gallery/plugins/{id}", s.handleDeletePlugin)
 		protected.HandleFunc("POST /api/v1/admin/gallery/plugins/{id}/test", s.handleTestPlugin)
 		protected.HandleFunc("POST /api/v1/admin/gallery/reprocess", s.handleReprocessGallery)
+		protected.HandleFunc("POST /api/v1/admin/gallery/restack", s.handleRestackGallery)
+		protected.HandleFunc("GET /api/v1/admin/gallery/failures", s.handleGalleryFailures)
+		protected.HandleFunc("POST /api/v1/admin/gallery/failures/retry", s.handleRetryGalleryFailures)
 
 		// Admin global tag management
 		protected.HandleFunc("DELETE /api/v1/admin/gallery/tags/{tag}", s.handleDeleteTagGlobal)
@@ -354,6 +575,7 @@ func (s *Server) Handler() http.Handler {
 	// Favorites endpoints
 	protected.HandleFunc("GET /api/v1/favorites", s.handleListFavorites)
 	protected.HandleFunc("GET /api/v1/favorites/paths", s.handleListFavoritePaths)
+	protected.HandleFunc("DELETE /api/v1/favorites/missing", s.handleRemoveMissingFavorites)
 	protected.HandleFunc("PUT /api/v1/favorites/{path...}", s.handleAddFavorite)
 	protected.HandleFunc("DELETE /api/v1/favorites/{path...}", s.handleRemoveFavorite)
 
@@ -370,6 +592,25 @@ func (s *Server) Handler() http.Handler {
 	// File properties endpoint
 	protected.HandleFunc("GET /api/v1/properties/{path...}", s.handleFileProperties)
 
+	// File comments
+	protected.HandleFunc("GET /api/v1/comments/{path...}", s.handleListComments)
+	protected.HandleFunc("POST /api/v1/comments/{path...}", s.handleAddComment)
+	protected.HandleFunc("PATCH /api/v1/comments/{id}", s.handleUpdateComment)
+	protected.HandleFunc("DELETE /api/v1/comments/{id}", s.handleDeleteComment)
+
+	// Sync conflicts
+	protected.HandleFunc("GET /api/v1/conflicts", s.handleListConflicts)
+	protected.HandleFunc("POST /api/v1/conflicts", s.handleReportConflict)
+	protected.HandleFunc("GET /api/v1/conflicts/{id}/mine", s.handleConflictMine)
+	protected.HandleFunc("GET /api/v1/conflicts/{id}/theirs", s.handleConflictTheirs)
+	protected.HandleFunc("POST /api/v1/conflicts/{id}/resolve", s.handleResolveConflict)
+
+	// Auto-organize rules
+	protected.HandleFunc("GET /api/v1/organize/{path...}", s.handleGetOrganizeRule)
+	protected.HandleFunc("PUT /api/v1/organize/{path...}", s.handleSetOrganizeRule)
+	protected.HandleFunc("DELETE /api/v1/organize/{path...}", s.handleDeleteOrganizeRule)
+	protected.HandleFunc("POST /api/v1/organize/{path...}", s.handleApplyOrganizeRule) // {path}/apply
+
 	// Visibility endpoints
 	protected.HandleFunc("GET /api/v1/visibility/{path...}", s.handleGetVisibility)
 	protected.HandleFunc("PUT /api/v1/visibility/{path...}", s.handleSetVisibility)
@@ -377,6 +618,8 @@ func (s *Server) Handler() http.Handler {
 	// Token management endpoints (user-facing)
 	protected.HandleFunc("DELETE /api/v1/auth/token", s.handleRevokeCurrentToken)
 	protected.HandleFunc("POST /api/v1/auth/refresh", s.handleRefreshToken)
+	protected.HandleFunc("POST /api/v1/auth/password", s.handleChangeOwnPassword)
+	protected.HandleFunc("GET /api/v1/auth/whoami", s.handleWhoAmI)
 	protected.HandleFunc("GET /api/v1/auth/sessions", s.handleListSessions)
 	protected.HandleFunc("DELETE /api/v1/auth/sessions/{tokenID}", s.handleRevokeSession)
 
@@ -387,6 +630,16 @@ func (s *Server) Handler() http.Handler {
 	protected.HandleFunc("POST /api/v1/auth/totp/disable", s.handleTOTPDisable)
 	protected.HandleFunc("POST /api/v1/auth/totp/backup", s.handleTOTPBackup)
 
+	// SSH public keys for the SFTP frontend
+	protected.HandleFunc("GET /api/v1/auth/ssh-keys", s.handleListSSHKeys)
+	protected.HandleFunc("POST /api/v1/auth/ssh-keys", s.handleAddSSHKey)
+	protected.HandleFunc("DELETE /api/v1/auth/ssh-keys/{keyID}", s.handleDeleteSSHKey)
+
+	// API keys (personal access tokens) for scripts and clients
+	protected.HandleFunc("GET /api/v1/auth/apikeys", s.handleListAPIKeys)
+	protected.HandleFunc("POST /api/v1/auth/apikeys", s.handleCreateAPIKey)
+	protected.HandleFunc("DELETE /api/v1/auth/apikeys/{keyID}", s.handleDeleteAPIKey)
+
 	// User usage endpoint
 	protected.HandleFunc("GET /api/v1/usage", s.handleGetUsage)
 
@@ -398,11 +651,12 @@ func (s *Server) Handler() http.Handler {
 
 	// Wrap protected routes with auth then rate limiter
 	// Use OIDC-aware middleware if OIDC is configured
+	scoped := s.impersonationScope(protected, s.apiKeyScope(protected))
 	var authed http.Handler
 	if s.auth.HasOIDC() {
-		authed = s.auth.MiddlewareWithOIDC(protected)
+		authed = s.auth.MiddlewareWithOIDC(scoped)
 	} else {
-		authed = s.auth.Middleware(protected)
+		authed = s.auth.Middleware(scoped)
 	}
 	getUserInfo := func(ctx context.Context) (int, int, bool) {
 		claims := auth.GetClaims(ctx)
@@ -414,15 +668,80 @@ func (s *Server) Handler() http.Handler {
 	rateLimited := quota.RateLimitMiddleware(s.rateLimiter, s.quotaStore, getUserInfo)(authed)
 	mux.Handle("/api/v1/", rateLimited)
 
-	// Apply logging and metrics middleware
-	return metrics.Middleware(logging.Middleware(mux))
+	// Apply CORS, client version, logging and metrics middleware. CORS runs
+	// before auth so that preflights are not rejected with 401, and leaves
+	// /webdav alone.
+	var corsCfg cors.Config
+	if s.config != nil {
+		corsCfg = cors.Config{
+			AllowedOrigins:   cors.ParseList(s.config.CORSAllowedOrigins),
+			AllowedHeaders:   cors.ParseList(s.config.CORSAllowedHeaders),
+			ExposedHeaders:   cors.ParseList(s.config.CORSExposedHeaders),
+			AllowCredentials: s.config.CORSAllowCredentials,
+			MaxAge:           s.config.CORSMaxAge,
+		}
+	}
+	withCORS := cors.Middleware(corsCfg, "/api/v1/")
+	return s.trackInFlight(metrics.Middleware(logging.Middleware(withCORS(s.clientVersionMiddleware(s.maintenanceMiddleware(mux))))))
 }
 
 // ─── Health ─────────────────────────────────────────────────────────────────
 
+// handleHealth reports liveness: the process is up and serving. It is also
+// served at /health for existing load balancer configurations.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	info := version.Get()
+	resp := map[string]interface{}{
+		"status":     "ok",
+		"version":    info.Version,
+		"commit":     info.Commit,
+		"build_date": info.BuildDate,
+		"go_version": info.GoVersion,
+	}
+	if v := s.settings.Get().MinClientVersion; v != "" {
+		resp["min_client_version"] = v
+	}
+	if st := s.maintenance.get(); st.ReadOnly {
+		resp["maintenance"] = st
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "version": "1.0"})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// clientVersionMiddleware tags requests from FruitSalade clients with the
+// client version from their User-Agent (recorded per session by auth) and
+// rejects clients older than MIN_CLIENT_VERSION with 426 Upgrade Required.
+// Browsers, WebDAV clients and development builds are never rejected.
+func (s *Server) clientVersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		component, clientVersion, ok := version.ParseUserAgent(r.UserAgent())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if minVersion := s.settings.Get().MinClientVersion; !version.AtLeast(clientVersion, minVersion) {
+			logging.Warn("rejecting outdated client",
+				zap.String("client", component),
+				zap.String("client_version", clientVersion),
+				zap.String("min_client_version", minVersion),
+				zap.String("remote_addr", r.RemoteAddr))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUpgradeRequired)
+			json.NewEncoder(w).Encode(protocol.UpgradeRequiredResponse{
+				Error:            "client upgrade required",
+				Code:             http.StatusUpgradeRequired,
+				ErrorCode:        protocol.ErrCodeUpgradeRequired,
+				ClientVersion:    clientVersion,
+				MinClientVersion: minVersion,
+				ServerVersion:    version.Version,
+			})
+			return
+		}
+
+		ctx := auth.ContextWithClientVersion(r.Context(), component+"/"+clientVersion)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
 // ─── SSE Events ─────────────────────────────────────────────────────────────
@@ -439,32 +758,102 @@ func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no")
 	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s\n\n", protocol.EventStreamPreamble)
 	flusher.Flush()
 
-	ch := s.broadcaster.Subscribe()
+	var userID int
+	var isAdmin bool
+	if claims := auth.GetClaims(r.Context()); claims != nil {
+		userID = claims.UserID
+		isAdmin = claims.IsAdmin
+	}
+
+	ch, replay := s.subscribeEvents(r)
 	defer s.broadcaster.Unsubscribe(ch)
 
+	for _, event := range replay {
+		writeSSEEvent(w, event, userID, isAdmin)
+	}
+	flusher.Flush()
+
+	// The stream ends before its token expires; see streamauth.go
+	claims := auth.GetClaims(r.Context())
+	deadline, stop := streamTimer(claims)
+	defer stop()
+
 	ctx := r.Context()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-deadline:
+			writeSSEEvent(w, expiringEvent(claims), userID, isAdmin)
+			flusher.Flush()
+			return
 		case event, ok := <-ch:
 			if !ok {
 				return
 			}
-			data, err := events.MarshalEvent(event)
-			if err != nil {
-				continue
+			if writeSSEEvent(w, event, userID, isAdmin) {
+				flusher.Flush()
 			}
-			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
-			flusher.Flush()
 		}
 	}
 }
 
-// publishEvent publishes an event to the broadcaster and persists to activity_log.
-func (s *Server) publishEvent(eventType, path string, version int, hash string, size int64, userID int, username string) {
+// writeSSEEvent writes one event if it is meant for the user, with an "id:"
+// line when it has an ID. It reports whether anything was written.
+func writeSSEEvent(w io.Writer, event events.Event, userID int, isAdmin bool) bool {
+	if !events.DeliverTo(event, userID, isAdmin) {
+		return false
+	}
+	data, err := events.MarshalEvent(event)
+	if err != nil {
+		logging.Warn("dropping invalid event", zap.String("type", event.Type), zap.Error(err))
+		return false
+	}
+	if event.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return true
+}
+
+// subscribeEvents subscribes to the broadcaster for an event stream. A
+// client resuming with Last-Event-ID (or ?last_event_id=, for WebSocket
+// clients in browsers that cannot set headers) gets the events it missed,
+// or a single resync-required event when they are no longer buffered.
+// While the server is read-only a maintenance event follows. The stream
+// is tied to the caller's session, so that revoking it ends the stream.
+func (s *Server) subscribeEvents(r *http.Request) (chan events.Event, []events.Event) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	lastID, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		lastID = 0
+	}
+
+	var sessionID int
+	if claims := auth.GetClaims(r.Context()); claims != nil {
+		sessionID = claims.SessionID
+	}
+	ch, missed, ok := s.broadcaster.SubscribeSession(lastID, sessionID)
+	if !ok {
+		logging.Debug("event stream resume too old, requesting resync", zap.Uint64("last_event_id", lastID))
+		missed = []events.Event{{Type: events.EventResyncRequired, Timestamp: time.Now().Unix()}}
+	}
+	// A new stream learns of maintenance mode at once, not only when it changes
+	if st := s.maintenance.get(); st.ReadOnly {
+		missed = append(missed, maintenanceEvent(st))
+	}
+	return ch, missed
+}
+
+// publishEvent publishes an event to the broadcaster and records it in the
+// activity log, with the request ID of ctx.
+func (s *Server) publishEvent(ctx context.Context, eventType, path string, version int, hash string, size int64, userID int, username string) {
 	if s.broadcaster != nil {
 		s.broadcaster.Publish(events.Event{
 			Type:     eventType,
@@ -477,28 +866,78 @@ func (s *Server) publishEvent(eventType, path string, version int, hash string,
 		})
 	}
 
-	// Persist to activity_log
-	db := s.metadata.DB()
-	db.ExecContext(context.Background(),
-		`INSERT INTO activity_log (user_id, username, action, resource_path, details)
-		 VALUES ($1, $2, $3, $4, $5)`,
-		userID, username, eventType, path,
-		fmt.Sprintf(`{"version":%d,"size":%d}`, version, size))
+	e := activity.Entry{
+		UserID:    userID,
+		Username:  username,
+		Action:    eventType,
+		Path:      path,
+		Details:   map[string]interface{}{"version": version, "size": size},
+		RequestID: logging.GetRequestID(ctx),
+	}
+	attributeImpersonator(ctx, &e)
+	s.recorder.Record(e)
+}
+
+// notifyPermissionGranted sends a permission-granted event to each
+// recipient other than the granting user.
+func (s *Server) notifyPermissionGranted(granter *auth.Claims, path string, grant *protocol.GrantPayload, recipients ...int) {
+	if s.broadcaster == nil {
+		return
+	}
+	for _, userID := range recipients {
+		if granter != nil && userID == granter.UserID {
+			continue
+		}
+		e := events.Event{
+			Type:      events.EventPermissionGranted,
+			Path:      path,
+			Grant:     grant,
+			ForUserID: userID,
+		}
+		if granter != nil {
+			e.UserID = granter.UserID
+			e.Username = granter.Username
+		}
+		s.broadcaster.Publish(e)
+	}
+}
+
+// recordActivity adds a non-file action by the requesting user to the
+// activity log.
+func (s *Server) recordActivity(ctx context.Context, claims *auth.Claims, action, path string, details map[string]interface{}) {
+	e := activity.Entry{Action: action, Path: path, Details: details, RequestID: logging.GetRequestID(ctx)}
+	if claims != nil {
+		e.UserID = claims.UserID
+		e.Username = claims.Username
+	}
+	attributeImpersonator(ctx, &e)
+	s.recorder.Record(e)
 }
 
 // ─── Tree ───────────────────────────────────────────────────────────────────
 
 func (s *Server) handleTree(w http.ResponseWriter, r *http.Request) {
-	if s.tree == nil {
+	tree, gen := s.treeSnapshot()
+	if tree == nil {
 		s.sendError(w, http.StatusInternalServerError, "metadata not initialized")
 		return
 	}
 
-	// Filter tree by user permissions
+	q, err := parseTreeQuery(r)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	claims := auth.GetClaims(r.Context())
-	filtered := s.filterTree(r.Context(), s.tree, claims)
+	if s.treeNotModified(w, r, gen, claims, q.order) {
+		return
+	}
 
-	resp := protocol.TreeResponse{Root: filtered}
+	// Filter tree by user permissions
+	filtered := s.filterTree(r.Context(), tree, claims, q.depth)
+
+	resp := protocol.TreeResponse{Root: pageChildren(q.order.Sorted(filtered), q.offset, q.limit), Partial: q.partial()}
 
 	if acceptsGzip(r) {
 		w.Header().Set("Content-Type", "application/json")
@@ -522,7 +961,8 @@ func (s *Server) handleSubtree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	node := s.findNode(s.tree, "/"+path)
+	tree, gen := s.treeSnapshot()
+	node := s.findNode(tree, "/"+path)
 	if node == nil {
 		s.sendError(w, http.StatusNotFound, "path not found: "+path)
 		return
@@ -535,8 +975,17 @@ func (s *Server) handleSubtree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filtered := s.filterTree(r.Context(), node, claims)
-	resp := protocol.TreeResponse{Root: filtered}
+	q, err := parseTreeQuery(r)
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.treeNotModified(w, r, gen, claims, q.order) {
+		return
+	}
+
+	filtered := s.filterTree(r.Context(), node, claims, q.depth)
+	resp := protocol.TreeResponse{Root: pageChildren(q.order.Sorted(filtered), q.offset, q.limit), Partial: q.partial()}
 
 	if acceptsGzip(r) {
 		w.Header().Set("Content-Type", "application/json")
@@ -553,14 +1002,39 @@ func (s *Server) handleSubtree(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// filterTree returns a copy of the tree with only nodes the user can read.
-// Admins see everything. Uses pre-loaded maps for performance.
-func (s *Server) filterTree(ctx context.Context, node *models.FileNode, claims *auth.Claims) *models.FileNode {
-	if node == nil || claims == nil {
-		return node
+// treeNotModified sets the ETag of a tree response and answers 304 when
+// the client's If-None-Match still matches it.
+func (s *Server) treeNotModified(w http.ResponseWriter, r *http.Request, gen uint64, claims *auth.Claims, order fstree.Order) bool {
+	etag := s.treeETag(r.Context(), gen, claims, order)
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		metrics.RecordTreeNotModified()
+		w.WriteHeader(http.StatusNotModified)
+		return true
 	}
-	if claims.IsAdmin {
-		return node
+	return false
+}
+
+// filterTree returns a copy of the tree with only nodes the user can read,
+// cut off depth levels below node (depth < 0 = unlimited). Directories at
+// the cut keep a child_count of their readable children. Admins see
+// everything. Uses pre-loaded maps for performance.
+func (s *Server) filterTree(ctx context.Context, node *models.FileNode, claims *auth.Claims, depth int) *models.FileNode {
+	if node == nil {
+		return nil
+	}
+	if claims != nil && claims.PathPrefix != "" {
+		node = pruneToPrefix(node, claims.PathPrefix)
+		if node == nil {
+			return nil
+		}
+	}
+	if claims == nil || claims.IsAdmin {
+		return limitDepth(node, depth)
 	}
 
 	// Pre-load maps once for the entire tree walk
@@ -573,22 +1047,21 @@ func (s *Server) filterTree(ctx context.Context, node *models.FileNode, claims *
 		userPerms = make(map[string]string)
 	}
 
-	return s.filterNodeRecursive(ctx, node, claims, userGroups, userPerms)
+	return s.filterNodes(node, claims, userGroups, userPerms, s.inheritedVisibility(node.Path), depth)
 }
 
-// filterNodeRecursive filters a single node using pre-loaded permission/group maps.
-func (s *Server) filterNodeRecursive(ctx context.Context, node *models.FileNode, claims *auth.Claims, userGroups map[int]string, userPerms map[string]string) *models.FileNode {
+// filterNodes filters the tree below node using pre-loaded permission/group
+// maps. inherited is the effective visibility of the node's parent. At
+// depth 0 the children of a directory are only counted, not returned. The
+// walk keeps its own queue of directories instead of recursing, so a deeply
+// nested tree cannot exhaust the stack.
+func (s *Server) filterNodes(node *models.FileNode, claims *auth.Claims, userGroups map[int]string, userPerms map[string]string, inherited sharing.Visibility, depth int) *models.FileNode {
 	if node == nil {
 		return nil
 	}
 
-	// 1. Visibility gate
-	if !s.permissions.CheckVisibility(node, claims.UserID, false, userGroups) {
-		return nil
-	}
-
-	// 2. Permission gate for files
-	if !node.IsDir && !s.checkAccessFast(node, claims, userGroups, userPerms) {
+	vis, ok := s.nodeReadable(node, claims, userGroups, userPerms, inherited)
+	if !ok {
 		return nil
 	}
 
@@ -596,21 +1069,76 @@ func (s *Server) filterNodeRecursive(ctx context.Context, node *models.FileNode,
 		return copyNode(node)
 	}
 
-	// 3. For directories, filter children recursively
-	filtered := copyNode(node)
-	filtered.Children = nil
+	type dirFrame struct {
+		src, dst *models.FileNode
+		vis      sharing.Visibility
+		depth    int
+	}
+	copyDir := func(src *models.FileNode) *models.FileNode {
+		dst := copyNode(src)
+		dst.Children = nil
+		dst.SetChildCount(0)
+		return dst
+	}
 
-	for _, child := range node.Children {
-		fc := s.filterNodeRecursive(ctx, child, claims, userGroups, userPerms)
-		if fc != nil {
-			filtered.Children = append(filtered.Children, fc)
+	root := copyDir(node)
+	dirs := []dirFrame{{src: node, dst: root, vis: vis, depth: depth}}
+	for i := 0; i < len(dirs); i++ {
+		d := dirs[i]
+		for _, child := range d.src.Children {
+			childVis, ok := s.nodeReadable(child, claims, userGroups, userPerms, d.vis)
+			if !ok {
+				continue
+			}
+			if d.depth == 0 {
+				d.dst.SetChildCount(d.dst.ChildCount + 1)
+				continue
+			}
+			if !child.IsDir {
+				d.dst.Children = append(d.dst.Children, copyNode(child))
+				continue
+			}
+			fc := copyDir(child)
+			d.dst.Children = append(d.dst.Children, fc)
+			dirs = append(dirs, dirFrame{src: child, dst: fc, vis: childVis, depth: d.depth - 1})
+		}
+	}
+
+	// Children come after their parent in dirs, so walking it backwards
+	// finishes every directory after its subdirectories. Aggregates must
+	// count only what the user can read, which is known only when the whole
+	// subtree was walked
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if d.depth != 0 {
+			d.dst.SetChildCount(len(d.dst.Children))
+		}
+		if d.depth < 0 {
+			fstree.SumChildren(d.dst)
+		} else {
+			d.dst.AggSize, d.dst.ItemCount = 0, 0
 		}
 	}
 
-	return filtered
+	return root
+}
+
+// nodeReadable applies the visibility gate (an empty visibility inherits the
+// parent's) and, for files, the permission gate. It returns the node's
+// effective visibility for its children.
+func (s *Server) nodeReadable(node *models.FileNode, claims *auth.Claims, userGroups map[int]string, userPerms map[string]string, inherited sharing.Visibility) (sharing.Visibility, bool) {
+	vis := inherited.Inherit(node)
+	if !s.permissions.CheckEffectiveVisibility(node, vis, claims.UserID, false, userGroups) {
+		return vis, false
+	}
+	if !node.IsDir && !s.checkAccessFast(node, claims, userGroups, userPerms) {
+		return vis, false
+	}
+	return vis, true
 }
 
 // checkAccessFast checks access using pre-loaded maps (no DB queries in the hot path).
+// Nodes of aliases are checked at the path they stand for.
 func (s *Server) checkAccessFast(node *models.FileNode, claims *auth.Claims, userGroups map[int]string, userPerms map[string]string) bool {
 	// Owner always has access
 	if node.OwnerID > 0 && node.OwnerID == claims.UserID {
@@ -618,7 +1146,8 @@ func (s *Server) checkAccessFast(node *models.FileNode, claims *auth.Claims, use
 	}
 
 	// Check user file_permissions with path inheritance
-	segments := sharing.PathSegments(node.Path)
+	nodePath := nodeSource(node)
+	segments := sharing.PathSegments(nodePath)
 	for _, seg := range segments {
 		if perm, ok := userPerms[seg]; ok {
 			if sharing.PermissionSatisfies(perm, "read") {
@@ -638,42 +1167,80 @@ func (s *Server) checkAccessFast(node *models.FileNode, claims *auth.Claims, use
 	}
 
 	// Fall back to DB-based CheckAccess for group_permissions path inheritance
-	return s.permissions.CheckAccess(context.Background(), claims.UserID, node.Path, "read", false)
+	return s.permissions.CheckAccess(context.Background(), claims.UserID, nodePath, "read", false)
 }
 
-// copyNode creates a shallow copy of a FileNode (without children).
+// inheritedVisibility returns the visibility the node at path inherits from
+// its ancestors in the tree.
+func (s *Server) inheritedVisibility(path string) sharing.Visibility {
+	var vis sharing.Visibility
+	node := s.currentTree()
+	if node == nil || path == "/" || path == "" {
+		return vis
+	}
+	vis = vis.Inherit(node)
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for _, name := range parts[:len(parts)-1] {
+		var next *models.FileNode
+		for _, child := range node.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+		vis = vis.Inherit(node)
+	}
+	return vis
+}
+
+// copyNode returns a shallow copy of node without its children. Handlers
+// copy nodes before changing them: the tree is shared by concurrent
+// requests and must never be modified (see treeupdate.go).
 func copyNode(node *models.FileNode) *models.FileNode {
 	return &models.FileNode{
-		ID:         node.ID,
-		Name:       node.Name,
-		Path:       node.Path,
-		Size:       node.Size,
-		ModTime:    node.ModTime,
-		IsDir:      node.IsDir,
-		Hash:       node.Hash,
-		Version:    node.Version,
-		OwnerID:    node.OwnerID,
-		Visibility: node.Visibility,
-		GroupID:    node.GroupID,
+		ID:          node.ID,
+		Name:        node.Name,
+		Path:        node.Path,
+		Size:        node.Size,
+		ModTime:     node.ModTime,
+		IsDir:       node.IsDir,
+		Hash:        node.Hash,
+		Version:     node.Version,
+		OwnerID:     node.OwnerID,
+		Visibility:  node.Visibility,
+		GroupID:     node.GroupID,
+		ChildCount:  node.ChildCount,
+		HasChildren: node.HasChildren,
+		AggSize:     node.AggSize,
+		ItemCount:   node.ItemCount,
+		Alias:       node.Alias,
+		AliasTarget: node.AliasTarget,
 	}
 }
 
+// findNode returns the node at path in the tree below root, walking down
+// one level at a time.
 func (s *Server) findNode(root *models.FileNode, path string) *models.FileNode {
-	if root == nil {
-		return nil
-	}
-
 	path = strings.TrimSuffix(path, "/")
-	rootPath := strings.TrimSuffix(root.Path, "/")
-
-	if rootPath == path || (path == "/" && rootPath == "/") {
-		return root
-	}
-
-	for _, child := range root.Children {
-		if found := s.findNode(child, path); found != nil {
-			return found
+	node := root
+	for node != nil {
+		if strings.TrimSuffix(node.Path, "/") == path {
+			return node
+		}
+		var next *models.FileNode
+		for _, child := range node.Children {
+			childPath := strings.TrimSuffix(child.Path, "/")
+			if childPath == path || strings.HasPrefix(path, childPath+"/") {
+				next = child
+				break
+			}
 		}
+		node = next
 	}
 	return nil
 }
@@ -688,7 +1255,7 @@ func (s *Server) handleContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Look up file - try by path first, then by ID (for FUSE client compat)
-	fullPath := "/" + pathParam
+	fullPath, _ := s.resolveAlias("/" + pathParam)
 	fileRow, _ := s.metadata.GetFileRow(r.Context(), fullPath)
 
 	// Check read permission
@@ -735,9 +1302,38 @@ func (s *Server) handleContent(w http.ResponseWriter, r *http.Request) {
 	offset, length, hasRange := parseRangeHeader(r.Header.Get("Range"), totalSize)
 
 	// Resolve backend
-	backend, _, err := s.storageRouter.ResolveForFile(r.Context(), storageLocID, groupID)
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), storageLocID, groupID)
 	if err != nil {
-		s.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
+		s.sendStorageError(w, err)
+		return
+	}
+
+	// Set Content-Type based on file extension
+	ct := mime.TypeByExtension(filepath.Ext(fullPath))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+
+	var limit int64
+	if claims != nil {
+		var ok bool
+		if limit, ok = s.downloadAllowed(w, r, claims.UserID, claims.IsAdmin, fullPath, "content"); !ok {
+			return
+		}
+	}
+
+	// Redirect opted-in clients straight to the object store
+	if url := presignedURL(r, backend, lookupKey, ct, ""); url != "" {
+		served := totalSize
+		if hasRange {
+			served = length
+		}
+		metrics.RecordContentDownload(served, true)
+		if claims != nil {
+			s.quotaStore.TrackBandwidth(r.Context(), claims.UserID, 0, served)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusFound)
 		return
 	}
 
@@ -750,32 +1346,113 @@ func (s *Server) handleContent(w http.ResponseWriter, r *http.Request) {
 	}
 	defer reader.Close()
 
-	// Set Content-Type based on file extension
-	ct := mime.TypeByExtension(filepath.Ext(fullPath))
-	if ct == "" {
-		ct = "application/octet-stream"
-	}
 	w.Header().Set("Content-Type", ct)
 
+	// Compress whole files of compressible types if the client accepts it
+	var encoding string
+	if s.compressContent(ct, totalSize) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !hasRange {
+			encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		}
+	}
+
 	if hasRange {
 		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, totalSize))
 		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
 		w.WriteHeader(http.StatusPartialContent)
+	} else if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		w.WriteHeader(http.StatusOK)
 	} else {
 		w.Header().Set("Content-Length", strconv.FormatInt(totalSize, 10))
 		w.WriteHeader(http.StatusOK)
 	}
 
-	n, err := io.Copy(w, reader)
+	var dst io.Writer = w
+	var meter *quota.Meter
+	if claims != nil {
+		meter = s.quotaStore.NewMeter(r.Context(), w, claims.UserID, limit)
+		dst = meter
+	}
+	var enc *contentEncoder
+	if encoding != "" {
+		enc = newContentEncoder(dst, encoding)
+		dst = enc
+	}
+	n, err := io.Copy(dst, reader)
+	if enc != nil {
+		if cerr := enc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if meter != nil {
+		meter.Finish()
+		if meter.Exceeded() {
+			metrics.RecordDownloadDenied("content")
+		}
+	}
 	if err != nil {
 		logging.Warn("content transfer error", zap.String("path", r.URL.Path), zap.Error(err))
 	}
 	metrics.RecordContentDownload(n, err == nil)
+}
 
-	// Track bandwidth
-	if claims != nil {
-		s.quotaStore.TrackBandwidth(r.Context(), claims.UserID, 0, n)
+// downloadAllowed checks the daily bandwidth quota before a download of
+// path and answers 429 if it is used up. limit is what to pass to
+// NewMeter: 0 for admins and exempt paths, whose downloads are only
+// tracked. source labels the denied-downloads metric.
+func (s *Server) downloadAllowed(w http.ResponseWriter, r *http.Request, userID int, isAdmin bool, path, source string) (limit int64, ok bool) {
+	if isAdmin || quota.PathExempt(path, s.bandwidthExempt) {
+		return 0, true
+	}
+	limit, ok, err := s.quotaStore.DownloadLimit(r.Context(), userID)
+	if err != nil {
+		// Don't lock users out when the quota can't be read
+		logging.Warn("bandwidth quota check failed", zap.Int("user_id", userID), zap.Error(err))
+		return 0, true
+	}
+	if !ok {
+		metrics.RecordDownloadDenied(source)
+		w.Header().Set("Retry-After", strconv.Itoa(quota.RetryAfterReset(time.Now())))
+		s.sendErrorCode(w, http.StatusTooManyRequests, protocol.ErrCodeBandwidthExceeded, "daily bandwidth quota exceeded", "")
+		return 0, false
 	}
+	return limit, true
+}
+
+// wantsRedirect reports whether the client listed protocol.AcceptRedirect
+// in its Accept header.
+func wantsRedirect(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, _, _ := strings.Cut(part, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), protocol.AcceptRedirect) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// presignedURL returns a presigned download URL for key when the client
+// opted in and the file's storage location has presigned downloads
+// enabled. It returns "" when the content should be proxied instead.
+func presignedURL(r *http.Request, backend storage.Backend, key, contentType, disposition string) string {
+	if !wantsRedirect(r) {
+		return ""
+	}
+	provider, ok := backend.(storage.PresignedURLProvider)
+	if !ok || !provider.PresignEnabled() {
+		return ""
+	}
+	url, err := provider.PresignGetObject(r.Context(), key, contentType, disposition)
+	if err != nil {
+		// Fall back to proxying
+		logging.Warn("presign failed", zap.String("key", key), zap.Error(err))
+		return ""
+	}
+	return url
 }
 
 // ─── Upload ─────────────────────────────────────────────────────────────────
@@ -786,6 +1463,11 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusBadRequest, "path required")
 		return
 	}
+	if err := validateNewPath(path); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	path, _ = s.resolveAlias(path)
 
 	claims := auth.GetClaims(r.Context())
 
@@ -795,15 +1477,14 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Determine effective upload size limit (per-user override or global)
-	effectiveMaxUpload := s.maxUploadSize
-	if claims != nil {
-		userLimit, err := s.quotaStore.GetUploadSizeLimit(r.Context(), claims.UserID)
-		if err == nil && userLimit > 0 {
-			effectiveMaxUpload = userLimit
-		}
+	declaredHash, ok := declaredSHA256(r)
+	if !ok {
+		s.sendError(w, http.StatusBadRequest, "invalid "+protocol.HeaderContentSHA256+" header: want a hex SHA-256")
+		return
 	}
 
+	effectiveMaxUpload := s.uploadLimit(r.Context(), claims)
+
 	// Check content length
 	if r.ContentLength > effectiveMaxUpload {
 		s.sendError(w, http.StatusRequestEntityTooLarge,
@@ -814,7 +1495,7 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	// Limit reader to max upload size
 	limitedReader := io.LimitReader(r.Body, effectiveMaxUpload+1)
 
-	// Read content and compute hash
+	// Read content
 	content, err := io.ReadAll(limitedReader)
 	if err != nil {
 		metrics.RecordContentUpload(0, false)
@@ -829,176 +1510,208 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check storage quota
-	if claims != nil {
-		ok, err := s.quotaStore.CheckStorageQuota(r.Context(), claims.UserID, int64(len(content)))
-		if err == nil && !ok {
-			metrics.RecordQuotaExceeded("storage")
-			s.sendError(w, http.StatusRequestEntityTooLarge, "storage quota exceeded")
-			return
+	expectedVersion, _ := strconv.Atoi(r.Header.Get("X-Expected-Version"))
+	res, err := s.uploads.Store(r.Context(), upload.Request{
+		Path:            path,
+		Content:         bytes.NewReader(content),
+		Size:            int64(len(content)),
+		Claims:          claims,
+		ExpectedVersion: expectedVersion,
+		IfMatch:         r.Header.Get("If-Match"),
+		CreateOnly:      r.Header.Get("If-None-Match") == "*",
+		SHA256:          declaredHash,
+	})
+	if err != nil {
+		var conflict *upload.ConflictError
+		var checksum *upload.ChecksumError
+		switch {
+		case errors.As(err, &checksum):
+			s.sendChecksumMismatch(w, path, checksum, "content")
+		case errors.As(err, &conflict):
+			// The rejected content is kept for the user to resolve
+			var conflictID int64
+			if c, err := s.recordConflict(r.Context(), claims, path, protocol.ConflictSourceUpload, "",
+				conflict.CurrentVersion, conflict.CurrentHash, content); err != nil {
+				logging.Warn("failed to record sync conflict", zap.String("path", path), zap.Error(err))
+			} else if c != nil {
+				conflictID = c.ID
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(protocol.ConflictResponse{
+				Error:           conflict.Reason,
+				Code:            http.StatusConflict,
+				ErrorCode:       protocol.ErrCodeVersionConflict,
+				RequestID:       w.Header().Get("X-Request-ID"),
+				Path:            path,
+				ExpectedVersion: conflict.ExpectedVersion,
+				CurrentVersion:  conflict.CurrentVersion,
+				CurrentHash:     conflict.CurrentHash,
+				ConflictID:      conflictID,
+			})
+		default:
+			s.sendUploadError(w, err)
 		}
+		return
 	}
 
-	hash := sha256.Sum256(content)
-	hashStr := fmt.Sprintf("%x", hash)
+	s.afterUpload(r.Context(), claims, res)
 
-	// S3 key is the path without leading /
-	s3Key := strings.TrimPrefix(path, "/")
-
-	// Check if file already exists (for versioning and conflict detection)
-	newVersion := 1
-	existingRow, _ := s.metadata.GetFileRow(r.Context(), path)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":    path,
+		"size":    res.Size,
+		"hash":    res.Hash,
+		"version": res.Version,
+	})
+}
 
-	// Conflict detection: check X-Expected-Version and If-Match headers
-	if existingRow != nil && !existingRow.IsDir {
-		if evStr := r.Header.Get("X-Expected-Version"); evStr != "" {
-			expectedVersion, _ := strconv.Atoi(evStr)
-			if expectedVersion > 0 && expectedVersion != existingRow.Version {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(protocol.ConflictResponse{
-					Error:           "version conflict",
-					Path:            path,
-					ExpectedVersion: expectedVersion,
-					CurrentVersion:  existingRow.Version,
-					CurrentHash:     existingRow.Hash,
-				})
-				return
-			}
-		}
-		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
-			etag := strings.Trim(ifMatch, "\"")
-			if etag != existingRow.Hash {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(protocol.ConflictResponse{
-					Error:           "content conflict (hash mismatch)",
-					Path:            path,
-					ExpectedVersion: existingRow.Version,
-					CurrentVersion:  existingRow.Version,
-					CurrentHash:     existingRow.Hash,
-				})
-				return
-			}
-		}
+// sendUploadError reports an error of upload.Service.Store other than a
+// checksum mismatch or a version conflict.
+func (s *Server) sendUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, upload.ErrQuotaExceeded):
+		s.sendErrorCode(w, http.StatusRequestEntityTooLarge, protocol.ErrCodeQuotaExceeded, err.Error(), "")
+	case errors.Is(err, upload.ErrIsDir):
+		s.sendError(w, http.StatusConflict, "path is a directory")
+	case errors.Is(err, upload.ErrExists):
+		s.sendError(w, http.StatusPreconditionFailed, "file already exists")
+	case errors.Is(err, storage.ErrInvalidKey):
+		s.sendPathError(w, err)
+	case errors.Is(err, storage.ErrReadOnlyStorage):
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
+	case errors.Is(err, storage.ErrStorageUnavailable):
+		s.sendStorageError(w, err)
+	default:
+		s.sendError(w, http.StatusInternalServerError, err.Error())
 	}
+}
 
-	if existingRow != nil && !existingRow.IsDir && existingRow.Size > 0 {
-		// Save current state as a version before overwriting
-		if err := s.metadata.SaveVersion(r.Context(), path); err != nil {
-			logging.Warn("failed to save version", zap.String("path", path), zap.Error(err))
-		}
-
-		// Resolve existing file's backend for version backup
-		existBackend, _, _ := s.storageRouter.ResolveForFile(r.Context(), existingRow.StorageLocID, existingRow.GroupID)
-		if existBackend != nil {
-			versionKey := fmt.Sprintf("_versions/%s/%d", s3Key, existingRow.Version)
-			if err := existBackend.CopyObject(r.Context(), existingRow.S3Key, versionKey); err != nil {
-				logging.Warn("failed to backup version content", zap.String("path", path), zap.Error(err))
-			}
-		}
+// afterUpload updates the tree, publishes the file event and queues the
+// stored file for gallery processing and content indexing.
+func (s *Server) afterUpload(ctx context.Context, claims *auth.Claims, res *upload.Result) {
+	path := res.Path
+	s.updateTree(ctx, path)
 
-		newVersion = existingRow.Version + 1
-	}
+	logging.Info("file uploaded",
+		zap.String("path", path),
+		zap.Int64("size", res.Size),
+		zap.String("hash", res.Hash[:16]),
+		zap.Int("version", res.Version))
 
-	// Resolve backend for upload
-	var groupID *int
-	if existingRow != nil {
-		groupID = existingRow.GroupID
+	// Publish SSE event
+	eventType := events.EventCreate
+	if !res.Created {
+		eventType = events.EventModify
 	}
-	backend, loc, err := s.storageRouter.ResolveForUpload(r.Context(), path, groupID)
-	if err != nil {
-		if errors.Is(err, storage.ErrReadOnlyStorage) {
-			s.sendError(w, http.StatusForbidden, "storage location is read-only")
-			return
-		}
-		s.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
-		return
+	var eventUserID int
+	var eventUsername string
+	if claims != nil {
+		eventUserID = claims.UserID
+		eventUsername = claims.Username
 	}
+	s.publishEvent(ctx, eventType, path, res.Version, res.Hash, res.Size, eventUserID, eventUsername)
 
-	// Upload to backend
-	if err := backend.PutObject(r.Context(), s3Key, strings.NewReader(string(content)), int64(len(content))); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to upload: "+err.Error())
-		return
+	// Gallery: enqueue image processing if applicable
+	if s.processor != nil && gallery.IsMediaFile(path) {
+		s.processor.Enqueue(path)
 	}
 
-	// Ensure parent directories exist
-	if err := s.ensureParentDirs(r.Context(), path); err != nil {
-		logging.Error("failed to ensure parent dirs", zap.Error(err))
+	// Content search: extract text from supported documents
+	if s.textIndexer != nil && textindex.IsIndexable(path) {
+		s.textIndexer.Enqueue(path)
 	}
+}
 
-	// Create/update metadata
-	parentPath := filepath.Dir(path)
-	if parentPath == "." {
-		parentPath = "/"
-	}
+// declaredSHA256 returns the X-Content-SHA256 header of r in lower case,
+// or "" if it is not set. ok is false if it is set but not a hex SHA-256.
+func declaredSHA256(r *http.Request) (sum string, ok bool) {
+	sum = strings.ToLower(strings.TrimSpace(r.Header.Get(protocol.HeaderContentSHA256)))
+	return sum, sum == "" || upload.ValidSHA256(sum)
+}
+
+// sendChecksumMismatch rejects an upload whose content does not match its
+// declared hash. source labels the metric.
+func (s *Server) sendChecksumMismatch(w http.ResponseWriter, path string, ce *upload.ChecksumError, source string) {
+	metrics.RecordUploadChecksumFailure(source)
+	logging.Warn("upload checksum mismatch",
+		zap.String("path", path),
+		zap.String("expected", ce.Expected),
+		zap.String("actual", ce.Actual))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(protocol.ChecksumMismatchResponse{
+		Error:          "content does not match " + protocol.HeaderContentSHA256,
+		Code:           http.StatusUnprocessableEntity,
+		ErrorCode:      protocol.ErrCodeChecksumMismatch,
+		Path:           path,
+		ExpectedSHA256: ce.Expected,
+		ActualSHA256:   ce.Actual,
+		RequestID:      w.Header().Get("X-Request-ID"),
+	})
+}
+
+// uploadLimit returns the upload size limit for claims: the user's own
+// limit if one is set, otherwise the global one.
+func (s *Server) uploadLimit(ctx context.Context, claims *auth.Claims) int64 {
+	return s.uploads.Limit(ctx, claims)
+}
 
-	storageLocID := &loc.ID
-	fileRow := &postgres.FileRow{
-		ID:           fileID(path),
-		Name:         filepath.Base(path),
-		Path:         path,
-		ParentPath:   parentPath,
-		Size:         int64(len(content)),
-		ModTime:      time.Now(),
-		IsDir:        false,
-		Hash:         hashStr,
-		S3Key:        s3Key,
-		Version:      newVersion,
-		StorageLocID: storageLocID,
+// handleUploadCheck runs the permission, upload size and storage quota
+// checks of handleUpload for a path and size, so a client can find out
+// before sending the content that an upload would be refused.
+func (s *Server) handleUploadCheck(w http.ResponseWriter, r *http.Request) {
+	var req protocol.UploadCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
 	}
-
-	// Set owner on first upload
-	if claims != nil && existingRow == nil {
-		ownerID := claims.UserID
-		fileRow.OwnerID = &ownerID
+	path := "/" + strings.Trim(req.Path, "/")
+	if path == "/" {
+		s.sendError(w, http.StatusBadRequest, "path required")
+		return
 	}
-
-	if err := s.metadata.UpsertFile(r.Context(), fileRow); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to save metadata: "+err.Error())
+	if err := validateNewPath(path); err != nil {
+		s.sendPathError(w, err)
 		return
 	}
-
-	// Refresh tree
-	s.RefreshTree(r.Context())
-
-	// Track bandwidth
-	if claims != nil {
-		s.quotaStore.TrackBandwidth(r.Context(), claims.UserID, int64(len(content)), 0)
+	path, _ = s.resolveAlias(path)
+	if req.Size < 0 {
+		s.sendError(w, http.StatusBadRequest, "size must not be negative")
+		return
 	}
 
-	logging.Info("file uploaded",
-		zap.String("path", path),
-		zap.Int("size", len(content)),
-		zap.String("hash", hashStr[:16]),
-		zap.Int("version", newVersion))
-
-	// Publish SSE event
-	eventType := events.EventCreate
-	if existingRow != nil {
-		eventType = events.EventModify
+	claims := auth.GetClaims(r.Context())
+	resp := protocol.UploadCheckResponse{
+		Allowed:       true,
+		MaxUploadSize: s.uploadLimit(r.Context(), claims),
 	}
-	var eventUserID int
-	var eventUsername string
-	if claims != nil {
-		eventUserID = claims.UserID
-		eventUsername = claims.Username
+	remaining, err := s.uploads.QuotaRemaining(r.Context(), claims)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to check quota: "+err.Error())
+		return
 	}
-	s.publishEvent(eventType, path, newVersion, hashStr, int64(len(content)), eventUserID, eventUsername)
+	resp.QuotaRemaining = remaining
 
-	// Gallery: enqueue image processing if applicable
-	if s.processor != nil && gallery.IsImageFile(path) {
-		s.processor.Enqueue(path)
+	switch {
+	case claims != nil && !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "write", claims.IsAdmin):
+		resp.Allowed = false
+		resp.Limit = protocol.UploadLimitPermission
+		resp.Reason = "write access denied"
+	case req.Size > resp.MaxUploadSize:
+		resp.Allowed = false
+		resp.Limit = protocol.UploadLimitSize
+		resp.Reason = fmt.Sprintf("file too large: max %d bytes", resp.MaxUploadSize)
+	case remaining >= 0 && req.Size > remaining:
+		resp.Allowed = false
+		resp.Limit = protocol.UploadLimitQuota
+		resp.Reason = fmt.Sprintf("storage quota exceeded: %d bytes remaining", remaining)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":    path,
-		"size":    len(content),
-		"hash":    hashStr,
-		"version": newVersion,
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 // ─── Create/Update ──────────────────────────────────────────────────────────
@@ -1009,6 +1722,11 @@ func (s *Server) handleCreateOrUpdate(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusBadRequest, "cannot modify root")
 		return
 	}
+	if err := validateNewPath(path); err != nil {
+		s.sendPathError(w, err)
+		return
+	}
+	path, _ = s.resolveAlias(path)
 
 	claims := auth.GetClaims(r.Context())
 	if claims != nil && !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "write", claims.IsAdmin) {
@@ -1022,7 +1740,7 @@ func (s *Server) handleCreateOrUpdate(w http.ResponseWriter, r *http.Request) {
 	// Check if the target storage location is read-only
 	_, _, roErr := s.storageRouter.ResolveForUpload(r.Context(), path, nil)
 	if roErr != nil && errors.Is(roErr, storage.ErrReadOnlyStorage) {
-		s.sendError(w, http.StatusForbidden, "storage location is read-only")
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
 		return
 	}
 
@@ -1057,7 +1775,7 @@ func (s *Server) handleCreateOrUpdate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.RefreshTree(r.Context())
+		s.updateTree(r.Context(), path)
 
 		logging.Info("directory created", zap.String("path", path))
 
@@ -1067,7 +1785,7 @@ func (s *Server) handleCreateOrUpdate(w http.ResponseWriter, r *http.Request) {
 			dirUserID = claims.UserID
 			dirUsername = claims.Username
 		}
-		s.publishEvent(events.EventCreate, path, 0, "", 0, dirUserID, dirUsername)
+		s.publishEvent(r.Context(), events.EventCreate, path, 0, "", 0, dirUserID, dirUsername)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -1090,6 +1808,10 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusBadRequest, "cannot delete root")
 		return
 	}
+	if s.insideAlias(path) {
+		s.sendError(w, http.StatusBadRequest, errInsideAlias.Error())
+		return
+	}
 
 	// Check ownership or admin
 	claims := auth.GetClaims(r.Context())
@@ -1116,7 +1838,7 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 
 	// Check if file's storage location is read-only
 	if fileRow.StorageLocID != nil && s.storageRouter.IsReadOnly(*fileRow.StorageLocID) {
-		s.sendError(w, http.StatusForbidden, "storage location is read-only")
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
 		return
 	}
 
@@ -1130,7 +1852,7 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.RefreshTree(r.Context())
+	s.updateTree(r.Context(), path)
 
 	logging.Info("file moved to trash", zap.String("path", path))
 
@@ -1138,7 +1860,7 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 	if claims != nil {
 		delUsername = claims.Username
 	}
-	s.publishEvent(events.EventDelete, path, 0, "", 0, userID, delUsername)
+	s.publishEvent(r.Context(), events.EventDelete, path, 0, "", 0, userID, delUsername)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1211,13 +1933,13 @@ func (s *Server) handleVersionContent(w http.ResponseWriter, r *http.Request, pa
 	}
 
 	// Resolve backend from version record
-	backend, _, err := s.storageRouter.ResolveForFile(r.Context(), vRecord.StorageLocID, nil)
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), vRecord.StorageLocID, nil)
 	if err != nil {
-		s.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
+		s.sendStorageError(w, err)
 		return
 	}
 
-	versionS3Key := fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(path, "/"), version)
+	versionS3Key := postgres.VersionContentKey(path, version, vRecord.S3Key)
 	reader, size, err := backend.GetObject(r.Context(), versionS3Key, 0, 0)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to retrieve version content: "+err.Error())
@@ -1262,14 +1984,25 @@ func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
 
 	// Check if file's storage location is read-only
 	if currentRow.StorageLocID != nil && s.storageRouter.IsReadOnly(*currentRow.StorageLocID) {
-		s.sendError(w, http.StatusForbidden, "storage location is read-only")
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
 		return
 	}
 
 	// Resolve backend for this file
 	backend, _, err := s.storageRouter.ResolveForFile(r.Context(), currentRow.StorageLocID, currentRow.GroupID)
 	if err != nil {
-		s.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
+		s.sendStorageError(w, err)
+		return
+	}
+
+	target, err := s.metadata.GetVersion(r.Context(), path, req.Version)
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, "version not found: "+err.Error())
+		return
+	}
+	shared := postgres.IsContentKey(target.S3Key)
+	if shared && (target.StorageLocID == nil || currentRow.StorageLocID == nil || *target.StorageLocID != *currentRow.StorageLocID) {
+		s.sendError(w, http.StatusConflict, "version is stored in a different storage location")
 		return
 	}
 
@@ -1277,25 +2010,41 @@ func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
 	if err := s.metadata.SaveVersion(r.Context(), path); err != nil {
 		logging.Warn("failed to save pre-rollback version", zap.Error(err))
 	}
-	versionKey := fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(path, "/"), currentRow.Version)
-	if err := backend.CopyObject(r.Context(), currentRow.S3Key, versionKey); err != nil {
-		logging.Warn("failed to backup pre-rollback content", zap.Error(err))
+	if !postgres.IsContentKey(currentRow.S3Key) {
+		versionKey := fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(path, "/"), currentRow.Version)
+		if err := backend.CopyObject(r.Context(), currentRow.S3Key, versionKey); err != nil {
+			logging.Warn("failed to backup pre-rollback content", zap.Error(err))
+		}
 	}
 
-	// Copy version content back to current S3 key
-	srcKey := fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(path, "/"), req.Version)
-	if err := backend.CopyObject(r.Context(), srcKey, currentRow.S3Key); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to restore content: "+err.Error())
-		return
+	// Point the file at the version's shared content, or copy the version's
+	// own copy back to the file's path key
+	restoreKey := target.S3Key
+	if shared {
+		if err := s.metadata.RefContent(r.Context(), restoreKey, *target.StorageLocID); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to restore content: "+err.Error())
+			return
+		}
+	} else {
+		restoreKey = currentRow.S3Key
+		if postgres.IsContentKey(restoreKey) {
+			restoreKey = strings.TrimPrefix(path, "/")
+		}
+		srcKey := fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(path, "/"), req.Version)
+		if err := backend.CopyObject(r.Context(), srcKey, restoreKey); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to restore content: "+err.Error())
+			return
+		}
 	}
 
 	newVersion := currentRow.Version + 1
-	if err := s.metadata.RestoreVersion(r.Context(), path, req.Version, newVersion, currentRow.S3Key); err != nil {
+	if err := s.metadata.RestoreVersion(r.Context(), path, req.Version, newVersion, restoreKey); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to restore metadata: "+err.Error())
 		return
 	}
+	s.releaseReplaced(r.Context(), currentRow, restoreKey)
 
-	s.RefreshTree(r.Context())
+	s.updateTree(r.Context(), path)
 
 	logging.Info("file rolled back",
 		zap.String("path", path),
@@ -1309,13 +2058,81 @@ func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
 		rbUserID = rbClaims.UserID
 		rbUsername = rbClaims.Username
 	}
-	s.publishEvent(events.EventVersion, path, newVersion, "", 0, rbUserID, rbUsername)
+	s.publishEvent(r.Context(), events.EventVersion, path, newVersion, "", 0, rbUserID, rbUsername)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.RollbackResponse{
+		Path:            path,
+		RestoredVersion: req.Version,
+		NewVersion:      newVersion,
+	})
+}
+
+func (s *Server) handleDeleteVersion(w http.ResponseWriter, r *http.Request) {
+	path := "/" + r.PathValue("path")
+	if path == "/" {
+		s.sendError(w, http.StatusBadRequest, "path required")
+		return
+	}
+
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	version, err := strconv.Atoi(r.URL.Query().Get("v"))
+	if err != nil || version < 1 {
+		s.sendError(w, http.StatusBadRequest, "invalid version number")
+		return
+	}
+
+	fileRow, err := s.metadata.GetFileRow(r.Context(), path)
+	if err != nil || fileRow == nil {
+		s.sendError(w, http.StatusNotFound, "file not found: "+path)
+		return
+	}
+
+	// Only the owner or an admin may discard history
+	if !claims.IsAdmin && (fileRow.OwnerID == nil || *fileRow.OwnerID != claims.UserID) {
+		s.sendError(w, http.StatusForbidden, "only the file owner can delete versions")
+		return
+	}
+
+	vRecord, err := s.metadata.GetVersion(r.Context(), path, version)
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, "version not found: "+err.Error())
+		return
+	}
+	if vRecord.StorageLocID != nil && s.storageRouter.IsReadOnly(*vRecord.StorageLocID) {
+		s.sendErrorCode(w, http.StatusForbidden, protocol.ErrCodeReadOnly, "storage location is read-only", "")
+		return
+	}
+
+	if _, err := s.metadata.DeleteVersion(r.Context(), path, version); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to delete version: "+err.Error())
+		return
+	}
+
+	backend, _, err := s.storageRouter.ResolveForFile(r.Context(), vRecord.StorageLocID, nil)
+	if err == nil && backend != nil {
+		key := postgres.VersionContentKey(path, version, vRecord.S3Key)
+		if err := s.metadata.ReleaseContent(r.Context(), key, vRecord.StorageLocID, backend.DeleteObject); err != nil {
+			logging.Warn("failed to delete version content",
+				zap.String("path", path), zap.Int("version", version), zap.Error(err))
+		}
+	}
+
+	logging.Info("version deleted",
+		zap.String("path", path),
+		zap.Int("version", version),
+		zap.String("user", claims.Username))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":             path,
-		"restored_version": req.Version,
-		"new_version":      newVersion,
+		"path":    path,
+		"version": version,
+		"deleted": true,
 	})
 }
 
@@ -1353,22 +2170,92 @@ func (s *Server) handleSetPermission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.permissions.SetPermission(r.Context(), req.UserID, path, req.Permission); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to set permission: "+err.Error())
-		return
+	if req.Recursive || req.Explicit {
+		if node := s.findNode(s.currentTree(), path); node == nil || !node.IsDir {
+			s.sendError(w, http.StatusBadRequest, "recursive permissions need a directory")
+			return
+		}
+	}
+
+	resp := map[string]interface{}{
+		"path":       path,
+		"user_id":    req.UserID,
+		"permission": req.Permission,
+	}
+	details := map[string]interface{}{
+		"user_id":    req.UserID,
+		"permission": req.Permission,
 	}
+	switch {
+	case req.Explicit:
+		count, jobID, err := s.setPermissionTree(r.Context(), claims, req.UserID, path, req.Permission)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to set permissions: "+err.Error())
+			return
+		}
+		resp["explicit"], resp["count"], resp["job_id"] = true, count, jobID
+		details["explicit"], details["count"] = true, count
+	case req.Recursive:
+		if err := s.permissions.SetRecursivePermission(r.Context(), req.UserID, path, req.Permission, claims.UserID); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to set permission: "+err.Error())
+			return
+		}
+		resp["recursive"] = true
+		details["recursive"] = true
+	default:
+		if err := s.permissions.SetPermission(r.Context(), req.UserID, path, req.Permission, claims.UserID); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to set permission: "+err.Error())
+			return
+		}
+	}
+	s.notifyPermissionGranted(claims, path, &protocol.GrantPayload{Permission: req.Permission}, req.UserID)
 
 	logging.Info("permission set",
 		zap.String("path", path),
 		zap.Int("user_id", req.UserID),
-		zap.String("permission", req.Permission))
+		zap.String("permission", req.Permission),
+		zap.Bool("recursive", req.Recursive),
+		zap.Bool("explicit", req.Explicit))
+	s.recordActivity(r.Context(), claims, activity.ActionPermissionSet, path, details)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":       path,
-		"user_id":    req.UserID,
-		"permission": req.Permission,
-	})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleSharedWithMe lists what other users shared with the current user,
+// directly or through a group.
+func (s *Server) handleSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	items, err := s.permissions.SharedWith(r.Context(), claims.UserID)
+	if err != nil {
+		logging.Error("list shared with me", zap.Error(err))
+		s.sendError(w, http.StatusInternalServerError, "failed to list shared items")
+		return
+	}
+
+	resp := make([]protocol.SharedWithMeEntry, 0, len(items))
+	for _, it := range items {
+		resp = append(resp, protocol.SharedWithMeEntry{
+			Path:       it.Path,
+			Name:       it.Name,
+			IsDir:      it.IsDir,
+			Size:       it.Size,
+			ModTime:    it.ModTime,
+			Permission: it.Permission,
+			GrantedBy:  it.GrantedBy,
+			GrantedAt:  it.GrantedAt,
+			GroupID:    it.GroupID,
+			GroupName:  it.GroupName,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleListPermissions(w http.ResponseWriter, r *http.Request) {
@@ -1402,6 +2289,7 @@ func (s *Server) handleListPermissions(w http.ResponseWriter, r *http.Request) {
 			Username:   p.Username,
 			Path:       p.Path,
 			Permission: p.Permission,
+			Recursive:  p.Recursive,
 		})
 	}
 
@@ -1437,19 +2325,32 @@ func (s *Server) handleDeletePermission(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.permissions.RemovePermission(r.Context(), userID, path); err != nil {
+	resp := map[string]interface{}{
+		"path":    path,
+		"user_id": userID,
+		"removed": true,
+	}
+	details := map[string]interface{}{
+		"user_id": userID,
+	}
+	// ?recursive=true also removes the user's permissions below path
+	if r.URL.Query().Get("recursive") == "true" {
+		n, err := s.permissions.RemovePermissionTree(r.Context(), userID, path)
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to remove permissions: "+err.Error())
+			return
+		}
+		resp["count"], details["recursive"], details["count"] = n, true, n
+	} else if err := s.permissions.RemovePermission(r.Context(), userID, path); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to remove permission: "+err.Error())
 		return
 	}
 
 	logging.Info("permission removed", zap.String("path", path), zap.Int("user_id", userID))
+	s.recordActivity(r.Context(), claims, activity.ActionPermissionRemove, path, details)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":    path,
-		"user_id": userID,
-		"removed": true,
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 // ─── Share Links ────────────────────────────────────────────────────────────
@@ -1495,7 +2396,13 @@ func (s *Server) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	link, err := s.shareLinks.Create(r.Context(), path, claims.UserID, req.Password, req.ExpiresInSec, req.MaxDownloads)
+	upload, status, msg := s.shareUploadOptions(r.Context(), claims, path, &req)
+	if status != 0 {
+		s.sendError(w, status, msg)
+		return
+	}
+
+	link, err := s.shareLinks.Create(r.Context(), path, claims.UserID, req.Password, req.ExpiresInSec, req.MaxDownloads, upload)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to create share link: "+err.Error())
 		return
@@ -1514,14 +2421,24 @@ func (s *Server) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
 	logging.Info("share link created",
 		zap.String("path", path),
 		zap.String("link_id", link.ID))
+	s.recordActivity(r.Context(), claims, activity.ActionShareCreate, path, map[string]interface{}{
+		"link_id":       link.ID,
+		"password":      req.Password != "",
+		"max_downloads": req.MaxDownloads,
+		"allow_upload":  link.AllowUpload,
+	})
 
 	resp := protocol.ShareLinkResponse{
-		ID:           link.ID,
-		Path:         link.Path,
-		URL:          shareURL,
-		ExpiresAt:    link.ExpiresAt,
-		MaxDownloads: link.MaxDownloads,
-		CreatedAt:    link.CreatedAt,
+		ID:             link.ID,
+		Path:           link.Path,
+		URL:            shareURL,
+		ExpiresAt:      link.ExpiresAt,
+		MaxDownloads:   link.MaxDownloads,
+		CreatedAt:      link.CreatedAt,
+		AllowUpload:    link.AllowUpload,
+		AllowDownload:  link.AllowDownload,
+		MaxUploadBytes: link.MaxUploadBytes,
+		MaxUploadFiles: link.MaxUploadFiles,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1552,9 +2469,32 @@ func (s *Server) handleShareDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Resolve backend for this file
-	backend, _, err := s.storageRouter.ResolveForFile(r.Context(), fileRow.StorageLocID, fileRow.GroupID)
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), fileRow.StorageLocID, fileRow.GroupID)
+	if err != nil {
+		s.sendStorageError(w, err)
+		return
+	}
+
+	// Downloads count against the bandwidth of the link's creator
+	creator, err := s.auth.GetUser(r.Context(), link.CreatedBy)
 	if err != nil {
-		s.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
+		s.sendError(w, http.StatusNotFound, "shared file not found")
+		return
+	}
+	limit, ok := s.downloadAllowed(w, r, creator.ID, creator.IsAdmin, link.Path, "share")
+	if !ok {
+		return
+	}
+
+	disposition := fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(link.Path))
+
+	// Redirect opted-in clients straight to the object store
+	if url := presignedURL(r, backend, fileRow.S3Key, "application/octet-stream", disposition); url != "" {
+		s.shareLinks.IncrementDownloads(r.Context(), token)
+		metrics.RecordContentDownload(fileRow.Size, true)
+		s.quotaStore.TrackBandwidth(r.Context(), creator.ID, 0, fileRow.Size)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusFound)
 		return
 	}
 
@@ -1570,11 +2510,16 @@ func (s *Server) handleShareDownload(w http.ResponseWriter, r *http.Request) {
 	s.shareLinks.IncrementDownloads(r.Context(), token)
 
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(link.Path)))
+	w.Header().Set("Content-Disposition", disposition)
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.WriteHeader(http.StatusOK)
 
-	n, err := io.Copy(w, reader)
+	meter := s.quotaStore.NewMeter(r.Context(), w, creator.ID, limit)
+	n, err := io.Copy(meter, reader)
+	meter.Finish()
+	if meter.Exceeded() {
+		metrics.RecordDownloadDenied("share")
+	}
 	if err != nil {
 		logging.Warn("share link transfer error", zap.String("token", token), zap.Error(err))
 	}
@@ -1599,18 +2544,42 @@ func (s *Server) handleShareInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := protocol.ShareInfoResponse{
-		HasPassword: info.HasPassword,
-		ExpiresAt:   info.ExpiresAt,
-		Valid:       info.Valid,
-		Error:       info.Error,
-	}
-
-	// Look up file metadata for name and size
-	if info.Valid {
+		HasPassword:   info.HasPassword,
+		ExpiresAt:     info.ExpiresAt,
+		Valid:         info.Valid,
+		Error:         info.Error,
+		AllowUpload:   info.AllowUpload,
+		AllowDownload: info.AllowDownload,
+	}
+	if info.AllowUpload && info.MaxUploadBytes > 0 {
+		left := max(info.MaxUploadBytes-info.UploadedBytes, 0)
+		resp.UploadBytesLeft = &left
+	}
+	if info.AllowUpload && info.MaxUploadFiles > 0 {
+		left := max(info.MaxUploadFiles-info.UploadedFiles, 0)
+		resp.UploadFilesLeft = &left
+	}
+
+	// Album links show the album's name
+	if info.AlbumID != nil {
+		resp.IsAlbum = true
+		resp.AllowDownload = false
+		if s.galleryStore != nil {
+			if album, err := s.galleryStore.GetAlbum(r.Context(), *info.AlbumID); err == nil && album != nil {
+				resp.FileName = album.Name
+			}
+		}
+	} else if info.Valid {
+		// Look up file metadata for name and size
 		fileRow, err := s.metadata.GetFileRow(r.Context(), info.Path)
 		if err == nil && fileRow != nil {
 			resp.FileName = fileRow.Name
 			resp.FileSize = fileRow.Size
+			resp.IsDir = fileRow.IsDir
+			if !fileRow.IsDir {
+				resp.MimeType = shareMimeType(fileRow.Name)
+				resp.PreviewAvailable = info.AllowDownload && s.sharePreviewAvailable(r.Context(), fileRow)
+			}
 		} else {
 			resp.FileName = filepath.Base(info.Path)
 		}
@@ -1650,6 +2619,9 @@ func (s *Server) handleRevokeShareLink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logging.Info("share link revoked", zap.String("link_id", linkID))
+	s.recordActivity(r.Context(), claims, activity.ActionShareRevoke, link.Path, map[string]interface{}{
+		"link_id": linkID,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1686,6 +2658,7 @@ func (s *Server) handleGetQuota(w http.ResponseWriter, r *http.Request) {
 		MaxBandwidthPerDay: q.MaxBandwidthPerDay,
 		MaxRequestsPerMin:  q.MaxRequestsPerMin,
 		MaxUploadSizeBytes: q.MaxUploadSizeBytes,
+		TrashRetentionDays: q.TrashRetentionDays,
 	})
 }
 
@@ -1727,6 +2700,13 @@ func (s *Server) handleSetQuota(w http.ResponseWriter, r *http.Request) {
 	if req.MaxUploadSizeBytes != nil {
 		current.MaxUploadSizeBytes = *req.MaxUploadSizeBytes
 	}
+	if req.TrashRetentionDays != nil {
+		if *req.TrashRetentionDays < 0 {
+			current.TrashRetentionDays = nil
+		} else {
+			current.TrashRetentionDays = req.TrashRetentionDays
+		}
+	}
 
 	if err := s.quotaStore.SetQuota(r.Context(), current); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to set quota: "+err.Error())
@@ -1742,6 +2722,7 @@ func (s *Server) handleSetQuota(w http.ResponseWriter, r *http.Request) {
 		MaxBandwidthPerDay: current.MaxBandwidthPerDay,
 		MaxRequestsPerMin:  current.MaxRequestsPerMin,
 		MaxUploadSizeBytes: current.MaxUploadSizeBytes,
+		TrashRetentionDays: current.TrashRetentionDays,
 	})
 }
 
@@ -1758,7 +2739,7 @@ func (s *Server) handleGetUsage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	storageUsed, err := s.quotaStore.GetStorageUsed(r.Context(), claims.UserID)
+	usage, err := s.quotaStore.GetUsage(r.Context(), claims.UserID)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to get storage usage: "+err.Error())
 		return
@@ -1773,7 +2754,10 @@ func (s *Server) handleGetUsage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(protocol.UsageResponse{
 		UserID:         claims.UserID,
-		StorageUsed:    storageUsed,
+		StorageUsed:    s.quotaStore.Counted(usage),
+		ContentBytes:   usage.Content,
+		VersionsBytes:  usage.Versions,
+		ThumbsBytes:    usage.Thumbs,
 		BandwidthToday: bIn + bOut,
 		Quota: protocol.UserQuotaResponse{
 			UserID:             q.UserID,
@@ -1781,6 +2765,7 @@ func (s *Server) handleGetUsage(w http.ResponseWriter, r *http.Request) {
 			MaxBandwidthPerDay: q.MaxBandwidthPerDay,
 			MaxRequestsPerMin:  q.MaxRequestsPerMin,
 			MaxUploadSizeBytes: q.MaxUploadSizeBytes,
+			TrashRetentionDays: q.TrashRetentionDays,
 		},
 	})
 }
@@ -1858,6 +2843,8 @@ func (s *Server) handleUserDashboard(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	trashWarning, trashBytes := s.trashWarning(ctx, claims.UserID)
+
 	resp := protocol.UserDashboardResponse{
 		UserID:         claims.UserID,
 		Username:       claims.Username,
@@ -1869,11 +2856,14 @@ func (s *Server) handleUserDashboard(w http.ResponseWriter, r *http.Request) {
 			MaxBandwidthPerDay: q.MaxBandwidthPerDay,
 			MaxRequestsPerMin:  q.MaxRequestsPerMin,
 			MaxUploadSizeBytes: q.MaxUploadSizeBytes,
+			TrashRetentionDays: q.TrashRetentionDays,
 		},
 		Groups:           groups,
 		FileCount:        fileCount,
 		ShareLinkCount:   shareLinkCount,
 		BandwidthHistory: protoHistory,
+		TrashBytes:       trashBytes,
+		TrashWarning:     trashWarning,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1882,6 +2872,7 @@ func (s *Server) handleUserDashboard(w http.ResponseWriter, r *http.Request) {
 
 // ─── Activity Feed ───────────────────────────────────────────────────────────
 
+// handleActivity handles GET /api/v1/activity: the caller's own activity.
 func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
 	claims := auth.GetClaims(r.Context())
 	if claims == nil {
@@ -1889,9 +2880,39 @@ func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	limitStr := r.URL.Query().Get("limit")
+	limit, before, action := activityQuery(r)
+	entries, err := s.metadata.GetUserActivity(r.Context(), claims.UserID, limit, before, action)
+	s.sendActivity(w, entries, err)
+}
+
+// handleAdminActivity handles GET /api/v1/admin/activity: activity of all
+// users, optionally filtered by user_id.
+func (s *Server) handleAdminActivity(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	limit, before, action := activityQuery(r)
+	var entries []postgres.ActivityEntry
+	var err error
+	if userStr := r.URL.Query().Get("user_id"); userStr != "" {
+		userID, convErr := strconv.Atoi(userStr)
+		if convErr != nil {
+			s.sendError(w, http.StatusBadRequest, "invalid user_id")
+			return
+		}
+		entries, err = s.metadata.GetUserActivity(r.Context(), userID, limit, before, action)
+	} else {
+		entries, err = s.metadata.GetActivity(r.Context(), limit, before, action)
+	}
+	s.sendActivity(w, entries, err)
+}
+
+// activityQuery parses the limit, before (RFC 3339) and action parameters
+// of the activity endpoints.
+func activityQuery(r *http.Request) (int, *time.Time, string) {
 	limit := 50
-	if limitStr != "" {
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if v, err := strconv.Atoi(limitStr); err == nil && v > 0 && v <= 200 {
 			limit = v
 		}
@@ -1904,13 +2925,10 @@ func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var entries []postgres.ActivityEntry
-	var err error
-	if claims.IsAdmin {
-		entries, err = s.metadata.GetActivity(r.Context(), limit, before)
-	} else {
-		entries, err = s.metadata.GetUserActivity(r.Context(), claims.UserID, limit, before)
-	}
+	return limit, before, r.URL.Query().Get("action")
+}
+
+func (s *Server) sendActivity(w http.ResponseWriter, entries []postgres.ActivityEntry, err error) {
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to get activity: "+err.Error())
 		return
@@ -1945,7 +2963,7 @@ func (s *Server) handleFileProperties(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get file metadata
-	node := s.findNode(s.tree, path)
+	node := s.findNode(s.currentTree(), path)
 	if node == nil {
 		s.sendError(w, http.StatusNotFound, "path not found: "+path)
 		return
@@ -1962,12 +2980,22 @@ func (s *Server) handleFileProperties(w http.ResponseWriter, r *http.Request) {
 		Version:    node.Version,
 		OwnerID:    node.OwnerID,
 		GroupID:    node.GroupID,
-		Visibility: node.Visibility,
+		Visibility: s.inheritedVisibility(node.Path).Inherit(node).Value,
 	}
 	if resp.Visibility == "" {
 		resp.Visibility = "public"
 	}
 
+	if node.IsDir {
+		agg := node
+		if !claims.IsAdmin {
+			agg = s.filterTree(r.Context(), node, claims, -1)
+		}
+		if agg != nil {
+			resp.AggSize, resp.ItemCount = agg.AggSize, agg.ItemCount
+		}
+	}
+
 	// Resolve owner name
 	if node.OwnerID > 0 {
 		if name, err := s.groups.GetUsernameByID(r.Context(), node.OwnerID); err == nil {
@@ -2028,6 +3056,24 @@ func (s *Server) handleFileProperties(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if n, err := s.metadata.CommentCount(r.Context(), path); err == nil {
+		resp.CommentCount = n
+	} else {
+		logging.Debug("properties: failed to count comments", zap.String("path", path), zap.Error(err))
+	}
+
+	if !node.IsDir {
+		if is, err := s.metadata.OpenIntegrityIssue(r.Context(), path); err == nil && is != nil {
+			resp.IntegrityIssue = &protocol.IntegrityIssueInfo{
+				DetectedAt: is.DetectedAt,
+				ActualHash: is.ActualHash,
+				Error:      is.Error,
+			}
+		} else if err != nil {
+			logging.Debug("properties: failed to get integrity issue", zap.String("path", path), zap.Error(err))
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -2035,48 +3081,11 @@ func (s *Server) handleFileProperties(w http.ResponseWriter, r *http.Request) {
 // ─── Helpers ────────────────────────────────────────────────────────────────
 
 func (s *Server) ensureParentDirs(ctx context.Context, path string) error {
-	parts := strings.Split(strings.Trim(path, "/"), "/")
-	if len(parts) <= 1 {
-		return nil
-	}
-
-	currentPath := ""
-	for i := 0; i < len(parts)-1; i++ {
-		currentPath += "/" + parts[i]
-
-		exists, err := s.metadata.PathExists(ctx, currentPath)
-		if err != nil {
-			return err
-		}
-		if exists {
-			continue
-		}
-
-		parentPath := filepath.Dir(currentPath)
-		if parentPath == "." {
-			parentPath = "/"
-		}
-
-		fileRow := &postgres.FileRow{
-			ID:         fileID(currentPath),
-			Name:       parts[i],
-			Path:       currentPath,
-			ParentPath: parentPath,
-			IsDir:      true,
-			ModTime:    time.Now(),
-		}
-
-		if err := s.metadata.UpsertFile(ctx, fileRow); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return s.uploads.EnsureParentDirs(ctx, path)
 }
 
 func fileID(path string) string {
-	h := sha256.Sum256([]byte(path))
-	return fmt.Sprintf("%x", h[:8])
+	return upload.FileID(path)
 }
 
 type gzipResponseWriter struct {
@@ -2142,11 +3151,56 @@ func parseRangeHeader(rangeHeader string, totalSize int64) (offset, length int64
 	return offset, length, true
 }
 
-func (s *Server) sendError(w http.ResponseWriter, code int, message string) {
+// sendError writes an ErrorResponse with the generic error code of status.
+func (s *Server) sendError(w http.ResponseWriter, status int, message string) {
+	s.sendErrorCode(w, status, protocol.ErrorCodeForStatus(status), message, "")
+}
+
+// sendErrorCode writes an ErrorResponse with a specific error code, for
+// failures a client tells apart by more than the HTTP status.
+func (s *Server) sendErrorCode(w http.ResponseWriter, status int, code protocol.ErrorCode, message, details string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(protocol.ErrorResponse{
-		Error: message,
-		Code:  code,
+		Error:     message,
+		Code:      status,
+		ErrorCode: code,
+		Details:   details,
+		RequestID: w.Header().Get("X-Request-ID"), // set by logging.Middleware
 	})
 }
+
+// validateNewPath checks the path of a file or directory about to be
+// created or renamed to: the rules of paths.ValidatePath, and that it maps
+// to an object key outside FruitSalade's reserved prefixes.
+func validateNewPath(p string) error {
+	if err := paths.ValidatePath(p); err != nil {
+		return err
+	}
+	_, err := storage.KeyForPath(p)
+	return err
+}
+
+// isPathError reports whether err comes from validateNewPath or
+// paths.ValidateSubtree.
+func isPathError(err error) bool {
+	var pathErr *paths.Error
+	return errors.As(err, &pathErr) || errors.Is(err, storage.ErrInvalidKey)
+}
+
+// sendPathError rejects a request naming a path that validateNewPath
+// refused with a 400 carrying DetailInvalidPath.
+func (s *Server) sendPathError(w http.ResponseWriter, err error) {
+	s.sendErrorCode(w, http.StatusBadRequest, protocol.ErrCodeInvalidPath, err.Error(), protocol.DetailInvalidPath)
+}
+
+// sendStorageError reports a failure to resolve a storage backend: 503 for
+// a location that failed its health check, 500 otherwise.
+func (s *Server) sendStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrStorageUnavailable) {
+		w.Header().Set("Retry-After", "30")
+		s.sendErrorCode(w, http.StatusServiceUnavailable, protocol.ErrCodeStorageUnavailable, err.Error()+", try again later", "")
+		return
+	}
+	s.sendError(w, http.StatusInternalServerError, "no storage backend: "+err.Error())
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/thumbs"
+)
+
+// ─── Share Link Previews ────────────────────────────────────────────────────
+
+// sharePreviewMaxAge is how long browsers may reuse a share preview. It is
+// short so a revoked or expired link stops showing one soon.
+const sharePreviewMaxAge = 5 * time.Minute
+
+// sharePreviewAvailable reports whether handleSharePreview can serve a
+// preview of fileRow.
+func (s *Server) sharePreviewAvailable(ctx context.Context, fileRow *postgres.FileRow) bool {
+	if fileRow == nil || fileRow.IsDir {
+		return false
+	}
+	if s.thumbnails != nil && s.thumbnails.Supports(fileRow.Path) {
+		return true
+	}
+	return s.galleryStore != nil && thumbs.KindOf(fileRow.Path) == thumbs.KindImage &&
+		s.galleryStore.GetThumbKey(ctx, fileRow.Path) != ""
+}
+
+// shareMimeType returns the MIME type of a shared file by its extension.
+func shareMimeType(name string) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// handleSharePreview serves a thumbnail of a shared file: the image itself
+// scaled down, or a rendering of the first page of a PDF or the first lines
+// of a text file. It is authorized by the link alone (and its password),
+// always shows the link's own path, and does not count as a download.
+func (s *Server) handleSharePreview(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	link, err := s.shareLinks.Validate(r.Context(), token, r.URL.Query().Get("password"))
+	if err != nil {
+		s.sendError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	size, err := thumbs.ParseSize(r.URL.Query().Get("size"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fileRow, err := s.metadata.GetFileRow(r.Context(), link.Path)
+	if err != nil || fileRow == nil {
+		s.sendError(w, http.StatusNotFound, "shared file not found")
+		return
+	}
+	if !s.sharePreviewAvailable(r.Context(), fileRow) {
+		s.sendError(w, http.StatusNotFound, "no preview for this file")
+		return
+	}
+
+	// The URL carries the token: keep the preview out of shared caches
+	cacheControl := fmt.Sprintf("private, max-age=%d", int(sharePreviewMaxAge.Seconds()))
+	if s.thumbnails != nil && s.thumbnails.Supports(fileRow.Path) {
+		s.serveThumb(w, r, fileRow, size, cacheControl)
+		return
+	}
+	s.serveGalleryThumb(w, r, fileRow.Path, cacheControl)
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Share Link Uploads ─────────────────────────────────────────────────────
+//
+// A share link for a folder can accept uploads from anyone holding it. The
+// files are stored as if the link's creator had uploaded them: the
+// creator's write access, upload size limit and storage quota apply, and
+// the creator owns the files.
+
+// shareUploadOptions checks the upload settings of a share link request.
+// It returns the options to create the link with, or a non-zero status and
+// message to reject the request with.
+func (s *Server) shareUploadOptions(ctx context.Context, claims *auth.Claims, filePath string, req *protocol.ShareLinkRequest) (*sharing.UploadOptions, int, string) {
+	allowDownload := req.AllowDownload == nil || *req.AllowDownload
+	if !req.AllowUpload {
+		if !allowDownload {
+			return nil, http.StatusBadRequest, "a share link must allow downloads or uploads"
+		}
+		return nil, 0, ""
+	}
+	if req.MaxUploadBytes < 0 || req.MaxUploadFiles < 0 {
+		return nil, http.StatusBadRequest, "upload limits must not be negative"
+	}
+
+	row, err := s.metadata.GetFileRow(ctx, filePath)
+	if err != nil || row == nil || !row.IsDir {
+		return nil, http.StatusBadRequest, "uploads can only be allowed on folder links"
+	}
+	if !s.permissions.CheckAccess(ctx, claims.UserID, filePath, "write", claims.IsAdmin) {
+		return nil, http.StatusForbidden, "write access required to allow uploads"
+	}
+
+	return &sharing.UploadOptions{
+		AllowDownload: allowDownload,
+		MaxBytes:      req.MaxUploadBytes,
+		MaxFiles:      req.MaxUploadFiles,
+	}, 0, ""
+}
+
+// handleShareUpload stores a file sent to an upload-enabled folder link.
+// Existing files are never replaced: if the name is taken, a " (n)" suffix
+// is added.
+func (s *Server) handleShareUpload(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	name := r.PathValue("filename")
+	if !validShareUploadName(name) {
+		s.sendError(w, http.StatusBadRequest, "invalid file name")
+		return
+	}
+
+	link, err := s.shareLinks.ValidateUpload(r.Context(), token, r.URL.Query().Get("password"))
+	if err != nil {
+		if errors.Is(err, sharing.ErrUploadLimit) {
+			s.sendErrorCode(w, http.StatusRequestEntityTooLarge, protocol.ErrCodeQuotaExceeded, err.Error(), "")
+			return
+		}
+		s.sendError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	dir, err := s.metadata.GetFileRow(r.Context(), link.Path)
+	if err != nil || dir == nil || !dir.IsDir {
+		s.sendError(w, http.StatusNotFound, "shared folder not found")
+		return
+	}
+
+	creator, err := s.auth.GetUser(r.Context(), link.CreatedBy)
+	if err != nil || creator.Disabled {
+		s.sendError(w, http.StatusForbidden, "share link is no longer available")
+		return
+	}
+	claims := &auth.Claims{UserID: creator.ID, Username: creator.Username, IsAdmin: creator.IsAdmin}
+
+	limit := s.uploadLimit(r.Context(), claims)
+	if link.MaxUploadBytes > 0 {
+		limit = min(limit, link.MaxUploadBytes-link.UploadedBytes)
+	}
+	if r.ContentLength > limit {
+		s.sendError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("file too large: max %d bytes", limit))
+		return
+	}
+	content, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to read content")
+		return
+	}
+	size := int64(len(content))
+	if size > limit {
+		s.sendError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("file too large: max %d bytes", limit))
+		return
+	}
+
+	if err := s.shareLinks.ReserveUpload(r.Context(), token, size); err != nil {
+		if errors.Is(err, sharing.ErrUploadLimit) {
+			s.sendErrorCode(w, http.StatusRequestEntityTooLarge, protocol.ErrCodeQuotaExceeded, err.Error(), "")
+			return
+		}
+		s.sendError(w, http.StatusInternalServerError, "failed to record upload")
+		return
+	}
+
+	// Hand the content to the regular upload path as the creator. It only
+	// creates: when a concurrent upload claimed the free name first, the
+	// next free one is tried.
+	var filePath string
+	var rec *capturedResponse
+	for n := 0; ; n++ {
+		filePath, n, err = s.freeChildPath(r.Context(), link.Path, name, n)
+		if err != nil {
+			s.shareLinks.ReleaseUpload(r.Context(), token, size)
+			s.sendError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		upload := r.Clone(auth.WithClaims(r.Context(), claims))
+		upload.SetPathValue("path", strings.TrimPrefix(filePath, "/"))
+		upload.Body = io.NopCloser(bytes.NewReader(content))
+		upload.ContentLength = size
+		upload.Header.Del("If-Match")
+		upload.Header.Del("X-Expected-Version")
+		upload.Header.Set("If-None-Match", "*")
+
+		rec = newCapturedResponse()
+		s.handleUpload(rec, upload)
+		if rec.status != http.StatusPreconditionFailed {
+			break
+		}
+	}
+	if rec.status != http.StatusCreated {
+		s.shareLinks.ReleaseUpload(r.Context(), token, size)
+		rec.copyTo(w)
+		return
+	}
+
+	logging.Info("file uploaded via share link",
+		zap.String("link_id", link.ID),
+		zap.String("path", filePath),
+		zap.Int64("size", size))
+	s.recordActivity(r.Context(), claims, activity.ActionShareUpload, filePath, map[string]interface{}{
+		"link_id": link.ID,
+		"size":    size,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(protocol.ShareUploadResponse{
+		Name: path.Base(filePath),
+		Size: size,
+	})
+}
+
+// handleShareFiles lists the files of a shared folder. Only links that
+// allow downloads can be listed, so an upload-only link does not reveal
+// what others have uploaded.
+func (s *Server) handleShareFiles(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	link, err := s.shareLinks.Validate(r.Context(), token, r.URL.Query().Get("password"))
+	if err != nil {
+		s.sendError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	dir, err := s.metadata.GetFileRow(r.Context(), link.Path)
+	if err != nil || dir == nil || !dir.IsDir {
+		s.sendError(w, http.StatusBadRequest, "share link is not for a folder")
+		return
+	}
+
+	nodes, err := s.metadata.ListDir(r.Context(), link.Path)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list files")
+		return
+	}
+
+	files := make([]protocol.ShareFileEntry, 0, len(nodes))
+	for _, n := range nodes {
+		files = append(files, protocol.ShareFileEntry{
+			Name:    n.Name,
+			Size:    n.Size,
+			ModTime: n.ModTime,
+			IsDir:   n.IsDir,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// freeChildPath returns dir/name, or dir/"stem (n).ext", for the first n
+// from on that is not taken, and that n. The name is only free when
+// checked; the upload must still claim it.
+func (s *Server) freeChildPath(ctx context.Context, dir, name string, from int) (string, int, error) {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := from; n < 100; n++ {
+		candidate := name
+		if n > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		}
+		p := path.Join(dir, candidate)
+		row, err := s.metadata.GetFileRow(ctx, p)
+		if err != nil {
+			return "", n, fmt.Errorf("check %s: %w", candidate, err)
+		}
+		if row == nil {
+			return p, n, nil
+		}
+	}
+	return "", 0, fmt.Errorf("too many files named %q", name)
+}
+
+// validShareUploadName reports whether name can be used as a file name in
+// a shared folder: a single valid path element of at most 255 bytes.
+func validShareUploadName(name string) bool {
+	if len(name) > 255 || strings.Contains(name, `\`) {
+		return false
+	}
+	return paths.ValidateName(name) == nil
+}
+
+// capturedResponse buffers a response so a handler's result can be
+// inspected before anything reaches the client.
+type capturedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newCapturedResponse() *capturedResponse {
+	return &capturedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (c *capturedResponse) Header() http.Header         { return c.header }
+func (c *capturedResponse) Write(b []byte) (int, error) { return c.body.Write(b) }
+func (c *capturedResponse) WriteHeader(status int)      { c.status = status }
+
+func (c *capturedResponse) copyTo(w http.ResponseWriter) {
+	for k, v := range c.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body.Bytes())
+}
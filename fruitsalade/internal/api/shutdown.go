@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+)
+
+// BeginShutdown puts the server into draining mode before the HTTP server
+// is shut down: new upload sessions are refused, readiness reports 503 so
+// load balancers stop routing here, and SSE clients get a final
+// server-shutdown event and are disconnected so they reconnect to another
+// instance. Requests already in flight are unaffected.
+func (s *Server) BeginShutdown() {
+	if !s.draining.CompareAndSwap(false, true) {
+		return
+	}
+	if s.broadcaster != nil {
+		s.broadcaster.Close()
+	}
+}
+
+// Draining reports whether BeginShutdown was called.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// InFlight returns the number of requests currently being served.
+func (s *Server) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// trackInFlight counts requests for InFlight.
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
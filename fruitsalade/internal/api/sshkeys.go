@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+)
+
+// handleListSSHKeys handles GET /api/v1/auth/ssh-keys (protected).
+func (s *Server) handleListSSHKeys(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	keys, err := s.auth.ListSSHKeys(r.Context(), claims.UserID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list ssh keys: "+err.Error())
+		return
+	}
+	if keys == nil {
+		keys = []auth.SSHKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handleAddSSHKey handles POST /api/v1/auth/ssh-keys (protected).
+// The key is given in authorized_keys format.
+func (s *Server) handleAddSSHKey(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var req struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PublicKey == "" {
+		s.sendError(w, http.StatusBadRequest, "public_key is required")
+		return
+	}
+
+	if _, _, err := auth.ParseAuthorizedKey(req.PublicKey); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, err := s.auth.AddSSHKey(r.Context(), claims.UserID, strings.TrimSpace(req.Name), req.PublicKey)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "already registered") {
+			code = http.StatusConflict
+		}
+		s.sendError(w, code, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// handleDeleteSSHKey handles DELETE /api/v1/auth/ssh-keys/{keyID} (protected).
+func (s *Server) handleDeleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.PathValue("keyID"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid key ID")
+		return
+	}
+
+	if err := s.auth.DeleteSSHKey(r.Context(), claims.UserID, keyID); err != nil {
+		code := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			code = http.StatusNotFound
+		}
+		s.sendError(w, code, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"key_id": keyID, "deleted": true})
+}
@@ -2,12 +2,17 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/importer"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
 )
@@ -28,7 +33,7 @@ func (s *Server) handleListStorageLocations(w http.ResponseWriter, r *http.Reque
 	// Redact secrets in configs
 	resp := make([]map[string]interface{}, 0, len(locs))
 	for _, loc := range locs {
-		resp = append(resp, redactedLocationMap(loc))
+		resp = append(resp, s.locationStatusMap(loc))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -57,7 +62,7 @@ func (s *Server) handleGetStorageLocation(w http.ResponseWriter, r *http.Request
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(redactedLocationMap(*loc))
+	json.NewEncoder(w).Encode(s.locationStatusMap(*loc))
 }
 
 func (s *Server) handleCreateStorageLocation(w http.ResponseWriter, r *http.Request) {
@@ -97,6 +102,14 @@ func (s *Server) handleCreateStorageLocation(w http.ResponseWriter, r *http.Requ
 	if req.ReadOnly != nil {
 		row.ReadOnly = *req.ReadOnly
 	}
+	if keyID := storage.EncryptionKeyID(row.Config); keyID != "" && !s.storageRouter.Keyring().Has(keyID) {
+		s.sendError(w, http.StatusBadRequest, "unknown encryption key: "+keyID)
+		return
+	}
+	if id := storage.ReplicaOf(row.Config); id != 0 && s.storageRouter.GetLocation(id) == nil {
+		s.sendError(w, http.StatusBadRequest, "replica_of names an unknown storage location")
+		return
+	}
 
 	created, err := s.locationStore.Create(r.Context(), row)
 	if err != nil {
@@ -171,6 +184,14 @@ func (s *Server) handleUpdateStorageLocation(w http.ResponseWriter, r *http.Requ
 	if req.ReadOnly != nil {
 		existing.ReadOnly = *req.ReadOnly
 	}
+	if keyID := storage.EncryptionKeyID(existing.Config); keyID != "" && !s.storageRouter.Keyring().Has(keyID) {
+		s.sendError(w, http.StatusBadRequest, "unknown encryption key: "+keyID)
+		return
+	}
+	if rid := storage.ReplicaOf(existing.Config); rid != 0 && (rid == id || s.storageRouter.GetLocation(rid) == nil) {
+		s.sendError(w, http.StatusBadRequest, "replica_of must name another storage location")
+		return
+	}
 
 	if err := s.locationStore.Update(r.Context(), existing); err != nil {
 		s.sendError(w, http.StatusInternalServerError, "failed to update storage location: "+err.Error())
@@ -243,7 +264,7 @@ func (s *Server) handleTestStorageLocation(w http.ResponseWriter, r *http.Reques
 
 	// Create a temporary backend to test connectivity
 	ctx := r.Context()
-	backend, err := storage.NewBackendFromConfig(ctx, loc.BackendType, loc.Config)
+	backend, err := s.storageRouter.OpenBackend(ctx, loc.BackendType, loc.Config)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -257,7 +278,7 @@ func (s *Server) handleTestStorageLocation(w http.ResponseWriter, r *http.Reques
 	if loc.ReadOnly {
 		// Read-only location: only test read connectivity (list/stat probe)
 		// Try to stat a known key; a "not found" error is fine — it proves connectivity.
-		_, _, statErr := backend.GetObject(ctx, "_fruitsalade_read_probe", 0, 0)
+		_, _, statErr := backend.StatObject(ctx, "_fruitsalade_read_probe")
 		// Accept "not found" style errors as success (connectivity works)
 		if statErr != nil && !isNotFoundError(statErr) {
 			w.Header().Set("Content-Type", "application/json")
@@ -290,6 +311,20 @@ func (s *Server) handleTestStorageLocation(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	size, _, err := backend.StatObject(ctx, testKey)
+	if err == nil && size != int64(len(testData)) {
+		err = fmt.Errorf("stored %d bytes, wrote %d", size, len(testData))
+	}
+	if err != nil {
+		backend.DeleteObject(ctx, testKey)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "stat test failed: " + err.Error(),
+		})
+		return
+	}
+
 	rc, _, err := backend.GetObject(ctx, testKey, 0, 0)
 	if err != nil {
 		backend.DeleteObject(ctx, testKey)
@@ -366,6 +401,214 @@ func (s *Server) handleStorageStats(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleRekeyStorageLocation switches an encrypted location to another
+// master key and starts re-wrapping the data keys of every object in its
+// backend, thumbnails included; the content itself is not re-encrypted.
+// The re-wrapping runs in the background; poll GET on the same path.
+func (s *Server) handleRekeyStorageLocation(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	if s.rekeyer == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "storage rekeying is not enabled")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid storage location ID")
+		return
+	}
+
+	var req struct {
+		KeyID string `json:"key_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID == "" {
+		s.sendError(w, http.StatusBadRequest, "key_id is required")
+		return
+	}
+	if !s.storageRouter.Keyring().Has(req.KeyID) {
+		s.sendError(w, http.StatusBadRequest, "unknown encryption key: "+req.KeyID)
+		return
+	}
+	if s.rekeyer.Status().Running {
+		s.sendError(w, http.StatusConflict, "a rekey is already running")
+		return
+	}
+
+	ctx := r.Context()
+	loc, err := s.locationStore.Get(ctx, id)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get storage location: "+err.Error())
+		return
+	}
+	if loc == nil {
+		s.sendError(w, http.StatusNotFound, "storage location not found")
+		return
+	}
+	if loc.ReadOnly {
+		s.sendError(w, http.StatusConflict, "storage location is read-only")
+		return
+	}
+	oldKeyID := storage.EncryptionKeyID(loc.Config)
+	if oldKeyID == "" {
+		s.sendError(w, http.StatusBadRequest, "storage location is not encrypted")
+		return
+	}
+
+	// New uploads use the new key from here on
+	if oldKeyID != req.KeyID {
+		var cfg map[string]interface{}
+		if err := json.Unmarshal(loc.Config, &cfg); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "invalid storage location config: "+err.Error())
+			return
+		}
+		cfg["encryption_key_id"] = req.KeyID
+		loc.Config, _ = json.Marshal(cfg)
+		if err := s.locationStore.Update(ctx, loc); err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to update storage location: "+err.Error())
+			return
+		}
+		if err := s.storageRouter.Reload(ctx); err != nil {
+			logging.Error("failed to reload storage router after rekey", zap.Error(err))
+		}
+	}
+
+	routed := s.storageRouter.GetLocation(id)
+	if routed == nil {
+		s.sendError(w, http.StatusInternalServerError, "storage location is not loaded")
+		return
+	}
+	backend, ok := routed.Backend.(*storage.EncryptedBackend)
+	if !ok || backend.KeyID() != req.KeyID {
+		s.sendError(w, http.StatusInternalServerError, "storage location did not switch to the new key")
+		return
+	}
+
+	if err := s.rekeyer.Trigger(id, oldKeyID, backend); err != nil {
+		if errors.Is(err, storage.ErrRekeyRunning) {
+			s.sendError(w, http.StatusConflict, "a rekey is already running")
+			return
+		}
+		s.sendError(w, http.StatusInternalServerError, "failed to start rekey: "+err.Error())
+		return
+	}
+
+	logging.Info("storage location rekey started",
+		zap.Int("id", id),
+		zap.String("old_key_id", oldKeyID),
+		zap.String("key_id", req.KeyID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.rekeyer.Status())
+}
+
+// handleRekeyStatus returns the progress of the current or last rekey of
+// a location.
+func (s *Server) handleRekeyStatus(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	if s.rekeyer == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "storage rekeying is not enabled")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid storage location ID")
+		return
+	}
+	st := s.rekeyer.Status()
+	if st.StartedAt == nil || st.LocationID != id {
+		s.sendError(w, http.StatusNotFound, "no rekey of this storage location")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// handleStartStorageImport registers the objects already in a location's
+// bucket as files below "path_prefix" without copying them. Objects whose
+// path is taken are skipped and listed in the status, never overwritten.
+// The import runs in the background; poll GET on the same path.
+func (s *Server) handleStartStorageImport(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return
+	}
+	if s.importer == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "storage import is not enabled")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid storage location ID")
+		return
+	}
+	if s.storageRouter.GetLocation(id) == nil {
+		s.sendError(w, http.StatusNotFound, "storage location not found")
+		return
+	}
+
+	var req importer.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.LocationID = id
+	req.UserID = claims.UserID
+	req.Username = claims.Username
+
+	if err := s.importer.Trigger(req); err != nil {
+		if errors.Is(err, importer.ErrRunning) {
+			s.sendError(w, http.StatusConflict, "an import is already running")
+			return
+		}
+		s.sendError(w, http.StatusInternalServerError, "failed to start import: "+err.Error())
+		return
+	}
+
+	logging.Info("storage import started",
+		zap.Int("id", id),
+		zap.String("prefix", req.Prefix),
+		zap.String("path_prefix", req.PathPrefix),
+		zap.Bool("dry_run", req.DryRun))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.importer.Status())
+}
+
+// handleStorageImportStatus returns the progress of the current or last
+// import of a location.
+func (s *Server) handleStorageImportStatus(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+	if s.importer == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "storage import is not enabled")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid storage location ID")
+		return
+	}
+	st := s.importer.Status()
+	if st.StartedAt == nil || st.Request.LocationID != id {
+		s.sendError(w, http.StatusNotFound, "no import of this storage location")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
 // redactedLocationMap converts a LocationRow to a JSON-friendly map with secrets redacted.
 func redactedLocationMap(loc storage.LocationRow) map[string]interface{} {
 	m := map[string]interface{}{
@@ -399,11 +642,25 @@ func redactedLocationMap(loc storage.LocationRow) map[string]interface{} {
 	return m
 }
 
+// locationStatusMap is redactedLocationMap plus the location's health: nil
+// until it has been checked, and for locations whose backend failed to open.
+func (s *Server) locationStatusMap(loc storage.LocationRow) map[string]interface{} {
+	m := redactedLocationMap(loc)
+	m["health"] = nil
+	if h, ok := s.storageRouter.Health(loc.ID); ok {
+		m["health"] = h
+	}
+	return m
+}
+
 // isNotFoundError checks if an error indicates an object was not found.
 func isNotFoundError(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "not found") ||
 		strings.Contains(msg, "no such key") ||
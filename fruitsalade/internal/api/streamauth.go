@@ -0,0 +1,80 @@
+package api
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Event Stream Auth ──────────────────────────────────────────────────────
+//
+// The token of an event stream (SSE or WebSocket) is only checked when the
+// stream opens. So that a stream does not outlive its token, it ends with
+// a reauth event shortly before the token expires, which leaves the client
+// time to refresh the still valid token and reconnect. Revoking a session
+// ends its streams at once with a reauth event saying so.
+
+// streamReauthLead is how long before its token expires a stream ends.
+const streamReauthLead = time.Minute
+
+// streamDeadline returns when a stream authenticated with claims must end,
+// or false if never: API keys and tokens without an expiry do not expire.
+// A token expiring within the lead keeps its stream until it expires.
+func streamDeadline(claims *auth.Claims, now time.Time) (time.Time, bool) {
+	if claims == nil || claims.APIKeyID != 0 || claims.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+	exp := claims.ExpiresAt.Time
+	if at := exp.Add(-streamReauthLead); at.After(now) {
+		return at, true
+	}
+	return exp, true
+}
+
+// streamTimer returns a channel that fires at the stream's deadline, nil
+// (never fires) if it has none, and a function to stop the timer.
+func streamTimer(claims *auth.Claims) (<-chan time.Time, func() bool) {
+	at, ok := streamDeadline(claims, time.Now())
+	if !ok {
+		return nil, func() bool { return false }
+	}
+	timer := time.NewTimer(time.Until(at))
+	return timer.C, timer.Stop
+}
+
+// reauthEvent returns the event that ends a stream for reason.
+func reauthEvent(reason string, expiresAt time.Time) events.Event {
+	e := events.Event{
+		Type:      events.EventReauth,
+		Timestamp: time.Now().Unix(),
+		Reauth:    &protocol.ReauthPayload{Reason: reason},
+	}
+	if !expiresAt.IsZero() {
+		e.Reauth.ExpiresAt = expiresAt.Unix()
+	}
+	return e
+}
+
+// expiringEvent returns the reauth event for a stream whose token expires.
+func expiringEvent(claims *auth.Claims) events.Event {
+	var exp time.Time
+	if claims.ExpiresAt != nil {
+		exp = claims.ExpiresAt.Time
+	}
+	return reauthEvent(protocol.ReauthExpiring, exp)
+}
+
+// endSessionStreams ends the event streams of a revoked session.
+func (s *Server) endSessionStreams(sessionID int) {
+	if s.broadcaster == nil || sessionID == 0 {
+		return
+	}
+	if n := s.broadcaster.EndSession(sessionID, reauthEvent(protocol.ReauthRevoked, time.Time{})); n > 0 {
+		logging.Info("ended event streams of revoked session", zap.Int("session_id", sessionID), zap.Int("streams", n))
+	}
+}
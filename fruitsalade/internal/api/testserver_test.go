@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/config"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/importer"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/scrub"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/testenv"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/thumbs"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/webhooks"
+)
+
+// testEnv holds the database server and S3 endpoint of the package's
+// tests, set up by TestMain.
+var testEnv *testenv.Env
+
+// TestServer is a fully wired Server with a database and bucket of its
+// own, served over HTTP.
+type TestServer struct {
+	*Server
+	URL   string
+	Token string // token of the default admin
+	DB    *sql.DB
+
+	http *httptest.Server
+	stop []func()
+}
+
+// NewTestServer starts a TestServer that is shut down when t ends. Tests
+// that change server-wide state (settings, storage locations) use one
+// instead of the shared testServer.
+func NewTestServer(t testing.TB) *TestServer {
+	t.Helper()
+	if testEnv == nil {
+		t.Skip("no test environment")
+	}
+	ts, err := startTestServer(testEnv)
+	if err != nil {
+		t.Fatalf("start test server: %v", err)
+	}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// startTestServer wires a Server the way the server binary does, on a new
+// database and bucket of env.
+func startTestServer(env *testenv.Env) (*TestServer, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := &TestServer{stop: []func(){cancel}}
+	fail := func(format string, args ...interface{}) (*TestServer, error) {
+		ts.Close()
+		return nil, fmt.Errorf(format, args...)
+	}
+
+	dbURL, err := env.NewDatabase(ctx)
+	if err != nil {
+		return fail("test database: %w", err)
+	}
+	metaStore, err := postgres.New(dbURL)
+	if err != nil {
+		return fail("postgres store: %w", err)
+	}
+	ts.stop = append(ts.stop, func() { metaStore.Close() })
+	db := metaStore.DB()
+	ts.DB = db
+
+	// Create root dir
+	metaStore.UpsertFile(ctx, &postgres.FileRow{
+		ID:         "root",
+		Name:       "/",
+		Path:       "/",
+		ParentPath: "/",
+		IsDir:      true,
+	})
+
+	authHandler := auth.New(db, "test-secret")
+	authHandler.EnsureDefaultAdmin(ctx)
+	db.ExecContext(ctx, `UPDATE users SET must_change_password = FALSE WHERE username = 'admin'`)
+
+	broadcaster := events.NewBroadcaster()
+	permissionStore := sharing.NewPermissionStore(db)
+	shareLinkStore := sharing.NewShareLinkStore(db)
+	quotaStore := quota.NewQuotaStore(db)
+	rateLimiter := quota.NewRateLimiter(quotaStore)
+	groupStore := sharing.NewGroupStore(db)
+	permissionStore.SetGroupStore(groupStore)
+	provisioner := sharing.NewProvisioner(groupStore, metaStore, permissionStore)
+
+	locationStore := storage.NewLocationStore(db)
+	s3Config, _ := json.Marshal(env.NewBucket())
+	if _, err := locationStore.Create(ctx, &storage.LocationRow{
+		Name:        "Test S3",
+		BackendType: "s3",
+		Config:      s3Config,
+		IsDefault:   true,
+	}); err != nil {
+		return fail("create default storage location: %w", err)
+	}
+	storageRouter, err := storage.NewRouter(ctx, locationStore, groupStore, nil)
+	if err != nil {
+		return fail("storage router: %w", err)
+	}
+
+	galleryStore := gallery.NewGalleryStore(db)
+	pluginCaller := gallery.NewPluginCaller("")
+	processor := gallery.NewProcessor(galleryStore, storageRouter, pluginCaller, 1)
+	processor.Start(ctx)
+	ts.stop = append(ts.stop, processor.Stop)
+
+	testCfg := &config.Config{
+		MaxUploadSize:        10 * 1024 * 1024,
+		CORSAllowedOrigins:   "https://app.example.com",
+		CORSAllowedHeaders:   "Authorization, X-Expected-Version, If-Match",
+		CORSExposedHeaders:   "ETag, X-Version, Content-Range",
+		CORSAllowCredentials: true,
+		CORSMaxAge:           10 * time.Minute,
+		ContentCompressTypes: "text/*,application/json",
+	}
+
+	srv := NewServer(
+		metaStore, storageRouter, authHandler, 10*1024*1024,
+		broadcaster, permissionStore, shareLinkStore,
+		quotaStore, rateLimiter, groupStore, testCfg,
+		provisioner, locationStore,
+		&GalleryDeps{Store: galleryStore, Processor: processor, PluginCaller: pluginCaller},
+	)
+	ts.Server = srv
+
+	thumbGenerator := thumbs.NewGenerator(nil, storageRouter, 1)
+	thumbGenerator.Start(ctx)
+	ts.stop = append(ts.stop, thumbGenerator.Stop)
+	srv.SetThumbnails(thumbGenerator)
+	srv.SetScrubber(scrub.New(metaStore, storageRouter, 0, false))
+	srv.SetImporter(importer.New(ctx, metaStore, storageRouter))
+	srv.SetRekeyer(storage.NewRekeyer(ctx))
+	webhookStore := webhooks.NewStore(db)
+	webhookDispatcher := webhooks.NewDispatcher(webhookStore, broadcaster, webhooks.Options{Timeout: 2 * time.Second, AllowInternal: true})
+	webhookDispatcher.Start(ctx)
+	ts.stop = append(ts.stop, webhookDispatcher.Stop)
+	srv.SetWebhooks(webhookStore, webhookDispatcher)
+	if err := srv.Init(ctx); err != nil {
+		return fail("server init: %w", err)
+	}
+
+	ts.http = httptest.NewServer(srv.Handler())
+	ts.URL = ts.http.URL
+	ts.stop = append(ts.stop, ts.http.Close)
+
+	ts.Token, err = getTestToken(ts.URL)
+	if err != nil || ts.Token == "" {
+		return fail("admin token: %v", err)
+	}
+	return ts, nil
+}
+
+// Close shuts the server down, in the reverse order of startup.
+func (ts *TestServer) Close() {
+	for i := len(ts.stop) - 1; i >= 0; i-- {
+		ts.stop[i]()
+	}
+	ts.stop = nil
+}
+
+// do sends a request with the admin token and returns the response status
+// and body.
+func (ts *TestServer) do(t *testing.T, method, path, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+ts.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+// upload stores content at path as the admin.
+func (ts *TestServer) upload(t *testing.T, path, content string) {
+	t.Helper()
+	if code, body := ts.do(t, "POST", "/api/v1/content/"+path, content); code != http.StatusCreated {
+		t.Fatalf("upload %s: %d %s", path, code, body)
+	}
+}
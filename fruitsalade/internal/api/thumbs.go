@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/thumbs"
+)
+
+// ─── Thumbnails ─────────────────────────────────────────────────────────────
+
+// handleThumb serves a cached thumbnail for any supported file type. On a
+// cache miss the thumbnail is queued for generation and the client is told
+// to retry with 202 Accepted.
+func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
+	pathParam := r.PathValue("path")
+	if pathParam == "" {
+		s.sendError(w, http.StatusBadRequest, "file path required")
+		return
+	}
+
+	size, err := thumbs.ParseSize(r.URL.Query().Get("size"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fullPath := "/" + pathParam
+	fileRow, _ := s.metadata.GetFileRow(r.Context(), fullPath)
+	if fileRow == nil || fileRow.IsDir {
+		s.sendError(w, http.StatusNotFound, "file not found: "+pathParam)
+		return
+	}
+
+	// Check read permission
+	claims := auth.GetClaims(r.Context())
+	if claims != nil {
+		if !s.permissions.CheckAccess(r.Context(), claims.UserID, fullPath, "read", claims.IsAdmin) {
+			s.sendError(w, http.StatusForbidden, "access denied")
+			return
+		}
+	}
+
+	if !s.thumbnails.Supports(fullPath) {
+		s.sendError(w, http.StatusNotFound, "no thumbnail for this file type")
+		return
+	}
+
+	// The same path may hold different content later; revalidate
+	s.serveThumb(w, r, fileRow, size, "private, no-cache")
+}
+
+// serveThumb serves the thumbnail of a file the caller may read, queueing
+// it for generation on a cache miss (202 Accepted with Retry-After).
+func (s *Server) serveThumb(w http.ResponseWriter, r *http.Request, fileRow *postgres.FileRow, size int, cacheControl string) {
+	contentID := thumbs.ContentID(fileRow.Hash, fileRow.S3Key, fileRow.Version)
+	etag := `"thumb-` + contentID + "-" + strconv.Itoa(size) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	backend, _, err := s.storageRouter.ResolveForRead(r.Context(), fileRow.StorageLocID, fileRow.GroupID)
+	if err != nil {
+		s.sendStorageError(w, err)
+		return
+	}
+
+	key := thumbs.Key(contentID, size)
+	if exists, _ := backend.ObjectExists(r.Context(), key); exists {
+		reader, n, err := backend.GetObject(r.Context(), key, 0, 0)
+		if err == nil {
+			defer reader.Close()
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", cacheControl)
+			io.Copy(w, reader)
+			return
+		}
+	}
+
+	status := s.thumbnails.Request(thumbs.Job{
+		Path:     fileRow.Path,
+		S3Key:    fileRow.S3Key,
+		FileSize: fileRow.Size,
+		Size:     size,
+		Key:      key,
+		Backend:  backend,
+	})
+	switch status {
+	case thumbs.StatusPending:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(thumbs.RetryAfter.Seconds())))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "pending"})
+	case thumbs.StatusFailed:
+		s.sendError(w, http.StatusNotFound, "thumbnail generation failed")
+	default:
+		s.sendError(w, http.StatusNotFound, "no thumbnail for this file type")
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
@@ -37,9 +38,16 @@ func (s *Server) handleTOTPVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Wrong codes count towards the same lockout as wrong passwords
+	if s.auth.LoginLocked(w, r, claims.Username) {
+		return
+	}
+
 	// Validate TOTP code
 	if err := s.auth.ValidateTOTP(r.Context(), claims.UserID, req.Code); err != nil {
 		metrics.RecordAuthAttempt(false)
+		s.recordActivity(r.Context(), claims, activity.ActionLoginFailed, "", map[string]interface{}{"reason": "invalid totp code"})
+		s.auth.CountLoginFailure(claims.Username, s.auth.ClientIP(r))
 		s.sendError(w, http.StatusUnauthorized, "invalid TOTP code")
 		return
 	}
@@ -53,7 +61,9 @@ func (s *Server) handleTOTPVerify(w http.ResponseWriter, r *http.Request) {
 	}
 
 	metrics.RecordAuthAttempt(true)
+	s.auth.ResetLoginFailures(claims.Username)
 	logging.Info("TOTP login successful", zap.String("username", claims.Username))
+	s.recordActivity(r.Context(), claims, activity.ActionLogin, "", map[string]interface{}{"device": req.DeviceName, "totp": true})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1,14 +1,24 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"strings"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
@@ -42,12 +52,14 @@ func (s *Server) handleTrashList(w http.ResponseWriter, r *http.Request) {
 			IsDir:         t.IsDir,
 			DeletedAt:     t.DeletedAt,
 			DeletedByName: t.DeletedByName,
+			PurgeAt:       trashPurgeAt(t.DeletedAt, s.trashRetention(t.RetentionDays)),
 		})
 	}
 	if resp == nil {
 		resp = []protocol.TrashItem{}
 	}
 
+	s.setTrashWarning(r.Context(), w, claims.UserID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -105,25 +117,14 @@ func (s *Server) handleTrashPurge(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete from storage
-	for _, p := range purged {
-		if p.S3Key == "" {
-			continue
-		}
-		backend, _, err := s.storageRouter.ResolveForFile(r.Context(), p.StorageLocID, p.GroupID)
-		if err == nil && backend != nil {
-			backend.DeleteObject(r.Context(), p.S3Key)
-		}
-	}
+	s.releasePurged(r.Context(), purged)
 
 	s.RefreshTree(r.Context())
 
 	logging.Info("file purged from trash", zap.String("path", path), zap.Int("count", len(purged)))
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":   path,
-		"purged": len(purged),
-	})
+	json.NewEncoder(w).Encode(protocol.TrashPurgeResponse{Path: path, Purged: len(purged)})
 }
 
 func (s *Server) handleTrashEmpty(w http.ResponseWriter, r *http.Request) {
@@ -140,24 +141,14 @@ func (s *Server) handleTrashEmpty(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete from storage
-	for _, p := range purged {
-		if p.S3Key == "" {
-			continue
-		}
-		backend, _, err := s.storageRouter.ResolveForFile(r.Context(), p.StorageLocID, p.GroupID)
-		if err == nil && backend != nil {
-			backend.DeleteObject(r.Context(), p.S3Key)
-		}
-	}
+	s.releasePurged(r.Context(), purged)
 
 	s.RefreshTree(r.Context())
 
 	logging.Info("trash emptied", zap.Int("count", len(purged)))
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"purged": len(purged),
-	})
+	json.NewEncoder(w).Encode(protocol.TrashPurgeResponse{Purged: len(purged)})
 }
 
 // ─── Favorites Handlers ─────────────────────────────────────────────────────
@@ -227,12 +218,18 @@ func (s *Server) handleListFavorites(w http.ResponseWriter, r *http.Request) {
 
 	var resp []protocol.FavoriteItem
 	for _, f := range items {
+		name := f.FileName
+		if name == "" {
+			name = path.Base(f.FilePath)
+		}
 		resp = append(resp, protocol.FavoriteItem{
 			FilePath: f.FilePath,
-			FileName: f.FileName,
+			FileName: name,
 			Size:     f.Size,
 			IsDir:    f.IsDir,
 			ModTime:  f.ModTime,
+			Missing:  f.Missing,
+			Trashed:  f.Trashed,
 		})
 	}
 	if resp == nil {
@@ -243,6 +240,26 @@ func (s *Server) handleListFavorites(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// handleRemoveMissingFavorites removes the caller's favorites whose file
+// no longer exists. Favorites of trashed files are kept so that restoring
+// the file brings them back.
+func (s *Server) handleRemoveMissingFavorites(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	n, err := s.metadata.RemoveMissingFavorites(r.Context(), claims.UserID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to remove missing favorites: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.RemoveMissingFavoritesResponse{Removed: n})
+}
+
 func (s *Server) handleListFavoritePaths(w http.ResponseWriter, r *http.Request) {
 	claims := auth.GetClaims(r.Context())
 	if claims == nil {
@@ -278,158 +295,150 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	typeFilter := r.URL.Query().Get("type") // "all", "files", "dirs", "images"
+	if r.URL.Query().Get("content") == "true" {
+		s.handleContentSearch(w, r, claims, query)
+		return
+	}
+
+	opts, err := searchOptions(r.URL.Query())
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Query = query
+	if r.URL.Query().Get("owner") == "me" {
+		opts.OwnerID = claims.UserID
+	}
+	if pf := s.galleryPermFilterAt(r.Context(), claims, 1); pf != nil {
+		opts.Access, opts.AccessArgs = pf.Condition, pf.Args
+	}
 
-	results, err := s.metadata.SearchFiles(r.Context(), query, typeFilter, 200)
+	results, total, err := s.metadata.SearchFiles(r.Context(), opts)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "search failed: "+err.Error())
 		return
 	}
 
-	var resp []protocol.SearchResult
+	resp := protocol.SearchResponse{
+		Results: make([]protocol.SearchResult, 0, len(results)),
+		Total:   total,
+		Offset:  opts.Offset,
+		Limit:   opts.Limit,
+		HasMore: opts.Offset+len(results) < total,
+	}
 	for _, r := range results {
-		resp = append(resp, protocol.SearchResult{
+		resp.Results = append(resp.Results, protocol.SearchResult{
 			ID:      r.ID,
 			Name:    r.Name,
 			Path:    r.Path,
 			Size:    r.Size,
 			IsDir:   r.IsDir,
 			ModTime: r.ModTime,
+			Match:   r.Match,
 		})
 	}
-	if resp == nil {
-		resp = []protocol.SearchResult{}
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// ─── Bulk Operation Handlers ────────────────────────────────────────────────
-
-func (s *Server) handleBulkMove(w http.ResponseWriter, r *http.Request) {
-	claims := auth.GetClaims(r.Context())
-	if claims == nil {
-		s.sendError(w, http.StatusUnauthorized, "authentication required")
-		return
-	}
-
-	var req protocol.BulkMoveRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.sendError(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-	if len(req.Paths) == 0 || req.Destination == "" {
-		s.sendError(w, http.StatusBadRequest, "paths and destination required")
-		return
-	}
-
-	// Ensure destination directory exists
-	if err := s.ensureParentDirs(r.Context(), req.Destination+"/placeholder"); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to create destination: "+err.Error())
-		return
-	}
-
-	resp := protocol.BulkResponse{}
-	for _, path := range req.Paths {
-		// Check permission
-		if !claims.IsAdmin && !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "write", false) {
-			resp.Failed++
-			resp.Errors = append(resp.Errors, "access denied: "+path)
+// searchOptions reads the filters and paging of a file search:
+// type, path_prefix, modified_after and modified_before (RFC 3339 or
+// YYYY-MM-DD), min_size and max_size (bytes), limit and offset.
+func searchOptions(q url.Values) (postgres.SearchOptions, error) {
+	opts := postgres.SearchOptions{
+		Type:       q.Get("type"),
+		PathPrefix: q.Get("path_prefix"),
+		Limit:      50,
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"modified_after", &opts.ModifiedAfter}, {"modified_before", &opts.ModifiedBefore}} {
+		v := q.Get(p.name)
+		if v == "" {
 			continue
 		}
-
-		baseName := path[strings.LastIndex(path, "/")+1:]
-		newPath := strings.TrimSuffix(req.Destination, "/") + "/" + baseName
-
-		if err := s.metadata.MoveFile(r.Context(), path, newPath); err != nil {
-			resp.Failed++
-			resp.Errors = append(resp.Errors, path+": "+err.Error())
-		} else {
-			// Move storage object
-			oldKey := strings.TrimPrefix(path, "/")
-			newKey := strings.TrimPrefix(newPath, "/")
-			fileRow, _ := s.metadata.GetFileRow(r.Context(), newPath)
-			if fileRow != nil && !fileRow.IsDir {
-				backend, _, _ := s.storageRouter.ResolveForFile(r.Context(), fileRow.StorageLocID, fileRow.GroupID)
-				if backend != nil {
-					backend.CopyObject(r.Context(), oldKey, newKey)
-					backend.DeleteObject(r.Context(), oldKey)
-				}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", v); err != nil {
+				return opts, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 time", p.name)
 			}
-			resp.Succeeded++
 		}
+		*p.dst = &t
 	}
 
-	s.RefreshTree(r.Context())
+	for _, p := range []struct {
+		name string
+		dst  **int64
+	}{{"min_size", &opts.MinSize}, {"max_size", &opts.MaxSize}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("%s must be a non-negative number of bytes", p.name)
+		}
+		*p.dst = &n
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			return opts, fmt.Errorf("limit must be between 1 and 200")
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("offset must not be negative")
+		}
+		opts.Offset = n
+	}
+	return opts, nil
 }
 
-func (s *Server) handleBulkCopy(w http.ResponseWriter, r *http.Request) {
-	claims := auth.GetClaims(r.Context())
-	if claims == nil {
-		s.sendError(w, http.StatusUnauthorized, "authentication required")
+// handleContentSearch queries the extracted-text index (GET /api/v1/search?content=true).
+func (s *Server) handleContentSearch(w http.ResponseWriter, r *http.Request, claims *auth.Claims, query string) {
+	if s.textIndex == nil {
+		s.sendError(w, http.StatusNotImplemented, "content search not enabled")
 		return
 	}
 
-	var req protocol.BulkCopyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.sendError(w, http.StatusBadRequest, "invalid request body")
-		return
-	}
-	if len(req.Paths) == 0 || req.Destination == "" {
-		s.sendError(w, http.StatusBadRequest, "paths and destination required")
+	pf := s.galleryPermFilterAt(r.Context(), claims, 2)
+	results, err := s.textIndex.Search(r.Context(), query, pf, 200)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "content search failed: "+err.Error())
 		return
 	}
 
-	// Ensure destination directory exists
-	if err := s.ensureParentDirs(r.Context(), req.Destination+"/placeholder"); err != nil {
-		s.sendError(w, http.StatusInternalServerError, "failed to create destination: "+err.Error())
-		return
+	// Ranked by relevance and not paged
+	resp := protocol.SearchResponse{
+		Results: make([]protocol.SearchResult, 0, len(results)),
+		Total:   len(results),
+		Limit:   200,
 	}
-
-	resp := protocol.BulkResponse{}
-	for _, path := range req.Paths {
-		// Check permission
-		if !claims.IsAdmin && !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "read", false) {
-			resp.Failed++
-			resp.Errors = append(resp.Errors, "access denied: "+path)
-			continue
-		}
-
-		baseName := path[strings.LastIndex(path, "/")+1:]
-		newPath := strings.TrimSuffix(req.Destination, "/") + "/" + baseName
-
-		// Copy metadata
-		if err := s.metadata.CopyFileRow(r.Context(), path, newPath); err != nil {
-			resp.Failed++
-			resp.Errors = append(resp.Errors, path+": "+err.Error())
-			continue
-		}
-
-		// Copy storage object
-		srcRow, _ := s.metadata.GetFileRow(r.Context(), path)
-		if srcRow != nil && !srcRow.IsDir {
-			srcKey := strings.TrimPrefix(path, "/")
-			dstKey := strings.TrimPrefix(newPath, "/")
-			backend, _, _ := s.storageRouter.ResolveForFile(r.Context(), srcRow.StorageLocID, srcRow.GroupID)
-			if backend != nil {
-				if err := backend.CopyObject(r.Context(), srcKey, dstKey); err != nil {
-					logging.Warn("bulk copy storage failed", zap.String("src", srcKey), zap.Error(err))
-				}
-			}
-		}
-		resp.Succeeded++
+	for _, res := range results {
+		resp.Results = append(resp.Results, protocol.SearchResult{
+			ID:      res.ID,
+			Name:    res.Name,
+			Path:    res.Path,
+			Size:    res.Size,
+			ModTime: res.ModTime,
+			Snippet: res.Snippet,
+			Match:   "content",
+		})
 	}
 
-	s.RefreshTree(r.Context())
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ─── Bulk Operation Handlers ────────────────────────────────────────────────
+
 func (s *Server) handleBulkShare(w http.ResponseWriter, r *http.Request) {
 	claims := auth.GetClaims(r.Context())
 	if claims == nil {
@@ -455,11 +464,12 @@ func (s *Server) handleBulkShare(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		_, err := s.shareLinks.Create(r.Context(), path, claims.UserID, req.Password, req.ExpiresInSec, req.MaxDownloads)
+		link, err := s.shareLinks.Create(r.Context(), path, claims.UserID, req.Password, req.ExpiresInSec, req.MaxDownloads, nil)
 		if err != nil {
 			resp.Failed++
 			resp.Errors = append(resp.Errors, path+": "+err.Error())
 		} else {
+			s.recordActivity(r.Context(), claims, activity.ActionShareCreate, path, map[string]interface{}{"link_id": link.ID})
 			resp.Succeeded++
 		}
 	}
@@ -503,6 +513,11 @@ func (s *Server) handleBulkAlbumAdd(w http.ResponseWriter, r *http.Request) {
 
 	resp := protocol.BulkResponse{}
 	for _, path := range req.Paths {
+		if !s.permissions.CheckAccess(r.Context(), claims.UserID, path, "read", claims.IsAdmin) {
+			resp.Failed++
+			resp.Errors = append(resp.Errors, path+": access denied")
+			continue
+		}
 		if err := s.galleryStore.AddImageToAlbum(r.Context(), req.AlbumID, path); err != nil {
 			resp.Failed++
 			resp.Errors = append(resp.Errors, path+": "+err.Error())
@@ -531,24 +546,91 @@ func (s *Server) handleBulkTag(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusBadRequest, "paths and tags required")
 		return
 	}
+	var remove bool
+	switch req.Action {
+	case "", "add":
+	case "remove":
+		remove = true
+	default:
+		s.sendError(w, http.StatusBadRequest, "action must be add or remove")
+		return
+	}
+	tags := make([]string, 0, len(req.Tags))
+	for _, t := range req.Tags {
+		tag, err := gallery.NormalizeTag(t)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		tags = append(tags, tag)
+	}
 
 	if s.galleryStore == nil {
 		s.sendError(w, http.StatusNotImplemented, "gallery not enabled")
 		return
 	}
 
-	resp := protocol.BulkResponse{}
+	resp := protocol.BulkTagResponse{Results: make([]protocol.BulkTagResult, 0, len(req.Paths))}
 	for _, path := range req.Paths {
-		for _, tag := range req.Tags {
-			if err := s.galleryStore.AddTag(r.Context(), path, tag, "manual", 1.0); err != nil {
-				resp.Failed++
-				resp.Errors = append(resp.Errors, path+": "+err.Error())
-			} else {
-				resp.Succeeded++
+		result := s.bulkTagPath(r.Context(), claims, path, tags, remove)
+		if result.Error != "" {
+			resp.Failed++
+			resp.Errors = append(resp.Errors, path+": "+result.Error)
+		} else {
+			resp.Succeeded++
+			if result.Queued {
+				resp.Queued++
+			} else if !remove {
+				resp.Tagged++
 			}
 		}
+		resp.Results = append(resp.Results, result)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// bulkTagPath adds tags to or removes them from one file of a bulk tag
+// request. Media files the gallery has not seen yet get a pending metadata
+// row and are queued for processing, so that they show up under their tags
+// once processed.
+func (s *Server) bulkTagPath(ctx context.Context, claims *auth.Claims, path string, tags []string, remove bool) protocol.BulkTagResult {
+	result := protocol.BulkTagResult{Path: path}
+	if !s.permissions.CheckAccess(ctx, claims.UserID, path, "write", claims.IsAdmin) {
+		result.Error = "write access denied"
+		return result
+	}
+
+	if remove {
+		for _, tag := range tags {
+			if err := s.galleryStore.RemoveTag(ctx, path, tag); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+		}
+		return result
+	}
+
+	if gallery.IsMediaFile(path) && !s.galleryStore.ImageExistsInDB(ctx, path) {
+		if err := s.galleryStore.EnsureRow(ctx, path); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				result.Error = "file not found"
+			} else {
+				result.Error = err.Error()
+			}
+			return result
+		}
+		if s.processor != nil {
+			s.processor.Enqueue(path)
+		}
+		result.Queued = true
+	}
+	for _, tag := range tags {
+		if err := s.galleryStore.AddTag(ctx, path, tag, "manual", 1.0); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	return result
+}
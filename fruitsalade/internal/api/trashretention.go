@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Trash Retention ────────────────────────────────────────────────────────
+//
+// Trashed items are purged once they have been in the trash for longer
+// than the retention of the user who deleted them: their user_quotas
+// override if set, the trash_retention_days setting otherwise. 0 keeps
+// items forever. Users whose trash holds more than trash_warn_size bytes
+// get an X-Trash-Warning header on the trash listing and a flag on their
+// dashboard.
+
+// trashPurgeInterval is how often expired trash is purged.
+const trashPurgeInterval = 6 * time.Hour
+
+// maxPurgedPaths bounds the paths listed in a trash-purged event.
+const maxPurgedPaths = 20
+
+// trashRetention returns the retention in days for items deleted by a
+// user with the given override.
+func (s *Server) trashRetention(override *int) int {
+	if override != nil {
+		return *override
+	}
+	return s.settings.Get().TrashRetentionDays
+}
+
+// trashPurgeAt returns when an item deleted at deletedAt is purged, or nil
+// if it is kept forever.
+func trashPurgeAt(deletedAt time.Time, days int) *time.Time {
+	if days <= 0 {
+		return nil
+	}
+	at := deletedAt.AddDate(0, 0, days)
+	return &at
+}
+
+// trashWarning reports whether the trash of userID holds more than the
+// trash_warn_size setting, and its size.
+func (s *Server) trashWarning(ctx context.Context, userID int) (bool, int64) {
+	size, err := s.metadata.UserTrashSize(ctx, userID)
+	if err != nil {
+		logging.Warn("failed to get trash size", zap.Int("user_id", userID), zap.Error(err))
+		return false, 0
+	}
+	limit := s.settings.Get().TrashWarnSize
+	return limit > 0 && size > limit, size
+}
+
+// setTrashWarning sets the X-Trash-Warning header to the size of the
+// caller's trash if it is over the warning threshold.
+func (s *Server) setTrashWarning(ctx context.Context, w http.ResponseWriter, userID int) {
+	if warn, size := s.trashWarning(ctx, userID); warn {
+		w.Header().Set(protocol.HeaderTrashWarning, strconv.FormatInt(size, 10))
+	}
+}
+
+// startTrashPurge purges expired trash every interval until ctx is done.
+func (s *Server) startTrashPurge(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.purgeExpiredTrash(ctx)
+			}
+		}
+	}()
+}
+
+// purgeExpiredTrash purges the trash items past their retention, releases
+// their content and tells the users they belonged to.
+func (s *Server) purgeExpiredTrash(ctx context.Context) {
+	purged, err := s.metadata.PurgeExpiredTrash(ctx, s.settings.Get().TrashRetentionDays)
+	if err != nil {
+		logging.Error("trash auto-purge failed", zap.Error(err))
+		return
+	}
+	if len(purged) == 0 {
+		return
+	}
+	s.releasePurged(ctx, purged)
+	if err := s.RefreshTree(ctx); err != nil {
+		logging.Error("tree rebuild failed", zap.Error(err))
+	}
+	s.publishTrashPurged(purged)
+	logging.Info("trash auto-purge completed", zap.Int("purged", len(purged)))
+}
+
+// releasePurged deletes the content of purged rows that no other row
+// references.
+func (s *Server) releasePurged(ctx context.Context, purged []postgres.PurgeFileRow) {
+	for _, p := range purged {
+		if p.S3Key == "" {
+			continue
+		}
+		backend, _, err := s.storageRouter.ResolveForFile(ctx, p.StorageLocID, p.GroupID)
+		if err == nil && backend != nil {
+			if err := s.metadata.ReleaseContent(ctx, p.S3Key, p.StorageLocID, backend.DeleteObject); err != nil {
+				logging.Warn("failed to delete purged content", zap.String("key", p.S3Key), zap.Error(err))
+			}
+		}
+	}
+}
+
+// publishTrashPurged sends each user whose items were purged a
+// trash-purged event listing the topmost purged paths.
+func (s *Server) publishTrashPurged(purged []postgres.PurgeFileRow) {
+	if s.broadcaster == nil {
+		return
+	}
+	byUser := make(map[int][]string)
+	for _, p := range purged {
+		if p.DeletedBy != nil {
+			byUser[*p.DeletedBy] = append(byUser[*p.DeletedBy], p.Path)
+		}
+	}
+	now := time.Now().Unix()
+	for userID, paths := range byUser {
+		s.broadcaster.Publish(events.Event{
+			Type:      events.EventTrashPurged,
+			Timestamp: now,
+			ForUserID: userID,
+			Trash:     &protocol.TrashPurgedPayload{Items: len(paths), Paths: topPaths(paths, maxPurgedPaths)},
+		})
+	}
+}
+
+// topPaths returns the paths not below another one of paths, sorted and
+// at most max of them.
+func topPaths(paths []string, max int) []string {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	var top []string
+next:
+	for _, p := range sorted {
+		for _, t := range top {
+			if pathWithin(p, t) {
+				continue next
+			}
+		}
+		if len(top) == max {
+			break
+		}
+		top = append(top, p)
+	}
+	return top
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// maxTreePageLimit caps ?limit= on the tree endpoints.
+const maxTreePageLimit = 10000
+
+// treeQuery holds the ?depth=, ?offset=, ?limit=, ?sort= and ?dir=
+// parameters of the tree endpoints. depth < 0 and limit 0 mean unlimited;
+// offset and limit page the children of the requested directory in order.
+// order applies to the children of every directory in the response.
+type treeQuery struct {
+	depth  int
+	offset int
+	limit  int
+	order  fstree.Order
+}
+
+func parseTreeQuery(r *http.Request) (treeQuery, error) {
+	q := treeQuery{depth: -1}
+	params := r.URL.Query()
+
+	if v := params.Get("depth"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			return q, fmt.Errorf("invalid depth")
+		}
+		q.depth = d
+	}
+	if v := params.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return q, fmt.Errorf("invalid offset")
+		}
+		q.offset = o
+	}
+	if v := params.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > maxTreePageLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxTreePageLimit)
+		}
+		q.limit = l
+	}
+	order, err := fstree.ParseOrder(params.Get("sort"), params.Get("dir"))
+	if err != nil {
+		return q, err
+	}
+	q.order = order
+
+	// Paging only makes sense with the children present
+	if (q.offset > 0 || q.limit > 0) && q.depth == 0 {
+		q.depth = 1
+	}
+	return q, nil
+}
+
+// partial reports whether the response may hold less than the whole subtree.
+func (q treeQuery) partial() bool {
+	return q.depth >= 0 || q.offset > 0 || q.limit > 0
+}
+
+// limitDepth returns node cut off depth levels below it (depth < 0 returns
+// node unchanged). Directories at the cut keep their child_count.
+func limitDepth(node *models.FileNode, depth int) *models.FileNode {
+	if node == nil || depth < 0 {
+		return node
+	}
+	limited := copyNode(node)
+	if depth == 0 || !node.IsDir {
+		return limited
+	}
+	limited.Children = make([]*models.FileNode, 0, len(node.Children))
+	for _, child := range node.Children {
+		limited.Children = append(limited.Children, limitDepth(child, depth-1))
+	}
+	limited.SetChildCount(len(limited.Children))
+	return limited
+}
+
+// pageChildren returns node with only its children in [offset,
+// offset+limit), which must already be in order. child_count keeps the
+// full count. limit 0 with offset 0 returns node unchanged.
+func pageChildren(node *models.FileNode, offset, limit int) *models.FileNode {
+	if node == nil || !node.IsDir || (offset == 0 && limit == 0) {
+		return node
+	}
+
+	children := node.Children
+	if offset > len(children) {
+		offset = len(children)
+	}
+	end := len(children)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	paged := copyNode(node)
+	paged.SetChildCount(len(children))
+	paged.Children = children[offset:end]
+	return paged
+}
+
+// treeETag returns the entity tag of a tree response for the user: the
+// tree generation plus a hash of the memberships and permissions the
+// filtered view depends on. Unchanged trees can then be answered with 304 without filtering and
+// encoding them. It returns "" when the permissions cannot be loaded.
+// Orders other than the default are part of the tag, since they change
+// the response.
+func (s *Server) treeETag(ctx context.Context, gen uint64, claims *auth.Claims, order fstree.Order) string {
+	h := fnv.New64a()
+	if order != fstree.DefaultOrder {
+		fmt.Fprintf(h, "o%s|%t|", order.Key, order.Desc)
+	}
+	if claims != nil {
+		fmt.Fprintf(h, "%d|%t|%s|", claims.UserID, claims.IsAdmin, claims.PathPrefix)
+		if !claims.IsAdmin {
+			groups, err := s.groups.GetUserGroupsMap(ctx, claims.UserID)
+			if err != nil {
+				return ""
+			}
+			perms, err := s.permissions.GetUserPermissionsMap(ctx, claims.UserID)
+			if err != nil {
+				return ""
+			}
+			ids := make([]int, 0, len(groups))
+			for id := range groups {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+			for _, id := range ids {
+				fmt.Fprintf(h, "g%d=%s|", id, groups[id])
+			}
+			paths := make([]string, 0, len(perms))
+			for p := range perms {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			for _, p := range paths {
+				fmt.Fprintf(h, "p%s=%s|", p, perms[p])
+			}
+			groupPerms, err := s.groups.ListUserGroupPermissions(ctx, claims.UserID)
+			if err != nil {
+				return ""
+			}
+			for _, gp := range groupPerms {
+				fmt.Fprintf(h, "gp%d%s=%s|", gp.GroupID, gp.Path, gp.Permission)
+			}
+		}
+	}
+	return fmt.Sprintf(`"tree-%d-%x"`, gen, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match too, since proxies weaken tags of gzipped responses.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+	"go.uber.org/zap"
+)
+
+// The metadata tree is never modified in place. Writes build a new root
+// with the copy-on-write helpers of the tree package and swap it in under
+// treeMu, so readers take the root with currentTree and walk it without
+// holding a lock. Single writes patch the affected nodes (updateTree);
+// bulk operations and a periodic backstop rebuild the whole tree from the
+// database (RefreshTree).
+
+// maxTreeRebuildRetries bounds how often RefreshTree starts over because an
+// incremental update landed while it was reading the database.
+const maxTreeRebuildRetries = 2
+
+// currentTree returns the current tree root. It must not be modified.
+func (s *Server) currentTree() *models.FileNode {
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	return s.tree
+}
+
+// treeGeneration returns a counter that changes on every tree swap.
+func (s *Server) treeGeneration() uint64 {
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	return s.treeGen
+}
+
+// treeSnapshot returns the current tree root together with its
+// generation, read atomically so the pair always matches.
+func (s *Server) treeSnapshot() (*models.FileNode, uint64) {
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	return s.tree, s.treeGen
+}
+
+// treeAge returns when the tree was last rebuilt in full.
+func (s *Server) treeAge() time.Duration {
+	s.treeMu.RLock()
+	defer s.treeMu.RUnlock()
+	return time.Since(s.treeBuilt)
+}
+
+// updateTree applies the database state of paths and their ancestors to
+// the tree: live rows are inserted or updated, the others removed with
+// everything below them. Directories keep the children already in the
+// tree, so this suits single-node writes (upload, mkdir, delete); moves
+// and restores of whole subtrees need RefreshTree. Falls back to a full
+// rebuild when the tree cannot be patched or the paths affect an alias.
+func (s *Server) updateTree(ctx context.Context, paths ...string) {
+	if s.refreshForAliases(ctx, paths) {
+		return
+	}
+	if err := s.patchTree(ctx, paths); err != nil {
+		logging.Warn("incremental tree update failed, rebuilding",
+			zap.Strings("paths", paths), zap.Error(err))
+		if err := s.RefreshTree(ctx); err != nil {
+			logging.Error("tree rebuild failed", zap.Error(err))
+		}
+	}
+}
+
+// patchTree does the work of updateTree. treeUpdateMu keeps the read and
+// the swap of concurrent updates in order, so an older read cannot
+// overwrite a newer one.
+func (s *Server) patchTree(ctx context.Context, paths []string) error {
+	s.treeUpdateMu.Lock()
+	defer s.treeUpdateMu.Unlock()
+
+	all := treeUpdatePaths(paths)
+	nodes, err := s.metadata.GetFileNodes(ctx, all)
+	if err != nil {
+		return err
+	}
+
+	// Apply to the tree as it is now, which a rebuild may have replaced
+	// during the query
+	s.treeMu.Lock()
+	defer s.treeMu.Unlock()
+	root := s.tree
+	if root == nil {
+		return fmt.Errorf("metadata tree not built")
+	}
+	for _, p := range all {
+		if node, ok := nodes[p]; ok {
+			if root, ok = fstree.Upsert(root, node); !ok {
+				return fmt.Errorf("parent of %s not in tree", p)
+			}
+		} else if p != "/" {
+			// Already absent is fine: the row may never have reached the tree
+			root, _ = fstree.Remove(root, p)
+		}
+	}
+	s.tree = root
+	s.treeGen++
+	return nil
+}
+
+// treeUpdatePaths returns paths and all their ancestors without
+// duplicates, parents first.
+func treeUpdatePaths(paths []string) []string {
+	seen := make(map[string]bool)
+	var all []string
+	for _, p := range paths {
+		p = "/" + strings.Trim(p, "/")
+		for _, a := range pathAncestors(p) {
+			if !seen[a] {
+				seen[a] = true
+				all = append(all, a)
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return strings.Count(strings.TrimSuffix(all[i], "/"), "/") < strings.Count(strings.TrimSuffix(all[j], "/"), "/")
+	})
+	return all
+}
+
+// pathAncestors returns "/", "/a", "/a/b" for "/a/b".
+func pathAncestors(p string) []string {
+	result := []string{"/"}
+	cur := ""
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		cur += "/" + part
+		result = append(result, cur)
+	}
+	return result
+}
+
+// startTreeRebuild rebuilds the tree every interval until ctx is done.
+func (s *Server) startTreeRebuild(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.RefreshTree(ctx); err != nil {
+					logging.Warn("periodic tree rebuild failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// handleTreeRebuild rebuilds the metadata tree from the database now.
+func (s *Server) handleTreeRebuild(w http.ResponseWriter, r *http.Request) {
+	if s.requireAdmin(w, r) == nil {
+		return
+	}
+
+	start := time.Now()
+	if err := s.RefreshTree(r.Context()); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to rebuild tree: "+err.Error())
+		return
+	}
+	logging.Info("metadata tree rebuilt on demand", zap.Duration("duration", time.Since(start)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":       fstree.CountNodes(s.currentTree()),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+)
+
+// ─── Version Retention ──────────────────────────────────────────────────────
+//
+// Saved versions are pruned by the version_keep_count and
+// version_max_age_days settings, or by the longest per-path override that
+// matches. The content of each pruned version is released, so shared
+// objects go once nothing else references them.
+
+// versionPruneInterval is how often versions are pruned.
+const versionPruneInterval = 6 * time.Hour
+
+// startVersionPrune prunes versions every interval until ctx is done.
+func (s *Server) startVersionPrune(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.pruneVersions(ctx)
+			}
+		}
+	}()
+}
+
+// pruneVersions deletes the versions outside the retention policy and
+// releases their content.
+func (s *Server) pruneVersions(ctx context.Context) {
+	st := s.settings.Get()
+	pruned, err := s.metadata.PruneVersions(ctx, postgres.RetentionPolicy{
+		KeepCount:  st.VersionKeepCount,
+		MaxAgeDays: st.VersionMaxAgeDays,
+	})
+	if err != nil {
+		logging.Error("version pruning failed", zap.Error(err))
+		return
+	}
+	if len(pruned) == 0 {
+		return
+	}
+
+	var freed int64
+	for _, p := range pruned {
+		// The version rows are gone, so say what is left behind
+		backend, _, err := s.storageRouter.ResolveForFile(ctx, p.StorageLocID, nil)
+		if err != nil || backend == nil {
+			logging.Warn("no storage for pruned version content",
+				zap.String("key", p.StorageKey), zap.Intp("location", p.StorageLocID), zap.Error(err))
+			continue
+		}
+		if err := s.metadata.ReleaseContent(ctx, p.StorageKey, p.StorageLocID, backend.DeleteObject); err != nil {
+			logging.Warn("failed to delete pruned version content",
+				zap.String("key", p.StorageKey), zap.Error(err))
+			continue
+		}
+		freed += p.Size
+	}
+	logging.Info("version pruning completed",
+		zap.Int("pruned", len(pruned)),
+		zap.Int64("bytes_freed", freed))
+}
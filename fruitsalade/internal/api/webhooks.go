@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/webhooks"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// ─── Admin: Webhooks ────────────────────────────────────────────────────────
+
+// requireWebhooks is requireAdmin for the webhook endpoints, which also
+// fail while webhooks are not enabled.
+func (s *Server) requireWebhooks(w http.ResponseWriter, r *http.Request) *auth.Claims {
+	claims := s.requireAdmin(w, r)
+	if claims == nil {
+		return nil
+	}
+	if s.webhookStore == nil || s.webhookDispatcher == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "webhooks are not enabled")
+		return nil
+	}
+	return claims
+}
+
+// webhookFromPath loads the webhook named by the {id} path value, sending
+// 400 or 404 if there is none.
+func (s *Server) webhookFromPath(w http.ResponseWriter, r *http.Request) *webhooks.Webhook {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid webhook ID")
+		return nil
+	}
+	hook, err := s.webhookStore.Get(r.Context(), id)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to get webhook: "+err.Error())
+		return nil
+	}
+	if hook == nil {
+		s.sendError(w, http.StatusNotFound, "webhook not found")
+		return nil
+	}
+	return hook
+}
+
+// reloadWebhooks makes the dispatcher pick up a change right away.
+func (s *Server) reloadWebhooks(r *http.Request) {
+	if err := s.webhookDispatcher.Reload(r.Context()); err != nil {
+		logging.Warn("failed to reload webhooks", zap.Error(err))
+	}
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if s.requireWebhooks(w, r) == nil {
+		return
+	}
+
+	hooks, err := s.webhookStore.List(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list webhooks: "+err.Error())
+		return
+	}
+
+	resp := make([]protocol.WebhookResponse, 0, len(hooks))
+	for _, h := range hooks {
+		resp = append(resp, webhookToResponse(h, false))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.requireWebhooks(w, r) == nil {
+		return
+	}
+	hook := s.webhookFromPath(w, r)
+	if hook == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhookToResponse(*hook, false))
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	claims := s.requireWebhooks(w, r)
+	if claims == nil {
+		return
+	}
+
+	var req protocol.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	hook := &webhooks.Webhook{
+		Name:       req.Name,
+		URL:        req.URL,
+		Secret:     req.Secret,
+		EventTypes: req.EventTypes,
+		PathPrefix: req.PathPrefix,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+		CreatedBy:  &claims.UserID,
+	}
+	if err := hook.Validate(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if hook.Secret == "" {
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			s.sendError(w, http.StatusInternalServerError, "failed to generate secret")
+			return
+		}
+		hook.Secret = secret
+	}
+
+	created, err := s.webhookStore.Create(r.Context(), hook)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to create webhook: "+err.Error())
+		return
+	}
+	s.reloadWebhooks(r)
+
+	logging.Info("webhook created",
+		zap.Int("id", created.ID), zap.String("name", created.Name), zap.String("admin", claims.Username))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhookToResponse(*created, true))
+}
+
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.requireWebhooks(w, r) == nil {
+		return
+	}
+	hook := s.webhookFromPath(w, r)
+	if hook == nil {
+		return
+	}
+
+	var req protocol.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	hook.Name = req.Name
+	hook.URL = req.URL
+	hook.EventTypes = req.EventTypes
+	hook.PathPrefix = req.PathPrefix
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	secretChanged := req.Secret != "" && req.Secret != hook.Secret
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	if err := hook.Validate(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.webhookStore.Update(r.Context(), hook); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to update webhook: "+err.Error())
+		return
+	}
+	s.reloadWebhooks(r)
+
+	logging.Info("webhook updated", zap.Int("id", hook.ID), zap.String("name", hook.Name))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhookToResponse(*hook, secretChanged))
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.requireWebhooks(w, r) == nil {
+		return
+	}
+	hook := s.webhookFromPath(w, r)
+	if hook == nil {
+		return
+	}
+
+	if err := s.webhookStore.Delete(r.Context(), hook.ID); err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to delete webhook: "+err.Error())
+		return
+	}
+	s.reloadWebhooks(r)
+
+	logging.Info("webhook deleted", zap.Int("id", hook.ID), zap.String("name", hook.Name))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      hook.ID,
+		"deleted": true,
+	})
+}
+
+// handleTestWebhook sends a "webhook-test" event to the webhook and waits
+// for the response. The delivery is logged but not retried.
+func (s *Server) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.requireWebhooks(w, r) == nil {
+		return
+	}
+	hook := s.webhookFromPath(w, r)
+	if hook == nil {
+		return
+	}
+
+	start := time.Now()
+	del, err := s.webhookDispatcher.Test(r.Context(), hook)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to send test event: "+err.Error())
+		return
+	}
+
+	resp := protocol.WebhookTestResponse{
+		Success:    del.Status == webhooks.StatusDelivered,
+		DeliveryID: del.ID,
+		Error:      del.LastError,
+		LatencyMs:  time.Since(start).Milliseconds(),
+	}
+	if del.ResponseStatus != nil {
+		resp.StatusCode = *del.ResponseStatus
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleWebhookDeliveries lists a webhook's deliveries, newest first.
+// ?status= filters by status, ?before= pages by delivery ID and ?limit=
+// caps the list (default 50, at most 500).
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if s.requireWebhooks(w, r) == nil {
+		return
+	}
+	hook := s.webhookFromPath(w, r)
+	if hook == nil {
+		return
+	}
+
+	q := r.URL.Query()
+	status := q.Get("status")
+	switch status {
+	case "", webhooks.StatusPending, webhooks.StatusSending, webhooks.StatusRetry,
+		webhooks.StatusDelivered, webhooks.StatusDead:
+	default:
+		s.sendError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+	limit := 50
+	if l := q.Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 || v > 500 {
+			s.sendError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = v
+	}
+	var before int64
+	if b := q.Get("before"); b != "" {
+		v, err := strconv.ParseInt(b, 10, 64)
+		if err != nil || v <= 0 {
+			s.sendError(w, http.StatusBadRequest, "invalid before")
+			return
+		}
+		before = v
+	}
+
+	deliveries, err := s.webhookStore.ListDeliveries(r.Context(), hook.ID, status, before, limit)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, "failed to list deliveries: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func webhookToResponse(h webhooks.Webhook, withSecret bool) protocol.WebhookResponse {
+	resp := protocol.WebhookResponse{
+		ID:         h.ID,
+		Name:       h.Name,
+		URL:        h.URL,
+		EventTypes: h.EventTypes,
+		PathPrefix: h.PathPrefix,
+		Enabled:    h.Enabled,
+		CreatedAt:  h.CreatedAt,
+		UpdatedAt:  h.UpdatedAt,
+	}
+	if withSecret {
+		resp.Secret = h.Secret
+	}
+	return resp
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/websocket"
+)
+
+// ─── WebSocket Events ───────────────────────────────────────────────────────
+//
+// GET /api/v1/ws delivers the same events as the SSE stream, one JSON text
+// frame per event, for networks whose proxies buffer SSE responses. The
+// token can be passed in the Authorization header or, for browsers, as
+// ?token=. Resuming works as for SSE, with the last seen event "id" in
+// Last-Event-ID or ?last_event_id=. Like SSE streams, a connection ends
+// with a reauth event before its token expires or when its session is
+// revoked.
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsIdleTimeout  = 2*wsPingInterval + 15*time.Second // no pong for two pings
+	wsWriteTimeout = 10 * time.Second
+	wsSendQueue    = 64
+)
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetClaims(r.Context())
+	if claims == nil {
+		s.sendError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	conn, err := websocket.Accept(w, r)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	defer conn.Close()
+	conn.WriteTimeout = wsWriteTimeout
+	conn.SetIdleTimeout(wsIdleTimeout)
+
+	ch, replay := s.subscribeEvents(r)
+	defer s.broadcaster.Unsubscribe(ch)
+
+	// The reader answers pings and notices pongs and the client closing;
+	// clients have nothing else to say.
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Missed events are written before the live stream starts; there may
+	// be more of them than the send queue holds.
+	for _, event := range replay {
+		if !events.DeliverTo(event, claims.UserID, claims.IsAdmin) {
+			continue
+		}
+		data, err := events.MarshalEvent(event)
+		if err != nil {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.OpText, data); err != nil {
+			return
+		}
+	}
+
+	queue := make(chan []byte, wsSendQueue)
+	writerDone := make(chan struct{})
+	go s.wsWriter(conn, queue, writerDone)
+
+	logging.Debug("websocket client connected", zap.String("username", claims.Username))
+	defer logging.Debug("websocket client disconnected", zap.String("username", claims.Username))
+
+	// The stream ends before its token expires; see streamauth.go
+	deadline, stop := streamTimer(claims)
+	defer stop()
+
+	for {
+		select {
+		case <-readerDone:
+			close(queue)
+			return
+		case <-writerDone:
+			return
+		case <-deadline:
+			if data, err := events.MarshalEvent(expiringEvent(claims)); err == nil {
+				select {
+				case queue <- data:
+				default:
+				}
+			}
+			close(queue)
+			<-writerDone
+			conn.CloseWith(websocket.CloseNormal, "token expiring")
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// Server shutting down or session revoked: send what is
+				// queued, then say goodbye
+				close(queue)
+				<-writerDone
+				conn.CloseWith(websocket.CloseGoingAway, "stream ended")
+				return
+			}
+			if !events.DeliverTo(event, claims.UserID, claims.IsAdmin) {
+				continue
+			}
+			data, err := events.MarshalEvent(event)
+			if err != nil {
+				logging.Warn("dropping invalid event", zap.String("type", event.Type), zap.Error(err))
+				continue
+			}
+			select {
+			case queue <- data:
+			default:
+				logging.Warn("websocket client too slow, disconnecting",
+					zap.String("username", claims.Username))
+				metrics.RecordWSClientDropped()
+				close(queue)
+				conn.CloseWith(websocket.CloseTryAgainLater, "send queue overflow")
+				return
+			}
+		}
+	}
+}
+
+// wsWriter writes queued events and keepalive pings until queue is closed
+// or a write fails.
+func (s *Server) wsWriter(conn *websocket.Conn, queue <-chan []byte, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-queue:
+			if !ok {
+				return
+			}
+			if err := conn.WriteMessage(websocket.OpText, data); err != nil {
+				conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.Ping(); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// APIKeyPrefix starts every API key secret, so keys can be told apart from
+// JWTs in an Authorization header.
+const APIKeyPrefix = "fsk_"
+
+// API key scopes.
+const (
+	APIKeyScopeRead      = "read"
+	APIKeyScopeReadWrite = "readwrite"
+)
+
+// APIKey is a long-lived credential for scripts and clients. The secret is
+// only returned when the key is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scope      string     `json:"scope"`
+	PathPrefix string     `json:"path_prefix,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ReadOnly reports whether the claims come from a read-only API key.
+func (c *Claims) ReadOnly() bool {
+	return c.APIKeyID != 0 && c.Scope == APIKeyScopeRead
+}
+
+// AllowsPath reports whether the claims may access p. Only API keys with a
+// path prefix are restricted.
+func (c *Claims) AllowsPath(p string) bool {
+	if c.PathPrefix == "" || c.PathPrefix == "/" {
+		return true
+	}
+	p = path.Clean("/" + p)
+	return p == c.PathPrefix || strings.HasPrefix(p, c.PathPrefix+"/")
+}
+
+// NormalizeAPIKeyScope validates a requested scope; empty means read-write.
+func NormalizeAPIKeyScope(scope string) (string, error) {
+	switch scope {
+	case "":
+		return APIKeyScopeReadWrite, nil
+	case APIKeyScopeRead, APIKeyScopeReadWrite:
+		return scope, nil
+	}
+	return "", fmt.Errorf("invalid scope %q (must be %s or %s)", scope, APIKeyScopeRead, APIKeyScopeReadWrite)
+}
+
+// CreateAPIKey generates a new key for a user and returns it together with
+// the secret, which is not stored and cannot be retrieved later.
+func (a *Auth) CreateAPIKey(ctx context.Context, userID int, name, scope, pathPrefix string) (*APIKey, string, error) {
+	scope, err := NormalizeAPIKeyScope(scope)
+	if err != nil {
+		return nil, "", err
+	}
+	if pathPrefix != "" {
+		pathPrefix = path.Clean("/" + pathPrefix)
+		if pathPrefix == "/" {
+			pathPrefix = ""
+		}
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("generate key: %w", err)
+	}
+	secret := APIKeyPrefix + hex.EncodeToString(buf)
+
+	k := &APIKey{
+		Name:       name,
+		Prefix:     secret[:len(APIKeyPrefix)+8],
+		Scope:      scope,
+		PathPrefix: pathPrefix,
+	}
+	err = a.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scope, path_prefix)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		userID, k.Name, k.Prefix, hashToken(secret), k.Scope, k.PathPrefix).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("create api key: %w", err)
+	}
+	return k, secret, nil
+}
+
+// ListAPIKeys returns a user's API keys (without secrets).
+func (a *Auth) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id, name, prefix, scope, path_prefix, created_at, last_used_at
+		 FROM api_keys WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scope, &k.PathPrefix, &k.CreatedAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("scan api key: %w", err)
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes an API key (must belong to userID).
+func (a *Auth) DeleteAPIKey(ctx context.Context, userID, keyID int) error {
+	result, err := a.db.ExecContext(ctx,
+		`DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("delete api key: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("api key not found")
+	}
+	return nil
+}
+
+// ValidateAPIKey looks up a key secret and returns the owner's claims with
+// the key's scope and path restriction.
+func (a *Auth) ValidateAPIKey(ctx context.Context, secret string) (*Claims, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, fmt.Errorf("not an api key")
+	}
+
+	claims := &Claims{}
+	err := a.db.QueryRowContext(ctx,
+		`SELECT k.id, k.scope, k.path_prefix, u.id, u.username, u.is_admin, u.must_change_password
+		 FROM api_keys k JOIN users u ON u.id = k.user_id
+		 WHERE k.key_hash = $1 AND u.disabled = FALSE`,
+		hashToken(secret)).Scan(&claims.APIKeyID, &claims.Scope, &claims.PathPrefix,
+		&claims.UserID, &claims.Username, &claims.IsAdmin, &claims.MustChangePassword)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown api key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// Keys are used for every request of a script; record use once a minute
+	a.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = NOW()
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`,
+		claims.APIKeyID)
+
+	return claims, nil
+}
+
+// extractAPIKey returns the API key sent with a request, either in the
+// X-API-Key header or as a bearer token with the key prefix.
+func extractAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); strings.HasPrefix(token, APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// serveAPIKey authenticates a request by API key for the middlewares.
+func (a *Auth) serveAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	claims, err := a.ValidateAPIKey(r.Context(), key)
+	if err != nil {
+		metrics.RecordAuthAttempt(false)
+		sendAuthError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+	if blockedByPasswordChange(w, r, claims) {
+		return
+	}
+	ctx := context.WithValue(r.Context(), userContextKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+)
+
+// MaxImpersonationTTL caps the lifetime of impersonation tokens, whatever
+// lifetime is configured.
+const MaxImpersonationTTL = 15 * time.Minute
+
+// ErrCannotImpersonate is returned by Impersonate for a user an admin may
+// not act as: themselves, another admin or a disabled account.
+var ErrCannotImpersonate = errors.New("user cannot be impersonated")
+
+// Impersonating reports whether the claims come from an impersonation
+// token.
+func (c *Claims) Impersonating() bool {
+	return c.ImpersonatorID != 0
+}
+
+// Impersonate issues a short-lived token that authenticates as the user
+// targetID on behalf of the admin. The token carries the user's claims
+// plus the admin as impersonator, cannot be refreshed and is revoked as
+// soon as the admin is demoted or disabled. ttl is capped at
+// MaxImpersonationTTL; zero means the cap.
+func (a *Auth) Impersonate(ctx context.Context, admin *Claims, targetID int, ttl time.Duration) (string, *Claims, error) {
+	if admin == nil || !admin.IsAdmin || admin.Impersonating() {
+		return "", nil, fmt.Errorf("%w: only admins acting as themselves may impersonate", ErrCannotImpersonate)
+	}
+	if targetID == admin.UserID {
+		return "", nil, fmt.Errorf("%w: cannot impersonate yourself", ErrCannotImpersonate)
+	}
+
+	var username string
+	var isAdmin, disabled bool
+	err := a.db.QueryRowContext(ctx,
+		`SELECT username, is_admin, disabled FROM users WHERE id = $1`, targetID).
+		Scan(&username, &isAdmin, &disabled)
+	if err == sql.ErrNoRows {
+		return "", nil, ErrUserNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("look up user: %w", err)
+	}
+	if isAdmin {
+		return "", nil, fmt.Errorf("%w: %s is an admin", ErrCannotImpersonate, username)
+	}
+	if disabled {
+		return "", nil, fmt.Errorf("%w: %s is disabled", ErrCannotImpersonate, username)
+	}
+
+	if ttl <= 0 || ttl > MaxImpersonationTTL {
+		ttl = MaxImpersonationTTL
+	}
+	now := time.Now()
+	claims := &Claims{
+		UserID:   targetID,
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "fruitsalade",
+		},
+		ImpersonatorID:   admin.UserID,
+		ImpersonatorName: admin.Username,
+	}
+	tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+	if err != nil {
+		return "", nil, fmt.Errorf("sign token: %w", err)
+	}
+
+	// Tracked like any session, so the user sees it and logout revokes it
+	_, err = a.db.ExecContext(ctx,
+		`INSERT INTO device_tokens (user_id, device_name, token_hash, client_version) VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		targetID, "impersonation by "+admin.Username, hashToken(tokenStr), ClientVersionFromContext(ctx))
+	if err != nil {
+		return "", nil, fmt.Errorf("record token: %w", err)
+	}
+	a.updateActiveTokenCount(ctx)
+
+	logging.Info("admin impersonation started",
+		zap.String("admin", admin.Username),
+		zap.String("user", username),
+		zap.Duration("ttl", ttl))
+	a.activity.Record(activity.Entry{
+		UserID:           targetID,
+		Username:         username,
+		Action:           activity.ActionImpersonate,
+		Details:          map[string]interface{}{"expires_at": claims.ExpiresAt.Time},
+		RequestID:        logging.GetRequestID(ctx),
+		ImpersonatorID:   admin.UserID,
+		ImpersonatorName: admin.Username,
+	})
+	return tokenStr, claims, nil
+}
+
+// mayImpersonate reports whether the user adminID is still an active admin.
+func (a *Auth) mayImpersonate(ctx context.Context, adminID int) bool {
+	var ok bool
+	err := a.db.QueryRowContext(ctx,
+		`SELECT is_admin AND NOT disabled FROM users WHERE id = $1`, adminID).Scan(&ok)
+	return err == nil && ok
+}
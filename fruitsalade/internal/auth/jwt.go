@@ -3,11 +3,13 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
@@ -24,7 +27,8 @@ import (
 type contextKey string
 
 const (
-	userContextKey contextKey = "user"
+	userContextKey          contextKey = "user"
+	clientVersionContextKey contextKey = "client_version"
 )
 
 // Claims holds JWT token claims.
@@ -33,26 +37,58 @@ type Claims struct {
 	Username string `json:"username"`
 	IsAdmin  bool   `json:"is_admin"`
 	jwt.RegisteredClaims
+
+	// Set on impersonation tokens: the admin acting as this user
+	ImpersonatorID   int    `json:"impersonator_id,omitempty"`
+	ImpersonatorName string `json:"impersonator_name,omitempty"`
+
+	// Loaded from the user record on every request, never signed
+	MustChangePassword bool `json:"-"`
+
+	// The device_tokens row of the token, if it is tracked; set by the
+	// middleware, never signed
+	SessionID int `json:"-"`
+
+	// Set when the request was authenticated with an API key
+	APIKeyID   int    `json:"-"`
+	Scope      string `json:"-"`
+	PathPrefix string `json:"-"`
 }
 
 // Auth handles JWT authentication.
 type Auth struct {
-	db     *sql.DB
-	secret []byte
-	oidc   *OIDCProvider
+	db       *sql.DB
+	secret   []byte
+	oidc     *OIDCProvider
+	activity *activity.Recorder
+	policy   PasswordPolicy
+
+	lockouts       *Lockouts
+	trustedProxies []*net.IPNet
 }
 
 // New creates a new Auth handler.
 func New(db *sql.DB, jwtSecret string) *Auth {
 	return &Auth{
-		db:     db,
-		secret: []byte(jwtSecret),
+		db:       db,
+		secret:   []byte(jwtSecret),
+		lockouts: NewLockouts(DefaultLockoutConfig),
 	}
 }
 
+// SetActivityRecorder enables recording logins in the activity log.
+func (a *Auth) SetActivityRecorder(recorder *activity.Recorder) {
+	a.activity = recorder
+}
+
 // Middleware returns HTTP middleware that validates JWT tokens.
 func (a *Auth) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := extractAPIKey(r); key != "" {
+			a.serveAPIKey(w, r, next, key)
+			return
+		}
+
 		tokenStr := extractToken(r)
 		if tokenStr == "" {
 			metrics.RecordAuthAttempt(false)
@@ -68,7 +104,7 @@ func (a *Auth) Middleware(next http.Handler) http.Handler {
 		}
 
 		// Check if token is revoked
-		revoked, err := a.isTokenRevoked(r.Context(), tokenStr)
+		revoked, err := a.checkSession(r.Context(), tokenStr, claims)
 		if err != nil {
 			logging.Error("token revocation check failed", zap.Error(err))
 		}
@@ -77,6 +113,9 @@ func (a *Auth) Middleware(next http.Handler) http.Handler {
 			sendAuthError(w, http.StatusUnauthorized, "token has been revoked")
 			return
 		}
+		if blockedByPasswordChange(w, r, claims) {
+			return
+		}
 
 		// Store claims in context
 		ctx := context.WithValue(r.Context(), userContextKey, claims)
@@ -109,17 +148,22 @@ func (a *Auth) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		sendAuthError(w, http.StatusBadRequest, "username and password required")
 		return
 	}
+	if a.LoginLocked(w, r, req.Username) {
+		return
+	}
 
 	// Look up user
 	var userID int
 	var hashedPassword string
-	var isAdmin bool
+	var isAdmin, disabled, mustChange bool
 	err := a.db.QueryRowContext(r.Context(),
-		`SELECT id, password, is_admin FROM users WHERE username = $1`,
-		req.Username).Scan(&userID, &hashedPassword, &isAdmin)
+		`SELECT id, password, is_admin, disabled, must_change_password FROM users WHERE username = $1`,
+		req.Username).Scan(&userID, &hashedPassword, &isAdmin, &disabled, &mustChange)
 	if err == sql.ErrNoRows {
 		metrics.RecordAuthAttempt(false)
 		logging.Warn("login failed: unknown user", zap.String("username", req.Username))
+		a.recordLoginFailed(r, 0, req.Username, "unknown user")
+		a.CountLoginFailure(req.Username, a.ClientIP(r))
 		sendAuthError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
@@ -134,11 +178,21 @@ func (a *Auth) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
 		metrics.RecordAuthAttempt(false)
 		logging.Warn("login failed: invalid password", zap.String("username", req.Username))
+		a.recordLoginFailed(r, userID, req.Username, "invalid password")
+		a.CountLoginFailure(req.Username, a.ClientIP(r))
 		sendAuthError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
+	if disabled {
+		metrics.RecordAuthAttempt(false)
+		logging.Warn("login failed: account disabled", zap.String("username", req.Username))
+		a.recordLoginFailed(r, userID, req.Username, "account disabled")
+		sendAuthError(w, http.StatusForbidden, "account disabled")
+		return
+	}
 
-	// Check if TOTP is enabled — if so, return a temp token for 2FA verification
+	// Check if TOTP is enabled — if so, return a temp token for 2FA verification.
+	// The failure count is only reset once the code is verified too.
 	totpEnabled, _ := a.IsTOTPEnabled(r.Context(), userID)
 	if totpEnabled {
 		tempToken, err := a.GenerateTOTPTempToken(userID, req.Username, isAdmin)
@@ -182,18 +236,28 @@ func (a *Auth) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if deviceName == "" {
 		deviceName = "unknown"
 	}
+	clientVersion := ClientVersionFromContext(r.Context())
 	tokenHash := hashToken(tokenStr)
 	_, err = a.db.ExecContext(r.Context(),
-		`INSERT INTO device_tokens (user_id, device_name, token_hash) VALUES ($1, $2, $3)`,
-		userID, deviceName, tokenHash)
+		`INSERT INTO device_tokens (user_id, device_name, token_hash, client_version) VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		userID, deviceName, tokenHash, clientVersion)
 	if err != nil {
 		logging.Error("failed to record device token", zap.Error(err))
 	}
 
 	metrics.RecordAuthAttempt(true)
+	a.ResetLoginFailures(req.Username)
 	logging.Info("login successful",
 		zap.String("username", req.Username),
-		zap.String("device", deviceName))
+		zap.String("device", deviceName),
+		zap.String("client_version", clientVersion))
+	a.activity.Record(activity.Entry{
+		UserID:    userID,
+		Username:  req.Username,
+		Action:    activity.ActionLogin,
+		Details:   map[string]interface{}{"device": deviceName, "remote_addr": r.RemoteAddr},
+		RequestID: logging.GetRequestID(r.Context()),
+	})
 
 	// Update active token count
 	a.updateActiveTokenCount(r.Context())
@@ -203,23 +267,32 @@ func (a *Auth) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		"token":      tokenStr,
 		"expires_at": claims.ExpiresAt.Time,
 		"user": map[string]interface{}{
-			"id":       userID,
-			"username": req.Username,
-			"is_admin": isAdmin,
+			"id":                   userID,
+			"username":             req.Username,
+			"is_admin":             isAdmin,
+			"must_change_password": mustChange,
 		},
 	})
 }
 
-// CreateUser creates a new user (admin only, or first user).
+// CreateUser creates a new user (admin only, or first user). The password
+// must meet the password policy.
 func (a *Auth) CreateUser(ctx context.Context, username, password string, isAdmin bool) error {
+	if err := a.policy.Validate(username, password); err != nil {
+		return err
+	}
+	return a.createUser(ctx, username, password, isAdmin, false)
+}
+
+func (a *Auth) createUser(ctx context.Context, username, password string, isAdmin, mustChange bool) error {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("hash password: %w", err)
 	}
 
 	_, err = a.db.ExecContext(ctx,
-		`INSERT INTO users (username, password, is_admin) VALUES ($1, $2, $3)`,
-		username, string(hashed), isAdmin)
+		`INSERT INTO users (username, password, is_admin, must_change_password) VALUES ($1, $2, $3, $4)`,
+		username, string(hashed), isAdmin, mustChange)
 	if err != nil {
 		return fmt.Errorf("insert user: %w", err)
 	}
@@ -228,7 +301,37 @@ func (a *Auth) CreateUser(ctx context.Context, username, password string, isAdmi
 	return nil
 }
 
-// EnsureDefaultAdmin creates a default admin user if no users exist.
+// GetUserIDByUsername returns the ID of the user with the given name, or 0
+// if there is none.
+func (a *Auth) GetUserIDByUsername(ctx context.Context, username string) (int, error) {
+	var id int
+	err := a.db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1`, username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lookup user: %w", err)
+	}
+	return id, nil
+}
+
+// CreateUserRandomPassword creates a non-admin user with a random password
+// nobody knows, for accounts created by import tools. An admin has to set
+// a password (or the user signs in through OIDC) before it can be used.
+func (a *Auth) CreateUserRandomPassword(ctx context.Context, username string) (int, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, fmt.Errorf("generate password: %w", err)
+	}
+	if err := a.createUser(ctx, username, hex.EncodeToString(buf), false, true); err != nil {
+		return 0, err
+	}
+	return a.GetUserIDByUsername(ctx, username)
+}
+
+// EnsureDefaultAdmin creates a default admin user if no users exist. It
+// must change its password before it can do anything else, as must an
+// existing admin account still using the default password.
 func (a *Auth) EnsureDefaultAdmin(ctx context.Context) error {
 	var count int
 	err := a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
@@ -238,8 +341,24 @@ func (a *Auth) EnsureDefaultAdmin(ctx context.Context) error {
 
 	if count == 0 {
 		logging.Warn("no users found, creating default admin (admin/admin)")
-		logging.Warn("** change the default password immediately! **")
-		return a.CreateUser(ctx, "admin", "admin", true)
+		logging.Warn("** the password must be changed at first login **")
+		return a.createUser(ctx, "admin", "admin", true, true)
+	}
+
+	var userID int
+	var hashed string
+	err = a.db.QueryRowContext(ctx,
+		`SELECT id, password FROM users WHERE username = 'admin' AND must_change_password = FALSE`).
+		Scan(&userID, &hashed)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check default admin: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashed), []byte("admin")) == nil {
+		logging.Warn("admin still uses the default password, a change is required at next login")
+		return a.ForcePasswordChange(ctx, userID)
 	}
 	return nil
 }
@@ -270,8 +389,8 @@ func (a *Auth) IssueToken(ctx context.Context, userID int, username string, isAd
 	}
 	tokenHash := hashToken(tokenStr)
 	_, err = a.db.ExecContext(ctx,
-		`INSERT INTO device_tokens (user_id, device_name, token_hash) VALUES ($1, $2, $3)`,
-		userID, deviceName, tokenHash)
+		`INSERT INTO device_tokens (user_id, device_name, token_hash, client_version) VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		userID, deviceName, tokenHash, ClientVersionFromContext(ctx))
 	if err != nil {
 		logging.Error("failed to record device token", zap.Error(err))
 	}
@@ -281,6 +400,18 @@ func (a *Auth) IssueToken(ctx context.Context, userID int, username string, isAd
 	return tokenStr, claims.ExpiresAt.Time, nil
 }
 
+// recordLoginFailed records a rejected password login. userID is 0 for
+// unknown users.
+func (a *Auth) recordLoginFailed(r *http.Request, userID int, username, reason string) {
+	a.activity.Record(activity.Entry{
+		UserID:    userID,
+		Username:  username,
+		Action:    activity.ActionLoginFailed,
+		Details:   map[string]interface{}{"reason": reason, "remote_addr": r.RemoteAddr, "ip": a.ClientIP(r)},
+		RequestID: logging.GetRequestID(r.Context()),
+	})
+}
+
 func (a *Auth) validateToken(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
@@ -313,6 +444,62 @@ func (a *Auth) isTokenRevoked(ctx context.Context, tokenStr string) (bool, error
 	return revoked, nil
 }
 
+// checkSession is isTokenRevoked for the auth middleware. Tokens of deleted
+// or disabled users count as revoked, and claims.IsAdmin is refreshed from
+// the user record so promotions and demotions apply at once. A tracked
+// token's row ID becomes claims.SessionID. It also
+// records the client version the session is used with, logging when a
+// device switches versions (e.g. after an upgrade) so stragglers can be
+// found.
+func (a *Auth) checkSession(ctx context.Context, tokenStr string, claims *Claims) (bool, error) {
+	h := hashToken(tokenStr)
+	var revoked, disabled, isAdmin, mustChange bool
+	var sessionID int
+	var stored sql.NullString
+	err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(d.revoked, FALSE), COALESCE(d.id, 0), d.client_version, u.disabled, u.is_admin, u.must_change_password
+		 FROM users u LEFT JOIN device_tokens d ON d.token_hash = $1 AND d.user_id = u.id
+		 WHERE u.id = $2`, h, claims.UserID).Scan(&revoked, &sessionID, &stored, &disabled, &isAdmin, &mustChange)
+	if err == sql.ErrNoRows {
+		return true, nil // User deleted
+	}
+	if err != nil {
+		return false, err
+	}
+	if disabled {
+		return true, nil
+	}
+	claims.IsAdmin = isAdmin
+	claims.MustChangePassword = mustChange
+	if claims.Impersonating() {
+		// Ends as soon as the admin loses their rights; a pending password
+		// change is the user's, not the admin's
+		if !a.mayImpersonate(ctx, claims.ImpersonatorID) {
+			return true, nil
+		}
+		claims.MustChangePassword = false
+	}
+	if sessionID == 0 {
+		return false, nil // Token not tracked = not revoked
+	}
+	claims.SessionID = sessionID
+
+	current := ClientVersionFromContext(ctx)
+	if !revoked && current != "" && current != stored.String {
+		if _, err := a.db.ExecContext(ctx,
+			`UPDATE device_tokens SET client_version = $1, last_used = NOW() WHERE token_hash = $2`,
+			current, h); err != nil {
+			logging.Warn("failed to record client version", zap.Error(err))
+		} else {
+			logging.Info("session client version changed",
+				zap.Int("user_id", claims.UserID),
+				zap.String("from", stored.String),
+				zap.String("to", current))
+		}
+	}
+	return revoked, nil
+}
+
 func (a *Auth) updateActiveTokenCount(ctx context.Context) {
 	var count int64
 	err := a.db.QueryRowContext(ctx,
@@ -339,10 +526,17 @@ func hashToken(token string) string {
 
 // User represents a user account.
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	IsAdmin   bool      `json:"is_admin"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	IsAdmin     bool      `json:"is_admin"`
+	Disabled    bool      `json:"disabled"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Filled in by ListUsers for the admin table
+	GroupCount  int   `json:"group_count"`
+	StorageUsed int64 `json:"storage_used"`
 }
 
 // HasOIDC returns true if an OIDC provider is configured.
@@ -364,10 +558,16 @@ func (a *Auth) DB() *sql.DB {
 	return a.db
 }
 
-// ListUsers returns all users ordered by ID.
+// ListUsers returns all users ordered by ID, with their group count and
+// storage used (the sum of owned file sizes, as for quotas).
 func (a *Auth) ListUsers(ctx context.Context) ([]User, error) {
 	rows, err := a.db.QueryContext(ctx,
-		`SELECT id, username, is_admin, created_at FROM users ORDER BY id`)
+		`SELECT u.id, u.username, u.is_admin, u.disabled, u.email, u.display_name, u.created_at,
+		        COALESCE(g.n, 0), COALESCE(f.used, 0)
+		 FROM users u
+		 LEFT JOIN (SELECT user_id, COUNT(*) AS n FROM group_members GROUP BY user_id) g ON g.user_id = u.id
+		 LEFT JOIN (SELECT owner_id, SUM(size) AS used FROM files WHERE is_dir = FALSE GROUP BY owner_id) f ON f.owner_id = u.id
+		 ORDER BY u.id`)
 	if err != nil {
 		return nil, fmt.Errorf("list users: %w", err)
 	}
@@ -376,7 +576,8 @@ func (a *Auth) ListUsers(ctx context.Context) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.IsAdmin, &u.CreatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.IsAdmin, &u.Disabled, &u.Email, &u.DisplayName, &u.CreatedAt,
+			&u.GroupCount, &u.StorageUsed); err != nil {
 			return nil, fmt.Errorf("scan user: %w", err)
 		}
 		users = append(users, u)
@@ -384,34 +585,22 @@ func (a *Auth) ListUsers(ctx context.Context) ([]User, error) {
 	return users, rows.Err()
 }
 
-// DeleteUser deletes a user by ID.
-func (a *Auth) DeleteUser(ctx context.Context, userID int) error {
-	result, err := a.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
-	if err != nil {
-		return fmt.Errorf("delete user: %w", err)
-	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("user not found")
-	}
-	logging.Info("user deleted", zap.Int("user_id", userID))
-	return nil
-}
-
-// ChangePassword changes the password for a user.
+// ChangePassword resets the password for a user (admin only). The user
+// must change it again at their next request.
 func (a *Auth) ChangePassword(ctx context.Context, userID int, newPassword string) error {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	if err != nil {
-		return fmt.Errorf("hash password: %w", err)
+	var username string
+	err := a.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
 	}
-	result, err := a.db.ExecContext(ctx,
-		`UPDATE users SET password = $1 WHERE id = $2`, string(hashed), userID)
 	if err != nil {
-		return fmt.Errorf("change password: %w", err)
+		return fmt.Errorf("get user: %w", err)
 	}
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("user not found")
+	if err := a.policy.Validate(username, newPassword); err != nil {
+		return err
+	}
+	if err := a.setPassword(ctx, userID, newPassword, true); err != nil {
+		return err
 	}
 	logging.Info("password changed", zap.Int("user_id", userID))
 	return nil
@@ -434,17 +623,20 @@ func (a *Auth) ActiveSessionCount(ctx context.Context) (int64, error) {
 
 // DeviceToken represents a device session.
 type DeviceToken struct {
-	ID         int       `json:"id"`
-	DeviceName string    `json:"device_name"`
-	CreatedAt  time.Time `json:"created_at"`
-	LastUsed   time.Time `json:"last_used"`
-	Revoked    bool      `json:"revoked"`
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	DeviceName    string    `json:"device_name"`
+	ClientVersion string    `json:"client_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastUsed      time.Time `json:"last_used"`
+	Revoked       bool      `json:"revoked"`
 }
 
 // ListSessions returns all device tokens for a user.
 func (a *Auth) ListSessions(ctx context.Context, userID int) ([]DeviceToken, error) {
 	rows, err := a.db.QueryContext(ctx,
-		`SELECT id, device_name, created_at, COALESCE(last_used, created_at), revoked
+		`SELECT id, device_name, COALESCE(client_version, ''), created_at, COALESCE(last_used, created_at), revoked
 		 FROM device_tokens WHERE user_id = $1 ORDER BY created_at DESC`, userID)
 	if err != nil {
 		return nil, fmt.Errorf("list sessions: %w", err)
@@ -454,7 +646,33 @@ func (a *Auth) ListSessions(ctx context.Context, userID int) ([]DeviceToken, err
 	var tokens []DeviceToken
 	for rows.Next() {
 		var t DeviceToken
-		if err := rows.Scan(&t.ID, &t.DeviceName, &t.CreatedAt, &t.LastUsed, &t.Revoked); err != nil {
+		if err := rows.Scan(&t.ID, &t.DeviceName, &t.ClientVersion, &t.CreatedAt, &t.LastUsed, &t.Revoked); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// ListAllSessions returns the active (non-revoked) device tokens of all
+// users, most recently used first. Used by the admin sessions listing.
+func (a *Auth) ListAllSessions(ctx context.Context) ([]DeviceToken, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT d.id, d.user_id, u.username, d.device_name, COALESCE(d.client_version, ''),
+		        d.created_at, COALESCE(d.last_used, d.created_at), d.revoked
+		 FROM device_tokens d JOIN users u ON u.id = d.user_id
+		 WHERE d.revoked = FALSE
+		 ORDER BY COALESCE(d.last_used, d.created_at) DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("list all sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []DeviceToken
+	for rows.Next() {
+		var t DeviceToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Username, &t.DeviceName, &t.ClientVersion,
+			&t.CreatedAt, &t.LastUsed, &t.Revoked); err != nil {
 			return nil, fmt.Errorf("scan session: %w", err)
 		}
 		tokens = append(tokens, t)
@@ -509,14 +727,20 @@ func (a *Auth) RefreshToken(ctx context.Context, oldTokenStr string) (string, ti
 	if revoked {
 		return "", time.Time{}, fmt.Errorf("token has been revoked")
 	}
+	if claims.Impersonating() {
+		return "", time.Time{}, fmt.Errorf("impersonation tokens cannot be refreshed")
+	}
 
-	// Re-verify user still exists
-	var isAdmin bool
+	// Re-verify user still exists and may log in
+	var isAdmin, disabled bool
 	err = a.db.QueryRowContext(ctx,
-		`SELECT is_admin FROM users WHERE id = $1`, claims.UserID).Scan(&isAdmin)
+		`SELECT is_admin, disabled FROM users WHERE id = $1`, claims.UserID).Scan(&isAdmin, &disabled)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("user not found")
 	}
+	if disabled {
+		return "", time.Time{}, ErrUserDisabled
+	}
 
 	// Generate new token
 	now := time.Now()
@@ -552,8 +776,8 @@ func (a *Auth) RefreshToken(ctx context.Context, oldTokenStr string) (string, ti
 	// Record new token
 	newHash := hashToken(newTokenStr)
 	a.db.ExecContext(ctx,
-		`INSERT INTO device_tokens (user_id, device_name, token_hash) VALUES ($1, $2, $3)`,
-		claims.UserID, deviceName, newHash)
+		`INSERT INTO device_tokens (user_id, device_name, token_hash, client_version) VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		claims.UserID, deviceName, newHash, ClientVersionFromContext(ctx))
 
 	a.updateActiveTokenCount(ctx)
 
@@ -566,19 +790,25 @@ func (a *Auth) RefreshToken(ctx context.Context, oldTokenStr string) (string, ti
 func (a *Auth) ValidateCredentials(ctx context.Context, username, password string) (*Claims, error) {
 	var userID int
 	var hashedPassword string
-	var isAdmin bool
+	var isAdmin, disabled, mustChange bool
 	err := a.db.QueryRowContext(ctx,
-		`SELECT id, password, is_admin FROM users WHERE username = $1`,
-		username).Scan(&userID, &hashedPassword, &isAdmin)
+		`SELECT id, password, is_admin, disabled, must_change_password FROM users WHERE username = $1`,
+		username).Scan(&userID, &hashedPassword, &isAdmin, &disabled, &mustChange)
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+	if disabled {
+		return nil, ErrUserDisabled
+	}
+	if mustChange {
+		return nil, ErrPasswordChangeRequired
 	}
 
 	return &Claims{
@@ -593,11 +823,26 @@ func WithClaims(ctx context.Context, claims *Claims) context.Context {
 	return context.WithValue(ctx, userContextKey, claims)
 }
 
+// ContextWithClientVersion records the requesting client's version (from its
+// User-Agent) so new and existing sessions can be tagged with it.
+func ContextWithClientVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, clientVersionContextKey, version)
+}
+
+// ClientVersionFromContext returns the client version recorded by
+// ContextWithClientVersion, or "" for non-FruitSalade clients.
+func ClientVersionFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(clientVersionContextKey).(string)
+	return v
+}
+
 func sendAuthError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(protocol.ErrorResponse{
-		Error: message,
-		Code:  code,
+		Error:     message,
+		Code:      code,
+		ErrorCode: protocol.ErrorCodeForStatus(code),
+		RequestID: w.Header().Get("X-Request-ID"), // set by logging.Middleware
 	})
 }
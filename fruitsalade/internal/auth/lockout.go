@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Login Lockout ───────────────────────────────────────────────────────────
+//
+// Failed password and TOTP attempts are counted per username and per client
+// IP. Once a key reaches its failure limit it is locked out, for BaseLockout
+// at first and twice as long with every further failure up to MaxLockout.
+// Attempts made while locked out are refused without checking the password.
+// A successful login clears the username's count; IP counts, like all
+// counts, are forgotten MaxLockout after their last failure.
+//
+// The state is kept in memory: it is lost on restart and not shared between
+// server instances.
+
+// Lockout kinds.
+const (
+	LockoutUser = "user"
+	LockoutIP   = "ip"
+)
+
+// LockoutConfig configures login lockout. MaxFailures 0 disables it.
+type LockoutConfig struct {
+	MaxFailures      int // per username
+	MaxFailuresPerIP int
+	BaseLockout      time.Duration
+	MaxLockout       time.Duration
+}
+
+// DefaultLockoutConfig is used until SetLockoutConfig is called.
+var DefaultLockoutConfig = LockoutConfig{
+	MaxFailures:      5,
+	MaxFailuresPerIP: 20,
+	BaseLockout:      time.Minute,
+	MaxLockout:       time.Hour,
+}
+
+// Lockout describes the failure count of a username or IP.
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Lockouts tracks failed login attempts. Safe for concurrent use.
+type Lockouts struct {
+	mu        sync.Mutex
+	cfg       LockoutConfig
+	entries   map[string]*lockoutEntry // "kind:key"
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewLockouts creates a tracker with the given config.
+func NewLockouts(cfg LockoutConfig) *Lockouts {
+	return &Lockouts{
+		cfg:     cfg,
+		entries: make(map[string]*lockoutEntry),
+		now:     time.Now,
+	}
+}
+
+// RetryAfter returns how long the username or IP is still locked out, or 0.
+func (l *Lockouts) RetryAfter(username, ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var wait time.Duration
+	for _, k := range lockoutKeys(username, ip) {
+		if e := l.entries[k]; e != nil && e.lockedUntil.After(now) {
+			wait = max(wait, e.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Fail counts a failed attempt and returns the kinds that became locked out
+// by it.
+func (l *Lockouts) Fail(username, ip string) []string {
+	if l.cfg.MaxFailures <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+
+	var locked []string
+	for _, k := range lockoutKeys(username, ip) {
+		e := l.entries[k]
+		if e == nil {
+			e = &lockoutEntry{}
+			l.entries[k] = e
+		}
+		e.failures++
+		e.lastFailure = now
+
+		kind, _, _ := strings.Cut(k, ":")
+		limit := l.cfg.MaxFailures
+		if kind == LockoutIP {
+			limit = l.cfg.MaxFailuresPerIP
+		}
+		if limit > 0 && e.failures >= limit {
+			e.lockedUntil = now.Add(l.lockoutDuration(e.failures - limit))
+			locked = append(locked, kind)
+		}
+	}
+	return locked
+}
+
+// lockoutDuration is BaseLockout doubled for every failure past the limit.
+func (l *Lockouts) lockoutDuration(extra int) time.Duration {
+	d := l.cfg.BaseLockout
+	for i := 0; i < extra && d < l.cfg.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, l.cfg.MaxLockout)
+}
+
+// Succeed clears the username's failures after a successful login.
+func (l *Lockouts) Succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, LockoutUser+":"+username)
+}
+
+// List returns the tracked usernames and IPs, locked out ones first.
+func (l *Lockouts) List() []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+
+	list := make([]Lockout, 0, len(l.entries))
+	for k, e := range l.entries {
+		kind, key, _ := strings.Cut(k, ":")
+		lo := Lockout{Kind: kind, Key: key, Failures: e.failures, LastFailure: e.lastFailure}
+		if e.lockedUntil.After(now) {
+			lo.LockedUntil = e.lockedUntil
+		}
+		list = append(list, lo)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].LockedUntil.IsZero() != list[j].LockedUntil.IsZero() {
+			return !list[i].LockedUntil.IsZero()
+		}
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+	return list
+}
+
+// Clear forgets the failures of a username or IP and reports whether there
+// were any.
+func (l *Lockouts) Clear(kind, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := kind + ":" + key
+	_, ok := l.entries[k]
+	delete(l.entries, k)
+	return ok
+}
+
+// prune drops entries whose last failure is older than MaxLockout, at most
+// once a minute. Called with mu held.
+func (l *Lockouts) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, e := range l.entries {
+		if now.Sub(e.lastFailure) > l.cfg.MaxLockout && !e.lockedUntil.After(now) {
+			delete(l.entries, k)
+		}
+	}
+}
+
+func lockoutKeys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, LockoutUser+":"+username)
+	}
+	if ip != "" {
+		keys = append(keys, LockoutIP+":"+ip)
+	}
+	return keys
+}
+
+// SetLockoutConfig replaces the lockout tracker, clearing all counts.
+func (a *Auth) SetLockoutConfig(cfg LockoutConfig) {
+	a.lockouts = NewLockouts(cfg)
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For header is
+// believed when determining the client IP of a login.
+func (a *Auth) SetTrustedProxies(nets []*net.IPNet) {
+	a.trustedProxies = nets
+}
+
+// Lockouts returns the login lockout tracker.
+func (a *Auth) Lockouts() *Lockouts {
+	return a.lockouts
+}
+
+// ClientIP returns the IP a request comes from. X-Forwarded-For is walked
+// from the right as long as the hop it names is a trusted proxy, so a
+// client cannot pick its own address by sending the header.
+func (a *Auth) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !a.trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !a.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (a *Auth) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range a.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma-separated list of IPs and CIDRs.
+func ParseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// LoginLocked answers a login attempt for username with 429 and a
+// Retry-After header if the user or client IP is locked out, and reports
+// whether it did.
+func (a *Auth) LoginLocked(w http.ResponseWriter, r *http.Request, username string) bool {
+	wait := a.lockouts.RetryAfter(username, a.ClientIP(r))
+	if wait <= 0 {
+		return false
+	}
+	a.recordLockedOutAttempt(username, a.ClientIP(r))
+	secs := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	sendAuthError(w, http.StatusTooManyRequests,
+		fmt.Sprintf("too many failed login attempts, try again in %d seconds", secs))
+	return true
+}
+
+// LoginRetryAfter returns how long logins for username from ip are still
+// refused, counting the attempt if they are. For frontends without an
+// http.Request, such as SFTP.
+func (a *Auth) LoginRetryAfter(username, ip string) time.Duration {
+	wait := a.lockouts.RetryAfter(username, ip)
+	if wait > 0 {
+		a.recordLockedOutAttempt(username, ip)
+	}
+	return wait
+}
+
+// CountLoginFailure counts a wrong password or TOTP code for username from
+// ip, recording a lockout in the activity log and metrics when one starts.
+func (a *Auth) CountLoginFailure(username, ip string) {
+	for _, kind := range a.lockouts.Fail(username, ip) {
+		metrics.RecordLoginLockout(kind)
+		logging.Warn("login locked out",
+			zap.String("kind", kind),
+			zap.String("username", username),
+			zap.String("ip", ip))
+		a.activity.Record(activity.Entry{
+			Username: username,
+			Action:   activity.ActionLoginLockout,
+			Details:  map[string]interface{}{"kind": kind, "ip": ip},
+		})
+	}
+}
+
+// ResetLoginFailures clears the failure count of username after a
+// completed login.
+func (a *Auth) ResetLoginFailures(username string) {
+	a.lockouts.Succeed(username)
+}
+
+func (a *Auth) recordLockedOutAttempt(username, ip string) {
+	metrics.RecordAuthAttempt(false)
+	metrics.RecordLoginLockedOut()
+	a.activity.Record(activity.Entry{
+		Username: username,
+		Action:   activity.ActionLoginFailed,
+		Details:  map[string]interface{}{"reason": "locked out", "ip": ip},
+	})
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLockouts() (*Lockouts, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLockouts(LockoutConfig{
+		MaxFailures:      3,
+		MaxFailuresPerIP: 5,
+		BaseLockout:      time.Minute,
+		MaxLockout:       10 * time.Minute,
+	})
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	l, now := newTestLockouts()
+
+	for i := 0; i < 2; i++ {
+		if locked := l.Fail("alice", "10.0.0.1"); len(locked) != 0 {
+			t.Fatalf("failure %d locked %v", i+1, locked)
+		}
+	}
+	if l.RetryAfter("alice", "") != 0 {
+		t.Fatal("locked out before the limit")
+	}
+
+	// Each failure past the limit doubles the lockout, up to MaxLockout
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		locked := l.Fail("alice", "")
+		if len(locked) != 1 || locked[0] != LockoutUser {
+			t.Fatalf("failure %d locked %v", i+3, locked)
+		}
+		if got := l.RetryAfter("alice", ""); got != want {
+			t.Errorf("failure %d: lockout %v, want %v", i+3, got, want)
+		}
+	}
+
+	*now = now.Add(11 * time.Minute)
+	if got := l.RetryAfter("alice", ""); got != 0 {
+		t.Errorf("still locked out after expiry: %v", got)
+	}
+
+	// Others from the same IP are not locked out by alice's failures
+	if got := l.RetryAfter("bob", "10.0.0.1"); got != 0 {
+		t.Errorf("bob locked out: %v", got)
+	}
+}
+
+func TestLockoutPerIP(t *testing.T) {
+	l, _ := newTestLockouts()
+
+	// A spray across usernames trips the IP limit
+	var locked []string
+	for _, user := range []string{"a", "b", "c", "d", "e"} {
+		locked = l.Fail(user, "10.0.0.2")
+	}
+	if len(locked) != 1 || locked[0] != LockoutIP {
+		t.Fatalf("fifth failure locked %v, want ip", locked)
+	}
+	if l.RetryAfter("someone", "10.0.0.2") == 0 {
+		t.Error("IP not locked out")
+	}
+	if l.RetryAfter("someone", "10.0.0.3") != 0 {
+		t.Error("other IP locked out")
+	}
+}
+
+func TestLockoutSucceedAndClear(t *testing.T) {
+	l, _ := newTestLockouts()
+	for i := 0; i < 3; i++ {
+		l.Fail("alice", "10.0.0.1")
+	}
+	if len(l.List()) != 2 || l.List()[0].LockedUntil.IsZero() {
+		t.Fatalf("List = %+v", l.List())
+	}
+
+	l.Succeed("alice")
+	if l.RetryAfter("alice", "") != 0 {
+		t.Error("successful login did not clear the username")
+	}
+	if list := l.List(); len(list) != 1 || list[0].Kind != LockoutIP {
+		t.Errorf("after success List = %+v, want only the IP", list)
+	}
+
+	if !l.Clear(LockoutIP, "10.0.0.1") {
+		t.Error("Clear of a tracked IP = false")
+	}
+	if l.Clear(LockoutIP, "10.0.0.1") {
+		t.Error("second Clear = true")
+	}
+}
+
+func TestLockoutDisabled(t *testing.T) {
+	l := NewLockouts(LockoutConfig{})
+	for i := 0; i < 100; i++ {
+		l.Fail("alice", "10.0.0.1")
+	}
+	if l.RetryAfter("alice", "10.0.0.1") != 0 || len(l.List()) != 0 {
+		t.Error("disabled lockout tracked failures")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Auth{trustedProxies: proxies}
+
+	tests := []struct {
+		remote, xff, want string
+	}{
+		{"203.0.113.5:1234", "", "203.0.113.5"},
+		{"203.0.113.5:1234", "198.51.100.1", "203.0.113.5"}, // untrusted peer
+		{"10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:1234", "1.1.1.1, 198.51.100.1, 192.168.1.1", "198.51.100.1"}, // spoofed leftmost hop
+		{"10.1.2.3:1234", "garbage", "10.1.2.3"},
+		{"10.1.2.3:1234", "", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/v1/auth/token", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := a.ClientIP(r); got != tt.want {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/golang-jwt/jwt/v5"
@@ -21,15 +24,25 @@ type OIDCConfig struct {
 	ClientSecret         string
 	AdminClaim           string // claim key for admin status (default: "is_admin")
 	AdminValue           string // claim value that indicates admin (default: "true")
+	GroupsClaim          string // claim listing group names to sync ("" = no sync)
 	DeviceAuthEndpoint   string // discovered from OIDC metadata
 	TokenEndpoint        string // discovered from OIDC metadata
 }
 
+// GroupSyncer applies the group names from a token to a user's memberships.
+type GroupSyncer interface {
+	SyncGroups(ctx context.Context, userID int, groupNames []string) error
+}
+
 // OIDCProvider validates OIDC tokens and auto-creates local users.
 type OIDCProvider struct {
 	verifier *oidc.IDTokenVerifier
 	config   OIDCConfig
 	auth     *Auth
+
+	groupSyncer GroupSyncer
+	syncMu      sync.Mutex
+	syncedFor   map[int]string // user ID -> groups last synced
 }
 
 // NewOIDCProvider creates an OIDC provider from config.
@@ -70,12 +83,19 @@ func NewOIDCProvider(ctx context.Context, cfg OIDCConfig, a *Auth) (*OIDCProvide
 		zap.String("token_endpoint", cfg.TokenEndpoint))
 
 	return &OIDCProvider{
-		verifier: verifier,
-		config:   cfg,
-		auth:     a,
+		verifier:  verifier,
+		config:    cfg,
+		auth:      a,
+		syncedFor: make(map[int]string),
 	}, nil
 }
 
+// SetGroupSyncer enables group membership sync from the configured groups
+// claim.
+func (o *OIDCProvider) SetGroupSyncer(gs GroupSyncer) {
+	o.groupSyncer = gs
+}
+
 // ValidateToken attempts to verify a token as an OIDC ID token.
 // If valid, ensures the user exists locally and returns local Claims.
 func (o *OIDCProvider) ValidateToken(ctx context.Context, tokenStr string) (*Claims, error) {
@@ -118,6 +138,11 @@ func (o *OIDCProvider) ValidateToken(ctx context.Context, tokenStr string) (*Cla
 		return nil, fmt.Errorf("ensure user: %w", err)
 	}
 
+	// Sync group memberships. A token without the claim leaves them alone.
+	if val, ok := rawClaims[o.config.GroupsClaim]; ok && o.config.GroupsClaim != "" && o.groupSyncer != nil {
+		o.syncGroups(ctx, userID, claimGroupNames(val))
+	}
+
 	return &Claims{
 		UserID:   userID,
 		Username: username,
@@ -129,10 +154,74 @@ func (o *OIDCProvider) ValidateToken(ctx context.Context, tokenStr string) (*Cla
 	}, nil
 }
 
+// syncGroups runs the group sync when the claimed groups differ from the
+// last sync for this user. Every request carries the ID token, so this keeps
+// the sync to once per login (or per change of groups).
+func (o *OIDCProvider) syncGroups(ctx context.Context, userID int, groups []string) {
+	key := strings.Join(groups, "\n")
+	o.syncMu.Lock()
+	last, seen := o.syncedFor[userID]
+	o.syncMu.Unlock()
+	if seen && last == key {
+		return
+	}
+
+	if err := o.groupSyncer.SyncGroups(ctx, userID, groups); err != nil {
+		logging.Warn("oidc group sync failed", zap.Int("user_id", userID), zap.Error(err))
+		return
+	}
+	o.syncMu.Lock()
+	o.syncedFor[userID] = key
+	o.syncMu.Unlock()
+}
+
+// claimGroupNames normalizes a groups claim: a list of strings or a single
+// comma-separated string. Keycloak full paths ("/org/team") map to their
+// last segment. The result is sorted and free of duplicates.
+func claimGroupNames(val interface{}) []string {
+	var raw []string
+	switch v := val.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	case []string:
+		raw = v
+	case string:
+		raw = strings.Split(v, ",")
+	}
+
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, name := range raw {
+		name = strings.TrimSpace(name)
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// oidcManagedPassword is stored as the password of users created through
+// OIDC. It is not a bcrypt hash, so no password matches it.
+const oidcManagedPassword = "oidc-managed"
+
 func (o *OIDCProvider) ensureUser(ctx context.Context, username string, isAdmin bool) (int, error) {
 	var userID int
+	var disabled bool
 	err := o.auth.db.QueryRowContext(ctx,
-		`SELECT id FROM users WHERE username = $1`, username).Scan(&userID)
+		`SELECT id, disabled FROM users WHERE username = $1`, username).Scan(&userID, &disabled)
+	if err == nil && disabled {
+		return 0, ErrUserDisabled
+	}
 	if err == nil {
 		return userID, nil
 	}
@@ -143,7 +232,7 @@ func (o *OIDCProvider) ensureUser(ctx context.Context, username string, isAdmin
 	// Auto-create user (random password since they authenticate via OIDC)
 	err = o.auth.db.QueryRowContext(ctx,
 		`INSERT INTO users (username, password, is_admin) VALUES ($1, $2, $3) RETURNING id`,
-		username, "oidc-managed", isAdmin).Scan(&userID)
+		username, oidcManagedPassword, isAdmin).Scan(&userID)
 	if err != nil {
 		return 0, fmt.Errorf("create oidc user: %w", err)
 	}
@@ -160,6 +249,11 @@ func (a *Auth) SetOIDCProvider(p *OIDCProvider) {
 // MiddlewareWithOIDC returns middleware that tries JWT first, then OIDC.
 func (a *Auth) MiddlewareWithOIDC(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := extractAPIKey(r); key != "" {
+			a.serveAPIKey(w, r, next, key)
+			return
+		}
+
 		tokenStr := extractToken(r)
 		if tokenStr == "" {
 			metrics.RecordAuthAttempt(false)
@@ -170,11 +264,14 @@ func (a *Auth) MiddlewareWithOIDC(next http.Handler) http.Handler {
 		// Try local JWT first
 		claims, err := a.validateToken(tokenStr)
 		if err == nil {
-			revoked, rerr := a.isTokenRevoked(r.Context(), tokenStr)
+			revoked, rerr := a.checkSession(r.Context(), tokenStr, claims)
 			if rerr != nil {
 				logging.Error("token revocation check failed", zap.Error(rerr))
 			}
 			if !revoked {
+				if blockedByPasswordChange(w, r, claims) {
+					return
+				}
 				ctx := context.WithValue(r.Context(), userContextKey, claims)
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClaimGroupNames(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want []string
+	}{
+		{"list", []interface{}{"staff", "devs"}, []string{"devs", "staff"}},
+		{"string slice", []string{"b", "a"}, []string{"a", "b"}},
+		{"comma string", "devs, staff,,", []string{"devs", "staff"}},
+		{"keycloak paths", []interface{}{"/org/devs", "/staff"}, []string{"devs", "staff"}},
+		{"duplicates", []interface{}{"devs", "/x/devs", " devs "}, []string{"devs"}},
+		{"non-strings skipped", []interface{}{"devs", 42, nil}, []string{"devs"}},
+		{"unsupported type", 7, []string{}},
+	}
+	for _, tc := range tests {
+		if got := claimGroupNames(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: claimGroupNames(%v) = %v, want %v", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
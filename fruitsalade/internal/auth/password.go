@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+var (
+	// ErrWeakPassword is returned when a password does not meet the policy.
+	ErrWeakPassword = errors.New("password does not meet the policy")
+
+	// ErrWrongPassword is returned when the current password does not match.
+	ErrWrongPassword = errors.New("current password is incorrect")
+
+	// ErrPasswordChangeRequired is returned by ValidateCredentials for users
+	// who must change their password first (through the API or web app).
+	ErrPasswordChangeRequired = errors.New("password change required")
+)
+
+// PasswordPolicy constrains passwords set through CreateUser and the
+// password change methods. The zero value accepts any non-empty password.
+type PasswordPolicy struct {
+	MinLength int
+
+	// Require three of: lowercase, uppercase, digits, other characters
+	RequireMixed bool
+
+	// Reject passwords equal to the username (case-insensitive)
+	RejectUsername bool
+}
+
+// Validate checks password against the policy.
+func (p PasswordPolicy) Validate(username, password string) error {
+	if password == "" {
+		return fmt.Errorf("%w: password is empty", ErrWeakPassword)
+	}
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if p.RejectUsername && strings.EqualFold(password, username) {
+		return fmt.Errorf("%w: must not be the username", ErrWeakPassword)
+	}
+	if p.RequireMixed && characterClasses(password) < 3 {
+		return fmt.Errorf("%w: must mix at least three of lowercase, uppercase, digits and symbols", ErrWeakPassword)
+	}
+	return nil
+}
+
+func characterClasses(s string) int {
+	var lower, upper, digit, other bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, b := range []bool{lower, upper, digit, other} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// SetPasswordPolicy sets the policy for new passwords.
+func (a *Auth) SetPasswordPolicy(p PasswordPolicy) {
+	a.policy = p
+}
+
+// ChangeOwnPassword changes a user's password after checking the current
+// one, and clears must_change_password. The new password must differ from
+// the current one.
+func (a *Auth) ChangeOwnPassword(ctx context.Context, userID int, current, newPassword string) error {
+	var username, hashed string
+	err := a.db.QueryRowContext(ctx,
+		`SELECT username, password FROM users WHERE id = $1`, userID).Scan(&username, &hashed)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashed), []byte(current)) != nil {
+		return ErrWrongPassword
+	}
+	if current == newPassword {
+		return fmt.Errorf("%w: must differ from the current password", ErrWeakPassword)
+	}
+	if err := a.policy.Validate(username, newPassword); err != nil {
+		return err
+	}
+	if err := a.setPassword(ctx, userID, newPassword, false); err != nil {
+		return err
+	}
+	logging.Info("password rotated", zap.Int("user_id", userID))
+	return nil
+}
+
+// ForcePasswordChange makes a user change their password before they can
+// use their sessions for anything else. OIDC users have no password to
+// change and count as not found.
+func (a *Auth) ForcePasswordChange(ctx context.Context, userID int) error {
+	res, err := a.db.ExecContext(ctx,
+		`UPDATE users SET must_change_password = TRUE WHERE id = $1 AND password <> $2`,
+		userID, oidcManagedPassword)
+	if err != nil {
+		return fmt.Errorf("force password change: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	logging.Info("password change forced", zap.Int("user_id", userID))
+	return nil
+}
+
+// ForcePasswordChangeAll forces a password change on every user with a
+// local password (OIDC users have none) and returns how many were marked.
+func (a *Auth) ForcePasswordChangeAll(ctx context.Context) (int64, error) {
+	res, err := a.db.ExecContext(ctx,
+		`UPDATE users SET must_change_password = TRUE WHERE password <> $1`, oidcManagedPassword)
+	if err != nil {
+		return 0, fmt.Errorf("force password change: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	logging.Info("password change forced for all users", zap.Int64("users", n))
+	return n, nil
+}
+
+func (a *Auth) setPassword(ctx context.Context, userID int, password string, mustChange bool) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	result, err := a.db.ExecContext(ctx,
+		`UPDATE users SET password = $1, must_change_password = $2 WHERE id = $3`,
+		string(hashed), mustChange, userID)
+	if err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// blockedByPasswordChange answers requests of a user who must change their
+// password with a 403 carrying DetailPasswordChangeRequired, and reports
+// whether it did.
+func blockedByPasswordChange(w http.ResponseWriter, r *http.Request, claims *Claims) bool {
+	if !claims.MustChangePassword || passwordChangeAllowed(r) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(protocol.ErrorResponse{
+		Error:     "password change required",
+		Code:      http.StatusForbidden,
+		ErrorCode: protocol.ErrCodePasswordChangeRequired,
+		Details:   protocol.DetailPasswordChangeRequired,
+		RequestID: w.Header().Get("X-Request-ID"),
+	})
+	return true
+}
+
+// passwordChangeAllowed reports whether a user who must change their
+// password may make this request: only the change itself and logout.
+func passwordChangeAllowed(r *http.Request) bool {
+	switch r.Method + " " + r.URL.Path {
+	case "POST /api/v1/auth/password", "DELETE /api/v1/auth/token":
+		return true
+	}
+	return false
+}
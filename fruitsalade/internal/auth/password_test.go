@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, RequireMixed: true, RejectUsername: true}
+	tests := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"alice", "Tr0ub4dor", true},
+		{"alice", "correct horse", false}, // lowercase and space only
+		{"alice", "correct Horse", true},
+		{"alice", "Sh0rt", false},
+		{"Alice1234", "alice1234", false}, // the username
+		{"alice", "", false},
+		{"alice", "ÄÖÜäöü12", true}, // length counts characters
+	}
+	for _, tt := range tests {
+		err := policy.Validate(tt.username, tt.password)
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(%q, %q) = %v, want ok=%v", tt.username, tt.password, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("Validate(%q, %q) error %v is not ErrWeakPassword", tt.username, tt.password, err)
+		}
+	}
+
+	if err := (PasswordPolicy{}).Validate("admin", "admin"); err != nil {
+		t.Errorf("zero policy rejected a password: %v", err)
+	}
+}
+
+func TestBlockedByPasswordChange(t *testing.T) {
+	tests := []struct {
+		method, path string
+		blocked      bool
+	}{
+		{"POST", "/api/v1/auth/password", false},
+		{"DELETE", "/api/v1/auth/token", false},
+		{"GET", "/api/v1/tree", true},
+		{"GET", "/api/v1/auth/password", true},
+		{"POST", "/api/v1/auth/refresh", true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := blockedByPasswordChange(w, r, &Claims{MustChangePassword: true}); got != tt.blocked {
+			t.Errorf("%s %s: blocked = %v, want %v", tt.method, tt.path, got, tt.blocked)
+		}
+		if tt.blocked && w.Code != 403 {
+			t.Errorf("%s %s: status %d, want 403", tt.method, tt.path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if blockedByPasswordChange(w, httptest.NewRequest("GET", "/api/v1/tree", nil), &Claims{}) {
+		t.Error("user without must_change_password was blocked")
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHKey is a public key a user can log in to the SFTP frontend with.
+type SSHKey struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	PublicKey   string     `json:"public_key"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ParseAuthorizedKey parses a single authorized_keys line. The key comment
+// is returned so it can serve as a default name.
+func ParseAuthorizedKey(line string) (ssh.PublicKey, string, error) {
+	key, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(line)))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return nil, "", fmt.Errorf("expected a single public key")
+	}
+	return key, comment, nil
+}
+
+// AddSSHKey registers a public key (authorized_keys format) for a user.
+func (a *Auth) AddSSHKey(ctx context.Context, userID int, name, authorizedKey string) (*SSHKey, error) {
+	key, comment, err := ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = comment
+	}
+
+	k := &SSHKey{
+		Name:        name,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}
+	err = a.db.QueryRowContext(ctx,
+		`INSERT INTO user_ssh_keys (user_id, name, public_key, fingerprint)
+		 VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		userID, k.Name, k.PublicKey, k.Fingerprint).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, fmt.Errorf("key is already registered")
+		}
+		return nil, fmt.Errorf("add ssh key: %w", err)
+	}
+	return k, nil
+}
+
+// ListSSHKeys returns a user's registered public keys.
+func (a *Auth) ListSSHKeys(ctx context.Context, userID int) ([]SSHKey, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id, name, public_key, fingerprint, created_at, last_used_at
+		 FROM user_ssh_keys WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list ssh keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []SSHKey
+	for rows.Next() {
+		var k SSHKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.PublicKey, &k.Fingerprint, &k.CreatedAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("scan ssh key: %w", err)
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteSSHKey removes a public key (must belong to userID).
+func (a *Auth) DeleteSSHKey(ctx context.Context, userID, keyID int) error {
+	result, err := a.db.ExecContext(ctx,
+		`DELETE FROM user_ssh_keys WHERE id = $1 AND user_id = $2`, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("delete ssh key: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("ssh key not found")
+	}
+	return nil
+}
+
+// ValidatePublicKey checks that key is registered to username and returns
+// the user's claims. Used by the SFTP frontend.
+func (a *Auth) ValidatePublicKey(ctx context.Context, username string, key ssh.PublicKey) (*Claims, error) {
+	var keyID, userID int
+	var isAdmin bool
+	err := a.db.QueryRowContext(ctx,
+		`SELECT k.id, u.id, u.is_admin FROM user_ssh_keys k
+		 JOIN users u ON u.id = k.user_id
+		 WHERE k.fingerprint = $1 AND u.username = $2 AND u.disabled = FALSE AND u.must_change_password = FALSE`,
+		ssh.FingerprintSHA256(key), username).Scan(&keyID, &userID, &isAdmin)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown public key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	a.db.ExecContext(ctx, `UPDATE user_ssh_keys SET last_used_at = NOW() WHERE id = $1`, keyID)
+
+	return &Claims{
+		UserID:   userID,
+		Username: username,
+		IsAdmin:  isAdmin,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+var (
+	// ErrDeletionOption is returned by DeleteUser unless exactly one of
+	// transfer_to and a valid orphan_action is given.
+	ErrDeletionOption = errors.New("either transfer_to or orphan_action (trash or keep-public) is required")
+
+	// ErrTransferTarget is returned by DeleteUser when the user to
+	// transfer to is the deleted one, does not exist or is disabled.
+	ErrTransferTarget = errors.New("transfer_to must be another active user")
+)
+
+// UserDeletion says what becomes of a deleted user's data; see
+// protocol.UserDeleteRequest. ActorID is the admin deleting the user: the
+// files trashed with protocol.OrphanTrash are attributed to them, as is
+// what the user had deleted before.
+type UserDeletion struct {
+	TransferTo   int
+	OrphanAction string
+	ActorID      int
+}
+
+func (d UserDeletion) validate(userID int) error {
+	switch {
+	case d.TransferTo != 0 && d.OrphanAction != "":
+		return ErrDeletionOption
+	case d.TransferTo != 0:
+		if d.TransferTo == userID {
+			return ErrTransferTarget
+		}
+		return nil
+	case d.OrphanAction == protocol.OrphanTrash, d.OrphanAction == protocol.OrphanKeepPublic:
+		return nil
+	default:
+		return ErrDeletionOption
+	}
+}
+
+// UserFootprint reports what deleting a user affects.
+func (a *Auth) UserFootprint(ctx context.Context, userID int) (*protocol.UserFootprintResponse, error) {
+	fp := &protocol.UserFootprintResponse{UserID: userID, GroupAdmin: []string{}}
+	err := a.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1`, userID).Scan(&fp.Username)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	err = a.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE deleted_at IS NULL),
+		        COALESCE(SUM(size) FILTER (WHERE deleted_at IS NULL), 0),
+		        COUNT(*) FILTER (WHERE deleted_at IS NULL AND visibility = 'private'),
+		        COALESCE(SUM(size) FILTER (WHERE deleted_at IS NULL AND visibility = 'private'), 0),
+		        COUNT(*) FILTER (WHERE deleted_at IS NOT NULL)
+		 FROM files WHERE is_dir = FALSE AND (owner_id = $1 OR (deleted_at IS NOT NULL AND deleted_by = $1))`, userID).
+		Scan(&fp.Files, &fp.Bytes, &fp.PrivateFiles, &fp.PrivateBytes, &fp.TrashedFiles)
+	if err != nil {
+		return nil, fmt.Errorf("count files: %w", err)
+	}
+
+	err = a.db.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM share_links WHERE created_by = $1 AND is_active),
+		        (SELECT COUNT(*) FROM user_favorites WHERE user_id = $1),
+		        (SELECT COUNT(*) FROM user_albums WHERE user_id = $1)`, userID).
+		Scan(&fp.ShareLinks, &fp.Favorites, &fp.Albums)
+	if err != nil {
+		return nil, fmt.Errorf("count links: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT g.name FROM group_members m JOIN groups g ON g.id = m.group_id
+		 WHERE m.user_id = $1 AND m.role = 'admin' ORDER BY g.name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list group roles: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan group: %w", err)
+		}
+		fp.GroupAdmin = append(fp.GroupAdmin, name)
+	}
+	return fp, rows.Err()
+}
+
+// DeleteUser deletes a user by ID, handing their data over or leaving it
+// without an owner as del says, all in one transaction. The last active
+// admin cannot be deleted.
+func (a *Auth) DeleteUser(ctx context.Context, userID int, del UserDeletion) (*protocol.UserDeleteResponse, error) {
+	if err := del.validate(userID); err != nil {
+		return nil, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	admins, err := lockActiveAdmins(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if isLastAdmin(admins, userID) {
+		return nil, ErrLastAdmin
+	}
+	var username string
+	err = tx.QueryRowContext(ctx, `SELECT username FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	resp := &protocol.UserDeleteResponse{UserID: userID, TransferTo: del.TransferTo, OrphanAction: del.OrphanAction}
+	if del.TransferTo != 0 {
+		var disabled bool
+		err := tx.QueryRowContext(ctx, `SELECT disabled FROM users WHERE id = $1 FOR UPDATE`, del.TransferTo).Scan(&disabled)
+		if err == sql.ErrNoRows || disabled {
+			return nil, ErrTransferTarget
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get transfer target: %w", err)
+		}
+		if resp.Files, err = transferUserData(ctx, tx, userID, del.TransferTo, username); err != nil {
+			return nil, err
+		}
+	} else {
+		if resp.Trashed, resp.Orphaned, err = orphanUserFiles(ctx, tx, userID, del.ActorID, del.OrphanAction); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("delete user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	resp.Deleted = true
+
+	logging.Info("user deleted",
+		zap.Int("user_id", userID),
+		zap.Int("transfer_to", del.TransferTo),
+		zap.String("orphan_action", del.OrphanAction),
+		zap.Int("files", resp.Files),
+		zap.Int("trashed", resp.Trashed),
+		zap.Int("orphaned", resp.Orphaned))
+	return resp, nil
+}
+
+// transferUserData gives the user's files, share links, favorites and
+// albums to target, and returns the number of files. An album whose name
+// target already uses gets the old owner's name appended. Group roles are
+// not handed over; they end with the user.
+func transferUserData(ctx context.Context, tx *sql.Tx, userID, target int, username string) (int, error) {
+	var files int
+	if err := tx.QueryRowContext(ctx,
+		`WITH moved AS (UPDATE files SET owner_id = $2 WHERE owner_id = $1 RETURNING is_dir, deleted_at)
+		 SELECT COUNT(*) FROM moved WHERE NOT is_dir AND deleted_at IS NULL`, userID, target).Scan(&files); err != nil {
+		return 0, fmt.Errorf("transfer files: %w", err)
+	}
+
+	steps := []struct{ what, query string }{
+		{"trash", `UPDATE files SET deleted_by = $2 WHERE deleted_by = $1`},
+		{"share links", `UPDATE share_links SET created_by = $2 WHERE created_by = $1`},
+		{"favorites", `INSERT INTO user_favorites (user_id, file_path, created_at)
+		               SELECT $2, file_path, created_at FROM user_favorites WHERE user_id = $1
+		               ON CONFLICT (user_id, file_path) DO NOTHING`},
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, userID, target); err != nil {
+			return 0, fmt.Errorf("transfer %s: %w", step.what, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE user_albums a SET user_id = $2, updated_at = NOW(),
+		        name = CASE WHEN EXISTS (SELECT 1 FROM user_albums b WHERE b.user_id = $2 AND b.name = a.name)
+		                    THEN a.name || ' (' || $3::text || ')' ELSE a.name END
+		 WHERE user_id = $1`, userID, target, username); err != nil {
+		return 0, fmt.Errorf("transfer albums: %w", err)
+	}
+	return files, nil
+}
+
+// orphanUserFiles leaves the user's files without an owner. With
+// protocol.OrphanTrash their private files, and everything below the
+// private directories, go to the trash first, owned by and attributed to
+// actorID; with protocol.OrphanKeepPublic the private files become
+// public. It returns the number of files trashed and orphaned.
+func orphanUserFiles(ctx context.Context, tx *sql.Tx, userID, actorID int, action string) (trashed, orphaned int, err error) {
+	if action == protocol.OrphanTrash {
+		if err := tx.QueryRowContext(ctx,
+			`WITH trashed AS (
+			     UPDATE files f SET deleted_at = NOW(), deleted_by = $2, original_path = f.path,
+			            owner_id = CASE WHEN f.owner_id = $1 THEN $2 ELSE f.owner_id END
+			     WHERE f.deleted_at IS NULL AND EXISTS (
+			         SELECT 1 FROM files p
+			         WHERE p.owner_id = $1 AND p.visibility = 'private' AND p.deleted_at IS NULL
+			           AND (f.path = p.path OR starts_with(f.path, p.path || '/')))
+			     RETURNING is_dir)
+			 SELECT COUNT(*) FROM trashed WHERE NOT is_dir`, userID, actorID).Scan(&trashed); err != nil {
+			return 0, 0, fmt.Errorf("trash private files: %w", err)
+		}
+	}
+
+	visibility := "visibility"
+	if action == protocol.OrphanKeepPublic {
+		visibility = "CASE WHEN visibility = 'private' THEN 'public' ELSE visibility END"
+	}
+	if err := tx.QueryRowContext(ctx,
+		`WITH orphaned AS (
+		     UPDATE files SET owner_id = NULL, visibility = `+visibility+`
+		     WHERE owner_id = $1 RETURNING is_dir, deleted_at)
+		 SELECT COUNT(*) FROM orphaned WHERE NOT is_dir AND deleted_at IS NULL`, userID).Scan(&orphaned); err != nil {
+		return 0, 0, fmt.Errorf("orphan files: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE files SET deleted_by = $2 WHERE deleted_by = $1`, userID, actorID); err != nil {
+		return 0, 0, fmt.Errorf("reattribute trash: %w", err)
+	}
+	return trashed, orphaned, nil
+}
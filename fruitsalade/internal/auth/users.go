@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+)
+
+var (
+	// ErrUserNotFound is returned for operations on a user that does not exist.
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrInvalidCredentials is returned by ValidateCredentials for an
+	// unknown user or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrUserDisabled is returned when a disabled user tries to authenticate.
+	ErrUserDisabled = errors.New("account disabled")
+
+	// ErrLastAdmin is returned when a change would leave no active admin.
+	ErrLastAdmin = errors.New("cannot remove the last active admin")
+
+	// ErrInvalidEmail is returned by UpdateUser for a malformed address.
+	ErrInvalidEmail = errors.New("invalid email address")
+)
+
+// UserUpdate is a partial update of a user account; nil fields are kept.
+type UserUpdate struct {
+	IsAdmin     *bool   `json:"is_admin"`
+	Disabled    *bool   `json:"disabled"`
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+}
+
+// GetUser returns a user by ID (without group count and storage used).
+func (a *Auth) GetUser(ctx context.Context, userID int) (*User, error) {
+	var u User
+	err := a.db.QueryRowContext(ctx,
+		`SELECT id, username, is_admin, disabled, email, display_name, created_at
+		 FROM users WHERE id = $1`, userID).
+		Scan(&u.ID, &u.Username, &u.IsAdmin, &u.Disabled, &u.Email, &u.DisplayName, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	return &u, nil
+}
+
+// UpdateUser applies a partial update to a user. Disabling a user revokes
+// all their sessions; the auth middleware rejects their tokens from then
+// on anyway. Demoting or disabling the last active admin fails with
+// ErrLastAdmin. An email address must be valid or empty.
+func (a *Auth) UpdateUser(ctx context.Context, userID int, upd UserUpdate) (*User, error) {
+	if upd.Email != nil {
+		email := strings.TrimSpace(*upd.Email)
+		if email != "" {
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidEmail, email)
+			}
+		}
+		upd.Email = &email
+	}
+	if upd.DisplayName != nil {
+		name := strings.TrimSpace(*upd.DisplayName)
+		upd.DisplayName = &name
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the admins before the user, in the same order as DeleteUser
+	admins, err := lockActiveAdmins(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, username, is_admin, disabled, email, display_name, created_at
+		 FROM users WHERE id = $1 FOR UPDATE`, userID).
+		Scan(&u.ID, &u.Username, &u.IsAdmin, &u.Disabled, &u.Email, &u.DisplayName, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	wasDisabled := u.Disabled
+
+	if upd.IsAdmin != nil {
+		u.IsAdmin = *upd.IsAdmin
+	}
+	if upd.Disabled != nil {
+		u.Disabled = *upd.Disabled
+	}
+	if upd.Email != nil {
+		u.Email = *upd.Email
+	}
+	if upd.DisplayName != nil {
+		u.DisplayName = *upd.DisplayName
+	}
+
+	if (!u.IsAdmin || u.Disabled) && isLastAdmin(admins, userID) {
+		return nil, ErrLastAdmin
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET is_admin = $2, disabled = $3, email = $4, display_name = $5 WHERE id = $1`,
+		userID, u.IsAdmin, u.Disabled, u.Email, u.DisplayName); err != nil {
+		return nil, fmt.Errorf("update user: %w", err)
+	}
+	if u.Disabled && !wasDisabled {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE device_tokens SET revoked = TRUE WHERE user_id = $1 AND revoked = FALSE`, userID); err != nil {
+			return nil, fmt.Errorf("revoke sessions: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	a.updateActiveTokenCount(ctx)
+	logging.Info("user updated",
+		zap.Int("user_id", userID),
+		zap.Bool("is_admin", u.IsAdmin),
+		zap.Bool("disabled", u.Disabled))
+	return &u, nil
+}
+
+// lockActiveAdmins returns the IDs of the active admins and locks their
+// rows until tx ends, so that concurrent demotions cannot both pass the
+// last-admin check.
+func lockActiveAdmins(ctx context.Context, tx *sql.Tx) ([]int, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id FROM users WHERE is_admin = TRUE AND disabled = FALSE ORDER BY id FOR UPDATE`)
+	if err != nil {
+		return nil, fmt.Errorf("list admins: %w", err)
+	}
+	defer rows.Close()
+
+	var admins []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan admin: %w", err)
+		}
+		admins = append(admins, id)
+	}
+	return admins, rows.Err()
+}
+
+// isLastAdmin reports whether userID is the only one of admins.
+func isLastAdmin(admins []int, userID int) bool {
+	return len(admins) == 1 && admins[0] == userID
+}
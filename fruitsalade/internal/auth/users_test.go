@@ -0,0 +1,41 @@
+package auth
+
+import "testing"
+
+func TestIsLastAdmin(t *testing.T) {
+	tests := []struct {
+		admins []int
+		userID int
+		want   bool
+	}{
+		{[]int{1}, 1, true},
+		{[]int{1}, 2, false},
+		{[]int{1, 2}, 1, false},
+		{nil, 1, false},
+	}
+	for _, tt := range tests {
+		if got := isLastAdmin(tt.admins, tt.userID); got != tt.want {
+			t.Errorf("isLastAdmin(%v, %d) = %v, want %v", tt.admins, tt.userID, got, tt.want)
+		}
+	}
+}
+
+func TestUserDeletionValidate(t *testing.T) {
+	tests := []struct {
+		del  UserDeletion
+		want error
+	}{
+		{UserDeletion{TransferTo: 2}, nil},
+		{UserDeletion{OrphanAction: "trash"}, nil},
+		{UserDeletion{OrphanAction: "keep-public"}, nil},
+		{UserDeletion{}, ErrDeletionOption},
+		{UserDeletion{OrphanAction: "shred"}, ErrDeletionOption},
+		{UserDeletion{TransferTo: 2, OrphanAction: "trash"}, ErrDeletionOption},
+		{UserDeletion{TransferTo: 1}, ErrTransferTarget},
+	}
+	for _, tt := range tests {
+		if got := tt.del.validate(1); got != tt.want {
+			t.Errorf("%+v.validate(1) = %v, want %v", tt.del, got, tt.want)
+		}
+	}
+}
@@ -4,7 +4,13 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+	"slices"
 	"strconv"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/cors"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
 )
 
 // Config holds all server configuration.
@@ -13,6 +19,13 @@ type Config struct {
 	ListenAddr  string
 	MetricsAddr string
 
+	// How long in-flight requests may run on SIGTERM before they are aborted
+	ShutdownTimeout time.Duration
+
+	// How often the metadata tree is rebuilt from the database as a backstop
+	// for the incremental updates made on every write (0 disables)
+	TreeRebuildInterval time.Duration
+
 	// Logging
 	LogLevel  string
 	LogFormat string
@@ -35,6 +48,23 @@ type Config struct {
 	// Auth
 	JWTSecret string
 
+	// Lifetime of admin impersonation tokens, at most 15 minutes
+	ImpersonationTTL time.Duration
+
+	// Password policy for local accounts
+	PasswordMinLength      int
+	PasswordRequireMixed   bool
+	PasswordRejectUsername bool
+
+	// Login lockout: failures per username and per IP before a lockout
+	// (0 disables), its first and longest duration, and the proxies whose
+	// X-Forwarded-For is believed (comma-separated IPs/CIDRs)
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginLockout          time.Duration
+	LoginLockoutMax       time.Duration
+	TrustedProxies        string
+
 	// OIDC (optional)
 	OIDCIssuerURL    string
 	OIDCClientID     string
@@ -42,6 +72,14 @@ type Config struct {
 	OIDCAdminClaim   string
 	OIDCAdminValue   string
 
+	// OIDC group sync ("" claim = disabled)
+	OIDCGroupsClaim      string
+	OIDCAutoCreateGroups bool
+	OIDCGroupRole        string
+
+	// Re-provision every group's folders and member homes at startup
+	ProvisionRepair bool
+
 	// Storage backend ("local" or "s3", default: "local")
 	StorageBackend   string
 	LocalStoragePath string
@@ -49,10 +87,109 @@ type Config struct {
 	// Uploads
 	MaxUploadSize int64
 
+	// Paths of new files and directories: at most MaxPathLength bytes and
+	// MaxPathDepth segments
+	MaxPathLength int
+	MaxPathDepth  int
+
 	// Quotas (defaults for new users)
 	DefaultMaxStorage    int64
 	DefaultMaxBandwidth  int64
 	DefaultRequestsPerMin int
+
+	// Whether old versions and thumbnails count toward storage quotas
+	QuotaIncludeDerived bool
+
+	// Comma-separated path prefixes whose downloads are not limited by the
+	// daily bandwidth quota (still tracked)
+	BandwidthExemptPaths string
+
+	// Trashed files are purged after this many days (0 = never); users
+	// can have their own retention (user_quotas)
+	TrashRetentionDays int
+
+	// Users whose trash holds more than this many bytes are warned (0 = never)
+	TrashWarnSize int64
+
+	// Share links: the longest a link may stay valid and the lifetime of
+	// links created without an expiry (0 = unset; links may then never
+	// expire)
+	ShareMaxLifetime     time.Duration
+	ShareDefaultLifetime time.Duration
+
+	// Version retention (0 = unlimited; per-path overrides live in the DB)
+	VersionKeepCount  int
+	VersionMaxAgeDays int
+
+	// Daily usage snapshots older than this are pruned
+	UsageHistoryDays int
+
+	// Store identical uploads once per storage location (content-addressed)
+	DedupEnabled bool
+
+	// Master keys for storage locations with an "encryption_key_id":
+	// "id:base64key" entries, inline and/or one per line in a file
+	EncryptionKeys     string
+	EncryptionKeysFile string
+
+	// Integrity scrubber: full runs every ScrubInterval (0 = manual only),
+	// reading at most ScrubMaxBytesPerSec (0 = unlimited); with
+	// ScrubAutoRepair, damaged files are restored from a matching version
+	ScrubInterval       time.Duration
+	ScrubMaxBytesPerSec int64
+	ScrubAutoRepair     bool
+
+	// Content search: files larger than this are not text-indexed (0 = no limit)
+	ContentIndexMaxSize int64
+
+	// Content downloads of these comma-separated MIME types ("text/*"
+	// matches a whole family) are compressed for clients that accept gzip
+	// or zstd ("" = never)
+	ContentCompressTypes string
+
+	// Clients older than this get 426 Upgrade Required ("" = no minimum)
+	MinClientVersion string
+
+	// CORS for /api/v1/: comma-separated origins allowed to call the API
+	// from a browser ("" = none), each exact, "*" or with a "*." subdomain
+	// wildcard such as "https://*.example.com"
+	CORSAllowedOrigins   string
+	CORSAllowedHeaders   string // comma-separated request headers
+	CORSExposedHeaders   string // comma-separated response headers
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration // how long browsers cache a preflight
+
+	// Gallery: max Hamming distance between perceptual hashes for two
+	// images to count as duplicates (0 = identical hashes only)
+	GalleryDuplicateDistance int
+
+	// Gallery processing: image workers (videos always get one), and how
+	// often a failing file is tried before it is dead-lettered
+	GalleryWorkers     int
+	GalleryMaxAttempts int
+
+	// Gallery: photos of one camera taken within this time of each other
+	// form a burst stack (0 = no stacks)
+	GalleryStackWindow time.Duration
+
+	// Gallery: the directory command plugins (exec:) must live in; empty
+	// allows none, so admins can only add webhook plugins
+	GalleryPluginDir string
+
+	// Outbound webhooks: concurrent deliveries, deliveries waiting for a
+	// worker, attempts before a delivery is dead-lettered, and the timeout
+	// of one attempt
+	WebhookWorkers     int
+	WebhookQueueSize   int
+	WebhookMaxAttempts int
+	WebhookTimeout     time.Duration
+
+	// Outbound webhooks: allow loopback, link-local and private addresses
+	WebhookAllowInternal bool
+
+	// SFTP frontend ("" = disabled)
+	SFTPListenAddr  string
+	SFTPHostKeyFile string
 }
 
 // Load reads configuration from environment variables with defaults.
@@ -60,6 +197,8 @@ func Load() (*Config, error) {
 	cfg := &Config{
 		ListenAddr:    envOr("LISTEN_ADDR", ":8080"),
 		MetricsAddr:   envOr("METRICS_ADDR", ":9090"),
+		ShutdownTimeout: envDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		TreeRebuildInterval: envDuration("TREE_REBUILD_INTERVAL", 5*time.Minute),
 		LogLevel:      envOr("LOG_LEVEL", "info"),
 		LogFormat:     envOr("LOG_FORMAT", "json"),
 		DatabaseURL:   envOr("DATABASE_URL", ""),
@@ -72,17 +211,67 @@ func Load() (*Config, error) {
 		TLSCertFile:   envOr("TLS_CERT_FILE", ""),
 		TLSKeyFile:    envOr("TLS_KEY_FILE", ""),
 		JWTSecret:     envOr("JWT_SECRET", ""),
+		PasswordMinLength:      envInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireMixed:   envBool("PASSWORD_REQUIRE_MIXED", false),
+		PasswordRejectUsername: envBool("PASSWORD_REJECT_USERNAME", true),
+		LoginMaxFailures:       envInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP:  envInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		LoginLockout:           envDuration("LOGIN_LOCKOUT", time.Minute),
+		LoginLockoutMax:        envDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+		ImpersonationTTL:       envDuration("IMPERSONATION_TTL", 15*time.Minute),
+		TrustedProxies:         envOr("TRUSTED_PROXIES", ""),
 		OIDCIssuerURL:    envOr("OIDC_ISSUER_URL", ""),
 		OIDCClientID:     envOr("OIDC_CLIENT_ID", ""),
 		OIDCClientSecret: envOr("OIDC_CLIENT_SECRET", ""),
 		OIDCAdminClaim:   envOr("OIDC_ADMIN_CLAIM", "is_admin"),
 		OIDCAdminValue:   envOr("OIDC_ADMIN_VALUE", "true"),
+		OIDCGroupsClaim:      envOr("OIDC_GROUPS_CLAIM", ""),
+		OIDCAutoCreateGroups: envBool("OIDC_AUTOCREATE_GROUPS", false),
+		OIDCGroupRole:        envOr("OIDC_GROUP_ROLE", "viewer"),
+		ProvisionRepair:      envBool("PROVISION_REPAIR", false),
 		StorageBackend:       envOr("STORAGE_BACKEND", "local"),
 		LocalStoragePath:     envOr("LOCAL_STORAGE_PATH", "/data/storage"),
 		MaxUploadSize:        envInt64("MAX_UPLOAD_SIZE", 100*1024*1024), // 100MB default
+		MaxPathLength:        envInt("MAX_PATH_LENGTH", 4096),
+		MaxPathDepth:         envInt("MAX_PATH_DEPTH", 128),
 		DefaultMaxStorage:    envInt64("DEFAULT_MAX_STORAGE", 0),        // 0 = unlimited
 		DefaultMaxBandwidth:  envInt64("DEFAULT_MAX_BANDWIDTH", 0),      // 0 = unlimited
 		DefaultRequestsPerMin: envInt("DEFAULT_REQUESTS_PER_MINUTE", 0), // 0 = unlimited
+		QuotaIncludeDerived:   envBool("QUOTA_INCLUDE_DERIVED", true),
+		BandwidthExemptPaths:  envOr("BANDWIDTH_EXEMPT_PATHS", ""),
+		TrashRetentionDays:    envInt("TRASH_RETENTION_DAYS", 30),
+		TrashWarnSize:         envInt64("TRASH_WARN_SIZE", 0),
+		ShareMaxLifetime:      envDuration("SHARE_MAX_LIFETIME", 0),
+		ShareDefaultLifetime:  envDuration("SHARE_DEFAULT_LIFETIME", 0),
+		VersionKeepCount:      envInt("VERSION_KEEP_COUNT", 0),          // 0 = keep all
+		VersionMaxAgeDays:     envInt("VERSION_MAX_AGE_DAYS", 0),        // 0 = no age limit
+		UsageHistoryDays:      envInt("USAGE_HISTORY_DAYS", 730),
+		DedupEnabled:          envBool("DEDUP_ENABLED", true),
+		EncryptionKeys:        envOr("ENCRYPTION_KEYS", ""),
+		EncryptionKeysFile:    envOr("ENCRYPTION_KEYS_FILE", ""),
+		ScrubInterval:         envDuration("SCRUB_INTERVAL", 7*24*time.Hour),
+		ScrubMaxBytesPerSec:   envInt64("SCRUB_MAX_BYTES_PER_SEC", 10*1024*1024), // 10MB/s default
+		ScrubAutoRepair:       envBool("SCRUB_AUTO_REPAIR", false),
+		ContentIndexMaxSize:   envInt64("CONTENT_INDEX_MAX_SIZE", 20*1024*1024), // 20MB default
+		ContentCompressTypes:  envOr("CONTENT_COMPRESS_TYPES", "text/*,application/json,image/svg+xml"),
+		MinClientVersion:      envOr("MIN_CLIENT_VERSION", ""),
+		CORSAllowedOrigins:    envOr("CORS_ALLOWED_ORIGINS", ""),
+		CORSAllowedHeaders:    envOr("CORS_ALLOWED_HEADERS", "Authorization, Content-Type, X-API-Key, X-Expected-Version, If-Match, If-None-Match, Range, X-Request-ID"),
+		CORSExposedHeaders:    envOr("CORS_EXPOSED_HEADERS", "ETag, X-Version, Content-Range, Content-Length, Retry-After, X-Request-ID"),
+		CORSAllowCredentials:  envBool("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:            envDuration("CORS_MAX_AGE", 10*time.Minute),
+		GalleryDuplicateDistance: envInt("GALLERY_DUPLICATE_DISTANCE", 4),
+		GalleryWorkers:           envInt("GALLERY_WORKERS", 2),
+		GalleryMaxAttempts:       envInt("GALLERY_MAX_ATTEMPTS", 5),
+		GalleryStackWindow:       envDuration("GALLERY_STACK_WINDOW", 3*time.Second),
+		GalleryPluginDir:         envOr("GALLERY_PLUGIN_DIR", ""),
+		WebhookWorkers:           envInt("WEBHOOK_WORKERS", 4),
+		WebhookQueueSize:         envInt("WEBHOOK_QUEUE_SIZE", 1000),
+		WebhookMaxAttempts:       envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookTimeout:           envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookAllowInternal:     envBool("WEBHOOK_ALLOW_INTERNAL", false),
+		SFTPListenAddr:           envOr("SFTP_LISTEN_ADDR", ""),
+		SFTPHostKeyFile:          envOr("SFTP_HOST_KEY_FILE", "/data/sftp_host_ed25519_key"),
 	}
 
 	if cfg.DatabaseURL == "" {
@@ -91,6 +280,72 @@ func Load() (*Config, error) {
 	if cfg.JWTSecret == "" {
 		return nil, fmt.Errorf("JWT_SECRET is required")
 	}
+	if cfg.MinClientVersion != "" && !version.IsRelease(cfg.MinClientVersion) {
+		return nil, fmt.Errorf("MIN_CLIENT_VERSION %q is not a valid version (expected e.g. 1.4.0)", cfg.MinClientVersion)
+	}
+	switch cfg.OIDCGroupRole {
+	case "admin", "editor", "viewer":
+	default:
+		return nil, fmt.Errorf("OIDC_GROUP_ROLE must be 'admin', 'editor', or 'viewer'")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("SHUTDOWN_TIMEOUT must not be negative")
+	}
+	if cfg.TreeRebuildInterval < 0 {
+		return nil, fmt.Errorf("TREE_REBUILD_INTERVAL must not be negative")
+	}
+	if cfg.CORSMaxAge < 0 {
+		return nil, fmt.Errorf("CORS_MAX_AGE must not be negative")
+	}
+	if cfg.CORSAllowCredentials && slices.Contains(cors.ParseList(cfg.CORSAllowedOrigins), "*") {
+		// Any site could then make requests with the user's credentials
+		return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS must list the origins, not *, when CORS_ALLOW_CREDENTIALS is true")
+	}
+	if cfg.ScrubInterval < 0 || cfg.ScrubMaxBytesPerSec < 0 {
+		return nil, fmt.Errorf("SCRUB_INTERVAL and SCRUB_MAX_BYTES_PER_SEC must not be negative")
+	}
+	if cfg.LoginMaxFailures < 0 || cfg.LoginMaxFailuresPerIP < 0 {
+		return nil, fmt.Errorf("LOGIN_MAX_FAILURES and LOGIN_MAX_FAILURES_PER_IP must not be negative")
+	}
+	if cfg.LoginLockout <= 0 || cfg.LoginLockoutMax < cfg.LoginLockout {
+		return nil, fmt.Errorf("LOGIN_LOCKOUT must be positive and at most LOGIN_LOCKOUT_MAX")
+	}
+	if cfg.ImpersonationTTL <= 0 {
+		return nil, fmt.Errorf("IMPERSONATION_TTL must be positive")
+	}
+	if cfg.MaxPathLength < 1 || cfg.MaxPathDepth < 1 {
+		return nil, fmt.Errorf("MAX_PATH_LENGTH and MAX_PATH_DEPTH must be at least 1")
+	}
+	if cfg.PasswordMinLength < 1 {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+	if cfg.GalleryDuplicateDistance < 0 || cfg.GalleryDuplicateDistance > 16 {
+		return nil, fmt.Errorf("GALLERY_DUPLICATE_DISTANCE must be between 0 and 16")
+	}
+	if cfg.GalleryStackWindow < 0 {
+		return nil, fmt.Errorf("GALLERY_STACK_WINDOW must not be negative")
+	}
+	if cfg.GalleryWorkers < 1 || cfg.GalleryMaxAttempts < 1 {
+		return nil, fmt.Errorf("GALLERY_WORKERS and GALLERY_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.GalleryPluginDir != "" && !path.IsAbs(cfg.GalleryPluginDir) {
+		return nil, fmt.Errorf("GALLERY_PLUGIN_DIR must be an absolute path")
+	}
+	if cfg.WebhookWorkers < 1 || cfg.WebhookQueueSize < 1 || cfg.WebhookMaxAttempts < 1 {
+		return nil, fmt.Errorf("WEBHOOK_WORKERS, WEBHOOK_QUEUE_SIZE and WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.WebhookTimeout <= 0 {
+		return nil, fmt.Errorf("WEBHOOK_TIMEOUT must be positive")
+	}
+	if cfg.ShareMaxLifetime < 0 || cfg.ShareDefaultLifetime < 0 {
+		return nil, fmt.Errorf("SHARE_MAX_LIFETIME and SHARE_DEFAULT_LIFETIME must not be negative")
+	}
+	if cfg.ShareMaxLifetime > 0 && cfg.ShareDefaultLifetime > cfg.ShareMaxLifetime {
+		return nil, fmt.Errorf("SHARE_DEFAULT_LIFETIME must be at most SHARE_MAX_LIFETIME")
+	}
+	if cfg.MaxUploadSize < 0 || cfg.TrashRetentionDays < 0 || cfg.TrashWarnSize < 0 || cfg.VersionKeepCount < 0 || cfg.VersionMaxAgeDays < 0 {
+		return nil, fmt.Errorf("MAX_UPLOAD_SIZE, TRASH_RETENTION_DAYS, TRASH_WARN_SIZE, VERSION_KEEP_COUNT and VERSION_MAX_AGE_DAYS must not be negative")
+	}
 
 	return cfg, nil
 }
@@ -137,3 +392,20 @@ func envInt64(key string, fallback int64) int64 {
 	}
 	return i
 }
+
+// envDuration accepts a Go duration ("45s", "2m") or a plain number of
+// seconds.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadCORSCredentials(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/fruitsalade")
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, *")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CORS_ALLOWED_ORIGINS") {
+		t.Errorf("credentials with any origin: err = %v", err)
+	}
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, https://*.example.com")
+	if _, err := Load(); err != nil {
+		t.Errorf("credentials with listed origins: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
+)
+
+// Settings are the settings an admin can change while the server runs
+// (PUT /api/v1/admin/config). They start from the environment, admin
+// changes are saved in the database, and consumers read them at use time
+// from a Live or subscribe to changes, so no restart is needed.
+type Settings struct {
+	LogLevel                 string `json:"log_level"`
+	MaxUploadSize            int64  `json:"max_upload_size"`
+	DefaultMaxStorage        int64  `json:"default_max_storage"`
+	DefaultMaxBandwidth      int64  `json:"default_max_bandwidth"`
+	DefaultRequestsPerMin    int    `json:"default_requests_per_min"`
+	TrashRetentionDays       int    `json:"trash_retention_days"`
+	TrashWarnSize            int64  `json:"trash_warn_size"`
+	VersionKeepCount         int    `json:"version_keep_count"`
+	VersionMaxAgeDays        int    `json:"version_max_age_days"`
+	MinClientVersion         string `json:"min_client_version"`
+	GalleryDuplicateDistance int    `json:"gallery_duplicate_distance"`
+	ShareMaxLifetimeSec      int64  `json:"share_max_lifetime_sec"`
+	ShareDefaultLifetimeSec  int64  `json:"share_default_lifetime_sec"`
+}
+
+// RestartRequired lists the settings shown by GET /api/v1/admin/config
+// that only take effect on a restart, as environment variables.
+var RestartRequired = []string{
+	"listen_addr", "metrics_addr",
+	"s3_endpoint", "s3_bucket", "s3_region", "s3_use_ssl",
+	"jwt_configured", "oidc_issuer",
+	"tls_enabled", "tls_cert_file",
+}
+
+// Settings returns the settings as configured by the environment.
+func (c *Config) Settings() Settings {
+	s := Settings{
+		LogLevel:                 strings.ToLower(c.LogLevel),
+		MaxUploadSize:            c.MaxUploadSize,
+		DefaultMaxStorage:        c.DefaultMaxStorage,
+		DefaultMaxBandwidth:      c.DefaultMaxBandwidth,
+		DefaultRequestsPerMin:    c.DefaultRequestsPerMin,
+		TrashRetentionDays:       c.TrashRetentionDays,
+		TrashWarnSize:            c.TrashWarnSize,
+		VersionKeepCount:         c.VersionKeepCount,
+		VersionMaxAgeDays:        c.VersionMaxAgeDays,
+		MinClientVersion:         c.MinClientVersion,
+		GalleryDuplicateDistance: c.GalleryDuplicateDistance,
+		ShareMaxLifetimeSec:      int64(c.ShareMaxLifetime / time.Second),
+		ShareDefaultLifetimeSec:  int64(c.ShareDefaultLifetime / time.Second),
+	}
+	// The logger falls back to info for a level it does not know
+	if !validLogLevel(s.LogLevel) {
+		s.LogLevel = "info"
+	}
+	return s
+}
+
+// SettingKeys returns the JSON keys of Settings, sorted.
+func SettingKeys() []string {
+	t := reflect.TypeOf(Settings{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("json"))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isSettingKey(key string) bool {
+	for _, k := range SettingKeys() {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func validLogLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+// Validate checks that every setting is in range.
+func (s Settings) Validate() error {
+	switch {
+	case !validLogLevel(s.LogLevel):
+		return fmt.Errorf("log_level must be debug, info, warn or error")
+	case s.MaxUploadSize < 0, s.DefaultMaxStorage < 0, s.DefaultMaxBandwidth < 0, s.DefaultRequestsPerMin < 0:
+		return fmt.Errorf("size and rate limits must not be negative")
+	case s.TrashRetentionDays < 0 || s.TrashWarnSize < 0:
+		return fmt.Errorf("trash_retention_days and trash_warn_size must not be negative")
+	case s.VersionKeepCount < 0 || s.VersionMaxAgeDays < 0:
+		return fmt.Errorf("version_keep_count and version_max_age_days must not be negative")
+	case s.MinClientVersion != "" && !version.IsRelease(s.MinClientVersion):
+		return fmt.Errorf("invalid min_client_version: %s", s.MinClientVersion)
+	case s.GalleryDuplicateDistance < 0 || s.GalleryDuplicateDistance > 16:
+		return fmt.Errorf("gallery_duplicate_distance must be between 0 and 16")
+	case s.ShareMaxLifetimeSec < 0 || s.ShareDefaultLifetimeSec < 0:
+		return fmt.Errorf("share_max_lifetime_sec and share_default_lifetime_sec must not be negative")
+	case s.ShareMaxLifetimeSec > 0 && s.ShareDefaultLifetimeSec > s.ShareMaxLifetimeSec:
+		return fmt.Errorf("share_default_lifetime_sec must be at most share_max_lifetime_sec")
+	}
+	return nil
+}
+
+// apply returns s with the settings in values, keyed by their JSON names,
+// replaced. A value of the wrong type or an unknown key is an error.
+func (s Settings) apply(values map[string]json.RawMessage) (Settings, error) {
+	if len(values) == 0 {
+		return s, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return s, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return s, err
+	}
+	for k, v := range values {
+		if !isSettingKey(k) {
+			return s, fmt.Errorf("unknown setting: %s", k)
+		}
+		// Decode on its own so a bad value names its setting
+		var probe Settings
+		one, _ := json.Marshal(map[string]json.RawMessage{k: v})
+		if err := json.Unmarshal(one, &probe); err != nil {
+			return s, fmt.Errorf("invalid %s: %s", k, v)
+		}
+		fields[k] = v
+	}
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return s, err
+	}
+	var out Settings
+	if err := json.Unmarshal(data, &out); err != nil {
+		return s, err
+	}
+	return out, nil
+}
+
+// Live holds the current Settings: the environment's, overridden by the
+// values an admin has set. Reads are lock-free; subscribers are told about
+// every change.
+type Live struct {
+	base Settings
+	cur  atomic.Pointer[Settings]
+
+	mu        sync.Mutex // serializes changes and their notifications
+	overrides map[string]json.RawMessage
+	subs      []func(Settings)
+}
+
+// NewLive returns a Live with base as the current settings.
+func NewLive(base Settings) *Live {
+	l := &Live{base: base, overrides: map[string]json.RawMessage{}}
+	l.cur.Store(&base)
+	return l
+}
+
+// Get returns the current settings.
+func (l *Live) Get() Settings {
+	return *l.cur.Load()
+}
+
+// Overridden returns the keys of the settings an admin has set, sorted.
+func (l *Live) Overridden() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]string, 0, len(l.overrides))
+	for k := range l.overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Subscribe calls fn with the new settings after every change.
+func (l *Live) Subscribe(fn func(Settings)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subs = append(l.subs, fn)
+}
+
+// Load applies overrides saved earlier. Values that no longer apply, such
+// as settings since removed, are skipped and reported in the error; the
+// rest take effect.
+func (l *Live) Load(overrides map[string]json.RawMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bad []string
+	merged := make(map[string]json.RawMessage, len(l.overrides)+len(overrides))
+	for k, v := range l.overrides {
+		merged[k] = v
+	}
+	next := l.Get()
+	for k, v := range overrides {
+		one := map[string]json.RawMessage{k: v}
+		s, err := next.apply(one)
+		if err == nil {
+			err = s.Validate()
+		}
+		if err != nil {
+			bad = append(bad, err.Error())
+			continue
+		}
+		next = s
+		merged[k] = v
+	}
+	l.set(next, merged)
+
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return fmt.Errorf("ignored saved settings: %s", strings.Join(bad, "; "))
+	}
+	return nil
+}
+
+// Update changes the settings in values, keyed by their JSON names; a null
+// value reverts a setting to the environment's. The result is validated
+// and passed to persist before it takes effect, so a failure to save
+// changes nothing. Update returns the new settings.
+func (l *Live) Update(values map[string]json.RawMessage, persist func(map[string]json.RawMessage) error) (Settings, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	merged := make(map[string]json.RawMessage, len(l.overrides)+len(values))
+	for k, v := range l.overrides {
+		merged[k] = v
+	}
+	for k, v := range values {
+		if !isSettingKey(k) {
+			return l.Get(), fmt.Errorf("unknown setting: %s", k)
+		}
+		if bytes.Equal(bytes.TrimSpace(v), []byte("null")) {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	next, err := l.base.apply(merged)
+	if err != nil {
+		return l.Get(), err
+	}
+	if err := next.Validate(); err != nil {
+		return l.Get(), err
+	}
+	if persist != nil {
+		if err := persist(values); err != nil {
+			return l.Get(), err
+		}
+	}
+	l.set(next, merged)
+	return next, nil
+}
+
+// set installs new settings and notifies the subscribers. l.mu is held.
+func (l *Live) set(next Settings, overrides map[string]json.RawMessage) {
+	l.overrides = overrides
+	prev := l.Get()
+	l.cur.Store(&next)
+	if prev == next {
+		return
+	}
+	for _, fn := range l.subs {
+		fn(next)
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSettings() Settings {
+	return Settings{LogLevel: "info", MaxUploadSize: 100, TrashRetentionDays: 30, GalleryDuplicateDistance: 4}
+}
+
+func raw(m map[string]string) map[string]json.RawMessage {
+	out := make(map[string]json.RawMessage, len(m))
+	for k, v := range m {
+		out[k] = json.RawMessage(v)
+	}
+	return out
+}
+
+func TestLiveUpdate(t *testing.T) {
+	l := NewLive(testSettings())
+	var notified []Settings
+	l.Subscribe(func(s Settings) { notified = append(notified, s) })
+
+	var persisted map[string]json.RawMessage
+	got, err := l.Update(raw(map[string]string{"max_upload_size": "2048", "log_level": `"debug"`}),
+		func(v map[string]json.RawMessage) error { persisted = v; return nil })
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got.MaxUploadSize != 2048 || got.LogLevel != "debug" || got.TrashRetentionDays != 30 {
+		t.Errorf("Update = %+v", got)
+	}
+	if l.Get() != got || len(notified) != 1 || notified[0] != got {
+		t.Errorf("current %+v, notified %+v", l.Get(), notified)
+	}
+	if len(persisted) != 2 {
+		t.Errorf("persisted %v", persisted)
+	}
+	if keys := strings.Join(l.Overridden(), ","); keys != "log_level,max_upload_size" {
+		t.Errorf("Overridden = %s", keys)
+	}
+
+	// null reverts to the base value
+	got, err = l.Update(raw(map[string]string{"max_upload_size": "null"}), nil)
+	if err != nil || got.MaxUploadSize != 100 || got.LogLevel != "debug" {
+		t.Errorf("revert = %+v, %v", got, err)
+	}
+
+	// An update that changes nothing notifies no one
+	l.Update(raw(map[string]string{"log_level": `"debug"`}), nil)
+	if len(notified) != 2 {
+		t.Errorf("%d notifications, want 2", len(notified))
+	}
+}
+
+func TestLiveUpdateRejected(t *testing.T) {
+	l := NewLive(testSettings())
+	for _, values := range []map[string]string{
+		{"log_level": `"loud"`},
+		{"max_upload_size": `"big"`},
+		{"trash_retention_days": "-1"},
+		{"trash_warn_size": "-1"},
+		{"min_client_version": `"latest"`},
+		{"gallery_duplicate_distance": "17"},
+		{"share_max_lifetime_sec": "-1"},
+		{"share_max_lifetime_sec": "3600", "share_default_lifetime_sec": "7200"},
+		{"listen_addr": `":1"`},
+		{"listen_addr": "null"},
+	} {
+		if _, err := l.Update(raw(values), nil); err == nil {
+			t.Errorf("Update(%v) succeeded", values)
+		}
+	}
+
+	// A failure to persist leaves the settings alone
+	_, err := l.Update(raw(map[string]string{"max_upload_size": "1"}),
+		func(map[string]json.RawMessage) error { return errors.New("db down") })
+	if err == nil || l.Get() != testSettings() || len(l.Overridden()) != 0 {
+		t.Errorf("failed persist: err %v, settings %+v", err, l.Get())
+	}
+}
+
+func TestLiveLoad(t *testing.T) {
+	l := NewLive(testSettings())
+	err := l.Load(raw(map[string]string{
+		"trash_retention_days": "7",
+		"max_upload_size":      `"big"`,
+		"removed_setting":      "1",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "max_upload_size") || !strings.Contains(err.Error(), "removed_setting") {
+		t.Errorf("Load error = %v", err)
+	}
+	if got := l.Get(); got.TrashRetentionDays != 7 || got.MaxUploadSize != 100 {
+		t.Errorf("after Load: %+v", got)
+	}
+	if keys := strings.Join(l.Overridden(), ","); keys != "trash_retention_days" {
+		t.Errorf("Overridden = %s", keys)
+	}
+}
+
+func TestConfigSettings(t *testing.T) {
+	cfg := &Config{LogLevel: "verbose", MaxUploadSize: 5, VersionKeepCount: 3, ShareMaxLifetime: 48 * time.Hour}
+	s := cfg.Settings()
+	if s.LogLevel != "info" || s.MaxUploadSize != 5 || s.VersionKeepCount != 3 || s.ShareMaxLifetimeSec != 172800 {
+		t.Errorf("Settings = %+v", s)
+	}
+	if len(SettingKeys()) != 13 {
+		t.Errorf("SettingKeys = %v", SettingKeys())
+	}
+}
@@ -0,0 +1,135 @@
+// Package cors answers browser cross-origin requests to the API, so that
+// web apps served from other origins can call it.
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// allowedMethods are the methods a preflight may ask for.
+const allowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// Config is a CORS policy.
+type Config struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), "*" for
+	// any origin, or subdomain wildcards ("https://*.example.com"). Empty
+	// disables CORS.
+	AllowedOrigins   []string
+	AllowedHeaders   []string // request headers a preflight may ask for
+	ExposedHeaders   []string // response headers scripts may read
+	AllowCredentials bool     // allow cookies and Authorization with credentialed fetches
+	MaxAge           time.Duration
+}
+
+// ParseList splits a comma-separated list, dropping empty entries.
+func ParseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Middleware returns middleware applying cfg to requests whose path starts
+// with one of prefixes; other requests pass through untouched. Preflight
+// requests from allowed origins are answered here, before any
+// authentication further down the chain. Requests from other origins get
+// no CORS headers, so browsers block them.
+func Middleware(cfg Config, prefixes ...string) func(http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	anyOrigin := false
+	origins := make([]string, 0, len(cfg.AllowedOrigins))
+	for _, o := range cfg.AllowedOrigins {
+		o = strings.ToLower(strings.TrimRight(o, "/"))
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins = append(origins, o)
+	}
+	allowHeaders := strings.Join(cfg.AllowedHeaders, ", ")
+	exposeHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge / time.Second))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasPrefix(r.URL.Path, prefixes) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" || !originAllowed(strings.ToLower(origin), origins) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// A credentialed response must name the origin, never "*"
+			if anyOrigin && !cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", allowedMethods)
+				if allowHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", allowHeaders)
+				}
+				if cfg.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// originAllowed matches a lower-cased origin against the allowed list. A
+// "scheme://*.domain" entry matches subdomains of domain but not domain.
+func originAllowed(origin string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(a, "://*.")
+		if !ok {
+			continue
+		}
+		prefix, suffix := scheme+"://", "."+host
+		if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			len(origin) > len(prefix)+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package cors
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authed stands in for the auth middleware: it rejects requests without a
+// bearer token.
+var authed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("ETag", `"abc"`)
+	w.Write([]byte("ok"))
+})
+
+func testConfig() Config {
+	return Config{
+		AllowedOrigins:   ParseList("https://app.example.com, https://*.example.org"),
+		AllowedHeaders:   ParseList("Authorization, X-Expected-Version, If-Match"),
+		ExposedHeaders:   ParseList("ETag, X-Version, Content-Range"),
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+func serve(h http.Handler, method, path, origin string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPreflight(t *testing.T) {
+	h := Middleware(testConfig(), "/api/v1/")(authed)
+	preflight := map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "authorization, x-expected-version",
+	}
+
+	// Answered before auth would reject it
+	rec := serve(h, "OPTIONS", "/api/v1/content/a.txt", "https://app.example.com", preflight)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", rec.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Headers":     "Authorization, X-Expected-Version, If-Match",
+		"Access-Control-Max-Age":           "600",
+	}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "DELETE") {
+		t.Errorf("Allow-Methods = %q", rec.Header().Get("Access-Control-Allow-Methods"))
+	}
+
+	// Subdomain wildcards match subdomains only
+	if rec := serve(h, "OPTIONS", "/api/v1/tree", "https://files.example.org", preflight); rec.Code != http.StatusNoContent {
+		t.Errorf("wildcard origin preflight status = %d, want 204", rec.Code)
+	}
+	for _, origin := range []string{"https://example.org", "https://evil.com", "http://app.example.com"} {
+		rec := serve(h, "OPTIONS", "/api/v1/tree", origin, preflight)
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Code != http.StatusUnauthorized {
+			t.Errorf("origin %s: status %d, allow-origin %q; want no CORS", origin, rec.Code,
+				rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	// Paths outside the prefixes, such as WebDAV, are untouched
+	rec = serve(h, "OPTIONS", "/webdav/a.txt", "https://app.example.com", preflight)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "" {
+		t.Errorf("webdav got CORS headers: %v", rec.Header())
+	}
+}
+
+func TestCredentialedRequest(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedOrigins = []string{"*"}
+	h := Middleware(cfg, "/api/v1/")(authed)
+
+	rec := serve(h, "GET", "/api/v1/tree", "https://other.test", map[string]string{"Authorization": "Bearer x"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	// With credentials the origin is echoed, never "*"
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://other.test" {
+		t.Errorf("Allow-Origin = %q, want the request origin", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Allow-Credentials = %q, want true", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != "ETag, X-Version, Content-Range" {
+		t.Errorf("Expose-Headers = %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q, want Origin", got)
+	}
+
+	// Without credentials any origin gets "*"
+	cfg.AllowCredentials = false
+	rec = serve(Middleware(cfg, "/api/v1/")(authed), "GET", "/api/v1/tree", "https://other.test", map[string]string{"Authorization": "Bearer x"})
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q, want *", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Allow-Credentials set without credentials")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	h := Middleware(Config{}, "/api/v1/")(authed)
+	rec := serve(h, "OPTIONS", "/api/v1/tree", "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method": "GET",
+	})
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("disabled CORS: status %d, headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	// The SSE stream must get CORS headers and still flush events through
+	// the middleware
+	events := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "event: hello\ndata: {}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	ts := httptest.NewServer(Middleware(testConfig(), "/api/v1/")(events))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/events", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Allow-Credentials = %q, want true", got)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: hello\n" {
+		t.Errorf("first line = %q, %v; want the flushed event", line, err)
+	}
+}
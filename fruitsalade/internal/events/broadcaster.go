@@ -2,73 +2,200 @@
 package events
 
 import (
-	"encoding/json"
 	"sync"
 	"time"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
+// Event types, re-exported from the protocol package.
 const (
-	EventCreate  = "create"
-	EventModify  = "modify"
-	EventDelete  = "delete"
-	EventVersion = "version"
+	EventCreate     = protocol.EventCreate
+	EventModify     = protocol.EventModify
+	EventDelete     = protocol.EventDelete
+	EventVersion    = protocol.EventVersion
+	EventDirChanged = protocol.EventDirChanged
+	EventJob        = protocol.EventJob
+	EventNotice     = protocol.EventNotice
+	EventShutdown   = protocol.EventShutdown
+
+	EventPermissionGranted = protocol.EventPermissionGranted
+	EventComment           = protocol.EventComment
+	EventStorageHealth     = protocol.EventStorageHealth
+	EventMaintenance       = protocol.EventMaintenance
+	EventConflict          = protocol.EventConflict
+	EventTrashPurged       = protocol.EventTrashPurged
+	EventResyncRequired    = protocol.EventResyncRequired
+	EventReauth            = protocol.EventReauth
 )
 
-// Event represents a file system change event.
-type Event struct {
-	Type      string `json:"type"`
-	Path      string `json:"path"`
-	Version   int    `json:"version,omitempty"`
-	Hash      string `json:"hash,omitempty"`
-	Size      int64  `json:"size,omitempty"`
-	Timestamp int64  `json:"timestamp"`
-	UserID    int    `json:"user_id,omitempty"`
-	Username  string `json:"username,omitempty"`
-}
+// Replay buffer bounds: published events are kept for resuming clients
+// until either limit is exceeded.
+const (
+	DefaultBufferSize = 10000
+	DefaultBufferAge  = 10 * time.Minute
+)
 
-// Broadcaster manages SSE subscribers and publishes events.
+// Event is a server-sent event; see protocol.Event for the wire format.
+type Event = protocol.Event
+
+// Broadcaster manages SSE subscribers and publishes events. Published
+// events get increasing IDs and are kept in a bounded buffer so that
+// reconnecting clients can replay what they missed. Each subscriber
+// remembers the session (device token ID) it was opened with, so that
+// revoking a session can end its streams.
 type Broadcaster struct {
 	mu          sync.RWMutex
-	subscribers map[chan Event]struct{}
+	subscribers map[chan Event]int // session ID, 0 if none
+	closed      bool
+
+	lastID uint64
+	buffer []bufferedEvent // ring, oldest at start
+	start  int
+	count  int
+	maxAge time.Duration
+}
+
+type bufferedEvent struct {
+	event     Event
+	published time.Time
 }
 
-// NewBroadcaster creates a new event broadcaster.
+// NewBroadcaster creates a new event broadcaster with the default replay
+// buffer.
 func NewBroadcaster() *Broadcaster {
+	return NewBroadcasterWithBuffer(DefaultBufferSize, DefaultBufferAge)
+}
+
+// NewBroadcasterWithBuffer creates a broadcaster that keeps at most size
+// events, none older than maxAge, for replay.
+//
+// IDs start at the creation time in microseconds, so a restarted instance
+// never reuses the IDs of its predecessor and clients resuming with an
+// old ID are told to resync.
+func NewBroadcasterWithBuffer(size int, maxAge time.Duration) *Broadcaster {
+	if size < 1 {
+		size = 1
+	}
 	return &Broadcaster{
-		subscribers: make(map[chan Event]struct{}),
+		subscribers: make(map[chan Event]int),
+		lastID:      uint64(time.Now().UnixMicro()),
+		buffer:      make([]bufferedEvent, size),
+		maxAge:      maxAge,
 	}
 }
 
 // Subscribe adds a new subscriber and returns its event channel.
-// The caller must call Unsubscribe when done.
+// The caller must call Unsubscribe when done. After Close the returned
+// channel is already closed.
 func (b *Broadcaster) Subscribe() chan Event {
-	ch := make(chan Event, 64)
+	ch, _, _ := b.SubscribeFrom(0)
+	return ch
+}
+
+// SubscribeFrom subscribes like Subscribe and also returns the buffered
+// events published after lastID, which the caller must send before
+// reading the channel. ok is false when events after lastID were already
+// evicted (or lastID is unknown to this instance); the client then needs
+// a resync. A lastID of 0 replays nothing.
+func (b *Broadcaster) SubscribeFrom(lastID uint64) (ch chan Event, missed []Event, ok bool) {
+	return b.SubscribeSession(lastID, 0)
+}
+
+// SubscribeSession is SubscribeFrom for a stream authenticated with the
+// given session, which EndSession can end. 0 is no session.
+func (b *Broadcaster) SubscribeSession(lastID uint64, sessionID int) (ch chan Event, missed []Event, ok bool) {
+	ch = make(chan Event, 64)
 	b.mu.Lock()
-	b.subscribers[ch] = struct{}{}
+	if b.closed {
+		close(ch)
+		b.mu.Unlock()
+		return ch, nil, true
+	}
+	ok = true
+	if lastID != 0 {
+		b.evictExpired(time.Now())
+		missed, ok = b.since(lastID)
+	}
+	b.subscribers[ch] = sessionID
 	b.mu.Unlock()
 	metrics.SetSSEConnectionsActive(int64(b.Count()))
-	return ch
+	return ch, missed, ok
 }
 
-// Unsubscribe removes a subscriber and closes its channel.
+// since returns the buffered events after lastID. Callers hold b.mu.
+func (b *Broadcaster) since(lastID uint64) ([]Event, bool) {
+	if lastID > b.lastID {
+		return nil, false
+	}
+	// The oldest event the client may have missed is lastID+1; it must
+	// still be buffered, unless nothing was published since.
+	oldest := b.lastID + 1
+	if b.count > 0 {
+		oldest = b.buffer[b.start].event.ID
+	}
+	if lastID+1 < oldest {
+		return nil, false
+	}
+	var missed []Event
+	for i := 0; i < b.count; i++ {
+		e := b.buffer[(b.start+i)%len(b.buffer)].event
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return missed, true
+}
+
+// append adds an event to the replay buffer. Callers hold b.mu.
+func (b *Broadcaster) append(e Event, now time.Time) {
+	b.evictExpired(now)
+	if b.count == len(b.buffer) {
+		b.start = (b.start + 1) % len(b.buffer)
+		b.count--
+	}
+	i := (b.start + b.count) % len(b.buffer)
+	b.buffer[i] = bufferedEvent{event: e, published: now}
+	b.count++
+}
+
+// evictExpired drops buffered events older than maxAge. Callers hold b.mu.
+func (b *Broadcaster) evictExpired(now time.Time) {
+	if b.maxAge <= 0 {
+		return
+	}
+	for b.count > 0 && now.Sub(b.buffer[b.start].published) > b.maxAge {
+		b.buffer[b.start] = bufferedEvent{}
+		b.start = (b.start + 1) % len(b.buffer)
+		b.count--
+	}
+}
+
+// Unsubscribe removes a subscriber and closes its channel. Channels that
+// were already closed by Close are ignored.
 func (b *Broadcaster) Unsubscribe(ch chan Event) {
 	b.mu.Lock()
-	delete(b.subscribers, ch)
-	close(ch)
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
 	b.mu.Unlock()
 	metrics.SetSSEConnectionsActive(int64(b.Count()))
 }
 
-// Publish sends an event to all subscribers. Non-blocking: drops events
-// for slow consumers.
+// Publish assigns the event the next ID, buffers it for replay and sends
+// it to all subscribers. Non-blocking: drops events for slow consumers.
 func (b *Broadcaster) Publish(event Event) {
+	now := time.Now()
 	if event.Timestamp == 0 {
-		event.Timestamp = time.Now().Unix()
+		event.Timestamp = now.Unix()
 	}
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	event.ID = b.lastID
+	b.append(event, now)
 	for ch := range b.subscribers {
 		select {
 		case ch <- event:
@@ -79,6 +206,69 @@ func (b *Broadcaster) Publish(event Event) {
 	metrics.RecordSSEEvent(event.Type)
 }
 
+// EndSession sends final to every subscriber of the session and closes
+// their channels, which ends the streams. It returns how many were ended.
+func (b *Broadcaster) EndSession(sessionID int, final Event) int {
+	if sessionID == 0 {
+		return 0
+	}
+	if final.Timestamp == 0 {
+		final.Timestamp = time.Now().Unix()
+	}
+	b.mu.Lock()
+	ended := 0
+	for ch, session := range b.subscribers {
+		if session != sessionID {
+			continue
+		}
+		sendFinal(ch, final)
+		close(ch)
+		delete(b.subscribers, ch)
+		ended++
+	}
+	b.mu.Unlock()
+	if ended > 0 {
+		metrics.SetSSEConnectionsActive(int64(b.Count()))
+	}
+	return ended
+}
+
+// Close sends a final server-shutdown event to every subscriber and closes
+// their channels, which ends the SSE streams so clients reconnect to another
+// instance. Later subscribers get a closed channel.
+func (b *Broadcaster) Close() {
+	event := Event{Type: EventShutdown, Timestamp: time.Now().Unix()}
+	b.mu.Lock()
+	for ch := range b.subscribers {
+		sendFinal(ch, event)
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+	b.closed = true
+	b.mu.Unlock()
+	metrics.RecordSSEEvent(event.Type)
+	metrics.SetSSEConnectionsActive(0)
+}
+
+// sendFinal sends the last event of a channel that is about to be closed,
+// dropping a stale event if the channel is full: the final one matters
+// more. Callers hold b.mu.
+func sendFinal(ch chan Event, event Event) {
+	select {
+	case ch <- event:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- event:
+	default:
+	}
+}
+
 // Count returns the current number of subscribers.
 func (b *Broadcaster) Count() int {
 	b.mu.RLock()
@@ -86,7 +276,16 @@ func (b *Broadcaster) Count() int {
 	return len(b.subscribers)
 }
 
-// MarshalEvent serializes an event to JSON.
+// DeliverTo reports whether an event is meant for the given user.
+func DeliverTo(e Event, userID int, isAdmin bool) bool {
+	if e.AdminOnly && !isAdmin {
+		return false
+	}
+	return e.ForUserID == 0 || e.ForUserID == userID
+}
+
+// MarshalEvent validates an event and serializes it to JSON with the
+// current schema version.
 func MarshalEvent(e Event) ([]byte, error) {
-	return json.Marshal(e)
+	return protocol.MarshalEvent(e)
 }
@@ -3,6 +3,8 @@ package events
 import (
 	"testing"
 	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
 func TestBroadcasterSubscribeUnsubscribe(t *testing.T) {
@@ -116,3 +118,155 @@ func TestMarshalEvent(t *testing.T) {
 		t.Error("expected non-empty JSON")
 	}
 }
+
+func TestBroadcasterClose(t *testing.T) {
+	b := NewBroadcaster()
+	ch := b.Subscribe()
+
+	// A full buffer must not swallow the shutdown event
+	for i := 0; i < 100; i++ {
+		b.Publish(Event{Type: EventModify, Path: "/f"})
+	}
+	b.Close()
+
+	var last Event
+	for ev := range ch {
+		last = ev
+	}
+	if last.Type != EventShutdown {
+		t.Errorf("last event = %q, want %q", last.Type, EventShutdown)
+	}
+	if b.Count() != 0 {
+		t.Errorf("expected 0 subscribers after close, got %d", b.Count())
+	}
+
+	// Unsubscribing a closed channel and subscribing late are safe
+	b.Unsubscribe(ch)
+	if _, ok := <-b.Subscribe(); ok {
+		t.Error("subscribe after close returned an open channel")
+	}
+}
+
+func TestBroadcasterEndSession(t *testing.T) {
+	b := NewBroadcaster()
+	ended1, _, _ := b.SubscribeSession(0, 7)
+	ended2, _, _ := b.SubscribeSession(0, 7)
+	other, _, _ := b.SubscribeSession(0, 8)
+	anon := b.Subscribe()
+	defer b.Unsubscribe(other)
+	defer b.Unsubscribe(anon)
+
+	for i := 0; i < 100; i++ {
+		b.Publish(Event{Type: EventModify, Path: "/f"})
+	}
+	final := Event{Type: EventReauth, Reauth: &protocol.ReauthPayload{Reason: protocol.ReauthRevoked}}
+	if n := b.EndSession(7, final); n != 2 {
+		t.Errorf("EndSession ended %d streams, want 2", n)
+	}
+	if n := b.EndSession(0, final); n != 0 {
+		t.Errorf("EndSession(0) ended %d streams, want none", n)
+	}
+
+	for _, ch := range []chan Event{ended1, ended2} {
+		var last Event
+		for ev := range ch {
+			last = ev
+		}
+		if last.Type != EventReauth || last.Timestamp == 0 {
+			t.Errorf("last event = %+v, want a stamped reauth event", last)
+		}
+	}
+	if b.Count() != 2 {
+		t.Errorf("expected 2 subscribers left, got %d", b.Count())
+	}
+	b.Unsubscribe(ended1) // already closed: ignored
+}
+
+func TestDeliverTo(t *testing.T) {
+	broadcast := Event{Type: EventCreate, Path: "/a"}
+	targeted := Event{Type: EventPermissionGranted, Path: "/a", ForUserID: 2}
+
+	adminOnly := Event{Type: EventStorageHealth, AdminOnly: true}
+
+	if !DeliverTo(broadcast, 1, false) || !DeliverTo(broadcast, 2, false) {
+		t.Error("untargeted event should reach every user")
+	}
+	if DeliverTo(targeted, 1, true) {
+		t.Error("targeted event reached another user")
+	}
+	if !DeliverTo(targeted, 2, false) {
+		t.Error("targeted event did not reach its user")
+	}
+	if DeliverTo(adminOnly, 1, false) || !DeliverTo(adminOnly, 1, true) {
+		t.Error("admin-only event should reach admins only")
+	}
+}
+
+func TestBroadcasterReplay(t *testing.T) {
+	b := NewBroadcasterWithBuffer(3, time.Minute)
+
+	first := b.Subscribe()
+	for _, p := range []string{"/a", "/b", "/c"} {
+		b.Publish(Event{Type: EventCreate, Path: p})
+	}
+	a, bb := <-first, <-first
+	<-first
+	b.Unsubscribe(first)
+	if bb.ID != a.ID+1 {
+		t.Fatalf("IDs not consecutive: %d, %d", a.ID, bb.ID)
+	}
+
+	ch, missed, ok := b.SubscribeFrom(a.ID)
+	defer b.Unsubscribe(ch)
+	if !ok || len(missed) != 2 || missed[0].Path != "/b" || missed[1].Path != "/c" {
+		t.Fatalf("replay after %d = %+v, %v", a.ID, missed, ok)
+	}
+
+	// Up to date: nothing to replay
+	last := missed[1].ID
+	ch2, missed, ok := b.SubscribeFrom(last)
+	b.Unsubscribe(ch2)
+	if !ok || len(missed) != 0 {
+		t.Errorf("replay after latest = %+v, %v", missed, ok)
+	}
+
+	// Push /a out of the buffer; resuming before it needs a resync
+	b.Publish(Event{Type: EventCreate, Path: "/d"})
+	if ch3, _, ok := b.SubscribeFrom(a.ID - 1); ok {
+		t.Error("evicted ID resumed without resync")
+	} else {
+		b.Unsubscribe(ch3)
+	}
+
+	// IDs from another instance or the future are unknown
+	if ch4, _, ok := b.SubscribeFrom(last + 100); ok {
+		t.Error("unknown ID resumed without resync")
+	} else {
+		b.Unsubscribe(ch4)
+	}
+}
+
+func TestBroadcasterReplayExpires(t *testing.T) {
+	b := NewBroadcasterWithBuffer(10, 20*time.Millisecond)
+	b.Publish(Event{Type: EventCreate, Path: "/old"})
+	b.Publish(Event{Type: EventCreate, Path: "/old2"})
+
+	ch, missed, ok := b.SubscribeFrom(1)
+	b.Unsubscribe(ch)
+	if ok {
+		t.Fatalf("ID 1 predates this broadcaster, got %+v", missed)
+	}
+
+	ch, missed, ok = b.SubscribeFrom(b.lastID - 1)
+	b.Unsubscribe(ch)
+	if !ok || len(missed) != 1 {
+		t.Fatalf("replay = %+v, %v", missed, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	ch, _, ok = b.SubscribeFrom(b.lastID - 1)
+	b.Unsubscribe(ch)
+	if ok {
+		t.Error("expired events resumed without resync")
+	}
+}
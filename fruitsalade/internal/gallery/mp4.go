@@ -0,0 +1,289 @@
+package gallery
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxMoovSize caps how much of the moov box is read into memory.
+const maxMoovSize = 64 << 20
+
+// mp4Epoch is the reference time for MP4/QuickTime timestamps.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ParseMP4File extracts video metadata from an MP4/MOV file using the
+// container's atoms (mvhd, tkhd, udta and Apple mdta keys). No decoding
+// is done, so it works without ffprobe but cannot produce poster frames.
+func ParseMP4File(path string) (*VideoInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ParseMP4(f, st.Size())
+}
+
+// ParseMP4 is ParseMP4File for an io.ReaderAt of the given size.
+func ParseMP4(r io.ReaderAt, size int64) (*VideoInfo, error) {
+	moov, err := findTopLevelBox(r, size, "moov")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &VideoInfo{}
+	var keys []string
+	walkBoxes(moov, func(path, typ string, body []byte) bool {
+		switch path + "/" + typ {
+		case "/mvhd":
+			parseMvhd(body, info)
+		case "/trak/tkhd":
+			parseTkhd(body, info)
+		case "/udta/\xa9xyz":
+			if info.Latitude == nil {
+				info.Latitude, info.Longitude, info.Altitude = parseISO6709(quickTimeText(body))
+			}
+		case "/udta/\xa9mak":
+			if info.CameraMake == "" {
+				info.CameraMake = quickTimeText(body)
+			}
+		case "/udta/\xa9mod":
+			if info.CameraModel == "" {
+				info.CameraModel = quickTimeText(body)
+			}
+		case "/meta/keys":
+			keys = parseMdtaKeys(body)
+		case "/meta/ilst":
+			applyMdtaValues(body, keys, info)
+		}
+		// Descend into containers
+		switch typ {
+		case "trak", "udta", "meta":
+			return true
+		}
+		return false
+	})
+
+	if info.Duration == 0 && info.Width == 0 {
+		return nil, fmt.Errorf("no movie header found")
+	}
+	return info, nil
+}
+
+// findTopLevelBox scans top-level boxes (skipping media data) and returns
+// the body of the first box of the given type.
+func findTopLevelBox(r io.ReaderAt, size int64, want string) ([]byte, error) {
+	var off int64
+	hdr := make([]byte, 16)
+	for off+8 <= size {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return nil, fmt.Errorf("read box header: %w", err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+		hdrLen := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, fmt.Errorf("read box header: %w", err)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			hdrLen = 16
+		}
+		if boxSize < hdrLen || off+boxSize > size {
+			return nil, fmt.Errorf("corrupt box %q at offset %d", typ, off)
+		}
+		if typ == want {
+			bodyLen := boxSize - hdrLen
+			if bodyLen > maxMoovSize {
+				return nil, fmt.Errorf("%s box too large (%d bytes)", want, bodyLen)
+			}
+			body := make([]byte, bodyLen)
+			if _, err := r.ReadAt(body, off+hdrLen); err != nil {
+				return nil, fmt.Errorf("read %s: %w", want, err)
+			}
+			return body, nil
+		}
+		off += boxSize
+	}
+	return nil, fmt.Errorf("no %s box found", want)
+}
+
+// walkBoxes calls fn for each child box in data; fn returns true to descend.
+// path is the chain of parent box types below the starting box.
+func walkBoxes(data []byte, fn func(path, typ string, body []byte) bool) {
+	walkBoxesAt("", data, fn)
+}
+
+func walkBoxesAt(path string, data []byte, fn func(path, typ string, body []byte) bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		typ := string(data[4:8])
+		hdrLen := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdrLen = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < hdrLen || size > uint64(len(data)) {
+			return
+		}
+		body := data[hdrLen:size]
+		if fn(path, typ, body) {
+			child := body
+			// ISO 'meta' is a full box (version+flags before its children);
+			// QuickTime 'meta' is a plain container.
+			if typ == "meta" && len(child) >= 8 && string(child[4:8]) != "hdlr" && string(child[4:8]) != "keys" {
+				child = child[4:]
+			}
+			walkBoxesAt(path+"/"+typ, child, fn)
+		}
+		data = data[size:]
+	}
+}
+
+func parseMvhd(b []byte, info *VideoInfo) {
+	if len(b) < 4 {
+		return
+	}
+	var created, timescale, duration uint64
+	if b[0] == 1 {
+		if len(b) < 32 {
+			return
+		}
+		created = binary.BigEndian.Uint64(b[4:12])
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	} else {
+		if len(b) < 20 {
+			return
+		}
+		created = uint64(binary.BigEndian.Uint32(b[4:8]))
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	if timescale > 0 {
+		info.Duration = float64(duration) / float64(timescale)
+	}
+	if created > 0 && info.CreatedAt == nil {
+		t := mp4Epoch.Add(time.Duration(created) * time.Second)
+		if t.Year() >= 1971 {
+			info.CreatedAt = &t
+		}
+	}
+}
+
+func parseTkhd(b []byte, info *VideoInfo) {
+	if len(b) < 4 {
+		return
+	}
+	// Offset of the transformation matrix; width/height follow it
+	matrixOff := 40
+	if b[0] == 1 {
+		matrixOff = 52
+	}
+	if len(b) < matrixOff+44 {
+		return
+	}
+	w := int(binary.BigEndian.Uint32(b[matrixOff+36:matrixOff+40]) >> 16)
+	h := int(binary.BigEndian.Uint32(b[matrixOff+40:matrixOff+44]) >> 16)
+	if w == 0 || h == 0 {
+		return // audio track
+	}
+
+	// A 90/270 degree rotation matrix has a=0 and b=±1.0 (16.16 fixed point)
+	a := int32(binary.BigEndian.Uint32(b[matrixOff : matrixOff+4]))
+	mb := int32(binary.BigEndian.Uint32(b[matrixOff+4 : matrixOff+8]))
+	if a == 0 && (mb == 1<<16 || mb == -(1<<16)) {
+		w, h = h, w
+	}
+
+	if w*h > info.Width*info.Height {
+		info.Width, info.Height = w, h
+	}
+}
+
+// quickTimeText decodes a QuickTime user-data text atom (16-bit length,
+// 16-bit language, text). iTunes-style atoms wrap the value in a 'data' box.
+func quickTimeText(b []byte) string {
+	if len(b) >= 16 && string(b[4:8]) == "data" {
+		return strings.TrimSpace(string(b[16:]))
+	}
+	if len(b) < 4 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(b[0:2]))
+	if n > len(b)-4 {
+		n = len(b) - 4
+	}
+	return strings.TrimSpace(string(b[4 : 4+n]))
+}
+
+// parseMdtaKeys parses an Apple 'keys' box into its 1-based key list.
+func parseMdtaKeys(b []byte) []string {
+	if len(b) < 8 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(b[4:8]))
+	data := b[8:]
+	keys := make([]string, 0, count)
+	for i := 0; i < count && len(data) >= 8; i++ {
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		if size < 8 || size > len(data) {
+			break
+		}
+		keys = append(keys, string(data[8:size]))
+		data = data[size:]
+	}
+	return keys
+}
+
+// applyMdtaValues maps 'ilst' entries (indexed by key number) onto info.
+func applyMdtaValues(b []byte, keys []string, info *VideoInfo) {
+	walkBoxes(b, func(_, typ string, body []byte) bool {
+		idx := int(binary.BigEndian.Uint32([]byte(typ)))
+		if idx < 1 || idx > len(keys) {
+			return false
+		}
+		value := mdtaValue(body)
+		switch keys[idx-1] {
+		case "com.apple.quicktime.make":
+			info.CameraMake = value
+		case "com.apple.quicktime.model":
+			info.CameraModel = value
+		case "com.apple.quicktime.location.ISO6709":
+			info.Latitude, info.Longitude, info.Altitude = parseISO6709(value)
+		case "com.apple.quicktime.creationdate":
+			if t := parseVideoTime(value); t != nil {
+				info.CreatedAt = t
+			}
+		}
+		return false
+	})
+}
+
+// mdtaValue extracts the UTF-8 payload of the 'data' box inside an ilst item.
+func mdtaValue(b []byte) string {
+	var value string
+	walkBoxes(b, func(_, typ string, body []byte) bool {
+		if typ == "data" && len(body) >= 8 && value == "" {
+			value = strings.TrimSpace(string(body[8:]))
+		}
+		return false
+	})
+	return value
+}
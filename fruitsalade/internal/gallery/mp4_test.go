@@ -0,0 +1,146 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(8+len(body)))
+	copy(b[4:8], typ)
+	return append(b, body...)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func mvhdV0(created uint32, timescale, duration uint32) []byte {
+	body := append([]byte{0, 0, 0, 0}, u32(created)...)
+	body = append(body, u32(created)...) // modification
+	body = append(body, u32(timescale)...)
+	body = append(body, u32(duration)...)
+	body = append(body, make([]byte, 80)...)
+	return box("mvhd", body)
+}
+
+func tkhdV0(width, height int, rotate90 bool) []byte {
+	body := make([]byte, 4+20+8+8) // version/flags, times/ids/duration, reserved, layer/group/volume
+	matrix := make([]byte, 36)
+	if rotate90 {
+		copy(matrix[0:4], u32(0))
+		copy(matrix[4:8], u32(1<<16))
+		copy(matrix[12:16], u32(uint32(0xFFFF0000)))
+		copy(matrix[16:20], u32(0))
+	} else {
+		copy(matrix[0:4], u32(1<<16))
+		copy(matrix[16:20], u32(1<<16))
+	}
+	copy(matrix[32:36], u32(1<<30))
+	body = append(body, matrix...)
+	body = append(body, u32(uint32(width)<<16)...)
+	body = append(body, u32(uint32(height)<<16)...)
+	return box("tkhd", body)
+}
+
+func qtText(s string) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b[0:2], uint16(len(s)))
+	return append(b, s...)
+}
+
+func TestParseMP4(t *testing.T) {
+	// 2024-06-01 12:00:00 UTC in seconds since 1904
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	createdSecs := uint32(created.Sub(mp4Epoch) / time.Second)
+
+	keys := box("keys", []byte{0, 0, 0, 0}, u32(2),
+		box("mdta", []byte("com.apple.quicktime.make")),
+		box("mdta", []byte("com.apple.quicktime.model")))
+	ilst := box("ilst",
+		box(string(u32(1)), box("data", u32(1), u32(0), []byte("Apple"))),
+		box(string(u32(2)), box("data", u32(1), u32(0), []byte("iPhone 15"))))
+
+	moov := box("moov",
+		mvhdV0(createdSecs, 600, 600*90+300), // 90.5 seconds
+		box("trak", tkhdV0(1920, 1080, true)),
+		box("trak", tkhdV0(0, 0, false)), // audio
+		box("udta", box("\xa9xyz", qtText("+48.8584+002.2945+035.000/"))),
+		box("meta", box("hdlr", make([]byte, 24)), keys, ilst),
+	)
+
+	// moov after mdat, as written by most cameras
+	file := bytes.Join([][]byte{
+		box("ftyp", []byte("isom"), u32(0x200), []byte("isommp41")),
+		box("mdat", make([]byte, 1024)),
+		moov,
+	}, nil)
+
+	info, err := ParseMP4(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("ParseMP4: %v", err)
+	}
+	if math.Abs(info.Duration-90.5) > 0.001 {
+		t.Errorf("duration = %v, want 90.5", info.Duration)
+	}
+	if info.Width != 1080 || info.Height != 1920 {
+		t.Errorf("dimensions = %dx%d, want 1080x1920 (rotated)", info.Width, info.Height)
+	}
+	if info.CreatedAt == nil || !info.CreatedAt.Equal(created) {
+		t.Errorf("created = %v, want %v", info.CreatedAt, created)
+	}
+	if info.Latitude == nil || math.Abs(*info.Latitude-48.8584) > 1e-6 ||
+		info.Longitude == nil || math.Abs(*info.Longitude-2.2945) > 1e-6 {
+		t.Errorf("location = %v, %v", info.Latitude, info.Longitude)
+	}
+	if info.Altitude == nil || *info.Altitude != 35 {
+		t.Errorf("altitude = %v, want 35", info.Altitude)
+	}
+	if info.CameraMake != "Apple" || info.CameraModel != "iPhone 15" {
+		t.Errorf("camera = %q %q", info.CameraMake, info.CameraModel)
+	}
+}
+
+func TestParseMP4NoMoov(t *testing.T) {
+	file := box("ftyp", []byte("isom"), u32(0))
+	if _, err := ParseMP4(bytes.NewReader(file), int64(len(file))); err == nil {
+		t.Error("expected error for file without moov")
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	lat, lon, alt := parseISO6709("-33.8568+151.2153/")
+	if lat == nil || *lat != -33.8568 || lon == nil || *lon != 151.2153 || alt != nil {
+		t.Errorf("got %v %v %v", lat, lon, alt)
+	}
+	if lat, _, _ := parseISO6709("garbage"); lat != nil {
+		t.Error("expected nil for invalid input")
+	}
+	if lat, _, _ := parseISO6709("+95.0+010.0/"); lat != nil {
+		t.Error("expected nil for out-of-range latitude")
+	}
+}
+
+func TestMediaTypeFor(t *testing.T) {
+	tests := map[string]string{
+		"/a/photo.JPG": MediaTypeImage,
+		"/a/clip.mp4":  MediaTypeVideo,
+		"/a/clip.MOV":  MediaTypeVideo,
+		"/a/clip.webm": MediaTypeVideo,
+	}
+	for path, want := range tests {
+		if got := MediaTypeFor(path); got != want {
+			t.Errorf("MediaTypeFor(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if IsMediaFile("/a/doc.pdf") {
+		t.Error("pdf should not be a gallery file")
+	}
+}
@@ -0,0 +1,209 @@
+package gallery
+
+import (
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaxDuplicateDistance is the largest Hamming distance accepted for duplicate
+// grouping; beyond it unrelated photos start to match.
+const MaxDuplicateDistance = 16
+
+// DHash computes a 64-bit difference hash: the image is reduced to a 9x8
+// grayscale grid and each bit records whether a pixel is brighter than its
+// right-hand neighbour. Re-encoded, resized or lightly edited copies of the
+// same photo end up within a few bits of each other.
+func DHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := small.Pix[y*small.Stride+x*4]
+			right := small.Pix[y*small.Stride+(x+1)*4]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// DHashReader decodes an image and returns its DHash.
+func DHashReader(r io.Reader) (uint64, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+// HammingDistance returns the number of differing bits between two hashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// HashedImage is an image with a perceptual hash, as considered for
+// duplicate grouping.
+type HashedImage struct {
+	FilePath     string
+	Size         int64
+	Width        int
+	Height       int
+	DateTaken    *time.Time
+	HasThumbnail bool
+	Hash         uint64
+}
+
+// DuplicateGroup is a set of perceptually similar images. Keep is the
+// suggested canonical copy; WastedBytes is the size of all other copies.
+type DuplicateGroup struct {
+	Keep        string
+	Images      []HashedImage
+	WastedBytes int64
+}
+
+// GroupDuplicates clusters images whose hashes are within maxDistance bits
+// of each other (transitively) and returns groups of two or more, sorted by
+// wasted bytes, largest first.
+func GroupDuplicates(images []HashedImage, maxDistance int) []DuplicateGroup {
+	if maxDistance < 0 {
+		maxDistance = 0
+	}
+
+	// Union-find over image indexes
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	tree := &bkTree{}
+	for i := range images {
+		tree.search(images[i].Hash, maxDistance, func(j int) {
+			if ri, rj := find(i), find(j); ri != rj {
+				parent[ri] = rj
+			}
+		})
+		tree.insert(images[i].Hash, i)
+	}
+
+	members := make(map[int][]int)
+	for i := range images {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups []DuplicateGroup
+	for _, idxs := range members {
+		if len(idxs) < 2 {
+			continue
+		}
+		g := DuplicateGroup{}
+		for _, i := range idxs {
+			g.Images = append(g.Images, images[i])
+		}
+		sort.Slice(g.Images, func(a, b int) bool {
+			return betterCopy(g.Images[a], g.Images[b])
+		})
+		g.Keep = g.Images[0].FilePath
+		for _, img := range g.Images[1:] {
+			g.WastedBytes += img.Size
+		}
+		groups = append(groups, g)
+	}
+
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].WastedBytes != groups[b].WastedBytes {
+			return groups[a].WastedBytes > groups[b].WastedBytes
+		}
+		return groups[a].Keep < groups[b].Keep
+	})
+	return groups
+}
+
+// betterCopy orders the copies in a group: highest resolution first, then
+// largest file, then shortest (usually the original) path.
+func betterCopy(a, b HashedImage) bool {
+	if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+		return pa > pb
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	if len(a.FilePath) != len(b.FilePath) {
+		return len(a.FilePath) < len(b.FilePath)
+	}
+	return a.FilePath < b.FilePath
+}
+
+// bkTree is a Burkhard-Keller tree over Hamming distance, so grouping a
+// library does not need to compare every pair of hashes.
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	hash     uint64
+	ids      []int
+	children map[int]*bkNode
+}
+
+func (t *bkTree) insert(hash uint64, id int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []int{id}}
+		return
+	}
+	n := t.root
+	for {
+		d := HammingDistance(hash, n.hash)
+		if d == 0 {
+			n.ids = append(n.ids, id)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[d] = &bkNode{hash: hash, ids: []int{id}}
+			return
+		}
+		n = child
+	}
+}
+
+func (t *bkTree) search(hash uint64, maxDistance int, fn func(id int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := HammingDistance(hash, n.hash)
+		if d <= maxDistance {
+			for _, id := range n.ids {
+				fn(id)
+			}
+		}
+		for cd, child := range n.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gallery
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// gradientImage draws a horizontal gradient with a dark block, so the hash
+// has a mix of set and unset bits.
+func gradientImage(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if x > w/3 && x < w/2 && y > h/4 && y < h/2 {
+				v = 10
+			}
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestDHashSimilarImages(t *testing.T) {
+	orig := gradientImage(640, 480, false)
+	resized := imaging.Resize(orig, 200, 150, imaging.Lanczos)
+	other := gradientImage(640, 480, true)
+
+	h1, h2, h3 := DHash(orig), DHash(resized), DHash(other)
+	if d := HammingDistance(h1, h2); d > 4 {
+		t.Errorf("resized copy distance = %d, want <= 4", d)
+	}
+	if d := HammingDistance(h1, h3); d < 20 {
+		t.Errorf("different image distance = %d, want >= 20", d)
+	}
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	images := []HashedImage{
+		{FilePath: "/a/small.jpg", Size: 100, Width: 800, Height: 600, Hash: 0xF0F0F0F0F0F0F0F0},
+		{FilePath: "/a/large.jpg", Size: 500, Width: 4000, Height: 3000, Hash: 0xF0F0F0F0F0F0F0F1},
+		{FilePath: "/backup/large.jpg", Size: 500, Width: 4000, Height: 3000, Hash: 0xF0F0F0F0F0F0F0F0},
+		{FilePath: "/c/other.jpg", Size: 900, Width: 100, Height: 100, Hash: 0x0F0F0F0F0F0F0F0F},
+		{FilePath: "/d/pair1.jpg", Size: 2000, Width: 100, Height: 100, Hash: 0x1234},
+		{FilePath: "/d/pair2.jpg", Size: 2000, Width: 100, Height: 100, Hash: 0x1234},
+	}
+
+	groups := GroupDuplicates(images, 2)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+
+	// Sorted by wasted bytes: pair (2000) before the triple (600)
+	if groups[0].WastedBytes != 2000 || groups[0].Keep != "/d/pair1.jpg" {
+		t.Errorf("group 0 = keep %s wasted %d", groups[0].Keep, groups[0].WastedBytes)
+	}
+	g := groups[1]
+	if len(g.Images) != 3 {
+		t.Fatalf("group 1 has %d images, want 3", len(g.Images))
+	}
+	// Highest resolution wins, then shortest path
+	if g.Keep != "/a/large.jpg" {
+		t.Errorf("keep = %s, want /a/large.jpg", g.Keep)
+	}
+	if g.WastedBytes != 600 {
+		t.Errorf("wasted = %d, want 600", g.WastedBytes)
+	}
+
+	if groups := GroupDuplicates(images, 0); len(groups) != 2 || len(groups[1].Images) != 2 {
+		t.Errorf("exact matching should split the near-duplicate off: %+v", groups)
+	}
+}
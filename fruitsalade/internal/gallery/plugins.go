@@ -4,31 +4,54 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
 	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 )
 
 const pluginTimeout = 30 * time.Second
+const pluginMaxOutput = 1 << 20 // 1 MB
 const minConfidence = 0.5
 
-// PluginCaller calls auto-tagging plugin webhooks.
+// execScheme marks a command plugin: its webhook_url is "exec:" followed
+// by the absolute path of the executable.
+const execScheme = "exec:"
+
+// PluginCaller calls auto-tagging plugins. A plugin is either a webhook,
+// which gets a JSON description of the image, or a command, which gets the
+// image bytes on stdin. Both answer with a PluginWebhookResponse.
 type PluginCaller struct {
-	client *http.Client
+	client    *http.Client
+	pluginDir string
 }
 
-// NewPluginCaller creates a new PluginCaller with a timeout.
-func NewPluginCaller() *PluginCaller {
+// NewPluginCaller creates a new PluginCaller. Calls are bounded by each
+// plugin's timeout. Command plugins run only if their executable is in
+// pluginDir; with pluginDir empty, none do.
+func NewPluginCaller(pluginDir string) *PluginCaller {
 	return &PluginCaller{
-		client: &http.Client{Timeout: pluginTimeout},
+		client:    &http.Client{},
+		pluginDir: pluginDir,
 	}
 }
 
-// PluginWebhookRequest is sent to plugin webhooks.
+// PluginWebhookRequest is sent to plugin webhooks. Command plugins get the
+// same fields as FRUITSALADE_* environment variables.
 type PluginWebhookRequest struct {
 	FilePath    string `json:"file_path"`
 	FileName    string `json:"file_name"`
@@ -48,8 +71,98 @@ type PluginTag struct {
 	Confidence float32 `json:"confidence"`
 }
 
+// PluginOptions are the per-plugin settings read from a plugin's config.
+type PluginOptions struct {
+	Timeout   time.Duration // per call
+	MaxOutput int64         // response/stdout limit in bytes
+
+	// Command plugins only
+	Args       []string          // arguments after the executable
+	Env        map[string]string // extra environment variables
+	Dir        string            // working directory ("" = the server's)
+	InheritEnv bool              // pass the server's environment (secrets included)
+	UID, GID   *int              // run as this user and group (Unix; needs root)
+}
+
+// pluginConfig is the JSON shape of the plugin config keys we read.
+type pluginConfig struct {
+	TimeoutSec     float64           `json:"timeout_sec"`
+	MaxOutputBytes int64             `json:"max_output_bytes"`
+	Args           []string          `json:"args"`
+	Env            map[string]string `json:"env"`
+	Dir            string            `json:"dir"`
+	InheritEnv     bool              `json:"inherit_env"`
+	UID            *int              `json:"uid"`
+	GID            *int              `json:"gid"`
+}
+
+// IsExecPlugin reports whether a plugin URL names a command plugin.
+func IsExecPlugin(webhookURL string) bool {
+	return strings.HasPrefix(webhookURL, execScheme)
+}
+
+// ParsePluginOptions reads the options of a plugin from its URL and
+// config, with defaults for unset keys. Unknown config keys are ignored, as
+// webhook plugins may use the config for their own settings. A command
+// plugin's executable must be in pluginDir, which the operator sets, so
+// that an admin account cannot run arbitrary commands on the server.
+func ParsePluginOptions(webhookURL string, config map[string]interface{}, pluginDir string) (*PluginOptions, error) {
+	var pc pluginConfig
+	if config != nil {
+		raw, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &pc); err != nil {
+			return nil, fmt.Errorf("invalid plugin config: %w", err)
+		}
+	}
+	if pc.TimeoutSec < 0 || pc.MaxOutputBytes < 0 {
+		return nil, errors.New("timeout_sec and max_output_bytes must not be negative")
+	}
+	if (pc.UID == nil) != (pc.GID == nil) {
+		return nil, errors.New("uid and gid must be set together")
+	}
+
+	opts := &PluginOptions{
+		Timeout:    pluginTimeout,
+		MaxOutput:  pluginMaxOutput,
+		Args:       pc.Args,
+		Env:        pc.Env,
+		Dir:        pc.Dir,
+		InheritEnv: pc.InheritEnv,
+		UID:        pc.UID,
+		GID:        pc.GID,
+	}
+	if pc.TimeoutSec > 0 {
+		opts.Timeout = time.Duration(pc.TimeoutSec * float64(time.Second))
+	}
+	if pc.MaxOutputBytes > 0 {
+		opts.MaxOutput = pc.MaxOutputBytes
+	}
+
+	if IsExecPlugin(webhookURL) {
+		exe := strings.TrimPrefix(webhookURL, execScheme)
+		if !path.IsAbs(exe) {
+			return nil, errors.New("command plugins need an absolute path, e.g. exec:/usr/local/bin/tagger")
+		}
+		if pluginDir == "" {
+			return nil, errors.New("command plugins are disabled; set GALLERY_PLUGIN_DIR to allow them")
+		}
+		if !strings.HasPrefix(path.Clean(exe), path.Clean(pluginDir)+"/") {
+			return nil, fmt.Errorf("command plugins must be in %s", pluginDir)
+		}
+		if opts.Dir != "" && !path.IsAbs(opts.Dir) {
+			return nil, errors.New("dir must be an absolute path")
+		}
+	} else if len(opts.Args) > 0 || len(opts.Env) > 0 || opts.Dir != "" || opts.InheritEnv || opts.UID != nil {
+		return nil, errors.New("args, env, dir, inherit_env, uid and gid only apply to exec: plugins")
+	}
+	return opts, nil
+}
+
 // CallPlugins calls all enabled plugins for a processed image and stores resulting tags.
-func (pc *PluginCaller) CallPlugins(ctx context.Context, store *GalleryStore, filePath, fileName string, size int64) {
+func (pc *PluginCaller) CallPlugins(ctx context.Context, store *GalleryStore, filePath string, content []byte) {
 	plugins, err := store.ListEnabledPlugins(ctx)
 	if err != nil {
 		logging.Warn("gallery: failed to list plugins", zap.Error(err))
@@ -62,19 +175,20 @@ func (pc *PluginCaller) CallPlugins(ctx context.Context, store *GalleryStore, fi
 
 	req := PluginWebhookRequest{
 		FilePath:    filePath,
-		FileName:    fileName,
+		FileName:    path.Base(filePath),
 		ContentType: contentTypeForExt(filePath),
-		Size:        size,
+		Size:        int64(len(content)),
 		ImageURL:    fmt.Sprintf("/api/v1/content/%s", filePath[1:]), // relative URL
 	}
 
 	for _, plugin := range plugins {
-		tags, err := pc.callPlugin(ctx, plugin, req)
+		tags, err := pc.callPlugin(ctx, plugin, req, content)
 		if err != nil {
 			logging.Warn("gallery: plugin call failed",
 				zap.String("plugin", plugin.Name),
 				zap.String("path", filePath),
 				zap.Error(err))
+			metrics.RecordGalleryPluginFailure(plugin.ID)
 			store.UpdatePluginHealth(ctx, plugin.ID, err.Error())
 			continue
 		}
@@ -89,25 +203,73 @@ func (pc *PluginCaller) CallPlugins(ctx context.Context, store *GalleryStore, fi
 	}
 }
 
-// TestPlugin sends a test request to a plugin webhook and returns the response.
-func (pc *PluginCaller) TestPlugin(ctx context.Context, plugin Plugin) (*PluginWebhookResponse, error) {
+// TestPlugin calls a plugin the way CallPlugins does, with a small sample
+// JPEG, and returns its response and how long it took.
+func (pc *PluginCaller) TestPlugin(ctx context.Context, plugin Plugin) (*PluginWebhookResponse, time.Duration, error) {
+	content, err := sampleJPEG()
+	if err != nil {
+		return nil, 0, err
+	}
 	req := PluginWebhookRequest{
 		FilePath:    "/test/image.jpg",
 		FileName:    "image.jpg",
 		ContentType: "image/jpeg",
-		Size:        1024,
+		Size:        int64(len(content)),
 		ImageURL:    "/api/v1/content/test/image.jpg",
 	}
 
-	tags, err := pc.callPlugin(ctx, plugin, req)
+	start := time.Now()
+	tags, err := pc.callPlugin(ctx, plugin, req, content)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, latency, err
+	}
+
+	return &PluginWebhookResponse{Tags: tags}, latency, nil
+}
+
+// sampleJPEG returns a small gradient image for plugin tests.
+func sampleJPEG() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (pc *PluginCaller) callPlugin(ctx context.Context, plugin Plugin, req PluginWebhookRequest, content []byte) ([]PluginTag, error) {
+	opts, err := ParsePluginOptions(plugin.WebhookURL, plugin.Config, pc.pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	var out []byte
+	if IsExecPlugin(plugin.WebhookURL) {
+		out, err = runExecPlugin(ctx, plugin, opts, req, content)
+	} else {
+		out, err = pc.callWebhook(ctx, plugin, opts, req)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &PluginWebhookResponse{Tags: tags}, nil
+	var resp PluginWebhookResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return resp.Tags, nil
 }
 
-func (pc *PluginCaller) callPlugin(ctx context.Context, plugin Plugin, req PluginWebhookRequest) ([]PluginTag, error) {
+func (pc *PluginCaller) callWebhook(ctx context.Context, plugin Plugin, opts *PluginOptions, req PluginWebhookRequest) ([]byte, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
@@ -121,6 +283,9 @@ func (pc *PluginCaller) callPlugin(ctx context.Context, plugin Plugin, req Plugi
 
 	resp, err := pc.client.Do(httpReq)
 	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("webhook timed out after %s", opts.Timeout)
+		}
 		return nil, fmt.Errorf("webhook call: %w", err)
 	}
 	defer resp.Body.Close()
@@ -129,14 +294,109 @@ func (pc *PluginCaller) callPlugin(ctx context.Context, plugin Plugin, req Plugi
 		return nil, fmt.Errorf("webhook returned %d", resp.StatusCode)
 	}
 
-	var webhookResp PluginWebhookResponse
-	if err := json.NewDecoder(resp.Body).Decode(&webhookResp); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+	out, err := io.ReadAll(io.LimitReader(resp.Body, opts.MaxOutput+1))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if int64(len(out)) > opts.MaxOutput {
+		return nil, fmt.Errorf("webhook response exceeds %d bytes", opts.MaxOutput)
+	}
+	return out, nil
+}
+
+// runExecPlugin runs a command plugin with the image on stdin and returns
+// its stdout. The plugin runs in its own process group, which is killed
+// as a whole when ctx expires.
+func runExecPlugin(ctx context.Context, plugin Plugin, opts *PluginOptions, req PluginWebhookRequest, content []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, strings.TrimPrefix(plugin.WebhookURL, execScheme), opts.Args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = pluginEnv(opts, req)
+	cmd.Stdin = bytes.NewReader(content)
+	stdout := &cappedBuffer{max: opts.MaxOutput}
+	stderr := &cappedBuffer{max: 4096}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := isolate(cmd, opts); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	fields := []zap.Field{
+		zap.String("plugin", plugin.Name),
+		zap.Int("plugin_id", plugin.ID),
+		zap.String("path", req.FilePath),
+		zap.Int("exit_code", exitCode),
+		zap.Duration("duration", time.Since(start)),
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		logging.Warn("gallery: plugin timed out", fields...)
+		return nil, fmt.Errorf("plugin timed out after %s", opts.Timeout)
+	case err != nil:
+		logging.Warn("gallery: plugin failed", append(fields, zap.Error(err))...)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("plugin exited with %d: %s", exitCode, msg)
+		}
+		return nil, fmt.Errorf("plugin: %w", err)
+	case stdout.overflow:
+		logging.Warn("gallery: plugin output too large", fields...)
+		return nil, fmt.Errorf("plugin output exceeds %d bytes", opts.MaxOutput)
+	}
+	logging.Debug("gallery: plugin finished", fields...)
+	return stdout.Bytes(), nil
+}
+
+// pluginEnv returns the environment of a command plugin: PATH (or the
+// server's whole environment with inherit_env), the request fields, then
+// the configured variables.
+func pluginEnv(opts *PluginOptions, req PluginWebhookRequest) []string {
+	var env []string
+	if opts.InheritEnv {
+		env = os.Environ()
+	} else {
+		env = []string{"PATH=" + os.Getenv("PATH")}
+	}
+	env = append(env,
+		"FRUITSALADE_FILE_PATH="+req.FilePath,
+		"FRUITSALADE_FILE_NAME="+req.FileName,
+		"FRUITSALADE_CONTENT_TYPE="+req.ContentType,
+		"FRUITSALADE_SIZE="+strconv.FormatInt(req.Size, 10),
+	)
+	for k, v := range opts.Env {
+		env = append(env, k+"="+v)
 	}
+	return env
+}
 
-	return webhookResp.Tags, nil
+// cappedBuffer keeps the first max bytes written to it and drops the rest,
+// so a runaway plugin cannot exhaust memory. (It must not embed
+// bytes.Buffer, whose ReadFrom would bypass the cap.)
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
 }
 
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		b.overflow = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) Bytes() []byte  { return b.buf.Bytes() }
+func (b *cappedBuffer) String() string { return b.buf.String() }
+
 func contentTypeForExt(path string) string {
 	switch {
 	case hasAnySuffix(path, ".jpg", ".jpeg"):
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package gallery
+
+import (
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// isolate only bounds the wait for output: process groups and user
+// switching are Unix features.
+func isolate(cmd *exec.Cmd, opts *PluginOptions) error {
+	if opts.UID != nil {
+		return errors.New("uid and gid are only supported on Unix")
+	}
+	cmd.WaitDelay = 5 * time.Second
+	return nil
+}
@@ -0,0 +1,132 @@
+package gallery
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePluginOptions(t *testing.T) {
+	opts, err := ParsePluginOptions("https://example.com/tag", map[string]interface{}{"model": "small"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != pluginTimeout || opts.MaxOutput != pluginMaxOutput {
+		t.Errorf("defaults = %v, %d", opts.Timeout, opts.MaxOutput)
+	}
+
+	opts, err = ParsePluginOptions("exec:/opt/tagger", map[string]interface{}{
+		"timeout_sec": 2.5,
+		"args":        []interface{}{"--fast"},
+		"env":         map[string]interface{}{"MODEL": "small"},
+		"dir":         "/opt",
+	}, "/opt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Timeout != 2500*time.Millisecond || len(opts.Args) != 1 || opts.Env["MODEL"] != "small" || opts.Dir != "/opt" {
+		t.Errorf("options = %+v", opts)
+	}
+
+	bad := []struct {
+		url, dir string
+		config   map[string]interface{}
+	}{
+		{"exec:tagger", "/opt", nil},
+		{"exec:/opt/tagger", "/opt", map[string]interface{}{"dir": "opt"}},
+		{"exec:/opt/tagger", "/opt", map[string]interface{}{"uid": 1000}},
+		{"exec:/opt/tagger", "/opt", map[string]interface{}{"timeout_sec": -1}},
+		{"exec:/opt/tagger", "/opt", map[string]interface{}{"args": "--fast"}},
+		{"https://example.com/tag", "/opt", map[string]interface{}{"args": []interface{}{"x"}}},
+		{"exec:/opt/tagger", "", nil},
+		{"exec:/bin/sh", "/opt", nil},
+		{"exec:/opt/../bin/sh", "/opt", nil},
+		{"exec:/optional/tagger", "/opt", nil},
+	}
+	for _, tt := range bad {
+		if _, err := ParsePluginOptions(tt.url, tt.config, tt.dir); err == nil {
+			t.Errorf("ParsePluginOptions(%q, %v, %q) succeeded", tt.url, tt.config, tt.dir)
+		}
+	}
+}
+
+// shPlugin returns a command plugin running script with /bin/sh.
+func shPlugin(t *testing.T, script string, config map[string]interface{}) Plugin {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	config["args"] = []interface{}{"-c", script}
+	return Plugin{ID: 1, Name: "sh", WebhookURL: "exec:/bin/sh", Config: config}
+}
+
+func TestExecPlugin(t *testing.T) {
+	t.Setenv("FRUITSALADE_TEST_SECRET", "hunter2")
+	plugin := shPlugin(t,
+		`n=$(wc -c | tr -d " "); printf '{"tags":[{"tag":"%s-%s-%s","confidence":0.9}]}' "$n" "$FRUITSALADE_FILE_NAME" "$MODEL$FRUITSALADE_TEST_SECRET"`,
+		map[string]interface{}{"env": map[string]interface{}{"MODEL": "small"}})
+
+	resp, latency, err := NewPluginCaller("/bin").TestPlugin(context.Background(), plugin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample, _ := sampleJPEG()
+	want := strconv.Itoa(len(sample)) + "-image.jpg-small"
+	if len(resp.Tags) != 1 || resp.Tags[0].Tag != want {
+		t.Errorf("tags = %+v, want %q (image on stdin, request in env, server env not inherited)", resp.Tags, want)
+	}
+	if latency <= 0 {
+		t.Errorf("latency = %v", latency)
+	}
+}
+
+func TestExecPluginFailures(t *testing.T) {
+	pc := NewPluginCaller("/bin")
+	req := PluginWebhookRequest{FilePath: "/a.jpg", FileName: "a.jpg"}
+
+	plugin := shPlugin(t, `echo "model missing" >&2; exit 3`, nil)
+	if _, err := pc.callPlugin(context.Background(), plugin, req, nil); err == nil || !strings.Contains(err.Error(), "exited with 3: model missing") {
+		t.Errorf("failing plugin: err = %v", err)
+	}
+
+	plugin = shPlugin(t, `head -c 5000 /dev/zero`, map[string]interface{}{"max_output_bytes": 100})
+	if _, err := pc.callPlugin(context.Background(), plugin, req, nil); err == nil || !strings.Contains(err.Error(), "exceeds 100 bytes") {
+		t.Errorf("chatty plugin: err = %v", err)
+	}
+
+	plugin = shPlugin(t, `echo not json`, nil)
+	if _, err := pc.callPlugin(context.Background(), plugin, req, nil); err == nil {
+		t.Error("plugin with invalid output succeeded")
+	}
+
+	// A plugin stored before GALLERY_PLUGIN_DIR was narrowed does not run
+	plugin = shPlugin(t, `echo '{"tags":[]}'`, nil)
+	if _, err := NewPluginCaller("/opt/plugins").callPlugin(context.Background(), plugin, req, nil); err == nil || !strings.Contains(err.Error(), "must be in /opt/plugins") {
+		t.Errorf("plugin outside the plugin dir: err = %v", err)
+	}
+}
+
+func TestExecPluginTimeoutKillsGroup(t *testing.T) {
+	if _, err := os.Stat("/bin/sleep"); err != nil {
+		t.Skip("needs /bin/sleep")
+	}
+	// The background sleep keeps stdout open; unless the whole group is
+	// killed the call waits for it
+	plugin := shPlugin(t, `/bin/sleep 30 & /bin/sleep 30`, map[string]interface{}{"timeout_sec": 0.2})
+
+	start := time.Now()
+	_, err := NewPluginCaller("/bin").callPlugin(context.Background(), plugin, PluginWebhookRequest{FilePath: "/a.jpg"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("call took %v after timing out", d)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package gallery
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// isolate puts a command plugin in its own process group, so that a
+// timeout kills anything it started too, and drops to the configured user.
+func isolate(cmd *exec.Cmd, opts *PluginOptions) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if opts.UID != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(*opts.UID), Gid: uint32(*opts.GID)}
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait on output pipes held open by a process that escaped
+	// the group
+	cmd.WaitDelay = 5 * time.Second
+	return nil
+}
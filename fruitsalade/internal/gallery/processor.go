@@ -3,14 +3,19 @@ package gallery
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
 )
 
@@ -33,15 +38,31 @@ func IsImageFile(path string) bool {
 	return false
 }
 
-// Processor handles background image processing (EXIF extraction, thumbnails, plugin calls).
+// Processor handles background image processing (EXIF extraction, thumbnails,
+// perceptual hashes, plugin calls).
+// Videos go through a separate, smaller queue so that slow probing and frame
+// extraction never hold up image processing. A file that fails is retried
+// with backoff, and dead-lettered after maxAttempts failures.
 type Processor struct {
 	store         *GalleryStore
 	storageRouter *storage.Router
 	pluginCaller  *PluginCaller
+	video         *VideoProber
 	queue         chan string
+	videoQueue    chan string
 	wg            sync.WaitGroup
 	cancel        context.CancelFunc
 	workers       int
+	videoWorkers  int
+	maxAttempts   int
+	stackWindow   time.Duration // 0 = no burst stacks
+	onProcessed   func(ctx context.Context, meta *ImageMetadata)
+	loops         sync.WaitGroup // retryLoop, stopped before the queues close
+
+	// For Drain: files queued or being processed, and the latter by path
+	pending  atomic.Int64
+	activeMu sync.Mutex
+	active   map[string]struct{}
 }
 
 // NewProcessor creates a new image processor.
@@ -53,19 +74,63 @@ func NewProcessor(store *GalleryStore, router *storage.Router, pluginCaller *Plu
 		store:         store,
 		storageRouter: router,
 		pluginCaller:  pluginCaller,
+		video:         NewVideoProber(),
 		queue:         make(chan string, 1000),
+		videoQueue:    make(chan string, 200),
+		active:        make(map[string]struct{}),
 		workers:       workers,
+		videoWorkers:  1,
+		maxAttempts:   DefaultMaxAttempts,
+		stackWindow:   DefaultStackWindow,
+	}
+}
+
+// SetMaxAttempts sets how often a file is tried before it is dead-lettered.
+// It must be set before Start.
+func (p *Processor) SetMaxAttempts(n int) {
+	if n > 0 {
+		p.maxAttempts = n
+	}
+}
+
+// SetStackWindow sets the maximum time between two photos of a burst
+// stack; 0 turns stacking off. It must be set before Start.
+func (p *Processor) SetStackWindow(d time.Duration) {
+	if d >= 0 {
+		p.stackWindow = d
 	}
 }
 
+// StackWindow returns the burst stack window, 0 if stacking is off.
+func (p *Processor) StackWindow() time.Duration {
+	return p.stackWindow
+}
+
+// SetOnProcessed registers a callback run after a file's metadata is saved
+// (used to auto-organize uploads by date taken). It must be set before files
+// are queued.
+func (p *Processor) SetOnProcessed(fn func(ctx context.Context, meta *ImageMetadata)) {
+	p.onProcessed = fn
+}
+
 // Start launches the worker goroutines.
 func (p *Processor) Start(ctx context.Context) {
 	ctx, p.cancel = context.WithCancel(ctx)
 	for i := 0; i < p.workers; i++ {
 		p.wg.Add(1)
-		go p.worker(ctx)
+		go p.worker(ctx, p.queue, p.processImage)
+	}
+	for i := 0; i < p.videoWorkers; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx, p.videoQueue, p.processVideo)
 	}
-	logging.Info("gallery processor started", zap.Int("workers", p.workers))
+	p.loops.Add(1)
+	go p.retryLoop(ctx)
+	logging.Info("gallery processor started",
+		zap.Int("workers", p.workers),
+		zap.Int("max_attempts", p.maxAttempts),
+		zap.Int("video_workers", p.videoWorkers),
+		zap.Bool("video_posters", p.video.CanExtractPoster()))
 }
 
 // Stop signals workers to stop and waits for them to finish.
@@ -73,11 +138,66 @@ func (p *Processor) Stop() {
 	if p.cancel != nil {
 		p.cancel()
 	}
+	p.loops.Wait()
 	close(p.queue)
+	close(p.videoQueue)
 	p.wg.Wait()
 	logging.Info("gallery processor stopped")
 }
 
+// Drain waits until both queues are empty and no file is being processed,
+// or until ctx is done. On timeout the workers are stopped and every queued
+// or interrupted file is reset to 'pending' so ProcessExisting picks it up
+// on the next start. It reports whether the queues drained. Stop must still
+// be called afterwards.
+func (p *Processor) Drain(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !p.idle() {
+		select {
+		case <-ctx.Done():
+			p.checkpoint()
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+func (p *Processor) idle() bool {
+	return p.pending.Load() == 0
+}
+
+// checkpoint stops the workers and marks unfinished files as pending.
+func (p *Processor) checkpoint() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.loops.Wait()
+	p.wg.Wait()
+
+	p.activeMu.Lock()
+	var paths []string
+	for path := range p.active {
+		paths = append(paths, path)
+	}
+	p.activeMu.Unlock()
+	for _, q := range []chan string{p.queue, p.videoQueue} {
+		for len(q) > 0 {
+			paths = append(paths, <-q)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, path := range paths {
+		if err := p.store.SetStatus(ctx, path, "pending"); err != nil {
+			logging.Warn("gallery: failed to checkpoint file", zap.String("path", path), zap.Error(err))
+		}
+	}
+	logging.Info("gallery processor checkpointed unfinished files", zap.Int("count", len(paths)))
+}
+
 // Enqueue adds a file path to the processing queue.
 func (p *Processor) Enqueue(filePath string) {
 	// Create a pending row so we track it
@@ -86,17 +206,38 @@ func (p *Processor) Enqueue(filePath string) {
 		return
 	}
 
+	if !p.push(filePath) {
+		logging.Warn("gallery processor queue full, dropping", zap.String("path", filePath))
+	}
+}
+
+// push adds a file to its queue without blocking and reports whether there
+// was room.
+func (p *Processor) push(filePath string) bool {
+	p.pending.Add(1)
 	select {
-	case p.queue <- filePath:
+	case p.queueFor(filePath) <- filePath:
+		return true
 	default:
-		logging.Warn("gallery processor queue full, dropping", zap.String("path", filePath))
+		p.pending.Add(-1)
+		return false
 	}
 }
 
-// ProcessExisting finds all unprocessed images and enqueues them.
+// queueFor returns the queue a file is processed on.
+func (p *Processor) queueFor(filePath string) chan string {
+	if IsVideoFile(filePath) {
+		return p.videoQueue
+	}
+	return p.queue
+}
+
+// ProcessExisting finds all unprocessed images and videos and enqueues them.
+// Files waiting for a retry or dead-lettered are left alone.
 func (p *Processor) ProcessExisting(ctx context.Context) {
-	// First, check for images in files table with no image_metadata row
-	unprocessed, err := p.store.ListUnprocessedImages(ctx, imageExtensions, 1000)
+	// First, check for media in files table with no image_metadata row
+	extensions := append(append([]string{}, imageExtensions...), videoExtensions...)
+	unprocessed, err := p.store.ListUnprocessedImages(ctx, extensions, 1000)
 	if err != nil {
 		logging.Warn("failed to list unprocessed images", zap.Error(err))
 		return
@@ -112,10 +253,7 @@ func (p *Processor) ProcessExisting(ctx context.Context) {
 		return
 	}
 	for _, path := range pending {
-		select {
-		case p.queue <- path:
-		default:
-		}
+		p.push(path)
 	}
 
 	total := len(unprocessed) + len(pending)
@@ -124,25 +262,110 @@ func (p *Processor) ProcessExisting(ctx context.Context) {
 	}
 }
 
-func (p *Processor) worker(ctx context.Context) {
+// Retry requeues dead-lettered or retrying files with a fresh set of
+// attempts and returns the paths that were requeued.
+func (p *Processor) Retry(ctx context.Context, paths []string) ([]string, error) {
+	reset, err := p.store.ResetFailures(ctx, paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range reset {
+		if !p.push(path) {
+			// Stays pending for ProcessExisting on the next start
+			logging.Warn("gallery processor queue full, retry deferred", zap.String("path", path))
+		}
+	}
+	return reset, nil
+}
+
+// retryInterval is how often due retries are requeued and the queue
+// metrics refreshed.
+const retryInterval = 30 * time.Second
+
+// retryLoop requeues files whose retry is due and keeps the queue and
+// failure gauges current.
+func (p *Processor) retryLoop(ctx context.Context) {
+	defer p.loops.Done()
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		paths, err := p.store.ClaimDueRetries(ctx, 100)
+		if err != nil {
+			logging.Warn("gallery: failed to claim retries", zap.Error(err))
+		}
+		for _, path := range paths {
+			if !p.push(path) {
+				// Still due, so the next tick picks it up again
+				p.store.SetStatus(ctx, path, StatusRetry)
+			}
+		}
+
+		metrics.SetGalleryQueueDepth("image", len(p.queue))
+		metrics.SetGalleryQueueDepth("video", len(p.videoQueue))
+		if retrying, failed, err := p.store.CountFailures(ctx); err == nil {
+			metrics.SetGalleryFailures(retrying, failed)
+		}
+	}
+}
+
+// fail records a failed attempt at processing a file.
+func (p *Processor) fail(ctx context.Context, filePath string, err error) {
+	metrics.RecordGalleryAttemptFailed()
+	status, rerr := p.store.RecordFailure(ctx, filePath, err.Error(), p.maxAttempts)
+	if rerr != nil {
+		logging.Warn("gallery: failed to record failure",
+			zap.String("path", filePath), zap.NamedError("cause", err), zap.Error(rerr))
+		return
+	}
+	if status == StatusFailed {
+		logging.Warn("gallery: giving up on file",
+			zap.String("path", filePath), zap.Int("attempts", p.maxAttempts), zap.Error(err))
+		return
+	}
+	logging.Warn("gallery: processing failed, will retry", zap.String("path", filePath), zap.Error(err))
+}
+
+func (p *Processor) worker(ctx context.Context, queue <-chan string, process func(context.Context, string) error) {
 	defer p.wg.Done()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case filePath, ok := <-p.queue:
+		case filePath, ok := <-queue:
 			if !ok {
 				return
 			}
-			p.processImage(ctx, filePath)
+			p.activeMu.Lock()
+			p.active[filePath] = struct{}{}
+			p.activeMu.Unlock()
+			err := process(ctx, filePath)
+			if ctx.Err() != nil {
+				// Interrupted: leave it in active for checkpoint
+				return
+			}
+			if err != nil {
+				p.fail(ctx, filePath, err)
+			}
+			p.activeMu.Lock()
+			delete(p.active, filePath)
+			p.activeMu.Unlock()
+			p.pending.Add(-1)
 		}
 	}
 }
 
-func (p *Processor) processImage(ctx context.Context, filePath string) {
+// processImage extracts an image's metadata and thumbnail. Errors are
+// failed attempts; problems that retrying cannot fix, such as missing EXIF
+// data, are only logged.
+func (p *Processor) processImage(ctx context.Context, filePath string) error {
 	if err := p.store.SetStatus(ctx, filePath, "processing"); err != nil {
-		logging.Warn("gallery: failed to set processing status", zap.String("path", filePath), zap.Error(err))
-		return
+		return fmt.Errorf("set processing status: %w", err)
 	}
 
 	s3Key := strings.TrimPrefix(filePath, "/")
@@ -150,25 +373,19 @@ func (p *Processor) processImage(ctx context.Context, filePath string) {
 	// Resolve storage backend
 	backend, _, err := p.storageRouter.GetDefault()
 	if err != nil {
-		logging.Warn("gallery: no default backend", zap.Error(err))
-		p.store.SetStatus(ctx, filePath, "failed")
-		return
+		return fmt.Errorf("no default backend: %w", err)
 	}
 
 	// Read the file content
 	reader, _, err := backend.GetObject(ctx, s3Key, 0, 0)
 	if err != nil {
-		logging.Warn("gallery: failed to read file", zap.String("path", filePath), zap.Error(err))
-		p.store.SetStatus(ctx, filePath, "failed")
-		return
+		return fmt.Errorf("read file: %w", err)
 	}
 
 	content, err := io.ReadAll(reader)
 	reader.Close()
 	if err != nil {
-		logging.Warn("gallery: failed to read content", zap.String("path", filePath), zap.Error(err))
-		p.store.SetStatus(ctx, filePath, "failed")
-		return
+		return fmt.Errorf("read content: %w", err)
 	}
 
 	// Extract EXIF
@@ -181,6 +398,7 @@ func (p *Processor) processImage(ctx context.Context, filePath string) {
 
 	meta := &ImageMetadata{
 		FilePath:        filePath,
+		MediaType:       MediaTypeImage,
 		CameraMake:      exifData.CameraMake,
 		CameraModel:     exifData.CameraModel,
 		LensModel:       exifData.LensModel,
@@ -205,22 +423,28 @@ func (p *Processor) processImage(ctx context.Context, filePath string) {
 	canThumbnail := ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" || ext == ".webp" || ext == ".bmp"
 
 	if canThumbnail {
-		thumbBytes, w, h, err := GenerateThumbnail(bytes.NewReader(content), exifData.Orientation)
+		// A format we can decode that fails to decode is corrupt or
+		// truncated, e.g. by an upload still in flight
+		thumbBytes, _, _, err := GenerateThumbnail(bytes.NewReader(content), exifData.Orientation)
 		if err != nil {
-			logging.Warn("gallery: thumbnail generation failed", zap.String("path", filePath), zap.Error(err))
-		} else {
-			// Store thumbnail
-			thumbKey := ThumbS3Key(s3Key)
-			if err := backend.PutObject(ctx, thumbKey, bytes.NewReader(thumbBytes), int64(len(thumbBytes))); err != nil {
-				logging.Warn("gallery: failed to store thumbnail", zap.String("path", filePath), zap.Error(err))
-			} else {
-				meta.HasThumbnail = true
-				meta.ThumbS3Key = thumbKey
-			}
-			_ = w
-			_ = h
+			return fmt.Errorf("generate thumbnail: %w", err)
 		}
 
+		// Perceptual hash for duplicate detection; the orientation-corrected
+		// thumbnail is plenty of detail and much cheaper than the original
+		if hash, err := DHashReader(bytes.NewReader(thumbBytes)); err == nil {
+			meta.PHash = &hash
+		}
+
+		// Store thumbnail
+		thumbKey := ThumbS3Key(s3Key)
+		if err := backend.PutObject(ctx, thumbKey, bytes.NewReader(thumbBytes), int64(len(thumbBytes))); err != nil {
+			return fmt.Errorf("store thumbnail: %w", err)
+		}
+		meta.HasThumbnail = true
+		meta.ThumbS3Key = thumbKey
+		meta.ThumbSize = int64(len(thumbBytes))
+
 		// Get full image dimensions (from EXIF or decoded)
 		if exifData.Width > 0 && exifData.Height > 0 {
 			meta.Width = exifData.Width
@@ -235,13 +459,26 @@ func (p *Processor) processImage(ctx context.Context, filePath string) {
 
 	// Save metadata
 	if err := p.store.UpsertMetadata(ctx, meta); err != nil {
-		logging.Warn("gallery: failed to save metadata", zap.String("path", filePath), zap.Error(err))
-		return
+		return fmt.Errorf("save metadata: %w", err)
+	}
+
+	// A stale stack only costs a cluttered timeline, so this is not a
+	// failed attempt
+	if p.stackWindow > 0 && meta.DateTaken != nil {
+		if err := p.store.UpdateStacks(ctx, filePath, p.stackWindow); err != nil {
+			logging.Warn("gallery: failed to update burst stacks", zap.String("path", filePath), zap.Error(err))
+		}
 	}
 
-	// Call plugins
+	// Call plugins; their failures are tracked as plugin health rather
+	// than failing the file
 	if p.pluginCaller != nil {
-		p.pluginCaller.CallPlugins(ctx, p.store, filePath, filepath.Base(filePath), int64(len(content)))
+		p.pluginCaller.CallPlugins(ctx, p.store, filePath, content)
+	}
+
+	// Last, since it may move the file
+	if p.onProcessed != nil {
+		p.onProcessed(ctx, meta)
 	}
 
 	logging.Debug("gallery: processed image",
@@ -249,4 +486,114 @@ func (p *Processor) processImage(ctx context.Context, filePath string) {
 		zap.Bool("thumbnail", meta.HasThumbnail),
 		zap.Int("width", meta.Width),
 		zap.Int("height", meta.Height))
+	return nil
+}
+
+// processVideo probes a video and extracts its poster frame. As with
+// images, errors are failed attempts.
+func (p *Processor) processVideo(ctx context.Context, filePath string) error {
+	if err := p.store.SetStatus(ctx, filePath, "processing"); err != nil {
+		return fmt.Errorf("set processing status: %w", err)
+	}
+
+	s3Key := strings.TrimPrefix(filePath, "/")
+
+	backend, _, err := p.storageRouter.GetDefault()
+	if err != nil {
+		return fmt.Errorf("no default backend: %w", err)
+	}
+
+	// ffprobe/ffmpeg and the atom parser need random access, so spool the
+	// video to a temp file instead of holding it in memory.
+	tmpPath, err := SpoolToTemp(ctx, backend, s3Key)
+	if err != nil {
+		return fmt.Errorf("read video: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	meta := &ImageMetadata{
+		FilePath:    filePath,
+		MediaType:   MediaTypeVideo,
+		Orientation: 1,
+		Status:      "done",
+	}
+
+	info, err := p.video.Probe(ctx, tmpPath)
+	if err != nil {
+		logging.Warn("gallery: video probe failed", zap.String("path", filePath), zap.Error(err))
+		// Continue anyway — the video still shows up in the gallery
+		info = &VideoInfo{}
+	}
+	meta.Duration = info.Duration
+	meta.Width = info.Width
+	meta.Height = info.Height
+	meta.DateTaken = info.CreatedAt
+	meta.CameraMake = info.CameraMake
+	meta.CameraModel = info.CameraModel
+	meta.Latitude = info.Latitude
+	meta.Longitude = info.Longitude
+	meta.Altitude = info.Altitude
+
+	// Poster frame thumbnail
+	if p.video.CanExtractPoster() {
+		frame, err := p.video.PosterFrame(ctx, tmpPath, info.Duration)
+		if err != nil {
+			logging.Warn("gallery: poster frame extraction failed", zap.String("path", filePath), zap.Error(err))
+		} else if thumbBytes, _, _, err := GenerateThumbnail(bytes.NewReader(frame), 1); err != nil {
+			logging.Warn("gallery: thumbnail generation failed", zap.String("path", filePath), zap.Error(err))
+		} else {
+			thumbKey := ThumbS3Key(s3Key)
+			if err := backend.PutObject(ctx, thumbKey, bytes.NewReader(thumbBytes), int64(len(thumbBytes))); err != nil {
+				logging.Warn("gallery: failed to store thumbnail", zap.String("path", filePath), zap.Error(err))
+			} else {
+				meta.HasThumbnail = true
+				meta.ThumbS3Key = thumbKey
+				meta.ThumbSize = int64(len(thumbBytes))
+			}
+		}
+	}
+
+	if err := p.store.UpsertMetadata(ctx, meta); err != nil {
+		return fmt.Errorf("save metadata: %w", err)
+	}
+
+	// Tagging plugins expect image payloads, so videos are not sent to them.
+
+	if p.onProcessed != nil {
+		p.onProcessed(ctx, meta)
+	}
+
+	logging.Debug("gallery: processed video",
+		zap.String("path", filePath),
+		zap.Bool("thumbnail", meta.HasThumbnail),
+		zap.Float64("duration", meta.Duration),
+		zap.Int("width", meta.Width),
+		zap.Int("height", meta.Height))
+	return nil
+}
+
+// SpoolToTemp copies an object into a temp file that keeps the original
+// extension (ffprobe uses it as a format hint) and returns its path. The
+// caller removes the file.
+func SpoolToTemp(ctx context.Context, backend storage.Backend, s3Key string) (string, error) {
+	reader, _, err := backend.GetObject(ctx, s3Key, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp("", "gallery-*"+strings.ToLower(filepath.Ext(s3Key)))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
 }
@@ -0,0 +1,149 @@
+package gallery
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+)
+
+// Processing statuses of an image_metadata row besides 'pending',
+// 'processing' and 'done'. A file that fails is retried with exponential
+// backoff and dead-lettered once it has used up its attempts.
+const (
+	StatusRetry  = "retry"  // failed, retried at next_retry_at
+	StatusFailed = "failed" // gave up; only an admin requeues it
+)
+
+// Retry defaults.
+const (
+	DefaultMaxAttempts = 5
+	retryBaseDelay     = time.Minute
+	retryMaxDelay      = 6 * time.Hour
+)
+
+// RetryDelay returns how long to wait before retrying a file that has
+// failed attempts times: retryBaseDelay doubled per earlier failure,
+// capped at retryMaxDelay.
+func RetryDelay(attempts int) time.Duration {
+	return retry.Backoff(attempts, retryBaseDelay, retryMaxDelay)
+}
+
+// ProcessingFailure is a dead-lettered file.
+type ProcessingFailure struct {
+	FilePath  string    `json:"file_path"`
+	MediaType string    `json:"media_type"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// RecordFailure counts a failed attempt at processing a file. It puts the
+// file in 'retry' with its next retry time, or in 'failed' once it has
+// failed maxAttempts times, and returns the new status.
+func (s *GalleryStore) RecordFailure(ctx context.Context, filePath, errMsg string, maxAttempts int) (string, error) {
+	var attempts int
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE image_metadata SET attempts = attempts + 1, last_error = $2, updated_at = NOW()
+		WHERE file_path = $1
+		RETURNING attempts`, filePath, errMsg).Scan(&attempts)
+	if err != nil {
+		return "", err
+	}
+
+	if attempts >= maxAttempts {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE image_metadata SET status = $2, next_retry_at = NULL WHERE file_path = $1`,
+			filePath, StatusFailed)
+		return StatusFailed, err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE image_metadata SET status = $2, next_retry_at = $3 WHERE file_path = $1`,
+		filePath, StatusRetry, time.Now().Add(RetryDelay(attempts)))
+	return StatusRetry, err
+}
+
+// ClaimDueRetries moves up to limit files whose retry is due back to
+// 'pending' and returns their paths.
+func (s *GalleryStore) ClaimDueRetries(ctx context.Context, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE image_metadata SET status = 'pending', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM image_metadata
+			WHERE status = $1 AND next_retry_at <= NOW()
+			ORDER BY next_retry_at LIMIT $2
+		)
+		RETURNING file_path`, StatusRetry, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+// ListFailures returns dead-lettered files, most recent first.
+func (s *GalleryStore) ListFailures(ctx context.Context, limit int) ([]ProcessingFailure, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT file_path, media_type, attempts, last_error, updated_at
+		FROM image_metadata WHERE status = $1
+		ORDER BY updated_at DESC LIMIT $2`, StatusFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []ProcessingFailure{}
+	for rows.Next() {
+		var f ProcessingFailure
+		if err := rows.Scan(&f.FilePath, &f.MediaType, &f.Attempts, &f.LastError, &f.FailedAt); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// ResetFailures moves the given dead-lettered or retrying files back to
+// 'pending' with a fresh set of attempts and returns the paths that were
+// reset.
+func (s *GalleryStore) ResetFailures(ctx context.Context, paths []string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE image_metadata
+		SET status = 'pending', attempts = 0, last_error = '', next_retry_at = NULL, updated_at = NOW()
+		WHERE file_path = ANY($1) AND status IN ($2, $3)
+		RETURNING file_path`, pq.Array(paths), StatusFailed, StatusRetry)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reset []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		reset = append(reset, p)
+	}
+	return reset, rows.Err()
+}
+
+// CountFailures returns the number of files waiting for a retry and the
+// number of dead-lettered files.
+func (s *GalleryStore) CountFailures(ctx context.Context) (retrying, failed int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status = $1), COUNT(*) FILTER (WHERE status = $2)
+		FROM image_metadata`, StatusRetry, StatusFailed).Scan(&retrying, &failed)
+	return retrying, failed, err
+}
@@ -18,11 +18,15 @@ type SearchParams struct {
 	CameraModel string
 	Country     string
 	City        string
+	MediaType   string // "image", "video" or "" for both
 	SortBy      string // "date", "name", "size"
 	SortOrder   string // "asc", "desc"
 	Limit       int
 	Offset      int
 
+	// CollapseStacks returns one photo per burst stack, its first match
+	CollapseStacks bool
+
 	// Permission context
 	UserID       int
 	UserGroupIDs []int
@@ -47,6 +51,10 @@ type SearchResult struct {
 	LocationCity    string
 	LocationCountry string
 	HasThumbnail    bool
+	MediaType       string
+	Duration        float64
+	StackID         *int
+	StackCount      int // matches in the stack; only with CollapseStacks
 }
 
 // PermFilter holds a SQL WHERE fragment and its arguments for permission filtering.
@@ -160,6 +168,13 @@ func (s *GalleryStore) Search(ctx context.Context, p *SearchParams) ([]SearchRes
 		argN++
 	}
 
+	// Media type filter
+	if p.MediaType != "" {
+		conditions = append(conditions, fmt.Sprintf("im.media_type = $%d", argN))
+		args = append(args, p.MediaType)
+		argN++
+	}
+
 	// Tag filter — require all specified tags
 	if len(p.Tags) > 0 {
 		for _, tag := range p.Tags {
@@ -172,11 +187,15 @@ func (s *GalleryStore) Search(ctx context.Context, p *SearchParams) ([]SearchRes
 
 	where := strings.Join(conditions, " AND ")
 
-	// Count total
+	// Count total; a stack counts once when collapsed
+	counted := "*"
+	if p.CollapseStacks {
+		counted = "DISTINCT " + stackKey
+	}
 	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*) FROM files f
+		SELECT COUNT(%s) FROM files f
 		JOIN image_metadata im ON im.file_path = f.path
-		WHERE %s`, where)
+		WHERE %s`, counted, where)
 
 	var total int
 	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
@@ -198,16 +217,33 @@ func (s *GalleryStore) Search(ctx context.Context, p *SearchParams) ([]SearchRes
 
 	// Select
 	selectQuery := fmt.Sprintf(`
-		SELECT f.path, f.name, f.size, f.mod_time, f.hash,
-			im.width, im.height, im.camera_make, im.camera_model,
-			im.date_taken, im.latitude, im.longitude,
-			im.location_city, im.location_country, im.has_thumbnail
+		SELECT `+searchColumns+`, 0
 		FROM files f
 		JOIN image_metadata im ON im.file_path = f.path
 		WHERE %s
 		ORDER BY %s %s%s
 		LIMIT $%d OFFSET $%d`,
 		where, orderCol, p.SortOrder, nullsLast, argN, argN+1)
+	if p.CollapseStacks {
+		// Rank the matches of each stack by date and keep the first
+		selectQuery = fmt.Sprintf(`
+		SELECT path, name, size, mod_time, hash, width, height, camera_make, camera_model,
+			date_taken, latitude, longitude, location_city, location_country, has_thumbnail,
+			media_type, duration, stack_id, stack_count
+		FROM (
+			SELECT `+searchColumns+`,
+				COUNT(*) OVER (PARTITION BY %[1]s) AS stack_count,
+				ROW_NUMBER() OVER (PARTITION BY %[1]s ORDER BY im.date_taken, im.id) AS stack_rank,
+				%[2]s AS sort_key
+			FROM files f
+			JOIN image_metadata im ON im.file_path = f.path
+			WHERE %[3]s
+		) matches
+		WHERE stack_rank = 1
+		ORDER BY sort_key %[4]s%[5]s
+		LIMIT $%[6]d OFFSET $%[7]d`,
+			stackKey, orderCol, where, p.SortOrder, nullsLast, argN, argN+1)
+	}
 
 	args = append(args, p.Limit, p.Offset)
 
@@ -225,6 +261,7 @@ func (s *GalleryStore) Search(ctx context.Context, p *SearchParams) ([]SearchRes
 			&r.Width, &r.Height, &r.CameraMake, &r.CameraModel,
 			&r.DateTaken, &r.Latitude, &r.Longitude,
 			&r.LocationCity, &r.LocationCountry, &r.HasThumbnail,
+			&r.MediaType, &r.Duration, &r.StackID, &r.StackCount,
 		); err != nil {
 			return nil, 0, fmt.Errorf("scan: %w", err)
 		}
@@ -234,6 +271,54 @@ func (s *GalleryStore) Search(ctx context.Context, p *SearchParams) ([]SearchRes
 	return results, total, rows.Err()
 }
 
+// searchColumns are the columns of a SearchResult up to StackCount.
+const searchColumns = `f.path, f.name, f.size, f.mod_time, f.hash,
+			im.width, im.height, im.camera_make, im.camera_model,
+			im.date_taken, im.latitude, im.longitude,
+			im.location_city, im.location_country, im.has_thumbnail,
+			im.media_type, im.duration, im.stack_id`
+
+// stackKey partitions photos by stack; photos in no stack are their own.
+const stackKey = "COALESCE(im.stack_id, -im.id)"
+
+// ListStack returns the processed members of a burst stack, oldest first.
+// pf, if not nil, must start its placeholders at $2.
+func (s *GalleryStore) ListStack(ctx context.Context, stackID int, pf *PermFilter) ([]SearchResult, error) {
+	args := []interface{}{stackID}
+	permWhere := ""
+	if pf != nil {
+		permWhere = " AND " + pf.Condition
+		args = append(args, pf.Args...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+searchColumns+`, 0
+		FROM files f
+		JOIN image_metadata im ON im.file_path = f.path
+		WHERE im.stack_id = $1 AND im.status = 'done' AND f.deleted_at IS NULL`+permWhere+`
+		ORDER BY im.date_taken, im.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list stack: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(
+			&r.FilePath, &r.FileName, &r.Size, &r.ModTime, &r.Hash,
+			&r.Width, &r.Height, &r.CameraMake, &r.CameraModel,
+			&r.DateTaken, &r.Latitude, &r.Longitude,
+			&r.LocationCity, &r.LocationCountry, &r.HasThumbnail,
+			&r.MediaType, &r.Duration, &r.StackID, &r.StackCount,
+		); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
 // ─── Album Queries ──────────────────────────────────────────────────────────
 
 // DateAlbumRow holds a year/month/count grouping.
@@ -243,7 +328,7 @@ type DateAlbumRow struct {
 	Count int
 }
 
-// GetAlbumsByDate returns images grouped by year and month.
+// GetAlbumsByDate returns images and videos grouped by year and month.
 func (s *GalleryStore) GetAlbumsByDate(ctx context.Context, pf *PermFilter) ([]DateAlbumRow, error) {
 	var args []interface{}
 	join := ""
@@ -285,7 +370,7 @@ type LocationAlbumRow struct {
 	Count   int
 }
 
-// GetAlbumsByLocation returns images grouped by country and city.
+// GetAlbumsByLocation returns images and videos grouped by country and city.
 func (s *GalleryStore) GetAlbumsByLocation(ctx context.Context, pf *PermFilter) ([]LocationAlbumRow, error) {
 	var args []interface{}
 	join := ""
@@ -327,7 +412,7 @@ type CameraAlbumRow struct {
 	Count int
 }
 
-// GetAlbumsByCamera returns images grouped by camera make and model.
+// GetAlbumsByCamera returns images and videos grouped by camera make and model.
 func (s *GalleryStore) GetAlbumsByCamera(ctx context.Context, pf *PermFilter) ([]CameraAlbumRow, error) {
 	var args []interface{}
 	join := ""
@@ -495,11 +580,49 @@ func (s *GalleryStore) ImageExistsInDB(ctx context.Context, filePath string) boo
 // EnsureRow creates a pending image_metadata row if one doesn't exist.
 func (s *GalleryStore) EnsureRow(ctx context.Context, filePath string) error {
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO image_metadata (file_path, status) VALUES ($1, 'pending')
-		ON CONFLICT (file_path) DO NOTHING`, filePath)
+		INSERT INTO image_metadata (file_path, status, media_type) VALUES ($1, 'pending', $2)
+		ON CONFLICT (file_path) DO NOTHING`, filePath, MediaTypeFor(filePath))
 	if err != nil && strings.Contains(err.Error(), "violates foreign key") {
 		// File doesn't exist in files table (race condition)
 		return sql.ErrNoRows
 	}
 	return err
 }
+
+// ─── Duplicates ─────────────────────────────────────────────────────────────
+
+// ListHashedImages returns all processed, non-trashed images that have a
+// perceptual hash, for duplicate grouping.
+func (s *GalleryStore) ListHashedImages(ctx context.Context, pf *PermFilter) ([]HashedImage, error) {
+	var args []interface{}
+	permWhere := ""
+	if pf != nil {
+		permWhere = " AND " + pf.Condition
+		args = pf.Args
+	}
+
+	query := fmt.Sprintf(`
+		SELECT f.path, f.size, im.width, im.height, im.date_taken, im.has_thumbnail, im.phash
+		FROM image_metadata im
+		JOIN files f ON f.path = im.file_path
+		WHERE im.phash IS NOT NULL AND im.status = 'done'
+			AND f.is_dir = FALSE AND f.deleted_at IS NULL%s`, permWhere)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list hashed images: %w", err)
+	}
+	defer rows.Close()
+
+	var results []HashedImage
+	for rows.Next() {
+		var r HashedImage
+		var hash int64
+		if err := rows.Scan(&r.FilePath, &r.Size, &r.Width, &r.Height, &r.DateTaken, &r.HasThumbnail, &hash); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		r.Hash = uint64(hash)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,234 @@
+package gallery
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ─── Burst Stacks ───────────────────────────────────────────────────────────
+//
+// A burst is a run of photos taken with the same camera, each within the
+// stack window of the one before. Runs of two or more get a stack_id, the
+// image_metadata id of their first photo, so that search can show one
+// photo per stack. Photos with a perceptual hash are only chained to a
+// neighbour whose hash is close, so two people shooting the same model
+// side by side are not mixed. A photo taken out of its stack (unstacked)
+// is never stacked again.
+
+// DefaultStackWindow is the default maximum time between two photos of
+// a burst.
+const DefaultStackWindow = 3 * time.Second
+
+// maxStackDistance is the largest Hamming distance between the perceptual
+// hashes of two neighbouring burst photos.
+const maxStackDistance = 16
+
+// StackCandidate is a photo that can be stacked.
+type StackCandidate struct {
+	ID          int
+	CameraMake  string
+	CameraModel string
+	DateTaken   time.Time
+	PHash       *uint64
+}
+
+// AssignStacks groups photos into bursts and returns the stack id of every
+// photo in a burst, keyed by photo id. Photos not in a burst are left out.
+func AssignStacks(photos []StackCandidate, window time.Duration) map[int]int {
+	sorted := append([]StackCandidate(nil), photos...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.CameraMake != b.CameraMake {
+			return a.CameraMake < b.CameraMake
+		}
+		if a.CameraModel != b.CameraModel {
+			return a.CameraModel < b.CameraModel
+		}
+		if !a.DateTaken.Equal(b.DateTaken) {
+			return a.DateTaken.Before(b.DateTaken)
+		}
+		return a.ID < b.ID
+	})
+
+	stacks := make(map[int]int)
+	start := 0
+	flush := func(end int) {
+		if end-start < 2 {
+			return
+		}
+		for _, p := range sorted[start:end] {
+			stacks[p.ID] = sorted[start].ID
+		}
+	}
+	for i := 1; i <= len(sorted); i++ {
+		if i < len(sorted) && sameBurst(sorted[i-1], sorted[i], window) {
+			continue
+		}
+		flush(i)
+		start = i
+	}
+	return stacks
+}
+
+// sameBurst reports whether b, taken at or after a, continues a's burst.
+func sameBurst(a, b StackCandidate, window time.Duration) bool {
+	if a.CameraMake != b.CameraMake || a.CameraModel != b.CameraModel {
+		return false
+	}
+	if b.DateTaken.Sub(a.DateTaken) > window {
+		return false
+	}
+	if a.PHash != nil && b.PHash != nil && HammingDistance(*a.PHash, *b.PHash) > maxStackDistance {
+		return false
+	}
+	return true
+}
+
+// stackableWhere selects the photos that can be stacked.
+const stackableWhere = `im.status = 'done' AND im.media_type = 'image' AND NOT im.unstacked
+	AND im.date_taken IS NOT NULL
+	AND (COALESCE(im.camera_make, '') <> '' OR COALESCE(im.camera_model, '') <> '')`
+
+// listStackCandidates returns the stackable photos matching cond, with
+// args for its placeholders.
+func (s *GalleryStore) listStackCandidates(ctx context.Context, cond string, args ...interface{}) ([]StackCandidate, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT im.id, COALESCE(im.camera_make, ''), COALESCE(im.camera_model, ''), im.date_taken, im.phash
+		FROM image_metadata im
+		WHERE %s AND %s`, stackableWhere, cond), args...)
+	if err != nil {
+		return nil, fmt.Errorf("list stack candidates: %w", err)
+	}
+	defer rows.Close()
+
+	var photos []StackCandidate
+	for rows.Next() {
+		var c StackCandidate
+		var hash sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.CameraMake, &c.CameraModel, &c.DateTaken, &hash); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		if hash.Valid {
+			h := uint64(hash.Int64)
+			c.PHash = &h
+		}
+		photos = append(photos, c)
+	}
+	return photos, rows.Err()
+}
+
+// saveStacks stores the stack ids of photos, clearing those of photos
+// that are in no stack.
+func (s *GalleryStore) saveStacks(ctx context.Context, photos []StackCandidate, stacks map[int]int) error {
+	ids := make([]int64, len(photos))
+	stackIDs := make([]int64, len(photos)) // 0 for none; ids start at 1
+	for i, p := range photos {
+		ids[i] = int64(p.ID)
+		stackIDs[i] = int64(stacks[p.ID])
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE image_metadata im SET stack_id = NULLIF(u.stack_id, 0)
+		FROM unnest($1::BIGINT[], $2::BIGINT[]) AS u(id, stack_id)
+		WHERE im.id = u.id AND im.stack_id IS DISTINCT FROM NULLIF(u.stack_id, 0)`,
+		pq.Array(ids), pq.Array(stackIDs))
+	if err != nil {
+		return fmt.Errorf("save stacks: %w", err)
+	}
+	return nil
+}
+
+// UpdateStacks restacks the photos around the one at filePath: those of
+// the same camera taken within window of it, of each other, and so on.
+// The processor calls it for every new photo.
+func (s *GalleryStore) UpdateStacks(ctx context.Context, filePath string, window time.Duration) error {
+	var cameraMake, cameraModel string
+	var taken *time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(camera_make, ''), COALESCE(camera_model, ''), date_taken
+		FROM image_metadata WHERE file_path = $1`, filePath).Scan(&cameraMake, &cameraModel, &taken)
+	if err == sql.ErrNoRows || (err == nil && taken == nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get photo: %w", err)
+	}
+
+	// Widen the range until no photo of the camera lies within window of
+	// its ends, so that whole bursts are restacked
+	from, to := *taken, *taken
+	var photos []StackCandidate
+	for {
+		photos, err = s.listStackCandidates(ctx, `COALESCE(im.camera_make, '') = $1 AND COALESCE(im.camera_model, '') = $2
+			AND im.date_taken BETWEEN $3 AND $4`,
+			cameraMake, cameraModel, from.Add(-window), to.Add(window))
+		if err != nil {
+			return err
+		}
+		lo, hi := from, to
+		for _, p := range photos {
+			if p.DateTaken.Before(lo) {
+				lo = p.DateTaken
+			}
+			if p.DateTaken.After(hi) {
+				hi = p.DateTaken
+			}
+		}
+		if lo.Equal(from) && hi.Equal(to) {
+			break
+		}
+		from, to = lo, hi
+	}
+
+	// Photos stacked before but no longer stackable leave their stack
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE image_metadata SET stack_id = NULL
+		WHERE file_path = $1 AND stack_id IS NOT NULL`, filePath); err != nil {
+		return fmt.Errorf("clear stack: %w", err)
+	}
+	return s.saveStacks(ctx, photos, AssignStacks(photos, window))
+}
+
+// RestackAll recomputes every stack, for photos processed before stacking
+// was enabled or after the window changed. It returns the number of
+// stacked photos and of stacks.
+func (s *GalleryStore) RestackAll(ctx context.Context, window time.Duration) (int, int, error) {
+	photos, err := s.listStackCandidates(ctx, "TRUE")
+	if err != nil {
+		return 0, 0, err
+	}
+	stacks := AssignStacks(photos, window)
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE image_metadata im SET stack_id = NULL
+		WHERE stack_id IS NOT NULL AND NOT (`+stackableWhere+`)`); err != nil {
+		return 0, 0, fmt.Errorf("clear stacks: %w", err)
+	}
+	if err := s.saveStacks(ctx, photos, stacks); err != nil {
+		return 0, 0, err
+	}
+	ids := make(map[int]bool)
+	for _, id := range stacks {
+		ids[id] = true
+	}
+	return len(stacks), len(ids), nil
+}
+
+// Unstack takes the photo at filePath out of stack stackID for good and
+// restacks the rest, which may split the stack or leave a single photo
+// unstacked. It reports false if the photo is not in that stack.
+func (s *GalleryStore) Unstack(ctx context.Context, stackID int, filePath string, window time.Duration) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE image_metadata SET unstacked = TRUE, stack_id = NULL
+		WHERE file_path = $1 AND stack_id = $2`, filePath, stackID)
+	if err != nil {
+		return false, fmt.Errorf("unstack: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, s.UpdateStacks(ctx, filePath, window)
+}
@@ -0,0 +1,41 @@
+package gallery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssignStacks(t *testing.T) {
+	base := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec float64) time.Time { return base.Add(time.Duration(sec * float64(time.Second))) }
+	near, far := uint64(0xF0F0F0F0F0F0F0F0), uint64(0x0F0F0F0F0F0F0F0F)
+	photos := []StackCandidate{
+		// A burst, out of order: each within 3s of the one before
+		{ID: 3, CameraModel: "Pixel 8", DateTaken: at(4)},
+		{ID: 1, CameraModel: "Pixel 8", DateTaken: at(0)},
+		{ID: 2, CameraModel: "Pixel 8", DateTaken: at(2.5), PHash: &near},
+		// Too long after the burst
+		{ID: 4, CameraModel: "Pixel 8", DateTaken: at(10)},
+		// Another camera at the same time
+		{ID: 5, CameraModel: "iPhone 15", DateTaken: at(1)},
+		{ID: 6, CameraModel: "iPhone 15", DateTaken: at(1.5)},
+		// Same camera and time but a different picture
+		{ID: 7, CameraModel: "X100V", DateTaken: at(0), PHash: &near},
+		{ID: 8, CameraModel: "X100V", DateTaken: at(1), PHash: &far},
+	}
+
+	stacks := AssignStacks(photos, 3*time.Second)
+	want := map[int]int{1: 1, 2: 1, 3: 1, 5: 5, 6: 5}
+	if len(stacks) != len(want) {
+		t.Fatalf("stacks = %v, want %v", stacks, want)
+	}
+	for id, stack := range want {
+		if stacks[id] != stack {
+			t.Errorf("photo %d in stack %d, want %d", id, stacks[id], stack)
+		}
+	}
+
+	if stacks := AssignStacks(photos, time.Second); len(stacks) != 2 || stacks[6] != 5 {
+		t.Errorf("1s window stacks = %v, want only 5 and 6", stacks)
+	}
+}
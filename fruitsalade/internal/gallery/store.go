@@ -14,6 +14,8 @@ import (
 type ImageMetadata struct {
 	ID              int        `json:"id"`
 	FilePath        string     `json:"file_path"`
+	MediaType       string     `json:"media_type"`
+	Duration        float64    `json:"duration,omitempty"`
 	Width           int        `json:"width"`
 	Height          int        `json:"height"`
 	CameraMake      string     `json:"camera_make"`
@@ -34,7 +36,9 @@ type ImageMetadata struct {
 	Orientation     int        `json:"orientation"`
 	HasThumbnail    bool       `json:"has_thumbnail"`
 	ThumbS3Key      string     `json:"thumb_s3_key"`
+	ThumbSize       int64      `json:"-"` // bytes stored under ThumbS3Key, counted toward the owner's quota
 	Status          string     `json:"status"`
+	PHash           *uint64    `json:"-"` // perceptual hash (dHash), nil if not computed
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
 }
@@ -87,20 +91,22 @@ func (s *GalleryStore) UpsertMetadata(ctx context.Context, m *ImageMetadata) err
 			focal_length, aperture, shutter_speed, iso, flash,
 			date_taken, latitude, longitude, altitude,
 			location_country, location_city, location_name,
-			orientation, has_thumbnail, thumb_s3_key, status, updated_at
-		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,NOW())
+			orientation, has_thumbnail, thumb_s3_key, status, media_type, duration, phash, thumb_size, updated_at
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,NOW())
 		ON CONFLICT (file_path) DO UPDATE SET
 			width=$2, height=$3, camera_make=$4, camera_model=$5, lens_model=$6,
 			focal_length=$7, aperture=$8, shutter_speed=$9, iso=$10, flash=$11,
 			date_taken=$12, latitude=$13, longitude=$14, altitude=$15,
 			location_country=$16, location_city=$17, location_name=$18,
 			orientation=$19, has_thumbnail=$20, thumb_s3_key=$21, status=$22,
-			updated_at=NOW()`,
+			media_type=$23, duration=$24, phash=$25, thumb_size=$26, updated_at=NOW(),
+			attempts=0, last_error='', next_retry_at=NULL`,
 		m.FilePath, m.Width, m.Height, m.CameraMake, m.CameraModel, m.LensModel,
 		m.FocalLength, m.Aperture, m.ShutterSpeed, m.ISO, m.Flash,
 		m.DateTaken, m.Latitude, m.Longitude, m.Altitude,
 		m.LocationCountry, m.LocationCity, m.LocationName,
 		m.Orientation, m.HasThumbnail, m.ThumbS3Key, m.Status,
+		mediaTypeOrDefault(m.MediaType, m.FilePath), m.Duration, phashToDB(m.PHash), m.ThumbSize,
 	)
 	return err
 }
@@ -113,14 +119,16 @@ func (s *GalleryStore) GetMetadata(ctx context.Context, filePath string) (*Image
 			focal_length, aperture, shutter_speed, iso, flash,
 			date_taken, latitude, longitude, altitude,
 			location_country, location_city, location_name,
-			orientation, has_thumbnail, thumb_s3_key, status, created_at, updated_at
+			orientation, has_thumbnail, thumb_s3_key, status, media_type, duration,
+			created_at, updated_at
 		FROM image_metadata WHERE file_path = $1`, filePath,
 	).Scan(
 		&m.ID, &m.FilePath, &m.Width, &m.Height, &m.CameraMake, &m.CameraModel, &m.LensModel,
 		&m.FocalLength, &m.Aperture, &m.ShutterSpeed, &m.ISO, &m.Flash,
 		&m.DateTaken, &m.Latitude, &m.Longitude, &m.Altitude,
 		&m.LocationCountry, &m.LocationCity, &m.LocationName,
-		&m.Orientation, &m.HasThumbnail, &m.ThumbS3Key, &m.Status, &m.CreatedAt, &m.UpdatedAt,
+		&m.Orientation, &m.HasThumbnail, &m.ThumbS3Key, &m.Status, &m.MediaType, &m.Duration,
+		&m.CreatedAt, &m.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -128,6 +136,21 @@ func (s *GalleryStore) GetMetadata(ctx context.Context, filePath string) (*Image
 	return m, err
 }
 
+// phashToDB stores the unsigned hash bit-for-bit in a signed BIGINT column.
+func phashToDB(h *uint64) interface{} {
+	if h == nil {
+		return nil
+	}
+	return int64(*h)
+}
+
+func mediaTypeOrDefault(mediaType, filePath string) string {
+	if mediaType != "" {
+		return mediaType
+	}
+	return MediaTypeFor(filePath)
+}
+
 // ListPendingProcessing returns file paths of images with status 'pending'.
 func (s *GalleryStore) ListPendingProcessing(ctx context.Context, limit int) ([]string, error) {
 	rows, err := s.db.QueryContext(ctx,
@@ -171,6 +194,19 @@ func (s *GalleryStore) GetThumbKey(ctx context.Context, filePath string) string
 	return key
 }
 
+// RecordThumbnail attributes a generated preview thumbnail of size bytes
+// to the file at filePath, so it counts toward the file owner's storage.
+// A thumbnail shared by identical files stays with the first file
+// recorded for it.
+func (s *GalleryStore) RecordThumbnail(ctx context.Context, filePath, key string, size int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO thumbnail_objects (s3_key, file_path, size)
+		 SELECT $1, $2, $3 WHERE EXISTS (SELECT 1 FROM files WHERE path = $2)
+		 ON CONFLICT (s3_key) DO UPDATE SET size = EXCLUDED.size`,
+		key, filePath, size)
+	return err
+}
+
 // ─── Tags ───────────────────────────────────────────────────────────────────
 
 // AddTag adds a tag to an image. Returns error on conflict.
@@ -211,20 +247,23 @@ func (s *GalleryStore) GetTagsForFile(ctx context.Context, filePath string) ([]I
 	return tags, rows.Err()
 }
 
-// ListAllTags returns all distinct tags with counts.
+// ListAllTags returns all distinct tags with the number of files carrying
+// them. A file tagged both manually and by a plugin counts once, and
+// trashed files not at all.
 func (s *GalleryStore) ListAllTags(ctx context.Context, pf *PermFilter) ([]TagCount, error) {
 	var args []interface{}
-	join := ""
 	permWhere := ""
 	if pf != nil {
-		join = " JOIN files f ON f.path = it.file_path"
-		permWhere = " WHERE " + pf.Condition
+		permWhere = " AND " + pf.Condition
 		args = pf.Args
 	}
 
 	query := fmt.Sprintf(
-		`SELECT it.tag, COUNT(*) as cnt FROM image_tags it%s%s GROUP BY it.tag ORDER BY cnt DESC, it.tag`,
-		join, permWhere)
+		`SELECT it.tag, COUNT(DISTINCT it.file_path) as cnt
+		FROM image_tags it JOIN files f ON f.path = it.file_path
+		WHERE f.deleted_at IS NULL%s
+		GROUP BY it.tag ORDER BY cnt DESC, it.tag`,
+		permWhere)
 
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -254,6 +293,7 @@ type TagCount struct {
 // Stats holds aggregate gallery statistics.
 type Stats struct {
 	TotalImages int `json:"total_images"`
+	Videos      int `json:"videos"`
 	WithGPS     int `json:"with_gps"`
 	WithTags    int `json:"with_tags"`
 	Processed   int `json:"processed"`
@@ -269,12 +309,13 @@ func (s *GalleryStore) GetStats(ctx context.Context, pf *PermFilter) (*Stats, er
 		err := s.db.QueryRowContext(ctx, `
 			SELECT
 				COUNT(*),
+				COUNT(*) FILTER (WHERE media_type = 'video'),
 				COUNT(*) FILTER (WHERE latitude IS NOT NULL AND longitude IS NOT NULL),
 				(SELECT COUNT(DISTINCT file_path) FROM image_tags),
 				COUNT(*) FILTER (WHERE status = 'done'),
 				COUNT(*) FILTER (WHERE status = 'pending')
 			FROM image_metadata`).Scan(
-			&st.TotalImages, &st.WithGPS, &st.WithTags, &st.Processed, &st.Pending)
+			&st.TotalImages, &st.Videos, &st.WithGPS, &st.WithTags, &st.Processed, &st.Pending)
 		if err != nil {
 			return nil, err
 		}
@@ -285,6 +326,7 @@ func (s *GalleryStore) GetStats(ctx context.Context, pf *PermFilter) (*Stats, er
 	query := fmt.Sprintf(`
 		SELECT
 			COUNT(DISTINCT im.file_path),
+			COUNT(DISTINCT im.file_path) FILTER (WHERE im.media_type = 'video'),
 			COUNT(DISTINCT im.file_path) FILTER (WHERE im.latitude IS NOT NULL AND im.longitude IS NOT NULL),
 			COUNT(DISTINCT it.file_path),
 			COUNT(DISTINCT im.file_path) FILTER (WHERE im.status = 'done'),
@@ -295,7 +337,7 @@ func (s *GalleryStore) GetStats(ctx context.Context, pf *PermFilter) (*Stats, er
 		WHERE %s`, pf.Condition)
 
 	err := s.db.QueryRowContext(ctx, query, pf.Args...).Scan(
-		&st.TotalImages, &st.WithGPS, &st.WithTags, &st.Processed, &st.Pending)
+		&st.TotalImages, &st.Videos, &st.WithGPS, &st.WithTags, &st.Processed, &st.Pending)
 	if err != nil {
 		return nil, err
 	}
@@ -546,8 +588,16 @@ func (s *GalleryStore) SetAlbumCover(ctx context.Context, albumID int, coverPath
 	return err
 }
 
-// AddImageToAlbum adds an image to an album, ignoring duplicates.
+// ErrNotMedia is returned when a file that is not a photo or video is
+// added to an album.
+var ErrNotMedia = fmt.Errorf("only photos and videos can be added to an album")
+
+// AddImageToAlbum adds an image to an album, ignoring duplicates. Returns
+// ErrNotMedia if the file is not a photo or video.
 func (s *GalleryStore) AddImageToAlbum(ctx context.Context, albumID int, filePath string) error {
+	if !IsMediaFile(filePath) {
+		return ErrNotMedia
+	}
 	_, err := s.db.ExecContext(ctx, `
 		INSERT INTO album_images (album_id, file_path)
 		VALUES ($1, $2)
@@ -582,6 +632,60 @@ func (s *GalleryStore) GetAlbumImages(ctx context.Context, albumID int) ([]strin
 	return paths, rows.Err()
 }
 
+// AlbumItem is a member of an album with what a shared album page shows
+// of it.
+type AlbumItem struct {
+	FilePath     string
+	Name         string
+	Size         int64
+	MediaType    string
+	Width        int
+	Height       int
+	DateTaken    *time.Time
+	HasThumbnail bool
+}
+
+// ListAlbumItems returns the members of an album that are not in the
+// trash, newest additions first.
+func (s *GalleryStore) ListAlbumItems(ctx context.Context, albumID int) ([]AlbumItem, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT f.path, f.name, f.size, COALESCE(im.media_type, ''), COALESCE(im.width, 0),
+			COALESCE(im.height, 0), im.date_taken, COALESCE(im.has_thumbnail, FALSE)
+		FROM album_images ai
+		JOIN files f ON f.path = ai.file_path
+		LEFT JOIN image_metadata im ON im.file_path = ai.file_path
+		WHERE ai.album_id = $1 AND f.deleted_at IS NULL
+		ORDER BY ai.added_at DESC`, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []AlbumItem
+	for rows.Next() {
+		var it AlbumItem
+		if err := rows.Scan(&it.FilePath, &it.Name, &it.Size, &it.MediaType, &it.Width,
+			&it.Height, &it.DateTaken, &it.HasThumbnail); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// AlbumContains reports whether filePath is a member of an album and not
+// in the trash.
+func (s *GalleryStore) AlbumContains(ctx context.Context, albumID int, filePath string) (bool, error) {
+	var ok bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM album_images ai
+			JOIN files f ON f.path = ai.file_path
+			WHERE ai.album_id = $1 AND ai.file_path = $2
+				AND f.deleted_at IS NULL AND f.is_dir = FALSE)`, albumID, filePath).Scan(&ok)
+	return ok, err
+}
+
 // GetAlbumsForImage returns all albums that contain a given image.
 func (s *GalleryStore) GetAlbumsForImage(ctx context.Context, filePath string) ([]AlbumSummary, error) {
 	rows, err := s.db.QueryContext(ctx, `
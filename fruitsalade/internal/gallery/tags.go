@@ -0,0 +1,33 @@
+package gallery
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxTagLength is the longest tag, in characters, users may set.
+const MaxTagLength = 64
+
+// ErrInvalidTag is returned by NormalizeTag for tags users may not set.
+var ErrInvalidTag = errors.New("invalid tag")
+
+// NormalizeTag validates a tag given by a user and returns it in its
+// stored form: lower case, trimmed, with runs of spaces collapsed. Tags may
+// contain letters, digits, spaces and "-", "_" and ".".
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" {
+		return "", fmt.Errorf("%w: empty", ErrInvalidTag)
+	}
+	if n := len([]rune(tag)); n > MaxTagLength {
+		return "", fmt.Errorf("%w: longer than %d characters", ErrInvalidTag, MaxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" -_.", r) {
+			return "", fmt.Errorf("%w: %q not allowed", ErrInvalidTag, r)
+		}
+	}
+	return tag, nil
+}
@@ -0,0 +1,31 @@
+package gallery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	valid := map[string]string{
+		"Beach":                           "beach",
+		"  New   York  ":                  "new york",
+		"family_2024":                     "family_2024",
+		"sci-fi":                          "sci-fi",
+		"Zürich":                          "zürich",
+		"v1.2":                            "v1.2",
+		strings.Repeat("a", MaxTagLength): strings.Repeat("a", MaxTagLength),
+	}
+	for in, want := range valid {
+		got, err := NormalizeTag(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeTag(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "   ", "a/b", "<script>", "a,b", "emoji😀", strings.Repeat("a", MaxTagLength+1)} {
+		if _, err := NormalizeTag(in); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("NormalizeTag(%q) error = %v, want ErrInvalidTag", in, err)
+		}
+	}
+}
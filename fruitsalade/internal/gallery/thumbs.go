@@ -24,6 +24,11 @@ func ThumbS3Key(originalPath string) string {
 // GenerateThumbnail reads an image, generates a 400x400 max thumbnail,
 // applies EXIF orientation correction, and returns the JPEG bytes.
 func GenerateThumbnail(r io.Reader, orientation int) ([]byte, int, int, error) {
+	return GenerateThumbnailSize(r, orientation, ThumbMaxSize)
+}
+
+// GenerateThumbnailSize is GenerateThumbnail with a custom bounding box.
+func GenerateThumbnailSize(r io.Reader, orientation, maxSize int) ([]byte, int, int, error) {
 	img, _, err := image.Decode(r)
 	if err != nil {
 		return nil, 0, 0, err
@@ -32,8 +37,8 @@ func GenerateThumbnail(r io.Reader, orientation int) ([]byte, int, int, error) {
 	// Apply EXIF orientation
 	img = applyOrientation(img, orientation)
 
-	// Fit within ThumbMaxSize x ThumbMaxSize preserving aspect ratio
-	thumb := imaging.Fit(img, ThumbMaxSize, ThumbMaxSize, imaging.Lanczos)
+	// Fit within maxSize x maxSize preserving aspect ratio
+	thumb := imaging.Fit(img, maxSize, maxSize, imaging.Lanczos)
 
 	bounds := thumb.Bounds()
 	w := bounds.Dx()
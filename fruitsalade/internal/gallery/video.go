@@ -0,0 +1,286 @@
+package gallery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoExtensions are file extensions treated as gallery videos.
+var videoExtensions = []string{".mp4", ".m4v", ".mov", ".mkv", ".webm"}
+
+// mp4Extensions are the video containers the built-in atom parser understands.
+var mp4Extensions = []string{".mp4", ".m4v", ".mov"}
+
+// Media types stored in image_metadata.media_type.
+const (
+	MediaTypeImage = "image"
+	MediaTypeVideo = "video"
+)
+
+const videoToolTimeout = 2 * time.Minute
+
+// IsVideoFile checks if a file path has a video extension.
+func IsVideoFile(path string) bool {
+	return hasExt(path, videoExtensions)
+}
+
+// IsMediaFile checks if a file belongs in the gallery (image or video).
+func IsMediaFile(path string) bool {
+	return IsImageFile(path) || IsVideoFile(path)
+}
+
+// MediaTypeFor returns the media type for a gallery file path.
+func MediaTypeFor(path string) string {
+	if IsVideoFile(path) {
+		return MediaTypeVideo
+	}
+	return MediaTypeImage
+}
+
+func hasExt(path string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// VideoInfo holds metadata extracted from a video container.
+type VideoInfo struct {
+	Duration    float64 // seconds
+	Width       int
+	Height      int
+	CreatedAt   *time.Time
+	CameraMake  string
+	CameraModel string
+	Latitude    *float64
+	Longitude   *float64
+	Altitude    *float32
+}
+
+// VideoProber extracts video metadata and poster frames. It shells out to
+// ffprobe/ffmpeg when they are on PATH and falls back to the built-in
+// MP4/MOV atom parser (metadata only, no poster frame) otherwise.
+type VideoProber struct {
+	ffprobe string
+	ffmpeg  string
+	timeout time.Duration
+}
+
+// NewVideoProber looks up ffprobe and ffmpeg on PATH.
+func NewVideoProber() *VideoProber {
+	v := &VideoProber{timeout: videoToolTimeout}
+	if p, err := exec.LookPath("ffprobe"); err == nil {
+		v.ffprobe = p
+	}
+	if p, err := exec.LookPath("ffmpeg"); err == nil {
+		v.ffmpeg = p
+	}
+	return v
+}
+
+// CanExtractPoster reports whether poster-frame thumbnails are available.
+func (v *VideoProber) CanExtractPoster() bool {
+	return v.ffmpeg != ""
+}
+
+// Probe extracts metadata from the video file at path.
+func (v *VideoProber) Probe(ctx context.Context, path string) (*VideoInfo, error) {
+	if v.ffprobe != "" {
+		info, err := v.runFFprobe(ctx, path)
+		if err == nil {
+			return info, nil
+		}
+		if !hasExt(path, mp4Extensions) {
+			return nil, err
+		}
+		// Fall through to the atom parser for MP4/MOV
+	}
+	if !hasExt(path, mp4Extensions) {
+		return nil, fmt.Errorf("no metadata parser for %s (install ffprobe)", filepath.Ext(path))
+	}
+	return ParseMP4File(path)
+}
+
+// PosterFrame returns a JPEG frame taken shortly after the start of the video.
+func (v *VideoProber) PosterFrame(ctx context.Context, path string, duration float64) ([]byte, error) {
+	if v.ffmpeg == "" {
+		return nil, fmt.Errorf("ffmpeg not available")
+	}
+
+	// Skip the first second (often black) unless the clip is very short
+	at := 1.0
+	if duration > 0 && duration < 2 {
+		at = duration / 2
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, v.ffmpeg,
+		"-v", "error",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-vcodec", "mjpeg",
+		"-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no frame")
+	}
+	return stdout.Bytes(), nil
+}
+
+// ffprobeOutput is the subset of `ffprobe -show_format -show_streams` we use.
+type ffprobeOutput struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+func (v *VideoProber) runFFprobe(ctx context.Context, path string) (*VideoInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, v.ffprobe,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %w", err)
+	}
+
+	info := &VideoInfo{}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+
+	for _, st := range probe.Streams {
+		if st.CodecType != "video" || st.Width == 0 {
+			continue
+		}
+		info.Width, info.Height = st.Width, st.Height
+		rotation := 0.0
+		if r, err := strconv.ParseFloat(st.Tags["rotate"], 64); err == nil {
+			rotation = r
+		}
+		for _, sd := range st.SideDataList {
+			if sd.Rotation != 0 {
+				rotation = sd.Rotation
+			}
+		}
+		if isQuarterTurn(rotation) {
+			info.Width, info.Height = info.Height, info.Width
+		}
+		break
+	}
+
+	tags := probe.Format.Tags
+	for _, key := range []string{"com.apple.quicktime.creationdate", "creation_time"} {
+		if t := parseVideoTime(tags[key]); t != nil {
+			info.CreatedAt = t
+			break
+		}
+	}
+	info.CameraMake = firstTag(tags, "com.apple.quicktime.make", "make")
+	info.CameraModel = firstTag(tags, "com.apple.quicktime.model", "model")
+	if loc := firstTag(tags, "com.apple.quicktime.location.ISO6709", "location"); loc != "" {
+		info.Latitude, info.Longitude, info.Altitude = parseISO6709(loc)
+	}
+
+	return info, nil
+}
+
+func firstTag(tags map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v := strings.TrimSpace(tags[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func isQuarterTurn(deg float64) bool {
+	d := int(deg) % 360
+	if d < 0 {
+		d += 360
+	}
+	return d == 90 || d == 270
+}
+
+// parseVideoTime parses the timestamp formats found in video metadata.
+func parseVideoTime(s string) *time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02T15:04:05.000000Z",
+		"2006-01-02 15:04:05",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Year() < 1971 {
+				return nil // unset timestamps come out as 1904/1970
+			}
+			return &t
+		}
+	}
+	return nil
+}
+
+var iso6709Re = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+// parseISO6709 parses decimal-degree ISO 6709 strings like
+// "+37.7749-122.4194+010.000/" as written by phones into video metadata.
+func parseISO6709(s string) (*float64, *float64, *float32) {
+	m := iso6709Re.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, nil, nil
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, nil, nil
+	}
+	var alt *float32
+	if m[3] != "" {
+		if a, err := strconv.ParseFloat(m[3], 32); err == nil {
+			a32 := float32(a)
+			alt = &a32
+		}
+	}
+	return &lat, &lon, alt
+}
@@ -0,0 +1,319 @@
+// Package importer registers objects that already exist in a storage
+// location as files, without copying their content: the new file rows
+// point at the existing keys. Sizes come from the listing; hashes are left
+// empty for the scrubber to fill in.
+package importer
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+)
+
+// ErrRunning is returned by Trigger while an import is in progress.
+var ErrRunning = errors.New("import already running")
+
+// maxReportedConflicts caps the conflicting paths listed in the status.
+const maxReportedConflicts = 1000
+
+// Request describes an import: the objects of location LocationID whose
+// keys start with Prefix become files below PathPrefix, named by the rest
+// of their key. A DryRun reports what would be imported without writing.
+type Request struct {
+	LocationID int    `json:"location_id"`
+	Prefix     string `json:"prefix"`
+	PathPrefix string `json:"path_prefix"`
+	DryRun     bool   `json:"dry_run"`
+
+	// Who started the import
+	UserID   int    `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Status describes the current or most recent import.
+type Status struct {
+	Running       bool       `json:"running"`
+	Request       Request    `json:"request"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	Objects       int64      `json:"objects"`   // objects listed
+	Files         int64      `json:"files"`     // files registered, or that would be
+	Dirs          int64      `json:"dirs"`      // directories created, or that would be
+	Bytes         int64      `json:"bytes"`     // size of the registered files
+	Existing      int64      `json:"existing"`  // objects already registered at their path
+	Ignored       int64      `json:"ignored"`   // FruitSalade's own objects
+	Invalid       int64      `json:"invalid"`   // keys that do not make a valid path
+	Conflicts     int64      `json:"conflicts"` // objects whose path is taken
+	ConflictPaths []string   `json:"conflict_paths,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// Importer runs one import at a time in the background.
+type Importer struct {
+	metadata *postgres.Store
+	router   *storage.Router
+	onChange func(ctx context.Context, st Status)
+
+	ctx    context.Context
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates an Importer. Imports stop when ctx is done.
+func New(ctx context.Context, metadata *postgres.Store, router *storage.Router) *Importer {
+	return &Importer{metadata: metadata, router: router, ctx: ctx}
+}
+
+// SetOnChange registers a callback run after an import that added files or
+// directories (used to refresh the metadata tree).
+func (im *Importer) SetOnChange(fn func(ctx context.Context, st Status)) {
+	im.onChange = fn
+}
+
+// Trigger starts req in the background. The location must exist.
+func (im *Importer) Trigger(req Request) error {
+	req.Prefix = strings.TrimPrefix(req.Prefix, "/")
+	req.PathPrefix = path.Clean("/" + req.PathPrefix)
+	loc := im.router.GetLocation(req.LocationID)
+	if loc == nil {
+		return errors.New("storage location not found")
+	}
+
+	im.mu.Lock()
+	if im.status.Running {
+		im.mu.Unlock()
+		return ErrRunning
+	}
+	now := time.Now()
+	im.status = Status{Running: true, Request: req, StartedAt: &now}
+	im.mu.Unlock()
+
+	go im.run(im.ctx, req, loc)
+	return nil
+}
+
+// Status returns the progress of the current or last import.
+func (im *Importer) Status() Status {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	st := im.status
+	st.ConflictPaths = append([]string(nil), st.ConflictPaths...)
+	return st
+}
+
+func (im *Importer) update(fn func(st *Status)) {
+	im.mu.Lock()
+	fn(&im.status)
+	im.mu.Unlock()
+}
+
+// run lists the location's objects and registers them. Directories are
+// created as the first object below them is reached.
+func (im *Importer) run(ctx context.Context, req Request, loc *storage.StorageLocation) {
+	r := &run{im: im, req: req, loc: loc, dirs: make(map[string]bool)}
+	runErr := loc.Backend.ListObjects(ctx, req.Prefix, func(key string, size int64, modTime time.Time) error {
+		im.update(func(st *Status) { st.Objects++ })
+		return r.object(ctx, key, size, modTime)
+	})
+
+	now := time.Now()
+	im.update(func(st *Status) {
+		st.Running = false
+		st.FinishedAt = &now
+		if runErr != nil {
+			st.Error = runErr.Error()
+		}
+	})
+	st := im.Status()
+	if !req.DryRun && (st.Files > 0 || st.Dirs > 0) && im.onChange != nil {
+		im.onChange(context.WithoutCancel(ctx), st)
+	}
+
+	fields := []zap.Field{
+		zap.Int("location_id", req.LocationID),
+		zap.String("prefix", req.Prefix),
+		zap.String("path_prefix", req.PathPrefix),
+		zap.Bool("dry_run", req.DryRun),
+		zap.Int64("objects", st.Objects),
+		zap.Int64("files", st.Files),
+		zap.Int64("dirs", st.Dirs),
+		zap.Int64("bytes", st.Bytes),
+		zap.Int64("existing", st.Existing),
+		zap.Int64("conflicts", st.Conflicts),
+	}
+	if runErr != nil {
+		logging.Error("storage import aborted", append(fields, zap.Error(runErr))...)
+		return
+	}
+	logging.Info("storage import finished", fields...)
+}
+
+// run is the state of one import.
+type run struct {
+	im   *Importer
+	req  Request
+	loc  *storage.StorageLocation
+	dirs map[string]bool // directory paths seen: true if usable
+}
+
+// object imports one listed object. Only database errors stop the import.
+func (r *run) object(ctx context.Context, key string, size int64, modTime time.Time) error {
+	if isInternal(key) {
+		r.im.update(func(st *Status) { st.Ignored++ })
+		return nil
+	}
+	p, ok := targetPath(r.req.PathPrefix, strings.TrimPrefix(key, r.req.Prefix))
+	if !ok {
+		r.im.update(func(st *Status) { st.Invalid++ })
+		return nil
+	}
+
+	// A key ending in a slash is a folder marker
+	if strings.HasSuffix(key, "/") {
+		_, err := r.dir(ctx, p, modTime)
+		return err
+	}
+	if usable, err := r.dir(ctx, path.Dir(p), modTime); err != nil || !usable {
+		return err
+	}
+
+	existing, err := r.im.metadata.GetFileRow(ctx, p)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		r.taken(existing, p, key)
+		return nil
+	}
+	if !r.req.DryRun {
+		added, err := r.im.metadata.InsertFile(ctx, r.row(p, key, size, modTime, false))
+		if err != nil {
+			return err
+		}
+		if !added {
+			r.conflict(p)
+			return nil
+		}
+	}
+	r.im.update(func(st *Status) {
+		st.Files++
+		st.Bytes += size
+	})
+	return nil
+}
+
+// dir makes sure p and its parents are directories, creating the missing
+// ones. It reports false, and records a conflict, if a file is in the way.
+func (r *run) dir(ctx context.Context, p string, modTime time.Time) (bool, error) {
+	if p == "/" {
+		return true, nil
+	}
+	if usable, seen := r.dirs[p]; seen {
+		return usable, nil
+	}
+	usable, err := r.dir(ctx, path.Dir(p), modTime)
+	if err != nil || !usable {
+		r.dirs[p] = false
+		return false, err
+	}
+
+	existing, err := r.im.metadata.GetFileRow(ctx, p)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case existing != nil:
+		usable = existing.IsDir
+		if !usable {
+			r.conflict(p)
+		}
+	case r.req.DryRun:
+		usable = true
+	default:
+		added, err := r.im.metadata.InsertFile(ctx, r.row(p, "", 0, modTime, true))
+		if err != nil {
+			return false, err
+		}
+		usable = added
+		if !added {
+			r.conflict(p)
+		}
+	}
+	r.dirs[p] = usable
+	if usable && existing == nil {
+		r.im.update(func(st *Status) { st.Dirs++ })
+	}
+	return usable, nil
+}
+
+// taken records an object whose path already has a row: the same object
+// imported before, or a conflict.
+func (r *run) taken(existing *postgres.FileRow, p, key string) {
+	if !existing.IsDir && existing.S3Key == key &&
+		existing.StorageLocID != nil && *existing.StorageLocID == r.loc.ID {
+		r.im.update(func(st *Status) { st.Existing++ })
+		return
+	}
+	r.conflict(p)
+}
+
+func (r *run) conflict(p string) {
+	r.im.update(func(st *Status) {
+		st.Conflicts++
+		if len(st.ConflictPaths) < maxReportedConflicts {
+			st.ConflictPaths = append(st.ConflictPaths, p)
+		}
+	})
+}
+
+func (r *run) row(p, key string, size int64, modTime time.Time, isDir bool) *postgres.FileRow {
+	locID := r.loc.ID
+	row := &postgres.FileRow{
+		ID:         upload.FileID(p),
+		Name:       path.Base(p),
+		Path:       p,
+		ParentPath: path.Dir(p),
+		IsDir:      isDir,
+		ModTime:    modTime,
+		GroupID:    r.loc.GroupID,
+	}
+	if !isDir {
+		row.Size = size
+		row.S3Key = key
+		row.Version = 1
+		row.StorageLocID = &locID
+	}
+	return row
+}
+
+// targetPath returns the path of the file for the key rel, relative to the
+// import prefix, below pathPrefix. Keys with empty, "." or ".." segments
+// (other than a trailing slash) are not valid paths.
+func targetPath(pathPrefix, rel string) (string, bool) {
+	rel = strings.TrimSuffix(rel, "/")
+	if rel == "" {
+		return "", false
+	}
+	for _, seg := range strings.Split(rel, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", false
+		}
+	}
+	return path.Join("/", pathPrefix, rel), true
+}
+
+// isInternal reports whether key is one of FruitSalade's own objects,
+// which are never imported.
+func isInternal(key string) bool {
+	return storage.IsReservedKey(key)
+}
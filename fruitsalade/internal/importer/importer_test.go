@@ -0,0 +1,40 @@
+package importer
+
+import "testing"
+
+func TestTargetPath(t *testing.T) {
+	tests := []struct {
+		prefix, rel string
+		want        string
+		ok          bool
+	}{
+		{"/archive", "2019/a.jpg", "/archive/2019/a.jpg", true},
+		{"/", "a.jpg", "/a.jpg", true},
+		{"/archive", "2019/", "/archive/2019", true},
+		{"/archive", "", "", false},
+		{"/archive", "a//b", "", false},
+		{"/archive", "../etc/passwd", "", false},
+		{"/archive", "a/./b", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := targetPath(tt.prefix, tt.rel)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("targetPath(%q, %q) = %q, %v; want %q, %v", tt.prefix, tt.rel, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIsInternal(t *testing.T) {
+	for key, want := range map[string]bool{
+		"_versions/a.txt/3":        true,
+		"_cas/abcdef":              true,
+		"_thumbs/x_256.jpg":        true,
+		"_fruitsalade_test_probe":  true,
+		"photos/_versions/a.txt/3": false,
+		"_notes.txt":               false,
+	} {
+		if got := isInternal(key); got != want {
+			t.Errorf("isInternal(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
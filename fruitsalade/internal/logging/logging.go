@@ -3,6 +3,8 @@ package logging
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"time"
 
@@ -144,12 +146,34 @@ func Fatal(msg string, fields ...zap.Field) {
 	L().Fatal(msg, fields...)
 }
 
-// requestIDGenerator generates unique request IDs.
-var requestIDCounter uint64
+// maxRequestIDLen caps the length of a client-supplied request ID.
+const maxRequestIDLen = 128
 
+// generateRequestID returns a random 16-character hex request ID.
 func generateRequestID() string {
-	requestIDCounter++
-	return time.Now().Format("20060102150405") + "-" + string(rune('A'+requestIDCounter%26))
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to
+// echo and log: short, and only letters, digits and ._:- so that clients
+// can derive child IDs such as "<parent>.3".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // responseWriter wraps http.ResponseWriter to capture status and size.
@@ -180,12 +204,15 @@ func (rw *responseWriter) Unwrap() http.ResponseWriter {
 	return rw.ResponseWriter
 }
 
-// Middleware returns HTTP middleware that adds request logging.
+// Middleware returns HTTP middleware that adds request logging. Every
+// request gets an ID, taken from a valid incoming X-Request-ID header or
+// generated, which is added to the request's logger, echoed in the
+// X-Request-ID response header and available from GetRequestID.
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		requestID := r.Header.Get("X-Request-ID")
-		if requestID == "" {
+		if !validRequestID(requestID) {
 			requestID = generateRequestID()
 		}
 
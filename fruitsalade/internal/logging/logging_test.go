@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var seen string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestID(r.Context())
+	}))
+
+	serve := func(incoming string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/tree", nil)
+		if incoming != "" {
+			req.Header.Set("X-Request-ID", incoming)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("X-Request-ID"); got != seen {
+			t.Errorf("response header %q, context %q", got, seen)
+		}
+		return seen
+	}
+
+	// A client's ID, including a child ID, is propagated
+	if got := serve("3f2a9c.2"); got != "3f2a9c.2" {
+		t.Errorf("propagated ID = %q, want 3f2a9c.2", got)
+	}
+
+	// Missing or unsafe IDs are replaced with generated ones
+	a, b := serve(""), serve("")
+	if len(a) != 16 || a == b {
+		t.Errorf("generated IDs %q and %q, want distinct 16-char IDs", a, b)
+	}
+	for _, bad := range []string{"bad id\ninjected", strings.Repeat("x", maxRequestIDLen+1)} {
+		if got := serve(bad); got == bad || !validRequestID(got) {
+			t.Errorf("unsafe ID %q was used as %q", bad, got)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// ─── Aliases ─────────────────────────────────────────────────────────────────
+//
+// An alias is a files row with alias_target_id set. It has no content of
+// its own: BuildTree fills it in with a copy of its target's subtree, so it
+// shows the target's files under its own path. The target is referenced
+// by id with ON UPDATE/DELETE CASCADE, so aliases follow MoveFile and go
+// when the target is purged.
+
+// CreateAlias adds an alias at link for the live file or directory at
+// target, owned by ownerID. It reports false if link is taken or target
+// does not exist.
+func (s *Store) CreateAlias(ctx context.Context, link, target string, ownerID int) (bool, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("create_alias", time.Since(start)) }()
+
+	link = normalizePath(link)
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO files (id, name, path, parent_path, is_dir, owner_id, alias_target_id, updated_at)
+		 SELECT $1, $2, $3, $4, t.is_dir, $5, t.id, NOW()
+		 FROM files t WHERE t.path = $6 AND t.deleted_at IS NULL
+		 ON CONFLICT (path) DO NOTHING`,
+		fileID(link), path.Base(link), link, path.Dir(link), ownerID, normalizePath(target))
+	if err != nil {
+		return false, fmt.Errorf("create alias: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// Alias resolution states.
+const (
+	aliasPending = iota
+	aliasResolving
+	aliasResolved
+	aliasDropped
+)
+
+// resolveAliases fills in the alias nodes of a tree built from rows: each
+// takes its target's metadata and a copy of its children, re-rooted at the
+// alias' path. Aliases inside a target are resolved before the target is
+// copied. Aliases whose target is gone, and those that would end up
+// containing themselves, are removed from the tree. nodes maps the paths of
+// rows to their nodes, whose children are already sorted.
+func resolveAliases(rows []FileRow, nodes map[string]*models.FileNode) {
+	pathByID := make(map[string]string, len(rows))
+	var aliases []*FileRow
+	for i := range rows {
+		pathByID[rows[i].ID] = rows[i].Path
+		if rows[i].AliasTargetID != "" {
+			aliases = append(aliases, &rows[i])
+		}
+	}
+	if len(aliases) == 0 {
+		return
+	}
+
+	state := make(map[string]int, len(aliases))
+	drop := func(a *FileRow, cycle bool) {
+		state[a.Path] = aliasDropped
+		if parent := nodes[a.ParentPath]; parent != nil {
+			for i, c := range parent.Children {
+				if c.Path == a.Path {
+					parent.Children = append(parent.Children[:i:i], parent.Children[i+1:]...)
+					parent.SetChildCount(len(parent.Children))
+					break
+				}
+			}
+		}
+		// A trashed target hides its aliases until it is restored
+		if cycle {
+			logging.Warn("alias would contain itself, left out of the tree", zap.String("path", a.Path))
+		}
+	}
+
+	var resolve func(a *FileRow)
+	resolve = func(a *FileRow) {
+		switch state[a.Path] {
+		case aliasResolved, aliasDropped:
+			return
+		case aliasResolving:
+			drop(a, true)
+			return
+		}
+		state[a.Path] = aliasResolving
+
+		targetPath, ok := pathByID[a.AliasTargetID]
+		target := nodes[targetPath]
+		if !ok || target == nil {
+			drop(a, false)
+			return
+		}
+		if isPathWithin(a.Path, targetPath) {
+			drop(a, true)
+			return
+		}
+		for _, inner := range aliases {
+			if isPathWithin(inner.Path, targetPath) {
+				resolve(inner)
+			}
+		}
+		if state[a.Path] == aliasDropped {
+			return
+		}
+
+		// An alias of an alias stands for the latter's target
+		real := targetPath
+		if target.AliasTarget != "" {
+			real = target.AliasTarget
+		}
+		node := nodes[a.Path]
+		children := aliasCopy(target, a.Path).Children
+		*node = *target
+		node.Name = a.Name
+		node.Path = a.Path
+		node.Alias = true
+		node.AliasTarget = real
+		node.Children = children
+		state[a.Path] = aliasResolved
+	}
+	for _, a := range aliases {
+		resolve(a)
+	}
+}
+
+// aliasCopy returns a copy of the subtree at n re-rooted at p. The copies
+// keep the ids of the nodes they stand for, which the content endpoint
+// resolves, and record their paths as AliasTarget.
+func aliasCopy(n *models.FileNode, p string) *models.FileNode {
+	c := *n
+	c.Path = p
+	if n.AliasTarget == "" {
+		c.AliasTarget = n.Path
+	}
+	if len(n.Children) > 0 {
+		c.Children = make([]*models.FileNode, len(n.Children))
+		for i, child := range n.Children {
+			c.Children[i] = aliasCopy(child, strings.TrimSuffix(p, "/")+"/"+child.Name)
+		}
+	}
+	return &c
+}
+
+// isPathWithin reports whether p is dir or below it.
+func isPathWithin(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,137 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── File Comments ───────────────────────────────────────────────────────────
+//
+// file_comments references files(path) with ON UPDATE/DELETE CASCADE, so
+// comments follow MoveFile and disappear when a file is purged.
+
+// CommentRow is a comment on a file.
+type CommentRow struct {
+	ID         int64
+	Path       string
+	AuthorID   int // 0 once the author is deleted
+	AuthorName string
+	Body       string
+	ParentID   int64 // 0 for top-level comments
+	Resolved   bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+const commentColumns = `c.id, c.file_path, COALESCE(c.author_id, 0), COALESCE(u.username, ''),
+	c.body, COALESCE(c.parent_comment_id, 0), c.resolved, c.created_at, c.updated_at`
+
+func scanComment(row interface{ Scan(...any) error }) (*CommentRow, error) {
+	var c CommentRow
+	err := row.Scan(&c.ID, &c.Path, &c.AuthorID, &c.AuthorName,
+		&c.Body, &c.ParentID, &c.Resolved, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListComments returns the comments on path, oldest first.
+func (s *Store) ListComments(ctx context.Context, path string) ([]CommentRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("list_comments", time.Since(start)) }()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+commentColumns+`
+		 FROM file_comments c LEFT JOIN users u ON u.id = c.author_id
+		 WHERE c.file_path = $1
+		 ORDER BY c.created_at, c.id`, normalizePath(path))
+	if err != nil {
+		return nil, fmt.Errorf("list comments: %w", err)
+	}
+	defer rows.Close()
+
+	var result []CommentRow
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan comment: %w", err)
+		}
+		result = append(result, *c)
+	}
+	return result, rows.Err()
+}
+
+// GetComment returns a comment by ID, or nil if there is none.
+func (s *Store) GetComment(ctx context.Context, id int64) (*CommentRow, error) {
+	c, err := scanComment(s.db.QueryRowContext(ctx,
+		`SELECT `+commentColumns+`
+		 FROM file_comments c LEFT JOIN users u ON u.id = c.author_id
+		 WHERE c.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get comment: %w", err)
+	}
+	return c, nil
+}
+
+// AddComment adds a comment to path. parentID is 0 for a top-level comment.
+func (s *Store) AddComment(ctx context.Context, path string, authorID int, body string, parentID int64) (*CommentRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("add_comment", time.Since(start)) }()
+
+	var parent *int64
+	if parentID > 0 {
+		parent = &parentID
+	}
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO file_comments (file_path, author_id, body, parent_comment_id)
+		 VALUES ($1, $2, $3, $4) RETURNING id`,
+		normalizePath(path), authorID, body, parent).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("add comment: %w", err)
+	}
+	return s.GetComment(ctx, id)
+}
+
+// UpdateComment changes a comment's body and/or resolved flag; nil leaves
+// a field unchanged.
+func (s *Store) UpdateComment(ctx context.Context, id int64, body *string, resolved *bool) (*CommentRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("update_comment", time.Since(start)) }()
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE file_comments SET
+			body = COALESCE($2, body),
+			resolved = COALESCE($3, resolved),
+			updated_at = NOW()
+		 WHERE id = $1`, id, body, resolved)
+	if err != nil {
+		return nil, fmt.Errorf("update comment: %w", err)
+	}
+	return s.GetComment(ctx, id)
+}
+
+// DeleteComment deletes a comment and its replies.
+func (s *Store) DeleteComment(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM file_comments WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete comment: %w", err)
+	}
+	return nil
+}
+
+// CommentCount returns the number of comments on path, replies included.
+func (s *Store) CommentCount(ctx context.Context, path string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM file_comments WHERE file_path = $1`, normalizePath(path)).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Sync Conflicts ──────────────────────────────────────────────────────────
+//
+// sync_conflicts keeps content that lost against a newer server version
+// until its user resolves the conflict. file_path is not a foreign key: the
+// losing content stays available after the file is moved or deleted.
+
+// ConflictRow is a sync conflict.
+type ConflictRow struct {
+	ID            int64
+	UserID        int
+	Path          string
+	Source        string
+	Device        string
+	ServerVersion int
+	ServerHash    string
+	MineKey       string
+	MineSize      int64
+	MineHash      string
+	StorageLocID  *int
+	Resolution    string // "" while open
+	CreatedAt     time.Time
+	ResolvedAt    *time.Time
+}
+
+const conflictColumns = `id, user_id, file_path, source, device, server_version, server_hash,
+	mine_key, mine_size, mine_hash, storage_location_id, resolution, created_at, resolved_at`
+
+func scanConflict(row interface{ Scan(...any) error }) (*ConflictRow, error) {
+	var c ConflictRow
+	var locID sql.NullInt64
+	var resolvedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.UserID, &c.Path, &c.Source, &c.Device, &c.ServerVersion, &c.ServerHash,
+		&c.MineKey, &c.MineSize, &c.MineHash, &locID, &c.Resolution, &c.CreatedAt, &resolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	if locID.Valid {
+		id := int(locID.Int64)
+		c.StorageLocID = &id
+	}
+	if resolvedAt.Valid {
+		c.ResolvedAt = &resolvedAt.Time
+	}
+	return &c, nil
+}
+
+// AddConflict records a conflict and returns it with its ID and creation
+// time set.
+func (s *Store) AddConflict(ctx context.Context, c *ConflictRow) (*ConflictRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("add_conflict", time.Since(start)) }()
+
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO sync_conflicts (user_id, file_path, source, device, server_version, server_hash,
+			mine_key, mine_size, mine_hash, storage_location_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		c.UserID, normalizePath(c.Path), c.Source, c.Device, c.ServerVersion, c.ServerHash,
+		c.MineKey, c.MineSize, c.MineHash, c.StorageLocID).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("add conflict: %w", err)
+	}
+	return s.GetConflict(ctx, id)
+}
+
+// FindOpenConflict returns the user's open conflict on path with content
+// hash mineHash, or nil if there is none, so that a retried upload does not
+// record the same conflict twice.
+func (s *Store) FindOpenConflict(ctx context.Context, userID int, path, mineHash string) (*ConflictRow, error) {
+	c, err := scanConflict(s.db.QueryRowContext(ctx,
+		`SELECT `+conflictColumns+` FROM sync_conflicts
+		 WHERE user_id = $1 AND file_path = $2 AND mine_hash = $3 AND resolution = ''
+		 ORDER BY id DESC LIMIT 1`, userID, normalizePath(path), mineHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find conflict: %w", err)
+	}
+	return c, nil
+}
+
+// GetConflict returns a conflict by ID, or nil if there is none.
+func (s *Store) GetConflict(ctx context.Context, id int64) (*ConflictRow, error) {
+	c, err := scanConflict(s.db.QueryRowContext(ctx,
+		`SELECT `+conflictColumns+` FROM sync_conflicts WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get conflict: %w", err)
+	}
+	return c, nil
+}
+
+// ListOpenConflicts returns the user's unresolved conflicts, newest first.
+func (s *Store) ListOpenConflicts(ctx context.Context, userID int) ([]ConflictRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("list_conflicts", time.Since(start)) }()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+conflictColumns+` FROM sync_conflicts
+		 WHERE user_id = $1 AND resolution = ''
+		 ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list conflicts: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ConflictRow
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan conflict: %w", err)
+		}
+		result = append(result, *c)
+	}
+	return result, rows.Err()
+}
+
+// ResolveConflict marks an open conflict resolved. It returns false if the
+// conflict was already resolved.
+func (s *Store) ResolveConflict(ctx context.Context, id int64, resolution string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE sync_conflicts SET resolution = $2, resolved_at = NOW()
+		 WHERE id = $1 AND resolution = ''`, id, resolution)
+	if err != nil {
+		return false, fmt.Errorf("resolve conflict: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
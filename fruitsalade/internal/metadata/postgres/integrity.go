@@ -0,0 +1,193 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Integrity Issues ────────────────────────────────────────────────────────
+//
+// The scrubber records files whose object does not hash to files.hash. An
+// issue stays open until the file is repaired or a later scrub finds it
+// intact; repaired issues are kept as a record.
+
+// IntegrityIssue is a file whose stored object failed verification.
+type IntegrityIssue struct {
+	Path         string     `json:"path"`
+	StorageLocID *int       `json:"storage_location_id,omitempty"`
+	S3Key        string     `json:"s3_key"`
+	ExpectedHash string     `json:"expected_hash"`
+	ActualHash   string     `json:"actual_hash,omitempty"` // "" when the object could not be read
+	Error        string     `json:"error,omitempty"`
+	DetectedAt   time.Time  `json:"detected_at"`
+	RepairedAt   *time.Time `json:"repaired_at,omitempty"`
+	RepairedFrom int        `json:"repaired_from,omitempty"` // version restored from
+}
+
+const integrityColumns = `file_path, storage_location_id, s3_key, expected_hash, actual_hash, error,
+	detected_at, repaired_at, COALESCE(repaired_from, 0)`
+
+func scanIntegrityIssue(row interface{ Scan(...any) error }) (*IntegrityIssue, error) {
+	var is IntegrityIssue
+	var locID sql.NullInt64
+	var repairedAt sql.NullTime
+	if err := row.Scan(&is.Path, &locID, &is.S3Key, &is.ExpectedHash, &is.ActualHash, &is.Error,
+		&is.DetectedAt, &repairedAt, &is.RepairedFrom); err != nil {
+		return nil, err
+	}
+	if locID.Valid {
+		id := int(locID.Int64)
+		is.StorageLocID = &id
+	}
+	if repairedAt.Valid {
+		is.RepairedAt = &repairedAt.Time
+	}
+	return &is, nil
+}
+
+// ListScrubFiles returns up to limit file rows with stored content, at or
+// below prefix ("" or "/" for all) and after the path after, ordered by
+// path. Trashed files are included.
+func (s *Store) ListScrubFiles(ctx context.Context, prefix, after string, limit int) ([]FileRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("list_scrub_files", time.Since(start)) }()
+
+	prefix = normalizePath(prefix)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT path, size, hash, s3_key, version, group_id, storage_location_id
+		 FROM files
+		 WHERE is_dir = FALSE AND s3_key <> '' AND path > $1
+		   AND ($2 = '/' OR path = $2 OR path LIKE $3)
+		 ORDER BY path LIMIT $4`,
+		after, prefix, strings.TrimSuffix(prefix, "/")+"/%", limit)
+	if err != nil {
+		return nil, fmt.Errorf("list scrub files: %w", err)
+	}
+	defer rows.Close()
+
+	var result []FileRow
+	for rows.Next() {
+		var r FileRow
+		var groupID, storageLocID sql.NullInt64
+		if err := rows.Scan(&r.Path, &r.Size, &r.Hash, &r.S3Key, &r.Version, &groupID, &storageLocID); err != nil {
+			return nil, fmt.Errorf("scan scrub file: %w", err)
+		}
+		if groupID.Valid {
+			gid := int(groupID.Int64)
+			r.GroupID = &gid
+		}
+		if storageLocID.Valid {
+			slid := int(storageLocID.Int64)
+			r.StorageLocID = &slid
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// BackfillFileHash records the hash of a file registered without one, as
+// long as the file still has no hash and is still stored at s3Key.
+func (s *Store) BackfillFileHash(ctx context.Context, path, s3Key, hash string) error {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("backfill_file_hash", time.Since(start)) }()
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE files SET hash = $3 WHERE path = $1 AND s3_key = $2 AND hash = ''`,
+		normalizePath(path), s3Key, hash)
+	if err != nil {
+		return fmt.Errorf("backfill hash: %w", err)
+	}
+	return nil
+}
+
+// RecordIntegrityIssue opens (or refreshes) the issue for a file.
+func (s *Store) RecordIntegrityIssue(ctx context.Context, is *IntegrityIssue) error {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("record_integrity_issue", time.Since(start)) }()
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO integrity_issues (file_path, storage_location_id, s3_key, expected_hash, actual_hash, error)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (file_path) DO UPDATE SET
+			storage_location_id = EXCLUDED.storage_location_id,
+			s3_key = EXCLUDED.s3_key,
+			expected_hash = EXCLUDED.expected_hash,
+			actual_hash = EXCLUDED.actual_hash,
+			error = EXCLUDED.error,
+			detected_at = NOW(),
+			repaired_at = NULL,
+			repaired_from = NULL`,
+		normalizePath(is.Path), is.StorageLocID, is.S3Key, is.ExpectedHash, is.ActualHash, is.Error)
+	if err != nil {
+		return fmt.Errorf("record integrity issue: %w", err)
+	}
+	return nil
+}
+
+// MarkIntegrityRepaired closes a file's issue, noting the version its
+// content was restored from.
+func (s *Store) MarkIntegrityRepaired(ctx context.Context, path string, fromVersion int) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE integrity_issues SET repaired_at = NOW(), repaired_from = $2 WHERE file_path = $1`,
+		normalizePath(path), fromVersion)
+	if err != nil {
+		return fmt.Errorf("mark integrity issue repaired: %w", err)
+	}
+	return nil
+}
+
+// ClearIntegrityIssue drops a file's open issue after it verified clean.
+func (s *Store) ClearIntegrityIssue(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM integrity_issues WHERE file_path = $1 AND repaired_at IS NULL`, normalizePath(path))
+	if err != nil {
+		return fmt.Errorf("clear integrity issue: %w", err)
+	}
+	return nil
+}
+
+// ListIntegrityIssues returns issues, newest first. Repaired ones are only
+// included if includeRepaired is set.
+func (s *Store) ListIntegrityIssues(ctx context.Context, includeRepaired bool, limit int) ([]IntegrityIssue, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("list_integrity_issues", time.Since(start)) }()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+integrityColumns+` FROM integrity_issues
+		 WHERE $1 OR repaired_at IS NULL
+		 ORDER BY detected_at DESC, file_path LIMIT $2`, includeRepaired, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list integrity issues: %w", err)
+	}
+	defer rows.Close()
+
+	result := []IntegrityIssue{}
+	for rows.Next() {
+		is, err := scanIntegrityIssue(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan integrity issue: %w", err)
+		}
+		result = append(result, *is)
+	}
+	return result, rows.Err()
+}
+
+// OpenIntegrityIssue returns a file's unrepaired issue, or nil if there is
+// none.
+func (s *Store) OpenIntegrityIssue(ctx context.Context, path string) (*IntegrityIssue, error) {
+	is, err := scanIntegrityIssue(s.db.QueryRowContext(ctx,
+		`SELECT `+integrityColumns+` FROM integrity_issues
+		 WHERE file_path = $1 AND repaired_at IS NULL`, normalizePath(path)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get integrity issue: %w", err)
+	}
+	return is, nil
+}
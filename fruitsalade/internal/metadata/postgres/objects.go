@@ -0,0 +1,217 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// ─── Content Deduplication ───────────────────────────────────────────────────
+//
+// With dedup enabled, uploads are stored once per storage location under
+// ContentKey(hash) and every files and file_versions row with that content
+// points at the same object. content_objects counts those rows; the object
+// is deleted when the last one goes. SaveVersion and CopyFileRow take their
+// reference in SQL, everything else through the methods below.
+//
+// Rows with other keys (written before dedup or with it disabled) own their
+// objects as before: versions are copied to VersionS3Key and deletes remove
+// the object directly.
+
+// contentKeyPrefix is where deduplicated content lives in every backend.
+const contentKeyPrefix = "_cas/"
+
+// ContentKey returns the storage key of the shared object holding hash.
+func ContentKey(hash string) string {
+	return contentKeyPrefix + hash
+}
+
+// IsContentKey reports whether key is a shared, reference-counted object.
+func IsContentKey(key string) bool {
+	return strings.HasPrefix(key, contentKeyPrefix)
+}
+
+// VersionContentKey returns where the content of a saved version is stored:
+// the shared object for deduplicated files, else the version's own copy.
+func VersionContentKey(path string, version int, s3Key string) string {
+	if IsContentKey(s3Key) {
+		return s3Key
+	}
+	return VersionS3Key(path, version)
+}
+
+// SetDedup enables content deduplication for new uploads. Call before
+// serving requests.
+func (s *Store) SetDedup(enabled bool) {
+	s.dedup = enabled
+}
+
+// PutContent stores content with the given hash at a storage location and
+// returns its key. With dedup enabled the content goes to ContentKey(hash)
+// and put is skipped when the location already has it; deduped reports
+// that. The caller's row then holds a reference and must point at key.
+// With dedup disabled put writes to fallbackKey.
+func (s *Store) PutContent(ctx context.Context, hash string, locID int, size int64, fallbackKey string, put func(key string) error) (key string, deduped bool, err error) {
+	if !s.dedup {
+		return fallbackKey, false, put(fallbackKey)
+	}
+
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("put_content", time.Since(start)) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Inserting or touching the row locks it until commit: concurrent
+	// uploads of the same content wait until the object is stored, and a
+	// release cannot delete it in between.
+	var refcount int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO content_objects (hash, storage_location_id, size)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (hash, storage_location_id) DO UPDATE SET hash = EXCLUDED.hash
+		 RETURNING refcount`,
+		hash, locID, size).Scan(&refcount)
+	if err != nil {
+		return "", false, fmt.Errorf("lock content object: %w", err)
+	}
+
+	key = ContentKey(hash)
+	deduped = refcount > 0
+	if !deduped {
+		if err := put(key); err != nil {
+			return "", false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE content_objects SET refcount = refcount + 1
+		 WHERE hash = $1 AND storage_location_id = $2`,
+		hash, locID); err != nil {
+		return "", false, fmt.Errorf("reference content object: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("commit: %w", err)
+	}
+
+	if deduped {
+		logging.Debug("deduplicated upload", zap.String("hash", hash), zap.Int("location", locID))
+	}
+	return key, deduped, nil
+}
+
+// RefContent takes another reference on a shared object for a row that now
+// points at key.
+func (s *Store) RefContent(ctx context.Context, key string, locID int) error {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("ref_content", time.Since(start)) }()
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE content_objects SET refcount = refcount + 1
+		 WHERE hash = $1 AND storage_location_id = $2 AND refcount > 0`,
+		strings.TrimPrefix(key, contentKeyPrefix), locID)
+	if err != nil {
+		return fmt.Errorf("reference content object: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("content object not found: %s", key)
+	}
+	return nil
+}
+
+// ReleaseContent drops a row's hold on key and calls del when nothing else
+// needs the object: at once for keys owned by a single row, when the last
+// reference goes for shared objects. A shared object is kept if del fails.
+func (s *Store) ReleaseContent(ctx context.Context, key string, locID *int, del func(ctx context.Context, key string) error) error {
+	if key == "" {
+		return nil
+	}
+	if !IsContentKey(key) {
+		return del(ctx, key)
+	}
+	if locID == nil {
+		return fmt.Errorf("content object %s has no storage location", key)
+	}
+
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("release_content", time.Since(start)) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	hash := strings.TrimPrefix(key, contentKeyPrefix)
+	var refcount int
+	err = tx.QueryRowContext(ctx,
+		`UPDATE content_objects SET refcount = refcount - 1
+		 WHERE hash = $1 AND storage_location_id = $2
+		 RETURNING refcount`,
+		hash, *locID).Scan(&refcount)
+	if err == sql.ErrNoRows {
+		// Not counted, so not ours to delete
+		logging.Warn("release of untracked content object", zap.String("key", key), zap.Int("location", *locID))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("release content object: %w", err)
+	}
+
+	if refcount <= 0 {
+		if err := del(ctx, key); err != nil {
+			return fmt.Errorf("delete content object: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM content_objects WHERE hash = $1 AND storage_location_id = $2`,
+			hash, *locID); err != nil {
+			return fmt.Errorf("delete content object row: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ReleaseReplaced releases what a file row held on oldKey after it was
+// pointed at newKey. A legacy key the row still writes to is left alone.
+func (s *Store) ReleaseReplaced(ctx context.Context, oldKey, newKey string, locID *int, del func(ctx context.Context, key string) error) error {
+	if !IsContentKey(oldKey) && oldKey == newKey {
+		return nil
+	}
+	return s.ReleaseContent(ctx, oldKey, locID, del)
+}
+
+// DedupStats summarizes content_objects.
+type DedupStats struct {
+	Objects      int64 `json:"objects"`
+	References   int64 `json:"references"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	LogicalBytes int64 `json:"logical_bytes"`
+	SavedBytes   int64 `json:"saved_bytes"`
+}
+
+// GetDedupStats returns how much storage deduplication saves: LogicalBytes
+// is what the referencing rows would occupy with a copy each.
+func (s *Store) GetDedupStats(ctx context.Context) (*DedupStats, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("dedup_stats", time.Since(start)) }()
+
+	var st DedupStats
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(refcount), 0), COALESCE(SUM(size), 0), COALESCE(SUM(size * refcount), 0)
+		 FROM content_objects`).
+		Scan(&st.Objects, &st.References, &st.StoredBytes, &st.LogicalBytes)
+	if err != nil {
+		return nil, fmt.Errorf("dedup stats: %w", err)
+	}
+	st.SavedBytes = st.LogicalBytes - st.StoredBytes
+	return &st, nil
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Auto-Organize Rules ─────────────────────────────────────────────────────
+//
+// organize_rules references files(path) with ON UPDATE/DELETE CASCADE, so a
+// rule follows its directory when it moves and goes away when it is purged.
+
+// OrganizeRule sorts media in a directory into date-named subdirectories.
+type OrganizeRule struct {
+	DirPath   string
+	Pattern   string
+	Fallback  string
+	CreatedBy int // 0 once the user is deleted
+	UpdatedAt time.Time
+}
+
+// GetOrganizeRule returns the rule of dir, or nil if it has none.
+func (s *Store) GetOrganizeRule(ctx context.Context, dir string) (*OrganizeRule, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("get_organize_rule", time.Since(start)) }()
+
+	var r OrganizeRule
+	err := s.db.QueryRowContext(ctx,
+		`SELECT dir_path, pattern, fallback, COALESCE(created_by, 0), updated_at
+		 FROM organize_rules WHERE dir_path = $1`, normalizePath(dir)).
+		Scan(&r.DirPath, &r.Pattern, &r.Fallback, &r.CreatedBy, &r.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get organize rule: %w", err)
+	}
+	return &r, nil
+}
+
+// SetOrganizeRule creates or replaces the rule of dir.
+func (s *Store) SetOrganizeRule(ctx context.Context, dir, pattern, fallback string, userID int) (*OrganizeRule, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("set_organize_rule", time.Since(start)) }()
+
+	var createdBy *int
+	if userID > 0 {
+		createdBy = &userID
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO organize_rules (dir_path, pattern, fallback, created_by)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (dir_path) DO UPDATE SET
+			pattern = EXCLUDED.pattern,
+			fallback = EXCLUDED.fallback,
+			updated_at = NOW()`,
+		normalizePath(dir), pattern, fallback, createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("set organize rule: %w", err)
+	}
+	return s.GetOrganizeRule(ctx, dir)
+}
+
+// DeleteOrganizeRule removes the rule of dir and reports whether there was one.
+func (s *Store) DeleteOrganizeRule(ctx context.Context, dir string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM organize_rules WHERE dir_path = $1`, normalizePath(dir))
+	if err != nil {
+		return false, fmt.Errorf("delete organize rule: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -5,41 +5,45 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
 	"go.uber.org/zap"
 )
 
 // Store is a PostgreSQL metadata store.
 type Store struct {
-	db *sql.DB
+	db    *sql.DB
+	dedup bool
 }
 
 // FileRow maps to the files table.
 type FileRow struct {
-	ID           string
-	Name         string
-	Path         string
-	ParentPath   string
-	Size         int64
-	ModTime      time.Time
-	IsDir        bool
-	Hash         string
-	S3Key        string
-	Version      int
-	OwnerID      *int
-	Visibility   string // "public", "group", "private"
-	GroupID      *int
-	StorageLocID *int // Storage location ID (NULL = default)
+	ID            string
+	Name          string
+	Path          string
+	ParentPath    string
+	Size          int64
+	ModTime       time.Time
+	IsDir         bool
+	Hash          string
+	S3Key         string
+	Version       int
+	OwnerID       *int
+	Visibility    string // "public", "group", "private"
+	GroupID       *int
+	StorageLocID  *int   // Storage location ID (NULL = default)
+	AliasTargetID string // ID of the target of an alias row, "" for others
 }
 
 // New creates a new PostgreSQL metadata store.
@@ -106,7 +110,8 @@ func (s *Store) BuildTree(ctx context.Context) (*models.FileNode, error) {
 	}()
 
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key, version, owner_id, visibility, group_id, storage_location_id
+		`SELECT id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key, version, owner_id, visibility, group_id, storage_location_id,
+		        COALESCE(alias_target_id, '')
 		 FROM files WHERE deleted_at IS NULL ORDER BY path`)
 	if err != nil {
 		return nil, fmt.Errorf("query files: %w", err)
@@ -122,7 +127,8 @@ func (s *Store) BuildTree(ctx context.Context) (*models.FileNode, error) {
 		var ownerID, groupID, storageLocID sql.NullInt64
 		var visibility sql.NullString
 		if err := rows.Scan(&r.ID, &r.Name, &r.Path, &r.ParentPath,
-			&r.Size, &r.ModTime, &r.IsDir, &r.Hash, &r.S3Key, &r.Version, &ownerID, &visibility, &groupID, &storageLocID); err != nil {
+			&r.Size, &r.ModTime, &r.IsDir, &r.Hash, &r.S3Key, &r.Version, &ownerID, &visibility, &groupID, &storageLocID,
+			&r.AliasTargetID); err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
 		}
 		if ownerID.Valid {
@@ -183,6 +189,17 @@ func (s *Store) BuildTree(ctx context.Context) (*models.FileNode, error) {
 		}
 	}
 
+	// Rows come in path order, which depends on the database collation;
+	// children are served in the DefaultOrder
+	for _, node := range nodeMap {
+		fstree.DefaultOrder.Sort(node.Children)
+		node.SetChildCount(len(node.Children))
+	}
+	fstree.DefaultOrder.Sort(root.Children)
+	root.SetChildCount(len(root.Children))
+	resolveAliases(allRows, nodeMap)
+	fstree.Aggregate(root)
+
 	logging.Debug("built metadata tree", zap.Int("nodes", len(allRows)))
 	return root, nil
 }
@@ -248,6 +265,52 @@ func (s *Store) GetFileRow(ctx context.Context, path string) (*FileRow, error) {
 	return &r, nil
 }
 
+// GetFileNodes returns the live (not trashed) nodes at paths, keyed by
+// path. Paths without a live row are absent from the result. The nodes have
+// no children.
+func (s *Store) GetFileNodes(ctx context.Context, paths []string) (map[string]*models.FileNode, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("get_file_nodes", time.Since(start)) }()
+
+	normalized := make([]string, len(paths))
+	for i, p := range paths {
+		normalized[i] = normalizePath(p)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key, version, owner_id, visibility, group_id
+		 FROM files WHERE path = ANY($1) AND deleted_at IS NULL`, pq.Array(normalized))
+	if err != nil {
+		return nil, fmt.Errorf("query files: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make(map[string]*models.FileNode, len(paths))
+	for rows.Next() {
+		var r FileRow
+		var ownerID, groupID sql.NullInt64
+		var visibility sql.NullString
+		if err := rows.Scan(&r.ID, &r.Name, &r.Path, &r.ParentPath,
+			&r.Size, &r.ModTime, &r.IsDir, &r.Hash, &r.S3Key, &r.Version, &ownerID, &visibility, &groupID); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		if ownerID.Valid {
+			oid := int(ownerID.Int64)
+			r.OwnerID = &oid
+		}
+		if visibility.Valid {
+			r.Visibility = visibility.String
+		} else {
+			r.Visibility = "public"
+		}
+		if groupID.Valid {
+			gid := int(groupID.Int64)
+			r.GroupID = &gid
+		}
+		nodes[r.Path] = rowToNode(&r)
+	}
+	return nodes, rows.Err()
+}
+
 // ListDir returns children of a directory.
 func (s *Store) ListDir(ctx context.Context, path string) ([]*models.FileNode, error) {
 	start := time.Now()
@@ -275,6 +338,60 @@ func (s *Store) ListDir(ctx context.Context, path string) ([]*models.FileNode, e
 	return nodes, rows.Err()
 }
 
+// AddAggregates sets AggSize and ItemCount on the directories among nodes,
+// e.g. the results of GetMetadata or ListDir, which leave them zero. It
+// runs one query however many directories there are.
+func (s *Store) AddAggregates(ctx context.Context, nodes ...*models.FileNode) error {
+	var paths, patterns []string
+	for _, n := range nodes {
+		if n.IsDir {
+			p := normalizePath(n.Path)
+			paths = append(paths, p)
+			patterns = append(patterns, escapeLike(strings.TrimSuffix(p, "/"))+"/%")
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("dir_aggregates", time.Since(start)) }()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT d.path, COALESCE(SUM(f.size) FILTER (WHERE NOT f.is_dir), 0), COUNT(f.path)
+		 FROM unnest($1::text[], $2::text[]) AS d(path, pattern)
+		 LEFT JOIN files f ON f.path LIKE d.pattern AND f.path <> d.path AND f.deleted_at IS NULL
+		 GROUP BY d.path`, pq.Array(paths), pq.Array(patterns))
+	if err != nil {
+		return fmt.Errorf("query aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	type agg struct {
+		size  int64
+		count int
+	}
+	aggs := make(map[string]agg, len(paths))
+	for rows.Next() {
+		var p string
+		var a agg
+		if err := rows.Scan(&p, &a.size, &a.count); err != nil {
+			return fmt.Errorf("scan aggregate: %w", err)
+		}
+		aggs[p] = a
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		if a, ok := aggs[normalizePath(n.Path)]; ok && n.IsDir {
+			n.AggSize, n.ItemCount = a.size, a.count
+		}
+	}
+	return nil
+}
+
 // GetS3Key returns the S3 object key for a file ID.
 func (s *Store) GetS3Key(ctx context.Context, fileID string) (string, error) {
 	start := time.Now()
@@ -341,6 +458,34 @@ func (s *Store) UpsertFile(ctx context.Context, f *FileRow) error {
 	return nil
 }
 
+// InsertFile adds f unless a row, live or trashed, already holds its path.
+// It reports whether f was added.
+func (s *Store) InsertFile(ctx context.Context, f *FileRow) (bool, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("insert_file", time.Since(start)) }()
+	return insertFile(ctx, s.db, f)
+}
+
+// querier is what the helpers shared by Store methods and their
+// transactions need: a *sql.DB or a *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertFile(ctx context.Context, q querier, f *FileRow) (bool, error) {
+	result, err := q.ExecContext(ctx,
+		`INSERT INTO files (id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key, version, owner_id, visibility, group_id, storage_location_id, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW())
+		 ON CONFLICT (path) DO NOTHING`,
+		f.ID, f.Name, f.Path, f.ParentPath, f.Size, f.ModTime, f.IsDir, f.Hash, f.S3Key, f.Version, f.OwnerID, f.Visibility, f.GroupID, f.StorageLocID)
+	if err != nil {
+		return false, fmt.Errorf("insert: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
 // DeleteFile removes a file entry.
 func (s *Store) DeleteFile(ctx context.Context, path string) error {
 	start := time.Now()
@@ -356,21 +501,28 @@ func (s *Store) DeleteFile(ctx context.Context, path string) error {
 	return nil
 }
 
-// DeleteTree removes a directory and all its children.
-func (s *Store) DeleteTree(ctx context.Context, path string) (int64, error) {
+// DeleteTree removes a directory and all its children. Returns storage info
+// of the removed files for cleanup.
+func (s *Store) DeleteTree(ctx context.Context, path string) ([]PurgeFileRow, error) {
 	start := time.Now()
 	defer func() { metrics.RecordDBQuery("delete_tree", time.Since(start)) }()
 
 	path = normalizePath(path)
-	result, err := s.db.ExecContext(ctx,
-		`DELETE FROM files WHERE path = $1 OR path LIKE $2`,
+	rows, err := s.db.QueryContext(ctx,
+		`DELETE FROM files WHERE path = $1 OR path LIKE $2
+		 RETURNING s3_key, storage_location_id, group_id, path, deleted_by`,
 		path, path+"/%")
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	rows, _ := result.RowsAffected()
-	logging.Debug("deleted tree", zap.String("path", path), zap.Int64("rows", rows))
-	return rows, nil
+	defer rows.Close()
+
+	deleted, err := s.finishPurge(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	logging.Debug("deleted tree", zap.String("path", path), zap.Int("rows", len(deleted)))
+	return deleted, nil
 }
 
 // FileCount returns the total number of file entries.
@@ -383,6 +535,10 @@ func (s *Store) FileCount(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+// ErrPathExists is returned when a row, live or trashed, already holds
+// the path a file would be written to.
+var ErrPathExists = errors.New("path already exists")
+
 // PathExists checks if a path exists in the database.
 func (s *Store) PathExists(ctx context.Context, path string) (bool, error) {
 	path = normalizePath(path)
@@ -425,22 +581,37 @@ type VersionRecord struct {
 	CreatedAt    time.Time
 }
 
-// SaveVersion saves the current file state as a version record.
+// SaveVersion saves the current file state as a version record. A version
+// of deduplicated content takes a reference on the shared object instead of
+// needing a copy.
 func (s *Store) SaveVersion(ctx context.Context, path string) error {
 	start := time.Now()
 	defer func() { metrics.RecordDBQuery("save_version", time.Since(start)) }()
 
-	path = normalizePath(path)
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO file_versions (file_id, path, version, size, hash, s3_key, storage_location_id)
-		 SELECT id, path, version, size, hash, s3_key, storage_location_id FROM files WHERE path = $1
-		 ON CONFLICT (path, version) DO NOTHING`,
-		path)
+	if err := saveVersion(ctx, s.db, normalizePath(path)); err != nil {
+		return err
+	}
+	logging.Debug("saved version", zap.String("path", path))
+	return nil
+}
+
+func saveVersion(ctx context.Context, q querier, path string) error {
+	_, err := q.ExecContext(ctx,
+		`WITH v AS (
+			INSERT INTO file_versions (file_id, path, version, size, hash, s3_key, storage_location_id)
+			SELECT id, path, version, size, hash, s3_key, storage_location_id FROM files WHERE path = $1
+			ON CONFLICT (path, version) DO NOTHING
+			RETURNING s3_key, storage_location_id
+		 )
+		 UPDATE content_objects o SET refcount = o.refcount + 1
+		 FROM v
+		 WHERE LEFT(v.s3_key, LENGTH($2)) = $2
+		   AND o.hash = SUBSTRING(v.s3_key FROM LENGTH($2) + 1)
+		   AND o.storage_location_id = v.storage_location_id`,
+		path, contentKeyPrefix)
 	if err != nil {
 		return fmt.Errorf("save version: %w", err)
 	}
-
-	logging.Debug("saved version", zap.String("path", path))
 	return nil
 }
 
@@ -574,6 +745,179 @@ func (s *Store) ListVersionedFiles(ctx context.Context) ([]VersionedFileSummary,
 	return results, rows.Err()
 }
 
+// VersionS3Key returns the storage key holding the content of a saved version.
+func VersionS3Key(path string, version int) string {
+	return fmt.Sprintf("_versions/%s/%d", strings.TrimPrefix(path, "/"), version)
+}
+
+// DeleteVersion removes a single version record and returns it so the caller
+// can delete the stored content.
+func (s *Store) DeleteVersion(ctx context.Context, path string, version int) (*VersionRecord, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("delete_version", time.Since(start)) }()
+
+	path = normalizePath(path)
+	var v VersionRecord
+	var slid sql.NullInt64
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM file_versions WHERE path = $1 AND version = $2
+		 RETURNING file_id, path, version, size, hash, s3_key, storage_location_id, created_at`,
+		path, version).
+		Scan(&v.FileID, &v.Path, &v.Version, &v.Size, &v.Hash, &v.S3Key, &slid, &v.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("delete version %d for %s: %w", version, path, err)
+	}
+	if slid.Valid {
+		id := int(slid.Int64)
+		v.StorageLocID = &id
+	}
+	return &v, nil
+}
+
+// ─── Version Retention ───────────────────────────────────────────────────────
+
+// RetentionPolicy limits how many old versions are kept. Zero means unlimited.
+type RetentionPolicy struct {
+	KeepCount  int `json:"keep_count"`
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// RetentionOverride is a per-path retention policy. Nil fields inherit the
+// server-wide default.
+type RetentionOverride struct {
+	Path       string    `json:"path"`
+	KeepCount  *int      `json:"keep_count"`
+	MaxAgeDays *int      `json:"max_age_days"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PrunedVersion identifies a version whose record was removed and whose
+// content must be released with ReleaseContent.
+type PrunedVersion struct {
+	Path         string
+	Version      int
+	Size         int64
+	StorageKey   string
+	StorageLocID *int
+}
+
+// ListRetentionOverrides returns all per-path retention overrides.
+func (s *Store) ListRetentionOverrides(ctx context.Context) ([]RetentionOverride, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT path, keep_count, max_age_days, updated_at
+		 FROM version_retention_policies ORDER BY path`)
+	if err != nil {
+		return nil, fmt.Errorf("list retention overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var result []RetentionOverride
+	for rows.Next() {
+		var o RetentionOverride
+		var keep, age sql.NullInt64
+		if err := rows.Scan(&o.Path, &keep, &age, &o.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan retention override: %w", err)
+		}
+		if keep.Valid {
+			v := int(keep.Int64)
+			o.KeepCount = &v
+		}
+		if age.Valid {
+			v := int(age.Int64)
+			o.MaxAgeDays = &v
+		}
+		result = append(result, o)
+	}
+	return result, rows.Err()
+}
+
+// SetRetentionOverride creates or replaces the retention override for a path.
+func (s *Store) SetRetentionOverride(ctx context.Context, path string, keepCount, maxAgeDays *int) error {
+	path = normalizePath(path)
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO version_retention_policies (path, keep_count, max_age_days)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (path) DO UPDATE SET
+			keep_count = EXCLUDED.keep_count,
+			max_age_days = EXCLUDED.max_age_days,
+			updated_at = NOW()`,
+		path, keepCount, maxAgeDays)
+	if err != nil {
+		return fmt.Errorf("set retention override: %w", err)
+	}
+	return nil
+}
+
+// DeleteRetentionOverride removes the retention override for a path.
+func (s *Store) DeleteRetentionOverride(ctx context.Context, path string) error {
+	path = normalizePath(path)
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM version_retention_policies WHERE path = $1`, path)
+	if err != nil {
+		return fmt.Errorf("delete retention override: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("retention override not found: %s", path)
+	}
+	return nil
+}
+
+// PruneVersions deletes version records that fall outside the retention
+// policy and returns what was removed. Each version is checked against the
+// longest matching per-path override, falling back to defaults. A version is
+// pruned when it is beyond the newest KeepCount versions or older than
+// MaxAgeDays, whichever limits are set.
+func (s *Store) PruneVersions(ctx context.Context, defaults RetentionPolicy) ([]PrunedVersion, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("prune_versions", time.Since(start)) }()
+
+	rows, err := s.db.QueryContext(ctx,
+		`WITH ranked AS (
+			SELECT fv.id,
+			       ROW_NUMBER() OVER (PARTITION BY fv.path ORDER BY fv.version DESC) AS rn,
+			       fv.created_at,
+			       COALESCE(p.keep_count, $1) AS keep_count,
+			       COALESCE(p.max_age_days, $2) AS max_age_days
+			FROM file_versions fv
+			LEFT JOIN LATERAL (
+				SELECT vp.keep_count, vp.max_age_days
+				FROM version_retention_policies vp
+				WHERE vp.path = '/' OR vp.path = fv.path
+				   OR LEFT(fv.path, LENGTH(vp.path) + 1) = vp.path || '/'
+				ORDER BY LENGTH(vp.path) DESC
+				LIMIT 1
+			) p ON TRUE
+		 )
+		 DELETE FROM file_versions fv
+		 USING ranked r
+		 WHERE fv.id = r.id
+		   AND ((r.keep_count > 0 AND r.rn > r.keep_count)
+		     OR (r.max_age_days > 0 AND r.created_at < NOW() - (r.max_age_days || ' days')::INTERVAL))
+		 RETURNING fv.path, fv.version, fv.size, fv.s3_key, fv.storage_location_id`,
+		defaults.KeepCount, defaults.MaxAgeDays)
+	if err != nil {
+		return nil, fmt.Errorf("prune versions: %w", err)
+	}
+	defer rows.Close()
+
+	var pruned []PrunedVersion
+	for rows.Next() {
+		var p PrunedVersion
+		var s3Key string
+		var slid sql.NullInt64
+		if err := rows.Scan(&p.Path, &p.Version, &p.Size, &s3Key, &slid); err != nil {
+			return nil, fmt.Errorf("scan pruned version: %w", err)
+		}
+		if slid.Valid {
+			id := int(slid.Int64)
+			p.StorageLocID = &id
+		}
+		p.StorageKey = VersionContentKey(p.Path, p.Version, s3Key)
+		pruned = append(pruned, p)
+	}
+	return pruned, rows.Err()
+}
+
 // ─── Trash (Soft Delete) ─────────────────────────────────────────────────────
 
 // TrashRow holds a trashed file's information.
@@ -585,10 +929,24 @@ type TrashRow struct {
 	IsDir         bool
 	DeletedAt     time.Time
 	DeletedByName string
+	// RetentionDays is the deleting user's trash retention override, nil
+	// if the server setting applies
+	RetentionDays *int
 }
 
 // SoftDeleteFile marks a file (or directory tree) as deleted.
 func (s *Store) SoftDeleteFile(ctx context.Context, path string, userID int) error {
+	return s.softDelete(ctx, path, &userID)
+}
+
+// SoftDeleteUnattributed marks a file (or directory tree) as deleted
+// without recording who deleted it, for maintenance tools that act on
+// behalf of no user.
+func (s *Store) SoftDeleteUnattributed(ctx context.Context, path string) error {
+	return s.softDelete(ctx, path, nil)
+}
+
+func (s *Store) softDelete(ctx context.Context, path string, userID *int) error {
 	start := time.Now()
 	defer func() { metrics.RecordDBQuery("soft_delete_file", time.Since(start)) }()
 
@@ -600,10 +958,59 @@ func (s *Store) SoftDeleteFile(ctx context.Context, path string, userID int) err
 	if err != nil {
 		return fmt.Errorf("soft delete: %w", err)
 	}
-	logging.Debug("soft-deleted file", zap.String("path", path), zap.Int("user_id", userID))
+	if userID != nil {
+		logging.Debug("soft-deleted file", zap.String("path", path), zap.Int("user_id", *userID))
+	} else {
+		logging.Debug("soft-deleted file", zap.String("path", path))
+	}
 	return nil
 }
 
+// SubtreeRow is a file row with its trash state, as returned by ListSubtree.
+type SubtreeRow struct {
+	FileRow
+	Deleted bool
+}
+
+// ListSubtree returns the rows at and below path, trashed ones included,
+// ordered by path.
+func (s *Store) ListSubtree(ctx context.Context, path string) ([]SubtreeRow, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("list_subtree", time.Since(start)) }()
+
+	path = normalizePath(path)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key,
+		        owner_id, COALESCE(visibility, 'public'), group_id, deleted_at IS NOT NULL
+		 FROM files WHERE path = $1 OR path LIKE $2 ORDER BY path`,
+		path, strings.TrimSuffix(path, "/")+"/%")
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SubtreeRow
+	for rows.Next() {
+		var r SubtreeRow
+		var ownerID, groupID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Name, &r.Path, &r.ParentPath,
+			&r.Size, &r.ModTime, &r.IsDir, &r.Hash, &r.S3Key,
+			&ownerID, &r.Visibility, &groupID, &r.Deleted); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		if ownerID.Valid {
+			oid := int(ownerID.Int64)
+			r.OwnerID = &oid
+		}
+		if groupID.Valid {
+			gid := int(groupID.Int64)
+			r.GroupID = &gid
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
 // ListTrash returns all soft-deleted files. If userID is non-nil, filters by deleted_by.
 func (s *Store) ListTrash(ctx context.Context, userID *int) ([]TrashRow, error) {
 	start := time.Now()
@@ -613,15 +1020,17 @@ func (s *Store) ListTrash(ctx context.Context, userID *int) ([]TrashRow, error)
 	var args []interface{}
 	if userID != nil {
 		query = `SELECT f.id, f.name, f.original_path, f.size, f.is_dir, f.deleted_at,
-		         COALESCE(u.username, '') AS deleted_by_name
+		         COALESCE(u.username, '') AS deleted_by_name, q.trash_retention_days
 		         FROM files f LEFT JOIN users u ON u.id = f.deleted_by
+		         LEFT JOIN user_quotas q ON q.user_id = f.deleted_by
 		         WHERE f.deleted_at IS NOT NULL AND f.deleted_by = $1
 		         ORDER BY f.deleted_at DESC`
 		args = []interface{}{*userID}
 	} else {
 		query = `SELECT f.id, f.name, f.original_path, f.size, f.is_dir, f.deleted_at,
-		         COALESCE(u.username, '') AS deleted_by_name
+		         COALESCE(u.username, '') AS deleted_by_name, q.trash_retention_days
 		         FROM files f LEFT JOIN users u ON u.id = f.deleted_by
+		         LEFT JOIN user_quotas q ON q.user_id = f.deleted_by
 		         WHERE f.deleted_at IS NOT NULL
 		         ORDER BY f.deleted_at DESC`
 	}
@@ -635,10 +1044,15 @@ func (s *Store) ListTrash(ctx context.Context, userID *int) ([]TrashRow, error)
 	var items []TrashRow
 	for rows.Next() {
 		var t TrashRow
+		var retention sql.NullInt64
 		if err := rows.Scan(&t.ID, &t.Name, &t.OriginalPath, &t.Size, &t.IsDir,
-			&t.DeletedAt, &t.DeletedByName); err != nil {
+			&t.DeletedAt, &t.DeletedByName, &retention); err != nil {
 			return nil, fmt.Errorf("scan trash: %w", err)
 		}
+		if retention.Valid {
+			days := int(retention.Int64)
+			t.RetentionDays = &days
+		}
 		items = append(items, t)
 	}
 	return items, rows.Err()
@@ -670,6 +1084,8 @@ type PurgeFileRow struct {
 	S3Key        string
 	StorageLocID *int
 	GroupID      *int
+	Path         string
+	DeletedBy    *int
 }
 
 // PurgeFile permanently deletes a trashed file. Returns storage info for cleanup.
@@ -680,18 +1096,25 @@ func (s *Store) PurgeFile(ctx context.Context, originalPath string) ([]PurgeFile
 	originalPath = normalizePath(originalPath)
 	rows, err := s.db.QueryContext(ctx,
 		`DELETE FROM files WHERE original_path = $1 AND deleted_at IS NOT NULL
-		 RETURNING s3_key, storage_location_id, group_id`,
+		 RETURNING s3_key, storage_location_id, group_id, path, deleted_by`,
 		originalPath)
 	if err != nil {
 		return nil, fmt.Errorf("purge file: %w", err)
 	}
 	defer rows.Close()
 
+	return s.finishPurge(ctx, rows)
+}
+
+// finishPurge scans the rows returned by a purge, then drops the favorites
+// that pointed at the purged paths.
+func (s *Store) finishPurge(ctx context.Context, rows *sql.Rows) ([]PurgeFileRow, error) {
 	var purged []PurgeFileRow
+	var paths []string
 	for rows.Next() {
 		var p PurgeFileRow
-		var slid, gid sql.NullInt64
-		if err := rows.Scan(&p.S3Key, &slid, &gid); err != nil {
+		var slid, gid, deletedBy sql.NullInt64
+		if err := rows.Scan(&p.S3Key, &slid, &gid, &p.Path, &deletedBy); err != nil {
 			return nil, fmt.Errorf("scan purge: %w", err)
 		}
 		if slid.Valid {
@@ -702,9 +1125,22 @@ func (s *Store) PurgeFile(ctx context.Context, originalPath string) ([]PurgeFile
 			id := int(gid.Int64)
 			p.GroupID = &id
 		}
+		if deletedBy.Valid {
+			id := int(deletedBy.Int64)
+			p.DeletedBy = &id
+		}
 		purged = append(purged, p)
+		paths = append(paths, p.Path)
 	}
-	return purged, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := s.dropFavorites(ctx, paths); err != nil {
+		logging.Warn("failed to drop favorites of purged files", zap.Error(err))
+	}
+	return purged, nil
 }
 
 // PurgeAllTrash permanently deletes all trashed files. Returns storage info for cleanup.
@@ -714,64 +1150,38 @@ func (s *Store) PurgeAllTrash(ctx context.Context) ([]PurgeFileRow, error) {
 
 	rows, err := s.db.QueryContext(ctx,
 		`DELETE FROM files WHERE deleted_at IS NOT NULL
-		 RETURNING s3_key, storage_location_id, group_id`)
+		 RETURNING s3_key, storage_location_id, group_id, path, deleted_by`)
 	if err != nil {
 		return nil, fmt.Errorf("purge all trash: %w", err)
 	}
 	defer rows.Close()
 
-	var purged []PurgeFileRow
-	for rows.Next() {
-		var p PurgeFileRow
-		var slid, gid sql.NullInt64
-		if err := rows.Scan(&p.S3Key, &slid, &gid); err != nil {
-			return nil, fmt.Errorf("scan purge: %w", err)
-		}
-		if slid.Valid {
-			id := int(slid.Int64)
-			p.StorageLocID = &id
-		}
-		if gid.Valid {
-			id := int(gid.Int64)
-			p.GroupID = &id
-		}
-		purged = append(purged, p)
-	}
-	return purged, rows.Err()
+	return s.finishPurge(ctx, rows)
 }
 
-// PurgeExpiredTrash permanently deletes trash items older than maxAge. Returns storage info.
-func (s *Store) PurgeExpiredTrash(ctx context.Context, maxAge time.Duration) ([]PurgeFileRow, error) {
+// PurgeExpiredTrash permanently deletes trash items kept longer than their
+// retention: the deleting user's trash_retention_days if set, defaultDays
+// otherwise. A retention of 0 keeps items forever. Returns storage info.
+func (s *Store) PurgeExpiredTrash(ctx context.Context, defaultDays int) ([]PurgeFileRow, error) {
 	start := time.Now()
 	defer func() { metrics.RecordDBQuery("purge_expired_trash", time.Since(start)) }()
 
-	cutoff := time.Now().Add(-maxAge)
 	rows, err := s.db.QueryContext(ctx,
-		`DELETE FROM files WHERE deleted_at IS NOT NULL AND deleted_at < $1
-		 RETURNING s3_key, storage_location_id, group_id`, cutoff)
+		`WITH expired AS (
+		     SELECT f.id FROM files f
+		     LEFT JOIN user_quotas q ON q.user_id = f.deleted_by
+		     WHERE f.deleted_at IS NOT NULL
+		       AND COALESCE(q.trash_retention_days, $1) > 0
+		       AND f.deleted_at < NOW() - COALESCE(q.trash_retention_days, $1) * INTERVAL '1 day'
+		 )
+		 DELETE FROM files WHERE id IN (SELECT id FROM expired)
+		 RETURNING s3_key, storage_location_id, group_id, path, deleted_by`, defaultDays)
 	if err != nil {
 		return nil, fmt.Errorf("purge expired trash: %w", err)
 	}
 	defer rows.Close()
 
-	var purged []PurgeFileRow
-	for rows.Next() {
-		var p PurgeFileRow
-		var slid, gid sql.NullInt64
-		if err := rows.Scan(&p.S3Key, &slid, &gid); err != nil {
-			return nil, fmt.Errorf("scan purge: %w", err)
-		}
-		if slid.Valid {
-			id := int(slid.Int64)
-			p.StorageLocID = &id
-		}
-		if gid.Valid {
-			id := int(gid.Int64)
-			p.GroupID = &id
-		}
-		purged = append(purged, p)
-	}
-	return purged, rows.Err()
+	return s.finishPurge(ctx, rows)
 }
 
 // ─── Favorites ───────────────────────────────────────────────────────────────
@@ -807,13 +1217,71 @@ func (s *Store) RemoveFavorite(ctx context.Context, userID int, filePath string)
 	return nil
 }
 
-// FavoriteRow holds favorite info joined with file metadata.
+// moveFavorites points the favorites of oldPath and anything below it at
+// newPath. A user who already has the new path as a favorite keeps that one.
+func moveFavorites(ctx context.Context, tx *sql.Tx, oldPath, newPath string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE user_favorites uf
+		 SET file_path = CASE WHEN uf.file_path = $2 THEN $1
+		                      ELSE $1 || substring(uf.file_path from length($2) + 1) END
+		 WHERE (uf.file_path = $2 OR uf.file_path LIKE $2 || '/%')
+		   AND NOT EXISTS (
+		     SELECT 1 FROM user_favorites o WHERE o.user_id = uf.user_id
+		     AND o.file_path = CASE WHEN uf.file_path = $2 THEN $1
+		                            ELSE $1 || substring(uf.file_path from length($2) + 1) END)`,
+		newPath, oldPath)
+	if err != nil {
+		return fmt.Errorf("move favorites: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM user_favorites WHERE file_path = $1 OR file_path LIKE $1 || '/%'`, oldPath)
+	if err != nil {
+		return fmt.Errorf("drop moved favorites: %w", err)
+	}
+	return nil
+}
+
+// dropFavorites removes favorites of paths that no longer exist at all,
+// live or in the trash.
+func (s *Store) dropFavorites(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM user_favorites uf
+		 WHERE uf.file_path = ANY($1)
+		   AND NOT EXISTS (SELECT 1 FROM files f WHERE f.path = uf.file_path)`,
+		pq.Array(paths))
+	return err
+}
+
+// RemoveMissingFavorites removes a user's favorites whose file is gone
+// (not merely in the trash) and returns how many were removed.
+func (s *Store) RemoveMissingFavorites(ctx context.Context, userID int) (int64, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("remove_missing_favorites", time.Since(start)) }()
+
+	result, err := s.db.ExecContext(ctx,
+		`DELETE FROM user_favorites uf
+		 WHERE uf.user_id = $1
+		   AND NOT EXISTS (SELECT 1 FROM files f WHERE f.path = uf.file_path)`,
+		userID)
+	if err != nil {
+		return 0, fmt.Errorf("remove missing favorites: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// FavoriteRow holds favorite info joined with file metadata. Missing
+// favorites have no file; Trashed ones have a file in the trash.
 type FavoriteRow struct {
 	FilePath string
 	FileName string
 	Size     int64
 	IsDir    bool
 	ModTime  time.Time
+	Missing  bool
+	Trashed  bool
 }
 
 // ListFavorites returns all favorites for a user, joined with file metadata.
@@ -823,7 +1291,8 @@ func (s *Store) ListFavorites(ctx context.Context, userID int) ([]FavoriteRow, e
 
 	rows, err := s.db.QueryContext(ctx,
 		`SELECT uf.file_path, COALESCE(f.name, ''), COALESCE(f.size, 0),
-		        COALESCE(f.is_dir, FALSE), COALESCE(f.mod_time, NOW())
+		        COALESCE(f.is_dir, FALSE), COALESCE(f.mod_time, uf.created_at), f.path IS NULL,
+		        f.path IS NULL AND EXISTS (SELECT 1 FROM files t WHERE t.path = uf.file_path)
 		 FROM user_favorites uf
 		 LEFT JOIN files f ON f.path = uf.file_path AND f.deleted_at IS NULL
 		 WHERE uf.user_id = $1
@@ -836,7 +1305,7 @@ func (s *Store) ListFavorites(ctx context.Context, userID int) ([]FavoriteRow, e
 	var items []FavoriteRow
 	for rows.Next() {
 		var f FavoriteRow
-		if err := rows.Scan(&f.FilePath, &f.FileName, &f.Size, &f.IsDir, &f.ModTime); err != nil {
+		if err := rows.Scan(&f.FilePath, &f.FileName, &f.Size, &f.IsDir, &f.ModTime, &f.Missing, &f.Trashed); err != nil {
 			return nil, fmt.Errorf("scan favorite: %w", err)
 		}
 		items = append(items, f)
@@ -878,49 +1347,124 @@ type SearchResultRow struct {
 	IsDir   bool
 	ModTime time.Time
 	Tags    []string
+	Match   string // what matched: "name", "path" or "tag"
+}
+
+// SearchOptions are the filters and paging of SearchFiles. Zero values
+// don't filter.
+type SearchOptions struct {
+	Query          string
+	Type           string // "files", "dirs" or "images"; anything else is all
+	PathPrefix     string // only this path and below
+	ModifiedAfter  *time.Time
+	ModifiedBefore *time.Time
+	MinSize        *int64
+	MaxSize        *int64
+	OwnerID        int
+
+	// Access limits the results to files the caller may see: an SQL
+	// condition on files f whose placeholders start at $1, with its
+	// arguments. Empty for admins.
+	Access     string
+	AccessArgs []interface{}
+
+	Limit  int // default 50, at most 200
+	Offset int
 }
 
-// SearchFiles searches files by name, path, or tags.
-func (s *Store) SearchFiles(ctx context.Context, query, typeFilter string, limit int) ([]SearchResultRow, error) {
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchFiles searches files by name, path, or tags and returns one page
+// of results, newest first, with the total number of matches.
+func (s *Store) SearchFiles(ctx context.Context, opts SearchOptions) ([]SearchResultRow, int, error) {
 	start := time.Now()
 	defer func() { metrics.RecordDBQuery("search_files", time.Since(start)) }()
 
-	if limit <= 0 || limit > 200 {
-		limit = 200
+	if opts.Limit <= 0 || opts.Limit > 200 {
+		opts.Limit = 50
+	}
+	if opts.Offset < 0 {
+		opts.Offset = 0
 	}
 
-	baseQuery := `SELECT DISTINCT f.id, f.name, f.path, f.size, f.is_dir, f.mod_time
-	              FROM files f
-	              LEFT JOIN image_tags it ON it.file_path = f.path
-	              WHERE f.deleted_at IS NULL
-	              AND (f.name ILIKE '%' || $1 || '%' OR f.path ILIKE '%' || $1 || '%' OR it.tag ILIKE '%' || $1 || '%')`
+	// Access arguments come first so the condition's placeholders hold
+	args := append([]interface{}{}, opts.AccessArgs...)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	pattern := arg("%" + opts.Query + "%")
+	conds := []string{
+		"f.deleted_at IS NULL",
+		fmt.Sprintf(`(f.name ILIKE %[1]s OR f.path ILIKE %[1]s
+		 OR EXISTS (SELECT 1 FROM image_tags it WHERE it.file_path = f.path AND it.tag ILIKE %[1]s))`, pattern),
+	}
+	if opts.Access != "" {
+		conds = append(conds, opts.Access)
+	}
 
-	switch typeFilter {
+	switch opts.Type {
 	case "files":
-		baseQuery += ` AND NOT f.is_dir`
+		conds = append(conds, "NOT f.is_dir")
 	case "dirs":
-		baseQuery += ` AND f.is_dir`
+		conds = append(conds, "f.is_dir")
 	case "images":
-		baseQuery += ` AND lower(f.name) ~ '\.(jpg|jpeg|png|gif|webp|bmp|svg)$'`
+		conds = append(conds, `lower(f.name) ~ '\.(jpg|jpeg|png|gif|webp|bmp|svg)$'`)
+	}
+	if prefix := normalizePath(opts.PathPrefix); opts.PathPrefix != "" && prefix != "/" {
+		conds = append(conds, fmt.Sprintf("(f.path = %s OR f.path LIKE %s)", arg(prefix), arg(escapeLike(prefix)+"/%")))
+	}
+	if opts.ModifiedAfter != nil {
+		conds = append(conds, "f.mod_time >= "+arg(*opts.ModifiedAfter))
+	}
+	if opts.ModifiedBefore != nil {
+		conds = append(conds, "f.mod_time < "+arg(*opts.ModifiedBefore))
 	}
+	if opts.MinSize != nil {
+		conds = append(conds, "f.size >= "+arg(*opts.MinSize))
+	}
+	if opts.MaxSize != nil {
+		conds = append(conds, "f.size <= "+arg(*opts.MaxSize))
+	}
+	if opts.OwnerID != 0 {
+		conds = append(conds, "f.owner_id = "+arg(opts.OwnerID))
+	}
+	where := strings.Join(conds, " AND ")
 
-	baseQuery += ` ORDER BY f.mod_time DESC LIMIT $2`
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM files f WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count search: %w", err)
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	query := fmt.Sprintf(`SELECT f.id, f.name, f.path, f.size, f.is_dir, f.mod_time,
+	              CASE WHEN f.name ILIKE %[1]s THEN 'name' WHEN f.path ILIKE %[1]s THEN 'path' ELSE 'tag' END
+	              FROM files f
+	              WHERE %[2]s
+	              ORDER BY f.mod_time DESC, f.path
+	              LIMIT %[3]s OFFSET %[4]s`, pattern, where, arg(opts.Limit), arg(opts.Offset))
 
-	rows, err := s.db.QueryContext(ctx, baseQuery, query, limit)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("search files: %w", err)
+		return nil, 0, fmt.Errorf("search files: %w", err)
 	}
 	defer rows.Close()
 
 	var results []SearchResultRow
 	for rows.Next() {
 		var r SearchResultRow
-		if err := rows.Scan(&r.ID, &r.Name, &r.Path, &r.Size, &r.IsDir, &r.ModTime); err != nil {
-			return nil, fmt.Errorf("scan search: %w", err)
+		if err := rows.Scan(&r.ID, &r.Name, &r.Path, &r.Size, &r.IsDir, &r.ModTime, &r.Match); err != nil {
+			return nil, 0, fmt.Errorf("scan search: %w", err)
 		}
 		results = append(results, r)
 	}
-	return results, rows.Err()
+	return results, total, rows.Err()
 }
 
 // ─── Move & Copy ─────────────────────────────────────────────────────────────
@@ -939,6 +1483,59 @@ func (s *Store) MoveFile(ctx context.Context, oldPath, newPath string) error {
 	}
 	defer tx.Rollback()
 
+	if err := moveFile(ctx, tx, oldPath, newPath); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceFile moves the file at oldPath onto the file at newPath in one
+// transaction and returns the moved file's new version: the one after the
+// replaced file's, so the move reads as an overwrite. Saving the replaced
+// file as a version and releasing its content is left to the caller.
+func (s *Store) ReplaceFile(ctx context.Context, oldPath, newPath string) (int, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("replace_file", time.Since(start)) }()
+
+	oldPath = normalizePath(oldPath)
+	newPath = normalizePath(newPath)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var replaced int
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM files WHERE path = $1 AND is_dir = FALSE AND deleted_at IS NULL
+		 RETURNING version`, newPath).Scan(&replaced)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no file to replace at %s", newPath)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("delete replaced file: %w", err)
+	}
+
+	if err := moveFile(ctx, tx, oldPath, newPath); err != nil {
+		return 0, err
+	}
+	version := replaced + 1
+	res, err := tx.ExecContext(ctx,
+		`UPDATE files SET version = $2 WHERE path = $1`, newPath, version)
+	if err != nil {
+		return 0, fmt.Errorf("set version: %w", err)
+	}
+	// The move matches nothing if the source went away meanwhile; the
+	// rollback then keeps the replaced file
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, fmt.Errorf("no file to move at %s", oldPath)
+	}
+	return version, tx.Commit()
+}
+
+// moveFile moves a row and the rows below it within tx.
+func moveFile(ctx context.Context, tx *sql.Tx, oldPath, newPath string) error {
 	newParent := filepath.Dir(newPath)
 	if newParent == "." {
 		newParent = "/"
@@ -946,7 +1543,7 @@ func (s *Store) MoveFile(ctx context.Context, oldPath, newPath string) error {
 	newName := filepath.Base(newPath)
 
 	// Update the file/directory itself
-	_, err = tx.ExecContext(ctx,
+	_, err := tx.ExecContext(ctx,
 		`UPDATE files SET path = $1, parent_path = $2, name = $3, id = $4, updated_at = NOW()
 		 WHERE path = $5 AND deleted_at IS NULL`,
 		newPath, newParent, newName, fileID(newPath), oldPath)
@@ -970,44 +1567,243 @@ func (s *Store) MoveFile(ctx context.Context, oldPath, newPath string) error {
 		return fmt.Errorf("move children: %w", err)
 	}
 
-	return tx.Commit()
+	return moveFavorites(ctx, tx, oldPath, newPath)
 }
 
-// CopyFileRow copies a file's metadata to a new path (does not copy storage objects).
-func (s *Store) CopyFileRow(ctx context.Context, srcPath, dstPath string) error {
+// CopyFileRow copies a file's metadata to a new path as a new file:
+// version 1, owned by ownerID if set. Deduplicated content is shared with
+// the source; other content must be copied by the caller to the new path's
+// key. Fails with ErrPathExists if a row, live or trashed, holds dstPath.
+func (s *Store) CopyFileRow(ctx context.Context, srcPath, dstPath string, ownerID *int) error {
 	start := time.Now()
 	defer func() { metrics.RecordDBQuery("copy_file_row", time.Since(start)) }()
 
 	srcPath = normalizePath(srcPath)
 	dstPath = normalizePath(dstPath)
 
+	copied, err := copyFileRow(ctx, s.db, srcPath, dstPath, strings.TrimPrefix(dstPath, "/"), ownerID)
+	if err != nil {
+		return err
+	}
+	if copied == 0 {
+		if exists, err := s.PathExists(ctx, dstPath); err == nil && exists {
+			return ErrPathExists
+		}
+		return fmt.Errorf("copy file: %s not found", srcPath)
+	}
+	return nil
+}
+
+// copyFileRow inserts a copy of the row at srcPath at dstPath with its
+// content under dstS3Key, unless deduplicated, and returns how many rows
+// it inserted: none if srcPath does not exist or dstPath does.
+func copyFileRow(ctx context.Context, q querier, srcPath, dstPath, dstS3Key string, ownerID *int) (int, error) {
 	dstParent := filepath.Dir(dstPath)
 	if dstParent == "." {
 		dstParent = "/"
 	}
 	dstName := filepath.Base(dstPath)
-	dstS3Key := strings.TrimPrefix(dstPath, "/")
 
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO files (id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key, version, owner_id, visibility, group_id, storage_location_id, created_at, updated_at)
-		 SELECT $1, $2, $3, $4, size, NOW(), is_dir, hash, $5, 1, owner_id, visibility, group_id, storage_location_id, NOW(), NOW()
-		 FROM files WHERE path = $6 AND deleted_at IS NULL
-		 ON CONFLICT (path) DO NOTHING`,
-		fileID(dstPath), dstName, dstPath, dstParent, dstS3Key, srcPath)
+	var copied int
+	err := q.QueryRowContext(ctx,
+		`WITH c AS (
+			INSERT INTO files (id, name, path, parent_path, size, mod_time, is_dir, hash, s3_key, version, owner_id, visibility, group_id, storage_location_id, created_at, updated_at)
+			SELECT $1, $2, $3, $4, size, NOW(), is_dir, hash,
+			       CASE WHEN LEFT(s3_key, LENGTH($7)) = $7 THEN s3_key ELSE $5 END,
+			       1, COALESCE($8, owner_id), visibility, group_id, storage_location_id, NOW(), NOW()
+			FROM files WHERE path = $6 AND deleted_at IS NULL
+			ON CONFLICT (path) DO NOTHING
+			RETURNING s3_key, storage_location_id
+		 ), r AS (
+			UPDATE content_objects o SET refcount = o.refcount + 1
+			FROM c
+			WHERE LEFT(c.s3_key, LENGTH($7)) = $7
+			  AND o.hash = SUBSTRING(c.s3_key FROM LENGTH($7) + 1)
+			  AND o.storage_location_id = c.storage_location_id
+		 )
+		 SELECT COUNT(*) FROM c`,
+		fileID(dstPath), dstName, dstPath, dstParent, dstS3Key, srcPath, contentKeyPrefix, ownerID).Scan(&copied)
 	if err != nil {
-		return fmt.Errorf("copy file: %w", err)
+		return 0, fmt.Errorf("copy file: %w", err)
 	}
-	return nil
+	return copied, nil
+}
+
+// PathMove is one move of MoveFiles. With Replace the live file or empty
+// directory at To is replaced, a file keeping its content as a version.
+type PathMove struct {
+	From, To string
+	Replace  bool
+}
+
+// MoveFiles applies moves in order in one transaction: all of them or, on
+// error, none. It returns the version of each moved file that replaced
+// another (the one after the replaced file's), 0 for the others.
+// Releasing replaced content is left to the caller.
+func (s *Store) MoveFiles(ctx context.Context, moves []PathMove) ([]int, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("move_files", time.Since(start)) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	versions := make([]int, len(moves))
+	for i, m := range moves {
+		from, to := normalizePath(m.From), normalizePath(m.To)
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM files WHERE path = $1 AND deleted_at IS NULL)`, from).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("find %s: %w", from, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("no file to move at %s", from)
+		}
+		if m.Replace {
+			if versions[i], err = replaceRow(ctx, tx, to); err != nil {
+				return nil, err
+			}
+		}
+		if err := moveFile(ctx, tx, from, to); err != nil {
+			return nil, err
+		}
+		if versions[i] > 0 {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE files SET version = $2 WHERE path = $1`, to, versions[i]); err != nil {
+				return nil, fmt.Errorf("set version: %w", err)
+			}
+		}
+	}
+	return versions, tx.Commit()
+}
+
+// PathCopy is one row of CopyFiles. A file is copied from the row at From
+// with its content under S3Key, unless deduplicated; a directory (empty
+// From) is created. With Replace the live file or empty directory at To is
+// replaced as by MoveFiles.
+type PathCopy struct {
+	From, To, S3Key string
+	Replace         bool
+}
+
+// CopyFiles creates copies in order in one transaction, owned by ownerID:
+// all of them or, on error, none. Copies are at version 1, or the one
+// after the file they replace, which CopyFiles returns for each copy.
+// Fails with ErrPathExists if a row, live or trashed, holds the path of a
+// copy that does not replace it.
+func (s *Store) CopyFiles(ctx context.Context, copies []PathCopy, ownerID *int) ([]int, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("copy_files", time.Since(start)) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	versions := make([]int, len(copies))
+	for i, c := range copies {
+		to := normalizePath(c.To)
+		versions[i] = 1
+		if c.Replace {
+			if versions[i], err = replaceRow(ctx, tx, to); err != nil {
+				return nil, err
+			}
+		}
+
+		var copied int
+		if c.From == "" {
+			dir := &FileRow{
+				ID:         fileID(to),
+				Name:       filepath.Base(to),
+				Path:       to,
+				ParentPath: filepath.Dir(to),
+				IsDir:      true,
+				ModTime:    time.Now(),
+				OwnerID:    ownerID,
+			}
+			var added bool
+			if added, err = insertFile(ctx, tx, dir); added {
+				copied = 1
+			}
+			versions[i] = 0
+		} else {
+			copied, err = copyFileRow(ctx, tx, normalizePath(c.From), to, c.S3Key, ownerID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if copied == 0 {
+			if exists, err := s.PathExists(ctx, to); err == nil && exists {
+				return nil, fmt.Errorf("%w: %s", ErrPathExists, to)
+			}
+			return nil, fmt.Errorf("copy file: %s not found", c.From)
+		}
+		if versions[i] > 1 {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE files SET version = $2 WHERE path = $1`, to, versions[i]); err != nil {
+				return nil, fmt.Errorf("set version: %w", err)
+			}
+		}
+	}
+	return versions, tx.Commit()
+}
+
+// replaceRow removes the live file or empty directory at p that a move or
+// copy replaces, keeping a file with content as a version, and returns the
+// version the replacing file gets: 0 for a directory.
+func replaceRow(ctx context.Context, tx *sql.Tx, p string) (int, error) {
+	var isDir bool
+	var version int
+	var size int64
+	err := tx.QueryRowContext(ctx,
+		`SELECT is_dir, version, size FROM files WHERE path = $1 AND deleted_at IS NULL`, p).Scan(&isDir, &version, &size)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("nothing to replace at %s", p)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("find replaced: %w", err)
+	}
+
+	if isDir {
+		res, err := tx.ExecContext(ctx,
+			`DELETE FROM files WHERE path = $1
+			   AND NOT EXISTS (SELECT 1 FROM files c WHERE c.parent_path = $1)`, p)
+		if err != nil {
+			return 0, fmt.Errorf("delete replaced directory: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, fmt.Errorf("directory not empty: %s", p)
+		}
+		return 0, nil
+	}
+
+	if size > 0 {
+		if err := saveVersion(ctx, tx, p); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM files WHERE path = $1`, p); err != nil {
+		return 0, fmt.Errorf("delete replaced file: %w", err)
+	}
+	return version + 1, nil
 }
 
 // ─── Storage Dashboard Analytics ─────────────────────────────────────────
 
-// UserStorageBreakdown is storage usage for a single user.
+// UserStorageBreakdown is storage usage for a single user. Size is the sum
+// of the live content of their Count files and the versions and thumbnails
+// attributed to them.
 type UserStorageBreakdown struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Size     int64  `json:"size"`
-	Count    int    `json:"count"`
+	UserID        int    `json:"user_id"`
+	Username      string `json:"username"`
+	Size          int64  `json:"size"`
+	Count         int    `json:"count"`
+	ContentBytes  int64  `json:"content_bytes"`
+	VersionsBytes int64  `json:"versions_bytes"`
+	ThumbsBytes   int64  `json:"thumbs_bytes"`
 }
 
 // GroupStorageBreakdown is storage usage for a single group.
@@ -1049,16 +1845,40 @@ type StorageGrowthPoint struct {
 	TotalFiles int    `json:"total_files"`
 }
 
-// StorageByUser returns storage breakdown by user.
+// StorageByUser returns storage breakdown by user. Versions and
+// thumbnails belong to the owner of their file, trashed or not.
 func (s *Store) StorageByUser(ctx context.Context) ([]UserStorageBreakdown, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT COALESCE(f.owner_id, 0), COALESCE(u.username, 'unknown'),
-		        COALESCE(SUM(f.size), 0), COUNT(*)
-		 FROM files f
-		 LEFT JOIN users u ON u.id = f.owner_id
-		 WHERE f.deleted_at IS NULL AND f.is_dir = FALSE
-		 GROUP BY f.owner_id, u.username
-		 ORDER BY SUM(f.size) DESC`)
+		`WITH content AS (
+			SELECT COALESCE(owner_id, 0) AS owner_id, SUM(size) AS bytes, COUNT(*) AS n
+			FROM files
+			WHERE deleted_at IS NULL AND is_dir = FALSE
+			GROUP BY 1
+		 ), versions AS (
+			SELECT COALESCE(f.owner_id, 0) AS owner_id, SUM(v.size) AS bytes
+			FROM file_versions v
+			JOIN files f ON f.path = v.path
+			GROUP BY 1
+		 ), thumbs AS (
+			SELECT COALESCE(f.owner_id, 0) AS owner_id, SUM(t.size) AS bytes
+			FROM (SELECT file_path, thumb_size AS size FROM image_metadata
+			      UNION ALL
+			      SELECT file_path, size FROM thumbnail_objects) t
+			JOIN files f ON f.path = t.file_path
+			GROUP BY 1
+		 ), owners AS (
+			SELECT owner_id FROM content
+			UNION SELECT owner_id FROM versions
+			UNION SELECT owner_id FROM thumbs
+		 )
+		 SELECT o.owner_id, COALESCE(u.username, 'unknown'), COALESCE(c.n, 0),
+		        COALESCE(c.bytes, 0), COALESCE(v.bytes, 0), COALESCE(t.bytes, 0)
+		 FROM owners o
+		 LEFT JOIN content c ON c.owner_id = o.owner_id
+		 LEFT JOIN versions v ON v.owner_id = o.owner_id
+		 LEFT JOIN thumbs t ON t.owner_id = o.owner_id
+		 LEFT JOIN users u ON u.id = o.owner_id
+		 ORDER BY COALESCE(c.bytes, 0) + COALESCE(v.bytes, 0) + COALESCE(t.bytes, 0) DESC`)
 	if err != nil {
 		return nil, fmt.Errorf("storage by user: %w", err)
 	}
@@ -1067,9 +1887,11 @@ func (s *Store) StorageByUser(ctx context.Context) ([]UserStorageBreakdown, erro
 	var result []UserStorageBreakdown
 	for rows.Next() {
 		var b UserStorageBreakdown
-		if err := rows.Scan(&b.UserID, &b.Username, &b.Size, &b.Count); err != nil {
+		if err := rows.Scan(&b.UserID, &b.Username, &b.Count,
+			&b.ContentBytes, &b.VersionsBytes, &b.ThumbsBytes); err != nil {
 			return nil, err
 		}
+		b.Size = b.ContentBytes + b.VersionsBytes + b.ThumbsBytes
 		result = append(result, b)
 	}
 	return result, rows.Err()
@@ -1214,6 +2036,16 @@ func (s *Store) StorageGrowth(ctx context.Context, days int) ([]StorageGrowthPoi
 	return result, rows.Err()
 }
 
+// UserTrashSize returns the total size of the files userID has trashed.
+func (s *Store) UserTrashSize(ctx context.Context, userID int) (int64, error) {
+	var totalSize int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(size), 0)
+		 FROM files WHERE deleted_at IS NOT NULL AND is_dir = FALSE AND deleted_by = $1`,
+		userID).Scan(&totalSize)
+	return totalSize, err
+}
+
 // TrashStats returns total size and count of trashed files.
 func (s *Store) TrashStats(ctx context.Context) (int64, int, error) {
 	var totalSize int64
@@ -1225,6 +2057,16 @@ func (s *Store) TrashStats(ctx context.Context) (int64, int, error) {
 	return totalSize, count, err
 }
 
+// VersionStats returns total size and count of stored old versions.
+func (s *Store) VersionStats(ctx context.Context) (int64, int, error) {
+	var totalSize int64
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(size), 0), COUNT(*) FROM file_versions`).
+		Scan(&totalSize, &count)
+	return totalSize, count, err
+}
+
 // extensionCategory maps a file extension to a broad category.
 func extensionCategory(ext string) string {
 	switch ext {
@@ -1274,46 +2116,83 @@ type ActivityEntry struct {
 	Action       string    `json:"action"`
 	ResourcePath string    `json:"resource_path"`
 	Details      string    `json:"details"`
+	RequestID    string    `json:"request_id,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
+
+	// Set for actions an admin performed while impersonating UserID
+	ImpersonatorID int    `json:"impersonator_id,omitempty"`
+	Impersonator   string `json:"impersonator,omitempty"`
 }
 
 // GetActivity returns recent activity entries (all users, for admins).
-func (s *Store) GetActivity(ctx context.Context, limit int, before *time.Time) ([]ActivityEntry, error) {
-	var query string
-	var args []interface{}
+// An empty action matches every action.
+func (s *Store) GetActivity(ctx context.Context, limit int, before *time.Time, action string) ([]ActivityEntry, error) {
+	return s.queryActivity(ctx, 0, limit, before, action)
+}
 
-	if before != nil {
-		query = `SELECT id, COALESCE(user_id, 0), username, action, resource_path, COALESCE(details::text, '{}'), created_at
-		         FROM activity_log WHERE created_at < $1 ORDER BY created_at DESC LIMIT $2`
-		args = []interface{}{*before, limit}
-	} else {
-		query = `SELECT id, COALESCE(user_id, 0), username, action, resource_path, COALESCE(details::text, '{}'), created_at
-		         FROM activity_log ORDER BY created_at DESC LIMIT $1`
-		args = []interface{}{limit}
-	}
+// GetUserActivity returns recent activity entries for a specific user,
+// including what they did while impersonating someone else. An empty
+// action matches every action.
+func (s *Store) GetUserActivity(ctx context.Context, userID, limit int, before *time.Time, action string) ([]ActivityEntry, error) {
+	return s.queryActivity(ctx, userID, limit, before, action)
+}
 
-	return s.queryActivity(ctx, query, args...)
+// InsertActivity writes a batch of activity entries in one statement.
+func (s *Store) InsertActivity(ctx context.Context, entries []ActivityEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO activity_log (user_id, username, action, resource_path, details, request_id, created_at,
+		impersonator_id, impersonator) VALUES `)
+	args := make([]interface{}, 0, len(entries)*9)
+	for i, e := range entries {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		n := i * 9
+		fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d::jsonb, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9)
+		var userID, impersonatorID interface{}
+		if e.UserID > 0 {
+			userID = e.UserID
+		}
+		if e.ImpersonatorID > 0 {
+			impersonatorID = e.ImpersonatorID
+		}
+		details := e.Details
+		if details == "" {
+			details = "{}"
+		}
+		args = append(args, userID, e.Username, e.Action, e.ResourcePath, details, e.RequestID, e.CreatedAt,
+			impersonatorID, e.Impersonator)
+	}
+	if _, err := s.db.ExecContext(ctx, sb.String(), args...); err != nil {
+		return fmt.Errorf("insert activity: %w", err)
+	}
+	return nil
 }
 
-// GetUserActivity returns recent activity entries for a specific user.
-func (s *Store) GetUserActivity(ctx context.Context, userID, limit int, before *time.Time) ([]ActivityEntry, error) {
-	var query string
+func (s *Store) queryActivity(ctx context.Context, userID, limit int, before *time.Time, action string) ([]ActivityEntry, error) {
+	query := `SELECT id, COALESCE(user_id, 0), username, action, resource_path, COALESCE(details::text, '{}'), request_id, created_at,
+	                 COALESCE(impersonator_id, 0), impersonator
+	          FROM activity_log WHERE TRUE`
 	var args []interface{}
-
+	if userID > 0 {
+		args = append(args, userID)
+		query += fmt.Sprintf(" AND (user_id = $%d OR impersonator_id = $%d)", len(args), len(args))
+	}
 	if before != nil {
-		query = `SELECT id, COALESCE(user_id, 0), username, action, resource_path, COALESCE(details::text, '{}'), created_at
-		         FROM activity_log WHERE user_id = $1 AND created_at < $2 ORDER BY created_at DESC LIMIT $3`
-		args = []interface{}{userID, *before, limit}
-	} else {
-		query = `SELECT id, COALESCE(user_id, 0), username, action, resource_path, COALESCE(details::text, '{}'), created_at
-		         FROM activity_log WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2`
-		args = []interface{}{userID, limit}
+		args = append(args, *before)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
 	}
+	if action != "" {
+		args = append(args, action)
+		query += fmt.Sprintf(" AND action = $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
 
-	return s.queryActivity(ctx, query, args...)
-}
-
-func (s *Store) queryActivity(ctx context.Context, query string, args ...interface{}) ([]ActivityEntry, error) {
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query activity: %w", err)
@@ -1323,7 +2202,8 @@ func (s *Store) queryActivity(ctx context.Context, query string, args ...interfa
 	var entries []ActivityEntry
 	for rows.Next() {
 		var e ActivityEntry
-		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.Action, &e.ResourcePath, &e.Details, &e.CreatedAt); err != nil {
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.Action, &e.ResourcePath, &e.Details, &e.RequestID, &e.CreatedAt,
+			&e.ImpersonatorID, &e.Impersonator); err != nil {
 			return nil, err
 		}
 		entries = append(entries, e)
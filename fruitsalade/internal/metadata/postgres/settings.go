@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ─── Server Settings ─────────────────────────────────────────────────────────
+//
+// server_settings holds the runtime settings an admin has changed, as JSON
+// values by key. The config package applies them over the environment.
+
+// ServerSettings returns the saved settings by key.
+func (s *Store) ServerSettings(ctx context.Context) (map[string]json.RawMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM server_settings`)
+	if err != nil {
+		return nil, fmt.Errorf("list server settings: %w", err)
+	}
+	defer rows.Close()
+
+	result := map[string]json.RawMessage{}
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("scan server setting: %w", err)
+		}
+		result[key] = value
+	}
+	return result, rows.Err()
+}
+
+// SaveServerSettings stores the given settings in one transaction. A null
+// value deletes the setting.
+func (s *Store) SaveServerSettings(ctx context.Context, values map[string]json.RawMessage, updatedBy int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for key, value := range values {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM server_settings WHERE key = $1`, key); err != nil {
+				return fmt.Errorf("delete server setting %s: %w", key, err)
+			}
+			continue
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO server_settings (key, value, updated_by, updated_at)
+			 VALUES ($1, $2, $3, NOW())
+			 ON CONFLICT (key) DO UPDATE
+			 SET value = EXCLUDED.value, updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+			key, string(value), updatedBy)
+		if err != nil {
+			return fmt.Errorf("save server setting %s: %w", key, err)
+		}
+	}
+	return tx.Commit()
+}
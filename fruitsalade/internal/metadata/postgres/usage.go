@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Usage History ───────────────────────────────────────────────────────────
+//
+// The storage dashboard computes totals on the fly; usage_snapshots keeps
+// one row per day so growth (net of deletions) can be charted. Recording
+// twice on the same day overwrites that day's row.
+
+// usageTopN is how many users and groups a snapshot keeps.
+const usageTopN = 10
+
+// UsageSnapshot is the storage usage recorded for one day.
+type UsageSnapshot struct {
+	Date         string                  `json:"date"`
+	TotalBytes   int64                   `json:"total_bytes"`
+	FileCount    int64                   `json:"file_count"`
+	TrashBytes   int64                   `json:"trash_bytes"`
+	TrashCount   int64                   `json:"trash_count"`
+	VersionBytes int64                   `json:"version_bytes"`
+	VersionCount int64                   `json:"version_count"`
+	TopUsers     []UserStorageBreakdown  `json:"top_users"`
+	TopGroups    []GroupStorageBreakdown `json:"top_groups"`
+}
+
+// RecordUsageSnapshot stores today's usage, replacing an earlier snapshot
+// of the same day.
+func (s *Store) RecordUsageSnapshot(ctx context.Context) (*UsageSnapshot, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("record_usage_snapshot", time.Since(start)) }()
+
+	byUser, err := s.StorageByUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byGroup, err := s.StorageByGroup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trashSize, trashCount, err := s.TrashStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("trash stats: %w", err)
+	}
+	versionSize, versionCount, err := s.VersionStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("version stats: %w", err)
+	}
+
+	snap := &UsageSnapshot{
+		TrashBytes:   trashSize,
+		TrashCount:   int64(trashCount),
+		VersionBytes: versionSize,
+		VersionCount: int64(versionCount),
+		TopUsers:     []UserStorageBreakdown{},
+		TopGroups:    []GroupStorageBreakdown{},
+	}
+	// Every live file has exactly one owner row (unowned ones count as 0)
+	for _, u := range byUser {
+		snap.TotalBytes += u.ContentBytes
+		snap.FileCount += int64(u.Count)
+	}
+	if len(byUser) > usageTopN {
+		byUser = byUser[:usageTopN]
+	}
+	if len(byGroup) > usageTopN {
+		byGroup = byGroup[:usageTopN]
+	}
+	snap.TopUsers = append(snap.TopUsers, byUser...)
+	snap.TopGroups = append(snap.TopGroups, byGroup...)
+
+	topUsers, _ := json.Marshal(snap.TopUsers)
+	topGroups, _ := json.Marshal(snap.TopGroups)
+	err = s.db.QueryRowContext(ctx,
+		`INSERT INTO usage_snapshots (day, total_bytes, file_count, trash_bytes, trash_count,
+		                              version_bytes, version_count, top_users, top_groups, recorded_at)
+		 VALUES (CURRENT_DATE, $1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		 ON CONFLICT (day) DO UPDATE SET
+			total_bytes = EXCLUDED.total_bytes,
+			file_count = EXCLUDED.file_count,
+			trash_bytes = EXCLUDED.trash_bytes,
+			trash_count = EXCLUDED.trash_count,
+			version_bytes = EXCLUDED.version_bytes,
+			version_count = EXCLUDED.version_count,
+			top_users = EXCLUDED.top_users,
+			top_groups = EXCLUDED.top_groups,
+			recorded_at = NOW()
+		 RETURNING day::TEXT`,
+		snap.TotalBytes, snap.FileCount, snap.TrashBytes, snap.TrashCount,
+		snap.VersionBytes, snap.VersionCount, topUsers, topGroups).Scan(&snap.Date)
+	if err != nil {
+		return nil, fmt.Errorf("record usage snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// UsageHistory returns the snapshots of the last days days, oldest first.
+func (s *Store) UsageHistory(ctx context.Context, days int) ([]UsageSnapshot, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("usage_history", time.Since(start)) }()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT day::TEXT, total_bytes, file_count, trash_bytes, trash_count,
+		        version_bytes, version_count, top_users, top_groups
+		 FROM usage_snapshots
+		 WHERE day > CURRENT_DATE - $1::INT
+		 ORDER BY day`, days)
+	if err != nil {
+		return nil, fmt.Errorf("usage history: %w", err)
+	}
+	defer rows.Close()
+
+	var result []UsageSnapshot
+	for rows.Next() {
+		var snap UsageSnapshot
+		var topUsers, topGroups []byte
+		if err := rows.Scan(&snap.Date, &snap.TotalBytes, &snap.FileCount,
+			&snap.TrashBytes, &snap.TrashCount, &snap.VersionBytes, &snap.VersionCount,
+			&topUsers, &topGroups); err != nil {
+			return nil, fmt.Errorf("scan usage snapshot: %w", err)
+		}
+		if err := json.Unmarshal(topUsers, &snap.TopUsers); err != nil {
+			return nil, fmt.Errorf("decode top users: %w", err)
+		}
+		if err := json.Unmarshal(topGroups, &snap.TopGroups); err != nil {
+			return nil, fmt.Errorf("decode top groups: %w", err)
+		}
+		result = append(result, snap)
+	}
+	return result, rows.Err()
+}
+
+// PruneUsageSnapshots deletes snapshots older than keepDays days.
+func (s *Store) PruneUsageSnapshots(ctx context.Context, keepDays int) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM usage_snapshots WHERE day <= CURRENT_DATE - $1::INT`, keepDays)
+	if err != nil {
+		return 0, fmt.Errorf("prune usage snapshots: %w", err)
+	}
+	return res.RowsAffected()
+}
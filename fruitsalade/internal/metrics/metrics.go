@@ -4,6 +4,7 @@ package metrics
 import (
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -61,6 +62,22 @@ var (
 		[]string{"status"},
 	)
 
+	contentCompressedRawBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_content_compressed_raw_bytes_total",
+			Help: "Content bytes served compressed, before compression",
+		},
+		[]string{"encoding"},
+	)
+
+	contentCompressedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_content_compressed_bytes_total",
+			Help: "Content bytes served compressed, as sent",
+		},
+		[]string{"encoding"},
+	)
+
 	// Metadata metrics
 	metadataTreeSize = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -69,6 +86,13 @@ var (
 		},
 	)
 
+	treeNotModifiedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_tree_not_modified_total",
+			Help: "Tree requests answered with 304 Not Modified",
+		},
+	)
+
 	metadataRefreshDuration = promauto.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "fruitsalade_metadata_refresh_duration_seconds",
@@ -86,6 +110,21 @@ var (
 		[]string{"result"},
 	)
 
+	loginLockoutsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_login_lockouts_total",
+			Help: "Login lockouts started, by kind (user or ip)",
+		},
+		[]string{"kind"},
+	)
+
+	loginLockedOutTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_login_locked_out_attempts_total",
+			Help: "Login attempts refused because the user or IP was locked out",
+		},
+	)
+
 	activeTokens = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "fruitsalade_active_tokens",
@@ -126,6 +165,13 @@ var (
 		[]string{"type"},
 	)
 
+	wsClientsDroppedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_ws_clients_dropped_total",
+			Help: "WebSocket event clients disconnected because their send queue overflowed",
+		},
+	)
+
 	// Sharing metrics
 	shareLinksActive = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -157,6 +203,14 @@ var (
 		},
 	)
 
+	rateLimitHitsByUser = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_rate_limit_hits_by_user_total",
+			Help: "Rate limit rejections per user ID; users beyond the first 200 share the \"other\" label",
+		},
+		[]string{"user"},
+	)
+
 	quotaExceededTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "fruitsalade_quota_exceeded_total",
@@ -165,6 +219,30 @@ var (
 		[]string{"type"},
 	)
 
+	downloadsDeniedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_downloads_denied_total",
+			Help: "Downloads refused or cut short by the daily bandwidth quota",
+		},
+		[]string{"source"},
+	)
+
+	uploadChecksumFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_upload_checksum_failures_total",
+			Help: "Uploads rejected because the body did not match its X-Content-SHA256",
+		},
+		[]string{"source"},
+	)
+
+	// Activity log metrics
+	activityDroppedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_activity_dropped_total",
+			Help: "Activity log entries dropped because the write buffer was full",
+		},
+	)
+
 	// S3 metrics
 	s3OperationDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -182,6 +260,79 @@ var (
 		},
 		[]string{"operation", "status"},
 	)
+
+	// Integrity scrubber
+	scrubBytesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_scrub_bytes_total",
+			Help: "Bytes read and verified by the integrity scrubber",
+		},
+	)
+
+	integrityMismatchesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_integrity_mismatches_total",
+			Help: "Stored objects found not to match their hash, by whether they were repaired",
+		},
+		[]string{"repaired"},
+	)
+
+	// Gallery processing
+	galleryQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fruitsalade_gallery_queue_depth",
+			Help: "Files waiting in a gallery processing queue",
+		},
+		[]string{"queue"},
+	)
+
+	galleryFailures = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fruitsalade_gallery_failures",
+			Help: "Gallery files that failed processing, waiting for a retry (retry) or given up on (failed)",
+		},
+		[]string{"state"},
+	)
+
+	galleryAttemptsFailedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_gallery_attempts_failed_total",
+			Help: "Failed gallery processing attempts",
+		},
+	)
+
+	galleryPluginFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_gallery_plugin_failures_total",
+			Help: "Failed gallery tagging plugin calls, by plugin ID",
+		},
+		[]string{"plugin_id"},
+	)
+
+	// Outbound webhooks
+	webhookQueueDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "fruitsalade_webhook_queue_depth",
+			Help: "Webhook deliveries waiting for a worker",
+		},
+	)
+
+	webhookAttemptsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fruitsalade_webhook_attempts_total",
+			Help: "Webhook delivery attempts, by outcome (delivered, retry, dead)",
+		},
+		[]string{"outcome"},
+	)
+
+	// Storage location health
+	storageLocationHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fruitsalade_storage_location_healthy",
+			Help: "Whether a storage location passed its last health check (1) or not (0)",
+		},
+		[]string{"location"},
+	)
 )
 
 // Handler returns the Prometheus metrics HTTP handler.
@@ -205,6 +356,13 @@ func RecordContentDownload(bytes int64, success bool) {
 	contentDownloadsTotal.WithLabelValues(status).Inc()
 }
 
+// RecordContentCompression records a content download sent with
+// encoding: raw bytes of content that went out as compressed bytes.
+func RecordContentCompression(encoding string, raw, compressed int64) {
+	contentCompressedRawBytes.WithLabelValues(encoding).Add(float64(raw))
+	contentCompressedBytes.WithLabelValues(encoding).Add(float64(compressed))
+}
+
 // RecordContentUpload records a content upload.
 func RecordContentUpload(bytes int64, success bool) {
 	contentBytesUploaded.Add(float64(bytes))
@@ -220,6 +378,11 @@ func SetMetadataTreeSize(size int64) {
 	metadataTreeSize.Set(float64(size))
 }
 
+// RecordTreeNotModified records a tree request answered with 304.
+func RecordTreeNotModified() {
+	treeNotModifiedTotal.Inc()
+}
+
 // RecordMetadataRefresh records metadata refresh duration.
 func RecordMetadataRefresh(duration time.Duration) {
 	metadataRefreshDuration.Observe(duration.Seconds())
@@ -234,6 +397,16 @@ func RecordAuthAttempt(success bool) {
 	authAttemptsTotal.WithLabelValues(result).Inc()
 }
 
+// RecordLoginLockout records the start of a login lockout.
+func RecordLoginLockout(kind string) {
+	loginLockoutsTotal.WithLabelValues(kind).Inc()
+}
+
+// RecordLoginLockedOut records a login attempt refused during a lockout.
+func RecordLoginLockedOut() {
+	loginLockedOutTotal.Inc()
+}
+
 // SetActiveTokens sets the number of active tokens.
 func SetActiveTokens(count int64) {
 	activeTokens.Set(float64(count))
@@ -269,6 +442,12 @@ func RecordSSEEvent(eventType string) {
 	sseEventsTotal.WithLabelValues(eventType).Inc()
 }
 
+// RecordWSClientDropped records a WebSocket client dropped for falling
+// behind.
+func RecordWSClientDropped() {
+	wsClientsDroppedTotal.Inc()
+}
+
 // SetShareLinksActive sets the number of active share links.
 func SetShareLinksActive(count int64) {
 	shareLinksActive.Set(float64(count))
@@ -288,9 +467,35 @@ func RecordPermissionCheck(allowed bool) {
 	permissionChecksTotal.WithLabelValues(result).Inc()
 }
 
-// RecordRateLimitHit records a rate limit rejection.
-func RecordRateLimitHit() {
+// maxRateLimitUserLabels bounds the cardinality of the per-user rate limit
+// counter.
+const maxRateLimitUserLabels = 200
+
+var (
+	rateLimitUsersMu sync.Mutex
+	rateLimitUsers   = make(map[int]string)
+)
+
+// RecordRateLimitHit records a rate limit rejection for a user.
+func RecordRateLimitHit(userID int) {
 	rateLimitHitsTotal.Inc()
+	rateLimitHitsByUser.WithLabelValues(rateLimitUserLabel(userID)).Inc()
+}
+
+// rateLimitUserLabel returns the user's label, or "other" once the label
+// budget is used up.
+func rateLimitUserLabel(userID int) string {
+	rateLimitUsersMu.Lock()
+	defer rateLimitUsersMu.Unlock()
+	if label, ok := rateLimitUsers[userID]; ok {
+		return label
+	}
+	if len(rateLimitUsers) >= maxRateLimitUserLabels {
+		return "other"
+	}
+	label := strconv.Itoa(userID)
+	rateLimitUsers[userID] = label
+	return label
 }
 
 // RecordQuotaExceeded records a quota exceeded rejection.
@@ -298,6 +503,76 @@ func RecordQuotaExceeded(quotaType string) {
 	quotaExceededTotal.WithLabelValues(quotaType).Inc()
 }
 
+// RecordDownloadDenied records a download stopped by the bandwidth quota;
+// source is "content", "share" or "webdav".
+func RecordDownloadDenied(source string) {
+	downloadsDeniedTotal.WithLabelValues(source).Inc()
+}
+
+// RecordUploadChecksumFailure records an upload rejected for a checksum
+// mismatch; source is "content" or "chunked".
+func RecordUploadChecksumFailure(source string) {
+	uploadChecksumFailuresTotal.WithLabelValues(source).Inc()
+}
+
+// RecordScrubBytes records bytes verified by the integrity scrubber.
+func RecordScrubBytes(n int64) {
+	scrubBytesTotal.Add(float64(n))
+}
+
+// RecordIntegrityMismatch records an object that failed verification.
+func RecordIntegrityMismatch(repaired bool) {
+	integrityMismatchesTotal.WithLabelValues(strconv.FormatBool(repaired)).Inc()
+}
+
+// SetStorageLocationHealthy records the outcome of a storage health check.
+func SetStorageLocationHealthy(location string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	storageLocationHealthy.WithLabelValues(location).Set(v)
+}
+
+// SetGalleryQueueDepth records the length of a gallery processing queue
+// ("image" or "video").
+func SetGalleryQueueDepth(queue string, n int) {
+	galleryQueueDepth.WithLabelValues(queue).Set(float64(n))
+}
+
+// SetGalleryFailures records the number of gallery files waiting for a
+// retry and the number given up on.
+func SetGalleryFailures(retrying, failed int) {
+	galleryFailures.WithLabelValues("retry").Set(float64(retrying))
+	galleryFailures.WithLabelValues("failed").Set(float64(failed))
+}
+
+// RecordGalleryAttemptFailed records a failed gallery processing attempt.
+func RecordGalleryAttemptFailed() {
+	galleryAttemptsFailedTotal.Inc()
+}
+
+// RecordGalleryPluginFailure records a failed call to a tagging plugin.
+func RecordGalleryPluginFailure(pluginID int) {
+	galleryPluginFailuresTotal.WithLabelValues(strconv.Itoa(pluginID)).Inc()
+}
+
+// SetWebhookQueueDepth records the number of webhook deliveries waiting
+// for a worker.
+func SetWebhookQueueDepth(n int) {
+	webhookQueueDepth.Set(float64(n))
+}
+
+// RecordWebhookAttempt records a webhook delivery attempt and its outcome.
+func RecordWebhookAttempt(outcome string) {
+	webhookAttemptsTotal.WithLabelValues(outcome).Inc()
+}
+
+// RecordActivityDropped records an activity log entry dropped on a full buffer.
+func RecordActivityDropped() {
+	activityDroppedTotal.Inc()
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code.
 type responseWriter struct {
 	http.ResponseWriter
@@ -0,0 +1,150 @@
+// Package paths validates file paths before they enter the metadata tree.
+// Every endpoint and tool that creates or renames files checks new paths
+// with ValidatePath, so that the tree never holds names other clients
+// cannot represent, or nesting deep enough to strain the code that walks
+// it.
+package paths
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits bound the size of a path.
+type Limits struct {
+	MaxBytes int // length of the whole path in bytes
+	MaxDepth int // number of segments ("/a/b" is 2 deep)
+}
+
+// DefaultLimits apply until SetLimits is called.
+var DefaultLimits = Limits{MaxBytes: 4096, MaxDepth: 128}
+
+var limits atomic.Pointer[Limits]
+
+// SetLimits replaces the limits ValidatePath enforces. Zero fields keep
+// their default.
+func SetLimits(l Limits) {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultLimits.MaxBytes
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	limits.Store(&l)
+}
+
+// CurrentLimits returns the limits ValidatePath enforces.
+func CurrentLimits() Limits {
+	if l := limits.Load(); l != nil {
+		return *l
+	}
+	return DefaultLimits
+}
+
+// Error describes why a path was rejected.
+type Error struct {
+	Path   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Path, e.Reason)
+}
+
+// ValidatePath checks a server path ("/a/b.txt"; the leading and one
+// trailing slash are optional) for use as the name of a new file or
+// directory. It rejects paths longer or deeper than the limits, empty,
+// "." and ".." segments, invalid UTF-8, control characters, and segments
+// starting or ending with whitespace. The root itself is valid.
+func ValidatePath(p string) error {
+	lim := CurrentLimits()
+	if len(p) > lim.MaxBytes {
+		return &Error{Path: truncate(p), Reason: fmt.Sprintf("longer than %d bytes", lim.MaxBytes)}
+	}
+	if !utf8.ValidString(p) {
+		return &Error{Path: p, Reason: "not valid UTF-8"}
+	}
+
+	trimmed := strings.TrimPrefix(strings.TrimSuffix(p, "/"), "/")
+	if trimmed == "" {
+		return nil
+	}
+	segments := strings.Split(trimmed, "/")
+	if len(segments) > lim.MaxDepth {
+		return &Error{Path: truncate(p), Reason: fmt.Sprintf("more than %d levels deep", lim.MaxDepth)}
+	}
+	for _, seg := range segments {
+		if reason := checkSegment(seg); reason != "" {
+			return &Error{Path: p, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// ValidateSubtree checks that a tree height levels deep can be placed at p
+// without its deepest entries exceeding the depth limit. p itself must
+// already have passed ValidatePath.
+func ValidateSubtree(p string, height int) error {
+	lim := CurrentLimits()
+	depth := 0
+	if trimmed := strings.Trim(p, "/"); trimmed != "" {
+		depth = strings.Count(trimmed, "/") + 1
+	}
+	if depth+height > lim.MaxDepth {
+		return &Error{Path: truncate(p), Reason: fmt.Sprintf("contents would be more than %d levels deep", lim.MaxDepth)}
+	}
+	return nil
+}
+
+// ValidateName checks a single file or directory name, as ValidatePath
+// checks each segment.
+func ValidateName(name string) error {
+	if strings.Contains(name, "/") {
+		return &Error{Path: name, Reason: "name contains \"/\""}
+	}
+	if !utf8.ValidString(name) {
+		return &Error{Path: name, Reason: "not valid UTF-8"}
+	}
+	if reason := checkSegment(name); reason != "" {
+		return &Error{Path: name, Reason: reason}
+	}
+	return nil
+}
+
+// checkSegment returns why seg is not a valid name, or "".
+func checkSegment(seg string) string {
+	switch seg {
+	case "":
+		return "empty segment"
+	case ".", "..":
+		return fmt.Sprintf("%q segment", seg)
+	}
+	for _, r := range seg {
+		if unicode.IsControl(r) {
+			return fmt.Sprintf("control character %U in %q", r, seg)
+		}
+	}
+	first, _ := utf8.DecodeRuneInString(seg)
+	last, _ := utf8.DecodeLastRuneInString(seg)
+	if unicode.IsSpace(first) || unicode.IsSpace(last) {
+		return fmt.Sprintf("segment %q starts or ends with whitespace", seg)
+	}
+	return ""
+}
+
+// truncate shortens a path for an error message.
+func truncate(p string) string {
+	const max = 80
+	if len(p) <= max {
+		return p
+	}
+	for i := max; i > 0; i-- {
+		if utf8.RuneStart(p[i]) {
+			return p[:i] + "..."
+		}
+	}
+	return p[:max] + "..."
+}
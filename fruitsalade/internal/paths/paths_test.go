@@ -0,0 +1,88 @@
+package paths
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePath(t *testing.T) {
+	valid := []string{
+		"/",
+		"",
+		"/docs/report.txt",
+		"docs/report.txt",
+		"/docs/",
+		"/a b/c.d",
+		"/.hidden/..x",
+		"/фото/2024",
+	}
+	for _, p := range valid {
+		if err := ValidatePath(p); err != nil {
+			t.Errorf("ValidatePath(%q) = %v, want nil", p, err)
+		}
+	}
+
+	invalid := []string{
+		"/a//b",
+		"/a/./b",
+		"/a/../b",
+		"/..",
+		"/a/b\x00c",
+		"/a/b\nc",
+		"/a/\x7f",
+		"/a/ b",
+		"/a/b /c",
+		"/a/b\t",
+		"/a/ b",
+		"/a/\xff",
+		"/" + strings.Repeat("x", 5000),
+		strings.Repeat("/d", 129),
+	}
+	for _, p := range invalid {
+		err := ValidatePath(p)
+		if err == nil {
+			t.Errorf("ValidatePath(%q) = nil, want an error", p)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("ValidatePath(%q) returned %T, want *Error", p, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	defer SetLimits(DefaultLimits)
+
+	SetLimits(Limits{MaxBytes: 10, MaxDepth: 2})
+	if err := ValidatePath("/a/b"); err != nil {
+		t.Errorf("ValidatePath within limits = %v", err)
+	}
+	if err := ValidatePath("/a/b/c"); err == nil {
+		t.Error("path deeper than MaxDepth accepted")
+	}
+	if err := ValidatePath("/abcdefghij"); err == nil {
+		t.Error("path longer than MaxBytes accepted")
+	}
+	if err := ValidateSubtree("/a", 1); err != nil {
+		t.Errorf("ValidateSubtree within limits = %v", err)
+	}
+	if err := ValidateSubtree("/a", 2); err == nil {
+		t.Error("subtree deeper than MaxDepth accepted")
+	}
+
+	SetLimits(Limits{})
+	if got := CurrentLimits(); got != DefaultLimits {
+		t.Errorf("SetLimits with zero fields = %+v, want the defaults", got)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	if err := ValidateName("report.txt"); err != nil {
+		t.Errorf("ValidateName = %v", err)
+	}
+	for _, name := range []string{"", ".", "..", "a/b", " a", "a\x01"} {
+		if ValidateName(name) == nil {
+			t.Errorf("ValidateName(%q) = nil, want an error", name)
+		}
+	}
+}
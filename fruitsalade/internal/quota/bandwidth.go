@@ -0,0 +1,152 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrBandwidthExceeded is returned by Meter.Write once the user's daily
+// bandwidth quota is used up.
+var ErrBandwidthExceeded = errors.New("daily bandwidth quota exceeded")
+
+// meterCheckInterval is how many bytes a Meter passes between quota checks.
+// It bounds how far a single download can run past the quota.
+var meterCheckInterval int64 = 8 << 20
+
+// DownloadLimit returns the user's daily bandwidth quota (0 = unlimited)
+// and whether any of it is left today.
+func (s *QuotaStore) DownloadLimit(ctx context.Context, userID int) (limit int64, ok bool, err error) {
+	q, err := s.GetQuota(ctx, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	if q.MaxBandwidthPerDay == 0 {
+		return 0, true, nil
+	}
+	bIn, bOut, err := s.GetBandwidthToday(ctx, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	return q.MaxBandwidthPerDay, bIn+bOut < q.MaxBandwidthPerDay, nil
+}
+
+// trackBandwidthTotal records outgoing bytes and returns the user's total
+// for today, uploads included.
+func (s *QuotaStore) trackBandwidthTotal(ctx context.Context, userID int, bytesOut int64) (int64, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO bandwidth_usage (user_id, date, bytes_in, bytes_out)
+		 VALUES ($1, CURRENT_DATE, 0, $2)
+		 ON CONFLICT (user_id, date) DO UPDATE SET
+			bytes_out = bandwidth_usage.bytes_out + EXCLUDED.bytes_out
+		 RETURNING bytes_in + bytes_out`,
+		userID, bytesOut).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("track bandwidth: %w", err)
+	}
+	return total, nil
+}
+
+// RetryAfterReset returns the seconds until the daily bandwidth resets at
+// midnight UTC.
+func RetryAfterReset(now time.Time) int {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(midnight.Sub(now).Seconds()) + 1
+}
+
+// ParseExemptPaths splits a comma-separated list of path prefixes.
+func ParseExemptPaths(list string) []string {
+	var prefixes []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimRight(strings.TrimSpace(p), "/")
+		if p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// PathExempt reports whether p is one of prefixes or below one.
+func PathExempt(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Meter counts the bytes of a download against a user's bandwidth. Every
+// meterCheckInterval bytes it records them and, if the user has a limit,
+// fails with ErrBandwidthExceeded once today's total reaches it. Finish
+// records the rest.
+type Meter struct {
+	w       io.Writer
+	store   *QuotaStore
+	ctx     context.Context
+	userID  int
+	limit   int64 // 0 = unlimited
+	pending int64 // written but not yet recorded
+	written int64
+	over    bool
+}
+
+// NewMeter wraps w. limit is the user's quota from DownloadLimit, or 0 for
+// downloads that are only tracked.
+func (s *QuotaStore) NewMeter(ctx context.Context, w io.Writer, userID int, limit int64) *Meter {
+	return &Meter{
+		w:      w,
+		store:  s,
+		ctx:    context.WithoutCancel(ctx), // still record after the client hangs up
+		userID: userID,
+		limit:  limit,
+	}
+}
+
+// Write implements io.Writer.
+func (m *Meter) Write(p []byte) (int, error) {
+	if m.over {
+		return 0, ErrBandwidthExceeded
+	}
+	n, err := m.w.Write(p)
+	m.written += int64(n)
+	m.pending += int64(n)
+	if err != nil || m.limit == 0 || m.pending < meterCheckInterval {
+		return n, err
+	}
+
+	total, terr := m.store.trackBandwidthTotal(m.ctx, m.userID, m.pending)
+	if terr != nil {
+		// Keep serving; the bytes are recorded by the next check or Finish
+		return n, nil
+	}
+	m.pending = 0
+	if total >= m.limit {
+		m.over = true
+		return n, ErrBandwidthExceeded
+	}
+	return n, nil
+}
+
+// Written returns the number of bytes written so far.
+func (m *Meter) Written() int64 {
+	return m.written
+}
+
+// Exceeded reports whether the meter cut the download short.
+func (m *Meter) Exceeded() bool {
+	return m.over
+}
+
+// Finish records the bytes not recorded yet.
+func (m *Meter) Finish() {
+	if m.pending > 0 {
+		m.store.TrackBandwidth(m.ctx, m.userID, 0, m.pending)
+		m.pending = 0
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
@@ -14,10 +15,24 @@ import (
 // This function type allows decoupling from the auth package.
 type UserIDFromContext func(ctx context.Context) (userID int, rpm int, ok bool)
 
+// exemptFromRateLimit reports whether a request is not counted against the
+// requests-per-minute budget: the long-lived SSE stream and health checks.
+func exemptFromRateLimit(r *http.Request) bool {
+	return r.URL.Path == "/api/v1/events" ||
+		r.URL.Path == "/health" || strings.HasPrefix(r.URL.Path, "/health/")
+}
+
 // RateLimitMiddleware returns middleware that enforces per-user rate limits.
+// Rejected requests get 429 with a Retry-After header and a JSON
+// protocol.ErrorResponse body.
 func RateLimitMiddleware(limiter *RateLimiter, store *QuotaStore, getUserInfo UserIDFromContext) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exemptFromRateLimit(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			userID, _, ok := getUserInfo(r.Context())
 			if !ok {
 				// No user context (unauthenticated request) - let it pass
@@ -34,14 +49,16 @@ func RateLimitMiddleware(limiter *RateLimiter, store *QuotaStore, getUserInfo Us
 
 			rpm := q.MaxRequestsPerMin
 			if !limiter.Allow(userID, rpm) {
-				metrics.RecordRateLimitHit()
+				metrics.RecordRateLimitHit(userID)
 				retryAfter := limiter.RetryAfter(userID, rpm)
 				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusTooManyRequests)
 				json.NewEncoder(w).Encode(protocol.ErrorResponse{
-					Error: "rate limit exceeded",
-					Code:  http.StatusTooManyRequests,
+					Error:     "rate limit exceeded",
+					Code:      http.StatusTooManyRequests,
+					ErrorCode: protocol.ErrCodeRateLimited,
+					RequestID: w.Header().Get("X-Request-ID"),
 				})
 				return
 			}
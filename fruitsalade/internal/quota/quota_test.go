@@ -1,6 +1,9 @@
 package quota
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -118,3 +121,88 @@ func TestRateLimiterCleanup(t *testing.T) {
 		t.Errorf("expected 1 bucket after cleanup, got %d", count)
 	}
 }
+
+func TestRateLimiterTopThrottled(t *testing.T) {
+	rl := NewRateLimiter(nil)
+
+	// User 1 is rejected 3 times, user 2 once, user 3 never
+	for i := 0; i < 4; i++ {
+		rl.Allow(1, 1)
+	}
+	rl.Allow(2, 1)
+	rl.Allow(2, 1)
+	rl.Allow(3, 1)
+
+	top := rl.TopThrottled(10)
+	if len(top) != 2 || top[0] != (ThrottledUser{UserID: 1, Throttled: 3}) || top[1] != (ThrottledUser{UserID: 2, Throttled: 1}) {
+		t.Errorf("TopThrottled = %+v", top)
+	}
+	if top := rl.TopThrottled(1); len(top) != 1 || top[0].UserID != 1 {
+		t.Errorf("TopThrottled(1) = %+v", top)
+	}
+
+	// Entries older than the window are pruned
+	rl.throttled[1] = map[int64]int{time.Now().Add(-2*ThrottleWindow).Unix() / 60: 5}
+	rl.Cleanup(24 * time.Hour)
+	if top := rl.TopThrottled(10); len(top) != 1 || top[0].UserID != 2 {
+		t.Errorf("after cleanup TopThrottled = %+v", top)
+	}
+}
+
+func TestRateLimitMiddlewareExemptions(t *testing.T) {
+	// A nil store would panic if the request were accounted
+	getUserInfo := func(ctx context.Context) (int, int, bool) { return 1, 0, true }
+	handler := RateLimitMiddleware(NewRateLimiter(nil), nil, getUserInfo)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, path := range []string{"/api/v1/events", "/health", "/health/ready"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, rec.Code)
+		}
+	}
+}
+
+func TestRetryAfterReset(t *testing.T) {
+	now := time.Date(2024, 3, 31, 23, 59, 0, 0, time.UTC)
+	if got := RetryAfterReset(now); got != 61 {
+		t.Errorf("RetryAfterReset(23:59) = %d, want 61", got)
+	}
+	// Other zones count to midnight UTC too
+	now = time.Date(2024, 4, 1, 1, 59, 0, 0, time.FixedZone("CEST", 2*3600))
+	if got := RetryAfterReset(now); got != 61 {
+		t.Errorf("RetryAfterReset(01:59 CEST) = %d, want 61", got)
+	}
+}
+
+func TestPathExempt(t *testing.T) {
+	prefixes := ParseExemptPaths(" /public/ , ,/shared/docs")
+	if len(prefixes) != 2 {
+		t.Fatalf("ParseExemptPaths = %q", prefixes)
+	}
+	for path, want := range map[string]bool{
+		"/public":             true,
+		"/public/a.txt":       true,
+		"/publicity/a.txt":    false,
+		"/shared/docs/x.pdf":  true,
+		"/shared/other/x.pdf": false,
+	} {
+		if got := PathExempt(path, prefixes); got != want {
+			t.Errorf("PathExempt(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestCountedUsage(t *testing.T) {
+	u := Usage{Content: 100, Versions: 30, Thumbs: 5}
+
+	s := NewQuotaStore(nil)
+	if got := s.Counted(u); got != 135 {
+		t.Errorf("Counted = %d, want 135 (versions and thumbnails count by default)", got)
+	}
+	s.SetCountDerived(false)
+	if got := s.Counted(u); got != 100 {
+		t.Errorf("Counted = %d, want 100 with derived storage excluded", got)
+	}
+}
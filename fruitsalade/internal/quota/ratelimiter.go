@@ -1,15 +1,29 @@
 package quota
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
 
+// ThrottleWindow is how long rejected requests count towards TopThrottled.
+const ThrottleWindow = time.Hour
+
 // RateLimiter implements per-user token bucket rate limiting.
 type RateLimiter struct {
 	mu      sync.Mutex
 	buckets map[int]*tokenBucket
 	store   *QuotaStore
+
+	// Rejected requests per user, bucketed by Unix minute
+	throttled map[int]map[int64]int
+}
+
+// ThrottledUser is the number of requests rejected for a user within the
+// throttle window.
+type ThrottledUser struct {
+	UserID    int `json:"user_id"`
+	Throttled int `json:"throttled"`
 }
 
 type tokenBucket struct {
@@ -22,8 +36,9 @@ type tokenBucket struct {
 // NewRateLimiter creates a new per-user rate limiter.
 func NewRateLimiter(store *QuotaStore) *RateLimiter {
 	return &RateLimiter{
-		buckets: make(map[int]*tokenBucket),
-		store:   store,
+		buckets:   make(map[int]*tokenBucket),
+		store:     store,
+		throttled: make(map[int]map[int64]int),
 	}
 }
 
@@ -66,6 +81,7 @@ func (rl *RateLimiter) Allow(userID int, rpm int) bool {
 
 	// Check if we have a token
 	if bucket.tokens < 1 {
+		rl.recordThrottle(userID, now)
 		return false
 	}
 
@@ -108,4 +124,58 @@ func (rl *RateLimiter) Cleanup(maxAge time.Duration) {
 			delete(rl.buckets, userID)
 		}
 	}
+
+	oldest := time.Now().Add(-ThrottleWindow).Unix() / 60
+	for userID, minutes := range rl.throttled {
+		for m := range minutes {
+			if m < oldest {
+				delete(minutes, m)
+			}
+		}
+		if len(minutes) == 0 {
+			delete(rl.throttled, userID)
+		}
+	}
+}
+
+// recordThrottle counts a rejected request. Called with rl.mu held.
+func (rl *RateLimiter) recordThrottle(userID int, now time.Time) {
+	minutes, ok := rl.throttled[userID]
+	if !ok {
+		minutes = make(map[int64]int)
+		rl.throttled[userID] = minutes
+	}
+	minutes[now.Unix()/60]++
+}
+
+// TopThrottled returns up to n users with the most requests rejected in
+// the last hour, most throttled first.
+func (rl *RateLimiter) TopThrottled(n int) []ThrottledUser {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	oldest := time.Now().Add(-ThrottleWindow).Unix() / 60
+	users := []ThrottledUser{}
+	for userID, minutes := range rl.throttled {
+		total := 0
+		for m, count := range minutes {
+			if m >= oldest {
+				total += count
+			}
+		}
+		if total > 0 {
+			users = append(users, ThrottledUser{UserID: userID, Throttled: total})
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Throttled != users[j].Throttled {
+			return users[i].Throttled > users[j].Throttled
+		}
+		return users[i].UserID < users[j].UserID
+	})
+	if n > 0 && len(users) > n {
+		users = users[:n]
+	}
+	return users
 }
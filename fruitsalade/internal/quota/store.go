@@ -16,6 +16,10 @@ type Quota struct {
 	MaxBandwidthPerDay int64
 	MaxRequestsPerMin  int
 	MaxUploadSizeBytes int64
+
+	// TrashRetentionDays overrides the server's trash retention for the
+	// items the user deleted; nil uses the server's, 0 means never purge.
+	TrashRetentionDays *int
 }
 
 type cachedQuota struct {
@@ -23,21 +27,46 @@ type cachedQuota struct {
 	expires time.Time
 }
 
+// Usage is the storage a user occupies, by kind.
+type Usage struct {
+	Content  int64 // owned files, trashed ones included
+	Versions int64 // old versions of owned files
+	Thumbs   int64 // gallery and preview thumbnails of owned files
+}
+
 // QuotaStore manages user quotas and usage tracking.
 type QuotaStore struct {
 	db    *sql.DB
 	mu    sync.RWMutex
 	cache map[int]*cachedQuota
 	ttl   time.Duration
+
+	countDerived bool // versions and thumbnails count toward the quota
 }
 
 // NewQuotaStore creates a new quota store.
 func NewQuotaStore(db *sql.DB) *QuotaStore {
 	return &QuotaStore{
-		db:    db,
-		cache: make(map[int]*cachedQuota),
-		ttl:   5 * time.Minute,
+		db:           db,
+		cache:        make(map[int]*cachedQuota),
+		ttl:          5 * time.Minute,
+		countDerived: true,
+	}
+}
+
+// SetCountDerived sets whether versions and thumbnails count toward the
+// storage quota (the default) or only file content does. Call before
+// serving requests.
+func (s *QuotaStore) SetCountDerived(on bool) {
+	s.countDerived = on
+}
+
+// Counted returns the part of u that counts toward the storage quota.
+func (s *QuotaStore) Counted(u Usage) int64 {
+	if !s.countDerived {
+		return u.Content
 	}
+	return u.Content + u.Versions + u.Thumbs
 }
 
 // InvalidateQuotaCache removes a user's cached quota (call after SetQuota).
@@ -59,10 +88,15 @@ func (s *QuotaStore) GetQuota(ctx context.Context, userID int) (*Quota, error) {
 	s.mu.RUnlock()
 
 	q := &Quota{UserID: userID}
+	var trashDays sql.NullInt64
 	err := s.db.QueryRowContext(ctx,
-		`SELECT max_storage_bytes, max_bandwidth_per_day, max_requests_per_minute, max_upload_size_bytes
+		`SELECT max_storage_bytes, max_bandwidth_per_day, max_requests_per_minute, max_upload_size_bytes, trash_retention_days
 		 FROM user_quotas WHERE user_id = $1`, userID).
-		Scan(&q.MaxStorageBytes, &q.MaxBandwidthPerDay, &q.MaxRequestsPerMin, &q.MaxUploadSizeBytes)
+		Scan(&q.MaxStorageBytes, &q.MaxBandwidthPerDay, &q.MaxRequestsPerMin, &q.MaxUploadSizeBytes, &trashDays)
+	if trashDays.Valid {
+		days := int(trashDays.Int64)
+		q.TrashRetentionDays = &days
+	}
 	if err == sql.ErrNoRows {
 		// Cache zero-value too (most users have no quota row)
 		s.mu.Lock()
@@ -83,15 +117,16 @@ func (s *QuotaStore) GetQuota(ctx context.Context, userID int) (*Quota, error) {
 // SetQuota sets or updates the quota for a user.
 func (s *QuotaStore) SetQuota(ctx context.Context, q *Quota) error {
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO user_quotas (user_id, max_storage_bytes, max_bandwidth_per_day, max_requests_per_minute, max_upload_size_bytes, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, NOW())
+		`INSERT INTO user_quotas (user_id, max_storage_bytes, max_bandwidth_per_day, max_requests_per_minute, max_upload_size_bytes, trash_retention_days, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
 		 ON CONFLICT (user_id) DO UPDATE SET
 			max_storage_bytes = EXCLUDED.max_storage_bytes,
 			max_bandwidth_per_day = EXCLUDED.max_bandwidth_per_day,
 			max_requests_per_minute = EXCLUDED.max_requests_per_minute,
 			max_upload_size_bytes = EXCLUDED.max_upload_size_bytes,
+			trash_retention_days = EXCLUDED.trash_retention_days,
 			updated_at = NOW()`,
-		q.UserID, q.MaxStorageBytes, q.MaxBandwidthPerDay, q.MaxRequestsPerMin, q.MaxUploadSizeBytes)
+		q.UserID, q.MaxStorageBytes, q.MaxBandwidthPerDay, q.MaxRequestsPerMin, q.MaxUploadSizeBytes, q.TrashRetentionDays)
 	if err != nil {
 		return fmt.Errorf("set quota: %w", err)
 	}
@@ -99,16 +134,35 @@ func (s *QuotaStore) SetQuota(ctx context.Context, q *Quota) error {
 	return nil
 }
 
-// GetStorageUsed returns the total storage used by a user (sum of owned file sizes).
-func (s *QuotaStore) GetStorageUsed(ctx context.Context, userID int) (int64, error) {
-	var used sql.NullInt64
+// GetUsage returns the storage a user occupies. Versions and thumbnails
+// belong to the owner of their file; they stop counting as soon as they
+// are deleted or their file is purged from the trash.
+func (s *QuotaStore) GetUsage(ctx context.Context, userID int) (Usage, error) {
+	var u Usage
 	err := s.db.QueryRowContext(ctx,
-		`SELECT COALESCE(SUM(size), 0) FROM files WHERE owner_id = $1 AND is_dir = FALSE`,
-		userID).Scan(&used)
+		`SELECT
+			(SELECT COALESCE(SUM(size), 0) FROM files WHERE owner_id = $1 AND is_dir = FALSE),
+			(SELECT COALESCE(SUM(v.size), 0) FROM file_versions v
+			 JOIN files f ON f.path = v.path WHERE f.owner_id = $1),
+			(SELECT COALESCE(SUM(m.thumb_size), 0) FROM image_metadata m
+			 JOIN files f ON f.path = m.file_path WHERE f.owner_id = $1)
+			+ (SELECT COALESCE(SUM(t.size), 0) FROM thumbnail_objects t
+			 JOIN files f ON f.path = t.file_path WHERE f.owner_id = $1)`,
+		userID).Scan(&u.Content, &u.Versions, &u.Thumbs)
 	if err != nil {
-		return 0, fmt.Errorf("get storage used: %w", err)
+		return Usage{}, fmt.Errorf("get storage usage: %w", err)
 	}
-	return used.Int64, nil
+	return u, nil
+}
+
+// GetStorageUsed returns the storage counted against a user's quota: their
+// file content, plus versions and thumbnails unless SetCountDerived(false).
+func (s *QuotaStore) GetStorageUsed(ctx context.Context, userID int) (int64, error) {
+	u, err := s.GetUsage(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return s.Counted(u), nil
 }
 
 // CheckStorageQuota checks if a user can upload a file of the given size.
@@ -129,6 +183,27 @@ func (s *QuotaStore) CheckStorageQuota(ctx context.Context, userID int, addition
 	return used+additionalBytes <= q.MaxStorageBytes, nil
 }
 
+// StorageRemaining returns how many more bytes a user may store, or -1 if
+// their storage is unlimited. It is never below 0 for a limited user.
+func (s *QuotaStore) StorageRemaining(ctx context.Context, userID int) (int64, error) {
+	q, err := s.GetQuota(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if q.MaxStorageBytes == 0 {
+		return -1, nil
+	}
+
+	used, err := s.GetStorageUsed(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if used >= q.MaxStorageBytes {
+		return 0, nil
+	}
+	return q.MaxStorageBytes - used, nil
+}
+
 // GetUploadSizeLimit returns the effective upload size limit for a user.
 // Returns the user-specific limit if set, otherwise 0 (caller uses global default).
 func (s *QuotaStore) GetUploadSizeLimit(ctx context.Context, userID int) (int64, error) {
@@ -0,0 +1,410 @@
+// Package scrub verifies that stored objects still hash to what the
+// metadata says, recording mismatches as integrity issues and optionally
+// restoring the content from a saved version with the same hash.
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+)
+
+// ErrRunning is returned by Trigger while a run is in progress.
+var ErrRunning = errors.New("scrub already running")
+
+// scrubBatch is how many file rows a run fetches at a time.
+const scrubBatch = 500
+
+// Scope limits a run to files at or below Prefix and/or stored on one
+// location. The zero Scope covers everything.
+type Scope struct {
+	Prefix     string `json:"prefix,omitempty"`
+	LocationID int    `json:"location_id,omitempty"`
+}
+
+// Status describes the current or most recent run.
+type Status struct {
+	Running    bool       `json:"running"`
+	Trigger    string     `json:"trigger,omitempty"` // "schedule" or "manual"
+	Scope      Scope      `json:"scope"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Files      int64      `json:"files"`      // files verified
+	Bytes      int64      `json:"bytes"`      // bytes read
+	Mismatches int64      `json:"mismatches"` // files whose object is missing, unreadable or wrong
+	Repaired   int64      `json:"repaired"`
+	Skipped    int64      `json:"skipped"`    // files whose location could not be reached
+	Backfilled int64      `json:"backfilled"` // files registered without a hash that got one
+	Error      string     `json:"error,omitempty"`
+}
+
+// Scrubber walks the file rows and re-hashes their objects, at most rate
+// bytes per second.
+type Scrubber struct {
+	metadata   *postgres.Store
+	router     *storage.Router
+	rate       int64 // bytes per second, 0 = unlimited
+	autoRepair bool
+
+	ctx    context.Context
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a Scrubber. rate caps read throughput in bytes per second
+// (0 = unlimited); with autoRepair, mismatches are restored from the latest
+// saved version whose content still matches.
+func New(metadata *postgres.Store, router *storage.Router, rate int64, autoRepair bool) *Scrubber {
+	return &Scrubber{
+		metadata:   metadata,
+		router:     router,
+		rate:       rate,
+		autoRepair: autoRepair,
+		ctx:        context.Background(),
+	}
+}
+
+// Start scrubs everything every interval (0 = only on Trigger) until ctx
+// is done. Runs started by Trigger also stop with ctx.
+func (s *Scrubber) Start(ctx context.Context, interval time.Duration) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.begin("schedule", Scope{}) {
+					s.run(ctx, Scope{})
+				}
+			}
+		}
+	}()
+	logging.Info("integrity scrubber started",
+		zap.Duration("interval", interval),
+		zap.Int64("rate", s.rate),
+		zap.Bool("auto_repair", s.autoRepair))
+}
+
+// Trigger starts a run over scope in the background.
+func (s *Scrubber) Trigger(scope Scope) error {
+	if !s.begin("manual", scope) {
+		return ErrRunning
+	}
+	s.mu.Lock()
+	ctx := s.ctx
+	s.mu.Unlock()
+	go s.run(ctx, scope)
+	return nil
+}
+
+// Status returns the progress of the current or last run.
+func (s *Scrubber) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Scrubber) begin(trigger string, scope Scope) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Running {
+		return false
+	}
+	now := time.Now()
+	s.status = Status{Running: true, Trigger: trigger, Scope: scope, StartedAt: &now}
+	return true
+}
+
+func (s *Scrubber) update(fn func(st *Status)) {
+	s.mu.Lock()
+	fn(&s.status)
+	s.mu.Unlock()
+}
+
+func (s *Scrubber) run(ctx context.Context, scope Scope) {
+	th := &throttle{rate: s.rate, start: time.Now()}
+	// Deduplicated content is shared between files; read each object once
+	shared := make(map[string]verdict)
+
+	var runErr error
+	after := ""
+	for runErr == nil {
+		files, err := s.metadata.ListScrubFiles(ctx, scope.Prefix, after, scrubBatch)
+		if err != nil {
+			runErr = err
+			break
+		}
+		if len(files) == 0 {
+			break
+		}
+		for i := range files {
+			if ctx.Err() != nil {
+				runErr = ctx.Err()
+				break
+			}
+			s.checkFile(ctx, &files[i], scope, th, shared)
+		}
+		after = files[len(files)-1].Path
+	}
+
+	now := time.Now()
+	s.update(func(st *Status) {
+		st.Running = false
+		st.FinishedAt = &now
+		if runErr != nil {
+			st.Error = runErr.Error()
+		}
+	})
+	st := s.Status()
+	fields := []zap.Field{
+		zap.String("prefix", scope.Prefix),
+		zap.Int("location_id", scope.LocationID),
+		zap.Int64("files", st.Files),
+		zap.Int64("bytes", st.Bytes),
+		zap.Int64("mismatches", st.Mismatches),
+		zap.Int64("repaired", st.Repaired),
+		zap.Int64("skipped", st.Skipped),
+		zap.Int64("backfilled", st.Backfilled),
+	}
+	if runErr != nil {
+		logging.Error("integrity scrub aborted", append(fields, zap.Error(runErr))...)
+		return
+	}
+	logging.Info("integrity scrub finished", fields...)
+}
+
+// verdict is the outcome of reading one object.
+type verdict struct {
+	hash string
+	err  error // object missing or unreadable
+}
+
+func (s *Scrubber) checkFile(ctx context.Context, f *postgres.FileRow, scope Scope, th *throttle, shared map[string]verdict) {
+	backend, loc, err := s.router.ResolveForFile(ctx, f.StorageLocID, f.GroupID)
+	if loc != nil && scope.LocationID != 0 && loc.ID != scope.LocationID {
+		return
+	}
+	if err != nil {
+		s.update(func(st *Status) { st.Skipped++ })
+		return
+	}
+	if f.Hash == "" {
+		s.backfill(ctx, f, backend, th)
+		return
+	}
+
+	cacheKey := fmt.Sprintf("%d:%s", loc.ID, f.S3Key)
+	v, seen := shared[cacheKey]
+	if !seen {
+		sum, n, err := hashObject(ctx, backend, f.S3Key, th)
+		s.update(func(st *Status) { st.Bytes += n })
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && n == 0 {
+			// The backend failed before sending anything: not the object's fault
+			logging.Warn("scrub: failed to read object",
+				zap.String("path", f.Path), zap.String("key", f.S3Key), zap.Error(err))
+			s.update(func(st *Status) { st.Skipped++ })
+			return
+		}
+		v = verdict{hash: sum, err: err}
+		if postgres.IsContentKey(f.S3Key) {
+			shared[cacheKey] = v
+		}
+	}
+	s.update(func(st *Status) { st.Files++ })
+
+	if v.err == nil && v.hash == f.Hash {
+		if err := s.metadata.ClearIntegrityIssue(ctx, f.Path); err != nil {
+			logging.Warn("scrub: failed to clear integrity issue", zap.String("path", f.Path), zap.Error(err))
+		}
+		return
+	}
+
+	issue := &postgres.IntegrityIssue{
+		Path:         f.Path,
+		StorageLocID: &loc.ID,
+		S3Key:        f.S3Key,
+		ExpectedHash: f.Hash,
+		ActualHash:   v.hash,
+	}
+	if v.err != nil {
+		issue.ActualHash = ""
+		issue.Error = v.err.Error()
+	}
+	if err := s.metadata.RecordIntegrityIssue(ctx, issue); err != nil {
+		logging.Error("scrub: failed to record integrity issue", zap.String("path", f.Path), zap.Error(err))
+	}
+	logging.Error("integrity mismatch",
+		zap.String("path", f.Path),
+		zap.String("location", loc.Name),
+		zap.String("key", f.S3Key),
+		zap.String("expected", f.Hash),
+		zap.String("actual", issue.ActualHash),
+		zap.String("error", issue.Error))
+
+	repaired := false
+	if s.autoRepair {
+		version, err := s.repair(ctx, f, backend, th)
+		switch {
+		case err != nil:
+			logging.Error("scrub: repair failed", zap.String("path", f.Path), zap.Error(err))
+		case version > 0:
+			repaired = true
+			if err := s.metadata.MarkIntegrityRepaired(ctx, f.Path, version); err != nil {
+				logging.Warn("scrub: failed to mark issue repaired", zap.String("path", f.Path), zap.Error(err))
+			}
+			logging.Info("integrity issue repaired",
+				zap.String("path", f.Path), zap.Int("from_version", version))
+			delete(shared, cacheKey)
+		}
+	}
+	metrics.RecordIntegrityMismatch(repaired)
+	s.update(func(st *Status) {
+		st.Mismatches++
+		if repaired {
+			st.Repaired++
+		}
+	})
+}
+
+// backfill hashes the object of a file that was registered in place
+// without a hash (see the bucket import) and records the hash. Nothing is
+// there to verify it against yet.
+func (s *Scrubber) backfill(ctx context.Context, f *postgres.FileRow, backend storage.Backend, th *throttle) {
+	sum, n, err := hashObject(ctx, backend, f.S3Key, th)
+	s.update(func(st *Status) { st.Bytes += n })
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logging.Warn("scrub: failed to hash object",
+			zap.String("path", f.Path), zap.String("key", f.S3Key), zap.Error(err))
+		s.update(func(st *Status) { st.Skipped++ })
+		return
+	}
+	if err := s.metadata.BackfillFileHash(ctx, f.Path, f.S3Key, sum); err != nil {
+		logging.Warn("scrub: failed to record hash", zap.String("path", f.Path), zap.Error(err))
+		return
+	}
+	s.update(func(st *Status) { st.Backfilled++ })
+}
+
+// repair restores f's object from the latest saved version with the same
+// hash whose own object still verifies. It returns that version, or 0 if
+// none qualifies.
+func (s *Scrubber) repair(ctx context.Context, f *postgres.FileRow, dst storage.Backend, th *throttle) (int, error) {
+	versions, _, err := s.metadata.ListVersions(ctx, f.Path)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range versions { // newest first
+		key := postgres.VersionContentKey(f.Path, v.Version, v.S3Key)
+		if v.Hash != f.Hash || key == f.S3Key {
+			continue
+		}
+		src, _, err := s.router.ResolveForFile(ctx, v.StorageLocID, nil)
+		if err != nil {
+			continue
+		}
+		sum, n, err := hashObject(ctx, src, key, th)
+		s.update(func(st *Status) { st.Bytes += n })
+		if err != nil || sum != f.Hash {
+			continue
+		}
+		if err := copyObject(ctx, src, key, dst, f.S3Key, v.Size); err != nil {
+			return 0, fmt.Errorf("restore from version %d: %w", v.Version, err)
+		}
+		return v.Version, nil
+	}
+	return 0, nil
+}
+
+func copyObject(ctx context.Context, src storage.Backend, srcKey string, dst storage.Backend, dstKey string, size int64) error {
+	if src == dst {
+		return src.CopyObject(ctx, srcKey, dstKey)
+	}
+	rc, _, err := src.GetObject(ctx, srcKey, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return dst.PutObject(ctx, dstKey, rc, size)
+}
+
+// hashObject streams an object through sha256 at the throttle's pace. It
+// returns the hex digest and the number of bytes read.
+func hashObject(ctx context.Context, b storage.Backend, key string, th *throttle) (string, int64, error) {
+	rc, _, err := b.GetObject(ctx, key, 0, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, &throttledReader{ctx: ctx, r: rc, t: th})
+	metrics.RecordScrubBytes(n)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// throttle paces the reads of a whole run to rate bytes per second.
+type throttle struct {
+	rate  int64 // 0 = unlimited
+	start time.Time
+	n     int64
+}
+
+// delay adds n bytes read and returns how long to wait before reading on.
+func (t *throttle) delay(n int64, now time.Time) time.Duration {
+	t.n += n
+	if t.rate <= 0 {
+		return 0
+	}
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	return due.Sub(now)
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if d := tr.t.delay(int64(n), time.Now()); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-tr.ctx.Done():
+			timer.Stop()
+			return n, tr.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
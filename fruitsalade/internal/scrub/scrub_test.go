@@ -0,0 +1,69 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	start := time.Now()
+	th := &throttle{rate: 1000, start: start}
+
+	// 500 bytes at 1000 B/s are due after half a second
+	if d := th.delay(500, start); d != 500*time.Millisecond {
+		t.Errorf("delay after 500 bytes = %v, want 500ms", d)
+	}
+	// Time already spent counts
+	if d := th.delay(500, start.Add(800*time.Millisecond)); d != 200*time.Millisecond {
+		t.Errorf("delay after 1000 bytes at 800ms = %v, want 200ms", d)
+	}
+	// Running behind the rate never waits
+	if d := th.delay(100, start.Add(5*time.Second)); d > 0 {
+		t.Errorf("delay when behind = %v, want none", d)
+	}
+
+	unlimited := &throttle{start: start}
+	if d := unlimited.delay(1<<30, start); d != 0 {
+		t.Errorf("unlimited delay = %v, want 0", d)
+	}
+}
+
+func TestHashObject(t *testing.T) {
+	ctx := context.Background()
+	b, err := local.New(local.Config{RootPath: t.TempDir(), CreateDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("fruit"), 10000)
+	if err := b.PutObject(ctx, "files/a.bin", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, n, err := hashObject(ctx, b, "files/a.bin", &throttle{start: time.Now()})
+	if err != nil {
+		t.Fatalf("hashObject: %v", err)
+	}
+	want := sha256.Sum256(data)
+	if sum != hex.EncodeToString(want[:]) || n != int64(len(data)) {
+		t.Errorf("hashObject = %s, %d; want %x, %d", sum, n, want, len(data))
+	}
+
+	if _, _, err := hashObject(ctx, b, "files/none.bin", &throttle{start: time.Now()}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("hashObject(missing) error = %v, want fs.ErrNotExist", err)
+	}
+
+	// A throttled read stops when the run is cancelled
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := hashObject(cctx, b, "files/a.bin", &throttle{rate: 1, start: time.Now()}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled hashObject error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,605 @@
+package sftpd
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/activity"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	uploadsvc "github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+var (
+	errIsDir    = uploadsvc.ErrIsDir
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+	errExists   = errors.New("file already exists")
+)
+
+// fileSystem maps SFTP requests of one authenticated session onto the
+// metadata store and storage backends.
+type fileSystem struct {
+	srv    *Server
+	claims *auth.Claims
+}
+
+func (fs *fileSystem) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs}
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func fileID(p string) string {
+	h := sha256.Sum256([]byte(p))
+	return fmt.Sprintf("%x", h[:8])
+}
+
+func parentOf(p string) string {
+	parent := path.Dir(p)
+	if parent == "." {
+		parent = "/"
+	}
+	return parent
+}
+
+func (fs *fileSystem) canWrite(ctx context.Context, p string) bool {
+	return fs.srv.permissions.CheckAccess(ctx, fs.claims.UserID, p, "write", fs.claims.IsAdmin)
+}
+
+// ─── Reads ──────────────────────────────────────────────────────────────────
+
+// Fileread opens a file for download.
+func (fs *fileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	ctx := r.Context()
+	p := cleanPath(r.Filepath)
+
+	// StatVisible applies the visibility and read permission checks
+	node := fs.srv.api.StatVisible(ctx, fs.claims, p)
+	if node == nil {
+		return nil, os.ErrNotExist
+	}
+	if node.IsDir {
+		return nil, errIsDir
+	}
+
+	// Below an alias the content is the target's
+	p, _ = fs.srv.api.ResolveAlias(p)
+	row, err := fs.srv.metadata.GetFileRow(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, os.ErrNotExist
+	}
+	backend, _, err := fs.srv.storageRouter.ResolveForRead(ctx, row.StorageLocID, row.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("resolve storage backend: %w", err)
+	}
+
+	return &objectReader{
+		ctx:     ctx,
+		backend: backend,
+		key:     row.S3Key,
+		size:    row.Size,
+		done: func(n int64) {
+			metrics.RecordContentDownload(n, true)
+			fs.srv.quotaStore.TrackBandwidth(ctx, fs.claims.UserID, 0, n)
+		},
+	}, nil
+}
+
+// objectReader serves ReadAt from a streaming GetObject. Sequential reads
+// reuse the open stream; any other offset reopens it there.
+type objectReader struct {
+	ctx     context.Context
+	backend storage.Backend
+	key     string
+	size    int64
+	done    func(bytesRead int64)
+
+	mu     sync.Mutex
+	stream io.ReadCloser
+	pos    int64
+	read   int64
+}
+
+func (o *objectReader) ReadAt(p []byte, off int64) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	if o.stream == nil || off != o.pos {
+		if o.stream != nil {
+			o.stream.Close()
+			o.stream = nil
+		}
+		stream, _, err := o.backend.GetObject(o.ctx, o.key, off, 0)
+		if err != nil {
+			return 0, err
+		}
+		o.stream = stream
+		o.pos = off
+	}
+
+	n, err := io.ReadFull(o.stream, p)
+	o.pos += int64(n)
+	o.read += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		o.stream.Close()
+		o.stream = nil
+	}
+	return n, err
+}
+
+func (o *objectReader) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stream != nil {
+		o.stream.Close()
+		o.stream = nil
+	}
+	if o.done != nil && o.read > 0 {
+		o.done(o.read)
+		o.done = nil
+	}
+	return nil
+}
+
+// ─── Writes ─────────────────────────────────────────────────────────────────
+
+// Filewrite opens a file for upload. Data is spooled to a temp file and
+// stored when the client closes the handle, so the quota check, versioning
+// and SSE event see the complete file.
+func (fs *fileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	ctx := r.Context()
+	p := cleanPath(r.Filepath)
+	if p == "/" {
+		return nil, errIsDir
+	}
+	if err := paths.ValidatePath(p); err != nil {
+		return nil, err
+	}
+	if err := fs.srv.api.WriteBlocked(); err != nil {
+		return nil, err
+	}
+
+	// A file written at an alias is stored at its target
+	p, _ = fs.srv.api.ResolveAlias(p)
+	if !fs.canWrite(ctx, p) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	existing, err := fs.srv.metadata.GetFileRow(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.IsDir {
+		return nil, errIsDir
+	}
+	if _, _, err := fs.srv.storageRouter.ResolveForUpload(ctx, p, nil); errors.Is(err, storage.ErrReadOnlyStorage) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	tmp, err := os.CreateTemp("", "sftp-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	u := &upload{fs: fs, ctx: ctx, path: p, file: tmp, limit: fs.uploadLimit(ctx)}
+
+	// Without O_TRUNC the client may rewrite part of an existing file
+	// (e.g. resuming with reput), so start from the current content.
+	if existing != nil && !r.Pflags().Trunc && existing.Size > 0 {
+		if err := u.prefill(existing); err != nil {
+			u.discard()
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// uploadLimit is the per-user upload size limit, or the global one.
+func (fs *fileSystem) uploadLimit(ctx context.Context) int64 {
+	return fs.srv.uploads.Limit(ctx, fs.claims)
+}
+
+// upload is a file handle opened for writing.
+type upload struct {
+	fs    *fileSystem
+	ctx   context.Context
+	path  string
+	file  *os.File
+	limit int64
+
+	mu      sync.Mutex
+	aborted error
+}
+
+func (u *upload) prefill(row *postgres.FileRow) error {
+	backend, _, err := u.fs.srv.storageRouter.ResolveForFile(u.ctx, row.StorageLocID, row.GroupID)
+	if err != nil {
+		return fmt.Errorf("resolve storage backend: %w", err)
+	}
+	reader, _, err := backend.GetObject(u.ctx, row.S3Key, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(u.file, reader)
+	return err
+}
+
+func (u *upload) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > u.limit {
+		return 0, fmt.Errorf("file too large: max %d bytes", u.limit)
+	}
+	return u.file.WriteAt(p, off)
+}
+
+// TransferError is called by the request server when the session ends with
+// the handle still open; the partial upload is then discarded on Close.
+func (u *upload) TransferError(err error) {
+	u.mu.Lock()
+	u.aborted = err
+	u.mu.Unlock()
+}
+
+func (u *upload) discard() {
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
+
+func (u *upload) Close() error {
+	defer u.discard()
+
+	u.mu.Lock()
+	aborted := u.aborted
+	u.mu.Unlock()
+	if aborted != nil {
+		logging.Warn("sftp upload aborted", zap.String("path", u.path), zap.Error(aborted))
+		return aborted
+	}
+
+	info, err := u.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := u.fs.store(u.ctx, u.path, u.file, info.Size()); err != nil {
+		metrics.RecordContentUpload(0, false)
+		logging.Warn("sftp upload failed", zap.String("path", u.path), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// store saves spooled content as a new file or a new version.
+func (fs *fileSystem) store(ctx context.Context, p string, file *os.File, size int64) error {
+	claims := fs.claims
+
+	res, err := fs.srv.uploads.Store(ctx, uploadsvc.Request{
+		Path:    p,
+		Content: file,
+		Size:    size,
+		Claims:  claims,
+	})
+	switch {
+	case errors.Is(err, storage.ErrReadOnlyStorage):
+		return sftp.ErrSSHFxPermissionDenied
+	case err != nil:
+		return err
+	}
+	metrics.RecordContentUpload(size, true)
+
+	logging.Info("sftp file uploaded",
+		zap.String("path", p),
+		zap.Int64("size", size),
+		zap.String("username", claims.Username),
+		zap.Int("version", res.Version))
+
+	eventType := events.EventCreate
+	if !res.Created {
+		eventType = events.EventModify
+	}
+	fs.srv.api.NotifyChange(ctx, eventType, p, res.Version, res.Hash, size, claims)
+	return nil
+}
+
+// ─── Commands ───────────────────────────────────────────────────────────────
+
+// Filecmd handles mkdir, rmdir, remove and rename. Attribute changes are
+// accepted and ignored; links are not supported.
+func (fs *fileSystem) Filecmd(r *sftp.Request) error {
+	ctx := r.Context()
+	p := cleanPath(r.Filepath)
+
+	if r.Method == "Setstat" {
+		return nil
+	}
+	if err := fs.srv.api.WriteBlocked(); err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case "Mkdir":
+		return fs.mkdir(ctx, p)
+	case "Rmdir":
+		return fs.remove(ctx, p, true)
+	case "Remove":
+		return fs.remove(ctx, p, false)
+	case "Rename", "PosixRename":
+		return fs.rename(ctx, p, cleanPath(r.Target))
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (fs *fileSystem) mkdir(ctx context.Context, p string) error {
+	if p == "/" {
+		return errExists
+	}
+	if err := paths.ValidatePath(p); err != nil {
+		return err
+	}
+	p, _ = fs.srv.api.ResolveAlias(p)
+	if !fs.canWrite(ctx, p) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if exists, _ := fs.srv.metadata.PathExists(ctx, p); exists {
+		return errExists
+	}
+	if _, _, err := fs.srv.storageRouter.ResolveForUpload(ctx, p, nil); errors.Is(err, storage.ErrReadOnlyStorage) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	if err := fs.srv.api.EnsureParentDirs(ctx, p); err != nil {
+		return fmt.Errorf("failed to create parent dirs: %w", err)
+	}
+	ownerID := fs.claims.UserID
+	row := &postgres.FileRow{
+		ID:         fileID(p),
+		Name:       path.Base(p),
+		Path:       p,
+		ParentPath: parentOf(p),
+		IsDir:      true,
+		ModTime:    time.Now(),
+		OwnerID:    &ownerID,
+	}
+	if err := fs.srv.metadata.UpsertFile(ctx, row); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	logging.Info("sftp directory created", zap.String("path", p), zap.String("username", fs.claims.Username))
+	fs.srv.api.NotifyChange(ctx, events.EventCreate, p, 0, "", 0, fs.claims)
+	return nil
+}
+
+// remove moves a file or an empty directory to the trash. Like the HTTP
+// delete, only the owner or an admin may do this.
+func (fs *fileSystem) remove(ctx context.Context, p string, dir bool) error {
+	if p == "/" {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	node := fs.srv.api.StatVisible(ctx, fs.claims, p)
+	if node == nil {
+		return os.ErrNotExist
+	}
+	if node.IsDir && !dir {
+		return errIsDir
+	}
+	if !node.IsDir && dir {
+		return errNotDir
+	}
+	if dir {
+		children, err := fs.srv.metadata.ListDir(ctx, p)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return errNotEmpty
+		}
+	}
+
+	if !fs.claims.IsAdmin {
+		ownerID, hasOwner := fs.srv.permissions.GetOwnerID(ctx, p)
+		if hasOwner && ownerID != fs.claims.UserID &&
+			!fs.srv.permissions.CheckAccess(ctx, fs.claims.UserID, p, "owner", false) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+	}
+	row, err := fs.srv.metadata.GetFileRow(ctx, p)
+	if err != nil {
+		return err
+	}
+	if row != nil && row.StorageLocID != nil && fs.srv.storageRouter.IsReadOnly(*row.StorageLocID) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	if err := fs.srv.metadata.SoftDeleteFile(ctx, p, fs.claims.UserID); err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+
+	logging.Info("sftp file moved to trash", zap.String("path", p), zap.String("username", fs.claims.Username))
+	fs.srv.api.NotifyChange(ctx, events.EventDelete, p, 0, "", 0, fs.claims)
+	return nil
+}
+
+// rename moves a file or directory. The target must not exist.
+func (fs *fileSystem) rename(ctx context.Context, src, dst string) error {
+	if src == "/" || dst == "/" {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	node := fs.srv.api.StatVisible(ctx, fs.claims, src)
+	if node == nil {
+		return os.ErrNotExist
+	}
+	if src == dst {
+		return nil
+	}
+	if strings.HasPrefix(dst, src+"/") {
+		return errors.New("cannot move a directory into itself")
+	}
+	if err := paths.ValidatePath(dst); err != nil {
+		return err
+	}
+	if !fs.canWrite(ctx, src) || !fs.canWrite(ctx, dst) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if exists, _ := fs.srv.metadata.PathExists(ctx, dst); exists {
+		return errExists
+	}
+	row, err := fs.srv.metadata.GetFileRow(ctx, src)
+	if err != nil {
+		return err
+	}
+	if row != nil && row.StorageLocID != nil && fs.srv.storageRouter.IsReadOnly(*row.StorageLocID) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	if err := fs.srv.api.EnsureParentDirs(ctx, dst); err != nil {
+		return fmt.Errorf("failed to create parent dirs: %w", err)
+	}
+	if err := fs.srv.metadata.MoveFile(ctx, src, dst); err != nil {
+		return err
+	}
+	fs.relocateObjects(ctx, dst)
+
+	logging.Info("sftp file moved",
+		zap.String("from", src), zap.String("to", dst), zap.String("username", fs.claims.Username))
+	fs.srv.api.NotifyChange(ctx, events.EventDelete, src, 0, "", 0, fs.claims)
+	fs.srv.api.NotifyChange(ctx, events.EventCreate, dst, node.Version, node.Hash, node.Size, fs.claims)
+	fs.srv.api.RecordActivity(fs.claims, activity.ActionMove, dst, map[string]interface{}{"from": src, "via": "sftp"})
+	return nil
+}
+
+// relocateObjects moves the storage objects under a renamed path so their
+// keys match the new paths again. Shared content is not keyed by path.
+func (fs *fileSystem) relocateObjects(ctx context.Context, p string) {
+	row, err := fs.srv.metadata.GetFileRow(ctx, p)
+	if err != nil || row == nil {
+		return
+	}
+	if row.IsDir {
+		children, _ := fs.srv.metadata.ListDir(ctx, p)
+		for _, child := range children {
+			fs.relocateObjects(ctx, child.Path)
+		}
+		return
+	}
+
+	newKey, err := storage.KeyForPath(row.Path)
+	if err != nil {
+		logging.Warn("not moving object", zap.String("path", p), zap.Error(err))
+		return
+	}
+	if row.S3Key == "" || row.S3Key == newKey || postgres.IsContentKey(row.S3Key) {
+		return
+	}
+	backend, _, err := fs.srv.storageRouter.ResolveForFile(ctx, row.StorageLocID, row.GroupID)
+	if err != nil {
+		return
+	}
+	if err := backend.CopyObject(ctx, row.S3Key, newKey); err != nil {
+		logging.Warn("failed to move object", zap.String("path", p), zap.Error(err))
+		return
+	}
+	oldKey := row.S3Key
+	row.S3Key = newKey
+	if err := fs.srv.metadata.UpsertFile(ctx, row); err != nil {
+		logging.Warn("failed to update storage key", zap.String("path", p), zap.Error(err))
+		return
+	}
+	backend.DeleteObject(ctx, oldKey)
+}
+
+// ─── Listing ────────────────────────────────────────────────────────────────
+
+// Filelist handles directory listings and stat, showing only what the user
+// can see in the tree.
+func (fs *fileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	ctx := r.Context()
+	p := cleanPath(r.Filepath)
+
+	switch r.Method {
+	case "List":
+		children, ok := fs.srv.api.ListVisible(ctx, fs.claims, p)
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		infos := make([]os.FileInfo, 0, len(children))
+		for _, child := range children {
+			infos = append(infos, nodeInfo(child))
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		node := fs.srv.api.StatVisible(ctx, fs.claims, p)
+		if node == nil {
+			return nil, os.ErrNotExist
+		}
+		return listerAt{nodeInfo(node)}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(f, l[offset:])
+	if n < len(f) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func nodeInfo(node *models.FileNode) *fileInfo {
+	name := node.Name
+	if name == "" {
+		name = "/"
+	}
+	return &fileInfo{name: name, size: node.Size, isDir: node.IsDir, modTime: node.ModTime}
+}
+
+// fileInfo implements os.FileInfo.
+type fileInfo struct {
+	name    string
+	size    int64
+	isDir   bool
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,289 @@
+// Package sftpd provides an SFTP interface to FruitSalade storage.
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	uploadsvc "github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// Keys used to carry the authenticated user through ssh.Permissions.
+const (
+	extUserID  = "fruitsalade-user-id"
+	extIsAdmin = "fruitsalade-is-admin"
+)
+
+// API is the part of the HTTP API server the SFTP frontend shares: its view
+// of the tree, its change notifications and the activity log. Implemented by *api.Server.
+type API interface {
+	StatVisible(ctx context.Context, claims *auth.Claims, path string) *models.FileNode
+	ListVisible(ctx context.Context, claims *auth.Claims, dir string) ([]*models.FileNode, bool)
+	ResolveAlias(path string) (string, bool)
+	EnsureParentDirs(ctx context.Context, path string) error
+	NotifyChange(ctx context.Context, eventType, path string, version int, hash string, size int64, claims *auth.Claims)
+	RecordActivity(claims *auth.Claims, action, path string, details map[string]interface{})
+	WriteBlocked() error // non-nil while the server is in read-only maintenance mode
+}
+
+// Server accepts SSH connections and serves the sftp subsystem.
+type Server struct {
+	metadata      *postgres.Store
+	storageRouter *storage.Router
+	auth          *auth.Auth
+	permissions   *sharing.PermissionStore
+	quotaStore    *quota.QuotaStore
+	api           API
+	uploads       *uploadsvc.Service
+
+	sshConfig *ssh.ServerConfig
+	listener  net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer creates an SFTP server using the given host key.
+func NewServer(
+	metadata *postgres.Store,
+	storageRouter *storage.Router,
+	authHandler *auth.Auth,
+	permissions *sharing.PermissionStore,
+	quotaStore *quota.QuotaStore,
+	api API,
+	uploads *uploadsvc.Service,
+	hostKey ssh.Signer,
+) *Server {
+	s := &Server{
+		metadata:      metadata,
+		storageRouter: storageRouter,
+		auth:          authHandler,
+		permissions:   permissions,
+		quotaStore:    quotaStore,
+		api:           api,
+		uploads:       uploads,
+		conns:         make(map[net.Conn]struct{}),
+	}
+	s.sshConfig = &ssh.ServerConfig{
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+		ServerVersion:     "SSH-2.0-FruitSalade",
+	}
+	s.sshConfig.AddHostKey(hostKey)
+	return s
+}
+
+// checkPassword authenticates against the user table. Accounts with TOTP
+// enabled cannot log in with a password alone and must use a public key.
+func (s *Server) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	ctx := context.Background()
+	ip := remoteIP(conn.RemoteAddr())
+	if wait := s.auth.LoginRetryAfter(conn.User(), ip); wait > 0 {
+		return nil, fmt.Errorf("too many failed login attempts, try again in %s", wait.Round(time.Second))
+	}
+	claims, err := s.auth.ValidateCredentials(ctx, conn.User(), string(password))
+	if err != nil {
+		metrics.RecordAuthAttempt(false)
+		logging.Warn("sftp auth failed",
+			zap.String("username", conn.User()),
+			zap.String("remote", conn.RemoteAddr().String()),
+			zap.Error(err))
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			s.auth.CountLoginFailure(conn.User(), ip)
+		}
+		return nil, err
+	}
+	if enabled, _ := s.auth.IsTOTPEnabled(ctx, claims.UserID); enabled {
+		metrics.RecordAuthAttempt(false)
+		logging.Warn("sftp password auth refused: TOTP enabled",
+			zap.String("username", conn.User()))
+		return nil, fmt.Errorf("two-factor authentication enabled; use a public key")
+	}
+	metrics.RecordAuthAttempt(true)
+	s.auth.ResetLoginFailures(conn.User())
+	return claimsPermissions(claims), nil
+}
+
+// remoteIP returns the IP of a connection's remote address.
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// checkPublicKey authenticates with a key registered via the API.
+func (s *Server) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	claims, err := s.auth.ValidatePublicKey(context.Background(), conn.User(), key)
+	if err != nil {
+		// Clients offer every key they have, so failures are expected
+		logging.Debug("sftp public key rejected",
+			zap.String("username", conn.User()),
+			zap.String("fingerprint", ssh.FingerprintSHA256(key)))
+		return nil, err
+	}
+	metrics.RecordAuthAttempt(true)
+	return claimsPermissions(claims), nil
+}
+
+func claimsPermissions(claims *auth.Claims) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			extUserID:  strconv.Itoa(claims.UserID),
+			extIsAdmin: strconv.FormatBool(claims.IsAdmin),
+		},
+	}
+}
+
+func claimsFromPermissions(username string, perms *ssh.Permissions) (*auth.Claims, error) {
+	if perms == nil {
+		return nil, fmt.Errorf("connection not authenticated")
+	}
+	userID, err := strconv.Atoi(perms.Extensions[extUserID])
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id: %w", err)
+	}
+	isAdmin, _ := strconv.ParseBool(perms.Extensions[extIsAdmin])
+	return &auth.Claims{UserID: userID, Username: username, IsAdmin: isAdmin}, nil
+}
+
+// Start listens on addr and serves connections in the background.
+func (s *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("sftp listen: %w", err)
+	}
+	s.listener = ln
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.track(conn, true)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.track(conn, false)
+				s.serveConn(conn)
+			}()
+		}
+	}()
+
+	logging.Info("sftp server listening", zap.String("addr", ln.Addr().String()))
+	return nil
+}
+
+// Stop closes the listener and all open connections, then waits for the
+// connection handlers to finish.
+func (s *Server) Stop() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	logging.Info("sftp server stopped")
+}
+
+func (s *Server) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) serveConn(nConn net.Conn) {
+	defer nConn.Close()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(nConn, s.sshConfig)
+	if err != nil {
+		logging.Debug("sftp handshake failed",
+			zap.String("remote", nConn.RemoteAddr().String()), zap.Error(err))
+		return
+	}
+	defer sconn.Close()
+
+	claims, err := claimsFromPermissions(sconn.User(), sconn.Permissions)
+	if err != nil {
+		logging.Warn("sftp connection rejected", zap.Error(err))
+		return
+	}
+	logging.Info("sftp session opened",
+		zap.String("username", claims.Username),
+		zap.String("remote", sconn.RemoteAddr().String()))
+
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			logging.Warn("sftp channel accept failed", zap.Error(err))
+			continue
+		}
+		go s.serveSession(ch, requests, claims)
+	}
+
+	logging.Info("sftp session closed", zap.String("username", claims.Username))
+}
+
+// serveSession waits for the sftp subsystem request on a session channel.
+// Shells and exec requests are refused.
+func (s *Server) serveSession(ch ssh.Channel, requests <-chan *ssh.Request, claims *auth.Claims) {
+	defer ch.Close()
+
+	for req := range requests {
+		var payload struct{ Name string }
+		ok := req.Type == "subsystem" &&
+			ssh.Unmarshal(req.Payload, &payload) == nil &&
+			payload.Name == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		fs := &fileSystem{srv: s, claims: claims}
+		server := sftp.NewRequestServer(ch, fs.handlers())
+		if err := server.Serve(); err != nil && err != io.EOF {
+			logging.Debug("sftp session ended", zap.String("username", claims.Username), zap.Error(err))
+		}
+		server.Close()
+		return
+	}
+}
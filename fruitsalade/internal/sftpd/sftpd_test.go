@@ -0,0 +1,171 @@
+package sftpd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
+)
+
+func TestLoadOrCreateHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "host_key")
+
+	first, err := LoadOrCreateHostKey(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("key not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	second, err := LoadOrCreateHostKey(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if ssh.FingerprintSHA256(first.PublicKey()) != ssh.FingerprintSHA256(second.PublicKey()) {
+		t.Error("reloaded key differs from the generated one")
+	}
+
+	os.WriteFile(path, []byte("garbage"), 0600)
+	if _, err := LoadOrCreateHostKey(path); err == nil {
+		t.Error("expected error for an invalid key file")
+	}
+}
+
+func TestClaimsRoundTrip(t *testing.T) {
+	perms := claimsPermissions(&auth.Claims{UserID: 42, Username: "alice", IsAdmin: true})
+	claims, err := claimsFromPermissions("alice", perms)
+	if err != nil {
+		t.Fatalf("claimsFromPermissions: %v", err)
+	}
+	if claims.UserID != 42 || claims.Username != "alice" || !claims.IsAdmin {
+		t.Errorf("got %+v", claims)
+	}
+
+	if _, err := claimsFromPermissions("alice", nil); err == nil {
+		t.Error("expected error without permissions")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := map[string]string{
+		"":              "/",
+		"/":             "/",
+		"docs/a.txt":    "/docs/a.txt",
+		"/docs//a.txt":  "/docs/a.txt",
+		"/docs/../etc":  "/etc",
+		"../../outside": "/outside",
+	}
+	for in, want := range tests {
+		if got := cleanPath(in); got != want {
+			t.Errorf("cleanPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestListerAt(t *testing.T) {
+	l := listerAt{&fileInfo{name: "a"}, &fileInfo{name: "b"}, &fileInfo{name: "c"}}
+
+	buf := make([]os.FileInfo, 2)
+	n, err := l.ListAt(buf, 0)
+	if n != 2 || err != nil {
+		t.Errorf("first page: n=%d err=%v", n, err)
+	}
+	n, err = l.ListAt(buf, 2)
+	if n != 1 || err != io.EOF || buf[0].Name() != "c" {
+		t.Errorf("last page: n=%d err=%v", n, err)
+	}
+	if n, err = l.ListAt(buf, 3); n != 0 || err != io.EOF {
+		t.Errorf("past end: n=%d err=%v", n, err)
+	}
+}
+
+func TestObjectReader(t *testing.T) {
+	backend, err := local.New(local.Config{RootPath: t.TempDir(), CreateDirs: true})
+	if err != nil {
+		t.Fatalf("local backend: %v", err)
+	}
+	ctx := context.Background()
+	content := "0123456789abcdefghij"
+	backend.PutObject(ctx, "files/data.txt", strings.NewReader(content), int64(len(content)))
+
+	var tracked int64
+	r := &objectReader{
+		ctx:     ctx,
+		backend: backend,
+		key:     "files/data.txt",
+		size:    int64(len(content)),
+		done:    func(n int64) { tracked = n },
+	}
+
+	buf := make([]byte, 5)
+	for _, tc := range []struct {
+		off  int64
+		want string
+	}{
+		{0, "01234"},
+		{5, "56789"},  // sequential, reuses the stream
+		{15, "fghij"}, // seek forward
+		{2, "23456"},  // seek back
+	} {
+		n, err := r.ReadAt(buf, tc.off)
+		if err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d): %v", tc.off, err)
+		}
+		if got := string(buf[:n]); got != tc.want {
+			t.Errorf("ReadAt(%d) = %q, want %q", tc.off, got, tc.want)
+		}
+	}
+
+	n, err := r.ReadAt(buf, 18)
+	if n != 2 || err != io.EOF {
+		t.Errorf("short read at end: n=%d err=%v", n, err)
+	}
+	if _, err := r.ReadAt(buf, 20); err != io.EOF {
+		t.Errorf("read past end: %v", err)
+	}
+
+	r.Close()
+	if tracked != 22 {
+		t.Errorf("tracked %d bytes, want 22", tracked)
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	tmp, err := os.CreateTemp(t.TempDir(), "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upload{path: "/big.bin", file: tmp, limit: 8}
+	defer u.discard()
+
+	if _, err := u.WriteAt([]byte("1234"), 0); err != nil {
+		t.Fatalf("write within limit: %v", err)
+	}
+	if _, err := u.WriteAt([]byte("5678"), 4); err != nil {
+		t.Fatalf("write up to limit: %v", err)
+	}
+	if _, err := u.WriteAt([]byte("9"), 8); err == nil {
+		t.Error("write past limit should fail")
+	}
+
+	// An interrupted transfer is not committed
+	u.TransferError(io.ErrUnexpectedEOF)
+	if err := u.Close(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Close after transfer error = %v", err)
+	}
+	if _, err := os.Stat(tmp.Name()); !os.IsNotExist(err) {
+		t.Error("spool file was not removed")
+	}
+}
@@ -26,7 +26,8 @@ type Group struct {
 type GroupMember struct {
 	UserID   int       `json:"user_id"`
 	Username string    `json:"username"`
-	Role     string    `json:"role"` // "admin", "editor", "viewer"
+	Role     string    `json:"role"`   // "admin", "editor", "viewer"
+	Source   string    `json:"source"` // "manual" or "oidc"
 	AddedAt  time.Time `json:"added_at"`
 }
 
@@ -54,14 +55,19 @@ func (s *GroupStore) DB() *sql.DB {
 	return s.db
 }
 
-// CreateGroup creates a new group with optional parent.
+// CreateGroup creates a new group with optional parent. A createdBy of 0
+// records no creator, for groups created by tools.
 func (s *GroupStore) CreateGroup(ctx context.Context, name, description string, parentID *int, createdBy int) (*Group, error) {
+	var creator *int
+	if createdBy > 0 {
+		creator = &createdBy
+	}
 	var g Group
 	err := s.db.QueryRowContext(ctx,
 		`INSERT INTO groups (name, description, parent_id, created_by)
 		 VALUES ($1, $2, $3, $4)
 		 RETURNING id, name, description, parent_id, created_by, created_at`,
-		name, description, parentID, createdBy).Scan(&g.ID, &g.Name, &g.Description, &g.ParentID, &g.CreatedBy, &g.CreatedAt)
+		name, description, parentID, creator).Scan(&g.ID, &g.Name, &g.Description, &g.ParentID, &g.CreatedBy, &g.CreatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("create group: %w", err)
 	}
@@ -145,15 +151,59 @@ func (s *GroupStore) GetGroup(ctx context.Context, groupID int) (*Group, error)
 	return &g, nil
 }
 
-// AddMember adds a user to a group with a role.
+// GetGroupIDByName returns the ID of the group with the given name, or 0
+// if there is none.
+func (s *GroupStore) GetGroupIDByName(ctx context.Context, name string) (int, error) {
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM groups WHERE name = $1`, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lookup group: %w", err)
+	}
+	return id, nil
+}
+
+// GroupPath builds the full path of a group's folder by walking up the
+// hierarchy, e.g. "/engineering/backend".
+func (s *GroupStore) GroupPath(ctx context.Context, group *Group) (string, error) {
+	if group.ParentID == nil {
+		return "/" + group.Name, nil
+	}
+
+	// Walk up to build path segments
+	var segments []string
+	segments = append(segments, group.Name)
+
+	currentID := group.ParentID
+	for currentID != nil {
+		parent, err := s.GetGroup(ctx, *currentID)
+		if err != nil {
+			return "", err
+		}
+		segments = append(segments, parent.Name)
+		currentID = parent.ParentID
+	}
+
+	// Reverse to get top-down order
+	path := ""
+	for i := len(segments) - 1; i >= 0; i-- {
+		path += "/" + segments[i]
+	}
+	return path, nil
+}
+
+// AddMember adds a user to a group with a role. A membership added this way
+// is manual, so OIDC group sync leaves it alone even if it existed before.
 func (s *GroupStore) AddMember(ctx context.Context, groupID, userID int, role string) error {
 	if role == "" {
 		role = "viewer"
 	}
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO group_members (group_id, user_id, role)
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT (group_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		`INSERT INTO group_members (group_id, user_id, role, source)
+		 VALUES ($1, $2, $3, 'manual')
+		 ON CONFLICT (group_id, user_id) DO UPDATE SET role = EXCLUDED.role, source = 'manual'`,
 		groupID, userID, role)
 	if err != nil {
 		return fmt.Errorf("add member: %w", err)
@@ -175,7 +225,7 @@ func (s *GroupStore) RemoveMember(ctx context.Context, groupID, userID int) erro
 // ListMembers returns all members of a group with roles.
 func (s *GroupStore) ListMembers(ctx context.Context, groupID int) ([]GroupMember, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT gm.user_id, u.username, gm.role, gm.added_at
+		`SELECT gm.user_id, u.username, gm.role, gm.source, gm.added_at
 		 FROM group_members gm
 		 JOIN users u ON u.id = gm.user_id
 		 WHERE gm.group_id = $1
@@ -188,7 +238,7 @@ func (s *GroupStore) ListMembers(ctx context.Context, groupID int) ([]GroupMembe
 	var members []GroupMember
 	for rows.Next() {
 		var m GroupMember
-		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.AddedAt); err != nil {
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.Source, &m.AddedAt); err != nil {
 			return nil, fmt.Errorf("scan member: %w", err)
 		}
 		members = append(members, m)
@@ -522,13 +572,15 @@ func (s *GroupStore) GetUsernameByID(ctx context.Context, userID int) (string, e
 
 // ─── Permissions ────────────────────────────────────────────────────────────
 
-// SetPermission sets a permission for a group on a path (upsert).
-func (s *GroupStore) SetPermission(ctx context.Context, groupID int, path, permission string) error {
+// SetPermission sets a permission for a group on a path (upsert). grantedBy
+// is the granting user, 0 for grants made by the system.
+func (s *GroupStore) SetPermission(ctx context.Context, groupID int, path, permission string, grantedBy int) error {
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO group_permissions (group_id, path, permission)
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT (group_id, path) DO UPDATE SET permission = EXCLUDED.permission`,
-		groupID, path, permission)
+		`INSERT INTO group_permissions (group_id, path, permission, granted_by)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (group_id, path) DO UPDATE
+		 SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by`,
+		groupID, path, permission, nullUserID(grantedBy))
 	if err != nil {
 		return fmt.Errorf("set group permission: %w", err)
 	}
@@ -570,6 +622,31 @@ func (s *GroupStore) ListPermissionsByGroup(ctx context.Context, groupID int) ([
 	return perms, rows.Err()
 }
 
+// ListUserGroupPermissions returns the permissions of the groups a user is
+// a direct member of, ordered by group and path.
+func (s *GroupStore) ListUserGroupPermissions(ctx context.Context, userID int) ([]GroupPermission, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT gp.id, gp.group_id, gp.path, gp.permission
+		 FROM group_permissions gp
+		 JOIN group_members gm ON gm.group_id = gp.group_id
+		 WHERE gm.user_id = $1
+		 ORDER BY gp.group_id, gp.path`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list user group permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var perms []GroupPermission
+	for rows.Next() {
+		var p GroupPermission
+		if err := rows.Scan(&p.ID, &p.GroupID, &p.Path, &p.Permission); err != nil {
+			return nil, fmt.Errorf("scan permission: %w", err)
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
 // ListPermissionsByPath returns all group permissions for a given path.
 func (s *GroupStore) ListPermissionsByPath(ctx context.Context, path string) ([]GroupPermission, error) {
 	rows, err := s.db.QueryContext(ctx,
@@ -0,0 +1,178 @@
+package sharing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+)
+
+// MembershipSourceOIDC marks group memberships managed by OIDC claim sync.
+const MembershipSourceOIDC = "oidc"
+
+// MembershipSync is the outcome of syncing a user's memberships.
+type MembershipSync struct {
+	Added   []int    // group IDs the user was added to
+	Removed []int    // group IDs the user was removed from
+	Created []*Group // groups created because they did not exist yet
+	Unknown []string // names with no matching group (autocreate off)
+}
+
+// Changed reports whether the sync modified anything.
+func (m *MembershipSync) Changed() bool {
+	return len(m.Added) > 0 || len(m.Removed) > 0 || len(m.Created) > 0
+}
+
+// SyncMemberships makes the user's memberships with the given source match
+// groupNames: missing memberships are added with defaultRole and those no
+// longer listed are removed. Memberships from other sources (e.g. added by
+// an admin) are never touched. With autoCreate, unknown names become new
+// top-level groups.
+func (s *GroupStore) SyncMemberships(ctx context.Context, userID int, groupNames []string, source, defaultRole string, autoCreate bool) (*MembershipSync, error) {
+	if defaultRole == "" {
+		defaultRole = "viewer"
+	}
+	result := &MembershipSync{}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	keep := []int{}
+	for _, name := range groupNames {
+		var groupID int
+		err := tx.QueryRowContext(ctx, `SELECT id FROM groups WHERE name = $1`, name).Scan(&groupID)
+		if err == sql.ErrNoRows {
+			if !autoCreate {
+				result.Unknown = append(result.Unknown, name)
+				continue
+			}
+			g := &Group{}
+			err = tx.QueryRowContext(ctx,
+				`INSERT INTO groups (name, description) VALUES ($1, $2)
+				 RETURNING id, name, description, parent_id, created_by, created_at`,
+				name, "Created from identity provider groups").
+				Scan(&g.ID, &g.Name, &g.Description, &g.ParentID, &g.CreatedBy, &g.CreatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("create group %q: %w", name, err)
+			}
+			groupID = g.ID
+			result.Created = append(result.Created, g)
+		} else if err != nil {
+			return nil, fmt.Errorf("lookup group %q: %w", name, err)
+		}
+		keep = append(keep, groupID)
+
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO group_members (group_id, user_id, role, source)
+			 VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (group_id, user_id) DO NOTHING`,
+			groupID, userID, defaultRole, source)
+		if err != nil {
+			return nil, fmt.Errorf("add member: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			result.Added = append(result.Added, groupID)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`DELETE FROM group_members
+		 WHERE user_id = $1 AND source = $2 AND NOT (group_id = ANY($3))
+		 RETURNING group_id`,
+		userID, source, pq.Array(keep))
+	if err != nil {
+		return nil, fmt.Errorf("remove stale members: %w", err)
+	}
+	for rows.Next() {
+		var groupID int
+		if err := rows.Scan(&groupID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan removed member: %w", err)
+		}
+		result.Removed = append(result.Removed, groupID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return result, nil
+}
+
+// ClaimGroupSync keeps group memberships in line with the groups claim of
+// an identity provider token and provisions folders like the admin API.
+type ClaimGroupSync struct {
+	groups      *GroupStore
+	provisioner *Provisioner
+	defaultRole string
+	autoCreate  bool
+	onChange    func(ctx context.Context)
+}
+
+// NewClaimGroupSync creates a claim sync. provisioner may be nil.
+func NewClaimGroupSync(groups *GroupStore, provisioner *Provisioner, defaultRole string, autoCreate bool) *ClaimGroupSync {
+	return &ClaimGroupSync{
+		groups:      groups,
+		provisioner: provisioner,
+		defaultRole: defaultRole,
+		autoCreate:  autoCreate,
+	}
+}
+
+// SetOnChange registers a callback run after a sync that changed folders
+// or memberships (used to refresh the metadata tree).
+func (c *ClaimGroupSync) SetOnChange(fn func(ctx context.Context)) {
+	c.onChange = fn
+}
+
+// SyncGroups applies the group names from a user's token.
+func (c *ClaimGroupSync) SyncGroups(ctx context.Context, userID int, groupNames []string) error {
+	result, err := c.groups.SyncMemberships(ctx, userID, groupNames, MembershipSourceOIDC, c.defaultRole, c.autoCreate)
+	if err != nil {
+		return err
+	}
+
+	if c.provisioner != nil {
+		for _, g := range result.Created {
+			if err := c.provisioner.ProvisionGroupFolders(ctx, g); err != nil {
+				logging.Warn("failed to provision group folders",
+					zap.Int("group_id", g.ID), zap.Error(err))
+			}
+		}
+		for _, groupID := range result.Added {
+			if err := c.provisioner.ProvisionUserHome(ctx, userID, groupID); err != nil {
+				logging.Warn("failed to provision user home",
+					zap.Int("user_id", userID), zap.Int("group_id", groupID), zap.Error(err))
+			}
+		}
+		for _, groupID := range result.Removed {
+			_ = c.provisioner.DeprovisionUserHome(ctx, userID, groupID)
+		}
+	}
+
+	if len(result.Unknown) > 0 {
+		logging.Debug("oidc groups without a matching group",
+			zap.Int("user_id", userID), zap.Strings("groups", result.Unknown))
+	}
+	if result.Changed() {
+		logging.Info("oidc group memberships synced",
+			zap.Int("user_id", userID),
+			zap.Ints("added", result.Added),
+			zap.Ints("removed", result.Removed),
+			zap.Int("groups_created", len(result.Created)))
+		if c.onChange != nil {
+			c.onChange(ctx)
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 
@@ -36,21 +37,102 @@ type Permission struct {
 	Username   string
 	Path       string
 	Permission string // "owner", "read", "write"
+	Recursive  bool   // granted on a directory for its whole subtree
 }
 
-// SetPermission grants a permission for a user on a path.
-func (s *PermissionStore) SetPermission(ctx context.Context, userID int, path, permission string) error {
+// SetPermission grants a permission for a user on a path. grantedBy is the
+// granting user, 0 for grants made by the system.
+func (s *PermissionStore) SetPermission(ctx context.Context, userID int, path, permission string, grantedBy int) error {
+	return s.setPermission(ctx, userID, path, permission, grantedBy, false)
+}
+
+// SetRecursivePermission grants a permission for a user on a directory and,
+// through inheritance, everything below it. It is one row like any other
+// grant, marked recursive in listings.
+func (s *PermissionStore) SetRecursivePermission(ctx context.Context, userID int, path, permission string, grantedBy int) error {
+	return s.setPermission(ctx, userID, path, permission, grantedBy, true)
+}
+
+func (s *PermissionStore) setPermission(ctx context.Context, userID int, path, permission string, grantedBy int, recursive bool) error {
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO file_permissions (user_id, path, permission)
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT (user_id, path) DO UPDATE SET permission = EXCLUDED.permission`,
-		userID, path, permission)
+		`INSERT INTO file_permissions (user_id, path, permission, granted_by, recursive)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (user_id, path) DO UPDATE
+		 SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by, recursive = EXCLUDED.recursive`,
+		userID, path, permission, nullUserID(grantedBy), recursive)
 	if err != nil {
 		return fmt.Errorf("set permission: %w", err)
 	}
 	return nil
 }
 
+// permissionTreeBatch is the number of rows SetPermissionTree writes per
+// statement, and so how often it reports progress.
+const permissionTreeBatch = 500
+
+// SetPermissionTree grants a permission for a user on path and on every
+// existing file and directory below it, as one explicit row each, in a
+// single transaction. progress, if not nil, is called after each batch of
+// rows with the number written so far and the total. It returns the number
+// of rows written.
+func (s *PermissionStore) SetPermissionTree(ctx context.Context, userID int, path, permission string, grantedBy int,
+	progress func(done, total int)) (int, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("set_permission_tree", time.Since(start)) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	rows, err := tx.QueryContext(ctx,
+		`SELECT path FROM files
+		 WHERE (path = $1 OR left(path, length($2)) = $2) AND deleted_at IS NULL
+		 ORDER BY path`, path, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("list subtree: %w", err)
+	}
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan path: %w", err)
+		}
+		paths = append(paths, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("list subtree: %w", err)
+	}
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("file not found: %s", path)
+	}
+
+	for i := 0; i < len(paths); i += permissionTreeBatch {
+		end := min(i+permissionTreeBatch, len(paths))
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO file_permissions (user_id, path, permission, granted_by)
+			 SELECT $1, p, $3, $4 FROM unnest($2::text[]) AS p
+			 ON CONFLICT (user_id, path) DO UPDATE
+			 SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by, recursive = FALSE`,
+			userID, pq.Array(paths[i:end]), permission, nullUserID(grantedBy))
+		if err != nil {
+			return 0, fmt.Errorf("set permissions: %w", err)
+		}
+		if progress != nil {
+			progress(end, len(paths))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return len(paths), nil
+}
+
 // RemovePermission removes a user's permission on a path.
 func (s *PermissionStore) RemovePermission(ctx context.Context, userID int, path string) error {
 	_, err := s.db.ExecContext(ctx,
@@ -62,10 +144,142 @@ func (s *PermissionStore) RemovePermission(ctx context.Context, userID int, path
 	return nil
 }
 
+// RemovePermissionTree removes a user's permissions on path and everything
+// below it. Returns the number of rows removed.
+func (s *PermissionStore) RemovePermissionTree(ctx context.Context, userID int, path string) (int64, error) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM file_permissions
+		 WHERE user_id = $1 AND (path = $2 OR left(path, length($3)) = $3)`,
+		userID, path, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("remove permissions: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// CopyPermissions copies the user permissions of from onto to, replacing
+// grants the same users already have there, in one transaction. With
+// groups, the group permissions of from are copied too. grantedBy becomes
+// the grantor of the copies. It returns the copied user permissions and
+// the number of copied group permissions.
+func (s *PermissionStore) CopyPermissions(ctx context.Context, from, to string, grantedBy int, groups bool) ([]Permission, int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`INSERT INTO file_permissions (user_id, path, permission, granted_by, recursive)
+		 SELECT user_id, $2, permission, $3, recursive FROM file_permissions WHERE path = $1
+		 ON CONFLICT (user_id, path) DO UPDATE
+		 SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by, recursive = EXCLUDED.recursive
+		 RETURNING id, user_id, path, permission, recursive`,
+		from, to, nullUserID(grantedBy))
+	if err != nil {
+		return nil, 0, fmt.Errorf("copy permissions: %w", err)
+	}
+	var copied []Permission
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Path, &p.Permission, &p.Recursive); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("scan permission: %w", err)
+		}
+		copied = append(copied, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("copy permissions: %w", err)
+	}
+
+	var groupCount int64
+	if groups {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO group_permissions (group_id, path, permission, granted_by)
+			 SELECT group_id, $2, permission, $3 FROM group_permissions WHERE path = $1
+			 ON CONFLICT (group_id, path) DO UPDATE
+			 SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by`,
+			from, to, nullUserID(grantedBy))
+		if err != nil {
+			return nil, 0, fmt.Errorf("copy group permissions: %w", err)
+		}
+		groupCount, _ = res.RowsAffected()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("commit: %w", err)
+	}
+	return copied, groupCount, nil
+}
+
+// SharedItem is a path shared with a user by someone else, directly or
+// through one of their groups.
+type SharedItem struct {
+	Path       string
+	Name       string
+	IsDir      bool
+	Size       int64
+	ModTime    time.Time
+	Permission string
+	GrantedBy  string // empty if unknown
+	GrantedAt  time.Time
+	GroupID    int // 0 for direct grants
+	GroupName  string
+}
+
+// SharedWith returns the existing paths a user was given permission on by
+// someone else, newest grant first. Grants the user made themselves and
+// grants on paths they own (such as their home directory) are left out.
+func (s *PermissionStore) SharedWith(ctx context.Context, userID int) ([]SharedItem, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT f.path, f.name, f.is_dir, f.size, f.mod_time, fp.permission,
+		        COALESCE(u.username, ''), fp.created_at, 0, ''
+		 FROM file_permissions fp
+		 JOIN files f ON f.path = fp.path AND f.deleted_at IS NULL
+		 LEFT JOIN users u ON u.id = fp.granted_by
+		 WHERE fp.user_id = $1
+		   AND fp.granted_by IS DISTINCT FROM $1
+		   AND f.owner_id IS DISTINCT FROM $1
+		 UNION ALL
+		 SELECT f.path, f.name, f.is_dir, f.size, f.mod_time, gp.permission,
+		        COALESCE(u.username, ''), gp.created_at, g.id, g.name
+		 FROM group_permissions gp
+		 JOIN group_members gm ON gm.group_id = gp.group_id AND gm.user_id = $1
+		 JOIN groups g ON g.id = gp.group_id
+		 JOIN files f ON f.path = gp.path AND f.deleted_at IS NULL
+		 LEFT JOIN users u ON u.id = gp.granted_by
+		 WHERE gp.granted_by IS DISTINCT FROM $1
+		   AND f.owner_id IS DISTINCT FROM $1
+		 ORDER BY 8 DESC, 1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list shared with user: %w", err)
+	}
+	defer rows.Close()
+
+	var items []SharedItem
+	for rows.Next() {
+		var it SharedItem
+		if err := rows.Scan(&it.Path, &it.Name, &it.IsDir, &it.Size, &it.ModTime, &it.Permission,
+			&it.GrantedBy, &it.GrantedAt, &it.GroupID, &it.GroupName); err != nil {
+			return nil, fmt.Errorf("scan shared item: %w", err)
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// nullUserID maps the user ID 0 to NULL.
+func nullUserID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
 // ListPermissions returns all permissions for a path.
 func (s *PermissionStore) ListPermissions(ctx context.Context, path string) ([]Permission, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT fp.id, fp.user_id, u.username, fp.path, fp.permission
+		`SELECT fp.id, fp.user_id, u.username, fp.path, fp.permission, fp.recursive
 		 FROM file_permissions fp
 		 JOIN users u ON u.id = fp.user_id
 		 WHERE fp.path = $1
@@ -78,7 +292,7 @@ func (s *PermissionStore) ListPermissions(ctx context.Context, path string) ([]P
 	var perms []Permission
 	for rows.Next() {
 		var p Permission
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Path, &p.Permission); err != nil {
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Username, &p.Path, &p.Permission, &p.Recursive); err != nil {
 			return nil, fmt.Errorf("scan permission: %w", err)
 		}
 		perms = append(perms, p)
@@ -171,26 +385,53 @@ func (s *PermissionStore) GetOwnerID(ctx context.Context, path string) (int, boo
 
 // ─── Visibility ─────────────────────────────────────────────────────────────
 
+// Visibility is the effective visibility of a node. A node with an empty
+// visibility inherits it from the nearest ancestor with an explicit one;
+// OwnerID and GroupID come from the node that set it.
+type Visibility struct {
+	Value   string
+	GroupID int
+	OwnerID int
+}
+
+// Inherit returns the effective visibility of node, a child of the node
+// with visibility v. The zero Visibility is public.
+func (v Visibility) Inherit(node *models.FileNode) Visibility {
+	if node.Visibility == "" {
+		return v
+	}
+	return Visibility{Value: node.Visibility, GroupID: node.GroupID, OwnerID: node.OwnerID}
+}
+
 // CheckVisibility returns true if the user can see this node based on visibility.
+// An empty visibility is treated as public; use CheckEffectiveVisibility when
+// the inherited value is known.
 func (s *PermissionStore) CheckVisibility(node *models.FileNode, userID int, isAdmin bool, userGroups map[int]string) bool {
+	return s.CheckEffectiveVisibility(node, Visibility{}.Inherit(node), userID, isAdmin, userGroups)
+}
+
+// CheckEffectiveVisibility returns true if the user can see node, given its
+// effective visibility eff. Inherited private nodes are visible to their own
+// owner and to the owner of the directory that made them private.
+func (s *PermissionStore) CheckEffectiveVisibility(node *models.FileNode, eff Visibility, userID int, isAdmin bool, userGroups map[int]string) bool {
 	if isAdmin {
 		return true
 	}
 
-	vis := node.Visibility
+	vis := eff.Value
 	if vis == "" || vis == "public" {
 		return true
 	}
 
 	if vis == "private" {
-		return node.OwnerID == userID
+		return node.OwnerID == userID || eff.OwnerID == userID
 	}
 
 	if vis == "group" {
-		if node.GroupID == 0 {
+		if eff.GroupID == 0 {
 			return true // no group_id set, treat as public
 		}
-		_, isMember := userGroups[node.GroupID]
+		_, isMember := userGroups[eff.GroupID]
 		return isMember
 	}
 
@@ -226,6 +467,65 @@ func (s *PermissionStore) GetVisibility(ctx context.Context, path string) (strin
 	return vis, nil
 }
 
+// SetVisibilityRecursive sets the visibility of a directory and clears it
+// on everything below, so the whole subtree inherits the new value. Returns
+// the number of rows changed.
+func (s *PermissionStore) SetVisibilityRecursive(ctx context.Context, path, visibility string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE files SET visibility = $2 WHERE path = $1`, path, visibility)
+	if err != nil {
+		return 0, fmt.Errorf("set visibility: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, fmt.Errorf("file not found: %s", path)
+	}
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	res, err = tx.ExecContext(ctx,
+		`UPDATE files SET visibility = ''
+		 WHERE left(path, length($1)) = $1 AND path <> $2 AND visibility <> ''`,
+		prefix, path)
+	if err != nil {
+		return 0, fmt.Errorf("clear descendant visibility: %w", err)
+	}
+	cleared, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return cleared + 1, nil
+}
+
+// ResolveVisibility returns the visibility a new file at path inherits: the
+// explicit visibility (and group) of its nearest ancestor, or "public".
+func (s *PermissionStore) ResolveVisibility(ctx context.Context, path string) (string, *int, error) {
+	ancestors := PathSegments(path)[1:]
+	var vis string
+	var groupID sql.NullInt64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT visibility, group_id FROM files
+		 WHERE path = ANY($1) AND visibility <> '' AND deleted_at IS NULL
+		 ORDER BY length(path) DESC LIMIT 1`,
+		pq.Array(ancestors)).Scan(&vis, &groupID)
+	if err == sql.ErrNoRows {
+		return "public", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("resolve visibility: %w", err)
+	}
+	if groupID.Valid {
+		id := int(groupID.Int64)
+		return vis, &id, nil
+	}
+	return vis, nil, nil
+}
+
 // GetUserPermissionsMap returns all file permissions for a user as a map[path]permission.
 func (s *PermissionStore) GetUserPermissionsMap(ctx context.Context, userID int) (map[string]string, error) {
 	rows, err := s.db.QueryContext(ctx,
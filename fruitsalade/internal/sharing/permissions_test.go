@@ -104,3 +104,73 @@ func TestCheckVisibility(t *testing.T) {
 		t.Error("admin should see group node regardless of membership")
 	}
 }
+
+func TestInheritedVisibility(t *testing.T) {
+	store := &PermissionStore{}
+	members := map[int]string{5: "viewer"}
+
+	// /team is a group directory containing an inheriting file and an
+	// explicitly public one
+	root := &models.FileNode{Path: "/", Visibility: ""}
+	teamDir := &models.FileNode{Path: "/team", Visibility: "group", GroupID: 5, OwnerID: 1, IsDir: true}
+	inheriting := &models.FileNode{Path: "/team/plan.txt", OwnerID: 1}
+	public := &models.FileNode{Path: "/team/readme.txt", Visibility: "public", OwnerID: 1}
+
+	dirVis := Visibility{}.Inherit(root).Inherit(teamDir)
+	if dirVis.Value != "group" || dirVis.GroupID != 5 {
+		t.Fatalf("dir visibility = %+v", dirVis)
+	}
+
+	inhVis := dirVis.Inherit(inheriting)
+	if inhVis.Value != "group" || inhVis.GroupID != 5 {
+		t.Errorf("empty visibility should inherit the group setting, got %+v", inhVis)
+	}
+	if !store.CheckEffectiveVisibility(inheriting, inhVis, 2, false, members) {
+		t.Error("inherited group file should be visible to members")
+	}
+	if store.CheckEffectiveVisibility(inheriting, inhVis, 2, false, nil) {
+		t.Error("inherited group file should be hidden from non-members")
+	}
+
+	pubVis := dirVis.Inherit(public)
+	if pubVis.Value != "public" {
+		t.Errorf("explicit visibility should win over the directory, got %+v", pubVis)
+	}
+	if !store.CheckEffectiveVisibility(public, pubVis, 2, false, nil) {
+		t.Error("explicitly public file should be visible to non-members")
+	}
+
+	// Nothing set anywhere: public
+	if v := (Visibility{}).Inherit(root).Inherit(inheriting); v.Value != "" {
+		t.Errorf("no explicit visibility should stay empty (public), got %+v", v)
+	}
+	if !store.CheckEffectiveVisibility(inheriting, Visibility{}, 2, false, nil) {
+		t.Error("file without any visibility should be public")
+	}
+}
+
+func TestInheritedPrivateVisibility(t *testing.T) {
+	store := &PermissionStore{}
+
+	home := &models.FileNode{Path: "/home/alice", Visibility: "private", OwnerID: 1, IsDir: true}
+	own := &models.FileNode{Path: "/home/alice/notes.txt", OwnerID: 1}
+	dropped := &models.FileNode{Path: "/home/alice/from-bob.txt", OwnerID: 2}
+
+	vis := Visibility{}.Inherit(home)
+	if !store.CheckEffectiveVisibility(own, vis.Inherit(own), 1, false, nil) {
+		t.Error("owner should see files in their private directory")
+	}
+	if store.CheckEffectiveVisibility(own, vis.Inherit(own), 3, false, nil) {
+		t.Error("others should not see files in a private directory")
+	}
+	// A file another user put there is visible to its owner and the dir owner
+	if !store.CheckEffectiveVisibility(dropped, vis.Inherit(dropped), 2, false, nil) {
+		t.Error("file owner should see their file")
+	}
+	if !store.CheckEffectiveVisibility(dropped, vis.Inherit(dropped), 1, false, nil) {
+		t.Error("directory owner should see files inheriting its private setting")
+	}
+	if !store.CheckEffectiveVisibility(dropped, vis.Inherit(dropped), 3, true, nil) {
+		t.Error("admin should see everything")
+	}
+}
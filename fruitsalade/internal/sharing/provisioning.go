@@ -10,6 +10,7 @@ import (
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
 // Provisioner handles auto-provisioning of group folders and user home directories.
@@ -32,19 +33,23 @@ func NewProvisioner(groups *GroupStore, meta *postgres.Store, perms *PermissionS
 // For top-level groups: /{group_name}/ and /{group_name}/shared/
 // For subgroups: resolves full path from top-level group down.
 func (p *Provisioner) ProvisionGroupFolders(ctx context.Context, group *Group) error {
-	groupPath, err := p.resolveGroupPath(ctx, group)
+	return p.provisionGroupFolders(ctx, group, nil)
+}
+
+func (p *Provisioner) provisionGroupFolders(ctx context.Context, group *Group, rep *provisionLog) error {
+	groupPath, err := p.groups.GroupPath(ctx, group)
 	if err != nil {
 		return fmt.Errorf("resolve group path: %w", err)
 	}
 
 	// Create group directory
-	if err := p.ensureDir(ctx, groupPath, 0, group.ID); err != nil {
+	if err := p.ensureDir(ctx, groupPath, 0, group.ID, rep); err != nil {
 		return fmt.Errorf("create group dir: %w", err)
 	}
 
 	// Create shared/ subdirectory with group visibility
 	sharedPath := groupPath + "/shared"
-	if err := p.ensureDirWithVisibility(ctx, sharedPath, 0, group.ID, "group"); err != nil {
+	if err := p.ensureDirWithVisibility(ctx, sharedPath, 0, group.ID, "group", rep); err != nil {
 		return fmt.Errorf("create shared dir: %w", err)
 	}
 
@@ -54,6 +59,10 @@ func (p *Provisioner) ProvisionGroupFolders(ctx context.Context, group *Group) e
 // ProvisionUserHome creates a user's home directory within a top-level group.
 // Creates /{group_name}/home/{username}/ with private visibility.
 func (p *Provisioner) ProvisionUserHome(ctx context.Context, userID, groupID int) error {
+	return p.provisionUserHome(ctx, userID, groupID, nil)
+}
+
+func (p *Provisioner) provisionUserHome(ctx context.Context, userID, groupID int, rep *provisionLog) error {
 	// Get the top-level group for this group
 	topGroup, err := p.groups.GetTopLevelGroup(ctx, groupID)
 	if err != nil {
@@ -69,24 +78,129 @@ func (p *Provisioner) ProvisionUserHome(ctx context.Context, userID, groupID int
 	// Create /{group_name}/home/ if it doesn't exist
 	groupPath := "/" + topGroup.Name
 	homePath := groupPath + "/home"
-	if err := p.ensureDir(ctx, homePath, 0, topGroup.ID); err != nil {
+	if err := p.ensureDir(ctx, homePath, 0, topGroup.ID, rep); err != nil {
 		return fmt.Errorf("create home dir: %w", err)
 	}
 
 	// Create /{group_name}/home/{username}/ with private visibility
 	userHomePath := homePath + "/" + username
-	if err := p.ensureDirWithVisibility(ctx, userHomePath, userID, topGroup.ID, "private"); err != nil {
+	if err := p.ensureDirWithVisibility(ctx, userHomePath, userID, topGroup.ID, "private", rep); err != nil {
 		return fmt.Errorf("create user home dir: %w", err)
 	}
 
-	// Grant write permission on home directory
-	if err := p.perms.SetPermission(ctx, userID, userHomePath, "write"); err != nil {
+	// Grant write permission on home directory. A repair leaves an existing
+	// grant alone, even one an admin has since changed.
+	item := protocol.ProvisionItem{Type: "permission", Path: userHomePath, UserID: userID, Permission: "write"}
+	if rep != nil {
+		has, err := p.hasPermission(ctx, userID, userHomePath)
+		if err != nil {
+			return fmt.Errorf("check home permission: %w", err)
+		}
+		if has {
+			rep.present(item)
+			return nil
+		}
+	}
+	if err := p.perms.SetPermission(ctx, userID, userHomePath, "write", 0); err != nil {
 		return fmt.Errorf("set home permission: %w", err)
 	}
+	rep.created(item)
 
 	return nil
 }
 
+// RepairGroup re-runs provisioning for a group and the homes of all its
+// members. Missing directories and home permissions are recreated; existing
+// ones, and everything inside them, are left as they are. A member whose
+// home cannot be repaired is recorded in the report's errors and does not
+// stop the others.
+func (p *Provisioner) RepairGroup(ctx context.Context, group *Group) (*protocol.ProvisionResponse, error) {
+	rep := newProvisionLog(group)
+	if err := p.provisionGroupFolders(ctx, group, rep); err != nil {
+		return nil, err
+	}
+
+	members, err := p.groups.ListMembers(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if err := p.provisionUserHome(ctx, m.UserID, group.ID, rep); err != nil {
+			rep.resp.Errors = append(rep.resp.Errors, fmt.Sprintf("home of %s: %v", m.Username, err))
+		}
+	}
+	return rep.resp, nil
+}
+
+// RepairAll runs RepairGroup for every group. A group that fails is logged
+// and skipped.
+func (p *Provisioner) RepairAll(ctx context.Context) ([]*protocol.ProvisionResponse, error) {
+	groups, err := p.groups.ListGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*protocol.ProvisionResponse
+	for i := range groups {
+		rep, err := p.RepairGroup(ctx, &groups[i])
+		if err != nil {
+			logging.Warn("provision repair failed",
+				zap.Int("group_id", groups[i].ID), zap.String("group", groups[i].Name), zap.Error(err))
+			continue
+		}
+		reports = append(reports, rep)
+	}
+	return reports, nil
+}
+
+func (p *Provisioner) hasPermission(ctx context.Context, userID int, path string) (bool, error) {
+	perms, err := p.perms.ListPermissions(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	for _, perm := range perms {
+		if perm.UserID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// provisionLog collects what a repair created and found. Each item is
+// reported once, however many members share it. A nil log records nothing.
+type provisionLog struct {
+	resp *protocol.ProvisionResponse
+	seen map[protocol.ProvisionItem]bool
+}
+
+func newProvisionLog(group *Group) *provisionLog {
+	return &provisionLog{
+		resp: &protocol.ProvisionResponse{
+			GroupID:   group.ID,
+			GroupName: group.Name,
+			Created:   []protocol.ProvisionItem{},
+			Present:   []protocol.ProvisionItem{},
+		},
+		seen: make(map[protocol.ProvisionItem]bool),
+	}
+}
+
+func (l *provisionLog) created(item protocol.ProvisionItem) {
+	if l == nil || l.seen[item] {
+		return
+	}
+	l.seen[item] = true
+	l.resp.Created = append(l.resp.Created, item)
+}
+
+func (l *provisionLog) present(item protocol.ProvisionItem) {
+	if l == nil || l.seen[item] {
+		return
+	}
+	l.seen[item] = true
+	l.resp.Present = append(l.resp.Present, item)
+}
+
 // DeprovisionUserHome removes permissions on a user's home directory.
 // Does NOT delete files (data preservation).
 func (p *Provisioner) DeprovisionUserHome(ctx context.Context, userID, groupID int) error {
@@ -108,48 +222,22 @@ func (p *Provisioner) DeprovisionUserHome(ctx context.Context, userID, groupID i
 	return nil
 }
 
-// resolveGroupPath builds the full path for a group by walking up the hierarchy.
-func (p *Provisioner) resolveGroupPath(ctx context.Context, group *Group) (string, error) {
-	if group.ParentID == nil {
-		return "/" + group.Name, nil
-	}
-
-	// Walk up to build path segments
-	var segments []string
-	segments = append(segments, group.Name)
-
-	currentID := group.ParentID
-	for currentID != nil {
-		parent, err := p.groups.GetGroup(ctx, *currentID)
-		if err != nil {
-			return "", err
-		}
-		segments = append(segments, parent.Name)
-		currentID = parent.ParentID
-	}
-
-	// Reverse to get top-down order
-	path := ""
-	for i := len(segments) - 1; i >= 0; i-- {
-		path += "/" + segments[i]
-	}
-	return path, nil
-}
-
 // ensureDir creates a directory entry if it doesn't exist.
-func (p *Provisioner) ensureDir(ctx context.Context, path string, ownerID, groupID int) error {
+func (p *Provisioner) ensureDir(ctx context.Context, path string, ownerID, groupID int, rep *provisionLog) error {
+	item := protocol.ProvisionItem{Type: "directory", Path: path}
 	exists, err := p.meta.PathExists(ctx, path)
 	if err != nil {
 		return err
 	}
 	if exists {
+		rep.present(item)
 		return nil
 	}
 
 	// Ensure parent exists first
 	parentPath := parentOf(path)
 	if parentPath != "/" {
-		if err := p.ensureDir(ctx, parentPath, 0, groupID); err != nil {
+		if err := p.ensureDir(ctx, parentPath, 0, groupID, rep); err != nil {
 			return err
 		}
 	}
@@ -169,22 +257,28 @@ func (p *Provisioner) ensureDir(ctx context.Context, path string, ownerID, group
 		row.GroupID = &groupID
 	}
 
-	return p.meta.UpsertFile(ctx, row)
+	if err := p.meta.UpsertFile(ctx, row); err != nil {
+		return err
+	}
+	rep.created(item)
+	return nil
 }
 
 // ensureDirWithVisibility creates a directory entry with specific visibility.
-func (p *Provisioner) ensureDirWithVisibility(ctx context.Context, path string, ownerID, groupID int, visibility string) error {
+func (p *Provisioner) ensureDirWithVisibility(ctx context.Context, path string, ownerID, groupID int, visibility string, rep *provisionLog) error {
+	item := protocol.ProvisionItem{Type: "directory", Path: path}
 	exists, err := p.meta.PathExists(ctx, path)
 	if err != nil {
 		return err
 	}
 	if exists {
+		rep.present(item)
 		return nil
 	}
 
 	parentPath := parentOf(path)
 	if parentPath != "/" {
-		if err := p.ensureDir(ctx, parentPath, 0, groupID); err != nil {
+		if err := p.ensureDir(ctx, parentPath, 0, groupID, rep); err != nil {
 			return err
 		}
 	}
@@ -205,7 +299,11 @@ func (p *Provisioner) ensureDirWithVisibility(ctx context.Context, path string,
 		row.GroupID = &groupID
 	}
 
-	return p.meta.UpsertFile(ctx, row)
+	if err := p.meta.UpsertFile(ctx, row); err != nil {
+		return err
+	}
+	rep.created(item)
+	return nil
 }
 
 func pathID(path string) string {
@@ -5,7 +5,11 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -13,6 +17,14 @@ import (
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
 )
 
+// ErrUploadLimit is returned when an upload would exceed a link's upload
+// caps.
+var ErrUploadLimit = errors.New("share link upload limit reached")
+
+// ErrAlbumLink is returned when an album share link is used as a file or
+// folder link.
+var ErrAlbumLink = errors.New("share link is for an album")
+
 // ShareLink represents a file share link.
 type ShareLink struct {
 	ID            string
@@ -24,105 +36,243 @@ type ShareLink struct {
 	DownloadCount int
 	IsActive      bool
 	CreatedAt     time.Time
+
+	// Upload links (file drops) for folders
+	AllowUpload    bool
+	AllowDownload  bool
+	MaxUploadBytes int64 // 0 = unlimited
+	MaxUploadFiles int   // 0 = unlimited
+	UploadedBytes  int64
+	UploadedFiles  int
+
+	// Album links share a custom gallery album; Path is empty
+	AlbumID *int
+}
+
+// uploadFull reports whether the link's upload caps are used up.
+func (l *ShareLink) uploadFull() bool {
+	return (l.MaxUploadBytes > 0 && l.UploadedBytes >= l.MaxUploadBytes) ||
+		(l.MaxUploadFiles > 0 && l.UploadedFiles >= l.MaxUploadFiles)
+}
+
+// UploadOptions makes a folder share link accept uploads.
+type UploadOptions struct {
+	AllowDownload bool
+	MaxBytes      int64 // 0 = unlimited
+	MaxFiles      int   // 0 = unlimited
+}
+
+// LifetimePolicy bounds how long share links stay valid.
+type LifetimePolicy struct {
+	Max     time.Duration // 0 = unlimited; links may never expire
+	Default time.Duration // for links created without an expiry; 0 = none
+}
+
+// Expiry returns when a link created at now with the requested lifetime
+// expires under p, or nil if it never does. The request is capped at Max;
+// without one, Default applies, or else Max.
+func (p LifetimePolicy) Expiry(now time.Time, requested time.Duration) *time.Time {
+	d := requested
+	if d <= 0 {
+		d = p.Default
+	}
+	if p.Max > 0 && (d <= 0 || d > p.Max) {
+		d = p.Max
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := now.Add(d)
+	return &t
+}
+
+// Exceeds reports whether a link created at createdAt and expiring at
+// expiresAt (nil = never) stays valid longer than p allows.
+func (p LifetimePolicy) Exceeds(createdAt time.Time, expiresAt *time.Time) bool {
+	if p.Max <= 0 {
+		return false
+	}
+	return expiresAt == nil || expiresAt.Sub(createdAt) > p.Max
 }
 
 // ShareLinkStore manages share links.
 type ShareLinkStore struct {
-	db *sql.DB
+	db     *sql.DB
+	policy atomic.Pointer[LifetimePolicy]
 }
 
 // NewShareLinkStore creates a new share link store.
 func NewShareLinkStore(db *sql.DB) *ShareLinkStore {
-	return &ShareLinkStore{db: db}
+	s := &ShareLinkStore{db: db}
+	s.policy.Store(&LifetimePolicy{})
+	return s
+}
+
+// SetLifetimePolicy sets the policy applied to links created from now on.
+func (s *ShareLinkStore) SetLifetimePolicy(p LifetimePolicy) {
+	s.policy.Store(&p)
+}
+
+// LifetimePolicy returns the policy applied to new links.
+func (s *ShareLinkStore) LifetimePolicy() LifetimePolicy {
+	return *s.policy.Load()
+}
+
+// ApplyMaxLifetime shortens the active links that would stay valid longer
+// than max after their creation, links that never expire included, to
+// expire max after it. Links past their expiry are left alone. With
+// dryRun, nothing changes. It returns the number of links (to be)
+// shortened.
+func (s *ShareLinkStore) ApplyMaxLifetime(ctx context.Context, max time.Duration, dryRun bool) (int64, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+	const where = ` WHERE is_active = TRUE
+		   AND (expires_at IS NULL OR (expires_at > NOW() AND expires_at > created_at + make_interval(secs => $1)))`
+	secs := max.Seconds()
+
+	if dryRun {
+		var count int64
+		err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM share_links`+where, secs).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("count share links over max lifetime: %w", err)
+		}
+		return count, nil
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE share_links SET expires_at = created_at + make_interval(secs => $1)`+where, secs)
+	if err != nil {
+		return 0, fmt.Errorf("apply max share link lifetime: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Create creates a new share link. A nil upload creates a download-only
+// link.
+func (s *ShareLinkStore) Create(ctx context.Context, path string, createdBy int, password string, expiresInSec int64, maxDownloads int, upload *UploadOptions) (*ShareLink, error) {
+	link := s.newLink(path, createdBy, expiresInSec, maxDownloads)
+	if upload != nil {
+		link.AllowUpload = true
+		link.AllowDownload = upload.AllowDownload
+		link.MaxUploadBytes = upload.MaxBytes
+		link.MaxUploadFiles = upload.MaxFiles
+	}
+	if err := s.insert(ctx, link, password); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// CreateForAlbum creates a share link for a custom gallery album. The
+// link's MaxDownloads caps how often the album can be opened.
+func (s *ShareLinkStore) CreateForAlbum(ctx context.Context, albumID, createdBy int, password string, expiresInSec int64, maxViews int) (*ShareLink, error) {
+	link := s.newLink("", createdBy, expiresInSec, maxViews)
+	link.AlbumID = &albumID
+	if err := s.insert(ctx, link, password); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// newLink returns a link expiring as the lifetime policy allows.
+func (s *ShareLinkStore) newLink(path string, createdBy int, expiresInSec int64, maxDownloads int) *ShareLink {
+	now := time.Now()
+	var requested time.Duration
+	if expiresInSec > 0 {
+		requested = time.Duration(expiresInSec) * time.Second
+		if requested/time.Second != time.Duration(expiresInSec) {
+			requested = math.MaxInt64 // overflow: as long as allowed
+		}
+	}
+	return &ShareLink{
+		Path:          path,
+		CreatedBy:     createdBy,
+		ExpiresAt:     s.LifetimePolicy().Expiry(now, requested),
+		MaxDownloads:  maxDownloads,
+		IsActive:      true,
+		CreatedAt:     now,
+		AllowDownload: true,
+	}
 }
 
-// Create creates a new share link.
-func (s *ShareLinkStore) Create(ctx context.Context, path string, createdBy int, password string, expiresInSec int64, maxDownloads int) (*ShareLink, error) {
+// insert gives link a token and stores it.
+func (s *ShareLinkStore) insert(ctx context.Context, link *ShareLink, password string) error {
 	id, err := generateToken()
 	if err != nil {
-		return nil, fmt.Errorf("generate token: %w", err)
+		return fmt.Errorf("generate token: %w", err)
 	}
+	link.ID = id
 
 	var passwordHash sql.NullString
 	if password != "" {
 		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
-			return nil, fmt.Errorf("hash password: %w", err)
+			return fmt.Errorf("hash password: %w", err)
 		}
 		passwordHash = sql.NullString{String: string(hashed), Valid: true}
 	}
 
-	var expiresAt *time.Time
-	if expiresInSec > 0 {
-		t := time.Now().Add(time.Duration(expiresInSec) * time.Second)
-		expiresAt = &t
-	}
-
 	_, err = s.db.ExecContext(ctx,
-		`INSERT INTO share_links (id, path, created_by, expires_at, password_hash, max_downloads)
-		 VALUES ($1, $2, $3, $4, $5, $6)`,
-		id, path, createdBy, expiresAt, passwordHash, maxDownloads)
+		`INSERT INTO share_links (id, path, created_by, expires_at, password_hash, max_downloads,
+		                          allow_upload, allow_download, max_upload_bytes, max_upload_files, album_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		link.ID, link.Path, link.CreatedBy, link.ExpiresAt, passwordHash, link.MaxDownloads,
+		link.AllowUpload, link.AllowDownload, link.MaxUploadBytes, link.MaxUploadFiles, link.AlbumID)
 	if err != nil {
-		return nil, fmt.Errorf("insert share link: %w", err)
-	}
-
-	link := &ShareLink{
-		ID:           id,
-		Path:         path,
-		CreatedBy:    createdBy,
-		ExpiresAt:    expiresAt,
-		MaxDownloads: maxDownloads,
-		IsActive:     true,
-		CreatedAt:    time.Now(),
+		return fmt.Errorf("insert share link: %w", err)
 	}
 
 	s.updateActiveCount(ctx)
-	return link, nil
+	return nil
 }
 
 // ShareLinkInfoResult contains metadata about a share link without requiring a password.
 type ShareLinkInfoResult struct {
-	Path          string     `json:"path"`
-	HasPassword   bool       `json:"has_password"`
-	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
-	MaxDownloads  int        `json:"max_downloads"`
-	DownloadCount int        `json:"download_count"`
-	IsActive      bool       `json:"is_active"`
-	Valid         bool       `json:"valid"`
-	Error         string     `json:"error,omitempty"`
+	Path           string     `json:"path"`
+	HasPassword    bool       `json:"has_password"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads   int        `json:"max_downloads"`
+	DownloadCount  int        `json:"download_count"`
+	IsActive       bool       `json:"is_active"`
+	AllowUpload    bool       `json:"allow_upload"`
+	AllowDownload  bool       `json:"allow_download"`
+	MaxUploadBytes int64      `json:"max_upload_bytes,omitempty"`
+	MaxUploadFiles int        `json:"max_upload_files,omitempty"`
+	UploadedBytes  int64      `json:"uploaded_bytes,omitempty"`
+	UploadedFiles  int        `json:"uploaded_files,omitempty"`
+	AlbumID        *int       `json:"album_id,omitempty"`
+	Valid          bool       `json:"valid"`
+	Error          string     `json:"error,omitempty"`
 }
 
 // GetInfo returns metadata about a share link without checking the password.
+// An upload link stays valid while either downloads or uploads are left.
 func (s *ShareLinkStore) GetInfo(ctx context.Context, id string) (*ShareLinkInfoResult, error) {
-	var link ShareLink
-	var expiresAt sql.NullTime
-	var passwordHash sql.NullString
-
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, path, created_by, expires_at, password_hash, max_downloads, download_count, is_active, created_at
-		 FROM share_links WHERE id = $1`, id).
-		Scan(&link.ID, &link.Path, &link.CreatedBy, &expiresAt, &passwordHash,
-			&link.MaxDownloads, &link.DownloadCount, &link.IsActive, &link.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("share link not found")
-	}
+	link, err := s.get(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("query share link: %w", err)
-	}
-
-	if expiresAt.Valid {
-		link.ExpiresAt = &expiresAt.Time
+		return nil, err
 	}
 
 	result := &ShareLinkInfoResult{
-		Path:          link.Path,
-		HasPassword:   passwordHash.Valid && passwordHash.String != "",
-		ExpiresAt:     link.ExpiresAt,
-		MaxDownloads:  link.MaxDownloads,
-		DownloadCount: link.DownloadCount,
-		IsActive:      link.IsActive,
-		Valid:         true,
-	}
+		Path:           link.Path,
+		HasPassword:    link.PasswordHash != "",
+		ExpiresAt:      link.ExpiresAt,
+		MaxDownloads:   link.MaxDownloads,
+		DownloadCount:  link.DownloadCount,
+		IsActive:       link.IsActive,
+		AllowUpload:    link.AllowUpload,
+		AllowDownload:  link.AllowDownload,
+		MaxUploadBytes: link.MaxUploadBytes,
+		MaxUploadFiles: link.MaxUploadFiles,
+		UploadedBytes:  link.UploadedBytes,
+		UploadedFiles:  link.UploadedFiles,
+		AlbumID:        link.AlbumID,
+		Valid:          true,
+	}
+
+	downloadsLeft := link.AllowDownload && (link.MaxDownloads == 0 || link.DownloadCount < link.MaxDownloads)
+	uploadsLeft := link.AllowUpload && !link.uploadFull()
 
 	if !link.IsActive {
 		result.Valid = false
@@ -130,63 +280,144 @@ func (s *ShareLinkStore) GetInfo(ctx context.Context, id string) (*ShareLinkInfo
 	} else if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
 		result.Valid = false
 		result.Error = "share link has expired"
-	} else if link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
+	} else if !downloadsLeft && !uploadsLeft {
 		result.Valid = false
 		result.Error = "share link download limit reached"
+		if link.AlbumID != nil {
+			result.Error = "share link view limit reached"
+		}
+		if link.AllowUpload {
+			result.Error = ErrUploadLimit.Error()
+		}
 	}
 
 	return result, nil
 }
 
-// Validate checks if a share link is valid and returns it.
-// Checks: exists, active, not expired, download limit not reached.
+// Validate checks if a share link is valid for downloading and returns it.
+// Checks: exists, active, not expired, downloads allowed, download limit
+// not reached.
 func (s *ShareLinkStore) Validate(ctx context.Context, id string, password string) (*ShareLink, error) {
-	var link ShareLink
-	var expiresAt sql.NullTime
-	var passwordHash sql.NullString
+	link, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if link.AlbumID != nil {
+		return nil, ErrAlbumLink
+	}
+	if err := link.checkUsable(password); err != nil {
+		return nil, err
+	}
 
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, path, created_by, expires_at, password_hash, max_downloads, download_count, is_active, created_at
-		 FROM share_links WHERE id = $1`, id).
-		Scan(&link.ID, &link.Path, &link.CreatedBy, &expiresAt, &passwordHash,
-			&link.MaxDownloads, &link.DownloadCount, &link.IsActive, &link.CreatedAt)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("share link not found")
+	if !link.AllowDownload {
+		return nil, fmt.Errorf("share link does not allow downloads")
 	}
+
+	if link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
+		return nil, fmt.Errorf("share link download limit reached")
+	}
+
+	return link, nil
+}
+
+// ValidateAlbum checks if a share link for an album is usable and returns
+// it. A view (opening the album) also needs views left; loading the
+// members of an album already opened does not.
+func (s *ShareLinkStore) ValidateAlbum(ctx context.Context, id string, password string, view bool) (*ShareLink, error) {
+	link, err := s.get(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("query share link: %w", err)
+		return nil, err
+	}
+	if link.AlbumID == nil {
+		return nil, fmt.Errorf("share link is not for an album")
+	}
+	if err := link.checkUsable(password); err != nil {
+		return nil, err
 	}
 
-	if expiresAt.Valid {
-		link.ExpiresAt = &expiresAt.Time
+	if view && link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
+		return nil, fmt.Errorf("share link view limit reached")
 	}
-	if passwordHash.Valid {
-		link.PasswordHash = passwordHash.String
+
+	return link, nil
+}
+
+// ValidateUpload checks if a share link accepts uploads and returns it.
+// The caps are only checked here; ReserveUpload enforces them.
+func (s *ShareLinkStore) ValidateUpload(ctx context.Context, id string, password string) (*ShareLink, error) {
+	link, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if link.AlbumID != nil {
+		return nil, ErrAlbumLink
+	}
+	if err := link.checkUsable(password); err != nil {
+		return nil, err
 	}
 
-	if !link.IsActive {
-		return nil, fmt.Errorf("share link has been revoked")
+	if !link.AllowUpload {
+		return nil, fmt.Errorf("share link does not allow uploads")
 	}
 
-	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
-		return nil, fmt.Errorf("share link has expired")
+	if link.uploadFull() {
+		return nil, ErrUploadLimit
 	}
 
-	if link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
-		return nil, fmt.Errorf("share link download limit reached")
+	return link, nil
+}
+
+// checkUsable checks that the link is active, not expired, and that the
+// password matches if it has one.
+func (l *ShareLink) checkUsable(password string) error {
+	if !l.IsActive {
+		return fmt.Errorf("share link has been revoked")
+	}
+
+	if l.ExpiresAt != nil && time.Now().After(*l.ExpiresAt) {
+		return fmt.Errorf("share link has expired")
 	}
 
 	// Check password if required
-	if link.PasswordHash != "" {
+	if l.PasswordHash != "" {
 		if password == "" {
-			return nil, fmt.Errorf("password required")
+			return fmt.Errorf("password required")
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
-			return nil, fmt.Errorf("invalid password")
+		if err := bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(password)); err != nil {
+			return fmt.Errorf("invalid password")
 		}
 	}
+	return nil
+}
 
-	return &link, nil
+// ReserveUpload counts an upload of size bytes against the link's caps,
+// failing with ErrUploadLimit if it does not fit. Concurrent uploads cannot
+// overshoot the caps. Call ReleaseUpload if the upload then fails.
+func (s *ShareLinkStore) ReserveUpload(ctx context.Context, id string, size int64) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE share_links SET uploaded_bytes = uploaded_bytes + $2, uploaded_files = uploaded_files + 1
+		 WHERE id = $1 AND is_active = TRUE AND allow_upload = TRUE
+		   AND (max_upload_bytes = 0 OR uploaded_bytes + $2 <= max_upload_bytes)
+		   AND (max_upload_files = 0 OR uploaded_files < max_upload_files)`, id, size)
+	if err != nil {
+		return fmt.Errorf("reserve upload: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUploadLimit
+	}
+	return nil
+}
+
+// ReleaseUpload gives back a reservation made by ReserveUpload.
+func (s *ShareLinkStore) ReleaseUpload(ctx context.Context, id string, size int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE share_links SET uploaded_bytes = GREATEST(uploaded_bytes - $2, 0),
+		                        uploaded_files = GREATEST(uploaded_files - 1, 0)
+		 WHERE id = $1`, id, size)
+	if err != nil {
+		return fmt.Errorf("release upload: %w", err)
+	}
+	return nil
 }
 
 // IncrementDownloads increments the download count for a share link.
@@ -213,25 +444,41 @@ func (s *ShareLinkStore) Revoke(ctx context.Context, id string) error {
 
 // GetByID returns a share link by ID (without validation).
 func (s *ShareLinkStore) GetByID(ctx context.Context, id string) (*ShareLink, error) {
+	return s.get(ctx, id)
+}
+
+func (s *ShareLinkStore) get(ctx context.Context, id string) (*ShareLink, error) {
 	var link ShareLink
 	var expiresAt sql.NullTime
 	var passwordHash sql.NullString
+	var albumID sql.NullInt64
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, path, created_by, expires_at, password_hash, max_downloads, download_count, is_active, created_at
+		`SELECT id, path, created_by, expires_at, password_hash, max_downloads, download_count, is_active, created_at,
+		        allow_upload, allow_download, max_upload_bytes, max_upload_files, uploaded_bytes, uploaded_files,
+		        album_id
 		 FROM share_links WHERE id = $1`, id).
 		Scan(&link.ID, &link.Path, &link.CreatedBy, &expiresAt, &passwordHash,
-			&link.MaxDownloads, &link.DownloadCount, &link.IsActive, &link.CreatedAt)
+			&link.MaxDownloads, &link.DownloadCount, &link.IsActive, &link.CreatedAt,
+			&link.AllowUpload, &link.AllowDownload, &link.MaxUploadBytes, &link.MaxUploadFiles,
+			&link.UploadedBytes, &link.UploadedFiles, &albumID)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("share link not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
+		return nil, fmt.Errorf("query share link: %w", err)
 	}
 
 	if expiresAt.Valid {
 		link.ExpiresAt = &expiresAt.Time
 	}
+	if passwordHash.Valid {
+		link.PasswordHash = passwordHash.String
+	}
+	if albumID.Valid {
+		id := int(albumID.Int64)
+		link.AlbumID = &id
+	}
 	return &link, nil
 }
 
@@ -246,14 +493,22 @@ type ShareLinkWithUser struct {
 	DownloadCount   int        `json:"download_count"`
 	IsActive        bool       `json:"is_active"`
 	CreatedAt       time.Time  `json:"created_at"`
+	AllowUpload     bool       `json:"allow_upload"`
+	AlbumID         *int       `json:"album_id,omitempty"`
+	AlbumName       string     `json:"album_name,omitempty"`
+
+	// Set in admin listings for links outliving the max lifetime policy
+	ExceedsPolicy bool `json:"exceeds_policy,omitempty"`
 }
 
 // ListAll returns all share links with creator usernames, optionally filtered to active only.
 func (s *ShareLinkStore) ListAll(ctx context.Context, activeOnly bool) ([]ShareLinkWithUser, error) {
 	query := `SELECT sl.id, sl.path, sl.created_by, u.username, sl.expires_at,
-	           sl.max_downloads, sl.download_count, sl.is_active, sl.created_at
+	           sl.max_downloads, sl.download_count, sl.is_active, sl.created_at, sl.allow_upload,
+	           sl.album_id, COALESCE(a.name, '')
 	          FROM share_links sl
-	          JOIN users u ON u.id = sl.created_by`
+	          JOIN users u ON u.id = sl.created_by
+	          LEFT JOIN user_albums a ON a.id = sl.album_id`
 	if activeOnly {
 		query += ` WHERE sl.is_active = TRUE`
 	}
@@ -263,79 +518,85 @@ func (s *ShareLinkStore) ListAll(ctx context.Context, activeOnly bool) ([]ShareL
 	if err != nil {
 		return nil, fmt.Errorf("list share links: %w", err)
 	}
-	defer rows.Close()
-
-	var links []ShareLinkWithUser
-	for rows.Next() {
-		var l ShareLinkWithUser
-		var expiresAt sql.NullTime
-		if err := rows.Scan(&l.ID, &l.Path, &l.CreatedBy, &l.CreatedByUser,
-			&expiresAt, &l.MaxDownloads, &l.DownloadCount, &l.IsActive, &l.CreatedAt); err != nil {
-			return nil, fmt.Errorf("scan share link: %w", err)
-		}
-		if expiresAt.Valid {
-			l.ExpiresAt = &expiresAt.Time
-		}
-		links = append(links, l)
-	}
-	return links, rows.Err()
+	return scanLinksWithUser(rows)
 }
 
 // ListByUser returns active share links created by a specific user.
 func (s *ShareLinkStore) ListByUser(ctx context.Context, userID int) ([]ShareLinkWithUser, error) {
 	rows, err := s.db.QueryContext(ctx,
 		`SELECT sl.id, sl.path, sl.created_by, u.username, sl.expires_at,
-		        sl.max_downloads, sl.download_count, sl.is_active, sl.created_at
+		        sl.max_downloads, sl.download_count, sl.is_active, sl.created_at, sl.allow_upload,
+		        sl.album_id, COALESCE(a.name, '')
 		 FROM share_links sl
 		 JOIN users u ON u.id = sl.created_by
+		 LEFT JOIN user_albums a ON a.id = sl.album_id
 		 WHERE sl.created_by = $1 AND sl.is_active = TRUE
 		 ORDER BY sl.created_at DESC`, userID)
 	if err != nil {
 		return nil, fmt.Errorf("list share links by user: %w", err)
 	}
-	defer rows.Close()
-
-	var links []ShareLinkWithUser
-	for rows.Next() {
-		var l ShareLinkWithUser
-		var expiresAt sql.NullTime
-		if err := rows.Scan(&l.ID, &l.Path, &l.CreatedBy, &l.CreatedByUser,
-			&expiresAt, &l.MaxDownloads, &l.DownloadCount, &l.IsActive, &l.CreatedAt); err != nil {
-			return nil, fmt.Errorf("scan share link: %w", err)
-		}
-		if expiresAt.Valid {
-			l.ExpiresAt = &expiresAt.Time
-		}
-		links = append(links, l)
-	}
-	return links, rows.Err()
+	return scanLinksWithUser(rows)
 }
 
 // ListByPath returns active share links for a specific file path.
 func (s *ShareLinkStore) ListByPath(ctx context.Context, path string) ([]ShareLinkWithUser, error) {
 	rows, err := s.db.QueryContext(ctx,
 		`SELECT sl.id, sl.path, sl.created_by, u.username, sl.expires_at,
-		        sl.max_downloads, sl.download_count, sl.is_active, sl.created_at
+		        sl.max_downloads, sl.download_count, sl.is_active, sl.created_at, sl.allow_upload,
+		        sl.album_id, COALESCE(a.name, '')
 		 FROM share_links sl
 		 JOIN users u ON u.id = sl.created_by
+		 LEFT JOIN user_albums a ON a.id = sl.album_id
 		 WHERE sl.path = $1 AND sl.is_active = TRUE
 		 ORDER BY sl.created_at DESC`, path)
 	if err != nil {
 		return nil, fmt.Errorf("list share links by path: %w", err)
 	}
+	return scanLinksWithUser(rows)
+}
+
+// ListUnderPath returns share links for root and everything below it,
+// optionally filtered to active only.
+func (s *ShareLinkStore) ListUnderPath(ctx context.Context, root string, activeOnly bool) ([]ShareLinkWithUser, error) {
+	query := `SELECT sl.id, sl.path, sl.created_by, u.username, sl.expires_at,
+	                 sl.max_downloads, sl.download_count, sl.is_active, sl.created_at, sl.allow_upload,
+	           sl.album_id, COALESCE(a.name, '')
+	          FROM share_links sl
+	          JOIN users u ON u.id = sl.created_by
+	          LEFT JOIN user_albums a ON a.id = sl.album_id
+	          WHERE (sl.path = $1 OR starts_with(sl.path, $2))`
+	if activeOnly {
+		query += ` AND sl.is_active = TRUE`
+	}
+	query += ` ORDER BY sl.created_at DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, root, strings.TrimSuffix(root, "/")+"/")
+	if err != nil {
+		return nil, fmt.Errorf("list share links under path: %w", err)
+	}
+	return scanLinksWithUser(rows)
+}
+
+func scanLinksWithUser(rows *sql.Rows) ([]ShareLinkWithUser, error) {
 	defer rows.Close()
 
 	var links []ShareLinkWithUser
 	for rows.Next() {
 		var l ShareLinkWithUser
 		var expiresAt sql.NullTime
+		var albumID sql.NullInt64
 		if err := rows.Scan(&l.ID, &l.Path, &l.CreatedBy, &l.CreatedByUser,
-			&expiresAt, &l.MaxDownloads, &l.DownloadCount, &l.IsActive, &l.CreatedAt); err != nil {
+			&expiresAt, &l.MaxDownloads, &l.DownloadCount, &l.IsActive, &l.CreatedAt, &l.AllowUpload,
+			&albumID, &l.AlbumName); err != nil {
 			return nil, fmt.Errorf("scan share link: %w", err)
 		}
 		if expiresAt.Valid {
 			l.ExpiresAt = &expiresAt.Time
 		}
+		if albumID.Valid {
+			id := int(albumID.Int64)
+			l.AlbumID = &id
+		}
 		links = append(links, l)
 	}
 	return links, rows.Err()
@@ -2,6 +2,7 @@ package sharing
 
 import (
 	"testing"
+	"time"
 )
 
 func TestPathSegments(t *testing.T) {
@@ -53,3 +54,45 @@ func TestPermissionSatisfies(t *testing.T) {
 		}
 	}
 }
+
+func TestLifetimePolicyExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		policy    LifetimePolicy
+		requested time.Duration
+		want      time.Duration // 0 = never expires
+	}{
+		{LifetimePolicy{}, 0, 0},
+		{LifetimePolicy{}, time.Hour, time.Hour},
+		{LifetimePolicy{Default: 2 * time.Hour}, 0, 2 * time.Hour},
+		{LifetimePolicy{Default: 2 * time.Hour}, time.Hour, time.Hour},
+		{LifetimePolicy{Max: 24 * time.Hour}, 0, 24 * time.Hour},
+		{LifetimePolicy{Max: 24 * time.Hour}, 48 * time.Hour, 24 * time.Hour},
+		{LifetimePolicy{Max: 24 * time.Hour, Default: time.Hour}, 0, time.Hour},
+		{LifetimePolicy{Max: 24 * time.Hour, Default: time.Hour}, 3 * time.Hour, 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		got := tt.policy.Expiry(now, tt.requested)
+		switch {
+		case tt.want == 0 && got != nil:
+			t.Errorf("%+v, %v: expires %v, want never", tt.policy, tt.requested, got)
+		case tt.want != 0 && (got == nil || !got.Equal(now.Add(tt.want))):
+			t.Errorf("%+v, %v: expires %v, want %v", tt.policy, tt.requested, got, now.Add(tt.want))
+		}
+	}
+}
+
+func TestLifetimePolicyExceeds(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	week := created.Add(7 * 24 * time.Hour)
+	day := LifetimePolicy{Max: 24 * time.Hour}
+	if (LifetimePolicy{}).Exceeds(created, nil) {
+		t.Error("a link without expiry exceeds an unset policy")
+	}
+	if !day.Exceeds(created, nil) || !day.Exceeds(created, &week) {
+		t.Error("links outliving the max do not exceed it")
+	}
+	if hour := created.Add(time.Hour); day.Exceeds(created, &hour) {
+		t.Error("a link within the max exceeds it")
+	}
+}
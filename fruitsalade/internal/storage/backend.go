@@ -5,6 +5,7 @@ package storage
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // Backend is the interface for content storage backends.
@@ -27,9 +28,34 @@ type Backend interface {
 	// ObjectExists checks if an object exists at the given key.
 	ObjectExists(ctx context.Context, key string) (bool, error)
 
+	// StatObject returns the size and modification time of an object
+	// without reading it. A missing object yields an error wrapping
+	// fs.ErrNotExist.
+	StatObject(ctx context.Context, key string) (size int64, modTime time.Time, err error)
+
+	// ListObjects calls fn with the key, size and modification time of
+	// every object whose key starts with prefix. An error from fn stops the
+	// listing and is returned.
+	ListObjects(ctx context.Context, prefix string, fn func(key string, size int64, modTime time.Time) error) error
+
 	// Type returns the backend type identifier ("s3", "local", "smb").
 	Type() string
 
 	// Close releases any resources held by the backend.
 	Close() error
 }
+
+// PresignedURLProvider is an optional capability of backends that can hand
+// out short-lived URLs, letting clients download directly from the object
+// store instead of through the API server. Callers type-assert a Backend
+// to it; backends without the capability are always proxied.
+type PresignedURLProvider interface {
+	// PresignEnabled reports whether the storage location has presigned
+	// downloads turned on.
+	PresignEnabled() bool
+
+	// PresignGetObject returns a URL that allows a GET of key until it
+	// expires. Non-empty contentType and contentDisposition override the
+	// response headers. Range requests against the URL are honored.
+	PresignGetObject(ctx context.Context, key, contentType, contentDisposition string) (string, error)
+}
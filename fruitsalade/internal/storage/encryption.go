@@ -0,0 +1,598 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ─── Encryption at Rest ─────────────────────────────────────────────────────
+//
+// A location whose config sets "encryption_key_id" stores every object
+// encrypted. Each object gets a random data key, wrapped with the named
+// master key from the Keyring, and the plaintext is sealed with AES-256-GCM
+// in 64 KB frames so ranged reads only fetch and open the frames they need.
+//
+// Object layout:
+//
+//	magic "FSE1"           4 bytes
+//	key ID length          1 byte
+//	key ID                 32 bytes, zero-padded
+//	wrapped data key       60 bytes: nonce, sealed key, tag
+//	nonce prefix           8 bytes
+//	frames                 up to 64 KB of ciphertext + 16-byte tag each
+//
+// Frame i uses the nonce prefix followed by i as a big-endian uint32, and
+// the last frame is authenticated as such, so frames cannot be reordered,
+// dropped or truncated unnoticed. An empty object has one empty frame.
+//
+// Objects without the magic (written before encryption was turned on) are
+// read as plaintext.
+
+const (
+	encMagic          = "FSE1"
+	encMaxKeyID       = 32
+	encKeySize        = 32
+	encWrappedKeySize = 12 + encKeySize + 16
+	encNoncePrefix    = 8
+	encHeaderSize     = 4 + 1 + encMaxKeyID + encWrappedKeySize + encNoncePrefix
+	encFrameSize      = 64 * 1024
+	encTagSize        = 16
+	encSealedFrame    = encFrameSize + encTagSize
+)
+
+// Keyring holds the master keys that wrap per-object data keys, by ID.
+// Keys stay needed after a rotation until every object has been re-wrapped.
+type Keyring struct {
+	keys map[string][]byte
+}
+
+// ParseKeyring parses "id:base64key" entries separated by commas or
+// newlines. Keys must be 32 bytes; lines starting with # are ignored.
+func ParseKeyring(spec string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption key entry %q: expected id:base64key", entry)
+		}
+		if len(id) > encMaxKeyID {
+			return nil, fmt.Errorf("encryption key id %q is longer than %d bytes", id, encMaxKeyID)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		if len(key) != encKeySize {
+			return nil, fmt.Errorf("encryption key %q is %d bytes, want %d", id, len(key), encKeySize)
+		}
+		if _, dup := kr.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate encryption key id %q", id)
+		}
+		kr.keys[id] = key
+	}
+	return kr, nil
+}
+
+// LoadKeyring combines the keys given inline with those in file (either
+// may be empty).
+func LoadKeyring(spec, file string) (*Keyring, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption keys: %w", err)
+		}
+		if spec != "" {
+			spec += "\n"
+		}
+		spec += string(data)
+	}
+	return ParseKeyring(spec)
+}
+
+// Has reports whether the keyring holds a key with the given ID.
+func (k *Keyring) Has(id string) bool {
+	if k == nil {
+		return false
+	}
+	_, ok := k.keys[id]
+	return ok
+}
+
+// IDs returns the key IDs in sorted order.
+func (k *Keyring) IDs() []string {
+	if k == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k *Keyring) aead(id string) (cipher.AEAD, error) {
+	if !k.Has(id) {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return newGCM(k.keys[id])
+}
+
+// EncryptionKeyID returns the "encryption_key_id" of a location config, ""
+// when the location is not encrypted.
+func EncryptionKeyID(config json.RawMessage) string {
+	var cfg struct {
+		EncryptionKeyID string `json:"encryption_key_id"`
+	}
+	if len(config) == 0 || json.Unmarshal(config, &cfg) != nil {
+		return ""
+	}
+	return cfg.EncryptionKeyID
+}
+
+// EncryptedBackend encrypts objects on the way into a Backend and decrypts
+// them on the way out. Deletes, copies and existence checks go straight to
+// the wrapped backend; copies share the source's data key.
+type EncryptedBackend struct {
+	Backend
+	keys  *Keyring
+	keyID string
+}
+
+// NewEncryptedBackend wraps inner so that new objects are encrypted under
+// the master key keyID. Objects under other keys in keys stay readable.
+func NewEncryptedBackend(inner Backend, keys *Keyring, keyID string) (*EncryptedBackend, error) {
+	if !keys.Has(keyID) {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	return &EncryptedBackend{Backend: inner, keys: keys, keyID: keyID}, nil
+}
+
+// KeyID returns the master key new objects are encrypted under.
+func (b *EncryptedBackend) KeyID() string { return b.keyID }
+
+// PutObject encrypts body and stores it. size is the plaintext size.
+func (b *EncryptedBackend) PutObject(ctx context.Context, key string, body io.Reader, size int64) error {
+	header, aead, err := b.newHeader()
+	if err != nil {
+		return fmt.Errorf("encrypt %s: %w", key, err)
+	}
+	sealer := &frameSealer{
+		src:    bufio.NewReaderSize(body, encFrameSize),
+		aead:   aead,
+		prefix: header.noncePrefix,
+		frame:  make([]byte, encFrameSize),
+		sealed: make([]byte, 0, encSealedFrame),
+	}
+	return b.Backend.PutObject(ctx, key, io.MultiReader(bytes.NewReader(header.bytes), sealer), sealedSize(size))
+}
+
+// GetObject returns the plaintext of an object, or the requested range of
+// it, and its size.
+func (b *EncryptedBackend) GetObject(ctx context.Context, key string, offset, length int64) (io.ReadCloser, int64, error) {
+	if offset == 0 && length == 0 {
+		return b.getAll(ctx, key)
+	}
+
+	total, _, err := b.Backend.StatObject(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	header, err := b.readHeader(ctx, key, total)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header == nil {
+		return b.Backend.GetObject(ctx, key, offset, length)
+	}
+
+	plainSize := plaintextSize(total)
+	if offset < 0 || offset > plainSize {
+		return nil, 0, fmt.Errorf("range of %s: offset %d outside object of %d bytes", key, offset, plainSize)
+	}
+	end := plainSize
+	if length > 0 && offset+length < end {
+		end = offset + length
+	}
+	if end == offset {
+		return io.NopCloser(bytes.NewReader(nil)), 0, nil
+	}
+
+	first := offset / encFrameSize
+	last := (end - 1) / encFrameSize
+	start := encHeaderSize + first*encSealedFrame
+	span := (last - first + 1) * encSealedFrame
+	if start+span > total {
+		span = total - start
+	}
+	rc, _, err := b.Backend.GetObject(ctx, key, start, span)
+	if err != nil {
+		return nil, 0, err
+	}
+	opener, err := b.opener(header, rc, total, uint32(first))
+	if err != nil {
+		rc.Close()
+		return nil, 0, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	if _, err := io.CopyN(io.Discard, opener, offset-first*encFrameSize); err != nil {
+		rc.Close()
+		return nil, 0, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return &encReadCloser{Reader: io.LimitReader(opener, end-offset), Closer: rc}, end - offset, nil
+}
+
+// getAll streams a whole object, reading the header from the same request.
+func (b *EncryptedBackend) getAll(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	rc, total, err := b.Backend.GetObject(ctx, key, 0, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	head := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		rc.Close()
+		return nil, 0, fmt.Errorf("read %s: %w", key, err)
+	}
+	header, err := parseHeader(head[:n])
+	if err != nil {
+		rc.Close()
+		return nil, 0, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	if header == nil {
+		return &encReadCloser{Reader: io.MultiReader(bytes.NewReader(head[:n]), rc), Closer: rc}, total, nil
+	}
+	opener, err := b.opener(header, rc, total, 0)
+	if err != nil {
+		rc.Close()
+		return nil, 0, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return &encReadCloser{Reader: opener, Closer: rc}, plaintextSize(total), nil
+}
+
+// StatObject returns the plaintext size of an object.
+func (b *EncryptedBackend) StatObject(ctx context.Context, key string) (int64, time.Time, error) {
+	total, modTime, err := b.Backend.StatObject(ctx, key)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	header, err := b.readHeader(ctx, key, total)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if header == nil {
+		return total, modTime, nil
+	}
+	return plaintextSize(total), modTime, nil
+}
+
+// ListObjects lists the inner backend's objects with their plaintext
+// sizes, which takes a header read for each object that may be encrypted.
+func (b *EncryptedBackend) ListObjects(ctx context.Context, prefix string, fn func(key string, size int64, modTime time.Time) error) error {
+	return b.Backend.ListObjects(ctx, prefix, func(key string, total int64, modTime time.Time) error {
+		header, err := b.readHeader(ctx, key, total)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			return fn(key, total, modTime)
+		}
+		return fn(key, plaintextSize(total), modTime)
+	})
+}
+
+// Rewrap re-wraps the data key of an object under the backend's current
+// master key, leaving the encrypted frames as they are. It reports whether
+// the object was rewritten; plaintext objects and objects already under the
+// current key are left alone.
+func (b *EncryptedBackend) Rewrap(ctx context.Context, key string) (bool, error) {
+	rc, total, err := b.Backend.GetObject(ctx, key, 0, 0)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	head := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, fmt.Errorf("read %s: %w", key, err)
+	}
+	header, err := parseHeader(head[:n])
+	if err != nil || header == nil || header.keyID == b.keyID {
+		return false, err
+	}
+
+	dataKey, err := b.unwrapKey(header)
+	if err != nil {
+		return false, fmt.Errorf("rewrap %s: %w", key, err)
+	}
+	wrapped, err := b.wrapKey(dataKey)
+	if err != nil {
+		return false, fmt.Errorf("rewrap %s: %w", key, err)
+	}
+	rewrapped := encodeHeader(b.keyID, wrapped, header.noncePrefix)
+
+	// The wrapped backend writes atomically, so readers see the old or the
+	// new header, and both open the same frames.
+	if err := b.Backend.PutObject(ctx, key, io.MultiReader(bytes.NewReader(rewrapped), rc), total); err != nil {
+		return false, fmt.Errorf("rewrap %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// encHeader is the parsed header of an encrypted object.
+type encHeader struct {
+	keyID       string
+	wrappedKey  []byte
+	noncePrefix []byte
+	bytes       []byte
+}
+
+// newHeader creates a header with a fresh data key and returns it with the
+// cipher for the frames.
+func (b *EncryptedBackend) newHeader() (*encHeader, cipher.AEAD, error) {
+	dataKey := make([]byte, encKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := b.wrapKey(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := make([]byte, encNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &encHeader{
+		keyID:       b.keyID,
+		wrappedKey:  wrapped,
+		noncePrefix: prefix,
+		bytes:       encodeHeader(b.keyID, wrapped, prefix),
+	}, aead, nil
+}
+
+// readHeader reads the header of an object of total bytes. It returns nil
+// for plaintext objects.
+func (b *EncryptedBackend) readHeader(ctx context.Context, key string, total int64) (*encHeader, error) {
+	if total < encHeaderSize+encTagSize {
+		return nil, nil
+	}
+	rc, _, err := b.Backend.GetObject(ctx, key, 0, encHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	head := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(rc, head); err != nil {
+		return nil, fmt.Errorf("read header of %s: %w", key, err)
+	}
+	header, err := parseHeader(head)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return header, nil
+}
+
+// opener returns a reader of the plaintext of the frames in src, the first
+// of which is frame number first of an object of total bytes.
+func (b *EncryptedBackend) opener(header *encHeader, src io.Reader, total int64, first uint32) (io.Reader, error) {
+	dataKey, err := b.unwrapKey(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	frames := (total - encHeaderSize + encSealedFrame - 1) / encSealedFrame
+	return &frameOpener{
+		src:    src,
+		aead:   aead,
+		prefix: header.noncePrefix,
+		index:  first,
+		last:   uint32(frames - 1),
+		frame:  make([]byte, encSealedFrame),
+	}, nil
+}
+
+func (b *EncryptedBackend) wrapKey(dataKey []byte) ([]byte, error) {
+	aead, err := b.keys.aead(b.keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(b.keyID)), nil
+}
+
+func (b *EncryptedBackend) unwrapKey(header *encHeader) ([]byte, error) {
+	aead, err := b.keys.aead(header.keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed := header.wrappedKey[:aead.NonceSize()], header.wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(header.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key with %q: %w", header.keyID, err)
+	}
+	return dataKey, nil
+}
+
+func encodeHeader(keyID string, wrappedKey, noncePrefix []byte) []byte {
+	h := make([]byte, 0, encHeaderSize)
+	h = append(h, encMagic...)
+	h = append(h, byte(len(keyID)))
+	h = append(h, keyID...)
+	h = append(h, make([]byte, encMaxKeyID-len(keyID))...)
+	h = append(h, wrappedKey...)
+	h = append(h, noncePrefix...)
+	return h
+}
+
+// parseHeader parses the start of an object. It returns nil, nil when the
+// object is not encrypted.
+func parseHeader(head []byte) (*encHeader, error) {
+	if len(head) < encHeaderSize || string(head[:len(encMagic)]) != encMagic {
+		return nil, nil
+	}
+	p := head[len(encMagic):]
+	idLen := int(p[0])
+	if idLen == 0 || idLen > encMaxKeyID {
+		return nil, errors.New("corrupt encryption header")
+	}
+	p = p[1:]
+	h := &encHeader{keyID: string(p[:idLen]), bytes: head[:encHeaderSize]}
+	p = p[encMaxKeyID:]
+	h.wrappedKey = p[:encWrappedKeySize]
+	h.noncePrefix = p[encWrappedKeySize : encWrappedKeySize+encNoncePrefix]
+	return h, nil
+}
+
+// sealedSize returns the stored size of a plaintext of size bytes.
+func sealedSize(size int64) int64 {
+	frames := (size + encFrameSize - 1) / encFrameSize
+	if frames == 0 {
+		frames = 1
+	}
+	return encHeaderSize + size + frames*encTagSize
+}
+
+// plaintextSize returns the plaintext size of a stored object of total bytes.
+func plaintextSize(total int64) int64 {
+	body := total - encHeaderSize
+	frames := (body + encSealedFrame - 1) / encSealedFrame
+	return body - frames*encTagSize
+}
+
+func frameNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, encNoncePrefix+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encNoncePrefix:], index)
+	return nonce
+}
+
+// frameAAD marks the last frame of an object.
+func frameAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encReadCloser reads from a decrypting Reader and closes the underlying
+// object.
+type encReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// frameSealer reads plaintext from src and returns sealed frames.
+type frameSealer struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	frame  []byte
+	sealed []byte
+	out    []byte
+	done   bool
+}
+
+func (s *frameSealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(s.src, s.frame)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		last := n < encFrameSize
+		if !last {
+			// A full frame is the last one if nothing follows it
+			if _, err := s.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		s.out = s.aead.Seal(s.sealed[:0], frameNonce(s.prefix, s.index), s.frame[:n], frameAAD(last))
+		s.index++
+		s.done = last
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// frameOpener reads sealed frames from src and returns their plaintext.
+type frameOpener struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	last   uint32
+	frame  []byte
+	out    []byte
+	done   bool
+}
+
+func (o *frameOpener) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(o.src, o.frame)
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := o.index == o.last
+		if n < encSealedFrame && !last {
+			return 0, io.ErrUnexpectedEOF
+		}
+		plain, err := o.aead.Open(o.frame[:0], frameNonce(o.prefix, o.index), o.frame[:n], frameAAD(last))
+		if err != nil {
+			return 0, fmt.Errorf("frame %d: %w", o.index, err)
+		}
+		o.out = plain
+		o.index++
+		o.done = last
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
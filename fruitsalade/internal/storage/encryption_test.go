@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
+)
+
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	var entries []string
+	for _, id := range ids {
+		key := make([]byte, 32)
+		rand.Read(key)
+		entries = append(entries, id+":"+base64.StdEncoding.EncodeToString(key))
+	}
+	kr, err := ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	return kr
+}
+
+func newEncryptedTestBackend(t *testing.T, kr *Keyring, keyID string) (*EncryptedBackend, string) {
+	t.Helper()
+	dir := t.TempDir()
+	inner, err := local.New(local.Config{RootPath: dir, CreateDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewEncryptedBackend(inner, kr, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, dir
+}
+
+func readObject(t *testing.T, b Backend, key string, offset, length int64) ([]byte, int64) {
+	t.Helper()
+	rc, size, err := b.GetObject(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("GetObject(%s, %d, %d): %v", key, offset, length, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %s (%d, %d): %v", key, offset, length, err)
+	}
+	return data, size
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	b, dir := newEncryptedTestBackend(t, testKeyring(t, "k1"), "k1")
+	ctx := context.Background()
+
+	for _, n := range []int{0, 1, encFrameSize - 1, encFrameSize, encFrameSize + 1, 3*encFrameSize + 7} {
+		key := fmt.Sprintf("obj-%d", n)
+		data := randomBytes(n)
+		if err := b.PutObject(ctx, key, bytes.NewReader(data), int64(n)); err != nil {
+			t.Fatalf("PutObject(%d): %v", n, err)
+		}
+
+		stored, _ := os.ReadFile(filepath.Join(dir, key))
+		if int64(len(stored)) != sealedSize(int64(n)) {
+			t.Errorf("%d bytes stored as %d, want %d", n, len(stored), sealedSize(int64(n)))
+		}
+		if n > 16 && bytes.Contains(stored, data[:16]) {
+			t.Errorf("%d bytes: plaintext visible in stored object", n)
+		}
+
+		got, size := readObject(t, b, key, 0, 0)
+		if !bytes.Equal(got, data) || size != int64(n) {
+			t.Errorf("%d bytes: read back %d bytes (size %d)", n, len(got), size)
+		}
+		if size, _, err := b.StatObject(ctx, key); err != nil || size != int64(n) {
+			t.Errorf("StatObject(%d) = %d, %v", n, size, err)
+		}
+	}
+}
+
+func TestEncryptedRangedRead(t *testing.T) {
+	b, _ := newEncryptedTestBackend(t, testKeyring(t, "k1"), "k1")
+	data := randomBytes(3*encFrameSize + 100)
+	if err := b.PutObject(context.Background(), "obj", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	total := int64(len(data))
+	tests := []struct{ offset, length int64 }{
+		{0, 10},
+		{5, 0},
+		{encFrameSize - 3, 6},        // across a frame boundary
+		{encFrameSize, encFrameSize}, // exactly one frame
+		{100, 2*encFrameSize + 50},
+		{total - 1, 1},
+		{total - 50, 500}, // clamped at the end
+		{total, 0},
+	}
+	for _, tt := range tests {
+		end := total
+		if tt.length > 0 && tt.offset+tt.length < end {
+			end = tt.offset + tt.length
+		}
+		got, size := readObject(t, b, "obj", tt.offset, tt.length)
+		if !bytes.Equal(got, data[tt.offset:end]) || size != end-tt.offset {
+			t.Errorf("range (%d, %d): got %d bytes (size %d), want %d", tt.offset, tt.length, len(got), size, end-tt.offset)
+		}
+	}
+
+	if _, _, err := b.GetObject(context.Background(), "obj", total+1, 0); err == nil {
+		t.Error("GetObject past the end should fail")
+	}
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	b, dir := newEncryptedTestBackend(t, testKeyring(t, "k1"), "k1")
+	data := randomBytes(2*encFrameSize + 10)
+	b.PutObject(context.Background(), "obj", bytes.NewReader(data), int64(len(data)))
+	path := filepath.Join(dir, "obj")
+	stored, _ := os.ReadFile(path)
+
+	readAll := func() error {
+		rc, _, err := b.GetObject(context.Background(), "obj", 0, 0)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.ReadAll(rc)
+		return err
+	}
+
+	flipped := bytes.Clone(stored)
+	flipped[encHeaderSize+encSealedFrame+5] ^= 1
+	os.WriteFile(path, flipped, 0644)
+	if readAll() == nil {
+		t.Error("modified frame was accepted")
+	}
+
+	// Dropping the last frame leaves a valid-looking object of whole frames
+	os.WriteFile(path, stored[:encHeaderSize+2*encSealedFrame], 0644)
+	if readAll() == nil {
+		t.Error("truncated object was accepted")
+	}
+}
+
+func TestEncryptedReadsPlaintextObjects(t *testing.T) {
+	b, dir := newEncryptedTestBackend(t, testKeyring(t, "k1"), "k1")
+	for name, content := range map[string]string{"short": "hello", "long": strings.Repeat("plain text ", 100)} {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if got, size := readObject(t, b, name, 0, 0); string(got) != content || size != int64(len(content)) {
+			t.Errorf("%s: read %q (size %d)", name, got, size)
+		}
+		if got, _ := readObject(t, b, name, 1, 3); string(got) != content[1:4] {
+			t.Errorf("%s: range read %q", name, got)
+		}
+		if size, _, _ := b.StatObject(context.Background(), name); size != int64(len(content)) {
+			t.Errorf("%s: StatObject size %d", name, size)
+		}
+	}
+}
+
+func TestEncryptedListObjects(t *testing.T) {
+	b, dir := newEncryptedTestBackend(t, testKeyring(t, "k1"), "k1")
+	ctx := context.Background()
+	sealed := randomBytes(encFrameSize + 7)
+	b.PutObject(ctx, "docs/sealed.bin", bytes.NewReader(sealed), int64(len(sealed)))
+	os.WriteFile(filepath.Join(dir, "docs", "plain.txt"), []byte("plain"), 0644)
+	os.WriteFile(filepath.Join(dir, "docs", ".fruitsalade-1.tmp"), []byte("partial"), 0644)
+	os.MkdirAll(filepath.Join(dir, "other"), 0755)
+	os.WriteFile(filepath.Join(dir, "other", "x"), []byte("x"), 0644)
+
+	sizes := make(map[string]int64)
+	err := b.ListObjects(ctx, "docs/", func(key string, size int64, _ time.Time) error {
+		sizes[key] = size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	want := map[string]int64{"docs/sealed.bin": int64(len(sealed)), "docs/plain.txt": 5}
+	if fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("listed %v, want %v", sizes, want)
+	}
+}
+
+func TestEncryptedRewrap(t *testing.T) {
+	kr := testKeyring(t, "old", "new")
+	oldBackend, dir := newEncryptedTestBackend(t, kr, "old")
+	ctx := context.Background()
+	data := randomBytes(encFrameSize + 42)
+	oldBackend.PutObject(ctx, "obj", bytes.NewReader(data), int64(len(data)))
+	before, _ := os.ReadFile(filepath.Join(dir, "obj"))
+
+	newBackend, err := NewEncryptedBackend(oldBackend.Backend, kr, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done, err := newBackend.Rewrap(ctx, "obj"); !done || err != nil {
+		t.Fatalf("Rewrap = %v, %v", done, err)
+	}
+	if done, err := newBackend.Rewrap(ctx, "obj"); done || err != nil {
+		t.Errorf("second Rewrap = %v, %v, want no-op", done, err)
+	}
+
+	after, _ := os.ReadFile(filepath.Join(dir, "obj"))
+	if !bytes.Equal(before[encHeaderSize:], after[encHeaderSize:]) {
+		t.Error("Rewrap re-encrypted the frames")
+	}
+	header, _ := parseHeader(after)
+	if header == nil || header.keyID != "new" {
+		t.Fatalf("header after rewrap = %+v", header)
+	}
+
+	// Readable with only the new key
+	onlyNew := &Keyring{keys: map[string][]byte{"new": kr.keys["new"]}}
+	reader, _ := NewEncryptedBackend(oldBackend.Backend, onlyNew, "new")
+	if got, _ := readObject(t, reader, "obj", 0, 0); !bytes.Equal(got, data) {
+		t.Error("content changed after rewrap")
+	}
+}
+
+func TestRekeyer(t *testing.T) {
+	kr := testKeyring(t, "old", "new")
+	oldBackend, _ := newEncryptedTestBackend(t, kr, "old")
+	ctx := context.Background()
+	data := randomBytes(100)
+	for _, key := range []string{"_thumbs/a.jpg", "_cas/abc", "docs/a.txt"} {
+		oldBackend.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)))
+	}
+	oldBackend.Backend.PutObject(ctx, "docs/plain.txt", strings.NewReader("plain"), 5)
+
+	newBackend, err := NewEncryptedBackend(oldBackend.Backend, kr, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rk := NewRekeyer(ctx)
+	if err := rk.Trigger(7, "old", newBackend); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rk.Status().Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	st := rk.Status()
+	if st.Running || st.LocationID != 7 || st.OldKeyID != "old" || st.KeyID != "new" ||
+		st.Objects != 4 || st.Rewrapped != 3 || st.Failed != 0 || st.Error != "" {
+		t.Fatalf("status = %+v", st)
+	}
+
+	// Every encrypted object, thumbnails included, opens with the new key
+	onlyNew := &Keyring{keys: map[string][]byte{"new": kr.keys["new"]}}
+	reader, _ := NewEncryptedBackend(oldBackend.Backend, onlyNew, "new")
+	for _, key := range []string{"_thumbs/a.jpg", "_cas/abc", "docs/a.txt"} {
+		if got, _ := readObject(t, reader, key, 0, 0); !bytes.Equal(got, data) {
+			t.Errorf("%s unreadable with the new key", key)
+		}
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	kr, err := ParseKeyring("# master keys\na:" + key + "\n b:" + key + " ,")
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	if ids := kr.IDs(); len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("IDs = %v", ids)
+	}
+
+	for _, bad := range []string{
+		"nokey",
+		"a:" + base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		"a:not-base64!",
+		"a:" + key + ",a:" + key,
+		strings.Repeat("x", 33) + ":" + key,
+	} {
+		if _, err := ParseKeyring(bad); err == nil {
+			t.Errorf("ParseKeyring(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// ─── Location Health ────────────────────────────────────────────────────────
+//
+// The Router stats a probe key on every location at a fixed interval. A
+// location that fails is refused straight away by ResolveForFile and
+// ResolveForUpload instead of letting each request wait out the backend's
+// timeout. Reads through ResolveForRead fail over to a healthy location
+// whose config sets "replica_of" to the failed location's ID; keeping the
+// replica in sync is up to the backend (e.g. S3 bucket replication).
+// Locations count as healthy until their first check says otherwise.
+
+// ErrStorageUnavailable is returned when the resolved location failed its
+// last health check.
+var ErrStorageUnavailable = errors.New("storage location unavailable")
+
+const (
+	// healthProbeKey is the object a health check stats. It need not exist:
+	// a not-found answer proves the backend is reachable.
+	healthProbeKey = ".fruitsalade-health"
+
+	// healthProbeTimeout bounds a single check.
+	healthProbeTimeout = 10 * time.Second
+)
+
+// LocationHealth is the outcome of a location's latest health check.
+type LocationHealth struct {
+	Healthy   bool      `json:"healthy"`
+	CheckedAt time.Time `json:"checked_at"`
+	Since     time.Time `json:"since"` // when Healthy last changed
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+}
+
+// ReplicaOf returns the "replica_of" location ID of a location config, or 0.
+func ReplicaOf(config json.RawMessage) int {
+	var cfg struct {
+		ReplicaOf int `json:"replica_of"`
+	}
+	if len(config) == 0 || json.Unmarshal(config, &cfg) != nil {
+		return 0
+	}
+	return cfg.ReplicaOf
+}
+
+func unavailable(loc *StorageLocation) error {
+	return fmt.Errorf("%w: %q failed its health check", ErrStorageUnavailable, loc.Name)
+}
+
+// ResolveForRead is ResolveForFile for reads: if the file's location is
+// unhealthy, it returns a healthy replica of it instead.
+func (r *Router) ResolveForRead(ctx context.Context, storageLocID *int, groupID *int) (Backend, *StorageLocation, error) {
+	loc, err := r.resolveFile(ctx, storageLocID, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.Healthy(loc.ID) {
+		return loc.Backend, loc, nil
+	}
+	if replica := r.HealthyReplica(loc.ID); replica != nil {
+		return replica.Backend, replica, nil
+	}
+	return nil, loc, unavailable(loc)
+}
+
+// HealthyReplica returns a healthy location configured as a replica of id,
+// or nil.
+func (r *Router) HealthyReplica(id int) *StorageLocation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, loc := range r.locations {
+		if ReplicaOf(loc.Config) == id && r.Healthy(loc.ID) {
+			return loc
+		}
+	}
+	return nil
+}
+
+// Healthy reports whether a location passed its last health check.
+func (r *Router) Healthy(id int) bool {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	h, ok := r.health[id]
+	return !ok || h.Healthy
+}
+
+// Health returns a location's last health check; ok is false before the
+// first one.
+func (r *Router) Health(id int) (h LocationHealth, ok bool) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	h, ok = r.health[id]
+	return h, ok
+}
+
+// SetOnHealthChange registers fn to be called when a location becomes
+// unhealthy or recovers.
+func (r *Router) SetOnHealthChange(fn func(loc *StorageLocation, h LocationHealth)) {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	r.onHealthChange = fn
+}
+
+// StartHealthChecks checks every location now and then every interval
+// until ctx is done.
+func (r *Router) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		r.CheckHealth(ctx)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.CheckHealth(ctx)
+			}
+		}
+	}()
+}
+
+// CheckHealth probes all locations concurrently and records the results.
+func (r *Router) CheckHealth(ctx context.Context) {
+	r.mu.RLock()
+	locs := make([]*StorageLocation, 0, len(r.locations))
+	for _, loc := range r.locations {
+		locs = append(locs, loc)
+	}
+	r.mu.RUnlock()
+
+	// Forget locations that were removed
+	r.healthMu.Lock()
+	for id := range r.health {
+		if !containsLocation(locs, id) {
+			delete(r.health, id)
+		}
+	}
+	r.healthMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, loc := range locs {
+		wg.Add(1)
+		go func(loc *StorageLocation) {
+			defer wg.Done()
+			r.probe(ctx, loc)
+		}(loc)
+	}
+	wg.Wait()
+}
+
+func containsLocation(locs []*StorageLocation, id int) bool {
+	for _, loc := range locs {
+		if loc.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) probe(ctx context.Context, loc *StorageLocation) {
+	pctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	start := time.Now()
+	_, _, err := loc.Backend.StatObject(pctx, healthProbeKey)
+	cancel()
+	if ctx.Err() != nil {
+		return // shutting down; not the backend's fault
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	r.recordHealth(loc, err, time.Since(start))
+}
+
+// recordHealth stores a check result and reports transitions.
+func (r *Router) recordHealth(loc *StorageLocation, err error, latency time.Duration) {
+	now := time.Now()
+	h := LocationHealth{
+		Healthy:   err == nil,
+		CheckedAt: now,
+		Since:     now,
+		LatencyMs: latency.Milliseconds(),
+	}
+	if err != nil {
+		h.Error = err.Error()
+	}
+
+	r.healthMu.Lock()
+	prev, seen := r.health[loc.ID]
+	if seen && prev.Healthy == h.Healthy {
+		h.Since = prev.Since
+	}
+	// Locations start out healthy, so a first failed check is a transition
+	changed := (seen && prev.Healthy != h.Healthy) || (!seen && !h.Healthy)
+	r.health[loc.ID] = h
+	fn := r.onHealthChange
+	r.healthMu.Unlock()
+
+	metrics.SetStorageLocationHealthy(loc.Name, h.Healthy)
+	if !changed {
+		return
+	}
+	if h.Healthy {
+		logging.Info("storage location recovered",
+			zap.Int("location_id", loc.ID),
+			zap.String("name", loc.Name))
+	} else {
+		logging.Error("storage location unhealthy",
+			zap.Int("location_id", loc.ID),
+			zap.String("name", loc.Name),
+			zap.Error(err))
+	}
+	if fn != nil {
+		fn(loc, h)
+	}
+}
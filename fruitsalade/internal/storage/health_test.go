@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
+)
+
+// flakyBackend is a local backend whose health probe fails while down is set.
+type flakyBackend struct {
+	Backend
+	down bool
+}
+
+func (b *flakyBackend) StatObject(ctx context.Context, key string) (int64, time.Time, error) {
+	if b.down {
+		return 0, time.Time{}, errors.New("connection refused")
+	}
+	return b.Backend.StatObject(ctx, key)
+}
+
+func newHealthTestRouter(t *testing.T) (*Router, *flakyBackend, *flakyBackend) {
+	t.Helper()
+	newBackend := func() *flakyBackend {
+		inner, err := local.New(local.Config{RootPath: t.TempDir(), CreateDirs: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &flakyBackend{Backend: inner}
+	}
+	primary, replica := newBackend(), newBackend()
+	primaryLoc := &StorageLocation{
+		LocationRow: LocationRow{ID: 1, Name: "primary", IsDefault: true},
+		Backend:     primary,
+	}
+	replicaLoc := &StorageLocation{
+		LocationRow: LocationRow{ID: 2, Name: "replica", Config: json.RawMessage(`{"replica_of": 1}`)},
+		Backend:     replica,
+	}
+	r := &Router{
+		locations:  map[int]*StorageLocation{1: primaryLoc, 2: replicaLoc},
+		groupMap:   make(map[int][]*StorageLocation),
+		defaultLoc: primaryLoc,
+		health:     make(map[int]LocationHealth),
+	}
+	return r, primary, replica
+}
+
+func TestHealthFailover(t *testing.T) {
+	ctx := context.Background()
+	r, primary, replica := newHealthTestRouter(t)
+
+	var changes []LocationHealth
+	r.SetOnHealthChange(func(loc *StorageLocation, h LocationHealth) {
+		if loc.ID == 1 {
+			changes = append(changes, h)
+		}
+	})
+
+	// A missing probe key still counts as healthy
+	r.CheckHealth(ctx)
+	if h, ok := r.Health(1); !ok || !h.Healthy {
+		t.Fatalf("Health(1) = %+v, %v; want healthy", h, ok)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("healthy first check reported %d changes", len(changes))
+	}
+
+	primary.down = true
+	r.CheckHealth(ctx)
+	if len(changes) != 1 || changes[0].Healthy || changes[0].Error == "" {
+		t.Fatalf("changes = %+v, want one unhealthy change", changes)
+	}
+
+	if _, _, err := r.ResolveForFile(ctx, nil, nil); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("ResolveForFile error = %v, want ErrStorageUnavailable", err)
+	}
+	if _, _, err := r.ResolveForUpload(ctx, "/a.txt", nil); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("ResolveForUpload error = %v, want ErrStorageUnavailable", err)
+	}
+	b, loc, err := r.ResolveForRead(ctx, nil, nil)
+	if err != nil || loc.ID != 2 || b != Backend(replica) {
+		t.Errorf("ResolveForRead = %v, %v; want replica", loc, err)
+	}
+
+	// Without a healthy replica reads fail too
+	replica.down = true
+	r.CheckHealth(ctx)
+	if _, _, err := r.ResolveForRead(ctx, nil, nil); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("ResolveForRead error = %v, want ErrStorageUnavailable", err)
+	}
+
+	// A second failed check is not a new transition
+	if len(changes) != 1 {
+		t.Fatalf("changes = %d after repeated failure, want 1", len(changes))
+	}
+
+	primary.down = false
+	r.CheckHealth(ctx)
+	if len(changes) != 2 || !changes[1].Healthy {
+		t.Fatalf("changes = %+v, want recovery", changes)
+	}
+	if _, loc, err := r.ResolveForRead(ctx, nil, nil); err != nil || loc.ID != 1 {
+		t.Errorf("ResolveForRead after recovery = %v, %v; want primary", loc, err)
+	}
+}
+
+func TestReplicaOf(t *testing.T) {
+	tests := []struct {
+		config string
+		want   int
+	}{
+		{`{"replica_of": 3}`, 3},
+		{`{"bucket": "x"}`, 0},
+		{``, 0},
+		{`not json`, 0},
+	}
+	for _, tt := range tests {
+		if got := ReplicaOf(json.RawMessage(tt.config)); got != tt.want {
+			t.Errorf("ReplicaOf(%q) = %d, want %d", tt.config, got, tt.want)
+		}
+	}
+}
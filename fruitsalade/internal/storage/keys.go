@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrInvalidKey is returned by KeyForPath for a path that cannot be stored
+// under its own object key.
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// ReservedPrefixes are the keys FruitSalade keeps next to file content:
+// old versions, thumbnails, deduplicated content, the losing content of
+// sync conflicts and health probes. No user path maps to them.
+var ReservedPrefixes = []string{"_versions/", "_thumbs/", "_cas/", "_conflicts/", "_fruitsalade_"}
+
+// maxKeySegment is the longest path segment KeyForPath accepts, the name
+// limit of common filesystems.
+const maxKeySegment = 255
+
+// KeyForPath returns the object key of the file at the server path p: the
+// cleaned path without its leading slash. Every writer that derives a key
+// from a user path goes through it. Paths with ".." or "." segments, also
+// when percent-encoded, backslashes, NUL bytes or segments longer than 255
+// bytes are refused with ErrInvalidKey, as are paths whose key would fall
+// under one of the ReservedPrefixes.
+func KeyForPath(p string) (string, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: %q %s", ErrInvalidKey, p, reason)
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", invalid("contains a NUL byte")
+	}
+	if strings.ContainsRune(p, '\\') {
+		return "", invalid("contains a backslash")
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if len(seg) > maxKeySegment {
+			return "", invalid(fmt.Sprintf("has a segment longer than %d bytes", maxKeySegment))
+		}
+		if dec, err := url.PathUnescape(seg); err == nil && (dec == "." || dec == "..") {
+			return "", invalid("has a dot segment")
+		}
+	}
+
+	key := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if key == "" {
+		return "", invalid("is the root")
+	}
+	if IsReservedKey(key) {
+		return "", invalid("is reserved for internal use")
+	}
+	return key, nil
+}
+
+// IsReservedKey reports whether key lies under one of the ReservedPrefixes.
+func IsReservedKey(key string) bool {
+	for _, prefix := range ReservedPrefixes {
+		if strings.HasPrefix(key+"/", prefix) {
+			return true
+		}
+	}
+	return false
+}
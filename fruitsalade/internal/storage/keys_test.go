@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyForPath(t *testing.T) {
+	valid := map[string]string{
+		"/docs/report.txt":             "docs/report.txt",
+		"docs/report.txt":              "docs/report.txt",
+		"/docs/":                       "docs",
+		"/photos/_versions/a.jpg":      "photos/_versions/a.jpg",
+		"/_notes.txt":                  "_notes.txt",
+		"/a%2eb/c":                     "a%2eb/c",
+		"/" + strings.Repeat("x", 255): strings.Repeat("x", 255),
+	}
+	for p, want := range valid {
+		got, err := KeyForPath(p)
+		if err != nil || got != want {
+			t.Errorf("KeyForPath(%q) = %q, %v, want %q", p, got, err, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/",
+		"/../etc/passwd",
+		"/docs/../../etc/passwd",
+		"/docs/./a",
+		"/%2e%2e/etc/passwd",
+		"/docs/%2E%2e/x",
+		"/docs/%2e",
+		"/..\\..\\etc\\passwd",
+		"/docs\\a.txt",
+		"/docs/a\x00.txt",
+		"/" + strings.Repeat("x", 256),
+		"/_versions/docs/a.txt/1",
+		"/_versions",
+		"/_thumbs/abc_256.jpg",
+		"/_cas/abcdef",
+		"/_conflicts/abcdef",
+		"/_fruitsalade_read_probe",
+		"//_cas/abcdef",
+	}
+	for _, p := range invalid {
+		if key, err := KeyForPath(p); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("KeyForPath(%q) = %q, %v, want ErrInvalidKey", p, key, err)
+		}
+	}
+}
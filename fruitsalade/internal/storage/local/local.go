@@ -4,10 +4,15 @@ package local
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 // Config holds local filesystem backend settings.
@@ -16,9 +21,13 @@ type Config struct {
 	CreateDirs bool   `json:"create_dirs"`
 }
 
+// ErrOutsideRoot is returned for a key that would resolve to a file outside
+// the root directory.
+var ErrOutsideRoot = errors.New("key resolves outside the storage root")
+
 // LocalBackend implements storage.Backend using the local filesystem.
 type LocalBackend struct {
-	rootPath   string
+	rootPath   string // absolute and clean
 	createDirs bool
 }
 
@@ -41,9 +50,13 @@ func New(cfg Config) (*LocalBackend, error) {
 	} else if !info.IsDir() {
 		return nil, fmt.Errorf("root path %s is not a directory", cfg.RootPath)
 	}
+	root, err := filepath.Abs(cfg.RootPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve root path %s: %w", cfg.RootPath, err)
+	}
 
 	return &LocalBackend{
-		rootPath:   cfg.RootPath,
+		rootPath:   root,
 		createDirs: cfg.CreateDirs,
 	}, nil
 }
@@ -57,13 +70,26 @@ func NewFromJSON(raw json.RawMessage) (*LocalBackend, error) {
 	return New(cfg)
 }
 
-func (b *LocalBackend) fullPath(key string) string {
-	return filepath.Join(b.rootPath, filepath.FromSlash(key))
+// fullPath returns the file of key. Callers above the backend sanitize
+// keys already; this is the last line of defence against one that would
+// still land outside the root, such as "../etc/passwd".
+func (b *LocalBackend) fullPath(key string) (string, error) {
+	if strings.ContainsRune(key, 0) {
+		return "", fmt.Errorf("%w: %q contains a NUL byte", ErrOutsideRoot, key)
+	}
+	full := filepath.Join(b.rootPath, filepath.FromSlash(key))
+	if full != b.rootPath && !strings.HasPrefix(full, b.rootPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrOutsideRoot, key)
+	}
+	return full, nil
 }
 
 // GetObject reads a file from the local filesystem with range support.
 func (b *LocalBackend) GetObject(_ context.Context, key string, offset, length int64) (io.ReadCloser, int64, error) {
-	path := b.fullPath(key)
+	path, err := b.fullPath(key)
+	if err != nil {
+		return nil, 0, err
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, 0, fmt.Errorf("open %s: %w", key, err)
@@ -76,6 +102,10 @@ func (b *LocalBackend) GetObject(_ context.Context, key string, offset, length i
 	}
 
 	totalSize := info.Size()
+	if offset < 0 || offset > totalSize {
+		f.Close()
+		return nil, 0, fmt.Errorf("range of %s: offset %d outside object of %d bytes", key, offset, totalSize)
+	}
 
 	if offset > 0 {
 		if _, err := f.Seek(offset, io.SeekStart); err != nil {
@@ -84,23 +114,23 @@ func (b *LocalBackend) GetObject(_ context.Context, key string, offset, length i
 		}
 	}
 
-	if length > 0 {
+	// Like an S3 range, a length past the end returns what is there
+	returnSize := totalSize - offset
+	if length > 0 && length < returnSize {
 		return &limitedReadCloser{
 			Reader: io.LimitReader(f, length),
 			Closer: f,
 		}, length, nil
 	}
-
-	returnSize := totalSize - offset
-	if returnSize < 0 {
-		returnSize = 0
-	}
 	return f, returnSize, nil
 }
 
 // PutObject writes content to the local filesystem atomically.
 func (b *LocalBackend) PutObject(_ context.Context, key string, body io.Reader, size int64) error {
-	path := b.fullPath(key)
+	path, err := b.fullPath(key)
+	if err != nil {
+		return err
+	}
 	dir := filepath.Dir(path)
 
 	if b.createDirs {
@@ -136,8 +166,11 @@ func (b *LocalBackend) PutObject(_ context.Context, key string, body io.Reader,
 
 // DeleteObject removes a file from the local filesystem.
 func (b *LocalBackend) DeleteObject(_ context.Context, key string) error {
-	path := b.fullPath(key)
-	err := os.Remove(path)
+	path, err := b.fullPath(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("delete %s: %w", key, err)
 	}
@@ -146,8 +179,14 @@ func (b *LocalBackend) DeleteObject(_ context.Context, key string) error {
 
 // CopyObject copies a file on the local filesystem.
 func (b *LocalBackend) CopyObject(_ context.Context, srcKey, dstKey string) error {
-	srcPath := b.fullPath(srcKey)
-	dstPath := b.fullPath(dstKey)
+	srcPath, err := b.fullPath(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := b.fullPath(dstKey)
+	if err != nil {
+		return err
+	}
 
 	if b.createDirs {
 		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
@@ -188,8 +227,11 @@ func (b *LocalBackend) CopyObject(_ context.Context, srcKey, dstKey string) erro
 
 // ObjectExists checks if a file exists on the local filesystem.
 func (b *LocalBackend) ObjectExists(_ context.Context, key string) (bool, error) {
-	path := b.fullPath(key)
-	_, err := os.Stat(path)
+	path, err := b.fullPath(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -199,6 +241,73 @@ func (b *LocalBackend) ObjectExists(_ context.Context, key string) (bool, error)
 	return true, nil
 }
 
+// StatObject returns the size and modification time of a file.
+func (b *LocalBackend) StatObject(_ context.Context, key string) (int64, time.Time, error) {
+	path, err := b.fullPath(key)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("stat %s: %w", key, err)
+	}
+	if info.IsDir() {
+		return 0, time.Time{}, fmt.Errorf("stat %s: is a directory", key)
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+// ListObjects walks the files below the root whose keys start with prefix.
+// Temp files of unfinished writes are left out.
+func (b *LocalBackend) ListObjects(ctx context.Context, prefix string, fn func(key string, size int64, modTime time.Time) error) error {
+	// Only walk the directory the prefix points into
+	start := b.rootPath
+	if dir := path.Dir(prefix); strings.Contains(prefix, "/") && dir != "." {
+		var err error
+		if start, err = b.fullPath(dir); err != nil {
+			return err
+		}
+	}
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.rootPath, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(key, prefix) ||
+			(strings.HasPrefix(d.Name(), ".fruitsalade-") && strings.HasSuffix(d.Name(), ".tmp")) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		return fn(key, info.Size(), info.ModTime())
+	})
+	if err != nil {
+		return fmt.Errorf("list %s: %w", prefix, err)
+	}
+	return nil
+}
+
 // Type returns "local".
 func (b *LocalBackend) Type() string { return "local" }
 
@@ -0,0 +1,49 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeysStayInsideRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	b, err := New(Config{RootPath: root, CreateDirs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{
+		"../escaped.txt",
+		"a/../../escaped.txt",
+		"../root-sibling/x",
+		"a\x00b",
+	} {
+		err := b.PutObject(ctx, key, bytes.NewReader([]byte("x")), 1)
+		if !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("PutObject(%q) = %v, want ErrOutsideRoot", key, err)
+		}
+		if _, _, err := b.GetObject(ctx, key, 0, 0); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("GetObject(%q) = %v, want ErrOutsideRoot", key, err)
+		}
+		if err := b.CopyObject(ctx, "a.txt", key); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("CopyObject(a.txt, %q) = %v, want ErrOutsideRoot", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("a file was written outside the root")
+	}
+
+	// Keys that only look odd stay inside
+	if err := b.PutObject(ctx, "a/./b/../c.txt", bytes.NewReader([]byte("x")), 1); err != nil {
+		t.Errorf("PutObject inside the root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", "c.txt")); err != nil {
+		t.Errorf("cleaned key not stored at a/c.txt: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+)
+
+// ErrRekeyRunning is returned by Rekeyer.Trigger while a rekey is in
+// progress.
+var ErrRekeyRunning = errors.New("rekey already running")
+
+// RekeyStatus describes the current or most recent rekey.
+type RekeyStatus struct {
+	Running    bool       `json:"running"`
+	LocationID int        `json:"location_id"`
+	OldKeyID   string     `json:"old_key_id"`
+	KeyID      string     `json:"key_id"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Objects    int64      `json:"objects"`   // objects listed
+	Rewrapped  int64      `json:"rewrapped"` // objects moved to the new key
+	Failed     int64      `json:"failed"`    // objects that could not be re-wrapped
+	Error      string     `json:"error,omitempty"`
+}
+
+// Rekeyer re-wraps the data keys of every object of an encrypted location
+// under its current master key, one location at a time, in the
+// background.
+type Rekeyer struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	status RekeyStatus
+}
+
+// NewRekeyer creates a Rekeyer. Rekeys stop when ctx is done.
+func NewRekeyer(ctx context.Context) *Rekeyer {
+	return &Rekeyer{ctx: ctx}
+}
+
+// Trigger starts re-wrapping the objects of location locationID, which
+// backend stores under its new key, in the background.
+func (rk *Rekeyer) Trigger(locationID int, oldKeyID string, backend *EncryptedBackend) error {
+	rk.mu.Lock()
+	if rk.status.Running {
+		rk.mu.Unlock()
+		return ErrRekeyRunning
+	}
+	now := time.Now()
+	rk.status = RekeyStatus{
+		Running:    true,
+		LocationID: locationID,
+		OldKeyID:   oldKeyID,
+		KeyID:      backend.KeyID(),
+		StartedAt:  &now,
+	}
+	rk.mu.Unlock()
+
+	go rk.run(rk.ctx, locationID, backend)
+	return nil
+}
+
+// Status returns the progress of the current or last rekey.
+func (rk *Rekeyer) Status() RekeyStatus {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	return rk.status
+}
+
+func (rk *Rekeyer) update(fn func(st *RekeyStatus)) {
+	rk.mu.Lock()
+	fn(&rk.status)
+	rk.mu.Unlock()
+}
+
+// run lists the location's objects and re-wraps each one. Keys are listed
+// first so rewritten objects are not listed again. Objects that fail are
+// counted and logged; only a failed listing stops the rekey.
+func (rk *Rekeyer) run(ctx context.Context, locationID int, backend *EncryptedBackend) {
+	var keys []string
+	runErr := backend.ListObjects(ctx, "", func(key string, _ int64, _ time.Time) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if runErr == nil {
+		rk.update(func(st *RekeyStatus) { st.Objects = int64(len(keys)) })
+		for _, key := range keys {
+			if runErr = ctx.Err(); runErr != nil {
+				break
+			}
+			done, err := backend.Rewrap(ctx, key)
+			if err != nil {
+				logging.Warn("failed to rewrap object",
+					zap.Int("location_id", locationID), zap.String("key", key), zap.Error(err))
+			}
+			rk.update(func(st *RekeyStatus) {
+				if err != nil {
+					st.Failed++
+				} else if done {
+					st.Rewrapped++
+				}
+			})
+		}
+	}
+
+	now := time.Now()
+	rk.update(func(st *RekeyStatus) {
+		st.Running = false
+		st.FinishedAt = &now
+		if runErr != nil {
+			st.Error = runErr.Error()
+		}
+	})
+	st := rk.Status()
+
+	fields := []zap.Field{
+		zap.Int("location_id", locationID),
+		zap.String("old_key_id", st.OldKeyID),
+		zap.String("key_id", st.KeyID),
+		zap.Int64("objects", st.Objects),
+		zap.Int64("rewrapped", st.Rewrapped),
+		zap.Int64("failed", st.Failed),
+	}
+	if runErr != nil {
+		logging.Error("storage location rekey aborted", append(fields, zap.Error(runErr))...)
+		return
+	}
+	logging.Info("storage location rekeyed", fields...)
+}
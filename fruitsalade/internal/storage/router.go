@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -30,15 +31,23 @@ type Router struct {
 	defaultLoc *StorageLocation
 	locStore   *LocationStore
 	groupStore *sharing.GroupStore
+	keys       *Keyring
+
+	healthMu       sync.Mutex
+	health         map[int]LocationHealth // id -> last check; no entry = not checked yet
+	onHealthChange func(loc *StorageLocation, h LocationHealth)
 }
 
 // NewRouter creates a Router and loads all configured storage locations.
-func NewRouter(ctx context.Context, locStore *LocationStore, groupStore *sharing.GroupStore) (*Router, error) {
+// keys holds the master keys of encrypted locations (nil if there are none).
+func NewRouter(ctx context.Context, locStore *LocationStore, groupStore *sharing.GroupStore, keys *Keyring) (*Router, error) {
 	r := &Router{
 		locations:  make(map[int]*StorageLocation),
 		groupMap:   make(map[int][]*StorageLocation),
 		locStore:   locStore,
 		groupStore: groupStore,
+		keys:       keys,
+		health:     make(map[int]LocationHealth),
 	}
 
 	if err := r.Reload(ctx); err != nil {
@@ -71,7 +80,7 @@ func (r *Router) Reload(ctx context.Context) error {
 		if existing != nil && string(existing.Config) == string(row.Config) && existing.BackendType == row.BackendType {
 			backend = existing.Backend
 		} else {
-			backend, err = NewBackendFromConfig(ctx, row.BackendType, row.Config)
+			backend, err = r.OpenBackend(ctx, row.BackendType, row.Config)
 			if err != nil {
 				logging.Error("failed to initialize storage backend",
 					zap.Int("location_id", row.ID),
@@ -122,16 +131,47 @@ func (r *Router) Reload(ctx context.Context) error {
 	return nil
 }
 
+// OpenBackend creates a Backend for a location config, wrapped in an
+// EncryptedBackend when the config names an encryption key.
+func (r *Router) OpenBackend(ctx context.Context, backendType string, config json.RawMessage) (Backend, error) {
+	keyID := EncryptionKeyID(config)
+	if keyID != "" && !r.keys.Has(keyID) {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	backend, err := NewBackendFromConfig(ctx, backendType, config)
+	if err != nil || keyID == "" {
+		return backend, err
+	}
+	return NewEncryptedBackend(backend, r.keys, keyID)
+}
+
+// Keyring returns the master keys available to encrypted locations.
+func (r *Router) Keyring() *Keyring {
+	return r.keys
+}
+
 // ResolveForFile resolves which backend holds an existing file's content.
 // Priority: storageLocID (explicit) > groupID (walk to root) > default.
+// Returns ErrStorageUnavailable if the location failed its last health check.
 func (r *Router) ResolveForFile(ctx context.Context, storageLocID *int, groupID *int) (Backend, *StorageLocation, error) {
+	loc, err := r.resolveFile(ctx, storageLocID, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !r.Healthy(loc.ID) {
+		return nil, loc, unavailable(loc)
+	}
+	return loc.Backend, loc, nil
+}
+
+func (r *Router) resolveFile(ctx context.Context, storageLocID *int, groupID *int) (*StorageLocation, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	// 1. Explicit storage location
 	if storageLocID != nil {
 		if loc, ok := r.locations[*storageLocID]; ok {
-			return loc.Backend, loc, nil
+			return loc, nil
 		}
 	}
 
@@ -139,33 +179,44 @@ func (r *Router) ResolveForFile(ctx context.Context, storageLocID *int, groupID
 	if groupID != nil && *groupID > 0 {
 		loc := r.resolveByGroup(ctx, *groupID)
 		if loc != nil {
-			return loc.Backend, loc, nil
+			return loc, nil
 		}
 	}
 
 	// 3. Default
 	if r.defaultLoc != nil {
-		return r.defaultLoc.Backend, r.defaultLoc, nil
+		return r.defaultLoc, nil
 	}
 
-	return nil, nil, fmt.Errorf("no storage backend available")
+	return nil, fmt.Errorf("no storage backend available")
 }
 
 // ResolveForUpload resolves which backend to use for a new file upload.
 // Priority: groupID (walk to root) > path-based group match > default.
-// Returns ErrReadOnlyStorage if the resolved location is read-only.
+// Returns ErrReadOnlyStorage if the resolved location is read-only and
+// ErrStorageUnavailable if it failed its last health check.
 func (r *Router) ResolveForUpload(ctx context.Context, path string, groupID *int) (Backend, *StorageLocation, error) {
+	loc, err := r.resolveUpload(ctx, path, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc.ReadOnly {
+		return nil, loc, ErrReadOnlyStorage
+	}
+	if !r.Healthy(loc.ID) {
+		return nil, loc, unavailable(loc)
+	}
+	return loc.Backend, loc, nil
+}
+
+func (r *Router) resolveUpload(ctx context.Context, path string, groupID *int) (*StorageLocation, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	// 1. Known group
 	if groupID != nil && *groupID > 0 {
-		loc := r.resolveByGroup(ctx, *groupID)
-		if loc != nil {
-			if loc.ReadOnly {
-				return nil, loc, ErrReadOnlyStorage
-			}
-			return loc.Backend, loc, nil
+		if loc := r.resolveByGroup(ctx, *groupID); loc != nil {
+			return loc, nil
 		}
 	}
 
@@ -173,25 +224,18 @@ func (r *Router) ResolveForUpload(ctx context.Context, path string, groupID *int
 	if path != "" && path != "/" {
 		firstSeg := extractFirstSegment(path)
 		if firstSeg != "" {
-			loc := r.resolveByGroupName(ctx, firstSeg)
-			if loc != nil {
-				if loc.ReadOnly {
-					return nil, loc, ErrReadOnlyStorage
-				}
-				return loc.Backend, loc, nil
+			if loc := r.resolveByGroupName(ctx, firstSeg); loc != nil {
+				return loc, nil
 			}
 		}
 	}
 
 	// 3. Default
 	if r.defaultLoc != nil {
-		if r.defaultLoc.ReadOnly {
-			return nil, r.defaultLoc, ErrReadOnlyStorage
-		}
-		return r.defaultLoc.Backend, r.defaultLoc, nil
+		return r.defaultLoc, nil
 	}
 
-	return nil, nil, fmt.Errorf("no storage backend available")
+	return nil, fmt.Errorf("no storage backend available")
 }
 
 // IsReadOnly returns whether a storage location is read-only.
@@ -3,14 +3,17 @@ package s3
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"go.uber.org/zap"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
@@ -25,20 +28,79 @@ type BackendConfig struct {
 	SecretKey string `json:"secret_key"`
 	Region    string `json:"region"`
 	UseSSL    bool   `json:"use_ssl"`
+
+	// PresignDownloads redirects opted-in clients to presigned GET URLs
+	// instead of proxying content through the server.
+	PresignDownloads bool `json:"presign_downloads,omitempty"`
+	// PresignTTLSec is the lifetime of presigned URLs (default 300).
+	PresignTTLSec int `json:"presign_ttl_sec,omitempty"`
+	// PresignEndpoint is the endpoint clients use to reach the bucket when
+	// it differs from Endpoint (e.g. a public hostname for an internal MinIO).
+	PresignEndpoint string `json:"presign_endpoint,omitempty"`
 }
 
+// defaultPresignTTL is used when PresignTTLSec is unset.
+const defaultPresignTTL = 5 * time.Minute
+
 // S3Backend implements storage.Backend using S3/MinIO.
 type S3Backend struct {
 	client *s3.Client
 	bucket string
+
+	presign    *s3.PresignClient // nil unless presigned downloads are enabled
+	presignTTL time.Duration
 }
 
 // NewBackend creates a new S3 backend from a BackendConfig.
 func NewBackend(ctx context.Context, cfg BackendConfig) (*S3Backend, error) {
+	client, err := newClient(ctx, cfg, cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := &S3Backend{
+		client: client,
+		bucket: cfg.Bucket,
+	}
+
+	if err := backend.configurePresign(ctx, cfg); err != nil {
+		return nil, err
+	}
+
+	// Verify bucket exists
+	if err := backend.ensureBucket(ctx); err != nil {
+		logging.Error("bucket check failed", zap.Error(err))
+	}
+
+	return backend, nil
+}
+
+// configurePresign sets up presigned downloads if the config enables them.
+func (b *S3Backend) configurePresign(ctx context.Context, cfg BackendConfig) error {
+	if !cfg.PresignDownloads {
+		return nil
+	}
+	presignClient := b.client
+	if cfg.PresignEndpoint != "" && cfg.PresignEndpoint != cfg.Endpoint {
+		var err error
+		if presignClient, err = newClient(ctx, cfg, cfg.PresignEndpoint); err != nil {
+			return fmt.Errorf("presign endpoint: %w", err)
+		}
+	}
+	b.presign = s3.NewPresignClient(presignClient)
+	b.presignTTL = defaultPresignTTL
+	if cfg.PresignTTLSec > 0 {
+		b.presignTTL = time.Duration(cfg.PresignTTLSec) * time.Second
+	}
+	return nil
+}
+
+// newClient builds an S3 client for the given endpoint.
+func newClient(ctx context.Context, cfg BackendConfig, endpoint string) (*s3.Client, error) {
 	resolver := aws.EndpointResolverWithOptionsFunc(
 		func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 			return aws.Endpoint{
-				URL:               cfg.Endpoint,
+				URL:               endpoint,
 				HostnameImmutable: true,
 			}, nil
 		},
@@ -55,21 +117,9 @@ func NewBackend(ctx context.Context, cfg BackendConfig) (*S3Backend, error) {
 		return nil, fmt.Errorf("load aws config: %w", err)
 	}
 
-	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
 		o.UsePathStyle = true
-	})
-
-	backend := &S3Backend{
-		client: client,
-		bucket: cfg.Bucket,
-	}
-
-	// Verify bucket exists
-	if err := backend.ensureBucket(ctx); err != nil {
-		logging.Error("bucket check failed", zap.Error(err))
-	}
-
-	return backend, nil
+	}), nil
 }
 
 // NewBackendFromJSON creates an S3Backend from raw JSON config.
@@ -212,6 +262,102 @@ func (b *S3Backend) ObjectExists(ctx context.Context, key string) (bool, error)
 	return true, nil
 }
 
+// StatObject returns the size and modification time of an object from a
+// HEAD request.
+func (b *S3Backend) StatObject(ctx context.Context, key string) (int64, time.Time, error) {
+	start := time.Now()
+
+	result, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		metrics.RecordS3Operation("head_object", time.Since(start), false)
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return 0, time.Time{}, fmt.Errorf("stat object %s: %w", key, fs.ErrNotExist)
+		}
+		return 0, time.Time{}, fmt.Errorf("stat object %s: %w", key, err)
+	}
+	metrics.RecordS3Operation("head_object", time.Since(start), true)
+
+	var size int64
+	if result.ContentLength != nil {
+		size = *result.ContentLength
+	}
+	var modTime time.Time
+	if result.LastModified != nil {
+		modTime = *result.LastModified
+	}
+	return size, modTime, nil
+}
+
+// ListObjects pages through the bucket's objects under prefix, 1000 at a
+// time.
+func (b *S3Backend) ListObjects(ctx context.Context, prefix string, fn func(key string, size int64, modTime time.Time) error) error {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			metrics.RecordS3Operation("list_objects", time.Since(start), false)
+			return fmt.Errorf("list objects %s: %w", prefix, err)
+		}
+		metrics.RecordS3Operation("list_objects", time.Since(start), true)
+
+		for _, obj := range page.Contents {
+			var size int64
+			if obj.Size != nil {
+				size = *obj.Size
+			}
+			var modTime time.Time
+			if obj.LastModified != nil {
+				modTime = *obj.LastModified
+			}
+			if err := fn(aws.ToString(obj.Key), size, modTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PresignEnabled reports whether presigned downloads are configured.
+func (b *S3Backend) PresignEnabled() bool {
+	return b.presign != nil
+}
+
+// PresignGetObject returns a presigned GET URL for key that is valid for
+// the location's presign TTL.
+func (b *S3Backend) PresignGetObject(ctx context.Context, key, contentType, contentDisposition string) (string, error) {
+	if b.presign == nil {
+		return "", fmt.Errorf("presigned downloads are disabled")
+	}
+	start := time.Now()
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		input.ResponseContentType = aws.String(contentType)
+	}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+
+	req, err := b.presign.PresignGetObject(ctx, input, s3.WithPresignExpires(b.presignTTL))
+	if err != nil {
+		metrics.RecordS3Operation("presign_get_object", time.Since(start), false)
+		return "", fmt.Errorf("presign %s: %w", key, err)
+	}
+	metrics.RecordS3Operation("presign_get_object", time.Since(start), true)
+	return req.URL, nil
+}
+
 // Type returns "s3".
 func (b *S3Backend) Type() string { return "s3" }
 
@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestBackend(t *testing.T, cfg BackendConfig) *S3Backend {
+	t.Helper()
+	ctx := context.Background()
+	client, err := newClient(ctx, cfg, cfg.Endpoint)
+	if err != nil {
+		t.Fatalf("newClient: %v", err)
+	}
+	b := &S3Backend{client: client, bucket: cfg.Bucket}
+	if err := b.configurePresign(ctx, cfg); err != nil {
+		t.Fatalf("configurePresign: %v", err)
+	}
+	return b
+}
+
+func TestPresignDisabledByDefault(t *testing.T) {
+	b := newTestBackend(t, BackendConfig{
+		Endpoint: "http://minio:9000", Bucket: "fs", AccessKey: "a", SecretKey: "s", Region: "us-east-1",
+	})
+	if b.PresignEnabled() {
+		t.Fatal("presign should be disabled without presign_downloads")
+	}
+	if _, err := b.PresignGetObject(context.Background(), "k", "", ""); err == nil {
+		t.Fatal("PresignGetObject should fail when disabled")
+	}
+}
+
+func TestPresignGetObject(t *testing.T) {
+	b := newTestBackend(t, BackendConfig{
+		Endpoint:         "http://minio:9000",
+		Bucket:           "fs",
+		AccessKey:        "a",
+		SecretKey:        "s",
+		Region:           "us-east-1",
+		PresignDownloads: true,
+		PresignTTLSec:    60,
+		PresignEndpoint:  "https://files.example.com",
+	})
+	if !b.PresignEnabled() {
+		t.Fatal("presign should be enabled")
+	}
+
+	raw, err := b.PresignGetObject(context.Background(), "files/report.pdf",
+		"application/pdf", `attachment; filename="report.pdf"`)
+	if err != nil {
+		t.Fatalf("PresignGetObject: %v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+
+	if u.Host != "files.example.com" || u.Path != "/fs/files/report.pdf" {
+		t.Errorf("URL = %s, want public endpoint with path-style key", raw)
+	}
+	q := u.Query()
+	if q.Get("X-Amz-Expires") != "60" {
+		t.Errorf("X-Amz-Expires = %q, want 60", q.Get("X-Amz-Expires"))
+	}
+	if q.Get("X-Amz-Signature") == "" {
+		t.Error("URL is not signed")
+	}
+	if q.Get("response-content-type") != "application/pdf" ||
+		!strings.Contains(q.Get("response-content-disposition"), "report.pdf") {
+		t.Errorf("response overrides missing: %v", q)
+	}
+}
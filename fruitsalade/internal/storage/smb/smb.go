@@ -21,7 +21,10 @@ type Config struct {
 	MountPath string `json:"mount_path"` // Local mount point where share is mounted
 }
 
-// SMBBackend wraps a LocalBackend at the SMB mount point.
+// SMBBackend wraps a LocalBackend at the SMB mount point. Ranged reads
+// seek in the file, CopyObject streams through a temp file on the share
+// that is renamed into place, and StatObject reads the file's attributes,
+// so SMB locations support versioning and range requests like the others.
 type SMBBackend struct {
 	*local.LocalBackend
 	config Config
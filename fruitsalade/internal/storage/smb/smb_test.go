@@ -0,0 +1,185 @@
+package smb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests run against a plain directory, and against a mounted Samba
+// share when TEST_SMB_MOUNT points at one (see the integration job in CI).
+
+func testBackends(t *testing.T) map[string]*SMBBackend {
+	t.Helper()
+	dirs := map[string]string{"tempdir": t.TempDir()}
+	if mount := os.Getenv("TEST_SMB_MOUNT"); mount != "" {
+		dir, err := os.MkdirTemp(mount, "fstest-")
+		if err != nil {
+			t.Fatalf("create test dir on %s: %v", mount, err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		dirs["samba"] = dir
+	}
+
+	backends := make(map[string]*SMBBackend)
+	for name, dir := range dirs {
+		b, err := New(Config{Server: "//samba/share", MountPath: dir})
+		if err != nil {
+			t.Fatalf("New(%s): %v", dir, err)
+		}
+		backends[name] = b
+	}
+	return backends
+}
+
+func put(t *testing.T, b *SMBBackend, key, data string) {
+	t.Helper()
+	if err := b.PutObject(context.Background(), key, strings.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("PutObject(%s): %v", key, err)
+	}
+}
+
+func get(t *testing.T, b *SMBBackend, key string, offset, length int64) (string, int64) {
+	t.Helper()
+	rc, size, err := b.GetObject(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("GetObject(%s, %d, %d): %v", key, offset, length, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %s: %v", key, err)
+	}
+	return string(data), size
+}
+
+func TestRangedGetObject(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			put(t, b, "files/range.txt", "0123456789")
+
+			tests := []struct {
+				offset, length int64
+				want           string
+			}{
+				{0, 0, "0123456789"},
+				{3, 4, "3456"},
+				{7, 0, "789"},
+				{8, 10, "89"}, // length past the end is clamped
+				{10, 0, ""},
+			}
+			for _, tt := range tests {
+				data, size := get(t, b, "files/range.txt", tt.offset, tt.length)
+				if data != tt.want || size != int64(len(tt.want)) {
+					t.Errorf("GetObject(%d, %d) = %q (size %d), want %q", tt.offset, tt.length, data, size, tt.want)
+				}
+			}
+
+			if _, _, err := b.GetObject(context.Background(), "files/range.txt", 11, 0); err == nil {
+				t.Error("GetObject past the end should fail")
+			}
+		})
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			put(t, b, "files/doc.txt", "version one")
+
+			if err := b.CopyObject(ctx, "files/doc.txt", "_versions/files/doc.txt/1"); err != nil {
+				t.Fatalf("CopyObject: %v", err)
+			}
+			put(t, b, "files/doc.txt", "version two")
+
+			if data, _ := get(t, b, "_versions/files/doc.txt/1", 0, 0); data != "version one" {
+				t.Errorf("copy = %q, want the old content", data)
+			}
+			if data, _ := get(t, b, "files/doc.txt", 0, 0); data != "version two" {
+				t.Errorf("source = %q, want the new content", data)
+			}
+
+			// Copying over an existing object replaces it
+			if err := b.CopyObject(ctx, "files/doc.txt", "_versions/files/doc.txt/1"); err != nil {
+				t.Fatalf("CopyObject over existing: %v", err)
+			}
+			if data, _ := get(t, b, "_versions/files/doc.txt/1", 0, 0); data != "version two" {
+				t.Errorf("overwritten copy = %q", data)
+			}
+
+			if err := b.CopyObject(ctx, "files/missing.txt", "files/dst.txt"); err == nil {
+				t.Error("CopyObject of a missing source should fail")
+			}
+			if ok, _ := b.ObjectExists(ctx, "files/dst.txt"); ok {
+				t.Error("failed copy left a destination behind")
+			}
+		})
+	}
+}
+
+func TestStatObject(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			before := time.Now().Add(-time.Minute)
+			put(t, b, "files/stat.bin", strings.Repeat("x", 1234))
+
+			size, modTime, err := b.StatObject(ctx, "files/stat.bin")
+			if err != nil {
+				t.Fatalf("StatObject: %v", err)
+			}
+			if size != 1234 {
+				t.Errorf("size = %d, want 1234", size)
+			}
+			if modTime.Before(before) {
+				t.Errorf("modTime = %v, want after %v", modTime, before)
+			}
+
+			_, _, err = b.StatObject(ctx, "files/none.bin")
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("StatObject(missing) error = %v, want fs.ErrNotExist", err)
+			}
+			if _, _, err := b.StatObject(ctx, "files"); err == nil {
+				t.Error("StatObject of a directory should fail")
+			}
+
+			if err := b.DeleteObject(ctx, "files/stat.bin"); err != nil {
+				t.Fatalf("DeleteObject: %v", err)
+			}
+			if _, _, err := b.StatObject(ctx, "files/stat.bin"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("StatObject after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestNoTempFilesLeft(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				put(t, b, "dir/f.txt", fmt.Sprint(i))
+				if err := b.CopyObject(context.Background(), "dir/f.txt", "dir/g.txt"); err != nil {
+					t.Fatalf("CopyObject: %v", err)
+				}
+			}
+			entries, err := os.ReadDir(b.config.MountPath + "/dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 {
+				var names []string
+				for _, e := range entries {
+					names = append(names, e.Name())
+				}
+				t.Errorf("dir holds %v, want only f.txt and g.txt", names)
+			}
+		})
+	}
+}
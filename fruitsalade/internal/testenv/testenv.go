@@ -0,0 +1,255 @@
+// Package testenv provides the PostgreSQL server and S3 storage that
+// integration tests run against.
+//
+// TEST_DATABASE_URL and TEST_S3_ENDPOINT select existing services. A
+// service without one is started in a Docker container with a random host
+// port, which is removed by Close. Every database handed out is a new,
+// migrated database, and every bucket has a random name, so test binaries
+// of several packages can share the services and run in parallel.
+package testenv
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+
+	_ "github.com/lib/pq"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	s3storage "github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/s3"
+)
+
+// ErrUnavailable is returned by Start when a service is neither configured
+// nor can be started because Docker is not reachable.
+var ErrUnavailable = errors.New("set TEST_DATABASE_URL and TEST_S3_ENDPOINT or make Docker available")
+
+const (
+	postgresImage = "postgres"
+	postgresTag   = "16-alpine"
+	minioImage    = "minio/minio"
+	minioTag      = "latest"
+
+	minioUser     = "minioadmin"
+	minioPassword = "minioadmin"
+
+	// containerLifetime is how long Docker keeps a container of a test
+	// binary that did not get to call Close.
+	containerLifetime = 15 * time.Minute
+)
+
+// Env is a PostgreSQL server and an S3 endpoint.
+type Env struct {
+	serverURL string // URL of the postgres server's maintenance database
+	s3        s3storage.BackendConfig
+
+	pool      *dockertest.Pool
+	resources []*dockertest.Resource
+
+	mu        sync.Mutex
+	databases []string
+}
+
+// Start connects to the configured services and starts containers for
+// the others. It returns ErrUnavailable if that is not possible.
+func Start(ctx context.Context) (*Env, error) {
+	e := &Env{
+		serverURL: os.Getenv("TEST_DATABASE_URL"),
+		s3: s3storage.BackendConfig{
+			Endpoint:  os.Getenv("TEST_S3_ENDPOINT"),
+			AccessKey: minioUser,
+			SecretKey: minioPassword,
+			Region:    "us-east-1",
+		},
+	}
+	if e.serverURL == "" || e.s3.Endpoint == "" {
+		pool, err := dockertest.NewPool("")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		if err := pool.Client.Ping(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		pool.MaxWait = 2 * time.Minute
+		e.pool = pool
+	}
+
+	if e.serverURL == "" {
+		if err := e.startPostgres(); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	if e.s3.Endpoint == "" {
+		if err := e.startMinIO(); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("postgres", e.serverURL)
+	if err == nil {
+		err = db.PingContext(ctx)
+		db.Close()
+	}
+	if err != nil {
+		e.Close()
+		return nil, fmt.Errorf("test database server not reachable: %w", err)
+	}
+	return e, nil
+}
+
+// run starts a container that is removed when it stops.
+func (e *Env) run(opts *dockertest.RunOptions) (*dockertest.Resource, error) {
+	res, err := e.pool.RunWithOptions(opts, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start %s container: %w", opts.Repository, err)
+	}
+	e.resources = append(e.resources, res)
+	res.Expire(uint(containerLifetime.Seconds()))
+	return res, nil
+}
+
+func (e *Env) startPostgres() error {
+	res, err := e.run(&dockertest.RunOptions{
+		Repository: postgresImage,
+		Tag:        postgresTag,
+		Env: []string{
+			"POSTGRES_USER=fruitsalade",
+			"POSTGRES_PASSWORD=fruitsalade",
+			"POSTGRES_DB=postgres",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	e.serverURL = fmt.Sprintf("postgres://fruitsalade:fruitsalade@%s/postgres?sslmode=disable", res.GetHostPort("5432/tcp"))
+
+	return e.pool.Retry(func() error {
+		db, err := sql.Open("postgres", e.serverURL)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	})
+}
+
+func (e *Env) startMinIO() error {
+	res, err := e.run(&dockertest.RunOptions{
+		Repository: minioImage,
+		Tag:        minioTag,
+		Cmd:        []string{"server", "/data"},
+		Env: []string{
+			"MINIO_ROOT_USER=" + minioUser,
+			"MINIO_ROOT_PASSWORD=" + minioPassword,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	e.s3.Endpoint = "http://" + res.GetHostPort("9000/tcp")
+
+	return e.pool.Retry(func() error {
+		resp, err := http.Get(e.s3.Endpoint + "/minio/health/live")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("minio health: %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// NewDatabase creates a database with the server's migrations applied and
+// returns its URL. It is dropped by Close.
+func (e *Env) NewDatabase(ctx context.Context) (string, error) {
+	name := "fruitsalade_test_" + randomSuffix()
+
+	admin, err := sql.Open("postgres", e.serverURL)
+	if err != nil {
+		return "", err
+	}
+	defer admin.Close()
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+name); err != nil {
+		return "", fmt.Errorf("create database: %w", err)
+	}
+	e.mu.Lock()
+	e.databases = append(e.databases, name)
+	e.mu.Unlock()
+
+	u, err := url.Parse(e.serverURL)
+	if err != nil {
+		return "", fmt.Errorf("parse TEST_DATABASE_URL: %w", err)
+	}
+	u.Path = "/" + name
+	dbURL := u.String()
+
+	store, err := postgres.New(dbURL)
+	if err != nil {
+		return "", err
+	}
+	defer store.Close()
+	if err := store.Migrate(MigrationsDir()); err != nil {
+		return "", fmt.Errorf("migrate: %w", err)
+	}
+	return dbURL, nil
+}
+
+// NewBucket returns the configuration of an S3 location on a bucket of
+// its own. The bucket is created by the backend when first opened.
+func (e *Env) NewBucket() s3storage.BackendConfig {
+	cfg := e.s3
+	cfg.Bucket = "fruitsalade-test-" + randomSuffix()
+	return cfg
+}
+
+// Close drops the databases and removes the containers.
+func (e *Env) Close() {
+	if e.serverURL != "" {
+		e.mu.Lock()
+		databases := e.databases
+		e.databases = nil
+		e.mu.Unlock()
+		if db, err := sql.Open("postgres", e.serverURL); err == nil {
+			for _, name := range databases {
+				db.Exec("DROP DATABASE IF EXISTS " + name + " WITH (FORCE)")
+			}
+			db.Close()
+		}
+	}
+	for _, res := range e.resources {
+		e.pool.Purge(res)
+	}
+	e.resources = nil
+}
+
+// MigrationsDir returns the server's migrations directory.
+func MigrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "migrations")
+}
+
+// randomSuffix returns a name suffix valid in database and bucket names.
+func randomSuffix() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
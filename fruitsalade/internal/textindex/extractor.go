@@ -0,0 +1,86 @@
+// Package textindex extracts plain text from uploaded documents and stores it
+// in a PostgreSQL full-text index for content search.
+package textindex
+
+import (
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MaxTextBytes caps the amount of extracted text stored per file. PostgreSQL
+// tsvectors are limited to 1MB, so longer documents are truncated.
+const MaxTextBytes = 512 * 1024
+
+// Extractor pulls plain text out of a document.
+type Extractor interface {
+	Extract(r io.Reader) (string, error)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]Extractor{
+		".txt": PlainTextExtractor{},
+		".md":  PlainTextExtractor{},
+		".csv": PlainTextExtractor{},
+		".pdf": PDFExtractor{},
+	}
+)
+
+// Register installs an extractor for a file extension (e.g. ".docx"),
+// replacing any existing one.
+func Register(ext string, e Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[strings.ToLower(ext)] = e
+}
+
+// ExtractorFor returns the extractor for a file path, or nil if the type is
+// not indexable.
+func ExtractorFor(path string) Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	return extractors[strings.ToLower(filepath.Ext(path))]
+}
+
+// IsIndexable checks if a file path has a registered extractor.
+func IsIndexable(path string) bool {
+	return ExtractorFor(path) != nil
+}
+
+// Extensions returns the registered file extensions, sorted.
+func Extensions() []string {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	exts := make([]string, 0, len(extractors))
+	for ext := range extractors {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// PlainTextExtractor handles UTF-8 text formats (.txt, .md, .csv).
+type PlainTextExtractor struct{}
+
+// Extract reads up to MaxTextBytes of text.
+func (PlainTextExtractor) Extract(r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxTextBytes))
+	if err != nil {
+		return "", err
+	}
+	return sanitizeText(string(data)), nil
+}
+
+// sanitizeText makes extracted text safe to store in a TEXT column: invalid
+// UTF-8 and NUL bytes are dropped and the result is capped at MaxTextBytes.
+func sanitizeText(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.ReplaceAll(s, "\x00", "")
+	if len(s) > MaxTextBytes {
+		s = strings.ToValidUTF8(s[:MaxTextBytes], "")
+	}
+	return s
+}
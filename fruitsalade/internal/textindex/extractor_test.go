@@ -0,0 +1,88 @@
+package textindex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func buildPDF(content []byte, flate bool) []byte {
+	dict := fmt.Sprintf("<< /Length %d >>", len(content))
+	if flate {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(content)
+		zw.Close()
+		content = buf.Bytes()
+		dict = fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", len(content))
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("4 0 obj\n" + dict + "\nstream\n")
+	pdf.Write(content)
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestPDFExtractor(t *testing.T) {
+	content := []byte("BT /F1 12 Tf 72 712 Td (Quarterly report) Tj 0 -14 Td [(Reve) 20 (nue) -300 (grew)] TJ ET")
+
+	for _, flate := range []bool{false, true} {
+		text, err := PDFExtractor{}.Extract(bytes.NewReader(buildPDF(content, flate)))
+		if err != nil {
+			t.Fatalf("flate=%v: extract: %v", flate, err)
+		}
+		if !strings.Contains(text, "Quarterly report") {
+			t.Errorf("flate=%v: missing Tj text in %q", flate, text)
+		}
+		if !strings.Contains(text, "Revenue grew") {
+			t.Errorf("flate=%v: missing TJ text in %q", flate, text)
+		}
+	}
+}
+
+func TestPDFExtractorEscapes(t *testing.T) {
+	content := []byte(`BT (a \(nested\) \101B) Tj <48656C6C6F> Tj ET`)
+	text, err := PDFExtractor{}.Extract(bytes.NewReader(buildPDF(content, false)))
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if text != "a (nested) ABHello" {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestPDFExtractorRejectsNonPDF(t *testing.T) {
+	if _, err := (PDFExtractor{}).Extract(strings.NewReader("hello")); err == nil {
+		t.Error("expected error for non-PDF input")
+	}
+}
+
+func TestPlainTextExtractorSanitizes(t *testing.T) {
+	text, err := PlainTextExtractor{}.Extract(strings.NewReader("ok\x00 \xffdone"))
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if text != "ok done" {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestIsIndexable(t *testing.T) {
+	cases := map[string]bool{
+		"/docs/a.PDF":   true,
+		"/notes/b.md":   true,
+		"/data/c.csv":   true,
+		"/x/readme.txt": true,
+		"/img/d.jpg":    false,
+		"/noext":        false,
+	}
+	for path, want := range cases {
+		if got := IsIndexable(path); got != want {
+			t.Errorf("IsIndexable(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
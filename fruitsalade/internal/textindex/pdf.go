@@ -0,0 +1,289 @@
+package textindex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PDFExtractor is a best-effort extractor for PDFs that use simple (non-CID)
+// font encodings. It inflates FlateDecode content streams and collects the
+// operands of text-showing operators. Register a different Extractor for
+// ".pdf" to use a full PDF library instead.
+type PDFExtractor struct{}
+
+// Extract returns the text found in the document's content streams.
+func (PDFExtractor) Extract(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("not a PDF document")
+	}
+
+	var out strings.Builder
+	for _, content := range pdfContentStreams(data) {
+		extractTextOps(content, &out)
+		if out.Len() >= MaxTextBytes {
+			break
+		}
+	}
+	return sanitizeText(collapseBlankLines(out.String())), nil
+}
+
+// pdfContentStreams returns the decoded bodies of all streams that look like
+// page content. Fonts, images, xref and object streams are skipped.
+func pdfContentStreams(data []byte) [][]byte {
+	var streams [][]byte
+	pos := 0
+	for {
+		i := bytes.Index(data[pos:], []byte("stream"))
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		pos = start + len("stream")
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+
+		body := pos
+		if body < len(data) && data[body] == '\r' {
+			body++
+		}
+		if body < len(data) && data[body] == '\n' {
+			body++
+		}
+		end := bytes.Index(data[body:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[body : body+end]
+		pos = body + end + len("endstream")
+
+		dictStart := bytes.LastIndex(data[:start], []byte("obj"))
+		if dictStart < 0 {
+			dictStart = 0
+		}
+		dict := data[dictStart:start]
+		if bytes.Contains(dict, []byte("/Subtype")) || bytes.Contains(dict, []byte("/Length1")) ||
+			bytes.Contains(dict, []byte("/Type")) {
+			continue
+		}
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			// Keep whatever inflated cleanly; truncated streams are common.
+			decoded, _ := io.ReadAll(io.LimitReader(zr, 16*MaxTextBytes))
+			zr.Close()
+			streams = append(streams, decoded)
+		case bytes.Contains(dict, []byte("/Filter")):
+			// Unsupported filter (LZW, DCT, ...)
+		default:
+			streams = append(streams, raw)
+		}
+	}
+	return streams
+}
+
+// extractTextOps scans a content stream and writes the strings shown by the
+// Tj, TJ, ' and " operators. Line-positioning operators start a new line.
+func extractTextOps(content []byte, out *strings.Builder) {
+	var pending strings.Builder
+	inArray := false
+
+	newline := func() {
+		s := out.String()
+		if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+			out.WriteByte('\n')
+		}
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := readLiteralString(content[i:])
+			pending.WriteString(s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			s, n := readHexString(content[i:])
+			pending.WriteString(s)
+			i += n
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '/':
+			i++
+			for i < len(content) && isRegularByte(content[i]) {
+				i++
+			}
+		case isRegularByte(c):
+			startTok := i
+			for i < len(content) && isRegularByte(content[i]) {
+				i++
+			}
+			tok := string(content[startTok:i])
+			if f, err := strconv.ParseFloat(tok, 64); err == nil {
+				// Large negative kerning inside a TJ array is a word gap
+				if inArray && f < -200 {
+					pending.WriteByte(' ')
+				}
+				continue
+			}
+			switch tok {
+			case "Tj", "TJ":
+				out.WriteString(pending.String())
+			case "'", "\"":
+				newline()
+				out.WriteString(pending.String())
+			case "T*", "Td", "TD", "ET":
+				newline()
+			}
+			pending.Reset()
+		default:
+			i++
+		}
+	}
+	newline()
+}
+
+// readLiteralString decodes a (...) string starting at b[0] and returns the
+// text and the number of bytes consumed.
+func readLiteralString(b []byte) (string, int) {
+	var buf []byte
+	depth := 0
+	i := 0
+	for i < len(b) {
+		c := b[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				buf = append(buf, c)
+			}
+			depth++
+			i++
+		case ')':
+			depth--
+			i++
+			if depth == 0 {
+				return pdfBytesToText(buf), i
+			}
+			buf = append(buf, c)
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			e := b[i]
+			switch e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+				if e == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+					i++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					j := 0
+					for j < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7' {
+						v = v*8 + int(b[i]-'0')
+						i++
+						j++
+					}
+					buf = append(buf, byte(v))
+					continue
+				}
+				buf = append(buf, e)
+			}
+			i++
+		default:
+			buf = append(buf, c)
+			i++
+		}
+	}
+	return pdfBytesToText(buf), i
+}
+
+// readHexString decodes a <...> string starting at b[0].
+func readHexString(b []byte) (string, int) {
+	end := bytes.IndexByte(b, '>')
+	if end < 0 {
+		return "", len(b)
+	}
+	var digits []byte
+	for _, c := range b[1:end] {
+		if unicode.Is(unicode.ASCII_Hex_Digit, rune(c)) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	buf := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		v, _ := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		buf = append(buf, byte(v))
+	}
+	return pdfBytesToText(buf), end + 1
+}
+
+// pdfBytesToText maps string bytes to runes (PDFDocEncoding is close enough
+// to Latin-1 for indexing) and drops control characters.
+func pdfBytesToText(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		r := rune(c)
+		if r == '\n' || r == '\t' || unicode.IsPrint(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func isRegularByte(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0,
+		'(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+func collapseBlankLines(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			kept = append(kept, l)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,155 @@
+package textindex
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+)
+
+// Processor extracts and indexes document text in the background.
+type Processor struct {
+	store         *Store
+	storageRouter *storage.Router
+	queue         chan string
+	wg            sync.WaitGroup
+	cancel        context.CancelFunc
+	workers       int
+	maxSize       int64
+}
+
+// NewProcessor creates a new text index processor. Files larger than maxSize
+// bytes are skipped (0 = no limit).
+func NewProcessor(store *Store, router *storage.Router, workers int, maxSize int64) *Processor {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Processor{
+		store:         store,
+		storageRouter: router,
+		queue:         make(chan string, 1000),
+		workers:       workers,
+		maxSize:       maxSize,
+	}
+}
+
+// Start launches the worker goroutines.
+func (p *Processor) Start(ctx context.Context) {
+	ctx, p.cancel = context.WithCancel(ctx)
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx)
+	}
+	logging.Info("text index processor started",
+		zap.Int("workers", p.workers),
+		zap.Int64("max_size", p.maxSize))
+}
+
+// Stop signals workers to stop and waits for them to finish.
+func (p *Processor) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	close(p.queue)
+	p.wg.Wait()
+	logging.Info("text index processor stopped")
+}
+
+// Enqueue adds a file path to the indexing queue.
+func (p *Processor) Enqueue(filePath string) {
+	select {
+	case p.queue <- filePath:
+	default:
+		logging.Warn("text index queue full, dropping", zap.String("path", filePath))
+	}
+}
+
+// ProcessExisting finds indexable files that have not been indexed yet and
+// enqueues them.
+func (p *Processor) ProcessExisting(ctx context.Context) {
+	paths, err := p.store.ListUnindexed(ctx, Extensions(), p.maxSize, 1000)
+	if err != nil {
+		logging.Warn("failed to list unindexed files", zap.Error(err))
+		return
+	}
+	for _, path := range paths {
+		p.Enqueue(path)
+	}
+	if len(paths) > 0 {
+		logging.Info("text index: enqueued existing files", zap.Int("count", len(paths)))
+	}
+}
+
+func (p *Processor) worker(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case filePath, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			p.processFile(ctx, filePath)
+		}
+	}
+}
+
+func (p *Processor) processFile(ctx context.Context, filePath string) {
+	extractor := ExtractorFor(filePath)
+	if extractor == nil {
+		return
+	}
+
+	info, err := p.store.GetFileInfo(ctx, filePath)
+	if err != nil {
+		logging.Warn("text index: failed to look up file", zap.String("path", filePath), zap.Error(err))
+		return
+	}
+	if info == nil {
+		return
+	}
+	if p.maxSize > 0 && info.Size > p.maxSize {
+		logging.Debug("text index: skipping large file",
+			zap.String("path", filePath), zap.Int64("size", info.Size))
+		return
+	}
+
+	backend, _, err := p.storageRouter.ResolveForRead(ctx, info.StorageLocID, info.GroupID)
+	if err != nil {
+		logging.Warn("text index: no storage backend", zap.String("path", filePath), zap.Error(err))
+		return
+	}
+
+	reader, _, err := backend.GetObject(ctx, info.S3Key, 0, 0)
+	if err != nil {
+		logging.Warn("text index: failed to read file", zap.String("path", filePath), zap.Error(err))
+		p.store.UpsertContent(ctx, filePath, "", "failed")
+		return
+	}
+
+	var r io.Reader = reader
+	if p.maxSize > 0 {
+		r = io.LimitReader(reader, p.maxSize)
+	}
+	text, err := extractor.Extract(r)
+	reader.Close()
+	if err != nil {
+		logging.Warn("text index: extraction failed", zap.String("path", filePath), zap.Error(err))
+		p.store.UpsertContent(ctx, filePath, "", "failed")
+		return
+	}
+
+	if err := p.store.UpsertContent(ctx, filePath, text, "done"); err != nil {
+		logging.Warn("text index: failed to save content", zap.String("path", filePath), zap.Error(err))
+		return
+	}
+
+	logging.Debug("text index: indexed file",
+		zap.String("path", filePath),
+		zap.Int("chars", len(text)))
+}
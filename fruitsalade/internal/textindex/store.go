@@ -0,0 +1,196 @@
+package textindex
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+)
+
+// Store provides access to the file_contents table.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a new Store.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// FileInfo holds the storage location of a file to be indexed.
+type FileInfo struct {
+	S3Key        string
+	Size         int64
+	StorageLocID *int
+	GroupID      *int
+}
+
+// GetFileInfo returns storage information for a live, non-directory file.
+func (s *Store) GetFileInfo(ctx context.Context, filePath string) (*FileInfo, error) {
+	var fi FileInfo
+	var slid, gid sql.NullInt64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT s3_key, size, storage_location_id, group_id FROM files
+		 WHERE path = $1 AND is_dir = FALSE AND deleted_at IS NULL`, filePath).
+		Scan(&fi.S3Key, &fi.Size, &slid, &gid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get file info: %w", err)
+	}
+	if slid.Valid {
+		id := int(slid.Int64)
+		fi.StorageLocID = &id
+	}
+	if gid.Valid {
+		id := int(gid.Int64)
+		fi.GroupID = &id
+	}
+	return &fi, nil
+}
+
+// UpsertContent stores the extracted text for a file. status is "done" or
+// "failed".
+func (s *Store) UpsertContent(ctx context.Context, filePath, content, status string) error {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("upsert_file_content", time.Since(start)) }()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO file_contents (file_path, content, status, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (file_path) DO UPDATE SET
+			content = EXCLUDED.content, status = EXCLUDED.status, updated_at = NOW()`,
+		filePath, content, status)
+	if err != nil {
+		if strings.Contains(err.Error(), "violates foreign key") {
+			// File was deleted before we got to it
+			return nil
+		}
+		return fmt.Errorf("upsert file content: %w", err)
+	}
+	return nil
+}
+
+// ListUnindexed returns paths of indexable files without a file_contents row.
+func (s *Store) ListUnindexed(ctx context.Context, extensions []string, maxSize int64, limit int) ([]string, error) {
+	var likeConds []string
+	var args []interface{}
+	for i, ext := range extensions {
+		likeConds = append(likeConds, fmt.Sprintf("LOWER(f.name) LIKE $%d", i+1))
+		args = append(args, "%"+ext)
+	}
+	if len(likeConds) == 0 {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT f.path FROM files f
+		LEFT JOIN file_contents fc ON fc.file_path = f.path
+		WHERE f.is_dir = FALSE AND f.deleted_at IS NULL AND fc.file_path IS NULL
+		AND ($%d <= 0 OR f.size <= $%d)
+		AND (%s)
+		ORDER BY f.mod_time DESC
+		LIMIT $%d`, len(args)+1, len(args)+1, strings.Join(likeConds, " OR "), len(args)+2)
+	args = append(args, maxSize, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		// Table might not exist yet during migration
+		if strings.Contains(err.Error(), "does not exist") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list unindexed: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+// ts_headline marks matches with these private-use characters rather than
+// with HTML, which would be mixed with the unescaped file content.
+const (
+	markStart = "\uE000"
+	markStop  = "\uE001"
+)
+
+// markTags turns the match markers into <mark> tags.
+var markTags = strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+
+// highlight returns a ts_headline snippet as HTML: the file content
+// escaped, with matches in <mark> tags.
+func highlight(snippet string) string {
+	return markTags.Replace(html.EscapeString(snippet))
+}
+
+// SearchResult is a file matching a content query.
+type SearchResult struct {
+	ID      string
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Snippet string
+}
+
+// Search runs a full-text query against indexed file contents. Snippets
+// are HTML, the escaped text with matches in <mark> tags. pf restricts
+// results to files the caller can read; nil means no filtering (admin).
+// pf must be built with argStart 2.
+func (s *Store) Search(ctx context.Context, query string, pf *gallery.PermFilter, limit int) ([]SearchResult, error) {
+	start := time.Now()
+	defer func() { metrics.RecordDBQuery("search_file_contents", time.Since(start)) }()
+
+	if limit <= 0 || limit > 200 {
+		limit = 200
+	}
+
+	args := []interface{}{query}
+	permWhere := ""
+	if pf != nil {
+		permWhere = " AND " + pf.Condition
+		args = append(args, pf.Args...)
+	}
+	args = append(args, limit)
+
+	sqlQuery := fmt.Sprintf(`
+		SELECT f.id, f.name, f.path, f.size, f.mod_time,
+		       ts_headline('simple', fc.content, q,
+		                   'StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=5')
+		FROM file_contents fc
+		JOIN files f ON f.path = fc.file_path,
+		     websearch_to_tsquery('simple', $1) q
+		WHERE fc.tsv @@ q AND f.deleted_at IS NULL%s
+		ORDER BY ts_rank(fc.tsv, q) DESC, f.mod_time DESC
+		LIMIT $%d`, markStart, markStop, permWhere, len(args))
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("content search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ID, &r.Name, &r.Path, &r.Size, &r.ModTime, &r.Snippet); err != nil {
+			return nil, fmt.Errorf("scan content search: %w", err)
+		}
+		r.Snippet = highlight(r.Snippet)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,11 @@
+package textindex
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	snippet := `<script>alert("x")</script> & the ` + markStart + "report" + markStop + " <b>"
+	want := `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; the <mark>report</mark> &lt;b&gt;`
+	if got := highlight(snippet); got != want {
+		t.Errorf("highlight = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,298 @@
+package thumbs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+)
+
+// RetryAfter is the delay suggested to clients while a thumbnail is being
+// generated.
+const RetryAfter = 2 * time.Second
+
+const (
+	// failedTTL is how long a failed render is remembered before it is
+	// attempted again.
+	failedTTL = 10 * time.Minute
+
+	// maxImageSource caps the size of images decoded in memory.
+	maxImageSource = 100 << 20
+)
+
+// Status is the outcome of a thumbnail request that missed the cache.
+type Status int
+
+const (
+	// StatusPending means the thumbnail is queued or being rendered.
+	StatusPending Status = iota
+	// StatusUnsupported means no renderer handles the file type.
+	StatusUnsupported
+	// StatusFailed means rendering failed recently and is not retried yet.
+	StatusFailed
+)
+
+// Job describes a thumbnail to render.
+type Job struct {
+	Path     string          // virtual file path
+	S3Key    string          // content object key
+	FileSize int64           // content size in bytes
+	Size     int             // thumbnail bounding box
+	Key      string          // thumbnail object key (see Key)
+	Backend  storage.Backend // backend holding both content and thumbnail
+}
+
+// Generator renders thumbnails on background workers. Requests for the same
+// thumbnail are coalesced while it is pending.
+type Generator struct {
+	galleryStore  *gallery.GalleryStore
+	storageRouter *storage.Router
+	video         *gallery.VideoProber
+	pdf           *PDFRenderer
+
+	queue   chan Job
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+	workers int
+
+	mu      sync.Mutex
+	pending map[string]bool
+	failed  map[string]time.Time
+}
+
+// NewGenerator creates a thumbnail generator. When galleryStore is set,
+// existing gallery thumbnails are used as the source for small sizes
+// instead of decoding the original, and generated thumbnails are recorded
+// there so they count toward the file owner's storage.
+func NewGenerator(galleryStore *gallery.GalleryStore, router *storage.Router, workers int) *Generator {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Generator{
+		galleryStore:  galleryStore,
+		storageRouter: router,
+		video:         gallery.NewVideoProber(),
+		pdf:           NewPDFRenderer(),
+		queue:         make(chan Job, 500),
+		workers:       workers,
+		pending:       make(map[string]bool),
+		failed:        make(map[string]time.Time),
+	}
+}
+
+// Start launches the worker goroutines.
+func (g *Generator) Start(ctx context.Context) {
+	ctx, g.cancel = context.WithCancel(ctx)
+	for i := 0; i < g.workers; i++ {
+		g.wg.Add(1)
+		go g.worker(ctx)
+	}
+	logging.Info("thumbnail generator started",
+		zap.Int("workers", g.workers),
+		zap.Bool("pdf", g.pdf.Available()),
+		zap.Bool("video", g.video.CanExtractPoster()))
+}
+
+// Stop signals workers to stop and waits for them to finish.
+func (g *Generator) Stop() {
+	if g.cancel != nil {
+		g.cancel()
+	}
+	close(g.queue)
+	g.wg.Wait()
+	logging.Info("thumbnail generator stopped")
+}
+
+// Supports reports whether a thumbnail can be rendered for the path with
+// the tools available on this host.
+func (g *Generator) Supports(path string) bool {
+	switch KindOf(path) {
+	case KindImage, KindText:
+		return true
+	case KindVideo:
+		return g.video.CanExtractPoster() || g.galleryStore != nil
+	case KindPDF:
+		return g.pdf.Available()
+	}
+	return false
+}
+
+// Request queues a thumbnail that is not in the cache yet.
+func (g *Generator) Request(job Job) Status {
+	if !g.Supports(job.Path) {
+		return StatusUnsupported
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if at, ok := g.failed[job.Key]; ok {
+		if time.Since(at) < failedTTL {
+			return StatusFailed
+		}
+		delete(g.failed, job.Key)
+	}
+	if g.pending[job.Key] {
+		return StatusPending
+	}
+
+	select {
+	case g.queue <- job:
+		g.pending[job.Key] = true
+	default:
+		// Not marked pending, so the next request tries again
+		logging.Warn("thumbnail queue full, dropping", zap.String("path", job.Path))
+	}
+	return StatusPending
+}
+
+func (g *Generator) worker(ctx context.Context) {
+	defer g.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-g.queue:
+			if !ok {
+				return
+			}
+			g.process(ctx, job)
+		}
+	}
+}
+
+func (g *Generator) process(ctx context.Context, job Job) {
+	data, err := g.render(ctx, job)
+	if err == nil {
+		err = job.Backend.PutObject(ctx, job.Key, bytes.NewReader(data), int64(len(data)))
+	}
+
+	g.mu.Lock()
+	delete(g.pending, job.Key)
+	if err != nil {
+		g.failed[job.Key] = time.Now()
+		g.pruneFailedLocked()
+	}
+	g.mu.Unlock()
+
+	if err != nil {
+		logging.Warn("thumbnail generation failed",
+			zap.String("path", job.Path), zap.Int("size", job.Size), zap.Error(err))
+		return
+	}
+	if g.galleryStore != nil {
+		if err := g.galleryStore.RecordThumbnail(ctx, job.Path, job.Key, int64(len(data))); err != nil {
+			logging.Warn("failed to record thumbnail size", zap.String("path", job.Path), zap.Error(err))
+		}
+	}
+	logging.Debug("thumbnail generated",
+		zap.String("path", job.Path), zap.Int("size", job.Size), zap.Int("bytes", len(data)))
+}
+
+// pruneFailedLocked drops expired failure records. Caller holds g.mu.
+func (g *Generator) pruneFailedLocked() {
+	if len(g.failed) < 1000 {
+		return
+	}
+	for key, at := range g.failed {
+		if time.Since(at) >= failedTTL {
+			delete(g.failed, key)
+		}
+	}
+}
+
+func (g *Generator) render(ctx context.Context, job Job) ([]byte, error) {
+	kind := KindOf(job.Path)
+
+	// Gallery thumbnails are already oriented and at most ThumbMaxSize, so
+	// they make a cheap source for smaller images and video posters.
+	if (kind == KindImage || kind == KindVideo) && job.Size <= gallery.ThumbMaxSize {
+		if data := g.galleryThumb(ctx, job.Path); data != nil {
+			return RenderImage(data, job.Size)
+		}
+	}
+
+	switch kind {
+	case KindImage:
+		if job.FileSize > maxImageSource {
+			return nil, fmt.Errorf("image too large (%d bytes)", job.FileSize)
+		}
+		reader, _, err := job.Backend.GetObject(ctx, job.S3Key, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(io.LimitReader(reader, maxImageSource))
+		if err != nil {
+			return nil, err
+		}
+		return RenderImage(data, job.Size)
+
+	case KindText:
+		var length int64
+		if job.FileSize > textPreviewBytes {
+			length = textPreviewBytes
+		}
+		reader, _, err := job.Backend.GetObject(ctx, job.S3Key, 0, length)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return RenderText(reader, job.Size)
+
+	case KindPDF:
+		tmpPath, err := gallery.SpoolToTemp(ctx, job.Backend, job.S3Key)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmpPath)
+		return g.pdf.Render(ctx, tmpPath, job.Size)
+
+	case KindVideo:
+		tmpPath, err := gallery.SpoolToTemp(ctx, job.Backend, job.S3Key)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmpPath)
+		frame, err := g.video.PosterFrame(ctx, tmpPath, 0)
+		if err != nil {
+			return nil, err
+		}
+		return RenderImage(frame, job.Size)
+	}
+	return nil, fmt.Errorf("unsupported file type")
+}
+
+// galleryThumb returns the gallery thumbnail for a path, or nil.
+func (g *Generator) galleryThumb(ctx context.Context, path string) []byte {
+	if g.galleryStore == nil || g.storageRouter == nil {
+		return nil
+	}
+	thumbKey := g.galleryStore.GetThumbKey(ctx, path)
+	if thumbKey == "" {
+		return nil
+	}
+	backend, _, err := g.storageRouter.GetDefault()
+	if err != nil {
+		return nil
+	}
+	reader, _, err := backend.GetObject(ctx, thumbKey, 0, 0)
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil
+	}
+	return data
+}
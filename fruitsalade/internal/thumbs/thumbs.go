@@ -0,0 +1,277 @@
+// Package thumbs renders preview thumbnails for files of any supported type
+// (images, videos, PDFs, plain text) and caches them next to the content in
+// the file's storage backend.
+package thumbs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/gallery"
+)
+
+// DefaultSize is the thumbnail bounding box used when none is requested.
+const DefaultSize = 256
+
+// Sizes are the bounding boxes (in pixels) clients may request. Keeping the
+// set small bounds the number of cached variants per file.
+var Sizes = []int{64, 128, 256, 512}
+
+// Quality is the JPEG quality of generated thumbnails.
+const Quality = 80
+
+// Kinds of source files a thumbnail can be rendered from.
+const (
+	KindImage = "image"
+	KindVideo = "video"
+	KindPDF   = "pdf"
+	KindText  = "text"
+)
+
+// textExtensions are rendered as a preview of their first lines.
+var textExtensions = []string{
+	".txt", ".md", ".log", ".csv", ".tsv", ".json", ".yaml", ".yml", ".toml",
+	".ini", ".conf", ".xml", ".html", ".css", ".sql", ".sh",
+	".go", ".py", ".js", ".ts", ".c", ".h", ".cpp", ".java", ".rs", ".rb",
+}
+
+const (
+	// textPreviewBytes is how much of a text file is read for its preview.
+	textPreviewBytes = 4096
+	textPreviewLines = 32
+	textPreviewCols  = 64
+
+	pdfToolTimeout = time.Minute
+)
+
+// ParseSize validates a requested thumbnail size. An empty string selects
+// DefaultSize.
+func ParseSize(s string) (int, error) {
+	if s == "" {
+		return DefaultSize, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	for _, size := range Sizes {
+		if n == size {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported size %d (allowed: %s)", n, sizeList())
+}
+
+func sizeList() string {
+	parts := make([]string, len(Sizes))
+	for i, s := range Sizes {
+		parts[i] = strconv.Itoa(s)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ContentID identifies a file's content for cache keys. It is the content
+// hash when known; files stored before hashing fall back to their storage
+// key and version so a new version still gets a new thumbnail.
+func ContentID(hash, s3Key string, version int) string {
+	if hash != "" {
+		return hash
+	}
+	sum := sha256.Sum256([]byte(s3Key))
+	return fmt.Sprintf("k%s-v%d", hex.EncodeToString(sum[:8]), version)
+}
+
+// Key returns the storage key of a cached thumbnail. Keys are derived from
+// the content, not the path, so edits invalidate the cache automatically
+// and identical files share one thumbnail.
+func Key(contentID string, size int) string {
+	return fmt.Sprintf("_thumbs/%s_%d.jpg", contentID, size)
+}
+
+// KindOf returns the kind of thumbnail source for a path, or "" if the type
+// has no renderer.
+func KindOf(path string) string {
+	switch {
+	case gallery.IsImageFile(path):
+		return KindImage
+	case gallery.IsVideoFile(path):
+		return KindVideo
+	case strings.EqualFold(filepath.Ext(path), ".pdf"):
+		return KindPDF
+	case isTextFile(path):
+		return KindText
+	}
+	return ""
+}
+
+func isTextFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range textExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// encode fits img into a size x size box and encodes it as JPEG.
+func encode(img image.Image, size int) ([]byte, error) {
+	thumb := imaging.Fit(img, size, size, imaging.Lanczos)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderImage decodes an image, applies EXIF orientation and returns a JPEG
+// thumbnail.
+func RenderImage(data []byte, size int) ([]byte, error) {
+	orientation := 1
+	if ex, _ := gallery.ExtractExif(bytes.NewReader(data)); ex != nil && ex.Orientation > 0 {
+		orientation = ex.Orientation
+	}
+	thumb, _, _, err := gallery.GenerateThumbnailSize(bytes.NewReader(data), orientation, size)
+	return thumb, err
+}
+
+// RenderText draws the first lines of a text file onto a page-shaped
+// canvas. Binary content is rejected.
+func RenderText(r io.Reader, size int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, textPreviewBytes))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, fmt.Errorf("binary content")
+	}
+
+	face := basicfont.Face7x13
+	const margin = 12
+	lineHeight := face.Metrics().Height.Ceil()
+	width := margin*2 + textPreviewCols*face.Advance
+	height := width * 4 / 3
+
+	page := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+
+	d := &font.Drawer{
+		Dst:  page,
+		Src:  image.NewUniform(color.RGBA{0x33, 0x33, 0x33, 0xff}),
+		Face: face,
+	}
+	y := margin + face.Ascent
+	for _, line := range previewLines(data) {
+		if y > height-margin {
+			break
+		}
+		d.Dot = fixed.P(margin, y)
+		d.DrawString(line)
+		y += lineHeight
+	}
+	return encode(page, size)
+}
+
+// previewLines splits text into display lines: tabs are expanded, control
+// characters and invalid UTF-8 are replaced, and long lines are cut.
+func previewLines(data []byte) []string {
+	// A truncated multi-byte sequence at the read limit is not an error
+	for len(data) > 0 && !utf8.Valid(data) {
+		r, n := utf8.DecodeLastRune(data)
+		if r != utf8.RuneError || n > 1 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+
+	var lines []string
+	for _, raw := range strings.Split(string(data), "\n") {
+		if len(lines) == textPreviewLines {
+			break
+		}
+		raw = strings.ReplaceAll(strings.TrimRight(raw, "\r"), "\t", "    ")
+		var b strings.Builder
+		cols := 0
+		for _, r := range raw {
+			if cols == textPreviewCols {
+				break
+			}
+			// basicfont only covers printable ASCII
+			if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+				r = '?'
+			}
+			b.WriteRune(r)
+			cols++
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// PDFRenderer rasterizes the first page of a PDF with pdftoppm (poppler).
+type PDFRenderer struct {
+	pdftoppm string
+}
+
+// NewPDFRenderer looks up pdftoppm on PATH.
+func NewPDFRenderer() *PDFRenderer {
+	r := &PDFRenderer{}
+	if p, err := exec.LookPath("pdftoppm"); err == nil {
+		r.pdftoppm = p
+	}
+	return r
+}
+
+// Available reports whether PDF thumbnails can be rendered.
+func (r *PDFRenderer) Available() bool {
+	return r.pdftoppm != ""
+}
+
+// Render returns a JPEG thumbnail of the first page of the PDF at path.
+func (r *PDFRenderer) Render(ctx context.Context, path string, size int) ([]byte, error) {
+	if r.pdftoppm == "" {
+		return nil, fmt.Errorf("pdftoppm not available")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pdfToolTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, r.pdftoppm,
+		"-f", "1", "-l", "1",
+		"-singlefile",
+		"-jpeg",
+		"-scale-to", strconv.Itoa(size),
+		path)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	img, _, err := image.Decode(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("decode pdftoppm output: %w", err)
+	}
+	return encode(img, size)
+}
@@ -0,0 +1,196 @@
+package thumbs
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage/local"
+)
+
+func TestParseSize(t *testing.T) {
+	if n, err := ParseSize(""); err != nil || n != DefaultSize {
+		t.Errorf("ParseSize(\"\") = %d, %v; want %d", n, err, DefaultSize)
+	}
+	if n, err := ParseSize("128"); err != nil || n != 128 {
+		t.Errorf("ParseSize(128) = %d, %v", n, err)
+	}
+	for _, s := range []string{"100", "0", "-64", "big", "4096"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%q) should fail", s)
+		}
+	}
+}
+
+func TestKeyAndContentID(t *testing.T) {
+	if got := Key("abc123", 256); got != "_thumbs/abc123_256.jpg" {
+		t.Errorf("Key = %q", got)
+	}
+	if got := ContentID("abc123", "files/a.txt", 3); got != "abc123" {
+		t.Errorf("ContentID with hash = %q", got)
+	}
+
+	// Without a hash the key changes with the version
+	v1 := ContentID("", "files/a.txt", 1)
+	v2 := ContentID("", "files/a.txt", 2)
+	if v1 == v2 || !strings.HasPrefix(v1, "k") {
+		t.Errorf("ContentID fallback = %q, %q", v1, v2)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	tests := map[string]string{
+		"/a/photo.JPG":  KindImage,
+		"/a/clip.mp4":   KindVideo,
+		"/a/report.pdf": KindPDF,
+		"/a/notes.md":   KindText,
+		"/a/main.go":    KindText,
+		"/a/archive.7z": "",
+		"/a/noext":      "",
+	}
+	for path, want := range tests {
+		if got := KindOf(path); got != want {
+			t.Errorf("KindOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func decodeJPEG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("output is not a JPEG: %v", err)
+	}
+	return img
+}
+
+func TestRenderImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			src.Set(x, y, color.RGBA{uint8(x), uint8(y), 0x80, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, src)
+
+	out, err := RenderImage(buf.Bytes(), 128)
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	b := decodeJPEG(t, out).Bounds()
+	if b.Dx() != 128 || b.Dy() != 64 {
+		t.Errorf("thumbnail is %dx%d, want 128x64", b.Dx(), b.Dy())
+	}
+
+	if _, err := RenderImage([]byte("not an image"), 128); err == nil {
+		t.Error("RenderImage should fail on garbage")
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	out, err := RenderText(strings.NewReader("# Notes\n\tindented\nline three\n"), 256)
+	if err != nil {
+		t.Fatalf("RenderText: %v", err)
+	}
+	b := decodeJPEG(t, out).Bounds()
+	if b.Dy() != 256 || b.Dx() >= b.Dy() {
+		t.Errorf("text preview is %dx%d, want portrait 256 high", b.Dx(), b.Dy())
+	}
+
+	if _, err := RenderText(bytes.NewReader([]byte{'a', 0, 'b'}), 256); err == nil {
+		t.Error("RenderText should reject binary content")
+	}
+}
+
+func TestPreviewLines(t *testing.T) {
+	long := strings.Repeat("x", textPreviewCols+10)
+	lines := previewLines([]byte("a\tb\r\n" + long + "\nhé\n"))
+	if lines[0] != "a    b" {
+		t.Errorf("tab/CR handling: %q", lines[0])
+	}
+	if len(lines[1]) != textPreviewCols {
+		t.Errorf("long line not cut: %d chars", len(lines[1]))
+	}
+	if lines[2] != "h?" {
+		t.Errorf("non-ASCII not replaced: %q", lines[2])
+	}
+
+	// A multi-byte rune split by the read limit is dropped
+	lines = previewLines([]byte("ok\xc3"))
+	if lines[0] != "ok" {
+		t.Errorf("truncated rune: %q", lines[0])
+	}
+
+	many := strings.Repeat("line\n", textPreviewLines*2)
+	if n := len(previewLines([]byte(many))); n != textPreviewLines {
+		t.Errorf("got %d lines, want %d", n, textPreviewLines)
+	}
+}
+
+func TestGeneratorRendersAndCoalesces(t *testing.T) {
+	backend, err := local.New(local.Config{RootPath: t.TempDir(), CreateDirs: true})
+	if err != nil {
+		t.Fatalf("local backend: %v", err)
+	}
+	ctx := context.Background()
+	content := "hello thumbnails\n"
+	if err := backend.PutObject(ctx, "files/notes.txt", strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	g := NewGenerator(nil, nil, 1)
+
+	if st := g.Request(Job{Path: "/archive.7z", Key: "x", Backend: backend}); st != StatusUnsupported {
+		t.Errorf("unsupported type: status %v", st)
+	}
+
+	job := Job{
+		Path:     "/notes.txt",
+		S3Key:    "files/notes.txt",
+		FileSize: int64(len(content)),
+		Size:     64,
+		Key:      Key("h1", 64),
+		Backend:  backend,
+	}
+	// Queued before the workers start, so both requests see it pending
+	if st := g.Request(job); st != StatusPending {
+		t.Fatalf("first request: status %v", st)
+	}
+	if st := g.Request(job); st != StatusPending || len(g.queue) != 1 {
+		t.Fatalf("second request: status %v, queue %d (want coalesced)", st, len(g.queue))
+	}
+
+	g.Start(ctx)
+	defer g.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if ok, _ := backend.ObjectExists(ctx, job.Key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("thumbnail was not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A source that cannot be read is remembered as failed
+	bad := job
+	bad.S3Key = "files/missing.txt"
+	bad.Key = Key("h2", 64)
+	g.Request(bad)
+	deadline = time.Now().Add(5 * time.Second)
+	for g.Request(bad) != StatusFailed {
+		if time.Now().After(deadline) {
+			t.Fatal("failed render was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
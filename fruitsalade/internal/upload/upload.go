@@ -0,0 +1,430 @@
+// Package upload stores uploaded content as a new file or a new version of
+// an existing one. The HTTP API, WebDAV and SFTP frontends share it so that
+// versioning, conflict detection, ownership and quotas work the same way
+// whichever way a file arrives.
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+)
+
+var (
+	ErrTooLarge      = errors.New("file too large")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrIsDir         = errors.New("is a directory")
+	ErrExists        = errors.New("file already exists")
+)
+
+// ChecksumError reports that the uploaded content does not match the hash
+// the client declared for it, most likely because it was corrupted in
+// transit.
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: declared %s, received %s", e.Expected, e.Actual)
+}
+
+// ValidSHA256 reports whether s is a hex SHA-256 as accepted for
+// Request.SHA256.
+func ValidSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ConflictError reports that a file changed since the client last saw it.
+type ConflictError struct {
+	Reason          string
+	ExpectedVersion int
+	CurrentVersion  int
+	CurrentHash     string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// Request is a file to store.
+type Request struct {
+	Path    string
+	Content io.ReadSeeker
+	Size    int64
+	Claims  *auth.Claims // nil when auth is disabled
+
+	// Preconditions, checked against the existing file. Zero values skip
+	// the check.
+	ExpectedVersion int
+	IfMatch         string // expected hash, possibly quoted, or "*"
+
+	// CreateOnly stores the file only if nothing, live or trashed, is at
+	// Path yet; otherwise Store returns ErrExists. The path is claimed
+	// before the content is stored, so of two uploads racing for it one
+	// fails rather than replacing the other.
+	CreateOnly bool
+
+	// SHA256 is the hex hash the client computed for Content. If set and
+	// the received content differs, nothing is stored and Store returns a
+	// *ChecksumError.
+	SHA256 string
+}
+
+// Result describes a stored file.
+type Result struct {
+	Path     string
+	Size     int64
+	Hash     string
+	Version  int
+	Created  bool
+	Existing *postgres.FileRow // the replaced file, nil if Created
+}
+
+// Service stores uploads.
+type Service struct {
+	metadata      *postgres.Store
+	storageRouter *storage.Router
+	permissions   *sharing.PermissionStore
+	quotaStore    *quota.QuotaStore
+	maxUploadSize atomic.Int64
+}
+
+// NewService creates an upload service with the global upload size limit.
+func NewService(metadata *postgres.Store, storageRouter *storage.Router, permissions *sharing.PermissionStore, quotaStore *quota.QuotaStore, maxUploadSize int64) *Service {
+	s := &Service{
+		metadata:      metadata,
+		storageRouter: storageRouter,
+		permissions:   permissions,
+		quotaStore:    quotaStore,
+	}
+	s.maxUploadSize.Store(maxUploadSize)
+	return s
+}
+
+// SetMaxUploadSize changes the global upload size limit.
+func (s *Service) SetMaxUploadSize(n int64) {
+	s.maxUploadSize.Store(n)
+}
+
+// Limit returns the upload size limit for claims: the user's own limit if
+// one is set, otherwise the global one.
+func (s *Service) Limit(ctx context.Context, claims *auth.Claims) int64 {
+	if claims != nil {
+		userLimit, err := s.quotaStore.GetUploadSizeLimit(ctx, claims.UserID)
+		if err == nil && userLimit > 0 {
+			return userLimit
+		}
+	}
+	return s.maxUploadSize.Load()
+}
+
+// CheckQuota returns ErrQuotaExceeded if storing size more bytes would take
+// the user past their storage quota.
+func (s *Service) CheckQuota(ctx context.Context, claims *auth.Claims, size int64) error {
+	if claims == nil {
+		return nil
+	}
+	ok, err := s.quotaStore.CheckStorageQuota(ctx, claims.UserID, size)
+	if err == nil && !ok {
+		metrics.RecordQuotaExceeded("storage")
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// QuotaRemaining returns how many more bytes the user may store, or -1 if
+// there is no storage quota.
+func (s *Service) QuotaRemaining(ctx context.Context, claims *auth.Claims) (int64, error) {
+	if claims == nil {
+		return -1, nil
+	}
+	return s.quotaStore.StorageRemaining(ctx, claims.UserID)
+}
+
+// CheckPreconditions returns a *ConflictError if existing does not match
+// the expected version or hash. A missing file matches anything.
+func CheckPreconditions(existing *postgres.FileRow, expectedVersion int, ifMatch string) error {
+	if existing == nil || existing.IsDir {
+		return nil
+	}
+	if expectedVersion > 0 && expectedVersion != existing.Version {
+		return &ConflictError{
+			Reason:          "version conflict",
+			ExpectedVersion: expectedVersion,
+			CurrentVersion:  existing.Version,
+			CurrentHash:     existing.Hash,
+		}
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, existing.Hash) {
+		return &ConflictError{
+			Reason:          "content conflict (hash mismatch)",
+			ExpectedVersion: existing.Version,
+			CurrentVersion:  existing.Version,
+			CurrentHash:     existing.Hash,
+		}
+	}
+	return nil
+}
+
+// etagMatches reports whether an If-Match header value names hash. The
+// value may list several, optionally weak, entity tags.
+func etagMatches(ifMatch, hash string) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.TrimPrefix(tag, "W/")
+		if strings.Trim(tag, `"`) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Store saves req.Content at req.Path. An existing file is kept as a
+// version and replaced; a new file is owned by the uploading user and takes
+// the visibility of its nearest ancestor that sets one. The caller is
+// responsible for the write permission check and for notifying listeners.
+func (s *Service) Store(ctx context.Context, req Request) (*Result, error) {
+	s3Key, err := storage.KeyForPath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.CheckQuota(ctx, req.Claims, req.Size); err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	if _, err := req.Content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, req.Content); err != nil {
+		return nil, err
+	}
+	hashStr := fmt.Sprintf("%x", h.Sum(nil))
+	if req.SHA256 != "" && !strings.EqualFold(req.SHA256, hashStr) {
+		return nil, &ChecksumError{Expected: strings.ToLower(req.SHA256), Actual: hashStr}
+	}
+
+	existing, _ := s.metadata.GetFileRow(ctx, req.Path)
+	if existing != nil && existing.IsDir {
+		return nil, ErrIsDir
+	}
+	if err := CheckPreconditions(existing, req.ExpectedVersion, req.IfMatch); err != nil {
+		return nil, err
+	}
+	if req.CreateOnly {
+		if existing != nil {
+			return nil, ErrExists
+		}
+		claimed, err := s.claimPath(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			return nil, ErrExists
+		}
+		stored := false
+		defer func() {
+			if !stored {
+				// Also when the client hung up mid-upload
+				if err := s.metadata.DeleteFile(context.WithoutCancel(ctx), req.Path); err != nil {
+					logging.Warn("failed to release claimed path", zap.String("path", req.Path), zap.Error(err))
+				}
+			}
+		}()
+		res, err := s.store(ctx, req, s3Key, hashStr, nil)
+		stored = err == nil
+		return res, err
+	}
+	return s.store(ctx, req, s3Key, hashStr, existing)
+}
+
+// claimPath adds an empty row for req.Path, owned by the uploader, unless
+// a row already holds the path. It reports whether the row was added.
+func (s *Service) claimPath(ctx context.Context, req Request) (bool, error) {
+	row := &postgres.FileRow{
+		ID:         FileID(req.Path),
+		Name:       path.Base(req.Path),
+		Path:       req.Path,
+		ParentPath: path.Dir(req.Path),
+		ModTime:    time.Now(),
+		Version:    1,
+		Visibility: "private",
+	}
+	if req.Claims != nil {
+		ownerID := req.Claims.UserID
+		row.OwnerID = &ownerID
+	}
+	added, err := s.metadata.InsertFile(ctx, row)
+	if err != nil {
+		return false, fmt.Errorf("claim path: %w", err)
+	}
+	return added, nil
+}
+
+// store saves the content of req, hashed to hashStr, replacing existing
+// if it is not nil.
+func (s *Service) store(ctx context.Context, req Request, s3Key, hashStr string, existing *postgres.FileRow) (*Result, error) {
+	newVersion := 1
+	if existing != nil && existing.Size > 0 {
+		// Save current state as a version before overwriting
+		if err := s.metadata.SaveVersion(ctx, req.Path); err != nil {
+			logging.Warn("failed to save version", zap.String("path", req.Path), zap.Error(err))
+		}
+
+		// Deduplicated content needs no copy: the version references the
+		// shared object
+		existBackend, _, _ := s.storageRouter.ResolveForFile(ctx, existing.StorageLocID, existing.GroupID)
+		if existBackend != nil && !postgres.IsContentKey(existing.S3Key) {
+			versionKey := fmt.Sprintf("_versions/%s/%d", s3Key, existing.Version)
+			if err := existBackend.CopyObject(ctx, existing.S3Key, versionKey); err != nil {
+				logging.Warn("failed to backup version content", zap.String("path", req.Path), zap.Error(err))
+			}
+		}
+		newVersion = existing.Version + 1
+	}
+
+	var groupID *int
+	if existing != nil {
+		groupID = existing.GroupID
+	}
+	backend, loc, err := s.storageRouter.ResolveForUpload(ctx, req.Path, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("no storage backend: %w", err)
+	}
+
+	// Upload to backend, unless the location already holds this content
+	if _, err := req.Content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s3Key, _, err = s.metadata.PutContent(ctx, hashStr, loc.ID, req.Size, s3Key, func(key string) error {
+		return backend.PutObject(ctx, key, req.Content, req.Size)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload: %w", err)
+	}
+
+	if err := s.EnsureParentDirs(ctx, req.Path); err != nil {
+		logging.Error("failed to ensure parent dirs", zap.Error(err))
+	}
+
+	row := &postgres.FileRow{
+		ID:           FileID(req.Path),
+		Name:         path.Base(req.Path),
+		Path:         req.Path,
+		ParentPath:   path.Dir(req.Path),
+		Size:         req.Size,
+		ModTime:      time.Now(),
+		Hash:         hashStr,
+		S3Key:        s3Key,
+		Version:      newVersion,
+		StorageLocID: &loc.ID,
+	}
+	if existing == nil {
+		if req.Claims != nil {
+			ownerID := req.Claims.UserID
+			row.OwnerID = &ownerID
+		}
+		vis, visGroupID, err := s.permissions.ResolveVisibility(ctx, req.Path)
+		if err != nil {
+			logging.Warn("failed to resolve inherited visibility", zap.String("path", req.Path), zap.Error(err))
+		} else {
+			row.Visibility = vis
+			if vis == "group" {
+				row.GroupID = visGroupID
+			}
+		}
+	}
+
+	if err := s.metadata.UpsertFile(ctx, row); err != nil {
+		if postgres.IsContentKey(s3Key) {
+			s.metadata.ReleaseContent(ctx, s3Key, &loc.ID, backend.DeleteObject)
+		}
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if existing != nil {
+		if old, _, err := s.storageRouter.ResolveForFile(ctx, existing.StorageLocID, existing.GroupID); err == nil && old != nil {
+			if err := s.metadata.ReleaseReplaced(ctx, existing.S3Key, s3Key, existing.StorageLocID, old.DeleteObject); err != nil {
+				logging.Warn("failed to release replaced content",
+					zap.String("path", req.Path), zap.String("key", existing.S3Key), zap.Error(err))
+			}
+		}
+	}
+
+	if req.Claims != nil {
+		s.quotaStore.TrackBandwidth(ctx, req.Claims.UserID, req.Size, 0)
+	}
+
+	return &Result{
+		Path:     req.Path,
+		Size:     req.Size,
+		Hash:     hashStr,
+		Version:  newVersion,
+		Created:  existing == nil,
+		Existing: existing,
+	}, nil
+}
+
+// EnsureParentDirs creates the missing directories above p.
+func (s *Service) EnsureParentDirs(ctx context.Context, p string) error {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) <= 1 {
+		return nil
+	}
+
+	currentPath := ""
+	for i := 0; i < len(parts)-1; i++ {
+		currentPath += "/" + parts[i]
+
+		exists, err := s.metadata.PathExists(ctx, currentPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		fileRow := &postgres.FileRow{
+			ID:         FileID(currentPath),
+			Name:       parts[i],
+			Path:       currentPath,
+			ParentPath: path.Dir(currentPath),
+			IsDir:      true,
+			ModTime:    time.Now(),
+		}
+		if err := s.metadata.UpsertFile(ctx, fileRow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileID returns the ID of the file row at p.
+func FileID(p string) string {
+	h := sha256.Sum256([]byte(p))
+	return fmt.Sprintf("%x", h[:8])
+}
@@ -0,0 +1,64 @@
+package upload
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	row := &postgres.FileRow{Path: "/a.txt", Version: 3, Hash: "abc123"}
+
+	tests := []struct {
+		name            string
+		existing        *postgres.FileRow
+		expectedVersion int
+		ifMatch         string
+		wantConflict    bool
+	}{
+		{"no preconditions", row, 0, "", false},
+		{"new file", nil, 2, "nope", false},
+		{"directory", &postgres.FileRow{IsDir: true}, 2, "nope", false},
+		{"version matches", row, 3, "", false},
+		{"version differs", row, 2, "", true},
+		{"hash matches", row, 0, "abc123", false},
+		{"quoted hash matches", row, 0, `"abc123"`, false},
+		{"weak hash matches", row, 0, `W/"abc123"`, false},
+		{"one of several matches", row, 0, `"old", "abc123"`, false},
+		{"any", row, 0, "*", false},
+		{"hash differs", row, 0, `"old"`, true},
+	}
+	for _, tt := range tests {
+		err := CheckPreconditions(tt.existing, tt.expectedVersion, tt.ifMatch)
+		var conflict *ConflictError
+		if got := errors.As(err, &conflict); got != tt.wantConflict {
+			t.Errorf("%s: err = %v, want conflict %v", tt.name, err, tt.wantConflict)
+			continue
+		}
+		if conflict != nil && (conflict.CurrentVersion != 3 || conflict.CurrentHash != "abc123") {
+			t.Errorf("%s: conflict = %+v", tt.name, conflict)
+		}
+	}
+}
+
+func TestFileID(t *testing.T) {
+	if a, b := FileID("/a"), FileID("/b"); a == b || len(a) != 16 {
+		t.Errorf("FileID = %q, %q", a, b)
+	}
+}
+
+func TestValidSHA256(t *testing.T) {
+	valid := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	for s, want := range map[string]bool{
+		valid:            true,
+		valid[:63]:       false,
+		valid + "0":      false,
+		"":               false,
+		"zz" + valid[2:]: false,
+	} {
+		if got := ValidSHA256(s); got != want {
+			t.Errorf("ValidSHA256(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
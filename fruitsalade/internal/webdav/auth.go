@@ -1,6 +1,7 @@
 package webdav
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -26,7 +27,11 @@ func BasicAuthMiddleware(a *auth.Auth) func(http.Handler) http.Handler {
 			username, password, ok := r.BasicAuth()
 			if !ok {
 				w.Header().Set("WWW-Authenticate", `Basic realm="FruitSalade"`)
-				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				sendError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+
+			if a.LoginLocked(w, r, username) {
 				return
 			}
 
@@ -35,11 +40,15 @@ func BasicAuthMiddleware(a *auth.Auth) func(http.Handler) http.Handler {
 				logging.Warn("webdav auth failed",
 					zap.String("username", username),
 					zap.Error(err))
+				if errors.Is(err, auth.ErrInvalidCredentials) {
+					a.CountLoginFailure(username, a.ClientIP(r))
+				}
 				w.Header().Set("WWW-Authenticate", `Basic realm="FruitSalade"`)
-				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+				sendError(w, http.StatusUnauthorized, "invalid credentials")
 				return
 			}
 
+			a.ResetLoginFailures(username)
 			ctx := auth.WithClaims(r.Context(), claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
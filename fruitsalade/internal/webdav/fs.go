@@ -16,15 +16,22 @@ import (
 	"golang.org/x/net/webdav"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
 )
 
 // FruitFS implements webdav.FileSystem backed by multi-backend storage + PostgreSQL.
 type FruitFS struct {
 	metadata      *postgres.Store
 	storageRouter *storage.Router
+	permissions   *sharing.PermissionStore
+	api           API
 }
 
 var _ webdav.FileSystem = (*FruitFS)(nil)
@@ -43,6 +50,13 @@ func (fs *FruitFS) Mkdir(ctx context.Context, name string, perm os.FileMode) err
 	if name == "/" {
 		return nil
 	}
+	if root, _ := splitVirtual(name); root != "" {
+		return os.ErrPermission
+	}
+	if err := paths.ValidatePath(name); err != nil {
+		return err
+	}
+	name, _ = fs.api.ResolveAlias(name)
 
 	parentPath := filepath.Dir(name)
 	if parentPath == "." {
@@ -76,12 +90,34 @@ func (fs *FruitFS) OpenFile(ctx context.Context, name string, flag int, perm os.
 
 	writable := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0
 
+	if root, rest := splitVirtual(name); root != "" {
+		if writable {
+			return nil, os.ErrPermission
+		}
+		return fs.openVirtual(ctx, name, root, rest)
+	}
+
+	// At an alias, files are read and written at the target
+	name, _ = fs.api.ResolveAlias(name)
+
 	if writable {
+		if err := paths.ValidatePath(name); err != nil {
+			return nil, err
+		}
+		claims := auth.GetClaims(ctx)
+		if claims != nil && !fs.permissions.CheckAccess(ctx, claims.UserID, name, "write", claims.IsAdmin) {
+			return nil, os.ErrPermission
+		}
+		opts, _ := ctx.Value(putKey{}).(putOptions)
+		if opts.limit == 0 {
+			opts.limit = fs.api.Uploads().Limit(ctx, claims)
+		}
 		return &FruitFile{
 			fs:       fs,
 			name:     name,
 			writable: true,
 			buf:      &bytes.Buffer{},
+			put:      opts,
 			ctx:      ctx,
 		}, nil
 	}
@@ -109,6 +145,12 @@ func (fs *FruitFS) RemoveAll(ctx context.Context, name string) error {
 	if name == "/" {
 		return fmt.Errorf("cannot remove root")
 	}
+	switch root, rest := splitVirtual(name); root {
+	case trashRoot:
+		return fs.purgeTrash(ctx, rest)
+	case versionsRoot:
+		return os.ErrPermission
+	}
 
 	row, err := fs.metadata.GetFileRow(ctx, name)
 	if err != nil {
@@ -119,34 +161,51 @@ func (fs *FruitFS) RemoveAll(ctx context.Context, name string) error {
 	}
 
 	if row.IsDir {
-		// Delete children from storage
-		children, _ := fs.metadata.ListDir(ctx, name)
-		for _, child := range children {
-			if !child.IsDir {
-				s3Key := strings.TrimPrefix(child.Path, "/")
-				backend, _, err := fs.storageRouter.GetDefault()
-				if err == nil {
-					backend.DeleteObject(ctx, s3Key)
-				}
-			}
+		// Delete the files below it from storage
+		deleted, err := fs.metadata.DeleteTree(ctx, name)
+		if err != nil {
+			return err
 		}
-		_, err := fs.metadata.DeleteTree(ctx, name)
-		return err
+		for _, d := range deleted {
+			fs.releaseContent(ctx, d.S3Key, d.StorageLocID, d.GroupID)
+		}
+		return nil
 	}
 
 	// Single file — resolve backend from file's storage location
-	s3Key := strings.TrimPrefix(name, "/")
-	backend, _, err := fs.storageRouter.ResolveForFile(ctx, row.StorageLocID, nil)
-	if err == nil {
-		backend.DeleteObject(ctx, s3Key)
+	if err := fs.metadata.DeleteFile(ctx, name); err != nil {
+		return err
+	}
+	fs.releaseContent(ctx, row.S3Key, row.StorageLocID, row.GroupID)
+	return nil
+}
+
+// releaseContent deletes the content of a removed file row, or drops its
+// reference on shared content.
+func (fs *FruitFS) releaseContent(ctx context.Context, key string, locID, groupID *int) {
+	backend, _, err := fs.storageRouter.ResolveForFile(ctx, locID, groupID)
+	if err != nil || backend == nil {
+		return
+	}
+	if err := fs.metadata.ReleaseContent(ctx, key, locID, backend.DeleteObject); err != nil {
+		logging.Warn("webdav: failed to delete content", zap.String("key", key), zap.Error(err))
 	}
-	return fs.metadata.DeleteFile(ctx, name)
 }
 
 // Rename moves a file from oldName to newName.
 func (fs *FruitFS) Rename(ctx context.Context, oldName, newName string) error {
 	oldName = normalizePath(oldName)
 	newName = normalizePath(newName)
+	// Restoring from the trash is handled by trashMiddleware
+	if root, _ := splitVirtual(oldName); root != "" {
+		return os.ErrPermission
+	}
+	if root, _ := splitVirtual(newName); root != "" {
+		return os.ErrPermission
+	}
+	if err := paths.ValidatePath(newName); err != nil {
+		return err
+	}
 
 	row, err := fs.metadata.GetFileRow(ctx, oldName)
 	if err != nil {
@@ -160,14 +219,19 @@ func (fs *FruitFS) Rename(ctx context.Context, oldName, newName string) error {
 		return fmt.Errorf("directory rename not supported")
 	}
 
-	// Copy object on the same backend
-	oldKey := strings.TrimPrefix(oldName, "/")
-	newKey := strings.TrimPrefix(newName, "/")
+	// Copy object on the same backend; shared content stays where it is
+	oldKey := row.S3Key
+	newKey, err := storage.KeyForPath(newName)
+	if err != nil {
+		return err
+	}
 	backend, _, err := fs.storageRouter.ResolveForFile(ctx, row.StorageLocID, nil)
 	if err != nil {
 		return err
 	}
-	if err := backend.CopyObject(ctx, oldKey, newKey); err != nil {
+	if postgres.IsContentKey(oldKey) {
+		newKey = oldKey
+	} else if err := backend.CopyObject(ctx, oldKey, newKey); err != nil {
 		return err
 	}
 
@@ -195,8 +259,10 @@ func (fs *FruitFS) Rename(ctx context.Context, oldName, newName string) error {
 		return err
 	}
 
-	// Delete old
-	backend.DeleteObject(ctx, oldKey)
+	// Delete old; the new row took over the old one's reference
+	if !postgres.IsContentKey(oldKey) {
+		backend.DeleteObject(ctx, oldKey)
+	}
 	return fs.metadata.DeleteFile(ctx, oldName)
 }
 
@@ -207,8 +273,12 @@ func (fs *FruitFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	if name == "/" {
 		return &fileInfo{name: "/", isDir: true, modTime: time.Now()}, nil
 	}
+	if root, rest := splitVirtual(name); root != "" {
+		return fs.statVirtual(ctx, root, rest)
+	}
 
-	row, err := fs.metadata.GetFileRow(ctx, name)
+	target, _ := fs.api.ResolveAlias(name)
+	row, err := fs.metadata.GetFileRow(ctx, target)
 	if err != nil {
 		return nil, err
 	}
@@ -217,10 +287,11 @@ func (fs *FruitFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	}
 
 	return &fileInfo{
-		name:    row.Name,
+		name:    filepath.Base(name),
 		size:    row.Size,
 		isDir:   row.IsDir,
 		modTime: row.ModTime,
+		hash:    row.Hash,
 	}, nil
 }
 
@@ -231,6 +302,8 @@ type FruitFile struct {
 	row      *postgres.FileRow
 	writable bool
 	buf      *bytes.Buffer
+	put      putOptions
+	info     *fileInfo // Stat result of a written file, given its hash on Close
 	ctx      context.Context
 
 	// Read state
@@ -247,69 +320,42 @@ func (f *FruitFile) Close() error {
 		f.reader = nil
 	}
 
-	if !f.writable || f.buf == nil || f.buf.Len() == 0 {
+	if !f.writable || f.buf == nil {
 		return nil
 	}
-
-	// Upload buffer to storage
-	name := f.name
 	content := f.buf.Bytes()
-	s3Key := strings.TrimPrefix(name, "/")
-
-	// Resolve which backend to use for this upload
-	backend, loc, err := f.fs.storageRouter.ResolveForUpload(f.ctx, name, nil)
+	f.buf = nil
+
+	// Errors here reach the client as 405; the PUT middleware has already
+	// checked what it could before the body was read
+	claims := auth.GetClaims(f.ctx)
+	res, err := f.fs.api.Uploads().Store(f.ctx, upload.Request{
+		Path:    f.name,
+		Content: bytes.NewReader(content),
+		Size:    int64(len(content)),
+		Claims:  claims,
+		IfMatch: f.put.ifMatch,
+	})
 	if err != nil {
-		return fmt.Errorf("resolve storage backend: %w", err)
-	}
-
-	if err := backend.PutObject(f.ctx, s3Key, bytes.NewReader(content), int64(len(content))); err != nil {
+		metrics.RecordContentUpload(0, false)
+		logging.Warn("webdav upload failed", zap.String("path", f.name), zap.Error(err))
 		return err
 	}
-
-	// Compute hash
-	h := sha256.Sum256(content)
-	hashStr := fmt.Sprintf("%x", h)
-
-	// Check for existing entry (versioning)
-	newVersion := 1
-	existing, _ := f.fs.metadata.GetFileRow(f.ctx, name)
-	if existing != nil && !existing.IsDir {
-		newVersion = existing.Version + 1
-	}
-
-	parentPath := filepath.Dir(name)
-	if parentPath == "." {
-		parentPath = "/"
-	}
-	idH := sha256.Sum256([]byte(name))
-	row := &postgres.FileRow{
-		ID:           fmt.Sprintf("%x", idH[:8]),
-		Name:         filepath.Base(name),
-		Path:         name,
-		ParentPath:   parentPath,
-		Size:         int64(len(content)),
-		ModTime:      time.Now(),
-		IsDir:        false,
-		Hash:         hashStr,
-		S3Key:        s3Key,
-		Version:      newVersion,
-		StorageLocID: &loc.ID,
-	}
-
-	if claims := auth.GetClaims(f.ctx); claims != nil && existing == nil {
-		ownerID := claims.UserID
-		row.OwnerID = &ownerID
-	}
-
-	if err := f.fs.metadata.UpsertFile(f.ctx, row); err != nil {
-		return err
+	metrics.RecordContentUpload(res.Size, true)
+	if f.info != nil {
+		f.info.hash = res.Hash
 	}
 
 	logging.Debug("webdav file written",
-		zap.String("path", name),
-		zap.Int("size", len(content)),
-		zap.Int("version", newVersion))
+		zap.String("path", f.name),
+		zap.Int64("size", res.Size),
+		zap.Int("version", res.Version))
 
+	eventType := events.EventCreate
+	if !res.Created {
+		eventType = events.EventModify
+	}
+	f.fs.api.NotifyChange(f.ctx, eventType, f.name, res.Version, res.Hash, res.Size, claims)
 	return nil
 }
 
@@ -323,7 +369,7 @@ func (f *FruitFile) Read(p []byte) (int, error) {
 		if f.row == nil {
 			return 0, io.EOF
 		}
-		backend, _, err := f.fs.storageRouter.ResolveForFile(f.ctx, f.row.StorageLocID, nil)
+		backend, _, err := f.fs.storageRouter.ResolveForRead(f.ctx, f.row.StorageLocID, nil)
 		if err != nil {
 			return 0, fmt.Errorf("resolve storage backend: %w", err)
 		}
@@ -341,9 +387,12 @@ func (f *FruitFile) Read(p []byte) (int, error) {
 }
 
 func (f *FruitFile) Write(p []byte) (int, error) {
-	if !f.writable {
+	if !f.writable || f.buf == nil {
 		return 0, fmt.Errorf("file not opened for writing")
 	}
+	if int64(f.buf.Len()+len(p)) > f.put.limit {
+		return 0, fmt.Errorf("%w: max %d bytes", upload.ErrTooLarge, f.put.limit)
+	}
 	return f.buf.Write(p)
 }
 
@@ -394,6 +443,7 @@ func (f *FruitFile) Readdir(count int) ([]os.FileInfo, error) {
 			size:    child.Size,
 			isDir:   child.IsDir,
 			modTime: child.ModTime,
+			hash:    child.Hash,
 		})
 	}
 
@@ -411,6 +461,7 @@ func (f *FruitFile) Stat() (os.FileInfo, error) {
 			size:    f.row.Size,
 			isDir:   f.row.IsDir,
 			modTime: f.row.ModTime,
+			hash:    f.row.Hash,
 		}, nil
 	}
 	// Root or new file
@@ -418,21 +469,27 @@ func (f *FruitFile) Stat() (os.FileInfo, error) {
 		return &fileInfo{name: "/", isDir: true, modTime: time.Now()}, nil
 	}
 	if f.writable {
-		return &fileInfo{
+		var size int64
+		if f.buf != nil {
+			size = int64(f.buf.Len())
+		}
+		f.info = &fileInfo{
 			name:    filepath.Base(f.name),
-			size:    int64(f.buf.Len()),
+			size:    size,
 			modTime: time.Now(),
-		}, nil
+		}
+		return f.info, nil
 	}
 	return nil, os.ErrNotExist
 }
 
-// fileInfo implements os.FileInfo.
+// fileInfo implements os.FileInfo and webdav.ETager.
 type fileInfo struct {
 	name    string
 	size    int64
 	isDir   bool
 	modTime time.Time
+	hash    string
 }
 
 func (fi *fileInfo) Name() string       { return fi.name }
@@ -441,6 +498,15 @@ func (fi *fileInfo) IsDir() bool        { return fi.isDir }
 func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *fileInfo) Sys() interface{}   { return nil }
 
+// ETag is the content hash, the ETag the HTTP API uses, so a client can
+// send it back in If-Match.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.hash == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + fi.hash + `"`, nil
+}
+
 func (fi *fileInfo) Mode() os.FileMode {
 	if fi.isDir {
 		return os.ModeDir | 0755
@@ -1,21 +1,263 @@
 package webdav
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/net/webdav"
 
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/paths"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/quota"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/sharing"
 	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/storage"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/upload"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
-// NewHandler creates a WebDAV HTTP handler with authentication.
-func NewHandler(metadata *postgres.Store, storageRouter *storage.Router, authHandler *auth.Auth) http.Handler {
+// API is the part of the HTTP API server the WebDAV frontend shares: its
+// upload service, alias resolution and change notifications. Implemented
+// by *api.Server.
+type API interface {
+	ResolveAlias(path string) (string, bool)
+	Uploads() *upload.Service
+	NotifyChange(ctx context.Context, eventType, path string, version int, hash string, size int64, claims *auth.Claims)
+}
+
+// NewHandler creates a WebDAV HTTP handler with authentication. GET
+// downloads count against the user's daily bandwidth quota; those of admins
+// and below the exempt path prefixes are only tracked. Old versions and the
+// user's trash are served under /.versions and /.trash. PUT stores files
+// like the HTTP upload endpoint does.
+func NewHandler(metadata *postgres.Store, storageRouter *storage.Router, authHandler *auth.Auth, quotaStore *quota.QuotaStore, permissions *sharing.PermissionStore, api API, exempt []string) http.Handler {
+	fs := &FruitFS{metadata: metadata, storageRouter: storageRouter, permissions: permissions, api: api}
 	davHandler := &webdav.Handler{
-		FileSystem: &FruitFS{metadata: metadata, storageRouter: storageRouter},
+		FileSystem: fs,
 		LockSystem: webdav.NewMemLS(),
 		Prefix:     "/webdav",
 	}
-	return BasicAuthMiddleware(authHandler)(davHandler)
+	return BasicAuthMiddleware(authHandler)(bandwidthMiddleware(quotaStore, exempt, pathMiddleware(virtualMiddleware(fs, putMiddleware(fs, davHandler)))))
+}
+
+// putKey is the context key of the putOptions of a PUT request.
+type putKey struct{}
+
+// putOptions carries what putMiddleware learned about a PUT request to the
+// file it opens.
+type putOptions struct {
+	ifMatch string
+	limit   int64
+}
+
+// putMiddleware checks a PUT before its body is read: write access, the
+// upload size limit and storage quota when the length is known, and the
+// If-Match and If-None-Match preconditions against the content hash, which
+// is the ETag WebDAV reports. The golang.org/x/net/webdav handler answers
+// any error that surfaces later, while the file is stored, with 405.
+func putMiddleware(fs *FruitFS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		claims := auth.GetClaims(ctx)
+		name, _ := fs.api.ResolveAlias(normalizePath(strings.TrimPrefix(r.URL.Path, "/webdav")))
+
+		if claims != nil && !fs.permissions.CheckAccess(ctx, claims.UserID, name, "write", claims.IsAdmin) {
+			sendError(w, http.StatusForbidden, "write access denied")
+			return
+		}
+
+		uploads := fs.api.Uploads()
+		limit := uploads.Limit(ctx, claims)
+		if r.ContentLength > limit {
+			sendError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file too large: max %d bytes", limit))
+			return
+		}
+		if r.ContentLength > 0 {
+			if err := uploads.CheckQuota(ctx, claims, r.ContentLength); err != nil {
+				sendErrorCode(w, http.StatusInsufficientStorage, protocol.ErrCodeQuotaExceeded, err.Error(), "")
+				return
+			}
+		}
+
+		existing, err := fs.metadata.GetFileRow(ctx, name)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if existing != nil && existing.IsDir {
+			sendError(w, http.StatusMethodNotAllowed, "cannot PUT to a collection")
+			return
+		}
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch != "" {
+			if existing == nil {
+				sendError(w, http.StatusPreconditionFailed, "file does not exist")
+				return
+			}
+			if err := upload.CheckPreconditions(existing, 0, ifMatch); err != nil {
+				w.Header().Set("ETag", `"`+existing.Hash+`"`)
+				sendError(w, http.StatusPreconditionFailed, err.Error())
+				return
+			}
+		}
+		if r.Header.Get("If-None-Match") == "*" && existing != nil {
+			sendError(w, http.StatusPreconditionFailed, "file exists")
+			return
+		}
+
+		ctx = context.WithValue(ctx, putKey{}, putOptions{ifMatch: ifMatch, limit: limit})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// pathMiddleware refuses with 400 requests that would create a file or
+// directory at a path paths.ValidatePath rejects or that does not map to a
+// valid storage key: PUT and MKCOL targets and COPY and MOVE destinations.
+func pathMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var target string
+		switch r.Method {
+		case http.MethodPut, "MKCOL":
+			target = strings.TrimPrefix(r.URL.Path, "/webdav")
+		case "COPY", "MOVE":
+			if u, err := url.Parse(r.Header.Get("Destination")); err == nil {
+				target = strings.TrimPrefix(u.Path, "/webdav")
+			}
+		}
+		if target != "" {
+			err := paths.ValidatePath(target)
+			if err == nil {
+				_, err = storage.KeyForPath(target)
+			}
+			if err != nil {
+				sendPathError(w, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// virtualMiddleware refuses writes into the virtual trees with 403 and
+// restores items that are moved or copied out of /.trash. The trash keeps
+// no copy of what it restores, so COPY behaves like MOVE there.
+func virtualMiddleware(fs *FruitFS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := normalizePath(strings.TrimPrefix(r.URL.Path, "/webdav"))
+		var dst string
+		if hdr := r.Header.Get("Destination"); hdr != "" {
+			if u, err := url.Parse(hdr); err == nil {
+				dst = normalizePath(strings.TrimPrefix(u.Path, "/webdav"))
+			}
+		}
+		if !virtualAllowed(r.Method, name, dst) {
+			sendError(w, http.StatusForbidden, "read-only virtual folder")
+			return
+		}
+
+		root, rest := splitVirtual(name)
+		if root != trashRoot || (r.Method != "COPY" && r.Method != "MOVE") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if dst == "" {
+			sendError(w, http.StatusBadRequest, "destination required")
+			return
+		}
+		err := fs.restoreTrash(r.Context(), rest, dst, r.Header.Get("Overwrite") != "F")
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusCreated)
+		case errors.Is(err, os.ErrNotExist):
+			sendError(w, http.StatusNotFound, "not found in trash")
+		case errors.Is(err, os.ErrExist):
+			sendError(w, http.StatusPreconditionFailed, "destination exists")
+		case errors.Is(err, os.ErrPermission):
+			sendError(w, http.StatusForbidden, "directories can only be restored to their original path")
+		default:
+			sendError(w, http.StatusInternalServerError, "failed to restore: "+err.Error())
+		}
+	})
+}
+
+// sendError writes a JSON error response with the generic error code of
+// status.
+func sendError(w http.ResponseWriter, status int, msg string) {
+	sendErrorCode(w, status, protocol.ErrorCodeForStatus(status), msg, "")
+}
+
+// sendErrorCode writes a JSON error response with a specific error code.
+func sendErrorCode(w http.ResponseWriter, status int, code protocol.ErrorCode, msg, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(protocol.ErrorResponse{
+		Error:     msg,
+		Code:      status,
+		ErrorCode: code,
+		Details:   details,
+		RequestID: w.Header().Get("X-Request-ID"),
+	})
+}
+
+// sendPathError writes the 400 for a path paths.ValidatePath rejected.
+func sendPathError(w http.ResponseWriter, err error) {
+	sendErrorCode(w, http.StatusBadRequest, protocol.ErrCodeInvalidPath, err.Error(), protocol.DetailInvalidPath)
+}
+
+// bandwidthMiddleware meters GET responses against the bandwidth quota,
+// refusing them with 429 once it is used up and cutting off a transfer
+// that runs past it.
+func bandwidthMiddleware(store *quota.QuotaStore, exempt []string, next http.Handler) http.Handler {
+	if store == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := auth.GetClaims(r.Context())
+		if r.Method != http.MethodGet || claims == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var limit int64
+		if !claims.IsAdmin && !quota.PathExempt(strings.TrimPrefix(r.URL.Path, "/webdav"), exempt) {
+			l, ok, err := store.DownloadLimit(r.Context(), claims.UserID)
+			if err == nil && !ok {
+				metrics.RecordDownloadDenied("webdav")
+				w.Header().Set("Retry-After", strconv.Itoa(quota.RetryAfterReset(time.Now())))
+				sendErrorCode(w, http.StatusTooManyRequests, protocol.ErrCodeBandwidthExceeded, "daily bandwidth quota exceeded", "")
+				return
+			}
+			limit = l
+		}
+
+		mw := &meteredWriter{ResponseWriter: w, meter: store.NewMeter(r.Context(), w, claims.UserID, limit)}
+		next.ServeHTTP(mw, r)
+		mw.meter.Finish()
+		if mw.meter.Exceeded() {
+			metrics.RecordDownloadDenied("webdav")
+		}
+	})
+}
+
+// meteredWriter sends a response body through a quota.Meter.
+type meteredWriter struct {
+	http.ResponseWriter
+	meter *quota.Meter
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	return m.meter.Write(p)
 }
@@ -0,0 +1,458 @@
+package webdav
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/auth"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+)
+
+// Two read-only virtual trees sit next to the real files:
+//
+//	/.versions/<path>/<n>  version n of the file at <path>
+//	/.trash/<name>         an item in the user's trash
+//
+// Moving (or copying) an item out of /.trash restores it and deleting it
+// purges it; every other write into the virtual trees is refused.
+const (
+	versionsRoot = "/.versions"
+	trashRoot    = "/.trash"
+)
+
+// splitVirtual returns the virtual tree a normalized path lies in, if any,
+// and the path below that tree's root.
+func splitVirtual(name string) (root, rest string) {
+	for _, r := range []string{versionsRoot, trashRoot} {
+		if name == r {
+			return r, "/"
+		}
+		if strings.HasPrefix(name, r+"/") {
+			return r, name[len(r):]
+		}
+	}
+	return "", name
+}
+
+// virtualAllowed reports whether a request with method on the normalized
+// path name, and destination dst for COPY and MOVE, may touch the virtual
+// trees. Reads are always allowed.
+func virtualAllowed(method, name, dst string) bool {
+	root, rest := splitVirtual(name)
+	dstRoot, _ := splitVirtual(dst)
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND", "UNLOCK":
+		return true
+	case "DELETE":
+		return root == "" || (root == trashRoot && rest != "/")
+	case "MOVE":
+		return dstRoot == "" && (root == "" || (root == trashRoot && rest != "/"))
+	case "COPY":
+		// Copying a version out gives a live copy of it
+		return dstRoot == "" && (root != trashRoot || rest != "/")
+	}
+	return root == ""
+}
+
+// virtualInfo is the os.FileInfo of a virtual tree entry. It knows its
+// content type, so PROPFIND does not open the file to sniff one.
+type virtualInfo struct {
+	fileInfo
+	ctype string
+}
+
+func (vi *virtualInfo) ContentType(ctx context.Context) (string, error) {
+	if vi.isDir {
+		return "", webdav.ErrNotImplemented
+	}
+	return vi.ctype, nil
+}
+
+func newVirtualInfo(name string, size int64, isDir bool, modTime time.Time, fileName string) *virtualInfo {
+	ctype := mime.TypeByExtension(path.Ext(fileName))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	return &virtualInfo{
+		fileInfo: fileInfo{name: name, size: size, isDir: isDir, modTime: modTime},
+		ctype:    ctype,
+	}
+}
+
+// virtualNode is a resolved entry of a virtual tree.
+type virtualNode struct {
+	info *virtualInfo
+
+	// Content of a file
+	key   string
+	locID *int
+
+	// list returns the entries of a directory
+	list func() ([]os.FileInfo, error)
+}
+
+// virtualFile is an open virtual tree entry. Files read through the
+// embedded FruitFile; directories list their node's entries.
+type virtualFile struct {
+	*FruitFile
+	node *virtualNode
+}
+
+func (f *virtualFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *virtualFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.node.list == nil {
+		return nil, errors.New("not a directory")
+	}
+	infos, err := f.node.list()
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && len(infos) > count {
+		infos = infos[:count]
+	}
+	return infos, nil
+}
+
+func (f *virtualFile) Stat() (os.FileInfo, error) {
+	return f.node.info, nil
+}
+
+// statVirtual is Stat for a path in a virtual tree.
+func (fs *FruitFS) statVirtual(ctx context.Context, root, rest string) (os.FileInfo, error) {
+	node, err := fs.resolveVirtual(ctx, root, rest)
+	if err != nil {
+		return nil, err
+	}
+	return node.info, nil
+}
+
+// openVirtual opens a path in a virtual tree for reading.
+func (fs *FruitFS) openVirtual(ctx context.Context, name, root, rest string) (webdav.File, error) {
+	node, err := fs.resolveVirtual(ctx, root, rest)
+	if err != nil {
+		return nil, err
+	}
+	row := &postgres.FileRow{
+		Name:         node.info.name,
+		Size:         node.info.size,
+		IsDir:        node.info.isDir,
+		ModTime:      node.info.modTime,
+		S3Key:        node.key,
+		StorageLocID: node.locID,
+	}
+	return &virtualFile{
+		FruitFile: &FruitFile{fs: fs, name: name, row: row, ctx: ctx},
+		node:      node,
+	}, nil
+}
+
+func (fs *FruitFS) resolveVirtual(ctx context.Context, root, rest string) (*virtualNode, error) {
+	if root == versionsRoot {
+		return fs.resolveVersions(ctx, rest)
+	}
+	items, err := fs.trashItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fs.resolveTrash(ctx, items, rest)
+}
+
+// ─── Versions ───────────────────────────────────────────────────────────────
+
+// resolveVersions resolves a path below /.versions. Directories mirror the
+// real tree, a file is a directory of its versions, and <file>/<n> is
+// version n.
+func (fs *FruitFS) resolveVersions(ctx context.Context, rest string) (*virtualNode, error) {
+	if rest == "/" {
+		return &virtualNode{
+			info: newVirtualInfo(path.Base(versionsRoot), 0, true, time.Now(), ""),
+			list: func() ([]os.FileInfo, error) { return fs.listVersionsDir(ctx, "/") },
+		}, nil
+	}
+
+	row, err := fs.metadata.GetFileRow(ctx, rest)
+	if err != nil {
+		return nil, err
+	}
+	if row != nil {
+		if row.IsDir {
+			return &virtualNode{
+				info: newVirtualInfo(row.Name, 0, true, row.ModTime, ""),
+				list: func() ([]os.FileInfo, error) { return fs.listVersionsDir(ctx, rest) },
+			}, nil
+		}
+		return &virtualNode{
+			info: newVirtualInfo(row.Name, 0, true, row.ModTime, ""),
+			list: func() ([]os.FileInfo, error) { return fs.listFileVersions(ctx, rest) },
+		}, nil
+	}
+
+	filePath := path.Dir(rest)
+	n, err := strconv.Atoi(path.Base(rest))
+	if err != nil || n < 1 || filePath == "/" {
+		return nil, os.ErrNotExist
+	}
+	v, err := fs.metadata.GetVersion(ctx, filePath, n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &virtualNode{
+		info:  newVirtualInfo(strconv.Itoa(n), v.Size, false, v.CreatedAt, filePath),
+		key:   postgres.VersionContentKey(filePath, n, v.S3Key),
+		locID: v.StorageLocID,
+	}, nil
+}
+
+// listVersionsDir lists a real directory below /.versions: its files show
+// up as directories of their versions.
+func (fs *FruitFS) listVersionsDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	children, err := fs.metadata.ListDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, child := range children {
+		infos = append(infos, newVirtualInfo(child.Name, 0, true, child.ModTime, ""))
+	}
+	return infos, nil
+}
+
+// listFileVersions lists the stored versions of a file, oldest first.
+func (fs *FruitFS) listFileVersions(ctx context.Context, filePath string) ([]os.FileInfo, error) {
+	versions, _, err := fs.metadata.ListVersions(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		infos = append(infos, newVirtualInfo(strconv.Itoa(v.Version), v.Size, false, v.CreatedAt, filePath))
+	}
+	return infos, nil
+}
+
+// ─── Trash ──────────────────────────────────────────────────────────────────
+
+// trashItems returns the authenticated user's trash, one row per original
+// path.
+func (fs *FruitFS) trashItems(ctx context.Context) ([]postgres.TrashRow, error) {
+	claims := auth.GetClaims(ctx)
+	if claims == nil {
+		return nil, os.ErrPermission
+	}
+	rows, err := fs.metadata.ListTrash(ctx, &claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(rows))
+	var items []postgres.TrashRow
+	for _, t := range rows {
+		if !seen[t.OriginalPath] {
+			seen[t.OriginalPath] = true
+			items = append(items, t)
+		}
+	}
+	return items, nil
+}
+
+// trashTop returns the items whose parent directory is not in the trash
+// too, keyed by their name in /.trash. Items that share a name get their
+// ID added to it.
+func trashTop(items []postgres.TrashRow) map[string]postgres.TrashRow {
+	inTrash := make(map[string]bool, len(items))
+	for _, t := range items {
+		inTrash[t.OriginalPath] = true
+	}
+	var top []postgres.TrashRow
+	count := make(map[string]int)
+	for _, t := range items {
+		if !inTrash[path.Dir(t.OriginalPath)] {
+			top = append(top, t)
+			count[t.Name]++
+		}
+	}
+	names := make(map[string]postgres.TrashRow, len(top))
+	for _, t := range top {
+		name := t.Name
+		if count[name] > 1 {
+			id := t.ID
+			if len(id) > 8 {
+				id = id[:8]
+			}
+			ext := path.Ext(name)
+			name = strings.TrimSuffix(name, ext) + " (" + id + ")" + ext
+		}
+		names[name] = t
+	}
+	return names
+}
+
+// resolveTrash resolves a path below /.trash. Trashed directories list the
+// trashed items below them.
+func (fs *FruitFS) resolveTrash(ctx context.Context, items []postgres.TrashRow, rest string) (*virtualNode, error) {
+	if rest == "/" {
+		return &virtualNode{
+			info: newVirtualInfo(path.Base(trashRoot), 0, true, time.Now(), ""),
+			list: func() ([]os.FileInfo, error) {
+				top := trashTop(items)
+				infos := make([]os.FileInfo, 0, len(top))
+				for name, t := range top {
+					infos = append(infos, trashInfo(name, t))
+				}
+				sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+				return infos, nil
+			},
+		}, nil
+	}
+
+	t, ok := findTrashItem(items, rest)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	node := &virtualNode{info: trashInfo(path.Base(rest), t)}
+	if t.IsDir {
+		node.list = func() ([]os.FileInfo, error) {
+			var infos []os.FileInfo
+			for _, c := range items {
+				if path.Dir(c.OriginalPath) == t.OriginalPath && c.OriginalPath != t.OriginalPath {
+					infos = append(infos, trashInfo(c.Name, c))
+				}
+			}
+			return infos, nil
+		}
+		return node, nil
+	}
+
+	// Trashed rows keep their path, so the content can still be read
+	row, err := fs.metadata.GetFileRow(ctx, t.OriginalPath)
+	if err != nil {
+		return nil, err
+	}
+	if row != nil {
+		node.key = row.S3Key
+		node.locID = row.StorageLocID
+	}
+	return node, nil
+}
+
+// findTrashItem returns the trash item at a path below /.trash.
+func findTrashItem(items []postgres.TrashRow, rest string) (postgres.TrashRow, bool) {
+	first, below, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	t, ok := trashTop(items)[first]
+	if !ok || below == "" {
+		return t, ok
+	}
+	want := t.OriginalPath + "/" + below
+	for _, c := range items {
+		if c.OriginalPath == want {
+			return c, true
+		}
+	}
+	return postgres.TrashRow{}, false
+}
+
+func trashInfo(name string, t postgres.TrashRow) *virtualInfo {
+	var size int64
+	if !t.IsDir {
+		size = t.Size
+	}
+	return newVirtualInfo(name, size, t.IsDir, t.DeletedAt, t.Name)
+}
+
+// trashSubtree returns the original paths of a trash item and the trashed
+// items below it.
+func trashSubtree(items []postgres.TrashRow, t postgres.TrashRow) []string {
+	var paths []string
+	for _, c := range items {
+		if c.OriginalPath == t.OriginalPath || strings.HasPrefix(c.OriginalPath, t.OriginalPath+"/") {
+			paths = append(paths, c.OriginalPath)
+		}
+	}
+	return paths
+}
+
+// restoreTrash restores the trash item at rest, with everything trashed
+// below it, and moves it to dst if that is not where it came from. Unless
+// overwrite is set, a live file at dst is left alone and os.ErrExist
+// returned.
+func (fs *FruitFS) restoreTrash(ctx context.Context, rest, dst string, overwrite bool) error {
+	items, err := fs.trashItems(ctx)
+	if err != nil {
+		return err
+	}
+	t, ok := findTrashItem(items, rest)
+	if !ok {
+		return os.ErrNotExist
+	}
+	if dst != t.OriginalPath && t.IsDir {
+		// Directories can only go back where they were
+		return os.ErrPermission
+	}
+	if dst != t.OriginalPath && !overwrite {
+		if row, err := fs.metadata.GetFileRow(ctx, dst); err != nil {
+			return err
+		} else if row != nil {
+			return os.ErrExist
+		}
+	}
+
+	for _, p := range trashSubtree(items, t) {
+		if err := fs.metadata.RestoreFile(ctx, p); err != nil {
+			return err
+		}
+	}
+	logging.Info("webdav: restored from trash", zap.String("path", t.OriginalPath))
+
+	if dst != t.OriginalPath {
+		return fs.Rename(ctx, t.OriginalPath, dst)
+	}
+	return nil
+}
+
+// purgeTrash permanently deletes the trash item at rest and everything
+// trashed below it.
+func (fs *FruitFS) purgeTrash(ctx context.Context, rest string) error {
+	if rest == "/" {
+		return os.ErrPermission
+	}
+	items, err := fs.trashItems(ctx)
+	if err != nil {
+		return err
+	}
+	t, ok := findTrashItem(items, rest)
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	for _, p := range trashSubtree(items, t) {
+		purged, err := fs.metadata.PurgeFile(ctx, p)
+		if err != nil {
+			return err
+		}
+		for _, d := range purged {
+			if d.S3Key != "" {
+				fs.releaseContent(ctx, d.S3Key, d.StorageLocID, d.GroupID)
+			}
+		}
+	}
+	logging.Info("webdav: purged from trash", zap.String("path", t.OriginalPath))
+	return nil
+}
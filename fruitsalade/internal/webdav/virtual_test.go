@@ -0,0 +1,82 @@
+package webdav
+
+import (
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metadata/postgres"
+)
+
+func TestSplitVirtual(t *testing.T) {
+	tests := []struct {
+		name, root, rest string
+	}{
+		{"/.versions", versionsRoot, "/"},
+		{"/.versions/docs/a.txt/3", versionsRoot, "/docs/a.txt/3"},
+		{"/.trash/a.txt", trashRoot, "/a.txt"},
+		{"/.trashcan", "", "/.trashcan"},
+		{"/docs/.trash", "", "/docs/.trash"},
+	}
+	for _, tt := range tests {
+		root, rest := splitVirtual(tt.name)
+		if root != tt.root || rest != tt.rest {
+			t.Errorf("splitVirtual(%q) = %q, %q; want %q, %q", tt.name, root, rest, tt.root, tt.rest)
+		}
+	}
+}
+
+func TestVirtualAllowed(t *testing.T) {
+	tests := []struct {
+		method, name, dst string
+		want              bool
+	}{
+		{"PROPFIND", "/.versions/a.txt", "", true},
+		{"GET", "/.trash/a.txt", "", true},
+		{"PUT", "/.versions/a.txt/1", "", false},
+		{"PUT", "/.trash/a.txt", "", false},
+		{"MKCOL", "/.trash/new", "", false},
+		{"PROPPATCH", "/.trash/a.txt", "", false},
+		{"LOCK", "/.versions/a.txt/1", "", false},
+		{"DELETE", "/.versions/a.txt/1", "", false},
+		{"DELETE", "/.trash", "", false},
+		{"DELETE", "/.trash/a.txt", "", true},
+		{"MOVE", "/.trash/a.txt", "/a.txt", true},
+		{"MOVE", "/a.txt", "/.trash/a.txt", false},
+		{"MOVE", "/.versions/a.txt/1", "/a.txt", false},
+		{"COPY", "/.versions/a.txt/1", "/old.txt", true},
+		{"COPY", "/a.txt", "/.versions/a.txt/9", false},
+		{"PUT", "/a.txt", "", true},
+	}
+	for _, tt := range tests {
+		if got := virtualAllowed(tt.method, tt.name, tt.dst); got != tt.want {
+			t.Errorf("virtualAllowed(%s %s -> %q) = %v, want %v", tt.method, tt.name, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestTrashNames(t *testing.T) {
+	items := []postgres.TrashRow{
+		{ID: "aaaaaaaa11", Name: "report.txt", OriginalPath: "/a/report.txt"},
+		{ID: "bbbbbbbb22", Name: "report.txt", OriginalPath: "/b/report.txt"},
+		{ID: "cccccccc33", Name: "photos", OriginalPath: "/photos", IsDir: true},
+		{ID: "dddddddd44", Name: "cat.jpg", OriginalPath: "/photos/cat.jpg"},
+	}
+
+	top := trashTop(items)
+	if len(top) != 3 {
+		t.Fatalf("top-level items = %v, want 3", top)
+	}
+	if top["report (aaaaaaaa).txt"].OriginalPath != "/a/report.txt" ||
+		top["report (bbbbbbbb).txt"].OriginalPath != "/b/report.txt" {
+		t.Errorf("clashing names not told apart: %v", top)
+	}
+
+	if it, ok := findTrashItem(items, "/photos/cat.jpg"); !ok || it.ID != "dddddddd44" {
+		t.Errorf("findTrashItem(/photos/cat.jpg) = %v, %v", it, ok)
+	}
+	if _, ok := findTrashItem(items, "/cat.jpg"); ok {
+		t.Error("item below a trashed directory found at the top level")
+	}
+	if got := trashSubtree(items, top["photos"]); len(got) != 2 {
+		t.Errorf("trashSubtree(photos) = %v, want the directory and cat.jpg", got)
+	}
+}
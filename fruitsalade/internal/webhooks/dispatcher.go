@@ -0,0 +1,581 @@
+// Package webhooks sends file events to admin-configured HTTP endpoints as
+// signed JSON POSTs, retrying failed deliveries with exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/logging"
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/metrics"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+)
+
+// Request headers of a delivery. The signature is "sha256=" followed by
+// the hex HMAC-SHA256 of the request body, keyed with the webhook secret.
+const (
+	SignatureHeader = "X-FruitSalade-Signature"
+	EventHeader     = "X-FruitSalade-Event"
+	DeliveryHeader  = "X-FruitSalade-Delivery"
+)
+
+// EventTest is the event type sent by Test.
+const EventTest = "webhook-test"
+
+// Defaults used when Options leaves a value at zero.
+const (
+	DefaultWorkers     = 4
+	DefaultQueueSize   = 1000
+	DefaultMaxAttempts = 8
+	DefaultTimeout     = 10 * time.Second
+)
+
+const (
+	retryBaseDelay = time.Minute
+	retryMaxDelay  = 6 * time.Hour
+
+	// pollInterval is how often due retries and deliveries that did not
+	// fit in the queue are claimed.
+	pollInterval = 5 * time.Second
+	// reloadInterval is how often webhooks changed elsewhere are picked up.
+	reloadInterval = time.Minute
+	// deliveryRetention is how long finished deliveries stay in the log.
+	deliveryRetention = 30 * 24 * time.Hour
+	// maxResponseBody caps how much of a response is read; the start of
+	// an error response is kept as the delivery's last error.
+	maxResponseBody = 64 << 10
+	maxErrorBody    = 512
+)
+
+// skippedEvents are stream control events that are never forwarded.
+var skippedEvents = map[string]bool{
+	events.EventShutdown:       true,
+	events.EventResyncRequired: true,
+}
+
+// RetryDelay returns how long to wait before retrying a delivery that has
+// failed attempts times: retryBaseDelay doubled per earlier failure,
+// capped at retryMaxDelay.
+func RetryDelay(attempts int) time.Duration {
+	return retry.Backoff(attempts, retryBaseDelay, retryMaxDelay)
+}
+
+// Sign returns the signature header value of body for secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateSecret returns a random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Validate checks a webhook before it is saved and cleans up its path
+// prefix: the URL must be absolute http(s), and event types must be ones
+// the server publishes.
+func (h *Webhook) Validate() error {
+	if strings.TrimSpace(h.Name) == "" {
+		return errors.New("name is required")
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	for _, t := range h.EventTypes {
+		e := events.Event{Type: t}
+		if !e.Known() || skippedEvents[t] {
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	if h.PathPrefix != "" {
+		h.PathPrefix = path.Clean("/" + h.PathPrefix)
+	}
+	return nil
+}
+
+// Matches reports whether an event is sent to the webhook: it must be
+// enabled, list the event type (or no types at all) and, with a path
+// prefix, the event path must be the prefix or lie below it.
+func (h *Webhook) Matches(e events.Event) bool {
+	if !h.Enabled || skippedEvents[e.Type] {
+		return false
+	}
+	if len(h.EventTypes) > 0 && !slices.Contains(h.EventTypes, e.Type) {
+		return false
+	}
+	if h.PathPrefix != "" {
+		prefix := strings.TrimSuffix(h.PathPrefix, "/")
+		if e.Path == "" {
+			return false
+		}
+		return prefix == "" || e.Path == prefix || strings.HasPrefix(e.Path, prefix+"/")
+	}
+	return true
+}
+
+// Payload is the JSON body POSTed to a webhook.
+type Payload struct {
+	DeliveryID int64           `json:"delivery_id"`
+	WebhookID  int             `json:"webhook_id"`
+	Attempt    int             `json:"attempt"`
+	Event      json.RawMessage `json:"event"` // protocol.Event
+}
+
+// Options bounds a Dispatcher.
+type Options struct {
+	Workers     int           // concurrent deliveries
+	QueueSize   int           // events and deliveries waiting in memory
+	MaxAttempts int           // attempts before a delivery is dead
+	Timeout     time.Duration // per attempt
+
+	// AllowInternal lets webhooks reach loopback, link-local and private
+	// addresses, which are refused by default.
+	AllowInternal bool
+}
+
+// errInternalAddress is the delivery error for a webhook whose host
+// resolves to an address refused without Options.AllowInternal.
+var errInternalAddress = errors.New("webhook address is internal (set WEBHOOK_ALLOW_INTERNAL to allow it)")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// refuseInternal is a net.Dialer Control function that refuses internal
+// addresses. It runs after name resolution, so a host name that resolves
+// to one, or is changed to, is refused as well.
+func refuseInternal(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", errInternalAddress, ip)
+	}
+	return nil
+}
+
+type job struct {
+	delivery Delivery
+	hook     Webhook
+}
+
+// Dispatcher subscribes to the event broadcaster, logs a delivery for every
+// webhook an event matches and POSTs it on a fixed pool of workers.
+// Everything it holds in memory is bounded: events that arrive faster than
+// they can be logged are dropped, and deliveries that do not fit in the
+// queue wait in the database until a worker is free. A dead endpoint
+// therefore costs at most Workers blocked requests of Timeout each.
+type Dispatcher struct {
+	store       *Store
+	broadcaster *events.Broadcaster
+	client      *http.Client
+	workers     int
+	maxAttempts int
+
+	incoming chan events.Event
+	queue    chan job
+	sub      chan events.Event
+	wg       sync.WaitGroup
+	cancel   context.CancelFunc
+
+	mu    sync.RWMutex
+	hooks []Webhook
+}
+
+// NewDispatcher creates a Dispatcher. Zero options take the defaults.
+func NewDispatcher(store *Store, broadcaster *events.Broadcaster, opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !opts.AllowInternal {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: refuseInternal}
+		transport.DialContext = dialer.DialContext
+		// A proxy would make the request to the internal address instead
+		transport.Proxy = nil
+	}
+	return &Dispatcher{
+		store:       store,
+		broadcaster: broadcaster,
+		client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: transport,
+			// A redirect is a failed delivery, not a reason to resend the
+			// event somewhere else.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		workers:     opts.Workers,
+		maxAttempts: opts.MaxAttempts,
+		incoming:    make(chan events.Event, opts.QueueSize),
+		queue:       make(chan job, opts.QueueSize),
+	}
+}
+
+// Start loads the webhooks, requeues deliveries interrupted by a previous
+// shutdown and starts consuming events.
+func (d *Dispatcher) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+
+	if n, err := d.store.ResetSending(ctx); err != nil {
+		logging.Error("failed to requeue webhook deliveries", zap.Error(err))
+	} else if n > 0 {
+		logging.Info("requeued interrupted webhook deliveries", zap.Int64("count", n))
+	}
+	if err := d.Reload(ctx); err != nil {
+		logging.Error("failed to load webhooks", zap.Error(err))
+	}
+
+	d.sub = d.broadcaster.Subscribe()
+	d.wg.Add(3 + d.workers)
+	go d.receive()
+	go d.record(ctx)
+	go d.poll(ctx)
+	for i := 0; i < d.workers; i++ {
+		go d.worker(ctx)
+	}
+	logging.Info("webhook dispatcher started",
+		zap.Int("workers", d.workers),
+		zap.Int("queue_size", cap(d.queue)),
+		zap.Int("max_attempts", d.maxAttempts))
+}
+
+// Stop stops consuming events and waits for the workers. Deliveries still
+// queued are sent after the next Start.
+func (d *Dispatcher) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	if d.sub != nil {
+		d.broadcaster.Unsubscribe(d.sub)
+	}
+	d.wg.Wait()
+	logging.Info("webhook dispatcher stopped")
+}
+
+// Reload re-reads the webhooks from the database. The API calls it after
+// every change.
+func (d *Dispatcher) Reload(ctx context.Context) error {
+	hooks, err := d.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.hooks = hooks
+	d.mu.Unlock()
+	return nil
+}
+
+// matching returns the webhooks e is sent to.
+func (d *Dispatcher) matching(e events.Event) []Webhook {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var hooks []Webhook
+	for _, h := range d.hooks {
+		if h.Matches(e) {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// lookup returns the webhook with the given ID from the cache, falling
+// back to the database.
+func (d *Dispatcher) lookup(ctx context.Context, id int) (*Webhook, error) {
+	d.mu.RLock()
+	for _, h := range d.hooks {
+		if h.ID == id {
+			d.mu.RUnlock()
+			return &h, nil
+		}
+	}
+	d.mu.RUnlock()
+	return d.store.Get(ctx, id)
+}
+
+// receive moves events from the broadcaster to the incoming buffer without
+// blocking, so the broadcaster never drops events for this subscriber
+// while deliveries are being logged.
+func (d *Dispatcher) receive() {
+	defer d.wg.Done()
+	for e := range d.sub {
+		select {
+		case d.incoming <- e:
+		default:
+			logging.Warn("webhook event buffer full, dropping event",
+				zap.String("type", e.Type), zap.String("path", e.Path))
+		}
+	}
+}
+
+// record logs a delivery per matching webhook for every incoming event
+// and queues it.
+func (d *Dispatcher) record(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-d.incoming:
+			hooks := d.matching(e)
+			if len(hooks) == 0 {
+				continue
+			}
+			payload, err := json.Marshal(e)
+			if err != nil {
+				logging.Error("failed to encode webhook event", zap.Error(err))
+				continue
+			}
+			for _, h := range hooks {
+				del, err := d.store.CreateDelivery(ctx, h.ID, e.Type, payload, StatusSending)
+				if err != nil {
+					logging.Error("failed to log webhook delivery",
+						zap.Int("webhook_id", h.ID), zap.Error(err))
+					continue
+				}
+				d.enqueue(ctx, job{delivery: *del, hook: h})
+			}
+		}
+	}
+}
+
+// enqueue hands a claimed delivery to the workers, or back to the database
+// when the queue is full.
+func (d *Dispatcher) enqueue(ctx context.Context, j job) {
+	select {
+	case d.queue <- j:
+		metrics.SetWebhookQueueDepth(len(d.queue))
+	default:
+		if err := d.store.SetPending(ctx, j.delivery.ID); err != nil {
+			logging.Error("failed to defer webhook delivery",
+				zap.Int64("delivery_id", j.delivery.ID), zap.Error(err))
+		}
+	}
+}
+
+// poll claims due deliveries while the queue has room, and periodically
+// reloads webhooks and prunes the delivery log.
+func (d *Dispatcher) poll(ctx context.Context) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastReload, lastPrune := time.Now(), time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(lastReload) >= reloadInterval {
+			if err := d.Reload(ctx); err != nil {
+				logging.Warn("failed to reload webhooks", zap.Error(err))
+			}
+			lastReload = time.Now()
+		}
+		if time.Since(lastPrune) >= time.Hour {
+			if n, err := d.store.PruneDeliveries(ctx, deliveryRetention); err != nil {
+				logging.Warn("failed to prune webhook deliveries", zap.Error(err))
+			} else if n > 0 {
+				logging.Info("pruned webhook deliveries", zap.Int64("count", n))
+			}
+			lastPrune = time.Now()
+		}
+
+		free := cap(d.queue) - len(d.queue)
+		if free <= 0 {
+			continue
+		}
+		due, err := d.store.ClaimDue(ctx, free)
+		if err != nil {
+			logging.Warn("failed to claim webhook deliveries", zap.Error(err))
+			continue
+		}
+		for _, del := range due {
+			h, err := d.lookup(ctx, del.WebhookID)
+			if err != nil || h == nil {
+				// Deleting a webhook deletes its deliveries, so this is a
+				// lookup failure; try again on the next tick
+				d.store.SetPending(ctx, del.ID)
+				continue
+			}
+			d.enqueue(ctx, job{delivery: del, hook: *h})
+		}
+	}
+}
+
+func (d *Dispatcher) worker(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-d.queue:
+			metrics.SetWebhookQueueDepth(len(d.queue))
+			d.deliver(ctx, j)
+		}
+	}
+}
+
+// deliver makes one attempt at a delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, j job) {
+	status, err := d.post(ctx, j.hook, j.delivery)
+	if ctx.Err() != nil {
+		// Shutting down: left 'sending' and requeued by the next Start
+		return
+	}
+	if err == nil {
+		if err := d.store.RecordSuccess(ctx, j.delivery.ID, status); err != nil {
+			logging.Error("failed to record webhook delivery",
+				zap.Int64("delivery_id", j.delivery.ID), zap.Error(err))
+		}
+		metrics.RecordWebhookAttempt(StatusDelivered)
+		return
+	}
+
+	outcome, rerr := d.store.RecordFailure(ctx, j.delivery.ID, status, err.Error(), d.maxAttempts)
+	if rerr != nil {
+		logging.Error("failed to record webhook failure",
+			zap.Int64("delivery_id", j.delivery.ID), zap.Error(rerr))
+		return
+	}
+	metrics.RecordWebhookAttempt(outcome)
+	if outcome == StatusDead {
+		logging.Warn("webhook delivery dead-lettered",
+			zap.Int("webhook_id", j.hook.ID),
+			zap.Int64("delivery_id", j.delivery.ID),
+			zap.String("url", j.hook.URL),
+			zap.Error(err))
+	} else {
+		logging.Debug("webhook delivery failed, will retry",
+			zap.Int("webhook_id", j.hook.ID),
+			zap.Int64("delivery_id", j.delivery.ID),
+			zap.Error(err))
+	}
+}
+
+// post sends one attempt of a delivery and returns the response status (0
+// if there was no response). Any status outside 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, h Webhook, del Delivery) (int, error) {
+	body, err := json.Marshal(Payload{
+		DeliveryID: del.ID,
+		WebhookID:  h.ID,
+		Attempt:    del.Attempts + 1,
+		Event:      del.Payload,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("encode payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FruitSalade-Webhooks")
+	req.Header.Set(EventHeader, del.EventType)
+	req.Header.Set(DeliveryHeader, fmt.Sprint(del.ID))
+	req.Header.Set(SignatureHeader, Sign(h.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := fmt.Sprintf("HTTP %d", resp.StatusCode)
+		if s := strings.TrimSpace(string(snippet)); s != "" {
+			msg += ": " + s
+		}
+		return resp.StatusCode, fmt.Errorf("%s", msg)
+	}
+	return resp.StatusCode, nil
+}
+
+// Test sends a "webhook-test" event to h right away and returns the logged
+// delivery. A failed test is dead at once rather than retried; the error
+// only reports failures to log it.
+func (d *Dispatcher) Test(ctx context.Context, h *Webhook) (*Delivery, error) {
+	e := events.Event{
+		Schema:    protocol.EventSchemaVersion,
+		Type:      EventTest,
+		Path:      h.PathPrefix,
+		Timestamp: time.Now().Unix(),
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	del, err := d.store.CreateDelivery(ctx, h.ID, e.Type, payload, StatusSending)
+	if err != nil {
+		return nil, err
+	}
+
+	status, postErr := d.post(ctx, *h, *del)
+	del.Attempts = 1
+	del.NextAttemptAt = nil
+	if status != 0 {
+		del.ResponseStatus = &status
+	}
+	if postErr == nil {
+		del.Status = StatusDelivered
+		err = d.store.RecordSuccess(ctx, del.ID, status)
+	} else {
+		del.Status = StatusDead
+		del.LastError = postErr.Error()
+		_, err = d.store.RecordFailure(ctx, del.ID, status, del.LastError, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return del, nil
+}
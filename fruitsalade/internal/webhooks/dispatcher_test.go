@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/fruitsalade/internal/events"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{1000, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"a":1}' | openssl dgst -sha256 -hmac secret
+	got := Sign("secret", []byte(`{"a":1}`))
+	want := "sha256=aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494"
+	if got != want {
+		t.Errorf("Sign = %q, want %q", got, want)
+	}
+	if Sign("other", []byte(`{"a":1}`)) == got {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		hook Webhook
+		e    events.Event
+		want bool
+	}{
+		{"all events", Webhook{Enabled: true}, events.Event{Type: events.EventCreate, Path: "/a"}, true},
+		{"disabled", Webhook{}, events.Event{Type: events.EventCreate, Path: "/a"}, false},
+		{"listed type", Webhook{Enabled: true, EventTypes: []string{"create", "delete"}},
+			events.Event{Type: events.EventDelete, Path: "/a"}, true},
+		{"unlisted type", Webhook{Enabled: true, EventTypes: []string{"create"}},
+			events.Event{Type: events.EventModify, Path: "/a"}, false},
+		{"control event", Webhook{Enabled: true}, events.Event{Type: events.EventShutdown}, false},
+		{"under prefix", Webhook{Enabled: true, PathPrefix: "/docs"},
+			events.Event{Type: events.EventCreate, Path: "/docs/a.txt"}, true},
+		{"prefix itself", Webhook{Enabled: true, PathPrefix: "/docs/"},
+			events.Event{Type: events.EventDelete, Path: "/docs"}, true},
+		{"sibling of prefix", Webhook{Enabled: true, PathPrefix: "/docs"},
+			events.Event{Type: events.EventCreate, Path: "/docs2/a.txt"}, false},
+		{"no path with prefix", Webhook{Enabled: true, PathPrefix: "/docs"},
+			events.Event{Type: events.EventStorageHealth}, false},
+		{"root prefix", Webhook{Enabled: true, PathPrefix: "/"},
+			events.Event{Type: events.EventCreate, Path: "/a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hook.Matches(tt.e); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	valid := Webhook{Name: "ci", URL: "https://example.com/hook", EventTypes: []string{"create"}, PathPrefix: "docs/"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if valid.PathPrefix != "/docs" {
+		t.Errorf("PathPrefix = %q, want /docs", valid.PathPrefix)
+	}
+
+	for _, h := range []Webhook{
+		{URL: "https://example.com/hook"},
+		{Name: "ci", URL: "ftp://example.com/hook"},
+		{Name: "ci", URL: "/relative"},
+		{Name: "ci", URL: "https://example.com", EventTypes: []string{"bogus"}},
+		{Name: "ci", URL: "https://example.com", EventTypes: []string{events.EventShutdown}},
+	} {
+		if err := h.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", h)
+		}
+	}
+}
+
+func TestPostSignsPayload(t *testing.T) {
+	var gotBody []byte
+	var gotHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotHeader = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil, nil, Options{AllowInternal: true})
+	hook := Webhook{ID: 3, URL: srv.URL, Secret: "s3cret"}
+	del := Delivery{ID: 42, EventType: "create", Payload: json.RawMessage(`{"type":"create","path":"/a"}`), Attempts: 1}
+
+	status, err := d.post(context.Background(), hook, del)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("post = %d, %v", status, err)
+	}
+	if sig := gotHeader.Get(SignatureHeader); sig != Sign("s3cret", gotBody) {
+		t.Errorf("signature %q does not match body", sig)
+	}
+	if gotHeader.Get(EventHeader) != "create" || gotHeader.Get(DeliveryHeader) != "42" {
+		t.Errorf("headers = %v", gotHeader)
+	}
+
+	var p Payload
+	if err := json.Unmarshal(gotBody, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.DeliveryID != 42 || p.WebhookID != 3 || p.Attempt != 2 {
+		t.Errorf("payload = %+v", p)
+	}
+	var e events.Event
+	if err := json.Unmarshal(p.Event, &e); err != nil || e.Path != "/a" {
+		t.Errorf("event = %+v, %v", e, err)
+	}
+}
+
+func TestPostFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil, nil, Options{Timeout: 50 * time.Millisecond, AllowInternal: true})
+	del := Delivery{ID: 1, Payload: json.RawMessage(`{}`)}
+
+	status, err := d.post(context.Background(), Webhook{URL: srv.URL + "/error"}, del)
+	if err == nil || status != http.StatusInternalServerError || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("error response: %d, %v", status, err)
+	}
+	status, err = d.post(context.Background(), Webhook{URL: srv.URL + "/redirect"}, del)
+	if err == nil || status != http.StatusFound {
+		t.Errorf("redirect was followed: %d, %v", status, err)
+	}
+	status, err = d.post(context.Background(), Webhook{URL: srv.URL + "/slow"}, del)
+	if err == nil || status != 0 {
+		t.Errorf("timeout not reported: %d, %v", status, err)
+	}
+}
+
+func TestPostRefusesInternal(t *testing.T) {
+	var called bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil, nil, Options{})
+	del := Delivery{ID: 1, Payload: json.RawMessage(`{}`)}
+	for _, u := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		if _, err := d.post(context.Background(), Webhook{URL: u}, del); !errors.Is(err, errInternalAddress) {
+			t.Errorf("post to %s: err = %v, want errInternalAddress", u, err)
+		}
+	}
+	if called {
+		t.Error("internal endpoint was called")
+	}
+
+	for _, addr := range []string{"10.0.0.1:80", "192.168.1.1:443", "169.254.169.254:80", "[::1]:80", "[fe80::1]:80", "0.0.0.0:80", "100.64.0.1:80"} {
+		if err := refuseInternal("tcp", addr, nil); !errors.Is(err, errInternalAddress) {
+			t.Errorf("refuseInternal(%s) = %v", addr, err)
+		}
+	}
+	if err := refuseInternal("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("refuseInternal(public) = %v", err)
+	}
+}
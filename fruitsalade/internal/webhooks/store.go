@@ -0,0 +1,303 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Delivery statuses. A delivery is created 'pending' (or 'sending' when it
+// goes straight to a worker), moves to 'retry' with a next attempt time
+// after a failure, and ends 'delivered' or, once it has used up its
+// attempts, 'dead'.
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusRetry     = "retry"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Webhook is a row in the webhooks table.
+type Webhook struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"` // empty = all
+	PathPrefix string    `json:"path_prefix,omitempty"`
+	Enabled    bool      `json:"enabled"`
+	CreatedBy  *int      `json:"created_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Delivery is a row in the webhook_deliveries table: one event sent, or to
+// be sent, to one webhook.
+type Delivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// Store provides access to the webhooks and webhook_deliveries tables.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a new Store.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const webhookColumns = `id, name, url, secret, event_types, path_prefix, enabled, created_by, created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	var h Webhook
+	var createdBy sql.NullInt64
+	if err := row.Scan(&h.ID, &h.Name, &h.URL, &h.Secret, pq.Array(&h.EventTypes),
+		&h.PathPrefix, &h.Enabled, &createdBy, &h.CreatedAt, &h.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		h.CreatedBy = &id
+	}
+	if h.EventTypes == nil {
+		h.EventTypes = []string{}
+	}
+	return &h, nil
+}
+
+// Create inserts a webhook and fills in its ID and timestamps.
+func (s *Store) Create(ctx context.Context, h *Webhook) (*Webhook, error) {
+	if h.EventTypes == nil {
+		h.EventTypes = []string{}
+	}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (name, url, secret, event_types, path_prefix, enabled, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at`,
+		h.Name, h.URL, h.Secret, pq.Array(h.EventTypes), h.PathPrefix, h.Enabled, h.CreatedBy,
+	).Scan(&h.ID, &h.CreatedAt, &h.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create webhook: %w", err)
+	}
+	return h, nil
+}
+
+// Get returns the webhook with the given ID, or nil if there is none.
+func (s *Store) Get(ctx context.Context, id int) (*Webhook, error) {
+	h, err := scanWebhook(s.db.QueryRowContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook: %w", err)
+	}
+	return h, nil
+}
+
+// List returns all webhooks ordered by name.
+func (s *Store) List(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+webhookColumns+` FROM webhooks ORDER BY name, id`)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook: %w", err)
+		}
+		hooks = append(hooks, *h)
+	}
+	return hooks, rows.Err()
+}
+
+// Update saves the name, URL, secret, filters and enabled flag of h.
+func (s *Store) Update(ctx context.Context, h *Webhook) error {
+	if h.EventTypes == nil {
+		h.EventTypes = []string{}
+	}
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE webhooks SET name = $2, url = $3, secret = $4, event_types = $5,
+			path_prefix = $6, enabled = $7, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at`,
+		h.ID, h.Name, h.URL, h.Secret, pq.Array(h.EventTypes), h.PathPrefix, h.Enabled,
+	).Scan(&h.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("update webhook: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a webhook and its delivery log.
+func (s *Store) Delete(ctx context.Context, id int) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	return nil
+}
+
+// CreateDelivery logs a new delivery of payload to a webhook in the given
+// status ('pending' or 'sending'), due now, and returns it.
+func (s *Store) CreateDelivery(ctx context.Context, webhookID int, eventType string, payload []byte, status string) (*Delivery, error) {
+	d := &Delivery{WebhookID: webhookID, EventType: eventType, Payload: payload, Status: status}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, next_attempt_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id, next_attempt_at, created_at, updated_at`,
+		webhookID, eventType, payload, status,
+	).Scan(&d.ID, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create delivery: %w", err)
+	}
+	return d, nil
+}
+
+// SetPending hands a delivery that could not be queued back to ClaimDue.
+func (s *Store) SetPending(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $2, updated_at = NOW() WHERE id = $1`,
+		id, StatusPending)
+	return err
+}
+
+// ResetSending returns deliveries left 'sending' by a previous process to
+// 'pending', so they are claimed again.
+func (s *Store) ResetSending(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $1, updated_at = NOW() WHERE status = $2`,
+		StatusPending, StatusSending)
+	if err != nil {
+		return 0, fmt.Errorf("reset deliveries: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ClaimDue moves up to limit pending or retrying deliveries that are due
+// to 'sending' and returns them, oldest due first. Deliveries of disabled
+// webhooks wait until the webhook is enabled again.
+func (s *Store) ClaimDue(ctx context.Context, limit int) ([]Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET status = $1, updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status IN ($2, $3) AND next_attempt_at <= NOW()
+			  AND webhook_id IN (SELECT id FROM webhooks WHERE enabled)
+			ORDER BY next_attempt_at LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook_id, event_type, payload, status, attempts,
+			response_status, last_error, next_attempt_at, created_at, updated_at`,
+		StatusSending, StatusPending, StatusRetry, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim deliveries: %w", err)
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+// RecordSuccess marks a delivery as delivered.
+func (s *Store) RecordSuccess(ctx context.Context, id int64, responseStatus int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1,
+			response_status = $3, last_error = '', next_attempt_at = NULL, updated_at = NOW()
+		WHERE id = $1`, id, StatusDelivered, responseStatus)
+	return err
+}
+
+// RecordFailure counts a failed attempt at a delivery. It puts the
+// delivery in 'retry' with its next attempt time, or in 'dead' once it
+// has failed maxAttempts times, and returns the new status. A
+// responseStatus of 0 means no response was received.
+func (s *Store) RecordFailure(ctx context.Context, id int64, responseStatus int, errMsg string, maxAttempts int) (string, error) {
+	var attempts int
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE webhook_deliveries SET attempts = attempts + 1,
+			response_status = NULLIF($2, 0), last_error = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING attempts`, id, responseStatus, errMsg).Scan(&attempts)
+	if err != nil {
+		return "", err
+	}
+
+	if attempts >= maxAttempts {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = $2, next_attempt_at = NULL WHERE id = $1`,
+			id, StatusDead)
+		return StatusDead, err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $2, next_attempt_at = $3 WHERE id = $1`,
+		id, StatusRetry, time.Now().Add(RetryDelay(attempts)))
+	return StatusRetry, err
+}
+
+// ListDeliveries returns the newest deliveries of a webhook, optionally
+// only those in one status. before, if non-zero, pages to deliveries with
+// a smaller ID.
+func (s *Store) ListDeliveries(ctx context.Context, webhookID int, status string, before int64, limit int) ([]Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, webhook_id, event_type, payload, status, attempts,
+			response_status, last_error, next_attempt_at, created_at, updated_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4`, webhookID, status, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list deliveries: %w", err)
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+// PruneDeliveries deletes delivered and dead deliveries older than
+// maxAge and returns how many were removed.
+func (s *Store) PruneDeliveries(ctx context.Context, maxAge time.Duration) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status IN ($1, $2) AND updated_at < $3`,
+		StatusDelivered, StatusDead, time.Now().Add(-maxAge))
+	if err != nil {
+		return 0, fmt.Errorf("prune deliveries: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func scanDeliveries(rows *sql.Rows) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var respStatus sql.NullInt64
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &payload, &d.Status, &d.Attempts,
+			&respStatus, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+		d.Payload = payload
+		if respStatus.Valid {
+			code := int(respStatus.Int64)
+			d.ResponseStatus = &code
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
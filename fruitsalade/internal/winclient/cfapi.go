@@ -0,0 +1,56 @@
+package winclient
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// hydrationChunkSize is the size of the ranged requests used to hydrate a
+// placeholder; progress is reported to Explorer after each one. It is a
+// multiple of 4 KiB, as CfAPI requires for every transfer but the last.
+const hydrationChunkSize = 4 << 20
+
+// localSyncInterval is how often the sync root is scanned for local
+// changes, new files and pin state changes made in Explorer.
+const localSyncInterval = 5 * time.Second
+
+// serverPathOf maps a path below syncRoot to its server path ("/a/b.txt").
+// It returns false for paths outside the sync root and the root itself.
+func serverPathOf(syncRoot, localPath string) (string, bool) {
+	rel, err := filepath.Rel(syncRoot, localPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), true
+}
+
+// localPathOf maps a server path to its location below syncRoot.
+func localPathOf(syncRoot, serverPath string) string {
+	return filepath.Join(syncRoot, filepath.FromSlash(strings.TrimPrefix(serverPath, "/")))
+}
+
+// sortParentsFirst orders nodes so that every directory comes before its
+// contents, which is the order placeholders must be created in.
+func sortParentsFirst(nodes []*models.FileNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		di, dj := strings.Count(nodes[i].Path, "/"), strings.Count(nodes[j].Path, "/")
+		if di != dj {
+			return di < dj
+		}
+		return nodes[i].Path < nodes[j].Path
+	})
+}
+
+// sortChildrenFirst orders nodes so that contents come before their
+// directory, which is the order placeholders must be removed in.
+func sortChildrenFirst(nodes []*models.FileNode) {
+	sortParentsFirst(nodes)
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+}
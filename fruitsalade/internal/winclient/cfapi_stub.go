@@ -1,4 +1,4 @@
-//go:build !windows
+//go:build !windows || !cgo
 
 package winclient
 
@@ -7,7 +7,7 @@ import (
 	"fmt"
 )
 
-// CfAPIBackend is a stub for non-Windows platforms.
+// CfAPIBackend is a stub for non-Windows platforms and builds without cgo.
 type CfAPIBackend struct {
 	syncRoot string
 }
@@ -22,9 +22,9 @@ func (b *CfAPIBackend) Name() string {
 }
 
 func (b *CfAPIBackend) Start(ctx context.Context, core *ClientCore) error {
-	return fmt.Errorf("CfAPI is only available on Windows")
+	return fmt.Errorf("CfAPI is only available in Windows builds with cgo")
 }
 
 func (b *CfAPIBackend) Stop() error {
-	return fmt.Errorf("CfAPI is only available on Windows")
+	return fmt.Errorf("CfAPI is only available in Windows builds with cgo")
 }
@@ -0,0 +1,87 @@
+package winclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+func TestServerPathOf(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "FruitSalade")
+
+	tests := []struct {
+		local string
+		want  string
+		ok    bool
+	}{
+		{filepath.Join(root, "docs", "a.txt"), "/docs/a.txt", true},
+		{filepath.Join(root, "top.txt"), "/top.txt", true},
+		{root, "", false},
+		{filepath.Dir(root), "", false},
+		{filepath.Join(filepath.Dir(root), "other", "x"), "", false},
+	}
+	for _, tt := range tests {
+		got, ok := serverPathOf(root, tt.local)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("serverPathOf(%q) = %q, %v; want %q, %v", tt.local, got, ok, tt.want, tt.ok)
+		}
+		if ok && localPathOf(root, got) != tt.local {
+			t.Errorf("localPathOf(%q) = %q, want %q", got, localPathOf(root, got), tt.local)
+		}
+	}
+}
+
+func TestSortParentsFirst(t *testing.T) {
+	nodes := []*models.FileNode{
+		{Path: "/a/b/c.txt"}, {Path: "/z.txt"}, {Path: "/a/b"}, {Path: "/a"},
+	}
+
+	sortParentsFirst(nodes)
+	if got := pathsOf(nodes); got[0] != "/a" || got[1] != "/z.txt" || got[2] != "/a/b" || got[3] != "/a/b/c.txt" {
+		t.Errorf("parents first = %v", got)
+	}
+
+	sortChildrenFirst(nodes)
+	if got := pathsOf(nodes); got[0] != "/a/b/c.txt" || got[3] != "/a" {
+		t.Errorf("children first = %v", got)
+	}
+}
+
+func TestOnChange(t *testing.T) {
+	files := []*models.FileNode{{ID: "a", Path: "/a.txt", Name: "a.txt", Size: 1}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(protocol.TreeResponse{Root: &models.FileNode{
+			ID: "/", Path: "/", IsDir: true, Children: files,
+		}})
+	}))
+	defer ts.Close()
+
+	core, err := NewClientCore(CoreConfig{ServerURL: ts.URL, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewClientCore: %v", err)
+	}
+	if err := core.FetchMetadata(context.Background()); err != nil {
+		t.Fatalf("FetchMetadata: %v", err)
+	}
+
+	var diffs []*MetadataDiff
+	core.OnChange(func(d *MetadataDiff) { diffs = append(diffs, d) })
+
+	// An unchanged tree notifies nobody
+	core.RefreshMetadata(context.Background())
+	if len(diffs) != 0 {
+		t.Fatalf("got %d notifications for an unchanged tree", len(diffs))
+	}
+
+	files = append(files, &models.FileNode{ID: "b", Path: "/b.txt", Name: "b.txt"})
+	core.RefreshMetadata(context.Background())
+	if len(diffs) != 1 || len(diffs[0].Added) != 1 || diffs[0].Added[0].Path != "/b.txt" {
+		t.Errorf("diffs = %+v, want /b.txt added", diffs)
+	}
+}
@@ -1,4 +1,4 @@
-//go:build windows
+//go:build windows && cgo
 
 package winclient
 
@@ -6,6 +6,7 @@ package winclient
 #cgo CFLAGS: -I${SRCDIR}/../../../windows
 #cgo LDFLAGS: -lcldapi -lole32
 
+#include <stdlib.h>
 #include "cfapi_shim.h"
 */
 import "C"
@@ -13,38 +14,78 @@ import "C"
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// HRESULTs the backend checks for or reports.
+const (
+	hrAlreadyExists = 0x800700B7  // HRESULT_FROM_WIN32(ERROR_ALREADY_EXISTS)
+	hrFileExists    = 0x80070050  // HRESULT_FROM_WIN32(ERROR_FILE_EXISTS)
+	eFail           = -0x7fffbffb // E_FAIL (0x80004005) as a signed HRESULT
 )
 
 // CfAPIBackend implements Backend using Windows Cloud Files API.
+//
+// Every node of the metadata tree is a placeholder in the sync root.
+// Opening one hydrates it through ranged content requests; hydrated files
+// are tracked in the cache so that cold ones are dehydrated when the cache
+// exceeds its size, and Explorer's "Always keep on this device" and "Free
+// up space" map onto cache pins. Local edits are uploaded when a file is
+// closed, and metadata changes (refreshes and SSE events) update or remove
+// placeholders.
 type CfAPIBackend struct {
 	syncRoot string
 	core     *ClientCore
 	connKey  C.CF_CONNECTION_KEY
+	ctx      context.Context
 
 	mu        sync.Mutex
 	connected bool
+
+	transfersMu sync.Mutex
+	transfers   map[int64]context.CancelFunc // pending hydrations by transfer key
+
+	// syncMu serializes local change handling with server changes
+	syncMu   sync.Mutex
+	synced   map[string]string // server path -> hash of our last upload
+	pinState map[string]int    // server path -> last seen pin flags
+	closed   chan string       // closed placeholders to check for edits
 }
 
 // NewCfAPIBackend creates a CfAPI backend.
 func NewCfAPIBackend(syncRoot string) *CfAPIBackend {
-	return &CfAPIBackend{syncRoot: syncRoot}
+	return &CfAPIBackend{
+		syncRoot:  syncRoot,
+		transfers: make(map[int64]context.CancelFunc),
+		synced:    make(map[string]string),
+		pinState:  make(map[string]int),
+		closed:    make(chan string, 64),
+	}
 }
 
 func (b *CfAPIBackend) Name() string {
 	return "cfapi"
 }
 
-// globalCfAPIBackend is used by the CGO callback to route hydration requests.
+// globalCfAPIBackend is used by the CGO callbacks to route requests.
 var globalCfAPIBackend *CfAPIBackend
 
 func (b *CfAPIBackend) Start(ctx context.Context, core *ClientCore) error {
 	b.core = core
+	b.ctx = ctx
 	globalCfAPIBackend = b
 
 	if err := os.MkdirAll(b.syncRoot, 0755); err != nil {
@@ -75,22 +116,28 @@ func (b *CfAPIBackend) Start(ctx context.Context, core *ClientCore) error {
 	if hr != 0 {
 		return fmt.Errorf("cfapi_connect_sync_root failed: HRESULT 0x%08x", uint32(hr))
 	}
+	b.mu.Lock()
 	b.connected = true
+	b.mu.Unlock()
+
+	// Hydrated files count against the cache; evicting one dehydrates it
+	core.Cache.SetEvictFunc(b.dehydrate)
+	if err := core.Cache.LoadPins(); err != nil {
+		logger.Error("Failed to load pins: %v", err)
+	}
 
 	// Fetch metadata and create placeholders
 	if err := core.FetchMetadata(ctx); err != nil {
 		return fmt.Errorf("initial metadata fetch: %w", err)
 	}
 
-	tree := core.Metadata()
-	if tree != nil {
-		b.createPlaceholdersRecursive(tree, b.syncRoot)
+	if root := core.Metadata(); root != nil {
+		b.createPlaceholdersRecursive(root)
 	}
 
+	core.OnChange(b.applyDiff)
 	core.StartBackgroundLoops(ctx)
-
-	// Watch for metadata changes in background
-	go b.watchMetadataChanges(ctx)
+	go b.localSyncLoop(ctx)
 
 	logger.Info("CfAPI backend started at %s", b.syncRoot)
 
@@ -106,11 +153,12 @@ func (b *CfAPIBackend) Stop() error {
 
 	if b.core != nil {
 		b.core.StopBackgroundLoops()
+		if err := b.core.Cache.SavePins(); err != nil {
+			logger.Error("Failed to persist pins: %v", err)
+		}
 	}
 
 	if b.connected {
-		cRoot := C.CString(b.syncRoot)
-		defer C.free(unsafe.Pointer(cRoot))
 		C.cfapi_disconnect_sync_root(b.connKey)
 		b.connected = false
 	}
@@ -118,97 +166,285 @@ func (b *CfAPIBackend) Stop() error {
 	return nil
 }
 
-func (b *CfAPIBackend) createPlaceholdersRecursive(node *models.FileNode, localDir string) {
-	for _, child := range node.Children {
-		localPath := localDir + string(os.PathSeparator) + child.Name
+// --- Placeholders ---
 
-		cPath := C.CString(localDir)
-		cName := C.CString(child.Name)
-		cID := C.CString(child.ID)
-		isDir := C.int(0)
+func (b *CfAPIBackend) createPlaceholdersRecursive(node *models.FileNode) {
+	for _, child := range node.Children {
+		b.createPlaceholder(child)
 		if child.IsDir {
-			isDir = 1
+			b.createPlaceholdersRecursive(child)
 		}
+	}
+}
+
+// createPlaceholder creates the placeholder for node, or updates it when it
+// exists from a previous run.
+func (b *CfAPIBackend) createPlaceholder(node *models.FileNode) {
+	localPath := localPathOf(b.syncRoot, node.Path)
+
+	cPath := C.CString(filepath.Dir(localPath))
+	cName := C.CString(node.Name)
+	cID := C.CString(node.ID)
+	isDir := C.int(0)
+	if node.IsDir {
+		isDir = 1
+	}
+	hr := C.cfapi_create_placeholder(cPath, cName, cID,
+		C.longlong(node.Size), C.longlong(node.ModTime.Unix()), isDir)
+	C.free(unsafe.Pointer(cPath))
+	C.free(unsafe.Pointer(cName))
+	C.free(unsafe.Pointer(cID))
 
-		C.cfapi_create_placeholder(cPath, cName, cID,
-			C.longlong(child.Size), C.longlong(child.ModTime.Unix()), isDir)
+	switch uint32(hr) {
+	case 0:
+	case hrAlreadyExists, hrFileExists:
+		b.updatePlaceholder(node)
+	default:
+		logger.Error("Create placeholder %s: HRESULT 0x%08x", node.Path, uint32(hr))
+	}
+}
 
-		C.free(unsafe.Pointer(cPath))
-		C.free(unsafe.Pointer(cName))
-		C.free(unsafe.Pointer(cID))
+// updatePlaceholder sets the placeholder metadata to node's and marks it
+// in sync.
+func (b *CfAPIBackend) updatePlaceholder(node *models.FileNode) {
+	cPath := C.CString(localPathOf(b.syncRoot, node.Path))
+	cID := C.CString(node.ID)
+	hr := C.cfapi_update_placeholder(cPath, cID,
+		C.longlong(node.Size), C.longlong(node.ModTime.Unix()))
+	C.free(unsafe.Pointer(cPath))
+	C.free(unsafe.Pointer(cID))
 
-		if child.IsDir {
-			os.MkdirAll(localPath, 0755)
-			b.createPlaceholdersRecursive(child, localPath)
-		}
+	if hr != 0 {
+		logger.Error("Update placeholder %s: HRESULT 0x%08x", node.Path, uint32(hr))
 	}
 }
 
-func (b *CfAPIBackend) watchMetadataChanges(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		// The refresh loop already updates metadata. We periodically check for diffs
-		// and update placeholders. In practice, SSE or refresh loop triggers RefreshMetadata
-		// which returns a diff, but we keep this simple polling approach for robustness.
-		diff, err := b.core.RefreshMetadata(ctx)
-		if err != nil || diff == nil {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
+// dehydrate is the cache eviction hook for hydrated placeholders.
+func (b *CfAPIBackend) dehydrate(entry *models.CacheEntry) {
+	if err := dehydratePath(entry.LocalPath); err != nil {
+		logger.Error("Dehydrate %s: %v", entry.Path, err)
+		return
+	}
+	logger.Debug("Dehydrated %s", entry.Path)
+}
+
+func dehydratePath(localPath string) error {
+	cPath := C.CString(localPath)
+	defer C.free(unsafe.Pointer(cPath))
+	if hr := C.cfapi_dehydrate_placeholder(cPath); hr != 0 {
+		return fmt.Errorf("HRESULT 0x%08x", uint32(hr))
+	}
+	return nil
+}
+
+// hydratePath downloads the full content of a placeholder. It blocks until
+// the hydration callback has delivered the data.
+func hydratePath(localPath string) error {
+	cPath := C.CString(localPath)
+	defer C.free(unsafe.Pointer(cPath))
+	if hr := C.cfapi_hydrate_placeholder(cPath); hr != 0 {
+		return fmt.Errorf("HRESULT 0x%08x", uint32(hr))
+	}
+	return nil
+}
+
+func convertToPlaceholder(localPath, identity string) error {
+	cPath := C.CString(localPath)
+	defer C.free(unsafe.Pointer(cPath))
+	cID := C.CString(identity)
+	defer C.free(unsafe.Pointer(cID))
+	if hr := C.cfapi_convert_to_placeholder(cPath, cID); hr != 0 {
+		return fmt.Errorf("HRESULT 0x%08x", uint32(hr))
+	}
+	return nil
+}
+
+// placeholderState returns the CFAPI_STATE_* flags of a local path.
+func placeholderState(localPath string) (int, error) {
+	cPath := C.CString(localPath)
+	defer C.free(unsafe.Pointer(cPath))
+	var flags C.int
+	if hr := C.cfapi_get_state(cPath, &flags); hr != 0 {
+		return 0, fmt.Errorf("HRESULT 0x%08x", uint32(hr))
+	}
+	return int(flags), nil
+}
+
+// --- Server changes ---
+
+// applyDiff brings the placeholders in line with a metadata refresh:
+// new nodes get placeholders, changed files are updated (and their stale
+// content dropped), and deleted nodes are removed.
+func (b *CfAPIBackend) applyDiff(diff *MetadataDiff) {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+
+	added := append([]*models.FileNode(nil), diff.Added...)
+	sortParentsFirst(added)
+	for _, node := range added {
+		b.createPlaceholder(node)
+	}
+
+	for _, node := range diff.Changed {
+		if b.ownUpload(node) {
 			continue
 		}
+		b.refreshPlaceholder(node)
+	}
 
-		for _, node := range diff.Added {
-			dir := b.syncRoot + string(os.PathSeparator) + dirOf(node.Path)
-			b.createPlaceholderSingle(dir, node)
-		}
+	removed := append([]*models.FileNode(nil), diff.Removed...)
+	sortChildrenFirst(removed)
+	for _, node := range removed {
+		b.removePlaceholder(node)
+	}
+}
+
+// ownUpload reports whether a change is the echo of our own upload.
+func (b *CfAPIBackend) ownUpload(node *models.FileNode) bool {
+	hash, ok := b.synced[node.Path]
+	if ok && hash == node.Hash {
+		delete(b.synced, node.Path)
+		return true
+	}
+	return false
+}
+
+// refreshPlaceholder applies a server-side change to a file. Local content
+// is dropped so the next open fetches the new version; pinned files are
+// downloaded again right away.
+func (b *CfAPIBackend) refreshPlaceholder(node *models.FileNode) {
+	if node.IsDir {
+		b.updatePlaceholder(node)
+		return
+	}
+
+	localPath := localPathOf(b.syncRoot, node.Path)
+	state, err := placeholderState(localPath)
+	if err != nil {
+		b.createPlaceholder(node)
+		return
+	}
+	if state&C.CFAPI_STATE_PLACEHOLDER == 0 || state&C.CFAPI_STATE_IN_SYNC == 0 {
+		// The upload of the local edit will conflict and keep both versions
+		logger.Warn("%s changed on the server and locally", node.Path)
+		return
+	}
 
-		for _, node := range diff.Changed {
-			localPath := b.syncRoot + string(os.PathSeparator) + node.Path
-			cPath := C.CString(localPath)
-			cID := C.CString(node.ID)
-			C.cfapi_update_placeholder(cPath, cID,
-				C.longlong(node.Size), C.longlong(node.ModTime.Unix()))
-			C.free(unsafe.Pointer(cPath))
-			C.free(unsafe.Pointer(cID))
+	id := tree.CacheID(node.ID)
+	pinned := b.core.Cache.IsPinned(id)
+	if state&C.CFAPI_STATE_PARTIAL == 0 {
+		if b.core.Cache.IsCached(id) && !pinned {
+			b.core.Cache.Evict(id)
+		} else if err := dehydratePath(localPath); err != nil {
+			logger.Error("Dehydrate %s: %v", node.Path, err)
 		}
+	}
+	b.updatePlaceholder(node)
 
-		// Removed files: the OS handles deletion via the sync root
+	if pinned {
+		go func() {
+			if err := hydratePath(localPath); err != nil {
+				logger.Error("Download pinned %s: %v", node.Path, err)
+			}
+		}()
 	}
+	logger.Debug("Placeholder updated: %s", node.Path)
 }
 
-func (b *CfAPIBackend) createPlaceholderSingle(localDir string, node *models.FileNode) {
-	cPath := C.CString(localDir)
-	cName := C.CString(node.Name)
-	cID := C.CString(node.ID)
-	isDir := C.int(0)
-	if node.IsDir {
-		isDir = 1
+// removePlaceholder removes the local copy of a node deleted on the server.
+// Files with local edits are kept, and are uploaded again.
+func (b *CfAPIBackend) removePlaceholder(node *models.FileNode) {
+	localPath := localPathOf(b.syncRoot, node.Path)
+	state, err := placeholderState(localPath)
+	if err != nil {
+		return // already gone
 	}
-	C.cfapi_create_placeholder(cPath, cName, cID,
-		C.longlong(node.Size), C.longlong(node.ModTime.Unix()), isDir)
-	C.free(unsafe.Pointer(cPath))
-	C.free(unsafe.Pointer(cName))
-	C.free(unsafe.Pointer(cID))
+	if !node.IsDir && (state&C.CFAPI_STATE_PLACEHOLDER == 0 || state&C.CFAPI_STATE_IN_SYNC == 0) {
+		logger.Warn("%s was deleted on the server but has local changes; keeping it", node.Path)
+		return
+	}
+
+	if !node.IsDir {
+		id := tree.CacheID(node.ID)
+		b.core.Cache.Unpin(id)
+		b.core.Cache.Evict(id)
+	}
+	delete(b.pinState, node.Path)
+
+	if err := os.Remove(localPath); err != nil {
+		logger.Debug("Remove %s: %v", localPath, err)
+		return
+	}
+	logger.Info("Removed %s (deleted on server)", node.Path)
 }
 
-func dirOf(path string) string {
-	for i := len(path) - 1; i >= 0; i-- {
-		if path[i] == '/' {
-			if i == 0 {
-				return ""
-			}
-			return path[1:i] // strip leading /
+// --- Hydration ---
+
+func transferKeyID(key C.CF_TRANSFER_KEY) int64 {
+	return *(*int64)(unsafe.Pointer(&key))
+}
+
+// resolve finds the node for a placeholder identity. Placeholders created
+// from local files carry their server path until the next refresh.
+func (b *CfAPIBackend) resolve(identity string) *models.FileNode {
+	if node := b.core.FindByID(identity); node != nil {
+		return node
+	}
+	return b.core.FindByPath(identity)
+}
+
+// hydrate services a fetch request with ranged content requests, reporting
+// progress to Explorer after each chunk.
+func (b *CfAPIBackend) hydrate(identity string, offset, length int64, transferKey C.CF_TRANSFER_KEY) {
+	node := b.resolve(identity)
+	if node == nil {
+		logger.Error("Hydration requested for unknown file %s", identity)
+		C.cfapi_transfer_error(b.connKey, transferKey, C.longlong(offset), C.long(eFail))
+		return
+	}
+
+	// Explorer or an application is waiting for the data
+	ctx, cancel := context.WithCancel(client.WithPriority(b.ctx, client.PriorityInteractive))
+	key := transferKeyID(transferKey)
+	b.transfersMu.Lock()
+	b.transfers[key] = cancel
+	b.transfersMu.Unlock()
+	defer func() {
+		b.transfersMu.Lock()
+		delete(b.transfers, key)
+		b.transfersMu.Unlock()
+		cancel()
+	}()
+
+	end := offset + length
+	for pos := offset; pos < end; {
+		n := end - pos
+		if n > hydrationChunkSize {
+			n = hydrationChunkSize
 		}
+		data, err := b.core.FetchContentRange(ctx, node.ID, pos, n)
+		if err == nil && len(data) == 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			logger.Error("Hydration failed for %s at offset %d: %v", node.Path, pos, err)
+			C.cfapi_transfer_error(b.connKey, transferKey, C.longlong(pos), C.long(eFail))
+			return
+		}
+
+		hr := C.cfapi_transfer_data(b.connKey, transferKey,
+			unsafe.Pointer(&data[0]), C.longlong(pos), C.longlong(len(data)))
+		if hr != 0 {
+			logger.Error("Transfer to %s failed: HRESULT 0x%08x", node.Path, uint32(hr))
+			return
+		}
+		pos += int64(len(data))
+		C.cfapi_report_progress(b.connKey, transferKey, C.longlong(length), C.longlong(pos-offset))
 	}
-	return ""
+
+	b.core.Stats.ContentFetches.Add(1)
+	b.core.Cache.Track(tree.CacheID(node.ID), node.Path, localPathOf(b.syncRoot, node.Path), node.Size)
+	logger.Debug("Hydrated %s (%d bytes at %d)", node.Path, length, offset)
 }
 
 //export goHydrationCallback
@@ -216,23 +452,280 @@ func goHydrationCallback(fileIdentity *C.char, fileIdentityLen C.int,
 	offset C.longlong, length C.longlong,
 	transferKey C.CF_TRANSFER_KEY) {
 
-	if globalCfAPIBackend == nil || globalCfAPIBackend.core == nil {
-		C.cfapi_transfer_error(globalCfAPIBackend.connKey, transferKey,
-			C.longlong(offset), C.long(0x80004005)) // E_FAIL
+	b := globalCfAPIBackend
+	if b == nil || b.core == nil {
 		return
 	}
+	b.hydrate(C.GoStringN(fileIdentity, fileIdentityLen), int64(offset), int64(length), transferKey)
+}
 
-	fileID := C.GoStringN(fileIdentity, fileIdentityLen)
-	ctx := context.Background()
+//export goCancelFetchCallback
+func goCancelFetchCallback(transferKey C.CF_TRANSFER_KEY) {
+	b := globalCfAPIBackend
+	if b == nil {
+		return
+	}
+	b.transfersMu.Lock()
+	cancel := b.transfers[transferKeyID(transferKey)]
+	b.transfersMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
 
-	data, err := globalCfAPIBackend.core.FetchContentRange(ctx, fileID, int64(offset), int64(length))
-	if err != nil {
-		logger.Error("Hydration failed for %s: %v", fileID, err)
-		C.cfapi_transfer_error(globalCfAPIBackend.connKey, transferKey,
-			C.longlong(offset), C.long(0x80004005))
+//export goFileCloseCallback
+func goFileCloseCallback(filePath *C.char, deleted C.int) {
+	b := globalCfAPIBackend
+	if b == nil || deleted != 0 {
 		return
 	}
+	select {
+	case b.closed <- C.GoString(filePath):
+	default:
+		// The next scan picks it up
+	}
+}
+
+// --- Local changes ---
+
+// localSyncLoop uploads closed files right away and scans the sync root
+// periodically for new files and pin changes.
+func (b *CfAPIBackend) localSyncLoop(ctx context.Context) {
+	ticker := time.NewTicker(localSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-b.closed:
+			b.syncMu.Lock()
+			pinsChanged := b.syncLocalPath(ctx, p)
+			b.syncMu.Unlock()
+			if pinsChanged {
+				b.savePins()
+			}
+		case <-ticker.C:
+			b.scanLocal(ctx)
+		}
+	}
+}
+
+func (b *CfAPIBackend) scanLocal(ctx context.Context) {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+
+	pinsChanged := false
+	filepath.WalkDir(b.syncRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || ctx.Err() != nil {
+			return nil
+		}
+		if serverPath, ok := serverPathOf(b.syncRoot, p); ok && b.core.Excluded(serverPath) {
+			// Left out by selective sync: local only
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if p != b.syncRoot && b.syncLocalPath(ctx, p) {
+			pinsChanged = true
+		}
+		return nil
+	})
+	if pinsChanged {
+		b.savePins()
+	}
+}
+
+func (b *CfAPIBackend) savePins() {
+	if err := b.core.Cache.SavePins(); err != nil {
+		logger.Error("Failed to persist pins: %v", err)
+	}
+}
+
+// syncLocalPath uploads a new or edited file or directory and applies its
+// Explorer pin state. Returns true if a pin changed. Must be called with
+// syncMu held.
+func (b *CfAPIBackend) syncLocalPath(ctx context.Context, localPath string) bool {
+	serverPath, ok := serverPathOf(b.syncRoot, localPath)
+	if !ok || b.core.Excluded(serverPath) {
+		return false
+	}
+	state, err := placeholderState(localPath)
+	if err != nil {
+		return false
+	}
+
+	isDir := state&C.CFAPI_STATE_DIRECTORY != 0
+	switch {
+	case state&C.CFAPI_STATE_PLACEHOLDER == 0:
+		b.uploadNew(ctx, localPath, serverPath, isDir)
+		return false
+	case isDir:
+		return false
+	case state&C.CFAPI_STATE_IN_SYNC == 0 && state&C.CFAPI_STATE_PARTIAL == 0:
+		if !b.uploadChanged(ctx, localPath, serverPath) {
+			return false
+		}
+		state |= C.CFAPI_STATE_IN_SYNC
+	}
+	return b.syncPinState(localPath, serverPath, state)
+}
+
+// uploadNew uploads a file or directory created in the sync root and turns
+// it into a placeholder.
+func (b *CfAPIBackend) uploadNew(ctx context.Context, localPath, serverPath string, isDir bool) {
+	child := &models.FileNode{
+		ID:      serverPath,
+		Name:    path.Base(serverPath),
+		Path:    serverPath,
+		IsDir:   isDir,
+		ModTime: time.Now(),
+	}
+
+	if isDir {
+		if err := b.core.CreateDirectory(ctx, strings.TrimPrefix(serverPath, "/")); err != nil {
+			logger.Error("Create directory %s: %v", serverPath, err)
+			return
+		}
+		b.core.Stats.DirsCreated.Add(1)
+	} else {
+		resp, err := b.core.UploadFile(ctx, strings.TrimPrefix(serverPath, "/"), localPath, 0)
+		if err != nil {
+			logger.Error("Upload %s: %v", serverPath, err)
+			return
+		}
+		child.Size, child.Hash, child.Version = resp.Size, resp.Hash, resp.Version
+		b.synced[serverPath] = resp.Hash
+		b.core.Stats.FilesCreated.Add(1)
+	}
+
+	if err := convertToPlaceholder(localPath, serverPath); err != nil {
+		logger.Error("Convert %s to placeholder: %v", localPath, err)
+	}
+	if b.core.FindByPath(serverPath) == nil {
+		b.core.AddMetadataChild(path.Dir(serverPath), child)
+	}
+	logger.Info("Uploaded new %s", serverPath)
+}
+
+// uploadChanged uploads an edited placeholder. When the server version
+// changed in the meantime, the local content is uploaded as a conflict copy
+// and the placeholder reverts to the server version. Returns true if the
+// file is in sync afterwards.
+func (b *CfAPIBackend) uploadChanged(ctx context.Context, localPath, serverPath string) bool {
+	node := b.core.FindByPath(serverPath)
+	version := 0
+	if node != nil {
+		version = node.Version
+	}
+
+	resp, err := b.core.UploadFile(ctx, strings.TrimPrefix(serverPath, "/"), localPath, version)
+	if ce, ok := client.AsConflict(err); ok {
+		copyPath := filepath.ToSlash(conflictCopyPath(serverPath))
+		if _, err := b.core.UploadFile(ctx, strings.TrimPrefix(copyPath, "/"), localPath, 0); err != nil {
+			logger.Error("Failed to upload conflict copy of %s: %v", serverPath, err)
+			return false
+		}
+		logger.Warn("Conflict on %s (expected v%d, server v%d): local changes saved as %s",
+			serverPath, ce.ExpectedVersion, ce.CurrentVersion, copyPath)
+
+		// Revert the placeholder to the server version. The tree is fetched
+		// directly: a refresh would call applyDiff, which needs syncMu.
+		root, err := b.core.Client.FetchMetadata(ctx)
+		if err != nil {
+			logger.Error("Fetch metadata after conflict on %s: %v", serverPath, err)
+			return false
+		}
+		current := tree.FindByPath(root, serverPath)
+		if current == nil {
+			return false
+		}
+		b.core.UpdateMetadataNode(serverPath, current.Size, current.Hash, current.ModTime, current.Version)
+		b.core.Cache.Unpin(tree.CacheID(current.ID))
+		b.core.Cache.Evict(tree.CacheID(current.ID))
+		b.updatePlaceholder(current)
+		if err := dehydratePath(localPath); err != nil {
+			logger.Error("Dehydrate %s: %v", serverPath, err)
+		}
+		return false
+	}
+	if err != nil {
+		logger.Error("Upload failed for %s: %v", serverPath, err)
+		return false
+	}
+
+	now := time.Now()
+	b.core.UpdateMetadataNode(serverPath, resp.Size, resp.Hash, now, resp.Version)
+	b.synced[serverPath] = resp.Hash
+
+	id := serverPath
+	if node != nil {
+		id = node.ID
+	}
+	b.updatePlaceholder(&models.FileNode{ID: id, Path: serverPath, Size: resp.Size, ModTime: now})
+	b.core.Cache.Track(tree.CacheID(id), serverPath, localPath, resp.Size)
+
+	logger.Info("Uploaded: %s (%d bytes, v%d)", serverPath, resp.Size, resp.Version)
+	return true
+}
 
-	C.cfapi_transfer_data(globalCfAPIBackend.connKey, transferKey,
-		unsafe.Pointer(&data[0]), C.longlong(offset), C.longlong(len(data)))
+// syncPinState maps Explorer's "Always keep on this device" and "Free up
+// space" onto cache pins. Only changes since the last scan are applied.
+// Returns true if a pin changed.
+func (b *CfAPIBackend) syncPinState(localPath, serverPath string, state int) bool {
+	node := b.core.FindByPath(serverPath)
+	if node == nil {
+		return false
+	}
+	id := tree.CacheID(node.ID)
+	partial := state&C.CFAPI_STATE_PARTIAL != 0
+
+	// Files hydrated before this run are tracked on first sight
+	if !partial && state&C.CFAPI_STATE_IN_SYNC != 0 && !b.core.Cache.IsCached(id) {
+		b.core.Cache.Track(id, serverPath, localPath, node.Size)
+	}
+
+	pin := state & (C.CFAPI_STATE_PINNED | C.CFAPI_STATE_UNPINNED)
+	last, seen := b.pinState[serverPath]
+	b.pinState[serverPath] = pin
+	if seen && last == pin {
+		return false
+	}
+
+	switch {
+	case pin&C.CFAPI_STATE_PINNED != 0:
+		if partial {
+			if err := hydratePath(localPath); err != nil {
+				logger.Error("Download pinned %s: %v", serverPath, err)
+				delete(b.pinState, serverPath) // retry on the next scan
+				return false
+			}
+		}
+		if err := b.core.Cache.Pin(id); err != nil {
+			logger.Error("Pin %s: %v", serverPath, err)
+			return false
+		}
+		logger.Info("Pinned: %s", serverPath)
+		return true
+
+	case pin&C.CFAPI_STATE_UNPINNED != 0:
+		b.core.Cache.Unpin(id)
+		if !partial {
+			if b.core.Cache.IsCached(id) {
+				b.core.Cache.Evict(id)
+			} else if err := dehydratePath(localPath); err != nil {
+				logger.Error("Dehydrate %s: %v", serverPath, err)
+			}
+		}
+		logger.Info("Freed up space: %s", serverPath)
+		return true
+
+	case seen:
+		// The pin was cleared without freeing up space
+		b.core.Cache.Unpin(id)
+		logger.Info("Unpinned: %s", serverPath)
+		return true
+	}
+	return false
 }
@@ -2,6 +2,7 @@ package winclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
@@ -207,7 +209,7 @@ func (b *CgoFuseBackend) Open(path string, flags int) (int, uint64) {
 	}
 
 	// Read mode: fetch content via core
-	ctx := b.ctx
+	ctx := client.WithPriority(b.ctx, client.PriorityInteractive)
 	cachePath, err := b.core.FetchContent(ctx, node)
 	if err != nil {
 		logger.Error("Open fetch failed for %s: %v", node.Path, err)
@@ -226,6 +228,12 @@ func (b *CgoFuseBackend) Open(path string, flags int) (int, uint64) {
 }
 
 func (b *CgoFuseBackend) openForWrite(node *models.FileNode, truncate bool) (int, uint64) {
+	// The buffer is in the cache directory and must leave its volume's
+	// free space reserve alone
+	if !truncate && node.Size > 0 && b.core.Cache.EnsureFree(node.Size) != nil {
+		return -fuse.ENOSPC, ^uint64(0)
+	}
+
 	tmpFile, err := os.CreateTemp(b.core.Config.CacheDir, "fruitsalade-write-*")
 	if err != nil {
 		logger.Error("Failed to create temp file: %v", err)
@@ -295,7 +303,7 @@ func (b *CgoFuseBackend) Read(path string, buff []byte, ofst int64, fh uint64) i
 	}
 
 	// Range read for large uncached files
-	ctx := b.ctx
+	ctx := client.WithPriority(b.ctx, client.PriorityInteractive)
 	node := b.core.FindByPath(resolvePath(path))
 	if node == nil {
 		return -fuse.ENOENT
@@ -329,9 +337,16 @@ func (b *CgoFuseBackend) Write(path string, buff []byte, ofst int64, fh uint64)
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if grow := ofst + int64(len(buff)) - h.size; grow > 0 && b.core.Cache.EnsureFree(grow) != nil {
+		return -fuse.ENOSPC
+	}
+
 	n, err := h.tmpFile.WriteAt(buff, ofst)
 	if err != nil {
 		logger.Error("Write error at offset %d: %v", ofst, err)
+		if errors.Is(err, syscall.ENOSPC) {
+			return -fuse.ENOSPC
+		}
 		return -fuse.EIO
 	}
 
@@ -426,6 +441,10 @@ func (b *CgoFuseBackend) Create(path string, flags int, mode uint32) (int, uint6
 
 	now := time.Now()
 	childPath := resolvePath(path)
+	if b.core.Excluded(childPath) {
+		logger.Error("Cannot create %s: excluded by selective sync", childPath)
+		return -fuse.EACCES, ^uint64(0)
+	}
 
 	childMeta := &models.FileNode{
 		ID:      childPath,
@@ -462,6 +481,11 @@ func (b *CgoFuseBackend) Mkdir(path string, mode uint32) int {
 		return -fuse.ENOENT
 	}
 
+	if b.core.Excluded(resolvePath(path)) {
+		logger.Error("Cannot create %s: excluded by selective sync", resolvePath(path))
+		return -fuse.EACCES
+	}
+
 	serverPath := strings.TrimPrefix(resolvePath(path), "/")
 	ctx := b.ctx
 	if err := b.core.CreateDirectory(ctx, serverPath); err != nil {
@@ -543,6 +567,10 @@ func (b *CgoFuseBackend) Rename(oldpath string, newpath string) int {
 
 	ctx := b.ctx
 	newResolved := resolvePath(newpath)
+	if b.core.Excluded(newResolved) {
+		logger.Error("Cannot move %s to %s: excluded by selective sync", oldpath, newResolved)
+		return -fuse.EACCES
+	}
 
 	if oldNode.IsDir {
 		if len(oldNode.Children) > 0 {
@@ -3,10 +3,7 @@ package winclient
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"hash"
 	"io"
 	"os"
 	"strings"
@@ -18,6 +15,7 @@ import (
 	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/selective"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/tree"
 )
 
@@ -25,13 +23,17 @@ import (
 type CoreConfig struct {
 	ServerURL         string
 	AuthToken         string
+	APIKey            string
 	CacheDir          string
 	SyncRoot          string
 	MaxCacheSize      int64
+	MinFreeSpace      int64 // free bytes kept on the cache's volume (0 = 1 GB or 5%, negative = none)
 	RefreshInterval   time.Duration
 	HealthCheckPeriod time.Duration
 	WatchSSE          bool
 	VerifyHash        bool
+	Exclude           []string // server folders left out, besides the sync-config file in CacheDir
+	RateLimits        client.RateLimits
 }
 
 // CoreStats holds client statistics.
@@ -70,6 +72,12 @@ type ClientCore struct {
 
 	mu       sync.RWMutex
 	metadata *models.FileNode
+	excludes *selective.Excludes // selective sync: folders left out of metadata
+
+	listenersMu sync.Mutex
+	listeners   []func(*MetadataDiff)
+
+	sync syncState // status, pause and recent activity (see status.go)
 
 	refreshTicker *time.Ticker
 	refreshStop   chan struct{}
@@ -87,17 +95,26 @@ func NewClientCore(cfg CoreConfig) (*ClientCore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create cache: %w", err)
 	}
+	c.SetMinFree(cfg.MinFreeSpace)
+
+	excludes, err := selective.LoadWith(cfg.CacheDir, cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("selective sync: %w", err)
+	}
 
 	clientCfg := client.Config{
-		BaseURL:   strings.TrimSuffix(cfg.ServerURL, "/"),
-		Timeout:   60 * time.Second,
-		AuthToken: cfg.AuthToken,
+		BaseURL:    strings.TrimSuffix(cfg.ServerURL, "/"),
+		Timeout:    60 * time.Second,
+		AuthToken:  cfg.AuthToken,
+		APIKey:     cfg.APIKey,
+		RateLimits: cfg.RateLimits,
 	}
 
 	core := &ClientCore{
 		Client:      client.New(clientCfg),
 		Cache:       c,
 		Config:      cfg,
+		excludes:    excludes,
 		refreshStop: make(chan struct{}),
 	}
 
@@ -106,8 +123,16 @@ func NewClientCore(cfg CoreConfig) (*ClientCore, error) {
 		if cfg.AuthToken != "" {
 			core.SSEClient.SetAuthToken(cfg.AuthToken)
 		}
+		core.SSEClient.SetAPIKey(cfg.APIKey)
+		core.SSEClient.SetReauthHandler(core.Client.Reauthenticate)
+		core.Client.OnTokenRefreshed(func(resp *client.RefreshResponse) {
+			core.SSEClient.SetAuthToken(resp.Token)
+		})
 	}
 
+	// The cache calls back with its lock held
+	c.SetDiskFullFunc(func(bool) { go core.notifyStatus() })
+
 	return core, nil
 }
 
@@ -122,7 +147,8 @@ func (c *ClientCore) Metadata() *models.FileNode {
 func (c *ClientCore) FetchMetadata(ctx context.Context) error {
 	logger.Info("Fetching metadata from %s", c.Config.ServerURL)
 
-	root, err := c.Client.FetchMetadata(ctx)
+	root, err := c.fetchTree(ctx)
+	c.refreshDone(err)
 	if err != nil {
 		return fmt.Errorf("fetch metadata: %w", err)
 	}
@@ -140,7 +166,8 @@ func (c *ClientCore) FetchMetadata(ctx context.Context) error {
 func (c *ClientCore) RefreshMetadata(ctx context.Context) (*MetadataDiff, error) {
 	logger.Debug("Refreshing metadata...")
 
-	root, err := c.Client.FetchMetadata(ctx)
+	root, err := c.fetchTree(ctx)
+	c.refreshDone(err)
 	if err != nil {
 		logger.Error("Metadata refresh failed: %v", err)
 		return nil, err
@@ -164,9 +191,41 @@ func (c *ClientCore) RefreshMetadata(ctx context.Context) (*MetadataDiff, error)
 		logger.Debug("Metadata refreshed: %d items", newCount)
 	}
 
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) > 0 {
+		c.listenersMu.Lock()
+		listeners := c.listeners
+		c.listenersMu.Unlock()
+		for _, fn := range listeners {
+			fn(diff)
+		}
+	}
+
 	return diff, nil
 }
 
+// fetchTree fetches the metadata tree without the excluded folders.
+func (c *ClientCore) fetchTree(ctx context.Context) (*models.FileNode, error) {
+	if c.excludes.Empty() {
+		return c.Client.FetchMetadata(ctx)
+	}
+	return c.excludes.FetchTree(ctx, c.Client, "/")
+}
+
+// Excluded reports whether serverPath is left out by selective sync. The
+// backends neither show nor upload excluded paths.
+func (c *ClientCore) Excluded(serverPath string) bool {
+	return c.excludes.Excluded(serverPath)
+}
+
+// OnChange registers fn to be called with the diff of every metadata
+// refresh that changed the tree, whether triggered by the refresh loop,
+// an SSE event or a caller.
+func (c *ClientCore) OnChange(fn func(*MetadataDiff)) {
+	c.listenersMu.Lock()
+	defer c.listenersMu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
 // FindByPath resolves a path in the metadata tree.
 func (c *ClientCore) FindByPath(path string) *models.FileNode {
 	c.mu.RLock()
@@ -202,37 +261,25 @@ func (c *ClientCore) FetchContent(ctx context.Context, node *models.FileNode) (s
 
 	serverID := strings.TrimPrefix(node.ID, "/")
 
-	reader, _, err := c.Client.FetchContentFull(ctx, serverID)
+	var fetched int64
+	cachePath, err := c.Cache.PutResumable(fileID, node.Path, node.Hash, node.Size, c.Config.VerifyHash,
+		func(offset int64) (io.ReadCloser, int64, error) {
+			reader, start, err := c.Client.FetchContentFrom(ctx, serverID, offset)
+			if err == nil {
+				fetched = node.Size - start
+			}
+			return reader, start, err
+		})
 	if err != nil {
 		c.Stats.FailedFetches.Add(1)
 		return "", fmt.Errorf("fetch content %s: %w", node.Path, err)
 	}
-	defer reader.Close()
-
-	var hashReader io.Reader = reader
-	var hasher hash.Hash
 	if c.Config.VerifyHash && node.Hash != "" {
-		hasher = sha256.New()
-		hashReader = io.TeeReader(reader, hasher)
-	}
-
-	cachePath, err := c.Cache.Put(fileID, hashReader, node.Size)
-	if err != nil {
-		c.Stats.FailedFetches.Add(1)
-		return "", fmt.Errorf("cache put %s: %w", node.Path, err)
-	}
-
-	if hasher != nil && node.Hash != "" {
-		actualHash := hex.EncodeToString(hasher.Sum(nil))
-		if actualHash != node.Hash {
-			c.Cache.Evict(fileID)
-			return "", fmt.Errorf("hash mismatch for %s: expected %s, got %s", node.Path, node.Hash, actualHash)
-		}
 		logger.Debug("Hash verified: %s", node.Path)
 	}
 
 	c.Stats.ContentFetches.Add(1)
-	c.Stats.BytesDownloaded.Add(node.Size)
+	c.Stats.BytesDownloaded.Add(fetched)
 	return cachePath, nil
 }
 
@@ -263,7 +310,10 @@ func (c *ClientCore) FetchContentRange(ctx context.Context, fileID string, offse
 }
 
 // UploadFile uploads a local file to the server.
-func (c *ClientCore) UploadFile(ctx context.Context, serverPath, localPath string, expectedVersion int) (*client.UploadResponse, error) {
+func (c *ClientCore) UploadFile(ctx context.Context, serverPath, localPath string, expectedVersion int) (resp *client.UploadResponse, err error) {
+	c.beginUpload()
+	defer func() { c.endUpload(serverPath, err) }()
+
 	f, err := os.Open(localPath)
 	if err != nil {
 		return nil, fmt.Errorf("open local file: %w", err)
@@ -278,7 +328,7 @@ func (c *ClientCore) UploadFile(ctx context.Context, serverPath, localPath strin
 	// Use SectionReader so HTTP client doesn't close our file
 	reader := io.NewSectionReader(f, 0, info.Size())
 
-	resp, err := c.Client.UploadFile(ctx, serverPath, reader, info.Size(), expectedVersion)
+	resp, err = c.Client.UploadFile(ctx, serverPath, reader, info.Size(), expectedVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -289,7 +339,9 @@ func (c *ClientCore) UploadFile(ctx context.Context, serverPath, localPath strin
 
 // UploadReader uploads content from a reader to the server.
 func (c *ClientCore) UploadReader(ctx context.Context, serverPath string, r io.Reader, size int64, expectedVersion int) (*client.UploadResponse, error) {
+	c.beginUpload()
 	resp, err := c.Client.UploadFile(ctx, serverPath, r, size, expectedVersion)
+	c.endUpload(serverPath, err)
 	if err != nil {
 		return nil, err
 	}
@@ -322,7 +374,7 @@ func (c *ClientCore) AddMetadataChild(parentPath string, child *models.FileNode)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if parent := tree.FindByPath(c.metadata, parentPath); parent != nil {
-		parent.Children = append(parent.Children, child)
+		tree.InsertChild(parent, child)
 	}
 }
 
@@ -351,6 +403,9 @@ func (c *ClientCore) UpdateMetadataNode(path string, size int64, hash string, mo
 
 // StartBackgroundLoops starts the refresh, SSE, and health check loops.
 func (c *ClientCore) StartBackgroundLoops(ctx context.Context) {
+	c.sync.mu.Lock()
+	c.sync.loopCtx = ctx
+	c.sync.mu.Unlock()
 	c.startRefreshLoop(ctx)
 	c.startSSEWatch(ctx)
 	c.startHealthCheck(ctx)
@@ -374,7 +429,7 @@ func (c *ClientCore) startRefreshLoop(ctx context.Context) {
 		for {
 			select {
 			case <-c.refreshTicker.C:
-				c.RefreshMetadata(ctx)
+				c.refreshOnce(ctx)
 			case <-c.refreshStop:
 				return
 			case <-ctx.Done():
@@ -386,6 +441,15 @@ func (c *ClientCore) startRefreshLoop(ctx context.Context) {
 	logger.Info("Metadata refresh enabled: every %v", c.Config.RefreshInterval)
 }
 
+// refreshOnce runs one round of the refresh loop. A panic is logged
+// rather than ending the loop.
+func (c *ClientCore) refreshOnce(ctx context.Context) {
+	defer logger.Recover("metadata refresh")
+	if !c.skipWhilePaused() {
+		c.RefreshMetadata(ctx)
+	}
+}
+
 func (c *ClientCore) stopRefreshLoop() {
 	if c.refreshTicker != nil {
 		c.refreshTicker.Stop()
@@ -406,13 +470,11 @@ func (c *ClientCore) startSSEWatch(ctx context.Context) {
 	go func() {
 		for {
 			select {
-			case _, ok := <-events:
+			case event, ok := <-events:
 				if !ok {
 					return
 				}
-				if _, err := c.RefreshMetadata(ctx); err != nil {
-					logger.Error("SSE refresh failed: %v", err)
-				}
+				c.handleEvent(ctx, event)
 			case err, ok := <-errors:
 				if !ok {
 					return
@@ -429,6 +491,29 @@ func (c *ClientCore) startSSEWatch(ctx context.Context) {
 	logger.Info("SSE watch enabled")
 }
 
+// handleEvent refreshes the metadata for a server event. A panic is
+// logged rather than ending the watch.
+func (c *ClientCore) handleEvent(ctx context.Context, event client.SSEEvent) {
+	defer logger.Recover("SSE event")
+	// A resync means events were missed; the full refresh
+	// below covers them.
+	if !event.IsTreeChange() && !event.NeedsResync() {
+		return
+	}
+	if event.Path != "" && c.Excluded(event.Path) {
+		return
+	}
+	if event.Path != "" {
+		c.addActivity(Activity{Kind: ActivityServer, Path: event.Path, Message: serverChange(event.Type)})
+	}
+	if c.skipWhilePaused() {
+		return
+	}
+	if _, err := c.RefreshMetadata(ctx); err != nil {
+		logger.Error("SSE refresh failed: %v", err)
+	}
+}
+
 func (c *ClientCore) stopSSEWatch() {
 	if c.sseCancel != nil {
 		c.sseCancel()
@@ -452,9 +537,14 @@ func (c *ClientCore) startHealthCheck(ctx context.Context) {
 			select {
 			case <-ticker.C:
 				wasOnline := c.Client.IsOnline()
+				wasRejected := c.Client.UpgradeRequired() != nil
 				err := c.Client.Ping(healthCtx)
+				c.notifyStatus()
 
-				if err == nil && !wasOnline {
+				if _, rejected := client.AsUpgradeRequired(err); rejected {
+					continue
+				}
+				if err == nil && (!wasOnline || wasRejected) && !c.skipWhilePaused() {
 					logger.Info("Server is back online, refreshing metadata...")
 					if _, refreshErr := c.RefreshMetadata(healthCtx); refreshErr != nil {
 						logger.Error("Failed to refresh metadata: %v", refreshErr)
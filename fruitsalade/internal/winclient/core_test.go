@@ -1,10 +1,21 @@
 package winclient
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/tree"
 )
 
@@ -184,3 +195,168 @@ func pathsOf(nodes []*models.FileNode) []string {
 	}
 	return paths
 }
+
+// recordingObserver collects what a ClientCore reports.
+type recordingObserver struct {
+	mu       sync.Mutex
+	statuses []SyncStatus
+	activity []Activity
+}
+
+func (o *recordingObserver) StatusChanged(st SyncStatus) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.statuses = append(o.statuses, st)
+}
+
+func (o *recordingObserver) ActivityAdded(a Activity) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.activity = append(o.activity, a)
+}
+
+func (o *recordingObserver) states() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var states []string
+	for _, st := range o.statuses {
+		states = append(states, st.State)
+	}
+	return states
+}
+
+func TestSyncStatusAndActivity(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/content/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		switch r.PathValue("path") {
+		case "conflict.txt":
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(protocol.ConflictResponse{Path: "/conflict.txt", ExpectedVersion: 1, CurrentVersion: 2})
+		case "denied.txt":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(protocol.ErrorResponse{Error: "invalid token"})
+		default:
+			json.NewEncoder(w).Encode(client.UploadResponse{Path: "/" + r.PathValue("path"), Version: 1})
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	core, err := NewClientCore(CoreConfig{ServerURL: ts.URL, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewClientCore: %v", err)
+	}
+	obs := &recordingObserver{}
+	core.AddObserver(obs)
+	ctx := context.Background()
+
+	if _, err := core.UploadReader(ctx, "ok.txt", strings.NewReader("hi"), 2, 0); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if got := obs.states(); !slices.Equal(got, []string{StateSyncing, StateOnline}) {
+		t.Errorf("states after upload = %v", got)
+	}
+
+	core.UploadReader(ctx, "conflict.txt", strings.NewReader("hi"), 2, 1)
+	if st := core.Status(); st.State != StateOnline || st.LastError != "" {
+		t.Errorf("status after conflict = %+v", st)
+	}
+
+	core.Pause()
+	if st := core.Status(); st.State != StatePaused {
+		t.Errorf("status while paused = %+v", st)
+	}
+	core.Resume()
+
+	core.UploadReader(ctx, "denied.txt", strings.NewReader("hi"), 2, 0)
+	if st := core.Status(); st.State != StateAuthFailed || st.LastError == "" {
+		t.Errorf("status after 401 = %+v", st)
+	}
+
+	recent := core.RecentActivity()
+	var kinds []string
+	for _, a := range recent {
+		kinds = append(kinds, a.Kind)
+	}
+	if !slices.Equal(kinds, []string{ActivityError, ActivityConflict, ActivityUpload}) {
+		t.Errorf("activity kinds = %v, want newest first", kinds)
+	}
+	if recent[2].Path != "/ok.txt" {
+		t.Errorf("activity path = %q", recent[2].Path)
+	}
+}
+
+func TestRecentActivityIsBounded(t *testing.T) {
+	core, err := NewClientCore(CoreConfig{ServerURL: "http://localhost:48000", CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewClientCore: %v", err)
+	}
+	for i := 0; i < maxRecentActivity+5; i++ {
+		core.addActivity(Activity{Kind: ActivityServer, Path: fmt.Sprintf("/f%d", i)})
+	}
+	recent := core.RecentActivity()
+	if len(recent) != maxRecentActivity {
+		t.Fatalf("len = %d", len(recent))
+	}
+	if recent[0].Path != fmt.Sprintf("/f%d", maxRecentActivity+4) {
+		t.Errorf("newest = %q", recent[0].Path)
+	}
+}
+
+func TestSelectiveSyncCore(t *testing.T) {
+	full := &models.FileNode{ID: "/", Path: "/", IsDir: true, Children: []*models.FileNode{
+		{ID: "/archives", Name: "archives", Path: "/archives", IsDir: true, Children: []*models.FileNode{
+			{ID: "/archives/a.zip", Name: "a.zip", Path: "/archives/a.zip", Size: 1},
+		}},
+		{ID: "/docs", Name: "docs", Path: "/docs", IsDir: true, Children: []*models.FileNode{
+			{ID: "/docs/b.txt", Name: "b.txt", Path: "/docs/b.txt", Size: 1},
+		}},
+	}}
+	var mu sync.Mutex
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		// Serves depth-limited trees like current servers
+		p := "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/tree"), "/")
+		node := tree.FindByPath(full, p)
+		if node == nil {
+			http.NotFound(w, r)
+			return
+		}
+		resp := protocol.TreeResponse{Root: node}
+		if r.URL.Query().Get("depth") == "1" {
+			copied := *node
+			copied.Children = nil
+			for _, c := range node.Children {
+				c := *c
+				c.Children = nil
+				copied.Children = append(copied.Children, &c)
+			}
+			resp = protocol.TreeResponse{Root: &copied, Partial: true}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+
+	core, err := NewClientCore(CoreConfig{ServerURL: ts.URL, CacheDir: t.TempDir(), Exclude: []string{"archives"}})
+	if err != nil {
+		t.Fatalf("NewClientCore: %v", err)
+	}
+	if err := core.FetchMetadata(context.Background()); err != nil {
+		t.Fatalf("FetchMetadata: %v", err)
+	}
+	if core.FindByPath("/archives") != nil || core.FindByPath("/docs/b.txt") == nil {
+		t.Errorf("tree = %v", pathsOf(core.Metadata().Children))
+	}
+	if !core.Excluded("/archives/a.zip") || core.Excluded("/docs") {
+		t.Error("Excluded disagrees with the configuration")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if slices.Contains(requested, "/api/v1/tree/archives") {
+		t.Errorf("requests = %v, excluded folder was fetched", requested)
+	}
+}
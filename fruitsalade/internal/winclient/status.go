@@ -0,0 +1,270 @@
+package winclient
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// Sync states reported in SyncStatus.State, most urgent first: a rejected
+// login hides everything else, and syncing is only reported while online.
+const (
+	StateAuthFailed = "auth-failed"
+	StatePaused     = "paused"
+	StateOffline    = "offline"
+	StateSyncing    = "syncing"
+	StateOnline     = "online"
+)
+
+// SyncStatus is the state of the client as shown to the user.
+type SyncStatus struct {
+	State     string
+	Pending   int    // uploads in progress
+	LastError string // most recent failed operation, if any
+	DiskFull  bool   // the cache's volume is down to its free space reserve
+}
+
+// Activity kinds.
+const (
+	ActivityServer   = "server"   // a change announced by the server
+	ActivityUpload   = "upload"   // a local change uploaded
+	ActivityConflict = "conflict" // an upload that conflicted with a server change
+	ActivityError    = "error"    // a failed upload or refresh
+)
+
+// Activity is an entry of the recent activity list.
+type Activity struct {
+	Time    time.Time
+	Kind    string
+	Path    string
+	Message string
+}
+
+// maxRecentActivity is how many activities RecentActivity keeps.
+const maxRecentActivity = 20
+
+// Observer receives the client's status changes and activity, for a user
+// interface such as the tray icon. Calls come from the goroutine that
+// caused them and must not block.
+type Observer interface {
+	// StatusChanged is called when SyncStatus changes.
+	StatusChanged(st SyncStatus)
+	// ActivityAdded is called for each new activity.
+	ActivityAdded(a Activity)
+}
+
+// syncState is the part of ClientCore behind Status, Pause and the
+// activity list.
+type syncState struct {
+	mu        sync.Mutex
+	observers []Observer
+	last      SyncStatus
+	paused    bool
+	missed    bool // a server change arrived while paused
+	pending   int
+	authErr   error
+	lastError string
+	recent    []Activity
+	loopCtx   context.Context
+}
+
+// AddObserver registers o for status changes and activity.
+func (c *ClientCore) AddObserver(o Observer) {
+	c.sync.mu.Lock()
+	defer c.sync.mu.Unlock()
+	c.sync.observers = append(c.sync.observers, o)
+}
+
+// Status returns the current sync status.
+func (c *ClientCore) Status() SyncStatus {
+	c.sync.mu.Lock()
+	defer c.sync.mu.Unlock()
+	return c.statusLocked()
+}
+
+func (c *ClientCore) statusLocked() SyncStatus {
+	st := SyncStatus{Pending: c.sync.pending, LastError: c.sync.lastError, DiskFull: c.Cache.DiskFull()}
+	switch {
+	case c.sync.authErr != nil || c.Client.AuthFailed() != nil:
+		st.State = StateAuthFailed
+	case c.sync.paused:
+		st.State = StatePaused
+	case !c.Client.IsOnline():
+		st.State = StateOffline
+	case c.sync.pending > 0:
+		st.State = StateSyncing
+	default:
+		st.State = StateOnline
+	}
+	return st
+}
+
+// RecentActivity returns the latest activities, newest first.
+func (c *ClientCore) RecentActivity() []Activity {
+	c.sync.mu.Lock()
+	defer c.sync.mu.Unlock()
+	recent := make([]Activity, len(c.sync.recent))
+	for i, a := range c.sync.recent {
+		recent[len(recent)-1-i] = a
+	}
+	return recent
+}
+
+// Pause stops applying server changes: the refresh loop, SSE events and
+// reconnects no longer refresh the metadata. Local changes are still
+// uploaded. Resume catches up.
+func (c *ClientCore) Pause() {
+	c.sync.mu.Lock()
+	c.sync.paused = true
+	c.sync.mu.Unlock()
+	logger.Info("Sync paused")
+	c.notifyStatus()
+}
+
+// Resume undoes Pause and refreshes the metadata if the server announced
+// changes in the meantime, or the refresh loop would have run.
+func (c *ClientCore) Resume() {
+	c.sync.mu.Lock()
+	wasPaused := c.sync.paused
+	c.sync.paused = false
+	catchUp := c.sync.missed
+	c.sync.missed = false
+	ctx := c.sync.loopCtx
+	c.sync.mu.Unlock()
+	if !wasPaused {
+		return
+	}
+	logger.Info("Sync resumed")
+	c.notifyStatus()
+
+	if catchUp && ctx != nil {
+		go c.RefreshMetadata(ctx)
+	}
+}
+
+// Paused reports whether sync is paused.
+func (c *ClientCore) Paused() bool {
+	c.sync.mu.Lock()
+	defer c.sync.mu.Unlock()
+	return c.sync.paused
+}
+
+// skipWhilePaused reports whether a background refresh should be skipped,
+// remembering to catch up on Resume.
+func (c *ClientCore) skipWhilePaused() bool {
+	c.sync.mu.Lock()
+	defer c.sync.mu.Unlock()
+	if c.sync.paused {
+		c.sync.missed = true
+	}
+	return c.sync.paused
+}
+
+// addActivity records a and tells the observers.
+func (c *ClientCore) addActivity(a Activity) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	c.sync.mu.Lock()
+	c.sync.recent = append(c.sync.recent, a)
+	if len(c.sync.recent) > maxRecentActivity {
+		c.sync.recent = c.sync.recent[len(c.sync.recent)-maxRecentActivity:]
+	}
+	observers := c.sync.observers
+	c.sync.mu.Unlock()
+
+	for _, o := range observers {
+		o.ActivityAdded(a)
+	}
+}
+
+// notifyStatus tells the observers if the status changed since the last
+// call.
+func (c *ClientCore) notifyStatus() {
+	c.sync.mu.Lock()
+	st := c.statusLocked()
+	if st == c.sync.last {
+		c.sync.mu.Unlock()
+		return
+	}
+	c.sync.last = st
+	observers := c.sync.observers
+	c.sync.mu.Unlock()
+
+	for _, o := range observers {
+		o.StatusChanged(st)
+	}
+}
+
+// checkAuth records whether err means the server refused the credentials;
+// any other outcome of a request clears an earlier refusal.
+func (c *ClientCore) checkAuth(err error) {
+	c.sync.mu.Lock()
+	if client.IsUnauthorized(err) {
+		c.sync.authErr = err
+	} else if err == nil || c.Client.IsOnline() {
+		c.sync.authErr = nil
+	}
+	c.sync.mu.Unlock()
+}
+
+// beginUpload counts an upload as pending.
+func (c *ClientCore) beginUpload() {
+	c.sync.mu.Lock()
+	c.sync.pending++
+	c.sync.mu.Unlock()
+	c.notifyStatus()
+}
+
+// endUpload records the outcome of an upload of serverPath.
+func (c *ClientCore) endUpload(serverPath string, err error) {
+	c.checkAuth(err)
+	c.sync.mu.Lock()
+	c.sync.pending--
+	if err != nil && !client.IsConflict(err) {
+		c.sync.lastError = err.Error()
+	}
+	c.sync.mu.Unlock()
+
+	a := Activity{Kind: ActivityUpload, Path: path.Join("/", serverPath), Message: "Uploaded"}
+	switch {
+	case client.IsConflict(err):
+		a.Kind = ActivityConflict
+		a.Message = "Changed on the server while edited here"
+	case err != nil:
+		a.Kind = ActivityError
+		a.Message = "Upload failed: " + err.Error()
+	}
+	c.addActivity(a)
+	c.notifyStatus()
+}
+
+// refreshDone records the outcome of a metadata refresh.
+func (c *ClientCore) refreshDone(err error) {
+	c.checkAuth(err)
+	if err != nil {
+		c.sync.mu.Lock()
+		c.sync.lastError = err.Error()
+		c.sync.mu.Unlock()
+	}
+	c.notifyStatus()
+}
+
+// serverChange describes a tree change event for the activity list.
+func serverChange(eventType string) string {
+	switch eventType {
+	case protocol.EventCreate:
+		return "Added on the server"
+	case protocol.EventDelete:
+		return "Deleted on the server"
+	case protocol.EventDirChanged:
+		return "Folder changed on the server"
+	default:
+		return "Changed on the server"
+	}
+}
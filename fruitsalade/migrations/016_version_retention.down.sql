@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_file_versions_created_at;
+DROP TABLE IF EXISTS version_retention_policies;
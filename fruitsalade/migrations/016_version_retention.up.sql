@@ -0,0 +1,13 @@
+-- Per-path version retention overrides.
+-- A policy applies to its path and everything below it; the longest matching
+-- path wins. NULL columns fall back to the server-wide defaults
+-- (VERSION_KEEP_COUNT / VERSION_MAX_AGE_DAYS). 0 means unlimited.
+CREATE TABLE IF NOT EXISTS version_retention_policies (
+    path         TEXT PRIMARY KEY,
+    keep_count   INT,
+    max_age_days INT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_versions_created_at ON file_versions (created_at);
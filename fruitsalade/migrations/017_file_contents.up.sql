@@ -0,0 +1,11 @@
+-- 017: Extracted document text for full-text content search
+
+CREATE TABLE IF NOT EXISTS file_contents (
+    file_path TEXT PRIMARY KEY REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE,
+    content TEXT NOT NULL DEFAULT '',
+    tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED,
+    status TEXT NOT NULL DEFAULT 'done',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_contents_tsv ON file_contents USING GIN (tsv);
@@ -0,0 +1 @@
+ALTER TABLE device_tokens DROP COLUMN IF EXISTS client_version;
@@ -0,0 +1,3 @@
+-- 018: Record the client version each device session was last seen with
+
+ALTER TABLE device_tokens ADD COLUMN IF NOT EXISTS client_version TEXT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_image_metadata_media_type;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS duration;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS media_type;
@@ -0,0 +1,6 @@
+-- 019: Video support in the gallery (media type and duration)
+
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS media_type TEXT NOT NULL DEFAULT 'image';
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_image_metadata_media_type ON image_metadata (media_type);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_image_metadata_phash;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS phash;
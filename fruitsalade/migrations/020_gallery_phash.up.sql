@@ -0,0 +1,5 @@
+-- 020: Perceptual hash for duplicate photo detection
+
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS phash BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_image_metadata_phash ON image_metadata (phash) WHERE phash IS NOT NULL;
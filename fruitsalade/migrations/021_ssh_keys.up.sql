@@ -0,0 +1,15 @@
+-- Per-user SSH public keys for the SFTP frontend.
+-- public_key is the authorized_keys form ("ssh-ed25519 AAAA..."); the
+-- fingerprint is its SHA256 fingerprint and is unique across all users so a
+-- key identifies exactly one account.
+CREATE TABLE IF NOT EXISTS user_ssh_keys (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL DEFAULT '',
+    public_key   TEXT NOT NULL,
+    fingerprint  TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_ssh_keys_user_id ON user_ssh_keys (user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_group_members_user_source;
+ALTER TABLE group_members DROP COLUMN IF EXISTS source;
@@ -0,0 +1,5 @@
+-- 022: Membership source, so OIDC group sync only removes memberships it added
+
+ALTER TABLE group_members ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'manual';
+
+CREATE INDEX IF NOT EXISTS idx_group_members_user_source ON group_members (user_id, source);
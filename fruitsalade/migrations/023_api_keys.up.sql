@@ -0,0 +1,18 @@
+-- 023: Long-lived API keys (personal access tokens) for automation
+-- Only the SHA256 of the secret is stored; prefix is the first characters of
+-- the secret, kept so users can tell their keys apart. scope is 'read' or
+-- 'readwrite'; a non-empty path_prefix restricts the key to that subtree.
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,
+    key_hash     TEXT NOT NULL UNIQUE,
+    scope        TEXT NOT NULL DEFAULT 'readwrite',
+    path_prefix  TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,11 @@
+-- Materialize inherited visibility so rows keep their effective value
+UPDATE files f SET visibility = COALESCE(
+    (SELECT a.visibility FROM files a
+     WHERE a.visibility <> ''
+       AND (a.path = '/' OR left(f.path, length(a.path) + 1) = a.path || '/')
+       AND a.path <> f.path
+     ORDER BY length(a.path) DESC LIMIT 1),
+    'public')
+WHERE f.visibility = '';
+
+ALTER TABLE files ALTER COLUMN visibility SET DEFAULT 'public';
@@ -0,0 +1,8 @@
+-- 024: Inherited visibility
+-- An empty visibility now means "inherit from the nearest ancestor directory
+-- with an explicit visibility" (public if there is none). New rows inherit
+-- by default. Rows written without a visibility (uploads and implicitly
+-- created directories already stored '') follow their directory from now on;
+-- explicit values are kept.
+
+ALTER TABLE files ALTER COLUMN visibility SET DEFAULT '';
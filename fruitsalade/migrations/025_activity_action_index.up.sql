@@ -0,0 +1,3 @@
+-- 025: Activity log action filter
+-- The activity endpoints can filter by action; index it for the admin feed.
+CREATE INDEX IF NOT EXISTS idx_activity_log_action ON activity_log (action, created_at DESC);
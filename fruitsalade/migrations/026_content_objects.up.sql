@@ -0,0 +1,12 @@
+-- 026: Content deduplication
+-- With dedup enabled, file content is stored once per storage location under
+-- _cas/<hash>. refcount is the number of files and file_versions rows (live
+-- or trashed) whose s3_key points at the object; it is deleted at zero.
+CREATE TABLE IF NOT EXISTS content_objects (
+    hash                TEXT NOT NULL,
+    storage_location_id INTEGER NOT NULL REFERENCES storage_locations(id) ON DELETE CASCADE,
+    size                BIGINT NOT NULL DEFAULT 0,
+    refcount            INT NOT NULL DEFAULT 0,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (hash, storage_location_id)
+);
@@ -0,0 +1,6 @@
+-- 027: User account status and profile
+-- Disabled users cannot log in and their existing tokens, API keys and SSH
+-- keys stop working. email and display_name are informational.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
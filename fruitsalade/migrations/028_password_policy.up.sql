@@ -0,0 +1,4 @@
+-- 028: Forced password rotation
+-- Users with must_change_password set can only change their password (or
+-- log out) until they do. Set on the default admin and on admin resets.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+ALTER TABLE share_links DROP COLUMN IF EXISTS uploaded_files;
+ALTER TABLE share_links DROP COLUMN IF EXISTS uploaded_bytes;
+ALTER TABLE share_links DROP COLUMN IF EXISTS max_upload_files;
+ALTER TABLE share_links DROP COLUMN IF EXISTS max_upload_bytes;
+ALTER TABLE share_links DROP COLUMN IF EXISTS allow_download;
+ALTER TABLE share_links DROP COLUMN IF EXISTS allow_upload;
@@ -0,0 +1,10 @@
+-- 029: Upload-enabled share links
+-- A share link for a folder can accept uploads from anyone holding it (a
+-- file drop). Uploads are owned by the link's creator and count against
+-- their quota; max_upload_bytes / max_upload_files cap a single link.
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS allow_upload BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS allow_download BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS max_upload_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS max_upload_files INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS uploaded_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS uploaded_files INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE group_permissions DROP COLUMN IF EXISTS granted_by;
+ALTER TABLE file_permissions DROP COLUMN IF EXISTS granted_by;
@@ -0,0 +1,6 @@
+-- 030: Permission grantors
+-- Records who granted a user or group permission, for the "Shared with me"
+-- listing. NULL for grants made before this migration or by the system
+-- (home directory provisioning).
+ALTER TABLE file_permissions ADD COLUMN IF NOT EXISTS granted_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE group_permissions ADD COLUMN IF NOT EXISTS granted_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,15 @@
+-- 031: Daily usage snapshots
+-- One row per day with storage totals and the largest users and groups, so
+-- the admin dashboard can chart growth including deletions.
+CREATE TABLE IF NOT EXISTS usage_snapshots (
+    day           DATE PRIMARY KEY,
+    total_bytes   BIGINT NOT NULL DEFAULT 0,
+    file_count    BIGINT NOT NULL DEFAULT 0,
+    trash_bytes   BIGINT NOT NULL DEFAULT 0,
+    trash_count   BIGINT NOT NULL DEFAULT 0,
+    version_bytes BIGINT NOT NULL DEFAULT 0,
+    version_count BIGINT NOT NULL DEFAULT 0,
+    top_users     JSONB NOT NULL DEFAULT '[]',
+    top_groups    JSONB NOT NULL DEFAULT '[]',
+    recorded_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+-- 032: File comments
+-- Review comments on files with one level of replies. Comments follow their
+-- file when it moves and go away when it is purged.
+CREATE TABLE IF NOT EXISTS file_comments (
+    id                BIGSERIAL PRIMARY KEY,
+    file_path         TEXT NOT NULL REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE,
+    author_id         INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    body              TEXT NOT NULL,
+    parent_comment_id BIGINT REFERENCES file_comments(id) ON DELETE CASCADE,
+    resolved          BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_file_comments_path ON file_comments (file_path, created_at);
@@ -0,0 +1,13 @@
+ALTER TABLE album_images DROP CONSTRAINT IF EXISTS album_images_file_path_fkey;
+ALTER TABLE album_images ADD CONSTRAINT album_images_file_path_fkey
+    FOREIGN KEY (file_path) REFERENCES files(path) ON DELETE CASCADE;
+
+ALTER TABLE image_tags DROP CONSTRAINT IF EXISTS image_tags_file_path_fkey;
+ALTER TABLE image_tags ADD CONSTRAINT image_tags_file_path_fkey
+    FOREIGN KEY (file_path) REFERENCES files(path) ON DELETE CASCADE;
+
+ALTER TABLE image_metadata DROP CONSTRAINT IF EXISTS image_metadata_file_path_fkey;
+ALTER TABLE image_metadata ADD CONSTRAINT image_metadata_file_path_fkey
+    FOREIGN KEY (file_path) REFERENCES files(path) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS organize_rules;
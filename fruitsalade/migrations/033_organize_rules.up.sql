@@ -0,0 +1,25 @@
+-- 033: Auto-organize rules
+-- A rule on a directory moves media uploaded into it into subdirectories
+-- named after the date taken, e.g. {year}/{month}. The gallery tables must
+-- follow such moves, so their file_path references now cascade on update
+-- like file_contents does.
+CREATE TABLE IF NOT EXISTS organize_rules (
+    dir_path   TEXT PRIMARY KEY REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE,
+    pattern    TEXT NOT NULL,
+    fallback   TEXT NOT NULL DEFAULT 'keep',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE image_metadata DROP CONSTRAINT IF EXISTS image_metadata_file_path_fkey;
+ALTER TABLE image_metadata ADD CONSTRAINT image_metadata_file_path_fkey
+    FOREIGN KEY (file_path) REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE image_tags DROP CONSTRAINT IF EXISTS image_tags_file_path_fkey;
+ALTER TABLE image_tags ADD CONSTRAINT image_tags_file_path_fkey
+    FOREIGN KEY (file_path) REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE album_images DROP CONSTRAINT IF EXISTS album_images_file_path_fkey;
+ALTER TABLE album_images ADD CONSTRAINT album_images_file_path_fkey
+    FOREIGN KEY (file_path) REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE;
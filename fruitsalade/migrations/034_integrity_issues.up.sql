@@ -0,0 +1,16 @@
+-- 034: Integrity issues
+-- Files whose stored object no longer matches their hash, as found by the
+-- scrubber. One row per file; a clean scrub of the file removes it unless
+-- it records a repair.
+CREATE TABLE IF NOT EXISTS integrity_issues (
+    file_path           TEXT PRIMARY KEY REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE,
+    storage_location_id INTEGER REFERENCES storage_locations(id) ON DELETE SET NULL,
+    s3_key              TEXT NOT NULL,
+    expected_hash       TEXT NOT NULL,
+    actual_hash         TEXT NOT NULL DEFAULT '', -- '' when the object could not be read
+    error               TEXT NOT NULL DEFAULT '',
+    detected_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    repaired_at         TIMESTAMPTZ,
+    repaired_from       INTEGER -- version the content was restored from
+);
+CREATE INDEX IF NOT EXISTS idx_integrity_issues_detected ON integrity_issues (detected_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE activity_log DROP COLUMN IF EXISTS request_id;
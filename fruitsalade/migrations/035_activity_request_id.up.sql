@@ -0,0 +1,4 @@
+-- 035: Request ID on activity entries
+-- The X-Request-ID of the API request that caused the action, to find its
+-- server and client log lines. Empty for actions outside the HTTP API.
+ALTER TABLE activity_log ADD COLUMN IF NOT EXISTS request_id TEXT NOT NULL DEFAULT '';
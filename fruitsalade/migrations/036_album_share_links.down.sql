@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_share_links_album_id;
+ALTER TABLE share_links DROP COLUMN IF EXISTS album_id;
@@ -0,0 +1,7 @@
+-- 036: Album share links
+-- A share link with an album_id shares a custom gallery album instead of a
+-- path (path is then empty): anyone holding it can view the album and the
+-- thumbnails and content of its members. max_downloads caps album views.
+ALTER TABLE share_links ADD COLUMN IF NOT EXISTS album_id INTEGER REFERENCES user_albums(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_share_links_album_id ON share_links (album_id) WHERE album_id IS NOT NULL;
@@ -0,0 +1,6 @@
+UPDATE image_metadata SET status = 'failed' WHERE status = 'retry';
+
+DROP INDEX IF EXISTS idx_image_metadata_next_retry;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS next_retry_at;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS last_error;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS attempts;
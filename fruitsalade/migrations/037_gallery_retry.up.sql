@@ -0,0 +1,13 @@
+-- 037: Retry accounting for gallery processing. A file that fails is
+-- retried with backoff ('retry' until next_retry_at) and dead-lettered as
+-- 'failed' after too many attempts.
+
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_image_metadata_next_retry ON image_metadata (next_retry_at) WHERE status = 'retry';
+
+-- Failures before retry accounting have no recorded error; give them, and
+-- files left 'processing' by a crash, a fresh start
+UPDATE image_metadata SET status = 'pending' WHERE status IN ('failed', 'processing');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS thumbnail_objects;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS thumb_size;
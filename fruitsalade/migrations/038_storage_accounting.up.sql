@@ -0,0 +1,16 @@
+-- 038: Storage accounting for thumbnails
+-- Thumbnails are attributed to the owner of the file they were made from,
+-- so they can count toward the owner's quota. Gallery thumbnails record
+-- their size in image_metadata; preview thumbnails, which are shared by
+-- identical files, are recorded once, for the file that first asked for
+-- them. Both go away with the file's row when it is purged.
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS thumb_size BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS thumbnail_objects (
+    s3_key     TEXT PRIMARY KEY,
+    file_path  TEXT NOT NULL REFERENCES files(path) ON DELETE CASCADE ON UPDATE CASCADE,
+    size       BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_thumbnail_objects_file_path ON thumbnail_objects (file_path);
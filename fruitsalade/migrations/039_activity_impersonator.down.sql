@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_activity_log_impersonator;
+ALTER TABLE activity_log DROP COLUMN IF EXISTS impersonator;
+ALTER TABLE activity_log DROP COLUMN IF EXISTS impersonator_id;
@@ -0,0 +1,9 @@
+-- 039: Impersonation in the activity log
+-- Actions an admin performs with an impersonation token are recorded for
+-- the impersonated user, with the admin in impersonator_id and
+-- impersonator, and show up in the activity of both.
+ALTER TABLE activity_log ADD COLUMN IF NOT EXISTS impersonator_id INTEGER;
+ALTER TABLE activity_log ADD COLUMN IF NOT EXISTS impersonator TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_activity_log_impersonator ON activity_log (impersonator_id, created_at DESC)
+    WHERE impersonator_id IS NOT NULL;
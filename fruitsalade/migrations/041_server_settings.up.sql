@@ -0,0 +1,10 @@
+-- 041: Server settings
+-- Runtime settings an admin changed through PUT /api/v1/admin/config, by
+-- their JSON key. They override the environment on every start; a setting
+-- without a row uses the environment's value.
+CREATE TABLE IF NOT EXISTS server_settings (
+    key        TEXT PRIMARY KEY,
+    value      JSONB NOT NULL,
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,24 @@
+-- 042: Sync conflicts
+-- Content of a user's that lost against a newer version of the same file,
+-- from an upload rejected with 409 or reported by a client. The losing
+-- content is kept under mine_key in storage_location_id until the conflict
+-- is resolved; resolution stays '' while it is open.
+CREATE TABLE IF NOT EXISTS sync_conflicts (
+    id                  BIGSERIAL PRIMARY KEY,
+    user_id             INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_path           TEXT NOT NULL,
+    source              TEXT NOT NULL,
+    device              TEXT NOT NULL DEFAULT '',
+    server_version      INTEGER NOT NULL DEFAULT 0,
+    server_hash         TEXT NOT NULL DEFAULT '',
+    mine_key            TEXT NOT NULL,
+    mine_size           BIGINT NOT NULL,
+    mine_hash           TEXT NOT NULL,
+    storage_location_id INTEGER REFERENCES storage_locations(id) ON DELETE SET NULL,
+    resolution          TEXT NOT NULL DEFAULT '',
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at         TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_conflicts_open ON sync_conflicts (user_id, id DESC)
+    WHERE resolution = '';
@@ -0,0 +1 @@
+ALTER TABLE file_permissions DROP COLUMN IF EXISTS recursive;
@@ -0,0 +1,5 @@
+-- 043: Recursive permission grants
+-- Marks a grant made with {"recursive": true}: one row on a directory that
+-- its whole subtree inherits. Every grant is inherited by CheckAccess; the
+-- flag records that the grantor meant it to be, for permission listings.
+ALTER TABLE file_permissions ADD COLUMN IF NOT EXISTS recursive BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_files_alias_target;
+ALTER TABLE files DROP COLUMN IF EXISTS alias_target_id;
@@ -0,0 +1,8 @@
+-- 044: Alias rows
+-- An alias stands for another file or directory, its target, at a second
+-- place in the tree without storing anything twice. The target is kept by
+-- id; ids follow the path, so a move of the target rewrites them and the
+-- foreign key carries the change over. Aliases of a purged target go too.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS alias_target_id TEXT
+    REFERENCES files(id) ON UPDATE CASCADE ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_files_alias_target ON files (alias_target_id) WHERE alias_target_id IS NOT NULL;
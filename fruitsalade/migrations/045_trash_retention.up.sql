@@ -0,0 +1,4 @@
+-- 045: Per-user trash retention
+-- Overrides the server's trash_retention_days for the items a user
+-- deleted: NULL uses the server setting, 0 keeps them until purged by hand.
+ALTER TABLE user_quotas ADD COLUMN IF NOT EXISTS trash_retention_days INTEGER;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_image_metadata_camera_date;
+DROP INDEX IF EXISTS idx_image_metadata_stack;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS unstacked;
+ALTER TABLE image_metadata DROP COLUMN IF EXISTS stack_id;
@@ -0,0 +1,10 @@
+-- 046: Burst stacks. Photos taken with the same camera within a few
+-- seconds of each other share a stack_id, the image_metadata id of the
+-- stack's first photo. unstacked keeps a photo a user took out of its
+-- stack from being stacked again.
+
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS stack_id INTEGER;
+ALTER TABLE image_metadata ADD COLUMN IF NOT EXISTS unstacked BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_image_metadata_stack ON image_metadata (stack_id) WHERE stack_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_image_metadata_camera_date ON image_metadata (camera_make, camera_model, date_taken);
@@ -12,6 +12,7 @@ var API = (function() {
         sessionStorage.removeItem('token');
         sessionStorage.removeItem('username');
         sessionStorage.removeItem('is_admin');
+        sessionStorage.removeItem('must_change_password');
     }
 
     function isAuthenticated() {
@@ -43,6 +44,17 @@ var API = (function() {
                 window.location.hash = '#login';
                 return Promise.reject(new Error('Unauthorized'));
             }
+            if (resp.status === 403) {
+                // The server blocks everything but a password change
+                return resp.clone().json().then(function(data) {
+                    if (data && data.details === 'password_change_required') {
+                        sessionStorage.setItem('must_change_password', 'true');
+                        window.location.hash = '#login';
+                        return Promise.reject(new Error('Password change required'));
+                    }
+                    return resp;
+                }, function() { return resp; });
+            }
             return resp;
         });
     }
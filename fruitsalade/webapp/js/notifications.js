@@ -16,17 +16,50 @@ var Notifications = (function() {
         try {
             eventSource = new EventSource('/api/v1/events?token=' + encodeURIComponent(token));
 
-            eventSource.addEventListener('file_created', function(e) {
-                onEvent('create', JSON.parse(e.data));
+            // The SSE event name matches the payload's "type"; other types
+            // (job, notice, future additions) are not file notifications.
+            ['create', 'modify', 'delete', 'version', 'permission-granted'].forEach(function(type) {
+                eventSource.addEventListener(type, function(e) {
+                    try {
+                        onEvent(type, JSON.parse(e.data));
+                    } catch(_) {}
+                });
             });
-            eventSource.addEventListener('file_modified', function(e) {
-                onEvent('modify', JSON.parse(e.data));
+            eventSource.addEventListener('maintenance', function(e) {
+                try {
+                    var m = JSON.parse(e.data).maintenance || {};
+                    if (m.readonly) {
+                        Toast.error('Server is read-only for maintenance' + (m.message ? ': ' + m.message : ''), 10000);
+                    } else {
+                        Toast.info('Server maintenance has ended; changes can be saved again');
+                    }
+                } catch(_) {}
+            });
+            // Sync conflicts are addressed to their user; only new ones
+            // need attention
+            eventSource.addEventListener('conflict', function(e) {
+                try {
+                    var data = JSON.parse(e.data);
+                    if (data.conflict && data.conflict.action === 'created') {
+                        onEvent('conflict', data);
+                    }
+                } catch(_) {}
             });
-            eventSource.addEventListener('file_deleted', function(e) {
-                onEvent('delete', JSON.parse(e.data));
+            // Expired trash was purged; an open trash view is stale
+            eventSource.addEventListener('trash-purged', function(e) {
+                try {
+                    var t = JSON.parse(e.data).trash || {};
+                    Toast.info(t.items + ' expired item' + (t.items === 1 ? '' : 's') + ' removed from trash');
+                    if (window.location.hash.indexOf('#trash') === 0) renderTrash();
+                } catch(_) {}
             });
-            eventSource.addEventListener('file_versioned', function(e) {
-                onEvent('version', JSON.parse(e.data));
+            // The stream ends before the token expires or when the session
+            // is revoked; reconnect with the current token unless revoked
+            eventSource.addEventListener('reauth', function(e) {
+                var reason = '';
+                try { reason = (JSON.parse(e.data).reauth || {}).reason; } catch(_) {}
+                disconnect();
+                if (reason !== 'revoked') connect();
             });
             // Generic message fallback
             eventSource.onmessage = function(e) {
@@ -265,6 +298,8 @@ var Notifications = (function() {
         if (!n.path) return;
         if (n.type === 'delete') {
             window.location.hash = '#trash';
+        } else if (n.type === 'permission-granted') {
+            window.location.hash = '#shares';
         } else {
             // Check if directory by trailing slash or type hint
             var isDir = n.path.endsWith('/') || (n.data && n.data.is_dir);
@@ -294,6 +329,8 @@ var Notifications = (function() {
             case 'modify': return '&#9998;';
             case 'delete': return '&#128465;';
             case 'version': return '&#128338;';
+            case 'permission-granted': return '&#128101;';
+            case 'conflict': return '&#9888;';
             default: return '&#128276;';
         }
     }
@@ -304,6 +341,8 @@ var Notifications = (function() {
             case 'modify': return 'File Modified';
             case 'delete': return 'File Deleted';
             case 'version': return 'New Version';
+            case 'permission-granted': return 'Shared with You';
+            case 'conflict': return 'Sync Conflict';
             default: return 'Notification';
         }
     }
@@ -284,5 +284,15 @@ var FileTypes = (function() {
         return galleryImageExts.indexOf(ext) !== -1;
     }
 
-    return { detect: detect, isText: isText, icon: icon, getExt: getExt, isGalleryImage: isGalleryImage };
+    // Types the server can render via /api/v1/thumb (images, videos, PDFs, text)
+    var thumbExts = ['.mp4', '.m4v', '.mov', '.mkv', '.webm', '.pdf',
+        '.txt', '.md', '.log', '.csv', '.tsv', '.json', '.yaml', '.yml', '.toml',
+        '.ini', '.conf', '.xml', '.html', '.css', '.sql', '.sh',
+        '.go', '.py', '.js', '.ts', '.c', '.h', '.cpp', '.java', '.rs', '.rb'];
+
+    function hasThumbnail(filename) {
+        return isGalleryImage(filename) || thumbExts.indexOf(getExt(filename)) !== -1;
+    }
+
+    return { detect: detect, isText: isText, icon: icon, getExt: getExt, isGalleryImage: isGalleryImage, hasThumbnail: hasThumbnail };
 })();
@@ -15,7 +15,10 @@ function renderActivity() {
         if (loading) return;
         loading = true;
 
-        var url = '/api/v1/activity?limit=50';
+        // Admins see everyone's activity
+        var url = sessionStorage.getItem('is_admin') === 'true'
+            ? '/api/v1/admin/activity?limit=50'
+            : '/api/v1/activity?limit=50';
         if (before) {
             url += '&before=' + encodeURIComponent(before);
         }
@@ -24,6 +24,9 @@ function loadAdminShares() {
                 : '<span class="badge badge-red">Revoked</span>';
 
             var expiresAt = l.expires_at ? formatDate(l.expires_at) : 'Never';
+            if (l.exceeds_policy) {
+                expiresAt += ' <span class="badge badge-yellow" title="Outlives the max share link lifetime">Over policy</span>';
+            }
             var downloads = l.download_count + (l.max_downloads > 0 ? '/' + l.max_downloads : '');
 
             rows += '<tr>' +
@@ -296,6 +296,12 @@ function renderBrowser() {
 
     // ── Render: List (default table) ────────────────────────────────────────
 
+    // Link icon after the name of an alias (a second path of a file or folder)
+    function aliasBadge(f) {
+        if (!f.alias) return '';
+        return ' <span class="alias-icon" title="Alias of ' + esc(f.alias_target) + '">&#128279;</span>';
+    }
+
     function renderTable(items) {
         var table = document.getElementById('file-table');
         if (!items || items.length === 0) {
@@ -323,10 +329,10 @@ function renderBrowser() {
             var nameLink;
             if (f.is_dir) {
                 nameLink = '<a class="file-name" href="#browser' + esc(f.path) + '">' +
-                    iconHtml + esc(f.name) + '</a>';
+                    iconHtml + esc(f.name) + aliasBadge(f) + '</a>';
             } else {
                 nameLink = '<a class="file-name" href="#viewer' + esc(f.path) + '">' +
-                    iconHtml + esc(f.name) + '</a>';
+                    iconHtml + esc(f.name) + aliasBadge(f) + '</a>';
             }
 
             // Visibility badge
@@ -406,7 +412,7 @@ function renderBrowser() {
             html += '<tr class="file-row' + (isSelected ? ' selected' : '') + '" data-path="' + esc(f.path) + '" data-isdir="' + (f.is_dir ? '1' : '0') + '" data-vis="' + esc(f.visibility || 'public') + '" data-idx="' + i + '">' +
                 '<td class="cb-col"><input type="checkbox" class="row-checkbox"' + (isSelected ? ' checked' : '') + '></td>' +
                 '<td class="fav-col"><button class="fav-btn' + (isFav ? ' fav-active' : '') + '" data-fav="' + esc(f.path) + '" title="' + (isFav ? 'Unstar' : 'Star') + '">' + (isFav ? '&#9733;' : '&#9734;') + '</button></td>' +
-                '<td><a class="file-name" href="' + href + '">' + iconHtml + esc(f.name) + '</a></td>' +
+                '<td><a class="file-name" href="' + href + '">' + iconHtml + esc(f.name) + aliasBadge(f) + '</a></td>' +
                 '<td>' + (f.is_dir ? '-' : formatBytes(f.size)) + '</td>' +
                 '<td class="compact-modified">' + formatDate(f.mod_time) + '</td>' +
                 '<td class="compact-kebab-col"><button class="kebab-btn" data-path="' + esc(f.path) + '" aria-label="Actions for ' + esc(f.name) + '">&#8942;</button></td>' +
@@ -452,7 +458,7 @@ function renderBrowser() {
             var isSelected = !!selectedPaths[f.path];
             var isFav = !!userFavorites[f.path];
             var href = f.is_dir ? '#browser' + esc(f.path) : '#viewer' + esc(f.path);
-            var isImage = !f.is_dir && FileTypes.isGalleryImage(f.name);
+            var hasThumb = !f.is_dir && FileTypes.hasThumbnail(f.name);
 
             html += '<div class="tile-card file-row' + (isSelected ? ' selected' : '') + '" data-path="' + esc(f.path) + '" data-isdir="' + (f.is_dir ? '1' : '0') + '" data-vis="' + esc(f.visibility || 'public') + '" data-idx="' + i + '">' +
                 '<div class="tile-controls">' +
@@ -464,12 +470,12 @@ function renderBrowser() {
                 '</div>' +
                 '<a href="' + href + '" class="tile-link">' +
                     '<div class="tile-thumb">' +
-                        (isImage
+                        (hasThumb
                             ? '<img class="tile-thumb-img" data-thumb-path="' + esc(f.path) + '" alt="" loading="lazy">'
                             : '<div class="tile-icon">' + FileTypes.icon(f.name, f.is_dir) + '</div>') +
                     '</div>' +
                     '<div class="tile-label">' +
-                        '<div class="tile-name" title="' + esc(f.name) + '">' + esc(f.name) + '</div>' +
+                        '<div class="tile-name" title="' + esc(f.name) + '">' + esc(f.name) + aliasBadge(f) + '</div>' +
                         '<div class="tile-meta">' + (f.is_dir ? 'Folder' : formatBytes(f.size)) + '</div>' +
                     '</div>' +
                 '</a>' +
@@ -479,29 +485,45 @@ function renderBrowser() {
         container.innerHTML = html;
         updateBatchToolbar();
 
-        // Load thumbnails for images
+        // Load thumbnails for images, videos, PDFs and text files
         container.querySelectorAll('.tile-thumb-img[data-thumb-path]').forEach(function(img) {
-            var filePath = img.getAttribute('data-thumb-path');
-            var url = '/api/v1/gallery/thumb/' + API.encodeURIPath(filePath.replace(/^\//, ''));
-            fetch(url, { headers: { 'Authorization': 'Bearer ' + API.getToken() } })
-                .then(function(r) { return r.blob(); })
-                .then(function(blob) {
-                    var objURL = URL.createObjectURL(blob);
-                    tileObjectURLs.push(objURL);
-                    img.src = objURL;
-                })
-                .catch(function() {
-                    // Fall back to file icon
-                    var iconDiv = document.createElement('div');
-                    iconDiv.className = 'tile-icon';
-                    iconDiv.innerHTML = FileTypes.icon(filePath.split('/').pop(), false);
-                    img.parentNode.replaceChild(iconDiv, img);
-                });
+            loadTileThumb(img, img.getAttribute('data-thumb-path'), 0);
         });
 
         wireFileRows(container, items);
     }
 
+    // Fetch a tile thumbnail, retrying while the server is still generating it
+    function loadTileThumb(img, filePath, attempt) {
+        var url = '/api/v1/thumb/' + API.encodeURIPath(filePath.replace(/^\//, '')) + '?size=256';
+        fetch(url, { headers: { 'Authorization': 'Bearer ' + API.getToken() } })
+            .then(function(r) {
+                if (r.status === 202 && attempt < 5) {
+                    var wait = parseInt(r.headers.get('Retry-After'), 10) || 2;
+                    setTimeout(function() {
+                        if (img.isConnected) loadTileThumb(img, filePath, attempt + 1);
+                    }, wait * 1000);
+                    return null;
+                }
+                if (!r.ok || r.status === 202) throw new Error('no thumbnail');
+                return r.blob();
+            })
+            .then(function(blob) {
+                if (!blob) return;
+                var objURL = URL.createObjectURL(blob);
+                tileObjectURLs.push(objURL);
+                img.src = objURL;
+            })
+            .catch(function() {
+                // Fall back to file icon
+                if (!img.parentNode) return;
+                var iconDiv = document.createElement('div');
+                iconDiv.className = 'tile-icon';
+                iconDiv.innerHTML = FileTypes.icon(filePath.split('/').pop(), false);
+                img.parentNode.replaceChild(iconDiv, img);
+            });
+    }
+
     // ── Kebab / Context Menu ────────────────────────────────────────────────
 
     var activeMenu = null;
@@ -609,7 +631,8 @@ function renderBrowser() {
                 }
             });
         } else if (action === 'share') {
-            showShareModal(path);
+            var shareRow = document.querySelector('.file-row[data-path="' + CSS.escape(path) + '"]');
+            showShareModal(path, shareRow && shareRow.getAttribute('data-isdir') === '1');
         } else if (action === 'history') {
             window.location.hash = '#versions' + path;
         } else if (action === 'visibility') {
@@ -804,7 +827,25 @@ function renderBrowser() {
 
     // ── Share Modal ─────────────────────────────────────────────────────────
 
-    function showShareModal(path) {
+    function showShareModal(path, isDir) {
+        var uploadFields = isDir ?
+            '<div class="form-group">' +
+                '<label><input type="checkbox" id="share-allow-upload"> Allow uploads (file drop)</label>' +
+            '</div>' +
+            '<div id="share-upload-opts" class="hidden">' +
+                '<div class="form-group">' +
+                    '<label><input type="checkbox" id="share-allow-download" checked> Visitors can see and download files</label>' +
+                '</div>' +
+                '<div class="form-group">' +
+                    '<label>Max upload size in MB (optional, total)</label>' +
+                    '<input type="number" id="share-max-upload-mb" placeholder="0 = unlimited">' +
+                '</div>' +
+                '<div class="form-group">' +
+                    '<label>Max uploaded files (optional)</label>' +
+                    '<input type="number" id="share-max-upload-files" placeholder="0 = unlimited">' +
+                '</div>' +
+            '</div>' : '';
+
         var contentDiv = document.createElement('div');
         contentDiv.innerHTML =
             '<form id="share-form">' +
@@ -820,12 +861,20 @@ function renderBrowser() {
                     '<label>Max downloads (optional)</label>' +
                     '<input type="number" id="share-max-dl" placeholder="0 = unlimited">' +
                 '</div>' +
+                uploadFields +
                 '<button type="submit" class="btn">Create Share Link</button>' +
             '</form>' +
             '<div id="share-result"></div>';
 
         Modal.open({ title: 'Share: ' + path.split('/').pop(), content: contentDiv });
 
+        var allowUploadCb = document.getElementById('share-allow-upload');
+        if (allowUploadCb) {
+            allowUploadCb.addEventListener('change', function() {
+                document.getElementById('share-upload-opts').classList.toggle('hidden', !allowUploadCb.checked);
+            });
+        }
+
         document.getElementById('share-form').addEventListener('submit', function(e) {
             e.preventDefault();
             var body = {};
@@ -835,6 +884,14 @@ function renderBrowser() {
             if (pw) body.password = pw;
             if (exp) body.expires_in_sec = parseInt(exp, 10);
             if (maxDl) body.max_downloads = parseInt(maxDl, 10);
+            if (allowUploadCb && allowUploadCb.checked) {
+                var maxMB = document.getElementById('share-max-upload-mb').value;
+                var maxFiles = document.getElementById('share-max-upload-files').value;
+                body.allow_upload = true;
+                body.allow_download = document.getElementById('share-allow-download').checked;
+                if (maxMB) body.max_upload_bytes = Math.round(parseFloat(maxMB) * 1024 * 1024);
+                if (maxFiles) body.max_upload_files = parseInt(maxFiles, 10);
+            }
 
             API.post('/api/v1/share/' + API.encodeURIPath(path.replace(/^\//, '')), body)
                 .then(function(resp) { return resp.json(); })
@@ -1431,6 +1488,15 @@ function renderBrowser() {
         contentDiv.innerHTML =
             '<p style="margin-bottom:0.75rem">' + (mode === 'move' ? 'Move' : 'Copy') + ' ' + paths.length + ' item(s) to:</p>' +
             '<div class="folder-picker-tree" id="folder-picker-tree">Loading...</div>' +
+            '<div class="form-group" style="margin-top:0.75rem">' +
+                '<label>If a name is already taken</label>' +
+                '<select id="folder-picker-conflict">' +
+                    '<option value="rename">Keep both (add a number)</option>' +
+                    '<option value="skip">Skip the item</option>' +
+                    '<option value="overwrite">Replace the existing file</option>' +
+                    '<option value="">Report an error</option>' +
+                '</select>' +
+            '</div>' +
             '<div style="margin-top:0.75rem">' +
                 '<button class="btn" id="folder-picker-confirm" disabled>' + (mode === 'move' ? 'Move Here' : 'Copy Here') + '</button>' +
             '</div>';
@@ -1477,16 +1543,24 @@ function renderBrowser() {
 
         document.getElementById('folder-picker-confirm').addEventListener('click', function() {
             if (!selectedDest) return;
+            var onConflict = document.getElementById('folder-picker-conflict').value;
             Modal.close();
 
             var endpoint = mode === 'move' ? '/api/v1/bulk/move' : '/api/v1/bulk/copy';
-            API.post(endpoint, { paths: paths, destination: selectedDest }).then(function(resp) {
+            var body = { paths: paths, destination: selectedDest };
+            if (onConflict) body.on_conflict = onConflict;
+            API.post(endpoint, body).then(function(resp) {
                 return resp.json();
             }).then(function(data) {
+                var done = (mode === 'move' ? 'Moved' : 'Copied') + ' ' + data.succeeded + ' item(s)';
+                if (data.skipped > 0) done += ', skipped ' + data.skipped;
                 if (data.failed > 0) {
-                    Toast.error((data.errors || []).join(', ') || 'Some items failed');
+                    var failed = (data.results || []).filter(function(r) { return r.status === 'failed'; });
+                    Toast.error(done + '. Failed: ' + (failed.map(function(r) {
+                        return r.from.split('/').pop() + ' (' + r.error + ')';
+                    }).join(', ') || (data.errors || []).join(', ')), 10000);
                 } else {
-                    Toast.success((mode === 'move' ? 'Moved' : 'Copied') + ' ' + data.succeeded + ' item(s)');
+                    Toast.success(done);
                 }
                 clearSelection();
                 loadDir(currentPath);
@@ -1796,7 +1870,7 @@ function renderBrowser() {
         if (newShareBtn) {
             newShareBtn.addEventListener('click', function() {
                 closeDetailPanel();
-                showShareModal(path);
+                showShareModal(path, data.is_dir);
             });
         }
 
@@ -1834,7 +1908,8 @@ function renderBrowser() {
     // Load directory contents
     function loadDir(path) {
         buildBreadcrumb(path);
-        var apiPath = path === '/' ? '/api/v1/tree' : '/api/v1/tree/' + API.encodeURIPath(path.replace(/^\//, ''));
+        // Only the immediate children are shown; don't fetch the whole subtree
+        var apiPath = (path === '/' ? '/api/v1/tree' : '/api/v1/tree/' + API.encodeURIPath(path.replace(/^\//, ''))) + '?depth=1';
 
         // Fetch favorites and directory in parallel
         Promise.all([
@@ -1874,6 +1949,9 @@ function renderFavorites() {
     app.innerHTML =
         '<div class="toolbar">' +
             '<h2>Favorites</h2>' +
+            '<div class="toolbar-actions">' +
+                '<button class="btn btn-sm btn-outline hidden" id="btn-clean-favorites">Remove missing</button>' +
+            '</div>' +
         '</div>' +
         '<div id="favorites-table" class="table-wrap">' +
             '<div style="padding:0.75rem">' +
@@ -1896,6 +1974,21 @@ function renderFavorites() {
             return;
         }
 
+        var missing = items.filter(function(f) { return f.missing && !f.trashed; }).length;
+        var cleanBtn = document.getElementById('btn-clean-favorites');
+        if (missing > 0) {
+            cleanBtn.classList.remove('hidden');
+            cleanBtn.textContent = 'Remove missing (' + missing + ')';
+            cleanBtn.addEventListener('click', function() {
+                API.del('/api/v1/favorites/missing').then(function(resp) {
+                    return resp.json();
+                }).then(function(data) {
+                    Toast.info('Removed ' + data.removed + ' missing favorite' + (data.removed === 1 ? '' : 's'));
+                    renderFavorites();
+                });
+            });
+        }
+
         var html = '<table class="favorites-table"><thead><tr>' +
             '<th>Name</th>' +
             '<th>Size</th>' +
@@ -1907,12 +2000,18 @@ function renderFavorites() {
             var iconHtml = FileTypes.icon(f.file_name || f.file_path.split('/').pop(), f.is_dir);
             var href = f.is_dir ? '#browser' + f.file_path : '#viewer' + f.file_path;
             var displayName = f.file_name || f.file_path.split('/').pop();
+            var nameHtml = '<a class="file-name" href="' + esc(href) + '">' + iconHtml + esc(displayName) + '</a>';
+            if (f.missing) {
+                nameHtml = '<span class="file-name" style="color:var(--text-muted)">' + iconHtml + esc(displayName) + '</span> ' +
+                    (f.trashed
+                        ? '<span class="badge badge-yellow" title="Restore it from the trash to use this favorite">In trash</span>'
+                        : '<span class="badge badge-red" title="The file was deleted">Missing</span>');
+            }
 
             html += '<tr class="file-row">' +
-                '<td><a class="file-name" href="' + esc(href) + '">' +
-                    iconHtml + esc(displayName) + '</a>' +
+                '<td>' + nameHtml +
                     '<div class="fav-path">' + esc(f.file_path) + '</div></td>' +
-                '<td>' + (f.is_dir ? '-' : formatBytes(f.size)) + '</td>' +
+                '<td>' + (f.is_dir || f.missing ? '-' : formatBytes(f.size)) + '</td>' +
                 '<td>' +
                     '<button class="btn btn-sm btn-outline" data-unfav="' + esc(f.file_path) + '" title="Remove favorite">&#9733;</button>' +
                 '</td>' +
@@ -121,8 +121,13 @@ function renderOverviewContent(container, data) {
         '<div class="quota-grid">' +
             metricCard('My Files', String(data.file_count)) +
             metricCard('Active Share Links', String(data.share_link_count)) +
+            metricCard('Trash', formatBytes(data.trash_bytes)) +
         '</div>' +
     '</div>';
+    if (data.trash_warning) {
+        html += '<div class="alert alert-warning">Your trash holds ' + formatBytes(data.trash_bytes) +
+            '. <a href="#trash">Empty it</a> to free space.</div>';
+    }
 
     // Bandwidth history chart (7 days)
     if (data.bandwidth_history && data.bandwidth_history.length > 0) {
@@ -133,8 +133,8 @@ function pluginFormHTML(plugin) {
             '<input type="text" id="plugin-name" value="' + esc(name) + '" required>' +
         '</div>' +
         '<div class="form-group">' +
-            '<label for="plugin-webhook-url">Webhook URL</label>' +
-            '<input type="text" id="plugin-webhook-url" value="' + esc(webhookUrl) + '" required placeholder="https://example.com/tag">' +
+            '<label for="plugin-webhook-url">Webhook URL or command</label>' +
+            '<input type="text" id="plugin-webhook-url" value="' + esc(webhookUrl) + '" required placeholder="https://example.com/tag or exec:/usr/local/bin/tagger">' +
         '</div>' +
         '<div class="form-group">' +
             '<label><input type="checkbox" id="plugin-enabled"' + (enabled ? ' checked' : '') + '> Enabled</label>' +
@@ -211,18 +211,19 @@ function testPlugin(id, btn) {
         var html = '';
 
         if (data.success) {
-            html += '<p><span class="badge badge-green">Success</span></p>';
+            html += '<p><span class="badge badge-green">Success</span> in ' + data.latency_ms + ' ms</p>';
             if (data.tags && data.tags.length > 0) {
                 html += '<p><strong>Returned Tags:</strong></p><ul>';
                 for (var i = 0; i < data.tags.length; i++) {
-                    html += '<li>' + esc(data.tags[i]) + '</li>';
+                    var t = data.tags[i];
+                    html += '<li>' + esc(t.tag) + ' (' + Math.round(t.confidence * 100) + '%)</li>';
                 }
                 html += '</ul>';
             } else {
                 html += '<p>No tags returned.</p>';
             }
         } else {
-            html += '<p><span class="badge badge-grey">Failed</span></p>';
+            html += '<p><span class="badge badge-grey">Failed</span> after ' + data.latency_ms + ' ms</p>';
             if (data.error) {
                 html += '<p><strong>Error:</strong> ' + esc(data.error) + '</p>';
             }
@@ -240,6 +240,7 @@ function renderGallery() {
         if (filterCountry) params.push('country=' + encodeURIComponent(filterCountry));
         params.push('sort_by=' + encodeURIComponent(sortBy));
         params.push('sort_order=' + encodeURIComponent(sortOrder));
+        params.push('collapse_stacks=true');
         params.push('limit=' + limit);
         params.push('offset=' + off);
         return '/api/v1/gallery/search?' + params.join('&');
@@ -437,6 +438,36 @@ function renderGallery() {
         }
     }
 
+    function formatVideoDuration(seconds) {
+        if (!seconds) return '';
+        var total = Math.round(seconds);
+        var h = Math.floor(total / 3600);
+        var m = Math.floor((total % 3600) / 60);
+        var sec = total % 60;
+        var mm = h > 0 && m < 10 ? '0' + m : String(m);
+        var ss = sec < 10 ? '0' + sec : String(sec);
+        return (h > 0 ? h + ':' : '') + mm + ':' + ss;
+    }
+
+    // Replace the stack representative at idx by all photos of its stack
+    function expandStack(idx) {
+        var rep = items[idx];
+        if (!rep || !rep.stack_id) return;
+        API.get('/api/v1/gallery/stack/' + rep.stack_id).then(function(data) {
+            if (data.error) {
+                Toast.error(data.error);
+                return;
+            }
+            var members = data.items || [];
+            if (members.length === 0 || items[idx] !== rep) return;
+            members.forEach(function(m) { m.stack_count = 0; });
+            items.splice.apply(items, [idx, 1].concat(members));
+            renderGalleryItems(false);
+        }).catch(function() {
+            Toast.error('Failed to load stack');
+        });
+    }
+
     function renderGridItems(container, append) {
         if (!append) {
             container.innerHTML = '';
@@ -477,6 +508,22 @@ function renderGallery() {
             img.alt = esc(item.file_name);
             img.setAttribute('loading', 'lazy');
             thumbWrap.appendChild(img);
+
+            if (item.media_type === 'video') {
+                var badge = document.createElement('span');
+                badge.className = 'gallery-video-badge';
+                badge.textContent = '\u25B6 ' + formatVideoDuration(item.duration);
+                thumbWrap.appendChild(badge);
+            }
+            if (item.stack_count > 1) {
+                var stackBadge = document.createElement('button');
+                stackBadge.type = 'button';
+                stackBadge.className = 'gallery-stack-badge';
+                stackBadge.title = 'Show all ' + item.stack_count + ' photos of this burst';
+                stackBadge.textContent = '\u29C9 ' + item.stack_count;
+                stackBadge.setAttribute('data-stack', String(item.stack_id));
+                thumbWrap.appendChild(stackBadge);
+            }
             el.appendChild(thumbWrap);
 
             if (gallerySelected[item.file_path]) {
@@ -524,6 +571,13 @@ function renderGallery() {
                         toggleGallerySelect(itemPath);
                     });
                 }
+                var stackEl = itemEl.querySelector('.gallery-stack-badge');
+                if (stackEl) {
+                    stackEl.addEventListener('click', function(e) {
+                        e.stopPropagation();
+                        expandStack(idx);
+                    });
+                }
                 itemEl.addEventListener('click', function(e) {
                     if (e.target.classList.contains('gallery-select-cb')) return;
                     if (gallerySelectionCount() > 0) {
@@ -802,6 +856,7 @@ function renderGallery() {
                         '<div class="gallery-album-card-meta">' + album.image_count + ' image' + (album.image_count !== 1 ? 's' : '') + '</div>' +
                     '</div>' +
                     '<div class="gallery-album-card-actions">' +
+                        '<button class="btn btn-xs btn-outline" data-action="share-album" data-id="' + album.id + '" title="Share">&#128279;</button>' +
                         '<button class="btn btn-xs btn-outline" data-action="edit-album" data-id="' + album.id + '" title="Edit">&#9998;</button>' +
                         '<button class="btn btn-xs btn-danger" data-action="delete-album" data-id="' + album.id + '" title="Delete">&times;</button>' +
                     '</div>' +
@@ -849,11 +904,13 @@ function renderGallery() {
                     e.stopPropagation();
                     var action = btn.getAttribute('data-action');
                     var id = parseInt(btn.getAttribute('data-id'), 10);
-                    if (action === 'edit-album') {
-                        var album = null;
-                        for (var k = 0; k < userAlbums.length; k++) {
-                            if (userAlbums[k].id === id) { album = userAlbums[k]; break; }
-                        }
+                    var album = null;
+                    for (var k = 0; k < userAlbums.length; k++) {
+                        if (userAlbums[k].id === id) { album = userAlbums[k]; break; }
+                    }
+                    if (action === 'share-album') {
+                        if (album) showGalleryShareModal(null, album);
+                    } else if (action === 'edit-album') {
                         showAlbumModal(album);
                     } else if (action === 'delete-album') {
                         if (!confirm('Delete this album? Images will not be deleted.')) return;
@@ -1901,7 +1958,9 @@ function renderGallery() {
 
     // ── Gallery Share Modal ──────────────────────────────────────────────────
 
-    function showGalleryShareModal(path) {
+    // Shares a single file, or a custom album when album is given. Album
+    // links count views rather than downloads.
+    function showGalleryShareModal(path, album) {
         var contentDiv = document.createElement('div');
         contentDiv.innerHTML =
             '<form id="gallery-share-form">' +
@@ -1914,14 +1973,14 @@ function renderGallery() {
                     '<input type="number" id="gallery-share-expiry" placeholder="e.g. 86400 for 1 day">' +
                 '</div>' +
                 '<div class="form-group">' +
-                    '<label>Max downloads (optional)</label>' +
+                    '<label>' + (album ? 'Max views' : 'Max downloads') + ' (optional)</label>' +
                     '<input type="number" id="gallery-share-max-dl" placeholder="0 = unlimited">' +
                 '</div>' +
                 '<button type="submit" class="btn">Create Share Link</button>' +
             '</form>' +
             '<div id="gallery-share-result"></div>';
 
-        Modal.open({ title: 'Share: ' + path.split('/').pop(), content: contentDiv });
+        Modal.open({ title: 'Share: ' + (album ? album.name : path.split('/').pop()), content: contentDiv });
 
         document.getElementById('gallery-share-form').addEventListener('submit', function(e) {
             e.preventDefault();
@@ -1931,9 +1990,12 @@ function renderGallery() {
             var maxDl = document.getElementById('gallery-share-max-dl').value;
             if (pw) body.password = pw;
             if (exp) body.expires_in_sec = parseInt(exp, 10);
-            if (maxDl) body.max_downloads = parseInt(maxDl, 10);
+            if (maxDl) body[album ? 'max_views' : 'max_downloads'] = parseInt(maxDl, 10);
 
-            API.post('/api/v1/share/' + API.encodeURIPath(path.replace(/^\//, '')), body)
+            var url = album ?
+                '/api/v1/gallery/albums/' + album.id + '/share' :
+                '/api/v1/share/' + API.encodeURIPath(path.replace(/^\//, ''));
+            API.post(url, body)
                 .then(function(resp) { return resp.json(); })
                 .then(function(data) {
                     if (data.error) {
@@ -2086,6 +2148,24 @@ function renderGallery() {
             lightboxObjectURL = null;
         }
 
+        // Videos stream straight from the content endpoint (supports Range)
+        var videoEl = document.querySelector('.lightbox-video');
+        if (videoEl) {
+            videoEl.pause();
+            videoEl.parentNode.removeChild(videoEl);
+        }
+        if (item.media_type === 'video') {
+            imgEl.classList.add('hidden');
+            videoEl = document.createElement('video');
+            videoEl.className = 'lightbox-image lightbox-video';
+            videoEl.controls = true;
+            videoEl.preload = 'metadata';
+            videoEl.src = API.downloadUrl(item.file_path.replace(/^\//, ''));
+            imgEl.parentNode.appendChild(videoEl);
+            return;
+        }
+        imgEl.classList.remove('hidden');
+
         var url = '/api/v1/content/' + API.encodeURIPath(item.file_path.replace(/^\//, ''));
         fetch(url, { headers: { 'Authorization': 'Bearer ' + API.getToken() } })
             .then(function(r) { return r.blob(); })
@@ -2134,6 +2214,8 @@ function renderGallery() {
 
     function closeLightbox() {
         var overlay = document.getElementById('lightbox-overlay');
+        var videoEl = overlay && overlay.querySelector('.lightbox-video');
+        if (videoEl) videoEl.pause();
         if (overlay && overlay.parentNode) {
             overlay.parentNode.removeChild(overlay);
         }
@@ -2563,7 +2645,11 @@ function renderGallery() {
             }).then(function(data) {
                 overlay.remove();
                 if (data.succeeded > 0) {
-                    Toast.success('Tagged ' + data.succeeded + ' item' + (data.succeeded > 1 ? 's' : ''));
+                    var msg = 'Tagged ' + data.succeeded + ' item' + (data.succeeded > 1 ? 's' : '');
+                    if (data.queued > 0) {
+                        msg += ' (' + data.queued + ' queued for processing)';
+                    }
+                    Toast.success(msg);
                 }
                 if (data.failed > 0) {
                     Toast.error(data.failed + ' failed');
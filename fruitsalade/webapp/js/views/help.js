@@ -437,7 +437,8 @@ var HELP_ARTICLES = [
                 '<li>Or use the action menu &rarr; <strong>Favorite</strong></li>' +
             '</ul>' +
             '<h4>Viewing Favorites</h4>' +
-            '<p>Navigate to the <strong>Favorites</strong> page from the sidebar to see all your starred files in one place.</p>'
+            '<p>Navigate to the <strong>Favorites</strong> page from the sidebar to see all your starred files in one place.</p>' +
+            '<p>Favorites follow files when they are moved or renamed. A favorite whose file was trashed is marked <strong>In trash</strong> and comes back when the file is restored; one whose file is gone for good is marked <strong>Missing</strong>. Use <strong>Remove missing</strong> to clear those.</p>'
     },
     {
         id: 'trash',
@@ -955,7 +956,8 @@ var HELP_ARTICLES = [
                 '<tr><td><code>/api/v1/gallery</code></td><td>Gallery (tags, albums, EXIF)</td></tr>' +
                 '<tr><td><code>/api/v1/groups</code></td><td>Group management</td></tr>' +
                 '<tr><td><code>/api/v1/users</code></td><td>User management (admin)</td></tr>' +
-                '<tr><td><code>/api/v1/activity</code></td><td>Activity feed (file operation history)</td></tr>' +
+                '<tr><td><code>/api/v1/activity</code></td><td>Your activity feed (file operations, shares, logins)</td></tr>' +
+                '<tr><td><code>/api/v1/admin/activity</code></td><td>Activity of all users (admin)</td></tr>' +
                 '<tr><td><code>/api/v1/bulk</code></td><td>Bulk operations (tag, album-add)</td></tr>' +
                 '<tr><td><code>/api/v1/events</code></td><td>SSE real-time events</td></tr>' +
                 '<tr><td><code>/api/v1/totp</code></td><td>2FA / TOTP management</td></tr>' +
@@ -994,9 +996,13 @@ var HELP_ARTICLES = [
                 '<tr><td><code>modify</code></td><td>A file is updated (new version uploaded)</td></tr>' +
                 '<tr><td><code>delete</code></td><td>A file or folder is deleted</td></tr>' +
                 '<tr><td><code>version</code></td><td>A version-related action (restore, conflict)</td></tr>' +
+                '<tr><td><code>dir_changed</code></td><td>Several changes in one folder, coalesced (<code>dir</code> object)</td></tr>' +
+                '<tr><td><code>job</code></td><td>Progress of a long-running server job (<code>job</code> object)</td></tr>' +
+                '<tr><td><code>notice</code></td><td>A message for connected users (<code>notice</code> object)</td></tr>' +
             '</table>' +
             '<h4>Event Payload</h4>' +
-            '<pre>event: modify\ndata: {\n  "type": "modify",\n  "path": "/docs/report.pdf",\n  "version": 3,\n  "hash": "a1b2c3...",\n  "size": 204800,\n  "timestamp": 1708617600\n}</pre>' +
+            '<pre>event: modify\ndata: {\n  "schema": 1,\n  "type": "modify",\n  "path": "/docs/report.pdf",\n  "version": 3,\n  "hash": "a1b2c3...",\n  "size": 204800,\n  "timestamp": 1708617600\n}</pre>' +
+            '<p>The stream opens with the comment <code>: fruitsalade-events schema=1</code>. New event types and fields may be added without changing the schema number, so clients should ignore types and fields they do not recognize. Payloads without <code>schema</code> come from older servers and are schema 1.</p>' +
             '<h4>Connection Details</h4>' +
             '<ul>' +
                 '<li>Requires authentication (Bearer token)</li>' +
@@ -1,4 +1,9 @@
 function renderLogin() {
+    if (API.isAuthenticated() && sessionStorage.getItem('must_change_password') === 'true') {
+        showChangePasswordForm();
+        return;
+    }
+
     var container = document.getElementById('login-container');
     container.innerHTML =
         '<div class="login-wrap">' +
@@ -59,6 +64,11 @@ function renderLogin() {
             API.setToken(data.token);
             sessionStorage.setItem('username', data.user.username);
             sessionStorage.setItem('is_admin', data.user.is_admin ? 'true' : 'false');
+            if (data.user.must_change_password) {
+                sessionStorage.setItem('must_change_password', 'true');
+                showChangePasswordForm(password);
+                return;
+            }
             window.location.hash = '#browser';
         }).catch(function() {
             errDiv.innerHTML = '<div class="alert alert-error">Login failed</div>';
@@ -116,3 +126,75 @@ function showTOTPForm(totpToken) {
         });
     });
 }
+
+// Shown when the server requires a password change before anything else.
+// currentPassword is prefilled right after login.
+function showChangePasswordForm(currentPassword) {
+    var container = document.getElementById('login-container');
+    container.innerHTML =
+        '<div class="login-wrap">' +
+            '<div class="form-card login-card">' +
+                '<h1>Change Password</h1>' +
+                '<p class="login-tagline">You must choose a new password before continuing.</p>' +
+                '<div id="change-pw-error"></div>' +
+                '<form id="change-pw-form">' +
+                    '<div class="form-group"' + (currentPassword ? ' style="display:none"' : '') + '>' +
+                        '<label for="current-password">Current Password</label>' +
+                        '<input type="password" id="current-password" autocomplete="current-password" required>' +
+                    '</div>' +
+                    '<div class="form-group">' +
+                        '<label for="new-password">New Password</label>' +
+                        '<input type="password" id="new-password" autocomplete="new-password" required autofocus>' +
+                    '</div>' +
+                    '<div class="form-group">' +
+                        '<label for="confirm-password">Confirm New Password</label>' +
+                        '<input type="password" id="confirm-password" autocomplete="new-password" required>' +
+                    '</div>' +
+                    '<button type="submit" class="btn login-submit">Change Password</button>' +
+                '</form>' +
+                '<p style="margin-top:1rem;text-align:center">' +
+                    '<a href="#login" class="totp-back-link" id="change-pw-logout">Log out</a>' +
+                '</p>' +
+            '</div>' +
+        '</div>';
+
+    document.getElementById('current-password').value = currentPassword || '';
+
+    document.getElementById('change-pw-logout').addEventListener('click', function(e) {
+        e.preventDefault();
+        API.del('/api/v1/auth/token').catch(function() {}).then(function() {
+            API.clearToken();
+            renderLogin();
+        });
+    });
+
+    document.getElementById('change-pw-form').addEventListener('submit', function(e) {
+        e.preventDefault();
+        var current = document.getElementById('current-password').value;
+        var newPass = document.getElementById('new-password').value;
+        var errDiv = document.getElementById('change-pw-error');
+        errDiv.innerHTML = '';
+
+        if (newPass !== document.getElementById('confirm-password').value) {
+            errDiv.innerHTML = '<div class="alert alert-error">Passwords do not match</div>';
+            return;
+        }
+
+        API.post('/api/v1/auth/password', {
+            current_password: current,
+            new_password: newPass
+        }).then(function(resp) {
+            return resp.json().then(function(data) {
+                if (!resp.ok) {
+                    errDiv.innerHTML = '<div class="alert alert-error">' + esc(data.error || 'Failed to change password') + '</div>';
+                    return;
+                }
+                sessionStorage.removeItem('must_change_password');
+                Toast.success('Password changed');
+                window.location.hash = '#browser';
+            });
+        }).catch(function() {
+            errDiv.innerHTML = '<div class="alert alert-error">Failed to change password</div>';
+        });
+    });
+}
@@ -75,24 +75,31 @@ function renderSearch() {
         }
     });
 
-    function doSearch(query, typeFilter) {
+    var shown = [];
+
+    function doSearch(query, typeFilter, offset) {
         var url = '/api/v1/search?q=' + encodeURIComponent(query);
         if (typeFilter && typeFilter !== 'all') {
             url += '&type=' + typeFilter;
         }
+        if (offset) {
+            url += '&offset=' + offset;
+        } else {
+            shown = [];
+            resultsDiv.innerHTML =
+                '<div style="padding:1.5rem;color:var(--text-muted)">Searching...</div>';
+        }
 
-        resultsDiv.innerHTML =
-            '<div style="padding:1.5rem;color:var(--text-muted)">Searching...</div>';
-
-        API.get(url).then(function(results) {
-            renderResults(results, query);
+        API.get(url).then(function(data) {
+            shown = shown.concat(data.results);
+            renderResults(shown, data, query, typeFilter);
         }).catch(function() {
             resultsDiv.innerHTML =
                 '<div class="alert alert-error">Search failed</div>';
         });
     }
 
-    function renderResults(items, query) {
+    function renderResults(items, data, query, typeFilter) {
         if (!items || items.length === 0) {
             resultsDiv.innerHTML =
                 '<div class="empty-state">' +
@@ -102,7 +109,8 @@ function renderSearch() {
             return;
         }
 
-        var html = '<div class="search-count">' + items.length + ' result' + (items.length !== 1 ? 's' : '') + '</div>';
+        var html = '<div class="search-count">' + data.total + ' result' + (data.total !== 1 ? 's' : '') +
+            (data.has_more ? ', showing ' + items.length : '') + '</div>';
         html += '<table class="responsive-table"><thead><tr>' +
             '<th>Name</th><th>Path</th><th>Size</th><th>Modified</th>' +
             '</tr></thead><tbody>';
@@ -116,7 +124,8 @@ function renderSearch() {
 
             html += '<tr class="file-row">' +
                 '<td data-label="Name"><a class="file-name" href="' + esc(href) + '">' +
-                    iconHtml + displayName + '</a></td>' +
+                    iconHtml + displayName + '</a>' +
+                    (f.match === 'tag' ? ' <span class="badge badge-blue">Tag</span>' : '') + '</td>' +
                 '<td data-label="Path" class="search-path">' + displayPath + '</td>' +
                 '<td data-label="Size">' + (f.is_dir ? '-' : formatBytes(f.size)) + '</td>' +
                 '<td data-label="Modified">' + formatDate(f.mod_time) + '</td>' +
@@ -124,7 +133,18 @@ function renderSearch() {
         }
 
         html += '</tbody></table>';
+        if (data.has_more) {
+            html += '<button class="btn btn-outline" id="search-more">Load more</button>';
+        }
         resultsDiv.innerHTML = html;
+
+        var more = document.getElementById('search-more');
+        if (more) {
+            more.addEventListener('click', function() {
+                more.disabled = true;
+                doSearch(query, typeFilter, items.length);
+            });
+        }
     }
 
     function highlightMatch(text, query) {
@@ -48,7 +48,7 @@ function renderConfigSections(data) {
     var rt = data.runtime;
     html += '<div class="settings-card editable">' +
         '<h3>Runtime Settings</h3>' +
-        '<p class="settings-hint">These settings can be changed at runtime.</p>' +
+        '<p class="settings-hint">These settings take effect immediately and are kept across restarts. The settings above need a restart.</p>' +
         '<form id="runtime-form">' +
             '<div class="form-group">' +
                 '<label for="cfg-log-level">Log Level</label>' +
@@ -63,6 +63,14 @@ function renderConfigSections(data) {
                 '<label for="cfg-max-upload">Max Upload Size (bytes)</label>' +
                 '<input type="number" id="cfg-max-upload" value="' + rt.max_upload_size + '">' +
             '</div>' +
+            '<div class="form-group">' +
+                '<label for="cfg-trash-days">Trash Retention (days, 0 = never purge)</label>' +
+                '<input type="number" id="cfg-trash-days" value="' + rt.trash_retention_days + '">' +
+            '</div>' +
+            '<div class="form-group">' +
+                '<label for="cfg-trash-warn">Trash Warning Size (bytes, 0 = never warn)</label>' +
+                '<input type="number" id="cfg-trash-warn" value="' + rt.trash_warn_size + '">' +
+            '</div>' +
             '<div class="form-group">' +
                 '<label for="cfg-max-storage">Default Max Storage (bytes, 0 = unlimited)</label>' +
                 '<input type="number" id="cfg-max-storage" value="' + rt.default_max_storage + '">' +
@@ -84,17 +92,25 @@ function renderConfigSections(data) {
     // Wire form submit
     document.getElementById('runtime-form').addEventListener('submit', function(e) {
         e.preventDefault();
-        var updates = {
+        var values = {
             log_level: document.getElementById('cfg-log-level').value,
             max_upload_size: parseInt(document.getElementById('cfg-max-upload').value, 10) || 0,
+            trash_retention_days: parseInt(document.getElementById('cfg-trash-days').value, 10) || 0,
+            trash_warn_size: parseInt(document.getElementById('cfg-trash-warn').value, 10) || 0,
             default_max_storage: parseInt(document.getElementById('cfg-max-storage').value, 10) || 0,
             default_max_bandwidth: parseInt(document.getElementById('cfg-max-bandwidth').value, 10) || 0,
             default_requests_per_min: parseInt(document.getElementById('cfg-rpm').value, 10) || 0
         };
+        // Only send what changed, so the rest keeps following the environment
+        var updates = {};
+        for (var key in values) {
+            if (values[key] !== rt[key]) updates[key] = values[key];
+        }
 
         API.put('/api/v1/admin/config', updates).then(function(resp) {
             return resp.json().then(function(data) {
                 if (resp.ok) {
+                    rt = data.runtime;
                     Toast.success('Settings saved successfully');
                 } else {
                     Toast.error(data.error || 'Failed to save settings');
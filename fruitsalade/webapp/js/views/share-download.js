@@ -56,14 +56,33 @@ function renderShareDownload() {
                     e.preventDefault();
                     var pw = document.getElementById('share-pw-input').value;
                     if (!pw) return;
+                    if (info.is_album) {
+                        renderAlbum(card, pw);
+                        return;
+                    }
+                    if (info.is_dir) {
+                        renderFolder(card, info, pw);
+                        return;
+                    }
                     startDownload(token, pw);
                 });
                 return;
             }
 
+            if (info.is_album) {
+                renderAlbum(card, password);
+                return;
+            }
+
+            if (info.is_dir) {
+                renderFolder(card, info, password);
+                return;
+            }
+
             // Ready to download (no password needed, or password in URL)
             card.innerHTML =
                 '<div class="share-brand">FruitSalade</div>' +
+                (info.preview_available ? '<div id="share-preview" class="share-preview hidden"></div>' : '') +
                 '<div class="share-file-info">' +
                     '<div class="share-file-icon">' + FileTypes.icon(info.file_name, false) + '</div>' +
                     '<div class="share-file-name">' + esc(info.file_name) + '</div>' +
@@ -76,6 +95,10 @@ function renderShareDownload() {
                 startDownload(token, password);
             });
 
+            if (info.preview_available) {
+                loadPreview(token, password, 5);
+            }
+
             // Auto-download if password was in URL
             if (password) {
                 startDownload(token, password);
@@ -91,6 +114,31 @@ function renderShareDownload() {
                 '</div>';
         });
 
+    // Shows the link's preview; it may still be rendering at first (202)
+    function loadPreview(token, pw, triesLeft) {
+        var url = '/api/v1/share/' + encodeURIComponent(token) + '/preview?size=512';
+        if (pw) {
+            url += '&password=' + encodeURIComponent(pw);
+        }
+        fetch(url).then(function(resp) {
+            if (resp.status === 202 && triesLeft > 1) {
+                var wait = (parseInt(resp.headers.get('Retry-After'), 10) || 2) * 1000;
+                setTimeout(function() { loadPreview(token, pw, triesLeft - 1); }, wait);
+                return;
+            }
+            if (!resp.ok) return;
+            return resp.blob().then(function(blob) {
+                var el = document.getElementById('share-preview');
+                if (!el) return;
+                var img = document.createElement('img');
+                img.alt = 'Preview';
+                img.src = URL.createObjectURL(blob);
+                el.appendChild(img);
+                el.classList.remove('hidden');
+            });
+        }).catch(function() {});
+    }
+
     function startDownload(token, pw) {
         var url = '/api/v1/share/' + encodeURIComponent(token);
         if (pw) {
@@ -124,6 +172,153 @@ function renderShareDownload() {
         });
     }
 
+    // Folder links: list the files if downloads are allowed, and take
+    // uploads if the link is a file drop.
+    function renderFolder(card, info, pw) {
+        var limits = [];
+        if (info.allow_upload && info.upload_files_left != null) limits.push(info.upload_files_left + ' more file(s)');
+        if (info.allow_upload && info.upload_bytes_left != null) limits.push(formatBytes(info.upload_bytes_left) + ' left');
+
+        card.innerHTML =
+            '<div class="share-brand">FruitSalade</div>' +
+            '<div class="share-file-info">' +
+                '<div class="share-file-icon">' + FileTypes.icon(info.file_name, true) + '</div>' +
+                '<div class="share-file-name">' + esc(info.file_name) + '</div>' +
+            '</div>' +
+            (info.expires_at ? '<div class="share-meta">Expires: ' + formatDate(info.expires_at) + '</div>' : '') +
+            (limits.length ? '<div class="share-meta">Uploads: ' + esc(limits.join(', ')) + '</div>' : '') +
+            (info.allow_upload ?
+                '<div class="share-drop" id="share-drop">' +
+                    '<p>Drop files here or</p>' +
+                    '<label class="btn">Choose Files<input type="file" id="share-file-input" multiple hidden></label>' +
+                '</div>' +
+                '<div id="share-upload-status" class="share-upload-status"></div>' : '') +
+            (info.allow_download ? '<div id="share-file-list" class="share-file-list"></div>' : '');
+
+        if (info.allow_download) loadFiles(pw);
+        if (!info.allow_upload) return;
+
+        var drop = document.getElementById('share-drop');
+        drop.addEventListener('dragover', function(e) {
+            e.preventDefault();
+            drop.classList.add('dragover');
+        });
+        drop.addEventListener('dragleave', function() {
+            drop.classList.remove('dragover');
+        });
+        drop.addEventListener('drop', function(e) {
+            e.preventDefault();
+            drop.classList.remove('dragover');
+            uploadFiles(Array.prototype.slice.call(e.dataTransfer.files), pw, info.allow_download);
+        });
+        document.getElementById('share-file-input').addEventListener('change', function(e) {
+            uploadFiles(Array.prototype.slice.call(e.target.files), pw, info.allow_download);
+            e.target.value = '';
+        });
+    }
+
+    // Album links: show the album as a grid of thumbnails. Each item
+    // opens its full content in a new tab.
+    function renderAlbum(card, pw) {
+        var base = '/api/v1/gallery/shared-album/' + encodeURIComponent(token);
+        var query = pw ? '?password=' + encodeURIComponent(pw) : '';
+        fetch(base + query).then(function(resp) {
+            return resp.json().then(function(data) {
+                if (!resp.ok) {
+                    showDownloadError(data.error || 'Failed to open album');
+                    return;
+                }
+                var html =
+                    '<div class="share-brand">FruitSalade</div>' +
+                    '<div class="share-file-info">' +
+                        '<div class="share-file-name">' + esc(data.name) + '</div>' +
+                        (data.description ? '<div class="share-meta">' + esc(data.description) + '</div>' : '') +
+                        (data.owner ? '<div class="share-meta">Shared by ' + esc(data.owner) + '</div>' : '') +
+                    '</div>' +
+                    (data.expires_at ? '<div class="share-meta">Expires: ' + formatDate(data.expires_at) + '</div>' : '');
+                if (data.items.length === 0) {
+                    html += '<div class="share-meta">This album is empty</div>';
+                } else {
+                    html += '<div class="share-album-grid">';
+                    for (var i = 0; i < data.items.length; i++) {
+                        var it = data.items[i];
+                        var rel = it.path.replace(/^\//, '').split('/').map(encodeURIComponent).join('/');
+                        html += '<a class="share-album-item" target="_blank" rel="noopener" title="' + esc(it.name) + '" ' +
+                            'href="' + base + '/content/' + rel + query + '">' +
+                            (it.has_thumbnail ?
+                                '<img loading="lazy" alt="' + esc(it.name) + '" src="' + base + '/thumb/' + rel + query + '">' :
+                                '<span class="share-album-icon">' + FileTypes.icon(it.name, false) + '</span>') +
+                        '</a>';
+                    }
+                    html += '</div>';
+                }
+                card.innerHTML = html;
+                card.classList.add('share-card-wide');
+            });
+        }).catch(function() {
+            showDownloadError('Failed to open album — network error');
+        });
+    }
+
+    function loadFiles(pw) {
+        var list = document.getElementById('share-file-list');
+        var url = '/api/v1/share/' + encodeURIComponent(token) + '/files';
+        if (pw) url += '?password=' + encodeURIComponent(pw);
+        fetch(url).then(function(resp) {
+            return resp.json().then(function(data) {
+                if (!resp.ok) {
+                    list.innerHTML = '<div class="share-dl-error">' + esc(data.error || 'Failed to list files') + '</div>';
+                    return;
+                }
+                if (data.length === 0) {
+                    list.innerHTML = '<div class="share-meta">No files yet</div>';
+                    return;
+                }
+                var html = '';
+                for (var i = 0; i < data.length; i++) {
+                    html += '<div class="share-file-entry">' +
+                        FileTypes.icon(data[i].name, data[i].is_dir) + ' ' + esc(data[i].name) +
+                        (data[i].is_dir ? '' : '<span class="share-file-size">' + formatBytes(data[i].size) + '</span>') +
+                    '</div>';
+                }
+                list.innerHTML = html;
+            });
+        }).catch(function() {
+            list.innerHTML = '<div class="share-dl-error">Failed to list files</div>';
+        });
+    }
+
+    // Uploads one file at a time so the link's limits apply in order.
+    function uploadFiles(files, pw, refreshList) {
+        var status = document.getElementById('share-upload-status');
+        if (files.length === 0) {
+            if (refreshList) loadFiles(pw);
+            return;
+        }
+        var file = files.shift();
+        var line = document.createElement('div');
+        line.textContent = file.name + ' — uploading...';
+        status.appendChild(line);
+
+        var url = '/api/v1/share/' + encodeURIComponent(token) + '/upload/' + encodeURIComponent(file.name);
+        if (pw) url += '?password=' + encodeURIComponent(pw);
+        fetch(url, { method: 'POST', body: file }).then(function(resp) {
+            return resp.json().then(function(data) {
+                if (resp.ok) {
+                    line.textContent = data.name + ' — uploaded (' + formatBytes(data.size) + ')';
+                } else {
+                    line.textContent = file.name + ' — ' + (data.error || 'upload failed');
+                    line.className = 'share-pw-error';
+                }
+            });
+        }).catch(function() {
+            line.textContent = file.name + ' — upload failed, network error';
+            line.className = 'share-pw-error';
+        }).then(function() {
+            uploadFiles(files, pw, refreshList);
+        });
+    }
+
     function showDownloadError(msg) {
         var errEl = document.getElementById('share-pw-error');
         if (errEl) {
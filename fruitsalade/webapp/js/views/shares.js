@@ -1,6 +1,10 @@
 function renderShares() {
     var app = document.getElementById('app');
     app.innerHTML =
+        '<div class="toolbar">' +
+            '<h2>Shared with Me</h2>' +
+        '</div>' +
+        '<div id="shared-with-me-content"></div>' +
         '<div class="toolbar">' +
             '<h2>My Share Links</h2>' +
         '</div>' +
@@ -12,9 +16,53 @@ function renderShares() {
             '</div>' +
         '</div>';
 
+    loadSharedWithMe();
     loadShares();
 }
 
+function loadSharedWithMe() {
+    var container = document.getElementById('shared-with-me-content');
+
+    API.get('/api/v1/shared-with-me').then(function(data) {
+        if (!data || data.length === 0) {
+            container.innerHTML =
+                '<div class="dashboard-section">' +
+                    '<p class="dashboard-empty">Nothing has been shared with you yet.</p>' +
+                '</div>';
+            return;
+        }
+
+        var html = '<div class="table-wrap"><table class="responsive-table"><thead><tr>' +
+            '<th>Name</th>' +
+            '<th>Permission</th>' +
+            '<th>Shared by</th>' +
+            '<th>Shared</th>' +
+            '</tr></thead><tbody>';
+
+        for (var i = 0; i < data.length; i++) {
+            var item = data[i];
+            var href = item.is_dir ? '#browser' + item.path : '#viewer' + item.path;
+            var by = item.granted_by || 'unknown';
+            if (item.group_name) by += ' (group ' + item.group_name + ')';
+
+            html += '<tr class="file-row">' +
+                '<td data-label="Name">' +
+                    '<a class="file-name" href="' + esc(href) + '">' + FileTypes.icon(item.name, item.is_dir) + esc(item.name) + '</a>' +
+                    '<div class="search-path">' + esc(item.path) + '</div>' +
+                '</td>' +
+                '<td data-label="Permission">' + esc(item.permission) + '</td>' +
+                '<td data-label="Shared by">' + esc(by) + '</td>' +
+                '<td data-label="Shared">' + formatDate(item.granted_at) + '</td>' +
+                '</tr>';
+        }
+
+        html += '</tbody></table></div>';
+        container.innerHTML = html;
+    }).catch(function() {
+        container.innerHTML = '<div class="alert alert-error">Failed to load shared items</div>';
+    });
+}
+
 function loadShares() {
     var container = document.getElementById('shares-content');
 
@@ -44,11 +92,20 @@ function loadShares() {
             var expInfo = link.expires_at ? formatDate(link.expires_at) : 'Never';
             var active = link.is_active !== false;
 
-            html += '<tr class="file-row">' +
-                '<td data-label="File">' +
+            var fileCell;
+            if (link.album_id) {
+                fileCell =
+                    '<a class="file-name" href="#gallery">' + FileTypes.icon(link.album_name, true) + esc(link.album_name || 'Deleted album') + '</a>' +
+                    ' <span class="badge badge-blue">Album</span>';
+            } else {
+                fileCell =
                     '<a class="file-name" href="#viewer' + esc(link.path) + '">' + iconHtml + esc(fileName) + '</a>' +
-                    '<div class="search-path">' + esc(link.path) + '</div>' +
-                '</td>' +
+                    (link.allow_upload ? ' <span class="badge badge-blue">File drop</span>' : '') +
+                    '<div class="search-path">' + esc(link.path) + '</div>';
+            }
+
+            html += '<tr class="file-row">' +
+                '<td data-label="File">' + fileCell + '</td>' +
                 '<td data-label="Downloads">' + dlInfo + '</td>' +
                 '<td data-label="Expires">' + expInfo + '</td>' +
                 '<td data-label="Created">' + formatDate(link.created_at) + '</td>' +
@@ -282,6 +282,13 @@ function renderConfigFields(container, backendType, config) {
             html += '<div class="form-group">' +
                 '<label><input type="checkbox" id="cfg-use_ssl"' +
                 (config.use_ssl ? ' checked' : '') + '> Use SSL</label></div>';
+            html += '<div class="form-group">' +
+                '<label><input type="checkbox" id="cfg-presign_downloads"' +
+                (config.presign_downloads ? ' checked' : '') + '> Redirect downloads to presigned URLs</label></div>';
+            html += configField('presign_ttl_sec', 'Presigned URL Lifetime (seconds)',
+                config.presign_ttl_sec || '', 'number', '300');
+            html += configField('presign_endpoint', 'Public Endpoint for Presigned URLs',
+                config.presign_endpoint || '', 'text', 'Defaults to Endpoint');
             break;
 
         case 'local':
@@ -327,6 +334,11 @@ function collectConfigValues(backendType) {
             if (secretVal) config.secret_key = secretVal;
             config.region = document.getElementById('cfg-region').value.trim() || 'us-east-1';
             config.use_ssl = document.getElementById('cfg-use_ssl').checked;
+            config.presign_downloads = document.getElementById('cfg-presign_downloads').checked;
+            var ttlVal = parseInt(document.getElementById('cfg-presign_ttl_sec').value, 10);
+            if (ttlVal > 0) config.presign_ttl_sec = ttlVal;
+            var presignEndpoint = document.getElementById('cfg-presign_endpoint').value.trim();
+            if (presignEndpoint) config.presign_endpoint = presignEndpoint;
             break;
 
         case 'local':
@@ -54,6 +54,7 @@ function renderTrash() {
             '<th>Size</th>' +
             '<th>Deleted</th>' +
             '<th>Deleted By</th>' +
+            '<th>Expires</th>' +
             '<th>Actions</th>' +
             '</tr></thead><tbody>';
 
@@ -67,6 +68,7 @@ function renderTrash() {
                 '<td data-label="Size">' + (item.is_dir ? '-' : formatBytes(item.size)) + '</td>' +
                 '<td data-label="Deleted">' + formatDate(item.deleted_at) + '</td>' +
                 '<td data-label="Deleted By">' + esc(item.deleted_by_name || '-') + '</td>' +
+                '<td data-label="Expires">' + (item.purge_at ? formatDate(item.purge_at) : 'Never') + '</td>' +
                 '<td data-label="Actions" class="trash-actions">' +
                     '<button class="btn btn-sm" data-restore="' + esc(item.original_path) + '">Restore</button>' +
                     '<button class="btn btn-sm btn-danger" data-purge="' + esc(item.original_path) + '">Delete</button>' +
@@ -4,12 +4,16 @@ function renderUsers() {
     app.innerHTML =
         '<div class="toolbar">' +
             '<h2>Users</h2>' +
-            '<button class="btn" id="btn-show-create">Create User</button>' +
+            '<div class="btn-group">' +
+                '<button class="btn btn-outline" id="btn-force-all-pw">Require New Passwords</button>' +
+                '<button class="btn" id="btn-show-create">Create User</button>' +
+            '</div>' +
         '</div>' +
         '<div id="user-form-area"></div>' +
         '<div id="users-table">Loading...</div>';
 
     document.getElementById('btn-show-create').addEventListener('click', showUserCreateForm);
+    document.getElementById('btn-force-all-pw').addEventListener('click', forcePasswordChangeAll);
     loadUserList();
 }
 
@@ -32,14 +36,21 @@ function loadUserList() {
             var u = users[i];
             rows += '<tr>' +
                 '<td data-label="ID">' + esc(u.id) + '</td>' +
-                '<td data-label="Username">' + esc(u.username) + '</td>' +
-                '<td data-label="Role">' + (u.is_admin ? '<span class="badge badge-blue">Admin</span>' : 'User') + '</td>' +
-                '<td data-label="Groups"><div class="user-groups-cell" id="user-groups-' + u.id + '"><span class="props-muted">loading...</span></div></td>' +
+                '<td data-label="Username">' + esc(u.username) +
+                    (u.display_name ? '<div class="props-muted">' + esc(u.display_name) + '</div>' : '') + '</td>' +
+                '<td data-label="Email">' + (u.email ? esc(u.email) : '<span class="props-muted">-</span>') + '</td>' +
+                '<td data-label="Role">' + (u.is_admin ? '<span class="badge badge-blue">Admin</span>' : 'User') +
+                    (u.disabled ? ' <span class="badge badge-red">Disabled</span>' : '') + '</td>' +
+                '<td data-label="Groups"><div class="user-groups-cell" id="user-groups-' + u.id + '">' +
+                    (u.group_count ? '<span class="props-muted">loading...</span>' : '<span class="props-muted">none</span>') + '</div></td>' +
+                '<td data-label="Storage">' + formatBytes(u.storage_used || 0) + '</td>' +
                 '<td data-label="Created">' + formatDate(u.created_at) + '</td>' +
                 '<td data-label="">' +
                     '<div class="btn-group">' +
+                        '<button class="btn btn-sm btn-outline" data-action="edit-user" data-id="' + u.id + '">Edit</button>' +
                         '<button class="btn btn-sm btn-outline" data-action="manage-groups" data-id="' + u.id + '" data-name="' + esc(u.username) + '">Groups</button>' +
                         '<button class="btn btn-sm btn-outline" data-action="password" data-id="' + u.id + '">Password</button>' +
+                        '<button class="btn btn-sm btn-outline" data-action="force-password" data-id="' + u.id + '" data-name="' + esc(u.username) + '">Require Change</button>' +
                         '<button class="btn btn-sm btn-danger" data-action="delete-user" data-id="' + u.id + '" data-name="' + esc(u.username) + '">Delete</button>' +
                     '</div>' +
                 '</td>' +
@@ -48,13 +59,15 @@ function loadUserList() {
 
         document.getElementById('users-table').innerHTML =
             '<div class="table-wrap"><table class="responsive-table">' +
-                '<thead><tr><th>ID</th><th>Username</th><th>Role</th><th>Groups</th><th>Created</th><th>Actions</th></tr></thead>' +
+                '<thead><tr><th>ID</th><th>Username</th><th>Email</th><th>Role</th><th>Groups</th><th>Storage</th><th>Created</th><th>Actions</th></tr></thead>' +
                 '<tbody>' + rows + '</tbody>' +
             '</table></div>';
 
-        // Load group memberships for each user
+        // Load group memberships for users that have any
+        var byID = {};
         for (var j = 0; j < users.length; j++) {
-            loadUserGroupBadges(users[j].id);
+            byID[users[j].id] = users[j];
+            if (users[j].group_count) loadUserGroupBadges(users[j].id);
         }
 
         // Wire action buttons
@@ -63,10 +76,14 @@ function loadUserList() {
                 var action = e.currentTarget.getAttribute('data-action');
                 var id = parseInt(e.currentTarget.getAttribute('data-id'), 10);
                 var name = e.currentTarget.getAttribute('data-name');
-                if (action === 'password') {
+                if (action === 'edit-user') {
+                    showUserEditModal(byID[id]);
+                } else if (action === 'password') {
                     showUserPasswordDialog(id);
+                } else if (action === 'force-password') {
+                    forcePasswordChange(id, name);
                 } else if (action === 'delete-user') {
-                    deleteUserById(id, name);
+                    deleteUserById(id, name, users);
                 } else if (action === 'manage-groups') {
                     showUserGroupsModal(id, name, groups || []);
                 }
@@ -278,25 +295,158 @@ function showUserCreateForm() {
     });
 }
 
-function deleteUserById(id, username) {
-    if (!confirm('Delete user "' + username + '"? This cannot be undone.')) return;
+function deleteUserById(id, username, users) {
+    API.get('/api/v1/admin/users/' + id + '/footprint').then(function(fp) {
+        var opts = '';
+        for (var i = 0; i < users.length; i++) {
+            if (users[i].id !== id && !users[i].disabled) {
+                opts += '<option value="' + users[i].id + '">' + esc(users[i].username) + '</option>';
+            }
+        }
+        var groups = fp.group_admin && fp.group_admin.length ? esc(fp.group_admin.join(', ')) : 'none';
+
+        var contentDiv = document.createElement('div');
+        contentDiv.innerHTML =
+            '<p>' + esc(username) + ' owns ' + fp.files + ' files (' + formatBytes(fp.bytes) + '), ' +
+                fp.private_files + ' of them private, and has ' + fp.trashed_files + ' files in the trash, ' +
+                fp.share_links + ' share links, ' + fp.favorites + ' favorites and ' + fp.albums + ' albums.</p>' +
+            '<p>Group admin of: ' + groups + '</p>' +
+            '<form id="delete-user-form">' +
+                '<div class="form-group">' +
+                    '<label for="delete-user-action">Their files</label>' +
+                    '<select id="delete-user-action">' +
+                        (opts ? '<option value="transfer">Transfer files, links, favorites and albums to another user</option>' : '') +
+                        '<option value="trash">Move private files to the trash, keep the rest</option>' +
+                        '<option value="keep-public">Keep all files and make private ones public</option>' +
+                    '</select>' +
+                '</div>' +
+                (opts ? '<div class="form-group" id="delete-user-target-group">' +
+                    '<label for="delete-user-target">Transfer to</label>' +
+                    '<select id="delete-user-target">' + opts + '</select>' +
+                '</div>' : '') +
+                '<button type="submit" class="btn btn-danger">Delete ' + esc(username) + '</button>' +
+            '</form>';
+
+        Modal.open({
+            title: 'Delete ' + username,
+            content: contentDiv
+        });
+
+        var action = document.getElementById('delete-user-action');
+        var targetGroup = document.getElementById('delete-user-target-group');
+        action.addEventListener('change', function() {
+            if (targetGroup) targetGroup.style.display = action.value === 'transfer' ? '' : 'none';
+        });
+
+        document.getElementById('delete-user-form').addEventListener('submit', function(e) {
+            e.preventDefault();
+            var body = action.value === 'transfer'
+                ? { transfer_to: parseInt(document.getElementById('delete-user-target').value, 10) }
+                : { orphan_action: action.value };
+            API.del('/api/v1/admin/users/' + id, body).then(function(resp) {
+                return resp.json().then(function(data) {
+                    if (resp.ok) {
+                        Modal.close();
+                        Toast.success('User deleted');
+                        loadUserList();
+                    } else {
+                        Toast.error(data.error || 'Failed to delete user');
+                    }
+                });
+            }).catch(function() {
+                Toast.error('Failed to delete user');
+            });
+        });
+    }).catch(function() {
+        Toast.error('Failed to load what the user owns');
+    });
+}
+
+function showUserEditModal(user) {
+    var contentDiv = document.createElement('div');
+    contentDiv.innerHTML =
+        '<form id="edit-user-form">' +
+            '<div class="form-group">' +
+                '<label for="edit-display-name">Display name</label>' +
+                '<input type="text" id="edit-display-name" value="' + esc(user.display_name || '') + '">' +
+            '</div>' +
+            '<div class="form-group">' +
+                '<label for="edit-email">Email</label>' +
+                '<input type="email" id="edit-email" value="' + esc(user.email || '') + '">' +
+            '</div>' +
+            '<div class="form-group checkbox-group">' +
+                '<input type="checkbox" id="edit-admin"' + (user.is_admin ? ' checked' : '') + '>' +
+                '<label for="edit-admin">Admin</label>' +
+            '</div>' +
+            '<div class="form-group checkbox-group">' +
+                '<input type="checkbox" id="edit-disabled"' + (user.disabled ? ' checked' : '') + '>' +
+                '<label for="edit-disabled">Disabled (signs the user out everywhere)</label>' +
+            '</div>' +
+            '<button type="submit" class="btn">Save</button>' +
+        '</form>';
+
+    Modal.open({
+        title: 'Edit ' + user.username,
+        content: contentDiv
+    });
+
+    document.getElementById('edit-user-form').addEventListener('submit', function(e) {
+        e.preventDefault();
+        API.put('/api/v1/admin/users/' + user.id, {
+            display_name: document.getElementById('edit-display-name').value,
+            email: document.getElementById('edit-email').value,
+            is_admin: document.getElementById('edit-admin').checked,
+            disabled: document.getElementById('edit-disabled').checked
+        }).then(function(resp) {
+            return resp.json().then(function(data) {
+                if (resp.ok) {
+                    Modal.close();
+                    Toast.success('User updated');
+                    loadUserList();
+                } else {
+                    Toast.error(data.error || 'Failed to update user');
+                }
+            });
+        }).catch(function() {
+            Toast.error('Failed to update user');
+        });
+    });
+}
+
+function forcePasswordChange(id, username) {
+    if (!confirm('Require "' + username + '" to choose a new password before doing anything else?')) return;
+
+    API.post('/api/v1/admin/users/' + id + '/force-password-change').then(function(resp) {
+        return resp.json().then(function(data) {
+            if (resp.ok) {
+                Toast.success(username + ' must change their password');
+            } else {
+                Toast.error(data.error || 'Failed to require a password change');
+            }
+        });
+    }).catch(function() {
+        Toast.error('Failed to require a password change');
+    });
+}
+
+function forcePasswordChangeAll() {
+    if (!confirm('Require every user with a password, including you, to choose a new one?')) return;
 
-    API.del('/api/v1/admin/users/' + id).then(function(resp) {
+    API.post('/api/v1/admin/users/force-password-change').then(function(resp) {
         return resp.json().then(function(data) {
             if (resp.ok) {
-                Toast.success('User deleted');
-                loadUserList();
+                Toast.success(data.users + ' users must change their password');
             } else {
-                Toast.error(data.error || 'Failed to delete user');
+                Toast.error(data.error || 'Failed to require password changes');
             }
         });
     }).catch(function() {
-        Toast.error('Failed to delete user');
+        Toast.error('Failed to require password changes');
     });
 }
 
 function showUserPasswordDialog(id) {
-    var newPass = prompt('Enter new password for user #' + id + ':');
+    var newPass = prompt('Enter new password for user #' + id + ' (they must change it at next login):');
     if (!newPass) return;
 
     API.put('/api/v1/admin/users/' + id + '/password', { password: newPass })
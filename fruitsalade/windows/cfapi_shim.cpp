@@ -5,8 +5,9 @@
  * It wraps the Windows CfAPI (Cloud Files API) to provide:
  *   - Sync root registration and connection
  *   - Placeholder creation and updates
- *   - Hydration callback dispatch to Go via goHydrationCallback
- *   - Data transfer for hydration requests
+ *   - Hydration, cancellation and close callback dispatch to Go
+ *   - Data transfer and progress reporting for hydration requests
+ *   - Placeholder state queries for pin and local change tracking
  *
  * Build requirements:
  *   - Windows 10 1809+ SDK
@@ -44,6 +45,16 @@ static std::wstring Utf8ToWide(const char *utf8) {
     return result;
 }
 
+// Convert a wide string to UTF-8.
+static std::string WideToUtf8(const wchar_t *wide) {
+    if (!wide || !*wide) return "";
+    int len = WideCharToMultiByte(CP_UTF8, 0, wide, -1, nullptr, 0, nullptr, nullptr);
+    if (len <= 0) return "";
+    std::string result(len - 1, '\0');
+    WideCharToMultiByte(CP_UTF8, 0, wide, -1, &result[0], len, nullptr, nullptr);
+    return result;
+}
+
 // Convert Unix timestamp to FILETIME.
 static FILETIME UnixToFileTime(long long unixTime) {
     // Windows FILETIME epoch: Jan 1, 1601.  Unix epoch: Jan 1, 1970.
@@ -65,7 +76,7 @@ static void CALLBACK FetchDataCallback(
     _In_ CONST CF_CALLBACK_PARAMETERS *callbackParameters)
 {
     // Extract file identity (our file ID stored as a UTF-8 string blob).
-    const char *fileIdentity = static_cast<const char *>(callbackInfo->FileIdentity);
+    char *fileIdentity = static_cast<char *>(const_cast<void *>(callbackInfo->FileIdentity));
     int fileIdentityLen = static_cast<int>(callbackInfo->FileIdentityLength);
 
     long long offset = callbackParameters->FetchData.RequiredFileOffset.QuadPart;
@@ -77,18 +88,33 @@ static void CALLBACK FetchDataCallback(
     goHydrationCallback(fileIdentity, fileIdentityLen, offset, length, transferKey);
 }
 
-// Callback for cancel fetch (no-op; Go side handles timeouts).
+// Callback for cancel fetch: the Go side cancels the pending download.
 static void CALLBACK CancelFetchDataCallback(
     _In_ CONST CF_CALLBACK_INFO *callbackInfo,
     _In_ CONST CF_CALLBACK_PARAMETERS *callbackParameters)
 {
-    // Intentionally empty.  The Go side will time out or cancel the context.
+    goCancelFetchCallback(callbackInfo->TransferKey);
+}
+
+// Callback for a closed placeholder: the Go side uploads local edits.
+// NormalizedPath is the full path without the drive, since we connect with
+// CF_CONNECT_FLAG_REQUIRE_FULL_FILE_PATH.
+static void CALLBACK FileCloseCompletionCallback(
+    _In_ CONST CF_CALLBACK_INFO *callbackInfo,
+    _In_ CONST CF_CALLBACK_PARAMETERS *callbackParameters)
+{
+    std::string path = WideToUtf8(callbackInfo->VolumeDosName) +
+                       WideToUtf8(callbackInfo->NormalizedPath);
+    int deleted = (callbackParameters->CloseCompletion.Flags &
+                   CF_CALLBACK_CLOSE_COMPLETION_FLAG_DELETED) ? 1 : 0;
+    goFileCloseCallback(&path[0], deleted);
 }
 
 // Callback table registered with CfConnectSyncRoot.
 static CF_CALLBACK_REGISTRATION s_callbackTable[] = {
-    { CF_CALLBACK_TYPE_FETCH_DATA,         FetchDataCallback },
-    { CF_CALLBACK_TYPE_CANCEL_FETCH_DATA,  CancelFetchDataCallback },
+    { CF_CALLBACK_TYPE_FETCH_DATA,                   FetchDataCallback },
+    { CF_CALLBACK_TYPE_CANCEL_FETCH_DATA,            CancelFetchDataCallback },
+    { CF_CALLBACK_TYPE_NOTIFY_FILE_CLOSE_COMPLETION, FileCloseCompletionCallback },
     CF_CALLBACK_REGISTRATION_END
 };
 
@@ -242,6 +268,102 @@ long cfapi_update_placeholder(const char *file_path,
     return static_cast<long>(hr);
 }
 
+long cfapi_convert_to_placeholder(const char *file_path,
+                                   const char *file_identity)
+{
+    std::wstring wPath = Utf8ToWide(file_path);
+
+    HANDLE hFile = CreateFileW(wPath.c_str(),
+        FILE_READ_ATTRIBUTES | FILE_WRITE_ATTRIBUTES, FILE_SHARE_READ, nullptr,
+        OPEN_EXISTING, FILE_FLAG_BACKUP_SEMANTICS, nullptr);
+
+    if (hFile == INVALID_HANDLE_VALUE) {
+        return static_cast<long>(HRESULT_FROM_WIN32(GetLastError()));
+    }
+
+    HRESULT hr = CfConvertToPlaceholder(
+        hFile,
+        file_identity,
+        static_cast<DWORD>(strlen(file_identity)),
+        CF_CONVERT_FLAG_MARK_IN_SYNC,
+        nullptr, nullptr);
+
+    CloseHandle(hFile);
+    return static_cast<long>(hr);
+}
+
+long cfapi_hydrate_placeholder(const char *file_path) {
+    std::wstring wPath = Utf8ToWide(file_path);
+
+    HANDLE hFile = CreateFileW(wPath.c_str(),
+        FILE_READ_ATTRIBUTES, FILE_SHARE_READ | FILE_SHARE_WRITE, nullptr,
+        OPEN_EXISTING, 0, nullptr);
+
+    if (hFile == INVALID_HANDLE_VALUE) {
+        return static_cast<long>(HRESULT_FROM_WIN32(GetLastError()));
+    }
+
+    LARGE_INTEGER start = {};
+    LARGE_INTEGER length;
+    length.QuadPart = -1; // to the end of the file
+
+    HRESULT hr = CfHydratePlaceholder(hFile, start, length,
+                                       CF_HYDRATE_FLAG_NONE, nullptr);
+    CloseHandle(hFile);
+    return static_cast<long>(hr);
+}
+
+long cfapi_get_state(const char *file_path, int *out_flags) {
+    std::wstring wPath = Utf8ToWide(file_path);
+    *out_flags = 0;
+
+    HANDLE hFile = CreateFileW(wPath.c_str(),
+        FILE_READ_ATTRIBUTES, FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+        nullptr, OPEN_EXISTING,
+        FILE_FLAG_BACKUP_SEMANTICS | FILE_FLAG_OPEN_REPARSE_POINT, nullptr);
+
+    if (hFile == INVALID_HANDLE_VALUE) {
+        return static_cast<long>(HRESULT_FROM_WIN32(GetLastError()));
+    }
+
+    FILE_ATTRIBUTE_TAG_INFO tagInfo = {};
+    BOOL ok = GetFileInformationByHandleEx(hFile, FileAttributeTagInfo,
+                                           &tagInfo, sizeof(tagInfo));
+    DWORD err = GetLastError();
+    CloseHandle(hFile);
+    if (!ok) {
+        return static_cast<long>(HRESULT_FROM_WIN32(err));
+    }
+
+    CF_PLACEHOLDER_STATE state = CfGetPlaceholderStateFromAttributeTag(
+        tagInfo.FileAttributes, tagInfo.ReparseTag);
+
+    int flags = 0;
+    if (state != CF_PLACEHOLDER_STATE_INVALID &&
+        (state & CF_PLACEHOLDER_STATE_PLACEHOLDER)) {
+        flags |= CFAPI_STATE_PLACEHOLDER;
+        if (state & CF_PLACEHOLDER_STATE_IN_SYNC) flags |= CFAPI_STATE_IN_SYNC;
+        if (state & CF_PLACEHOLDER_STATE_PARTIAL) flags |= CFAPI_STATE_PARTIAL;
+    }
+    if (tagInfo.FileAttributes & FILE_ATTRIBUTE_PINNED)    flags |= CFAPI_STATE_PINNED;
+    if (tagInfo.FileAttributes & FILE_ATTRIBUTE_UNPINNED)  flags |= CFAPI_STATE_UNPINNED;
+    if (tagInfo.FileAttributes & FILE_ATTRIBUTE_DIRECTORY) flags |= CFAPI_STATE_DIRECTORY;
+
+    *out_flags = flags;
+    return 0;
+}
+
+void cfapi_report_progress(CF_CONNECTION_KEY conn_key,
+                            CF_TRANSFER_KEY transfer_key,
+                            long long total,
+                            long long completed)
+{
+    LARGE_INTEGER liTotal, liCompleted;
+    liTotal.QuadPart = total;
+    liCompleted.QuadPart = completed;
+    CfReportProviderProgress(conn_key, transfer_key, liTotal, liCompleted);
+}
+
 long cfapi_dehydrate_placeholder(const char *file_path) {
     std::wstring wPath = Utf8ToWide(file_path);
 
@@ -82,6 +82,51 @@ long cfapi_update_placeholder(const char *file_path,
                                long long file_size,
                                long long mtime_unix);
 
+/*
+ * Convert a regular file or directory created in the sync root into an
+ * in-sync placeholder.
+ *   file_path:     absolute path (UTF-8)
+ *   file_identity: identity blob (UTF-8)
+ * Returns HRESULT.
+ */
+long cfapi_convert_to_placeholder(const char *file_path,
+                                   const char *file_identity);
+
+/*
+ * Download the full content of a placeholder (used for pinned files).
+ *   file_path: absolute path to the file (UTF-8)
+ * Returns HRESULT.
+ */
+long cfapi_hydrate_placeholder(const char *file_path);
+
+/* State flags returned by cfapi_get_state. */
+#define CFAPI_STATE_PLACEHOLDER 0x01 /* a placeholder, not a regular file */
+#define CFAPI_STATE_IN_SYNC     0x02 /* unchanged since the last sync */
+#define CFAPI_STATE_PARTIAL     0x04 /* content not (fully) on disk */
+#define CFAPI_STATE_PINNED      0x08 /* "Always keep on this device" */
+#define CFAPI_STATE_UNPINNED    0x10 /* "Free up space" */
+#define CFAPI_STATE_DIRECTORY   0x20
+
+/*
+ * Read the placeholder and pin state of a file or directory.
+ *   file_path: absolute path (UTF-8)
+ *   out_flags: receives a combination of CFAPI_STATE_* flags
+ * Returns HRESULT.
+ */
+long cfapi_get_state(const char *file_path, int *out_flags);
+
+/*
+ * Report hydration progress to Explorer.
+ *   conn_key:     connection key
+ *   transfer_key: transfer key from the hydration callback
+ *   total:        total bytes to transfer
+ *   completed:    bytes transferred so far
+ */
+void cfapi_report_progress(CF_CONNECTION_KEY conn_key,
+                            CF_TRANSFER_KEY transfer_key,
+                            long long total,
+                            long long completed);
+
 /*
  * Dehydrate a placeholder (remove local content, keep placeholder).
  *   file_path: absolute path to the file (UTF-8)
@@ -116,17 +161,25 @@ void cfapi_transfer_error(CF_CONNECTION_KEY conn_key,
                            long long offset,
                            long hr);
 
-#ifdef __cplusplus
-}
-#endif
-
 /*
- * Go callback declaration (implemented in cfapi_windows.go via //export).
- * Called by the C++ FetchDataCallback when CfAPI requests file data.
+ * Go callback declarations (implemented in cfapi_windows.go via //export).
+ * Pointer parameters are not const so the declarations match the ones cgo
+ * generates.
+ *
+ * goHydrationCallback is called by FetchDataCallback when CfAPI requests
+ * file data, goCancelFetchCallback when that request is cancelled, and
+ * goFileCloseCallback when a placeholder is closed (filePath is absolute,
+ * UTF-8; deleted is 1 if the file was deleted).
  */
-extern void goHydrationCallback(const char *fileIdentity, int fileIdentityLen,
+extern void goHydrationCallback(char *fileIdentity, int fileIdentityLen,
                                  long long offset, long long length,
                                  CF_TRANSFER_KEY transferKey);
+extern void goCancelFetchCallback(CF_TRANSFER_KEY transferKey);
+extern void goFileCloseCallback(char *filePath, int deleted);
+
+#ifdef __cplusplus
+}
+#endif
 
 #else /* !_WIN32 */
 
@@ -6,33 +6,92 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
 )
 
+// Eviction policies: which entry is removed first when the cache is full.
+const (
+	// PolicySizeAge evicts the entry with the largest size × time since
+	// last access, so one large cold file goes before many small warm ones.
+	PolicySizeAge = "size-age"
+	// PolicyLRU evicts the least recently used entry.
+	PolicyLRU = "lru"
+)
+
+// ValidPolicy reports whether p names an eviction policy.
+func ValidPolicy(p string) bool {
+	return p == PolicySizeAge || p == PolicyLRU
+}
+
 // Cache manages locally cached files.
 type Cache struct {
 	dir     string
 	maxSize int64 // Maximum cache size in bytes
-
-	mu      sync.RWMutex
-	entries map[string]*models.CacheEntry
-	size    int64
+	policy  string
+
+	mu       sync.RWMutex
+	entries  map[string]*models.CacheEntry
+	partials map[string]*partial
+	size     int64           // entries plus partial downloads
+	rules    map[string]bool // pinned path prefixes
+	onEvict  func(*models.CacheEntry)
+	leases   map[string]*leaseRef // active leases by file ID
+
+	journal        *os.File // index journal, opened on first change
+	journalRecords int
+
+	// Free space reserve on the cache's volume (diskspace.go)
+	minFree    int64 // 0 = DefaultMinFree, negative = none
+	diskSpace  diskSpaceFunc
+	diskFull   atomic.Bool
+	refused    int64 // bytes of the content last refused for lack of space
+	onDiskFull func(full bool)
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+
+	emergencyEvictions atomic.Int64
 }
 
-// New creates a new cache.
+// New creates a new cache, with the entries recorded in the cache index
+// (see index.go).
 func New(dir string, maxSize int64) (*Cache, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("create cache dir: %w", err)
 	}
-	return &Cache{
-		dir:     dir,
-		maxSize: maxSize,
-		entries: make(map[string]*models.CacheEntry),
-	}, nil
+	c := &Cache{
+		dir:       dir,
+		maxSize:   maxSize,
+		policy:    PolicySizeAge,
+		entries:   make(map[string]*models.CacheEntry),
+		leases:    make(map[string]*leaseRef),
+		partials:  make(map[string]*partial),
+		rules:     make(map[string]bool),
+		diskSpace: statDisk,
+	}
+	c.loadIndex()
+	c.loadPartials()
+	return c, nil
+}
+
+// SetPolicy sets the eviction policy (PolicySizeAge by default).
+func (c *Cache) SetPolicy(policy string) error {
+	if !ValidPolicy(policy) {
+		return fmt.Errorf("unknown cache policy %q", policy)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+	return nil
 }
 
 // Get returns the local path if the file is cached.
@@ -42,8 +101,10 @@ func (c *Cache) Get(fileID string) (string, bool) {
 
 	entry, ok := c.entries[fileID]
 	if !ok {
+		c.misses.Add(1)
 		return "", false
 	}
+	c.hits.Add(1)
 
 	// Update last access time
 	entry.LastAccess = time.Now()
@@ -53,15 +114,25 @@ func (c *Cache) Get(fileID string) (string, bool) {
 // Put stores a file in the cache.
 // Content is written atomically (temp file then rename).
 func (c *Cache) Put(fileID string, r io.Reader, size int64) (string, error) {
+	return c.PutFile(fileID, "", r, size)
+}
+
+// PutFile is Put for a file whose server path is known, so that pin rules
+// apply to it. It returns ErrDiskFull if the content does not fit on the
+// cache's volume.
+func (c *Cache) PutFile(fileID, path string, r io.Reader, size int64) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// Evict if needed
 	for c.size+size > c.maxSize {
-		if !c.evictOldest() {
+		if !c.evictOne() {
 			break // Nothing to evict
 		}
 	}
+	if err := c.ensureFreeLocked(size); err != nil {
+		return "", err
+	}
 
 	// Write to temp file
 	localPath := filepath.Join(c.dir, fileID)
@@ -85,23 +156,92 @@ func (c *Cache) Put(fileID string, r io.Reader, size int64) (string, error) {
 		return "", fmt.Errorf("rename temp file: %w", err)
 	}
 
-	c.entries[fileID] = &models.CacheEntry{
+	if old, ok := c.entries[fileID]; ok {
+		c.size -= old.Size
+	}
+	entry := &models.CacheEntry{
 		FileID:     fileID,
+		Path:       path,
 		LocalPath:  localPath,
 		Size:       written,
 		LastAccess: time.Now(),
 		Pinned:     false,
 	}
+	c.entries[fileID] = entry
 	c.size += written
+	c.recordPutLocked(entry)
 
 	return localPath, nil
 }
 
+// Track records a file whose content is kept outside the cache directory,
+// such as a hydrated Cloud Files placeholder. It counts against the cache
+// size and can be pinned like any other entry; evicting it calls the
+// function set with SetEvictFunc instead of removing localPath. As the
+// content is already on disk, other entries are evicted if it went into
+// the free space reserve.
+func (c *Cache) Track(fileID, path, localPath string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pinned := false
+	if old, ok := c.entries[fileID]; ok {
+		c.size -= old.Size
+		pinned = old.Pinned
+		delete(c.entries, fileID)
+	}
+	for c.size+size > c.maxSize {
+		if !c.evictOne() {
+			break
+		}
+	}
+
+	entry := &models.CacheEntry{
+		FileID:     fileID,
+		Path:       path,
+		LocalPath:  localPath,
+		Size:       size,
+		LastAccess: time.Now(),
+		Pinned:     pinned,
+		External:   true,
+	}
+	c.entries[fileID] = entry
+	c.size += size
+	c.recordPutLocked(entry)
+	c.ensureFreeLocked(0)
+}
+
+// SetEvictFunc sets the function called when a tracked entry is evicted.
+// It runs with the cache locked and must not call back into the cache.
+func (c *Cache) SetEvictFunc(fn func(*models.CacheEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvict = fn
+}
+
+// removeLocked drops an entry and its content. Must be called with lock held.
+func (c *Cache) removeLocked(fileID string, entry *models.CacheEntry) {
+	if entry.External {
+		if c.onEvict != nil {
+			c.onEvict(entry)
+		}
+	} else {
+		os.Remove(entry.LocalPath)
+	}
+	c.size -= entry.Size
+	delete(c.entries, fileID)
+	c.recordRemoveLocked(fileID)
+}
+
 // Evict removes a file from the cache.
 func (c *Cache) Evict(fileID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if p, ok := c.partials[fileID]; ok && !p.active {
+		c.removePartialLocked(fileID, p)
+	}
+
 	entry, ok := c.entries[fileID]
 	if !ok {
 		return nil
@@ -111,9 +251,52 @@ func (c *Cache) Evict(fileID string) error {
 		return fmt.Errorf("cannot evict pinned file: %s", fileID)
 	}
 
-	os.Remove(entry.LocalPath)
-	c.size -= entry.Size
-	delete(c.entries, fileID)
+	c.removeLocked(fileID, entry)
+	return nil
+}
+
+// Rename moves the entry of a renamed file to the file's new ID and path,
+// so the cached content follows the file instead of being downloaded
+// again. An entry already at newID belonged to a file the rename replaced
+// and is dropped; the moved entry stays pinned if either was. A partial
+// download of the old ID is discarded.
+func (c *Cache) Rename(oldID, newID, newPath string) error {
+	if oldID == newID {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p, ok := c.partials[oldID]; ok && !p.active {
+		c.removePartialLocked(oldID, p)
+	}
+
+	pinned := false
+	if old, ok := c.entries[newID]; ok {
+		pinned = old.Pinned
+		c.removeLocked(newID, old)
+	}
+
+	entry, ok := c.entries[oldID]
+	if !ok {
+		return nil
+	}
+	if !entry.External {
+		localPath := filepath.Join(c.dir, newID)
+		if err := os.Rename(entry.LocalPath, localPath); err != nil {
+			c.removeLocked(oldID, entry)
+			return fmt.Errorf("rename cached file: %w", err)
+		}
+		entry.LocalPath = localPath
+	}
+	delete(c.entries, oldID)
+	c.recordRemoveLocked(oldID)
+	c.moveLeaseLocked(oldID, newID)
+	entry.FileID = newID
+	entry.Path = newPath
+	entry.Pinned = entry.Pinned || pinned
+	c.entries[newID] = entry
+	c.recordPutLocked(entry)
 	return nil
 }
 
@@ -127,6 +310,7 @@ func (c *Cache) Pin(fileID string) error {
 		return fmt.Errorf("file not cached: %s", fileID)
 	}
 	entry.Pinned = true
+	c.recordPutLocked(entry)
 	return nil
 }
 
@@ -140,36 +324,64 @@ func (c *Cache) Unpin(fileID string) error {
 		return fmt.Errorf("file not cached: %s", fileID)
 	}
 	entry.Pinned = false
+	c.recordPutLocked(entry)
 	return nil
 }
 
-// evictOldest removes the oldest non-pinned file.
+// evictOne removes the entry or partial download that the policy ranks
+// first. Pinned and leased entries and downloads in progress are skipped.
 // Must be called with lock held.
-func (c *Cache) evictOldest() bool {
-	var oldest *models.CacheEntry
-	var oldestID string
+func (c *Cache) evictOne() bool {
+	now := time.Now()
+	var victim *models.CacheEntry
+	var victimID string
+	victimCost := -1.0
 
 	for id, entry := range c.entries {
-		if entry.Pinned {
+		if c.isPinnedLocked(entry) || c.isLeasedLocked(id) {
 			continue
 		}
-		if oldest == nil || entry.LastAccess.Before(oldest.LastAccess) {
-			oldest = entry
-			oldestID = id
+		if cost := c.evictionCost(entry.Size, now.Sub(entry.LastAccess)); cost > victimCost {
+			victim, victimID, victimCost = entry, id, cost
 		}
 	}
 
-	if oldest == nil {
+	var orphan *partial
+	var orphanID string
+	orphanCost := -1.0
+	for id, p := range c.partials {
+		if p.active || p.size == 0 {
+			continue
+		}
+		if cost := c.evictionCost(p.size, now.Sub(p.mtime)); cost > orphanCost {
+			orphan, orphanID, orphanCost = p, id, cost
+		}
+	}
+
+	if orphan != nil && orphanCost > victimCost {
+		c.removePartialLocked(orphanID, orphan)
+		c.evictions.Add(1)
+		return true
+	}
+	if victim == nil {
 		return false
 	}
 
-	os.Remove(oldest.LocalPath)
-	c.size -= oldest.Size
-	delete(c.entries, oldestID)
+	c.removeLocked(victimID, victim)
+	c.evictions.Add(1)
 	return true
 }
 
-// Stats returns cache statistics.
+// evictionCost ranks eviction candidates: the highest cost goes first.
+func (c *Cache) evictionCost(size int64, age time.Duration) float64 {
+	age = max(age, 0)
+	if c.policy == PolicyLRU {
+		return age.Seconds()
+	}
+	return float64(size) * age.Seconds()
+}
+
+// Stats returns cache statistics. size includes partial downloads.
 func (c *Cache) Stats() (size, maxSize int64, count int) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -195,28 +407,32 @@ func (c *Cache) Pinned() []*models.CacheEntry {
 
 	entries := make([]*models.CacheEntry, 0)
 	for _, entry := range c.entries {
-		if entry.Pinned {
+		if c.isPinnedLocked(entry) {
 			entries = append(entries, entry)
 		}
 	}
 	return entries
 }
 
-// Clear removes all non-pinned files from the cache.
+// Clear removes all non-pinned files from the cache, except those in use
+// under a lease.
 func (c *Cache) Clear() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	count := 0
 	for id, entry := range c.entries {
-		if entry.Pinned {
+		if c.isPinnedLocked(entry) || c.isLeasedLocked(id) {
 			continue
 		}
-		os.Remove(entry.LocalPath)
-		c.size -= entry.Size
-		delete(c.entries, id)
+		c.removeLocked(id, entry)
 		count++
 	}
+	for id, p := range c.partials {
+		if !p.active {
+			c.removePartialLocked(id, p)
+		}
+	}
 	return count
 }
 
@@ -233,12 +449,71 @@ func (c *Cache) IsCached(fileID string) bool {
 	return ok
 }
 
-// IsPinned returns true if the file is pinned.
+// IsPinned returns true if the file is pinned, directly or by a rule.
 func (c *Cache) IsPinned(fileID string) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	entry, ok := c.entries[fileID]
-	return ok && entry.Pinned
+	return ok && c.isPinnedLocked(entry)
+}
+
+// isPinnedLocked must be called with the lock held.
+func (c *Cache) isPinnedLocked(entry *models.CacheEntry) bool {
+	return entry.Pinned || (entry.Path != "" && c.ruleForLocked(entry.Path) != "")
+}
+
+// PinPrefix adds a pin rule: every file at or below prefix is kept in the
+// cache, including files cached later.
+func (c *Cache) PinPrefix(prefix string) string {
+	prefix = cleanRulePath(prefix)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules[prefix] = true
+	return prefix
+}
+
+// UnpinPrefix removes a pin rule. Files pinned individually stay pinned.
+func (c *Cache) UnpinPrefix(prefix string) error {
+	prefix = cleanRulePath(prefix)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.rules[prefix] {
+		return fmt.Errorf("no pin rule for %s", prefix)
+	}
+	delete(c.rules, prefix)
+	return nil
+}
+
+// PinRules returns the pinned path prefixes, sorted.
+func (c *Cache) PinRules() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rules := make([]string, 0, len(c.rules))
+	for r := range c.rules {
+		rules = append(rules, r)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// PinRuleFor returns the rule covering p, or "" if there is none.
+func (c *Cache) PinRuleFor(p string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ruleForLocked(cleanRulePath(p))
+}
+
+func (c *Cache) ruleForLocked(p string) string {
+	for r := range c.rules {
+		if r == "/" || p == r || strings.HasPrefix(p, r+"/") {
+			return r
+		}
+	}
+	return ""
+}
+
+func cleanRulePath(p string) string {
+	return path.Clean("/" + p)
 }
 
 // SavePins persists the pinned file IDs to a JSON file in the cache directory.
@@ -256,11 +531,23 @@ func (c *Cache) SavePins() error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(c.dir, "pins.json"), data, 0644)
+	if err := os.WriteFile(filepath.Join(c.dir, "pins.json"), data, 0644); err != nil {
+		return err
+	}
+
+	rules, err := json.Marshal(c.PinRules())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.dir, "pin-rules.json"), rules, 0644)
 }
 
-// LoadPins restores pinned status from the persisted pins file.
+// LoadPins restores pinned status and pin rules from the persisted files.
 func (c *Cache) LoadPins() error {
+	if _, err := c.LoadPinRules(); err != nil {
+		return err
+	}
+
 	data, err := os.ReadFile(filepath.Join(c.dir, "pins.json"))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -277,13 +564,45 @@ func (c *Cache) LoadPins() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for _, id := range pins {
-		if entry, ok := c.entries[id]; ok {
+		if entry, ok := c.entries[id]; ok && !entry.Pinned {
 			entry.Pinned = true
+			c.recordPutLocked(entry)
 		}
 	}
 	return nil
 }
 
+// LoadPinRules replaces the pin rules with the persisted ones (which another
+// process may have changed) and returns the rules that were added.
+func (c *Cache) LoadPinRules() ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, "pin-rules.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rules []string
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var added []string
+	loaded := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		r = cleanRulePath(r)
+		loaded[r] = true
+		if !c.rules[r] {
+			added = append(added, r)
+		}
+	}
+	c.rules = loaded
+	return added, nil
+}
+
 // PinByPath finds a cached file by matching a path suffix and pins it.
 // Returns the file ID if found.
 func (c *Cache) PinByPath(path string) (string, error) {
@@ -293,6 +612,7 @@ func (c *Cache) PinByPath(path string) (string, error) {
 	for id, entry := range c.entries {
 		if entry.LocalPath != "" && (id == path || filepath.Base(entry.LocalPath) == path) {
 			entry.Pinned = true
+			c.recordPutLocked(entry)
 			return id, nil
 		}
 	}
@@ -307,6 +627,7 @@ func (c *Cache) UnpinByPath(path string) (string, error) {
 	for id, entry := range c.entries {
 		if entry.LocalPath != "" && (id == path || filepath.Base(entry.LocalPath) == path) {
 			entry.Pinned = false
+			c.recordPutLocked(entry)
 			return id, nil
 		}
 	}
@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
 )
 
 func TestCache_PutAndGet(t *testing.T) {
@@ -314,3 +316,142 @@ func TestNew_CreatesDir(t *testing.T) {
 		t.Error("cache directory was not created")
 	}
 }
+
+func TestCache_PinPrefix(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if got := c.PinPrefix("projects/alpha/"); got != "/projects/alpha" {
+		t.Errorf("PinPrefix cleaned to %q", got)
+	}
+	c.PutFile("a1", "/projects/alpha/a.txt", bytes.NewReader([]byte("12345")), 5)
+	c.PutFile("b1", "/projects/alphabet.txt", bytes.NewReader([]byte("12345")), 5)
+
+	if !c.IsPinned("a1") || c.IsPinned("b1") {
+		t.Errorf("IsPinned: a1=%v b1=%v", c.IsPinned("a1"), c.IsPinned("b1"))
+	}
+	if r := c.PinRuleFor("/projects/alpha/sub/x"); r != "/projects/alpha" {
+		t.Errorf("PinRuleFor = %q", r)
+	}
+
+	// Making room evicts the unpinned file, never the one under the rule
+	c.PutFile("c1", "/other.txt", bytes.NewReader([]byte("12345")), 5)
+	if !c.IsCached("a1") || c.IsCached("b1") {
+		t.Errorf("after eviction: a1=%v b1=%v", c.IsCached("a1"), c.IsCached("b1"))
+	}
+	if n := c.Clear(); n != 1 || !c.IsCached("a1") {
+		t.Errorf("Clear removed %d, a1 cached=%v", n, c.IsCached("a1"))
+	}
+	if len(c.Pinned()) != 1 {
+		t.Errorf("Pinned() = %d entries, want 1", len(c.Pinned()))
+	}
+
+	// Rules survive a reload; LoadPinRules reports rules added elsewhere
+	if err := c.SavePins(); err != nil {
+		t.Fatalf("SavePins: %v", err)
+	}
+	c2, _ := New(dir, 10)
+	added, err := c2.LoadPinRules()
+	if err != nil || len(added) != 1 || added[0] != "/projects/alpha" {
+		t.Errorf("LoadPinRules = %v, %v", added, err)
+	}
+	if added, _ := c2.LoadPinRules(); len(added) != 0 {
+		t.Errorf("second LoadPinRules added %v", added)
+	}
+
+	if err := c.UnpinPrefix("/projects/alpha"); err != nil {
+		t.Fatalf("UnpinPrefix: %v", err)
+	}
+	if c.IsPinned("a1") {
+		t.Error("a1 still pinned after removing the rule")
+	}
+	if err := c.UnpinPrefix("/projects/alpha"); err == nil {
+		t.Error("expected error removing a missing rule")
+	}
+}
+
+func TestCache_PutFileReplaces(t *testing.T) {
+	c, _ := New(t.TempDir(), 100)
+	c.PutFile("a", "/a", bytes.NewReader([]byte("12345")), 5)
+	c.PutFile("a", "/a", bytes.NewReader([]byte("123")), 3)
+	if size, _, count := c.Stats(); size != 3 || count != 1 {
+		t.Errorf("size=%d count=%d, want 3 and 1", size, count)
+	}
+}
+
+func TestCache_Track(t *testing.T) {
+	c, _ := New(t.TempDir(), 10)
+	outside := filepath.Join(t.TempDir(), "doc.txt")
+	os.WriteFile(outside, []byte("123456"), 0644)
+
+	var evicted []string
+	c.SetEvictFunc(func(e *models.CacheEntry) { evicted = append(evicted, e.FileID) })
+
+	c.Track("doc", "/doc.txt", outside, 6)
+	if p, ok := c.Get("doc"); !ok || p != outside {
+		t.Fatalf("Get = %q, %v; want the tracked path", p, ok)
+	}
+
+	// Making room evicts the tracked entry through the hook, leaving its file
+	c.Put("b", bytes.NewReader([]byte("12345")), 5)
+	if len(evicted) != 1 || evicted[0] != "doc" {
+		t.Errorf("evicted = %v, want [doc]", evicted)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("tracked file removed: %v", err)
+	}
+	if size, _, _ := c.Stats(); size != 5 {
+		t.Errorf("size = %d, want 5", size)
+	}
+
+	// Re-tracking keeps the pin
+	c.Track("doc", "/doc.txt", outside, 4)
+	c.Pin("doc")
+	c.Track("doc", "/doc.txt", outside, 4)
+	if !c.IsPinned("doc") {
+		t.Error("pin lost on re-track")
+	}
+}
+
+func TestCache_Rename(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(dir, 100)
+	c.PutFile("old", "/a.txt", bytes.NewReader([]byte("new content")), 11)
+	c.PutFile("target", "/b.txt", bytes.NewReader([]byte("stale")), 5)
+	c.Pin("target")
+
+	if err := c.Rename("old", "target", "/b.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if c.IsCached("old") {
+		t.Error("old ID still cached")
+	}
+	p, ok := c.Get("target")
+	if !ok || p != filepath.Join(dir, "target") {
+		t.Fatalf("Get = %q, %v; want the entry under the new ID", p, ok)
+	}
+	if data, _ := os.ReadFile(p); string(data) != "new content" {
+		t.Errorf("content = %q, want the renamed file's", data)
+	}
+	if !c.IsPinned("target") {
+		t.Error("pin of the replaced entry lost")
+	}
+	if entries := c.List(); len(entries) != 1 || entries[0].Path != "/b.txt" {
+		t.Errorf("entries = %+v, want one entry for /b.txt", entries)
+	}
+	if size, _, _ := c.Stats(); size != 11 {
+		t.Errorf("size = %d, want 11", size)
+	}
+
+	// Nothing cached under the old ID: a stale target is still dropped
+	c.PutFile("c", "/c.txt", bytes.NewReader([]byte("123")), 3)
+	if err := c.Rename("missing", "c", "/c.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if c.IsCached("c") {
+		t.Error("replaced entry still cached")
+	}
+}
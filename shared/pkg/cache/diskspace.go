@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"errors"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+)
+
+// Besides the maximum cache size, the cache keeps a reserve of free space
+// on its volume, so that a cache larger than the disk has room for does
+// not fill it to the last byte. Content that would eat into the reserve
+// first evicts unpinned entries and, if that is not enough, is refused
+// with ErrDiskFull. The cache is then "disk full" until the refused
+// content would fit again.
+
+// ErrDiskFull is returned when content does not fit on the cache's volume
+// without going below the free space reserve.
+var ErrDiskFull = errors.New("not enough free disk space for the cache")
+
+// DefaultMinFree is the default free space reserve: 1 GB, or
+// defaultMinFreePercent of the volume if that is less.
+const (
+	DefaultMinFree        = 1 << 30
+	defaultMinFreePercent = 5
+)
+
+// DiskStatus describes the free space of the cache's volume.
+type DiskStatus struct {
+	Known   bool  // false if the free space could not be determined
+	Free    int64 // bytes available to the cache
+	Total   int64
+	Reserve int64 // free space the cache leaves alone
+	Full    bool  // content is refused for lack of space
+}
+
+// diskSpaceFunc returns the free and total bytes of the volume holding dir.
+type diskSpaceFunc func(dir string) (free, total uint64, err error)
+
+// SetMinFree sets the free space reserve in bytes: 0 selects
+// DefaultMinFree, a negative value disables the reserve.
+func (c *Cache) SetMinFree(bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.minFree = bytes
+}
+
+// SetDiskFullFunc sets the function called when the cache becomes disk
+// full and when it recovers. It runs with the cache locked and must not
+// call back into the cache.
+func (c *Cache) SetDiskFullFunc(fn func(full bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDiskFull = fn
+}
+
+// DiskFull reports whether the cache refuses content for lack of space.
+func (c *Cache) DiskFull() bool {
+	return c.diskFull.Load()
+}
+
+// EmergencyEvictions returns how many entries were evicted to keep the
+// free space reserve.
+func (c *Cache) EmergencyEvictions() int64 {
+	return c.emergencyEvictions.Load()
+}
+
+// DiskStatus returns the free space of the cache's volume. A disk full
+// cache recovers here once the content it refused would fit.
+func (c *Cache) DiskStatus() DiskStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.diskStatusLocked()
+	if st.Known && st.Full && st.Free-c.refused >= st.Reserve {
+		c.setDiskFullLocked(false, 0)
+		st.Full = false
+	}
+	return st
+}
+
+// EnsureFree makes room for n more bytes written to the cache's volume
+// outside the cache, such as a file being edited, evicting unpinned
+// entries if needed. It returns ErrDiskFull if they do not fit.
+func (c *Cache) EnsureFree(n int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ensureFreeLocked(n)
+}
+
+// diskStatusLocked must be called with the lock held.
+func (c *Cache) diskStatusLocked() DiskStatus {
+	st := DiskStatus{Full: c.diskFull.Load()}
+	if c.minFree < 0 || c.diskSpace == nil {
+		return st
+	}
+	free, total, err := c.diskSpace(c.dir)
+	if err != nil {
+		return st
+	}
+	st.Known = true
+	st.Free = int64(free)
+	st.Total = int64(total)
+	st.Reserve = c.minFree
+	if st.Reserve == 0 {
+		st.Reserve = min(DefaultMinFree, st.Total*defaultMinFreePercent/100)
+	}
+	return st
+}
+
+// ensureFreeLocked evicts the entries the policy ranks first until n more
+// bytes fit above the reserve, and returns ErrDiskFull if they do not. If
+// the free space is unknown, nothing is checked. Must be called with the
+// lock held.
+func (c *Cache) ensureFreeLocked(n int64) error {
+	for {
+		st := c.diskStatusLocked()
+		if !st.Known || st.Free-n >= st.Reserve {
+			c.setDiskFullLocked(false, 0)
+			return nil
+		}
+		if !c.evictOne() {
+			c.setDiskFullLocked(true, n)
+			return ErrDiskFull
+		}
+		c.emergencyEvictions.Add(1)
+	}
+}
+
+// setDiskFullLocked records whether content of refused bytes was turned
+// away. Must be called with the lock held.
+func (c *Cache) setDiskFullLocked(full bool, refused int64) {
+	c.refused = refused
+	if c.diskFull.Swap(full) == full {
+		return
+	}
+	if full {
+		logger.Warn("Cache volume is nearly full: %d bytes did not fit above the free space reserve", refused)
+	} else {
+		logger.Info("Cache volume has free space again")
+	}
+	if c.onDiskFull != nil {
+		c.onDiskFull(full)
+	}
+}
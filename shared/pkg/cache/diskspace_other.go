@@ -0,0 +1,6 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package cache
+
+// statDisk is not available here: the free space reserve is not enforced.
+var statDisk diskSpaceFunc
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fakeDisk is a volume of total bytes on which the cache's files are the
+// only ones besides used.
+type fakeDisk struct {
+	total, used int64
+	c           *Cache
+}
+
+func (d *fakeDisk) space(string) (free, total uint64, err error) {
+	return uint64(d.total - d.used - d.c.size), uint64(d.total), nil
+}
+
+func newDiskCache(t *testing.T, total, used int64) (*Cache, *fakeDisk) {
+	t.Helper()
+	c, err := New(t.TempDir(), 1<<40)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	d := &fakeDisk{total: total, used: used, c: c}
+	c.diskSpace = d.space
+	return c, d
+}
+
+func TestCache_DiskReserve(t *testing.T) {
+	// 1000 bytes, 5% (50) reserved by default
+	c, d := newDiskCache(t, 1000, 500)
+	var changes []bool
+	c.SetDiskFullFunc(func(full bool) { changes = append(changes, full) })
+
+	put := func(id string, size int) error {
+		_, err := c.Put(id, bytes.NewReader(make([]byte, size)), int64(size))
+		return err
+	}
+	if err := put("a", 200); err != nil {
+		t.Fatalf("Put a: %v", err)
+	}
+	if err := put("b", 200); err != nil {
+		t.Fatalf("Put b: %v", err)
+	}
+	// 100 free: b's 50 fit, more evicts a
+	if err := put("c", 100); err != nil {
+		t.Fatalf("Put c: %v", err)
+	}
+	if c.IsCached("a") || !c.IsCached("b") || c.EmergencyEvictions() != 1 {
+		t.Errorf("after c: a cached %v, b cached %v, %d emergency evictions",
+			c.IsCached("a"), c.IsCached("b"), c.EmergencyEvictions())
+	}
+
+	// Pinned content is never evicted for space
+	c.Pin("b")
+	c.Pin("c")
+	if err := put("d", 300); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("Put d = %v, want ErrDiskFull", err)
+	}
+	if c.IsCached("d") || !c.DiskFull() {
+		t.Errorf("d cached %v, disk full %v", c.IsCached("d"), c.DiskFull())
+	}
+	if err := c.EnsureFree(500); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("EnsureFree(500) = %v", err)
+	}
+	got := collectValues(t, NewCollector(c))
+	if got["fruitsalade_client_cache_disk_full"] != 1 || got["fruitsalade_client_cache_emergency_evictions_total"] != 1 ||
+		got["fruitsalade_client_cache_disk_free_bytes"] != 200 {
+		t.Errorf("metrics = %v", got)
+	}
+
+	// Freeing space elsewhere ends the disk full state
+	d.used = 0
+	if st := c.DiskStatus(); st.Full || st.Reserve != 50 || st.Free != 700 {
+		t.Errorf("DiskStatus = %+v", st)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("disk full changes = %v", changes)
+	}
+}
+
+func TestCache_DiskReserveSettings(t *testing.T) {
+	c, _ := newDiskCache(t, 100<<30, 0)
+	if st := c.DiskStatus(); st.Reserve != DefaultMinFree {
+		t.Errorf("default reserve = %d, want %d", st.Reserve, DefaultMinFree)
+	}
+	c.SetMinFree(4096)
+	if st := c.DiskStatus(); st.Reserve != 4096 {
+		t.Errorf("reserve = %d, want 4096", st.Reserve)
+	}
+
+	// Without a reserve, or with an unknown free space, nothing is refused
+	c, _ = newDiskCache(t, 1000, 1000)
+	c.SetMinFree(-1)
+	if err := c.EnsureFree(10); err != nil || c.DiskStatus().Known {
+		t.Errorf("no reserve: EnsureFree = %v, status %+v", err, c.DiskStatus())
+	}
+	c.SetMinFree(0)
+	c.diskSpace = func(string) (uint64, uint64, error) { return 0, 0, errors.New("unsupported") }
+	if err := c.EnsureFree(10); err != nil {
+		t.Errorf("unknown free space: EnsureFree = %v", err)
+	}
+}
+
+func TestCache_PutResumableDiskFull(t *testing.T) {
+	c, _ := newDiskCache(t, 1000, 900)
+	fetched := false
+	_, err := c.PutResumable("big", "/big", "", 200, false, func(int64) (r io.ReadCloser, start int64, err error) {
+		fetched = true
+		return io.NopCloser(bytes.NewReader(make([]byte, 200))), 0, nil
+	})
+	if !errors.Is(err, ErrDiskFull) || fetched {
+		t.Errorf("PutResumable = %v, fetched %v", err, fetched)
+	}
+}
@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// The cache index lets New pick up the entries of earlier runs without
+// looking at the cache directory. index.json is a snapshot of the entries;
+// every change after it is appended to index.journal as one JSON record
+// per line, and the journal is folded into a new snapshot once it grows
+// past the snapshot. New replays both and checks a sample of the entries
+// against the files on disk. If there is no index, or the sample finds a
+// missing or resized file, the directory is scanned instead.
+//
+// Access times are only written with snapshots, so after a crash the LRU
+// order is that of the last snapshot.
+
+const (
+	indexFile   = "index.json"
+	journalFile = "index.journal"
+
+	// indexSampleSize is how many entries New checks against the disk.
+	indexSampleSize = 16
+
+	// minCompactRecords is the journal length below which it is never
+	// folded into the snapshot.
+	minCompactRecords = 1024
+)
+
+// indexSnapshot is the content of index.json.
+type indexSnapshot struct {
+	Entries []*models.CacheEntry `json:"entries"`
+}
+
+// journalRecord is one line of index.journal: an entry added or changed
+// (Entry) or removed (Removed).
+type journalRecord struct {
+	Entry   *models.CacheEntry `json:"entry,omitempty"`
+	Removed string             `json:"removed,omitempty"`
+}
+
+// loadIndex fills the entries from the index, or from a scan of the
+// directory if the index is missing or does not match the disk.
+func (c *Cache) loadIndex() {
+	entries, records, torn, err := readIndex(c.dir)
+	if err == nil && c.sampleMatches(entries) {
+		for id, entry := range entries {
+			c.entries[id] = entry
+			c.size += entry.Size
+		}
+		c.journalRecords = records
+		if torn {
+			// Records appended after the torn line would not be replayed
+			c.compactLocked()
+		}
+		return
+	}
+	c.rebuildLocked(entries)
+}
+
+// readIndex returns the entries recorded in dir's index and the number of
+// journal records replayed. A torn last journal line, left by a crash
+// during a write, ends the replay and is reported.
+func readIndex(dir string) (entries map[string]*models.CacheEntry, records int, torn bool, err error) {
+	entries = make(map[string]*models.CacheEntry)
+
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	switch {
+	case err == nil:
+		var snap indexSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, 0, false, fmt.Errorf("read cache index: %w", err)
+		}
+		for _, entry := range snap.Entries {
+			if entry != nil && entry.FileID != "" {
+				entries[entry.FileID] = entry
+			}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, 0, false, err
+	}
+
+	f, jerr := os.Open(filepath.Join(dir, journalFile))
+	if jerr != nil {
+		if errors.Is(jerr, fs.ErrNotExist) && err == nil {
+			jerr = nil
+		}
+		return entries, 0, false, jerr
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var rec journalRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			torn = true
+			break
+		}
+		switch {
+		case rec.Entry != nil && rec.Entry.FileID != "":
+			entries[rec.Entry.FileID] = rec.Entry
+		case rec.Removed != "":
+			delete(entries, rec.Removed)
+		}
+		records++
+	}
+	for _, entry := range entries {
+		if !entry.External {
+			entry.LocalPath = filepath.Join(dir, entry.FileID)
+		}
+	}
+	return entries, records, torn, nil
+}
+
+// sampleMatches checks up to indexSampleSize entries in the cache
+// directory against their files.
+func (c *Cache) sampleMatches(entries map[string]*models.CacheEntry) bool {
+	checked := 0
+	for _, entry := range entries { // map order is random enough
+		if entry.External {
+			continue
+		}
+		fi, err := os.Stat(entry.LocalPath)
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() != entry.Size {
+			return false
+		}
+		if checked++; checked == indexSampleSize {
+			break
+		}
+	}
+	return true
+}
+
+// RebuildIndex replaces the entries with the files found in the cache
+// directory and writes a fresh index. Paths, pins and access times are
+// kept for files whose size is unchanged. It returns the number of
+// entries.
+func (c *Cache) RebuildIndex() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	known := c.entries
+	for _, entry := range known {
+		c.size -= entry.Size
+	}
+	c.entries = make(map[string]*models.CacheEntry)
+	if err := c.rebuildLocked(known); err != nil {
+		return len(c.entries), err
+	}
+	return len(c.entries), nil
+}
+
+// rebuildLocked adds an entry for every cached file in the directory,
+// taking what the directory cannot tell from known, and writes the index.
+// Tracked entries outside the directory are kept while their file exists.
+// Must be called with the lock held and without entries.
+func (c *Cache) rebuildLocked(known map[string]*models.CacheEntry) error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("scan cache dir: %w", err)
+	}
+	for _, de := range dirEntries {
+		name := de.Name()
+		if !de.Type().IsRegular() || !isCachedFileName(name) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entry := &models.CacheEntry{
+			FileID:     name,
+			LocalPath:  filepath.Join(c.dir, name),
+			Size:       fi.Size(),
+			LastAccess: fi.ModTime(),
+		}
+		if k, ok := known[name]; ok && !k.External && k.Size == fi.Size() {
+			entry.Path = k.Path
+			entry.Pinned = k.Pinned
+			entry.LastAccess = k.LastAccess
+		}
+		c.entries[name] = entry
+		c.size += entry.Size
+	}
+	for id, k := range known {
+		if _, err := os.Stat(k.LocalPath); k.External && err == nil {
+			c.entries[id] = k
+			c.size += k.Size
+		}
+	}
+	return c.compactLocked()
+}
+
+// isCachedFileName reports whether a file in the cache directory holds a
+// cache entry's content, rather than the cache's own or another
+// component's bookkeeping.
+func isCachedFileName(name string) bool {
+	switch name {
+	case indexFile, journalFile, "pins.json", "pin-rules.json", "selective-sync.json":
+		return false
+	}
+	return !strings.HasSuffix(name, ".tmp") &&
+		!strings.HasSuffix(name, partialSuffix) &&
+		!strings.HasSuffix(name, sidecarSuffix) &&
+		!strings.HasPrefix(name, "fruitsalade-write-")
+}
+
+// SaveIndex writes a snapshot of the entries, including their access
+// times, and starts an empty journal.
+func (c *Cache) SaveIndex() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compactLocked()
+}
+
+// compactLocked replaces index.json with a snapshot of the entries and
+// removes the journal. The snapshot is written to a temp file and renamed
+// into place, so a crash leaves the old snapshot and journal, which
+// replay to the same entries. Must be called with the lock held.
+func (c *Cache) compactLocked() error {
+	snap := indexSnapshot{Entries: make([]*models.CacheEntry, 0, len(c.entries))}
+	for _, entry := range c.entries {
+		snap.Entries = append(snap.Entries, entry)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	indexPath := filepath.Join(c.dir, indexFile)
+	tmp, err := os.CreateTemp(c.dir, indexFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write cache index: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), indexPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write cache index: %w", err)
+	}
+
+	if c.journal != nil {
+		c.journal.Close()
+		c.journal = nil
+	}
+	c.journalRecords = 0
+	if err := os.Remove(filepath.Join(c.dir, journalFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reset cache journal: %w", err)
+	}
+	return nil
+}
+
+// recordLocked appends a change to the journal, folding the journal into
+// the snapshot when it has grown past it. If the change cannot be written
+// the index is removed, so that the next start scans the directory rather
+// than trusting a stale index. Must be called with the lock held.
+func (c *Cache) recordLocked(rec journalRecord) {
+	err := c.appendLocked(rec)
+	if err == nil && c.journalRecords > max(minCompactRecords, len(c.entries)) {
+		err = c.compactLocked()
+	}
+	if err != nil {
+		if c.journal != nil {
+			c.journal.Close()
+			c.journal = nil
+		}
+		os.Remove(filepath.Join(c.dir, indexFile))
+		os.Remove(filepath.Join(c.dir, journalFile))
+	}
+}
+
+func (c *Cache) appendLocked(rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if c.journal == nil {
+		f, err := os.OpenFile(filepath.Join(c.dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		c.journal = f
+	}
+	if _, err := c.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.journalRecords++
+	return nil
+}
+
+// recordPutLocked journals an added or changed entry.
+func (c *Cache) recordPutLocked(entry *models.CacheEntry) {
+	c.recordLocked(journalRecord{Entry: entry})
+}
+
+// recordRemoveLocked journals a removed entry.
+func (c *Cache) recordRemoveLocked(fileID string) {
+	c.recordLocked(journalRecord{Removed: fileID})
+}
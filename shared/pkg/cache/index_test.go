@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func putString(t testing.TB, c *Cache, id, path, content string) {
+	t.Helper()
+	if _, err := c.PutFile(id, path, bytes.NewReader([]byte(content)), int64(len(content))); err != nil {
+		t.Fatalf("PutFile %s: %v", id, err)
+	}
+}
+
+func TestCache_IndexReopen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	putString(t, c, "a", "/docs/a.txt", "aaaa")
+	putString(t, c, "b", "/docs/b.txt", "bb")
+	putString(t, c, "c", "/docs/c.txt", "c")
+	if err := c.Pin("a"); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	if err := c.Evict("b"); err != nil {
+		t.Fatalf("Evict: %v", err)
+	}
+	if err := c.Rename("c", "d", "/docs/d.txt"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	// The changes are in the journal, not yet in a snapshot
+	if _, err := os.Stat(filepath.Join(dir, journalFile)); err != nil {
+		t.Fatalf("journal not written: %v", err)
+	}
+
+	check := func(c *Cache) {
+		t.Helper()
+		size, _, count := c.Stats()
+		if count != 2 || size != 5 {
+			t.Fatalf("Stats = %d bytes, %d entries; want 5 bytes, 2 entries", size, count)
+		}
+		if !c.IsPinned("a") {
+			t.Error("a lost its pin")
+		}
+		if c.IsCached("b") || c.IsCached("c") {
+			t.Error("removed entries came back")
+		}
+		p, ok := c.Get("d")
+		if !ok || p != filepath.Join(dir, "d") {
+			t.Errorf("Get(d) = %q, %v", p, ok)
+		}
+		for _, e := range c.List() {
+			if e.FileID == "d" && e.Path != "/docs/d.txt" {
+				t.Errorf("d has path %q", e.Path)
+			}
+		}
+	}
+
+	reopened, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(reopened)
+
+	if err := reopened.SaveIndex(); err != nil {
+		t.Fatalf("SaveIndex: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalFile)); !os.IsNotExist(err) {
+		t.Errorf("journal still there after SaveIndex: %v", err)
+	}
+	fromSnapshot, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(fromSnapshot)
+}
+
+func TestCache_IndexScansWithoutIndex(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"cached":               "12345",
+		"cached.tmp":           "x",
+		"dl.partial":           "x",
+		"dl.partial.json":      "{}",
+		"pins.json":            "[]",
+		"fruitsalade-write-12": "x",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, _, count := c.Stats(); count != 1 || !c.IsCached("cached") {
+		t.Fatalf("scan found %d entries: %v", count, c.List())
+	}
+	if _, err := os.Stat(filepath.Join(dir, indexFile)); err != nil {
+		t.Errorf("scan did not write the index: %v", err)
+	}
+}
+
+func TestCache_IndexRescansOnMismatch(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	putString(t, c, "a", "/a", "aaa")
+	putString(t, c, "b", "/b", "bbb")
+	if err := c.Pin("b"); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+
+	// Changes made behind the cache's back
+	os.Remove(filepath.Join(dir, "a"))
+	os.WriteFile(filepath.Join(dir, "stray"), []byte("ss"), 0644)
+
+	reopened, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if reopened.IsCached("a") {
+		t.Error("missing file still indexed")
+	}
+	if !reopened.IsCached("stray") {
+		t.Error("rescan did not pick up new file")
+	}
+	if !reopened.IsPinned("b") {
+		t.Error("rescan lost the pin of an unchanged file")
+	}
+	if size, _, _ := reopened.Stats(); size != 5 {
+		t.Errorf("size = %d, want 5", size)
+	}
+}
+
+func TestCache_IndexTornJournal(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	putString(t, c, "a", "/a", "aaa")
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"entry":{"file_id":"b","si`)
+	f.Close()
+
+	reopened, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if !reopened.IsCached("a") || reopened.IsCached("b") {
+		t.Errorf("entries after torn journal: %v", reopened.List())
+	}
+
+	// Changes after the torn line must survive the next start
+	putString(t, reopened, "c", "/c", "c")
+	again, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if !again.IsCached("a") || !again.IsCached("c") {
+		t.Errorf("entries after second reopen: %v", again.List())
+	}
+}
+
+func TestCache_RebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	putString(t, c, "a", "/a", "aaa")
+	c.Pin("a")
+	os.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0644)
+
+	n, err := c.RebuildIndex()
+	if err != nil {
+		t.Fatalf("RebuildIndex: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("RebuildIndex = %d, want 2", n)
+	}
+	if !c.IsPinned("a") {
+		t.Error("rebuild lost the pin")
+	}
+	if size, _, _ := c.Stats(); size != 4 {
+		t.Errorf("size = %d, want 4", size)
+	}
+}
+
+func TestCache_IndexCompacts(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 1<<30)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	putString(t, c, "a", "/a", "a")
+	for i := 0; i <= minCompactRecords; i++ {
+		c.Pin("a")
+	}
+	if c.journalRecords >= minCompactRecords {
+		t.Errorf("journal has %d records after compaction threshold", c.journalRecords)
+	}
+	if _, err := os.Stat(filepath.Join(dir, indexFile)); err != nil {
+		t.Errorf("no snapshot after compaction: %v", err)
+	}
+}
+
+// BenchmarkNew_Index opens a cache of 20000 files from its index.
+func BenchmarkNew_Index(b *testing.B) {
+	dir := b.TempDir()
+	c, err := New(dir, 1<<40)
+	if err != nil {
+		b.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 20000; i++ {
+		putString(b, c, fmt.Sprintf("file-%05d", i), fmt.Sprintf("/data/%05d", i), "content")
+	}
+	if err := c.SaveIndex(); err != nil {
+		b.Fatalf("SaveIndex: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := New(dir, 1<<40); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNew_Scan opens the same cache without an index.
+func BenchmarkNew_Scan(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < 20000; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%05d", i)), []byte("content"), 0644)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		os.Remove(filepath.Join(dir, indexFile))
+		if _, err := New(dir, 1<<40); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import "time"
+
+// Lease keeps a file's cache entry from being evicted to make room while
+// its content is in use, such as by an open file. The lease covers the
+// file ID, so it also holds content that replaces the entry, and follows
+// the entry through Rename. Explicit removals (Evict) still apply.
+type Lease struct {
+	c    *Cache
+	ref  *leaseRef
+	path string
+	done bool
+}
+
+// leaseRef counts the leases of a file ID.
+type leaseRef struct {
+	fileID string
+	n      int
+}
+
+// Acquire is Get for content that is about to be read: the entry is not
+// evicted to make room until the lease is released.
+func (c *Cache) Acquire(fileID string) (*Lease, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[fileID]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+
+	ref, ok := c.leases[fileID]
+	if !ok {
+		ref = &leaseRef{fileID: fileID}
+		c.leases[fileID] = ref
+	}
+	ref.n++
+	return &Lease{c: c, ref: ref, path: entry.LocalPath}, true
+}
+
+// Path returns the local path of the content at the time of Acquire.
+func (l *Lease) Path() string {
+	return l.path
+}
+
+// Release ends the lease. Releasing twice has no effect.
+func (l *Lease) Release() {
+	c := l.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l.done {
+		return
+	}
+	l.done = true
+	if entry, ok := c.entries[l.ref.fileID]; ok {
+		entry.LastAccess = time.Now()
+	}
+	if l.ref.n--; l.ref.n == 0 && c.leases[l.ref.fileID] == l.ref {
+		delete(c.leases, l.ref.fileID)
+	}
+}
+
+// Leased returns the number of file IDs with an active lease.
+func (c *Cache) Leased() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.leases)
+}
+
+// isLeasedLocked must be called with the lock held.
+func (c *Cache) isLeasedLocked(fileID string) bool {
+	return c.leases[fileID] != nil
+}
+
+// moveLeaseLocked moves the leases of oldID to newID. Leases of the entry
+// that newID replaces no longer hold anything. Must be called with the
+// lock held.
+func (c *Cache) moveLeaseLocked(oldID, newID string) {
+	ref, ok := c.leases[oldID]
+	if !ok {
+		return
+	}
+	delete(c.leases, oldID)
+	ref.fileID = newID
+	c.leases[newID] = ref
+}
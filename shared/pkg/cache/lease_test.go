@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache_LeaseBlocksEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 100)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	c.Put("open", bytes.NewReader(make([]byte, 60)), 60)
+
+	lease, ok := c.Acquire("open")
+	if !ok {
+		t.Fatal("Acquire missed a cached file")
+	}
+	if _, ok := c.Acquire("missing"); ok {
+		t.Error("Acquire hit a missing file")
+	}
+
+	// New content for the file is held by the same lease
+	c.Put("open", bytes.NewReader(make([]byte, 60)), 60)
+	c.Put("big", bytes.NewReader(make([]byte, 60)), 60)
+	if !c.IsCached("open") {
+		t.Fatal("leased entry was evicted")
+	}
+	if _, err := os.Stat(lease.Path()); err != nil {
+		t.Fatalf("leased content gone: %v", err)
+	}
+	if n := c.Clear(); n != 1 || !c.IsCached("open") {
+		t.Errorf("Clear removed %d entries, leased one cached: %v", n, c.IsCached("open"))
+	}
+
+	lease.Release()
+	lease.Release()
+	if c.Leased() != 0 {
+		t.Errorf("Leased = %d after release", c.Leased())
+	}
+	c.Put("next", bytes.NewReader(make([]byte, 60)), 60)
+	if c.IsCached("open") {
+		t.Error("released entry was not evicted")
+	}
+}
+
+func TestCache_SizeAgePolicy(t *testing.T) {
+	setup := func(policy string) *Cache {
+		c, err := New(t.TempDir(), 1000)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if err := c.SetPolicy(policy); err != nil {
+			t.Fatalf("SetPolicy: %v", err)
+		}
+		c.Put("small1", bytes.NewReader(make([]byte, 10)), 10)
+		c.Put("small2", bytes.NewReader(make([]byte, 10)), 10)
+		c.Put("giant", bytes.NewReader(make([]byte, 900)), 900)
+		// The small files were used longer ago, but not 90 times longer
+		c.mu.Lock()
+		c.entries["small1"].LastAccess = time.Now().Add(-10 * time.Millisecond)
+		c.entries["small2"].LastAccess = time.Now().Add(-10 * time.Millisecond)
+		c.entries["giant"].LastAccess = time.Now().Add(-time.Millisecond)
+		c.mu.Unlock()
+
+		c.Put("new", bytes.NewReader(make([]byte, 100)), 100)
+		return c
+	}
+
+	c := setup(PolicySizeAge)
+	if c.IsCached("giant") || !c.IsCached("small1") || !c.IsCached("small2") {
+		t.Errorf("size-age: giant cached %v, small cached %v %v",
+			c.IsCached("giant"), c.IsCached("small1"), c.IsCached("small2"))
+	}
+
+	c = setup(PolicyLRU)
+	if !c.IsCached("giant") || c.IsCached("small1") {
+		t.Errorf("lru: giant cached %v, small1 cached %v", c.IsCached("giant"), c.IsCached("small1"))
+	}
+
+	if err := c.SetPolicy("fifo"); err == nil {
+		t.Error("SetPolicy accepted an unknown policy")
+	}
+}
+
+func TestCache_EvictionUnderConcurrentReaders(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 4<<10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 8; i++ {
+		c.Put(fmt.Sprintf("file%d", i), bytes.NewReader(make([]byte, 512)), 512)
+	}
+
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.Put(fmt.Sprintf("file%d", i%32), bytes.NewReader(make([]byte, 1<<10)), 1<<10)
+		}
+	}()
+
+	var readers sync.WaitGroup
+	errs := make(chan error, 8)
+	for r := 0; r < 8; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for i := 0; i < 200; i++ {
+				lease, ok := c.Acquire(fmt.Sprintf("file%d", (r+i)%32))
+				if !ok {
+					continue
+				}
+				// Reads while the writer evicts must find the content
+				for j := 0; j < 3; j++ {
+					if _, err := os.ReadFile(lease.Path()); err != nil {
+						errs <- err
+						lease.Release()
+						return
+					}
+				}
+				lease.Release()
+			}
+		}(r)
+	}
+	readers.Wait()
+	close(stop)
+	writers.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("leased read failed: %v", err)
+	}
+	if c.Leased() != 0 {
+		t.Errorf("Leased = %d after all releases", c.Leased())
+	}
+}
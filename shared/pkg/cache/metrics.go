@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+)
+
+// Collector exports cache statistics as Prometheus metrics. Values are read
+// from the cache on every scrape.
+type Collector struct {
+	cache *Cache
+
+	bytes     *prometheus.Desc
+	maxBytes  *prometheus.Desc
+	files     *prometheus.Desc
+	pinned    *prometheus.Desc
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	hitRatio  *prometheus.Desc
+	evictions *prometheus.Desc
+
+	diskFree           *prometheus.Desc
+	diskFull           *prometheus.Desc
+	emergencyEvictions *prometheus.Desc
+}
+
+// NewCollector creates a collector for c.
+func NewCollector(c *Cache) *Collector {
+	return &Collector{
+		cache: c,
+		bytes: prometheus.NewDesc("fruitsalade_client_cache_bytes",
+			"Bytes currently stored in the local cache", nil, nil),
+		maxBytes: prometheus.NewDesc("fruitsalade_client_cache_max_bytes",
+			"Configured maximum cache size in bytes", nil, nil),
+		files: prometheus.NewDesc("fruitsalade_client_cache_files",
+			"Number of files in the local cache", nil, nil),
+		pinned: prometheus.NewDesc("fruitsalade_client_cache_pinned_files",
+			"Number of pinned files in the local cache", nil, nil),
+		hits: prometheus.NewDesc("fruitsalade_client_cache_hits_total",
+			"Cache lookups that found the file", nil, nil),
+		misses: prometheus.NewDesc("fruitsalade_client_cache_misses_total",
+			"Cache lookups that did not find the file", nil, nil),
+		hitRatio: prometheus.NewDesc("fruitsalade_client_cache_hit_ratio",
+			"Fraction of cache lookups that were hits", nil, nil),
+		evictions: prometheus.NewDesc("fruitsalade_client_cache_evictions_total",
+			"Files evicted to make room for new content", nil, nil),
+		diskFree: prometheus.NewDesc("fruitsalade_client_cache_disk_free_bytes",
+			"Free bytes on the cache's volume", nil, nil),
+		diskFull: prometheus.NewDesc("fruitsalade_client_cache_disk_full",
+			"Whether content is refused to keep the free space reserve (1) or not (0)", nil, nil),
+		emergencyEvictions: prometheus.NewDesc("fruitsalade_client_cache_emergency_evictions_total",
+			"Files evicted to keep the free space reserve on the cache's volume", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bytes
+	ch <- c.maxBytes
+	ch <- c.files
+	ch <- c.pinned
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.hitRatio
+	ch <- c.evictions
+	ch <- c.diskFree
+	ch <- c.diskFull
+	ch <- c.emergencyEvictions
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	size, maxSize, count := c.cache.Stats()
+	hits := float64(c.cache.hits.Load())
+	misses := float64(c.cache.misses.Load())
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = hits / (hits + misses)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(size))
+	ch <- prometheus.MustNewConstMetric(c.maxBytes, prometheus.GaugeValue, float64(maxSize))
+	ch <- prometheus.MustNewConstMetric(c.files, prometheus.GaugeValue, float64(count))
+	ch <- prometheus.MustNewConstMetric(c.pinned, prometheus.GaugeValue, float64(len(c.cache.Pinned())))
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, hits)
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, misses)
+	ch <- prometheus.MustNewConstMetric(c.hitRatio, prometheus.GaugeValue, ratio)
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(c.cache.evictions.Load()))
+
+	disk := c.cache.DiskStatus()
+	if disk.Known {
+		ch <- prometheus.MustNewConstMetric(c.diskFree, prometheus.GaugeValue, float64(disk.Free))
+	}
+	full := 0.0
+	if disk.Full {
+		full = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.diskFull, prometheus.GaugeValue, full)
+	ch <- prometheus.MustNewConstMetric(c.emergencyEvictions, prometheus.CounterValue, float64(c.cache.EmergencyEvictions()))
+}
+
+// ServeMetrics exposes the given collectors on addr at /metrics in the
+// background. The clients call it with their cache or filesystem
+// collector when metrics are enabled.
+func ServeMetrics(addr string, collectors ...prometheus.Collector) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("Metrics server failed: %v", err)
+		}
+	}()
+	logger.Info("Metrics available at http://%s/metrics", addr)
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func collectValues(t *testing.T, c prometheus.Collector) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 32)
+	c.Collect(ch)
+	close(ch)
+
+	values := make(map[string]float64)
+	for m := range ch {
+		var out dto.Metric
+		if err := m.Write(&out); err != nil {
+			t.Fatalf("write metric: %v", err)
+		}
+		desc := m.Desc().String()
+		name := desc[strings.Index(desc, `fqName: "`)+9:]
+		name = name[:strings.Index(name, `"`)]
+		switch {
+		case out.Gauge != nil:
+			values[name] = out.Gauge.GetValue()
+		case out.Counter != nil:
+			values[name] = out.Counter.GetValue()
+		}
+	}
+	return values
+}
+
+func TestCollector(t *testing.T) {
+	c, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	c.Put("a", bytes.NewReader([]byte("12345")), 5)
+	c.Put("b", bytes.NewReader([]byte("12345")), 5)
+	c.Pin("b")
+	c.Put("c", bytes.NewReader([]byte("123")), 3) // evicts a
+
+	c.Get("b")
+	c.Get("c")
+	c.Get("a")
+
+	got := collectValues(t, NewCollector(c))
+	want := map[string]float64{
+		"fruitsalade_client_cache_bytes":           8,
+		"fruitsalade_client_cache_max_bytes":       10,
+		"fruitsalade_client_cache_files":           2,
+		"fruitsalade_client_cache_pinned_files":    1,
+		"fruitsalade_client_cache_hits_total":      2,
+		"fruitsalade_client_cache_misses_total":    1,
+		"fruitsalade_client_cache_evictions_total": 1,
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+	if r := got["fruitsalade_client_cache_hit_ratio"]; r < 0.66 || r > 0.67 {
+		t.Errorf("hit ratio = %v, want 2/3", r)
+	}
+
+	// The collector must register cleanly
+	if err := prometheus.NewRegistry().Register(NewCollector(c)); err != nil {
+		t.Errorf("register: %v", err)
+	}
+}
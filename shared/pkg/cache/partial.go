@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// Partial downloads are kept as <fileID>.partial next to a
+// <fileID>.partial.json sidecar that records how many bytes of which
+// content have been written. An interrupted download resumes from there
+// instead of starting over, and only becomes a cache entry once the whole
+// file has been received and verified.
+
+const (
+	partialSuffix = ".partial"
+	sidecarSuffix = ".partial.json"
+
+	// checkpointInterval is how often a running download syncs the partial
+	// file and records its progress in the sidecar.
+	checkpointInterval = 8 << 20
+)
+
+// ErrVerifyFailed is returned by PutResumable when the downloaded content
+// does not match the expected hash or size. The partial file is discarded.
+var ErrVerifyFailed = errors.New("downloaded content failed verification")
+
+// Fetcher opens a file's content from offset to the end. It returns the
+// offset the content actually starts at, which may be 0 if the server
+// ignored the range.
+type Fetcher func(offset int64) (r io.ReadCloser, start int64, err error)
+
+// partialInfo is the sidecar of a partial download.
+type partialInfo struct {
+	Path     string    `json:"path,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	Size     int64     `json:"size"`
+	Received int64     `json:"received"`
+	Updated  time.Time `json:"updated"`
+}
+
+// partial tracks a partial download. Its size counts against the cache.
+type partial struct {
+	mu     sync.Mutex // held while downloading
+	active bool
+	size   int64
+	mtime  time.Time
+}
+
+// loadPartials registers partial downloads left by an earlier process.
+func (c *Cache) loadPartials() {
+	matches, _ := filepath.Glob(filepath.Join(c.dir, "*"+partialSuffix))
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		fileID := strings.TrimSuffix(filepath.Base(m), partialSuffix)
+		c.partials[fileID] = &partial{size: fi.Size(), mtime: fi.ModTime()}
+		c.size += fi.Size()
+	}
+}
+
+// PutResumable downloads a file into the cache through fetch. A partial
+// download left for the same content (same sum and size) is continued
+// from where it stopped; one for other content is discarded. With verify
+// and a non-empty sum the complete file must have that sha256, otherwise its
+// size must equal size. If the download fails the partial file is kept
+// for the next attempt. ErrDiskFull is returned, before anything is
+// downloaded, if the rest of the file does not fit on the cache's volume.
+func (c *Cache) PutResumable(fileID, path, sum string, size int64, verify bool, fetch Fetcher) (string, error) {
+	c.mu.Lock()
+	p, ok := c.partials[fileID]
+	if !ok {
+		p = &partial{}
+		c.partials[fileID] = p
+	}
+	c.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c.mu.Lock()
+	if c.partials[fileID] != p {
+		// Evicted while we waited
+		c.partials[fileID] = p
+	}
+	p.active = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		p.active = false
+		c.mu.Unlock()
+	}()
+
+	localPath := filepath.Join(c.dir, fileID)
+	partialPath := localPath + partialSuffix
+	info := &partialInfo{Path: path, Hash: sum, Size: size}
+
+	offset := c.resumeOffset(partialPath, info)
+
+	c.mu.Lock()
+	c.size += offset - p.size
+	p.size = offset
+	for c.size+size-offset > c.maxSize {
+		if !c.evictOne() {
+			break
+		}
+	}
+	err := c.ensureFreeLocked(size - offset)
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", fmt.Errorf("open partial file: %w", err)
+	}
+
+	var hasher hash.Hash
+	if verify && sum != "" {
+		hasher = sha256.New()
+	}
+	received, err := c.download(f, p, info, offset, hasher, fetch)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if received == 0 {
+			c.discardPartial(fileID, p)
+		} else {
+			c.checkpoint(p, partialPath, info, received)
+		}
+		return "", err
+	}
+
+	if received != size {
+		c.discardPartial(fileID, p)
+		return "", fmt.Errorf("%w: got %d bytes, expected %d", ErrVerifyFailed, received, size)
+	}
+	if hasher != nil {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != sum {
+			c.discardPartial(fileID, p)
+			return "", fmt.Errorf("%w: expected hash %s, got %s", ErrVerifyFailed, sum, actual)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return "", fmt.Errorf("promote partial file: %w", err)
+	}
+	os.Remove(localPath + sidecarSuffix)
+	c.dropPartialLocked(fileID, p)
+
+	if old, ok := c.entries[fileID]; ok {
+		c.size -= old.Size
+	}
+	entry := &models.CacheEntry{
+		FileID:     fileID,
+		Path:       path,
+		LocalPath:  localPath,
+		Size:       size,
+		LastAccess: time.Now(),
+	}
+	c.entries[fileID] = entry
+	c.size += size
+	c.recordPutLocked(entry)
+	return localPath, nil
+}
+
+// resumeOffset returns how many bytes of an existing partial file can be
+// kept for info's content, truncating the file to that length. It returns
+// 0 if there is nothing usable.
+func (c *Cache) resumeOffset(partialPath string, info *partialInfo) int64 {
+	data, err := os.ReadFile(strings.TrimSuffix(partialPath, partialSuffix) + sidecarSuffix)
+	if err != nil {
+		return 0
+	}
+	var prev partialInfo
+	if json.Unmarshal(data, &prev) != nil || prev.Hash != info.Hash || prev.Size != info.Size {
+		return 0
+	}
+	fi, err := os.Stat(partialPath)
+	// Bytes past the last checkpoint may not have reached the disk
+	if err != nil || fi.Size() < prev.Received || prev.Received > info.Size {
+		return 0
+	}
+	if os.Truncate(partialPath, prev.Received) != nil {
+		return 0
+	}
+	return prev.Received
+}
+
+// download writes the content from offset on into f, checkpointing as it
+// goes, and returns the number of bytes of the file now in f. hasher, if
+// set, is fed the whole file including the bytes already there.
+func (c *Cache) download(f *os.File, p *partial, info *partialInfo, offset int64, hasher hash.Hash, fetch Fetcher) (int64, error) {
+	if err := f.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("truncate partial file: %w", err)
+	}
+
+	var r io.ReadCloser
+	if offset < info.Size || info.Size == 0 {
+		var start int64
+		var err error
+		r, start, err = fetch(offset)
+		if err != nil {
+			return offset, err
+		}
+		defer r.Close()
+		if start != offset {
+			// The server sent the file from the beginning
+			offset = 0
+			if err := f.Truncate(0); err != nil {
+				return 0, fmt.Errorf("truncate partial file: %w", err)
+			}
+		}
+	}
+
+	if hasher != nil && offset > 0 {
+		if _, err := io.Copy(hasher, io.NewSectionReader(f, 0, offset)); err != nil {
+			return 0, fmt.Errorf("hash partial file: %w", err)
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek partial file: %w", err)
+	}
+	if r == nil {
+		return offset, nil
+	}
+
+	var w io.Writer = f
+	if hasher != nil {
+		w = io.MultiWriter(f, hasher)
+	}
+	received := offset
+	next := offset + checkpointInterval
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return received, fmt.Errorf("write content: %w", err)
+			}
+			received += int64(n)
+			if received >= next {
+				c.checkpoint(p, f.Name(), info, received)
+				next = received + checkpointInterval
+			}
+		}
+		if rerr == io.EOF {
+			return received, nil
+		}
+		if rerr != nil {
+			return received, fmt.Errorf("read content: %w", rerr)
+		}
+	}
+}
+
+// checkpoint syncs a partial file and records received in its sidecar.
+func (c *Cache) checkpoint(p *partial, partialPath string, info *partialInfo, received int64) {
+	if f, err := os.OpenFile(partialPath, os.O_WRONLY, 0); err == nil {
+		f.Sync()
+		f.Close()
+	}
+	info.Received = received
+	info.Updated = time.Now()
+	if data, err := json.Marshal(info); err == nil {
+		os.WriteFile(strings.TrimSuffix(partialPath, partialSuffix)+sidecarSuffix, data, 0644)
+	}
+
+	c.mu.Lock()
+	c.size += received - p.size
+	p.size = received
+	p.mtime = info.Updated
+	c.mu.Unlock()
+}
+
+// discardPartial removes a partial download that cannot be used.
+func (c *Cache) discardPartial(fileID string, p *partial) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removePartialLocked(fileID, p)
+}
+
+// removePartialLocked deletes a partial download's files. Must be called
+// with lock held.
+func (c *Cache) removePartialLocked(fileID string, p *partial) {
+	base := filepath.Join(c.dir, fileID)
+	os.Remove(base + partialSuffix)
+	os.Remove(base + sidecarSuffix)
+	c.dropPartialLocked(fileID, p)
+}
+
+// dropPartialLocked forgets a partial download. Must be called with lock
+// held.
+func (c *Cache) dropPartialLocked(fileID string, p *partial) {
+	c.size -= p.size
+	p.size = 0
+	if c.partials[fileID] == p {
+		delete(c.partials, fileID)
+	}
+}
+
+// PartialBytes returns the bytes held by partial downloads.
+func (c *Cache) PartialBytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var n int64
+	for _, p := range c.partials {
+		n += p.size
+	}
+	return n
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// brokenReader returns its data and then fails, like a dropped connection.
+type brokenReader struct {
+	r io.Reader
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func TestCache_PutResumable(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(dir, 1<<20)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	var offsets []int64
+	cut := int64(300)
+	fetch := func(offset int64) (io.ReadCloser, int64, error) {
+		offsets = append(offsets, offset)
+		r := io.Reader(bytes.NewReader(content[offset:]))
+		if cut > 0 {
+			r = &brokenReader{r: io.LimitReader(r, cut-offset)}
+		}
+		return io.NopCloser(r), offset, nil
+	}
+
+	if _, err := c.PutResumable("f", "/f", sha256Hex(content), int64(len(content)), true, fetch); err == nil {
+		t.Fatal("interrupted download succeeded")
+	}
+	if c.IsCached("f") {
+		t.Fatal("partial download became a cache entry")
+	}
+	if size, _, count := c.Stats(); size != 300 || count != 0 {
+		t.Errorf("Stats = %d, %d; want the 300 partial bytes and no entries", size, count)
+	}
+
+	// A new cache over the same directory picks the partial up and resumes it
+	c, _ = New(dir, 1<<20)
+	if n := c.PartialBytes(); n != 300 {
+		t.Errorf("PartialBytes after reload = %d, want 300", n)
+	}
+	cut = 0
+	path, err := c.PutResumable("f", "/f", sha256Hex(content), int64(len(content)), true, fetch)
+	if err != nil {
+		t.Fatalf("resumed download: %v", err)
+	}
+	if len(offsets) != 2 || offsets[1] != 300 {
+		t.Errorf("fetch offsets = %v, want [0 300]", offsets)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+		t.Error("resumed content differs")
+	}
+	if size, _, count := c.Stats(); size != int64(len(content)) || count != 1 {
+		t.Errorf("Stats = %d, %d; want %d and 1", size, count, len(content))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f.partial.json")); !os.IsNotExist(err) {
+		t.Error("sidecar left behind after promotion")
+	}
+}
+
+func TestCache_PutResumableRestarts(t *testing.T) {
+	c, _ := New(t.TempDir(), 1<<20)
+	content := []byte("the whole file")
+
+	// The server ignores the range and sends everything
+	c.PutResumable("f", "/f", sha256Hex(content), int64(len(content)), true, func(offset int64) (io.ReadCloser, int64, error) {
+		return io.NopCloser(&brokenReader{r: bytes.NewReader(content[:5])}), 0, nil
+	})
+	path, err := c.PutResumable("f", "/f", sha256Hex(content), int64(len(content)), true, func(offset int64) (io.ReadCloser, int64, error) {
+		if offset != 5 {
+			t.Errorf("resume offset = %d, want 5", offset)
+		}
+		return io.NopCloser(bytes.NewReader(content)), 0, nil
+	})
+	if err != nil {
+		t.Fatalf("PutResumable: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+		t.Errorf("content = %q, want %q", data, content)
+	}
+
+	// A partial for different content is not resumed
+	c.Evict("f")
+	other := []byte("new content!!")
+	c.PutResumable("g", "/g", sha256Hex(content), int64(len(content)), true, func(offset int64) (io.ReadCloser, int64, error) {
+		return io.NopCloser(&brokenReader{r: bytes.NewReader(content[:5])}), 0, nil
+	})
+	c.PutResumable("g", "/g", sha256Hex(other), int64(len(other)), true, func(offset int64) (io.ReadCloser, int64, error) {
+		if offset != 0 {
+			t.Errorf("offset for changed content = %d, want 0", offset)
+		}
+		return io.NopCloser(bytes.NewReader(other)), 0, nil
+	})
+}
+
+func TestCache_PutResumableVerify(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(dir, 1<<20)
+	content := []byte("expected")
+	corrupt := func(offset int64) (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader([]byte("tampered"))), 0, nil
+	}
+
+	if _, err := c.PutResumable("f", "/f", sha256Hex(content), 8, true, corrupt); !errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("hash mismatch error = %v, want ErrVerifyFailed", err)
+	}
+	if c.IsCached("f") || c.PartialBytes() != 0 {
+		t.Error("mismatched content kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f.partial")); !os.IsNotExist(err) {
+		t.Error("partial file kept after mismatch")
+	}
+
+	// Without hash verification only the size is checked
+	if _, err := c.PutResumable("f", "/f", sha256Hex(content), 8, false, corrupt); err != nil {
+		t.Errorf("unverified download: %v", err)
+	}
+	if _, err := c.PutResumable("g", "/g", "", 100, false, corrupt); !errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("size mismatch error = %v, want ErrVerifyFailed", err)
+	}
+}
+
+func TestCache_EvictPartials(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(dir, 20)
+	interrupted := func(offset int64) (io.ReadCloser, int64, error) {
+		return io.NopCloser(&brokenReader{r: bytes.NewReader([]byte("0123456789"))}), 0, nil
+	}
+
+	c.PutResumable("a", "/a", "", 100, false, interrupted)
+	if size, _, _ := c.Stats(); size != 10 {
+		t.Fatalf("size = %d, want 10", size)
+	}
+
+	// Making room removes the orphaned partial download
+	c.Put("b", bytes.NewReader(bytes.Repeat([]byte("x"), 15)), 15)
+	if n := c.PartialBytes(); n != 0 {
+		t.Errorf("PartialBytes = %d after eviction, want 0", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.partial")); !os.IsNotExist(err) {
+		t.Error("orphaned partial file not removed")
+	}
+	if size, _, _ := c.Stats(); size != 15 {
+		t.Errorf("size = %d, want 15", size)
+	}
+
+	// Evicting a file also drops its partial download
+	c.PutResumable("c", "/c", "", 100, false, interrupted)
+	c.Evict("c")
+	if _, err := os.Stat(filepath.Join(dir, "c.partial")); !os.IsNotExist(err) {
+		t.Error("partial file kept after Evict")
+	}
+}
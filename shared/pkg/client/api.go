@@ -0,0 +1,582 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+)
+
+// APIError is an error response of the v1 API. StatusCode tells a missing
+// path (404) from a denied request (403) or a conflict (409); Code says
+// more, and errors.Is matches it against the sentinel errors below.
+type APIError struct {
+	StatusCode int
+	Code       protocol.ErrorCode // "" from servers that predate error codes
+	Message    string
+	Details    string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.StatusCode)
+}
+
+// Is reports whether target is the sentinel error of e's code. Without a
+// code the generic one of its status is used.
+func (e *APIError) Is(target error) bool {
+	code := e.Code
+	if code == "" {
+		code = protocol.ErrorCodeForStatus(e.StatusCode)
+	}
+	sentinel, ok := codeErrors[code]
+	return ok && sentinel == target
+}
+
+// Sentinel errors for the API error codes a caller may want to act on,
+// e.g. errors.Is(err, ErrQuotaExceeded).
+var (
+	ErrNotFound               = errors.New("not found")
+	ErrAccessDenied           = errors.New("access denied")
+	ErrConflict               = errors.New("conflict")
+	ErrVersionConflict        = errors.New("version conflict")
+	ErrInvalidPath            = errors.New("invalid path")
+	ErrFileTooLarge           = errors.New("file too large")
+	ErrQuotaExceeded          = errors.New("storage quota exceeded")
+	ErrBandwidthExceeded      = errors.New("bandwidth quota exceeded")
+	ErrRateLimited            = errors.New("rate limited")
+	ErrReadOnly               = errors.New("storage location is read-only")
+	ErrStorageUnavailable     = errors.New("storage unavailable")
+	ErrPasswordChangeRequired = errors.New("password change required")
+	ErrMaintenance            = errors.New("server is in read-only maintenance mode")
+)
+
+var codeErrors = map[protocol.ErrorCode]error{
+	protocol.ErrCodeNotFound:               ErrNotFound,
+	protocol.ErrCodeAccessDenied:           ErrAccessDenied,
+	protocol.ErrCodeConflict:               ErrConflict,
+	protocol.ErrCodeVersionConflict:        ErrVersionConflict,
+	protocol.ErrCodeInvalidPath:            ErrInvalidPath,
+	protocol.ErrCodeFileTooLarge:           ErrFileTooLarge,
+	protocol.ErrCodeQuotaExceeded:          ErrQuotaExceeded,
+	protocol.ErrCodeBandwidthExceeded:      ErrBandwidthExceeded,
+	protocol.ErrCodeRateLimited:            ErrRateLimited,
+	protocol.ErrCodeReadOnly:               ErrReadOnly,
+	protocol.ErrCodeStorageUnavailable:     ErrStorageUnavailable,
+	protocol.ErrCodePasswordChangeRequired: ErrPasswordChangeRequired,
+	protocol.ErrCodeMaintenance:            ErrMaintenance,
+}
+
+// transient reports whether the server failed in a way that sending the
+// request again may fix: a 5xx other than a write refused in read-only
+// maintenance mode, which lasts until an admin ends it.
+func (e *APIError) transient() bool {
+	return e.StatusCode >= 500 && e.Code != protocol.ErrCodeMaintenance
+}
+
+// AsAPIError checks if an error is an APIError and returns it.
+func AsAPIError(err error) (*APIError, bool) {
+	var ae *APIError
+	if errors.As(err, &ae) {
+		return ae, true
+	}
+	return nil, false
+}
+
+// IsNotFound reports whether err is a 404 Not Found from the server.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsForbidden reports whether err is a 403 Forbidden from the server.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsUnauthorized reports whether the server refused the credentials: a
+// 401 Unauthorized, or a token the refresh loop found rejected.
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, ErrAuthRejected) || hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsConflict reports whether err is a 409 Conflict from the server,
+// including an upload's ConflictError.
+func IsConflict(err error) bool {
+	if _, ok := AsConflict(err); ok {
+		return true
+	}
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsPermanent reports whether err is a refusal from the server that
+// sending the request again cannot change: a 4xx other than 408 Request
+// Timeout and 429 Too Many Requests.
+func IsPermanent(err error) bool {
+	ae, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	switch ae.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return ae.StatusCode >= 400 && ae.StatusCode < 500
+}
+
+func hasStatus(err error, code int) bool {
+	ae, ok := AsAPIError(err)
+	return ok && ae.StatusCode == code
+}
+
+// readAPIError builds the APIError of a failed response from its
+// ErrorResponse body, if it has one.
+func readAPIError(resp *http.Response) *APIError {
+	ae := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	var er protocol.ErrorResponse
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&er) == nil {
+		ae.Code = er.ErrorCode
+		ae.Message = er.Error
+		ae.Details = er.Details
+		if er.RequestID != "" {
+			ae.RequestID = er.RequestID
+		}
+	}
+	return ae
+}
+
+// retryStatus reports whether a request that got code may succeed if sent
+// again.
+func retryStatus(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// escapePath turns a file path into the {path...} part of an API URL.
+func escapePath(p string) string {
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// call sends a request to the API and returns the successful response,
+// whose body the caller must close. body, if not nil, is sent as JSON.
+// Connection errors and transient 5xx responses are retried, except for
+// POSTs: those are sent once so that an operation is never applied twice.
+func (c *Client) call(ctx context.Context, method, endpoint string, query url.Values, body interface{}) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	u := c.baseURL + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	cfg := c.retryConfig
+	if method == http.MethodPost {
+		cfg.MaxAttempts = 1
+	}
+
+	var result *http.Response
+	err := retry.Do(ctx, cfg, func() error {
+		var rd io.Reader
+		if payload != nil {
+			rd = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, rd)
+		if err != nil {
+			return err
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		c.applyAuth(req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			c.setOnline(false)
+			return retry.Retryable(err)
+		}
+		c.setOnline(true)
+
+		if resp.StatusCode >= 300 {
+			ae := readAPIError(resp)
+			resp.Body.Close()
+			if retryStatus(resp.StatusCode) && ae.transient() {
+				return retry.Retryable(ae)
+			}
+			return ae
+		}
+		result = resp
+		return nil
+	})
+	return result, err
+}
+
+// do sends a request with call and decodes the JSON response into out,
+// unless out is nil. op names the operation in the debug log.
+func (c *Client) do(ctx context.Context, op, method, endpoint string, query url.Values, body, out interface{}) error {
+	ctx, done := c.begin(ctx, op, endpoint)
+	resp, err := c.call(ctx, method, endpoint, query, body)
+	if err == nil {
+		if out != nil {
+			err = json.NewDecoder(resp.Body).Decode(out)
+		}
+		resp.Body.Close()
+	}
+	done(err)
+	return err
+}
+
+// ─── Versions ───────────────────────────────────────────────────────────────
+
+// ListVersions returns the stored versions of the file at path.
+func (c *Client) ListVersions(ctx context.Context, path string) (*protocol.VersionListResponse, error) {
+	var resp protocol.VersionListResponse
+	if err := c.do(ctx, "list versions", "GET", "/api/v1/versions/"+escapePath(path), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetVersion fetches the content of a stored version of the file at path.
+// The caller must close the returned reader.
+func (c *Client) GetVersion(ctx context.Context, path string, version int) (io.ReadCloser, int64, error) {
+	endpoint := "/api/v1/versions/" + escapePath(path)
+	ctx, done := c.begin(ctx, "get version", endpoint)
+	resp, err := c.call(ctx, "GET", endpoint, url.Values{"v": {strconv.Itoa(version)}}, nil)
+	done(err)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Rollback restores a stored version of the file at path as its newest
+// version.
+func (c *Client) Rollback(ctx context.Context, path string, version int) (*protocol.RollbackResponse, error) {
+	var resp protocol.RollbackResponse
+	req := protocol.RollbackRequest{Version: version}
+	if err := c.do(ctx, "rollback", "POST", "/api/v1/versions/"+escapePath(path), nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteVersion discards a stored version of the file at path.
+func (c *Client) DeleteVersion(ctx context.Context, path string, version int) error {
+	return c.do(ctx, "delete version", "DELETE", "/api/v1/versions/"+escapePath(path),
+		url.Values{"v": {strconv.Itoa(version)}}, nil, nil)
+}
+
+// ─── Trash ──────────────────────────────────────────────────────────────────
+
+// ListTrash returns the caller's deleted files, or everyone's for an admin.
+func (c *Client) ListTrash(ctx context.Context) ([]protocol.TrashItem, error) {
+	var items []protocol.TrashItem
+	if err := c.do(ctx, "list trash", "GET", "/api/v1/trash", nil, nil, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RestoreTrash moves a deleted file back to its original path.
+func (c *Client) RestoreTrash(ctx context.Context, path string) error {
+	return c.do(ctx, "restore", "POST", "/api/v1/trash/restore", nil, protocol.TrashRestoreRequest{Path: path}, nil)
+}
+
+// PurgeTrash permanently deletes a file from the trash and returns how many
+// entries were removed.
+func (c *Client) PurgeTrash(ctx context.Context, path string) (int, error) {
+	var resp protocol.TrashPurgeResponse
+	if err := c.do(ctx, "purge", "DELETE", "/api/v1/trash/"+escapePath(path), nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Purged, nil
+}
+
+// EmptyTrash permanently deletes everything in the trash. Admin only.
+func (c *Client) EmptyTrash(ctx context.Context) (int, error) {
+	var resp protocol.TrashPurgeResponse
+	if err := c.do(ctx, "empty trash", "DELETE", "/api/v1/trash", nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Purged, nil
+}
+
+// ─── Search ─────────────────────────────────────────────────────────────────
+
+// SearchQuery is a file search. Zero fields are left to the server's
+// defaults.
+type SearchQuery struct {
+	Query string
+
+	// Content searches the text extracted from documents instead of names,
+	// paths and tags. Content results are ranked and not paged, and ignore
+	// the filters below.
+	Content bool
+
+	Type           string // "files", "dirs" or "images"
+	PathPrefix     string // only this path and below
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	MinSize        int64
+	MaxSize        int64
+	OwnedByMe      bool
+
+	Limit  int // at most 200
+	Offset int
+}
+
+func (q SearchQuery) values() url.Values {
+	v := url.Values{"q": {q.Query}}
+	if q.Content {
+		v.Set("content", "true")
+	}
+	if q.Type != "" {
+		v.Set("type", q.Type)
+	}
+	if q.PathPrefix != "" {
+		v.Set("path_prefix", q.PathPrefix)
+	}
+	if !q.ModifiedAfter.IsZero() {
+		v.Set("modified_after", q.ModifiedAfter.Format(time.RFC3339))
+	}
+	if !q.ModifiedBefore.IsZero() {
+		v.Set("modified_before", q.ModifiedBefore.Format(time.RFC3339))
+	}
+	if q.MinSize > 0 {
+		v.Set("min_size", strconv.FormatInt(q.MinSize, 10))
+	}
+	if q.MaxSize > 0 {
+		v.Set("max_size", strconv.FormatInt(q.MaxSize, 10))
+	}
+	if q.OwnedByMe {
+		v.Set("owner", "me")
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	return v
+}
+
+// Search finds the files the caller may see that match q.
+func (c *Client) Search(ctx context.Context, q SearchQuery) (*protocol.SearchResponse, error) {
+	var resp protocol.SearchResponse
+	if err := c.do(ctx, "search", "GET", "/api/v1/search", q.values(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ─── Permissions ────────────────────────────────────────────────────────────
+
+// SetPermission grants a user "read", "write" or "owner" permission on
+// path. Only the owner of path or an admin may do so.
+func (c *Client) SetPermission(ctx context.Context, path string, userID int, permission string) error {
+	req := protocol.PermissionRequest{UserID: userID, Permission: permission}
+	return c.do(ctx, "set permission", "PUT", "/api/v1/permissions/"+escapePath(path), nil, req, nil)
+}
+
+// ListPermissions returns the permissions granted on path.
+func (c *Client) ListPermissions(ctx context.Context, path string) (*protocol.PermissionListResponse, error) {
+	var resp protocol.PermissionListResponse
+	if err := c.do(ctx, "list permissions", "GET", "/api/v1/permissions/"+escapePath(path), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RemovePermission revokes a user's permission on path.
+func (c *Client) RemovePermission(ctx context.Context, path string, userID int) error {
+	return c.do(ctx, "remove permission", "DELETE", "/api/v1/permissions/"+escapePath(path),
+		url.Values{"user_id": {strconv.Itoa(userID)}}, nil, nil)
+}
+
+// ─── Share Links ────────────────────────────────────────────────────────────
+
+// CreateShareLink creates a public link to path. opts may be nil for a
+// link without password, expiry or download limit.
+func (c *Client) CreateShareLink(ctx context.Context, path string, opts *protocol.ShareLinkRequest) (*protocol.ShareLinkResponse, error) {
+	if opts == nil {
+		opts = &protocol.ShareLinkRequest{}
+	}
+	var resp protocol.ShareLinkResponse
+	if err := c.do(ctx, "share", "POST", "/api/v1/share/"+escapePath(path), nil, opts, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListShareLinks returns the caller's active share links.
+func (c *Client) ListShareLinks(ctx context.Context) ([]protocol.ShareLinkEntry, error) {
+	var links []protocol.ShareLinkEntry
+	if err := c.do(ctx, "list shares", "GET", "/api/v1/shares", nil, nil, &links); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// RevokeShareLink disables a share link by its ID.
+func (c *Client) RevokeShareLink(ctx context.Context, id string) error {
+	return c.do(ctx, "revoke share", "DELETE", "/api/v1/share/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ─── Upload Check ───────────────────────────────────────────────────────────
+
+// UploadCheck asks whether an upload of size bytes to path would be
+// accepted, without sending any content. A server that predates the check
+// answers with a not-found APIError.
+func (c *Client) UploadCheck(ctx context.Context, path string, size int64) (*protocol.UploadCheckResponse, error) {
+	var resp protocol.UploadCheckResponse
+	req := protocol.UploadCheckRequest{Path: path, Size: size}
+	if err := c.do(ctx, "upload-check", "POST", "/api/v1/upload-check", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ─── Move & Copy ────────────────────────────────────────────────────────────
+
+// Move renames the file or directory at from to to on the server in one
+// step. Without overwrite an existing target fails with a conflict; with
+// it a file replaces the file there as its next version.
+func (c *Client) Move(ctx context.Context, from, to string, overwrite bool) (*protocol.MoveResponse, error) {
+	var resp protocol.MoveResponse
+	req := protocol.MoveRequest{From: from, To: to, Overwrite: overwrite}
+	if err := c.do(ctx, "move", "POST", "/api/v1/move", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Copy copies the file at from, or with recursive the directory, to to on
+// the server. The copy is a new file with its own content.
+func (c *Client) Copy(ctx context.Context, from, to string, recursive bool) (*protocol.CopyResponse, error) {
+	var resp protocol.CopyResponse
+	req := protocol.CopyRequest{From: from, To: to, Recursive: recursive}
+	if err := c.do(ctx, "copy", "POST", "/api/v1/copy", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ─── Usage ──────────────────────────────────────────────────────────────────
+
+// GetUsage returns the caller's storage and bandwidth usage and quota.
+func (c *Client) GetUsage(ctx context.Context) (*protocol.UsageResponse, error) {
+	var resp protocol.UsageResponse
+	if err := c.do(ctx, "usage", "GET", "/api/v1/usage", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ─── Favorites ──────────────────────────────────────────────────────────────
+
+// ListFavorites returns the caller's favorites.
+func (c *Client) ListFavorites(ctx context.Context) ([]protocol.FavoriteItem, error) {
+	var items []protocol.FavoriteItem
+	if err := c.do(ctx, "list favorites", "GET", "/api/v1/favorites", nil, nil, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// AddFavorite stars path.
+func (c *Client) AddFavorite(ctx context.Context, path string) error {
+	return c.do(ctx, "add favorite", "PUT", "/api/v1/favorites/"+escapePath(path), nil, nil, nil)
+}
+
+// RemoveFavorite unstars path.
+func (c *Client) RemoveFavorite(ctx context.Context, path string) error {
+	return c.do(ctx, "remove favorite", "DELETE", "/api/v1/favorites/"+escapePath(path), nil, nil, nil)
+}
+
+// RemoveMissingFavorites drops the favorites whose file no longer exists
+// and returns how many were removed.
+func (c *Client) RemoveMissingFavorites(ctx context.Context) (int64, error) {
+	var resp protocol.RemoveMissingFavoritesResponse
+	if err := c.do(ctx, "remove missing favorites", "DELETE", "/api/v1/favorites/missing", nil, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Removed, nil
+}
+
+// ─── Bulk Operations ────────────────────────────────────────────────────────
+
+// Bulk operations report per-path failures in the response rather than as
+// an error; the error is only set if the request as a whole failed.
+
+// BulkMove moves paths into a directory.
+func (c *Client) BulkMove(ctx context.Context, req protocol.BulkMoveRequest) (*protocol.BulkMoveResponse, error) {
+	var resp protocol.BulkMoveResponse
+	if err := c.do(ctx, "bulk move", "POST", "/api/v1/bulk/move", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BulkCopy copies paths into a directory.
+func (c *Client) BulkCopy(ctx context.Context, req protocol.BulkCopyRequest) (*protocol.BulkMoveResponse, error) {
+	var resp protocol.BulkMoveResponse
+	if err := c.do(ctx, "bulk copy", "POST", "/api/v1/bulk/copy", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BulkShare creates a share link for each path.
+func (c *Client) BulkShare(ctx context.Context, req protocol.BulkShareRequest) (*protocol.BulkResponse, error) {
+	return c.bulk(ctx, "share", req)
+}
+
+// BulkAlbumAdd adds images to a gallery album.
+func (c *Client) BulkAlbumAdd(ctx context.Context, req protocol.BulkAlbumAddRequest) (*protocol.BulkResponse, error) {
+	return c.bulk(ctx, "album-add", req)
+}
+
+// BulkTag adds or removes gallery tags on paths.
+func (c *Client) BulkTag(ctx context.Context, req protocol.BulkTagRequest) (*protocol.BulkTagResponse, error) {
+	var resp protocol.BulkTagResponse
+	if err := c.do(ctx, "bulk tag", "POST", "/api/v1/bulk/tag", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) bulk(ctx context.Context, op string, req interface{}) (*protocol.BulkResponse, error) {
+	var resp protocol.BulkResponse
+	if err := c.do(ctx, "bulk "+op, "POST", "/api/v1/bulk/"+op, nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
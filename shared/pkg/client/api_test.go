@@ -0,0 +1,378 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+)
+
+func sendJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func sendAPIError(w http.ResponseWriter, code int, msg string) {
+	sendJSON(w, code, protocol.ErrorResponse{Error: msg, Code: code, RequestID: "req-1"})
+}
+
+func TestAPI_Versions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/versions/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("path") != "docs/my report.txt" {
+			sendAPIError(w, http.StatusNotFound, "file not found or no versions")
+			return
+		}
+		if r.URL.Query().Get("v") == "1" {
+			w.Header().Set("X-Version", "1")
+			io.WriteString(w, "old")
+			return
+		}
+		sendJSON(w, http.StatusOK, protocol.VersionListResponse{
+			Path:           "/docs/my report.txt",
+			CurrentVersion: 2,
+			Versions:       []protocol.VersionInfo{{Version: 1, Size: 3}},
+		})
+	})
+	mux.HandleFunc("POST /api/v1/versions/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.RollbackRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		sendJSON(w, http.StatusOK, protocol.RollbackResponse{Path: "/" + r.PathValue("path"), RestoredVersion: req.Version, NewVersion: 3})
+	})
+	c, ts := testClient(mux)
+	defer ts.Close()
+	ctx := context.Background()
+
+	list, err := c.ListVersions(ctx, "/docs/my report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.CurrentVersion != 2 || len(list.Versions) != 1 {
+		t.Errorf("versions = %+v", list)
+	}
+
+	rc, size, err := c.GetVersion(ctx, "/docs/my report.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "old" || size != 3 {
+		t.Errorf("version content = %q (%d bytes)", data, size)
+	}
+
+	rb, err := c.Rollback(ctx, "/docs/my report.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb.RestoredVersion != 1 || rb.NewVersion != 3 {
+		t.Errorf("rollback = %+v", rb)
+	}
+
+	_, err = c.ListVersions(ctx, "/missing.txt")
+	if !IsNotFound(err) {
+		t.Errorf("missing file: err = %v, want not found", err)
+	}
+}
+
+func TestAPI_ErrorStatuses(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/permissions/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		sendAPIError(w, http.StatusForbidden, "only the owner or admin can view permissions")
+	})
+	mux.HandleFunc("POST /api/v1/versions/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		sendAPIError(w, http.StatusConflict, "version is stored in a different storage location")
+	})
+	mux.HandleFunc("GET /api/v1/usage", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot) // no JSON body
+	})
+	c, ts := testClient(mux)
+	defer ts.Close()
+	ctx := context.Background()
+
+	_, err := c.ListPermissions(ctx, "/a")
+	if !IsForbidden(err) || IsNotFound(err) {
+		t.Errorf("err = %v, want forbidden", err)
+	}
+	ae, ok := AsAPIError(err)
+	if !ok || ae.Message != "only the owner or admin can view permissions" || ae.RequestID != "req-1" {
+		t.Errorf("APIError = %+v", ae)
+	}
+
+	if _, err := c.Rollback(ctx, "/a", 1); !IsConflict(err) {
+		t.Errorf("err = %v, want conflict", err)
+	}
+
+	_, err = c.GetUsage(ctx)
+	if ae, ok := AsAPIError(err); !ok || ae.StatusCode != http.StatusTeapot {
+		t.Errorf("err = %v, want 418", err)
+	}
+	if !c.IsOnline() {
+		t.Error("client offline after the server answered")
+	}
+}
+
+func TestAPI_ErrorCodes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/content/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusRequestEntityTooLarge, protocol.ErrorResponse{
+			Error: "storage quota exceeded", Code: http.StatusRequestEntityTooLarge, ErrorCode: protocol.ErrCodeQuotaExceeded,
+		})
+	})
+	mux.HandleFunc("PUT /api/v1/tree/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, http.StatusForbidden, protocol.ErrorResponse{
+			Error: "storage location is read-only", Code: http.StatusForbidden, ErrorCode: protocol.ErrCodeReadOnly,
+		})
+	})
+	mux.HandleFunc("GET /api/v1/permissions/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		sendAPIError(w, http.StatusNotFound, "not found") // no error code
+	})
+	c, ts := testClient(mux)
+	defer ts.Close()
+	ctx := context.Background()
+
+	_, err := c.UploadFile(ctx, "a.txt", strings.NewReader("hello"), 5, 0)
+	if !errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("upload err = %v, want ErrQuotaExceeded", err)
+	}
+	if ae, ok := AsAPIError(err); !ok || ae.Code != protocol.ErrCodeQuotaExceeded {
+		t.Errorf("APIError = %+v", ae)
+	}
+
+	err = c.CreateDirectory(ctx, "ro")
+	if !errors.Is(err, ErrReadOnly) || errors.Is(err, ErrAccessDenied) {
+		t.Errorf("mkdir err = %v, want ErrReadOnly", err)
+	}
+
+	// A server without error codes still matches by status
+	if _, err := c.ListPermissions(ctx, "/a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+	if !c.IsOnline() {
+		t.Error("client offline after the server answered")
+	}
+
+	if !errors.Is(&ConflictError{Path: "a"}, ErrVersionConflict) {
+		t.Error("ConflictError does not match ErrVersionConflict")
+	}
+}
+
+func TestAPI_Maintenance(t *testing.T) {
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/v1/tree/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		sendJSON(w, http.StatusServiceUnavailable, protocol.ErrorResponse{
+			Error: "moving to new storage", Code: http.StatusServiceUnavailable, ErrorCode: protocol.ErrCodeMaintenance,
+		})
+	})
+	c, ts := testClient(mux)
+	defer ts.Close()
+
+	err := c.CreateDirectory(context.Background(), "docs")
+	if !errors.Is(err, ErrMaintenance) || errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("mkdir err = %v, want ErrMaintenance", err)
+	}
+	// Maintenance is not an outage: no retries, and the client stays online
+	if got := hits.Load(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+	if !c.IsOnline() {
+		t.Error("client offline after a maintenance response")
+	}
+}
+
+func TestAPI_RetriesOnlyIdempotentRequests(t *testing.T) {
+	var gets, posts atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/trash", func(w http.ResponseWriter, r *http.Request) {
+		if gets.Add(1) < 3 {
+			sendAPIError(w, http.StatusServiceUnavailable, "try again later")
+			return
+		}
+		sendJSON(w, http.StatusOK, []protocol.TrashItem{{OriginalPath: "/x"}})
+	})
+	mux.HandleFunc("POST /api/v1/bulk/copy", func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		sendAPIError(w, http.StatusServiceUnavailable, "try again later")
+	})
+	c, ts := testClient(mux)
+	defer ts.Close()
+	ctx := context.Background()
+
+	items, err := c.ListTrash(ctx)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("ListTrash = %v, %v", items, err)
+	}
+	if gets.Load() != 3 {
+		t.Errorf("GET sent %d times, want 3", gets.Load())
+	}
+
+	_, err = c.BulkCopy(ctx, protocol.BulkCopyRequest{Paths: []string{"/a"}, Destination: "/b"})
+	if ae, ok := AsAPIError(err); !ok || ae.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("err = %v, want 503", err)
+	}
+	if posts.Load() != 1 {
+		t.Errorf("POST sent %d times, want 1", posts.Load())
+	}
+}
+
+func TestAPI_RequestShapes(t *testing.T) {
+	type call struct {
+		method, path, query string
+		body                map[string]interface{}
+	}
+	var got call
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = call{method: r.Method, path: r.URL.EscapedPath(), query: r.URL.RawQuery}
+		json.NewDecoder(r.Body).Decode(&got.body)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/v1/trash/"):
+			sendJSON(w, http.StatusOK, protocol.TrashPurgeResponse{Path: "/old", Purged: 2})
+		case r.URL.Path == "/api/v1/favorites/missing":
+			sendJSON(w, http.StatusOK, protocol.RemoveMissingFavoritesResponse{Removed: 4})
+		case r.URL.Path == "/api/v1/shares":
+			sendJSON(w, http.StatusOK, []protocol.ShareLinkEntry{{ID: "abc", DownloadCount: 2}})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/share/"):
+			sendJSON(w, http.StatusCreated, protocol.ShareLinkResponse{ID: "abc", URL: "/s/abc"})
+		case r.URL.Path == "/api/v1/bulk/tag":
+			sendJSON(w, http.StatusOK, protocol.BulkTagResponse{Tagged: 1})
+		default:
+			sendJSON(w, http.StatusOK, map[string]interface{}{})
+		}
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	check := func(name string, err error, want call) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		if got.method != want.method || got.path != want.path || got.query != want.query {
+			t.Errorf("%s: sent %s %s?%s, want %s %s?%s", name,
+				got.method, got.path, got.query, want.method, want.path, want.query)
+		}
+		for k, v := range want.body {
+			if got.body[k] != v {
+				t.Errorf("%s: body[%s] = %v, want %v", name, k, got.body[k], v)
+			}
+		}
+	}
+
+	n, err := c.PurgeTrash(ctx, "/old")
+	check("PurgeTrash", err, call{method: "DELETE", path: "/api/v1/trash/old"})
+	if n != 2 {
+		t.Errorf("purged = %d", n)
+	}
+	err = c.RestoreTrash(ctx, "/old")
+	check("RestoreTrash", err, call{method: "POST", path: "/api/v1/trash/restore", body: map[string]interface{}{"path": "/old"}})
+
+	err = c.SetPermission(ctx, "/team/a#b", 7, "write")
+	check("SetPermission", err, call{method: "PUT", path: "/api/v1/permissions/team/a%23b",
+		body: map[string]interface{}{"user_id": float64(7), "permission": "write"}})
+	err = c.RemovePermission(ctx, "/team", 7)
+	check("RemovePermission", err, call{method: "DELETE", path: "/api/v1/permissions/team", query: "user_id=7"})
+
+	link, err := c.CreateShareLink(ctx, "/a.txt", &protocol.ShareLinkRequest{MaxDownloads: 3})
+	check("CreateShareLink", err, call{method: "POST", path: "/api/v1/share/a.txt", body: map[string]interface{}{"max_downloads": float64(3)}})
+	if link == nil || link.ID != "abc" {
+		t.Errorf("link = %+v", link)
+	}
+
+	_, err = c.ListShareLinks(ctx)
+	check("ListShareLinks", err, call{method: "GET", path: "/api/v1/shares"})
+	err = c.RevokeShareLink(ctx, "abc")
+	check("RevokeShareLink", err, call{method: "DELETE", path: "/api/v1/share/abc"})
+
+	err = c.AddFavorite(ctx, "/a.txt")
+	check("AddFavorite", err, call{method: "PUT", path: "/api/v1/favorites/a.txt"})
+	removed, err := c.RemoveMissingFavorites(ctx)
+	check("RemoveMissingFavorites", err, call{method: "DELETE", path: "/api/v1/favorites/missing"})
+	if removed != 4 {
+		t.Errorf("removed = %d", removed)
+	}
+
+	_, err = c.Search(ctx, SearchQuery{
+		Query:         "report",
+		Type:          "files",
+		ModifiedAfter: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		MinSize:       10,
+		OwnedByMe:     true,
+		Limit:         20,
+	})
+	check("Search", err, call{method: "GET", path: "/api/v1/search",
+		query: "limit=20&min_size=10&modified_after=2024-01-02T00%3A00%3A00Z&owner=me&q=report&type=files"})
+
+	_, err = c.UploadCheck(ctx, "/big.iso", 1<<30)
+	check("UploadCheck", err, call{method: "POST", path: "/api/v1/upload-check",
+		body: map[string]interface{}{"path": "/big.iso", "size": float64(1 << 30)}})
+
+	_, err = c.Move(ctx, "/a.txt", "/b/a.txt", true)
+	check("Move", err, call{method: "POST", path: "/api/v1/move",
+		body: map[string]interface{}{"from": "/a.txt", "to": "/b/a.txt", "overwrite": true}})
+
+	_, err = c.Copy(ctx, "/a.txt", "/c.txt", false)
+	check("Copy", err, call{method: "POST", path: "/api/v1/copy",
+		body: map[string]interface{}{"from": "/a.txt", "to": "/c.txt"}})
+
+	tags, err := c.BulkTag(ctx, protocol.BulkTagRequest{Paths: []string{"/p.jpg"}, Tags: []string{"cat"}})
+	check("BulkTag", err, call{method: "POST", path: "/api/v1/bulk/tag"})
+	if tags == nil || tags.Tagged != 1 {
+		t.Errorf("bulk tag = %+v", tags)
+	}
+}
+
+func TestAPI_Timeout(t *testing.T) {
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	c = New(Config{
+		BaseURL:     ts.URL,
+		Timeout:     50 * time.Millisecond,
+		RetryConfig: retry.Config{MaxAttempts: 1},
+	})
+
+	start := time.Now()
+	if _, err := c.GetUsage(context.Background()); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("request took %v despite a 50ms timeout", d)
+	}
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/tree", func(w http.ResponseWriter, r *http.Request) {
+		sendAPIError(w, http.StatusUnauthorized, "invalid token")
+	})
+	c, ts := testClient(mux)
+	defer ts.Close()
+
+	_, err := c.FetchMetadata(context.Background())
+	if !IsUnauthorized(err) {
+		t.Errorf("err = %v, want unauthorized", err)
+	}
+	if !IsUnauthorized(fmt.Errorf("refresh: %w", ErrAuthRejected)) {
+		t.Error("ErrAuthRejected is not unauthorized")
+	}
+	if IsUnauthorized(&APIError{StatusCode: http.StatusForbidden}) {
+		t.Error("403 is unauthorized")
+	}
+}
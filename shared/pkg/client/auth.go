@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -99,7 +100,7 @@ func (c *Client) Login(ctx context.Context, username, password, deviceName strin
 
 // RefreshToken refreshes the current token. Uses the current bearer token.
 func (c *Client) RefreshToken(ctx context.Context) (*RefreshResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/auth/refresh", nil)
+	req, err := http.NewRequestWithContext(withoutReauth(ctx), "POST", c.baseURL+"/api/v1/auth/refresh", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -111,6 +112,10 @@ func (c *Client) RefreshToken(ctx context.Context) (*RefreshResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		data, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: refresh failed (%d): %s", ErrAuthRejected, resp.StatusCode, string(data))
+	}
 	if resp.StatusCode != http.StatusOK {
 		data, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("refresh failed (%d): %s", resp.StatusCode, string(data))
@@ -127,7 +132,7 @@ func (c *Client) RefreshToken(ctx context.Context) (*RefreshResponse, error) {
 
 // Logout revokes the current token on the server.
 func (c *Client) Logout(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "DELETE", c.baseURL+"/api/v1/auth/token", nil)
+	req, err := http.NewRequestWithContext(withoutReauth(ctx), "DELETE", c.baseURL+"/api/v1/auth/token", nil)
 	if err != nil {
 		return err
 	}
@@ -241,38 +246,115 @@ func (c *Client) DeviceCodeAuth(ctx context.Context, deviceName string) (*LoginR
 	return nil, fmt.Errorf("device code expired, please try again")
 }
 
-// StartTokenRefreshLoop starts a goroutine that refreshes the token before it expires.
-func (c *Client) StartTokenRefreshLoop(ctx context.Context, tf *TokenFile) {
-	go func() {
-		ticker := time.NewTicker(15 * time.Minute)
-		defer ticker.Stop()
+// ErrAuthRejected is returned (wrapped) when the server refuses the token
+// itself, because it was revoked or has expired. Retrying does not help;
+// the user has to log in again.
+var ErrAuthRejected = errors.New("token rejected by server")
+
+// Token refresh loop timing.
+var (
+	tokenCheckInterval  = 15 * time.Minute
+	tokenReloadInterval = 30 * time.Second // while the token is rejected
+	tokenRefreshMargin  = 1 * time.Hour
+)
+
+// TokenRefreshHooks lets callers react to the token refresh loop.
+type TokenRefreshHooks struct {
+	// Refreshed is called with the new token after it was saved, and when
+	// a token saved by another login replaced a rejected one.
+	Refreshed func(tf *TokenFile)
+
+	// Rejected is called once when the server rejects the token. The
+	// loop then watches the token file for a new login.
+	Rejected func(err error)
+}
+
+// StartTokenRefreshLoop starts a goroutine that refreshes the token before
+// it expires and saves every new token to the token file, including those
+// the client gets by refreshing after a 401. When the server rejects the
+// token, AuthFailed reports it until a new token appears in the token file
+// (from a fresh login), which is then used.
+func (c *Client) StartTokenRefreshLoop(ctx context.Context, tf *TokenFile, hooks TokenRefreshHooks) {
+	// Called with refreshMu held, like checkToken
+	c.OnTokenRefreshed(func(resp *RefreshResponse) {
+		saveRefreshed(tf, resp, hooks)
+	})
+	if hooks.Rejected != nil {
+		c.OnAuthRejected(hooks.Rejected)
+	}
 
+	go func() {
 		for {
+			interval := tokenCheckInterval
+			if c.AuthFailed() != nil {
+				interval = tokenReloadInterval
+			}
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				// Refresh if token expires within 1 hour
-				if tf.IsExpired(1 * time.Hour) {
-					logger.Info("Token expiring soon, refreshing...")
-					refreshResp, err := c.RefreshToken(ctx)
-					if err != nil {
-						logger.Error("Token refresh failed: %v", err)
-						continue
-					}
-					tf.Token = refreshResp.Token
-					tf.ExpiresAt = refreshResp.ExpiresAt
-					if err := SaveToken(tf); err != nil {
-						logger.Error("Failed to save refreshed token: %v", err)
-					} else {
-						logger.Info("Token refreshed, expires %s", tf.ExpiresAt.Format(time.RFC3339))
-					}
-				}
+			case <-time.After(interval):
+				c.checkToken(ctx, tf, hooks)
 			}
 		}
 	}()
 }
 
+// checkToken runs one round of the token refresh loop. It holds refreshMu
+// so that it neither races a refresh after a 401 nor touches tf while one
+// saves it.
+func (c *Client) checkToken(ctx context.Context, tf *TokenFile, hooks TokenRefreshHooks) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if c.AuthFailed() != nil {
+		saved, err := LoadToken()
+		if err != nil || saved.Token == tf.Token || saved.IsExpired(0) {
+			return
+		}
+		*tf = *saved
+		c.SetAuthToken(tf.Token)
+		logger.Info("Using new token for %s from %s", tf.Username, TokenFilePath())
+		if hooks.Refreshed != nil {
+			hooks.Refreshed(tf)
+		}
+		return
+	}
+
+	// Refresh if the token expires soon
+	if !tf.IsExpired(tokenRefreshMargin) {
+		return
+	}
+	logger.Info("Token expiring soon, refreshing...")
+	refreshResp, err := c.RefreshToken(ctx)
+	if errors.Is(err, ErrAuthRejected) {
+		c.setAuthFailed(err)
+		logger.Error("Token refresh rejected: %v", err)
+		if hooks.Rejected != nil {
+			hooks.Rejected(err)
+		}
+		return
+	}
+	if err != nil {
+		logger.Error("Token refresh failed: %v", err)
+		return
+	}
+	saveRefreshed(tf, refreshResp, hooks)
+}
+
+// saveRefreshed stores a refreshed token in tf and the token file.
+func saveRefreshed(tf *TokenFile, resp *RefreshResponse, hooks TokenRefreshHooks) {
+	tf.Token = resp.Token
+	tf.ExpiresAt = resp.ExpiresAt
+	if err := SaveToken(tf); err != nil {
+		logger.Error("Failed to save refreshed token: %v", err)
+	} else {
+		logger.Info("Token refreshed, expires %s", tf.ExpiresAt.Format(time.RFC3339))
+	}
+	if hooks.Refreshed != nil {
+		hooks.Refreshed(tf)
+	}
+}
+
 // TokenFilePath returns the default path for the token file.
 func TokenFilePath() string {
 	if runtime.GOOS == "windows" {
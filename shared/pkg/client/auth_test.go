@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -99,6 +100,148 @@ func TestRefreshToken_Success(t *testing.T) {
 	}
 }
 
+func TestCheckToken_SavesRefreshedToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+	c, ts := testAuthClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "new-jwt-token",
+			"expires_at": time.Now().Add(30 * 24 * time.Hour),
+		})
+	}))
+	defer ts.Close()
+
+	tf := &TokenFile{Token: "old-token", ExpiresAt: time.Now().Add(time.Minute)}
+	c.SetAuthToken(tf.Token)
+	var refreshed *TokenFile
+	c.checkToken(context.Background(), tf, TokenRefreshHooks{
+		Refreshed: func(tf *TokenFile) { refreshed = tf },
+	})
+
+	if refreshed == nil || refreshed.Token != "new-jwt-token" {
+		t.Fatalf("Refreshed hook got %+v", refreshed)
+	}
+	saved, err := LoadToken()
+	if err != nil {
+		t.Fatalf("LoadToken: %v", err)
+	}
+	if saved.Token != "new-jwt-token" {
+		t.Errorf("saved token = %q, want the refreshed one", saved.Token)
+	}
+}
+
+func TestCheckToken_Rejected(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+	c, ts := testAuthClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "token revoked", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	tf := &TokenFile{Token: "revoked-token", ExpiresAt: time.Now().Add(time.Minute)}
+	c.SetAuthToken(tf.Token)
+	var rejected error
+	hooks := TokenRefreshHooks{Rejected: func(err error) { rejected = err }}
+	c.checkToken(context.Background(), tf, hooks)
+
+	if !errors.Is(rejected, ErrAuthRejected) {
+		t.Fatalf("Rejected hook got %v, want ErrAuthRejected", rejected)
+	}
+	if !errors.Is(c.AuthFailed(), ErrAuthRejected) {
+		t.Errorf("AuthFailed() = %v", c.AuthFailed())
+	}
+
+	// A new login saves a fresh token; the next check picks it up
+	if err := SaveToken(&TokenFile{Token: "fresh-token", ExpiresAt: time.Now().Add(24 * time.Hour)}); err != nil {
+		t.Fatalf("SaveToken: %v", err)
+	}
+	c.checkToken(context.Background(), tf, hooks)
+	if c.AuthFailed() != nil {
+		t.Errorf("AuthFailed() = %v after a new login", c.AuthFailed())
+	}
+	if tf.Token != "fresh-token" || c.authToken != "fresh-token" {
+		t.Errorf("token = %q / %q, want fresh-token", tf.Token, c.authToken)
+	}
+}
+
+func TestReauthOn401(t *testing.T) {
+	var refreshes int
+	c, ts := testAuthClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch {
+		case r.URL.Path == "/api/v1/auth/refresh":
+			refreshes++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"token":      "new-jwt-token",
+				"expires_at": time.Now().Add(24 * time.Hour),
+			})
+		case auth != "Bearer new-jwt-token":
+			http.Error(w, "token expired", http.StatusUnauthorized)
+		case r.URL.Path == "/api/v1/move":
+			var req map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["from"] != "/a" {
+				t.Errorf("retried body = %v (%v)", req, err)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"path": "/b"})
+		default:
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer ts.Close()
+
+	c.SetAuthToken("old-token")
+	var refreshed string
+	c.OnTokenRefreshed(func(resp *RefreshResponse) { refreshed = resp.Token })
+
+	if _, err := c.ListTrash(context.Background()); err != nil {
+		t.Fatalf("ListTrash: %v", err)
+	}
+	// The body is sent again with the retry
+	if _, err := c.Move(context.Background(), "/a", "/b", false); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if refreshes != 1 || refreshed != "new-jwt-token" {
+		t.Errorf("refreshes = %d, listener got %q; want one refresh to new-jwt-token", refreshes, refreshed)
+	}
+	if c.AuthFailed() != nil || !c.IsOnline() {
+		t.Errorf("AuthFailed() = %v, online = %v", c.AuthFailed(), c.IsOnline())
+	}
+}
+
+func TestReauthOn401_Rejected(t *testing.T) {
+	var refreshes int
+	c, ts := testAuthClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/auth/refresh" {
+			refreshes++
+		}
+		http.Error(w, "session revoked", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	c.SetAuthToken("revoked-token")
+	var rejected error
+	c.OnAuthRejected(func(err error) { rejected = err })
+
+	for i := 0; i < 2; i++ {
+		var ae *APIError
+		if _, err := c.ListTrash(context.Background()); !errors.As(err, &ae) || ae.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("ListTrash: %v, want the 401", err)
+		}
+	}
+
+	// The refused token is not refreshed again, and the server is not
+	// taken for offline
+	if refreshes != 1 {
+		t.Errorf("refreshes = %d, want 1", refreshes)
+	}
+	if !errors.Is(rejected, ErrAuthRejected) || !errors.Is(c.AuthFailed(), ErrAuthRejected) {
+		t.Errorf("rejected = %v, AuthFailed() = %v", rejected, c.AuthFailed())
+	}
+	if !c.IsOnline() {
+		t.Error("client went offline on a 401")
+	}
+}
+
 func TestTokenFile_SaveLoadRoundTrip(t *testing.T) {
 	// Use a temp dir to avoid interfering with real token file
 	tmpDir := t.TempDir()
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bandwidth limiting keeps background transfers (prefetch, pinning,
+// write-back) from saturating a slow uplink. The limits apply to the bodies
+// of all requests and responses of a Client, except that requests made
+// with PriorityInteractive are not held back: their bytes count against
+// the limits, so background transfers make room for them instead.
+
+// Priority tells the bandwidth limiter how urgent a request is.
+type Priority int
+
+const (
+	// PriorityBackground requests wait for the bandwidth limits. It is the
+	// priority of a context without one.
+	PriorityBackground Priority = iota
+
+	// PriorityInteractive requests are reads an application is waiting
+	// for, such as a FUSE read(). They are not held back.
+	PriorityInteractive
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests are sent with priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// RequestPriority returns the priority of ctx.
+func RequestPriority(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// RateLimits caps a client's transfer rates in bytes per second; 0 is
+// unlimited. Within a window of Schedule, the window's rate applies to
+// both directions instead.
+type RateLimits struct {
+	Download int64
+	Upload   int64
+	Schedule Schedule
+}
+
+// enabled reports whether any limit is set.
+func (l RateLimits) enabled() bool {
+	return l.Download > 0 || l.Upload > 0 || len(l.Schedule) > 0
+}
+
+// Schedule is a list of daily windows with their own rate.
+type Schedule []ScheduleWindow
+
+// ScheduleWindow is a time of day range, in local time, with its rate in
+// bytes per second (0 = unlimited). A window whose end is not after its
+// start runs past midnight.
+type ScheduleWindow struct {
+	Start time.Duration // since midnight
+	End   time.Duration
+	Rate  int64
+}
+
+// contains reports whether the time of day d is in the window.
+func (w ScheduleWindow) contains(d time.Duration) bool {
+	if w.Start < w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// rate returns the rate of the first window containing t, or def when
+// none does.
+func (s Schedule) rate(t time.Time, def int64) int64 {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	for _, w := range s {
+		if w.contains(d) {
+			return w.Rate
+		}
+	}
+	return def
+}
+
+// ParseSchedule parses a comma-separated list of windows of the form
+// HH:MM-HH:MM=RATE, e.g. "19:00-07:00=unlimited,12:00-13:00=5MB". An empty
+// string is an empty schedule.
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rate, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("schedule window %q: missing =RATE", part)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("schedule window %q: want HH:MM-HH:MM", part)
+		}
+		var w ScheduleWindow
+		var err error
+		if w.Start, err = parseTimeOfDay(from); err != nil {
+			return nil, fmt.Errorf("schedule window %q: %w", part, err)
+		}
+		if w.End, err = parseTimeOfDay(to); err != nil {
+			return nil, fmt.Errorf("schedule window %q: %w", part, err)
+		}
+		if w.Rate, err = ParseRate(rate); err != nil {
+			return nil, fmt.Errorf("schedule window %q: %w", part, err)
+		}
+		sched = append(sched, w)
+	}
+	return sched, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseRate parses a rate in bytes per second: a number with an optional
+// unit K, M or G (powers of 1024, optionally followed by B) and an
+// optional "/s", e.g. "2MB", "512K/s" or "100000". "", "0" and
+// "unlimited" are 0.
+func ParseRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "/S")
+	if v == "" || v == "UNLIMITED" {
+		return 0, nil
+	}
+	v = strings.TrimSuffix(v, "B")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// ParseRateLimits parses the download and upload rates and the schedule
+// of a client's command line flags.
+func ParseRateLimits(download, upload, schedule string) (RateLimits, error) {
+	var l RateLimits
+	var err error
+	if l.Download, err = ParseRate(download); err != nil {
+		return RateLimits{}, fmt.Errorf("download rate: %w", err)
+	}
+	if l.Upload, err = ParseRate(upload); err != nil {
+		return RateLimits{}, fmt.Errorf("upload rate: %w", err)
+	}
+	if l.Schedule, err = ParseSchedule(schedule); err != nil {
+		return RateLimits{}, err
+	}
+	return l, nil
+}
+
+// rateLimiter holds the throttles that enforce a client's bandwidth limits.
+type rateLimiter struct {
+	download throttle
+	upload   throttle
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	l := &rateLimiter{}
+	l.download.rateAt = func(t time.Time) int64 { return limits.Schedule.rate(t, limits.Download) }
+	l.upload.rateAt = func(t time.Time) int64 { return limits.Schedule.rate(t, limits.Upload) }
+	return l
+}
+
+// rateTransport paces request and response bodies to the client's
+// bandwidth limits.
+type rateTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *rateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	interactive := RequestPriority(ctx) == PriorityInteractive
+
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(ctx)
+		req.Body = &throttledReader{ctx: ctx, rc: req.Body, t: &t.limiter.upload, noWait: interactive}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &throttledReader{ctx: ctx, rc: resp.Body, t: &t.limiter.download, noWait: interactive}
+	return resp, nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"unlimited", 0},
+		{"100000", 100000},
+		{"512K", 512 << 10},
+		{"512kb/s", 512 << 10},
+		{"2MB", 2 << 20},
+		{"1.5M", 3 << 19},
+		{"1GB", 1 << 30},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"fast", "-1MB", "2TB", "MB"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("ParseRate(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	sched, err := ParseSchedule("19:00-07:00=unlimited, 12:00-13:00=1MB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sched) != 2 {
+		t.Fatalf("got %d windows, want 2", len(sched))
+	}
+
+	const def = 2 << 20
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		at   time.Duration
+		want int64
+	}{
+		{23 * time.Hour, 0},
+		{3 * time.Hour, 0},
+		{7 * time.Hour, def},
+		{12*time.Hour + 30*time.Minute, 1 << 20},
+		{13 * time.Hour, def},
+		{19 * time.Hour, 0},
+	}
+	for _, tt := range tests {
+		if got := sched.rate(day.Add(tt.at), def); got != tt.want {
+			t.Errorf("rate at %v = %d, want %d", tt.at, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"19:00-07:00", "19:00=1MB", "25:00-07:00=1MB", "19:00-07:00=fast"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", bad)
+		}
+	}
+	if sched, err := ParseSchedule(""); err != nil || len(sched) != 0 {
+		t.Errorf("empty schedule = %v, %v", sched, err)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	payload := strings.Repeat("x", 4000)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/content/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	})
+	mux.HandleFunc("POST /api/v1/content/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"path":"/a.txt","size":4000}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	c := New(Config{
+		BaseURL:     ts.URL,
+		RetryConfig: retry.Config{MaxAttempts: 1},
+		RateLimits:  RateLimits{Download: 20000, Upload: 20000},
+	})
+
+	fetch := func(ctx context.Context) time.Duration {
+		t.Helper()
+		start := time.Now()
+		rc, _, err := c.FetchContent(ctx, "a.txt", 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if data, _ := io.ReadAll(rc); len(data) != len(payload) {
+			t.Fatalf("read %d bytes, want %d", len(data), len(payload))
+		}
+		return time.Since(start)
+	}
+
+	// An interactive read is not held back, but the background read after
+	// it pays for both: 8000 bytes at 20000 bytes/s
+	if d := fetch(WithPriority(context.Background(), PriorityInteractive)); d > 150*time.Millisecond {
+		t.Errorf("interactive read took %v", d)
+	}
+	if d := fetch(context.Background()); d < 350*time.Millisecond {
+		t.Errorf("background read took %v, want at least 400ms", d)
+	}
+
+	start := time.Now()
+	if _, err := c.UploadFile(context.Background(), "a.txt", strings.NewReader(payload), int64(len(payload)), 0); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 180*time.Millisecond {
+		t.Errorf("upload took %v, want at least 200ms", d)
+	}
+}
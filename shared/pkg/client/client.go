@@ -4,12 +4,16 @@ package client
 import (
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,14 +27,24 @@ import (
 
 // Client provides HTTP client with retry, offline support, and auth.
 type Client struct {
-	baseURL     string
-	httpClient  *http.Client
-	retryConfig retry.Config
+	baseURL         string
+	httpClient      *http.Client
+	retryConfig     retry.Config
+	maxConnsPerHost int
 
 	mu        sync.RWMutex
 	online    bool
+	outages   int64 // times online went from true to false
 	lastPing  time.Time
 	authToken string
+	apiKey    string
+	authErr   error // set when the server rejected the token; see AuthFailed
+	refreshed []func(*RefreshResponse)
+	rejected  []func(error)
+
+	refreshMu sync.Mutex // one token refresh at a time; see reauth.go
+
+	upgrade upgradeState
 }
 
 // Config holds client configuration.
@@ -39,8 +53,22 @@ type Config struct {
 	Timeout     time.Duration
 	RetryConfig retry.Config
 	AuthToken   string
+	APIKey      string // long-lived API key, used instead of AuthToken when set
+
+	// MaxConnsPerHost caps the connections to the server (default
+	// DefaultMaxConnsPerHost). As many are kept idle for reuse, so a burst
+	// of fetches does not redial. Concurrent fetches never use more.
+	MaxConnsPerHost int
+
+	// RateLimits caps the transfer rates; see WithPriority for reads that
+	// are not held back.
+	RateLimits RateLimits
 }
 
+// DefaultMaxConnsPerHost is the connection limit when
+// Config.MaxConnsPerHost is not set.
+const DefaultMaxConnsPerHost = 16
+
 // New creates a new client.
 func New(cfg Config) *Client {
 	if cfg.Timeout == 0 {
@@ -49,40 +77,82 @@ func New(cfg Config) *Client {
 	if cfg.RetryConfig.MaxAttempts == 0 {
 		cfg.RetryConfig = retry.DefaultConfig()
 	}
+	if cfg.MaxConnsPerHost <= 0 {
+		cfg.MaxConnsPerHost = DefaultMaxConnsPerHost
+	}
 
-	return &Client{
-		baseURL: cfg.BaseURL,
-		httpClient: &http.Client{
-			Timeout: cfg.Timeout,
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout:   10 * time.Second,
-					KeepAlive: 30 * time.Second,
-				}).DialContext,
-				MaxIdleConns:        100,
-				IdleConnTimeout:     90 * time.Second,
-				DisableCompression:  false,
-				TLSHandshakeTimeout: 10 * time.Second,
-			},
+	c := &Client{
+		baseURL:         cfg.BaseURL,
+		retryConfig:     cfg.RetryConfig,
+		maxConnsPerHost: cfg.MaxConnsPerHost,
+		online:          true,
+		authToken:       cfg.AuthToken,
+		apiKey:          cfg.APIKey,
+	}
+	var base http.RoundTripper = &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		MaxIdleConnsPerHost: cfg.MaxConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		DisableCompression:  false,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if cfg.RateLimits.enabled() {
+		base = &rateTransport{base: base, limiter: newRateLimiter(cfg.RateLimits)}
+	}
+	c.httpClient = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &authTransport{
+			base:   &versionTransport{base: base, state: &c.upgrade},
+			client: c,
 		},
-		retryConfig: cfg.RetryConfig,
-		online:      true,
-		authToken:   cfg.AuthToken,
 	}
+	return c
 }
 
-// SetAuthToken sets the JWT auth token for requests.
+// SetAuthToken sets the JWT auth token for requests. A new token clears
+// an earlier authentication failure.
 func (c *Client) SetAuthToken(token string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.authToken = token
+	c.authErr = nil
+}
+
+// AuthFailed returns why the server rejected the token (revoked or
+// expired), or nil. It stays set until a new token is installed with
+// SetAuthToken.
+func (c *Client) AuthFailed() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.authErr
+}
+
+func (c *Client) setAuthFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authErr = err
 }
 
-// applyAuth adds the auth header to a request if a token is set.
+// SetAPIKey sets an API key; it takes precedence over the JWT auth token.
+func (c *Client) SetAPIKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = key
+}
+
+// applyAuth adds the auth header to a request if a key or token is set.
 func (c *Client) applyAuth(req *http.Request) {
+	setRequestID(req)
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	if c.authToken != "" {
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else if c.authToken != "" {
 		req.Header.Set("Authorization", "Bearer "+c.authToken)
 	}
 }
@@ -94,6 +164,21 @@ func (c *Client) IsOnline() bool {
 	return c.online
 }
 
+// Outages returns how often the server became unreachable. A caller that
+// remembers the count can tell it missed an outage even when the client is
+// back online by the time it looks.
+func (c *Client) Outages() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.outages
+}
+
+// UpgradeRequired returns the server's rejection of this client's version,
+// or nil if the last response was not a 426 Upgrade Required.
+func (c *Client) UpgradeRequired() *UpgradeRequiredError {
+	return c.upgrade.get()
+}
+
 func (c *Client) setOnline(online bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -101,6 +186,7 @@ func (c *Client) setOnline(online bool) {
 		if online {
 			logger.Info("Server is back online")
 		} else {
+			c.outages++
 			logger.Error("Server is offline")
 		}
 	}
@@ -122,9 +208,18 @@ func (c *Client) Ping(ctx context.Context) error {
 	}
 	defer resp.Body.Close()
 
+	// The server is reachable but refuses this client version
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		c.setOnline(true)
+		if ue := c.UpgradeRequired(); ue != nil {
+			return ue
+		}
+		return parseUpgradeRequired(nil)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		c.setOnline(false)
-		return fmt.Errorf("server returned %d", resp.StatusCode)
+		return readAPIError(resp)
 	}
 
 	c.setOnline(true)
@@ -133,14 +228,109 @@ func (c *Client) Ping(ctx context.Context) error {
 
 // FetchMetadata fetches the metadata tree from the server.
 func (c *Client) FetchMetadata(ctx context.Context) (*models.FileNode, error) {
-	var result *models.FileNode
+	resp, _, err := c.fetchTree(ctx, c.baseURL+"/api/v1/tree", "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Root, nil
+}
+
+// FetchMetadataIfChanged fetches the full metadata tree unless it still
+// matches etag (from an earlier call), in which case it returns
+// ErrNotModified. The returned etag is empty if the server sent none.
+func (c *Client) FetchMetadataIfChanged(ctx context.Context, etag string) (*models.FileNode, string, error) {
+	resp, newETag, err := c.fetchTree(ctx, c.baseURL+"/api/v1/tree", etag)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Root, newETag, nil
+}
+
+// TreeChanged asks the server whether the tree visible to this user may
+// have changed since etag was returned, without transferring it. It
+// returns the current etag; false means ErrNotModified would be returned
+// for any tree request with etag.
+func (c *Client) TreeChanged(ctx context.Context, etag string) (string, bool, error) {
+	_, newETag, err := c.fetchTree(ctx, c.baseURL+"/api/v1/tree?depth=0", etag)
+	if errors.Is(err, ErrNotModified) {
+		return etag, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return newETag, true, nil
+}
+
+// dirPageSize is the number of children requested per page by FetchDir.
+const dirPageSize = 5000
+
+// FetchDir fetches a directory and its immediate children, paging through
+// large directories. Subdirectories come without children but with their
+// ChildCount. partial is false when the server predates depth-limited
+// trees; the node then holds the whole subtree.
+func (c *Client) FetchDir(ctx context.Context, path string) (node *models.FileNode, partial bool, err error) {
+	for offset := 0; ; {
+		q := url.Values{}
+		q.Set("depth", "1")
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(dirPageSize))
+		resp, _, err := c.fetchTree(ctx, c.treeURL(path)+"?"+q.Encode(), "")
+		if err != nil {
+			return nil, false, err
+		}
+		if resp.Root == nil {
+			return nil, false, fmt.Errorf("empty tree response for %s", path)
+		}
+		if !resp.Partial {
+			return resp.Root, false, nil
+		}
+
+		if node == nil {
+			node = resp.Root
+		} else {
+			node.Children = append(node.Children, resp.Root.Children...)
+		}
+		offset += len(resp.Root.Children)
+		if len(resp.Root.Children) == 0 || offset >= resp.Root.ChildCount {
+			return node, true, nil
+		}
+	}
+}
 
+// FetchSubtree fetches the whole subtree below path.
+func (c *Client) FetchSubtree(ctx context.Context, path string) (*models.FileNode, error) {
+	resp, _, err := c.fetchTree(ctx, c.treeURL(path), "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Root, nil
+}
+
+// treeURL returns the tree endpoint URL for a server path.
+func (c *Client) treeURL(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return c.baseURL + "/api/v1/tree"
+	}
+	return c.baseURL + "/api/v1/tree/" + (&url.URL{Path: path}).EscapedPath()
+}
+
+// fetchTree GETs a tree endpoint URL, conditionally if etag is set, and
+// returns the response's ETag.
+func (c *Client) fetchTree(ctx context.Context, treeURL, etag string) (*protocol.TreeResponse, string, error) {
+	var result *protocol.TreeResponse
+	var newETag string
+
+	ctx, done := c.begin(ctx, "fetch tree", strings.TrimPrefix(treeURL, c.baseURL))
 	err := retry.Do(ctx, c.retryConfig, func() error {
-		req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/tree", nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", treeURL, nil)
 		if err != nil {
 			return err
 		}
 		req.Header.Set("Accept-Encoding", "gzip")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
 		c.applyAuth(req)
 
 		resp, err := c.httpClient.Do(req)
@@ -150,12 +340,18 @@ func (c *Client) FetchMetadata(ctx context.Context) (*models.FileNode, error) {
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode == http.StatusNotModified {
+			c.setOnline(true)
+			return ErrNotModified
+		}
 		if resp.StatusCode != http.StatusOK {
-			c.setOnline(false)
-			if resp.StatusCode >= 500 {
-				return retry.Retryable(fmt.Errorf("server error: %d", resp.StatusCode))
+			ae := readAPIError(resp)
+			if ae.transient() {
+				c.setOnline(false)
+				return retry.Retryable(ae)
 			}
-			return fmt.Errorf("server returned %d", resp.StatusCode)
+			c.setOnline(true)
+			return ae
 		}
 
 		c.setOnline(true)
@@ -175,19 +371,33 @@ func (c *Client) FetchMetadata(ctx context.Context) (*models.FileNode, error) {
 			return err
 		}
 
-		result = treeResp.Root
+		result = &treeResp
+		newETag = resp.Header.Get("ETag")
 		return nil
 	})
+	if errors.Is(err, ErrNotModified) {
+		done(nil)
+	} else {
+		done(err)
+	}
 
-	return result, err
+	return result, newETag, err
 }
 
-// FetchContent fetches file content with optional range.
+// FetchContent fetches file content with optional range. A whole file may
+// arrive compressed; the reader returns it decompressed, and the size is
+// then -1. A failure the server reported is an *APIError; see IsPermanent.
 func (c *Client) FetchContent(ctx context.Context, fileID string, offset, length int64) (io.ReadCloser, int64, error) {
+	return c.fetchContent(ctx, c.retryConfig, fileID, offset, length)
+}
+
+// fetchContent is FetchContent with its own retry policy.
+func (c *Client) fetchContent(ctx context.Context, cfg retry.Config, fileID string, offset, length int64) (io.ReadCloser, int64, error) {
 	var reader io.ReadCloser
 	var totalSize int64
 
-	err := retry.Do(ctx, c.retryConfig, func() error {
+	ctx, done := c.begin(ctx, "fetch content", fmt.Sprintf("%s [%d+%d]", fileID, offset, length))
+	err := retry.Do(ctx, cfg, func() error {
 		url := c.baseURL + "/api/v1/content/" + fileID
 		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
@@ -200,9 +410,11 @@ func (c *Client) FetchContent(ctx context.Context, fileID string, offset, length
 				end = fmt.Sprintf("%d", offset+length-1)
 			}
 			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", offset, end))
+			// Offsets refer to the stored bytes, not a compressed stream
+			req.Header.Set("Accept-Encoding", "identity")
+		} else {
+			req.Header.Set("Accept-Encoding", "gzip")
 		}
-
-		req.Header.Set("Accept-Encoding", "gzip")
 		c.applyAuth(req)
 
 		resp, err := c.httpClient.Do(req)
@@ -212,12 +424,17 @@ func (c *Client) FetchContent(ctx context.Context, fileID string, offset, length
 		}
 
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-			resp.Body.Close()
-			c.setOnline(false)
-			if resp.StatusCode >= 500 {
-				return retry.Retryable(fmt.Errorf("server error: %d", resp.StatusCode))
+			defer resp.Body.Close()
+			ae := readAPIError(resp)
+			if ae.transient() {
+				c.setOnline(false)
+				return retry.Retryable(ae)
 			}
-			return fmt.Errorf("server returned %d", resp.StatusCode)
+			c.setOnline(true)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				return retry.Retryable(ae)
+			}
+			return ae
 		}
 
 		c.setOnline(true)
@@ -233,12 +450,14 @@ func (c *Client) FetchContent(ctx context.Context, fileID string, offset, length
 				return err
 			}
 			reader = &gzipReadCloser{gr: gr, body: resp.Body}
+			totalSize = -1
 		} else {
 			reader = resp.Body
 		}
 
 		return nil
 	})
+	done(err)
 
 	return reader, totalSize, err
 }
@@ -248,24 +467,142 @@ func (c *Client) FetchContentFull(ctx context.Context, fileID string) (io.ReadCl
 	return c.FetchContent(ctx, fileID, 0, -1)
 }
 
+// FetchContentFrom fetches a file's content from offset to the end, to
+// resume an interrupted download. It returns the offset the content
+// actually starts at, which is 0 when the server sent the whole file
+// instead of the requested range.
+func (c *Client) FetchContentFrom(ctx context.Context, fileID string, offset int64) (io.ReadCloser, int64, error) {
+	var reader io.ReadCloser
+	start := offset
+
+	ctx, done := c.begin(ctx, "fetch content", fmt.Sprintf("%s from %d", fileID, offset))
+	err := retry.Do(ctx, c.retryConfig, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/content/"+fileID, nil)
+		if err != nil {
+			return err
+		}
+		if start > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+			// Offsets refer to the stored bytes, not a compressed stream
+			req.Header.Set("Accept-Encoding", "identity")
+		} else {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		c.applyAuth(req)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			c.setOnline(false)
+			return retry.Retryable(err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && start > 0:
+			// The file shrank since the partial download began: start over
+			resp.Body.Close()
+			start = 0
+			return retry.Retryable(errors.New("range not satisfiable"))
+		case resp.StatusCode == http.StatusOK:
+			start = 0
+		case resp.StatusCode == http.StatusPartialContent && start > 0:
+		default:
+			ae := readAPIError(resp)
+			resp.Body.Close()
+			if ae.transient() {
+				c.setOnline(false)
+				return retry.Retryable(ae)
+			}
+			c.setOnline(true)
+			return ae
+		}
+
+		c.setOnline(true)
+
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				resp.Body.Close()
+				return err
+			}
+			reader = &gzipReadCloser{gr: gr, body: resp.Body}
+		} else {
+			reader = resp.Body
+		}
+		return nil
+	})
+	done(err)
+
+	return reader, start, err
+}
+
 // ErrOffline is returned when the server is offline.
 var ErrOffline = errors.New("server is offline")
 
+// ErrNotModified is returned by conditional tree fetches when the tree
+// still matches the given etag.
+var ErrNotModified = errors.New("not modified")
+
 // FetchResult holds the result of a concurrent file fetch.
 type FetchResult struct {
 	FileID string
 	Reader io.ReadCloser
 	Size   int64
 	Err    error
+
+	// Permanent is set when Err will not go away by retrying, such as a
+	// missing file (404) or a denied one (403).
+	Permanent bool
+}
+
+// FetchOptions tune FetchContentConcurrentWith.
+type FetchOptions struct {
+	// MaxConcurrent caps the files fetched at once (default 10). It is
+	// lowered to the client's MaxConnsPerHost.
+	MaxConcurrent int
+
+	// Attempts is how often each file is tried on transient errors
+	// (server errors, 429, timeouts, dropped connections), with the
+	// client's backoff in between. 0 uses the client's RetryConfig.
+	Attempts int
+
+	// BytesPerSec caps the combined read rate of all returned readers
+	// (0 = unlimited).
+	BytesPerSec int64
 }
 
 // FetchContentConcurrent fetches multiple files concurrently.
 func (c *Client) FetchContentConcurrent(ctx context.Context, fileIDs []string, maxConcurrent int) <-chan FetchResult {
+	return c.FetchContentConcurrentWith(ctx, fileIDs, FetchOptions{MaxConcurrent: maxConcurrent})
+}
+
+// FetchContentConcurrentWith fetches multiple files concurrently,
+// retrying each on transient errors. Results are sent as files become
+// available; the caller must close every Reader.
+func (c *Client) FetchContentConcurrentWith(ctx context.Context, fileIDs []string, opts FetchOptions) <-chan FetchResult {
 	results := make(chan FetchResult, len(fileIDs))
 
+	maxConcurrent := opts.MaxConcurrent
 	if maxConcurrent <= 0 {
 		maxConcurrent = 10
 	}
+	if maxConcurrent > c.maxConnsPerHost {
+		maxConcurrent = c.maxConnsPerHost
+	}
+	cfg := c.retryConfig
+	if opts.Attempts > 0 {
+		cfg.MaxAttempts = opts.Attempts
+	}
+	var th *throttle
+	if opts.BytesPerSec > 0 {
+		th = &throttle{rate: opts.BytesPerSec}
+	}
+
+	// Each fetch runs under a child of one request ID for the whole batch
+	parent := RequestID(ctx)
+	if parent == "" {
+		parent = NewRequestID()
+	}
+	logger.Debug("[%s] fetch %d files", parent, len(fileIDs))
 
 	go func() {
 		defer close(results)
@@ -273,7 +610,7 @@ func (c *Client) FetchContentConcurrent(ctx context.Context, fileIDs []string, m
 		sem := make(chan struct{}, maxConcurrent)
 		var wg sync.WaitGroup
 
-		for _, fileID := range fileIDs {
+		for i, fileID := range fileIDs {
 			select {
 			case <-ctx.Done():
 				results <- FetchResult{FileID: fileID, Err: ctx.Err()}
@@ -284,18 +621,22 @@ func (c *Client) FetchContentConcurrent(ctx context.Context, fileIDs []string, m
 			wg.Add(1)
 			sem <- struct{}{}
 
-			go func(id string) {
+			go func(id, reqID string) {
 				defer wg.Done()
 				defer func() { <-sem }()
 
-				reader, size, err := c.FetchContentFull(ctx, id)
+				reader, size, err := c.fetchContent(WithRequestID(ctx, reqID), cfg, id, 0, -1)
+				if err == nil && th != nil {
+					reader = &throttledReader{ctx: ctx, rc: reader, t: th}
+				}
 				results <- FetchResult{
-					FileID: id,
-					Reader: reader,
-					Size:   size,
-					Err:    err,
+					FileID:    id,
+					Reader:    reader,
+					Size:      size,
+					Err:       err,
+					Permanent: IsPermanent(err),
 				}
-			}(fileID)
+			}(fileID, childRequestID(parent, i+1))
 		}
 
 		wg.Wait()
@@ -367,6 +708,11 @@ func (e *ConflictError) Error() string {
 		e.Path, e.ExpectedVersion, e.CurrentVersion)
 }
 
+// Is makes a ConflictError match ErrVersionConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
 // AsConflict checks if an error is a ConflictError and returns it.
 func AsConflict(err error) (*ConflictError, bool) {
 	var ce *ConflictError
@@ -376,12 +722,40 @@ func AsConflict(err error) (*ConflictError, bool) {
 	return nil, false
 }
 
+// ErrChecksumMismatch is returned when the server kept receiving an upload
+// that did not match the hash the client computed for it.
+var ErrChecksumMismatch = errors.New("upload corrupted in transit")
+
 // UploadFile uploads file content to the server.
 // If expectedVersion > 0, the X-Expected-Version header is sent for conflict detection.
+// If content is an io.ReadSeeker, its SHA-256 is sent in the X-Content-SHA256
+// header so that the server rejects a body corrupted in transit, and failed
+// attempts are retried from the original offset.
 func (c *Client) UploadFile(ctx context.Context, path string, content io.Reader, size int64, expectedVersion int) (*UploadResponse, error) {
 	var result *UploadResponse
 
+	rs, seekable := content.(io.ReadSeeker)
+	var start int64
+	var sum string
+	if seekable {
+		var err error
+		if start, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, io.LimitReader(rs, size)); err != nil {
+			return nil, fmt.Errorf("hash upload: %w", err)
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	ctx, done := c.begin(ctx, "upload", path)
 	err := retry.Do(ctx, c.retryConfig, func() error {
+		if seekable {
+			if _, err := rs.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
 		url := c.baseURL + "/api/v1/content/" + path
 		req, err := http.NewRequestWithContext(ctx, "POST", url, content)
 		if err != nil {
@@ -393,6 +767,9 @@ func (c *Client) UploadFile(ctx context.Context, path string, content io.Reader,
 		if expectedVersion > 0 {
 			req.Header.Set("X-Expected-Version", strconv.Itoa(expectedVersion))
 		}
+		if sum != "" {
+			req.Header.Set(protocol.HeaderContentSHA256, sum)
+		}
 		c.applyAuth(req)
 
 		resp, err := c.httpClient.Do(req)
@@ -417,17 +794,31 @@ func (c *Client) UploadFile(ctx context.Context, path string, content io.Reader,
 			return &ConflictError{Path: path, ExpectedVersion: expectedVersion}
 		}
 
+		// The body arrived damaged; nothing was stored, so send it again
+		if resp.StatusCode == http.StatusUnprocessableEntity && sum != "" {
+			c.setOnline(true)
+			var cm protocol.ChecksumMismatchResponse
+			json.NewDecoder(resp.Body).Decode(&cm)
+			return retry.Retryable(fmt.Errorf("%w: %s: sent %s, server received %s",
+				ErrChecksumMismatch, path, sum, cm.ActualSHA256))
+		}
+
 		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-			c.setOnline(false)
-			if resp.StatusCode >= 500 {
-				return retry.Retryable(fmt.Errorf("server error: %d", resp.StatusCode))
+			ae := readAPIError(resp)
+			if ae.transient() {
+				c.setOnline(false)
+				return retry.Retryable(ae)
 			}
-			// Try to read error message
-			var errResp protocol.ErrorResponse
-			if json.NewDecoder(resp.Body).Decode(&errResp) == nil {
-				return fmt.Errorf("upload failed: %s", errResp.Error)
+			c.setOnline(true)
+			if resp.StatusCode == http.StatusUnauthorized {
+				// The token was refreshed but the used-up body could not
+				// be sent again; the next attempt uses the new token
+				if seekable && c.AuthFailed() == nil {
+					return retry.Retryable(ae)
+				}
+				return ae
 			}
-			return fmt.Errorf("upload failed: %d", resp.StatusCode)
+			return fmt.Errorf("upload failed: %w", ae)
 		}
 
 		c.setOnline(true)
@@ -439,12 +830,14 @@ func (c *Client) UploadFile(ctx context.Context, path string, content io.Reader,
 
 		return nil
 	})
+	done(err)
 
 	return result, err
 }
 
 // CreateDirectory creates a directory on the server.
 func (c *Client) CreateDirectory(ctx context.Context, path string) error {
+	ctx, done := c.begin(ctx, "mkdir", path)
 	err := retry.Do(ctx, c.retryConfig, func() error {
 		url := c.baseURL + "/api/v1/tree/" + path + "?type=dir"
 		req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
@@ -461,26 +854,26 @@ func (c *Client) CreateDirectory(ctx context.Context, path string) error {
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-			c.setOnline(false)
-			if resp.StatusCode >= 500 {
-				return retry.Retryable(fmt.Errorf("server error: %d", resp.StatusCode))
-			}
-			var errResp protocol.ErrorResponse
-			if json.NewDecoder(resp.Body).Decode(&errResp) == nil {
-				return fmt.Errorf("mkdir failed: %s", errResp.Error)
+			ae := readAPIError(resp)
+			if ae.transient() {
+				c.setOnline(false)
+				return retry.Retryable(ae)
 			}
-			return fmt.Errorf("mkdir failed: %d", resp.StatusCode)
+			c.setOnline(true)
+			return fmt.Errorf("mkdir failed: %w", ae)
 		}
 
 		c.setOnline(true)
 		return nil
 	})
+	done(err)
 
 	return err
 }
 
 // DeletePath deletes a file or directory on the server.
 func (c *Client) DeletePath(ctx context.Context, path string) error {
+	ctx, done := c.begin(ctx, "delete", path)
 	err := retry.Do(ctx, c.retryConfig, func() error {
 		url := c.baseURL + "/api/v1/tree/" + path
 		req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
@@ -497,23 +890,23 @@ func (c *Client) DeletePath(ctx context.Context, path string) error {
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			c.setOnline(false)
 			if resp.StatusCode == http.StatusNotFound {
+				c.setOnline(true)
 				return nil // Already deleted
 			}
-			if resp.StatusCode >= 500 {
-				return retry.Retryable(fmt.Errorf("server error: %d", resp.StatusCode))
+			ae := readAPIError(resp)
+			if ae.transient() {
+				c.setOnline(false)
+				return retry.Retryable(ae)
 			}
-			var errResp protocol.ErrorResponse
-			if json.NewDecoder(resp.Body).Decode(&errResp) == nil {
-				return fmt.Errorf("delete failed: %s", errResp.Error)
-			}
-			return fmt.Errorf("delete failed: %d", resp.StatusCode)
+			c.setOnline(true)
+			return fmt.Errorf("delete failed: %w", ae)
 		}
 
 		c.setOnline(true)
 		return nil
 	})
+	done(err)
 
 	return err
 }
@@ -1,11 +1,18 @@
 package client
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -137,6 +144,71 @@ func TestUploadFile_ServerError_Retry(t *testing.T) {
 	}
 }
 
+func TestUploadFile_Checksum(t *testing.T) {
+	var attempts atomic.Int32
+	var gotSum string
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSum = r.Header.Get(protocol.HeaderContentSHA256)
+		actual := fmt.Sprintf("%x", sha256.Sum256(body))
+		// Corrupt the first attempt in transit
+		if attempts.Add(1) == 1 || actual != gotSum {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(protocol.ChecksumMismatchResponse{ExpectedSHA256: gotSum, ActualSHA256: "bad"})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"path": "/test.txt", "size": len(body), "hash": actual, "version": 1})
+	}))
+	defer ts.Close()
+
+	resp, err := c.UploadFile(context.Background(), "test.txt", strings.NewReader("hello"), 5, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("hello"))); gotSum != want || resp.Hash != want {
+		t.Errorf("sent %s, stored %s, want %s", gotSum, resp.Hash, want)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestUploadFile_ChecksumKeepsFailing(t *testing.T) {
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(protocol.ChecksumMismatchResponse{ActualSHA256: "bad"})
+	}))
+	defer ts.Close()
+
+	_, err := c.UploadFile(context.Background(), "test.txt", strings.NewReader("hello"), 5, 0)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestUploadFile_NoChecksumForStreams(t *testing.T) {
+	var gotSum string
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSum = r.Header.Get(protocol.HeaderContentSHA256)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"path": "/test.txt", "size": 5, "version": 1})
+	}))
+	defer ts.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("hello"))
+		pw.Close()
+	}()
+	if _, err := c.UploadFile(context.Background(), "test.txt", pr, 5, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSum != "" {
+		t.Errorf("sent %s = %q for a stream that cannot be hashed up front", protocol.HeaderContentSHA256, gotSum)
+	}
+}
+
 func TestUploadFile_ConflictNotRetried(t *testing.T) {
 	var attempts atomic.Int32
 	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -181,3 +253,328 @@ func TestOnlineStatusAfterConflict(t *testing.T) {
 		t.Error("client should remain online after a 409 conflict")
 	}
 }
+
+func TestPing_UpgradeRequired(t *testing.T) {
+	var gotUA string
+	var reject atomic.Bool
+	reject.Store(true)
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+		if reject.Load() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUpgradeRequired)
+			json.NewEncoder(w).Encode(protocol.UpgradeRequiredResponse{
+				Error:            "client upgrade required",
+				Code:             http.StatusUpgradeRequired,
+				ClientVersion:    "1.0.0",
+				MinClientVersion: "1.2.0",
+				ServerVersion:    "1.3.0",
+			})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	err := c.Ping(context.Background())
+	ue, ok := AsUpgradeRequired(err)
+	if !ok {
+		t.Fatalf("expected UpgradeRequiredError, got %v", err)
+	}
+	if ue.MinClientVersion != "1.2.0" || ue.ServerVersion != "1.3.0" {
+		t.Errorf("unexpected error fields: %+v", ue)
+	}
+	if c.UpgradeRequired() == nil {
+		t.Error("expected client to remember upgrade-required state")
+	}
+	if gotUA != UserAgent {
+		t.Errorf("expected User-Agent %q, got %q", UserAgent, gotUA)
+	}
+
+	reject.Store(false)
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.UpgradeRequired() != nil {
+		t.Error("expected upgrade-required state to clear")
+	}
+}
+
+func TestFetchContentFrom(t *testing.T) {
+	content := "hello, resumable world"
+	ignoreRange := false
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRange {
+			w.Write([]byte(content))
+			return
+		}
+		http.ServeContent(w, r, "f.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	read := func(offset int64) (string, int64) {
+		t.Helper()
+		rc, start, err := c.FetchContentFrom(context.Background(), "f.txt", offset)
+		if err != nil {
+			t.Fatalf("FetchContentFrom(%d): %v", offset, err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), start
+	}
+
+	if got, start := read(7); got != content[7:] || start != 7 {
+		t.Errorf("resume at 7 = %q from %d, want %q from 7", got, start, content[7:])
+	}
+	// Past the end: the file shrank, so it starts over
+	if got, start := read(100); got != content || start != 0 {
+		t.Errorf("resume past end = %q from %d, want whole file from 0", got, start)
+	}
+	ignoreRange = true
+	if got, start := read(7); got != content || start != 0 {
+		t.Errorf("range ignored = %q from %d, want whole file from 0", got, start)
+	}
+}
+
+func TestFetchContentCompressed(t *testing.T) {
+	content := strings.Repeat("compressible text ", 100)
+	var encodings []string
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		if r.Header.Get("Range") != "" {
+			http.ServeContent(w, r, "f.txt", time.Time{}, strings.NewReader(content))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(content))
+		gw.Close()
+	}))
+	defer ts.Close()
+
+	rc, size, err := c.FetchContentFull(context.Background(), "f.txt")
+	if err != nil {
+		t.Fatalf("FetchContentFull: %v", err)
+	}
+	h := sha256.New()
+	io.Copy(h, rc)
+	rc.Close()
+	// The hash is taken over the decompressed bytes
+	if got, want := fmt.Sprintf("%x", h.Sum(nil)), fmt.Sprintf("%x", sha256.Sum256([]byte(content))); got != want {
+		t.Errorf("hash = %s, want %s", got, want)
+	}
+	if size != -1 {
+		t.Errorf("size = %d, want -1 for a compressed response", size)
+	}
+
+	rc, _, err = c.FetchContent(context.Background(), "f.txt", 10, 5)
+	if err != nil {
+		t.Fatalf("FetchContent: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != content[10:15] {
+		t.Errorf("range = %q, want %q", data, content[10:15])
+	}
+
+	// Only whole-file fetches advertise gzip
+	if want := []string{"gzip", "identity"}; strings.Join(encodings, ",") != strings.Join(want, ",") {
+		t.Errorf("Accept-Encoding = %q, want %q", encodings, want)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	var attempts atomic.Int32
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		if r.Method == "POST" && attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"path": "/a.txt", "version": 1}`))
+	}))
+	defer ts.Close()
+
+	// Retries of an operation reuse its ID
+	if _, err := c.UploadFile(context.Background(), "a.txt", strings.NewReader("a"), 1, 0); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if len(ids) != 2 || ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("upload request IDs = %q, want one ID for both attempts", ids)
+	}
+
+	// A caller's ID is used as is
+	ids = nil
+	c.DeletePath(WithRequestID(context.Background(), "sync-42"), "a.txt")
+	if len(ids) != 1 || ids[0] != "sync-42" {
+		t.Errorf("delete request IDs = %q, want [sync-42]", ids)
+	}
+
+	// Concurrent fetches get child IDs of the caller's ID
+	ids = nil
+	for res := range c.FetchContentConcurrent(WithRequestID(context.Background(), "batch"), []string{"a", "b", "c"}, 2) {
+		if res.Reader != nil {
+			res.Reader.Close()
+		}
+	}
+	sort.Strings(ids)
+	if want := []string{"batch.1", "batch.2", "batch.3"}; strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("concurrent fetch IDs = %q, want %q", ids, want)
+	}
+}
+
+func TestFetchContentConcurrent_Retries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/content/")
+		mu.Lock()
+		attempts[id]++
+		n := attempts[id]
+		mu.Unlock()
+		switch {
+		case id == "flaky" && n < 3:
+			w.WriteHeader(http.StatusBadGateway)
+		case id == "busy" && n < 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case id == "reset" && n < 2:
+			// Drop the connection without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case id == "missing":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(protocol.ErrorResponse{Error: "file not found"})
+		case id == "denied":
+			w.WriteHeader(http.StatusForbidden)
+		case id == "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("content of " + id))
+		}
+	}))
+	defer ts.Close()
+
+	ids := []string{"ok", "flaky", "busy", "reset", "missing", "denied", "down"}
+	results := map[string]FetchResult{}
+	for res := range c.FetchContentConcurrentWith(context.Background(), ids, FetchOptions{MaxConcurrent: 4, Attempts: 4}) {
+		if res.Reader != nil {
+			data, _ := io.ReadAll(res.Reader)
+			res.Reader.Close()
+			if string(data) != "content of "+res.FileID {
+				t.Errorf("%s: content %q", res.FileID, data)
+			}
+		}
+		results[res.FileID] = res
+	}
+
+	for _, id := range []string{"ok", "flaky", "busy", "reset"} {
+		if err := results[id].Err; err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+	for _, id := range []string{"missing", "denied"} {
+		if res := results[id]; res.Err == nil || !res.Permanent {
+			t.Errorf("%s: err %v, permanent %v; want a permanent failure", id, res.Err, res.Permanent)
+		}
+	}
+	if !IsNotFound(results["missing"].Err) || !IsForbidden(results["denied"].Err) {
+		t.Errorf("status lost: %v, %v", results["missing"].Err, results["denied"].Err)
+	}
+	if res := results["down"]; res.Err == nil || res.Permanent {
+		t.Errorf("down: err %v, permanent %v; want a transient failure", res.Err, res.Permanent)
+	}
+
+	want := map[string]int{"ok": 1, "flaky": 3, "busy": 2, "reset": 2, "missing": 1, "denied": 1, "down": 4}
+	for id, n := range want {
+		if attempts[id] != n {
+			t.Errorf("%s: %d attempts, want %d", id, attempts[id], n)
+		}
+	}
+}
+
+func TestFetchContentConcurrent_ConnLimit(t *testing.T) {
+	var active, peak atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("x"))
+	}))
+	defer ts.Close()
+	c := New(Config{BaseURL: ts.URL, MaxConnsPerHost: 3})
+
+	ids := make([]string, 12)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+	for res := range c.FetchContentConcurrent(context.Background(), ids, 20) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		res.Reader.Close()
+	}
+	if p := peak.Load(); p > 3 {
+		t.Errorf("%d requests at once, want at most 3", p)
+	}
+}
+
+func TestFetchContentConcurrent_Throttle(t *testing.T) {
+	payload := strings.Repeat("x", 1000)
+	c, ts := testClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer ts.Close()
+
+	// 4000 bytes at 20000 bytes/s take at least 200ms
+	start := time.Now()
+	var total int
+	for res := range c.FetchContentConcurrentWith(context.Background(), []string{"a", "b", "c", "d"}, FetchOptions{BytesPerSec: 20000}) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		data, _ := io.ReadAll(res.Reader)
+		res.Reader.Close()
+		total += len(data)
+	}
+	if total != 4000 {
+		t.Fatalf("read %d bytes, want 4000", total)
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("read 4000 bytes in %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("connection reset"), false},
+		{&APIError{StatusCode: http.StatusNotFound}, true},
+		{&APIError{StatusCode: http.StatusForbidden}, true},
+		{fmt.Errorf("fetch: %w", &APIError{StatusCode: http.StatusUnauthorized}), true},
+		{&APIError{StatusCode: http.StatusTooManyRequests}, false},
+		{&APIError{StatusCode: http.StatusRequestTimeout}, false},
+		{retry.Retryable(&APIError{StatusCode: http.StatusBadGateway}), false},
+	}
+	for _, tt := range tests {
+		if got := IsPermanent(tt.err); got != tt.want {
+			t.Errorf("IsPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+)
+
+// A 401 Unauthorized means the server refused the token, not that it is
+// unreachable: the client refreshes the token once and sends the request
+// again with the new one. If the refresh is refused too, AuthFailed
+// reports it until a new token is installed. Concurrent 401s share one
+// refresh.
+
+type noReauthKey struct{}
+
+// withoutReauth marks requests whose 401 must not trigger a refresh, such
+// as the refresh itself.
+func withoutReauth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noReauthKey{}, true)
+}
+
+// authTransport retries a request refused with 401 once with a refreshed
+// token.
+type authTransport struct {
+	base   http.RoundTripper
+	client *Client
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	stale, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || req.Context().Value(noReauthKey{}) != nil {
+		return resp, nil
+	}
+	token, err := t.client.reauthenticate(req.Context(), stale)
+	if err != nil {
+		return resp, nil
+	}
+	// A body that cannot be replayed was used up: the caller gets the
+	// 401, and its next attempt uses the new token
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+// OnTokenRefreshed registers fn to be called with every token the client
+// gets by refreshing a rejected or expiring one, for example to hand it to
+// an SSEClient. fn must not call back into the refresh.
+func (c *Client) OnTokenRefreshed(fn func(*RefreshResponse)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshed = append(c.refreshed, fn)
+}
+
+// OnAuthRejected registers fn to be called when a refresh after a 401 is
+// refused as well: the user has to log in again.
+func (c *Client) OnAuthRejected(fn func(error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejected = append(c.rejected, fn)
+}
+
+// Reauthenticate replaces stale, a token the server rejected or is about
+// to, by refreshing it. If the client already moved on to another token,
+// or authenticates with an API key, there is nothing to do.
+func (c *Client) Reauthenticate(ctx context.Context, stale string) error {
+	c.mu.RLock()
+	apiKey := c.apiKey
+	c.mu.RUnlock()
+	if apiKey != "" || stale == "" {
+		return nil
+	}
+	_, err := c.reauthenticate(ctx, stale)
+	return err
+}
+
+// reauthenticate returns the token to use instead of stale, refreshing
+// stale unless another caller already did.
+func (c *Client) reauthenticate(ctx context.Context, stale string) (string, error) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.RLock()
+	current, authErr := c.authToken, c.authErr
+	c.mu.RUnlock()
+	if current != stale && current != "" {
+		return current, nil
+	}
+	if authErr != nil {
+		return "", authErr
+	}
+
+	logger.Info("Refreshing token...")
+	resp, err := c.RefreshToken(ctx)
+	if err != nil {
+		logger.Error("Token refresh failed: %v", err)
+		if errors.Is(err, ErrAuthRejected) {
+			c.setAuthFailed(err)
+			c.mu.RLock()
+			listeners := c.rejected
+			c.mu.RUnlock()
+			for _, fn := range listeners {
+				fn(err)
+			}
+		}
+		return "", err
+	}
+
+	c.mu.RLock()
+	listeners := c.refreshed
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(resp)
+	}
+	return resp.Token, nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+)
+
+// Every operation (tree fetch, content fetch, upload, ...) runs under a
+// request ID sent as X-Request-ID, so that client debug logs, server logs
+// and activity log entries of the same operation can be matched up. Retries
+// of an operation reuse its ID.
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random 16-character hex request ID.
+func NewRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// WithRequestID returns a context whose requests are sent with id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// childRequestID returns the ID of the n-th (from 1) sub-request of the
+// operation with ID parent.
+func childRequestID(parent string, n int) string {
+	return fmt.Sprintf("%s.%d", parent, n)
+}
+
+// begin starts an operation: it gives ctx a request ID unless the caller
+// already set one and logs the operation at debug level. The returned
+// function logs the outcome.
+func (c *Client) begin(ctx context.Context, op, target string) (context.Context, func(error)) {
+	id := RequestID(ctx)
+	if id == "" {
+		id = NewRequestID()
+		ctx = WithRequestID(ctx, id)
+	}
+	start := time.Now()
+	logger.Debug("[%s] %s %s", id, op, target)
+	return ctx, func(err error) {
+		if err != nil {
+			logger.Debug("[%s] %s %s failed after %v: %v", id, op, target, time.Since(start), err)
+			return
+		}
+		logger.Debug("[%s] %s %s done in %v", id, op, target, time.Since(start))
+	}
+}
+
+// setRequestID sends the request ID of req's context, if any.
+func setRequestID(req *http.Request) {
+	if id := RequestID(req.Context()); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+}
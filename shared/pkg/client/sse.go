@@ -4,21 +4,57 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/websocket"
+)
+
+// Event transports.
+const (
+	TransportAuto = "auto" // SSE, switching to WebSocket when SSE keeps failing
+	TransportSSE  = "sse"
+	TransportWS   = "ws"
+)
+
+// ValidTransport reports whether t is a known event transport.
+func ValidTransport(t string) bool {
+	switch t {
+	case TransportAuto, TransportSSE, TransportWS:
+		return true
+	}
+	return false
+}
+
+const (
+	// autoSwitchAfter is how many connection attempts in a row may fail in
+	// auto mode before the other transport is tried.
+	autoSwitchAfter = 3
+
+	// sseFirstDataTimeout bounds the wait for the stream preamble in auto
+	// mode. The server sends it right away, so a silent stream is most
+	// likely held back by a buffering proxy.
+	sseFirstDataTimeout = 15 * time.Second
+
+	// wsIdleTimeout drops a WebSocket that has not even sent a ping for
+	// this long; the server pings every 30 seconds.
+	wsIdleTimeout = 75 * time.Second
 )
 
 // SSEEvent represents a Server-Sent Event.
 type SSEEvent struct {
-	Type string          `json:"type"`
-	Path string          `json:"path"`
-	Time int64           `json:"time"`
-	Raw  json.RawMessage `json:"-"`
+	protocol.Event
+	Raw json.RawMessage `json:"-"`
 }
 
 // SSEClient handles Server-Sent Events from the server.
@@ -29,6 +65,13 @@ type SSEClient struct {
 	reconnectMax time.Duration
 	mu           sync.RWMutex
 	authToken    string
+	apiKey       string
+	transport    string
+	reconnects   atomic.Int64
+	lastEventID  atomic.Uint64
+	connects     atomic.Int64
+	onReconnect  func()
+	onReauth     func(ctx context.Context, token string) error
 }
 
 // NewSSEClient creates a new SSE client.
@@ -40,9 +83,18 @@ func NewSSEClient(baseURL string) *SSEClient {
 		},
 		reconnectMin: 1 * time.Second,
 		reconnectMax: 30 * time.Second,
+		transport:    TransportAuto,
 	}
 }
 
+// SetTransport selects how Subscribe receives events: TransportAuto (the
+// default), TransportSSE or TransportWS.
+func (c *SSEClient) SetTransport(transport string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transport = transport
+}
+
 // SetAuthToken sets the JWT auth token for SSE requests.
 func (c *SSEClient) SetAuthToken(token string) {
 	c.mu.Lock()
@@ -50,21 +102,104 @@ func (c *SSEClient) SetAuthToken(token string) {
 	c.authToken = token
 }
 
-// Subscribe connects to the SSE endpoint and returns a channel of events.
+// SetAPIKey sets an API key for SSE requests; it takes precedence over the
+// JWT auth token.
+func (c *SSEClient) SetAPIKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = key
+}
+
+// SetReconnectHandler sets fn to be called whenever the stream is open
+// again after it was lost. Events sent while it was down may be missing,
+// so the caller should resynchronise. fn runs on the subscription's
+// goroutine and must not block.
+func (c *SSEClient) SetReconnectHandler(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnect = fn
+}
+
+// SetReauthHandler sets fn to be called when the server refuses the
+// stream's token with 401 or ends the stream with a reauth event because
+// the token is about to expire or its session was revoked. fn gets the
+// token the stream used; it should obtain a new one and install it with
+// SetAuthToken, as Client.Reauthenticate does together with
+// Client.OnTokenRefreshed. The stream reconnects once fn returns.
+func (c *SSEClient) SetReauthHandler(fn func(ctx context.Context, token string) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReauth = fn
+}
+
+// reauth calls the reauth handler, if any, for the token a stream used.
+func (c *SSEClient) reauth(ctx context.Context, token string) error {
+	c.mu.RLock()
+	fn := c.onReauth
+	c.mu.RUnlock()
+	if fn == nil || token == "" {
+		return nil
+	}
+	return fn(ctx, token)
+}
+
+// connected is called whenever a stream opens; every one after the first
+// is a reconnect.
+func (c *SSEClient) connected() {
+	if c.connects.Add(1) == 1 {
+		return
+	}
+	c.mu.RLock()
+	fn := c.onReconnect
+	c.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// Reconnects returns how often the stream was re-established after a
+// connection error.
+func (c *SSEClient) Reconnects() int64 {
+	return c.reconnects.Load()
+}
+
+// LastEventID returns the ID of the last event received, which is sent
+// as Last-Event-ID on reconnect so the server replays missed events.
+func (c *SSEClient) LastEventID() uint64 {
+	return c.lastEventID.Load()
+}
+
+// Subscribe connects to the event stream over the configured transport and
+// returns a channel of events.
 func (c *SSEClient) Subscribe(ctx context.Context) (<-chan SSEEvent, <-chan error) {
+	c.mu.RLock()
+	transport := c.transport
+	c.mu.RUnlock()
+	return c.watch(ctx, transport)
+}
+
+// WatchWS is Subscribe over the WebSocket endpoint only, for networks
+// whose proxies buffer SSE responses.
+func (c *SSEClient) WatchWS(ctx context.Context) (<-chan SSEEvent, <-chan error) {
+	return c.watch(ctx, TransportWS)
+}
+
+func (c *SSEClient) watch(ctx context.Context, transport string) (<-chan SSEEvent, <-chan error) {
 	events := make(chan SSEEvent, 100)
 	errors := make(chan error, 1)
 
-	go c.subscribeLoop(ctx, events, errors)
+	go c.subscribeLoop(ctx, transport, events, errors)
 
 	return events, errors
 }
 
-func (c *SSEClient) subscribeLoop(ctx context.Context, events chan<- SSEEvent, errors chan<- error) {
+func (c *SSEClient) subscribeLoop(ctx context.Context, transport string, events chan<- SSEEvent, errors chan<- error) {
 	defer close(events)
 	defer close(errors)
 
 	reconnectDelay := c.reconnectMin
+	useWS := transport == TransportWS
+	failures := 0
 
 	for {
 		select {
@@ -73,24 +208,44 @@ func (c *SSEClient) subscribeLoop(ctx context.Context, events chan<- SSEEvent, e
 		default:
 		}
 
-		err := c.connect(ctx, events)
+		var established bool
+		var err error
+		if useWS {
+			established, err = c.connectWS(ctx, events)
+		} else {
+			established, err = c.connect(ctx, events, transport == TransportAuto)
+		}
+		if established {
+			failures = 0
+		}
 		if err != nil {
 			if ctx.Err() != nil {
 				return
 			}
 
-			logger.Error("SSE connection error: %v (reconnecting in %s)", err, reconnectDelay)
+			wait := jitter(reconnectDelay)
+			logger.Error("%s connection error: %v (reconnecting in %s)", transportName(useWS), err, wait.Round(time.Millisecond))
+
+			if !established {
+				failures++
+			}
+			if transport == TransportAuto && failures >= autoSwitchAfter {
+				useWS = !useWS
+				failures = 0
+				logger.Info("Event stream keeps failing, switching to %s", transportName(useWS))
+			}
 
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(reconnectDelay):
+			case <-time.After(wait):
 			}
 
 			reconnectDelay *= 2
 			if reconnectDelay > c.reconnectMax {
 				reconnectDelay = c.reconnectMax
 			}
+			c.reconnects.Add(1)
 			continue
 		}
 
@@ -98,81 +253,271 @@ func (c *SSEClient) subscribeLoop(ctx context.Context, events chan<- SSEEvent, e
 	}
 }
 
-func (c *SSEClient) connect(ctx context.Context, events chan<- SSEEvent) error {
+// jitter spreads d by ±20% so that clients cut off by the same server
+// restart do not all reconnect at the same moment.
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+func transportName(ws bool) string {
+	if ws {
+		return "WebSocket"
+	}
+	return "SSE"
+}
+
+// connect reads the SSE stream until it ends. established reports whether
+// the stream delivered anything; with firstDataTimeout, a stream that stays
+// silent for sseFirstDataTimeout is given up.
+func (c *SSEClient) connect(ctx context.Context, events chan<- SSEEvent, firstDataTimeout bool) (established bool, err error) {
 	url := c.baseURL + "/api/v1/events"
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var silent atomic.Bool
+	if firstDataTimeout {
+		timer := time.AfterFunc(sseFirstDataTimeout, func() {
+			silent.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
+		defer func() {
+			if silent.Load() && ctx.Err() == nil {
+				established, err = false, fmt.Errorf("no data within %s (buffering proxy?)", sseFirstDataTimeout)
+			}
+		}()
+	}
+
+	req, err := http.NewRequestWithContext(connCtx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return false, fmt.Errorf("create request: %w", err)
 	}
 
+	req.Header.Set("User-Agent", UserAgent)
 	req.Header.Set("Accept", "text/event-stream")
 	req.Header.Set("Cache-Control", "no-cache")
 	c.mu.RLock()
 	token := c.authToken
+	apiKey := c.apiKey
 	c.mu.RUnlock()
-	if token != "" {
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	} else if token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
 	}
+	if id := c.lastEventID.Load(); id != 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(id, 10))
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("connect: %w", err)
+		return false, fmt.Errorf("connect: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return false, parseUpgradeRequired(body)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && apiKey == "" {
+		return false, c.unauthorized(ctx, token)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned %d", resp.StatusCode)
+		return false, fmt.Errorf("server returned %d", resp.StatusCode)
 	}
 
 	logger.Info("SSE connected to %s", url)
 
 	scanner := bufio.NewScanner(resp.Body)
 	var eventType string
+	var eventID uint64
 	var data string
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		if !established {
+			established = !silent.Load()
+			if established {
+				c.connected()
+			}
+		}
 
 		select {
 		case <-ctx.Done():
-			return nil
+			return established, nil
 		default:
 		}
 
 		if line == "" {
 			if data != "" {
-				event := SSEEvent{
-					Type: eventType,
-					Raw:  json.RawMessage(data),
-				}
-				json.Unmarshal([]byte(data), &event)
-
-				select {
-				case events <- event:
-				default:
-					logger.Debug("SSE event dropped (channel full)")
+				switch c.dispatch(eventType, eventID, data, events) {
+				case protocol.EventShutdown:
+					// The instance is going away; reconnect right away
+					// without backing off so a new instance takes over.
+					logger.Info("SSE server is shutting down, reconnecting")
+					return true, nil
+				case protocol.EventReauth:
+					return true, c.reauthenticate(ctx, token)
 				}
 			}
 			eventType = ""
+			eventID = 0
 			data = ""
 			continue
 		}
 
 		if strings.HasPrefix(line, ":") {
+			checkStreamSchema(line)
 			continue
 		}
 
 		if strings.HasPrefix(line, "event:") {
 			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		} else if strings.HasPrefix(line, "id:") {
+			eventID, _ = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "id:")), 10, 64)
 		} else if strings.HasPrefix(line, "data:") {
 			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("read: %w", err)
+		return established, fmt.Errorf("read: %w", err)
+	}
+
+	return established, fmt.Errorf("connection closed")
+}
+
+// connectWS reads events from the WebSocket endpoint until the connection
+// ends. established reports whether the handshake succeeded.
+func (c *SSEClient) connectWS(ctx context.Context, events chan<- SSEEvent) (bool, error) {
+	url := c.baseURL + "/api/v1/ws"
+
+	header := http.Header{}
+	header.Set("User-Agent", UserAgent)
+	c.mu.RLock()
+	token := c.authToken
+	apiKey := c.apiKey
+	c.mu.RUnlock()
+	if apiKey != "" {
+		header.Set("X-API-Key", apiKey)
+	} else if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	if id := c.lastEventID.Load(); id != 0 {
+		header.Set("Last-Event-ID", strconv.FormatUint(id, 10))
+	}
+
+	conn, resp, err := websocket.Dial(ctx, url, header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode == http.StatusUpgradeRequired {
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+				return false, parseUpgradeRequired(body)
+			}
+			if resp.StatusCode == http.StatusUnauthorized && apiKey == "" {
+				return false, c.unauthorized(ctx, token)
+			}
+		}
+		return false, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+	conn.SetIdleTimeout(wsIdleTimeout)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	logger.Info("WebSocket connected to %s", url)
+	c.connected()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return true, nil
+			}
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) && ce.Code == websocket.CloseGoingAway {
+				// Same as the SSE shutdown event: reconnect right away
+				logger.Info("WebSocket server is shutting down, reconnecting")
+				return true, nil
+			}
+			return true, fmt.Errorf("read: %w", err)
+		}
+		if c.dispatch("", 0, string(data), events) == protocol.EventReauth {
+			return true, c.reauthenticate(ctx, token)
+		}
+	}
+}
+
+// reauthenticate handles a reauth event: the stream ends, and once the
+// handler got a new token it reconnects right away. A nil error skips the
+// reconnect backoff.
+func (c *SSEClient) reauthenticate(ctx context.Context, token string) error {
+	logger.Info("Event stream ended for a new token, reconnecting")
+	if err := c.reauth(ctx, token); err != nil {
+		return fmt.Errorf("reauthenticate: %w", err)
+	}
+	return nil
+}
+
+// unauthorized handles a stream refused with 401: the handler refreshes
+// the token, and the stream reconnects after the usual backoff.
+func (c *SSEClient) unauthorized(ctx context.Context, token string) error {
+	if err := c.reauth(ctx, token); err != nil {
+		return fmt.Errorf("token rejected: %w", err)
+	}
+	return errors.New("token rejected, retrying with a new one")
+}
+
+// dispatch parses one event and forwards it. Malformed events and types
+// newer than this client are skipped so consumers only see known types.
+// id is the SSE "id:" line, if any; otherwise the payload's id is used.
+// It returns the type of a known event, "" otherwise.
+func (c *SSEClient) dispatch(name string, id uint64, data string, events chan<- SSEEvent) string {
+	ev, err := protocol.ParseEvent(name, []byte(data))
+	if ev != nil {
+		if id == 0 {
+			id = ev.ID
+		}
+		if id != 0 {
+			// Also for skipped events: they need no replay either
+			c.lastEventID.Store(id)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, protocol.ErrUnknownEventType) {
+			logger.Debug("SSE event ignored: %v", err)
+		} else {
+			logger.Error("SSE event invalid: %v", err)
+		}
+		return ""
 	}
+	if ev.Schema > protocol.EventSchemaVersion {
+		logger.Debug("SSE event uses schema %d (client supports %d)", ev.Schema, protocol.EventSchemaVersion)
+	}
+
+	select {
+	case events <- SSEEvent{Event: *ev, Raw: json.RawMessage(data)}:
+	default:
+		logger.Debug("SSE event dropped (channel full)")
+	}
+	return ev.Type
+}
 
-	return fmt.Errorf("connection closed")
+// checkStreamSchema logs when the stream preamble announces a newer event
+// schema than this client was built for.
+func checkStreamSchema(comment string) {
+	const marker = "fruitsalade-events schema="
+	idx := strings.Index(comment, marker)
+	if idx < 0 {
+		return
+	}
+	var schema int
+	if _, err := fmt.Sscanf(comment[idx+len(marker):], "%d", &schema); err != nil {
+		return
+	}
+	if schema > protocol.EventSchemaVersion {
+		logger.Info("Server event schema %d is newer than this client's (%d); unknown events will be ignored",
+			schema, protocol.EventSchemaVersion)
+	}
 }
@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/websocket"
+)
+
+func TestSSE_SkipsUnknownAndInvalidEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", protocol.EventStreamPreamble)
+		// Legacy v1 payload (no schema field)
+		fmt.Fprint(w, "event: create\ndata: {\"type\":\"create\",\"path\":\"/a.txt\",\"timestamp\":1}\n\n")
+		// Type from a future server
+		fmt.Fprint(w, "event: share_created\ndata: {\"type\":\"share_created\",\"path\":\"/a.txt\",\"timestamp\":2}\n\n")
+		// Malformed
+		fmt.Fprint(w, "event: modify\ndata: {not json}\n\n")
+		// Current job event with an unknown extra field
+		fmt.Fprint(w, "event: job\ndata: {\"schema\":1,\"type\":\"job\",\"path\":\"\",\"timestamp\":3,\"job\":{\"id\":\"j1\",\"kind\":\"scrub\",\"state\":\"done\"},\"request_id\":\"r1\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events, _ := NewSSEClient(ts.URL).Subscribe(ctx)
+
+	var got []SSEEvent
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-ctx.Done():
+			t.Fatalf("timed out; got %d events", len(got))
+		}
+	}
+
+	if got[0].Type != protocol.EventCreate || got[0].Path != "/a.txt" || got[0].Schema != 1 {
+		t.Errorf("event 0 = %+v", got[0].Event)
+	}
+	if got[1].Type != protocol.EventJob || got[1].Job == nil || got[1].Job.ID != "j1" {
+		t.Errorf("event 1 = %+v", got[1].Event)
+	}
+	if got[1].IsTreeChange() {
+		t.Error("job event should not be a tree change")
+	}
+	if _, ok := got[1].Extra["request_id"]; !ok {
+		t.Error("unknown field should be preserved in Extra")
+	}
+}
+
+func TestSSE_ReauthReconnectsWithNewToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", protocol.EventStreamPreamble)
+		if r.Header.Get("Authorization") == "Bearer old" {
+			fmt.Fprint(w, "event: reauth\ndata: {\"schema\":1,\"type\":\"reauth\",\"path\":\"\",\"timestamp\":1,\"reauth\":{\"reason\":\"expiring\",\"expires_at\":60}}\n\n")
+			return
+		}
+		fmt.Fprint(w, "event: create\ndata: {\"schema\":1,\"type\":\"create\",\"path\":\"/a.txt\",\"timestamp\":2}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := NewSSEClient(ts.URL)
+	c.SetTransport(TransportSSE)
+	c.SetAuthToken("old")
+	// A reconnect without a new token would wait this long
+	c.reconnectMin = time.Minute
+	var stale string
+	c.SetReauthHandler(func(ctx context.Context, token string) error {
+		stale = token
+		c.SetAuthToken("new")
+		return nil
+	})
+	events, _ := c.Subscribe(ctx)
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == protocol.EventReauth {
+				if ev.Reauth == nil || ev.Reauth.Reason != protocol.ReauthExpiring {
+					t.Errorf("reauth event = %+v", ev.Event)
+				}
+				continue
+			}
+			if ev.Path != "/a.txt" {
+				t.Errorf("event %+v", ev.Event)
+			}
+			if stale != "old" {
+				t.Errorf("reauth handler got %q, want the old token", stale)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("no event after reauth")
+		}
+	}
+}
+
+// wsEventServer serves events over /api/v1/ws and fails /api/v1/events.
+func wsEventServer(t *testing.T, payloads ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/ws" {
+			http.Error(w, "buffered to death", http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		conn, err := websocket.Accept(w, r)
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		defer conn.Close()
+		for _, p := range payloads {
+			conn.WriteMessage(websocket.OpText, []byte(p))
+		}
+		conn.ReadMessage() // until the client goes away
+	}))
+}
+
+func TestWatchWS(t *testing.T) {
+	ts := wsEventServer(t,
+		`{"schema":1,"type":"create","path":"/a.txt","timestamp":1}`,
+		`{"schema":1,"type":"share_created","path":"/a.txt","timestamp":2}`,
+		`{"schema":1,"type":"delete","path":"/b.txt","timestamp":3}`)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := NewSSEClient(ts.URL)
+	c.SetAuthToken("tok")
+	events, _ := c.WatchWS(ctx)
+
+	for _, want := range []string{"/a.txt", "/b.txt"} {
+		select {
+		case ev := <-events:
+			if ev.Path != want {
+				t.Errorf("event %+v, want path %s", ev.Event, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func TestSubscribeAutoFallsBackToWS(t *testing.T) {
+	ts := wsEventServer(t, `{"schema":1,"type":"modify","path":"/c.txt","timestamp":1}`)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := NewSSEClient(ts.URL)
+	c.SetAuthToken("tok")
+	c.reconnectMin = 10 * time.Millisecond
+	c.reconnectMax = 20 * time.Millisecond
+	events, _ := c.Subscribe(ctx)
+
+	select {
+	case ev := <-events:
+		if ev.Path != "/c.txt" {
+			t.Errorf("event %+v", ev.Event)
+		}
+	case <-ctx.Done():
+		t.Fatal("no event after SSE failures")
+	}
+
+	// With SSE forced there is no fallback
+	sseOnly := NewSSEClient(ts.URL)
+	sseOnly.SetTransport(TransportSSE)
+	sseOnly.reconnectMin = 10 * time.Millisecond
+	sseOnly.reconnectMax = 20 * time.Millisecond
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer shortCancel()
+	sseEvents, _ := sseOnly.Subscribe(shortCtx)
+	if ev, ok := <-sseEvents; ok {
+		t.Errorf("SSE-only client got %+v", ev.Event)
+	}
+}
+
+func TestSSE_ResumesWithLastEventID(t *testing.T) {
+	resumed := make(chan string, 1)
+	conns := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", protocol.EventStreamPreamble)
+		if conns == 1 {
+			// Drop the connection after one event
+			fmt.Fprint(w, "id: 41\nevent: create\ndata: {\"id\":41,\"type\":\"create\",\"path\":\"/a.txt\",\"timestamp\":1}\n\n")
+			return
+		}
+		resumed <- r.Header.Get("Last-Event-ID")
+		fmt.Fprint(w, "id: 42\nevent: delete\ndata: {\"id\":42,\"type\":\"delete\",\"path\":\"/a.txt\",\"timestamp\":2}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := NewSSEClient(ts.URL)
+	c.SetTransport(TransportSSE)
+	c.reconnectMin = 10 * time.Millisecond
+	events, _ := c.Subscribe(ctx)
+
+	for _, want := range []uint64{41, 42} {
+		select {
+		case ev := <-events:
+			if ev.ID != want {
+				t.Errorf("event %+v, want id %d", ev.Event, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for event %d", want)
+		}
+	}
+	if got := <-resumed; got != "41" {
+		t.Errorf("Last-Event-ID = %q, want 41", got)
+	}
+	if c.LastEventID() != 42 {
+		t.Errorf("LastEventID = %d", c.LastEventID())
+	}
+}
+
+func TestSSE_ReconnectHandler(t *testing.T) {
+	var conns atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "%s\n\n", protocol.EventStreamPreamble)
+		w.(http.Flusher).Flush()
+		if conns.Add(1) < 3 {
+			return // drop the stream
+		}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reconnected := make(chan struct{}, 4)
+	c := NewSSEClient(ts.URL)
+	c.SetTransport(TransportSSE)
+	c.reconnectMin = 10 * time.Millisecond
+	c.SetReconnectHandler(func() { reconnected <- struct{}{} })
+	c.Subscribe(ctx)
+
+	// The first connection is not a reconnect; the two after it are
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reconnected:
+		case <-ctx.Done():
+			t.Fatalf("got %d reconnects, want 2", i)
+		}
+	}
+	select {
+	case <-reconnected:
+		t.Error("handler called for the first connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for range 100 {
+		if d := jitter(time.Second); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jitter(1s) = %v", d)
+		}
+	}
+}
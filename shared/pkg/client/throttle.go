@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttle paces the reads of several readers to a combined rate of bytes
+// per second. Idle time is not saved up for a later burst.
+type throttle struct {
+	rate int64
+
+	// rateAt, when set, gives the rate at a time instead of rate. A rate
+	// of 0 is unlimited.
+	rateAt func(time.Time) int64
+
+	mu   sync.Mutex
+	next time.Time // when the bytes read so far are paid for
+}
+
+// delay adds n bytes read at now and returns how long to wait before
+// reading on.
+func (t *throttle) delay(n int, now time.Time) time.Duration {
+	rate := t.rate
+	if t.rateAt != nil {
+		rate = t.rateAt(now)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.next.Before(now) || rate <= 0 {
+		t.next = now
+	}
+	if rate <= 0 {
+		return 0
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	return t.next.Sub(now)
+}
+
+type throttledReader struct {
+	ctx context.Context
+	rc  io.ReadCloser
+	t   *throttle
+
+	// noWait counts the bytes against the rate without waiting for it;
+	// readers that do wait are held back all the longer.
+	noWait bool
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.rc.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	if d := tr.t.delay(n, time.Now()); d > 0 && !tr.noWait {
+		timer := time.NewTimer(d)
+		select {
+		case <-tr.ctx.Done():
+			timer.Stop()
+			return n, tr.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
+
+func (tr *throttledReader) Close() error {
+	return tr.rc.Close()
+}
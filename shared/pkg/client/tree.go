@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/pattern"
+)
+
+// DefaultTreeConcurrency is the number of parallel downloads of a tree
+// fetch when TreeFetchOptions.Concurrency is not set.
+const DefaultTreeConcurrency = 4
+
+// TreeFetchOptions select and throttle the files of a tree fetch.
+type TreeFetchOptions struct {
+	// Concurrency caps the files being transferred at once; a file counts
+	// until its reader is closed.
+	Concurrency int
+
+	// Include and Exclude are gitignore-style patterns (see package
+	// pattern) matched against paths relative to the fetched directory.
+	// With Include set only matching files are fetched; excluded
+	// directories are not descended into.
+	Include []string
+	Exclude []string
+
+	// Skip leaves out files it returns true for, e.g. ones already cached.
+	// It is called during NewTreeFetch only.
+	Skip func(node *models.FileNode) bool
+
+	// OnFileProgress and OnProgress are called as file content is read and
+	// when a file is done. Calls are serialized.
+	OnFileProgress func(FileProgress)
+	OnProgress     func(TreeProgress)
+}
+
+// FileProgress is the progress of one file of a tree fetch.
+type FileProgress struct {
+	Path      string
+	BytesDone int64
+	Size      int64 // from the metadata
+	Done      bool  // reader closed or fetch failed
+}
+
+// TreeProgress is the aggregate progress of a tree fetch.
+type TreeProgress struct {
+	FilesDone  int // files whose reader was closed, including failed ones
+	FilesTotal int
+	Failed     int
+	BytesDone  int64 // bytes read from the readers
+	BytesTotal int64 // sum of the sizes in the metadata
+}
+
+// TreeFetchResult is one file of a tree fetch. On success the caller must
+// close Reader, which frees the file's transfer slot.
+type TreeFetchResult struct {
+	Node   *models.FileNode
+	Reader io.ReadCloser
+	Size   int64
+	Err    error
+}
+
+// TreeFetch downloads the files selected from a metadata tree.
+type TreeFetch struct {
+	c     *Client
+	opts  TreeFetchOptions
+	files []*models.FileNode
+
+	mu       sync.Mutex
+	progress TreeProgress
+}
+
+// FetchTreeContent fetches the subtree at pathPrefix and streams the
+// content of the files selected by opts; see TreeFetch.Start.
+func (c *Client) FetchTreeContent(ctx context.Context, pathPrefix string, opts TreeFetchOptions) (<-chan TreeFetchResult, error) {
+	root, err := c.FetchSubtree(ctx, pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("not found: %s", pathPrefix)
+	}
+	tf, err := c.NewTreeFetch(root, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tf.Start(ctx), nil
+}
+
+// NewTreeFetch selects the files at or below root according to opts,
+// for callers that already hold the metadata. Nothing is downloaded until
+// Start.
+func (c *Client) NewTreeFetch(root *models.FileNode, opts TreeFetchOptions) (*TreeFetch, error) {
+	include, err := pattern.NewMatcher(opts.Include, pattern.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	exclude, err := pattern.NewMatcher(opts.Exclude, pattern.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultTreeConcurrency
+	}
+
+	tf := &TreeFetch{c: c, opts: opts}
+	if root == nil {
+		return tf, nil
+	}
+	base := strings.TrimSuffix(root.Path, "/")
+	stack := []*models.FileNode{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(node.Path, base), "/")
+		if node == root && !node.IsDir {
+			rel = node.Name
+		}
+		if rel != "" && exclude.Match(rel, node.IsDir) {
+			continue
+		}
+		if node.IsDir {
+			for i := len(node.Children) - 1; i >= 0; i-- {
+				stack = append(stack, node.Children[i])
+			}
+			continue
+		}
+		if !include.Empty() && !include.Match(rel, false) {
+			continue
+		}
+		if opts.Skip != nil && opts.Skip(node) {
+			continue
+		}
+		tf.files = append(tf.files, node)
+		tf.progress.BytesTotal += node.Size
+	}
+	tf.progress.FilesTotal = len(tf.files)
+	return tf, nil
+}
+
+// Files returns the selected files in tree order.
+func (tf *TreeFetch) Files() []*models.FileNode {
+	return tf.files
+}
+
+// Progress returns the current progress.
+func (tf *TreeFetch) Progress() TreeProgress {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	return tf.progress
+}
+
+// Start downloads the selected files, at most Concurrency at a time, and
+// returns their results in completion order. The channel is closed once
+// every file has been handed out and its reader closed. Cancelling ctx
+// aborts the transfers and closes the channel without results for the
+// files not started yet; the caller must either drain the channel or
+// cancel ctx. Start may only be called once.
+func (tf *TreeFetch) Start(ctx context.Context) <-chan TreeFetchResult {
+	results := make(chan TreeFetchResult)
+
+	parent := RequestID(ctx)
+	if parent == "" {
+		parent = NewRequestID()
+	}
+	logger.Debug("[%s] fetch tree: %d files, %d bytes", parent, len(tf.files), tf.progress.BytesTotal)
+	tf.report()
+
+	type job struct {
+		node  *models.FileNode
+		reqID string
+	}
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		for i, node := range tf.files {
+			select {
+			case jobs <- job{node, childRequestID(parent, i+1)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < tf.opts.Concurrency && i < len(tf.files); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if !tf.fetch(WithRequestID(ctx, j.reqID), j.node, results) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// fetch downloads one file, hands it to the caller and waits until its
+// reader is closed. It returns false when ctx was cancelled.
+func (tf *TreeFetch) fetch(ctx context.Context, node *models.FileNode, results chan<- TreeFetchResult) bool {
+	reader, size, err := tf.c.FetchContentFull(ctx, strings.TrimPrefix(node.ID, "/"))
+	if err != nil {
+		tf.fileDone(node, 0, true)
+		select {
+		case results <- TreeFetchResult{Node: node, Err: err}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if size < 0 {
+		// Compressed in transit
+		size = node.Size
+	}
+	pr := &progressReader{rc: reader, tf: tf, node: node, closed: make(chan struct{})}
+	select {
+	case results <- TreeFetchResult{Node: node, Reader: pr, Size: size}:
+	case <-ctx.Done():
+		pr.Close()
+		return false
+	}
+	select {
+	case <-pr.closed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// read records n more bytes of a file.
+func (tf *TreeFetch) read(node *models.FileNode, done int64, n int) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	tf.progress.BytesDone += int64(n)
+	if tf.opts.OnFileProgress != nil {
+		tf.opts.OnFileProgress(FileProgress{Path: node.Path, BytesDone: done, Size: node.Size})
+	}
+	if tf.opts.OnProgress != nil {
+		tf.opts.OnProgress(tf.progress)
+	}
+}
+
+// fileDone records a file as finished.
+func (tf *TreeFetch) fileDone(node *models.FileNode, done int64, failed bool) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	tf.progress.FilesDone++
+	if failed {
+		tf.progress.Failed++
+	}
+	if tf.opts.OnFileProgress != nil {
+		tf.opts.OnFileProgress(FileProgress{Path: node.Path, BytesDone: done, Size: node.Size, Done: true})
+	}
+	if tf.opts.OnProgress != nil {
+		tf.opts.OnProgress(tf.progress)
+	}
+}
+
+// report calls OnProgress with the current progress.
+func (tf *TreeFetch) report() {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	if tf.opts.OnProgress != nil {
+		tf.opts.OnProgress(tf.progress)
+	}
+}
+
+// progressReader counts the bytes read from a file of a tree fetch and
+// signals when it is closed.
+type progressReader struct {
+	rc     io.ReadCloser
+	tf     *TreeFetch
+	node   *models.FileNode
+	done   int64
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.done += int64(n)
+		r.tf.read(r.node, r.done, n)
+	}
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	err := r.rc.Close()
+	r.once.Do(func() {
+		r.tf.fileDone(r.node, r.done, false)
+		close(r.closed)
+	})
+	return err
+}
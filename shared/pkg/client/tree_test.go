@@ -0,0 +1,247 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// testTree is /docs with a.txt, b.log, notes/c.txt and build/d.txt.
+func testTree() *models.FileNode {
+	file := func(p, content string) *models.FileNode {
+		return &models.FileNode{ID: p, Name: p[strings.LastIndex(p, "/")+1:], Path: p, Size: int64(len(content))}
+	}
+	dir := func(p string, children ...*models.FileNode) *models.FileNode {
+		return &models.FileNode{ID: p, Name: p[strings.LastIndex(p, "/")+1:], Path: p, IsDir: true, Children: children}
+	}
+	return dir("/docs",
+		file("/docs/a.txt", "alpha"),
+		file("/docs/b.log", "bravo!"),
+		dir("/docs/notes", file("/docs/notes/c.txt", "charlie")),
+		dir("/docs/build", file("/docs/build/d.txt", "delta")),
+	)
+}
+
+var testTreeContent = map[string]string{
+	"docs/a.txt":       "alpha",
+	"docs/b.log":       "bravo!",
+	"docs/notes/c.txt": "charlie",
+	"docs/build/d.txt": "delta",
+}
+
+// treeHandler serves testTree and its content. slow, if set, is called
+// instead for content requests.
+func treeHandler(slow http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/tree/docs":
+			json.NewEncoder(w).Encode(protocol.TreeResponse{Root: testTree()})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/content/"):
+			if slow != nil {
+				slow(w, r)
+				return
+			}
+			content, ok := testTreeContent[strings.TrimPrefix(r.URL.Path, "/api/v1/content/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, content)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func TestFetchTreeContent(t *testing.T) {
+	c, ts := testClient(treeHandler(nil))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var last TreeProgress
+	fileDone := map[string]int64{}
+	results, err := c.FetchTreeContent(context.Background(), "/docs", TreeFetchOptions{
+		Concurrency: 2,
+		Exclude:     []string{"build/", "*.log"},
+		OnFileProgress: func(p FileProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if p.Done {
+				fileDone[p.Path] = p.BytesDone
+			}
+		},
+		OnProgress: func(p TreeProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			last = p
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for res := range results {
+		if res.Err != nil {
+			t.Fatalf("%s: %v", res.Node.Path, res.Err)
+		}
+		data, _ := io.ReadAll(res.Reader)
+		res.Reader.Close()
+		got[res.Node.Path] = string(data)
+	}
+
+	if len(got) != 2 || got["/docs/a.txt"] != "alpha" || got["/docs/notes/c.txt"] != "charlie" {
+		t.Errorf("fetched %v", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := TreeProgress{FilesDone: 2, FilesTotal: 2, BytesDone: 12, BytesTotal: 12}
+	if last != want {
+		t.Errorf("final progress %+v, want %+v", last, want)
+	}
+	if fileDone["/docs/a.txt"] != 5 || fileDone["/docs/notes/c.txt"] != 7 {
+		t.Errorf("per-file progress %v", fileDone)
+	}
+}
+
+func TestNewTreeFetch_Filters(t *testing.T) {
+	c := New(Config{BaseURL: "http://unused"})
+	tests := []struct {
+		name string
+		opts TreeFetchOptions
+		want []string
+	}{
+		{"all", TreeFetchOptions{}, []string{"/docs/a.txt", "/docs/b.log", "/docs/build/d.txt", "/docs/notes/c.txt"}},
+		{"include", TreeFetchOptions{Include: []string{"*.txt"}}, []string{"/docs/a.txt", "/docs/build/d.txt", "/docs/notes/c.txt"}},
+		{"include dir", TreeFetchOptions{Include: []string{"notes/"}}, []string{"/docs/notes/c.txt"}},
+		{"exclude wins", TreeFetchOptions{Include: []string{"*.txt"}, Exclude: []string{"build"}}, []string{"/docs/a.txt", "/docs/notes/c.txt"}},
+		{"skip", TreeFetchOptions{Skip: func(n *models.FileNode) bool { return n.Size < 6 }}, []string{"/docs/b.log", "/docs/notes/c.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tf, err := c.NewTreeFetch(testTree(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, n := range tf.Files() {
+				got = append(got, n.Path)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("files %v, want %v", got, tt.want)
+			}
+			if tf.Progress().FilesTotal != len(tt.want) {
+				t.Errorf("FilesTotal %d, want %d", tf.Progress().FilesTotal, len(tt.want))
+			}
+		})
+	}
+
+	if _, err := c.NewTreeFetch(testTree(), TreeFetchOptions{Include: []string{"[bad"}}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestFetchTreeContent_ConcurrencyLimit(t *testing.T) {
+	c, ts := testClient(treeHandler(nil))
+	defer ts.Close()
+
+	results, err := c.FetchTreeContent(context.Background(), "docs", TreeFetchOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold every reader for a while: no more than two may be open at once
+	var mu sync.Mutex
+	open, maxOpen := 0, 0
+	var wg sync.WaitGroup
+	for res := range results {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		mu.Lock()
+		open++
+		maxOpen = max(maxOpen, open)
+		mu.Unlock()
+		wg.Add(1)
+		go func(r io.ReadCloser) {
+			defer wg.Done()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			open--
+			mu.Unlock()
+			r.Close()
+		}(res.Reader)
+	}
+	wg.Wait()
+	if maxOpen != 2 {
+		t.Errorf("max open readers %d, want 2", maxOpen)
+	}
+}
+
+// treeFetchGoroutines counts the goroutines running TreeFetch code.
+func treeFetchGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	n := 0
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "client.(*TreeFetch)") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFetchTreeContent_CancelNoLeak(t *testing.T) {
+	started := make(chan struct{}, 10)
+	c, ts := testClient(treeHandler(func(w http.ResponseWriter, r *http.Request) {
+		// Send a little, then stall until the client goes away
+		w.Header().Set("Content-Length", "1000000")
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := c.FetchTreeContent(ctx, "docs", TreeFetchOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Take one file mid-transfer, then give up without draining
+	res := <-results
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	buf := make([]byte, 7)
+	io.ReadFull(res.Reader, buf)
+	<-started
+	cancel()
+	if _, err := io.ReadAll(res.Reader); err == nil {
+		t.Error("read after cancel succeeded")
+	}
+	res.Reader.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for treeFetchGoroutines() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tree fetch goroutines still running after cancel", treeFetchGoroutines())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The channel is closed once everything has stopped
+	for range results {
+	}
+}
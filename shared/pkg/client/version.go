@@ -0,0 +1,116 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/version"
+)
+
+// UserAgent is sent with every request so the server can log the client
+// version per session and enforce its minimum client version. Client
+// binaries override it at startup, e.g. version.UserAgent("fuse").
+var UserAgent = version.UserAgent("client")
+
+// UpgradeRequiredError is returned when the server rejects this client with
+// 426 Upgrade Required because it is older than the server's minimum version.
+type UpgradeRequiredError struct {
+	ClientVersion    string
+	MinClientVersion string
+	ServerVersion    string
+}
+
+func (e *UpgradeRequiredError) Error() string {
+	return fmt.Sprintf("client version %s is no longer supported by the server (minimum %s, server %s): please upgrade",
+		e.ClientVersion, e.MinClientVersion, e.ServerVersion)
+}
+
+// AsUpgradeRequired checks if an error is an UpgradeRequiredError and returns it.
+func AsUpgradeRequired(err error) (*UpgradeRequiredError, bool) {
+	var ue *UpgradeRequiredError
+	if errors.As(err, &ue) {
+		return ue, true
+	}
+	return nil, false
+}
+
+// upgradeState tracks whether the server currently rejects this client.
+type upgradeState struct {
+	mu  sync.RWMutex
+	err *UpgradeRequiredError
+}
+
+func (s *upgradeState) get() *UpgradeRequiredError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+func (s *upgradeState) set(err *UpgradeRequiredError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && s.err == nil {
+		logger.Error("Server rejected this client: %v", err)
+	} else if err == nil && s.err != nil {
+		logger.Info("Server accepts client version %s again", version.Version)
+	}
+	s.err = err
+}
+
+// versionTransport sets the User-Agent on every request and records 426
+// responses. The response is passed through unchanged, so callers still see
+// a non-retryable 4xx status.
+type versionTransport struct {
+	base  http.RoundTripper
+	state *upgradeState
+}
+
+func (t *versionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || t.state == nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		t.state.set(parseUpgradeRequired(body))
+	} else if t.state.get() != nil {
+		t.state.set(nil)
+	}
+	return resp, nil
+}
+
+// parseUpgradeRequired decodes a 426 response body, falling back to what
+// the client knows about itself when the body is not the expected JSON.
+func parseUpgradeRequired(body []byte) *UpgradeRequiredError {
+	ue := &UpgradeRequiredError{ClientVersion: version.Version}
+	var ur protocol.UpgradeRequiredResponse
+	if json.Unmarshal(body, &ur) == nil {
+		if ur.ClientVersion != "" {
+			ue.ClientVersion = ur.ClientVersion
+		}
+		ue.MinClientVersion = ur.MinClientVersion
+		ue.ServerVersion = ur.ServerVersion
+	}
+	if ue.MinClientVersion == "" {
+		ue.MinClientVersion = "unknown"
+	}
+	if ue.ServerVersion == "" {
+		ue.ServerVersion = "unknown"
+	}
+	return ue
+}
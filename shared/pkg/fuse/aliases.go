@@ -0,0 +1,52 @@
+package fuse
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// With Config.SymlinkAliases, aliases (a second server path of a file or
+// directory) are shown as symbolic links to their target instead of as a
+// copy of it. The links are relative, so they resolve inside the mount
+// wherever it is.
+
+var _ fs.NodeReadlinker = (*FruitNode)(nil)
+
+// isSymlink reports whether meta is shown as a symbolic link.
+func (f *FruitFS) isSymlink(meta *models.FileNode) bool {
+	return f.cfg.SymlinkAliases && meta.Alias && meta.AliasTarget != ""
+}
+
+// aliasLink returns the symbolic link content of the alias meta: the path
+// of its target relative to the alias' directory.
+func aliasLink(meta *models.FileNode) string {
+	dir := strings.Split(strings.Trim(path.Dir(meta.Path), "/"), "/")
+	target := strings.Split(strings.Trim(meta.AliasTarget, "/"), "/")
+	if dir[0] == "" {
+		dir = nil
+	}
+	common := 0
+	for common < len(dir) && common < len(target)-1 && dir[common] == target[common] {
+		common++
+	}
+	parts := make([]string, 0, len(dir)-common+len(target)-common)
+	for range dir[common:] {
+		parts = append(parts, "..")
+	}
+	return path.Join(append(parts, target[common:]...)...)
+}
+
+// Readlink returns the target of an alias shown as a symbolic link.
+func (n *FruitNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	meta := n.resolveMetadata()
+	if meta == nil || !n.fsys.isSymlink(meta) {
+		return nil, syscall.EINVAL
+	}
+	return []byte(aliasLink(meta)), 0
+}
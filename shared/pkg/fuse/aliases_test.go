@@ -0,0 +1,73 @@
+package fuse
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+func TestAliasLink(t *testing.T) {
+	tests := []struct {
+		link, target, want string
+	}{
+		{"/groups/design/brand", "/brand", "../../brand"},
+		{"/brand-link", "/brand", "brand"},
+		{"/a/l", "/a/t", "t"},
+		{"/a/b/l", "/a/c/d", "../c/d"},
+		{"/a/l", "/x/y/z", "../x/y/z"},
+	}
+	for _, tt := range tests {
+		got := aliasLink(&models.FileNode{Path: tt.link, Alias: true, AliasTarget: tt.target})
+		if got != tt.want {
+			t.Errorf("aliasLink(%s -> %s) = %q, want %q", tt.link, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestSymlinkAliases(t *testing.T) {
+	file := &models.FileNode{ID: "f", Path: "/brand/logo.svg", Name: "logo.svg", Size: 5, AliasTarget: "/brand/logo.svg"}
+	alias := &models.FileNode{ID: "b", Path: "/groups/brand", Name: "brand", IsDir: true,
+		Alias: true, AliasTarget: "/brand", Children: []*models.FileNode{file}}
+	dir := &models.FileNode{ID: "g", Path: "/groups", Name: "groups", IsDir: true, Children: []*models.FileNode{alias}}
+	ctx := context.Background()
+
+	for _, symlinks := range []bool{false, true} {
+		f, err := NewFruitFS(Config{ServerURL: "http://127.0.0.1:1", CacheDir: t.TempDir(), MaxCacheSize: 1 << 20, SymlinkAliases: symlinks})
+		if err != nil {
+			t.Fatalf("NewFruitFS: %v", err)
+		}
+		n := &FruitNode{fsys: f, metadata: alias}
+
+		var out gofuse.AttrOut
+		if errno := n.Getattr(ctx, nil, &out); errno != 0 {
+			t.Fatalf("symlinks=%v: Getattr: %v", symlinks, errno)
+		}
+		wantMode := uint32(syscall.S_IFDIR)
+		if symlinks {
+			wantMode = syscall.S_IFLNK
+		}
+		if out.Mode&syscall.S_IFMT != wantMode {
+			t.Errorf("symlinks=%v: mode = %o, want type %o", symlinks, out.Mode, wantMode)
+		}
+
+		stream, errno := (&FruitNode{fsys: f, metadata: dir}).Readdir(ctx)
+		if errno != 0 {
+			t.Fatalf("symlinks=%v: Readdir: %v", symlinks, errno)
+		}
+		if e, _ := stream.Next(); e.Mode != wantMode {
+			t.Errorf("symlinks=%v: dir entry mode = %o, want %o", symlinks, e.Mode, wantMode)
+		}
+
+		link, errno := n.Readlink(ctx)
+		if symlinks && (errno != 0 || string(link) != "../brand" || out.Size != uint64(len(link))) {
+			t.Errorf("Readlink = %q, %v (size %d)", link, errno, out.Size)
+		}
+		if !symlinks && errno != syscall.EINVAL {
+			t.Errorf("Readlink without symlinks = %v, want EINVAL", errno)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// Conflict policies, applied when the server rejects a write-back because
+// the file changed since it was opened.
+const (
+	// ConflictCopy keeps the server version and uploads the local content
+	// next to it as "<name>.conflict-<host>-<timestamp>".
+	ConflictCopy = "conflict-copy"
+	// ConflictOverwrite replaces the server version with the local content.
+	ConflictOverwrite = "overwrite"
+)
+
+// ValidConflictPolicy reports whether p is a known conflict policy.
+func ValidConflictPolicy(p string) bool {
+	return p == ConflictCopy || p == ConflictOverwrite
+}
+
+// conflictCopyPath returns the path the local content is saved under when a
+// write-back conflicts, e.g. "/docs/a.txt.conflict-laptop-20260220-153000".
+func conflictCopyPath(p, host string, now time.Time) string {
+	return path.Join(path.Dir(p), fmt.Sprintf("%s.conflict-%s-%s",
+		path.Base(p), host, now.Format("20060102-150405")))
+}
+
+// conflictHost returns the hostname used in conflict copy names, reduced to
+// characters that are safe in a file name.
+func conflictHost() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, host)
+}
+
+// saveConflictCopy uploads the handle's content as a conflict copy and
+// brings the original back in line with the server. The local changes stay
+// dirty if the copy cannot be uploaded. Must be called with fh.mu held.
+func (fh *FileHandle) saveConflictCopy(ctx context.Context, ce *client.ConflictError) syscall.Errno {
+	f := fh.node.fsys
+	orig := fh.node.metadata.Path
+	copyPath := conflictCopyPath(orig, f.hostname, time.Now())
+
+	reader := io.NewSectionReader(fh.tmpFile, 0, fh.size)
+	if _, err := f.client.UploadFile(ctx, strings.TrimPrefix(copyPath, "/"), reader, fh.size, 0); err != nil {
+		logger.Error("Failed to upload conflict copy of %s: %v", orig, err)
+		return f.errno(err)
+	}
+	f.stats.BytesUploaded.Add(fh.size)
+	logger.Warn("Conflict on %s (expected v%d, server v%d): local changes saved as %s",
+		orig, ce.ExpectedVersion, ce.CurrentVersion, copyPath)
+
+	fh.dirty = false
+	f.setDirty(orig, false)
+
+	if err := f.RefreshMetadata(ctx); err != nil {
+		logger.Error("Refresh after conflict on %s: %v", orig, err)
+		return 0
+	}
+	f.mu.Lock()
+	if fresh := fstree.FindByPath(f.metadata, orig); fresh != nil && fresh != fh.node.metadata {
+		fh.node.metadata.ID = fresh.ID
+		fh.node.metadata.Size = fresh.Size
+		fh.node.metadata.Hash = fresh.Hash
+		fh.node.metadata.Version = fresh.Version
+		fh.node.metadata.ModTime = fresh.ModTime
+	}
+	f.mu.Unlock()
+
+	// The cached original holds our rejected content; replace it
+	if f.cache.IsCached(fh.node.getFileID()) {
+		if _, err := fh.node.fetchFullContent(ctx); err != nil {
+			logger.Error("Refetch %s after conflict: %v", orig, err)
+			f.cache.Evict(fh.node.getFileID())
+		}
+	}
+	return 0
+}
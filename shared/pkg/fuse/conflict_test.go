@@ -1,14 +1,24 @@
 package fuse
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
 )
 
 func TestConflictCopyPath(t *testing.T) {
-	// Use a fixed date for reproducible tests
-	stamp := time.Now().Format("2006-01-02")
+	// Use a fixed time for reproducible tests
+	now := time.Date(2026, 2, 20, 15, 30, 0, 0, time.UTC)
 
 	tests := []struct {
 		name string
@@ -18,38 +28,150 @@ func TestConflictCopyPath(t *testing.T) {
 		{
 			name: "normal file",
 			path: "/docs/report.txt",
-			want: "/docs/report (conflict " + stamp + ").txt",
+			want: "/docs/report.txt.conflict-laptop-20260220-153000",
 		},
 		{
 			name: "no extension",
 			path: "/docs/README",
-			want: "/docs/README (conflict " + stamp + ")",
+			want: "/docs/README.conflict-laptop-20260220-153000",
 		},
 		{
 			name: "nested directory",
 			path: "/a/b/c/file.pdf",
-			want: "/a/b/c/file (conflict " + stamp + ").pdf",
+			want: "/a/b/c/file.pdf.conflict-laptop-20260220-153000",
 		},
 		{
 			name: "root file",
 			path: "/test.txt",
-			want: "/test (conflict " + stamp + ").txt",
-		},
-		{
-			name: "double extension",
-			path: "/data/archive.tar.gz",
-			want: "/data/archive.tar (conflict " + stamp + ").gz",
+			want: "/test.txt.conflict-laptop-20260220-153000",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := conflictCopyPath(tt.path)
-			// Normalize path separators for cross-platform testing
-			got = strings.ReplaceAll(got, "\\", "/")
+			got := conflictCopyPath(tt.path, "laptop", now)
 			if got != tt.want {
 				t.Errorf("conflictCopyPath(%q) = %q, want %q", tt.path, got, tt.want)
 			}
 		})
 	}
 }
+
+// conflictServer rejects conditional uploads of /doc.txt with 409 and
+// records every upload it accepts.
+type conflictServer struct {
+	mu      sync.Mutex
+	uploads map[string]string
+}
+
+func (s *conflictServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/tree" {
+		json.NewEncoder(w).Encode(protocol.TreeResponse{Root: &models.FileNode{
+			ID: "/", Path: "/", IsDir: true, Children: []*models.FileNode{
+				{ID: "/doc.txt", Path: "/doc.txt", Name: "doc.txt", Size: 6, Version: 3},
+			},
+		}})
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/content")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Write([]byte("server"))
+		return
+	}
+	if path == "/doc.txt" && r.Header.Get("X-Expected-Version") != "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(protocol.ConflictResponse{
+			Error: "version conflict", Path: path, ExpectedVersion: 1, CurrentVersion: 3,
+		})
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.uploads[path] = string(body)
+	s.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"path": path, "size": len(body), "version": 4})
+}
+
+func flushLocalEdit(t *testing.T, policy string) (*FruitFS, *FileHandle, map[string]string) {
+	t.Helper()
+	srv := &conflictServer{uploads: make(map[string]string)}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: dir, ConflictPolicy: policy})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	f.hostname = "laptop"
+	node := &models.FileNode{ID: "/doc.txt", Path: "/doc.txt", Name: "doc.txt", Size: 5, Version: 1}
+	f.metadata = &models.FileNode{ID: "/", Path: "/", IsDir: true, Children: []*models.FileNode{node}}
+	n := &FruitNode{fsys: f, metadata: node}
+	f.cache.PutFile(n.getFileID(), node.Path, strings.NewReader("local"), 5)
+
+	tmp, err := os.CreateTemp(dir, "write-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tmp.Close() })
+	tmp.WriteString("local")
+	fh := &FileHandle{node: n, tmpFile: tmp, size: 5, writable: true, dirty: true}
+	f.setDirty(node.Path, true)
+
+	if errno := fh.Flush(context.Background()); errno != 0 {
+		t.Fatalf("Flush: %v", errno)
+	}
+	if fh.dirty || f.isDirty(node.Path) {
+		t.Error("handle still dirty after flush")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return f, fh, srv.uploads
+}
+
+func TestFlushConflictCopy(t *testing.T) {
+	f, fh, uploads := flushLocalEdit(t, "")
+
+	var copyPath string
+	for p, body := range uploads {
+		if strings.HasPrefix(p, "/doc.txt.conflict-laptop-") && body == "local" {
+			copyPath = p
+		}
+	}
+	if copyPath == "" || len(uploads) != 1 {
+		t.Fatalf("uploads = %v, want a single conflict copy", uploads)
+	}
+
+	// The original follows the server again
+	if v := fh.node.metadata.Version; v != 3 {
+		t.Errorf("version = %d, want 3", v)
+	}
+	cachePath, ok := f.cache.Get(fh.node.getFileID())
+	if !ok {
+		t.Fatal("original dropped from the cache")
+	}
+	if data, _ := os.ReadFile(cachePath); string(data) != "server" {
+		t.Errorf("cached original = %q, want server content", data)
+	}
+}
+
+func TestFlushConflictOverwrite(t *testing.T) {
+	_, fh, uploads := flushLocalEdit(t, ConflictOverwrite)
+
+	if len(uploads) != 1 || uploads["/doc.txt"] != "local" {
+		t.Fatalf("uploads = %v, want /doc.txt overwritten", uploads)
+	}
+	if v := fh.node.metadata.Version; v != 4 {
+		t.Errorf("version = %d, want 4", v)
+	}
+}
+
+func TestConflictPolicyValidation(t *testing.T) {
+	if _, err := NewFruitFS(Config{CacheDir: t.TempDir(), ConflictPolicy: "merge"}); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
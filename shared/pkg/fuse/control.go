@@ -0,0 +1,228 @@
+package fuse
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Extended attributes beyond the read-only informational ones.
+const (
+	xattrStatus = "user.fruitsalade.status"
+	xattrPin    = "user.fruitsalade.pin"
+)
+
+// File states reported by user.fruitsalade.status.
+const (
+	StatusRemote = "remote" // only on the server
+	StatusCached = "cached" // downloaded, may be evicted
+	StatusPinned = "pinned" // downloaded and kept
+	StatusDirty  = "dirty"  // local writes not uploaded yet
+)
+
+// The control directory is a virtual directory at the mount root; reading
+// .fruitsalade/status returns Status as JSON.
+const (
+	controlDirName    = ".fruitsalade"
+	controlStatusName = "status"
+)
+
+// Status is a snapshot of the client state.
+type Status struct {
+	Server      string      `json:"server"`
+	Health      string      `json:"health"`
+	AuthError   string      `json:"auth_error,omitempty"`
+	Online      bool        `json:"online"`
+	Cache       CacheStatus `json:"cache"`
+	OpenHandles int64       `json:"open_handles"`
+	DirtyFiles  int         `json:"dirty_files"`
+	Stats       StatsStatus `json:"stats"`
+}
+
+// CacheStatus describes the local cache.
+type CacheStatus struct {
+	Dir         string `json:"dir"`
+	UsedBytes   int64  `json:"used_bytes"`
+	MaxBytes    int64  `json:"max_bytes"`
+	Files       int    `json:"files"`
+	PinnedFiles int    `json:"pinned_files"`
+
+	// Free space of the cache's volume, if known. With disk_full set, new
+	// content is not cached and writes fail with ENOSPC.
+	DiskFreeBytes    int64 `json:"disk_free_bytes,omitempty"`
+	DiskReserveBytes int64 `json:"disk_reserve_bytes,omitempty"`
+	DiskFull         bool  `json:"disk_full"`
+}
+
+// StatsStatus holds the Stats counters.
+type StatsStatus struct {
+	MetadataFetches int64 `json:"metadata_fetches"`
+	ContentFetches  int64 `json:"content_fetches"`
+	CacheHits       int64 `json:"cache_hits"`
+	CacheMisses     int64 `json:"cache_misses"`
+	BytesDownloaded int64 `json:"bytes_downloaded"`
+	BytesFromCache  int64 `json:"bytes_from_cache"`
+	BytesUploaded   int64 `json:"bytes_uploaded"`
+	FailedFetches   int64 `json:"failed_fetches"`
+	OfflineErrors   int64 `json:"offline_errors"`
+	DiskFullErrors  int64 `json:"disk_full_errors"`
+
+	EmergencyEvictions int64 `json:"emergency_evictions"`
+
+	RefreshesSkipped int64 `json:"refreshes_skipped"`
+	FetchRetries     int64 `json:"fetch_retries"`
+	StreamReconnects int64 `json:"stream_reconnects"`
+	ServerReconnects int64 `json:"server_reconnects"`
+
+	CoalescedFetches int64 `json:"coalesced_fetches"`
+	ReadAheadHits    int64 `json:"read_ahead_hits"`
+}
+
+// Status returns the current client state.
+func (f *FruitFS) Status() *Status {
+	used, max, count := f.cache.Stats()
+	disk := f.cache.DiskStatus()
+	f.dirtyMu.Lock()
+	dirty := len(f.dirty)
+	f.dirtyMu.Unlock()
+
+	var authError string
+	if err := f.client.AuthFailed(); err != nil {
+		authError = err.Error()
+	}
+
+	return &Status{
+		Server:    f.cfg.ServerURL,
+		AuthError: authError,
+		Health:    f.HealthState(),
+		Online:    f.IsOnline(),
+		Cache: CacheStatus{
+			Dir:         f.cache.Dir(),
+			UsedBytes:   used,
+			MaxBytes:    max,
+			Files:       count,
+			PinnedFiles: len(f.cache.Pinned()),
+
+			DiskFreeBytes:    disk.Free,
+			DiskReserveBytes: disk.Reserve,
+			DiskFull:         disk.Full,
+		},
+		OpenHandles: f.stats.OpenHandles.Load(),
+		DirtyFiles:  dirty,
+		Stats: StatsStatus{
+			MetadataFetches: f.stats.MetadataFetches.Load(),
+			ContentFetches:  f.stats.ContentFetches.Load(),
+			CacheHits:       f.stats.CacheHits.Load(),
+			CacheMisses:     f.stats.CacheMisses.Load(),
+			BytesDownloaded: f.stats.BytesDownloaded.Load(),
+			BytesFromCache:  f.stats.BytesFromCache.Load(),
+			BytesUploaded:   f.stats.BytesUploaded.Load(),
+			FailedFetches:   f.stats.FailedFetches.Load(),
+			OfflineErrors:   f.stats.OfflineErrors.Load(),
+			DiskFullErrors:  f.stats.DiskFullErrors.Load(),
+
+			EmergencyEvictions: f.cache.EmergencyEvictions(),
+
+			RefreshesSkipped: f.stats.RefreshesSkipped.Load(),
+			FetchRetries:     f.stats.FetchRetries.Load(),
+			StreamReconnects: f.stats.StreamReconnects.Load(),
+			ServerReconnects: f.stats.ServerReconnects.Load(),
+
+			CoalescedFetches: f.stats.CoalescedFetches.Load(),
+			ReadAheadHits:    f.stats.ReadAheadHits.Load(),
+		},
+	}
+}
+
+// controlDir is the .fruitsalade directory.
+type controlDir struct {
+	fs.Inode
+	fsys *FruitFS
+}
+
+var _ fs.NodeGetattrer = (*controlDir)(nil)
+var _ fs.NodeLookuper = (*controlDir)(nil)
+var _ fs.NodeReaddirer = (*controlDir)(nil)
+
+func (n *FruitNode) lookupControlDir(ctx context.Context, out *gofuse.EntryOut) *fs.Inode {
+	out.Mode = 0555 | syscall.S_IFDIR
+	out.Uid = uint32(os.Getuid())
+	out.Gid = uint32(os.Getgid())
+	return n.NewInode(ctx, &controlDir{fsys: n.fsys}, fs.StableAttr{Mode: syscall.S_IFDIR})
+}
+
+func (d *controlDir) Getattr(ctx context.Context, fh fs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	out.Mode = 0555 | syscall.S_IFDIR
+	out.Uid = uint32(os.Getuid())
+	out.Gid = uint32(os.Getgid())
+	return 0
+}
+
+func (d *controlDir) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if name != controlStatusName {
+		return nil, syscall.ENOENT
+	}
+	out.Mode = 0444 | syscall.S_IFREG
+	out.Uid = uint32(os.Getuid())
+	out.Gid = uint32(os.Getgid())
+	// Content changes all the time; don't let the kernel cache the entry
+	out.EntryValid = 0
+	out.AttrValid = 0
+	return d.NewInode(ctx, &controlFile{fsys: d.fsys}, fs.StableAttr{Mode: syscall.S_IFREG}), 0
+}
+
+func (d *controlDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return fs.NewListDirStream([]gofuse.DirEntry{
+		{Name: controlStatusName, Mode: syscall.S_IFREG},
+	}), 0
+}
+
+// controlFile is .fruitsalade/status. It reports size 0 and is opened with
+// direct I/O, so readers see the snapshot taken at open time in full.
+type controlFile struct {
+	fs.Inode
+	fsys *FruitFS
+}
+
+var _ fs.NodeGetattrer = (*controlFile)(nil)
+var _ fs.NodeOpener = (*controlFile)(nil)
+
+func (c *controlFile) Getattr(ctx context.Context, fh fs.FileHandle, out *gofuse.AttrOut) syscall.Errno {
+	out.Mode = 0444 | syscall.S_IFREG
+	out.Uid = uint32(os.Getuid())
+	out.Gid = uint32(os.Getgid())
+	return 0
+}
+
+func (c *controlFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EACCES
+	}
+	data, err := json.MarshalIndent(c.fsys.Status(), "", "  ")
+	if err != nil {
+		return nil, 0, syscall.EIO
+	}
+	return &snapshotHandle{data: append(data, '\n')}, gofuse.FOPEN_DIRECT_IO, 0
+}
+
+// snapshotHandle serves fixed content.
+type snapshotHandle struct {
+	data []byte
+}
+
+var _ fs.FileReader = (*snapshotHandle)(nil)
+
+func (h *snapshotHandle) Read(ctx context.Context, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
+	if off >= int64(len(h.data)) {
+		return gofuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	return gofuse.ReadResultData(h.data[off:end]), 0
+}
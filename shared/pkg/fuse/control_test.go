@@ -0,0 +1,107 @@
+package fuse
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+func newTestFS(t *testing.T) *FruitFS {
+	t.Helper()
+	f, err := NewFruitFS(Config{ServerURL: "http://127.0.0.1:1", CacheDir: t.TempDir(), MaxCacheSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	return f
+}
+
+func TestFileStatusAndPin(t *testing.T) {
+	f := newTestFS(t)
+	n := &FruitNode{fsys: f, metadata: &models.FileNode{ID: "/docs/a.txt", Path: "/docs/a.txt", Name: "a.txt", Size: 5}}
+	ctx := context.Background()
+
+	if got := n.fileStatus(); got != StatusRemote {
+		t.Errorf("status = %q, want remote", got)
+	}
+
+	f.cache.Put(n.getFileID(), strings.NewReader("hello"), 5)
+	if got := n.fileStatus(); got != StatusCached {
+		t.Errorf("status = %q, want cached", got)
+	}
+
+	if errno := n.Setxattr(ctx, xattrPin, []byte("1"), 0); errno != 0 {
+		t.Fatalf("pin: %v", errno)
+	}
+	if got := n.fileStatus(); got != StatusPinned {
+		t.Errorf("status = %q, want pinned", got)
+	}
+
+	f.setDirty(n.metadata.Path, true)
+	if got := n.fileStatus(); got != StatusDirty {
+		t.Errorf("status = %q, want dirty", got)
+	}
+	f.setDirty(n.metadata.Path, false)
+
+	if errno := n.Setxattr(ctx, xattrPin, []byte("0"), 0); errno != 0 {
+		t.Fatalf("unpin: %v", errno)
+	}
+	if got := n.fileStatus(); got != StatusCached {
+		t.Errorf("status after unpin = %q, want cached", got)
+	}
+
+	if errno := n.Setxattr(ctx, xattrPin, []byte("maybe"), 0); errno != syscall.EINVAL {
+		t.Errorf("invalid value: errno = %v, want EINVAL", errno)
+	}
+	if errno := n.Setxattr(ctx, "user.other", []byte("1"), 0); errno != syscall.ENOTSUP {
+		t.Errorf("unknown attr: errno = %v, want ENOTSUP", errno)
+	}
+}
+
+func TestPinRemoteWhileOffline(t *testing.T) {
+	f := newTestFS(t)
+	f.client.Ping(context.Background()) // unreachable server marks the client offline
+	n := &FruitNode{fsys: f, metadata: &models.FileNode{ID: "/b.txt", Path: "/b.txt", Name: "b.txt", Size: 3}}
+
+	if errno := n.Setxattr(context.Background(), xattrPin, []byte("1"), 0); errno != syscall.ENETUNREACH {
+		t.Errorf("errno = %v, want ENETUNREACH", errno)
+	}
+}
+
+func TestControlStatus(t *testing.T) {
+	f := newTestFS(t)
+	f.cache.Put("x", strings.NewReader("12345"), 5)
+	f.setDirty("/a", true)
+
+	h, _, errno := (&controlFile{fsys: f}).Open(context.Background(), syscall.O_RDONLY)
+	if errno != 0 {
+		t.Fatalf("open: %v", errno)
+	}
+	buf := make([]byte, 4096)
+	res, errno := h.(*snapshotHandle).Read(context.Background(), buf, 0)
+	if errno != 0 {
+		t.Fatalf("read: %v", errno)
+	}
+	data, _ := res.Bytes(buf)
+
+	var st Status
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("unmarshal %q: %v", data, err)
+	}
+	if st.Cache.Files != 1 || st.Cache.UsedBytes != 5 || st.DirtyFiles != 1 {
+		t.Errorf("status = %+v", st)
+	}
+
+	// Reads past the end return nothing
+	res, _ = h.(*snapshotHandle).Read(context.Background(), buf, int64(len(data)))
+	if rest, _ := res.Bytes(buf); len(rest) != 0 {
+		t.Errorf("read past end returned %d bytes", len(rest))
+	}
+
+	if _, _, errno := (&controlFile{fsys: f}).Open(context.Background(), syscall.O_WRONLY); errno != syscall.EACCES {
+		t.Errorf("write open: errno = %v, want EACCES", errno)
+	}
+}
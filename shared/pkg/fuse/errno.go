@@ -0,0 +1,45 @@
+package fuse
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/cache"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+)
+
+// errno maps a failed server request to the errno the file operation
+// returns, by the error code of the server's response, so that a full
+// quota reads as EDQUOT rather than a generic EIO. A full local disk is
+// ENOSPC.
+func (f *FruitFS) errno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, client.ErrOffline):
+		f.stats.OfflineErrors.Add(1)
+		return syscall.ENETUNREACH
+	case errors.Is(err, client.ErrNotFound):
+		return syscall.ENOENT
+	case errors.Is(err, client.ErrAccessDenied), errors.Is(err, client.ErrPasswordChangeRequired),
+		client.IsUnauthorized(err):
+		return syscall.EACCES
+	case errors.Is(err, client.ErrReadOnly), errors.Is(err, client.ErrMaintenance):
+		return syscall.EROFS
+	case errors.Is(err, client.ErrConflict):
+		return syscall.EEXIST
+	case errors.Is(err, client.ErrInvalidPath):
+		return syscall.EINVAL
+	case errors.Is(err, client.ErrQuotaExceeded):
+		return syscall.EDQUOT
+	case errors.Is(err, client.ErrFileTooLarge):
+		return syscall.EFBIG
+	case errors.Is(err, cache.ErrDiskFull), errors.Is(err, syscall.ENOSPC):
+		f.stats.DiskFullErrors.Add(1)
+		return syscall.ENOSPC
+	case errors.Is(err, client.ErrRateLimited), errors.Is(err, client.ErrBandwidthExceeded),
+		errors.Is(err, client.ErrStorageUnavailable):
+		return syscall.EAGAIN
+	}
+	return syscall.EIO
+}
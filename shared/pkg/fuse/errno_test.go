@@ -0,0 +1,51 @@
+package fuse
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/cache"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+func TestErrno(t *testing.T) {
+	f := newTestFS(t)
+	apiErr := func(status int, code protocol.ErrorCode) error {
+		return fmt.Errorf("upload failed: %w", &client.APIError{StatusCode: status, Code: code})
+	}
+	tests := []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{nil, 0},
+		{client.ErrOffline, syscall.ENETUNREACH},
+		{apiErr(http.StatusNotFound, protocol.ErrCodeNotFound), syscall.ENOENT},
+		{apiErr(http.StatusNotFound, ""), syscall.ENOENT},
+		{apiErr(http.StatusForbidden, protocol.ErrCodeAccessDenied), syscall.EACCES},
+		{apiErr(http.StatusForbidden, protocol.ErrCodeReadOnly), syscall.EROFS},
+		{apiErr(http.StatusUnauthorized, protocol.ErrCodeUnauthorized), syscall.EACCES},
+		{apiErr(http.StatusConflict, protocol.ErrCodeConflict), syscall.EEXIST},
+		{apiErr(http.StatusBadRequest, protocol.ErrCodeInvalidPath), syscall.EINVAL},
+		{apiErr(http.StatusRequestEntityTooLarge, protocol.ErrCodeQuotaExceeded), syscall.EDQUOT},
+		{apiErr(http.StatusRequestEntityTooLarge, protocol.ErrCodeFileTooLarge), syscall.EFBIG},
+		{apiErr(http.StatusTooManyRequests, protocol.ErrCodeBandwidthExceeded), syscall.EAGAIN},
+		{apiErr(http.StatusServiceUnavailable, protocol.ErrCodeStorageUnavailable), syscall.EAGAIN},
+		{apiErr(http.StatusServiceUnavailable, protocol.ErrCodeMaintenance), syscall.EROFS},
+		{apiErr(http.StatusInternalServerError, protocol.ErrCodeInternal), syscall.EIO},
+		{fmt.Errorf("disk full"), syscall.EIO},
+		{fmt.Errorf("cache: %w", cache.ErrDiskFull), syscall.ENOSPC},
+		{&os.PathError{Op: "write", Path: "/cache/f", Err: syscall.ENOSPC}, syscall.ENOSPC},
+	}
+	for _, tt := range tests {
+		if got := f.errno(tt.err); got != tt.want {
+			t.Errorf("errno(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if n := f.stats.DiskFullErrors.Load(); n != 2 {
+		t.Errorf("DiskFullErrors = %d, want 2", n)
+	}
+}
@@ -3,13 +3,12 @@ package fuse
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"hash"
 	"io"
+	"math/rand"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,6 +22,9 @@ import (
 	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/selective"
 	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
 )
 
@@ -37,13 +39,30 @@ type FruitFS struct {
 
 	mu       sync.RWMutex
 	metadata *models.FileNode
+	lazy     bool                 // metadata is loaded one directory at a time (lazy.go)
+	loaded   map[string]time.Time // lazy mode: directories whose children are in metadata
+	treeETag string               // server ETag the metadata is current with, "" if unknown
+	excludes *selective.Excludes  // selective sync (selective.go)
 
-	refreshTicker *time.Ticker
-	refreshStop   chan struct{}
+	refreshRunning bool
+	refreshStop    chan struct{}
 
 	sseCancel    context.CancelFunc
 	healthCancel context.CancelFunc
 
+	dirtyMu sync.Mutex
+	dirty   map[string]int // path -> open handles with unflushed writes
+
+	movesMu  sync.Mutex
+	ownMoves map[string]time.Time // paths of own renames -> until when their event is expected
+
+	hostname string // used in conflict copy names
+
+	root *FruitNode // root of the mount, nil until Mount; kernel caches are invalidated through it
+
+	fetchRetry retry.Config // content fetches through connection errors
+	fetches    *fetchGroup  // content fetches in flight and blocks read ahead (readahead.go)
+
 	stats Stats
 }
 
@@ -64,6 +83,38 @@ type Stats struct {
 	FilesDeleted    atomic.Int64
 	DirsDeleted     atomic.Int64
 	Renames         atomic.Int64
+	OpenHandles     atomic.Int64 // currently open file handles
+
+	// DiskFullErrors counts operations failed with ENOSPC because the
+	// cache's volume was down to its free space reserve.
+	DiskFullErrors atomic.Int64
+
+	// RefreshesSkipped counts refreshes the server answered with 304
+	// because the tree had not changed.
+	RefreshesSkipped atomic.Int64
+
+	// Reconnection: content fetches retried after a connection error,
+	// event streams re-established and times the server came back after
+	// an outage. The last two are each followed by a metadata refresh.
+	FetchRetries     atomic.Int64
+	StreamReconnects atomic.Int64
+	ServerReconnects atomic.Int64
+
+	// CoalescedFetches counts content fetches that waited for a download
+	// of the same content already in flight instead of starting one;
+	// ReadAheadHits counts range reads answered from a block read ahead.
+	CoalescedFetches atomic.Int64
+	ReadAheadHits    atomic.Int64
+
+	// Metadata fetch timing: count and total duration of completed fetches
+	MetadataFetchTimed atomic.Int64
+	MetadataFetchNanos atomic.Int64
+}
+
+// recordMetadataFetch records the duration of a completed metadata fetch.
+func (s *Stats) recordMetadataFetch(start time.Time) {
+	s.MetadataFetchTimed.Add(1)
+	s.MetadataFetchNanos.Add(int64(time.Since(start)))
 }
 
 // FruitNode represents a file or directory in the filesystem.
@@ -82,7 +133,19 @@ type Config struct {
 	RefreshInterval   time.Duration
 	VerifyHash        bool
 	WatchSSE          bool
+	WatchTransport    string // client.TransportAuto (default), TransportSSE or TransportWS
 	HealthCheckPeriod time.Duration
+	APIKey            string        // authenticate with an API key instead of a JWT
+	ConflictPolicy    string        // ConflictCopy (default) or ConflictOverwrite
+	CachePolicy       string        // cache.PolicySizeAge (default) or cache.PolicyLRU
+	MinFreeSpace      int64         // free bytes kept on the cache's volume (0 = 1 GB or 5%, negative = none)
+	DirSizes          bool          // report a directory's aggregate size as its st_size and st_blocks
+	Exclude           []string      // server folders left out of the mount, besides the sync-config file
+	ReadOnly          bool          // reject writes with EROFS without contacting the server
+	SymlinkAliases    bool          // show aliases as symbolic links to their target
+	ReadAhead         int64         // bytes sequential range reads of large files are extended to (0 = 1 MiB, negative = none)
+	AttrTimeout       time.Duration // how long the kernel caches names and attributes (0 = RefreshInterval, or 5s without one; negative = not at all)
+	RateLimits        client.RateLimits
 }
 
 // NewFruitFS creates a new FUSE filesystem.
@@ -90,15 +153,37 @@ func NewFruitFS(cfg Config) (*FruitFS, error) {
 	if cfg.MaxCacheSize == 0 {
 		cfg.MaxCacheSize = 1 << 30 // 1GB
 	}
+	if cfg.ConflictPolicy == "" {
+		cfg.ConflictPolicy = ConflictCopy
+	}
+	if !ValidConflictPolicy(cfg.ConflictPolicy) {
+		return nil, fmt.Errorf("unknown conflict policy %q", cfg.ConflictPolicy)
+	}
+
+	if cfg.CachePolicy == "" {
+		cfg.CachePolicy = cache.PolicySizeAge
+	}
+	if !cache.ValidPolicy(cfg.CachePolicy) {
+		return nil, fmt.Errorf("unknown cache policy %q", cfg.CachePolicy)
+	}
 
 	c, err := cache.New(cfg.CacheDir, cfg.MaxCacheSize)
 	if err != nil {
 		return nil, fmt.Errorf("create cache: %w", err)
 	}
+	c.SetPolicy(cfg.CachePolicy)
+	c.SetMinFree(cfg.MinFreeSpace)
+
+	excludes, err := selective.LoadWith(cfg.CacheDir, cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("selective sync: %w", err)
+	}
 
 	clientCfg := client.Config{
-		BaseURL: strings.TrimSuffix(cfg.ServerURL, "/"),
-		Timeout: 60 * time.Second,
+		BaseURL:    strings.TrimSuffix(cfg.ServerURL, "/"),
+		Timeout:    60 * time.Second,
+		APIKey:     cfg.APIKey,
+		RateLimits: cfg.RateLimits,
 	}
 
 	f := &FruitFS{
@@ -106,10 +191,26 @@ func NewFruitFS(cfg Config) (*FruitFS, error) {
 		cache:       c,
 		cfg:         cfg,
 		refreshStop: make(chan struct{}),
+		dirty:       make(map[string]int),
+		ownMoves:    make(map[string]time.Time),
+		hostname:    conflictHost(),
+		fetchRetry:  contentRetry,
+		fetches:     newFetchGroup(),
+		excludes:    excludes,
 	}
 
 	if cfg.WatchSSE {
 		f.sseClient = client.NewSSEClient(cfg.ServerURL)
+		f.sseClient.SetAPIKey(cfg.APIKey)
+		if cfg.WatchTransport != "" {
+			f.sseClient.SetTransport(cfg.WatchTransport)
+		}
+		// The stream ends shortly before its token expires; it resumes
+		// with the token the client refreshes
+		f.sseClient.SetReauthHandler(f.client.Reauthenticate)
+		f.client.OnTokenRefreshed(func(resp *client.RefreshResponse) {
+			f.sseClient.SetAuthToken(resp.Token)
+		})
 	}
 
 	return f, nil
@@ -123,41 +224,102 @@ func (f *FruitFS) SetAuthToken(token string) {
 	}
 }
 
-// FetchMetadata fetches the metadata tree from the server.
+// FetchMetadata fetches the metadata tree from the server. Servers that
+// support depth-limited trees return only the top level; directories below
+// are fetched on first use (see lazy.go).
 func (f *FruitFS) FetchMetadata(ctx context.Context) error {
 	logger.Info("Fetching metadata from %s", f.cfg.ServerURL)
 
-	tree, err := f.client.FetchMetadata(ctx)
+	start := time.Now()
+	tree, partial, err := f.client.FetchDir(ctx, "/")
 	if err != nil {
+		if ue := f.client.UpgradeRequired(); ue != nil {
+			return ue
+		}
 		return fmt.Errorf("fetch metadata: %w", err)
 	}
+	f.prune(tree)
 
 	f.mu.Lock()
 	f.metadata = tree
+	f.lazy = partial
+	f.treeETag = ""
+	f.loaded = make(map[string]time.Time)
+	if partial {
+		f.loaded["/"] = time.Now()
+	}
 	f.mu.Unlock()
 
 	f.stats.MetadataFetches.Add(1)
-	logger.Info("Metadata loaded: %d items", fstree.CountNodes(tree))
+	f.stats.recordMetadataFetch(start)
+	if partial {
+		logger.Info("Metadata loaded: %d top-level items, directories load on demand", len(tree.Children))
+	} else {
+		logger.Info("Metadata loaded: %d items", fstree.CountNodes(tree))
+	}
 	return nil
 }
 
-// RefreshMetadata refreshes the metadata tree.
+// RefreshMetadata refreshes the metadata tree. In lazy mode only the
+// directories listed so far are refetched. The request is conditional:
+// when the server reports the tree unchanged, nothing is transferred and
+// the refresh counts as skipped.
 func (f *FruitFS) RefreshMetadata(ctx context.Context) error {
 	logger.Debug("Refreshing metadata...")
 
-	tree, err := f.client.FetchMetadata(ctx)
+	f.mu.RLock()
+	etag := f.treeETag
+	f.mu.RUnlock()
+
+	if f.isLazy() {
+		newETag, changed, err := f.client.TreeChanged(ctx, etag)
+		if err == nil && !changed {
+			f.stats.RefreshesSkipped.Add(1)
+			logger.Debug("Metadata unchanged")
+			return nil
+		}
+		if err := f.refreshLoaded(ctx); err != nil {
+			if ue := f.client.UpgradeRequired(); ue != nil {
+				return ue
+			}
+			logger.Error("Metadata refresh failed: %v", err)
+			return err
+		}
+		if err == nil {
+			// Taken before the refetch, so at worst the next refresh
+			// fetches again
+			f.mu.Lock()
+			f.treeETag = newETag
+			f.mu.Unlock()
+		}
+		return nil
+	}
+
+	start := time.Now()
+	tree, newETag, err := f.client.FetchMetadataIfChanged(ctx, etag)
+	if errors.Is(err, client.ErrNotModified) {
+		f.stats.RefreshesSkipped.Add(1)
+		logger.Debug("Metadata unchanged")
+		return nil
+	}
 	if err != nil {
+		if ue := f.client.UpgradeRequired(); ue != nil {
+			return ue
+		}
 		logger.Error("Metadata refresh failed: %v", err)
 		return err
 	}
+	f.prune(tree)
 
 	f.mu.Lock()
 	oldCount := fstree.CountNodes(f.metadata)
 	f.metadata = tree
+	f.treeETag = newETag
 	newCount := fstree.CountNodes(tree)
 	f.mu.Unlock()
 
 	f.stats.MetadataFetches.Add(1)
+	f.stats.recordMetadataFetch(start)
 
 	if oldCount != newCount {
 		logger.Info("Metadata refreshed: %d -> %d items", oldCount, newCount)
@@ -168,34 +330,73 @@ func (f *FruitFS) RefreshMetadata(ctx context.Context) error {
 	return nil
 }
 
-// StartRefreshLoop starts periodic metadata refresh.
+// StartRefreshLoop starts periodic metadata refresh. Pin rules are loaded
+// (and pinned folders filled) at start and with every refresh.
 func (f *FruitFS) StartRefreshLoop(ctx context.Context) {
 	if f.cfg.RefreshInterval <= 0 {
+		go f.syncPinRules(ctx)
 		return
 	}
 
-	f.refreshTicker = time.NewTicker(f.cfg.RefreshInterval)
+	f.refreshRunning = true
 
 	go func() {
+		f.syncPinRules(ctx)
+		failures := 0
 		for {
+			timer := time.NewTimer(refreshDelay(f.cfg.RefreshInterval, failures))
 			select {
-			case <-f.refreshTicker.C:
-				f.RefreshMetadata(ctx)
+			case <-timer.C:
 			case <-f.refreshStop:
+				timer.Stop()
 				return
 			case <-ctx.Done():
+				timer.Stop()
 				return
 			}
+			if f.refreshOnce(ctx) {
+				failures = 0
+			} else {
+				failures++
+			}
 		}
 	}()
 
 	logger.Info("Metadata refresh enabled: every %v", f.cfg.RefreshInterval)
 }
 
+// refreshOnce runs one round of the refresh loop and reports whether the
+// metadata was refreshed. A panic is logged and counts as a failure, so
+// that the loop keeps running.
+func (f *FruitFS) refreshOnce(ctx context.Context) (ok bool) {
+	defer logger.Recover("metadata refresh")
+	f.reloadExcludes(ctx)
+	err := f.RefreshMetadata(ctx)
+	f.syncPinRules(ctx)
+	return err == nil
+}
+
+// refreshBackoffMax caps the refresh interval after repeated failures,
+// unless the configured interval is longer.
+const refreshBackoffMax = 5 * time.Minute
+
+// refreshDelay returns the wait before the next refresh: the interval
+// doubled for every failure in a row up to refreshBackoffMax, with ±20%
+// jitter so that clients started together do not refresh in lockstep.
+func refreshDelay(interval time.Duration, failures int) time.Duration {
+	d := interval
+	limit := max(refreshBackoffMax, interval)
+	for i := 0; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
 // StopRefreshLoop stops the metadata refresh loop.
 func (f *FruitFS) StopRefreshLoop() {
-	if f.refreshTicker != nil {
-		f.refreshTicker.Stop()
+	if f.refreshRunning {
+		f.refreshRunning = false
 		close(f.refreshStop)
 	}
 }
@@ -209,6 +410,7 @@ func (f *FruitFS) StartSSEWatch(ctx context.Context) {
 	sseCtx, cancel := context.WithCancel(ctx)
 	f.sseCancel = cancel
 
+	f.sseClient.SetReconnectHandler(func() { f.onStreamReconnect(sseCtx) })
 	events, errors := f.sseClient.Subscribe(sseCtx)
 
 	go func() {
@@ -218,12 +420,7 @@ func (f *FruitFS) StartSSEWatch(ctx context.Context) {
 				if !ok {
 					return
 				}
-				logger.Debug("SSE event: %s %s", event.Type, event.Path)
-				f.stats.MetadataFetches.Add(1)
-
-				if err := f.RefreshMetadata(ctx); err != nil {
-					logger.Error("SSE refresh failed: %v", err)
-				}
+				f.handleEvent(ctx, event)
 
 			case err, ok := <-errors:
 				if !ok {
@@ -242,6 +439,51 @@ func (f *FruitFS) StartSSEWatch(ctx context.Context) {
 	logger.Info("SSE watch enabled")
 }
 
+// handleEvent applies a server event to the tree and cache. A panic is
+// logged rather than ending the watch.
+func (f *FruitFS) handleEvent(ctx context.Context, event client.SSEEvent) {
+	defer logger.Recover("SSE event")
+	logger.Debug("SSE event: %s %s", event.Type, event.Path)
+	if event.NeedsResync() {
+		// Missed events are gone from the server's buffer
+		logger.Info("SSE events were missed, refetching metadata")
+		if err := f.FetchMetadata(ctx); err != nil {
+			logger.Error("SSE resync failed: %v", err)
+		}
+		return
+	}
+	if event.Type == protocol.EventMaintenance && event.Maintenance != nil {
+		if event.Maintenance.ReadOnly {
+			logger.Info("Server is in read-only maintenance mode: %s", event.Maintenance.Message)
+		} else {
+			logger.Info("Server maintenance mode ended")
+		}
+		return
+	}
+	if !event.IsTreeChange() {
+		return
+	}
+	if f.isExcluded(event.Path) {
+		return
+	}
+	if f.consumeOwnMove(event.Path) {
+		// The tree and cache already reflect our own rename
+		logger.Debug("SSE event for own rename skipped: %s", event.Path)
+		return
+	}
+	f.stats.MetadataFetches.Add(1)
+
+	if err := f.refreshForEvent(ctx, event.Path); err != nil {
+		logger.Error("SSE refresh failed: %v", err)
+		return
+	}
+	f.invalidatePath(event.Path)
+	switch event.Type {
+	case protocol.EventCreate, protocol.EventModify, protocol.EventVersion:
+		go f.fetchIfPinned(ctx, event.Path)
+	}
+}
+
 // StopSSEWatch stops the SSE event watcher.
 func (f *FruitFS) StopSSEWatch() {
 	if f.sseCancel != nil {
@@ -263,18 +505,11 @@ func (f *FruitFS) StartHealthCheck(ctx context.Context) {
 		ticker := time.NewTicker(f.cfg.HealthCheckPeriod)
 		defer ticker.Stop()
 
+		st := healthState{outages: f.client.Outages(), rejected: f.client.UpgradeRequired() != nil}
 		for {
 			select {
 			case <-ticker.C:
-				wasOnline := f.client.IsOnline()
-				err := f.client.Ping(healthCtx)
-
-				if err == nil && !wasOnline {
-					logger.Info("Server is back online, refreshing metadata...")
-					if refreshErr := f.RefreshMetadata(healthCtx); refreshErr != nil {
-						logger.Error("Failed to refresh metadata: %v", refreshErr)
-					}
-				}
+				st = f.checkHealth(healthCtx, st)
 			case <-healthCtx.Done():
 				return
 			}
@@ -318,15 +553,69 @@ func (f *FruitFS) Mount(mountPoint string) (*gofuse.Server, error) {
 	if err != nil {
 		return nil, fmt.Errorf("mount: %w", err)
 	}
+	f.root = root
 
 	return server, nil
 }
 
+// defaultAttrTimeout is how long the kernel caches names and attributes
+// when neither Config.AttrTimeout nor a refresh interval is set.
+const defaultAttrTimeout = 5 * time.Second
+
+// attrTimeout returns how long the kernel may cache names and attributes.
+// Changes from other clients reach the tree with the next metadata
+// refresh, so by default entries are trusted that long; server events
+// invalidate them earlier (invalidatePath).
+func (f *FruitFS) attrTimeout() time.Duration {
+	switch {
+	case f.cfg.AttrTimeout < 0:
+		return 0
+	case f.cfg.AttrTimeout > 0:
+		return f.cfg.AttrTimeout
+	case f.cfg.RefreshInterval > 0:
+		return f.cfg.RefreshInterval
+	default:
+		return defaultAttrTimeout
+	}
+}
+
+// invalidatePath drops what the kernel caches about the server path p:
+// its attributes and content, its entry in its parent directory and the
+// parent's listing. Only nodes the kernel knows are walked; paths it
+// never looked up have nothing cached.
+func (f *FruitFS) invalidatePath(p string) {
+	if f.root == nil {
+		return
+	}
+	parent := f.root.EmbeddedInode()
+	dirs := pathAndAncestors(parentDir(p))
+	for _, dir := range dirs[1:] {
+		if parent = parent.GetChild(path.Base(dir)); parent == nil {
+			return
+		}
+	}
+	parent.NotifyContent(0, 0)
+	if p == "/" {
+		return
+	}
+	name := path.Base(p)
+	if child := parent.GetChild(name); child != nil {
+		child.NotifyContent(0, 0)
+	}
+	parent.NotifyEntry(name)
+}
+
 // CacheStats returns cache statistics.
 func (f *FruitFS) CacheStats() (used, max int64, count int) {
 	return f.cache.Stats()
 }
 
+// SaveCacheIndex writes the cache index, including access times, so the
+// next mount starts with the current LRU order.
+func (f *FruitFS) SaveCacheIndex() error {
+	return f.cache.SaveIndex()
+}
+
 // GetStats returns filesystem statistics.
 func (f *FruitFS) GetStats() *Stats {
 	return &f.stats
@@ -337,6 +626,50 @@ func (f *FruitFS) IsOnline() bool {
 	return f.client.IsOnline()
 }
 
+// Health states reported by HealthState.
+const (
+	HealthOnline          = "online"
+	HealthOffline         = "offline"
+	HealthUpgradeRequired = "upgrade_required"
+	HealthAuthFailed      = "auth_failed"
+)
+
+// HealthState returns the connection state: online, offline,
+// upgrade_required when the server rejects this client's version, or
+// auth_failed when it rejected the token.
+func (f *FruitFS) HealthState() string {
+	if f.client.AuthFailed() != nil {
+		return HealthAuthFailed
+	}
+	if f.client.UpgradeRequired() != nil {
+		return HealthUpgradeRequired
+	}
+	if f.client.IsOnline() {
+		return HealthOnline
+	}
+	return HealthOffline
+}
+
+// setDirty tracks handles with unflushed writes for the file status.
+func (f *FruitFS) setDirty(path string, dirty bool) {
+	f.dirtyMu.Lock()
+	defer f.dirtyMu.Unlock()
+	if dirty {
+		f.dirty[path]++
+		return
+	}
+	if f.dirty[path]--; f.dirty[path] <= 0 {
+		delete(f.dirty, path)
+	}
+}
+
+// isDirty reports whether path has writes not yet uploaded.
+func (f *FruitFS) isDirty(path string) bool {
+	f.dirtyMu.Lock()
+	defer f.dirtyMu.Unlock()
+	return f.dirty[path] > 0
+}
+
 // Client returns the underlying HTTP client.
 func (f *FruitFS) Client() *client.Client {
 	return f.client
@@ -363,6 +696,8 @@ var _ fs.NodeReaddirer = (*FruitNode)(nil)
 var _ fs.NodeOpener = (*FruitNode)(nil)
 var _ fs.NodeReader = (*FruitNode)(nil)
 var _ fs.NodeGetxattrer = (*FruitNode)(nil)
+var _ fs.NodeSetxattrer = (*FruitNode)(nil)
+var _ fs.NodeRemovexattrer = (*FruitNode)(nil)
 var _ fs.NodeListxattrer = (*FruitNode)(nil)
 var _ fs.NodeCreater = (*FruitNode)(nil)
 var _ fs.NodeMkdirer = (*FruitNode)(nil)
@@ -378,35 +713,68 @@ func (n *FruitNode) Getattr(ctx context.Context, fh fs.FileHandle, out *gofuse.A
 	if meta == nil {
 		return syscall.ENOENT
 	}
+	n.fsys.fillAttr(meta, &out.Attr)
+	out.SetTimeout(n.fsys.attrTimeout())
+	return 0
+}
 
-	out.Mode = 0644
-	if meta.IsDir {
+// fillAttr sets the attributes of meta for Getattr, Lookup and
+// READDIRPLUS alike.
+func (f *FruitFS) fillAttr(meta *models.FileNode, out *gofuse.Attr) {
+	symlink := f.isSymlink(meta)
+	switch {
+	case symlink:
+		out.Mode = 0777 | syscall.S_IFLNK
+	case meta.IsDir:
 		out.Mode = 0755 | syscall.S_IFDIR
-	} else {
+	default:
 		out.Mode = 0644 | syscall.S_IFREG
 	}
+	if f.cfg.ReadOnly {
+		out.Mode &^= 0222
+	}
 
 	out.Size = uint64(meta.Size)
+	if symlink {
+		out.Size = uint64(len(aliasLink(meta)))
+	} else if meta.IsDir && f.cfg.DirSizes {
+		out.Size = uint64(meta.AggSize)
+		out.Blocks = (out.Size + 511) / 512
+	}
 	out.Mtime = uint64(meta.ModTime.Unix())
 	out.Atime = out.Mtime
 	out.Ctime = out.Mtime
 	out.Uid = uint32(os.Getuid())
 	out.Gid = uint32(os.Getgid())
+}
 
-	// Short attr timeout so kernel re-checks after metadata refresh
-	out.AttrValid = 5
-	out.AttrValidNsec = 0
+// childInode returns a new inode for the child meta of n, with its entry
+// and attributes in out.
+func (n *FruitNode) childInode(ctx context.Context, meta *models.FileNode, out *gofuse.EntryOut) *fs.Inode {
+	n.fsys.fillAttr(meta, &out.Attr)
+	timeout := n.fsys.attrTimeout()
+	out.SetEntryTimeout(timeout)
+	out.SetAttrTimeout(timeout)
 
-	return 0
+	child := &FruitNode{
+		fsys:     n.fsys,
+		metadata: meta,
+	}
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: out.Mode & syscall.S_IFMT})
 }
 
 // Lookup finds a child by name.
 func (n *FruitNode) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	n.fsys.ensureLoaded(ctx, n.metadata.Path)
 	meta := n.resolveMetadata()
 	if meta == nil || !meta.IsDir {
 		return nil, syscall.ENOENT
 	}
 
+	if meta.Path == "/" && name == controlDirName {
+		return n.lookupControlDir(ctx, out), 0
+	}
+
 	var childMeta *models.FileNode
 	for _, child := range meta.Children {
 		if child.Name == name {
@@ -418,55 +786,7 @@ func (n *FruitNode) Lookup(ctx context.Context, name string, out *gofuse.EntryOu
 	if childMeta == nil {
 		return nil, syscall.ENOENT
 	}
-
-	child := &FruitNode{
-		fsys:     n.fsys,
-		metadata: childMeta,
-	}
-
-	out.Mode = 0644
-	if childMeta.IsDir {
-		out.Mode = 0755 | syscall.S_IFDIR
-	} else {
-		out.Mode = 0644 | syscall.S_IFREG
-	}
-	out.Size = uint64(childMeta.Size)
-	out.Mtime = uint64(childMeta.ModTime.Unix())
-	out.Atime = out.Mtime
-	out.Ctime = out.Mtime
-	out.Uid = uint32(os.Getuid())
-	out.Gid = uint32(os.Getgid())
-
-	// Short entry/attr timeout so kernel re-checks after metadata refresh
-	out.EntryValid = 5
-	out.EntryValidNsec = 0
-	out.AttrValid = 5
-	out.AttrValidNsec = 0
-
-	stableAttr := fs.StableAttr{Mode: out.Mode}
-	return n.NewInode(ctx, child, stableAttr), 0
-}
-
-// Readdir lists directory contents.
-func (n *FruitNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	meta := n.resolveMetadata()
-	if meta == nil || !meta.IsDir {
-		return nil, syscall.ENOTDIR
-	}
-
-	entries := make([]gofuse.DirEntry, 0, len(meta.Children))
-	for _, child := range meta.Children {
-		mode := uint32(syscall.S_IFREG)
-		if child.IsDir {
-			mode = syscall.S_IFDIR
-		}
-		entries = append(entries, gofuse.DirEntry{
-			Name: child.Name,
-			Mode: mode,
-		})
-	}
-
-	return fs.NewListDirStream(entries), 0
+	return n.childInode(ctx, childMeta, out), 0
 }
 
 // Open prepares a file for reading or writing.
@@ -476,18 +796,25 @@ func (n *FruitNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint
 	}
 
 	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		if n.fsys.cfg.ReadOnly {
+			return nil, 0, syscall.EROFS
+		}
 		return n.openForWrite(ctx, flags&syscall.O_TRUNC != 0)
 	}
 
 	fileID := n.getFileID()
 
-	if cachePath, ok := n.fsys.cache.Get(fileID); ok {
+	// The handle leases the cached content so that it is not evicted
+	// while the file is open
+	if lease, ok := n.fsys.cache.Acquire(fileID); ok {
 		logger.Debug("Cache hit: %s", n.metadata.Path)
 		n.fsys.stats.CacheHits.Add(1)
+		n.fsys.stats.OpenHandles.Add(1)
 		return &FileHandle{
 			node:      n,
-			cachePath: cachePath,
+			cachePath: lease.Path(),
 			cached:    true,
+			lease:     lease,
 		}, gofuse.FOPEN_KEEP_CACHE, 0
 	}
 
@@ -503,21 +830,33 @@ func (n *FruitNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint
 
 	if n.metadata.Size < smallFileThreshold {
 		logger.Debug("Fetching small file: %s (%d bytes)", n.metadata.Path, n.metadata.Size)
-		cachePath, err := n.fetchFullContent(ctx)
+		cachePath, err := n.fetchFullContent(client.WithPriority(ctx, client.PriorityInteractive))
+		if errors.Is(err, cache.ErrDiskFull) {
+			// Not cached, but still readable from the server
+			logger.Warn("Disk full, reading %s from the server without caching", n.metadata.Path)
+			n.fsys.stats.OpenHandles.Add(1)
+			return &FileHandle{node: n}, 0, 0
+		}
 		if err != nil {
 			logger.Error("Fetch error: %v", err)
 			n.fsys.stats.FailedFetches.Add(1)
-			return nil, 0, syscall.EIO
+			return nil, 0, n.fsys.errno(err)
 		}
-		n.fsys.stats.ContentFetches.Add(1)
-		return &FileHandle{
+		n.fsys.stats.OpenHandles.Add(1)
+		fh := &FileHandle{
 			node:      n,
 			cachePath: cachePath,
 			cached:    true,
-		}, gofuse.FOPEN_KEEP_CACHE, 0
+		}
+		if lease, ok := n.fsys.cache.Acquire(fileID); ok {
+			fh.cachePath = lease.Path()
+			fh.lease = lease
+		}
+		return fh, gofuse.FOPEN_KEEP_CACHE, 0
 	}
 
 	logger.Debug("Opening large file for range reads: %s (%d bytes)", n.metadata.Path, n.metadata.Size)
+	n.fsys.stats.OpenHandles.Add(1)
 	return &FileHandle{
 		node:      n,
 		cachePath: "",
@@ -550,7 +889,9 @@ func (n *FruitNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off
 		return result, errno
 	}
 
-	return n.readRange(ctx, dest, off)
+	// An application is waiting for these bytes: they go ahead of
+	// prefetch and pinning under the bandwidth limits
+	return n.readRange(client.WithPriority(ctx, client.PriorityInteractive), dest, off, handle.sequential(off, len(dest)))
 }
 
 // Getxattr returns extended attribute value.
@@ -579,6 +920,16 @@ func (n *FruitNode) Getxattr(ctx context.Context, attr string, dest []byte) (uin
 		} else {
 			value = "false"
 		}
+	case "user.fruitsalade.health":
+		value = n.fsys.HealthState()
+	case xattrStatus:
+		value = n.fileStatus()
+	case xattrPin:
+		if n.fsys.cache.IsPinned(n.getFileID()) || n.fsys.cache.PinRuleFor(n.metadata.Path) != "" {
+			value = "1"
+		} else {
+			value = "0"
+		}
 	default:
 		return 0, syscall.ENODATA
 	}
@@ -604,6 +955,9 @@ func (n *FruitNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall
 		"user.fruitsalade.id",
 		"user.fruitsalade.hash",
 		"user.fruitsalade.online",
+		"user.fruitsalade.health",
+		xattrStatus,
+		xattrPin,
 	}
 
 	var total int
@@ -630,6 +984,103 @@ func (n *FruitNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall
 	return uint32(total), 0
 }
 
+// Setxattr handles writable attributes: user.fruitsalade.pin=1 downloads
+// and pins a file, =0 unpins it.
+func (n *FruitNode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
+	if attr != xattrPin {
+		return syscall.ENOTSUP
+	}
+	switch strings.TrimSpace(string(data)) {
+	case "1", "true":
+		return n.pin(ctx)
+	case "0", "false":
+		return n.unpin()
+	}
+	return syscall.EINVAL
+}
+
+// Removexattr removing user.fruitsalade.pin unpins the file.
+func (n *FruitNode) Removexattr(ctx context.Context, attr string) syscall.Errno {
+	if attr != xattrPin {
+		return syscall.ENOTSUP
+	}
+	return n.unpin()
+}
+
+// fileStatus returns dirty, pinned, cached or remote.
+func (n *FruitNode) fileStatus() string {
+	fileID := n.getFileID()
+	switch {
+	case n.fsys.isDirty(n.metadata.Path):
+		return StatusDirty
+	case n.fsys.cache.IsPinned(fileID):
+		return StatusPinned
+	case n.fsys.cache.IsCached(fileID):
+		return StatusCached
+	}
+	return StatusRemote
+}
+
+// pin makes sure the file is cached and keeps it from being evicted. For a
+// directory it adds a pin rule and downloads the contents in the background.
+func (n *FruitNode) pin(ctx context.Context) syscall.Errno {
+	if n.metadata.IsDir {
+		prefix := n.fsys.addPinRule(n.metadata.Path)
+		go func() {
+			if _, err := n.fsys.prefetchPrefix(context.Background(), prefix); err != nil {
+				logger.Error("Prefetch %s: %v", prefix, err)
+			}
+		}()
+		return 0
+	}
+	fileID := n.getFileID()
+	if !n.fsys.cache.IsCached(fileID) {
+		if !n.fsys.client.IsOnline() {
+			n.fsys.stats.OfflineErrors.Add(1)
+			return syscall.ENETUNREACH
+		}
+		if _, err := n.fetchFullContent(ctx); err != nil {
+			logger.Error("Pin %s: fetch failed: %v", n.metadata.Path, err)
+			n.fsys.stats.FailedFetches.Add(1)
+			return n.fsys.errno(err)
+		}
+		n.fsys.stats.ContentFetches.Add(1)
+	}
+	if err := n.fsys.cache.Pin(fileID); err != nil {
+		return syscall.EIO
+	}
+	if err := n.fsys.cache.SavePins(); err != nil {
+		logger.Error("Failed to persist pins: %v", err)
+	}
+	logger.Info("Pinned: %s", n.metadata.Path)
+	return 0
+}
+
+// unpin allows the file to be evicted again. Unpinning a file that is not
+// cached is a no-op.
+func (n *FruitNode) unpin() syscall.Errno {
+	if n.metadata.IsDir {
+		if n.fsys.cache.PinRuleFor(n.metadata.Path) == n.metadata.Path {
+			if err := n.fsys.UnpinPrefix(n.metadata.Path); err != nil {
+				return syscall.EIO
+			}
+		}
+		return 0
+	}
+	fileID := n.getFileID()
+	if !n.fsys.cache.IsPinned(fileID) {
+		return 0
+	}
+	if err := n.fsys.cache.Unpin(fileID); err != nil {
+		return syscall.EIO
+	}
+	if err := n.fsys.cache.SavePins(); err != nil {
+		logger.Error("Failed to persist pins: %v", err)
+	}
+	logger.Info("Unpinned: %s", n.metadata.Path)
+	return 0
+}
+
 func (n *FruitNode) readFromCache(cachePath string, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
 	f, err := os.Open(cachePath)
 	if err != nil {
@@ -650,40 +1101,38 @@ func (n *FruitNode) readFromCache(cachePath string, dest []byte, off int64) (gof
 	return gofuse.ReadResultData(dest[:bytesRead]), 0
 }
 
-func (n *FruitNode) readRange(ctx context.Context, dest []byte, off int64) (gofuse.ReadResult, syscall.Errno) {
+// readRange reads a large file that is not cached from the server. A
+// sequential read fetches the whole read-ahead block it falls in, which
+// the reads after it are answered from; any read is answered from such a
+// block when one is kept or in flight. Concurrent reads of the same range
+// share one download.
+func (n *FruitNode) readRange(ctx context.Context, dest []byte, off int64, sequential bool) (gofuse.ReadResult, syscall.Errno) {
 	if !n.fsys.client.IsOnline() {
 		logger.Error("Cannot read %s: server offline (range read)", n.metadata.Path)
 		n.fsys.stats.OfflineErrors.Add(1)
 		return nil, syscall.ENETUNREACH
 	}
 
-	fileID := strings.TrimPrefix(n.metadata.ID, "/")
-
 	end := off + int64(len(dest)) - 1
 	if end >= n.metadata.Size {
 		end = n.metadata.Size - 1
 	}
 	length := end - off + 1
+	if length <= 0 {
+		return gofuse.ReadResultData(nil), 0
+	}
 
 	logger.Debug("Range read: %s bytes=%d-%d", n.metadata.Path, off, end)
 
-	reader, _, err := n.fsys.client.FetchContent(ctx, fileID, off, length)
+	bytesRead, ok, err := n.readBlock(ctx, dest, off, length, sequential)
+	if !ok {
+		bytesRead, err = n.readExact(ctx, dest, off, length)
+	}
 	if err != nil {
 		logger.Error("Range read error: %v", err)
 		n.fsys.stats.FailedFetches.Add(1)
-		return nil, syscall.EIO
-	}
-	defer reader.Close()
-
-	bytesRead, err := io.ReadFull(reader, dest)
-	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-		logger.Error("Range read error: %v", err)
-		return nil, syscall.EIO
+		return nil, n.fsys.errno(err)
 	}
-
-	n.fsys.stats.RangeReads.Add(1)
-	n.fsys.stats.BytesDownloaded.Add(int64(bytesRead))
-
 	return gofuse.ReadResultData(dest[:bytesRead]), 0
 }
 
@@ -691,38 +1140,51 @@ func (n *FruitNode) getFileID() string {
 	return fstree.CacheID(n.metadata.ID)
 }
 
+// fetchFullContent downloads the file into the cache and returns the
+// cached file. Concurrent fetches of the same file download it once.
 func (n *FruitNode) fetchFullContent(ctx context.Context) (string, error) {
-	fileID := strings.TrimPrefix(n.metadata.ID, "/")
-
-	reader, _, err := n.fsys.client.FetchContentFull(ctx, fileID)
+	fl, err := n.fsys.fetches.do(ctx, "file:"+n.contentKey(), n.fsys.coalesced, func(fl *flight) error {
+		var err error
+		fl.path, err = n.downloadFullContent(ctx)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
-	defer reader.Close()
+	return fl.path, nil
+}
 
-	var hashReader io.Reader = reader
-	var hasher hash.Hash
-	if n.fsys.cfg.VerifyHash && n.metadata.Hash != "" {
-		hasher = sha256.New()
-		hashReader = io.TeeReader(reader, hasher)
-	}
+func (n *FruitNode) downloadFullContent(ctx context.Context) (string, error) {
+	fileID := strings.TrimPrefix(n.metadata.ID, "/")
 
-	cacheID := n.getFileID()
-	cachePath, err := n.fsys.cache.Put(cacheID, hashReader, n.metadata.Size)
+	// An interrupted download resumes from its partial file on the next open
+	// and, after a connection error, on the next attempt
+	var fetched int64
+	var cachePath string
+	err := n.fsys.fetchWithRetry(ctx, "download of "+n.metadata.Path, func() error {
+		var err error
+		cachePath, err = n.fsys.cache.PutResumable(n.getFileID(), n.metadata.Path, n.metadata.Hash,
+			n.metadata.Size, n.fsys.cfg.VerifyHash, func(offset int64) (io.ReadCloser, int64, error) {
+				reader, start, err := n.fsys.client.FetchContentFrom(ctx, fileID, offset)
+				if err == nil {
+					fetched = n.metadata.Size - start
+					if start > 0 {
+						logger.Debug("Resuming download of %s at %d bytes", n.metadata.Path, start)
+					}
+				}
+				return reader, start, err
+			})
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
-
-	if hasher != nil && n.metadata.Hash != "" {
-		actualHash := hex.EncodeToString(hasher.Sum(nil))
-		if actualHash != n.metadata.Hash {
-			n.fsys.cache.Evict(cacheID)
-			return "", fmt.Errorf("hash mismatch: expected %s, got %s", n.metadata.Hash, actualHash)
-		}
+	if n.fsys.cfg.VerifyHash && n.metadata.Hash != "" {
 		logger.Debug("Hash verified: %s", n.metadata.Path)
 	}
 
-	n.fsys.stats.BytesDownloaded.Add(n.metadata.Size)
+	n.fsys.stats.ContentFetches.Add(1)
+	n.fsys.stats.BytesDownloaded.Add(fetched)
 
 	return cachePath, nil
 }
@@ -732,6 +1194,7 @@ type FileHandle struct {
 	node      *FruitNode
 	cachePath string
 	cached    bool
+	lease     *cache.Lease // keeps cachePath from being evicted
 
 	// Write support
 	mu       sync.Mutex
@@ -739,6 +1202,10 @@ type FileHandle struct {
 	dirty    bool
 	tmpFile  *os.File
 	size     int64
+
+	// Range reads: the previous read, for read-ahead (sequential)
+	lastRead int64
+	nextRead int64
 }
 
 var _ fs.FileHandle = (*FileHandle)(nil)
@@ -746,23 +1213,33 @@ var _ fs.FileWriter = (*FileHandle)(nil)
 var _ fs.FileFlusher = (*FileHandle)(nil)
 var _ fs.FileReleaser = (*FileHandle)(nil)
 
-// openForWrite prepares a file for writing with a temp file buffer.
+// openForWrite prepares a file for writing with a temp file buffer. The
+// buffer lives in the cache directory, so its content must fit above the
+// free space reserve; if it does not, the open fails with ENOSPC.
 func (n *FruitNode) openForWrite(ctx context.Context, truncate bool) (fs.FileHandle, uint32, syscall.Errno) {
+	preload := !truncate && n.metadata.Size > 0
+	if preload {
+		if err := n.fsys.cache.EnsureFree(n.metadata.Size); err != nil {
+			return nil, 0, n.fsys.errno(err)
+		}
+	}
+
 	tmpFile, err := os.CreateTemp(n.fsys.cfg.CacheDir, "fruitsalade-write-*")
 	if err != nil {
 		logger.Error("Failed to create temp file: %v", err)
-		return nil, 0, syscall.EIO
+		return nil, 0, n.fsys.errno(err)
 	}
 
 	var size int64
 
 	// If not truncating, pre-load existing content
-	if !truncate && n.metadata.Size > 0 {
+	if preload {
 		fileID := n.getFileID()
+		var copyErr error
 		if cachePath, ok := n.fsys.cache.Get(fileID); ok {
 			src, err := os.Open(cachePath)
 			if err == nil {
-				size, _ = io.Copy(tmpFile, src)
+				size, copyErr = io.Copy(tmpFile, src)
 				src.Close()
 				tmpFile.Seek(0, io.SeekStart)
 			}
@@ -770,13 +1247,20 @@ func (n *FruitNode) openForWrite(ctx context.Context, truncate bool) (fs.FileHan
 			serverID := strings.TrimPrefix(n.metadata.ID, "/")
 			reader, _, err := n.fsys.client.FetchContentFull(ctx, serverID)
 			if err == nil {
-				size, _ = io.Copy(tmpFile, reader)
+				size, copyErr = io.Copy(tmpFile, reader)
 				reader.Close()
 				tmpFile.Seek(0, io.SeekStart)
 			}
 		}
+		if errors.Is(copyErr, syscall.ENOSPC) {
+			// A partial copy would upload a truncated file on flush
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+			return nil, 0, n.fsys.errno(copyErr)
+		}
 	}
 
+	n.fsys.stats.OpenHandles.Add(1)
 	return &FileHandle{
 		node:     n,
 		writable: true,
@@ -790,6 +1274,9 @@ func (n *FruitNode) Create(ctx context.Context, name string, flags uint32, mode
 	if n.metadata == nil || !n.metadata.IsDir {
 		return nil, nil, 0, syscall.ENOTDIR
 	}
+	if n.fsys.cfg.ReadOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
 
 	n.fsys.mu.RLock()
 	for _, child := range n.metadata.Children {
@@ -802,6 +1289,10 @@ func (n *FruitNode) Create(ctx context.Context, name string, flags uint32, mode
 
 	now := time.Now()
 	path := buildChildPath(n.metadata.Path, name)
+	if n.fsys.isExcluded(path) {
+		logger.Error("Cannot create %s: excluded by selective sync", path)
+		return nil, nil, 0, syscall.EACCES
+	}
 
 	childMeta := &models.FileNode{
 		ID:      path,
@@ -819,10 +1310,10 @@ func (n *FruitNode) Create(ctx context.Context, name string, flags uint32, mode
 	}
 
 	n.fsys.mu.Lock()
-	n.metadata.Children = append(n.metadata.Children, childMeta)
+	fstree.InsertChild(n.metadata, childMeta)
 	// Also update the FruitFS tree so resolveMetadata sees the new file
 	if treeNode := fstree.FindByPath(n.fsys.metadata, n.metadata.Path); treeNode != nil && treeNode != n.metadata {
-		treeNode.Children = append(treeNode.Children, childMeta)
+		fstree.InsertChild(treeNode, childMeta)
 	}
 	n.fsys.mu.Unlock()
 
@@ -850,6 +1341,7 @@ func (n *FruitNode) Create(ctx context.Context, name string, flags uint32, mode
 	}
 
 	n.fsys.stats.FilesCreated.Add(1)
+	n.fsys.stats.OpenHandles.Add(1)
 	logger.Info("Created file: %s", path)
 
 	return inode, fh, 0, 0
@@ -860,6 +1352,9 @@ func (n *FruitNode) Mkdir(ctx context.Context, name string, mode uint32, out *go
 	if n.metadata == nil || !n.metadata.IsDir {
 		return nil, syscall.ENOTDIR
 	}
+	if n.fsys.cfg.ReadOnly {
+		return nil, syscall.EROFS
+	}
 
 	n.fsys.mu.RLock()
 	for _, child := range n.metadata.Children {
@@ -871,11 +1366,15 @@ func (n *FruitNode) Mkdir(ctx context.Context, name string, mode uint32, out *go
 	n.fsys.mu.RUnlock()
 
 	path := buildChildPath(n.metadata.Path, name)
+	if n.fsys.isExcluded(path) {
+		logger.Error("Cannot create %s: excluded by selective sync", path)
+		return nil, syscall.EACCES
+	}
 	serverPath := strings.TrimPrefix(path, "/")
 
 	if err := n.fsys.client.CreateDirectory(ctx, serverPath); err != nil {
 		logger.Error("Mkdir failed for %s: %v", path, err)
-		return nil, syscall.EIO
+		return nil, n.fsys.errno(err)
 	}
 
 	now := time.Now()
@@ -888,9 +1387,9 @@ func (n *FruitNode) Mkdir(ctx context.Context, name string, mode uint32, out *go
 	}
 
 	n.fsys.mu.Lock()
-	n.metadata.Children = append(n.metadata.Children, childMeta)
+	fstree.InsertChild(n.metadata, childMeta)
 	if treeNode := fstree.FindByPath(n.fsys.metadata, n.metadata.Path); treeNode != nil && treeNode != n.metadata {
-		treeNode.Children = append(treeNode.Children, childMeta)
+		fstree.InsertChild(treeNode, childMeta)
 	}
 	n.fsys.mu.Unlock()
 
@@ -918,6 +1417,9 @@ func (n *FruitNode) Unlink(ctx context.Context, name string) syscall.Errno {
 	if n.metadata == nil || !n.metadata.IsDir {
 		return syscall.ENOTDIR
 	}
+	if n.fsys.cfg.ReadOnly {
+		return syscall.EROFS
+	}
 
 	n.fsys.mu.RLock()
 	var target *models.FileNode
@@ -939,7 +1441,7 @@ func (n *FruitNode) Unlink(ctx context.Context, name string) syscall.Errno {
 	serverPath := strings.TrimPrefix(target.Path, "/")
 	if err := n.fsys.client.DeletePath(ctx, serverPath); err != nil {
 		logger.Error("Delete failed for %s: %v", target.Path, err)
-		return syscall.EIO
+		return n.fsys.errno(err)
 	}
 
 	n.fsys.cache.Evict(fstree.CacheID(target.ID))
@@ -961,6 +1463,9 @@ func (n *FruitNode) Rmdir(ctx context.Context, name string) syscall.Errno {
 	if n.metadata == nil || !n.metadata.IsDir {
 		return syscall.ENOTDIR
 	}
+	if n.fsys.cfg.ReadOnly {
+		return syscall.EROFS
+	}
 
 	n.fsys.mu.RLock()
 	var target *models.FileNode
@@ -985,7 +1490,7 @@ func (n *FruitNode) Rmdir(ctx context.Context, name string) syscall.Errno {
 	serverPath := strings.TrimPrefix(target.Path, "/")
 	if err := n.fsys.client.DeletePath(ctx, serverPath); err != nil {
 		logger.Error("Rmdir failed for %s: %v", target.Path, err)
-		return syscall.EIO
+		return n.fsys.errno(err)
 	}
 
 	n.fsys.mu.Lock()
@@ -1005,6 +1510,14 @@ func (n *FruitNode) Setattr(ctx context.Context, f fs.FileHandle, in *gofuse.Set
 	if n.metadata == nil {
 		return syscall.ENOENT
 	}
+	if n.fsys.cfg.ReadOnly {
+		if _, ok := in.GetSize(); ok {
+			return syscall.EROFS
+		}
+		if _, ok := in.GetMTime(); ok {
+			return syscall.EROFS
+		}
+	}
 
 	if sz, ok := in.GetSize(); ok {
 		n.fsys.mu.Lock()
@@ -1029,114 +1542,6 @@ func (n *FruitNode) Setattr(ctx context.Context, f fs.FileHandle, in *gofuse.Set
 	return n.Getattr(ctx, f, out)
 }
 
-// Rename moves a file or directory.
-func (n *FruitNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
-	n.fsys.mu.RLock()
-	var source *models.FileNode
-	for _, child := range n.metadata.Children {
-		if child.Name == name {
-			source = child
-			break
-		}
-	}
-	n.fsys.mu.RUnlock()
-
-	if source == nil {
-		return syscall.ENOENT
-	}
-
-	newParentNode, ok := newParent.(*FruitNode)
-	if !ok {
-		return syscall.EIO
-	}
-
-	// Check RENAME_NOREPLACE
-	if flags&1 != 0 {
-		n.fsys.mu.RLock()
-		for _, child := range newParentNode.metadata.Children {
-			if child.Name == newName {
-				n.fsys.mu.RUnlock()
-				return syscall.EEXIST
-			}
-		}
-		n.fsys.mu.RUnlock()
-	}
-
-	newPath := buildChildPath(newParentNode.metadata.Path, newName)
-
-	if source.IsDir {
-		if len(source.Children) > 0 {
-			logger.Error("Rename of non-empty directory not supported: %s", source.Path)
-			return syscall.ENOTSUP
-		}
-		serverNewPath := strings.TrimPrefix(newPath, "/")
-		if err := n.fsys.client.CreateDirectory(ctx, serverNewPath); err != nil {
-			logger.Error("Rename create dir failed: %v", err)
-			return syscall.EIO
-		}
-		serverOldPath := strings.TrimPrefix(source.Path, "/")
-		n.fsys.client.DeletePath(ctx, serverOldPath)
-	} else {
-		// For files: read content, upload under new path, delete old
-		var content io.ReadCloser
-		var size int64
-
-		srcCacheID := fstree.CacheID(source.ID)
-		if cachePath, ok := n.fsys.cache.Get(srcCacheID); ok {
-			f, err := os.Open(cachePath)
-			if err != nil {
-				return syscall.EIO
-			}
-			info, _ := f.Stat()
-			content = f
-			size = info.Size()
-		} else if n.fsys.client.IsOnline() {
-			serverID := strings.TrimPrefix(source.ID, "/")
-			var err error
-			content, size, err = n.fsys.client.FetchContentFull(ctx, serverID)
-			if err != nil {
-				logger.Error("Rename fetch failed: %v", err)
-				return syscall.EIO
-			}
-		} else {
-			return syscall.ENETUNREACH
-		}
-		defer content.Close()
-
-		serverNewPath := strings.TrimPrefix(newPath, "/")
-		if _, err := n.fsys.client.UploadFile(ctx, serverNewPath, content, size, 0); err != nil {
-			logger.Error("Rename upload failed: %v", err)
-			return syscall.EIO
-		}
-
-		serverOldPath := strings.TrimPrefix(source.Path, "/")
-		n.fsys.client.DeletePath(ctx, serverOldPath)
-		n.fsys.cache.Evict(srcCacheID)
-	}
-
-	// Update local metadata tree
-	n.fsys.mu.Lock()
-	newParentNode.removeChildLocked(newName) // Remove existing target if any
-	n.removeChildLocked(name)
-	source.Name = newName
-	source.Path = newPath
-	source.ID = newPath
-	newParentNode.metadata.Children = append(newParentNode.metadata.Children, source)
-	// Also update FruitFS tree
-	if treeSrc := fstree.FindByPath(n.fsys.metadata, n.metadata.Path); treeSrc != nil && treeSrc != n.metadata {
-		fstree.RemoveChild(treeSrc, name)
-	}
-	if treeDst := fstree.FindByPath(n.fsys.metadata, newParentNode.metadata.Path); treeDst != nil && treeDst != newParentNode.metadata {
-		fstree.RemoveChild(treeDst, newName)
-		treeDst.Children = append(treeDst.Children, source)
-	}
-	n.fsys.mu.Unlock()
-
-	n.fsys.stats.Renames.Add(1)
-	logger.Info("Renamed: %s -> %s", name, newPath)
-	return 0
-}
-
 // Write writes data to the file buffer.
 func (fh *FileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
 	fh.mu.Lock()
@@ -1146,16 +1551,26 @@ func (fh *FileHandle) Write(ctx context.Context, data []byte, off int64) (uint32
 		return 0, syscall.EIO
 	}
 
+	// Growing the buffer must leave the free space reserve alone
+	if grow := off + int64(len(data)) - fh.size; grow > 0 {
+		if err := fh.node.fsys.cache.EnsureFree(grow); err != nil {
+			return 0, fh.node.fsys.errno(err)
+		}
+	}
+
 	n, err := fh.tmpFile.WriteAt(data, off)
 	if err != nil {
 		logger.Error("Write error at offset %d: %v", off, err)
-		return 0, syscall.EIO
+		return 0, fh.node.fsys.errno(err)
 	}
 
 	end := off + int64(n)
 	if end > fh.size {
 		fh.size = end
 	}
+	if !fh.dirty {
+		fh.node.fsys.setDirty(fh.node.metadata.Path, true)
+	}
 	fh.dirty = true
 
 	return uint32(n), 0
@@ -1170,31 +1585,27 @@ func (fh *FileHandle) Flush(ctx context.Context) syscall.Errno {
 		return 0
 	}
 
+	if errno := fh.checkUpload(ctx); errno != 0 {
+		return errno
+	}
+
 	// Use SectionReader so UploadFile (HTTP client) doesn't close our tmpFile
 	reader := io.NewSectionReader(fh.tmpFile, 0, fh.size)
 
 	path := strings.TrimPrefix(fh.node.metadata.Path, "/")
 	resp, err := fh.node.fsys.client.UploadFile(ctx, path, reader, fh.size, fh.node.metadata.Version)
-	if err != nil {
-		// Handle conflict: save local content as conflict copy, refresh metadata
-		if ce, ok := client.AsConflict(err); ok {
-			logger.Info("Conflict detected on %s (expected v%d, server v%d), saving conflict copy",
-				fh.node.metadata.Path, ce.ExpectedVersion, ce.CurrentVersion)
-
-			conflictPath := strings.TrimPrefix(conflictCopyPath(fh.node.metadata.Path), "/")
-			conflictReader := io.NewSectionReader(fh.tmpFile, 0, fh.size)
-			if _, cerr := fh.node.fsys.client.UploadFile(ctx, conflictPath, conflictReader, fh.size, 0); cerr != nil {
-				logger.Error("Failed to upload conflict copy: %v", cerr)
-			}
-
-			// Refresh metadata to get server's latest
-			fh.node.fsys.RefreshMetadata(ctx)
-			fh.dirty = false
-			return 0
+	if ce, ok := client.AsConflict(err); ok {
+		if fh.node.fsys.cfg.ConflictPolicy != ConflictOverwrite {
+			return fh.saveConflictCopy(ctx, ce)
 		}
-
+		logger.Warn("Conflict on %s (expected v%d, server v%d): overwriting server version",
+			fh.node.metadata.Path, ce.ExpectedVersion, ce.CurrentVersion)
+		reader = io.NewSectionReader(fh.tmpFile, 0, fh.size)
+		resp, err = fh.node.fsys.client.UploadFile(ctx, path, reader, fh.size, 0)
+	}
+	if err != nil {
 		logger.Error("Upload failed for %s: %v", fh.node.metadata.Path, err)
-		return syscall.EIO
+		return fh.node.fsys.errno(err)
 	}
 
 	// Update metadata with server response
@@ -1208,11 +1619,12 @@ func (fh *FileHandle) Flush(ctx context.Context) syscall.Errno {
 	// Update cache with the written content
 	cacheReader := io.NewSectionReader(fh.tmpFile, 0, fh.size)
 	cacheID := fh.node.getFileID()
-	if cachePath, err := fh.node.fsys.cache.Put(cacheID, cacheReader, fh.size); err == nil {
+	if cachePath, err := fh.node.fsys.cache.PutFile(cacheID, fh.node.metadata.Path, cacheReader, fh.size); err == nil {
 		fh.cachePath = cachePath
 		fh.cached = true
 	}
 
+	fh.node.fsys.setDirty(fh.node.metadata.Path, false)
 	fh.dirty = false
 	fh.node.fsys.stats.BytesUploaded.Add(fh.size)
 	logger.Info("Uploaded: %s (%d bytes, v%d)", fh.node.metadata.Path, fh.size, resp.Version)
@@ -1220,11 +1632,49 @@ func (fh *FileHandle) Flush(ctx context.Context) syscall.Errno {
 	return 0
 }
 
+// checkUpload asks the server whether it would accept the upload, so that
+// a file over a size limit or quota fails at once with EDQUOT instead of
+// after its whole content has been sent. If the check itself fails, e.g.
+// on a server without it, the upload goes ahead.
+func (fh *FileHandle) checkUpload(ctx context.Context) syscall.Errno {
+	path := fh.node.metadata.Path
+	check, err := fh.node.fsys.client.UploadCheck(ctx, path, fh.size)
+	if err != nil || check.Allowed {
+		return 0
+	}
+
+	switch check.Limit {
+	case protocol.UploadLimitPermission:
+		logger.Error("Upload refused for %s: no write access", path)
+		return syscall.EACCES
+	case protocol.UploadLimitSize:
+		logger.Error("Upload refused for %s: %d bytes exceeds the upload size limit of %d bytes",
+			path, fh.size, check.MaxUploadSize)
+	case protocol.UploadLimitQuota:
+		logger.Error("Upload refused for %s: %d bytes exceeds the %d bytes left in the storage quota",
+			path, fh.size, check.QuotaRemaining)
+	default:
+		logger.Error("Upload refused for %s: %s", path, check.Reason)
+	}
+	return syscall.EDQUOT
+}
+
 // Release cleans up the file handle.
 func (fh *FileHandle) Release(ctx context.Context) syscall.Errno {
 	fh.mu.Lock()
 	defer fh.mu.Unlock()
 
+	fh.node.fsys.stats.OpenHandles.Add(-1)
+	if fh.lease != nil {
+		fh.lease.Release()
+		fh.lease = nil
+	}
+	if fh.dirty {
+		// Upload failed; the local changes are lost with the handle
+		fh.node.fsys.setDirty(fh.node.metadata.Path, false)
+		fh.dirty = false
+	}
+
 	if fh.tmpFile != nil {
 		name := fh.tmpFile.Name()
 		fh.tmpFile.Close()
@@ -1246,15 +1696,6 @@ func (n *FruitNode) removeChildLocked(name string) {
 	}
 }
 
-// conflictCopyPath generates a conflict copy path like "/dir/file (conflict 2026-02-20).ext".
-func conflictCopyPath(path string) string {
-	dir := filepath.Dir(path)
-	ext := filepath.Ext(path)
-	base := strings.TrimSuffix(filepath.Base(path), ext)
-	stamp := time.Now().Format("2006-01-02")
-	return filepath.Join(dir, fmt.Sprintf("%s (conflict %s)%s", base, stamp, ext))
-}
-
 func buildChildPath(parentPath, name string) string {
 	if parentPath == "/" {
 		return "/" + name
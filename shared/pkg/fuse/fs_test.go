@@ -0,0 +1,232 @@
+package fuse
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+func TestRefreshSkipsUnchangedTree(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		f, srv := newTreeFS(t, lazy)
+		srv.etag = `"tree-1"`
+		ctx := context.Background()
+
+		// The first refresh learns the ETag, the second is answered with 304
+		for i := 0; i < 2; i++ {
+			if err := f.RefreshMetadata(ctx); err != nil {
+				t.Fatalf("lazy=%v: refresh %d: %v", lazy, i, err)
+			}
+		}
+		if got := f.stats.RefreshesSkipped.Load(); got != 1 {
+			t.Errorf("lazy=%v: skipped = %d, want 1", lazy, got)
+		}
+
+		srv.etag = `"tree-2"`
+		if err := f.RefreshMetadata(ctx); err != nil {
+			t.Fatalf("lazy=%v: refresh after change: %v", lazy, err)
+		}
+		if got := f.stats.RefreshesSkipped.Load(); got != 1 {
+			t.Errorf("lazy=%v: changed tree was skipped", lazy)
+		}
+	}
+}
+
+func TestRefreshDelay(t *testing.T) {
+	interval := 30 * time.Second
+	for range 100 {
+		if d := refreshDelay(interval, 0); d < 24*time.Second || d > 36*time.Second {
+			t.Fatalf("delay %v outside ±20%% of %v", d, interval)
+		}
+	}
+	if d := refreshDelay(interval, 2); d < 96*time.Second || d > 144*time.Second {
+		t.Errorf("delay after 2 failures = %v, want about 2m", d)
+	}
+	if d := refreshDelay(interval, 50); d > refreshBackoffMax*6/5 {
+		t.Errorf("delay after 50 failures = %v, want at most %v", d, refreshBackoffMax)
+	}
+	// Intervals beyond the cap are not shortened
+	if d := refreshDelay(time.Hour, 3); d < 48*time.Minute {
+		t.Errorf("delay for a 1h interval = %v", d)
+	}
+}
+
+func TestFlushRefusedByUploadCheck(t *testing.T) {
+	tests := []struct {
+		check protocol.UploadCheckResponse
+		want  syscall.Errno
+	}{
+		{protocol.UploadCheckResponse{Limit: protocol.UploadLimitQuota, QuotaRemaining: 2}, syscall.EDQUOT},
+		{protocol.UploadCheckResponse{Limit: protocol.UploadLimitSize, MaxUploadSize: 3}, syscall.EDQUOT},
+		{protocol.UploadCheckResponse{Limit: protocol.UploadLimitPermission}, syscall.EACCES},
+	}
+	for _, tt := range tests {
+		var uploads atomic.Int32
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /api/v1/upload-check", func(w http.ResponseWriter, r *http.Request) {
+			var req protocol.UploadCheckRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Path != "/doc.txt" || req.Size != 5 {
+				t.Errorf("check for %s (%d bytes)", req.Path, req.Size)
+			}
+			json.NewEncoder(w).Encode(tt.check)
+		})
+		mux.HandleFunc("POST /api/v1/content/", func(w http.ResponseWriter, r *http.Request) {
+			uploads.Add(1)
+		})
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+
+		dir := t.TempDir()
+		f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: dir})
+		if err != nil {
+			t.Fatalf("NewFruitFS: %v", err)
+		}
+		node := &models.FileNode{ID: "/doc.txt", Path: "/doc.txt", Name: "doc.txt", Version: 1}
+		tmp, err := os.CreateTemp(dir, "write-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tmp.Close()
+		tmp.WriteString("hello")
+		fh := &FileHandle{node: &FruitNode{fsys: f, metadata: node}, tmpFile: tmp, size: 5, writable: true, dirty: true}
+
+		if errno := fh.Flush(context.Background()); errno != tt.want {
+			t.Errorf("%s: Flush = %v, want %v", tt.check.Limit, errno, tt.want)
+		}
+		if uploads.Load() != 0 {
+			t.Errorf("%s: content uploaded despite a refused check", tt.check.Limit)
+		}
+	}
+}
+
+func TestOpenFileIsNotEvicted(t *testing.T) {
+	f, err := NewFruitFS(Config{ServerURL: "http://127.0.0.1:1", CacheDir: t.TempDir(), MaxCacheSize: 100})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	n := &FruitNode{fsys: f, metadata: &models.FileNode{ID: "/a.txt", Path: "/a.txt", Name: "a.txt", Size: 60}}
+	content := strings.Repeat("a", 60)
+	f.cache.PutFile(n.getFileID(), "/a.txt", strings.NewReader(content), 60)
+
+	fh, _, errno := n.Open(context.Background(), syscall.O_RDONLY)
+	if errno != 0 {
+		t.Fatalf("Open = %v", errno)
+	}
+
+	// A download that needs the space of the open file
+	f.cache.PutFile("big", "/big", strings.NewReader(strings.Repeat("b", 60)), 60)
+
+	dest := make([]byte, 60)
+	res, errno := n.Read(context.Background(), fh, dest, 0)
+	if errno != 0 {
+		t.Fatalf("Read of open file = %v", errno)
+	}
+	if got, _ := res.Bytes(dest); string(got) != content {
+		t.Errorf("Read = %q", got)
+	}
+
+	fh.(*FileHandle).Release(context.Background())
+	f.cache.PutFile("bigger", "/bigger", strings.NewReader(strings.Repeat("c", 60)), 60)
+	if f.cache.IsCached(n.getFileID()) {
+		t.Error("file still cached after release and more downloads")
+	}
+}
+
+func TestReadOnlyMount(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	f, err := NewFruitFS(Config{ServerURL: srv.URL, CacheDir: t.TempDir(), MaxCacheSize: 1 << 20, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	file := &models.FileNode{ID: "/docs/a.txt", Path: "/docs/a.txt", Name: "a.txt", Size: 5}
+	dir := &models.FileNode{ID: "/docs", Path: "/docs", Name: "docs", IsDir: true, Children: []*models.FileNode{file}}
+	d := &FruitNode{fsys: f, metadata: dir}
+	n := &FruitNode{fsys: f, metadata: file}
+	ctx := context.Background()
+
+	if _, _, _, errno := d.Create(ctx, "b.txt", syscall.O_WRONLY, 0644, &gofuse.EntryOut{}); errno != syscall.EROFS {
+		t.Errorf("Create = %v, want EROFS", errno)
+	}
+	if _, errno := d.Mkdir(ctx, "sub", 0755, &gofuse.EntryOut{}); errno != syscall.EROFS {
+		t.Errorf("Mkdir = %v, want EROFS", errno)
+	}
+	if errno := d.Unlink(ctx, "a.txt"); errno != syscall.EROFS {
+		t.Errorf("Unlink = %v, want EROFS", errno)
+	}
+	if errno := n.Rename(ctx, "a.txt", d, "c.txt", 0); errno != syscall.EROFS {
+		t.Errorf("Rename = %v, want EROFS", errno)
+	}
+	for _, flags := range []uint32{syscall.O_WRONLY, syscall.O_RDWR | syscall.O_TRUNC} {
+		if _, _, errno := n.Open(ctx, flags); errno != syscall.EROFS {
+			t.Errorf("Open(%#x) = %v, want EROFS", flags, errno)
+		}
+	}
+	in := &gofuse.SetAttrIn{}
+	in.Valid = gofuse.FATTR_SIZE
+	if errno := n.Setattr(ctx, nil, in, &gofuse.AttrOut{}); errno != syscall.EROFS {
+		t.Errorf("Setattr(size) = %v, want EROFS", errno)
+	}
+
+	var out gofuse.AttrOut
+	if errno := n.Getattr(ctx, nil, &out); errno != 0 {
+		t.Fatalf("Getattr: %v", errno)
+	}
+	if out.Mode&0222 != 0 {
+		t.Errorf("mode = %o, want no write bits", out.Mode)
+	}
+	if got := hits.Load(); got != 0 {
+		t.Errorf("server contacted %d times", got)
+	}
+}
+
+func TestWriteDiskFull(t *testing.T) {
+	f := newTestFS(t)
+	tmp, err := os.CreateTemp(t.TempDir(), "write-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	fh := &FileHandle{node: &FruitNode{fsys: f, metadata: &models.FileNode{Path: "/a.txt"}}, tmpFile: tmp, writable: true}
+	ctx := context.Background()
+
+	if _, errno := fh.Write(ctx, []byte("hello"), 0); errno != 0 {
+		t.Fatalf("Write = %v", errno)
+	}
+
+	// A reserve no volume can keep: overwriting needs no room, growing does
+	f.cache.SetMinFree(math.MaxInt64)
+	if _, errno := fh.Write(ctx, []byte("HELLO"), 0); errno != 0 {
+		t.Errorf("overwrite = %v", errno)
+	}
+	if _, errno := fh.Write(ctx, []byte(" world"), 5); errno != syscall.ENOSPC {
+		t.Errorf("growing write = %v, want ENOSPC", errno)
+	}
+	if fh.size != 5 {
+		t.Errorf("size = %d after a refused write", fh.size)
+	}
+
+	st := f.Status()
+	if !st.Cache.DiskFull || st.Stats.DiskFullErrors != 1 {
+		t.Errorf("status: disk full %v, %d disk full errors", st.Cache.DiskFull, st.Stats.DiskFullErrors)
+	}
+}
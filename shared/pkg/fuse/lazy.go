@@ -0,0 +1,206 @@
+package fuse
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// Lazy metadata: when the server supports depth-limited trees, the client
+// does not hold the whole tree. Each directory is fetched with a depth=1
+// subtree call the first time it is looked up or listed, and only the
+// directories listed so far are refreshed. Against older servers the full
+// tree is fetched as before. Excluded folders (selective.go) are never
+// loaded.
+
+// isLazy reports whether metadata is loaded one directory at a time.
+func (f *FruitFS) isLazy() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.lazy
+}
+
+// isLoaded reports whether the children of dir are in the tree.
+func (f *FruitFS) isLoaded(dir string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	_, ok := f.loaded[dir]
+	return ok
+}
+
+// ensureLoaded fetches dir and any ancestors whose children are not in the
+// tree yet. It is a no-op outside lazy mode.
+func (f *FruitFS) ensureLoaded(ctx context.Context, dir string) {
+	if !f.isLazy() || f.isExcluded(dir) {
+		return
+	}
+	for _, p := range pathAndAncestors(dir) {
+		if f.isLoaded(p) {
+			continue
+		}
+		if err := f.loadDir(ctx, p); err != nil {
+			logger.Error("List %s: %v", p, err)
+			return
+		}
+	}
+}
+
+// loadDir fetches the children of dir and splices them into the tree.
+func (f *FruitFS) loadDir(ctx context.Context, dir string) error {
+	start := time.Now()
+	node, partial, err := f.client.FetchDir(ctx, dir)
+	if err != nil {
+		f.mu.Lock()
+		delete(f.loaded, dir)
+		f.mu.Unlock()
+		return err
+	}
+	f.stats.MetadataFetches.Add(1)
+	f.stats.recordMetadataFetch(start)
+	f.prune(node)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !partial && dir == "/" {
+		// The server no longer limits depth: node is the whole tree
+		f.lazy = false
+	}
+	f.spliceDirLocked(dir, node)
+	f.loaded[dir] = time.Now()
+	return nil
+}
+
+// spliceDirLocked replaces the node at dir with fresh. Subdirectories that
+// were already loaded keep their children. Called with f.mu held.
+func (f *FruitFS) spliceDirLocked(dir string, fresh *models.FileNode) {
+	if dir == "/" {
+		f.carryLoadedLocked(f.metadata, fresh)
+		f.metadata = fresh
+		return
+	}
+	parent := fstree.FindByPath(f.metadata, parentDir(dir))
+	if parent == nil {
+		return
+	}
+	for i, child := range parent.Children {
+		if child.Path == dir {
+			f.carryLoadedLocked(child, fresh)
+			parent.Children[i] = fresh
+			return
+		}
+	}
+	fstree.InsertChild(parent, fresh)
+}
+
+// carryLoadedLocked moves the children of loaded subdirectories of old
+// over to their counterparts in fresh.
+func (f *FruitFS) carryLoadedLocked(old, fresh *models.FileNode) {
+	if old == nil {
+		return
+	}
+	prev := make(map[string]*models.FileNode, len(old.Children))
+	for _, child := range old.Children {
+		if child.IsDir {
+			prev[child.Name] = child
+		}
+	}
+	for _, child := range fresh.Children {
+		if o, ok := prev[child.Name]; ok && child.IsDir && child.Children == nil {
+			if _, loaded := f.loaded[child.Path]; loaded {
+				child.Children = o.Children
+			}
+		}
+	}
+}
+
+// refreshLoaded refetches every directory listed so far, parents first.
+// Directories that no longer exist are forgotten.
+func (f *FruitFS) refreshLoaded(ctx context.Context) error {
+	f.mu.RLock()
+	dirs := make([]string, 0, len(f.loaded))
+	for dir := range f.loaded {
+		dirs = append(dirs, dir)
+	}
+	f.mu.RUnlock()
+	sort.Slice(dirs, func(i, j int) bool {
+		di, dj := strings.Count(dirs[i], "/"), strings.Count(dirs[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	var firstErr error
+	for _, dir := range dirs {
+		if dir != "/" && !f.isLoaded(parentDir(dir)) {
+			// Parent vanished in this refresh
+			f.mu.Lock()
+			delete(f.loaded, dir)
+			f.mu.Unlock()
+			continue
+		}
+		if err := f.loadDir(ctx, dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// refreshForEvent updates the tree after a server change event: the whole
+// tree normally, only the affected directories in lazy mode.
+func (f *FruitFS) refreshForEvent(ctx context.Context, p string) error {
+	if !f.isLazy() {
+		return f.RefreshMetadata(ctx)
+	}
+	var firstErr error
+	for _, dir := range []string{parentDir(p), p} {
+		if !f.isLoaded(dir) {
+			continue
+		}
+		if err := f.loadDir(ctx, dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// subtreeFor returns the complete subtree at p, fetching it from the server
+// in lazy mode where the local tree may be partial. Excluded folders are
+// left out.
+func (f *FruitFS) subtreeFor(ctx context.Context, p string) (*models.FileNode, error) {
+	if f.isExcluded(p) {
+		return nil, nil
+	}
+	if f.isLazy() {
+		return f.excludeSet().FetchTree(ctx, f.client, p)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return fstree.FindByPath(f.metadata, p), nil
+}
+
+// pathAndAncestors returns "/", "/a", "/a/b" for "/a/b".
+func pathAndAncestors(p string) []string {
+	result := []string{"/"}
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return result
+	}
+	cur := ""
+	for _, part := range strings.Split(p, "/") {
+		cur += "/" + part
+		result = append(result, cur)
+	}
+	return result
+}
+
+// parentDir returns the directory containing the server path p.
+func parentDir(p string) string {
+	return path.Dir(p)
+}
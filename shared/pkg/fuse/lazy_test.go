@@ -0,0 +1,206 @@
+package fuse
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/selective"
+)
+
+// treeServer serves a fixed tree. With supportsDepth it honours ?depth=1
+// like current servers; without, it returns the whole subtree like servers
+// that predate partial trees.
+type treeServer struct {
+	supportsDepth bool
+	etag          string // sent with every response; If-None-Match gets 304
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *treeServer) tree() *models.FileNode {
+	return &models.FileNode{ID: "/", Path: "/", IsDir: true, ChildCount: 2, Children: []*models.FileNode{
+		{ID: "/docs", Name: "docs", Path: "/docs", IsDir: true, ChildCount: 1, Children: []*models.FileNode{
+			{ID: "/docs/a.txt", Name: "a.txt", Path: "/docs/a.txt", Size: 1},
+		}},
+		{ID: "/top.txt", Name: "top.txt", Path: "/top.txt", Size: 2},
+	}}
+}
+
+func (s *treeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path)
+	s.mu.Unlock()
+
+	p := "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/tree"), "/")
+	var node *models.FileNode
+	for _, n := range []*models.FileNode{s.tree(), s.tree().Children[0]} {
+		if n.Path == p {
+			node = n
+		}
+	}
+	if node == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+		if r.Header.Get("If-None-Match") == s.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	resp := protocol.TreeResponse{Root: node}
+	if s.supportsDepth && r.URL.Query().Get("depth") == "1" {
+		for _, c := range node.Children {
+			c.Children = nil
+		}
+		resp.Partial = true
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTreeFS(t *testing.T, supportsDepth bool) (*FruitFS, *treeServer) {
+	t.Helper()
+	srv := &treeServer{supportsDepth: supportsDepth}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	if err := f.FetchMetadata(context.Background()); err != nil {
+		t.Fatalf("FetchMetadata: %v", err)
+	}
+	return f, srv
+}
+
+func readdirNames(t *testing.T, n *FruitNode) []string {
+	t.Helper()
+	ds, errno := n.Readdir(context.Background())
+	if errno != 0 {
+		t.Fatalf("Readdir: %v", errno)
+	}
+	var names []string
+	for ds.HasNext() {
+		e, _ := ds.Next()
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestLazyReaddir(t *testing.T) {
+	f, srv := newTreeFS(t, true)
+	if !f.isLazy() {
+		t.Fatal("expected lazy mode against a server with partial trees")
+	}
+	docs := f.metadata.Children[0]
+	if docs.Children != nil || docs.ChildCount != 1 {
+		t.Fatalf("docs loaded eagerly: %+v", docs)
+	}
+
+	n := &FruitNode{fsys: f, metadata: docs}
+	if names := readdirNames(t, n); len(names) != 1 || names[0] != "a.txt" {
+		t.Errorf("Readdir(/docs) = %v", names)
+	}
+	if !f.isLoaded("/docs") {
+		t.Error("/docs not marked loaded")
+	}
+
+	// A refresh refetches the listed directories and keeps them loaded
+	if err := f.RefreshMetadata(context.Background()); err != nil {
+		t.Fatalf("RefreshMetadata: %v", err)
+	}
+	if names := readdirNames(t, n); len(names) != 1 {
+		t.Errorf("Readdir(/docs) after refresh = %v", names)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.requests) != 5 {
+		t.Errorf("requests = %v, want /, /docs, the change probe, then both again", srv.requests)
+	}
+}
+
+func TestLazyFallbackOldServer(t *testing.T) {
+	f, srv := newTreeFS(t, false)
+	if f.isLazy() {
+		t.Fatal("lazy mode against a server without partial trees")
+	}
+
+	n := &FruitNode{fsys: f, metadata: f.metadata.Children[0]}
+	if names := readdirNames(t, n); len(names) != 1 {
+		t.Errorf("Readdir(/docs) = %v", names)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.requests) != 1 {
+		t.Errorf("requests = %v, want only the initial tree", srv.requests)
+	}
+}
+
+func TestPathAndAncestors(t *testing.T) {
+	got := strings.Join(pathAndAncestors("/a/b"), ",")
+	if got != "/,/a,/a/b" {
+		t.Errorf("pathAndAncestors = %s", got)
+	}
+	if got := pathAndAncestors("/"); len(got) != 1 {
+		t.Errorf("pathAndAncestors(/) = %v", got)
+	}
+}
+
+func TestSelectiveSync(t *testing.T) {
+	srv := &treeServer{supportsDepth: true}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	cacheDir := t.TempDir()
+	if err := selective.Save(cacheDir, []string{"/docs"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: cacheDir})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	ctx := context.Background()
+	if err := f.FetchMetadata(ctx); err != nil {
+		t.Fatalf("FetchMetadata: %v", err)
+	}
+
+	root := &FruitNode{fsys: f, metadata: f.metadata}
+	if names := readdirNames(t, root); slices.Contains(names, "docs") {
+		t.Errorf("Readdir(/) = %v, excluded docs listed", names)
+	}
+	var out gofuse.EntryOut
+	if _, errno := root.Mkdir(ctx, "docs", 0755, &out); errno != syscall.EACCES {
+		t.Errorf("Mkdir(docs) = %v, want EACCES", errno)
+	}
+	f.ensureLoaded(ctx, "/docs")
+	srv.mu.Lock()
+	requests := slices.Clone(srv.requests)
+	srv.mu.Unlock()
+	if slices.Contains(requests, "/api/v1/tree/docs") {
+		t.Errorf("requests = %v, excluded folder was fetched", requests)
+	}
+
+	// Including the folder again takes effect on the next refresh
+	if err := selective.Save(cacheDir, nil); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	f.reloadExcludes(ctx)
+	if names := readdirNames(t, root); !slices.Contains(names, "docs") {
+		t.Errorf("Readdir(/) = %v after including docs", names)
+	}
+}
@@ -0,0 +1,123 @@
+package fuse
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/cache"
+)
+
+// Collector exports the filesystem statistics and those of its cache as
+// Prometheus metrics.
+type Collector struct {
+	fsys  *FruitFS
+	cache *cache.Collector
+
+	counters     []statCounter
+	openHandles  *prometheus.Desc
+	online       *prometheus.Desc
+	sseReconnect *prometheus.Desc
+	refresh      *prometheus.Desc
+}
+
+type statCounter struct {
+	desc  *prometheus.Desc
+	value func(*Stats) int64
+}
+
+func newStatCounter(name, help string, value func(*Stats) int64) statCounter {
+	return statCounter{prometheus.NewDesc("fruitsalade_fuse_"+name, help, nil, nil), value}
+}
+
+// Collector returns a Prometheus collector for the filesystem.
+func (f *FruitFS) Collector() *Collector {
+	return &Collector{
+		fsys:  f,
+		cache: cache.NewCollector(f.cache),
+		counters: []statCounter{
+			newStatCounter("metadata_fetches_total", "Metadata tree fetches",
+				func(s *Stats) int64 { return s.MetadataFetches.Load() }),
+			newStatCounter("content_fetches_total", "Full file downloads into the cache",
+				func(s *Stats) int64 { return s.ContentFetches.Load() }),
+			newStatCounter("cache_hits_total", "File opens served from the cache",
+				func(s *Stats) int64 { return s.CacheHits.Load() }),
+			newStatCounter("cache_misses_total", "File opens that were not cached",
+				func(s *Stats) int64 { return s.CacheMisses.Load() }),
+			newStatCounter("range_reads_total", "Range reads of large files from the server",
+				func(s *Stats) int64 { return s.RangeReads.Load() }),
+			newStatCounter("coalesced_fetches_total", "Content fetches that waited for the same download already in flight",
+				func(s *Stats) int64 { return s.CoalescedFetches.Load() }),
+			newStatCounter("read_ahead_hits_total", "Range reads answered from a block read ahead",
+				func(s *Stats) int64 { return s.ReadAheadHits.Load() }),
+			newStatCounter("downloaded_bytes_total", "Bytes downloaded from the server",
+				func(s *Stats) int64 { return s.BytesDownloaded.Load() }),
+			newStatCounter("cache_served_bytes_total", "Bytes read from the cache",
+				func(s *Stats) int64 { return s.BytesFromCache.Load() }),
+			newStatCounter("uploaded_bytes_total", "Bytes uploaded to the server",
+				func(s *Stats) int64 { return s.BytesUploaded.Load() }),
+			newStatCounter("failed_fetches_total", "Content fetches that failed",
+				func(s *Stats) int64 { return s.FailedFetches.Load() }),
+			newStatCounter("offline_errors_total", "Operations rejected because the server was offline",
+				func(s *Stats) int64 { return s.OfflineErrors.Load() }),
+			newStatCounter("disk_full_errors_total", "Operations failed with ENOSPC to keep the cache volume's free space reserve",
+				func(s *Stats) int64 { return s.DiskFullErrors.Load() }),
+			newStatCounter("refreshes_skipped_total", "Metadata refreshes skipped because the tree was unchanged",
+				func(s *Stats) int64 { return s.RefreshesSkipped.Load() }),
+			newStatCounter("fetch_retries_total", "Content fetches retried after a connection error",
+				func(s *Stats) int64 { return s.FetchRetries.Load() }),
+			newStatCounter("stream_reconnects_total", "Event stream reconnects, each followed by a metadata refresh",
+				func(s *Stats) int64 { return s.StreamReconnects.Load() }),
+			newStatCounter("server_reconnects_total", "Times the server came back after an outage, each followed by a metadata refresh",
+				func(s *Stats) int64 { return s.ServerReconnects.Load() }),
+		},
+		openHandles: prometheus.NewDesc("fruitsalade_fuse_open_handles",
+			"Currently open file handles", nil, nil),
+		online: prometheus.NewDesc("fruitsalade_fuse_online",
+			"Whether the server is reachable (1) or not (0)", nil, nil),
+		sseReconnect: prometheus.NewDesc("fruitsalade_fuse_sse_reconnects_total",
+			"Times the event stream was re-established after an error", nil, nil),
+		refresh: prometheus.NewDesc("fruitsalade_fuse_metadata_fetch_duration_seconds",
+			"Duration of metadata tree fetches", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, sc := range c.counters {
+		ch <- sc.desc
+	}
+	ch <- c.openHandles
+	ch <- c.online
+	ch <- c.sseReconnect
+	ch <- c.refresh
+	c.cache.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.fsys.GetStats()
+	for _, sc := range c.counters {
+		ch <- prometheus.MustNewConstMetric(sc.desc, prometheus.CounterValue, float64(sc.value(stats)))
+	}
+	ch <- prometheus.MustNewConstMetric(c.openHandles, prometheus.GaugeValue, float64(stats.OpenHandles.Load()))
+
+	online := 0.0
+	if c.fsys.IsOnline() {
+		online = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.online, prometheus.GaugeValue, online)
+
+	var reconnects int64
+	if c.fsys.sseClient != nil {
+		reconnects = c.fsys.sseClient.Reconnects()
+	}
+	ch <- prometheus.MustNewConstMetric(c.sseReconnect, prometheus.CounterValue, float64(reconnects))
+
+	ch <- prometheus.MustNewConstSummary(c.refresh,
+		uint64(stats.MetadataFetchTimed.Load()),
+		time.Duration(stats.MetadataFetchNanos.Load()).Seconds(),
+		nil)
+
+	c.cache.Collect(ch)
+}
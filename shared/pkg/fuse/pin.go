@@ -0,0 +1,168 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// prefetchConcurrency limits parallel downloads when filling a pinned folder.
+const prefetchConcurrency = 4
+
+// PinPrefix records a pin rule for a directory and downloads every file
+// below it that is not cached yet. Files added later are fetched when the
+// server announces them. Returns the number of files downloaded.
+func (f *FruitFS) PinPrefix(ctx context.Context, prefix string) (int, error) {
+	return f.prefetchPrefix(ctx, f.addPinRule(prefix))
+}
+
+// addPinRule records and persists a pin rule and returns the cleaned prefix.
+func (f *FruitFS) addPinRule(prefix string) string {
+	prefix = f.cache.PinPrefix(prefix)
+	if err := f.cache.SavePins(); err != nil {
+		logger.Error("Failed to persist pins: %v", err)
+	}
+	logger.Info("Pinned folder: %s", prefix)
+	return prefix
+}
+
+// UnpinPrefix removes the pin rule for a directory.
+func (f *FruitFS) UnpinPrefix(prefix string) error {
+	if err := f.cache.UnpinPrefix(prefix); err != nil {
+		return err
+	}
+	if err := f.cache.SavePins(); err != nil {
+		logger.Error("Failed to persist pins: %v", err)
+	}
+	logger.Info("Unpinned folder: %s", prefix)
+	return nil
+}
+
+// prefetchPrefix downloads the uncached files at or below prefix.
+func (f *FruitFS) prefetchPrefix(ctx context.Context, prefix string) (int, error) {
+	root, err := f.subtreeFor(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if root == nil {
+		return 0, fmt.Errorf("not found: %s", prefix)
+	}
+	f.mu.RLock()
+	tf, err := f.client.NewTreeFetch(root, client.TreeFetchOptions{
+		Concurrency: prefetchConcurrency,
+		Skip: func(node *models.FileNode) bool {
+			return f.cache.IsCached(fstree.CacheID(node.ID))
+		},
+	})
+	f.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	missing := tf.Progress().FilesTotal
+	if missing == 0 {
+		return 0, nil
+	}
+	if !f.client.IsOnline() {
+		f.stats.OfflineErrors.Add(1)
+		return 0, fmt.Errorf("server offline, %d files not downloaded", missing)
+	}
+
+	fetched := 0
+	var failed []string
+	for res := range tf.Start(ctx) {
+		node := res.Node
+		if res.Err != nil {
+			f.stats.FailedFetches.Add(1)
+			failed = append(failed, node.Path)
+			continue
+		}
+		_, err := f.cache.PutFile(fstree.CacheID(node.ID), node.Path, res.Reader, node.Size)
+		res.Reader.Close()
+		if err != nil {
+			f.stats.FailedFetches.Add(1)
+			failed = append(failed, node.Path)
+			continue
+		}
+		f.stats.ContentFetches.Add(1)
+		f.stats.BytesDownloaded.Add(node.Size)
+		fetched++
+	}
+
+	logger.Info("Prefetched %d/%d files under %s", fetched, missing, prefix)
+	if len(failed) > 0 {
+		return fetched, fmt.Errorf("%d files failed to download (first: %s)", len(failed), failed[0])
+	}
+	if err := ctx.Err(); err != nil && fetched < missing {
+		return fetched, err
+	}
+	return fetched, nil
+}
+
+// FillPinnedFolders loads the persisted pins and downloads the missing
+// files of every pinned folder. Returns the number of files downloaded.
+func (f *FruitFS) FillPinnedFolders(ctx context.Context) (int, error) {
+	if err := f.cache.LoadPins(); err != nil {
+		return 0, fmt.Errorf("load pins: %w", err)
+	}
+	total := 0
+	var firstErr error
+	for _, prefix := range f.cache.PinRules() {
+		n, err := f.prefetchPrefix(ctx, prefix)
+		total += n
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", prefix, err)
+		}
+	}
+	return total, firstErr
+}
+
+// syncPinRules picks up pin rules added by another process (the pin
+// subcommand) and fills the newly pinned folders.
+func (f *FruitFS) syncPinRules(ctx context.Context) {
+	added, err := f.cache.LoadPinRules()
+	if err != nil {
+		logger.Error("Failed to load pin rules: %v", err)
+		return
+	}
+	for _, prefix := range added {
+		logger.Info("New pin rule: %s", prefix)
+		if _, err := f.prefetchPrefix(ctx, prefix); err != nil {
+			logger.Error("Prefetch %s: %v", prefix, err)
+		}
+	}
+}
+
+// fetchIfPinned downloads a file announced by the server when a pin rule
+// covers it, so pinned folders stay complete and current.
+func (f *FruitFS) fetchIfPinned(ctx context.Context, path string) {
+	if f.cache.PinRuleFor(path) == "" {
+		return
+	}
+	f.ensureLoaded(ctx, parentDir(path))
+	f.mu.RLock()
+	node := fstree.FindByPath(f.metadata, path)
+	f.mu.RUnlock()
+	if node == nil {
+		return
+	}
+	if node.IsDir {
+		if _, err := f.prefetchPrefix(ctx, path); err != nil {
+			logger.Error("Prefetch %s: %v", path, err)
+		}
+		return
+	}
+
+	n := &FruitNode{fsys: f, metadata: node}
+	if _, err := n.fetchFullContent(ctx); err != nil {
+		logger.Error("Fetch pinned file %s: %v", path, err)
+		f.stats.FailedFetches.Add(1)
+		return
+	}
+	f.stats.ContentFetches.Add(1)
+	logger.Debug("Fetched pinned file: %s", path)
+}
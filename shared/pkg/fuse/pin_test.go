@@ -0,0 +1,72 @@
+package fuse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+func TestPinPrefixPrefetches(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + strings.TrimPrefix(r.URL.Path, "/api/v1/content/")))
+	}))
+	defer srv.Close()
+
+	f, err := NewFruitFS(Config{ServerURL: srv.URL, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	file := func(p string) *models.FileNode {
+		return &models.FileNode{ID: p, Path: p, Name: p[strings.LastIndex(p, "/")+1:], Size: int64(len("content of ") + len(p) - 1)}
+	}
+	f.metadata = &models.FileNode{ID: "/", Path: "/", IsDir: true, Children: []*models.FileNode{
+		{ID: "/alpha", Path: "/alpha", Name: "alpha", IsDir: true, Children: []*models.FileNode{
+			file("/alpha/a.txt"),
+			{ID: "/alpha/sub", Path: "/alpha/sub", Name: "sub", IsDir: true, Children: []*models.FileNode{
+				file("/alpha/sub/b.txt"),
+			}},
+		}},
+		file("/other.txt"),
+	}}
+
+	n, err := f.PinPrefix(context.Background(), "/alpha")
+	if err != nil || n != 2 {
+		t.Fatalf("PinPrefix = %d, %v; want 2 files", n, err)
+	}
+	for _, p := range []string{"/alpha/a.txt", "/alpha/sub/b.txt"} {
+		if !f.cache.IsPinned(fstree.CacheID(p)) {
+			t.Errorf("%s not pinned", p)
+		}
+	}
+	if f.cache.IsCached(fstree.CacheID("/other.txt")) {
+		t.Error("file outside the folder was fetched")
+	}
+
+	// Already cached files are not fetched again
+	if n, _ := f.PinPrefix(context.Background(), "/alpha"); n != 0 {
+		t.Errorf("second PinPrefix fetched %d files", n)
+	}
+
+	// A file announced later under the rule is fetched and pinned
+	f.mu.Lock()
+	f.metadata.Children[0].Children = append(f.metadata.Children[0].Children, file("/alpha/new.txt"))
+	f.mu.Unlock()
+	f.fetchIfPinned(context.Background(), "/alpha/new.txt")
+	if !f.cache.IsPinned(fstree.CacheID("/alpha/new.txt")) {
+		t.Error("new file under the pinned folder was not pinned")
+	}
+
+	// Unpinning the folder through the xattr removes the rule
+	dir := &FruitNode{fsys: f, metadata: f.metadata.Children[0]}
+	if errno := dir.Setxattr(context.Background(), xattrPin, []byte("0"), 0); errno != 0 {
+		t.Fatalf("unpin folder: %v", errno)
+	}
+	if len(f.cache.PinRules()) != 0 {
+		t.Errorf("rules left: %v", f.cache.PinRules())
+	}
+}
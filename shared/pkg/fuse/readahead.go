@@ -0,0 +1,237 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// defaultReadAhead is the block size sequential range reads are extended
+// to when Config.ReadAhead is 0.
+const defaultReadAhead = 1 << 20
+
+// maxReadAheadBlocks is how many blocks read ahead are kept in memory,
+// across all files.
+const maxReadAheadBlocks = 16
+
+// flight is a content fetch in progress. Callers that want the same
+// content wait for it instead of downloading it again.
+type flight struct {
+	done chan struct{}
+	data []byte // range and block fetches
+	path string // whole-file fetches: the cached file
+	err  error
+}
+
+// fetchGroup coalesces concurrent fetches of the same content and keeps
+// the blocks read ahead for range reads of large files.
+type fetchGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	blocks  map[string][]byte
+	order   []string // keys of blocks, oldest first
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{flights: make(map[string]*flight), blocks: make(map[string][]byte)}
+}
+
+// do calls fetch for key unless a fetch of key is in flight; then it
+// calls joined and waits for that fetch, sharing its result. A caller
+// whose leader was cancelled fetches itself rather than fail with it.
+func (g *fetchGroup) do(ctx context.Context, key string, joined func(), fetch func(*flight) error) (*flight, error) {
+	for {
+		g.mu.Lock()
+		if fl, ok := g.flights[key]; ok {
+			g.mu.Unlock()
+			joined()
+			select {
+			case <-fl.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if isCanceled(fl.err) && ctx.Err() == nil {
+				continue
+			}
+			return fl, fl.err
+		}
+		fl := &flight{done: make(chan struct{})}
+		g.flights[key] = fl
+		g.mu.Unlock()
+
+		func() {
+			defer func() {
+				g.mu.Lock()
+				delete(g.flights, key)
+				g.mu.Unlock()
+				close(fl.done)
+			}()
+			// What the waiters see if fetch panics
+			fl.err = errors.New("fetch panicked")
+			fl.err = fetch(fl)
+		}()
+		return fl, fl.err
+	}
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// block returns a block read ahead earlier.
+func (g *fetchGroup) block(key string) ([]byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	data, ok := g.blocks[key]
+	return data, ok
+}
+
+// keepBlock keeps a fetched block, dropping the oldest beyond
+// maxReadAheadBlocks.
+func (g *fetchGroup) keepBlock(key string, data []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.blocks[key]; ok {
+		return
+	}
+	g.blocks[key] = data
+	g.order = append(g.order, key)
+	for len(g.order) > maxReadAheadBlocks {
+		delete(g.blocks, g.order[0])
+		g.order = g.order[1:]
+	}
+}
+
+// readAhead returns the block size sequential range reads are extended
+// to, 0 if they are not.
+func (f *FruitFS) readAhead() int64 {
+	switch {
+	case f.cfg.ReadAhead < 0:
+		return 0
+	case f.cfg.ReadAhead > 0:
+		return f.cfg.ReadAhead
+	default:
+		return defaultReadAhead
+	}
+}
+
+// contentKey identifies the content of n for coalescing; a new version
+// of the file has a new key.
+func (n *FruitNode) contentKey() string {
+	return fmt.Sprintf("%s@%s:%d", n.metadata.ID, n.metadata.Hash, n.metadata.Size)
+}
+
+// readBlock answers a range read of [off, off+length) from the aligned
+// read-ahead block containing it, fetching the block if it is neither
+// kept nor in flight. It reports false when the range is not within one
+// block, or when the block would have to be fetched and fetch is false.
+func (n *FruitNode) readBlock(ctx context.Context, dest []byte, off, length int64, fetch bool) (int, bool, error) {
+	window := n.fsys.readAhead()
+	if window == 0 || length > window {
+		return 0, false, nil
+	}
+	start := off / window * window
+	end := min(start+window, n.metadata.Size)
+	if off+length > end {
+		return 0, false, nil
+	}
+
+	g := n.fsys.fetches
+	key := fmt.Sprintf("block:%s:%d", n.contentKey(), start)
+	if data, ok := g.block(key); ok {
+		n.fsys.stats.ReadAheadHits.Add(1)
+		if off-start >= int64(len(data)) {
+			return 0, true, nil
+		}
+		return copy(dest[:length], data[off-start:]), true, nil
+	}
+	g.mu.Lock()
+	_, inFlight := g.flights[key]
+	g.mu.Unlock()
+	if !fetch && !inFlight {
+		return 0, false, nil
+	}
+
+	fl, err := g.do(ctx, key, n.fsys.coalesced, func(fl *flight) error {
+		data := make([]byte, end-start)
+		read, err := n.fetchRange(ctx, data, start)
+		if err != nil {
+			return err
+		}
+		fl.data = data[:read]
+		g.keepBlock(key, fl.data)
+		return nil
+	})
+	if err != nil {
+		return 0, true, err
+	}
+	if off-start >= int64(len(fl.data)) {
+		return 0, true, nil
+	}
+	return copy(dest[:length], fl.data[off-start:]), true, nil
+}
+
+// readExact fetches exactly [off, off+length), sharing the download with
+// a concurrent read of the same range.
+func (n *FruitNode) readExact(ctx context.Context, dest []byte, off, length int64) (int, error) {
+	key := fmt.Sprintf("range:%s:%d-%d", n.contentKey(), off, length)
+	fl, err := n.fsys.fetches.do(ctx, key, n.fsys.coalesced, func(fl *flight) error {
+		data := make([]byte, length)
+		read, err := n.fetchRange(ctx, data, off)
+		fl.data = data[:read]
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return copy(dest[:length], fl.data), nil
+}
+
+// fetchRange downloads len(dest) bytes at off into dest. A server restart
+// cuts the transfer off; the whole range is fetched again once it is back.
+func (n *FruitNode) fetchRange(ctx context.Context, dest []byte, off int64) (int, error) {
+	fileID := strings.TrimPrefix(n.metadata.ID, "/")
+	length := int64(len(dest))
+	var bytesRead int
+	err := n.fsys.fetchWithRetry(ctx, "range read of "+n.metadata.Path, func() error {
+		reader, _, err := n.fsys.client.FetchContent(ctx, fileID, off, length)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		bytesRead, err = io.ReadFull(reader, dest)
+		if err == io.ErrUnexpectedEOF && int64(bytesRead) < length {
+			return err
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n.fsys.stats.RangeReads.Add(1)
+	n.fsys.stats.BytesDownloaded.Add(int64(bytesRead))
+	return bytesRead, nil
+}
+
+// coalesced counts a fetch that waited for another one of the same content.
+func (f *FruitFS) coalesced() {
+	f.stats.CoalescedFetches.Add(1)
+}
+
+// sequential reports whether a read at off follows on from the handle's
+// previous read, which the first read of a file at 0 counts as, and
+// records it.
+func (fh *FileHandle) sequential(off int64, size int) bool {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	seq := off >= fh.lastRead && off <= fh.nextRead
+	fh.lastRead, fh.nextRead = off, off+int64(size)
+	return seq
+}
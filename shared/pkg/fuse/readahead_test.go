@@ -0,0 +1,190 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// contentServer serves content with Range support, counting requests.
+// While hold is set, each request waits for it to be closed.
+type contentServer struct {
+	content  []byte
+	requests atomic.Int32
+	hold     chan struct{}
+}
+
+func (s *contentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if s.hold != nil {
+		<-s.hold
+	}
+	body := s.content
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+		body = s.content[start : end+1]
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	w.Write(body)
+}
+
+func newContentFS(t *testing.T, srv *contentServer, readAhead int64) *FruitNode {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: t.TempDir(), ReadAhead: readAhead})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	return &FruitNode{fsys: f, metadata: &models.FileNode{ID: "/big.bin", Path: "/big.bin", Size: int64(len(srv.content))}}
+}
+
+// waitCoalesced waits until n fetches have joined one in flight.
+func waitCoalesced(t *testing.T, f *FruitFS, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for f.GetStats().CoalescedFetches.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("coalesced fetches = %d, want %d", f.GetStats().CoalescedFetches.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReadAheadSequential(t *testing.T) {
+	srv := &contentServer{content: bytes.Repeat([]byte("0123456789abcdef"), 1<<17)} // 2 MiB
+	n := newContentFS(t, srv, 1<<20)
+	fh := &FileHandle{node: n}
+	ctx := context.Background()
+
+	dest := make([]byte, 128<<10)
+	for off := 0; off < len(srv.content); off += len(dest) {
+		res, errno := n.Read(ctx, fh, dest, int64(off))
+		if errno != 0 {
+			t.Fatalf("read at %d: %v", off, errno)
+		}
+		got, _ := res.Bytes(nil)
+		if !bytes.Equal(got, srv.content[off:off+len(dest)]) {
+			t.Fatalf("read at %d: wrong content", off)
+		}
+	}
+	if got := srv.requests.Load(); got != 2 {
+		t.Errorf("requests = %d, want one per 1 MiB block", got)
+	}
+	if got := n.fsys.GetStats().ReadAheadHits.Load(); got != 14 {
+		t.Errorf("read-ahead hits = %d, want 14", got)
+	}
+
+	// A random read outside the kept blocks fetches only what is read
+	other := &FileHandle{node: n}
+	n.fsys.fetches = newFetchGroup()
+	if _, errno := n.Read(ctx, other, make([]byte, 4096), 1<<20+8192); errno != 0 {
+		t.Fatalf("random read: %v", errno)
+	}
+	if got := n.fsys.GetStats().BytesDownloaded.Load(); got != int64(len(srv.content))+4096 {
+		t.Errorf("downloaded %d bytes, want %d", got, len(srv.content)+4096)
+	}
+}
+
+func TestConcurrentRangeReadsCoalesce(t *testing.T) {
+	for _, readAhead := range []int64{1 << 20, -1} {
+		srv := &contentServer{content: bytes.Repeat([]byte("x"), 2<<20), hold: make(chan struct{})}
+		n := newContentFS(t, srv, readAhead)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, errno := n.Read(context.Background(), &FileHandle{node: n}, make([]byte, 128<<10), 0); errno != 0 {
+					errs[i] = errno
+				}
+			}()
+		}
+		waitCoalesced(t, n.fsys, 1)
+		close(srv.hold)
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("read-ahead %d: %v", readAhead, err)
+			}
+		}
+		if got := srv.requests.Load(); got != 1 {
+			t.Errorf("read-ahead %d: requests = %d, want 1", readAhead, got)
+		}
+	}
+}
+
+func TestConcurrentFullFetchesCoalesce(t *testing.T) {
+	srv := &contentServer{content: []byte("small file content"), hold: make(chan struct{})}
+	n := newContentFS(t, srv, 0)
+
+	var wg sync.WaitGroup
+	paths := make([]string, 2)
+	errs := make([]error, 2)
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths[i], errs[i] = n.fetchFullContent(context.Background())
+		}()
+	}
+	waitCoalesced(t, n.fsys, 1)
+	close(srv.hold)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("fetchFullContent: %v", err)
+		}
+	}
+	if paths[0] == "" || paths[0] != paths[1] {
+		t.Errorf("paths = %q", paths)
+	}
+	if got := srv.requests.Load(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+	if got := n.fsys.GetStats().ContentFetches.Load(); got != 1 {
+		t.Errorf("content fetches = %d, want 1", got)
+	}
+}
+
+func TestReadAheadShortBlock(t *testing.T) {
+	// The server has less than the tree says: the kept block is empty
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	t.Cleanup(ts.Close)
+	f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	n := &FruitNode{fsys: f, metadata: &models.FileNode{ID: "/short.bin", Path: "/short.bin", Size: 8192}}
+	fh := &FileHandle{node: n}
+
+	for _, off := range []int64{0, 4096} {
+		res, errno := n.Read(context.Background(), fh, make([]byte, 4096), off)
+		if errno != 0 {
+			t.Fatalf("read at %d: %v", off, errno)
+		}
+		if got, _ := res.Bytes(nil); len(got) != 0 {
+			t.Errorf("read at %d: %d bytes, want none", off, len(got))
+		}
+	}
+	if got := f.GetStats().ReadAheadHits.Load(); got != 1 {
+		t.Errorf("read-ahead hits = %d, want 1", got)
+	}
+}
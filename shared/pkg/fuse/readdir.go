@@ -0,0 +1,122 @@
+package fuse
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// dirHandle is an open directory. It lists the children the directory
+// had when it was opened, and answers READDIRPLUS, which asks for the
+// attributes of every entry it lists, from the child just listed. `ls -l`
+// of a large directory then takes a few requests instead of a listing
+// followed by one lookup per entry, each searching the tree.
+type dirHandle struct {
+	node     *FruitNode
+	children []*models.FileNode
+	entries  []gofuse.DirEntry // children, then the control directory in the root
+	pos      int               // index of the next entry
+}
+
+var _ fs.NodeOpendirHandler = (*FruitNode)(nil)
+var _ fs.FileReaddirenter = (*dirHandle)(nil)
+var _ fs.FileSeekdirer = (*dirHandle)(nil)
+var _ fs.FileLookuper = (*dirHandle)(nil)
+
+// newDirHandle lists the directory n in the order of the tree: the
+// server's default order, which local changes keep by inserting with
+// InsertChild, so listings are not sorted again here.
+func (n *FruitNode) newDirHandle(ctx context.Context) (*dirHandle, syscall.Errno) {
+	n.fsys.ensureLoaded(ctx, n.metadata.Path)
+	meta := n.resolveMetadata()
+	if meta == nil || !meta.IsDir {
+		return nil, syscall.ENOTDIR
+	}
+
+	n.fsys.mu.RLock()
+	children := append([]*models.FileNode(nil), meta.Children...)
+	n.fsys.mu.RUnlock()
+
+	h := &dirHandle{node: n, children: children, entries: make([]gofuse.DirEntry, 0, len(children)+1)}
+	for _, child := range children {
+		mode := uint32(syscall.S_IFREG)
+		if n.fsys.isSymlink(child) {
+			mode = syscall.S_IFLNK
+		} else if child.IsDir {
+			mode = syscall.S_IFDIR
+		}
+		h.entries = append(h.entries, gofuse.DirEntry{
+			Name: child.Name,
+			Mode: mode,
+			Off:  uint64(len(h.entries) + 1),
+		})
+	}
+	if meta.Path == "/" {
+		h.entries = append(h.entries, gofuse.DirEntry{Name: controlDirName, Mode: syscall.S_IFDIR, Off: uint64(len(h.entries) + 1)})
+	}
+	return h, 0
+}
+
+// OpendirHandle opens the directory for READDIR and READDIRPLUS.
+func (n *FruitNode) OpendirHandle(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	h, errno := n.newDirHandle(ctx)
+	if errno != 0 {
+		return nil, 0, errno
+	}
+	return h, 0, 0
+}
+
+// Readdir lists the directory; the kernel reads it through OpendirHandle.
+func (n *FruitNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	h, errno := n.newDirHandle(ctx)
+	if errno != 0 {
+		return nil, errno
+	}
+	return fs.NewListDirStream(h.entries), 0
+}
+
+func (h *dirHandle) Readdirent(ctx context.Context) (*gofuse.DirEntry, syscall.Errno) {
+	if h.pos >= len(h.entries) {
+		return nil, 0
+	}
+	de := h.entries[h.pos]
+	h.pos++
+	return &de, 0
+}
+
+// Seekdir moves to the entry after off, the offset of the entry the
+// kernel read last.
+func (h *dirHandle) Seekdir(ctx context.Context, off uint64) syscall.Errno {
+	if off > uint64(len(h.entries)) {
+		return syscall.EINVAL
+	}
+	h.pos = int(off)
+	return 0
+}
+
+// Lookup returns the inode of an entry for READDIRPLUS. name is normally
+// the entry Readdirent returned last; after an interrupted read the
+// kernel may ask for earlier ones again.
+func (h *dirHandle) Lookup(ctx context.Context, name string, out *gofuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	i := h.pos - 1
+	if i < 0 || i >= len(h.entries) || h.entries[i].Name != name {
+		i = -1
+		for j := range h.entries {
+			if h.entries[j].Name == name {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, syscall.ENOENT
+		}
+	}
+	if i == len(h.children) {
+		return h.node.lookupControlDir(ctx, out), 0
+	}
+	return h.node.childInode(ctx, h.children[i], out), 0
+}
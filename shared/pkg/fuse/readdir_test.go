@@ -0,0 +1,204 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	gofuse "github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// newListFS returns the root of a filesystem over tree, with the inode
+// bridge a mount would set up, so that lookups can create inodes.
+func newListFS(tb testing.TB, cfg Config, tree *models.FileNode) *FruitNode {
+	tb.Helper()
+	cfg.ServerURL = "http://127.0.0.1:1"
+	cfg.CacheDir = tb.TempDir()
+	f, err := NewFruitFS(cfg)
+	if err != nil {
+		tb.Fatalf("NewFruitFS: %v", err)
+	}
+	f.metadata = tree
+	root := &FruitNode{fsys: f, metadata: tree}
+	fs.NewNodeFS(root, &fs.Options{})
+	return root
+}
+
+func TestReaddirPlus(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	file := &models.FileNode{ID: "/b.txt", Path: "/b.txt", Name: "b.txt", Size: 5, ModTime: mtime}
+	sub := &models.FileNode{ID: "/docs/a.txt", Path: "/docs/a.txt", Name: "a.txt", Size: 7}
+	docs := &models.FileNode{ID: "/docs", Path: "/docs", Name: "docs", IsDir: true, AggSize: 7, Children: []*models.FileNode{sub}}
+	tree := &models.FileNode{ID: "/", Path: "/", IsDir: true, Children: []*models.FileNode{docs, file}}
+	root := newListFS(t, Config{RefreshInterval: 30 * time.Second, DirSizes: true}, tree)
+	ctx := context.Background()
+
+	fh, _, errno := root.OpendirHandle(ctx, 0)
+	if errno != 0 {
+		t.Fatalf("OpendirHandle: %v", errno)
+	}
+	h := fh.(*dirHandle)
+
+	want := []struct {
+		name string
+		mode uint32
+		size uint64
+	}{
+		{"docs", syscall.S_IFDIR | 0755, 7},
+		{"b.txt", syscall.S_IFREG | 0644, 5},
+		{controlDirName, syscall.S_IFDIR | 0555, 0},
+	}
+	for i, w := range want {
+		de, errno := h.Readdirent(ctx)
+		if errno != 0 || de == nil {
+			t.Fatalf("Readdirent %d: %v, %v", i, de, errno)
+		}
+		if de.Name != w.name || de.Off != uint64(i+1) {
+			t.Errorf("entry %d = %q at %d, want %q at %d", i, de.Name, de.Off, w.name, i+1)
+		}
+		var out gofuse.EntryOut
+		child, errno := h.Lookup(ctx, de.Name, &out)
+		if errno != 0 || child == nil {
+			t.Fatalf("Lookup(%q): %v", de.Name, errno)
+		}
+		if out.Mode != w.mode || out.Size != w.size {
+			t.Errorf("%s: mode %o size %d, want %o %d", w.name, out.Mode, out.Size, w.mode, w.size)
+		}
+		if w.name != controlDirName && (out.EntryTimeout() != 30*time.Second || out.AttrTimeout() != 30*time.Second) {
+			t.Errorf("%s: timeouts %v/%v, want the refresh interval", w.name, out.EntryTimeout(), out.AttrTimeout())
+		}
+	}
+	if de, _ := h.Readdirent(ctx); de != nil {
+		t.Errorf("entry after the end: %q", de.Name)
+	}
+
+	// A read the kernel repeats from an earlier offset
+	if errno := h.Seekdir(ctx, 1); errno != 0 {
+		t.Fatalf("Seekdir: %v", errno)
+	}
+	if de, _ := h.Readdirent(ctx); de == nil || de.Name != "b.txt" {
+		t.Errorf("after Seekdir(1): %v, want b.txt", de)
+	}
+	var out gofuse.EntryOut
+	if _, errno := h.Lookup(ctx, "docs", &out); errno != 0 || out.Mode&syscall.S_IFDIR == 0 {
+		t.Errorf("Lookup of an earlier entry: %v, mode %o", errno, out.Mode)
+	}
+	if _, errno := h.Lookup(ctx, "missing", &out); errno != syscall.ENOENT {
+		t.Errorf("Lookup(missing) = %v, want ENOENT", errno)
+	}
+}
+
+func TestAttrTimeout(t *testing.T) {
+	tests := []struct {
+		attr, refresh, want time.Duration
+	}{
+		{0, 0, defaultAttrTimeout},
+		{0, 30 * time.Second, 30 * time.Second},
+		{time.Minute, 30 * time.Second, time.Minute},
+		{-time.Second, 30 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		f := &FruitFS{cfg: Config{AttrTimeout: tt.attr, RefreshInterval: tt.refresh}}
+		if got := f.attrTimeout(); got != tt.want {
+			t.Errorf("attrTimeout(%v, refresh %v) = %v, want %v", tt.attr, tt.refresh, got, tt.want)
+		}
+	}
+}
+
+// syntheticTree returns a tree of 10 folders with 10 subfolders of 500
+// files each: 50,110 nodes.
+func syntheticTree() *models.FileNode {
+	root := &models.FileNode{ID: "/", Path: "/", Name: "", IsDir: true}
+	for i := 0; i < 10; i++ {
+		top := &models.FileNode{Path: fmt.Sprintf("/top-%02d", i), Name: fmt.Sprintf("top-%02d", i), IsDir: true}
+		for j := 0; j < 10; j++ {
+			sub := &models.FileNode{Path: fmt.Sprintf("%s/sub-%02d", top.Path, j), Name: fmt.Sprintf("sub-%02d", j), IsDir: true}
+			for k := 0; k < 500; k++ {
+				name := fmt.Sprintf("file-%03d.txt", k)
+				sub.Children = append(sub.Children, &models.FileNode{Path: sub.Path + "/" + name, Name: name, Size: int64(k)})
+			}
+			top.Children = append(top.Children, sub)
+		}
+		root.Children = append(root.Children, top)
+	}
+	return root
+}
+
+// listLookup walks n the way `ls -lR` does over READDIR: a listing, then
+// a lookup and a getattr for every entry.
+func listLookup(b *testing.B, n *FruitNode) {
+	ctx := context.Background()
+	ds, errno := n.Readdir(ctx)
+	if errno != 0 {
+		b.Fatalf("Readdir: %v", errno)
+	}
+	for ds.HasNext() {
+		de, _ := ds.Next()
+		if de.Name == controlDirName {
+			continue
+		}
+		var out gofuse.EntryOut
+		child, errno := n.Lookup(ctx, de.Name, &out)
+		if errno != 0 {
+			b.Fatalf("Lookup(%q): %v", de.Name, errno)
+		}
+		node := child.Operations().(*FruitNode)
+		var attr gofuse.AttrOut
+		node.Getattr(ctx, nil, &attr)
+		if de.Mode&syscall.S_IFDIR != 0 {
+			listLookup(b, node)
+		}
+	}
+}
+
+// listPlus walks n the way `ls -lR` does over READDIRPLUS: the listing
+// carries the attributes, which the kernel then keeps.
+func listPlus(b *testing.B, n *FruitNode) {
+	ctx := context.Background()
+	fh, _, errno := n.OpendirHandle(ctx, 0)
+	if errno != 0 {
+		b.Fatalf("OpendirHandle: %v", errno)
+	}
+	h := fh.(*dirHandle)
+	for {
+		de, _ := h.Readdirent(ctx)
+		if de == nil {
+			return
+		}
+		if de.Name == controlDirName {
+			continue
+		}
+		var out gofuse.EntryOut
+		child, errno := h.Lookup(ctx, de.Name, &out)
+		if errno != 0 {
+			b.Fatalf("Lookup(%q): %v", de.Name, errno)
+		}
+		if de.Mode&syscall.S_IFDIR != 0 {
+			listPlus(b, child.Operations().(*FruitNode))
+		}
+	}
+}
+
+// BenchmarkListTree compares the requests of `ls -lR` on a 50k-node tree
+// with and without READDIRPLUS.
+func BenchmarkListTree(b *testing.B) {
+	root := newListFS(b, Config{}, syntheticTree())
+	for _, bm := range []struct {
+		name string
+		list func(*testing.B, *FruitNode)
+	}{
+		{"lookup", listLookup},
+		{"readdirplus", listPlus},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bm.list(b, root)
+			}
+		})
+	}
+}
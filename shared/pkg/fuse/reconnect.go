@@ -0,0 +1,105 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/retry"
+)
+
+// contentRetry is how long content fetches keep trying through connection
+// errors before the read fails with EIO. It spans a typical server restart:
+// about 15 seconds of waiting, on top of the client's own quick retries.
+var contentRetry = retry.Config{
+	MaxAttempts: 6,
+	InitialWait: 500 * time.Millisecond,
+	MaxWait:     8 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+}
+
+// isConnError reports whether err means the server could not be reached or
+// the transfer was cut off, as opposed to the server refusing the request.
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// The client marks failed connections and 5xx responses retryable
+	if retry.IsRetryable(err) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// fetchWithRetry runs fetch, retrying with backoff while it fails with a
+// connection error. what names the fetch in the log.
+func (f *FruitFS) fetchWithRetry(ctx context.Context, what string, fetch func() error) error {
+	attempt := 0
+	return retry.Do(ctx, f.fetchRetry, func() error {
+		if attempt++; attempt > 1 {
+			f.stats.FetchRetries.Add(1)
+			logger.Info("Retrying %s (attempt %d)", what, attempt)
+		}
+		err := fetch()
+		if isConnError(err) && ctx.Err() == nil {
+			return retry.Retryable(err)
+		}
+		return err
+	})
+}
+
+// onStreamReconnect refreshes the metadata after the event stream was lost
+// and re-established: the server may have restarted and forgotten the
+// events the client missed.
+func (f *FruitFS) onStreamReconnect(ctx context.Context) {
+	f.stats.StreamReconnects.Add(1)
+	go func() {
+		defer logger.Recover("refresh after reconnect")
+		logger.Info("Event stream reconnected, refreshing metadata...")
+		if err := f.RefreshMetadata(ctx); err != nil {
+			logger.Error("Refresh after reconnect failed: %v", err)
+		}
+	}()
+}
+
+// healthState is what the health check last saw.
+type healthState struct {
+	outages  int64 // client outages already followed by a refresh
+	rejected bool  // the server rejected this client's version
+}
+
+// checkHealth pings the server. Once it is reachable after an outage or a
+// version rejection, noticed here or by any other request, the metadata is
+// refreshed; if that fails the next check tries again.
+func (f *FruitFS) checkHealth(ctx context.Context, st healthState) healthState {
+	err := f.client.Ping(ctx)
+	if _, rejected := client.AsUpgradeRequired(err); rejected {
+		st.rejected = true
+		return st
+	}
+	if err != nil {
+		return st
+	}
+
+	outages := f.client.Outages()
+	if outages == st.outages && !st.rejected {
+		return st
+	}
+	logger.Info("Server is back online, refreshing metadata...")
+	if err := f.RefreshMetadata(ctx); err != nil {
+		logger.Error("Failed to refresh metadata: %v", err)
+		return st
+	}
+	f.stats.ServerReconnects.Add(1)
+	return healthState{outages: outages}
+}
@@ -0,0 +1,180 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// restartableServer is an httptest server that can be killed and brought
+// back on the same address, like a server restarted for an upgrade.
+type restartableServer struct {
+	t       *testing.T
+	handler http.Handler
+	addr    string
+
+	mu sync.Mutex
+	ts *httptest.Server
+}
+
+func newRestartableServer(t *testing.T, handler http.Handler) *restartableServer {
+	t.Helper()
+	s := &restartableServer{t: t, handler: handler, addr: "127.0.0.1:0"}
+	s.start()
+	s.addr = s.ts.Listener.Addr().String()
+	t.Cleanup(s.kill)
+	return s
+}
+
+func (s *restartableServer) URL() string {
+	return "http://" + s.addr
+}
+
+func (s *restartableServer) start() {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.t.Errorf("restart server: %v", err)
+		return
+	}
+	ts := httptest.NewUnstartedServer(s.handler)
+	ts.Listener.Close()
+	ts.Listener = l
+	ts.Start()
+	s.mu.Lock()
+	s.ts = ts
+	s.mu.Unlock()
+}
+
+func (s *restartableServer) kill() {
+	s.mu.Lock()
+	ts := s.ts
+	s.ts = nil
+	s.mu.Unlock()
+	if ts != nil {
+		ts.CloseClientConnections()
+		ts.Close()
+	}
+}
+
+func newRestartFS(t *testing.T, url string) *FruitFS {
+	t.Helper()
+	f, err := NewFruitFS(Config{ServerURL: url, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	f.fetchRetry.InitialWait = 50 * time.Millisecond
+	f.fetchRetry.MaxWait = 200 * time.Millisecond
+	f.fetchRetry.MaxAttempts = 20
+	return f
+}
+
+func TestRangeReadSurvivesServerRestart(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<17) // 2 MiB
+	cut := make(chan struct{})
+	var requests atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/content/", func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		body := content[start : end+1]
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		if requests.Add(1) == 1 {
+			// Send half, then die mid-transfer
+			w.Write(body[:len(body)/2])
+			w.(http.Flusher).Flush()
+			close(cut)
+			<-r.Context().Done()
+			return
+		}
+		w.Write(body)
+	})
+	srv := newRestartableServer(t, mux)
+	f := newRestartFS(t, srv.URL())
+
+	go func() {
+		<-cut
+		srv.kill()
+		time.Sleep(300 * time.Millisecond)
+		srv.start()
+	}()
+
+	n := &FruitNode{fsys: f, metadata: &models.FileNode{ID: "/big.bin", Path: "/big.bin", Size: int64(len(content))}}
+	const off = 4096
+	dest := make([]byte, 256<<10)
+	res, errno := n.Read(context.Background(), &FileHandle{node: n}, dest, off)
+	if errno != 0 {
+		t.Fatalf("read across restart: errno = %v", errno)
+	}
+	got, _ := res.Bytes(nil)
+	if !bytes.Equal(got, content[off:off+len(dest)]) {
+		t.Errorf("read %d bytes with wrong content", len(got))
+	}
+	if f.GetStats().FetchRetries.Load() == 0 {
+		t.Error("no fetch retries recorded")
+	}
+	if !f.IsOnline() {
+		t.Error("client still offline after a successful read")
+	}
+}
+
+func TestHealthCheckRefreshesAfterOutage(t *testing.T) {
+	tree := &treeServer{}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/tree", tree)
+	mux.Handle("/api/v1/tree/", tree)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	srv := newRestartableServer(t, mux)
+	f := newRestartFS(t, srv.URL())
+	ctx := context.Background()
+
+	if err := f.FetchMetadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	st := healthState{outages: f.client.Outages()}
+	requests := func() int {
+		tree.mu.Lock()
+		defer tree.mu.Unlock()
+		return len(tree.requests)
+	}
+
+	// Online and in sync: nothing to do
+	st = f.checkHealth(ctx, st)
+	before := requests()
+
+	srv.kill()
+	st = f.checkHealth(ctx, st)
+	if f.IsOnline() || f.HealthState() != HealthOffline {
+		t.Fatalf("health = %s after the server went away", f.HealthState())
+	}
+
+	srv.start()
+	st = f.checkHealth(ctx, st)
+	if !f.IsOnline() {
+		t.Fatal("still offline after the server came back")
+	}
+	if requests() == before {
+		t.Error("metadata was not refreshed after the outage")
+	}
+	if got := f.GetStats().ServerReconnects.Load(); got != 1 {
+		t.Errorf("ServerReconnects = %d, want 1", got)
+	}
+
+	// Back in sync: the next check does not refresh again
+	after := requests()
+	f.checkHealth(ctx, st)
+	if requests() != after {
+		t.Error("refreshed again without an outage")
+	}
+}
@@ -0,0 +1,298 @@
+package fuse
+
+import (
+	"context"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+// Renames are done by the server's move endpoint, which moves a file or a
+// whole directory in one transaction, so editors that save by writing a
+// temporary file and renaming it over the original get an atomic replace.
+// Cached content follows the moved files to their new IDs instead of being
+// downloaded again, and the events the server sends for the move are
+// recognised as our own so they do not trigger a refresh.
+
+// Rename flags (see rename(2)).
+const (
+	renameNoReplace = 1 // RENAME_NOREPLACE
+	renameExchange  = 2 // RENAME_EXCHANGE
+)
+
+// ownMoveTTL is how long the server's events for an own rename are
+// waited for before events on those paths are handled again.
+const ownMoveTTL = 30 * time.Second
+
+// Rename moves a file or directory.
+func (n *FruitNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags&renameExchange != 0 {
+		return syscall.ENOTSUP
+	}
+	if n.fsys.cfg.ReadOnly {
+		return syscall.EROFS
+	}
+	newParentNode, ok := newParent.(*FruitNode)
+	if !ok {
+		return syscall.EIO
+	}
+
+	n.fsys.mu.RLock()
+	source := findChild(n.metadata, name)
+	target := findChild(newParentNode.metadata, newName)
+	n.fsys.mu.RUnlock()
+
+	if source == nil {
+		return syscall.ENOENT
+	}
+	if target != nil {
+		if errno := checkReplace(source, target, flags); errno != 0 {
+			return errno
+		}
+	}
+
+	oldPath := source.Path
+	newPath := buildChildPath(newParentNode.metadata.Path, newName)
+	if oldPath == newPath {
+		return 0
+	}
+	if source.IsDir && strings.HasPrefix(newPath, oldPath+"/") {
+		return syscall.EINVAL
+	}
+	if n.fsys.isExcluded(newPath) {
+		logger.Error("Cannot move %s to %s: excluded by selective sync", oldPath, newPath)
+		return syscall.EACCES
+	}
+
+	// A file created here but not flushed yet is not on the server: the
+	// flush uploads it under its new name
+	var resp *protocol.MoveResponse
+	if source.IsDir || source.Version > 0 {
+		if !n.fsys.client.IsOnline() {
+			n.fsys.stats.OfflineErrors.Add(1)
+			return syscall.ENETUNREACH
+		}
+		n.fsys.expectOwnMove(oldPath, newPath)
+		var err error
+		resp, err = n.fsys.client.Move(ctx, oldPath, newPath, flags&renameNoReplace == 0)
+		if err != nil {
+			n.fsys.forgetOwnMove(oldPath, newPath)
+			logger.Error("Rename %s -> %s failed: %v", oldPath, newPath, err)
+			return n.fsys.moveErrno(err, target)
+		}
+	}
+	if target != nil && !target.IsDir {
+		// Replaced: its cached content is stale. Normally the target ID is
+		// the moved file's new one and the re-keying below drops the entry
+		// anyway, pinned or not
+		n.fsys.cache.Evict(fstree.CacheID(target.ID))
+	}
+
+	// New server IDs by path; the IDs below a moved directory need a fetch
+	ids := make(map[string]string)
+	if resp != nil {
+		ids[newPath] = resp.ID
+		if source.IsDir {
+			if sub, err := n.fsys.client.FetchSubtree(ctx, newPath); err == nil {
+				collectIDs(sub, ids)
+			} else {
+				logger.Warn("Fetch of renamed %s failed, dropping its cached files: %v", newPath, err)
+			}
+		}
+	}
+
+	n.fsys.mu.Lock()
+	pinned := n.fsys.rekeyCacheLocked(source, oldPath, newPath, ids)
+	newParentNode.removeChildLocked(newName) // the replaced target, if any
+	n.removeChildLocked(name)
+	relocateNode(source, oldPath, newPath, ids)
+	source.Name = newName
+	if resp != nil && !resp.IsDir {
+		source.Version = resp.Version
+	}
+	fstree.InsertChild(newParentNode.metadata, source)
+	// Also update FruitFS tree
+	if treeSrc := fstree.FindByPath(n.fsys.metadata, n.metadata.Path); treeSrc != nil && treeSrc != n.metadata {
+		fstree.RemoveChild(treeSrc, name)
+	}
+	if treeDst := fstree.FindByPath(n.fsys.metadata, newParentNode.metadata.Path); treeDst != nil && treeDst != newParentNode.metadata {
+		fstree.RemoveChild(treeDst, newName)
+		fstree.InsertChild(treeDst, source)
+	}
+	if n.fsys.lazy {
+		movePathKeys(n.fsys.loaded, oldPath, newPath)
+	}
+	n.fsys.mu.Unlock()
+
+	n.fsys.dirtyMu.Lock()
+	movePathKeys(n.fsys.dirty, oldPath, newPath)
+	n.fsys.dirtyMu.Unlock()
+
+	if pinned {
+		if err := n.fsys.cache.SavePins(); err != nil {
+			logger.Error("Failed to save pins: %v", err)
+		}
+	}
+
+	n.fsys.stats.Renames.Add(1)
+	logger.Info("Renamed: %s -> %s", oldPath, newPath)
+	return 0
+}
+
+// findChild returns the child of dir called name, or nil. Called with
+// fsys.mu held.
+func findChild(dir *models.FileNode, name string) *models.FileNode {
+	if dir == nil {
+		return nil
+	}
+	for _, child := range dir.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// checkReplace applies the rules of rename(2) for an existing target.
+// Whether a directory target is empty is left to the server when its
+// children are not loaded.
+func checkReplace(source, target *models.FileNode, flags uint32) syscall.Errno {
+	switch {
+	case flags&renameNoReplace != 0:
+		return syscall.EEXIST
+	case source.IsDir && !target.IsDir:
+		return syscall.ENOTDIR
+	case !source.IsDir && target.IsDir:
+		return syscall.EISDIR
+	case target.IsDir && len(target.Children) > 0:
+		return syscall.ENOTEMPTY
+	}
+	return 0
+}
+
+// moveErrno maps a failed move to the errno rename(2) would return.
+func (f *FruitFS) moveErrno(err error, target *models.FileNode) syscall.Errno {
+	if client.IsConflict(err) {
+		if target != nil && target.IsDir {
+			return syscall.ENOTEMPTY
+		}
+		return syscall.EEXIST
+	}
+	return f.errno(err)
+}
+
+// collectIDs records the ID of node and every node below it by path.
+func collectIDs(node *models.FileNode, ids map[string]string) {
+	if node == nil {
+		return
+	}
+	ids[node.Path] = node.ID
+	for _, child := range node.Children {
+		collectIDs(child, ids)
+	}
+}
+
+// renamedPath returns where p ends up when oldPath is renamed to newPath.
+func renamedPath(p, oldPath, newPath string) string {
+	return newPath + strings.TrimPrefix(p, oldPath)
+}
+
+// rekeyCacheLocked moves the cache entries of the files at and below node
+// to their new IDs. Files whose new ID is unknown are evicted, since their
+// old ID may be reused by a new file at the old path. Reports whether a
+// pinned entry moved. Called with fsys.mu held.
+func (f *FruitFS) rekeyCacheLocked(node *models.FileNode, oldPath, newPath string, ids map[string]string) bool {
+	if node.IsDir {
+		pinned := false
+		for _, child := range node.Children {
+			if f.rekeyCacheLocked(child, oldPath, newPath, ids) {
+				pinned = true
+			}
+		}
+		return pinned
+	}
+
+	oldID := fstree.CacheID(node.ID)
+	p := renamedPath(node.Path, oldPath, newPath)
+	id, ok := ids[p]
+	if !ok {
+		f.cache.Evict(oldID)
+		return false
+	}
+	newID := fstree.CacheID(id)
+	if err := f.cache.Rename(oldID, newID, p); err != nil {
+		logger.Warn("Cache entry of %s dropped: %v", p, err)
+		return false
+	}
+	return f.cache.IsPinned(newID)
+}
+
+// relocateNode gives node and the nodes below it their paths and IDs after
+// a rename, in place, so open inodes keep pointing at them. Nodes whose
+// server ID is unknown use their path, as new local nodes do. Called with
+// fsys.mu held.
+func relocateNode(node *models.FileNode, oldPath, newPath string, ids map[string]string) {
+	node.Path = renamedPath(node.Path, oldPath, newPath)
+	if id, ok := ids[node.Path]; ok {
+		node.ID = id
+	} else {
+		node.ID = node.Path
+	}
+	for _, child := range node.Children {
+		relocateNode(child, oldPath, newPath, ids)
+	}
+}
+
+// movePathKeys re-keys the entries of m at and below oldPath to newPath.
+func movePathKeys[V any](m map[string]V, oldPath, newPath string) {
+	for p, v := range m {
+		if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+			delete(m, p)
+			m[renamedPath(p, oldPath, newPath)] = v
+		}
+	}
+}
+
+// expectOwnMove records that events for oldPath and newPath are coming
+// from our own rename.
+func (f *FruitFS) expectOwnMove(oldPath, newPath string) {
+	f.movesMu.Lock()
+	defer f.movesMu.Unlock()
+	until := time.Now().Add(ownMoveTTL)
+	f.ownMoves[oldPath] = until
+	f.ownMoves[newPath] = until
+}
+
+// forgetOwnMove drops the record of a rename that failed.
+func (f *FruitFS) forgetOwnMove(oldPath, newPath string) {
+	f.movesMu.Lock()
+	defer f.movesMu.Unlock()
+	delete(f.ownMoves, oldPath)
+	delete(f.ownMoves, newPath)
+}
+
+// consumeOwnMove reports whether an event for p is the expected echo of an
+// own rename. Each recorded path matches one event.
+func (f *FruitFS) consumeOwnMove(p string) bool {
+	f.movesMu.Lock()
+	defer f.movesMu.Unlock()
+	now := time.Now()
+	for path, until := range f.ownMoves {
+		if now.After(until) {
+			delete(f.ownMoves, path)
+		}
+	}
+	if _, ok := f.ownMoves[p]; !ok {
+		return false
+	}
+	delete(f.ownMoves, p)
+	return true
+}
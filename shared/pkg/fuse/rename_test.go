@@ -0,0 +1,152 @@
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+)
+
+// moveServer answers move requests like the server and records them.
+type moveServer struct {
+	moves   []protocol.MoveRequest
+	subtree *models.FileNode // served for the tree of a moved directory
+}
+
+func (s *moveServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/move", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.MoveRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		s.moves = append(s.moves, req)
+		json.NewEncoder(w).Encode(protocol.MoveResponse{
+			From: req.From, To: req.To, ID: "id" + req.To, Version: 6, Replaced: req.Overwrite,
+		})
+	})
+	mux.HandleFunc("GET /api/v1/tree/", func(w http.ResponseWriter, r *http.Request) {
+		if s.subtree == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(protocol.TreeResponse{Root: s.subtree})
+	})
+	return mux
+}
+
+func newRenameFS(t *testing.T, srv *moveServer) (*FruitFS, *FruitNode, *FruitNode) {
+	t.Helper()
+	ts := httptest.NewServer(srv.handler())
+	t.Cleanup(ts.Close)
+	f, err := NewFruitFS(Config{ServerURL: ts.URL, CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewFruitFS: %v", err)
+	}
+	f.metadata = &models.FileNode{ID: "/", Path: "/", IsDir: true, Children: []*models.FileNode{
+		{ID: "a", Name: "a", Path: "/a", IsDir: true, Children: []*models.FileNode{
+			{ID: "doc", Name: "doc.txt", Path: "/a/doc.txt", Size: 3, Version: 2},
+			{ID: "sub", Name: "sub", Path: "/a/sub", IsDir: true, Children: []*models.FileNode{
+				{ID: "deep", Name: "deep.txt", Path: "/a/sub/deep.txt", Size: 4, Version: 1},
+			}},
+		}},
+		{ID: "b", Name: "b", Path: "/b", IsDir: true, Children: []*models.FileNode{
+			{ID: "old", Name: "doc.txt", Path: "/b/doc.txt", Size: 5, Version: 5},
+		}},
+	}}
+	a := &FruitNode{fsys: f, metadata: f.metadata.Children[0]}
+	b := &FruitNode{fsys: f, metadata: f.metadata.Children[1]}
+	return f, a, b
+}
+
+func TestRenameOverwritesAndKeepsCache(t *testing.T) {
+	srv := &moveServer{}
+	f, a, b := newRenameFS(t, srv)
+	f.cache.PutFile("doc", "/a/doc.txt", bytes.NewReader([]byte("new")), 3)
+	f.cache.PutFile("old", "/b/doc.txt", bytes.NewReader([]byte("stale")), 5)
+
+	if errno := a.Rename(context.Background(), "doc.txt", b, "doc.txt", 0); errno != 0 {
+		t.Fatalf("Rename = %v", errno)
+	}
+	if len(srv.moves) != 1 || srv.moves[0] != (protocol.MoveRequest{From: "/a/doc.txt", To: "/b/doc.txt", Overwrite: true}) {
+		t.Fatalf("moves = %+v", srv.moves)
+	}
+
+	// The cached content follows the file to its new ID; the replaced
+	// file's entry is gone
+	p, ok := f.cache.Get("id_b_doc.txt")
+	if !ok {
+		t.Fatal("renamed file not cached under its new ID")
+	}
+	if data, _ := os.ReadFile(p); string(data) != "new" {
+		t.Errorf("cached content = %q", data)
+	}
+	if f.cache.IsCached("doc") || f.cache.IsCached("old") {
+		t.Error("entries left under the old IDs")
+	}
+
+	if len(b.metadata.Children) != 1 {
+		t.Fatalf("target dir has %d children, want 1", len(b.metadata.Children))
+	}
+	moved := b.metadata.Children[0]
+	if moved.ID != "id/b/doc.txt" || moved.Path != "/b/doc.txt" || moved.Version != 6 {
+		t.Errorf("moved node = %+v", moved)
+	}
+	if findChild(a.metadata, "doc.txt") != nil {
+		t.Error("source still listed")
+	}
+
+	// The server's events for the rename are ours, once each
+	if !f.consumeOwnMove("/a/doc.txt") || !f.consumeOwnMove("/b/doc.txt") {
+		t.Error("events of the own rename not recognised")
+	}
+	if f.consumeOwnMove("/b/doc.txt") {
+		t.Error("a later event on the same path was treated as ours")
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	srv := &moveServer{}
+	_, a, b := newRenameFS(t, srv)
+	if errno := a.Rename(context.Background(), "doc.txt", b, "doc.txt", renameNoReplace); errno != syscall.EEXIST {
+		t.Errorf("Rename = %v, want EEXIST", errno)
+	}
+	if errno := a.Rename(context.Background(), "sub", b, "doc.txt", 0); errno != syscall.ENOTDIR {
+		t.Errorf("dir onto file = %v, want ENOTDIR", errno)
+	}
+	if len(srv.moves) != 0 {
+		t.Errorf("moves = %+v, want none", srv.moves)
+	}
+}
+
+func TestRenameDirectory(t *testing.T) {
+	srv := &moveServer{subtree: &models.FileNode{ID: "nc", Path: "/c", IsDir: true, Children: []*models.FileNode{
+		{ID: "ndoc", Path: "/c/doc.txt"},
+		{ID: "nsub", Path: "/c/sub", IsDir: true, Children: []*models.FileNode{
+			{ID: "ndeep", Path: "/c/sub/deep.txt"},
+		}},
+	}}}
+	f, _, _ := newRenameFS(t, srv)
+	root := &FruitNode{fsys: f, metadata: f.metadata}
+	f.cache.PutFile("deep", "/a/sub/deep.txt", bytes.NewReader([]byte("1234")), 4)
+	f.setDirty("/a/doc.txt", true)
+
+	if errno := root.Rename(context.Background(), "a", root, "c", 0); errno != 0 {
+		t.Fatalf("Rename = %v", errno)
+	}
+	if !f.cache.IsCached("ndeep") || f.cache.IsCached("deep") {
+		t.Error("cache entry below the directory not moved to its new ID")
+	}
+	deep := findChild(findChild(findChild(f.metadata, "c"), "sub"), "deep.txt")
+	if deep == nil || deep.ID != "ndeep" || deep.Path != "/c/sub/deep.txt" {
+		t.Errorf("node below the moved directory = %+v", deep)
+	}
+	if !f.isDirty("/c/doc.txt") || f.isDirty("/a/doc.txt") {
+		t.Error("unflushed writes not moved with the file")
+	}
+}
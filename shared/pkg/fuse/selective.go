@@ -0,0 +1,63 @@
+package fuse
+
+import (
+	"context"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/logger"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/selective"
+)
+
+// Selective sync: excluded folders (Config.Exclude plus the sync-config
+// file in the cache directory) are pruned from every tree the server
+// sends, never loaded in lazy mode, and events below them are ignored.
+// Nothing can be created under an excluded path, since its server
+// contents are unknown. The file is re-read with every refresh, so the
+// sync-config subcommand takes effect on a running mount.
+
+// isExcluded reports whether the server path p is left out of the mount.
+func (f *FruitFS) isExcluded(p string) bool {
+	return f.excludeSet().Excluded(p)
+}
+
+// excludeSet returns the current exclusions.
+func (f *FruitFS) excludeSet() *selective.Excludes {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.excludes
+}
+
+// Excludes returns the excluded server paths.
+func (f *FruitFS) Excludes() []string {
+	return f.excludeSet().Prefixes()
+}
+
+// prune drops the excluded nodes below node, which is not in the tree yet.
+func (f *FruitFS) prune(node *models.FileNode) {
+	if n := f.excludeSet().Prune(node); n > 0 {
+		logger.Debug("Selective sync: skipped %d excluded items below %s", n, node.Path)
+	}
+}
+
+// reloadExcludes re-reads the sync-config file and refetches the tree if
+// the exclusions changed, so newly included folders appear and newly
+// excluded ones vanish.
+func (f *FruitFS) reloadExcludes(ctx context.Context) {
+	ex, err := selective.LoadWith(f.cfg.CacheDir, f.cfg.Exclude)
+	if err != nil {
+		logger.Error("Failed to load selective sync config: %v", err)
+		return
+	}
+	f.mu.Lock()
+	changed := !ex.Equal(f.excludes)
+	f.excludes = ex
+	f.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	logger.Info("Selective sync changed, excluded: %v", ex.Prefixes())
+	if err := f.FetchMetadata(ctx); err != nil {
+		logger.Error("Metadata fetch after selective sync change failed: %v", err)
+	}
+}
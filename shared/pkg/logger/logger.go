@@ -4,9 +4,11 @@ package logger
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Level represents the log level.
@@ -15,6 +17,7 @@ type Level int
 const (
 	LevelQuiet Level = iota
 	LevelError
+	LevelWarn
 	LevelInfo
 	LevelDebug
 )
@@ -24,6 +27,7 @@ type Logger struct {
 	mu     sync.Mutex
 	level  Level
 	out    io.Writer
+	sinks  []io.Writer
 	prefix string
 }
 
@@ -39,13 +43,22 @@ func SetLevel(level Level) {
 	defaultLogger.level = level
 }
 
-// SetOutput sets the output writer.
+// SetOutput sets the console output writer, stderr by default. Sinks
+// added with AddSink keep receiving records.
 func SetOutput(w io.Writer) {
 	defaultLogger.mu.Lock()
 	defer defaultLogger.mu.Unlock()
 	defaultLogger.out = w
 }
 
+// AddSink adds a writer, such as a log file, that receives every record
+// along with the console output.
+func AddSink(w io.Writer) {
+	defaultLogger.mu.Lock()
+	defer defaultLogger.mu.Unlock()
+	defaultLogger.sinks = append(defaultLogger.sinks, w)
+}
+
 // SetPrefix sets the log prefix.
 func SetPrefix(prefix string) {
 	defaultLogger.mu.Lock()
@@ -60,6 +73,8 @@ func ParseLevel(s string) Level {
 		return LevelQuiet
 	case "error", "e":
 		return LevelError
+	case "warn", "warning", "w":
+		return LevelWarn
 	case "info", "i":
 		return LevelInfo
 	case "debug", "d", "verbose", "v":
@@ -81,23 +96,46 @@ func (l *Logger) log(level Level, format string, args ...interface{}) {
 	switch level {
 	case LevelError:
 		prefix = "[ERROR] "
+	case LevelWarn:
+		prefix = "[WARN]  "
 	case LevelInfo:
 		prefix = "[INFO]  "
 	case LevelDebug:
 		prefix = "[DEBUG] "
 	}
 
-	msg := fmt.Sprintf(format, args...)
-	log.SetOutput(l.out)
-	log.SetFlags(log.Ltime)
-	log.Printf("%s%s%s", l.prefix, prefix, msg)
+	// Every line of a multi-line message gets the timestamp and level,
+	// so that log files can be searched line by line
+	head := time.Now().Format(timeFormat) + " " + l.prefix + prefix
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(fmt.Sprintf(format, args...), "\n"), "\n") {
+		b.WriteString(head)
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	record := []byte(b.String())
+
+	if l.out != nil {
+		l.out.Write(record)
+	}
+	for _, w := range l.sinks {
+		w.Write(record)
+	}
 }
 
+// timeFormat is the timestamp at the start of every line.
+const timeFormat = "2006-01-02 15:04:05.000"
+
 // Error logs an error message.
 func Error(format string, args ...interface{}) {
 	defaultLogger.log(LevelError, format, args...)
 }
 
+// Warn logs a warning message.
+func Warn(format string, args ...interface{}) {
+	defaultLogger.log(LevelWarn, format, args...)
+}
+
 // Info logs an info message.
 func Info(format string, args ...interface{}) {
 	defaultLogger.log(LevelInfo, format, args...)
@@ -122,3 +160,13 @@ func Infof(format string, args ...interface{}) {
 func Debugf(format string, args ...interface{}) {
 	Debug(format, args...)
 }
+
+// Recover logs a panic of the calling goroutine with its stack instead of
+// letting it kill the process. Use it as
+//
+//	defer logger.Recover("refresh loop")
+func Recover(name string) {
+	if r := recover(); r != nil {
+		Error("Panic in %s: %v\n%s", name, r, debug.Stack())
+	}
+}
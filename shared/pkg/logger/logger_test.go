@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the default logger's output to a buffer, plus a sink,
+// for the duration of the test.
+func captureLogs(t *testing.T) (console, sink *bytes.Buffer) {
+	t.Helper()
+	console, sink = &bytes.Buffer{}, &bytes.Buffer{}
+	defaultLogger.mu.Lock()
+	out, sinks, level := defaultLogger.out, defaultLogger.sinks, defaultLogger.level
+	defaultLogger.out = console
+	defaultLogger.sinks = nil
+	defaultLogger.level = LevelDebug
+	defaultLogger.mu.Unlock()
+	AddSink(sink)
+	t.Cleanup(func() {
+		defaultLogger.mu.Lock()
+		defaultLogger.out, defaultLogger.sinks, defaultLogger.level = out, sinks, level
+		defaultLogger.mu.Unlock()
+	})
+	return console, sink
+}
+
+func TestLogFormat(t *testing.T) {
+	console, sink := captureLogs(t)
+
+	Info("mounted at %s", "/mnt")
+	Error("first\nsecond\n")
+	Debug("details")
+
+	line := regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} \[(ERROR|WARN|INFO|DEBUG)\] +(.*)$`)
+	var got []string
+	for _, l := range strings.Split(strings.TrimSuffix(console.String(), "\n"), "\n") {
+		m := line.FindStringSubmatch(l)
+		if m == nil {
+			t.Fatalf("line %q has no timestamp or level", l)
+		}
+		got = append(got, m[1]+" "+m[2])
+	}
+	want := []string{"INFO mounted at /mnt", "ERROR first", "ERROR second", "DEBUG details"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	if sink.String() != console.String() {
+		t.Errorf("sink got %q, console %q", sink.String(), console.String())
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	console, sink := captureLogs(t)
+	SetLevel(LevelWarn)
+
+	Info("hidden")
+	Warn("shown")
+
+	if strings.Contains(console.String(), "hidden") || strings.Contains(sink.String(), "hidden") {
+		t.Error("info record logged at warn level")
+	}
+	if !strings.Contains(sink.String(), "[WARN]  shown") {
+		t.Errorf("sink = %q, want the warning", sink.String())
+	}
+}
+
+func TestRecover(t *testing.T) {
+	_, sink := captureLogs(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer Recover("test loop")
+		panic("boom")
+	}()
+	<-done
+
+	out := sink.String()
+	if !strings.Contains(out, "[ERROR] Panic in test loop: boom") {
+		t.Errorf("log = %q, want the panic", out)
+	}
+	if !strings.Contains(out, "logger_test.go") {
+		t.Errorf("log = %q, want the stack", out)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "client.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, rec := range []string{"aaaaaa\n", "bbb\n", "cccccc\n", "dddddd\n", "eeeeee\n"} {
+		if _, err := f.Write([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Records are never split, and only two rotated files are kept
+	want := map[string]string{
+		path:        "eeeeee\n",
+		path + ".1": "dddddd\n",
+		path + ".2": "cccccc\n",
+	}
+	for p, content := range want {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(p), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want at most 2 rotated files", filepath.Base(path))
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, 6, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new\n"))
+	f.Close()
+	if _, err := f.Write([]byte("late\n")); err == nil {
+		t.Error("write after close succeeded")
+	}
+
+	// The existing size counts towards the limit
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "old\n" {
+		t.Errorf("rotated = %q, want the old content", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("current = %q, want the new record", data)
+	}
+}
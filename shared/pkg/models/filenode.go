@@ -17,13 +17,44 @@ type FileNode struct {
 	Visibility string      `json:"visibility,omitempty"`
 	GroupID    int         `json:"group_id,omitempty"`
 	Children   []*FileNode `json:"children,omitempty"`
+
+	// ChildCount is the number of children of a directory. It can exceed
+	// len(Children) when the server returned a partial tree (depth or
+	// paging), so clients know there is more to fetch.
+	ChildCount int `json:"child_count,omitempty"`
+
+	// HasChildren is set on a directory that is not empty, also when its
+	// children were left out, so that clients rendering lazily know
+	// whether to offer to expand it.
+	HasChildren bool `json:"has_children,omitempty"`
+
+	// AggSize is the total size of the files anywhere below a directory and
+	// ItemCount the number of files and directories below it. Both are
+	// zero for files.
+	AggSize   int64 `json:"agg_size,omitempty"`
+	ItemCount int   `json:"item_count,omitempty"`
+
+	// Alias is set on an alias: a node standing for the file or directory
+	// at AliasTarget, whose content and children it shows. The nodes below
+	// an alias directory have the AliasTarget they stand for too, but only
+	// those that are aliases themselves have the Alias flag.
+	Alias       bool   `json:"alias,omitempty"`
+	AliasTarget string `json:"alias_target,omitempty"`
+}
+
+// SetChildCount sets the ChildCount and HasChildren of a directory.
+func (n *FileNode) SetChildCount(count int) {
+	n.ChildCount = count
+	n.HasChildren = count > 0
 }
 
 // CacheEntry represents a cached file on the client.
 type CacheEntry struct {
 	FileID     string    `json:"file_id"`
+	Path       string    `json:"path,omitempty"` // server path, when known
 	LocalPath  string    `json:"local_path"`
 	Size       int64     `json:"size"`
 	LastAccess time.Time `json:"last_access"`
 	Pinned     bool      `json:"pinned"`
+	External   bool      `json:"external,omitempty"` // content lives at LocalPath outside the cache dir
 }
@@ -0,0 +1,281 @@
+// Package pattern implements gitignore-style path matching shared by the
+// client (prefetch, pinning, exclusions) and the server (upload policies,
+// search filters), so every feature interprets a pattern the same way.
+//
+// Semantics, for a slash-separated path relative to the root (a leading "/"
+// on the path is ignored):
+//
+//   - "*" matches any run of characters except "/"; "?" matches one
+//     character except "/"; "[abc]", "[a-z]" and "[!a-z]" (or "[^a-z]")
+//     match character classes. A backslash escapes the next character.
+//   - A pattern with no "/" (other than a trailing one) matches a name at any
+//     depth: "*.log" matches "a.log" and "x/y/a.log".
+//   - A pattern containing a "/" elsewhere is anchored to the root:
+//     "docs/*.md" matches "docs/a.md" but not "x/docs/a.md". A leading "/"
+//     only forces anchoring: "/build" matches "build" but not "x/build".
+//   - "**" as a whole segment matches zero or more directories:
+//     "**/tmp" matches "tmp" and "a/b/tmp", "a/**/b" matches "a/b" and
+//     "a/x/y/b", and a trailing "a/**" matches everything inside "a" (but not
+//     "a" itself). "**" inside a segment behaves like "*".
+//   - A trailing "/" restricts the pattern to directories: "cache/" matches
+//     a directory named cache but not a file named cache.
+//   - A path also matches when any of its parent directories matches, so
+//     "node_modules" or "docs/" covers everything beneath that directory.
+//   - In a Matcher, a leading "!" negates a pattern and the last matching
+//     pattern wins. Unlike git, a negation can re-include a path inside an
+//     excluded directory: ["build/", "!build/keep.txt"] matches
+//     "build/a.o" but not "build/keep.txt". Use "\!" or "\#" for a literal
+//     leading "!" or "#".
+//   - Matching is case-sensitive unless Options.CaseInsensitive is set.
+package pattern
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Options control how patterns are compiled.
+type Options struct {
+	// CaseInsensitive makes all comparisons ignore case.
+	CaseInsensitive bool
+}
+
+// Pattern is a single compiled pattern.
+type Pattern struct {
+	source   string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	fold     bool
+}
+
+// Compile parses a single pattern. Blank patterns are rejected; comment
+// handling is left to NewMatcher.
+func Compile(pattern string, opts Options) (*Pattern, error) {
+	p := &Pattern{source: pattern, fold: opts.CaseInsensitive}
+
+	s := strings.TrimRight(pattern, " \t\r\n")
+	if strings.HasPrefix(s, "!") {
+		p.negate = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, `\!`) || strings.HasPrefix(s, `\#`) {
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	if s == "" {
+		return nil, fmt.Errorf("empty pattern %q", pattern)
+	}
+
+	anchored := strings.Contains(s, "/")
+	s = strings.TrimLeft(s, "/")
+	if s == "" {
+		return nil, fmt.Errorf("pattern %q matches nothing", pattern)
+	}
+	if p.fold {
+		s = strings.ToLower(s)
+	}
+
+	var segs []string
+	if !anchored {
+		segs = append(segs, "**")
+	}
+	for _, seg := range strings.Split(s, "/") {
+		if seg == "" {
+			continue // collapse "a//b"
+		}
+		if seg != "**" {
+			if strings.Contains(seg, "**") {
+				seg = collapseStars(seg)
+			}
+			seg = convertClassNegation(seg)
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		// Consecutive "**" segments are equivalent to one
+		if seg == "**" && len(segs) > 0 && segs[len(segs)-1] == "**" {
+			continue
+		}
+		segs = append(segs, seg)
+	}
+	p.segments = segs
+	return p, nil
+}
+
+// String returns the pattern as written.
+func (p *Pattern) String() string { return p.source }
+
+// Negated reports whether the pattern starts with "!".
+func (p *Pattern) Negated() bool { return p.negate }
+
+// Match reports whether the pattern matches path or one of its parent
+// directories. isDir tells whether path itself is a directory. Negation is
+// ignored here; it only affects Matcher.
+func (p *Pattern) Match(name string, isDir bool) bool {
+	parts := splitPath(name, p.fold)
+	if len(parts) == 0 {
+		return false
+	}
+	for i := len(parts); i >= 1; i-- {
+		dir := i < len(parts) || isDir
+		if p.dirOnly && !dir {
+			continue
+		}
+		if matchSegments(p.segments, parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matcher evaluates an ordered list of patterns; the last matching pattern
+// decides the outcome.
+type Matcher struct {
+	patterns []*Pattern
+}
+
+// NewMatcher compiles patterns in order. Blank entries and lines starting
+// with "#" are skipped, so the contents of an ignore file can be passed
+// line by line.
+func NewMatcher(patterns []string, opts Options) (*Matcher, error) {
+	m := &Matcher{}
+	for _, raw := range patterns {
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		p, err := Compile(trimmed, opts)
+		if err != nil {
+			return nil, err
+		}
+		m.patterns = append(m.patterns, p)
+	}
+	return m, nil
+}
+
+// ParseLines splits text into lines for NewMatcher.
+func ParseLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// Empty reports whether the matcher has no patterns.
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// Patterns returns the compiled patterns in evaluation order.
+func (m *Matcher) Patterns() []*Pattern {
+	if m == nil {
+		return nil
+	}
+	return m.patterns
+}
+
+// Match reports whether path is selected by the pattern list.
+func (m *Matcher) Match(name string, isDir bool) bool {
+	matched, _ := m.Explain(name, isDir)
+	return matched
+}
+
+// Explain is like Match but also returns the deciding pattern (nil when no
+// pattern matched).
+func (m *Matcher) Explain(name string, isDir bool) (bool, *Pattern) {
+	if m == nil {
+		return false, nil
+	}
+	var last *Pattern
+	for _, p := range m.patterns {
+		if p.Match(name, isDir) {
+			last = p
+		}
+	}
+	if last == nil {
+		return false, nil
+	}
+	return !last.negate, last
+}
+
+// Match is a convenience wrapper that compiles a single case-sensitive
+// pattern and matches it against a file path.
+func Match(pattern, name string) (bool, error) {
+	p, err := Compile(pattern, Options{})
+	if err != nil {
+		return false, err
+	}
+	return p.Match(name, false), nil
+}
+
+// matchSegments matches pattern segments against path segments, where "**"
+// consumes zero or more path segments (at least one when it is last).
+func matchSegments(pat, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			rest := pat[1:]
+			if len(rest) == 0 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(rest, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], parts[0]); !ok {
+			return false
+		}
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+func splitPath(name string, fold bool) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	if fold {
+		name = strings.ToLower(name)
+	}
+	return strings.Split(name, "/")
+}
+
+// collapseStars turns runs of "*" into a single "*" (outside classes and
+// escapes are not a concern for path.Match; "**" simply means "*").
+func collapseStars(seg string) string {
+	for strings.Contains(seg, "**") {
+		seg = strings.ReplaceAll(seg, "**", "*")
+	}
+	return seg
+}
+
+// convertClassNegation rewrites gitignore's "[!...]" to path.Match's "[^...]".
+func convertClassNegation(seg string) string {
+	if !strings.Contains(seg, "[!") {
+		return seg
+	}
+	var b strings.Builder
+	escaped := false
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '[' && i+1 < len(seg) && seg[i+1] == '!':
+			b.WriteString("[^")
+			i++
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
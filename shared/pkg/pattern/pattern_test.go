@@ -0,0 +1,211 @@
+package pattern
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// Plain names match at any depth
+		{"foo.txt", "foo.txt", false, true},
+		{"foo.txt", "/foo.txt", false, true},
+		{"foo.txt", "a/b/foo.txt", false, true},
+		{"foo.txt", "foo.txt.bak", false, false},
+		{"foo", "foo/bar.txt", false, true},
+		{"foo", "xfoo", false, false},
+
+		// "*" does not cross separators
+		{"*.log", "a.log", false, true},
+		{"*.log", "x/y/a.log", false, true},
+		{"*.log", "a.log.gz", false, false},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "docs/sub/a.md", false, false},
+		{"a*b", "a/b", false, false},
+
+		// "?" and character classes
+		{"file?.txt", "file1.txt", false, true},
+		{"file?.txt", "file10.txt", false, false},
+		{"file?.txt", "file/.txt", false, false},
+		{"[abc].txt", "b.txt", false, true},
+		{"[abc].txt", "d.txt", false, false},
+		{"[a-c]x", "cx", false, true},
+		{"[!a-c]x", "cx", false, false},
+		{"[!a-c]x", "dx", false, true},
+		{"[^a-c]x", "dx", false, true},
+
+		// Escapes
+		{`\*.txt`, "*.txt", false, true},
+		{`\*.txt`, "a.txt", false, false},
+		{`\#notes`, "#notes", false, true},
+		{`\!important`, "!important", false, true},
+
+		// Anchoring
+		{"docs/a.md", "docs/a.md", false, true},
+		{"docs/a.md", "x/docs/a.md", false, false},
+		{"/build", "build", false, true},
+		{"/build", "build/out.o", false, true},
+		{"/build", "src/build", false, false},
+		{"/build", "src/build/out.o", false, false},
+
+		// Directory-only patterns
+		{"cache/", "cache", true, true},
+		{"cache/", "cache", false, false},
+		{"cache/", "cache/x.bin", false, true},
+		{"cache/", "a/cache/x.bin", false, true},
+		{"/cache/", "a/cache/x.bin", false, false},
+
+		// "**"
+		{"**/tmp", "tmp", true, true},
+		{"**/tmp", "a/b/tmp", true, true},
+		{"**/tmp", "a/b/tmp/file", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "x/a/b", false, false},
+		{"a/**", "a", true, false},
+		{"a/**", "a/x", false, true},
+		{"a/**", "a/x/y/z", false, true},
+		{"a/**/*.jpg", "a/photo.jpg", false, true},
+		{"a/**/*.jpg", "a/2024/01/photo.jpg", false, true},
+		{"a/**/*.jpg", "a/2024/photo.png", false, false},
+		{"**", "anything/at/all", false, true},
+		{"x**y", "xabcy", false, true},
+		{"x**y", "xa/by", false, false},
+		{"a/**/**/b", "a/b", false, true},
+
+		// Path normalization
+		{"a/b", "a//b", false, true},
+		{"a/b", "./a/b", false, true},
+		{"a/b", "a/c/../b", false, true},
+		{"a", "", false, false},
+		{"a", "/", true, false},
+
+		// Case sensitivity (default on)
+		{"*.JPG", "photo.jpg", false, false},
+		{"Photos/", "photos/a.jpg", false, false},
+	}
+
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern, Options{})
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Compile(%q).Match(%q, dir=%v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestPatternCaseInsensitive(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.JPG", "photo.jpg", true},
+		{"*.jpg", "PHOTO.JPG", true},
+		{"Photos/", "photos/a.jpg", true},
+		{"[A-C]x", "bX", true},
+		{"docs/readme.md", "DOCS/README.MD", true},
+		{"docs/readme.md", "other/README.MD", false},
+	}
+
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern, Options{CaseInsensitive: true})
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.path, false); got != tt.want {
+			t.Errorf("Compile(%q, fold).Match(%q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pat := range []string{"", "   ", "!", "/", "//", "[abc", "a/[/b"} {
+		if _, err := Compile(pat, Options{}); err == nil {
+			t.Errorf("Compile(%q) should fail", pat)
+		}
+	}
+}
+
+func TestMatcherNegation(t *testing.T) {
+	m, err := NewMatcher([]string{
+		"# build artifacts",
+		"",
+		"*.log",
+		"!important.log",
+		"build/",
+		"!build/keep.txt",
+	}, Options{})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	if len(m.Patterns()) != 4 {
+		t.Fatalf("expected 4 patterns (comments/blank skipped), got %d", len(m.Patterns()))
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"logs/debug.log", false, true},
+		{"important.log", false, false},
+		{"logs/important.log", false, false},
+		{"build", true, true},
+		{"build/a.o", false, true},
+		{"build/keep.txt", false, false},
+		{"src/main.go", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherLastMatchWins(t *testing.T) {
+	m, err := NewMatcher([]string{"!*.txt", "*.txt"}, Options{})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	matched, rule := m.Explain("a.txt", false)
+	if !matched || rule == nil || rule.String() != "*.txt" {
+		t.Errorf("Explain = %v, %v; want true, *.txt", matched, rule)
+	}
+
+	matched, rule = m.Explain("a.md", false)
+	if matched || rule != nil {
+		t.Errorf("Explain(a.md) = %v, %v; want false, nil", matched, rule)
+	}
+}
+
+func TestMatcherEmpty(t *testing.T) {
+	var nilMatcher *Matcher
+	if !nilMatcher.Empty() || nilMatcher.Match("a", false) {
+		t.Error("nil matcher should be empty and match nothing")
+	}
+
+	m, err := NewMatcher(ParseLines("# only comments\r\n\r\n"), Options{})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	if !m.Empty() {
+		t.Error("matcher with only comments should be empty")
+	}
+}
+
+func TestMatchHelper(t *testing.T) {
+	ok, err := Match("*.go", "cmd/main.go")
+	if err != nil || !ok {
+		t.Errorf("Match(*.go, cmd/main.go) = %v, %v", ok, err)
+	}
+	if _, err := Match("[", "x"); err == nil {
+		t.Error("Match with bad pattern should fail")
+	}
+}
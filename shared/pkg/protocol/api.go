@@ -10,14 +10,40 @@ import (
 // TreeResponse is returned by GET /api/v1/tree
 type TreeResponse struct {
 	Root *models.FileNode `json:"root"`
+
+	// Partial is set when the server honoured ?depth=, ?offset= or ?limit=
+	// and Root holds only part of the subtree. Older servers ignore these
+	// parameters, return the whole subtree and never set it.
+	Partial bool `json:"partial,omitempty"`
 }
 
-// ErrorResponse is returned on API errors.
+// ErrorResponse is returned on API errors. Code repeats the HTTP status;
+// ErrorCode says which of the ErrorCode constants the failure is, so that
+// clients need not parse Error.
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Details string `json:"details,omitempty"`
-}
+	Error     string    `json:"error"`
+	Code      int       `json:"code"`
+	ErrorCode ErrorCode `json:"error_code"`
+	Details   string    `json:"details,omitempty"`
+	RequestID string    `json:"request_id,omitempty"` // the X-Request-ID of the failed request
+}
+
+// DetailPasswordChangeRequired is the ErrorResponse.Details of the 403
+// returned for every request of a user who must change their password,
+// except POST /api/v1/auth/password and logout.
+const DetailPasswordChangeRequired = "password_change_required"
+
+// DetailInvalidPath is the ErrorResponse.Details of the 400 returned when a
+// path to create or rename to is too long, too deep, or has a segment that
+// is ".", "..", contains control characters or starts or ends with
+// whitespace. Error says which rule was broken.
+const DetailInvalidPath = "invalid_path"
+
+// AcceptRedirect is the media type a client lists in its Accept header on
+// content and share downloads to allow a 302 redirect to a presigned
+// object-store URL instead of a proxied body. Clients that omit it (e.g.
+// the FUSE client) are always served through the API.
+const AcceptRedirect = "application/vnd.fruitsalade.redirect"
 
 // ContentRequest parameters for GET /api/v1/content/{id}
 // Range header: "bytes=start-end"
@@ -47,29 +73,92 @@ type RollbackRequest struct {
 	Version int `json:"version"`
 }
 
-// ConflictResponse is returned when a write conflicts with the current state.
-type ConflictResponse struct {
-	Error           string `json:"error"`
+// RollbackResponse is returned by POST /api/v1/versions/{path}. The
+// restored content becomes NewVersion.
+type RollbackResponse struct {
 	Path            string `json:"path"`
-	ExpectedVersion int    `json:"expected_version"`
-	CurrentVersion  int    `json:"current_version"`
-	CurrentHash     string `json:"current_hash"`
+	RestoredVersion int    `json:"restored_version"`
+	NewVersion      int    `json:"new_version"`
+}
+
+// ConflictResponse is returned with 409 Conflict when an upload names a
+// version that is no longer current. Its error fields match ErrorResponse,
+// with ErrorCode ErrCodeVersionConflict. The rejected content is kept as
+// the sync conflict ConflictID, see SyncConflict.
+type ConflictResponse struct {
+	Error           string    `json:"error"`
+	Code            int       `json:"code"`
+	ErrorCode       ErrorCode `json:"error_code"`
+	RequestID       string    `json:"request_id,omitempty"`
+	Path            string    `json:"path"`
+	ExpectedVersion int       `json:"expected_version"`
+	CurrentVersion  int       `json:"current_version"`
+	CurrentHash     string    `json:"current_hash"`
+	ConflictID      int64     `json:"conflict_id,omitempty"`
+}
+
+// HeaderContentSHA256 carries the hex SHA-256 of an upload's body on
+// POST /api/v1/content/{path} and POST /api/v1/uploads/{id}/complete. The
+// server rejects the upload with 422 and a ChecksumMismatchResponse if the
+// bytes it received hash differently.
+const HeaderContentSHA256 = "X-Content-SHA256"
+
+// UploadCheckRequest is the body for POST /api/v1/upload-check.
+type UploadCheckRequest struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
 }
 
-// SSEEvent represents a server-sent event for real-time sync.
-type SSEEvent struct {
-	Type      string `json:"type"`
-	Path      string `json:"path"`
-	Version   int    `json:"version,omitempty"`
-	Hash      string `json:"hash,omitempty"`
-	Size      int64  `json:"size,omitempty"`
-	Timestamp int64  `json:"timestamp"`
+// Limits an upload check can report as the reason for refusing.
+const (
+	UploadLimitPermission = "permission"
+	UploadLimitSize       = "upload_size"
+	UploadLimitQuota      = "quota"
+)
+
+// UploadCheckResponse is returned by POST /api/v1/upload-check. It tells a
+// client whether an upload of the given size would be accepted before it
+// sends any content.
+type UploadCheckResponse struct {
+	Allowed        bool   `json:"allowed"`
+	Reason         string `json:"reason,omitempty"`
+	Limit          string `json:"limit,omitempty"` // one of the UploadLimit constants
+	MaxUploadSize  int64  `json:"max_upload_size"`
+	QuotaRemaining int64  `json:"quota_remaining"` // -1 = unlimited
+}
+
+// ChecksumMismatchResponse is returned with 422 Unprocessable Entity when
+// an upload does not match its declared X-Content-SHA256. Nothing is stored.
+type ChecksumMismatchResponse struct {
+	Error          string    `json:"error"`
+	Code           int       `json:"code"`
+	ErrorCode      ErrorCode `json:"error_code"`
+	Path           string    `json:"path"`
+	ExpectedSHA256 string    `json:"expected_sha256"`
+	ActualSHA256   string    `json:"actual_sha256"`
+	RequestID      string    `json:"request_id,omitempty"`
+}
+
+// UpgradeRequiredResponse is returned with 426 Upgrade Required when the
+// client's version is older than the server's configured minimum.
+type UpgradeRequiredResponse struct {
+	Error            string    `json:"error"`
+	Code             int       `json:"code"`
+	ErrorCode        ErrorCode `json:"error_code"`
+	ClientVersion    string    `json:"client_version"`
+	MinClientVersion string    `json:"min_client_version"`
+	ServerVersion    string    `json:"server_version"`
 }
 
 // PermissionRequest is the body for PUT /api/v1/permissions/{path}.
+// Recursive grants on a directory for its whole subtree with a single
+// row; Explicit instead writes a row for the directory and for each file
+// and directory below it.
 type PermissionRequest struct {
 	UserID     int    `json:"user_id"`
 	Permission string `json:"permission"` // "read", "write", "owner"
+	Recursive  bool   `json:"recursive,omitempty"`
+	Explicit   bool   `json:"explicit,omitempty"`
 }
 
 // PermissionResponse describes a single permission entry.
@@ -78,6 +167,23 @@ type PermissionResponse struct {
 	Username   string `json:"username,omitempty"`
 	Path       string `json:"path"`
 	Permission string `json:"permission"`
+	Recursive  bool   `json:"recursive,omitempty"`
+}
+
+// PermissionCopyRequest is the body for POST /api/v1/permissions/copy.
+type PermissionCopyRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PermissionCopyResponse is returned by POST /api/v1/permissions/copy.
+// GroupPermissions counts the copied group permissions, which only admins
+// copy.
+type PermissionCopyResponse struct {
+	From             string               `json:"from"`
+	To               string               `json:"to"`
+	Permissions      []PermissionResponse `json:"permissions"`
+	GroupPermissions int64                `json:"group_permissions"`
 }
 
 // PermissionListResponse is returned by GET /api/v1/permissions/{path}.
@@ -86,11 +192,32 @@ type PermissionListResponse struct {
 	Permissions []PermissionResponse `json:"permissions"`
 }
 
+// SharedWithMeEntry is an entry of GET /api/v1/shared-with-me.
+type SharedWithMeEntry struct {
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	IsDir      bool      `json:"is_dir"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Permission string    `json:"permission"`
+	GrantedBy  string    `json:"granted_by,omitempty"`
+	GrantedAt  time.Time `json:"granted_at"`
+	GroupID    int       `json:"group_id,omitempty"`
+	GroupName  string    `json:"group_name,omitempty"`
+}
+
 // ShareLinkRequest is the body for POST /api/v1/share/{path}.
 type ShareLinkRequest struct {
 	Password     string `json:"password,omitempty"`
 	ExpiresInSec int64  `json:"expires_in_sec,omitempty"` // 0 = no expiry
 	MaxDownloads int    `json:"max_downloads,omitempty"`  // 0 = unlimited
+
+	// Folder links only: accept uploads (a file drop). AllowDownload
+	// defaults to true; false makes the link upload-only.
+	AllowUpload    bool  `json:"allow_upload,omitempty"`
+	AllowDownload  *bool `json:"allow_download,omitempty"`
+	MaxUploadBytes int64 `json:"max_upload_bytes,omitempty"` // 0 = unlimited
+	MaxUploadFiles int   `json:"max_upload_files,omitempty"` // 0 = unlimited
 }
 
 // ShareLinkResponse is returned when creating a share link.
@@ -101,6 +228,26 @@ type ShareLinkResponse struct {
 	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
 	MaxDownloads int        `json:"max_downloads"`
 	CreatedAt    time.Time  `json:"created_at"`
+
+	AllowUpload    bool  `json:"allow_upload"`
+	AllowDownload  bool  `json:"allow_download"`
+	MaxUploadBytes int64 `json:"max_upload_bytes,omitempty"`
+	MaxUploadFiles int   `json:"max_upload_files,omitempty"`
+}
+
+// ShareLinkEntry is an entry of GET /api/v1/shares, the caller's active
+// share links.
+type ShareLinkEntry struct {
+	ID            string     `json:"id"`
+	Path          string     `json:"path"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads  int        `json:"max_downloads"`
+	DownloadCount int        `json:"download_count"`
+	IsActive      bool       `json:"is_active"`
+	CreatedAt     time.Time  `json:"created_at"`
+	AllowUpload   bool       `json:"allow_upload"`
+	AlbumID       *int       `json:"album_id,omitempty"`
+	AlbumName     string     `json:"album_name,omitempty"`
 }
 
 // ShareInfoResponse is returned by GET /api/v1/share/{token}/info.
@@ -111,6 +258,128 @@ type ShareInfoResponse struct {
 	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 	Valid       bool       `json:"valid"`
 	Error       string     `json:"error,omitempty"`
+
+	// Capabilities, for folder links that accept uploads
+	IsDir           bool   `json:"is_dir,omitempty"`
+	AllowUpload     bool   `json:"allow_upload"`
+	AllowDownload   bool   `json:"allow_download"`
+	UploadBytesLeft *int64 `json:"upload_bytes_left,omitempty"` // nil = unlimited
+	UploadFilesLeft *int   `json:"upload_files_left,omitempty"` // nil = unlimited
+
+	// Album links: FileName is the album's name
+	IsAlbum bool `json:"is_album,omitempty"`
+
+	// File links: the file's type, and whether GET
+	// /api/v1/share/{token}/preview has a thumbnail of it
+	MimeType         string `json:"mime_type,omitempty"`
+	PreviewAvailable bool   `json:"preview_available"`
+}
+
+// AlbumShareRequest is the body for POST /api/v1/gallery/albums/{id}/share.
+type AlbumShareRequest struct {
+	Password     string `json:"password,omitempty"`
+	ExpiresInSec int64  `json:"expires_in_sec,omitempty"` // 0 = no expiry
+	MaxViews     int    `json:"max_views,omitempty"`      // 0 = unlimited
+}
+
+// AlbumShareResponse is returned when creating an album share link.
+type AlbumShareResponse struct {
+	ID        string     `json:"id"`
+	AlbumID   int        `json:"album_id"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxViews  int        `json:"max_views"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SharedAlbumResponse is returned by GET /api/v1/gallery/shared-album/{token}.
+// Item paths address the thumb and content endpoints below it.
+type SharedAlbumResponse struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Owner       string            `json:"owner"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	Items       []SharedAlbumItem `json:"items"`
+}
+
+// SharedAlbumItem is a member of a shared album.
+type SharedAlbumItem struct {
+	Path         string     `json:"path"`
+	Name         string     `json:"name"`
+	Size         int64      `json:"size"`
+	MediaType    string     `json:"media_type,omitempty"`
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	DateTaken    *time.Time `json:"date_taken,omitempty"`
+	HasThumbnail bool       `json:"has_thumbnail"`
+}
+
+// ShareUploadResponse is returned by POST /api/v1/share/{token}/upload/{filename}.
+// Name differs from the requested file name if that was already taken.
+type ShareUploadResponse struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// ShareFileEntry is an entry of GET /api/v1/share/{token}/files.
+type ShareFileEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	IsDir   bool      `json:"is_dir"`
+}
+
+// UserFootprintResponse is returned by GET
+// /api/v1/admin/users/{userID}/footprint: what deleting the user affects.
+// Files and Bytes count the live files the user owns, PrivateFiles and
+// PrivateBytes those of them that orphan_action=trash moves to the trash.
+type UserFootprintResponse struct {
+	UserID       int      `json:"user_id"`
+	Username     string   `json:"username"`
+	Files        int      `json:"files"`
+	Bytes        int64    `json:"bytes"`
+	PrivateFiles int      `json:"private_files"`
+	PrivateBytes int64    `json:"private_bytes"`
+	TrashedFiles int      `json:"trashed_files"` // in the trash, owned or deleted by the user
+	ShareLinks   int      `json:"share_links"`
+	Favorites    int      `json:"favorites"`
+	Albums       int      `json:"albums"`
+	GroupAdmin   []string `json:"group_admin"` // groups the user has the admin role in
+}
+
+// What becomes of a deleted user's files without a transfer_to.
+const (
+	// OrphanTrash moves the user's private files to the trash, attributed
+	// to the deleting admin. The other files stay, without an owner.
+	OrphanTrash = "trash"
+
+	// OrphanKeepPublic keeps all of the user's files without an owner and
+	// makes the private ones public.
+	OrphanKeepPublic = "keep-public"
+)
+
+// UserDeleteRequest holds the options of DELETE
+// /api/v1/admin/users/{userID}, given as query parameters or as the
+// body. Exactly one is required: TransferTo hands the user's files, share
+// links, favorites and albums to another user;
+// OrphanAction (OrphanTrash or OrphanKeepPublic) leaves the files without
+// an owner, and the rest is deleted with the user.
+type UserDeleteRequest struct {
+	TransferTo   int    `json:"transfer_to,omitempty"`
+	OrphanAction string `json:"orphan_action,omitempty"`
+}
+
+// UserDeleteResponse is returned by DELETE /api/v1/admin/users/{userID}.
+// Files counts the files given to TransferTo, Trashed and Orphaned those
+// moved to the trash or left without an owner.
+type UserDeleteResponse struct {
+	UserID       int    `json:"user_id"`
+	Deleted      bool   `json:"deleted"`
+	TransferTo   int    `json:"transfer_to,omitempty"`
+	OrphanAction string `json:"orphan_action,omitempty"`
+	Files        int    `json:"files"`
+	Trashed      int    `json:"trashed"`
+	Orphaned     int    `json:"orphaned"`
 }
 
 // UserQuotaResponse describes a user's quota settings.
@@ -120,24 +389,59 @@ type UserQuotaResponse struct {
 	MaxBandwidthPerDay  int64 `json:"max_bandwidth_per_day"`
 	MaxRequestsPerMin   int   `json:"max_requests_per_minute"`
 	MaxUploadSizeBytes  int64 `json:"max_upload_size_bytes"`
+
+	// TrashRetentionDays is the user's own trash retention, if the server
+	// setting is overridden for them (0 = never purge).
+	TrashRetentionDays *int `json:"trash_retention_days,omitempty"`
 }
 
-// SetQuotaRequest is the body for PUT /api/v1/admin/quotas/{userID}.
+// SetQuotaRequest is the body for PUT /api/v1/admin/quotas/{userID}. A
+// negative TrashRetentionDays drops the user's override.
 type SetQuotaRequest struct {
 	MaxStorageBytes     *int64 `json:"max_storage_bytes,omitempty"`
 	MaxBandwidthPerDay  *int64 `json:"max_bandwidth_per_day,omitempty"`
 	MaxRequestsPerMin   *int   `json:"max_requests_per_minute,omitempty"`
 	MaxUploadSizeBytes  *int64 `json:"max_upload_size_bytes,omitempty"`
+	TrashRetentionDays  *int   `json:"trash_retention_days,omitempty"`
 }
 
-// UsageResponse describes a user's current resource usage.
+// UsageResponse describes a user's current resource usage. StorageUsed is
+// what counts toward the storage quota: ContentBytes, plus VersionsBytes
+// and ThumbsBytes unless the server excludes them.
 type UsageResponse struct {
 	UserID          int   `json:"user_id"`
 	StorageUsed     int64 `json:"storage_used"`
+	ContentBytes    int64 `json:"content_bytes"`
+	VersionsBytes   int64 `json:"versions_bytes"`
+	ThumbsBytes     int64 `json:"thumbs_bytes"`
 	BandwidthToday  int64 `json:"bandwidth_today"`
 	Quota           UserQuotaResponse `json:"quota"`
 }
 
+// Identity names a user.
+type Identity struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// ImpersonateResponse is returned by POST /api/v1/admin/impersonate/{userID}.
+type ImpersonateResponse struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	User         Identity  `json:"user"`
+	Impersonator Identity  `json:"impersonator"`
+}
+
+// WhoAmIResponse is returned by GET /api/v1/auth/whoami. Impersonator is
+// set when the token was issued by an admin acting as the user.
+type WhoAmIResponse struct {
+	UserID       int        `json:"user_id"`
+	Username     string     `json:"username"`
+	IsAdmin      bool       `json:"is_admin"`
+	Impersonator *Identity  `json:"impersonator,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
 // GroupRequest is the body for POST /api/v1/admin/groups.
 type GroupRequest struct {
 	Name        string `json:"name"`
@@ -152,8 +456,10 @@ type GroupMemberRequest struct {
 }
 
 // SetVisibilityRequest is the body for PUT /api/v1/visibility/{path}.
+// With Recursive, everything below a directory is reset to inherit it.
 type SetVisibilityRequest struct {
 	Visibility string `json:"visibility"` // "public"|"group"|"private"
+	Recursive  bool   `json:"recursive,omitempty"`
 }
 
 // GroupTreeNode represents a group in a nested tree.
@@ -180,6 +486,23 @@ type GroupPermissionRequest struct {
 	Permission string `json:"permission"`
 }
 
+// ProvisionItem is a directory or home permission checked by a provisioning repair.
+type ProvisionItem struct {
+	Type       string `json:"type"` // "directory" or "permission"
+	Path       string `json:"path"`
+	UserID     int    `json:"user_id,omitempty"`
+	Permission string `json:"permission,omitempty"`
+}
+
+// ProvisionResponse is returned by POST /api/v1/admin/groups/{id}/provision.
+type ProvisionResponse struct {
+	GroupID   int             `json:"group_id"`
+	GroupName string          `json:"group_name"`
+	Created   []ProvisionItem `json:"created"`
+	Present   []ProvisionItem `json:"present"`
+	Errors    []string        `json:"errors,omitempty"`
+}
+
 // FilePropertiesResponse is returned by GET /api/v1/properties/{path}.
 type FilePropertiesResponse struct {
 	// Core metadata
@@ -192,6 +515,11 @@ type FilePropertiesResponse struct {
 	Hash     string    `json:"hash,omitempty"`
 	Version  int       `json:"version"`
 
+	// Directories: total size of the files below and number of items
+	// below, counting only what the caller can read
+	AggSize   int64 `json:"agg_size,omitempty"`
+	ItemCount int   `json:"item_count,omitempty"`
+
 	// Ownership
 	OwnerID   int    `json:"owner_id,omitempty"`
 	OwnerName string `json:"owner_name,omitempty"`
@@ -211,6 +539,120 @@ type FilePropertiesResponse struct {
 
 	// Versions
 	VersionCount int `json:"version_count"`
+
+	// Comments
+	CommentCount int `json:"comment_count"`
+
+	// Set when the integrity scrubber found the stored content damaged
+	IntegrityIssue *IntegrityIssueInfo `json:"integrity_issue,omitempty"`
+}
+
+// IntegrityIssueInfo describes an unrepaired integrity issue on a file.
+type IntegrityIssueInfo struct {
+	DetectedAt time.Time `json:"detected_at"`
+	ActualHash string    `json:"actual_hash,omitempty"` // empty when the content could not be read
+	Error      string    `json:"error,omitempty"`
+}
+
+// CommentResponse is a file comment, returned by the comments endpoints.
+type CommentResponse struct {
+	ID         int64     `json:"id"`
+	Path       string    `json:"path"`
+	AuthorID   int       `json:"author_id,omitempty"` // 0 once the author is deleted
+	AuthorName string    `json:"author_name"`
+	Body       string    `json:"body"`
+	ParentID   int64     `json:"parent_id,omitempty"` // set for replies
+	Resolved   bool      `json:"resolved"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CreateCommentRequest is the body for POST /api/v1/comments/{path}.
+type CreateCommentRequest struct {
+	Body     string `json:"body"`
+	ParentID int64  `json:"parent_id,omitempty"` // reply to a top-level comment
+}
+
+// UpdateCommentRequest is the body for PATCH /api/v1/comments/{id}. Only
+// the author can change the body; anyone who can read the file can
+// resolve or reopen a comment.
+type UpdateCommentRequest struct {
+	Body     *string `json:"body,omitempty"`
+	Resolved *bool   `json:"resolved,omitempty"`
+}
+
+// Sync conflict sources, reported in SyncConflict.Source.
+const (
+	ConflictSourceUpload = "upload" // an upload rejected with 409
+	ConflictSourceClient = "client" // reported with POST /api/v1/conflicts
+)
+
+// Resolutions of a sync conflict, for POST /api/v1/conflicts/{id}/resolve.
+const (
+	ResolveKeepMine   = "keep-mine"   // mine becomes the file's new version
+	ResolveKeepTheirs = "keep-theirs" // mine is discarded
+	ResolveKeepBoth   = "keep-both"   // mine is saved as a sibling conflict copy
+)
+
+// ConflictSide describes one side of a sync conflict.
+type ConflictSide struct {
+	Version int       `json:"version,omitempty"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// SyncConflict is content of a user's that lost against a newer server
+// version of the same file. Mine is the losing content, kept until the
+// conflict is resolved; Theirs is the file as it is now on the server, nil
+// if it no longer exists. ServerVersion is the version mine lost to.
+type SyncConflict struct {
+	ID            int64         `json:"id"`
+	Path          string        `json:"path"`
+	Source        string        `json:"source"`
+	Device        string        `json:"device,omitempty"`
+	ServerVersion int           `json:"server_version"`
+	Mine          ConflictSide  `json:"mine"`
+	Theirs        *ConflictSide `json:"theirs,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Resolution    string        `json:"resolution,omitempty"`
+	ResolvedAt    *time.Time    `json:"resolved_at,omitempty"`
+	CopyPath      string        `json:"copy_path,omitempty"` // the sibling written by keep-both
+}
+
+// ResolveConflictRequest is the body for POST /api/v1/conflicts/{id}/resolve.
+type ResolveConflictRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+// Fallbacks of an auto-organize rule for files without a date taken.
+const (
+	OrganizeFallbackKeep    = "keep"     // leave the file where it is
+	OrganizeFallbackModTime = "mod_time" // sort by modification time
+)
+
+// OrganizeRuleRequest is the body for PUT /api/v1/organize/{path}. Pattern
+// is a relative path made of {year}, {month} and {day} placeholders and
+// literal names, e.g. "{year}/{month}". Fallback defaults to "keep".
+type OrganizeRuleRequest struct {
+	Pattern  string `json:"pattern"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// OrganizeRuleResponse is the auto-organize rule of a directory.
+type OrganizeRuleResponse struct {
+	Path      string    `json:"path"`
+	Pattern   string    `json:"pattern"`
+	Fallback  string    `json:"fallback"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrganizeApplyResponse is returned by POST /api/v1/organize/{path}/apply.
+type OrganizeApplyResponse struct {
+	Moved   int      `json:"moved"`
+	Skipped int      `json:"skipped"` // no date, or already in place
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
 }
 
 // BandwidthHistoryPoint is a single day's bandwidth usage.
@@ -231,6 +673,12 @@ type UserDashboardResponse struct {
 	FileCount         int                     `json:"file_count"`
 	ShareLinkCount    int                     `json:"share_link_count"`
 	BandwidthHistory  []BandwidthHistoryPoint `json:"bandwidth_history,omitempty"`
+
+	// TrashBytes is the size of the files the user deleted that are still
+	// in the trash; TrashWarning is set when it exceeds the server's
+	// trash_warn_size.
+	TrashBytes   int64 `json:"trash_bytes"`
+	TrashWarning bool  `json:"trash_warning,omitempty"`
 }
 
 // UserGroupInfo is a group membership entry for the user dashboard.
@@ -314,7 +762,28 @@ type GalleryItem struct {
 	LocationCity string     `json:"location_city,omitempty"`
 	Country      string     `json:"location_country,omitempty"`
 	HasThumbnail bool       `json:"has_thumbnail"`
+	MediaType    string     `json:"media_type,omitempty"`
+	Duration     float64    `json:"duration,omitempty"`
 	Tags         []string   `json:"tags,omitempty"`
+	StackID      *int       `json:"stack_id,omitempty"`    // burst stack the photo is in
+	StackCount   int        `json:"stack_count,omitempty"` // with collapse_stacks: matching photos in the stack
+}
+
+// GalleryStackResponse is returned by GET /api/v1/gallery/stack/{id}.
+type GalleryStackResponse struct {
+	StackID int           `json:"stack_id"`
+	Items   []GalleryItem `json:"items"`
+}
+
+// UnstackRequest is the body for POST /api/v1/gallery/stack/{id}/unstack.
+type UnstackRequest struct {
+	Path string `json:"path"`
+}
+
+// RestackResponse is returned by POST /api/v1/admin/gallery/restack.
+type RestackResponse struct {
+	Photos int `json:"photos"` // photos in a stack
+	Stacks int `json:"stacks"`
 }
 
 // GalleryMetadataResponse is returned by GET /api/v1/gallery/metadata/{path}.
@@ -322,6 +791,8 @@ type GalleryMetadataResponse struct {
 	FilePath        string     `json:"file_path"`
 	FileName        string     `json:"file_name"`
 	Size            int64      `json:"size"`
+	MediaType       string     `json:"media_type,omitempty"`
+	Duration        float64    `json:"duration,omitempty"`
 	Width           int        `json:"width,omitempty"`
 	Height          int        `json:"height,omitempty"`
 	CameraMake      string     `json:"camera_make,omitempty"`
@@ -397,6 +868,7 @@ type TagCountResponse struct {
 // GalleryStatsResponse is returned by GET /api/v1/gallery/stats.
 type GalleryStatsResponse struct {
 	TotalImages int `json:"total_images"`
+	Videos      int `json:"videos"`
 	WithGPS     int `json:"with_gps"`
 	WithTags    int `json:"with_tags"`
 	Processed   int `json:"processed"`
@@ -418,6 +890,47 @@ type TagRequest struct {
 	Tag string `json:"tag"`
 }
 
+// GalleryDuplicatesResponse is returned by GET /api/v1/gallery/duplicates.
+type GalleryDuplicatesResponse struct {
+	Groups           []DuplicateGroup `json:"groups"`
+	TotalGroups      int              `json:"total_groups"`
+	TotalWastedBytes int64            `json:"total_wasted_bytes"`
+	MaxDistance      int              `json:"max_distance"`
+}
+
+// DuplicateGroup is a set of perceptually similar images. Keep is the
+// suggested copy to retain; the others add up to WastedBytes.
+type DuplicateGroup struct {
+	Keep        string           `json:"keep"`
+	WastedBytes int64            `json:"wasted_bytes"`
+	Items       []DuplicateImage `json:"items"`
+}
+
+// DuplicateImage is one copy within a DuplicateGroup.
+type DuplicateImage struct {
+	FilePath     string     `json:"file_path"`
+	FileName     string     `json:"file_name"`
+	Size         int64      `json:"size"`
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	DateTaken    *time.Time `json:"date_taken,omitempty"`
+	HasThumbnail bool       `json:"has_thumbnail"`
+	Distance     int        `json:"distance"` // Hamming distance from the kept copy
+}
+
+// ResolveDuplicatesRequest is the body for POST /api/v1/gallery/duplicates/resolve.
+type ResolveDuplicatesRequest struct {
+	Trash       []string `json:"trash"`
+	MaxDistance *int     `json:"max_distance,omitempty"`
+}
+
+// ResolveDuplicatesResponse reports which paths were moved to trash.
+type ResolveDuplicatesResponse struct {
+	Trashed    []string          `json:"trashed"`
+	Failed     map[string]string `json:"failed,omitempty"`
+	FreedBytes int64             `json:"freed_bytes"`
+}
+
 // ─── Trash Types ────────────────────────────────────────────────────────────
 
 // TrashItem represents a soft-deleted file in the trash.
@@ -429,13 +942,29 @@ type TrashItem struct {
 	IsDir         bool      `json:"is_dir"`
 	DeletedAt     time.Time `json:"deleted_at"`
 	DeletedByName string    `json:"deleted_by_name,omitempty"`
+
+	// PurgeAt is when the item will be purged for good, unset if the
+	// retention that applies to it never purges.
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
 }
 
+// HeaderTrashWarning is set on GET /api/v1/trash when the caller's trash
+// holds more than the server's trash_warn_size. Its value is the size of
+// the trash in bytes.
+const HeaderTrashWarning = "X-Trash-Warning"
+
 // TrashRestoreRequest is the body for POST /api/v1/trash/restore.
 type TrashRestoreRequest struct {
 	Path string `json:"path"`
 }
 
+// TrashPurgeResponse is returned by DELETE /api/v1/trash/{path} and, without
+// a path, by DELETE /api/v1/trash. Purged counts the removed entries.
+type TrashPurgeResponse struct {
+	Path   string `json:"path,omitempty"`
+	Purged int    `json:"purged"`
+}
+
 // ─── Favorites Types ────────────────────────────────────────────────────────
 
 // FavoriteItem represents a user's bookmarked file.
@@ -445,6 +974,13 @@ type FavoriteItem struct {
 	Size     int64     `json:"size"`
 	IsDir    bool      `json:"is_dir"`
 	ModTime  time.Time `json:"mod_time,omitempty"`
+	Missing  bool      `json:"missing,omitempty"` // the file no longer exists
+	Trashed  bool      `json:"trashed,omitempty"` // the file is in the trash
+}
+
+// RemoveMissingFavoritesResponse is returned by DELETE /api/v1/favorites/missing.
+type RemoveMissingFavoritesResponse struct {
+	Removed int64 `json:"removed"`
 }
 
 // ─── Search Types ───────────────────────────────────────────────────────────
@@ -458,20 +994,133 @@ type SearchResult struct {
 	IsDir   bool      `json:"is_dir"`
 	ModTime time.Time `json:"mod_time"`
 	Tags    []string  `json:"tags,omitempty"`
+	Snippet string    `json:"snippet,omitempty"` // HTML-escaped match with <mark> highlights (content search only)
+	Match   string    `json:"match,omitempty"`   // what matched: "name", "path", "tag" or "content"
+}
+
+// SearchResponse is returned by GET /api/v1/search.
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	Limit   int            `json:"limit"`
+	HasMore bool           `json:"has_more"`
+}
+
+// ─── Move Types ─────────────────────────────────────────────────────────────
+
+// MoveRequest is the body for POST /api/v1/move. With Overwrite a file
+// may replace an existing file at To, which then becomes the moved file's
+// next version, and a directory an empty directory.
+type MoveRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// MoveResponse is returned by POST /api/v1/move. ID is the moved item's
+// ID at To and Version the moved file's version there; Replaced is set if
+// it overwrote something.
+type MoveResponse struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	ID       string `json:"id"`
+	IsDir    bool   `json:"is_dir"`
+	Version  int    `json:"version,omitempty"`
+	Replaced bool   `json:"replaced,omitempty"`
+}
+
+// ─── Copy Types ─────────────────────────────────────────────────────────────
+
+// CopyRequest is the body for POST /api/v1/copy. Recursive is required to
+// copy a directory.
+type CopyRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+// CopyResponse is returned by POST /api/v1/copy. Files that could not be
+// copied are listed in Errors; the rest of the copy goes ahead.
+type CopyResponse struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Files  int      `json:"files"`
+	Dirs   int      `json:"dirs"`
+	Bytes  int64    `json:"bytes"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ─── Alias Types ────────────────────────────────────────────────────────────
+
+// AliasRequest is the body for POST /api/v1/alias. Link becomes a second
+// path of the file or directory at Target; the response is the link's
+// node.
+type AliasRequest struct {
+	Target string `json:"target"`
+	Link   string `json:"link"`
 }
 
 // ─── Bulk Operation Types ───────────────────────────────────────────────────
 
-// BulkMoveRequest is the body for POST /api/v1/bulk/move.
+// BulkMoveRequest is the body for POST /api/v1/bulk/move. OnConflict
+// decides about items whose name is taken in Destination: one of the
+// OnConflict constants, or empty to fail them. With Atomic every item must
+// pass its checks and all run in one transaction, or none does.
 type BulkMoveRequest struct {
 	Paths       []string `json:"paths"`
 	Destination string   `json:"destination"`
+	OnConflict  string   `json:"on_conflict,omitempty"`
+	Atomic      bool     `json:"atomic,omitempty"`
 }
 
-// BulkCopyRequest is the body for POST /api/v1/bulk/copy.
+// BulkCopyRequest is the body for POST /api/v1/bulk/copy, with the fields
+// of a BulkMoveRequest.
 type BulkCopyRequest struct {
 	Paths       []string `json:"paths"`
 	Destination string   `json:"destination"`
+	OnConflict  string   `json:"on_conflict,omitempty"`
+	Atomic      bool     `json:"atomic,omitempty"`
+}
+
+// Conflict policies of a bulk move or copy.
+const (
+	OnConflictSkip      = "skip"      // leave the item where it is
+	OnConflictRename    = "rename"    // use "name (n).ext" instead
+	OnConflictOverwrite = "overwrite" // replace a file or an empty directory
+)
+
+// Statuses of a BulkItemResult.
+const (
+	BulkStatusMoved   = "moved"
+	BulkStatusCopied  = "copied"
+	BulkStatusSkipped = "skipped"
+	BulkStatusFailed  = "failed"
+	BulkStatusAborted = "aborted" // not run because an atomic request failed
+)
+
+// BulkItemResult is the outcome of a bulk move or copy for one path. To is
+// the item's final path, set unless it failed or was skipped; Code and
+// Error say why it failed or was skipped.
+type BulkItemResult struct {
+	From     string    `json:"from"`
+	To       string    `json:"to,omitempty"`
+	Status   string    `json:"status"`
+	Renamed  bool      `json:"renamed,omitempty"`
+	Replaced bool      `json:"replaced,omitempty"`
+	Code     ErrorCode `json:"code,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// BulkMoveResponse is the response for POST /api/v1/bulk/move and
+// /api/v1/bulk/copy, with a result per path in request order. Failed
+// counts aborted items too. RolledBack is set when an atomic request
+// failed while running and nothing was changed.
+type BulkMoveResponse struct {
+	BulkResponse
+	Skipped    int              `json:"skipped"`
+	RolledBack bool             `json:"rolled_back,omitempty"`
+	Results    []BulkItemResult `json:"results"`
 }
 
 // BulkShareRequest is the body for POST /api/v1/bulk/share.
@@ -482,10 +1131,31 @@ type BulkShareRequest struct {
 	MaxDownloads int      `json:"max_downloads,omitempty"`
 }
 
-// BulkTagRequest is the body for POST /api/v1/bulk/tag.
+// BulkTagRequest is the body for POST /api/v1/bulk/tag. Action is "add"
+// (the default) or "remove".
 type BulkTagRequest struct {
-	Paths []string `json:"paths"`
-	Tags  []string `json:"tags"`
+	Paths  []string `json:"paths"`
+	Tags   []string `json:"tags"`
+	Action string   `json:"action,omitempty"`
+}
+
+// BulkTagResult is the outcome of a bulk tag request for one path. Queued
+// is set when the file had no gallery metadata yet and was queued for
+// processing.
+type BulkTagResult struct {
+	Path   string `json:"path"`
+	Queued bool   `json:"queued,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkTagResponse is the response for POST /api/v1/bulk/tag. Succeeded
+// counts paths; Tagged those tagged right away and Queued those tagged
+// while waiting for gallery processing.
+type BulkTagResponse struct {
+	BulkResponse
+	Tagged  int             `json:"tagged"`
+	Queued  int             `json:"queued"`
+	Results []BulkTagResult `json:"results"`
 }
 
 // BulkAlbumAddRequest is the body for POST /api/v1/bulk/album-add.
@@ -501,6 +1171,17 @@ type BulkResponse struct {
 	Errors    []string `json:"errors,omitempty"`
 }
 
+// GalleryRetryRequest is the body for POST /api/v1/admin/gallery/failures/retry.
+type GalleryRetryRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// GalleryRetryResponse reports how many of the requested files were failed
+// or retrying and have been requeued.
+type GalleryRetryResponse struct {
+	Requeued int `json:"requeued"`
+}
+
 // AlbumRequest is the body for POST/PUT /api/v1/gallery/albums.
 type AlbumRequest struct {
 	Name        string `json:"name"`
@@ -552,3 +1233,38 @@ type PluginResponse struct {
 	CreatedAt  time.Time              `json:"created_at"`
 	UpdatedAt  time.Time              `json:"updated_at"`
 }
+
+// WebhookRequest is the body for POST/PUT /api/v1/admin/webhooks. An empty
+// secret generates one on create and keeps the current one on update;
+// empty event types subscribe to all events.
+type WebhookRequest struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
+	EventTypes []string `json:"event_types,omitempty"`
+	PathPrefix string   `json:"path_prefix,omitempty"`
+	Enabled    *bool    `json:"enabled,omitempty"` // default true
+}
+
+// WebhookResponse is returned by the webhook admin endpoints. Secret is
+// only included when the webhook is created or its secret is changed.
+type WebhookResponse struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	PathPrefix string    `json:"path_prefix,omitempty"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// WebhookTestResponse is returned by POST /api/v1/admin/webhooks/{id}/test.
+type WebhookTestResponse struct {
+	Success    bool   `json:"success"`
+	DeliveryID int64  `json:"delivery_id"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+}
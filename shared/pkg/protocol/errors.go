@@ -0,0 +1,87 @@
+package protocol
+
+import "net/http"
+
+// ErrorCode is the machine-readable kind of an API error, sent as
+// ErrorResponse.ErrorCode. New codes may be added; clients should treat an
+// unknown code like the one ErrorCodeForStatus gives for the HTTP status.
+type ErrorCode string
+
+const (
+	// Generic codes, one per HTTP status; see ErrorCodeForStatus.
+	ErrCodeBadRequest         ErrorCode = "bad_request"
+	ErrCodeUnauthorized       ErrorCode = "unauthorized"
+	ErrCodeAccessDenied       ErrorCode = "access_denied"
+	ErrCodeNotFound           ErrorCode = "not_found"
+	ErrCodeConflict           ErrorCode = "conflict"
+	ErrCodePreconditionFailed ErrorCode = "precondition_failed"
+	ErrCodeFileTooLarge       ErrorCode = "file_too_large"
+	ErrCodeUpgradeRequired    ErrorCode = "upgrade_required"
+	ErrCodeRateLimited        ErrorCode = "rate_limited"
+	ErrCodeInternal           ErrorCode = "internal"
+	ErrCodeUnavailable        ErrorCode = "unavailable"
+
+	// ErrCodeInvalidPath is the 400 of a path validation failure; Details
+	// is DetailInvalidPath as well.
+	ErrCodeInvalidPath ErrorCode = "invalid_path"
+
+	// ErrCodePasswordChangeRequired is the 403 of a user who must change
+	// their password; Details is DetailPasswordChangeRequired as well.
+	ErrCodePasswordChangeRequired ErrorCode = "password_change_required"
+
+	// ErrCodeReadOnly is the 403 of a write to a read-only storage location.
+	ErrCodeReadOnly ErrorCode = "read_only"
+
+	// ErrCodeQuotaExceeded is the 413 of an upload or copy that does not
+	// fit in the user's storage quota.
+	ErrCodeQuotaExceeded ErrorCode = "quota_exceeded"
+
+	// ErrCodeBandwidthExceeded is the 429 of a download once the user's
+	// daily bandwidth quota is used up.
+	ErrCodeBandwidthExceeded ErrorCode = "bandwidth_exceeded"
+
+	// ErrCodeVersionConflict is the 409 of an upload whose expected version
+	// or If-Match is no longer current; the body is a ConflictResponse.
+	ErrCodeVersionConflict ErrorCode = "version_conflict"
+
+	// ErrCodeChecksumMismatch is the 422 of an upload that does not match
+	// its X-Content-SHA256; the body is a ChecksumMismatchResponse.
+	ErrCodeChecksumMismatch ErrorCode = "checksum_mismatch"
+
+	// ErrCodeStorageUnavailable is the 503 of a request whose storage
+	// location failed its health check. Retry-After says when to try again.
+	ErrCodeStorageUnavailable ErrorCode = "storage_unavailable"
+
+	// ErrCodeMaintenance is the 503 of a write while the server is in
+	// read-only maintenance mode; Error is the admin's message.
+	ErrCodeMaintenance ErrorCode = "maintenance"
+)
+
+// ErrorCodeForStatus returns the generic code of an HTTP error status,
+// used where a handler has nothing more specific to say.
+func ErrorCodeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeAccessDenied
+	case http.StatusNotFound, http.StatusGone:
+		return ErrCodeNotFound
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusPreconditionFailed:
+		return ErrCodePreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return ErrCodeFileTooLarge
+	case http.StatusUpgradeRequired:
+		return ErrCodeUpgradeRequired
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	}
+	if status >= 500 {
+		return ErrCodeInternal
+	}
+	return ErrCodeBadRequest
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestErrorCodeForStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   ErrorCode
+	}{
+		{http.StatusBadRequest, ErrCodeBadRequest},
+		{http.StatusUnprocessableEntity, ErrCodeBadRequest},
+		{http.StatusUnauthorized, ErrCodeUnauthorized},
+		{http.StatusForbidden, ErrCodeAccessDenied},
+		{http.StatusNotFound, ErrCodeNotFound},
+		{http.StatusGone, ErrCodeNotFound},
+		{http.StatusConflict, ErrCodeConflict},
+		{http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge},
+		{http.StatusTooManyRequests, ErrCodeRateLimited},
+		{http.StatusInternalServerError, ErrCodeInternal},
+		{http.StatusBadGateway, ErrCodeInternal},
+		{http.StatusServiceUnavailable, ErrCodeUnavailable},
+	}
+	for _, tt := range tests {
+		if got := ErrorCodeForStatus(tt.status); got != tt.want {
+			t.Errorf("ErrorCodeForStatus(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
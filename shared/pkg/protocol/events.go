@@ -0,0 +1,362 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EventSchemaVersion is the version of the SSE event payloads produced by
+// this build. It is announced in the stream's opening comment and carried
+// in every event as "schema".
+//
+// Adding event types or optional fields does not change the version;
+// parsers ignore what they don't know. The version is only bumped when an
+// existing field changes meaning or is removed. Payloads without a "schema"
+// field predate versioning and are schema 1.
+const EventSchemaVersion = 1
+
+// EventStreamPreamble is the SSE comment sent when a stream opens.
+var EventStreamPreamble = fmt.Sprintf(": fruitsalade-events schema=%d", EventSchemaVersion)
+
+// SSE event types. The SSE "event:" name always equals Event.Type.
+const (
+	EventCreate     = "create"
+	EventModify     = "modify"
+	EventDelete     = "delete"
+	EventVersion    = "version"
+	EventDirChanged = "dir_changed"
+	EventJob        = "job"
+	EventNotice     = "notice"
+	EventShutdown   = "server-shutdown"
+
+	EventPermissionGranted = "permission-granted"
+	EventComment           = "comment"
+	EventStorageHealth     = "storage-health"
+	EventMaintenance       = "maintenance"
+	EventConflict          = "conflict"
+	EventTrashPurged       = "trash-purged"
+
+	// EventResyncRequired tells a resuming client that events it missed
+	// are no longer buffered and it must refetch its metadata.
+	EventResyncRequired = "resync-required"
+
+	// EventReauth is the last event of a stream that ends because its
+	// token is about to expire or its session was revoked. The client
+	// refreshes its token (or logs in again) and reconnects.
+	EventReauth = "reauth"
+)
+
+// knownEventTypes lists the types this build understands.
+var knownEventTypes = map[string]bool{
+	EventCreate:     true,
+	EventModify:     true,
+	EventDelete:     true,
+	EventVersion:    true,
+	EventDirChanged: true,
+	EventJob:        true,
+	EventNotice:     true,
+	EventShutdown:   true,
+
+	EventPermissionGranted: true,
+	EventComment:           true,
+	EventStorageHealth:     true,
+	EventMaintenance:       true,
+	EventConflict:          true,
+	EventTrashPurged:       true,
+	EventResyncRequired:    true,
+	EventReauth:            true,
+}
+
+// ErrUnknownEventType is returned (wrapped) by ParseEvent for event types
+// newer than this build. The event is still returned so callers can log it.
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// Event is the payload of a server-sent event.
+//
+// The top-level fields are the schema 1 file-event shape that every client
+// understands. Type-specific data for newer event types lives in its own
+// optional object (Dir, Job, Notice, Grant, Comment, Storage, Maintenance,
+// Conflict, Trash, Reauth) so older parsers can skip it.
+type Event struct {
+	// ID increases with every event an instance publishes and is also sent
+	// as the SSE "id:" line; a reconnecting client passes the last one it
+	// saw as Last-Event-ID to replay what it missed. 0 means none.
+	ID        uint64 `json:"id,omitempty"`
+	Schema    int    `json:"schema,omitempty"`
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	Version   int    `json:"version,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	UserID    int    `json:"user_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+
+	Dir     *DirChangedPayload    `json:"dir,omitempty"`
+	Job     *JobPayload           `json:"job,omitempty"`
+	Notice  *NoticePayload        `json:"notice,omitempty"`
+	Grant   *GrantPayload         `json:"grant,omitempty"`
+	Comment *CommentPayload       `json:"comment,omitempty"`
+	Storage *StorageHealthPayload `json:"storage,omitempty"`
+
+	Maintenance *MaintenanceStatus  `json:"maintenance,omitempty"`
+	Conflict    *ConflictPayload    `json:"conflict,omitempty"`
+	Trash       *TrashPurgedPayload `json:"trash,omitempty"`
+	Reauth      *ReauthPayload      `json:"reauth,omitempty"`
+
+	// ForUserID limits delivery to one user; 0 sends the event to everyone.
+	// AdminOnly limits it to admins. Neither is serialized.
+	ForUserID int  `json:"-"`
+	AdminOnly bool `json:"-"`
+
+	// Extra holds fields this build does not know about, keyed by JSON name.
+	// It is filled by ParseEvent and not re-serialized.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// SSEEvent is the former name of Event.
+//
+// Deprecated: use Event.
+type SSEEvent = Event
+
+// DirChangedPayload describes several changes inside one directory that
+// were coalesced into a single event. Event.Path is the directory.
+type DirChangedPayload struct {
+	Changes int      `json:"changes"`
+	Names   []string `json:"names,omitempty"` // affected child names, may be truncated
+}
+
+// JobPayload reports progress of a long-running server job (reprocessing,
+// imports, scrubs). Event.Path is the path the job operates on, if any.
+type JobPayload struct {
+	ID       string  `json:"id"`
+	Kind     string  `json:"kind"`
+	State    string  `json:"state"`              // "running", "done", "failed"
+	Progress float64 `json:"progress,omitempty"` // 0..1
+	Message  string  `json:"message,omitempty"`
+}
+
+// NoticePayload is a human-readable message for connected users.
+type NoticePayload struct {
+	Level   string `json:"level"` // "info", "warning", "error"
+	Message string `json:"message"`
+}
+
+// GrantPayload tells a user they were given a permission on Event.Path.
+// Event.UserID and Username are the granting user.
+type GrantPayload struct {
+	Permission string `json:"permission"`         // "read", "write", "owner"
+	GroupID    int    `json:"group_id,omitempty"` // set for grants to a group
+	GroupName  string `json:"group_name,omitempty"`
+}
+
+// Comment actions reported in CommentPayload.Action.
+const (
+	CommentAdded    = "added"
+	CommentEdited   = "edited"
+	CommentResolved = "resolved"
+	CommentReopened = "reopened"
+	CommentDeleted  = "deleted"
+)
+
+// CommentPayload tells viewers of Event.Path that its comments changed.
+// Event.UserID and Username are the user who made the change. The comment
+// text is not included; clients refetch the comments they show.
+type CommentPayload struct {
+	ID       int64  `json:"id"`
+	ParentID int64  `json:"parent_id,omitempty"`
+	Action   string `json:"action"`
+}
+
+// StorageHealthPayload tells admins that a storage location went down or
+// came back. It is only sent to admins.
+type StorageHealthPayload struct {
+	LocationID int    `json:"location_id"`
+	Name       string `json:"name"`
+	Healthy    bool   `json:"healthy"`
+	Error      string `json:"error,omitempty"`
+	ReplicaID  int    `json:"replica_id,omitempty"` // reads fail over to it while down
+}
+
+// MaintenanceStatus is the server's maintenance mode: while ReadOnly is
+// set, every request that would change files is refused with 503 and
+// ErrCodeMaintenance. It is the payload of the maintenance event, sent to
+// everyone when the mode changes and to each new stream while it is on,
+// and the body of GET and PUT /api/v1/admin/maintenance.
+type MaintenanceStatus struct {
+	ReadOnly bool       `json:"readonly"`
+	Message  string     `json:"message,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// Conflict actions reported in ConflictPayload.Action.
+const (
+	ConflictCreated  = "created"
+	ConflictResolved = "resolved"
+)
+
+// ConflictPayload tells a user that one of their sync conflicts on
+// Event.Path was created or resolved. It is only sent to that user; clients
+// fetch the details from GET /api/v1/conflicts.
+type ConflictPayload struct {
+	ID         int64  `json:"id"`
+	Action     string `json:"action"`
+	Resolution string `json:"resolution,omitempty"` // set when resolved
+}
+
+// TrashPurgedPayload tells a user that the periodic purge removed items
+// they had deleted from the trash, so open trash views can refresh. It is
+// only sent to that user.
+type TrashPurgedPayload struct {
+	Items int      `json:"items"`           // top-level items purged
+	Paths []string `json:"paths,omitempty"` // their original paths, may be truncated
+}
+
+// Reauth reasons reported in ReauthPayload.Reason.
+const (
+	ReauthExpiring = "expiring" // the token expires at ExpiresAt
+	ReauthRevoked  = "revoked"  // the session was revoked
+)
+
+// ReauthPayload says why the stream ends. It is only sent to the stream
+// it ends.
+type ReauthPayload struct {
+	Reason    string `json:"reason"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix seconds, for ReauthExpiring
+}
+
+// Known reports whether the event type is understood by this build.
+func (e *Event) Known() bool {
+	return knownEventTypes[e.Type]
+}
+
+// IsTreeChange reports whether the event means the file tree changed and
+// cached metadata should be refreshed.
+func (e *Event) IsTreeChange() bool {
+	switch e.Type {
+	case EventCreate, EventModify, EventDelete, EventVersion, EventDirChanged:
+		return true
+	}
+	return false
+}
+
+// NeedsResync reports whether the client missed events and must refetch
+// its whole metadata tree instead of applying individual changes.
+func (e *Event) NeedsResync() bool {
+	return e.Type == EventResyncRequired
+}
+
+// Validate checks that a known event type carries the fields it needs.
+// Unknown types are reported as ErrUnknownEventType.
+func (e *Event) Validate() error {
+	if e.Type == "" {
+		return errors.New("event type is required")
+	}
+	if !e.Known() {
+		return fmt.Errorf("%w: %q", ErrUnknownEventType, e.Type)
+	}
+	switch e.Type {
+	case EventCreate, EventModify, EventDelete, EventVersion:
+		if e.Path == "" {
+			return fmt.Errorf("%s event requires a path", e.Type)
+		}
+	case EventDirChanged:
+		if e.Path == "" || e.Dir == nil {
+			return fmt.Errorf("%s event requires a path and dir payload", e.Type)
+		}
+	case EventJob:
+		if e.Job == nil || e.Job.ID == "" {
+			return fmt.Errorf("%s event requires a job payload with an id", e.Type)
+		}
+	case EventNotice:
+		if e.Notice == nil || e.Notice.Message == "" {
+			return fmt.Errorf("%s event requires a notice payload with a message", e.Type)
+		}
+	case EventPermissionGranted:
+		if e.Path == "" || e.Grant == nil {
+			return fmt.Errorf("%s event requires a path and grant payload", e.Type)
+		}
+	case EventComment:
+		if e.Path == "" || e.Comment == nil {
+			return fmt.Errorf("%s event requires a path and comment payload", e.Type)
+		}
+	case EventStorageHealth:
+		if e.Storage == nil || e.Storage.LocationID == 0 {
+			return fmt.Errorf("%s event requires a storage payload with a location id", e.Type)
+		}
+	case EventMaintenance:
+		if e.Maintenance == nil {
+			return fmt.Errorf("%s event requires a maintenance payload", e.Type)
+		}
+	case EventConflict:
+		if e.Path == "" || e.Conflict == nil {
+			return fmt.Errorf("%s event requires a path and conflict payload", e.Type)
+		}
+	case EventTrashPurged:
+		if e.Trash == nil {
+			return fmt.Errorf("%s event requires a trash payload", e.Type)
+		}
+	case EventReauth:
+		if e.Reauth == nil || e.Reauth.Reason == "" {
+			return fmt.Errorf("%s event requires a reauth payload with a reason", e.Type)
+		}
+	}
+	return nil
+}
+
+// MarshalEvent validates an event, stamps the current schema version, and
+// serializes it for the SSE data line.
+func MarshalEvent(e Event) ([]byte, error) {
+	if e.Schema == 0 {
+		e.Schema = EventSchemaVersion
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// eventFields are the JSON names decoded into Event's typed fields.
+var eventFields = map[string]bool{
+	"id": true, "schema": true, "type": true, "path": true, "version": true, "hash": true,
+	"size": true, "timestamp": true, "user_id": true, "username": true,
+	"dir": true, "job": true, "notice": true, "grant": true, "comment": true, "storage": true,
+	"maintenance": true, "conflict": true, "trash": true, "reauth": true,
+}
+
+// ParseEvent decodes an SSE data payload. name is the SSE "event:" name and
+// is used when the payload has no type. Unknown fields are kept in Extra,
+// payloads from newer schema versions are decoded as far as possible, and
+// unknown types return the event together with ErrUnknownEventType.
+func ParseEvent(name string, data []byte) (*Event, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("decode event: %w", err)
+	}
+
+	e := &Event{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("decode event: %w", err)
+	}
+	for k, v := range raw {
+		if !eventFields[k] {
+			if e.Extra == nil {
+				e.Extra = make(map[string]json.RawMessage)
+			}
+			e.Extra[k] = v
+		}
+	}
+
+	if e.Schema == 0 {
+		e.Schema = 1
+	}
+	if e.Type == "" {
+		e.Type = name
+	}
+	if err := e.Validate(); err != nil {
+		return e, err
+	}
+	return e, nil
+}
@@ -0,0 +1,194 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// v1Payloads are events as emitted by servers before schema versioning.
+var v1Payloads = map[string]string{
+	EventCreate:  `{"type":"create","path":"/docs/a.txt","size":120,"timestamp":1700000000,"user_id":1,"username":"admin"}`,
+	EventModify:  `{"type":"modify","path":"/docs/a.txt","version":3,"hash":"abc123","size":140,"timestamp":1700000100,"user_id":2,"username":"alice"}`,
+	EventDelete:  `{"type":"delete","path":"/docs/old","timestamp":1700000200,"user_id":1,"username":"admin"}`,
+	EventVersion: `{"type":"version","path":"/docs/a.txt","version":2,"hash":"def456","size":100,"timestamp":1700000300}`,
+}
+
+// legacyClientEvent and legacyProtocolEvent are the structs older clients
+// decode events into.
+type legacyClientEvent struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Time int64  `json:"time"`
+}
+
+type legacyProtocolEvent struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	Version   int    `json:"version,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func TestParseEvent_V1Payloads(t *testing.T) {
+	for typ, payload := range v1Payloads {
+		e, err := ParseEvent(typ, []byte(payload))
+		if err != nil {
+			t.Errorf("%s: %v", typ, err)
+			continue
+		}
+		if e.Schema != 1 {
+			t.Errorf("%s: schema = %d, want 1 for unversioned payloads", typ, e.Schema)
+		}
+		if e.Type != typ || e.Path == "" || e.Timestamp == 0 {
+			t.Errorf("%s: parsed %+v", typ, e)
+		}
+		if len(e.Extra) != 0 {
+			t.Errorf("%s: unexpected extra fields %v", typ, e.Extra)
+		}
+		if !e.IsTreeChange() {
+			t.Errorf("%s: should be a tree change", typ)
+		}
+	}
+
+	e, _ := ParseEvent(EventModify, []byte(v1Payloads[EventModify]))
+	want := &Event{Schema: 1, Type: EventModify, Path: "/docs/a.txt", Version: 3, Hash: "abc123",
+		Size: 140, Timestamp: 1700000100, UserID: 2, Username: "alice"}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+}
+
+func TestParseEvent_TypeFromEventName(t *testing.T) {
+	e, err := ParseEvent(EventDelete, []byte(`{"path":"/x","timestamp":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventDelete {
+		t.Errorf("type = %q, want %q", e.Type, EventDelete)
+	}
+}
+
+// currentEvents are events produced by this build, one per known type.
+func currentEvents() []Event {
+	return []Event{
+		{Type: EventCreate, Path: "/a.txt", Size: 10, Timestamp: 1},
+		{Type: EventModify, Path: "/a.txt", Version: 2, Hash: "h", Size: 11, Timestamp: 2},
+		{Type: EventDelete, Path: "/a.txt", Timestamp: 3, UserID: 1, Username: "admin"},
+		{Type: EventVersion, Path: "/a.txt", Version: 1, Timestamp: 4},
+		{Type: EventDirChanged, Path: "/photos", Timestamp: 5, Dir: &DirChangedPayload{Changes: 42, Names: []string{"a.jpg"}}},
+		{Type: EventJob, Path: "/photos", Timestamp: 6, Job: &JobPayload{ID: "j1", Kind: "gallery_reprocess", State: "running", Progress: 0.5}},
+		{Type: EventNotice, Timestamp: 7, Notice: &NoticePayload{Level: "warning", Message: "maintenance at 22:00"}},
+		{Type: EventPermissionGranted, Path: "/docs", Timestamp: 8, UserID: 1, Username: "admin", Grant: &GrantPayload{Permission: "read"}},
+		{Type: EventComment, Path: "/docs/design.pdf", Timestamp: 9, UserID: 2, Username: "bob", Comment: &CommentPayload{ID: 7, ParentID: 3, Action: CommentAdded}},
+		{Type: EventStorageHealth, Timestamp: 10, Storage: &StorageHealthPayload{LocationID: 2, Name: "s3-eu", Error: "connection refused", ReplicaID: 3}},
+		{Type: EventMaintenance, Timestamp: 11, Maintenance: &MaintenanceStatus{ReadOnly: true, Message: "storage migration until 14:00"}},
+		{Type: EventConflict, Path: "/docs/plan.md", Timestamp: 12, Conflict: &ConflictPayload{ID: 4, Action: ConflictResolved, Resolution: ResolveKeepBoth}},
+		{Type: EventTrashPurged, Timestamp: 13, Trash: &TrashPurgedPayload{Items: 2, Paths: []string{"/old.txt", "/drafts"}}},
+		{Type: EventReauth, Timestamp: 14, Reauth: &ReauthPayload{Reason: ReauthExpiring, ExpiresAt: 74}},
+	}
+}
+
+func TestMarshalParseRoundTrip(t *testing.T) {
+	for _, in := range currentEvents() {
+		data, err := MarshalEvent(in)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", in.Type, err)
+		}
+		out, err := ParseEvent(in.Type, data)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", in.Type, err)
+		}
+		in.Schema = EventSchemaVersion
+		if !reflect.DeepEqual(*out, in) {
+			t.Errorf("%s: round trip got %+v, want %+v", in.Type, *out, in)
+		}
+	}
+}
+
+func TestCurrentEvents_LegacyParsers(t *testing.T) {
+	for _, in := range currentEvents() {
+		data, err := MarshalEvent(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var lc legacyClientEvent
+		if err := json.Unmarshal(data, &lc); err != nil {
+			t.Errorf("%s: legacy client parser failed: %v", in.Type, err)
+		}
+		if lc.Type != in.Type || lc.Path != in.Path {
+			t.Errorf("%s: legacy client parsed %+v", in.Type, lc)
+		}
+
+		var lp legacyProtocolEvent
+		if err := json.Unmarshal(data, &lp); err != nil {
+			t.Errorf("%s: legacy protocol parser failed: %v", in.Type, err)
+		}
+		if lp.Type != in.Type || lp.Path != in.Path || lp.Size != in.Size ||
+			lp.Version != in.Version || lp.Timestamp != in.Timestamp {
+			t.Errorf("%s: legacy protocol parsed %+v", in.Type, lp)
+		}
+	}
+}
+
+func TestParseEvent_ForwardCompatible(t *testing.T) {
+	// Newer schema with extra fields on a known type
+	e, err := ParseEvent("modify", []byte(`{"schema":2,"type":"modify","path":"/a","timestamp":1,"client_id":"c-1","request_id":"r-9"}`))
+	if err != nil {
+		t.Fatalf("newer payload of known type should parse: %v", err)
+	}
+	if e.Schema != 2 || e.Path != "/a" {
+		t.Errorf("parsed %+v", e)
+	}
+	if string(e.Extra["client_id"]) != `"c-1"` || string(e.Extra["request_id"]) != `"r-9"` {
+		t.Errorf("extra = %v", e.Extra)
+	}
+
+	// Unknown type is reported but still decoded
+	e, err = ParseEvent("share_created", []byte(`{"type":"share_created","path":"/a","timestamp":1}`))
+	if !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("err = %v, want ErrUnknownEventType", err)
+	}
+	if e == nil || e.Type != "share_created" || e.Known() {
+		t.Errorf("parsed %+v", e)
+	}
+}
+
+func TestParseEvent_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed":         `{"type":`,
+		"no type":           `{"path":"/a"}`,
+		"file without path": `{"type":"create","timestamp":1}`,
+		"job without id":    `{"type":"job","job":{"kind":"x"}}`,
+		"notice empty":      `{"type":"notice","notice":{"level":"info"}}`,
+		"dir without dir":   `{"type":"dir_changed","path":"/a"}`,
+	}
+	for name, payload := range tests {
+		if _, err := ParseEvent("", []byte(payload)); err == nil {
+			t.Errorf("%s: expected error", name)
+		} else if errors.Is(err, ErrUnknownEventType) {
+			t.Errorf("%s: got unknown-type error, want validation error", name)
+		}
+	}
+}
+
+func TestMarshalEvent_RejectsInvalid(t *testing.T) {
+	if _, err := MarshalEvent(Event{Type: EventCreate}); err == nil {
+		t.Error("create without path should fail")
+	}
+	if _, err := MarshalEvent(Event{Type: "bogus", Path: "/a"}); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("err = %v, want ErrUnknownEventType", err)
+	}
+	data, err := MarshalEvent(Event{Type: EventCreate, Path: "/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	if m["schema"] != float64(EventSchemaVersion) {
+		t.Errorf("schema = %v, want %d", m["schema"], EventSchemaVersion)
+	}
+}
@@ -142,3 +142,14 @@ func DoWithResult[T any](ctx context.Context, cfg Config, fn func() (T, error))
 
 	return result, lastErr
 }
+
+// Backoff returns how long to wait before the next try of something that
+// has failed attempts times: base doubled per earlier failure, capped at
+// max. It suits retries scheduled for later, where Do's sleep does not.
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	return min(d, max)
+}
@@ -0,0 +1,232 @@
+// Package selective implements selective sync: server folders a client
+// leaves out of its tree entirely. Excluded folders are not listed, not
+// fetched and not watched, which keeps large archives nobody needs
+// locally out of the client's memory and refreshes.
+//
+// Exclusions are path prefixes. They come from the client's -exclude
+// flags and from a file in the cache directory that the sync-config
+// subcommand edits, so they survive restarts and can be changed without
+// touching the mount's command line.
+package selective
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// FileName is the name of the persisted exclusion list in the cache
+// directory.
+const FileName = "selective-sync.json"
+
+// Excludes is an immutable set of excluded server paths. A nil *Excludes
+// excludes nothing.
+type Excludes struct {
+	prefixes []string // cleaned, sorted, none below another
+}
+
+// New builds an exclusion set. Paths are cleaned ("archives/" becomes
+// "/archives"); paths below another one are dropped. The root cannot be
+// excluded.
+func New(paths []string) (*Excludes, error) {
+	cleaned := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		p = cleanPath(p)
+		if p == "/" {
+			return nil, fmt.Errorf("cannot exclude the root folder")
+		}
+		cleaned = append(cleaned, p)
+	}
+	sort.Strings(cleaned)
+
+	e := &Excludes{}
+	for _, p := range cleaned {
+		if !e.Excluded(p) {
+			e.prefixes = append(e.prefixes, p)
+		}
+	}
+	return e, nil
+}
+
+// Load reads the persisted exclusions from the cache directory dir. A
+// missing file means no exclusions.
+func Load(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var paths []string
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", FileName, err)
+	}
+	return paths, nil
+}
+
+// Save persists paths as the exclusions of the cache directory dir,
+// replacing the file atomically so a running client never reads half
+// of it.
+func Save(dir string, paths []string) error {
+	e, err := New(paths)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(e.Prefixes(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, FileName+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, FileName))
+}
+
+// LoadWith returns the persisted exclusions of dir combined with extra
+// ones, such as those given on the command line.
+func LoadWith(dir string, extra []string) (*Excludes, error) {
+	paths, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	return New(append(paths, extra...))
+}
+
+// Prefixes returns the excluded paths, sorted.
+func (e *Excludes) Prefixes() []string {
+	if e == nil {
+		return nil
+	}
+	return append([]string(nil), e.prefixes...)
+}
+
+// Empty reports whether nothing is excluded.
+func (e *Excludes) Empty() bool {
+	return e == nil || len(e.prefixes) == 0
+}
+
+// Equal reports whether e and other exclude the same paths.
+func (e *Excludes) Equal(other *Excludes) bool {
+	a, b := e.Prefixes(), other.Prefixes()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Excluded reports whether the server path p is excluded, either itself
+// or through one of its parent folders.
+func (e *Excludes) Excluded(p string) bool {
+	if e.Empty() {
+		return false
+	}
+	p = cleanPath(p)
+	for _, prefix := range e.prefixes {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesBelow reports whether something strictly below the folder p is
+// excluded, so the folder cannot be fetched whole.
+func (e *Excludes) excludesBelow(p string) bool {
+	if e.Empty() {
+		return false
+	}
+	p = cleanPath(p)
+	if p == "/" {
+		return true
+	}
+	for _, prefix := range e.prefixes {
+		if strings.HasPrefix(prefix, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune removes the excluded nodes from the tree below node and returns
+// how many direct and indirect children were dropped. Counts of the
+// remaining nodes are left as the server sent them.
+func (e *Excludes) Prune(node *models.FileNode) int {
+	if e.Empty() || node == nil || !node.IsDir {
+		return 0
+	}
+	removed := 0
+	kept := node.Children[:0]
+	for _, child := range node.Children {
+		if e.Excluded(child.Path) {
+			removed++
+			continue
+		}
+		removed += e.Prune(child)
+		kept = append(kept, child)
+	}
+	for i := len(kept); i < len(node.Children); i++ {
+		node.Children[i] = nil
+	}
+	node.Children = kept
+	return removed
+}
+
+// FetchTree fetches the tree below root without the excluded folders.
+// Folders with nothing excluded below them are fetched whole with one
+// subtree request; only the folders leading to an exclusion are listed
+// one level at a time, so excluded subtrees are never transferred.
+// Against a server without depth-limited trees the whole tree is fetched
+// and pruned.
+func (e *Excludes) FetchTree(ctx context.Context, c *client.Client, root string) (*models.FileNode, error) {
+	if !e.excludesBelow(root) {
+		return c.FetchSubtree(ctx, root)
+	}
+
+	node, partial, err := c.FetchDir(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if !partial {
+		e.Prune(node)
+		return node, nil
+	}
+
+	kept := make([]*models.FileNode, 0, len(node.Children))
+	for _, child := range node.Children {
+		if e.Excluded(child.Path) {
+			continue
+		}
+		if child.IsDir {
+			sub, err := e.FetchTree(ctx, c, child.Path)
+			if err != nil {
+				return nil, err
+			}
+			child = sub
+		}
+		kept = append(kept, child)
+	}
+	node.Children = kept
+	return node, nil
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + strings.TrimSpace(p))
+}
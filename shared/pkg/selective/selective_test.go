@@ -0,0 +1,167 @@
+package selective
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/client"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+	"github.com/fruitsalade/fruitsalade/shared/pkg/protocol"
+	fstree "github.com/fruitsalade/fruitsalade/shared/pkg/tree"
+)
+
+func testTree() *models.FileNode {
+	dir := func(p string, children ...*models.FileNode) *models.FileNode {
+		return &models.FileNode{ID: p, Path: p, Name: p[strings.LastIndex(p, "/")+1:], IsDir: true,
+			ChildCount: len(children), Children: children}
+	}
+	file := func(p string) *models.FileNode {
+		return &models.FileNode{ID: p, Path: p, Name: p[strings.LastIndex(p, "/")+1:], Size: 1}
+	}
+	return dir("/",
+		dir("/archives", dir("/archives/2019", file("/archives/2019/a.zip"))),
+		dir("/docs", file("/docs/a.txt"), dir("/docs/old", file("/docs/old/b.txt")), file("/docs/c.txt")),
+		file("/top.txt"),
+	)
+}
+
+func TestNew(t *testing.T) {
+	e, err := New([]string{"docs/old/", "/archives", " ", "/archives/2019", "/docs/old/x"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := e.Prefixes(); !slices.Equal(got, []string{"/archives", "/docs/old"}) {
+		t.Errorf("Prefixes = %v", got)
+	}
+	if _, err := New([]string{"/"}); err == nil {
+		t.Error("excluding the root should fail")
+	}
+
+	var none *Excludes
+	if !none.Empty() || none.Excluded("/a") || none.Prune(testTree()) != 0 {
+		t.Error("nil Excludes should exclude nothing")
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	e, _ := New([]string{"/archives", "/docs/old"})
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/archives", true},
+		{"/archives/2019/a.zip", true},
+		{"archives/", true},
+		{"/archives-new", false},
+		{"/docs", false},
+		{"/docs/old/b.txt", true},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		if got := e.Excluded(tt.path); got != tt.want {
+			t.Errorf("Excluded(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	e, _ := New([]string{"/archives", "/docs/old"})
+	root := testTree()
+	if n := e.Prune(root); n != 2 {
+		t.Errorf("Prune removed %d nodes, want 2", n)
+	}
+	if fstree.FindByPath(root, "/archives") != nil || fstree.FindByPath(root, "/docs/old") != nil {
+		t.Error("excluded folders still in the tree")
+	}
+	if fstree.FindByPath(root, "/docs/c.txt") == nil || fstree.FindByPath(root, "/top.txt") == nil {
+		t.Error("included files were pruned")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	if paths, err := Load(dir); err != nil || paths != nil {
+		t.Fatalf("Load without a file = %v, %v", paths, err)
+	}
+	if err := Save(dir, []string{"b", "/a", "/a/x"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	e, err := LoadWith(dir, []string{"/c"})
+	if err != nil {
+		t.Fatalf("LoadWith: %v", err)
+	}
+	if got := e.Prefixes(); !slices.Equal(got, []string{"/a", "/b", "/c"}) {
+		t.Errorf("Prefixes = %v", got)
+	}
+	if err := Save(dir, []string{"/"}); err == nil {
+		t.Error("saving the root as an exclusion should fail")
+	}
+}
+
+// treeServer serves testTree, honouring ?depth=1 when supportsDepth is
+// set, and records every node it sends.
+type treeServer struct {
+	supportsDepth bool
+
+	mu   sync.Mutex
+	sent map[string]bool
+}
+
+func (s *treeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/tree"), "/")
+	node := fstree.FindByPath(testTree(), p)
+	if node == nil {
+		http.NotFound(w, r)
+		return
+	}
+	resp := protocol.TreeResponse{Root: node}
+	if s.supportsDepth && r.URL.Query().Get("depth") == "1" {
+		for _, c := range node.Children {
+			c.Children = nil
+		}
+		resp.Partial = true
+	}
+
+	s.mu.Lock()
+	var record func(n *models.FileNode)
+	record = func(n *models.FileNode) {
+		s.sent[n.Path] = true
+		for _, c := range n.Children {
+			record(c)
+		}
+	}
+	record(node)
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestFetchTree(t *testing.T) {
+	e, _ := New([]string{"/archives/2019", "/docs/old"})
+	for _, depth := range []bool{true, false} {
+		srv := &treeServer{supportsDepth: depth, sent: make(map[string]bool)}
+		ts := httptest.NewServer(srv)
+		c := client.New(client.Config{BaseURL: ts.URL})
+
+		root, err := e.FetchTree(context.Background(), c, "/")
+		ts.Close()
+		if err != nil {
+			t.Fatalf("depth=%v: FetchTree: %v", depth, err)
+		}
+		if fstree.FindByPath(root, "/archives/2019") != nil || fstree.FindByPath(root, "/docs/old") != nil {
+			t.Errorf("depth=%v: excluded folders in the tree", depth)
+		}
+		if fstree.FindByPath(root, "/archives") == nil || fstree.FindByPath(root, "/docs/c.txt") == nil {
+			t.Errorf("depth=%v: included nodes missing", depth)
+		}
+		if depth && (srv.sent["/archives/2019/a.zip"] || srv.sent["/docs/old/b.txt"]) {
+			t.Errorf("depth=%v: the server sent the contents of excluded folders", depth)
+		}
+	}
+}
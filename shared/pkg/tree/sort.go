@@ -0,0 +1,158 @@
+package tree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// Sort keys of an Order.
+const (
+	SortName  = "name"
+	SortSize  = "size"
+	SortMtime = "mtime"
+)
+
+// Order is an ordering of the children of a directory. Every order is
+// total: nodes with the same size or mtime fall back to the name order, and
+// names compare case-insensitively, then byte-wise, so that a listing does
+// not shift between refreshes. Desc reverses the whole order.
+type Order struct {
+	Key  string
+	Desc bool
+}
+
+// DefaultOrder is the order of the server's tree: case-insensitive name,
+// ascending. Trees kept by clients stay in it by inserting with
+// InsertChild.
+var DefaultOrder = Order{Key: SortName}
+
+// ParseOrder parses the ?sort= and ?dir= parameters of a tree request.
+// Empty values select the DefaultOrder.
+func ParseOrder(key, dir string) (Order, error) {
+	o := DefaultOrder
+	switch key {
+	case "":
+	case SortName, SortSize, SortMtime:
+		o.Key = key
+	default:
+		return o, fmt.Errorf("sort must be name, size or mtime")
+	}
+	switch dir {
+	case "", "asc":
+	case "desc":
+		o.Desc = true
+	default:
+		return o, fmt.Errorf("dir must be asc or desc")
+	}
+	return o, nil
+}
+
+// compareNames orders names case-insensitively, breaking ties byte-wise.
+func compareNames(a, b string) int {
+	if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// Compare returns a negative number if a comes before b in o, a positive
+// one if it comes after and 0 for nodes with the same name.
+func (o Order) Compare(a, b *models.FileNode) int {
+	c := 0
+	switch o.Key {
+	case SortSize:
+		c = cmpInt64(sortSize(a), sortSize(b))
+	case SortMtime:
+		c = a.ModTime.Compare(b.ModTime)
+	}
+	if c == 0 {
+		c = compareNames(a.Name, b.Name)
+	}
+	if o.Desc {
+		return -c
+	}
+	return c
+}
+
+// sortSize is a node's size for sorting: a directory's is the total size
+// of its files.
+func sortSize(n *models.FileNode) int64 {
+	if n.IsDir {
+		return n.AggSize
+	}
+	return n.Size
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Sort sorts children in place.
+func (o Order) Sort(children []*models.FileNode) {
+	sort.SliceStable(children, func(i, j int) bool { return o.Compare(children[i], children[j]) < 0 })
+}
+
+// sorted reports whether children are in order.
+func (o Order) sorted(children []*models.FileNode) bool {
+	for i := 1; i < len(children); i++ {
+		if o.Compare(children[i-1], children[i]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorted returns root with the children of every directory in order. It
+// does not modify root: directories that need reordering, and those above
+// them, are copied; the rest is shared.
+func (o Order) Sorted(root *models.FileNode) *models.FileNode {
+	if root == nil || len(root.Children) == 0 {
+		return root
+	}
+	var children []*models.FileNode
+	for i, child := range root.Children {
+		s := o.Sorted(child)
+		if s != child && children == nil {
+			children = make([]*models.FileNode, len(root.Children))
+			copy(children, root.Children[:i])
+		}
+		if children != nil {
+			children[i] = s
+		}
+	}
+	if children == nil {
+		if o.sorted(root.Children) {
+			return root
+		}
+		children = make([]*models.FileNode, len(root.Children))
+		copy(children, root.Children)
+	}
+	o.Sort(children)
+	n := *root
+	n.Children = children
+	return &n
+}
+
+// InsertChild adds child to parent at its place in the DefaultOrder,
+// replacing a child with the same name.
+func InsertChild(parent, child *models.FileNode) {
+	i := sort.Search(len(parent.Children), func(i int) bool {
+		return compareNames(parent.Children[i].Name, child.Name) >= 0
+	})
+	if i < len(parent.Children) && parent.Children[i].Name == child.Name {
+		parent.Children[i] = child
+		return
+	}
+	parent.Children = append(parent.Children, nil)
+	copy(parent.Children[i+1:], parent.Children[i:])
+	parent.Children[i] = child
+}
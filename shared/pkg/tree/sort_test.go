@@ -0,0 +1,97 @@
+package tree
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+func childNames(n *models.FileNode) []string {
+	var names []string
+	for _, c := range n.Children {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestParseOrder(t *testing.T) {
+	if o, err := ParseOrder("", ""); err != nil || o != DefaultOrder {
+		t.Errorf("ParseOrder(\"\", \"\") = %+v, %v", o, err)
+	}
+	if o, err := ParseOrder("mtime", "desc"); err != nil || o != (Order{Key: SortMtime, Desc: true}) {
+		t.Errorf("ParseOrder(mtime, desc) = %+v, %v", o, err)
+	}
+	for _, bad := range [][2]string{{"owner", ""}, {"name", "up"}} {
+		if _, err := ParseOrder(bad[0], bad[1]); err == nil {
+			t.Errorf("ParseOrder(%q, %q) succeeded", bad[0], bad[1])
+		}
+	}
+}
+
+func TestOrderSorted(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := &models.FileNode{Path: "/", IsDir: true, Children: []*models.FileNode{
+		{Name: "b.txt", Size: 5, ModTime: t0},
+		{Name: "Docs", IsDir: true, AggSize: 30, ModTime: t0.Add(time.Hour), Children: []*models.FileNode{
+			{Name: "z", Size: 1}, {Name: "a", Size: 2},
+		}},
+		{Name: "a.txt", Size: 5, ModTime: t0.Add(2 * time.Hour)},
+		{Name: "A.txt", Size: 1, ModTime: t0},
+	}}
+
+	tests := []struct {
+		order Order
+		want  []string
+	}{
+		{DefaultOrder, []string{"A.txt", "a.txt", "b.txt", "Docs"}},
+		{Order{Key: SortName, Desc: true}, []string{"Docs", "b.txt", "a.txt", "A.txt"}},
+		{Order{Key: SortSize}, []string{"A.txt", "a.txt", "b.txt", "Docs"}},
+		{Order{Key: SortSize, Desc: true}, []string{"Docs", "b.txt", "a.txt", "A.txt"}},
+		{Order{Key: SortMtime}, []string{"A.txt", "b.txt", "Docs", "a.txt"}},
+	}
+	for _, tt := range tests {
+		sorted := tt.order.Sorted(root)
+		if got := childNames(sorted); !equalNames(got, tt.want) {
+			t.Errorf("%+v: %v, want %v", tt.order, got, tt.want)
+		}
+	}
+
+	sorted := DefaultOrder.Sorted(root)
+	if got := childNames(sorted.Children[3]); !equalNames(got, []string{"a", "z"}) {
+		t.Errorf("Docs children = %v", got)
+	}
+	if root.Children[0].Name != "b.txt" || root.Children[1].Children[0].Name != "z" {
+		t.Error("Sorted modified the source tree")
+	}
+	// An ordered tree is returned as is
+	if again := DefaultOrder.Sorted(sorted); again != sorted {
+		t.Error("Sorted copied an ordered tree")
+	}
+}
+
+func TestInsertChild(t *testing.T) {
+	dir := &models.FileNode{IsDir: true}
+	for _, name := range []string{"b", "D", "a", "C"} {
+		InsertChild(dir, &models.FileNode{Name: name})
+	}
+	if got := childNames(dir); !equalNames(got, []string{"a", "b", "C", "D"}) {
+		t.Errorf("children = %v", got)
+	}
+	InsertChild(dir, &models.FileNode{Name: "b", Size: 7})
+	if len(dir.Children) != 4 || dir.Children[1].Size != 7 {
+		t.Errorf("replacing b: %+v", dir.Children)
+	}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -7,7 +7,8 @@ import (
 	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
 )
 
-// FindByPath resolves a path in the metadata tree (recursive).
+// FindByPath resolves a path in the metadata tree, descending only into
+// the directories on the path.
 func FindByPath(root *models.FileNode, path string) *models.FileNode {
 	if root == nil {
 		return nil
@@ -16,6 +17,9 @@ func FindByPath(root *models.FileNode, path string) *models.FileNode {
 		return root
 	}
 	for _, child := range root.Children {
+		if child.Path != path && !strings.HasPrefix(path, child.Path+"/") {
+			continue
+		}
 		if found := FindByPath(child, path); found != nil {
 			return found
 		}
@@ -44,18 +48,84 @@ func CacheID(id string) string {
 	return strings.ReplaceAll(id, "/", "_")
 }
 
-// CountNodes counts all nodes in a tree.
+// CountNodes counts all nodes in a tree. It walks the tree with an explicit
+// stack, so any depth is safe.
 func CountNodes(root *models.FileNode) int {
 	if root == nil {
 		return 0
 	}
-	count := 1
-	for _, child := range root.Children {
-		count += CountNodes(child)
+	count := 0
+	stack := []*models.FileNode{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		count++
+		stack = append(stack, node.Children...)
 	}
 	return count
 }
 
+// Height returns how many levels of descendants root has: 0 for a file or
+// an empty directory, 1 for a directory holding only files.
+func Height(root *models.FileNode) int {
+	if root == nil {
+		return 0
+	}
+	height := 0
+	level := []*models.FileNode{root}
+	for {
+		var next []*models.FileNode
+		for _, node := range level {
+			next = append(next, node.Children...)
+		}
+		if len(next) == 0 {
+			return height
+		}
+		height++
+		level = next
+	}
+}
+
+// Aggregate sets AggSize and ItemCount on every directory in the tree in a
+// single post-order pass. It modifies the tree in place. The directories are
+// collected breadth-first and summed in reverse, children before parents,
+// without recursion.
+func Aggregate(root *models.FileNode) {
+	if root == nil || !root.IsDir {
+		return
+	}
+	dirs := []*models.FileNode{root}
+	for i := 0; i < len(dirs); i++ {
+		for _, child := range dirs[i].Children {
+			if child.IsDir {
+				dirs = append(dirs, child)
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		SumChildren(dirs[i])
+	}
+}
+
+// SumChildren sets the AggSize and ItemCount of a directory from its
+// children, whose own aggregates must already be up to date. A file's are
+// set to zero.
+func SumChildren(node *models.FileNode) {
+	node.AggSize, node.ItemCount = 0, 0
+	if !node.IsDir {
+		return
+	}
+	for _, child := range node.Children {
+		node.ItemCount++
+		if child.IsDir {
+			node.AggSize += child.AggSize
+			node.ItemCount += child.ItemCount
+		} else {
+			node.AggSize += child.Size
+		}
+	}
+}
+
 // RemoveChild removes a child by name from a parent node.
 func RemoveChild(parent *models.FileNode, name string) {
 	for i, child := range parent.Children {
@@ -96,6 +96,61 @@ func TestCountNodes(t *testing.T) {
 	}
 }
 
+func TestAggregate(t *testing.T) {
+	root := &models.FileNode{
+		Path: "/", IsDir: true,
+		Children: []*models.FileNode{
+			{Path: "/a.txt", Name: "a.txt", Size: 1},
+			{Path: "/dir", Name: "dir", IsDir: true, Children: []*models.FileNode{
+				{Path: "/dir/b.txt", Name: "b.txt", Size: 2},
+				{Path: "/dir/sub", Name: "sub", IsDir: true, Children: []*models.FileNode{
+					{Path: "/dir/sub/c.txt", Name: "c.txt", Size: 4},
+				}},
+				{Path: "/dir/empty", Name: "empty", IsDir: true},
+			}},
+		},
+	}
+	Aggregate(root)
+
+	tests := []struct {
+		path  string
+		size  int64
+		items int
+	}{
+		{"/", 7, 6},
+		{"/dir", 6, 4},
+		{"/dir/sub", 4, 1},
+		{"/dir/empty", 0, 0},
+		{"/a.txt", 0, 0},
+	}
+	for _, tt := range tests {
+		n := FindByPath(root, tt.path)
+		if n.AggSize != tt.size || n.ItemCount != tt.items {
+			t.Errorf("%s: agg_size=%d item_count=%d, want %d and %d", tt.path, n.AggSize, n.ItemCount, tt.size, tt.items)
+		}
+	}
+}
+
+func TestDeepTree(t *testing.T) {
+	const depth = 100000
+	root := &models.FileNode{Path: "/", IsDir: true}
+	node := root
+	for i := 0; i < depth; i++ {
+		child := &models.FileNode{Name: "d", IsDir: true}
+		node.Children = []*models.FileNode{child}
+		node = child
+	}
+	node.Children = []*models.FileNode{{Name: "f", Size: 3}}
+
+	if got := CountNodes(root); got != depth+2 {
+		t.Errorf("CountNodes = %d, want %d", got, depth+2)
+	}
+	Aggregate(root)
+	if root.AggSize != 3 || root.ItemCount != depth+1 {
+		t.Errorf("root: agg_size=%d item_count=%d, want 3 and %d", root.AggSize, root.ItemCount, depth+1)
+	}
+}
+
 func TestRemoveChild(t *testing.T) {
 	parent := &models.FileNode{
 		Path: "/", IsDir: true,
@@ -0,0 +1,122 @@
+package tree
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+// The functions below update a tree copy-on-write: the nodes on the way
+// from the root to the change are copied and everything else is shared, so
+// a reader still walking the old root never sees a half-applied update.
+// The returned root replaces the old one; neither is modified. The
+// AggSize and ItemCount of the copied directories are recomputed.
+
+// Upsert returns root with node placed at node.Path. An existing node at
+// that path is replaced, keeping its children if both are directories; the
+// node's own children are ignored. The parent directory must already be in
+// the tree, otherwise root is returned unchanged and ok is false.
+func Upsert(root, node *models.FileNode) (newRoot *models.FileNode, ok bool) {
+	if root == nil || node == nil {
+		return root, false
+	}
+	if node.Path == "/" {
+		return replaceNode(root, node), true
+	}
+	return updateParent(root, splitPath(path.Dir(node.Path)), func(parent *models.FileNode) bool {
+		name := path.Base(node.Path)
+		for i, child := range parent.Children {
+			if child.Name == name {
+				parent.Children[i] = replaceNode(child, node)
+				return true
+			}
+		}
+		// New nodes go in the DefaultOrder, the order of BuildTree
+		i := sort.Search(len(parent.Children), func(i int) bool { return compareNames(parent.Children[i].Name, name) > 0 })
+		fresh := replaceNode(nil, node)
+		parent.Children = append(parent.Children, nil)
+		copy(parent.Children[i+1:], parent.Children[i:])
+		parent.Children[i] = fresh
+		return true
+	})
+}
+
+// Remove returns root without the node at p and everything below it. ok is
+// false, and root is returned unchanged, if there is no node at p. The root
+// itself cannot be removed.
+func Remove(root *models.FileNode, p string) (newRoot *models.FileNode, ok bool) {
+	if root == nil || p == "/" || p == "" {
+		return root, false
+	}
+	name := path.Base(p)
+	return updateParent(root, splitPath(path.Dir(p)), func(parent *models.FileNode) bool {
+		for i, child := range parent.Children {
+			if child.Name == name {
+				parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// updateParent copies the nodes from root down to the directory at parts
+// and calls fn on the copied directory, whose Children slice is its own.
+// fn reports whether it changed anything; if not, root is returned as is.
+func updateParent(root *models.FileNode, parts []string, fn func(parent *models.FileNode) bool) (*models.FileNode, bool) {
+	copied := shallowCopy(root)
+	if len(parts) == 0 {
+		if !fn(copied) {
+			return root, false
+		}
+		copied.SetChildCount(len(copied.Children))
+		SumChildren(copied)
+		return copied, true
+	}
+	for i, child := range root.Children {
+		if child.Name != parts[0] || !child.IsDir {
+			continue
+		}
+		updated, ok := updateParent(child, parts[1:], fn)
+		if !ok {
+			return root, false
+		}
+		copied.Children[i] = updated
+		SumChildren(copied)
+		return copied, true
+	}
+	return root, false
+}
+
+// replaceNode returns a copy of node that takes over the children, and so
+// the aggregates, of old when both are directories.
+func replaceNode(old, node *models.FileNode) *models.FileNode {
+	n := *node
+	n.Children = nil
+	n.SetChildCount(0)
+	if old != nil && old.IsDir && node.IsDir {
+		n.Children = old.Children
+		n.SetChildCount(len(old.Children))
+	}
+	SumChildren(&n)
+	return &n
+}
+
+// shallowCopy copies node and its Children slice, sharing the children.
+func shallowCopy(node *models.FileNode) *models.FileNode {
+	n := *node
+	n.Children = make([]*models.FileNode, len(node.Children), len(node.Children)+1)
+	copy(n.Children, node.Children)
+	return &n
+}
+
+// splitPath splits "/a/b" into ["a", "b"]; "/" yields nothing.
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
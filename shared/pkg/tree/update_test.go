@@ -0,0 +1,256 @@
+package tree
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/fruitsalade/fruitsalade/shared/pkg/models"
+)
+
+func newUpdateTree() *models.FileNode {
+	return &models.FileNode{Path: "/", IsDir: true, ChildCount: 2, Children: []*models.FileNode{
+		{Path: "/a.txt", Name: "a.txt", Size: 1},
+		{Path: "/dir", Name: "dir", IsDir: true, ChildCount: 1, Children: []*models.FileNode{
+			{Path: "/dir/b.txt", Name: "b.txt", Size: 2},
+		}},
+	}}
+}
+
+func TestUpsertInsert(t *testing.T) {
+	root := newUpdateTree()
+	updated, ok := Upsert(root, &models.FileNode{Path: "/dir/c.txt", Name: "c.txt", Size: 3})
+	if !ok {
+		t.Fatal("Upsert returned !ok")
+	}
+
+	dir := FindByPath(updated, "/dir")
+	if dir.ChildCount != 2 || len(dir.Children) != 2 || dir.Children[1].Name != "c.txt" {
+		t.Errorf("/dir children = %+v", dir.Children)
+	}
+	// The original tree is untouched
+	if old := FindByPath(root, "/dir"); len(old.Children) != 1 || old.ChildCount != 1 {
+		t.Errorf("original /dir modified: %+v", old.Children)
+	}
+	// Unrelated subtrees are shared
+	if updated.Children[0] != root.Children[0] {
+		t.Error("/a.txt was copied")
+	}
+}
+
+func TestUpsertReplaceKeepsChildren(t *testing.T) {
+	root := newUpdateTree()
+	updated, ok := Upsert(root, &models.FileNode{Path: "/dir", Name: "dir", IsDir: true, Visibility: "private"})
+	if !ok {
+		t.Fatal("Upsert returned !ok")
+	}
+	dir := FindByPath(updated, "/dir")
+	if dir.Visibility != "private" || len(dir.Children) != 1 || dir.ChildCount != 1 {
+		t.Errorf("/dir = %+v", dir)
+	}
+	if updated.ChildCount != 2 {
+		t.Errorf("root ChildCount = %d", updated.ChildCount)
+	}
+
+	updated, _ = Upsert(updated, &models.FileNode{Path: "/a.txt", Name: "a.txt", Size: 10, Version: 2})
+	if a := FindByPath(updated, "/a.txt"); a.Size != 10 || a.Version != 2 {
+		t.Errorf("/a.txt = %+v", a)
+	}
+	if a := FindByPath(root, "/a.txt"); a.Size != 1 {
+		t.Errorf("original /a.txt modified: %+v", a)
+	}
+}
+
+func TestUpsertMissingParent(t *testing.T) {
+	root := newUpdateTree()
+	updated, ok := Upsert(root, &models.FileNode{Path: "/nope/x.txt", Name: "x.txt"})
+	if ok || updated != root {
+		t.Error("Upsert under a missing parent should fail")
+	}
+	if _, ok := Upsert(root, &models.FileNode{Path: "/a.txt/x", Name: "x"}); ok {
+		t.Error("Upsert under a file should fail")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	root := newUpdateTree()
+	updated, ok := Remove(root, "/dir")
+	if !ok {
+		t.Fatal("Remove returned !ok")
+	}
+	if FindByPath(updated, "/dir/b.txt") != nil || updated.ChildCount != 1 {
+		t.Errorf("/dir still present: %+v", updated.Children)
+	}
+	if FindByPath(root, "/dir/b.txt") == nil || root.ChildCount != 2 {
+		t.Error("original tree modified")
+	}
+
+	if _, ok := Remove(root, "/missing"); ok {
+		t.Error("Remove(/missing) returned ok")
+	}
+	if _, ok := Remove(root, "/"); ok {
+		t.Error("Remove(/) returned ok")
+	}
+}
+
+func TestUpdatesKeepAggregates(t *testing.T) {
+	root := newUpdateTree()
+	Aggregate(root)
+	if root.AggSize != 3 || root.ItemCount != 3 {
+		t.Fatalf("root aggregates = %d, %d", root.AggSize, root.ItemCount)
+	}
+
+	updated, _ := Upsert(root, &models.FileNode{Path: "/dir/c.txt", Name: "c.txt", Size: 4})
+	if dir := FindByPath(updated, "/dir"); dir.AggSize != 6 || dir.ItemCount != 2 {
+		t.Errorf("/dir after insert: %d, %d", dir.AggSize, dir.ItemCount)
+	}
+	if updated.AggSize != 7 || updated.ItemCount != 4 {
+		t.Errorf("root after insert: %d, %d", updated.AggSize, updated.ItemCount)
+	}
+
+	// Replacing a directory row keeps the aggregates of its contents
+	updated, _ = Upsert(updated, &models.FileNode{Path: "/dir", Name: "dir", IsDir: true, Visibility: "private"})
+	if dir := FindByPath(updated, "/dir"); dir.AggSize != 6 || dir.ItemCount != 2 {
+		t.Errorf("/dir after replace: %d, %d", dir.AggSize, dir.ItemCount)
+	}
+
+	updated, _ = Remove(updated, "/dir")
+	if updated.AggSize != 1 || updated.ItemCount != 1 {
+		t.Errorf("root after remove: %d, %d", updated.AggSize, updated.ItemCount)
+	}
+	if root.AggSize != 3 || root.ItemCount != 3 {
+		t.Error("original aggregates modified")
+	}
+}
+
+// TestConcurrentSnapshots walks published roots while a writer keeps
+// replacing them. Run with -race: readers must never see a node change.
+func TestConcurrentSnapshots(t *testing.T) {
+	var mu sync.RWMutex
+	root := newUpdateTree()
+	current := func() *models.FileNode {
+		mu.RLock()
+		defer mu.RUnlock()
+		return root
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snap := current()
+				if n := CountNodes(snap); n < 3 {
+					t.Errorf("snapshot has %d nodes", n)
+					return
+				}
+				Flatten(snap)
+			}
+		}()
+	}
+
+	for i := 0; i < 500; i++ {
+		name := fmt.Sprintf("f%d.txt", i%20)
+		mu.Lock()
+		if i%3 == 2 {
+			root, _ = Remove(root, "/dir/"+name)
+		} else {
+			root, _ = Upsert(root, &models.FileNode{Path: "/dir/" + name, Name: name, Version: i})
+		}
+		mu.Unlock()
+	}
+	close(done)
+	wg.Wait()
+}
+
+// Benchmarks on a tree shaped like a large instance: 1M files spread over
+// 500 directories. BenchmarkRebuild is the in-memory part of a full
+// RefreshTree (the database query on top of it is not included).
+
+const (
+	benchDirs        = 500
+	benchFilesPerDir = 2000
+)
+
+var (
+	benchOnce sync.Once
+	benchRows []*models.FileNode
+	benchRoot *models.FileNode
+)
+
+func benchTree(b *testing.B) ([]*models.FileNode, *models.FileNode) {
+	b.Helper()
+	benchOnce.Do(func() {
+		benchRows = append(benchRows, &models.FileNode{Path: "/", IsDir: true})
+		for d := 0; d < benchDirs; d++ {
+			dir := fmt.Sprintf("/dir%04d", d)
+			benchRows = append(benchRows, &models.FileNode{Path: dir, Name: dir[1:], IsDir: true})
+			for f := 0; f < benchFilesPerDir; f++ {
+				name := fmt.Sprintf("file%05d.jpg", f)
+				benchRows = append(benchRows, &models.FileNode{Path: dir + "/" + name, Name: name, Size: int64(f)})
+			}
+		}
+		benchRoot = buildFromRows(benchRows)
+	})
+	return benchRows, benchRoot
+}
+
+// buildFromRows links rows sorted by path into a tree, as BuildTree does.
+func buildFromRows(rows []*models.FileNode) *models.FileNode {
+	nodes := make(map[string]*models.FileNode, len(rows))
+	var root *models.FileNode
+	for _, r := range rows {
+		n := *r
+		nodes[n.Path] = &n
+		if n.Path == "/" {
+			root = &n
+			continue
+		}
+		parent := nodes[path.Dir(n.Path)]
+		parent.Children = append(parent.Children, &n)
+	}
+	for _, n := range nodes {
+		n.ChildCount = len(n.Children)
+	}
+	return root
+}
+
+func BenchmarkRebuild(b *testing.B) {
+	rows, _ := benchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildFromRows(rows)
+	}
+}
+
+func BenchmarkUpsertNewFile(b *testing.B) {
+	_, root := benchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Upsert(root, &models.FileNode{Path: "/dir0250/new.jpg", Name: "new.jpg"})
+	}
+}
+
+func BenchmarkUpsertExistingFile(b *testing.B) {
+	_, root := benchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Upsert(root, &models.FileNode{Path: "/dir0250/file01000.jpg", Name: "file01000.jpg", Version: 2})
+	}
+}
+
+func BenchmarkRemove(b *testing.B) {
+	_, root := benchTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Remove(root, "/dir0250/file01000.jpg")
+	}
+}
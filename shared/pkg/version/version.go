@@ -0,0 +1,153 @@
+// Package version holds build information embedded at link time and helpers
+// for exchanging client versions with the server via the User-Agent header.
+//
+// Set the values with -ldflags, e.g.:
+//
+//	go build -ldflags "-X github.com/fruitsalade/fruitsalade/shared/pkg/version.Version=1.4.0 \
+//	  -X github.com/fruitsalade/fruitsalade/shared/pkg/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/fruitsalade/fruitsalade/shared/pkg/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Build information, overridden via -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// UserAgentPrefix is the product prefix shared by all FruitSalade clients.
+// The server only enforces a minimum version for User-Agents with this prefix.
+const UserAgentPrefix = "fruitsalade-"
+
+// Info is the build information reported by /health and -version.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// String returns a one-line description, e.g. "1.4.0 (abc1234, built 2024-05-01T10:00:00Z, linux/amd64)".
+func String() string {
+	i := Get()
+	return fmt.Sprintf("%s (%s, built %s, %s)", i.Version, i.Commit, i.BuildDate, i.Platform)
+}
+
+// UserAgent builds the User-Agent for a client component, e.g.
+// "fruitsalade-fuse/1.4.0 (abc1234; linux/amd64)".
+func UserAgent(component string) string {
+	return fmt.Sprintf("%s%s/%s (%s; %s/%s)", UserAgentPrefix, component, Version, Commit, runtime.GOOS, runtime.GOARCH)
+}
+
+// ParseUserAgent extracts the component and version from a FruitSalade
+// User-Agent. ok is false for any other User-Agent (browsers, curl, WebDAV
+// clients, older FruitSalade clients that sent Go's default).
+func ParseUserAgent(ua string) (component, ver string, ok bool) {
+	if !strings.HasPrefix(ua, UserAgentPrefix) {
+		return "", "", false
+	}
+	product := ua
+	if i := strings.IndexByte(product, ' '); i >= 0 {
+		product = product[:i]
+	}
+	name, ver, found := strings.Cut(product, "/")
+	if !found || ver == "" {
+		return "", "", false
+	}
+	return strings.TrimPrefix(name, UserAgentPrefix), ver, true
+}
+
+// IsRelease reports whether v looks like a release version ("1.4.0",
+// "v1.4", "1.4.0-rc1"). Development builds ("dev", git hashes) are not.
+func IsRelease(v string) bool {
+	_, ok := parse(v)
+	return ok
+}
+
+// Compare compares two release versions numerically, returning -1, 0 or +1.
+// Pre-release suffixes ("-rc1") sort before the plain release. Versions that
+// do not parse compare as equal, so development builds are never rejected.
+func Compare(a, b string) int {
+	pa, okA := parse(a)
+	pb, okB := parse(b)
+	if !okA || !okB {
+		return 0
+	}
+	for i := 0; i < 3; i++ {
+		if pa.nums[i] != pb.nums[i] {
+			if pa.nums[i] < pb.nums[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case pa.pre == pb.pre:
+		return 0
+	case pa.pre == "":
+		return 1
+	case pb.pre == "":
+		return -1
+	case pa.pre < pb.pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// AtLeast reports whether v satisfies the minimum version min. An empty min
+// or a non-release v always satisfies it.
+func AtLeast(v, min string) bool {
+	if min == "" || !IsRelease(v) {
+		return true
+	}
+	return Compare(v, min) >= 0
+}
+
+type parsed struct {
+	nums [3]int
+	pre  string
+}
+
+func parse(v string) (parsed, bool) {
+	var p parsed
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i] // build metadata is ignored
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		p.pre = v[i+1:]
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return p, false
+	}
+	for i, s := range parts {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return p, false
+		}
+		p.nums[i] = n
+	}
+	return p, true
+}
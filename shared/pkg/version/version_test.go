@@ -0,0 +1,74 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc1", 1},
+		{"1.0.0-rc1", "1.0.0-rc2", -1},
+		{"1.0.0+abc", "1.0.0", 0},
+		{"dev", "1.0.0", 0},
+		{"1.0.0", "abc1234", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	tests := []struct {
+		v, min string
+		want   bool
+	}{
+		{"1.2.0", "", true},
+		{"1.2.0", "1.2.0", true},
+		{"1.1.9", "1.2.0", false},
+		{"1.3.0", "1.2.0", true},
+		{"dev", "1.2.0", true},
+		{"1.2.0-rc1", "1.2.0", false},
+	}
+	for _, tt := range tests {
+		if got := AtLeast(tt.v, tt.min); got != tt.want {
+			t.Errorf("AtLeast(%q, %q) = %v, want %v", tt.v, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua        string
+		component string
+		ver       string
+		ok        bool
+	}{
+		{"fruitsalade-fuse/1.4.0 (abc1234; linux/amd64)", "fuse", "1.4.0", true},
+		{"fruitsalade-windows/dev (unknown; windows/amd64)", "windows", "dev", true},
+		{"fruitsalade-fuse/", "", "", false},
+		{"fruitsalade-fuse", "", "", false},
+		{"Go-http-client/1.1", "", "", false},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "", "", false},
+	}
+	for _, tt := range tests {
+		c, v, ok := ParseUserAgent(tt.ua)
+		if c != tt.component || v != tt.ver || ok != tt.ok {
+			t.Errorf("ParseUserAgent(%q) = %q, %q, %v; want %q, %q, %v", tt.ua, c, v, ok, tt.component, tt.ver, tt.ok)
+		}
+	}
+
+	c, v, ok := ParseUserAgent(UserAgent("fuse"))
+	if !ok || c != "fuse" || v != Version {
+		t.Errorf("round trip of UserAgent(fuse) = %q, %q, %v", c, v, ok)
+	}
+}
@@ -0,0 +1,446 @@
+// Package websocket implements the part of RFC 6455 that FruitSalade uses
+// to deliver events: unfragmented or fragmented text and binary messages,
+// ping/pong and the closing handshake. Extensions and subprotocols are not
+// supported.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Opcodes.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close codes.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseNoStatus      = 1005
+	ClosePolicy        = 1008
+	CloseTooBig        = 1009
+	CloseTryAgainLater = 1013
+)
+
+// MaxMessageSize is the largest message ReadMessage accepts.
+const MaxMessageSize = 1 << 20
+
+// handshakeGUID is appended to the client key to compute the accept key.
+const handshakeGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrBadHandshake is returned when an upgrade request or response is
+	// not a valid WebSocket handshake.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+
+	// ErrProtocol is returned for frames that violate the protocol.
+	ErrProtocol = errors.New("websocket: protocol error")
+
+	// ErrMessageTooLarge is returned for messages over MaxMessageSize.
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+
+	// ErrIdleTimeout is returned when nothing was received within the idle
+	// timeout.
+	ErrIdleTimeout = errors.New("websocket: idle timeout")
+)
+
+// CloseError is returned by ReadMessage when the peer closed the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed (%d)", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed (%d): %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. ReadMessage must be called from one
+// goroutine at a time; writes are safe for concurrent use.
+type Conn struct {
+	rwc    io.ReadWriteCloser
+	br     *bufio.Reader
+	client bool // frames we send are masked
+
+	// WriteTimeout bounds each write when the connection is a net.Conn
+	// (server side). Zero means no limit.
+	WriteTimeout time.Duration
+
+	wmu       sync.Mutex
+	closeSent atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+
+	imu      sync.Mutex
+	idle     time.Duration
+	idleT    *time.Timer
+	timedOut atomic.Bool
+}
+
+func newConn(rwc io.ReadWriteCloser, br *bufio.Reader, client bool) *Conn {
+	return &Conn{rwc: rwc, br: br, client: client}
+}
+
+// Accept completes the server side of the handshake and takes over the
+// connection. On ErrBadHandshake nothing has been written, so the caller
+// can still answer with an HTTP error.
+func Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("%w: not an upgrade request", ErrBadHandshake)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		return nil, fmt.Errorf("%w: invalid key", ErrBadHandshake)
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+	// Deadlines from the HTTP server would otherwise end the connection
+	netConn.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	netConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := netConn.Write([]byte(resp)); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("websocket: write handshake: %w", err)
+	}
+	netConn.SetWriteDeadline(time.Time{})
+
+	return newConn(netConn, brw.Reader, false), nil
+}
+
+// dialClient performs client handshakes. HTTP/2 is disabled because the
+// upgrade only exists in HTTP/1.1; proxies are taken from the environment.
+var dialClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSNextProto:        map[string]func(string, *tls.Conn) http.RoundTripper{},
+	},
+}
+
+// Dial opens a client connection to a ws://, wss://, http:// or https://
+// URL. If the server answers with anything but 101, the error wraps
+// ErrBadHandshake and the response is returned with its body unread; the
+// caller must close it.
+func Dial(ctx context.Context, rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: parse url: %w", err)
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, nil, fmt.Errorf("websocket: generate key: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(raw[:])
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := dialClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: dial: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp, fmt.Errorf("%w: server returned %d", ErrBadHandshake, resp.StatusCode)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !headerHasToken(resp.Header, "Upgrade", "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("%w: invalid upgrade response", ErrBadHandshake)
+	}
+	return newConn(rwc, bufio.NewReader(rwc), true), resp, nil
+}
+
+// SetIdleTimeout closes the connection when no frame, including pings and
+// pongs, arrives for d. ReadMessage then returns ErrIdleTimeout.
+func (c *Conn) SetIdleTimeout(d time.Duration) {
+	c.imu.Lock()
+	defer c.imu.Unlock()
+	c.idle = d
+	if c.idleT != nil {
+		c.idleT.Stop()
+	}
+	c.idleT = time.AfterFunc(d, func() {
+		c.timedOut.Store(true)
+		c.Close()
+	})
+}
+
+func (c *Conn) touch() {
+	c.imu.Lock()
+	defer c.imu.Unlock()
+	if c.idleT != nil {
+		c.idleT.Reset(c.idle)
+	}
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs skipped. When the peer closes the connection, the close is
+// acknowledged and a *CloseError returned.
+func (c *Conn) ReadMessage() (op int, data []byte, err error) {
+	defer func() {
+		if err != nil && c.timedOut.Load() {
+			err = ErrIdleTimeout
+		}
+	}()
+
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			ce := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			if c.closeSent.CompareAndSwap(false, true) {
+				c.writeFrame(OpClose, payload[:min(2, len(payload))])
+			}
+			c.Close()
+			return 0, nil, ce
+		case OpContinuation:
+			if op == 0 {
+				return 0, nil, fmt.Errorf("%w: unexpected continuation frame", ErrProtocol)
+			}
+		case OpText, OpBinary:
+			if op != 0 {
+				return 0, nil, fmt.Errorf("%w: expected continuation frame", ErrProtocol)
+			}
+			op = fop
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown opcode %d", ErrProtocol, fop)
+		}
+
+		if len(data)+len(payload) > MaxMessageSize {
+			return 0, nil, ErrMessageTooLarge
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	op = int(h[0] & 0x0f)
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	masked := h[1]&0x80 != 0
+	if masked == c.client {
+		// Clients must mask their frames, servers must not
+		return false, 0, nil, fmt.Errorf("%w: wrong masking", ErrProtocol)
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= OpClose && (n > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("%w: invalid control frame", ErrProtocol)
+	}
+	if n > MaxMessageSize {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	c.touch()
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends data as a single text or binary frame.
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	if op != OpText && op != OpBinary {
+		return fmt.Errorf("%w: not a data opcode", ErrProtocol)
+	}
+	return c.writeFrame(op, data)
+}
+
+// Ping sends a ping; the peer answers with a pong, which resets the idle
+// timeout.
+func (c *Conn) Ping() error {
+	return c.writeFrame(OpPing, nil)
+}
+
+// CloseWith starts the closing handshake with a status code and reason,
+// then closes the connection.
+func (c *Conn) CloseWith(code int, reason string) error {
+	if c.closeSent.CompareAndSwap(false, true) {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason[:min(len(reason), 123)]...)
+		c.writeFrame(OpClose, payload)
+	}
+	return c.Close()
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.imu.Lock()
+		if c.idleT != nil {
+			c.idleT.Stop()
+		}
+		c.imu.Unlock()
+		c.closeErr = c.rwc.Close()
+	})
+	return c.closeErr
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(op))
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return fmt.Errorf("websocket: generate mask: %w", err)
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if nc, ok := c.rwc.(net.Conn); ok && c.WriteTimeout > 0 {
+		nc.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+		defer nc.SetWriteDeadline(time.Time{})
+	}
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + handshakeGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
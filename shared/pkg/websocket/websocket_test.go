@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer echoes every message and closes normally on "bye".
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Accept(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "bye" {
+				conn.CloseWith(CloseNormal, "done")
+				return
+			}
+			if err := conn.WriteMessage(op, data); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestRoundTrip(t *testing.T) {
+	ts := echoServer(t)
+	defer ts.Close()
+
+	conn, _, err := Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"hello", strings.Repeat("x", 200), strings.Repeat("y", 70000)} {
+		if err := conn.WriteMessage(OpText, []byte(msg)); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+		op, data, err := conn.ReadMessage()
+		if err != nil || op != OpText || string(data) != msg {
+			t.Fatalf("echo of %d bytes = op %d, %d bytes, %v", len(msg), op, len(data), err)
+		}
+	}
+
+	// Pings are answered without surfacing as messages
+	if err := conn.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	conn.WriteMessage(OpText, []byte("bye"))
+	_, _, err = conn.ReadMessage()
+	var ce *CloseError
+	if !errors.As(err, &ce) || ce.Code != CloseNormal || ce.Reason != "done" {
+		t.Errorf("ReadMessage after close = %v", err)
+	}
+}
+
+func TestDialRejected(t *testing.T) {
+	ts := echoServer(t)
+	defer ts.Close()
+
+	// A plain GET is not an upgrade
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: status %d", resp.StatusCode)
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	_, resp, err = Dial(context.Background(), notFound.URL, nil)
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Dial to non-websocket server = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ts := echoServer(t)
+	defer ts.Close()
+
+	conn, _, err := Dial(context.Background(), ts.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.SetIdleTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, _, err := conn.ReadMessage(); !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("ReadMessage = %v, want ErrIdleTimeout", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("idle timeout took %v", d)
+	}
+}